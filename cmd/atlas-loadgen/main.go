@@ -0,0 +1,424 @@
+// Command atlas-loadgen simulates game servers and clients against a running
+// Atlas instance and reports request latencies.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/nspkt"
+	"github.com/spf13/pflag"
+)
+
+var opt struct {
+	Servers   int
+	Clients   int
+	Duration  time.Duration
+	Ramp      time.Duration
+	Heartbeat time.Duration
+	Poll      time.Duration
+	Auth      time.Duration
+	Bind      string
+	UserAgent string
+	Help      bool
+}
+
+func init() {
+	pflag.IntVarP(&opt.Servers, "servers", "s", 10, "Number of game servers to simulate")
+	pflag.IntVarP(&opt.Clients, "clients", "c", 100, "Number of clients to simulate")
+	pflag.DurationVarP(&opt.Duration, "duration", "d", time.Minute, "Amount of time to run for")
+	pflag.DurationVar(&opt.Ramp, "ramp", time.Second*10, "Amount of time to spread out the initial requests over")
+	pflag.DurationVar(&opt.Heartbeat, "heartbeat", time.Second*5, "Game server heartbeat interval")
+	pflag.DurationVar(&opt.Poll, "poll", time.Second*10, "Client server list polling interval")
+	pflag.DurationVar(&opt.Auth, "auth", 0, "Client origin_auth interval (0 to disable; the target must have ATLAS_API0_INSECURE_DEV_NO_CHECK_PLAYER_AUTH enabled)")
+	pflag.StringVar(&opt.Bind, "bind", "127.0.0.1", "IP to bind simulated game server UDP sockets to (must be reachable by the target at the IP it sees requests from)")
+	pflag.StringVar(&opt.UserAgent, "user-agent", "R2Northstar/0.0.0+dev (atlas-loadgen)", "User-Agent to use for requests")
+	pflag.BoolVarP(&opt.Help, "help", "h", false, "Show this help text")
+}
+
+func main() {
+	pflag.Parse()
+
+	if pflag.NArg() != 1 || opt.Help {
+		fmt.Printf("usage: %s [options] atlas_url\n\noptions:\n%s", os.Args[0], pflag.CommandLine.FlagUsages())
+		if opt.Help {
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(pflag.Arg(0), "/"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: invalid atlas url: %v\n", err)
+		os.Exit(2)
+	}
+
+	bind, err := netip.ParseAddr(opt.Bind)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: invalid bind address: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	ctx, cancel = context.WithTimeout(ctx, opt.Duration)
+	defer cancel()
+
+	lg := &loadgen{
+		Base: base,
+		Client: &http.Client{
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: opt.Servers + opt.Clients,
+			},
+			Timeout: time.Second * 15,
+		},
+		stats: map[string]*opStats{},
+	}
+
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < opt.Servers; i++ {
+		conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(bind, 0)))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: listen udp: %v\n", err)
+			os.Exit(1)
+		}
+		go serveConnectReplies(conn)
+
+		wg.Add(1)
+		go func(i int, conn *net.UDPConn) {
+			defer wg.Done()
+			defer conn.Close()
+			lg.runServer(ctx, i, conn.LocalAddr().(*net.UDPAddr).AddrPort().Port())
+		}(i, conn)
+	}
+	for i := 0; i < opt.Clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lg.runClient(ctx, i)
+		}(i)
+	}
+
+	tk := time.NewTicker(time.Second * 5)
+	go func() {
+		for range tk.C {
+			fmt.Fprintf(os.Stderr, "... %s elapsed, %d requests\n", time.Since(start).Truncate(time.Second), lg.total())
+		}
+	}()
+
+	wg.Wait()
+	tk.Stop()
+
+	lg.report(os.Stdout, time.Since(start))
+}
+
+type loadgen struct {
+	Base   *url.URL
+	Client *http.Client
+
+	mu    sync.Mutex
+	stats map[string]*opStats
+}
+
+type opStats struct {
+	durations []time.Duration
+	errors    map[string]int
+}
+
+// do makes a request to path and records its latency as op.
+func (lg *loadgen) do(ctx context.Context, op, method, path string, q url.Values, v any) error {
+	u := *lg.Base
+	u.Path += path
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", opt.UserAgent)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	t := time.Now()
+	err = func() error {
+		resp, err := lg.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		buf, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			var obj struct {
+				Error struct {
+					Enum string `json:"enum"`
+				} `json:"error"`
+			}
+			if json.Unmarshal(buf, &obj) == nil && obj.Error.Enum != "" {
+				return fmt.Errorf("response status %d (%s)", resp.StatusCode, obj.Error.Enum)
+			}
+			return fmt.Errorf("response status %d", resp.StatusCode)
+		}
+		if v != nil {
+			if err := json.Unmarshal(buf, v); err != nil {
+				return fmt.Errorf("decode response: %w", err)
+			}
+		}
+		return nil
+	}()
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return err // don't record requests cancelled due to shutdown
+	}
+	lg.record(op, time.Since(t), err)
+	return err
+}
+
+func (lg *loadgen) record(op string, d time.Duration, err error) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+
+	s, ok := lg.stats[op]
+	if !ok {
+		s = &opStats{errors: map[string]int{}}
+		lg.stats[op] = s
+	}
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err // don't include the url
+		}
+		s.errors[err.Error()]++
+	} else {
+		s.durations = append(s.durations, d)
+	}
+}
+
+func (lg *loadgen) total() int {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+
+	var n int
+	for _, s := range lg.stats {
+		n += len(s.durations)
+		for _, c := range s.errors {
+			n += c
+		}
+	}
+	return n
+}
+
+func (lg *loadgen) report(w io.Writer, elapsed time.Duration) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+
+	ops := make([]string, 0, len(lg.stats))
+	for op := range lg.stats {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	fmt.Fprintf(w, "%-16s %8s %8s %8s %10s %10s %10s %10s\n", "op", "ok", "err", "req/s", "p50", "p90", "p99", "max")
+	for _, op := range ops {
+		s := lg.stats[op]
+		sort.Slice(s.durations, func(i, j int) bool {
+			return s.durations[i] < s.durations[j]
+		})
+
+		var nerr int
+		for _, c := range s.errors {
+			nerr += c
+		}
+		fmt.Fprintf(w, "%-16s %8d %8d %8.1f %10s %10s %10s %10s\n", op,
+			len(s.durations), nerr,
+			float64(len(s.durations)+nerr)/elapsed.Seconds(),
+			percentile(s.durations, 0.50),
+			percentile(s.durations, 0.90),
+			percentile(s.durations, 0.99),
+			percentile(s.durations, 1))
+	}
+	for _, op := range ops {
+		s := lg.stats[op]
+		errs := make([]string, 0, len(s.errors))
+		for e := range s.errors {
+			errs = append(errs, e)
+		}
+		sort.Strings(errs)
+		for _, e := range errs {
+			fmt.Fprintf(w, "error: %s: %s (x%d)\n", op, e, s.errors[e])
+		}
+	}
+}
+
+// percentile returns the p-th percentile of the sorted durations.
+func percentile(ds []time.Duration, p float64) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	i := int(float64(len(ds))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(ds) {
+		i = len(ds) - 1
+	}
+	return ds[i].Round(time.Microsecond)
+}
+
+// sleep waits for d, returning false if ctx is cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// jitter returns a random duration up to d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(mrand.Int63n(int64(d)))
+}
+
+func (lg *loadgen) runServer(ctx context.Context, i int, port uint16) {
+	if !sleep(ctx, jitter(opt.Ramp)) {
+		return
+	}
+
+	maxPlayers := 16
+	q := url.Values{
+		"port":        {strconv.Itoa(int(port))},
+		"authPort":    {"udp"},
+		"name":        {"atlas-loadgen server " + strconv.Itoa(i)},
+		"description": {"simulated by atlas-loadgen"},
+		"map":         {"mp_glitch"},
+		"playlist":    {"aitdm"},
+		"maxPlayers":  {strconv.Itoa(maxPlayers)},
+		"playerCount": {"0"},
+	}
+
+	var id string
+	for id == "" {
+		var obj struct {
+			ID string `json:"id"`
+		}
+		if err := lg.do(ctx, "add_server", http.MethodPost, "/server/add_server", q, &obj); err == nil {
+			id = obj.ID
+		} else if !sleep(ctx, opt.Heartbeat) {
+			return
+		}
+	}
+
+	for sleep(ctx, opt.Heartbeat) {
+		if err := lg.do(ctx, "heartbeat", http.MethodPost, "/server/heartbeat", url.Values{
+			"id":          {id},
+			"playerCount": {strconv.Itoa(mrand.Intn(maxPlayers + 1))},
+		}, nil); err != nil && ctx.Err() == nil {
+			// re-register if it was dropped
+			var obj struct {
+				ID string `json:"id"`
+			}
+			if err := lg.do(ctx, "add_server", http.MethodPost, "/server/add_server", q, &obj); err == nil {
+				id = obj.ID
+			}
+		}
+	}
+
+	// note: use a new context since ours is done
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	lg.do(rctx, "remove_server", http.MethodDelete, "/server/remove_server", url.Values{"id": {id}}, nil)
+}
+
+func (lg *loadgen) runClient(ctx context.Context, i int) {
+	if !sleep(ctx, jitter(opt.Ramp)) {
+		return
+	}
+
+	uid := 1000000000000 + uint64(i)
+
+	var nextAuth time.Time
+	for {
+		if t := time.Now(); opt.Auth > 0 && !t.Before(nextAuth) {
+			lg.do(ctx, "origin_auth", http.MethodGet, "/client/origin_auth", url.Values{
+				"id":    {strconv.FormatUint(uid, 10)},
+				"token": {"atlas-loadgen"},
+			}, nil)
+			nextAuth = t.Add(opt.Auth)
+		}
+		lg.do(ctx, "servers", http.MethodGet, "/client/servers", nil, nil)
+
+		if !sleep(ctx, opt.Poll) {
+			return
+		}
+	}
+}
+
+// serveConnectReplies responds to connectionless `Hconnect` packets on conn
+// like a game server would until conn is closed.
+func serveConnectReplies(conn *net.UDPConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+
+		// 4: i32 = -1
+		// 1: u8  = 'H'
+		// 8: str = "connect\0"
+		// 8: u64 = uid
+		// 1: u8  = 2
+		data, ok := nspkt.DecryptPacket(buf[:n])
+		if !ok || len(data) < 4+1+8+8 || string(data[:4+1+8]) != "\xFF\xFF\xFF\xFFHconnect\x00" {
+			continue
+		}
+		uid := binary.LittleEndian.Uint64(data[4+1+8:])
+
+		// 4: i32 = -1
+		// 1: u8  = 'I'
+		// 4: i32 = challenge
+		// 8: u64 = uid
+		// 8: str = "connect\0"
+		// 4: ?
+		var b []byte
+		b = append(b, "\xFF\xFF\xFF\xFFI"...)
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint64(b, uid)
+		b = append(b, "connect\x00"...)
+		b = binary.LittleEndian.AppendUint32(b, 0)
+
+		nonce := make([]byte, 12)
+		if _, err := rand.Read(nonce); err != nil {
+			continue
+		}
+		conn.WriteToUDPAddrPort(nspkt.EncryptPacket(nonce, b), addr)
+	}
+}
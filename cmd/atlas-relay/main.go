@@ -0,0 +1,118 @@
+// Command atlas-relay runs a regional relay which mirrors the server list from
+// a primary Atlas instance and serves it locally.
+//
+// The relay must first be added on the primary with the /admin/relays API,
+// which returns the token to set in ATLAS_RELAY_TOKEN.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/edgerelay"
+	"github.com/r2northstar/atlas/pkg/mtls"
+	"github.com/rs/zerolog"
+	"github.com/spf13/pflag"
+)
+
+var opt struct {
+	Listen  string
+	Metrics string
+	ID      string
+	MaxAge  time.Duration
+	TLSCert string
+	TLSKey  string
+	TLSCA   []string
+	Help    bool
+}
+
+func init() {
+	pflag.StringVarP(&opt.Listen, "listen", "l", ":8080", "Address to serve the server list on")
+	pflag.StringVar(&opt.Metrics, "metrics", "", "Address to serve prometheus metrics on (empty to disable)")
+	pflag.StringVar(&opt.ID, "id", "", "Relay ID registered with the primary")
+	pflag.DurationVar(&opt.MaxAge, "max-age", time.Second*30, "Maximum age of the server list before redirecting requests to the primary")
+	pflag.StringVar(&opt.TLSCert, "tls-cert", "", "PEM-encoded client certificate to present to the primary for mTLS")
+	pflag.StringVar(&opt.TLSKey, "tls-key", "", "PEM-encoded private key for the client certificate")
+	pflag.StringSliceVar(&opt.TLSCA, "tls-ca", nil, "PEM-encoded CA certificates to verify the primary against instead of the system roots")
+	pflag.BoolVarP(&opt.Help, "help", "h", false, "Show this help text")
+}
+
+func main() {
+	pflag.Parse()
+
+	if pflag.NArg() != 1 || opt.ID == "" || opt.Help {
+		fmt.Printf("usage: %s [options] --id relay_id primary_url\n\nthe relay token is read from ATLAS_RELAY_TOKEN\n\noptions:\n%s", os.Args[0], pflag.CommandLine.FlagUsages())
+		if opt.Help {
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
+	token := os.Getenv("ATLAS_RELAY_TOKEN")
+	if token == "" {
+		fmt.Fprintf(os.Stderr, "fatal: ATLAS_RELAY_TOKEN is not set\n")
+		os.Exit(2)
+	}
+
+	var tlsConfig *tls.Config
+	if opt.TLSCert != "" || opt.TLSKey != "" {
+		var err error
+		if tlsConfig, err = mtls.ClientConfig(opt.TLSCert, opt.TLSKey, opt.TLSCA); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	r := &edgerelay.Relay{
+		Primary:   pflag.Arg(0),
+		ID:        opt.ID,
+		Token:     token,
+		MaxAge:    opt.MaxAge,
+		TLSConfig: tlsConfig,
+		Logger:    zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger(),
+	}
+
+	go func() {
+		if err := r.Run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	if opt.Metrics != "" {
+		go func() {
+			if err := http.ListenAndServe(opt.Metrics, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+				r.WritePrometheus(w)
+			})); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: serve metrics: %v\n", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	s := &http.Server{
+		Addr:              opt.Listen,
+		Handler:           r,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	go func() {
+		<-ctx.Done()
+		sctx, scancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer scancel()
+		s.Shutdown(sctx)
+	}()
+	if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(1)
+	}
+}
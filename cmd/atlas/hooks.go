@@ -0,0 +1,7 @@
+package main
+
+// Hooks (see api0.Hook) can be compiled in by adding a blank import for the
+// package registering them here.
+import (
+// _ "example.com/atlas-hooks/whitelist"
+)
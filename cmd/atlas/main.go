@@ -39,19 +39,10 @@ func main() {
 		os.Exit(0)
 	}
 
-	var e []string
-	if pflag.NArg() == 0 {
-		e = os.Environ()
-	} else {
-		if x, err := readEnv(pflag.Arg(0)); err == nil {
-			e = x
-		} else {
-			fmt.Fprintf(os.Stderr, "error: read env file: %v\n", err)
-			os.Exit(1)
-		}
-		if v, ok := os.LookupEnv("NOTIFY_SOCKET"); ok {
-			e = append(e, "NOTIFY_SOCKET="+v)
-		}
+	e, err := loadEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: read env file: %v\n", err)
+		os.Exit(1)
 	}
 
 	dbg := http.NewServeMux()
@@ -84,6 +75,18 @@ func main() {
 
 	dbg.Handle("/nspkt", nspkt.DebugMonitorHandler(s.API0.NSPkt))
 
+	s.LoadConfig = func() (*atlas.Config, error) {
+		e, err := loadEnv()
+		if err != nil {
+			return nil, fmt.Errorf("read env file: %w", err)
+		}
+		var c atlas.Config
+		if err := c.UnmarshalEnv(e, false); err != nil {
+			return nil, fmt.Errorf("parse config: %w", err)
+		}
+		return &c, nil
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
@@ -103,6 +106,21 @@ func main() {
 	}
 }
 
+// loadEnv gets the environment variables to use for the config.
+func loadEnv() ([]string, error) {
+	if pflag.NArg() == 0 {
+		return os.Environ(), nil
+	}
+	e, err := readEnv(pflag.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := os.LookupEnv("NOTIFY_SOCKET"); ok {
+		e = append(e, "NOTIFY_SOCKET="+v)
+	}
+	return e, nil
+}
+
 func getEnvList(k string, e ...[]string) (string, bool) {
 	for _, l := range e {
 		for _, x := range l {
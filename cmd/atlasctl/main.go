@@ -0,0 +1,462 @@
+// Command atlasctl performs maintenance tasks for Atlas.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/r2northstar/atlas/pkg/atlas"
+	"github.com/r2northstar/atlas/pkg/legacyimport"
+	"github.com/r2northstar/atlas/pkg/pdatashard"
+	"github.com/r2northstar/atlas/pkg/signkeys"
+	"github.com/r2northstar/atlas/pkg/storagemigrate"
+	"github.com/spf13/pflag"
+)
+
+var commands = map[string]struct {
+	Help string
+	Run  func(name string, args []string) int
+}{
+	"import":             {"Import accounts, pdata, and bans from the old master server", importLegacy},
+	"migrate-storage":    {"Copy accounts, pdata, and bans between storage backends", migrateStorage},
+	"rebalance-pdata":    {"Move pdata to the correct shard after changing shards", rebalancePdata},
+	"rotate-signing-key": {"Generate a new signing key, expiring the previous ones", rotateSigningKey},
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.Run(os.Args[0]+" "+os.Args[1], os.Args[2:]))
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s command [options]\n\ncommands:\n", os.Args[0])
+	ns := make([]string, 0, len(commands))
+	for n := range commands {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	for _, n := range ns {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", n, commands[n].Help)
+	}
+}
+
+func migrateStorage(name string, args []string) int {
+	var opt struct {
+		AccountsFrom   string
+		AccountsTo     string
+		PdataFrom      string
+		PdataTo        string
+		EncryptionKeys string
+		VerifyOnly     bool
+		Progress       bool
+		Help           bool
+	}
+
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.StringVar(&opt.AccountsFrom, "accounts-from", "", "Source account storage (e.g., sqlite3:/path/to/atlas.db)")
+	fs.StringVar(&opt.AccountsTo, "accounts-to", "", "Destination account storage")
+	fs.StringVar(&opt.PdataFrom, "pdata-from", "", "Source pdata storage (e.g., sqlite3:/path/to/pdata.db)")
+	fs.StringVar(&opt.PdataTo, "pdata-to", "", "Destination pdata storage")
+	fs.StringVar(&opt.EncryptionKeys, "encryption-keys", os.Getenv("ATLAS_API0_STORAGE_ENCRYPTION_KEYS"), "Storage encryption keys (defaults to ATLAS_API0_STORAGE_ENCRYPTION_KEYS)")
+	fs.BoolVar(&opt.VerifyOnly, "verify-only", false, "Only compare the source and destination storage")
+	fs.BoolVarP(&opt.Progress, "progress", "p", false, "Show progress")
+	fs.BoolVarP(&opt.Help, "help", "h", false, "Show this help text")
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+	if opt.Help || fs.NArg() != 0 || (opt.AccountsFrom == "") != (opt.AccountsTo == "") || (opt.PdataFrom == "") != (opt.PdataTo == "") || (opt.AccountsFrom == "" && opt.PdataFrom == "") {
+		fmt.Printf("usage: %s [options]\n\n"+
+			"Copies accounts (including state and ban lists) and pdata from one storage\n"+
+			"backend to another, replacing existing records, then verifies them by hash.\n"+
+			"To migrate without downtime, set ATLAS_API0_STORAGE_ACCOUNTS_DUALWRITE and\n"+
+			"ATLAS_API0_STORAGE_PDATA_DUALWRITE to the destination, restart Atlas, run\n"+
+			"this (again if records changed during the copy), then switch the primary\n"+
+			"storage to the destination.\n\noptions:\n%s", name, fs.FlagUsages())
+		if opt.Help {
+			return 2
+		}
+		return 0
+	}
+
+	var src, dst storagemigrate.Storage
+	defer func() {
+		for _, x := range []any{src.Accounts, src.Pdata, dst.Accounts, dst.Pdata} {
+			if c, ok := x.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	}()
+	if opt.AccountsFrom != "" {
+		var err error
+		if src.Accounts, err = atlas.OpenAccountStorage(opt.AccountsFrom, opt.EncryptionKeys); err != nil {
+			fmt.Fprintf(os.Stderr, "error: open source account storage: %v\n", err)
+			return 1
+		}
+		if dst.Accounts, err = atlas.OpenAccountStorage(opt.AccountsTo, opt.EncryptionKeys); err != nil {
+			fmt.Fprintf(os.Stderr, "error: open destination account storage: %v\n", err)
+			return 1
+		}
+	}
+	if opt.PdataFrom != "" {
+		var err error
+		if src.Pdata, err = atlas.OpenPdataStorage(opt.PdataFrom, opt.EncryptionKeys); err != nil {
+			fmt.Fprintf(os.Stderr, "error: open source pdata storage: %v\n", err)
+			return 1
+		}
+		if dst.Pdata, err = atlas.OpenPdataStorage(opt.PdataTo, opt.EncryptionKeys); err != nil {
+			fmt.Fprintf(os.Stderr, "error: open destination pdata storage: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if !opt.VerifyOnly {
+		var n int
+		st, err := storagemigrate.Copy(ctx, dst, src, func(st storagemigrate.Stats) {
+			if n++; opt.Progress && n%1000 == 0 {
+				fmt.Printf("copied %s\n", st)
+			}
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: copy: %v\n", err)
+			return 1
+		}
+		fmt.Printf("copied %s\n", st)
+	}
+
+	r, err := storagemigrate.Verify(ctx, dst, src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: verify: %v\n", err)
+		return 1
+	}
+	fmt.Printf("verified %s\n", r.Stats)
+
+	ks := make([]string, 0, len(r.Digest))
+	for k := range r.Digest {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	for _, k := range ks {
+		fmt.Printf("  %s sha256:%s\n", k, r.Digest[k])
+	}
+	for _, k := range ks {
+		if m := r.Mismatched[k]; len(m) != 0 {
+			fmt.Printf("mismatched %s (first %d): %v\n", k, len(m), m)
+		}
+	}
+	if !r.OK() {
+		fmt.Fprintf(os.Stderr, "error: destination does not match source\n")
+		return 1
+	}
+	return 0
+}
+
+func importLegacy(name string, args []string) int {
+	var opt struct {
+		Accounts       string
+		Pdata          string
+		EncryptionKeys string
+		NorthstarDB    string
+		BanListFile    string
+		BanList        string
+		BanSource      string
+		Report         string
+		DryRun         bool
+		Overwrite      bool
+		Progress       bool
+		Help           bool
+	}
+
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.StringVar(&opt.Accounts, "accounts", os.Getenv("ATLAS_API0_STORAGE_ACCOUNTS"), "Account storage (defaults to ATLAS_API0_STORAGE_ACCOUNTS)")
+	fs.StringVar(&opt.Pdata, "pdata", os.Getenv("ATLAS_API0_STORAGE_PDATA"), "Pdata storage (defaults to ATLAS_API0_STORAGE_PDATA)")
+	fs.StringVar(&opt.EncryptionKeys, "encryption-keys", os.Getenv("ATLAS_API0_STORAGE_ENCRYPTION_KEYS"), "Storage encryption keys (defaults to ATLAS_API0_STORAGE_ENCRYPTION_KEYS)")
+	fs.StringVar(&opt.NorthstarDB, "northstar-db", "", "NorthstarMasterServer playerdata.db to import accounts and pdata from")
+	fs.StringVar(&opt.BanListFile, "banlist-txt", "", "Northstar banlist.txt to import bans from")
+	fs.StringVar(&opt.BanList, "ban-list", "legacy", "Ban list ID to import bans into (created if it doesn't exist)")
+	fs.StringVar(&opt.BanSource, "ban-source", "legacy-import", "Contributor name for imported bans")
+	fs.StringVar(&opt.Report, "report", "", "Write a JSON mapping report to this file")
+	fs.BoolVarP(&opt.DryRun, "dry-run", "n", false, "Only report what would be imported")
+	fs.BoolVar(&opt.Overwrite, "overwrite", false, "Replace existing accounts and pdata instead of skipping them")
+	fs.BoolVarP(&opt.Progress, "progress", "p", false, "Show progress")
+	fs.BoolVarP(&opt.Help, "help", "h", false, "Show this help text")
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+	if opt.Help || fs.NArg() != 0 || (opt.NorthstarDB == "" && opt.BanListFile == "") || opt.Accounts == "" || (opt.NorthstarDB != "" && opt.Pdata == "") {
+		fmt.Printf("usage: %s [options]\n\n"+
+			"Imports accounts and pdata from a NorthstarMasterServer database, and bans\n"+
+			"from a Northstar banlist.txt. Existing records are kept unless --overwrite\n"+
+			"is set. Invalid and default pdata is not imported. A summary of how the\n"+
+			"records were mapped is printed, and can be written as JSON with --report.\n"+
+			"Ban lists are loaded at startup, so Atlas should be restarted after\n"+
+			"importing bans. To import data from another Atlas instance, use\n"+
+			"migrate-storage instead.\n\noptions:\n%s", name, fs.FlagUsages())
+		if opt.Help {
+			return 2
+		}
+		return 0
+	}
+
+	im := &legacyimport.Importer{
+		DryRun:    opt.DryRun,
+		Overwrite: opt.Overwrite,
+		BanList:   opt.BanList,
+		BanSource: opt.BanSource,
+	}
+	defer func() {
+		for _, x := range []any{im.Accounts, im.Pdata} {
+			if c, ok := x.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	}()
+	var err error
+	if im.Accounts, err = atlas.OpenAccountStorage(opt.Accounts, opt.EncryptionKeys); err != nil {
+		fmt.Fprintf(os.Stderr, "error: open account storage: %v\n", err)
+		return 1
+	}
+	if opt.NorthstarDB != "" {
+		if im.Pdata, err = atlas.OpenPdataStorage(opt.Pdata, opt.EncryptionKeys); err != nil {
+			fmt.Fprintf(os.Stderr, "error: open pdata storage: %v\n", err)
+			return 1
+		}
+	}
+	var n int
+	im.Progress = func(r *legacyimport.Report) {
+		if n++; opt.Progress && n%1000 == 0 {
+			fmt.Printf("processed %d records\n", n)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	err = func() error {
+		if opt.NorthstarDB != "" {
+			db, err := sqlx.Connect("sqlite3", "file:"+opt.NorthstarDB+"?mode=ro")
+			if err != nil {
+				return fmt.Errorf("open northstar db: %w", err)
+			}
+			defer db.Close()
+
+			if err := im.ImportNorthstarDB(ctx, db); err != nil {
+				return err
+			}
+		}
+		if opt.BanListFile != "" {
+			f, err := os.Open(opt.BanListFile)
+			if err != nil {
+				return fmt.Errorf("open ban list: %w", err)
+			}
+			defer f.Close()
+
+			if err := im.ImportBanList(ctx, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+
+	r := &im.Report
+	if opt.DryRun {
+		fmt.Printf("dry run, nothing was written\n")
+	}
+	for _, k := range r.Outcomes() {
+		fmt.Printf("  %-24s %d\n", k, r.Counts[k])
+	}
+	for _, x := range r.Issues {
+		switch {
+		case x.UID != 0:
+			fmt.Printf("%s: uid %d: %s\n", x.Kind, x.UID, x.Message)
+		case x.Line != 0:
+			fmt.Printf("%s: line %d: %s\n", x.Kind, x.Line, x.Message)
+		default:
+			fmt.Printf("%s: %s\n", x.Kind, x.Message)
+		}
+	}
+	if r.Truncated != 0 {
+		fmt.Printf("(and %d more issues)\n", r.Truncated)
+	}
+	if opt.Report != "" {
+		if buf, err := json.MarshalIndent(r, "", "  "); err != nil {
+			fmt.Fprintf(os.Stderr, "error: encode report: %v\n", err)
+			return 1
+		} else if err := os.WriteFile(opt.Report, append(buf, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "error: write report: %v\n", err)
+			return 1
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: import: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func rebalancePdata(name string, args []string) int {
+	var opt struct {
+		Pdata          string
+		EncryptionKeys string
+		DryRun         bool
+		Progress       bool
+		Help           bool
+	}
+
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.StringVar(&opt.Pdata, "pdata", os.Getenv("ATLAS_API0_STORAGE_PDATA"), "Sharded pdata storage (defaults to ATLAS_API0_STORAGE_PDATA)")
+	fs.StringVar(&opt.EncryptionKeys, "encryption-keys", os.Getenv("ATLAS_API0_STORAGE_ENCRYPTION_KEYS"), "Storage encryption keys (defaults to ATLAS_API0_STORAGE_ENCRYPTION_KEYS)")
+	fs.BoolVarP(&opt.DryRun, "dry-run", "n", false, "Only count the pdata which would be moved")
+	fs.BoolVarP(&opt.Progress, "progress", "p", false, "Show progress")
+	fs.BoolVarP(&opt.Help, "help", "h", false, "Show this help text")
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+	if opt.Help || fs.NArg() != 0 || opt.Pdata == "" {
+		fmt.Printf("usage: %s [options]\n\n"+
+			"Moves pdata which isn't on the shard it is assigned to (i.e., after adding\n"+
+			"or removing shards) to the correct one. Atlas looks up missing pdata on the\n"+
+			"other shards, so it can keep running with the new shards while this runs,\n"+
+			"but a write made by Atlas while a player's pdata is being moved may be lost.\n"+
+			"When removing a shard, keep it in the list until this has been run.\n"+
+			"\noptions:\n%s", name, fs.FlagUsages())
+		if opt.Help {
+			return 2
+		}
+		return 0
+	}
+
+	x, err := atlas.OpenPdataStorage(opt.Pdata, opt.EncryptionKeys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: open pdata storage: %v\n", err)
+		return 1
+	}
+	if c, ok := x.(io.Closer); ok {
+		defer c.Close()
+	}
+	s, ok := x.(*pdatashard.Storage)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: pdata storage is not sharded\n")
+		return 1
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	st, err := s.Rebalance(ctx, opt.DryRun, func(st pdatashard.RebalanceStats) {
+		if opt.Progress && (st.Moved+st.Removed)%1000 == 0 {
+			fmt.Printf("rebalanced %s\n", st)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: rebalance: %v\n", err)
+		return 1
+	}
+	if opt.DryRun {
+		fmt.Printf("would rebalance %s\n", st)
+	} else {
+		fmt.Printf("rebalanced %s\n", st)
+	}
+	for _, sh := range s.Shards() {
+		fmt.Printf("  %-16s %d\n", sh.Name, st.Shards[sh.Name])
+	}
+	return 0
+}
+
+func rotateSigningKey(name string, args []string) int {
+	var opt struct {
+		Keys    string
+		Overlap time.Duration
+		Init    bool
+		List    bool
+		Help    bool
+	}
+
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.StringVar(&opt.Keys, "keys", os.Getenv("ATLAS_API0_SIGNING_KEYS"), "Signing key file (defaults to ATLAS_API0_SIGNING_KEYS)")
+	fs.DurationVar(&opt.Overlap, "overlap", time.Hour*24*7, "How long the previous keys remain valid for verification")
+	fs.BoolVar(&opt.Init, "init", false, "Create the key file if it doesn't exist")
+	fs.BoolVarP(&opt.List, "list", "l", false, "Only list the keys")
+	fs.BoolVarP(&opt.Help, "help", "h", false, "Show this help text")
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+	if opt.Help || fs.NArg() != 0 || opt.Keys == "" || opt.Overlap < 0 {
+		fmt.Printf("usage: %s [options]\n\n"+
+			"Generates a new signing key for trusted server attestations and the update\n"+
+			"manifest. New signatures are made with the new key once Atlas is reloaded\n"+
+			"(SIGHUP), and the previous keys remain published in the JWKS until the\n"+
+			"overlap window ends, so verifiers have time to fetch the new key. Expired\n"+
+			"keys are removed.\n\noptions:\n%s", name, fs.FlagUsages())
+		if opt.Help {
+			return 2
+		}
+		return 0
+	}
+
+	ks, err := signkeys.ReadFile(opt.Keys)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || !opt.Init || opt.List {
+			fmt.Fprintf(os.Stderr, "error: read keys: %v\n", err)
+			return 1
+		}
+		ks = nil
+	}
+
+	t := time.Now()
+	if !opt.List {
+		var nk signkeys.Key
+		if ks, nk, err = signkeys.Rotate(ks, t, opt.Overlap); err != nil {
+			fmt.Fprintf(os.Stderr, "error: rotate keys: %v\n", err)
+			return 1
+		}
+		if _, err := signkeys.NewSet(ks...); err != nil {
+			fmt.Fprintf(os.Stderr, "error: rotate keys: %v\n", err)
+			return 1
+		}
+		if err := signkeys.WriteFile(opt.Keys, ks); err != nil {
+			fmt.Fprintf(os.Stderr, "error: write keys: %v\n", err)
+			return 1
+		}
+		fmt.Printf("generated key %s\n", nk.ID)
+	}
+	for _, k := range ks {
+		switch {
+		case k.Expires.IsZero():
+			fmt.Printf("  %s created %s (current)\n", k.ID, k.Created.Format(time.RFC3339))
+		case k.Active(t):
+			fmt.Printf("  %s created %s, expires %s\n", k.ID, k.Created.Format(time.RFC3339), k.Expires.Format(time.RFC3339))
+		default:
+			fmt.Printf("  %s created %s, expired %s\n", k.ID, k.Created.Format(time.RFC3339), k.Expires.Format(time.RFC3339))
+		}
+	}
+	return 0
+}
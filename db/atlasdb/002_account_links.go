@@ -0,0 +1,38 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up002, down002)
+}
+
+func up002(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE account_links (
+			uid           TEXT NOT NULL,
+			provider      TEXT NOT NULL,
+			external_id   TEXT NOT NULL,
+			external_name TEXT NOT NULL DEFAULT '',
+			verified_at   INTEGER NOT NULL,
+			PRIMARY KEY (uid, provider),
+			UNIQUE (provider, external_id)
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create account_links table: %w", err)
+	}
+	return nil
+}
+
+func down002(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE account_links`); err != nil {
+		return fmt.Errorf("drop account_links table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up003, down003)
+}
+
+func up003(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE state (
+			key   TEXT PRIMARY KEY NOT NULL,
+			value BLOB NOT NULL
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create state table: %w", err)
+	}
+	return nil
+}
+
+func down003(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE state`); err != nil {
+		return fmt.Errorf("drop state table: %w", err)
+	}
+	return nil
+}
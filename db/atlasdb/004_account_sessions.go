@@ -0,0 +1,26 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up004, down004)
+}
+
+func up004(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE accounts ADD COLUMN auth_sessions TEXT`); err != nil {
+		return fmt.Errorf("add accounts auth_sessions column: %w", err)
+	}
+	return nil
+}
+
+func down004(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE accounts DROP COLUMN auth_sessions`); err != nil {
+		return fmt.Errorf("drop accounts auth_sessions column: %w", err)
+	}
+	return nil
+}
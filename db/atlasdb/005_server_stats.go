@@ -0,0 +1,44 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up005, down005)
+}
+
+func up005(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE server_stats (
+			addr        TEXT    NOT NULL,
+			resolution  INTEGER NOT NULL,
+			start       INTEGER NOT NULL,
+			name        TEXT    NOT NULL,
+			samples     INTEGER NOT NULL,
+			player_sum  INTEGER NOT NULL,
+			player_max  INTEGER NOT NULL,
+			max_players INTEGER NOT NULL,
+			maps        TEXT,
+			PRIMARY KEY (addr, resolution, start)
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create server_stats table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX server_stats_resolution_start_idx ON server_stats(resolution, start)`); err != nil {
+		return fmt.Errorf("create server_stats resolution start index: %w", err)
+	}
+	return nil
+}
+
+func down005(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE server_stats`); err != nil {
+		return fmt.Errorf("drop server_stats table: %w", err)
+	}
+	return nil
+}
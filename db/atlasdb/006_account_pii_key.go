@@ -0,0 +1,26 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up006, down006)
+}
+
+func up006(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE accounts ADD COLUMN pii_key TEXT NOT NULL DEFAULT ''`); err != nil {
+		return fmt.Errorf("add accounts pii_key column: %w", err)
+	}
+	return nil
+}
+
+func down006(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE accounts DROP COLUMN pii_key`); err != nil {
+		return fmt.Errorf("drop accounts pii_key column: %w", err)
+	}
+	return nil
+}
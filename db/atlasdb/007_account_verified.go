@@ -0,0 +1,32 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up007, down007)
+}
+
+func up007(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE accounts ADD COLUMN verified_at INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return fmt.Errorf("add accounts verified_at column: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE accounts ADD COLUMN auth_stale_verified INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return fmt.Errorf("add accounts auth_stale_verified column: %w", err)
+	}
+	return nil
+}
+
+func down007(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE accounts DROP COLUMN auth_stale_verified`); err != nil {
+		return fmt.Errorf("drop accounts auth_stale_verified column: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE accounts DROP COLUMN verified_at`); err != nil {
+		return fmt.Errorf("drop accounts verified_at column: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up008, down008)
+}
+
+func up008(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE username_history (
+			uid           TEXT NOT NULL,
+			time          INTEGER NOT NULL,
+			from_username TEXT NOT NULL COLLATE NOCASE,
+			to_username   TEXT NOT NULL COLLATE NOCASE
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create username_history table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX username_history_uid_idx ON username_history(uid, time)`); err != nil {
+		return fmt.Errorf("create username_history uid index: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX username_history_from_idx ON username_history(from_username, uid)`); err != nil {
+		return fmt.Errorf("create username_history from index: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX username_history_to_idx ON username_history(to_username, uid)`); err != nil {
+		return fmt.Errorf("create username_history to index: %w", err)
+	}
+	return nil
+}
+
+func down008(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE username_history`); err != nil {
+		return fmt.Errorf("drop username_history table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up009, down009)
+}
+
+func up009(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE account_signals (
+			kind       TEXT NOT NULL,
+			hash       TEXT NOT NULL,
+			uid        TEXT NOT NULL,
+			first_seen INTEGER NOT NULL,
+			last_seen  INTEGER NOT NULL,
+			PRIMARY KEY (kind, hash, uid)
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create account_signals table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX account_signals_uid_idx ON account_signals(uid)`); err != nil {
+		return fmt.Errorf("create account_signals uid index: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX account_signals_last_seen_idx ON account_signals(last_seen)`); err != nil {
+		return fmt.Errorf("create account_signals last_seen index: %w", err)
+	}
+	return nil
+}
+
+func down009(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE account_signals`); err != nil {
+		return fmt.Errorf("drop account_signals table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up010, down010)
+}
+
+func up010(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE ban_list_entries (
+			list    TEXT    NOT NULL,
+			uid     TEXT    NOT NULL,
+			source  TEXT    NOT NULL,
+			reason  TEXT    NOT NULL,
+			revoked INTEGER NOT NULL,
+			created INTEGER NOT NULL,
+			updated INTEGER NOT NULL,
+			PRIMARY KEY (list, uid, source)
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create ban_list_entries table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX ban_list_entries_uid_idx ON ban_list_entries(uid)`); err != nil {
+		return fmt.Errorf("create ban_list_entries uid index: %w", err)
+	}
+	return nil
+}
+
+func down010(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE ban_list_entries`); err != nil {
+		return fmt.Errorf("drop ban_list_entries table: %w", err)
+	}
+	return nil
+}
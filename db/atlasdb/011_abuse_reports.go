@@ -0,0 +1,54 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up011, down011)
+}
+
+func up011(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE abuse_reports (
+			id          TEXT    PRIMARY KEY NOT NULL,
+			time        INTEGER NOT NULL,
+			server_id   TEXT    NOT NULL,
+			server_name TEXT    NOT NULL,
+			server_addr TEXT    NOT NULL,
+			reporter    TEXT    NOT NULL,
+			reported    TEXT    NOT NULL,
+			reason      TEXT    NOT NULL,
+			evidence    TEXT    NOT NULL,
+			map         TEXT    NOT NULL,
+			playlist    TEXT    NOT NULL,
+			context     TEXT,
+			status      TEXT    NOT NULL,
+			note        TEXT    NOT NULL
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create abuse_reports table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX abuse_reports_status_time_idx ON abuse_reports(status, time)`); err != nil {
+		return fmt.Errorf("create abuse_reports status index: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX abuse_reports_reported_idx ON abuse_reports(reported)`); err != nil {
+		return fmt.Errorf("create abuse_reports reported index: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX abuse_reports_reporter_idx ON abuse_reports(reporter)`); err != nil {
+		return fmt.Errorf("create abuse_reports reporter index: %w", err)
+	}
+	return nil
+}
+
+func down011(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE abuse_reports`); err != nil {
+		return fmt.Errorf("drop abuse_reports table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up012, down012)
+}
+
+func up012(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE accounts ADD COLUMN auth_ip_flags INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return fmt.Errorf("add accounts auth_ip_flags column: %w", err)
+	}
+	return nil
+}
+
+func down012(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE accounts DROP COLUMN auth_ip_flags`); err != nil {
+		return fmt.Errorf("drop accounts auth_ip_flags column: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up013, down013)
+}
+
+func up013(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE player_ratings (
+			uid        TEXT    NOT NULL,
+			mode       TEXT    NOT NULL,
+			rating     REAL    NOT NULL,
+			deviation  REAL    NOT NULL,
+			volatility REAL    NOT NULL,
+			matches    INTEGER NOT NULL,
+			updated    INTEGER NOT NULL,
+			PRIMARY KEY (uid, mode)
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create player_ratings table: %w", err)
+	}
+	return nil
+}
+
+func down013(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE player_ratings`); err != nil {
+		return fmt.Errorf("drop player_ratings table: %w", err)
+	}
+	return nil
+}
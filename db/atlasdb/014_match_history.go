@@ -0,0 +1,63 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up014, down014)
+}
+
+func up014(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE matches (
+			id          TEXT    PRIMARY KEY NOT NULL,
+			time        INTEGER NOT NULL,
+			server_id   TEXT    NOT NULL,
+			server_name TEXT    NOT NULL,
+			map         TEXT    NOT NULL,
+			mode        TEXT    NOT NULL,
+			duration    INTEGER NOT NULL
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create matches table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX matches_time_idx ON matches(time)`); err != nil {
+		return fmt.Errorf("create matches time index: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE match_players (
+			match_id TEXT    NOT NULL,
+			idx      INTEGER NOT NULL,
+			uid      TEXT    NOT NULL,
+			time     INTEGER NOT NULL,
+			username TEXT    NOT NULL,
+			team     INTEGER NOT NULL,
+			rank     INTEGER NOT NULL,
+			stats    TEXT,
+			PRIMARY KEY (match_id, idx)
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create match_players table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX match_players_uid_time_idx ON match_players(uid, time)`); err != nil {
+		return fmt.Errorf("create match_players uid index: %w", err)
+	}
+	return nil
+}
+
+func down014(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE match_players`); err != nil {
+		return fmt.Errorf("drop match_players table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DROP TABLE matches`); err != nil {
+		return fmt.Errorf("drop matches table: %w", err)
+	}
+	return nil
+}
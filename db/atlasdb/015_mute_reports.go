@@ -0,0 +1,41 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up015, down015)
+}
+
+func up015(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE mute_reports (
+			uid         TEXT    NOT NULL,
+			server_addr TEXT    NOT NULL,
+			kind        TEXT    NOT NULL,
+			server_name TEXT    NOT NULL,
+			reason      TEXT    NOT NULL,
+			time        INTEGER NOT NULL,
+			PRIMARY KEY (uid, server_addr, kind)
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create mute_reports table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX mute_reports_time_idx ON mute_reports(time)`); err != nil {
+		return fmt.Errorf("create mute_reports time index: %w", err)
+	}
+	return nil
+}
+
+func down015(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE mute_reports`); err != nil {
+		return fmt.Errorf("drop mute_reports table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up016, down016)
+}
+
+func up016(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE crashes (
+			signature   TEXT    PRIMARY KEY NOT NULL,
+			first       INTEGER NOT NULL,
+			last        INTEGER NOT NULL,
+			count       INTEGER NOT NULL,
+			fingerprint TEXT    NOT NULL,
+			versions    TEXT    NOT NULL,
+			mods        TEXT    NOT NULL
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create crashes table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX crashes_last_idx ON crashes(last)`); err != nil {
+		return fmt.Errorf("create crashes last index: %w", err)
+	}
+	return nil
+}
+
+func down016(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE crashes`); err != nil {
+		return fmt.Errorf("drop crashes table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up017, down017)
+}
+
+func up017(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE leases (
+			name    TEXT    PRIMARY KEY NOT NULL,
+			holder  TEXT    NOT NULL,
+			expires INTEGER NOT NULL
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create leases table: %w", err)
+	}
+	return nil
+}
+
+func down017(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE leases`); err != nil {
+		return fmt.Errorf("drop leases table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up018, down018)
+}
+
+func up018(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE outbox (
+			id       TEXT    PRIMARY KEY NOT NULL,
+			queue    TEXT    NOT NULL,
+			key      TEXT    NOT NULL,
+			payload  BLOB    NOT NULL,
+			created  INTEGER NOT NULL,
+			state    INTEGER NOT NULL,
+			attempts INTEGER NOT NULL,
+			next     INTEGER NOT NULL,
+			error    TEXT    NOT NULL
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create outbox table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE UNIQUE INDEX outbox_key_idx ON outbox(queue, key) WHERE key != ''`); err != nil {
+		return fmt.Errorf("create outbox key index: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX outbox_next_idx ON outbox(queue, state, next)`); err != nil {
+		return fmt.Errorf("create outbox next index: %w", err)
+	}
+	return nil
+}
+
+func down018(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE outbox`); err != nil {
+		return fmt.Errorf("drop outbox table: %w", err)
+	}
+	return nil
+}
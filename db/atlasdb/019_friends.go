@@ -0,0 +1,40 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up019, down019)
+}
+
+func up019(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE friends (
+			uid     TEXT    NOT NULL,
+			friend  TEXT    NOT NULL,
+			status  TEXT    NOT NULL,
+			created INTEGER NOT NULL,
+			updated INTEGER NOT NULL,
+			PRIMARY KEY (uid, friend)
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create friends table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX friends_friend_idx ON friends(friend)`); err != nil {
+		return fmt.Errorf("create friends friend index: %w", err)
+	}
+	return nil
+}
+
+func down019(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE friends`); err != nil {
+		return fmt.Errorf("drop friends table: %w", err)
+	}
+	return nil
+}
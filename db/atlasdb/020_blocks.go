@@ -0,0 +1,38 @@
+package atlasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up020, down020)
+}
+
+func up020(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(`
+		CREATE TABLE blocks (
+			uid     TEXT    NOT NULL,
+			blocked TEXT    NOT NULL,
+			created INTEGER NOT NULL,
+			PRIMARY KEY (uid, blocked)
+		) STRICT;
+	`, `
+		`, "\n")); err != nil {
+		return fmt.Errorf("create blocks table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX blocks_blocked_idx ON blocks(blocked)`); err != nil {
+		return fmt.Errorf("create blocks blocked index: %w", err)
+	}
+	return nil
+}
+
+func down020(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE blocks`); err != nil {
+		return fmt.Errorf("drop blocks table: %w", err)
+	}
+	return nil
+}
@@ -2,20 +2,33 @@
 package atlasdb
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/netip"
 	"net/url"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/keyring"
+	"github.com/r2northstar/atlas/pkg/outbox"
 )
 
 // DB stores atlas data in a sqlite3 database.
 type DB struct {
 	x *sqlx.DB
+
+	// Keyring, if set, is used to encrypt account PII (IP addresses and auth
+	// sessions) at rest. It is required to read accounts which were previously
+	// encrypted. Usernames are not encrypted since they are used for lookups.
+	// It must not be changed after the DB is first used.
+	Keyring *keyring.Keyring
 }
 
 // Open opens a DB from the provided sqlite3 filename.
@@ -32,7 +45,7 @@ func Open(name string) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &DB{x}, nil
+	return &DB{x: x}, nil
 }
 
 func (db *DB) Close() error {
@@ -49,6 +62,14 @@ func (db *DB) GetUIDsByUsername(username string) ([]uint64, error) {
 	return u, nil
 }
 
+func (db *DB) GetAccountUIDs() ([]uint64, error) {
+	var u []uint64
+	if err := db.x.Select(&u, `SELECT uid FROM accounts ORDER BY CAST(uid AS INTEGER)`); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
 func (db *DB) GetAccount(uid uint64) (*api0.Account, error) {
 	var obj struct {
 		UID        uint64 `db:"uid"`
@@ -57,6 +78,11 @@ func (db *DB) GetAccount(uid uint64) (*api0.Account, error) {
 		AuthToken  string `db:"auth_token"`
 		AuthExpiry int64  `db:"auth_expiry"`
 		LastServer string `db:"last_server"`
+		Sessions   []byte `db:"auth_sessions"`
+		PIIKey     string `db:"pii_key"`
+		AuthStale  bool   `db:"auth_stale_verified"`
+		VerifiedAt int64  `db:"verified_at"`
+		AuthFlags  uint8  `db:"auth_ip_flags"`
 	}
 	if err := db.x.Get(&obj, `SELECT * FROM accounts WHERE uid = ?`, uid); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -65,6 +91,23 @@ func (db *DB) GetAccount(uid uint64) (*api0.Account, error) {
 		return nil, err
 	}
 
+	if obj.PIIKey != "" {
+		if obj.AuthIP != "" {
+			if v, err := db.openPII(obj.PIIKey, uid, "auth_ip", obj.AuthIP); err == nil {
+				obj.AuthIP = v
+			} else {
+				return nil, fmt.Errorf("decrypt auth_ip: %w", err)
+			}
+		}
+		if len(obj.Sessions) != 0 {
+			if v, err := db.openPII(obj.PIIKey, uid, "auth_sessions", string(obj.Sessions)); err == nil {
+				obj.Sessions = []byte(v)
+			} else {
+				return nil, fmt.Errorf("decrypt auth_sessions: %w", err)
+			}
+		}
+	}
+
 	var authExpiry time.Time
 	if obj.AuthExpiry != 0 {
 		authExpiry = time.Unix(obj.AuthExpiry, 0)
@@ -79,16 +122,61 @@ func (db *DB) GetAccount(uid uint64) (*api0.Account, error) {
 		}
 	}
 
+	var sessions []api0.AccountSession
+	if len(obj.Sessions) != 0 {
+		var v []dbAccountSession
+		if err := json.Unmarshal(obj.Sessions, &v); err != nil {
+			return nil, fmt.Errorf("parse auth_sessions: %w", err)
+		}
+		for _, x := range v {
+			s := api0.AccountSession{
+				Token:         x.Token,
+				StaleVerified: x.StaleVerified,
+				IPFlags:       api0.IPReputation(x.IPFlags),
+			}
+			if x.Expiry != 0 {
+				s.Expiry = time.Unix(x.Expiry, 0)
+			}
+			if x.IP != "" {
+				if v, err := netip.ParseAddr(x.IP); err == nil {
+					s.IP = v
+				} else {
+					return nil, fmt.Errorf("parse auth_sessions: parse ip: %w", err)
+				}
+			}
+			sessions = append(sessions, s)
+		}
+	}
+
+	var verifiedAt time.Time
+	if obj.VerifiedAt != 0 {
+		verifiedAt = time.Unix(obj.VerifiedAt, 0)
+	}
+
 	return &api0.Account{
-		UID:             obj.UID,
-		Username:        obj.Username,
-		AuthIP:          authIP,
-		AuthToken:       obj.AuthToken,
-		AuthTokenExpiry: authExpiry,
-		LastServerID:    obj.LastServer,
+		UID:               obj.UID,
+		Username:          obj.Username,
+		AuthIP:            authIP,
+		AuthToken:         obj.AuthToken,
+		AuthTokenExpiry:   authExpiry,
+		AuthStaleVerified: obj.AuthStale,
+		AuthIPFlags:       api0.IPReputation(obj.AuthFlags),
+		OtherSessions:     sessions,
+		LastServerID:      obj.LastServer,
+		VerifiedAt:        verifiedAt,
 	}, nil
 }
 
+// dbAccountSession is the JSON representation of an api0.AccountSession in
+// the auth_sessions column.
+type dbAccountSession struct {
+	IP            string `json:"ip,omitempty"`
+	Token         string `json:"token"`
+	Expiry        int64  `json:"expiry,omitempty"`
+	StaleVerified bool   `json:"stale_verified,omitempty"`
+	IPFlags       uint8  `json:"ip_flags,omitempty"`
+}
+
 func (db *DB) SaveAccount(a *api0.Account) error {
 	var authExpiry int64
 	if !a.AuthTokenExpiry.IsZero() {
@@ -100,19 +188,1486 @@ func (db *DB) SaveAccount(a *api0.Account) error {
 		authIP = a.AuthIP.StringExpanded()
 	}
 
+	var sessions *string
+	if len(a.OtherSessions) != 0 {
+		v := make([]dbAccountSession, len(a.OtherSessions))
+		for i, x := range a.OtherSessions {
+			v[i].Token = x.Token
+			v[i].StaleVerified = x.StaleVerified
+			v[i].IPFlags = uint8(x.IPFlags)
+			if !x.Expiry.IsZero() {
+				v[i].Expiry = x.Expiry.Unix()
+			}
+			if x.IP.IsValid() {
+				v[i].IP = x.IP.StringExpanded()
+			}
+		}
+		if buf, err := json.Marshal(v); err == nil {
+			x := string(buf)
+			sessions = &x
+		} else {
+			return fmt.Errorf("encode auth_sessions: %w", err)
+		}
+	}
+
+	var verifiedAt int64
+	if !a.VerifiedAt.IsZero() {
+		verifiedAt = a.VerifiedAt.Unix()
+	}
+
+	var piiKey string
+	if db.Keyring != nil {
+		piiKey = db.Keyring.Primary()
+		if authIP != "" {
+			if v, err := db.sealPII(a.UID, "auth_ip", authIP); err == nil {
+				authIP = v
+			} else {
+				return fmt.Errorf("encrypt auth_ip: %w", err)
+			}
+		}
+		if sessions != nil {
+			if v, err := db.sealPII(a.UID, "auth_sessions", *sessions); err == nil {
+				sessions = &v
+			} else {
+				return fmt.Errorf("encrypt auth_sessions: %w", err)
+			}
+		}
+	}
+
+	if _, err := db.x.NamedExec(`
+		INSERT OR REPLACE INTO
+		accounts ( uid,  username,  auth_ip,  auth_token,  auth_expiry,  auth_stale_verified,  auth_ip_flags,  auth_sessions,  last_server,  pii_key,  verified_at)
+		VALUES   (:uid, :username, :auth_ip, :auth_token, :auth_expiry, :auth_stale_verified, :auth_ip_flags, :auth_sessions, :last_server, :pii_key, :verified_at)
+	`, map[string]any{
+		"uid":                 a.UID,
+		"username":            a.Username,
+		"auth_ip":             authIP,
+		"auth_token":          a.AuthToken,
+		"auth_expiry":         authExpiry,
+		"auth_stale_verified": a.AuthStaleVerified,
+		"auth_ip_flags":       uint8(a.AuthIPFlags),
+		"auth_sessions":       sessions,
+		"last_server":         a.LastServerID,
+		"pii_key":             piiKey,
+		"verified_at":         verifiedAt,
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteAccount(uid uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM accounts WHERE uid = ?`, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sealPII encrypts the value of an account column with the primary key,
+// returning it as base64.
+func (db *DB) sealPII(uid uint64, col, v string) (string, error) {
+	_, buf, err := db.Keyring.Seal(piiAD(uid, col), []byte(v))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// openPII decrypts the base64 value of an account column.
+func (db *DB) openPII(id string, uid uint64, col, v string) (string, error) {
+	if db.Keyring == nil {
+		return "", fmt.Errorf("no keyring set")
+	}
+	buf, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return "", err
+	}
+	if buf, err = db.Keyring.Open(id, piiAD(uid, col), buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// rotatePII encrypts the value of an account column with the primary key. If
+// id is not empty, the value is already encrypted, and only the data key is
+// re-encrypted.
+func (db *DB) rotatePII(id string, uid uint64, col, v string) (string, error) {
+	if id == "" {
+		return db.sealPII(uid, col, v)
+	}
+	buf, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return "", err
+	}
+	if _, buf, err = db.Keyring.Rewrap(id, piiAD(uid, col), buf); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// piiAD returns the additional data used to bind an encrypted account column
+// to uid.
+func piiAD(uid uint64, col string) []byte {
+	return []byte("account:" + strconv.FormatUint(uid, 10) + ":" + col)
+}
+
+// RotateKeys re-encrypts account PII which is unencrypted or was encrypted
+// with a key other than the primary one in the keyring, returning the number
+// of accounts updated. It processes accounts in batches, and is safe to run
+// concurrently with other operations.
+func (db *DB) RotateKeys(ctx context.Context) (n int, err error) {
+	if db.Keyring == nil {
+		return 0, fmt.Errorf("no keyring set")
+	}
+	primary := db.Keyring.Primary()
+
+	var last string
+	for {
+		var objs []struct {
+			UID      uint64  `db:"uid"`
+			AuthIP   string  `db:"auth_ip"`
+			Sessions *string `db:"auth_sessions"`
+			PIIKey   string  `db:"pii_key"`
+		}
+		if err := db.x.SelectContext(ctx, &objs, `
+			SELECT uid, IFNULL(auth_ip, '') AS auth_ip, auth_sessions, pii_key FROM accounts
+			WHERE uid > ? AND pii_key != ? AND (pii_key != '' OR IFNULL(auth_ip, '') != '' OR auth_sessions IS NOT NULL)
+			ORDER BY uid LIMIT 500
+		`, last, primary); err != nil {
+			return n, err
+		}
+		if len(objs) == 0 {
+			return n, nil
+		}
+		for _, obj := range objs {
+			last = strconv.FormatUint(obj.UID, 10)
+
+			authIP, sessions := obj.AuthIP, obj.Sessions
+			if authIP != "" {
+				if authIP, err = db.rotatePII(obj.PIIKey, obj.UID, "auth_ip", authIP); err != nil {
+					return n, fmt.Errorf("uid %d: auth_ip: %w", obj.UID, err)
+				}
+			}
+			if sessions != nil {
+				if v, err := db.rotatePII(obj.PIIKey, obj.UID, "auth_sessions", *sessions); err == nil {
+					sessions = &v
+				} else {
+					return n, fmt.Errorf("uid %d: auth_sessions: %w", obj.UID, err)
+				}
+			}
+
+			// if the account was changed in the meantime, it will have
+			// already been encrypted with the primary key
+			if res, err := db.x.ExecContext(ctx, `
+				UPDATE accounts SET pii_key = ?, auth_ip = ?, auth_sessions = ?
+				WHERE uid = ? AND pii_key = ? AND IFNULL(auth_ip, '') = ? AND auth_sessions IS ?
+			`, primary, authIP, sessions, obj.UID, obj.PIIKey, obj.AuthIP, obj.Sessions); err != nil {
+				return n, fmt.Errorf("uid %d: %w", obj.UID, err)
+			} else if c, _ := res.RowsAffected(); c != 0 {
+				n++
+			}
+		}
+	}
+}
+
+// Compact rebuilds the database to reclaim space from deleted rows, returning
+// the number of bytes reclaimed. It blocks writes while running.
+func (db *DB) Compact(ctx context.Context) (int64, error) {
+	before, err := db.size(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.x.ExecContext(ctx, `VACUUM`); err != nil {
+		return 0, err
+	}
+	after, err := db.size(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+func (db *DB) size(ctx context.Context) (int64, error) {
+	var pages, pageSize int64
+	if err := db.x.GetContext(ctx, &pages, `PRAGMA page_count`); err != nil {
+		return 0, err
+	}
+	if err := db.x.GetContext(ctx, &pageSize, `PRAGMA page_size`); err != nil {
+		return 0, err
+	}
+	return pages * pageSize, nil
+}
+
+func (db *DB) GetAccountLinks(uid uint64) ([]api0.AccountLink, error) {
+	var objs []struct {
+		UID          uint64 `db:"uid"`
+		Provider     string `db:"provider"`
+		ExternalID   string `db:"external_id"`
+		ExternalName string `db:"external_name"`
+		VerifiedAt   int64  `db:"verified_at"`
+	}
+	if err := db.x.Select(&objs, `SELECT * FROM account_links WHERE uid = ?`, uid); err != nil {
+		return nil, err
+	}
+
+	var ls []api0.AccountLink
+	for _, obj := range objs {
+		ls = append(ls, api0.AccountLink{
+			UID:          obj.UID,
+			Provider:     api0.AccountLinkProvider(obj.Provider),
+			ExternalID:   obj.ExternalID,
+			ExternalName: obj.ExternalName,
+			VerifiedAt:   time.Unix(obj.VerifiedAt, 0),
+		})
+	}
+	return ls, nil
+}
+
+func (db *DB) GetUIDByAccountLink(provider api0.AccountLinkProvider, externalID string) (uint64, bool, error) {
+	var uid uint64
+	if err := db.x.Get(&uid, `SELECT uid FROM account_links WHERE provider = ? AND external_id = ?`, string(provider), externalID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return uid, true, nil
+}
+
+func (db *DB) SaveAccountLink(l *api0.AccountLink) error {
+	// note: INSERT OR REPLACE also removes rows conflicting on the unique (provider, external_id)
+	if _, err := db.x.NamedExec(`
+		INSERT OR REPLACE INTO
+		account_links ( uid,  provider,  external_id,  external_name,  verified_at)
+		VALUES        (:uid, :provider, :external_id, :external_name, :verified_at)
+	`, map[string]any{
+		"uid":           l.UID,
+		"provider":      string(l.Provider),
+		"external_id":   l.ExternalID,
+		"external_name": l.ExternalName,
+		"verified_at":   l.VerifiedAt.Unix(),
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteAccountLink(uid uint64, provider api0.AccountLinkProvider) error {
+	if _, err := db.x.Exec(`DELETE FROM account_links WHERE uid = ? AND provider = ?`, uid, string(provider)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) GetUsernameHistory(uid uint64) ([]api0.UsernameChange, error) {
+	var objs []struct {
+		UID  uint64 `db:"uid"`
+		Time int64  `db:"time"`
+		From string `db:"from_username"`
+		To   string `db:"to_username"`
+	}
+	if err := db.x.Select(&objs, `SELECT uid, time, from_username, to_username FROM username_history WHERE uid = ? ORDER BY time, rowid`, uid); err != nil {
+		return nil, err
+	}
+
+	var cs []api0.UsernameChange
+	for _, obj := range objs {
+		cs = append(cs, api0.UsernameChange{
+			UID:  obj.UID,
+			Time: time.UnixMilli(obj.Time),
+			From: obj.From,
+			To:   obj.To,
+		})
+	}
+	return cs, nil
+}
+
+func (db *DB) GetUIDsByPastUsername(username string) ([]uint64, error) {
+	var u []uint64
+	if username != "" {
+		if err := db.x.Select(&u, `
+			SELECT uid FROM username_history WHERE from_username = ?1
+			UNION
+			SELECT uid FROM username_history WHERE to_username = ?1
+		`, username); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+func (db *DB) SaveUsernameChange(c *api0.UsernameChange) error {
+	if _, err := db.x.NamedExec(`
+		INSERT INTO
+		username_history ( uid,  time,  from_username,  to_username)
+		VALUES           (:uid, :time, :from_username, :to_username)
+	`, map[string]any{
+		"uid":           c.UID,
+		"time":          c.Time.UnixMilli(),
+		"from_username": c.From,
+		"to_username":   c.To,
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteUsernameHistory(uid uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM username_history WHERE uid = ?`, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) GetAccountSignals(uid uint64) ([]api0.AccountSignal, error) {
+	var objs []struct {
+		UID       uint64 `db:"uid"`
+		Kind      string `db:"kind"`
+		Hash      string `db:"hash"`
+		FirstSeen int64  `db:"first_seen"`
+		LastSeen  int64  `db:"last_seen"`
+	}
+	if err := db.x.Select(&objs, `SELECT uid, kind, hash, first_seen, last_seen FROM account_signals WHERE uid = ?`, uid); err != nil {
+		return nil, err
+	}
+
+	var ss []api0.AccountSignal
+	for _, obj := range objs {
+		ss = append(ss, api0.AccountSignal{
+			UID:       obj.UID,
+			Kind:      api0.AccountSignalKind(obj.Kind),
+			Hash:      obj.Hash,
+			FirstSeen: time.Unix(obj.FirstSeen, 0),
+			LastSeen:  time.Unix(obj.LastSeen, 0),
+		})
+	}
+	return ss, nil
+}
+
+func (db *DB) GetUIDsByAccountSignal(kind api0.AccountSignalKind, hash string, limit int) ([]uint64, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	var u []uint64
+	if err := db.x.Select(&u, `SELECT uid FROM account_signals WHERE kind = ? AND hash = ? LIMIT ?`, string(kind), hash, limit); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (db *DB) SaveAccountSignal(s *api0.AccountSignal) error {
+	if _, err := db.x.NamedExec(`
+		INSERT INTO
+		account_signals ( uid,  kind,  hash,  first_seen,  last_seen)
+		VALUES          (:uid, :kind, :hash, :first_seen, :last_seen)
+		ON CONFLICT (kind, hash, uid) DO UPDATE SET last_seen = excluded.last_seen
+	`, map[string]any{
+		"uid":        s.UID,
+		"kind":       string(s.Kind),
+		"hash":       s.Hash,
+		"first_seen": s.FirstSeen.Unix(),
+		"last_seen":  s.LastSeen.Unix(),
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteAccountSignals(uid uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM account_signals WHERE uid = ?`, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteAccountSignalsBefore(t time.Time) error {
+	if _, err := db.x.Exec(`DELETE FROM account_signals WHERE last_seen < ?`, t.Unix()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) GetBanListEntries(list string) ([]api0.BanListEntry, error) {
+	var objs []struct {
+		List    string `db:"list"`
+		UID     uint64 `db:"uid"`
+		Source  string `db:"source"`
+		Reason  string `db:"reason"`
+		Revoked bool   `db:"revoked"`
+		Created int64  `db:"created"`
+		Updated int64  `db:"updated"`
+	}
+	if err := db.x.Select(&objs, `SELECT list, uid, source, reason, revoked, created, updated FROM ban_list_entries WHERE list = ?`, list); err != nil {
+		return nil, err
+	}
+
+	var es []api0.BanListEntry
+	for _, obj := range objs {
+		es = append(es, api0.BanListEntry{
+			List:    obj.List,
+			UID:     obj.UID,
+			Source:  obj.Source,
+			Reason:  obj.Reason,
+			Revoked: obj.Revoked,
+			Created: time.UnixMilli(obj.Created),
+			Updated: time.UnixMilli(obj.Updated),
+		})
+	}
+	return es, nil
+}
+
+func (db *DB) SaveBanListEntry(e *api0.BanListEntry) error {
+	if _, err := db.x.NamedExec(`
+		INSERT INTO
+		ban_list_entries ( list,  uid,  source,  reason,  revoked,  created,  updated)
+		VALUES           (:list, :uid, :source, :reason, :revoked, :created, :updated)
+		ON CONFLICT (list, uid, source) DO UPDATE SET
+			reason  = excluded.reason,
+			revoked = excluded.revoked,
+			updated = excluded.updated
+	`, map[string]any{
+		"list":    e.List,
+		"uid":     e.UID,
+		"source":  e.Source,
+		"reason":  e.Reason,
+		"revoked": e.Revoked,
+		"created": e.Created.UnixMilli(),
+		"updated": e.Updated.UnixMilli(),
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteBanList(list string) error {
+	if _, err := db.x.Exec(`DELETE FROM ban_list_entries WHERE list = ?`, list); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteBanListEntries(uid uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM ban_list_entries WHERE uid = ?`, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+type dbAbuseReport struct {
+	ID         string  `db:"id"`
+	Time       int64   `db:"time"`
+	ServerID   string  `db:"server_id"`
+	ServerName string  `db:"server_name"`
+	ServerAddr string  `db:"server_addr"`
+	Reporter   uint64  `db:"reporter"`
+	Reported   uint64  `db:"reported"`
+	Reason     string  `db:"reason"`
+	Evidence   string  `db:"evidence"`
+	Map        string  `db:"map"`
+	Playlist   string  `db:"playlist"`
+	Context    *string `db:"context"`
+	Status     string  `db:"status"`
+	Note       string  `db:"note"`
+}
+
+func (obj dbAbuseReport) decode() (api0.AbuseReport, error) {
+	r := api0.AbuseReport{
+		ID:         obj.ID,
+		Time:       time.UnixMilli(obj.Time),
+		ServerID:   obj.ServerID,
+		ServerName: obj.ServerName,
+		Reporter:   obj.Reporter,
+		Reported:   obj.Reported,
+		Reason:     obj.Reason,
+		Evidence:   obj.Evidence,
+		Map:        obj.Map,
+		Playlist:   obj.Playlist,
+		Status:     api0.AbuseReportStatus(obj.Status),
+		Note:       obj.Note,
+	}
+	if obj.ServerAddr != "" {
+		v, err := netip.ParseAddrPort(obj.ServerAddr)
+		if err != nil {
+			return r, fmt.Errorf("decode server addr for report %s: %w", obj.ID, err)
+		}
+		r.ServerAddr = v
+	}
+	if obj.Context != nil {
+		if err := json.Unmarshal([]byte(*obj.Context), &r.Context); err != nil {
+			return r, fmt.Errorf("decode context for report %s: %w", obj.ID, err)
+		}
+	}
+	return r, nil
+}
+
+func (db *DB) GetAbuseReport(id string) (*api0.AbuseReport, error) {
+	var obj dbAbuseReport
+	if err := db.x.Get(&obj, `SELECT * FROM abuse_reports WHERE id = ?`, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	r, err := obj.decode()
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (db *DB) GetAbuseReports(status api0.AbuseReportStatus, reported uint64, limit int) ([]api0.AbuseReport, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	var objs []dbAbuseReport
+	if err := db.x.Select(&objs, `
+		SELECT * FROM abuse_reports
+		WHERE (? = '' OR status = ?) AND (? = 0 OR reported = ?)
+		ORDER BY time
+		LIMIT ?
+	`, string(status), string(status), reported, reported, limit); err != nil {
+		return nil, err
+	}
+
+	var rs []api0.AbuseReport
+	for _, obj := range objs {
+		r, err := obj.decode()
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+func (db *DB) SaveAbuseReport(r *api0.AbuseReport) error {
+	var ctx *string
+	if r.Context != nil {
+		buf, err := json.Marshal(r.Context)
+		if err != nil {
+			return fmt.Errorf("encode context: %w", err)
+		}
+		x := string(buf)
+		ctx = &x
+	}
+	var addr string
+	if r.ServerAddr.IsValid() {
+		addr = r.ServerAddr.String()
+	}
 	if _, err := db.x.NamedExec(`
 		INSERT OR REPLACE INTO
-		accounts ( uid,  username,  auth_ip,  auth_token,  auth_expiry,  last_server)
-		VALUES   (:uid, :username, :auth_ip, :auth_token, :auth_expiry, :last_server)
+		abuse_reports ( id,  time,  server_id,  server_name,  server_addr,  reporter,  reported,  reason,  evidence,  map,  playlist,  context,  status,  note)
+		VALUES        (:id, :time, :server_id, :server_name, :server_addr, :reporter, :reported, :reason, :evidence, :map, :playlist, :context, :status, :note)
 	`, map[string]any{
-		"uid":         a.UID,
-		"username":    a.Username,
-		"auth_ip":     authIP,
-		"auth_token":  a.AuthToken,
-		"auth_expiry": authExpiry,
-		"last_server": a.LastServerID,
+		"id":          r.ID,
+		"time":        r.Time.UnixMilli(),
+		"server_id":   r.ServerID,
+		"server_name": r.ServerName,
+		"server_addr": addr,
+		"reporter":    r.Reporter,
+		"reported":    r.Reported,
+		"reason":      r.Reason,
+		"evidence":    r.Evidence,
+		"map":         r.Map,
+		"playlist":    r.Playlist,
+		"context":     ctx,
+		"status":      string(r.Status),
+		"note":        r.Note,
 	}); err != nil {
 		return err
 	}
 	return nil
 }
+
+func (db *DB) DeleteAbuseReports(uid uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM abuse_reports WHERE reporter = ? OR reported = ?`, uid, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) GetState(key string) ([]byte, bool, error) {
+	var buf []byte
+	if err := db.x.Get(&buf, `SELECT value FROM state WHERE key = ?`, key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if buf == nil {
+		buf = []byte{}
+	}
+	return buf, true, nil
+}
+
+func (db *DB) GetStateKeys() ([]string, error) {
+	var ks []string
+	if err := db.x.Select(&ks, `SELECT key FROM state ORDER BY key`); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+func (db *DB) SetState(key string, buf []byte) error {
+	if buf == nil {
+		if _, err := db.x.Exec(`DELETE FROM state WHERE key = ?`, key); err != nil {
+			return err
+		}
+		return nil
+	}
+	if _, err := db.x.Exec(`INSERT OR REPLACE INTO state (key, value) VALUES (?, ?)`, key, buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) GetServerStats(addr netip.AddrPort, resolution time.Duration, since time.Time) ([]api0.ServerStatsBucket, error) {
+	var objs []struct {
+		Start      int64   `db:"start"`
+		Name       string  `db:"name"`
+		Samples    int     `db:"samples"`
+		PlayerSum  int     `db:"player_sum"`
+		PlayerMax  int     `db:"player_max"`
+		MaxPlayers int     `db:"max_players"`
+		Maps       *string `db:"maps"`
+	}
+	if err := db.x.Select(&objs, `
+		SELECT start, name, samples, player_sum, player_max, max_players, maps
+		FROM server_stats
+		WHERE addr = ? AND resolution = ? AND start >= ?
+		ORDER BY start
+	`, dbServerStatsAddr(addr), int64(resolution), since.UnixNano()); err != nil {
+		return nil, err
+	}
+
+	var bs []api0.ServerStatsBucket
+	for _, obj := range objs {
+		b := api0.ServerStatsBucket{
+			Addr:       addr,
+			Start:      time.Unix(0, obj.Start),
+			Resolution: resolution,
+			Name:       obj.Name,
+			Samples:    obj.Samples,
+			PlayerSum:  obj.PlayerSum,
+			PlayerMax:  obj.PlayerMax,
+			MaxPlayers: obj.MaxPlayers,
+		}
+		if obj.Maps != nil {
+			if err := json.Unmarshal([]byte(*obj.Maps), &b.Maps); err != nil {
+				return nil, fmt.Errorf("decode maps for bucket at %d: %w", obj.Start, err)
+			}
+		}
+		bs = append(bs, b)
+	}
+	return bs, nil
+}
+
+func (db *DB) SaveServerStats(bs []api0.ServerStatsBucket) error {
+	tx, err := db.x.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, b := range bs {
+		var maps *string
+		if b.Maps != nil {
+			buf, err := json.Marshal(b.Maps)
+			if err != nil {
+				return fmt.Errorf("encode maps: %w", err)
+			}
+			x := string(buf)
+			maps = &x
+		}
+		if _, err := tx.NamedExec(`
+			INSERT OR REPLACE INTO
+			server_stats ( addr,  resolution,  start,  name,  samples,  player_sum,  player_max,  max_players,  maps)
+			VALUES       (:addr, :resolution, :start, :name, :samples, :player_sum, :player_max, :max_players, :maps)
+		`, map[string]any{
+			"addr":        dbServerStatsAddr(b.Addr),
+			"resolution":  int64(b.Resolution),
+			"start":       b.Start.UnixNano(),
+			"name":        b.Name,
+			"samples":     b.Samples,
+			"player_sum":  b.PlayerSum,
+			"player_max":  b.PlayerMax,
+			"max_players": b.MaxPlayers,
+			"maps":        maps,
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// dbServerStatsAddr encodes addr for the server_stats table, using an empty
+// string for the totals.
+func dbServerStatsAddr(addr netip.AddrPort) string {
+	if !addr.IsValid() {
+		return ""
+	}
+	return addr.String()
+}
+
+func (db *DB) DeleteServerStats(resolution time.Duration, before time.Time) (int, error) {
+	res, err := db.x.Exec(`DELETE FROM server_stats WHERE resolution = ? AND start < ?`, int64(resolution), before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+type dbPlayerRating struct {
+	UID        uint64  `db:"uid"`
+	Mode       string  `db:"mode"`
+	Rating     float64 `db:"rating"`
+	Deviation  float64 `db:"deviation"`
+	Volatility float64 `db:"volatility"`
+	Matches    int     `db:"matches"`
+	Updated    int64   `db:"updated"`
+}
+
+func (obj dbPlayerRating) decode() api0.PlayerRating {
+	return api0.PlayerRating{
+		UID:        obj.UID,
+		Mode:       obj.Mode,
+		Rating:     obj.Rating,
+		Deviation:  obj.Deviation,
+		Volatility: obj.Volatility,
+		Matches:    obj.Matches,
+		Updated:    time.UnixMilli(obj.Updated),
+	}
+}
+
+func (db *DB) GetRatings(uid uint64) ([]api0.PlayerRating, error) {
+	var objs []dbPlayerRating
+	if err := db.x.Select(&objs, `SELECT * FROM player_ratings WHERE uid = ? ORDER BY mode`, uid); err != nil {
+		return nil, err
+	}
+	var rs []api0.PlayerRating
+	for _, obj := range objs {
+		rs = append(rs, obj.decode())
+	}
+	return rs, nil
+}
+
+func (db *DB) GetRating(uid uint64, mode string) (*api0.PlayerRating, error) {
+	var obj dbPlayerRating
+	if err := db.x.Get(&obj, `SELECT * FROM player_ratings WHERE uid = ? AND mode = ?`, uid, mode); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	r := obj.decode()
+	return &r, nil
+}
+
+func (db *DB) SaveRatings(rs []api0.PlayerRating) error {
+	tx, err := db.x.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range rs {
+		if _, err := tx.NamedExec(`
+			INSERT OR REPLACE INTO
+			player_ratings ( uid,  mode,  rating,  deviation,  volatility,  matches,  updated)
+			VALUES         (:uid, :mode, :rating, :deviation, :volatility, :matches, :updated)
+		`, map[string]any{
+			"uid":        r.UID,
+			"mode":       r.Mode,
+			"rating":     r.Rating,
+			"deviation":  r.Deviation,
+			"volatility": r.Volatility,
+			"matches":    r.Matches,
+			"updated":    r.Updated.UnixMilli(),
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *DB) DeleteRatings(uid uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM player_ratings WHERE uid = ?`, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+type dbMatch struct {
+	ID         string `db:"id"`
+	Time       int64  `db:"time"`
+	ServerID   string `db:"server_id"`
+	ServerName string `db:"server_name"`
+	Map        string `db:"map"`
+	Mode       string `db:"mode"`
+	Duration   int64  `db:"duration"`
+}
+
+type dbMatchPlayer struct {
+	MatchID  string  `db:"match_id"`
+	Idx      int     `db:"idx"`
+	UID      uint64  `db:"uid"`
+	Time     int64   `db:"time"`
+	Username string  `db:"username"`
+	Team     int     `db:"team"`
+	Rank     int     `db:"rank"`
+	Stats    *string `db:"stats"`
+}
+
+func (db *DB) GetMatches(uid uint64, before time.Time, limit int) ([]api0.MatchRecord, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	var b int64
+	if !before.IsZero() {
+		b = before.UnixMilli()
+	}
+	var objs []dbMatch
+	if err := db.x.Select(&objs, `
+		SELECT * FROM matches
+		WHERE id IN (SELECT match_id FROM match_players WHERE uid = ? AND (? = 0 OR time < ?))
+		ORDER BY time DESC
+		LIMIT ?
+	`, uid, b, b, limit); err != nil {
+		return nil, err
+	}
+
+	var ms []api0.MatchRecord
+	for _, obj := range objs {
+		m := api0.MatchRecord{
+			ID:         obj.ID,
+			Time:       time.UnixMilli(obj.Time),
+			ServerID:   obj.ServerID,
+			ServerName: obj.ServerName,
+			Map:        obj.Map,
+			Mode:       obj.Mode,
+			Duration:   time.Duration(obj.Duration) * time.Millisecond,
+		}
+		var pobjs []dbMatchPlayer
+		if err := db.x.Select(&pobjs, `SELECT * FROM match_players WHERE match_id = ? ORDER BY idx`, obj.ID); err != nil {
+			return nil, err
+		}
+		for _, pobj := range pobjs {
+			p := api0.MatchPlayer{
+				UID:      pobj.UID,
+				Username: pobj.Username,
+				Team:     pobj.Team,
+				Rank:     pobj.Rank,
+			}
+			if pobj.Stats != nil {
+				if err := json.Unmarshal([]byte(*pobj.Stats), &p.Stats); err != nil {
+					return nil, fmt.Errorf("decode stats for match %s: %w", obj.ID, err)
+				}
+			}
+			m.Players = append(m.Players, p)
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+func (db *DB) SaveMatch(m *api0.MatchRecord) error {
+	tx, err := db.x.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.NamedExec(`
+		INSERT OR REPLACE INTO
+		matches ( id,  time,  server_id,  server_name,  map,  mode,  duration)
+		VALUES  (:id, :time, :server_id, :server_name, :map, :mode, :duration)
+	`, map[string]any{
+		"id":          m.ID,
+		"time":        m.Time.UnixMilli(),
+		"server_id":   m.ServerID,
+		"server_name": m.ServerName,
+		"map":         m.Map,
+		"mode":        m.Mode,
+		"duration":    m.Duration.Milliseconds(),
+	}); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM match_players WHERE match_id = ?`, m.ID); err != nil {
+		return err
+	}
+	for i, p := range m.Players {
+		var stats *string
+		if p.Stats != nil {
+			buf, err := json.Marshal(p.Stats)
+			if err != nil {
+				return fmt.Errorf("encode stats: %w", err)
+			}
+			x := string(buf)
+			stats = &x
+		}
+		if _, err := tx.NamedExec(`
+			INSERT INTO
+			match_players ( match_id,  idx,  uid,  time,  username,  team,  rank,  stats)
+			VALUES        (:match_id, :idx, :uid, :time, :username, :team, :rank, :stats)
+		`, map[string]any{
+			"match_id": m.ID,
+			"idx":      i,
+			"uid":      p.UID,
+			"time":     m.Time.UnixMilli(),
+			"username": p.Username,
+			"team":     p.Team,
+			"rank":     p.Rank,
+			"stats":    stats,
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *DB) DeleteMatchesBefore(t time.Time) error {
+	tx, err := db.x.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM match_players WHERE time < ?`, t.UnixMilli()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM matches WHERE time < ?`, t.UnixMilli()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) DeletePlayerMatches(uid uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM match_players WHERE uid = ?`, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+type dbMuteReport struct {
+	UID        uint64 `db:"uid"`
+	ServerAddr string `db:"server_addr"`
+	Kind       string `db:"kind"`
+	ServerName string `db:"server_name"`
+	Reason     string `db:"reason"`
+	Time       int64  `db:"time"`
+}
+
+func (db *DB) GetMuteReports(uid uint64, since time.Time) ([]api0.MuteReport, error) {
+	var objs []dbMuteReport
+	if err := db.x.Select(&objs, `SELECT * FROM mute_reports WHERE uid = ? AND time >= ? ORDER BY time`, uid, since.UnixMilli()); err != nil {
+		return nil, err
+	}
+	var rs []api0.MuteReport
+	for _, obj := range objs {
+		addr, err := netip.ParseAddr(obj.ServerAddr)
+		if err != nil {
+			return nil, fmt.Errorf("decode server addr for mute report: %w", err)
+		}
+		rs = append(rs, api0.MuteReport{
+			UID:        obj.UID,
+			ServerAddr: addr,
+			Kind:       api0.MuteKind(obj.Kind),
+			ServerName: obj.ServerName,
+			Reason:     obj.Reason,
+			Time:       time.UnixMilli(obj.Time),
+		})
+	}
+	return rs, nil
+}
+
+func (db *DB) SaveMuteReport(r api0.MuteReport) error {
+	if _, err := db.x.NamedExec(`
+		INSERT OR REPLACE INTO
+		mute_reports ( uid,  server_addr,  kind,  server_name,  reason,  time)
+		VALUES       (:uid, :server_addr, :kind, :server_name, :reason, :time)
+	`, map[string]any{
+		"uid":         r.UID,
+		"server_addr": r.ServerAddr.String(),
+		"kind":        string(r.Kind),
+		"server_name": r.ServerName,
+		"reason":      r.Reason,
+		"time":        r.Time.UnixMilli(),
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteMuteReport(uid uint64, addr netip.Addr, kind api0.MuteKind) error {
+	if _, err := db.x.Exec(`DELETE FROM mute_reports WHERE uid = ? AND server_addr = ? AND kind = ?`, uid, addr.String(), string(kind)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteMuteReportsBefore(t time.Time) error {
+	if _, err := db.x.Exec(`DELETE FROM mute_reports WHERE time < ?`, t.UnixMilli()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeletePlayerMuteReports(uid uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM mute_reports WHERE uid = ?`, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+type dbFriend struct {
+	UID     uint64 `db:"uid"`
+	Friend  uint64 `db:"friend"`
+	Status  string `db:"status"`
+	Created int64  `db:"created"`
+	Updated int64  `db:"updated"`
+}
+
+func (obj dbFriend) decode() api0.Friend {
+	return api0.Friend{
+		UID:     obj.UID,
+		Friend:  obj.Friend,
+		Status:  api0.FriendStatus(obj.Status),
+		Created: time.UnixMilli(obj.Created),
+		Updated: time.UnixMilli(obj.Updated),
+	}
+}
+
+func (db *DB) GetFriends(uid uint64) ([]api0.Friend, error) {
+	var objs []dbFriend
+	if err := db.x.Select(&objs, `SELECT * FROM friends WHERE uid = ?`, uid); err != nil {
+		return nil, err
+	}
+	var fs []api0.Friend
+	for _, obj := range objs {
+		fs = append(fs, obj.decode())
+	}
+	// uids are stored as text, so they can't be ordered numerically in sql
+	sort.Slice(fs, func(i, j int) bool {
+		return fs[i].Friend < fs[j].Friend
+	})
+	return fs, nil
+}
+
+func (db *DB) GetFriend(uid, friend uint64) (*api0.Friend, error) {
+	var obj dbFriend
+	if err := db.x.Get(&obj, `SELECT * FROM friends WHERE uid = ? AND friend = ?`, uid, friend); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	f := obj.decode()
+	return &f, nil
+}
+
+func (db *DB) SaveFriends(fs []api0.Friend) error {
+	tx, err := db.x.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, f := range fs {
+		if _, err := tx.NamedExec(`
+			INSERT OR REPLACE INTO
+			friends ( uid,  friend,  status,  created,  updated)
+			VALUES  (:uid, :friend, :status, :created, :updated)
+		`, map[string]any{
+			"uid":     f.UID,
+			"friend":  f.Friend,
+			"status":  string(f.Status),
+			"created": f.Created.UnixMilli(),
+			"updated": f.Updated.UnixMilli(),
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *DB) DeleteFriend(uid, friend uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM friends WHERE (uid = ? AND friend = ?) OR (uid = ? AND friend = ?)`, uid, friend, friend, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteFriends(uid uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM friends WHERE uid = ? OR friend = ?`, uid, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+type dbBlock struct {
+	UID     uint64 `db:"uid"`
+	Blocked uint64 `db:"blocked"`
+	Created int64  `db:"created"`
+}
+
+func (obj dbBlock) decode() api0.Block {
+	return api0.Block{
+		UID:     obj.UID,
+		Blocked: obj.Blocked,
+		Created: time.UnixMilli(obj.Created),
+	}
+}
+
+func (db *DB) GetBlocks(uid uint64) ([]api0.Block, error) {
+	var objs []dbBlock
+	if err := db.x.Select(&objs, `SELECT * FROM blocks WHERE uid = ?`, uid); err != nil {
+		return nil, err
+	}
+	var bs []api0.Block
+	for _, obj := range objs {
+		bs = append(bs, obj.decode())
+	}
+	// uids are stored as text, so they can't be ordered numerically in sql
+	sort.Slice(bs, func(i, j int) bool {
+		return bs[i].Blocked < bs[j].Blocked
+	})
+	return bs, nil
+}
+
+func (db *DB) GetBlockedBy(uid uint64) ([]uint64, error) {
+	var uids []uint64
+	if err := db.x.Select(&uids, `SELECT uid FROM blocks WHERE blocked = ?`, uid); err != nil {
+		return nil, err
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		return uids[i] < uids[j]
+	})
+	return uids, nil
+}
+
+func (db *DB) GetBlock(uid, blocked uint64) (*api0.Block, error) {
+	var obj dbBlock
+	if err := db.x.Get(&obj, `SELECT * FROM blocks WHERE uid = ? AND blocked = ?`, uid, blocked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	b := obj.decode()
+	return &b, nil
+}
+
+func (db *DB) SaveBlock(b api0.Block) error {
+	if _, err := db.x.NamedExec(`
+		INSERT OR REPLACE INTO
+		blocks ( uid,  blocked,  created)
+		VALUES (:uid, :blocked, :created)
+	`, map[string]any{
+		"uid":     b.UID,
+		"blocked": b.Blocked,
+		"created": b.Created.UnixMilli(),
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteBlock(uid, blocked uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM blocks WHERE uid = ? AND blocked = ?`, uid, blocked); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteBlocks(uid uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM blocks WHERE uid = ? OR blocked = ?`, uid, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+type dbCrash struct {
+	Signature   string `db:"signature"`
+	First       int64  `db:"first"`
+	Last        int64  `db:"last"`
+	Count       int    `db:"count"`
+	Fingerprint string `db:"fingerprint"`
+	Versions    string `db:"versions"`
+	Mods        string `db:"mods"`
+}
+
+type dbCrashFingerprint struct {
+	Exception string   `json:"exception"`
+	Module    string   `json:"module,omitempty"`
+	Stack     []string `json:"stack,omitempty"`
+}
+
+func (obj dbCrash) decode() (api0.CrashSignature, error) {
+	c := api0.CrashSignature{
+		Signature: obj.Signature,
+		First:     time.UnixMilli(obj.First),
+		Last:      time.UnixMilli(obj.Last),
+		Count:     obj.Count,
+	}
+	var f dbCrashFingerprint
+	if err := json.Unmarshal([]byte(obj.Fingerprint), &f); err != nil {
+		return c, fmt.Errorf("decode fingerprint for crash %s: %w", obj.Signature, err)
+	}
+	c.Fingerprint = api0.CrashFingerprint{
+		Exception: f.Exception,
+		Module:    f.Module,
+		Stack:     f.Stack,
+	}
+	if err := json.Unmarshal([]byte(obj.Versions), &c.Versions); err != nil {
+		return c, fmt.Errorf("decode versions for crash %s: %w", obj.Signature, err)
+	}
+	if err := json.Unmarshal([]byte(obj.Mods), &c.Mods); err != nil {
+		return c, fmt.Errorf("decode mods for crash %s: %w", obj.Signature, err)
+	}
+	return c, nil
+}
+
+func (db *DB) RecordCrash(r api0.CrashReport) error {
+	tx, err := db.x.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	c := api0.CrashSignature{
+		Signature: r.Signature,
+		First:     r.Time,
+		Versions:  map[string]int{},
+		Mods:      map[string]int{},
+	}
+	var obj dbCrash
+	if err := tx.Get(&obj, `SELECT * FROM crashes WHERE signature = ?`, r.Signature); err == nil {
+		if c, err = obj.decode(); err != nil {
+			return err
+		}
+		if c.Versions == nil {
+			c.Versions = map[string]int{}
+		}
+		if c.Mods == nil {
+			c.Mods = map[string]int{}
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	c.Last = r.Time
+	c.Count++
+	c.Fingerprint = r.Fingerprint
+	c.Versions[r.Version]++
+	for _, m := range r.Mods {
+		c.Mods[m]++
+	}
+
+	fingerprint, err := json.Marshal(dbCrashFingerprint{
+		Exception: c.Fingerprint.Exception,
+		Module:    c.Fingerprint.Module,
+		Stack:     c.Fingerprint.Stack,
+	})
+	if err != nil {
+		return fmt.Errorf("encode fingerprint: %w", err)
+	}
+	versions, err := json.Marshal(c.Versions)
+	if err != nil {
+		return fmt.Errorf("encode versions: %w", err)
+	}
+	mods, err := json.Marshal(c.Mods)
+	if err != nil {
+		return fmt.Errorf("encode mods: %w", err)
+	}
+	if _, err := tx.NamedExec(`
+		INSERT OR REPLACE INTO
+		crashes ( signature,  first,  last,  count,  fingerprint,  versions,  mods)
+		VALUES  (:signature, :first, :last, :count, :fingerprint, :versions, :mods)
+	`, map[string]any{
+		"signature":   c.Signature,
+		"first":       c.First.UnixMilli(),
+		"last":        c.Last.UnixMilli(),
+		"count":       c.Count,
+		"fingerprint": string(fingerprint),
+		"versions":    string(versions),
+		"mods":        string(mods),
+	}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) GetCrashes(since time.Time, limit int) ([]api0.CrashSignature, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	var objs []dbCrash
+	if err := db.x.Select(&objs, `SELECT * FROM crashes WHERE last >= ? ORDER BY count DESC, last DESC LIMIT ?`, since.UnixMilli(), limit); err != nil {
+		return nil, err
+	}
+	var cs []api0.CrashSignature
+	for _, obj := range objs {
+		c, err := obj.decode()
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+func (db *DB) GetCrash(signature string) (*api0.CrashSignature, error) {
+	var obj dbCrash
+	if err := db.x.Get(&obj, `SELECT * FROM crashes WHERE signature = ?`, signature); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	c, err := obj.decode()
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (db *DB) DeleteCrash(signature string) error {
+	if _, err := db.x.Exec(`DELETE FROM crashes WHERE signature = ?`, signature); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteCrashesBefore(t time.Time) error {
+	if _, err := db.x.Exec(`DELETE FROM crashes WHERE last < ?`, t.UnixMilli()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AcquireLease acquires or renews a lease for leader election. Expiry times
+// use the local clock, so instances sharing the database must be in sync.
+func (db *DB) AcquireLease(name, holder string, ttl time.Duration) (string, error) {
+	tx, err := db.x.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec(`
+		INSERT INTO leases (name, holder, expires) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires = excluded.expires
+		WHERE leases.holder = excluded.holder OR leases.expires <= ?
+	`, name, holder, now.Add(ttl).UnixMilli(), now.UnixMilli()); err != nil {
+		return "", err
+	}
+
+	var cur string
+	if err := tx.Get(&cur, `SELECT holder FROM leases WHERE name = ?`, name); err != nil {
+		return "", err
+	}
+	return cur, tx.Commit()
+}
+
+func (db *DB) ReleaseLease(name, holder string) error {
+	if _, err := db.x.Exec(`DELETE FROM leases WHERE name = ? AND holder = ?`, name, holder); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) PutOutbox(it outbox.Item) (bool, error) {
+	res, err := db.x.NamedExec(`
+		INSERT INTO
+		outbox ( id,  queue,  key,  payload,  created,  state,  attempts,  next,  error)
+		VALUES (:id, :queue, :key, :payload, :created, :state, :attempts, :next, :error)
+		ON CONFLICT (queue, key) WHERE key != '' DO NOTHING
+	`, map[string]any{
+		"id":       it.ID,
+		"queue":    it.Queue,
+		"key":      it.Key,
+		"payload":  it.Payload,
+		"created":  it.Created.UnixMilli(),
+		"state":    int(it.State),
+		"attempts": it.Attempts,
+		"next":     it.Next.UnixMilli(),
+		"error":    it.Error,
+	})
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+func (db *DB) ClaimOutbox(queue string, now, until time.Time, n int) ([]outbox.Item, error) {
+	tx, err := db.x.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var objs []struct {
+		ID       string `db:"id"`
+		Queue    string `db:"queue"`
+		Key      string `db:"key"`
+		Payload  []byte `db:"payload"`
+		Created  int64  `db:"created"`
+		State    int    `db:"state"`
+		Attempts int    `db:"attempts"`
+		Next     int64  `db:"next"`
+		Error    string `db:"error"`
+	}
+	if err := tx.Select(&objs, `
+		SELECT id, queue, key, payload, created, state, attempts, next, error FROM outbox
+		WHERE queue = ? AND state = ? AND next <= ?
+		ORDER BY created, id LIMIT ?
+	`, queue, int(outbox.StatePending), now.UnixMilli(), n); err != nil {
+		return nil, err
+	}
+
+	its := make([]outbox.Item, 0, len(objs))
+	for _, obj := range objs {
+		if _, err := tx.Exec(`UPDATE outbox SET next = ? WHERE id = ?`, until.UnixMilli(), obj.ID); err != nil {
+			return nil, err
+		}
+		its = append(its, outbox.Item{
+			ID:       obj.ID,
+			Queue:    obj.Queue,
+			Key:      obj.Key,
+			Payload:  obj.Payload,
+			Created:  time.UnixMilli(obj.Created),
+			State:    outbox.State(obj.State),
+			Attempts: obj.Attempts,
+			Next:     until,
+			Error:    obj.Error,
+		})
+	}
+	return its, tx.Commit()
+}
+
+func (db *DB) UpdateOutbox(it outbox.Item) error {
+	if _, err := db.x.Exec(`UPDATE outbox SET state = ?, attempts = ?, next = ?, error = ? WHERE id = ?`, int(it.State), it.Attempts, it.Next.UnixMilli(), it.Error, it.ID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) PruneOutbox(queue string, before time.Time) (int, error) {
+	res, err := db.x.Exec(`DELETE FROM outbox WHERE queue = ? AND state != ? AND next < ?`, queue, int(outbox.StatePending), before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
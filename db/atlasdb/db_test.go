@@ -2,11 +2,18 @@ package atlasdb
 
 import (
 	"context"
+	"net/netip"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/r2northstar/atlas/pkg/api/api0"
 	"github.com/r2northstar/atlas/pkg/api/api0/api0testutil"
+	"github.com/r2northstar/atlas/pkg/keyring"
+	"github.com/r2northstar/atlas/pkg/leader/leadertest"
+	"github.com/r2northstar/atlas/pkg/outbox/outboxtest"
 )
 
 func TestAccountStorage(t *testing.T) {
@@ -29,3 +36,410 @@ func TestAccountStorage(t *testing.T) {
 
 	api0testutil.TestAccountStorage(t, db)
 }
+
+func TestAccountLinkStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestAccountLinkStorage(t, db)
+}
+
+func TestUsernameHistoryStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestUsernameHistoryStorage(t, db)
+}
+
+func TestAccountSignalStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestAccountSignalStorage(t, db)
+}
+
+func TestBanListStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestBanListStorage(t, db)
+}
+
+func TestAbuseReportStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestAbuseReportStorage(t, db)
+}
+
+func TestStateStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestStateStorage(t, db)
+}
+
+func TestServerStatsStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestServerStatsStorage(t, db)
+}
+
+func TestRatingStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestRatingStorage(t, db)
+}
+
+func TestMatchHistoryStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestMatchHistoryStorage(t, db)
+}
+
+func TestMuteReportStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestMuteReportStorage(t, db)
+}
+
+func TestCrashStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestCrashStorage(t, db)
+}
+
+func TestFriendStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestFriendStorage(t, db)
+}
+
+func TestBlockStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestBlockStorage(t, db)
+}
+
+func TestLeaseBackend(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	leadertest.TestBackend(t, db)
+}
+
+func TestOutboxBackend(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	outboxtest.TestBackend(t, db)
+}
+
+func TestAccountListStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestAccountListStorage(t, db)
+}
+
+func TestStateListStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestStateListStorage(t, db)
+}
+
+func TestAccountStorageEncrypted(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	db.Keyring, err = keyring.Parse("a:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	if err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestAccountStorage(t, db)
+}
+
+func TestAccountStorageRotateKeys(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "atlas.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	acct := &api0.Account{
+		UID:             1,
+		Username:        "test",
+		AuthIP:          netip.MustParseAddr("192.0.2.1"),
+		AuthToken:       "token",
+		AuthTokenExpiry: time.Unix(1700000000, 0),
+		OtherSessions: []api0.AccountSession{{
+			IP:     netip.MustParseAddr("192.0.2.2"),
+			Token:  "token2",
+			Expiry: time.Unix(1700000000, 0),
+		}},
+	}
+	if err := db.SaveAccount(acct); err != nil {
+		t.Fatalf("save account: %v", err)
+	}
+	if err := db.SaveAccount(&api0.Account{UID: 2}); err != nil {
+		t.Fatalf("save account: %v", err)
+	}
+
+	for i, ks := range []string{
+		"a:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		"b:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=,a:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+	} {
+		if db.Keyring, err = keyring.Parse(ks); err != nil {
+			panic(err)
+		}
+		if n, err := db.RotateKeys(context.Background()); err != nil {
+			t.Fatalf("rotate %d: %v", i, err)
+		} else if n != 1 {
+			t.Errorf("rotate %d: expected 1 account to be updated, got %d", i, n)
+		}
+		if n, err := db.RotateKeys(context.Background()); err != nil || n != 0 {
+			t.Errorf("rotate %d: expected no accounts to be updated again, got %d, %v", i, n, err)
+		}
+
+		var raw struct {
+			AuthIP   string `db:"auth_ip"`
+			Sessions string `db:"auth_sessions"`
+			PIIKey   string `db:"pii_key"`
+		}
+		if err := db.x.Get(&raw, `SELECT auth_ip, auth_sessions, pii_key FROM accounts WHERE uid = 1`); err != nil {
+			t.Fatalf("rotate %d: get raw account: %v", i, err)
+		}
+		if raw.PIIKey != db.Keyring.Primary() {
+			t.Errorf("rotate %d: expected key %q, got %q", i, db.Keyring.Primary(), raw.PIIKey)
+		}
+		if strings.Contains(raw.AuthIP, "192.0.2.") || strings.Contains(raw.Sessions, "192.0.2.") {
+			t.Errorf("rotate %d: raw account contains unencrypted ip", i)
+		}
+
+		if a, err := db.GetAccount(1); err != nil {
+			t.Errorf("rotate %d: get account: %v", i, err)
+		} else if a.AuthIP != acct.AuthIP || len(a.OtherSessions) != 1 || a.OtherSessions[0] != acct.OtherSessions[0] {
+			t.Errorf("rotate %d: incorrect account after rotation: %+v", i, a)
+		}
+	}
+
+	db.Keyring = nil
+	if _, err := db.GetAccount(1); err == nil {
+		t.Errorf("expected error reading encrypted account without keyring")
+	}
+}
@@ -0,0 +1,26 @@
+package pdatadb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	migrate(up002, down002)
+}
+
+func up002(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE pdata ADD COLUMN pdata_key TEXT NOT NULL DEFAULT ''`); err != nil {
+		return fmt.Errorf("add pdata pdata_key column: %w", err)
+	}
+	return nil
+}
+
+func down002(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE pdata DROP COLUMN pdata_key`); err != nil {
+		return fmt.Errorf("drop pdata pdata_key column: %w", err)
+	}
+	return nil
+}
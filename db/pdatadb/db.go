@@ -3,16 +3,19 @@ package pdatadb
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
 	"sync"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/klauspost/compress/gzip"
+	"github.com/r2northstar/atlas/pkg/keyring"
 )
 
 // DB stores player data in a sqlite3 database.
@@ -20,6 +23,11 @@ type DB struct {
 	x     *sqlx.DB
 	gzipW sync.Pool
 	gzipR sync.Pool
+
+	// Keyring, if set, is used to encrypt pdata at rest. It is required to
+	// read pdata which was previously encrypted. It must not be changed after
+	// the DB is first used.
+	Keyring *keyring.Keyring
 }
 
 // Open opens a DB from the provided sqlite3 uri.
@@ -61,6 +69,14 @@ func (db *DB) GetPdataHash(uid uint64) (hash [sha256.Size]byte, exists bool, err
 	return hash, true, nil
 }
 
+func (db *DB) GetPdataUIDs() ([]uint64, error) {
+	var u []uint64
+	if err := db.x.Select(&u, `SELECT uid FROM pdata ORDER BY uid`); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
 func (db *DB) GetPdataCached(uid uint64, sha [sha256.Size]byte) (buf []byte, exists bool, err error) {
 	tx, err := db.x.Beginx()
 	if err != nil {
@@ -90,15 +106,27 @@ func (db *DB) GetPdataCached(uid uint64, sha [sha256.Size]byte) (buf []byte, exi
 	var obj struct {
 		PdataComp string `db:"pdata_comp"`
 		PdataHash string `db:"pdata_hash"`
+		PdataKey  string `db:"pdata_key"`
 		Pdata     []byte `db:"pdata"`
 	}
-	if err := db.x.Get(&obj, `SELECT pdata_comp, pdata_hash, pdata FROM pdata WHERE uid = ?`, uid); err != nil {
+	if err := db.x.Get(&obj, `SELECT pdata_comp, pdata_hash, pdata_key, pdata FROM pdata WHERE uid = ?`, uid); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, false, nil
 		}
 		return nil, false, err
 	}
 
+	if obj.PdataKey != "" {
+		if db.Keyring == nil {
+			return nil, false, fmt.Errorf("decrypt pdata: no keyring set")
+		}
+		b, err := db.Keyring.Open(obj.PdataKey, pdataAD(uid), obj.Pdata)
+		if err != nil {
+			return nil, false, fmt.Errorf("decrypt pdata: %w", err)
+		}
+		obj.Pdata = b
+	}
+
 	switch obj.PdataComp {
 	case "":
 	case "gzip":
@@ -166,17 +194,113 @@ func (db *DB) SetPdata(uid uint64, buf []byte) (n int, err error) {
 		buf = b.Bytes()
 	}
 
+	var pdataKey string
+	if db.Keyring != nil {
+		if pdataKey, buf, err = db.Keyring.Seal(pdataAD(uid), buf); err != nil {
+			return 0, fmt.Errorf("encrypt pdata: %w", err)
+		}
+	}
+
 	if _, err := db.x.NamedExec(`
 		INSERT OR REPLACE INTO
-		pdata  ( uid,  pdata_comp,  pdata_hash,  pdata)
-		VALUES (:uid, :pdata_comp, :pdata_hash, :pdata)
+		pdata  ( uid,  pdata_comp,  pdata_hash,  pdata_key,  pdata)
+		VALUES (:uid, :pdata_comp, :pdata_hash, :pdata_key, :pdata)
 	`, map[string]any{
 		"uid":        uid,
 		"pdata_comp": pdataComp,
 		"pdata_hash": pdataHash,
+		"pdata_key":  pdataKey,
 		"pdata":      buf,
 	}); err != nil {
 		return 0, err
 	}
 	return len(buf), nil
 }
+
+func (db *DB) DeletePdata(uid uint64) error {
+	if _, err := db.x.Exec(`DELETE FROM pdata WHERE uid = ?`, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RotateKeys re-encrypts pdata which is unencrypted or was encrypted with a key
+// other than the primary one in the keyring, returning the number of records
+// updated. It processes records in batches, and is safe to run concurrently
+// with other operations.
+func (db *DB) RotateKeys(ctx context.Context) (n int, err error) {
+	if db.Keyring == nil {
+		return 0, fmt.Errorf("no keyring set")
+	}
+	primary := db.Keyring.Primary()
+
+	var last uint64
+	for {
+		var objs []struct {
+			UID      uint64 `db:"uid"`
+			PdataKey string `db:"pdata_key"`
+			Pdata    []byte `db:"pdata"`
+		}
+		if err := db.x.SelectContext(ctx, &objs, `SELECT uid, pdata_key, pdata FROM pdata WHERE uid > ? AND pdata_key != ? ORDER BY uid LIMIT 500`, last, primary); err != nil {
+			return n, err
+		}
+		if len(objs) == 0 {
+			return n, nil
+		}
+		for _, obj := range objs {
+			last = obj.UID
+
+			var id string
+			var buf []byte
+			if obj.PdataKey == "" {
+				id, buf, err = db.Keyring.Seal(pdataAD(obj.UID), obj.Pdata)
+			} else {
+				id, buf, err = db.Keyring.Rewrap(obj.PdataKey, pdataAD(obj.UID), obj.Pdata)
+			}
+			if err != nil {
+				return n, fmt.Errorf("uid %d: %w", obj.UID, err)
+			}
+
+			// if the pdata was changed in the meantime, it will have already
+			// been encrypted with the primary key
+			if res, err := db.x.ExecContext(ctx, `UPDATE pdata SET pdata_key = ?, pdata = ? WHERE uid = ? AND pdata_key = ? AND pdata = ?`, id, buf, obj.UID, obj.PdataKey, obj.Pdata); err != nil {
+				return n, fmt.Errorf("uid %d: %w", obj.UID, err)
+			} else if c, _ := res.RowsAffected(); c != 0 {
+				n++
+			}
+		}
+	}
+}
+
+// pdataAD returns the additional data used to bind encrypted pdata to uid.
+// Compact rebuilds the database to reclaim space from deleted rows, returning
+// the number of bytes reclaimed. It blocks writes while running.
+func (db *DB) Compact(ctx context.Context) (int64, error) {
+	before, err := db.size(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.x.ExecContext(ctx, `VACUUM`); err != nil {
+		return 0, err
+	}
+	after, err := db.size(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+func (db *DB) size(ctx context.Context) (int64, error) {
+	var pages, pageSize int64
+	if err := db.x.GetContext(ctx, &pages, `PRAGMA page_count`); err != nil {
+		return 0, err
+	}
+	if err := db.x.GetContext(ctx, &pageSize, `PRAGMA page_size`); err != nil {
+		return 0, err
+	}
+	return pages * pageSize, nil
+}
+
+func pdataAD(uid uint64) []byte {
+	return strconv.AppendUint([]byte("pdata:"), uid, 10)
+}
@@ -1,12 +1,15 @@
 package pdatadb
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"path/filepath"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/r2northstar/atlas/pkg/api/api0/api0testutil"
+	"github.com/r2northstar/atlas/pkg/keyring"
 )
 
 func TestPdataStorage(t *testing.T) {
@@ -29,3 +32,123 @@ func TestPdataStorage(t *testing.T) {
 
 	api0testutil.TestPdataStorage(t, db)
 }
+
+func TestPdataListStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "pdata.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestPdataListStorage(t, db)
+}
+
+func TestPdataCompactStorage(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "pdata.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestPdataCompactStorage(t, db)
+}
+
+func TestPdataStorageEncrypted(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "pdata.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	db.Keyring, err = keyring.Parse("a:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	if err != nil {
+		panic(err)
+	}
+
+	api0testutil.TestPdataStorage(t, db)
+}
+
+func TestPdataStorageRotateKeys(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "pdata.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	_, tgt, err := db.Version()
+	if err != nil {
+		panic(err)
+	}
+	if err := db.MigrateUp(context.Background(), tgt); err != nil {
+		panic(err)
+	}
+
+	buf := []byte("test pdata")
+	if _, err := db.SetPdata(1, buf); err != nil {
+		t.Fatalf("set pdata: %v", err)
+	}
+
+	for i, ks := range []string{
+		"a:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		"b:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=,a:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+	} {
+		if db.Keyring, err = keyring.Parse(ks); err != nil {
+			panic(err)
+		}
+		if n, err := db.RotateKeys(context.Background()); err != nil {
+			t.Fatalf("rotate %d: %v", i, err)
+		} else if n != 1 {
+			t.Errorf("rotate %d: expected 1 record to be updated, got %d", i, n)
+		}
+		if n, err := db.RotateKeys(context.Background()); err != nil || n != 0 {
+			t.Errorf("rotate %d: expected no records to be updated again, got %d, %v", i, n, err)
+		}
+
+		var raw struct {
+			PdataKey string `db:"pdata_key"`
+			Pdata    []byte `db:"pdata"`
+		}
+		if err := db.x.Get(&raw, `SELECT pdata_key, pdata FROM pdata WHERE uid = 1`); err != nil {
+			t.Fatalf("rotate %d: get raw pdata: %v", i, err)
+		}
+		if raw.PdataKey != db.Keyring.Primary() {
+			t.Errorf("rotate %d: expected key %q, got %q", i, db.Keyring.Primary(), raw.PdataKey)
+		}
+		if bytes.Contains(raw.Pdata, buf) {
+			t.Errorf("rotate %d: raw pdata is not encrypted", i)
+		}
+
+		if v, exists, err := db.GetPdataCached(1, [sha256.Size]byte{}); err != nil || !exists || !bytes.Equal(v, buf) {
+			t.Errorf("rotate %d: get pdata: got %q, %t, %v", i, v, exists, err)
+		}
+	}
+
+	db.Keyring = nil
+	if _, _, err := db.GetPdataCached(1, [sha256.Size]byte{}); err == nil {
+		t.Errorf("expected error reading encrypted pdata without keyring")
+	}
+}
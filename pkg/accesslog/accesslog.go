@@ -0,0 +1,172 @@
+// Package accesslog writes HTTP access logs as JSON lines, with sampling and
+// redaction of personal information and credentials.
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+)
+
+// Sample logs a percentage of successful requests under a path prefix.
+type Sample struct {
+	Path    string
+	Percent int
+}
+
+// ParseSample parses a sample in the format /path/prefix=percent.
+func ParseSample(s string) (Sample, error) {
+	p, v, ok := strings.Cut(s, "=")
+	if !ok || !strings.HasPrefix(p, "/") {
+		return Sample{}, fmt.Errorf("parse sample %q: expected /path/prefix=percent", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(v, "%"))
+	if err != nil || n < 0 || n > 100 {
+		return Sample{}, fmt.Errorf("parse sample %q: percent must be between 0 and 100", s)
+	}
+	return Sample{strings.TrimSuffix(p, "/"), n}, nil
+}
+
+// Logger writes access log entries. It is safe for concurrent use, but the
+// fields must not be modified after the first request is logged.
+type Logger struct {
+	// Output is where JSON lines are written to. Each entry is written in a
+	// single call.
+	Output io.Writer
+
+	// IPv4Prefix and IPv6Prefix truncate client IPs to the specified number of
+	// bits. If zero, addresses are not truncated.
+	IPv4Prefix int
+	IPv6Prefix int
+
+	// RedactQuery contains query parameters (case-insensitive) to replace the
+	// values of in the logged URI.
+	RedactQuery []string
+
+	// RedactUserAgent removes the user agent.
+	RedactUserAgent bool
+
+	// Sample contains sampling rates for requests under specific path
+	// prefixes. The first matching one is used. Requests which fail with a
+	// 4xx or 5xx status are always logged.
+	Sample []Sample
+
+	// Path, if provided, gets the path to match Sample against.
+	Path func(*http.Request) string
+
+	init    sync.Once
+	logger  zerolog.Logger
+	metrics struct {
+		written atomic.Uint64
+		sampled atomic.Uint64
+	}
+}
+
+// Log logs a request.
+func (l *Logger) Log(r *http.Request, status, size int, duration time.Duration) {
+	l.init.Do(func() {
+		l.logger = zerolog.New(l.Output).With().Timestamp().Logger()
+	})
+
+	if status < 400 && !l.sampled(r) {
+		l.metrics.sampled.Add(1)
+		return
+	}
+
+	e := l.logger.Log()
+	if rid, ok := hlog.IDFromRequest(r); ok {
+		e = e.Stringer("rid", rid)
+	}
+	e = e.
+		Str("request_ip", l.ip(r.RemoteAddr)).
+		Str("request_host", r.Host).
+		Str("request_method", r.Method).
+		Str("request_uri", l.uri(r.URL))
+	if !l.RedactUserAgent {
+		e = e.Str("request_user_agent", r.UserAgent())
+	}
+	e.
+		Int("response_status", status).
+		Int("response_size", size).
+		Dur("response_duration", duration).
+		Send()
+	l.metrics.written.Add(1)
+}
+
+func (l *Logger) sampled(r *http.Request) bool {
+	path := r.URL.Path
+	if l.Path != nil {
+		path = l.Path(r)
+	}
+	for _, s := range l.Sample {
+		if s.Path == "" || path == s.Path || (strings.HasPrefix(path, s.Path) && path[len(s.Path)] == '/') {
+			return s.Percent >= 100 || (s.Percent > 0 && rand.Intn(100) < s.Percent)
+		}
+	}
+	return true
+}
+
+// ip truncates the IP address in addr (which may have a port, which is
+// removed) according to the configured prefix lengths.
+func (l *Logger) ip(addr string) string {
+	var ip netip.Addr
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		ip = ap.Addr()
+	} else if a, err := netip.ParseAddr(addr); err == nil {
+		ip = a
+	} else {
+		return addr
+	}
+	ip = ip.Unmap()
+
+	bits := l.IPv6Prefix
+	if ip.Is4() {
+		bits = l.IPv4Prefix
+	}
+	if bits <= 0 || bits >= ip.BitLen() {
+		return ip.String()
+	}
+	if p, err := ip.Prefix(bits); err == nil {
+		return p.Addr().String()
+	}
+	return ip.String()
+}
+
+// uri formats u with the values of sensitive query parameters replaced.
+func (l *Logger) uri(u *url.URL) string {
+	if u.RawQuery == "" || len(l.RedactQuery) == 0 {
+		return u.RequestURI()
+	}
+	ps := strings.Split(u.RawQuery, "&")
+	for i, p := range ps {
+		rk, _, _ := strings.Cut(p, "=")
+		if k, err := url.QueryUnescape(rk); err == nil {
+			for _, x := range l.RedactQuery {
+				if strings.EqualFold(k, x) {
+					ps[i] = rk + "=REDACTED"
+					break
+				}
+			}
+		}
+	}
+	v := *u
+	v.RawQuery = strings.Join(ps, "&")
+	return v.RequestURI()
+}
+
+// WritePrometheus writes prometheus text metrics to w.
+func (l *Logger) WritePrometheus(w io.Writer) {
+	fmt.Fprintln(w, `atlas_accesslog_requests_total{result="written"}`, l.metrics.written.Load())
+	fmt.Fprintln(w, `atlas_accesslog_requests_total{result="sampled_out"}`, l.metrics.sampled.Load())
+}
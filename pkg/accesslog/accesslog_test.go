@@ -0,0 +1,132 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	var b bytes.Buffer
+	l := &Logger{
+		Output:          &b,
+		IPv4Prefix:      24,
+		IPv6Prefix:      48,
+		RedactQuery:     []string{"playerToken", "token"},
+		RedactUserAgent: true,
+		Sample: []Sample{
+			{"/client/servers", 0},
+			{"/client", 100},
+		},
+	}
+
+	for _, tc := range []struct {
+		Addr   string
+		URI    string
+		Status int
+		IP     string
+		LogURI string
+	}{
+		{"192.0.2.123:1234", "/client/auth_with_server?id=1&playerToken=secret&TOKEN=x&server=abc", 200, "192.0.2.0", "/client/auth_with_server?id=1&playerToken=REDACTED&TOKEN=REDACTED&server=abc"},
+		{"[2001:db8:1234:5678::1]:1234", "/client/servers", 500, "2001:db8:1234::", "/client/servers"},
+		{"[::ffff:192.0.2.1]:1234", "/client/servers", 200, "", ""}, // sampled out
+		{"invalid", "/server/heartbeat", 204, "invalid", "/server/heartbeat"},
+	} {
+		b.Reset()
+
+		r := httptest.NewRequest(http.MethodGet, tc.URI, nil)
+		r.RemoteAddr = tc.Addr
+		r.Header.Set("User-Agent", "R2Northstar/1.0.0")
+		l.Log(r, tc.Status, 10, time.Millisecond*5)
+
+		if tc.LogURI == "" {
+			if b.Len() != 0 {
+				t.Errorf("%s: expected request to be sampled out, got %s", tc.URI, b.String())
+			}
+			continue
+		}
+
+		var e map[string]any
+		if err := json.Unmarshal(b.Bytes(), &e); err != nil {
+			t.Errorf("%s: invalid entry %q: %v", tc.URI, b.String(), err)
+			continue
+		}
+		if v := e["request_ip"]; v != tc.IP {
+			t.Errorf("%s: expected ip %q, got %v", tc.URI, tc.IP, v)
+		}
+		if v := e["request_uri"]; v != tc.LogURI {
+			t.Errorf("%s: expected uri %q, got %v", tc.URI, tc.LogURI, v)
+		}
+		if _, ok := e["request_user_agent"]; ok {
+			t.Errorf("%s: expected user agent to be redacted", tc.URI)
+		}
+		if v := e["response_status"]; v != float64(tc.Status) {
+			t.Errorf("%s: expected status %d, got %v", tc.URI, tc.Status, v)
+		}
+	}
+
+	b.Reset()
+	l.WritePrometheus(&b)
+	if !strings.Contains(b.String(), `atlas_accesslog_requests_total{result="sampled_out"} 1`) {
+		t.Errorf("incorrect metrics:\n%s", b.String())
+	}
+}
+
+func TestParseSample(t *testing.T) {
+	if s, err := ParseSample("/client/servers/=10%"); err != nil || s.Path != "/client/servers" || s.Percent != 10 {
+		t.Errorf("incorrect sample %+v (err: %v)", s, err)
+	}
+	for _, x := range []string{"", "/client", "client=10", "/client=101", "/client=x"} {
+		if _, err := ParseSample(x); err == nil {
+			t.Errorf("parse %q: expected error", x)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "access.log")
+	f := &File{
+		Path:       fn,
+		MaxSize:    10,
+		MaxBackups: 2,
+	}
+	defer f.Close()
+
+	for _, x := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		if _, err := f.Write([]byte(x)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	for fn, exp := range map[string]string{
+		fn:        "dddddd\n",
+		fn + ".1": "cccccc\n",
+		fn + ".2": "bbbbbb\n",
+	} {
+		if buf, err := os.ReadFile(fn); err != nil || string(buf) != exp {
+			t.Errorf("%s: expected %q, got %q (err: %v)", filepath.Base(fn), exp, buf, err)
+		}
+	}
+	if _, err := os.Stat(fn + ".3"); err == nil {
+		t.Errorf("expected old backups to be removed")
+	}
+
+	// external rotation
+	if err := os.Rename(fn, fn+".old"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, err := f.Write([]byte("e\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if buf, err := os.ReadFile(fn); err != nil || string(buf) != "e\n" {
+		t.Errorf("expected new file after reopen, got %q (err: %v)", buf, err)
+	}
+}
@@ -0,0 +1,115 @@
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// File is an append-only log file which is optionally rotated by size. It is
+// safe for concurrent use.
+type File struct {
+	// Path is the path to the file.
+	Path string
+
+	// Mode is the permissions to create the file with. If zero, it defaults
+	// to 0644.
+	Mode fs.FileMode
+
+	// MaxSize is the size in bytes after which the file is rotated to
+	// Path.1, Path.1 to Path.2, and so on. If zero, it is never rotated.
+	MaxSize int64
+
+	// MaxBackups is the number of rotated files to keep.
+	MaxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Write appends p to the file, opening or rotating it first if required.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f != nil && f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	if f.f == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen closes the file so it is opened again on the next write (e.g., after
+// it has been moved by an external log rotation tool).
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.close()
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.Reopen()
+}
+
+func (f *File) open() error {
+	mode := f.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	x, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, mode)
+	if err != nil {
+		return fmt.Errorf("open access log: %w", err)
+	}
+	st, err := x.Stat()
+	if err != nil {
+		x.Close()
+		return fmt.Errorf("open access log: %w", err)
+	}
+	f.f, f.size = x, st.Size()
+	return nil
+}
+
+func (f *File) close() error {
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f, f.size = nil, 0
+	return err
+}
+
+func (f *File) rotate() error {
+	if err := f.close(); err != nil {
+		return fmt.Errorf("rotate access log: %w", err)
+	}
+	if f.MaxBackups <= 0 {
+		if err := os.Remove(f.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("rotate access log: %w", err)
+		}
+		return nil
+	}
+	for i := f.MaxBackups - 1; i >= 0; i-- {
+		src := f.Path
+		if i != 0 {
+			src += "." + strconv.Itoa(i)
+		}
+		if err := os.Rename(src, f.Path+"."+strconv.Itoa(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("rotate access log: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+// Package analytics exports events to external analytics sinks.
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPExporter exports events in batches as newline-delimited JSON POSTed to
+// an HTTP endpoint. Events are dropped if the queue is full or the request
+// fails. It is safe for concurrent use.
+type HTTPExporter struct {
+	// URL is the endpoint to POST batches to. It is required.
+	URL string
+
+	// Header contains additional headers to send (e.g., Authorization).
+	Header http.Header
+
+	// Client is the HTTP client to use. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// BatchSize is the maximum number of events per request. If zero, it
+	// defaults to 500.
+	BatchSize int
+
+	// FlushInterval is the maximum amount of time to wait before sending a
+	// non-empty batch. If zero, it defaults to 10 seconds.
+	FlushInterval time.Duration
+
+	// QueueSize is the maximum number of events waiting to be sent. If zero,
+	// it defaults to 10000.
+	QueueSize int
+
+	// ErrorHook is called when a batch fails to be sent.
+	ErrorHook func(n int, err error)
+
+	init  sync.Once
+	queue chan []byte
+
+	metrics struct {
+		events_total struct {
+			queued     atomic.Uint64
+			sent       atomic.Uint64
+			dropped    atomic.Uint64
+			failed     atomic.Uint64
+			encode_err atomic.Uint64
+		}
+		batches_total struct {
+			success atomic.Uint64
+			failed  atomic.Uint64
+		}
+	}
+}
+
+func (e *HTTPExporter) initQueue() {
+	e.init.Do(func() {
+		n := e.QueueSize
+		if n <= 0 {
+			n = 10000
+		}
+		e.queue = make(chan []byte, n)
+	})
+}
+
+// Publish queues v to be encoded as JSON and exported. It never blocks.
+func (e *HTTPExporter) Publish(v any) {
+	e.initQueue()
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		e.metrics.events_total.encode_err.Add(1)
+		return
+	}
+	select {
+	case e.queue <- buf:
+		e.metrics.events_total.queued.Add(1)
+	default:
+		e.metrics.events_total.dropped.Add(1)
+	}
+}
+
+// Run sends queued events until ctx is canceled, then attempts to send any
+// remaining queued events.
+func (e *HTTPExporter) Run(ctx context.Context) {
+	e.initQueue()
+
+	size := e.BatchSize
+	if size <= 0 {
+		size = 500
+	}
+	interval := e.FlushInterval
+	if interval <= 0 {
+		interval = time.Second * 10
+	}
+
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+
+	var b bytes.Buffer
+	var n int
+	flush := func() {
+		if n != 0 {
+			e.send(&b, n)
+			b.Reset()
+			n = 0
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case buf := <-e.queue:
+					b.Write(buf)
+					b.WriteByte('\n')
+					if n++; n >= size {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		case <-tk.C:
+			flush()
+		case buf := <-e.queue:
+			b.Write(buf)
+			b.WriteByte('\n')
+			if n++; n >= size {
+				flush()
+			}
+		}
+	}
+}
+
+func (e *HTTPExporter) send(b *bytes.Buffer, n int) {
+	err := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(b.Bytes()))
+		if err != nil {
+			return err
+		}
+		for k, v := range e.Header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+
+		cl := e.Client
+		if cl == nil {
+			cl = http.DefaultClient
+		}
+
+		resp, err := cl.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("response status %d (%s)", resp.StatusCode, resp.Status)
+		}
+		return nil
+	}()
+	if err != nil {
+		e.metrics.batches_total.failed.Add(1)
+		e.metrics.events_total.failed.Add(uint64(n))
+		if e.ErrorHook != nil {
+			e.ErrorHook(n, err)
+		}
+		return
+	}
+	e.metrics.batches_total.success.Add(1)
+	e.metrics.events_total.sent.Add(uint64(n))
+}
+
+// WritePrometheus writes prometheus text metrics to w.
+func (e *HTTPExporter) WritePrometheus(w io.Writer) {
+	fmt.Fprintln(w, `atlas_analytics_events_total{result="queued"}`, e.metrics.events_total.queued.Load())
+	fmt.Fprintln(w, `atlas_analytics_events_total{result="sent"}`, e.metrics.events_total.sent.Load())
+	fmt.Fprintln(w, `atlas_analytics_events_total{result="dropped"}`, e.metrics.events_total.dropped.Load())
+	fmt.Fprintln(w, `atlas_analytics_events_total{result="failed"}`, e.metrics.events_total.failed.Load())
+	fmt.Fprintln(w, `atlas_analytics_events_total{result="encode_err"}`, e.metrics.events_total.encode_err.Load())
+	fmt.Fprintln(w, `atlas_analytics_batches_total{result="success"}`, e.metrics.batches_total.success.Load())
+	fmt.Fprintln(w, `atlas_analytics_batches_total{result="failed"}`, e.metrics.batches_total.failed.Load())
+}
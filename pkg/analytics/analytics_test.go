@@ -0,0 +1,77 @@
+package analytics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPExporter(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("Authorization"); v != "Bearer test" {
+			t.Errorf("incorrect authorization header %q", v)
+		}
+		if v := r.Header.Get("Content-Type"); v != "application/x-ndjson" {
+			t.Errorf("incorrect content type %q", v)
+		}
+		var b []int
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var v struct {
+				N int `json:"n"`
+			}
+			if err := json.Unmarshal(sc.Bytes(), &v); err != nil {
+				t.Errorf("invalid event %q: %v", sc.Text(), err)
+			}
+			b = append(b, v.N)
+		}
+		mu.Lock()
+		batches = append(batches, b)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	e := &HTTPExporter{
+		URL:           srv.URL,
+		Header:        http.Header{"Authorization": {"Bearer test"}},
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+		QueueSize:     10,
+		ErrorHook: func(n int, err error) {
+			t.Errorf("unexpected error sending %d events: %v", n, err)
+		},
+	}
+	for i := 0; i < 12; i++ {
+		e.Publish(map[string]int{"n": i})
+	}
+	if n := e.metrics.events_total.dropped.Load(); n != 2 {
+		t.Errorf("expected 2 events to be dropped, got %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e.Run(ctx)
+
+	var n int
+	for _, b := range batches {
+		if len(b) > 3 {
+			t.Errorf("batch too large: %v", b)
+		}
+		for _, x := range b {
+			if x != n {
+				t.Errorf("events out of order or missing: %v", batches)
+			}
+			n++
+		}
+	}
+	if n != 10 {
+		t.Errorf("expected 10 events to be sent, got %d", n)
+	}
+}
@@ -0,0 +1,233 @@
+package api0
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/origin"
+	"github.com/rs/zerolog/hlog"
+)
+
+// accountLookupOriginBatch is the maximum number of users to request from
+// Origin at once.
+const accountLookupOriginBatch = 50
+
+// accountLookupRequest is the request body for a bulk account lookup.
+type accountLookupRequest struct {
+	UIDs []uint64 `json:"uids" validate:"required,min=1,max=500"`
+
+	// Backfill looks up usernames from Origin for accounts without one,
+	// saving them to existing accounts.
+	Backfill bool `json:"backfill"`
+}
+
+type accountLookupResult struct {
+	UID           string     `json:"uid"`
+	Found         bool       `json:"found"`
+	Username      string     `json:"username,omitempty"`
+	VerifiedAt    *time.Time `json:"verified_at,omitempty"`
+	LastServerID  string     `json:"last_server_id,omitempty"`
+	AuthIP        string     `json:"auth_ip,omitempty"`
+	AuthExpiry    *time.Time `json:"auth_expiry,omitempty"`
+	StaleVerified bool       `json:"stale_verified,omitempty"`
+	Guest         bool       `json:"guest,omitempty"`
+	Sessions      int        `json:"sessions"`
+	Backfilled    bool       `json:"backfilled,omitempty"`
+	Erased        bool       `json:"erased,omitempty"`
+}
+
+// originUserInfo gets Origin user info for uids in batches, refreshing the
+// nucleus token if required.
+func (h *Handler) originUserInfo(ctx context.Context, uids []uint64) (map[uint64]origin.UserInfo, error) {
+	if h.OriginAuthMgr == nil {
+		return nil, errors.New("no origin auth available")
+	}
+	res := make(map[uint64]origin.UserInfo, len(uids))
+	for len(uids) != 0 {
+		batch := uids
+		if len(batch) > accountLookupOriginBatch {
+			batch = batch[:accountLookupOriginBatch]
+		}
+		uids = uids[len(batch):]
+
+		tok, _, err := h.OriginAuthMgr.OriginAuth(false)
+		if err != nil {
+			return res, err
+		}
+		ui, err := origin.GetUserInfo(ctx, tok, batch...)
+		if errors.Is(err, origin.ErrAuthRequired) {
+			if tok, _, err = h.OriginAuthMgr.OriginAuth(true); err != nil {
+				return res, err
+			}
+			ui, err = origin.GetUserInfo(ctx, tok, batch...)
+		}
+		if err != nil {
+			return res, err
+		}
+		for _, x := range ui {
+			res[x.UserID] = x
+		}
+	}
+	return res, nil
+}
+
+// handleAdminAccounts looks up stored account info for many UIDs at once,
+// optionally backfilling missing usernames from Origin.
+func (h *Handler) handleAdminAccounts(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "accounts"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	var req accountLookupRequest
+	if err := decodeJSON(r, &req); err != nil {
+		h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+		respError(w, r, err)
+		return
+	}
+
+	res := make([]accountLookupResult, 0, len(req.UIDs))
+	accts := make(map[uint64]*Account, len(req.UIDs))
+	missing := map[uint64]int{} // index in res
+	for _, uid := range req.UIDs {
+		if _, ok := accts[uid]; ok {
+			continue // duplicate
+		}
+		acct, err := h.AccountStorage.GetAccount(uid)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", uid).
+				Msgf("failed to read account from storage")
+			h.m().admin_requests_total.fail_storage_error_account(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		accts[uid] = acct
+		if acct != nil && acct.Username == "" {
+			missing[uid] = len(res)
+		}
+
+		x := accountLookupResult{
+			UID:    strconv.FormatUint(uid, 10),
+			Found:  acct != nil,
+			Erased: h.isErased(uid),
+			Guest:  IsGuestUID(uid),
+		}
+		if acct != nil {
+			x.Username = acct.Username
+			x.LastServerID = acct.LastServerID
+			x.StaleVerified = acct.AuthStaleVerified
+			x.Sessions = len(acct.OtherSessions)
+			if !acct.VerifiedAt.IsZero() {
+				t := acct.VerifiedAt.UTC()
+				x.VerifiedAt = &t
+			}
+			if acct.AuthToken != "" {
+				x.Sessions++
+				if acct.AuthIP.IsValid() {
+					x.AuthIP = acct.AuthIP.String()
+				}
+				if !acct.AuthTokenExpiry.IsZero() {
+					t := acct.AuthTokenExpiry.UTC()
+					x.AuthExpiry = &t
+				}
+			}
+		}
+		res = append(res, x)
+	}
+
+	var backfillErr string
+	if req.Backfill && len(missing) != 0 {
+		uids := make([]uint64, 0, len(missing))
+		for uid := range missing {
+			uids = append(uids, uid)
+		}
+		ui, err := h.originUserInfo(r.Context(), uids)
+		if err != nil {
+			hlog.FromRequest(r).Warn().
+				Err(err).
+				Int("count", len(missing)).
+				Msgf("failed to backfill usernames from origin")
+			h.m().admin_accounts_backfill_total.fail_origin_error.Add(len(missing) - len(ui))
+			backfillErr = err.Error()
+		}
+		for uid, i := range missing {
+			u, ok := ui[uid]
+			if !ok || u.EAID == "" {
+				if err == nil {
+					h.m().admin_accounts_backfill_total.notfound.Inc()
+				}
+				continue
+			}
+
+			// re-read the account since it may have been updated while
+			// waiting for origin (there's still a small window for races,
+			// but it's much smaller than the origin request)
+			acct, err := h.AccountStorage.GetAccount(uid)
+			if err != nil {
+				hlog.FromRequest(r).Error().
+					Err(err).
+					Uint64("uid", uid).
+					Msgf("failed to read account from storage")
+				h.m().admin_requests_total.fail_storage_error_account(endpoint).Inc()
+				respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+				return
+			}
+			if acct == nil || acct.Username != "" {
+				if acct != nil {
+					res[i].Username = acct.Username
+				}
+				continue // deleted or already updated by origin auth
+			}
+			acct.Username = u.EAID
+			if err := h.AccountStorage.SaveAccount(acct); err != nil {
+				hlog.FromRequest(r).Error().
+					Err(err).
+					Uint64("uid", uid).
+					Msgf("failed to save account to storage")
+				h.m().admin_requests_total.fail_storage_error_account(endpoint).Inc()
+				respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+				return
+			}
+			h.m().admin_accounts_backfill_total.success.Inc()
+			res[i].Username = u.EAID
+			res[i].Backfilled = true
+		}
+	}
+
+	hlog.FromRequest(r).Info().
+		Int("count", len(res)).
+		Bool("backfill", req.Backfill).
+		Msgf("looked up accounts")
+
+	obj := map[string]any{
+		"success":  true,
+		"accounts": res,
+	}
+	if backfillErr != "" {
+		obj["backfill_error"] = backfillErr
+	}
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, obj)
+}
@@ -1,17 +1,21 @@
 package api0
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
+	"mime"
 	"net/http"
 	"net/netip"
 	"strconv"
+	"time"
 
 	"github.com/r2northstar/atlas/pkg/pdata"
 	"github.com/rs/zerolog/hlog"
 )
 
 func (h *Handler) handleAccountsWritePersistence(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost && r.Method != http.MethodPatch {
 		h.m().accounts_writepersistence_requests_total.http_method_not_allowed.Inc()
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
@@ -24,61 +28,88 @@ func (h *Handler) handleAccountsWritePersistence(w http.ResponseWriter, r *http.
 	w.Header().Set("Pragma", "no-cache")
 
 	if r.Method == http.MethodOptions {
-		w.Header().Set("Allow", "OPTIONS, POST")
+		w.Header().Set("Allow", "OPTIONS, POST, PATCH")
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	if err := r.ParseMultipartForm(2 << 20); err != nil {
-		h.m().accounts_writepersistence_requests_total.reject_bad_request.Inc()
-		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("failed to parse multipart form: %v", err))
+	if v := r.URL.Query().Get("serverId"); v != "" && !h.checkServerSignature(w, r, h.ServerList.GetServerByID(v), "write_persistence") {
 		return
 	}
 
-	pf, pfHdr, err := r.FormFile("pdata")
-	if err != nil {
-		h.m().accounts_writepersistence_requests_total.reject_bad_request.Inc()
-		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("missing pdata file: %v", err))
-		return
-	}
-	defer pf.Close()
+	var buf, patch []byte
+	if r.Method == http.MethodPatch {
+		// partial update containing only the changed fields as JSON (see
+		// pdata.Pdata.Patch), which is applied to the stored pdata
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+			h.m().accounts_writepersistence_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusUnsupportedMediaType, ErrorCode_BAD_REQUEST.MessageObjf("pdata patch must be application/json"))
+			return
+		}
+		b, err := io.ReadAll(io.LimitReader(r.Body, (1<<20)+1))
+		if err != nil {
+			h.m().accounts_writepersistence_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("failed to read pdata patch"))
+			return
+		}
+		if len(b) > 1<<20 {
+			h.m().accounts_writepersistence_requests_total.reject_too_large.Inc()
+			respFail(w, r, http.StatusRequestEntityTooLarge, ErrorCode_BAD_REQUEST.MessageObjf("pdata patch is too large"))
+			return
+		}
+		patch = b
+	} else {
+		if err := r.ParseMultipartForm(2 << 20); err != nil {
+			h.m().accounts_writepersistence_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("failed to parse multipart form: %v", err))
+			return
+		}
 
-	if pfHdr.Size > (2 << 20) {
-		h.m().accounts_writepersistence_requests_total.reject_too_large.Inc()
-		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("pdata file is too large"))
-		return
-	}
+		pf, pfHdr, err := r.FormFile("pdata")
+		if err != nil {
+			h.m().accounts_writepersistence_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("missing pdata file: %v", err))
+			return
+		}
+		defer pf.Close()
 
-	buf, err := io.ReadAll(pf)
-	if err != nil {
-		hlog.FromRequest(r).Error().
-			Err(err).
-			Msgf("failed to read uploaded data file (size: %d)", pfHdr.Size)
-		h.m().accounts_writepersistence_requests_total.fail_other_error.Inc()
-		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
-		return
-	}
+		if pfHdr.Size > (2 << 20) {
+			h.m().accounts_writepersistence_requests_total.reject_too_large.Inc()
+			respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("pdata file is too large"))
+			return
+		}
 
-	var pd pdata.Pdata
-	if err := pd.UnmarshalBinary(buf); err != nil {
-		hlog.FromRequest(r).Warn().
-			Err(err).
-			Msgf("invalid pdata rejected")
-		h.m().accounts_writepersistence_requests_total.reject_invalid_pdata.Inc()
-		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid pdata"))
-		return
-	}
+		buf, err = io.ReadAll(pf)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to read uploaded data file (size: %d)", pfHdr.Size)
+			h.m().accounts_writepersistence_requests_total.fail_other_error.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
 
-	if len(pd.ExtraData) > 512 { // arbitrary limit
-		hlog.FromRequest(r).Warn().
-			Err(err).
-			Msgf("pdata with too much trailing junk rejected")
-		h.m().accounts_writepersistence_requests_total.reject_too_much_extradata.Inc()
-		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid pdata"))
-		return
-	}
+		var pd pdata.Pdata
+		if err := pd.UnmarshalBinary(buf); err != nil {
+			hlog.FromRequest(r).Warn().
+				Err(err).
+				Msgf("invalid pdata rejected")
+			h.m().accounts_writepersistence_requests_total.reject_invalid_pdata.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid pdata"))
+			return
+		}
+
+		if len(pd.ExtraData) > 512 { // arbitrary limit
+			hlog.FromRequest(r).Warn().
+				Err(err).
+				Msgf("pdata with too much trailing junk rejected")
+			h.m().accounts_writepersistence_requests_total.reject_too_much_extradata.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid pdata"))
+			return
+		}
 
-	h.m().accounts_writepersistence_extradata_size_bytes.Update(float64(len(pd.ExtraData)))
+		h.m().accounts_writepersistence_extradata_size_bytes.Update(float64(len(pd.ExtraData)))
+	}
 
 	uidQ := r.URL.Query().Get("id")
 	if uidQ == "" {
@@ -94,6 +125,14 @@ func (h *Handler) handleAccountsWritePersistence(w http.ResponseWriter, r *http.
 		return
 	}
 
+	if patch != nil && !h.featureEnabled(r, FeaturePdataPatch, uid) {
+		// servers should fall back to writing the full pdata
+		h.m().accounts_writepersistence_requests_total.reject_disabled.Inc()
+		w.Header().Set("Allow", "OPTIONS, POST")
+		respFail(w, r, http.StatusMethodNotAllowed, ErrorCode_BAD_REQUEST.MessageObjf("pdata patches are not enabled"))
+		return
+	}
+
 	serverID := r.URL.Query().Get("serverId") // blank on listen server
 
 	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
@@ -106,7 +145,7 @@ func (h *Handler) handleAccountsWritePersistence(w http.ResponseWriter, r *http.
 		return
 	}
 
-	acct, err := h.AccountStorage.GetAccount(uid)
+	acct, err := h.accountStorage(r).GetAccount(uid)
 	if err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
@@ -122,14 +161,19 @@ func (h *Handler) handleAccountsWritePersistence(w http.ResponseWriter, r *http.
 		return
 	}
 
+	var (
+		quotaKey string
+		srv      *Server
+	)
 	if acct.IsOnOwnServer() {
-		if acct.AuthIP != raddr.Addr() {
+		quotaKey = "ip:" + raddr.Addr().String()
+		if !acct.HasAuthIP(raddr.Addr()) {
 			h.m().accounts_writepersistence_requests_total.reject_unauthorized.Inc()
 			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
 			return
 		}
 	} else {
-		srv := h.ServerList.GetServerByID(serverID)
+		srv = h.ServerList.GetServerByID(serverID)
 		if srv == nil {
 			h.m().accounts_writepersistence_requests_total.reject_unauthorized.Inc()
 			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such game server"))
@@ -145,9 +189,132 @@ func (h *Handler) handleAccountsWritePersistence(w http.ResponseWriter, r *http.
 			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
 			return
 		}
+		quotaKey = "server:" + srv.ID
 	}
 
-	if n, err := h.PdataStorage.SetPdata(uid, buf); err != nil {
+	if h.isErased(uid) {
+		h.m().accounts_writepersistence_requests_total.reject_erased.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_ACCOUNT_ERASED.MessageObj())
+		return
+	}
+
+	// limit how quickly a buggy or malicious server can churn through pdata
+	window := h.PdataWriteWindow
+	if window <= 0 {
+		window = time.Minute
+	}
+	now := time.Now()
+	if !h.pdataServerLimiter.Allow(quotaKey, now, h.PdataServerWriteLimit, window) {
+		hlog.FromRequest(r).Warn().
+			Str("server", quotaKey).
+			Msgf("server exceeded pdata write quota")
+		h.m().accounts_writepersistence_requests_total.reject_quota_server.Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int(window/time.Second)))
+		respFailExtra(w, r, http.StatusTooManyRequests, ErrorCode_QUOTA_EXCEEDED.MessageObjf("too many pdata writes from this server"), map[string]any{
+			"quota": "server",
+		})
+		return
+	}
+	if !h.pdataPlayerLimiter.Allow(uid, now, h.PdataPlayerWriteLimit, window) {
+		hlog.FromRequest(r).Warn().
+			Uint64("uid", uid).
+			Str("server", quotaKey).
+			Msgf("player exceeded pdata write quota")
+		h.m().accounts_writepersistence_requests_total.reject_quota_player.Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int(window/time.Second)))
+		respFailExtra(w, r, http.StatusTooManyRequests, ErrorCode_QUOTA_EXCEEDED.MessageObjf("too many pdata writes for this player"), map[string]any{
+			"quota": "player",
+		})
+		return
+	}
+	if !h.checkPlayerQuota(w, r, PlayerQuotaPdataWrite, uid) {
+		h.m().accounts_writepersistence_requests_total.reject_quota_player_hourly.Inc()
+		return
+	}
+
+	// serialize writes for the same player so patches aren't lost
+	mu := &h.pdataWriteMu[uid%uint64(len(h.pdataWriteMu))]
+	mu.Lock()
+	defer mu.Unlock()
+
+	rules, err := h.pdataRules.Get(h.StateStorage, "pdatarules")
+	if err != nil {
+		hlog.FromRequest(r).Warn().Err(err).Msg("failed to load pdata rules, allowing write")
+	}
+
+	// the current pdata is only needed for patches, rules, and hooks
+	var cur []byte
+	if patch != nil || len(rules.Rules) != 0 || h.hasPdataWriteHooks() {
+		var exists bool
+		cur, exists, err = h.pdataStorage(r).GetPdataCached(uid, [sha256.Size]byte{})
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", uid).
+				Msgf("failed to read pdata from storage")
+			h.m().accounts_writepersistence_requests_total.fail_storage_error_pdata.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if !exists {
+			cur = pdata.DefaultPdata
+		}
+	}
+
+	if patch != nil {
+
+		// servers can use the ETag from the pdata they got to detect
+		// concurrent changes
+		if im := r.Header.Get("If-Match"); im != "" {
+			hash := sha256.Sum256(cur)
+			if !etagMatch(im, `W/"`+hex.EncodeToString(hash[:])+`"`) {
+				h.m().accounts_writepersistence_requests_total.reject_precondition_failed.Inc()
+				respFail(w, r, http.StatusPreconditionFailed, ErrorCode_BAD_REQUEST.MessageObjf("pdata has been modified"))
+				return
+			}
+		}
+
+		var pd pdata.Pdata
+		if err := pd.UnmarshalBinary(cur); err != nil {
+			hlog.FromRequest(r).Warn().
+				Err(err).
+				Uint64("uid", uid).
+				Msgf("failed to parse pdata from storage")
+			h.m().accounts_writepersistence_requests_total.fail_other_error.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObjf("failed to parse stored pdata"))
+			return
+		}
+		if err := pd.Patch(patch); err != nil {
+			h.m().accounts_writepersistence_requests_total.reject_invalid_patch.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid pdata patch: %v", err))
+			return
+		}
+		if buf, err = pd.MarshalBinary(); err != nil {
+			h.m().accounts_writepersistence_requests_total.reject_invalid_patch.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid pdata patch: %v", err))
+			return
+		}
+	}
+
+	if !h.checkPdataRules(r, rules, uid, srv, cur, buf) {
+		h.m().accounts_writepersistence_requests_total.reject_pdata_rule.Inc()
+		respFail(w, r, http.StatusUnprocessableEntity, ErrorCode_BAD_REQUEST.MessageObjf("pdata rejected by sanity rules"))
+		return
+	}
+
+	buf, ok := h.runPdataWriteHooks(w, r, uid, cur, buf)
+	if !ok {
+		return
+	}
+
+	if IsGuestUID(uid) {
+		// guest pdata is never persisted
+		h.m().accounts_writepersistence_requests_total.success_guest.Inc()
+		respJSON(w, r, http.StatusOK, nil)
+		return
+	}
+
+	if n, err := h.pdataStorage(r).SetPdata(uid, buf); err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
 			Uint64("uid", uid).
@@ -159,7 +326,11 @@ func (h *Handler) handleAccountsWritePersistence(w http.ResponseWriter, r *http.
 		h.m().accounts_writepersistence_stored_size_bytes.Update(float64(n))
 	}
 
-	h.m().accounts_writepersistence_requests_total.success.Inc()
+	if patch != nil {
+		h.m().accounts_writepersistence_requests_total.success_patch.Inc()
+	} else {
+		h.m().accounts_writepersistence_requests_total.success.Inc()
+	}
 	respJSON(w, r, http.StatusOK, nil)
 }
 
@@ -184,11 +355,9 @@ func (h *Handler) handleAccountsLookupUID(w http.ResponseWriter, r *http.Request
 	username := r.URL.Query().Get("username")
 	if username == "" {
 		h.m().accounts_lookupuid_requests_total.reject_bad_request.Inc()
-		respJSON(w, r, http.StatusBadRequest, map[string]any{
-			"success":  false,
+		respFailExtra(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("username param is required"), map[string]any{
 			"username": "",
 			"matches":  []uint64{},
-			"error":    ErrorCode_BAD_REQUEST.MessageObjf("username param is required"),
 		})
 		return
 	}
@@ -198,21 +367,23 @@ func (h *Handler) handleAccountsLookupUID(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	uids, err := h.AccountStorage.GetUIDsByUsername(username)
+	uids, err := h.accountStorage(r).GetUIDsByUsername(username)
 	if err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
 			Msgf("failed to find account uids from storage for %q", username)
 		h.m().accounts_lookupuid_requests_total.fail_storage_error_account.Inc()
-		respJSON(w, r, http.StatusInternalServerError, map[string]any{
-			"success":  false,
+		respFailExtra(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj(), map[string]any{
 			"username": username,
 			"matches":  []uint64{},
-			"error":    ErrorCode_INTERNAL_SERVER_ERROR.MessageObj(),
 		})
 		return
 	}
 
+	if uids == nil {
+		uids = []uint64{} // the original master server always returns an array
+	}
+
 	switch len(uids) {
 	case 0:
 		h.m().accounts_lookupuid_requests_total.success_nomatch.Inc()
@@ -249,11 +420,9 @@ func (h *Handler) handleAccountsGetUsername(w http.ResponseWriter, r *http.Reque
 	uidQ := r.URL.Query().Get("uid")
 	if uidQ == "" {
 		h.m().accounts_getusername_requests_total.reject_bad_request.Inc()
-		respJSON(w, r, http.StatusBadRequest, map[string]any{
-			"success": false,
+		respFailExtra(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("uid param is required"), map[string]any{
 			"uid":     "",
 			"matches": []string{},
-			"error":   ErrorCode_BAD_REQUEST.MessageObjf("uid param is required"),
 		})
 		return
 	}
@@ -261,27 +430,23 @@ func (h *Handler) handleAccountsGetUsername(w http.ResponseWriter, r *http.Reque
 	uid, err := strconv.ParseUint(uidQ, 10, 64)
 	if err != nil {
 		h.m().accounts_getusername_requests_total.reject_bad_request.Inc()
-		respJSON(w, r, http.StatusNotFound, map[string]any{
-			"success": false,
+		respFailExtra(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj(), map[string]any{
 			"uid":     strconv.FormatUint(uid, 10),
 			"matches": []string{},
-			"error":   ErrorCode_PLAYER_NOT_FOUND.MessageObj(),
 		})
 		return
 	}
 
-	acct, err := h.AccountStorage.GetAccount(uid)
+	acct, err := h.accountStorage(r).GetAccount(uid)
 	if err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
 			Uint64("uid", uid).
 			Msgf("failed to read account from storage")
 		h.m().accounts_getusername_requests_total.fail_storage_error_account.Inc()
-		respJSON(w, r, http.StatusInternalServerError, map[string]any{
-			"success": false,
+		respFailExtra(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj(), map[string]any{
 			"uid":     strconv.FormatUint(uid, 10),
 			"matches": []string{},
-			"error":   ErrorCode_INTERNAL_SERVER_ERROR.MessageObj(),
 		})
 		return
 	}
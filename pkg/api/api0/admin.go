@@ -0,0 +1,35 @@
+package api0
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// checkAdmin checks whether r is authorized with the admin secret, writing an
+// error response and returning false if not. If AdminSecret is empty, the admin
+// API is disabled and requests are rejected as not found.
+func (h *Handler) checkAdmin(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	if h.AdminSecret == "" {
+		h.m().admin_requests_total.reject_disabled(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return false
+	}
+	if tok := r.Header.Get("Authorization"); !strings.HasPrefix(tok, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(tok, "Bearer ")), []byte(h.AdminSecret)) != 1 {
+		h.m().admin_requests_total.reject_unauthorized(endpoint).Inc()
+		w.Header().Set("WWW-Authenticate", `Bearer realm="atlas-admin"`)
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_UNAUTHORIZED.MessageObj())
+		return false
+	}
+	if h.AdminRequireClientCert && !h.verifyClientCert(r) {
+		h.m().admin_requests_total.reject_client_cert(endpoint).Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED.MessageObjf("a valid client certificate is required"))
+		return false
+	}
+	return true
+}
+
+// verifyClientCert checks if r has a valid client certificate.
+func (h *Handler) verifyClientCert(r *http.Request) bool {
+	return h.VerifyClientCert != nil && h.VerifyClientCert(r)
+}
@@ -0,0 +1,426 @@
+package api0
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+const (
+	// altMaxSignalAccounts is the maximum number of accounts a signal can be
+	// shared by before it is ignored for alt detection (e.g., CGNAT or a
+	// LAN cafe).
+	altMaxSignalAccounts = 16
+
+	// altMinScore is the minimum score for a possible alt to be reported, so
+	// a single shared IP isn't enough on its own.
+	altMinScore = 2
+
+	// accountSignalRetention is how long account signals are kept after they
+	// were last seen if AccountSignalRetention is zero.
+	accountSignalRetention = time.Hour * 24 * 90
+)
+
+// AltReport is a possible alt of a banned player found by alt detection. It is
+// only a hint for moderators.
+type AltReport struct {
+	UID            uint64   `json:"uid"`
+	Username       string   `json:"username,omitempty"`
+	BannedUID      uint64   `json:"banned_uid"`
+	BannedUsername string   `json:"banned_username,omitempty"`
+	Score          int      `json:"score"`
+	Reasons        []string `json:"reasons"`
+}
+
+// AltReports is the result of an alt detection run.
+type AltReports struct {
+	GeneratedAt time.Time   `json:"generated_at"`
+	Reports     []AltReport `json:"reports"`
+}
+
+// accountSignalHash hashes an account signal value.
+func accountSignalHash(kind AccountSignalKind, value string) string {
+	b := sha256.Sum256([]byte("atlas-account-signal\x00" + string(kind) + "\x00" + value))
+	return hex.EncodeToString(b[:16])
+}
+
+// recordAccountSignal records a signal for an account if AccountSignalStorage
+// is provided. Errors are logged.
+func (h *Handler) recordAccountSignal(r *http.Request, uid uint64, kind AccountSignalKind, value string) {
+	if h.AccountSignalStorage == nil || value == "" {
+		return
+	}
+	t := time.Now()
+	if err := h.AccountSignalStorage.SaveAccountSignal(&AccountSignal{
+		UID:       uid,
+		Kind:      kind,
+		Hash:      accountSignalHash(kind, value),
+		FirstSeen: t,
+		LastSeen:  t,
+	}); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Str("kind", string(kind)).
+			Msgf("failed to save account signal to storage")
+	}
+}
+
+// DetectAlts correlates banned accounts with other accounts by shared signals
+// and username patterns, saving the possible alts for the admin API. Signals
+// older than the retention period are deleted first. It does nothing if
+// AccountSignalStorage isn't provided.
+func (h *Handler) DetectAlts(t time.Time) error {
+	if h.AccountSignalStorage == nil {
+		return nil
+	}
+
+	retention := h.AccountSignalRetention
+	if retention <= 0 {
+		retention = accountSignalRetention
+	}
+	if err := h.AccountSignalStorage.DeleteAccountSignalsBefore(t.Add(-retention)); err != nil {
+		return fmt.Errorf("delete old account signals: %w", err)
+	}
+
+	bans, err := h.getBans()
+	if err != nil {
+		return fmt.Errorf("get bans: %w", err)
+	}
+	banned := make(map[uint64]bool, len(bans))
+	for _, b := range bans {
+		banned[b.UID] = true
+	}
+
+	reports := []AltReport{}
+	for _, b := range bans {
+		rs, err := h.detectAlts(b.UID, banned)
+		if err != nil {
+			return fmt.Errorf("banned uid %d: %w", b.UID, err)
+		}
+		reports = append(reports, rs...)
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].Score > reports[j].Score
+	})
+
+	return h.altReports.Update(h.StateStorage, "altreports", func(AltReports) (AltReports, error) {
+		return AltReports{
+			GeneratedAt: t.UTC().Truncate(time.Second),
+			Reports:     reports,
+		}, nil
+	})
+}
+
+// detectAlts finds possible alts of a single banned account.
+func (h *Handler) detectAlts(buid uint64, banned map[uint64]bool) ([]AltReport, error) {
+	type candidate struct {
+		ips, hints int
+		reasons    []string
+		score      int
+	}
+	cs := map[uint64]*candidate{}
+	get := func(uid uint64) *candidate {
+		c, ok := cs[uid]
+		if !ok {
+			c = new(candidate)
+			cs[uid] = c
+		}
+		return c
+	}
+
+	bnames, err := h.accountNames(buid)
+	if err != nil {
+		return nil, err
+	}
+
+	ss, err := h.AccountSignalStorage.GetAccountSignals(buid)
+	if err != nil {
+		return nil, fmt.Errorf("get account signals: %w", err)
+	}
+	for _, s := range ss {
+		uids, err := h.AccountSignalStorage.GetUIDsByAccountSignal(s.Kind, s.Hash, altMaxSignalAccounts+1)
+		if err != nil {
+			return nil, fmt.Errorf("get uids by account signal: %w", err)
+		}
+		if len(uids) > altMaxSignalAccounts {
+			continue
+		}
+		for _, uid := range uids {
+			if uid == buid || banned[uid] {
+				continue
+			}
+			switch c := get(uid); s.Kind {
+			case AccountSignalIP:
+				c.ips++
+			case AccountSignalHint:
+				c.hints++
+			}
+		}
+	}
+
+	// ban evaders often reuse their old name on a new account
+	for _, name := range bnames {
+		uids, err := h.AccountStorage.GetUIDsByUsername(name)
+		if err != nil {
+			return nil, fmt.Errorf("get uids by username: %w", err)
+		}
+		if h.UsernameHistoryStorage != nil {
+			past, err := h.UsernameHistoryStorage.GetUIDsByPastUsername(name)
+			if err != nil {
+				return nil, fmt.Errorf("get uids by past username: %w", err)
+			}
+			uids = append(uids, past...)
+		}
+		for _, uid := range uids {
+			if uid == buid || banned[uid] {
+				continue
+			}
+			c := get(uid)
+			reason := fmt.Sprintf("reused name %q", name)
+			for _, x := range c.reasons {
+				if x == reason {
+					reason = ""
+					break
+				}
+			}
+			if reason != "" {
+				c.score += 2
+				c.reasons = append(c.reasons, reason)
+			}
+		}
+	}
+
+	var reports []AltReport
+	for uid, c := range cs {
+		if c.hints != 0 {
+			c.score += 3 * c.hints
+			c.reasons = append(c.reasons, fmt.Sprintf("%d shared server hints", c.hints))
+		}
+		if c.ips != 0 {
+			if c.ips > 3 {
+				c.score += 3
+			} else {
+				c.score += c.ips
+			}
+			c.reasons = append(c.reasons, fmt.Sprintf("%d shared ips", c.ips))
+		}
+
+		names, err := h.accountNames(uid)
+		if err != nil {
+			return nil, err
+		}
+		if a, b, ok := similarNames(bnames, names); ok && !strings.EqualFold(a, b) {
+			c.score++
+			c.reasons = append(c.reasons, fmt.Sprintf("similar name %q", b))
+		}
+
+		if c.score < altMinScore {
+			continue
+		}
+		rp := AltReport{
+			UID:       uid,
+			BannedUID: buid,
+			Score:     c.score,
+			Reasons:   c.reasons,
+		}
+		if len(names) != 0 {
+			rp.Username = names[0]
+		}
+		if len(bnames) != 0 {
+			rp.BannedUsername = bnames[0]
+		}
+		reports = append(reports, rp)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].UID < reports[j].UID
+	})
+	return reports, nil
+}
+
+// accountNames gets the current username of uid, followed by any previous
+// usernames.
+func (h *Handler) accountNames(uid uint64) ([]string, error) {
+	var names []string
+	acct, err := h.AccountStorage.GetAccount(uid)
+	if err != nil {
+		return nil, fmt.Errorf("get account: %w", err)
+	}
+	if acct != nil && acct.Username != "" {
+		names = append(names, acct.Username)
+	}
+	if h.UsernameHistoryStorage != nil {
+		cs, err := h.UsernameHistoryStorage.GetUsernameHistory(uid)
+		if err != nil {
+			return nil, fmt.Errorf("get username history: %w", err)
+		}
+		for i := len(cs) - 1; i >= 0; i-- {
+			names = appendName(names, cs[i].To)
+			names = appendName(names, cs[i].From)
+		}
+	}
+	return names, nil
+}
+
+func appendName(names []string, name string) []string {
+	if name == "" {
+		return names
+	}
+	for _, x := range names {
+		if strings.EqualFold(x, name) {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
+// similarNames checks if any names in a and b look alike after normalizing
+// common substitutions and decorations (e.g., xX_b0b_Xx and Bob2).
+func similarNames(a, b []string) (string, string, bool) {
+	for _, x := range a {
+		nx := normalizeName(x)
+		if len(nx) < 3 {
+			continue
+		}
+		for _, y := range b {
+			ny := normalizeName(y)
+			if len(ny) < 3 {
+				continue
+			}
+			switch {
+			case nx == ny:
+			case len(nx) >= 5 && len(ny) >= 5 && levenshtein(nx, ny) <= 1:
+			default:
+				continue
+			}
+			return x, y, true
+		}
+	}
+	return "", "", false
+}
+
+// normalizeName lowercases name, undoes common leetspeak, and strips digits,
+// symbols, and xX-style decorations.
+func normalizeName(name string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(name) {
+		switch c {
+		case '0':
+			c = 'o'
+		case '1', '!':
+			c = 'i'
+		case '3':
+			c = 'e'
+		case '4', '@':
+			c = 'a'
+		case '5', '$':
+			c = 's'
+		case '7':
+			c = 't'
+		}
+		if c >= 'a' && c <= 'z' {
+			b.WriteRune(c)
+		}
+	}
+	s := b.String()
+	for len(s) > 4 && strings.HasPrefix(s, "x") && strings.HasSuffix(s, "x") {
+		s = s[1 : len(s)-1]
+	}
+	return s
+}
+
+// levenshtein computes the edit distance between two ASCII strings.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			d := prev[j-1]
+			if a[i-1] != b[j-1] {
+				d++
+			}
+			if v := prev[j] + 1; v < d {
+				d = v
+			}
+			if v := cur[j-1] + 1; v < d {
+				d = v
+			}
+			cur[j] = d
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func (h *Handler) handleAdminAltReports(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "altreports"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	if h.AccountSignalStorage == nil {
+		h.m().admin_requests_total.reject_disabled(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if err := h.DetectAlts(time.Now()); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to run alt detection")
+			h.m().admin_requests_total.fail_storage_error_account(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		hlog.FromRequest(r).Info().
+			Msgf("ran alt detection at admin request")
+	}
+
+	rs, err := h.altReports.Get(h.StateStorage, "altreports")
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to load alt reports from storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if rs.Reports == nil {
+		rs.Reports = []AltReport{}
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":      true,
+		"generated_at": rs.GeneratedAt,
+		"reports":      rs.Reports,
+	})
+}
@@ -0,0 +1,74 @@
+package api0
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// AnalyticsEventType is the type of an analytics event.
+type AnalyticsEventType string
+
+const (
+	AnalyticsEventPlayerAuth       AnalyticsEventType = "player_auth"       // player authenticated with origin
+	AnalyticsEventPlayerJoin       AnalyticsEventType = "player_join"       // player authenticated with a server
+	AnalyticsEventServerRegistered AnalyticsEventType = "server_registered" // server was verified and added to the list
+	AnalyticsEventServerRemoved    AnalyticsEventType = "server_removed"    // server removed itself from the list
+)
+
+// AnalyticsEvent is an anonymized event for export to external analytics. It
+// does not contain IPs, usernames, or UIDs.
+type AnalyticsEvent struct {
+	Time time.Time          `json:"time"`
+	Type AnalyticsEventType `json:"type"`
+
+	// Player is a stable pseudonymous identifier for the player derived from
+	// the UID and AnalyticsKey.
+	Player string `json:"player,omitempty"`
+
+	LauncherVersion string `json:"launcher_version,omitempty"`
+
+	Server   string `json:"server,omitempty"` // server id
+	Region   string `json:"region,omitempty"`
+	Map      string `json:"map,omitempty"`
+	Playlist string `json:"playlist,omitempty"`
+}
+
+// analyticsEvent sends ev to Analytics, if set.
+func (h *Handler) analyticsEvent(ev AnalyticsEvent) {
+	if h.Analytics != nil {
+		if ev.Time.IsZero() {
+			ev.Time = time.Now().UTC()
+		}
+		h.Analytics(ev)
+	}
+}
+
+// analyticsPlayer returns the pseudonymous analytics identifier for uid.
+func (h *Handler) analyticsPlayer(uid uint64) string {
+	h.analyticsKeyInit.Do(func() {
+		if h.AnalyticsKey == nil {
+			h.analyticsKey = make([]byte, 32)
+			if _, err := rand.Read(h.analyticsKey); err != nil {
+				panic(err)
+			}
+		} else {
+			h.analyticsKey = h.AnalyticsKey
+		}
+	})
+	m := hmac.New(sha256.New, h.analyticsKey)
+	m.Write(binary.LittleEndian.AppendUint64(nil, uid))
+	return hex.EncodeToString(m.Sum(nil)[:16])
+}
+
+// analyticsServer fills the server fields of ev from s.
+func analyticsServer(ev AnalyticsEvent, s *Server) AnalyticsEvent {
+	ev.Server = s.ID
+	ev.Region = s.Region
+	ev.Map = s.Map
+	ev.Playlist = s.Playlist
+	return ev
+}
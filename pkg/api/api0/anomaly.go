@@ -0,0 +1,323 @@
+package api0
+
+import (
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// AnomalyDetection configures automatic tarpits and blocks for IPs and subnets
+// with unusually high auth failure or registration rejection rates. When the
+// number of failures within the window exceeds a threshold, requests are
+// delayed for the penalty duration, and if it is exceeded again while
+// tarpitted, requests are rejected until the penalty expires.
+type AnomalyDetection struct {
+	// IPThreshold is the number of failures from a single IPv4 address or
+	// IPv6 /64 within the window. If zero, IPs are not penalized.
+	IPThreshold int
+
+	// SubnetThreshold is the number of failures from an IPv4 /24 or IPv6 /48
+	// within the window. If zero, subnets are not penalized.
+	SubnetThreshold int
+
+	// Window is the sliding window to count failures over. If zero, it
+	// defaults to 5 minutes.
+	Window time.Duration
+
+	// TarpitDelay is the delay added to tarpitted requests. If zero, it
+	// defaults to 3 seconds.
+	TarpitDelay time.Duration
+
+	// Duration is how long tarpits and blocks last. If zero, it defaults to
+	// 15 minutes.
+	Duration time.Duration
+}
+
+func (a AnomalyDetection) enabled() bool {
+	return a.IPThreshold > 0 || a.SubnetThreshold > 0
+}
+
+func (a AnomalyDetection) window() time.Duration {
+	if a.Window <= 0 {
+		return time.Minute * 5
+	}
+	return a.Window
+}
+
+func (a AnomalyDetection) tarpitDelay() time.Duration {
+	if a.TarpitDelay <= 0 {
+		return time.Second * 3
+	}
+	return a.TarpitDelay
+}
+
+func (a AnomalyDetection) duration() time.Duration {
+	if a.Duration <= 0 {
+		return time.Minute * 15
+	}
+	return a.Duration
+}
+
+// anomalyPenalty is an active tarpit or block.
+type anomalyPenalty struct {
+	Prefix netip.Prefix `json:"prefix"`
+	Block  bool         `json:"block"`
+	Reason string       `json:"reason"`
+	Since  time.Time    `json:"since"`
+	Until  time.Time    `json:"until"`
+}
+
+// anomalyDetector tracks failures and penalties.
+type anomalyDetector struct {
+	failures  rateLimiter[netip.Prefix]
+	mu        sync.Mutex
+	penalties map[netip.Prefix]anomalyPenalty
+}
+
+// anomalyPrefixes gets the IP and subnet prefixes for ip.
+func anomalyPrefixes(ip netip.Addr) (single, subnet netip.Prefix) {
+	ip = ip.Unmap()
+	if ip.Is4() {
+		single = netip.PrefixFrom(ip, 32)
+		subnet, _ = ip.Prefix(24)
+	} else {
+		single, _ = ip.Prefix(64)
+		subnet, _ = ip.Prefix(48)
+	}
+	return
+}
+
+// penalty gets the most severe active penalty for any of ps at t.
+func (d *anomalyDetector) penalty(t time.Time, ps ...netip.Prefix) (anomalyPenalty, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var res anomalyPenalty
+	var ok bool
+	for _, p := range ps {
+		x, exists := d.penalties[p]
+		if !exists {
+			continue
+		}
+		if !t.Before(x.Until) {
+			delete(d.penalties, p)
+			continue
+		}
+		if !ok || (x.Block && !res.Block) {
+			res, ok = x, true
+		}
+	}
+	return res, ok
+}
+
+// escalate tarpits p, or blocks it if it is already tarpitted, returning the
+// new penalty.
+func (d *anomalyDetector) escalate(p netip.Prefix, t time.Time, dur time.Duration, reason string) anomalyPenalty {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.penalties == nil {
+		d.penalties = make(map[netip.Prefix]anomalyPenalty)
+	}
+
+	// forget expired penalties so the map doesn't grow forever
+	for k, x := range d.penalties {
+		if !t.Before(x.Until) {
+			delete(d.penalties, k)
+		}
+	}
+
+	x := anomalyPenalty{
+		Prefix: p,
+		Reason: reason,
+		Since:  t,
+		Until:  t.Add(dur),
+	}
+	if old, exists := d.penalties[p]; exists {
+		x.Block = true
+		x.Since = old.Since
+	}
+	d.penalties[p] = x
+	return x
+}
+
+// list gets the active penalties at t, sorted by prefix.
+func (d *anomalyDetector) list(t time.Time) []anomalyPenalty {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ps := []anomalyPenalty{}
+	for _, x := range d.penalties {
+		if t.Before(x.Until) {
+			ps = append(ps, x)
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		if a, b := ps[i].Prefix.Addr(), ps[j].Prefix.Addr(); a != b {
+			return a.Less(b)
+		}
+		return ps[i].Prefix.Bits() < ps[j].Prefix.Bits()
+	})
+	return ps
+}
+
+// clear removes the penalty and failure history for p, or all of them if p is
+// invalid.
+func (d *anomalyDetector) clear(p netip.Prefix) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !p.IsValid() {
+		d.penalties = nil
+		d.failures.Clear()
+		return
+	}
+	delete(d.penalties, p)
+	d.failures.Reset(p)
+}
+
+// anomalyFailure records an auth failure or registration rejection from ip,
+// penalizing the ip or subnet if a threshold is exceeded.
+func (h *Handler) anomalyFailure(r *http.Request, ip netip.Addr, reason string) {
+	a := h.AnomalyDetection
+	if !a.enabled() || !ip.IsValid() {
+		return
+	}
+	t := time.Now()
+	single, subnet := anomalyPrefixes(ip)
+	for _, x := range []struct {
+		scope     string
+		prefix    netip.Prefix
+		threshold int
+	}{
+		{"ip", single, a.IPThreshold},
+		{"subnet", subnet, a.SubnetThreshold},
+	} {
+		if x.threshold <= 0 || h.anomaly.failures.Allow(x.prefix, t, x.threshold, a.window()) {
+			continue
+		}
+		h.anomaly.failures.Reset(x.prefix)
+
+		p := h.anomaly.escalate(x.prefix, t, a.duration(), reason)
+		typ, action := NotificationAnomalyTarpit, "tarpitted"
+		if p.Block {
+			typ, action = NotificationAnomalyBlock, "blocked"
+			h.m().anomaly_penalties_total.block(x.scope).Inc()
+		} else {
+			h.m().anomaly_penalties_total.tarpit(x.scope).Inc()
+		}
+		hlog.FromRequest(r).Warn().
+			Str("prefix", x.prefix.String()).
+			Str("reason", reason).
+			Bool("block", p.Block).
+			Time("until", p.Until).
+			Msgf("%s %s due to repeated failures", x.scope, action)
+		h.notify(Notification{
+			Type:    typ,
+			Message: x.scope + " " + x.prefix.String() + " " + action + " after more than " + strconv.Itoa(x.threshold) + " failures within " + a.window().String(),
+			Fields: map[string]string{
+				"prefix": x.prefix.String(),
+				"reason": reason,
+				"until":  p.Until.UTC().Format(time.RFC3339),
+			},
+		})
+	}
+}
+
+// checkAnomaly applies any active penalty for ip. If it is blocked, it writes
+// an error response and returns false. If it is tarpitted, it waits before
+// returning true.
+func (h *Handler) checkAnomaly(w http.ResponseWriter, r *http.Request, ip netip.Addr) bool {
+	a := h.AnomalyDetection
+	if !a.enabled() || !ip.IsValid() {
+		return true
+	}
+	single, subnet := anomalyPrefixes(ip)
+	p, ok := h.anomaly.penalty(time.Now(), single, subnet)
+	if !ok {
+		return true
+	}
+	if p.Block {
+		h.m().anomaly_checks_total.reject_block.Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(p.Until).Seconds())+1))
+		respFail(w, r, http.StatusTooManyRequests, ErrorCode_RATE_LIMITED.MessageObj())
+		return false
+	}
+	h.m().anomaly_checks_total.tarpit.Inc()
+	tm := time.NewTimer(a.tarpitDelay())
+	defer tm.Stop()
+	select {
+	case <-tm.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (h *Handler) handleAdminAnomalies(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "anomalies"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success":   true,
+			"penalties": h.anomaly.list(time.Now()),
+		})
+		return
+	case http.MethodDelete:
+		var q struct {
+			Prefix string `param:"prefix"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		var p netip.Prefix
+		if q.Prefix != "" {
+			var err error
+			if p, err = netip.ParsePrefix(q.Prefix); err != nil {
+				h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+				respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid prefix: %v", err))
+				return
+			}
+			p = p.Masked()
+		}
+		h.anomaly.clear(p)
+
+		hlog.FromRequest(r).Info().
+			Str("prefix", q.Prefix).
+			Msgf("cleared anomaly penalties")
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
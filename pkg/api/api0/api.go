@@ -1,5 +1,8 @@
 // Package api0 implements the original master server API.
 //
+// The unversioned paths remain wire-compatible with the original master server
+// (see TestCompat in pkg/e2e), and newer clients can use /v2 (see apiVersion).
+//
 // External differences:
 //   - Proper HTTP response codes are used (this won't break anything since existing code doesn't check them).
 //   - Caching headers are supported and used where appropriate.
@@ -14,6 +17,7 @@ package api0
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
@@ -27,10 +31,15 @@ import (
 
 	"github.com/klauspost/compress/gzip"
 	"github.com/pg9182/ip2x"
+	"github.com/r2northstar/atlas/pkg/cache"
+	"github.com/r2northstar/atlas/pkg/discord"
 	"github.com/r2northstar/atlas/pkg/eax"
+	"github.com/r2northstar/atlas/pkg/l10n"
 	"github.com/r2northstar/atlas/pkg/metricsx"
 	"github.com/r2northstar/atlas/pkg/nspkt"
 	"github.com/r2northstar/atlas/pkg/origin"
+	"github.com/r2northstar/atlas/pkg/outbox"
+	"github.com/r2northstar/atlas/pkg/signkeys"
 	"github.com/rs/zerolog/hlog"
 	"golang.org/x/mod/semver"
 )
@@ -59,16 +68,180 @@ type Handler struct {
 	// EAXClient makes requests to the EAX API.
 	EAXClient *eax.Client
 
-	// CleanBadWords is used to filter bad words from server names and
-	// descriptions. If not provided, words will not be filtered.
+	// CleanBadWords is used to filter bad words from server names,
+	// descriptions, and relayed chat. If not provided, words will not be
+	// filtered. It can be changed with Reconfigure.
 	CleanBadWords func(s string) string
 
+	// Cache is used to cache username and game server location lookups. It
+	// may be shared between instances. If not provided, lookups are not
+	// cached. Cached usernames are only used if the lookup fails.
+	Cache cache.Cache
+
+	// StateStorage stores server-wide state managed via the admin API. If not
+	// provided, admin-managed content is not persisted and cannot be changed.
+	StateStorage StateStorage
+
+	// ServerStatsStorage stores aggregated game server statistics recorded
+	// by RecordServerStats. If not provided, server stats history is disabled.
+	ServerStatsStorage ServerStatsStorage
+
+	// Analytics is called with anonymized events for export to external
+	// analytics sinks. It must not block. If not provided, events are not
+	// exported.
+	Analytics func(AnalyticsEvent)
+
+	// AnalyticsKey is used to derive pseudonymous player identifiers for
+	// analytics events. If not provided, a random key is generated, so
+	// identifiers will change after restarting.
+	AnalyticsKey []byte
+
+	// ServerStatsRetention is the amount of time to keep hourly server stats
+	// for. If zero, it defaults to 90 days.
+	ServerStatsRetention time.Duration
+
+	// Retention configures the jobs run by RunRetention.
+	Retention RetentionConfig
+
+	// Compactors are compacted by RunRetention if Retention.Compact is set.
+	Compactors []CompactStorage
+
+	// AdminSecret is the bearer token required for the admin API. If empty,
+	// the admin API is disabled.
+	AdminSecret string
+
+	// VerifyClientCert checks whether r was made over a connection with a
+	// valid mTLS client certificate. It is required for
+	// AdminRequireClientCert and RelayRequireClientCert.
+	VerifyClientCert func(r *http.Request) bool
+
+	// AdminRequireClientCert requires admin API requests to have a valid
+	// client certificate in addition to the admin secret.
+	AdminRequireClientCert bool
+
+	// RelayRequireClientCert requires relay server list streams to have a
+	// valid client certificate in addition to the relay token.
+	RelayRequireClientCert bool
+
+	// AccountLinkStorage stores links between accounts and external accounts.
+	// If not provided, account linking is disabled.
+	AccountLinkStorage AccountLinkStorage
+
+	// UsernameHistoryStorage, if provided, records username changes detected
+	// during auth for the admin name history endpoint.
+	UsernameHistoryStorage UsernameHistoryStorage
+
+	// AccountSignalStorage, if provided, records hashed IPs and server-provided
+	// hints for accounts, which are used for alt detection.
+	AccountSignalStorage AccountSignalStorage
+
+	// AccountSignalRetention is how long account signals are kept after they
+	// were last seen. If zero, it defaults to 90 days.
+	AccountSignalRetention time.Duration
+
+	// BanListStorage, if provided, stores bans contributed by trusted servers
+	// to the community ban lists configured via the admin API.
+	BanListStorage BanListStorage
+
+	// AbuseReportStorage, if provided, stores player reports submitted by game
+	// servers for the admin moderation queue.
+	AbuseReportStorage AbuseReportStorage
+
+	// ReportRateLimit is the maximum number of reports a player can make
+	// within ReportRateWindow. If zero, reports are not rate limited.
+	ReportRateLimit int
+
+	// ReportRateWindow is the window for ReportRateLimit and for ignoring
+	// duplicate reports. If zero, it defaults to an hour.
+	ReportRateWindow time.Duration
+
+	// ServerDelists configures the durations of admin delisting actions
+	// against game servers (see /admin/serverdelists). Appeals require
+	// AbuseReportStorage.
+	ServerDelists ServerDelistConfig
+
+	// RatingStorage, if provided, stores player skill ratings updated from
+	// match results submitted by game servers.
+	RatingStorage RatingStorage
+
+	// PublicRatings exposes player skill ratings via the player API.
+	PublicRatings bool
+
+	// MatchHistoryStorage, if provided, stores match summaries submitted by
+	// game servers for the player match history endpoint.
+	MatchHistoryStorage MatchHistoryStorage
+
+	// MatchHistoryRetention is how long matches are kept for. If zero, it
+	// defaults to 90 days.
+	MatchHistoryRetention time.Duration
+
+	// MuteReportStorage, if provided, stores chat and voice mutes reported by
+	// game servers, which are aggregated so other servers (and the chat
+	// relay) can mute players muted by many servers.
+	MuteReportStorage MuteReportStorage
+
+	// MuteReports configures how mute reports are aggregated.
+	MuteReports MuteReportConfig
+
+	// CrashStorage, if provided, aggregates crash reports uploaded by game
+	// servers by signature for the admin API.
+	CrashStorage CrashStorage
+
+	// CrashRetention is how long crash signatures are kept for after the last
+	// report. If zero, it defaults to 30 days.
+	CrashRetention time.Duration
+
+	// CrashRateLimit is the maximum number of crash reports from a single
+	// server IP per hour. If zero, crash reports are not rate limited.
+	CrashRateLimit int
+
+	// PdataPlayerWriteLimit is the maximum number of pdata writes for a single
+	// player within PdataWriteWindow. If zero, player writes are not limited.
+	PdataPlayerWriteLimit int
+
+	// PdataServerWriteLimit is the maximum number of pdata writes from a
+	// single game server (or listen server IP) within PdataWriteWindow. If
+	// zero, server writes are not limited.
+	PdataServerWriteLimit int
+
+	// PdataWriteWindow is the window for PdataPlayerWriteLimit and
+	// PdataServerWriteLimit. If zero, it defaults to a minute.
+	PdataWriteWindow time.Duration
+
+	// PlayerQuotas limits auth attempts, pdata writes, and reports per player
+	// after authentication, in addition to the IP and server limits.
+	PlayerQuotas PlayerQuotas
+
+	// DiscordOAuth2, if provided, enables linking Discord accounts.
+	DiscordOAuth2 *discord.OAuth2
+
+	// DiscordBotToken, if provided, allows servers to restrict joining to
+	// players with a linked Discord account having a role in a guild the bot
+	// is in. It requires AccountLinkStorage.
+	DiscordBotToken string
+
+	// SteamWebAPIKey, if provided, enables linking Steam accounts.
+	SteamWebAPIKey string
+
+	// SteamAppID is the app ID to verify Steam session tickets for. If zero,
+	// Titanfall 2 is used.
+	SteamAppID uint32
+
 	// MainMenuPromos gets the main menu promos to return for a request.
 	MainMenuPromos func(*http.Request) MainMenuPromos
 
-	// NotFound handles requests not handled by this Handler.
+	// NotFound handles unversioned requests not handled by this Handler.
 	NotFound http.Handler
 
+	// APIv1Sunset, if not zero, is advertised in the Deprecation, Sunset, and
+	// Link headers of responses to requests for the legacy API (i.e., /v1 and
+	// unversioned paths). Legacy requests are still served after it.
+	APIv1Sunset time.Time
+
+	// OnReload, if provided, is called by the admin API to reload the
+	// configuration (e.g., by calling Reconfigure).
+	OnReload func() error
+
 	// MaxServers limits the number of registered servers. If -1, no limit is
 	// applied. If 0, a reasonable default is used.
 	MaxServers int
@@ -87,13 +260,105 @@ type Handler struct {
 	// versions are always allowed.
 	MinimumLauncherVersionClient, MinimumLauncherVersionServer string
 
+	// BlockedLauncherVersions denies authentication and server registration to
+	// specific versions. Each one may be a full version, or a major.minor or
+	// major version to block all versions with that prefix. +dev versions are
+	// always allowed.
+	BlockedLauncherVersions []string
+
+	// LauncherUpdateURL is returned to clients which are denied due to their
+	// version.
+	LauncherUpdateURL string
+
 	// TokenExpiryTime controls the expiry of player masterserver auth tokens.
 	// If zero, a reasonable a default is used.
 	TokenExpiryTime time.Duration
 
+	// SessionPolicy controls how concurrent auth sessions for the same account
+	// are handled. If empty, SessionPolicyKickOldest is used.
+	SessionPolicy SessionPolicy
+
+	// MaxSessions is the maximum number of concurrent auth sessions per
+	// account for SessionPolicyKickOldest and SessionPolicyAllow. If zero, only
+	// one session is allowed.
+	MaxSessions int
+
+	// AuthReplayWindow is how long stryder tokens used for origin_auth are
+	// remembered so reuse (e.g., of a sniffed token) can be rejected. If zero,
+	// replay protection is disabled.
+	AuthReplayWindow time.Duration
+
+	// AuthReplayMaxTokens is the maximum number of used stryder tokens to
+	// remember. If zero, a reasonable default is used.
+	AuthReplayMaxTokens int
+
+	// OriginBreakerThreshold is the number of consecutive stryder auth
+	// failures (other than rejected tokens) after which Origin is considered
+	// unavailable and stryder requests are skipped for OriginBreakerCooldown.
+	// If zero, the circuit breaker is disabled.
+	OriginBreakerThreshold int
+
+	// OriginBreakerCooldown is how long to skip stryder requests for once the
+	// circuit breaker opens, after which a single request is attempted to
+	// check if it has recovered. If zero, 30 seconds is used.
+	OriginBreakerCooldown time.Duration
+
+	// DegradedAuthMaxAge, if non-zero, allows players to authenticate while
+	// the Origin circuit breaker is open if their account was verified with
+	// stryder within this duration. These sessions are tagged as
+	// stale-verified.
+	DegradedAuthMaxAge time.Duration
+
+	// DegradedAuthAnyIP allows degraded auth from IPs which haven't previously
+	// been used by the account.
+	DegradedAuthAnyIP bool
+
+	// GuestMode configures restricted short-lived guest sessions for when
+	// Origin isn't available (e.g., at LAN parties).
+	GuestMode GuestMode
+
+	// PlayerPresenceTTL is how long player presence is kept without a
+	// keep-alive from the server they're connected to. If zero, 2 minutes is
+	// used.
+	PlayerPresenceTTL time.Duration
+
+	// FriendStorage, if provided, stores friends lists for /client/friends.
+	FriendStorage FriendStorage
+
+	// BlockStorage, if provided, stores player block lists for
+	// /client/blocks, which are enforced by matchmaking, parties, friends, and
+	// the chat relay.
+	BlockStorage BlockStorage
+
+	// JoinTokens configures the two-step join flow with /client/join_token.
+	JoinTokens JoinTokens
+
+	// UDPHeartbeat configures heartbeats over UDP for game servers registered
+	// with a signing key.
+	UDPHeartbeat UDPHeartbeat
+
+	// CORS configures cross-origin access for browser-based clients. It can be
+	// changed with Reconfigure.
+	CORS CORS
+
 	// AllowGameServerIPv6 controls whether to allow game servers to use IPv6.
 	AllowGameServerIPv6 bool
 
+	// AttackMode configures the proof-of-work challenge for unauthenticated
+	// endpoints. It can be overridden at runtime via the admin API.
+	AttackMode AttackMode
+
+	// ServerAttestationKey is used to sign attestations for trusted servers and
+	// the launcher update manifest. If not provided, a random key is used. It
+	// is ignored if SigningKeys is provided.
+	ServerAttestationKey ed25519.PrivateKey
+
+	// SigningKeys, if provided, contains the keys used to sign attestations
+	// for trusted servers and the launcher update manifest. Signatures are
+	// made with the newest key, and the active keys are published at
+	// /.well-known/jwks.json for game servers which verify them locally.
+	SigningKeys *signkeys.Set
+
 	// LookupIP looks up an IP2Location record for an IP. If not provided,
 	// server regions and geo metrics are disabled. If it doesn't include latlon
 	// info, geo metrics will be disabled too.
@@ -110,10 +375,183 @@ type Handler struct {
 	// empty region and no error if no region is to be assigned.
 	GetRegion func(netip.Addr, ip2x.Record) (string, error)
 
+	// LookupIPReputation gets the reputation flags for an IP (e.g., from an
+	// IP2Proxy database). If provided, the flags are recorded for new auth
+	// sessions and server registrations.
+	LookupIPReputation func(netip.Addr) (IPReputation, error)
+
+	// IPReputationVerify contains the reputation flags for which players must
+	// have a linked external account to authenticate. If any are set,
+	// LookupIPReputation and AccountLinkStorage should be provided.
+	IPReputationVerify IPReputation
+
+	// LookupIPNetwork gets the country and ASN for an IP. If not provided,
+	// network rules can only match all IPs.
+	LookupIPNetwork func(netip.Addr) (IPNetwork, error)
+
+	// Hooks contains compiled-in extensions to call from the handlers (see
+	// Hook and RegisteredHooks). They are called in order.
+	Hooks []Hook
+
+	// AnomalyDetection configures automatic tarpits and blocks for IPs with
+	// high auth failure or registration rejection rates. If no thresholds are
+	// set, it is disabled.
+	AnomalyDetection AnomalyDetection
+
+	// Honeypot configures decoy paths and parameters which tag and rate limit
+	// the IPs using them.
+	Honeypot Honeypot
+
+	// Captcha configures CAPTCHA verification for browser requests from IPs
+	// penalized by AnomalyDetection. If no provider is set, it is disabled.
+	Captcha CaptchaConfig
+
+	// Notify is called with operational notifications (e.g., automatic
+	// abuse mitigations). It must not block.
+	Notify func(Notification)
+
+	// IdempotencyWindow is how long responses to server registration, pdata
+	// write, and ban requests with an Idempotency-Key header are remembered,
+	// so client retries with the same key are replayed instead of being
+	// applied again. If zero, idempotency keys are ignored.
+	IdempotencyWindow time.Duration
+
+	// IdempotencyMaxKeys is the maximum number of idempotency keys to
+	// remember. If zero, a reasonable default is used.
+	IdempotencyMaxKeys int
+
+	// PartyMaxSize is the maximum number of players in a party. If zero,
+	// parties are disabled.
+	PartyMaxSize int
+
+	// Matchmaking configures the matchmaking queue. If MatchSize is zero, it
+	// is disabled.
+	Matchmaking MatchmakingConfig
+
+	// FeatureFlags controls the gradual rollout of features (see Features).
+	// Flags can be overridden via the admin API, which requires StateStorage.
+	// This is reloadable.
+	FeatureFlags FeatureFlags
+
+	// ServerListCanary is an alternate server list ranking to serve to clients
+	// in the FeatureServerListCanary rollout. Responses include an
+	// X-Atlas-Variant header, and join metrics are recorded for each variant
+	// to compare them.
+	ServerListCanary ServerListRank
+
+	// ServerSearchRateLimit is the maximum number of server searches from a
+	// single IP per minute. If zero, searches are not rate limited.
+	ServerSearchRateLimit int
+
+	// ChatRelay enables the WebSocket chat relay for servers to exchange
+	// global and lobby chat. Messages are filtered with CleanBadWords, and
+	// messages from players muted via the admin API (this requires
+	// StateStorage) or by enough servers (see MuteReportStorage) are dropped.
+	ChatRelay bool
+
+	// ChatBridge, if provided, is called with every relayed chat message
+	// (e.g., to forward it to Discord). It must not block.
+	ChatBridge func(ChatMessage)
+
+	// MirrorCheckClient is the HTTP client used by CheckMirrors to download
+	// release and mod URLs. If nil, http.DefaultClient is used.
+	MirrorCheckClient *http.Client
+
+	// ServerWebhooks allows server owners to register a URL at /server/webhook
+	// to receive signed delisted, verification_failed, banned, and moderation
+	// events. This requires StateStorage, and CheckServerWebhooks to be called
+	// every ServerWebhookCheckInterval.
+	ServerWebhooks bool
+
+	// ServerWebhookClient is the HTTP client used to send server webhooks. If
+	// nil, a client which refuses to connect to non-public addresses is used.
+	ServerWebhookClient *http.Client
+
+	// Outbox, if set, persists server webhook deliveries until they succeed,
+	// retrying them according to OutboxOptions, and deduplicates events
+	// detected by multiple instances. RunOutbox must be running for them to
+	// be sent.
+	Outbox        outbox.Backend
+	OutboxOptions outbox.Options
+
 	metricsInit sync.Once
 	metricsObj  apiMetrics
 
+	reloadable atomic.Pointer[ReloadableConfig]
+
 	connect sync.Map // [connectStateKey]*connectState
+
+	tokenKeyInit sync.Once
+	tokenKey     []byte
+
+	authNonces            nonceStore
+	serverSignatureNonces nonceStore
+	challengeNonces       nonceStore
+
+	idempotency idempotencyCache
+
+	discordRoles discordRoleCache
+
+	matchmaking matchmaker
+	parties     partyStore
+	chatRelay   chatRelay
+
+	pdataWriteMu [64]sync.Mutex
+
+	pdataPlayerLimiter rateLimiter[uint64]
+	playerQuotas       playerQuotas
+	pdataServerLimiter rateLimiter[string]
+
+	originBreaker circuitBreaker
+
+	erased erasureTombstones
+
+	analyticsKeyInit sync.Once
+	analyticsKey     []byte
+
+	serverHistory serverHistory
+
+	serverStats          serverStatsRecorder
+	populationPeaksCache populationPeaksCache
+
+	motd                      stateValue[[]MOTD]
+	events                    stateValue[[]Event]
+	incidents                 stateValue[[]Incident]
+	translations              stateValue[l10n.Catalog]
+	versionGateOverride       stateValue[VersionGate]
+	attackModeOverride        stateValue[*AttackMode]
+	featureFlagOverrides      stateValue[FeatureFlags]
+	trustedServers            stateValue[[]TrustedServer]
+	trustedServerApplications stateValue[[]TrustedServerApplication]
+	serverDelists             stateValue[[]ServerDelist]
+	bans                      stateValue[[]Ban]
+	altReports                stateValue[AltReports]
+	banLists                  stateValue[[]BanList]
+	networkRules              stateValue[NetworkRules]
+	pdataRules                stateValue[PdataRules]
+	chatMutes                 stateValue[[]ChatMute]
+	releases                  stateValue[[]Release]
+	modIndex                  stateValue[[]ModIndexEntry]
+	mirrors                   mirrorHealth
+	conditional               conditionalCache
+	joinTokens                joinTokenStore
+	presence                  presenceStore
+	friendsMu                 sync.Mutex
+	relays                    stateValue[[]Relay]
+	relayHub                  relayHub
+	anomaly                   anomalyDetector
+	honeypot                  honeypotTags
+	serverWebhooks            stateValue[[]ServerWebhook]
+	serverWebhookMon          serverWebhookMonitor
+
+	reportLimiter rateLimiter[uint64]
+	crashLimiter  rateLimiter[netip.Addr]
+	searchLimiter rateLimiter[netip.Addr]
+	guestLimiter  rateLimiter[netip.Addr]
+	reportDupes   rateLimiter[[2]uint64]
+
+	signingKeysInit sync.Once
+	signingKeysSet  *signkeys.Set
 }
 
 type connectStateKey struct {
@@ -122,6 +560,7 @@ type connectStateKey struct {
 }
 
 type connectState struct {
+	uid      uint64
 	res      chan<- string // buffer 1
 	pdata    []byte
 	gotPdata atomic.Bool
@@ -138,33 +577,212 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Server", "Atlas")
 
+	r, ver := stripAPIVersion(r)
+	h.setAPIVersionHeaders(w, r, ver)
+	if ver == apiVersionV2 {
+		v2, r2 := newV2ResponseWriter(w, r)
+		defer func() {
+			if notPanicked {
+				v2.finish()
+			}
+		}()
+		w, r = v2, r2
+	}
+
+	w, corsOK := h.withCORS(w, r)
+	if !corsOK {
+		notPanicked = true
+		return
+	}
+
+	r = h.withLocalizer(r)
+
+	if !h.checkHoneypot(w, r) {
+		notPanicked = true
+		return
+	}
+
+	if !h.checkChallenge(w, r) {
+		notPanicked = true
+		return
+	}
+
+	if !h.checkCaptcha(w, r) {
+		notPanicked = true
+		return
+	}
+
 	switch r.URL.Path {
 	case "/client/mainmenupromos":
 		h.handleMainMenuPromos(w, r)
+	case "/client/matchmaking":
+		h.handleClientMatchmaking(w, r)
+	case "/client/party":
+		h.handleClientParty(w, r)
+	case "/client/friends":
+		h.handleClientFriends(w, r)
+	case "/client/blocks":
+		h.handleClientBlocks(w, r)
+	case "/client/motd":
+		h.handleClientMOTD(w, r)
+	case "/client/server_attestation_key":
+		h.handleClientServerAttestationKey(w, r)
+	case "/.well-known/jwks.json":
+		h.handleJWKS(w, r)
+	case "/client/update_manifest":
+		h.handleClientUpdateManifest(w, r)
+	case "/client/mods":
+		h.handleClientMods(w, r)
+	case "/client/server_mods":
+		h.handleClientServerMods(w, r)
+	case "/client/relays":
+		h.handleClientRelays(w, r)
+	case "/client/challenge":
+		h.handleClientChallenge(w, r)
+	case "/client/captcha":
+		h.handleClientCaptcha(w, r)
 	case "/client/origin_auth":
 		h.handleClientOriginAuth(w, r)
+	case "/client/guest_auth":
+		h.handleClientGuestAuth(w, r)
+	case "/client/join_token":
+		h.handleClientJoinToken(w, r)
 	case "/client/auth_with_server":
 		h.handleClientAuthWithServer(w, r)
 	case "/client/auth_with_self":
 		h.handleClientAuthWithSelf(w, r)
 	case "/client/servers":
 		h.handleClientServers(w, r)
-	case "/server/add_server", "/server/update_values", "/server/heartbeat":
+	case "/client/servers/search":
+		h.handleClientServersSearch(w, r)
+	case "/client/population":
+		h.handleClientPopulation(w, r)
+	case "/api/status":
+		h.handleAPIStatus(w, r)
+	case "/server/add_server":
+		h.serveIdempotent(w, r, h.handleServerUpsert)
+	case "/server/update_values", "/server/heartbeat":
 		h.handleServerUpsert(w, r)
 	case "/server/remove_server":
 		h.handleServerRemove(w, r)
+	case "/server/diagnose":
+		h.handleServerDiagnose(w, r)
+	case "/server/owner_status":
+		h.handleServerOwnerStatus(w, r)
+	case "/server/delist_appeal":
+		h.handleServerDelistAppeal(w, r)
+	case "/server/webhook":
+		h.handleServerWebhook(w, r)
+	case "/server/stats_history":
+		h.handleServerStatsHistory(w, r)
+	case "/server/apply_trusted":
+		h.handleServerApplyTrusted(w, r)
+	case "/server/banlist":
+		h.serveIdempotent(w, r, h.handleServerBanList)
+	case "/server/match_result":
+		h.serveIdempotent(w, r, h.handleServerMatchResult)
+	case "/server/report":
+		h.handleServerReport(w, r)
+	case "/server/crash":
+		h.handleServerCrash(w, r)
+	case "/server/players":
+		h.handleServerPlayers(w, r)
+	case "/server/mute":
+		h.handleServerMute(w, r)
+	case "/server/mute_status":
+		h.handleServerMuteStatus(w, r)
+	case "/server/chat":
+		h.handleServerChat(w, r)
 	case "/server/connect":
 		h.handleServerConnect(w, r)
+	case "/relay/stream":
+		h.handleRelayStream(w, r)
 	case "/accounts/write_persistence":
-		h.handleAccountsWritePersistence(w, r)
+		h.serveIdempotent(w, r, h.handleAccountsWritePersistence)
 	case "/accounts/get_username":
 		h.handleAccountsGetUsername(w, r)
 	case "/accounts/lookup_uid":
 		h.handleAccountsLookupUID(w, r)
+	case "/accounts/link/discord":
+		h.handleAccountsLinkDiscord(w, r)
+	case "/accounts/link/discord/callback":
+		h.handleAccountsLinkDiscordCallback(w, r)
+	case "/accounts/link/steam":
+		h.handleAccountsLinkSteam(w, r)
+	case "/accounts/unlink":
+		h.handleAccountsUnlink(w, r)
+	case "/accounts/get_links":
+		h.handleAccountsGetLinks(w, r)
+	case "/accounts/delete_data":
+		h.handleAccountsDeleteData(w, r)
+	case "/accounts/export_data":
+		h.handleAccountsExportData(w, r)
+	case "/admin/motd":
+		h.handleAdminMOTD(w, r)
+	case "/admin/releases":
+		h.handleAdminReleases(w, r)
+	case "/admin/mods":
+		h.handleAdminMods(w, r)
+	case "/admin/mirrors":
+		h.handleAdminMirrors(w, r)
+	case "/admin/relays":
+		h.handleAdminRelays(w, r)
+	case "/admin/events":
+		h.handleAdminEvents(w, r)
+	case "/admin/incidents":
+		h.handleAdminIncidents(w, r)
+	case "/admin/translations":
+		h.handleAdminTranslations(w, r)
+	case "/admin/versiongate":
+		h.handleAdminVersionGate(w, r)
+	case "/admin/attackmode":
+		h.handleAdminAttackMode(w, r)
+	case "/admin/serverdelists":
+		h.handleAdminServerDelists(w, r)
+	case "/admin/trustedservers":
+		h.handleAdminTrustedServers(w, r)
+	case "/admin/erase":
+		h.handleAdminErase(w, r)
+	case "/admin/namehistory":
+		h.handleAdminNameHistory(w, r)
+	case "/admin/accounts":
+		h.handleAdminAccounts(w, r)
+	case "/admin/bans":
+		h.serveIdempotent(w, r, h.handleAdminBans)
+	case "/admin/altreports":
+		h.handleAdminAltReports(w, r)
+	case "/admin/banlists":
+		h.serveIdempotent(w, r, h.handleAdminBanLists)
+	case "/admin/reports":
+		h.handleAdminReports(w, r)
+	case "/admin/netrules":
+		h.handleAdminNetworkRules(w, r)
+	case "/admin/features":
+		h.handleAdminFeatures(w, r)
+	case "/admin/pdatarules":
+		h.handleAdminPdataRules(w, r)
+	case "/admin/crashes":
+		h.handleAdminCrashes(w, r)
+	case "/admin/chatmutes":
+		h.handleAdminChatMutes(w, r)
+	case "/admin/anomalies":
+		h.handleAdminAnomalies(w, r)
+	case "/admin/honeypot":
+		h.handleAdminHoneypot(w, r)
+	case "/admin/reload":
+		h.handleAdminReload(w, r)
+	case "/player/ratings":
+		h.handlePlayerRatings(w, r)
+	case "/player/matches":
+		h.handlePlayerMatches(w, r)
 	case "/player/pdata", "/player/info", "/player/stats", "/player/loadout":
 		h.handlePlayer(w, r)
 	default:
-		if h.NotFound == nil {
+		if _, ok := parsePlayerMatchesPath(r.URL.Path); ok {
+			h.handlePlayerMatches(w, r)
+			break
+		}
+		if h.NotFound == nil || ver != apiVersionUnversioned {
 			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		} else {
 			notPanicked = true
@@ -174,8 +792,8 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	notPanicked = true
 }
 
-// CheckLauncherVersion checks if the r was made by NorthstarLauncher and if it
-// is at least MinimumLauncherVersion.
+// CheckLauncherVersion checks if the r was made by NorthstarLauncher, if it
+// is at least MinimumLauncherVersion, and if it isn't blocked.
 func (h *Handler) CheckLauncherVersion(r *http.Request, client bool) bool {
 	rver, _, _ := strings.Cut(r.Header.Get("User-Agent"), " ")
 	if x := strings.TrimPrefix(rver, "R2Northstar/"); rver != x {
@@ -188,25 +806,30 @@ func (h *Handler) CheckLauncherVersion(r *http.Request, client bool) bool {
 		h.m().versiongate_checks_total.reject_notns.Inc()
 		return false // deny: not R2Northstar
 	}
+	return h.checkLauncherVersion(r, rver, client)
+}
+
+// checkLauncherVersion is like CheckLauncherVersion, but checks the
+// v-prefixed launcher version rver. The request is only used for logging, and
+// may be nil.
+func (h *Handler) checkLauncherVersion(r *http.Request, rver string, client bool) bool {
+	g := h.versionGate(r)
 
 	var mver string
 	if client {
-		mver = h.MinimumLauncherVersionClient
+		mver = g.MinimumClient
 	} else {
-		mver = h.MinimumLauncherVersionServer
+		mver = g.MinimumServer
 	}
-	if mver != "" {
-		if mver[0] != 'v' {
-			mver = "v" + mver
+	if mver = normalizeLauncherVersion(mver); mver != "" && !semver.IsValid(mver) {
+		if r != nil {
+			hlog.FromRequest(r).Warn().Msgf("not checking invalid minimum version %q", mver)
 		}
-	} else {
-		h.m().versiongate_checks_total.success_ok.Inc()
-		return true // allow: no minimum version
+		mver = "" // allow: invalid minimum version
 	}
-	if !semver.IsValid(mver) {
-		hlog.FromRequest(r).Warn().Msgf("not checking invalid minimum version %q", mver)
+	if mver == "" && len(g.Blocked) == 0 {
 		h.m().versiongate_checks_total.success_ok.Inc()
-		return true // allow: invalid minimum version
+		return true // allow: no minimum version or blocked versions
 	}
 
 	if strings.HasSuffix(rver, "+dev") {
@@ -218,7 +841,14 @@ func (h *Handler) CheckLauncherVersion(r *http.Request, client bool) bool {
 		return false // deny: invalid version
 	}
 
-	if semver.Compare(rver, mver) < 0 {
+	for _, b := range g.Blocked {
+		if matchLauncherVersion(rver, b) {
+			h.m().versiongate_checks_total.reject_blocked.Inc()
+			return false // deny: blocked
+		}
+	}
+
+	if mver != "" && semver.Compare(rver, mver) < 0 {
 		h.m().versiongate_checks_total.reject_old.Inc()
 		return false // deny: too old
 	}
@@ -272,21 +902,68 @@ func (h *Handler) geoCounter2(r *http.Request, ctr *metricsx.GeoCounter2) {
 	}
 }
 
-// respFail writes a {success:false,error:ErrorObj} response with the provided
-// response status.
+// respFail writes a {success:false,error:ErrorObj,request_id:string} response
+// with the provided response status. All API errors should be written using
+// it (or respFailExtra/respError) so clients can rely on a single format.
 func respFail(w http.ResponseWriter, r *http.Request, status int, obj ErrorObj) {
+	respFailExtra(w, r, status, obj, nil)
+}
+
+// respError is like respFail, but uses the status and error object mapped from
+// err by errorResponse.
+func respError(w http.ResponseWriter, r *http.Request, err error) {
+	status, obj := errorResponse(err)
+	respFail(w, r, status, obj)
+}
+
+// respFailExtra is like respFail, but also includes additional top-level
+// fields in the response.
+func respFailExtra(w http.ResponseWriter, r *http.Request, status int, obj ErrorObj, extra map[string]any) {
+	obj = localizeError(w, r, obj)
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		obj.Retryable = true
+	}
+	m := make(map[string]any, len(extra)+3)
+	for k, v := range extra {
+		m[k] = v
+	}
+	m["success"] = false
+	m["error"] = obj
 	if rid, ok := hlog.IDFromRequest(r); ok {
-		respJSON(w, r, status, map[string]any{
-			"success":    false,
-			"error":      obj,
-			"request_id": rid.String(),
-		})
-	} else {
-		respJSON(w, r, status, map[string]any{
-			"success": false,
-			"error":   obj,
-		})
+		m["request_id"] = rid.String()
 	}
+	respJSON(w, r, status, m)
+}
+
+// respJSONPool contains buffers for respJSON, which is called for almost every
+// request (including game server heartbeats).
+var respJSONPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// contentTypeJSON is the Content-Type header value for JSON responses. It is
+// assigned directly to the header map to avoid allocating a new slice for every
+// response, so it must not be modified.
+var contentTypeJSON = []string{"application/json; charset=utf-8"}
+
+// noCacheHeaders are the header values set by setNoCacheHeaders. They must not
+// be modified.
+var (
+	noCacheHeaderCacheControl = []string{"private, no-cache, no-store"}
+	noCacheHeaderExpires      = []string{"0"}
+	noCacheHeaderPragma       = []string{"no-cache"}
+)
+
+// setNoCacheHeaders sets the headers to prevent caching of the response. It is
+// equivalent to setting them with Header.Set, but doesn't allocate.
+func setNoCacheHeaders(w http.ResponseWriter) {
+	hdr := w.Header()
+	hdr["Cache-Control"] = noCacheHeaderCacheControl
+	hdr["Expires"] = noCacheHeaderExpires
+	hdr["Pragma"] = noCacheHeaderPragma
 }
 
 // respJSON writes the JSON encoding of obj with the provided response status.
@@ -295,13 +972,23 @@ func respJSON(w http.ResponseWriter, r *http.Request, status int, obj any) {
 		w.WriteHeader(status)
 		return
 	}
-	buf, err := json.Marshal(obj)
-	if err != nil {
+	b := respJSONPool.Get().(*bytes.Buffer)
+	defer func() {
+		if b.Cap() <= 64<<10 { // don't keep large buffers around
+			respJSONPool.Put(b)
+		}
+	}()
+	b.Reset()
+
+	// note: this is equivalent to json.Marshal, but with a trailing newline
+	if err := json.NewEncoder(b).Encode(obj); err != nil {
 		panic(err)
 	}
-	hlog.FromRequest(r).Trace().Msgf("json api response %.2048s", string(buf))
-	buf = append(buf, '\n')
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	buf := b.Bytes()
+	if e := hlog.FromRequest(r).Trace(); e.Enabled() {
+		e.Msgf("json api response %.2048s", string(bytes.TrimSuffix(buf, []byte{'\n'})))
+	}
+	w.Header()["Content-Type"] = contentTypeJSON
 	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
 	w.WriteHeader(status)
 	w.Write(buf)
@@ -323,7 +1010,9 @@ func respMaybeCompress(w http.ResponseWriter, r *http.Request, status int, buf [
 			if cbuf.Len() < int(float64(len(buf))*0.8) {
 				buf = cbuf.Bytes()
 				w.Header().Set("Content-Encoding", "gzip")
-				w.Header().Del("ETag") // to avoid breaking caching proxies since ETag must be unique if Content-Encoding is different
+				if !strings.HasPrefix(w.Header().Get("ETag"), "W/") {
+					w.Header().Del("ETag") // to avoid breaking caching proxies since strong ETags must be unique if Content-Encoding is different
+				}
 			}
 			break
 		}
@@ -335,6 +1024,89 @@ func respMaybeCompress(w http.ResponseWriter, r *http.Request, status int, buf [
 	}
 }
 
+// etagMatch checks if the If-None-Match header value inm matches etag using
+// weak comparison.
+func etagMatch(inm, etag string) bool {
+	if inm = strings.TrimSpace(inm); inm == "" {
+		return false
+	}
+	if inm == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(inm, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(v), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateContentType chooses the offered media type best matching the Accept
+// header of r, preferring earlier offers when equally acceptable. If the header
+// is missing or nothing matches, the first offer is returned. Aliases for an
+// offer may be specified after it, separated by spaces.
+func negotiateContentType(r *http.Request, offers ...string) string {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return offers[0]
+	}
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		var q float64
+		for _, typ := range strings.Fields(offer) {
+			if x := acceptQuality(accept, typ); x > q {
+				q = x
+			}
+		}
+		if q > bestQ {
+			best, _, _ = strings.Cut(offer, " ")
+			bestQ = q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value of the most specific Accept media range
+// matching typ.
+func acceptQuality(accept []string, typ string) float64 {
+	major, _, _ := strings.Cut(typ, "/")
+
+	var q float64
+	specificity := -1
+	for _, a := range accept {
+		for _, rng := range strings.Split(a, ",") {
+			mt, params, _ := strings.Cut(rng, ";")
+			mt = strings.ToLower(strings.TrimSpace(mt))
+
+			var s int
+			switch {
+			case mt == typ:
+				s = 2
+			case mt == major+"/*":
+				s = 1
+			case mt == "*/*":
+				s = 0
+			default:
+				continue
+			}
+			if s <= specificity {
+				continue
+			}
+			x := 1.0
+			for _, p := range strings.Split(params, ";") {
+				if k, v, ok := strings.Cut(p, "="); ok && strings.TrimSpace(k) == "q" {
+					if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && f >= 0 && f <= 1 {
+						x = f
+					}
+				}
+			}
+			q, specificity = x, s
+		}
+	}
+	return q
+}
+
 // cryptoRandHex gets a string of random hex digits with length n.
 func cryptoRandHex(n int) (string, error) {
 	b := make([]byte, (n+1)/2) // round up
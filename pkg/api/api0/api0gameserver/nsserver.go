@@ -12,6 +12,8 @@ import (
 	"net/netip"
 	"net/url"
 	"strconv"
+
+	"github.com/r2northstar/atlas/pkg/trace"
 )
 
 var (
@@ -67,8 +69,15 @@ func Verify(ctx context.Context, auth netip.AddrPort) error {
 // AuthenticateIncomingPlayer checks if a player can connect to a game server,
 // registers a one-time connection token, and sends the player's pdata. If the
 // authentication request returns invalid JSON, err is ErrInvalidResponse. If
-// the authentication response .success is false, err is ErrAuthFailed.
-func AuthenticateIncomingPlayer(ctx context.Context, auth netip.AddrPort, uid uint64, username, connToken, serverToken string, pdata []byte) error {
+// the authentication response .success is false, err is ErrAuthFailed. If
+// preauth is not empty, it is sent in the PreauthHeader.
+func AuthenticateIncomingPlayer(ctx context.Context, auth netip.AddrPort, uid uint64, username, connToken, serverToken, preauth string, pdata []byte) (err error) {
+	ctx, span := trace.StartClient(ctx, "gameserver.AuthenticateIncomingPlayer", trace.String("server.address", auth.String()))
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
 	u := "http://" + auth.String() + "/authenticate_incoming_player" +
 		"?id=" + strconv.FormatUint(uid, 10) +
 		"&authToken=" + url.QueryEscape(connToken) +
@@ -80,6 +89,9 @@ func AuthenticateIncomingPlayer(ctx context.Context, auth netip.AddrPort, uid ui
 		return err // shouldn't happen
 	}
 	req.Header.Set("User-Agent", "Atlas")
+	if preauth != "" {
+		req.Header.Set(PreauthHeader, preauth)
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
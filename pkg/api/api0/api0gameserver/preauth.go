@@ -0,0 +1,79 @@
+package api0gameserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// PreauthHeader is the header containing the signed pre-authorization sent
+// with /authenticate_incoming_player to servers registered with a signing key.
+// For UDP auth, it is sent as the "preauth" field of the connect request.
+const PreauthHeader = "X-Atlas-Preauth"
+
+var (
+	ErrPreauthInvalid = errors.New("invalid preauth")
+	ErrPreauthExpired = errors.New("preauth expired")
+)
+
+// Preauth is a notification from the master server that a player has been
+// authenticated to connect to a server. Since it is signed with the server's
+// signing key, game servers can verify it locally with VerifyPreauth without
+// trusting the connection it was received over.
+type Preauth struct {
+	ServerID string     `json:"server"`
+	UID      uint64     `json:"uid,string"`
+	Username string     `json:"username"`
+	Token    string     `json:"token"` // the connection token
+	IP       netip.Addr `json:"ip"`    // the ip the player authenticated from
+	Expires  int64      `json:"exp"`   // unix time
+}
+
+// SignPreauth encodes and signs p with the server's signing key, returning it
+// as "<base64url json>.<hex hmac-sha256>".
+func SignPreauth(key string, p Preauth) string {
+	buf, err := json.Marshal(p)
+	if err != nil {
+		panic(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(buf)
+	return payload + "." + hex.EncodeToString(preauthMAC(key, payload))
+}
+
+// VerifyPreauth checks the signature and expiry of a preauth signed by
+// SignPreauth, returning the decoded preauth.
+func VerifyPreauth(key, s string, now time.Time) (Preauth, error) {
+	var p Preauth
+	payload, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return p, ErrPreauthInvalid
+	}
+	mac, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, preauthMAC(key, payload)) {
+		return p, ErrPreauthInvalid
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return p, ErrPreauthInvalid
+	}
+	if err := json.Unmarshal(buf, &p); err != nil {
+		return p, ErrPreauthInvalid
+	}
+	if now.Unix() > p.Expires {
+		return p, ErrPreauthExpired
+	}
+	return p, nil
+}
+
+func preauthMAC(key, payload string) []byte {
+	m := hmac.New(sha256.New, []byte(key))
+	m.Write([]byte("atlas-preauth-v1\n"))
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
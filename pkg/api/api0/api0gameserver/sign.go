@@ -0,0 +1,41 @@
+package api0gameserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers for signed game server requests to the master server.
+const (
+	SignatureHeader          = "X-Atlas-Signature"
+	SignatureTimestampHeader = "X-Atlas-Timestamp"
+)
+
+// RequestSignature computes the hex-encoded HMAC-SHA256 signature of a game
+// server request to the master server using the signing key returned by
+// add_server. The path is the endpoint path (e.g., /server/heartbeat) without
+// any tenant prefix or API version, and rawQuery is the query string exactly
+// as sent.
+func RequestSignature(key, method, path, rawQuery string, ts int64, body []byte) string {
+	bh := sha256.Sum256(body)
+	m := hmac.New(sha256.New, []byte(key))
+	m.Write([]byte("atlas-server-v1\n"))
+	m.Write([]byte(method + "\n"))
+	m.Write([]byte(path + "\n"))
+	m.Write([]byte(rawQuery + "\n"))
+	m.Write([]byte(strconv.FormatInt(ts, 10) + "\n"))
+	m.Write([]byte(hex.EncodeToString(bh[:])))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// SignRequest sets the signature headers on req, which must have the provided
+// body and endpoint path (see RequestSignature).
+func SignRequest(req *http.Request, key, path string, body []byte, t time.Time) {
+	ts := t.Unix()
+	req.Header.Set(SignatureTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(SignatureHeader, RequestSignature(key, req.Method, path, req.URL.RawQuery, ts, body))
+}
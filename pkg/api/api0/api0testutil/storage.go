@@ -2,6 +2,7 @@ package api0testutil
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"math"
 	"math/rand"
@@ -170,6 +171,29 @@ func TestAccountStorage(t *testing.T, s api0.AccountStorage) {
 				t.Fatalf("uids should be empty")
 			}
 		})
+		t.Run("Delete", func(t *testing.T) {
+			if err := s.DeleteAccount(uid1); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := s.DeleteAccount(uid1); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if acct, err := s.GetAccount(uid1); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if acct != nil {
+				t.Fatalf("account should not exist")
+			}
+			if u, err := s.GetUIDsByUsername(act1.Username); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if len(u) != 0 {
+				t.Fatalf("deleted account should not be returned by username")
+			}
+			if acct, err := s.GetAccount(uid0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if acct == nil {
+				t.Fatalf("other account should still exist")
+			}
+		})
 	}
 
 	// test that it still functions properly with large numbers of users and
@@ -236,7 +260,17 @@ func TestAccountStorage(t *testing.T, s api0.AccountStorage) {
 				uacct.AuthIP = netip.MustParseAddr("127.0.0.1")
 				uacct.AuthToken = "dummy"
 				uacct.AuthTokenExpiry = time.Now().Add(time.Minute * 30).Truncate(time.Second)
+				uacct.AuthStaleVerified = rand.Intn(2) == 0
+				uacct.AuthIPFlags = api0.IPReputation(rand.Intn(8))
+				uacct.OtherSessions = []api0.AccountSession{{
+					IP:            netip.MustParseAddr("127.0.0.2"),
+					Token:         "dummy2",
+					Expiry:        time.Now().Add(time.Minute * 20).Truncate(time.Second),
+					StaleVerified: true,
+					IPFlags:       api0.IPReputationHosting | api0.IPReputationVPN,
+				}}
 				uacct.LastServerID = "self"
+				uacct.VerifiedAt = time.Now().Add(-time.Hour).Truncate(time.Second)
 
 				// update the account
 				if err := s.SaveAccount(uacct); err != nil {
@@ -504,6 +538,24 @@ func TestPdataStorage(t *testing.T, s api0.PdataStorage) {
 				})
 			}
 		})
+		t.Run("DeleteUser1", func(t *testing.T) {
+			if err := s.DeletePdata(user1); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := s.DeletePdata(user1); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, exists, err := s.GetPdataHash(user1); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if exists {
+				t.Fatalf("exists should be false")
+			}
+			if buf, exists, err := s.GetPdataCached(user1, zeroSHA); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if exists || buf != nil {
+				t.Fatalf("pdata should not exist")
+			}
+		})
 	}
 
 	// test that it still functions properly with large numbers of users and
@@ -634,6 +686,932 @@ func TestPdataStorage(t *testing.T, s api0.PdataStorage) {
 	})
 }
 
+// TestAccountLinkStorage tests whether an EMPTY account link storage instance
+// implements the interface correctly.
+func TestAccountLinkStorage(t *testing.T, s api0.AccountLinkStorage) {
+	uid0 := uint64(999999)
+	uid1 := uint64(math.MaxUint64 >> 1)
+	lnk0 := &api0.AccountLink{
+		UID:          uid0,
+		Provider:     api0.AccountLinkProviderDiscord,
+		ExternalID:   "80351110224678912",
+		ExternalName: "nelly",
+		VerifiedAt:   time.Now().Truncate(time.Second),
+	}
+	lnk1 := &api0.AccountLink{
+		UID:        uid0,
+		Provider:   api0.AccountLinkProviderSteam,
+		ExternalID: "76561197960287930",
+		VerifiedAt: time.Now().Truncate(time.Second),
+	}
+	lnk2 := &api0.AccountLink{
+		UID:        uid1,
+		Provider:   api0.AccountLinkProviderDiscord,
+		ExternalID: "80351110224678913",
+		VerifiedAt: time.Now().Truncate(time.Second),
+	}
+	sortLinks := func(ls []api0.AccountLink) []api0.AccountLink {
+		sort.Slice(ls, func(i, j int) bool {
+			return ls[i].Provider < ls[j].Provider
+		})
+		return ls
+	}
+	t.Run("GetNonexistent", func(t *testing.T) {
+		ls, err := s.GetAccountLinks(uid0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(ls) != 0 {
+			t.Fatalf("expected no links, got %d", len(ls))
+		}
+		if _, exists, err := s.GetUIDByAccountLink(lnk0.Provider, lnk0.ExternalID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if exists {
+			t.Fatalf("link should not exist")
+		}
+	})
+	t.Run("SaveNew", func(t *testing.T) {
+		for _, l := range []*api0.AccountLink{lnk0, lnk1, lnk2} {
+			if err := s.SaveAccountLink(l); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	})
+	t.Run("Get", func(t *testing.T) {
+		ls, err := s.GetAccountLinks(uid0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(sortLinks(ls), []api0.AccountLink{*lnk0, *lnk1}) {
+			t.Fatalf("incorrect links: %#v", ls)
+		}
+		ls, err = s.GetAccountLinks(uid1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(ls, []api0.AccountLink{*lnk2}) {
+			t.Fatalf("incorrect links: %#v", ls)
+		}
+	})
+	t.Run("GetUID", func(t *testing.T) {
+		for _, l := range []*api0.AccountLink{lnk0, lnk1, lnk2} {
+			uid, exists, err := s.GetUIDByAccountLink(l.Provider, l.ExternalID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !exists || uid != l.UID {
+				t.Fatalf("expected uid %d, got %d (exists=%t)", l.UID, uid, exists)
+			}
+		}
+		if _, exists, err := s.GetUIDByAccountLink(api0.AccountLinkProviderSteam, lnk0.ExternalID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if exists {
+			t.Fatalf("external ids must be scoped to the provider")
+		}
+	})
+	t.Run("Update", func(t *testing.T) {
+		lnk0.ExternalID = "80351110224678914"
+		lnk0.ExternalName = "nelly2"
+		if err := s.SaveAccountLink(lnk0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, exists, err := s.GetUIDByAccountLink(lnk0.Provider, "80351110224678912"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if exists {
+			t.Fatalf("old external id should no longer be linked")
+		}
+		ls, err := s.GetAccountLinks(uid0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(sortLinks(ls), []api0.AccountLink{*lnk0, *lnk1}) {
+			t.Fatalf("incorrect links: %#v", ls)
+		}
+	})
+	t.Run("Steal", func(t *testing.T) {
+		lnk2.ExternalID = lnk0.ExternalID
+		if err := s.SaveAccountLink(lnk2); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if uid, exists, err := s.GetUIDByAccountLink(lnk2.Provider, lnk2.ExternalID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !exists || uid != uid1 {
+			t.Fatalf("external account should be linked to the new uid")
+		}
+		ls, err := s.GetAccountLinks(uid0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(ls, []api0.AccountLink{*lnk1}) {
+			t.Fatalf("incorrect links: %#v", ls)
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		if err := s.DeleteAccountLink(uid0, api0.AccountLinkProviderSteam); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.DeleteAccountLink(uid0, api0.AccountLinkProviderSteam); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ls, err := s.GetAccountLinks(uid0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(ls) != 0 {
+			t.Fatalf("expected no links, got %d", len(ls))
+		}
+		if _, exists, err := s.GetUIDByAccountLink(lnk1.Provider, lnk1.ExternalID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if exists {
+			t.Fatalf("link should not exist")
+		}
+	})
+	t.Run("StressSteal", func(t *testing.T) {
+		// many accounts racing to link the same external account must result
+		// in exactly one of them being linked
+		var (
+			concurrency int    = 16
+			uids        uint64 = 256
+		)
+		if runtime.GOOS != "linux" {
+			concurrency /= 4
+			uids /= 4
+		}
+		var wg sync.WaitGroup
+		var fail atomic.Int32
+		sem := make(chan struct{}, concurrency)
+		for uid := uint64(1000); uid < 1000+uids; uid++ {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(uid uint64) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				randSched()
+				if err := s.SaveAccountLink(&api0.AccountLink{
+					UID:          uid,
+					Provider:     api0.AccountLinkProviderDiscord,
+					ExternalID:   "stress",
+					ExternalName: strconv.FormatUint(uid, 10),
+					VerifiedAt:   time.Unix(1000, 0),
+				}); err != nil {
+					t.Logf("error: %v", err)
+					fail.Store(1)
+				}
+			}(uid)
+		}
+		wg.Wait()
+		if fail.Load() != 0 {
+			t.FailNow()
+		}
+
+		owner, exists, err := s.GetUIDByAccountLink(api0.AccountLinkProviderDiscord, "stress")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !exists {
+			t.Fatalf("external account should be linked")
+		}
+		var n int
+		for uid := uint64(1000); uid < 1000+uids; uid++ {
+			ls, err := s.GetAccountLinks(uid)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, l := range ls {
+				if l.Provider == api0.AccountLinkProviderDiscord {
+					if l.UID != owner || l.ExternalName != strconv.FormatUint(owner, 10) {
+						t.Errorf("external account linked to uid %d, but reverse lookup returned %d", l.UID, owner)
+					}
+					n++
+				}
+			}
+		}
+		if n != 1 {
+			t.Fatalf("expected external account to be linked to exactly one uid, got %d", n)
+		}
+	})
+}
+
+// TestUsernameHistoryStorage tests whether an EMPTY username history storage
+// instance implements the interface correctly.
+func TestUsernameHistoryStorage(t *testing.T, s api0.UsernameHistoryStorage) {
+	uid0 := uint64(999999)
+	uid1 := uint64(math.MaxUint64 >> 1)
+	now := time.Now().Truncate(time.Millisecond)
+	chg0 := &api0.UsernameChange{
+		UID:  uid0,
+		Time: now.Add(-time.Hour * 2),
+		From: "Alice",
+		To:   "Bob",
+	}
+	chg1 := &api0.UsernameChange{
+		UID:  uid0,
+		Time: now.Add(-time.Hour),
+		From: "Bob",
+		To:   "Carol",
+	}
+	chg2 := &api0.UsernameChange{
+		UID:  uid1,
+		Time: now,
+		From: "Dave",
+		To:   "bob",
+	}
+	sortUIDs := func(u []uint64) []uint64 {
+		sort.Slice(u, func(i, j int) bool {
+			return u[i] < u[j]
+		})
+		return u
+	}
+	t.Run("GetNonexistent", func(t *testing.T) {
+		cs, err := s.GetUsernameHistory(uid0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(cs) != 0 {
+			t.Fatalf("expected no username changes")
+		}
+		uids, err := s.GetUIDsByPastUsername("Bob")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(uids) != 0 {
+			t.Fatalf("expected no uids")
+		}
+	})
+	t.Run("Save", func(t *testing.T) {
+		for _, c := range []*api0.UsernameChange{chg1, chg0, chg2} {
+			if err := s.SaveUsernameChange(c); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if cs, err := s.GetUsernameHistory(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(cs) != 2 {
+			t.Fatalf("expected 2 username changes, got %d", len(cs))
+		} else if !cs[0].Time.Equal(chg0.Time) || cs[0].UID != chg0.UID || cs[0].From != chg0.From || cs[0].To != chg0.To {
+			t.Fatalf("incorrect username change (or not sorted by time): %+v", cs[0])
+		} else if !cs[1].Time.Equal(chg1.Time) || cs[1].UID != chg1.UID || cs[1].From != chg1.From || cs[1].To != chg1.To {
+			t.Fatalf("incorrect username change (or not sorted by time): %+v", cs[1])
+		}
+		if uids, err := s.GetUIDsByPastUsername("BOB"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(sortUIDs(uids), []uint64{uid0, uid1}) {
+			t.Fatalf("incorrect uids for username (must be case insensitive): %v", uids)
+		}
+		if uids, err := s.GetUIDsByPastUsername("alice"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(uids, []uint64{uid0}) {
+			t.Fatalf("incorrect uids for username: %v", uids)
+		}
+		if uids, err := s.GetUIDsByPastUsername(""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(uids) != 0 {
+			t.Fatalf("expected no uids for empty username")
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		if err := s.DeleteUsernameHistory(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.DeleteUsernameHistory(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cs, err := s.GetUsernameHistory(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(cs) != 0 {
+			t.Fatalf("expected no username changes")
+		}
+		if uids, err := s.GetUIDsByPastUsername("bob"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(uids, []uint64{uid1}) {
+			t.Fatalf("incorrect uids for username: %v", uids)
+		}
+	})
+}
+
+// TestAccountSignalStorage tests whether an EMPTY account signal storage
+// instance implements the interface correctly.
+func TestAccountSignalStorage(t *testing.T, s api0.AccountSignalStorage) {
+	uid0 := uint64(999999)
+	uid1 := uint64(math.MaxUint64 >> 1)
+	now := time.Now().Truncate(time.Second)
+	sortUIDs := func(u []uint64) []uint64 {
+		sort.Slice(u, func(i, j int) bool {
+			return u[i] < u[j]
+		})
+		return u
+	}
+	t.Run("GetNonexistent", func(t *testing.T) {
+		if ss, err := s.GetAccountSignals(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(ss) != 0 {
+			t.Fatalf("expected no signals")
+		}
+		if uids, err := s.GetUIDsByAccountSignal(api0.AccountSignalIP, "a", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(uids) != 0 {
+			t.Fatalf("expected no uids")
+		}
+	})
+	t.Run("Save", func(t *testing.T) {
+		for _, x := range []api0.AccountSignal{
+			{UID: uid0, Kind: api0.AccountSignalIP, Hash: "a", FirstSeen: now.Add(-time.Hour * 3), LastSeen: now.Add(-time.Hour * 3)},
+			{UID: uid0, Kind: api0.AccountSignalIP, Hash: "a", FirstSeen: now.Add(-time.Hour), LastSeen: now.Add(-time.Hour)},
+			{UID: uid0, Kind: api0.AccountSignalHint, Hash: "a", FirstSeen: now.Add(-time.Hour * 2), LastSeen: now.Add(-time.Hour * 2)},
+			{UID: uid1, Kind: api0.AccountSignalIP, Hash: "a", FirstSeen: now, LastSeen: now},
+			{UID: uid1, Kind: api0.AccountSignalIP, Hash: "b", FirstSeen: now, LastSeen: now},
+		} {
+			if err := s.SaveAccountSignal(&x); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if ss, err := s.GetAccountSignals(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(ss) != 2 {
+			t.Fatalf("expected 2 signals, got %d", len(ss))
+		} else {
+			sort.Slice(ss, func(i, j int) bool {
+				return ss[i].Kind > ss[j].Kind
+			})
+			if ss[0].Kind != api0.AccountSignalIP || ss[0].Hash != "a" || ss[0].UID != uid0 {
+				t.Fatalf("incorrect signal %+v", ss[0])
+			}
+			if !ss[0].FirstSeen.Equal(now.Add(-time.Hour*3)) || !ss[0].LastSeen.Equal(now.Add(-time.Hour)) {
+				t.Fatalf("incorrect signal times (should keep first seen and update last seen): %+v", ss[0])
+			}
+			if ss[1].Kind != api0.AccountSignalHint || ss[1].Hash != "a" || ss[1].UID != uid0 {
+				t.Fatalf("incorrect signal %+v", ss[1])
+			}
+		}
+		if uids, err := s.GetUIDsByAccountSignal(api0.AccountSignalIP, "a", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(sortUIDs(uids), []uint64{uid0, uid1}) {
+			t.Fatalf("incorrect uids for signal: %v", uids)
+		}
+		if uids, err := s.GetUIDsByAccountSignal(api0.AccountSignalIP, "a", 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(uids) != 1 {
+			t.Fatalf("expected limit to be respected, got %v", uids)
+		}
+		if uids, err := s.GetUIDsByAccountSignal(api0.AccountSignalHint, "a", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(uids, []uint64{uid0}) {
+			t.Fatalf("incorrect uids for signal (kind must match): %v", uids)
+		}
+	})
+	t.Run("DeleteBefore", func(t *testing.T) {
+		if err := s.DeleteAccountSignalsBefore(now.Add(-time.Hour).Add(-time.Minute)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ss, err := s.GetAccountSignals(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(ss) != 1 || ss[0].Kind != api0.AccountSignalIP {
+			t.Fatalf("expected only the recently seen signal to remain, got %+v", ss)
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		if err := s.DeleteAccountSignals(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.DeleteAccountSignals(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ss, err := s.GetAccountSignals(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(ss) != 0 {
+			t.Fatalf("expected no signals")
+		}
+		if uids, err := s.GetUIDsByAccountSignal(api0.AccountSignalIP, "a", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(uids, []uint64{uid1}) {
+			t.Fatalf("incorrect uids for signal: %v", uids)
+		}
+	})
+}
+
+// TestBanListStorage tests whether an EMPTY ban list storage instance
+// implements the interface correctly.
+func TestBanListStorage(t *testing.T, s api0.BanListStorage) {
+	uid0 := uint64(999999)
+	uid1 := uint64(math.MaxUint64 >> 1)
+	now := time.Now().Truncate(time.Millisecond)
+	get := func(t *testing.T, list string) []api0.BanListEntry {
+		es, err := s.GetBanListEntries(list)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sort.Slice(es, func(i, j int) bool {
+			if es[i].UID != es[j].UID {
+				return es[i].UID < es[j].UID
+			}
+			return es[i].Source < es[j].Source
+		})
+		return es
+	}
+	t.Run("GetNonexistent", func(t *testing.T) {
+		if es := get(t, "a"); len(es) != 0 {
+			t.Fatalf("expected no entries")
+		}
+	})
+	t.Run("Save", func(t *testing.T) {
+		for _, x := range []api0.BanListEntry{
+			{List: "a", UID: uid0, Source: "x", Reason: "cheating", Created: now.Add(-time.Hour), Updated: now.Add(-time.Hour)},
+			{List: "a", UID: uid0, Source: "y", Created: now.Add(-time.Minute), Updated: now.Add(-time.Minute)},
+			{List: "a", UID: uid1, Source: "x", Created: now, Updated: now},
+			{List: "b", UID: uid0, Source: "x", Created: now, Updated: now},
+			{List: "a", UID: uid0, Source: "x", Reason: "unbanned", Revoked: true, Created: now, Updated: now},
+		} {
+			if err := s.SaveBanListEntry(&x); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		es := get(t, "a")
+		if len(es) != 3 {
+			t.Fatalf("expected 3 entries, got %d", len(es))
+		}
+		if e := es[0]; e.List != "a" || e.UID != uid0 || e.Source != "x" || e.Reason != "unbanned" || !e.Revoked {
+			t.Fatalf("incorrect entry (should be replaced) %+v", e)
+		} else if !e.Created.Equal(now.Add(-time.Hour)) || !e.Updated.Equal(now) {
+			t.Fatalf("incorrect entry times (should keep created and update updated): %+v", e)
+		}
+		if e := es[1]; e.UID != uid0 || e.Source != "y" || e.Reason != "" || e.Revoked {
+			t.Fatalf("incorrect entry %+v", e)
+		}
+		if e := es[2]; e.UID != uid1 || e.Source != "x" {
+			t.Fatalf("incorrect entry %+v", e)
+		}
+		if es := get(t, "b"); len(es) != 1 || es[0].List != "b" || es[0].UID != uid0 {
+			t.Fatalf("incorrect entries for list b: %+v", es)
+		}
+	})
+	t.Run("DeleteEntries", func(t *testing.T) {
+		if err := s.DeleteBanListEntries(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if es := get(t, "a"); len(es) != 1 || es[0].UID != uid1 {
+			t.Fatalf("expected only entries for other uids to remain, got %+v", es)
+		}
+		if es := get(t, "b"); len(es) != 0 {
+			t.Fatalf("expected entries in other lists to be deleted, got %+v", es)
+		}
+	})
+	t.Run("DeleteList", func(t *testing.T) {
+		if err := s.SaveBanListEntry(&api0.BanListEntry{List: "b", UID: uid1, Source: "x", Created: now, Updated: now}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.DeleteBanList("a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if es := get(t, "a"); len(es) != 0 {
+			t.Fatalf("expected no entries")
+		}
+		if es := get(t, "b"); len(es) != 1 {
+			t.Fatalf("expected entries in other lists to remain, got %+v", es)
+		}
+	})
+}
+
+// TestAbuseReportStorage tests whether an EMPTY abuse report storage instance
+// implements the interface correctly.
+func TestAbuseReportStorage(t *testing.T, s api0.AbuseReportStorage) {
+	uid0 := uint64(999999)
+	uid1 := uint64(math.MaxUint64 >> 1)
+	uid2 := uint64(1234)
+	now := time.Now().Truncate(time.Millisecond)
+	ids := func(rs []api0.AbuseReport) []string {
+		var x []string
+		for _, r := range rs {
+			x = append(x, r.ID)
+		}
+		return x
+	}
+	t.Run("GetNonexistent", func(t *testing.T) {
+		if r, err := s.GetAbuseReport("a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if r != nil {
+			t.Fatalf("expected no report")
+		}
+		if rs, err := s.GetAbuseReports("", 0, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(rs) != 0 {
+			t.Fatalf("expected no reports")
+		}
+	})
+	t.Run("Save", func(t *testing.T) {
+		r := api0.AbuseReport{
+			ID:         "a",
+			Time:       now.Add(-time.Hour),
+			ServerID:   "server",
+			ServerName: "Server",
+			ServerAddr: netip.MustParseAddrPort("127.0.0.1:37015"),
+			Reporter:   uid0,
+			Reported:   uid1,
+			Reason:     "cheating",
+			Evidence:   "evidence",
+			Map:        "mp_glitch",
+			Playlist:   "ps",
+			Context:    map[string]string{"round": "2"},
+			Status:     api0.AbuseReportOpen,
+		}
+		for _, x := range []api0.AbuseReport{
+			r,
+			{ID: "b", Time: now, Reporter: uid0, Reported: uid2, Status: api0.AbuseReportOpen},
+			{ID: "c", Time: now.Add(-time.Minute), Reporter: uid2, Reported: uid1, Status: api0.AbuseReportDismissed, Note: "no evidence"},
+		} {
+			if err := s.SaveAbuseReport(&x); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if x, err := s.GetAbuseReport("a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if x == nil {
+			t.Fatalf("expected report")
+		} else if !x.Time.Equal(r.Time) {
+			t.Fatalf("incorrect time: expected %s, got %s", r.Time, x.Time)
+		} else if x.Time = r.Time; !reflect.DeepEqual(*x, r) {
+			t.Fatalf("incorrect report: expected %+v, got %+v", r, *x)
+		}
+		if rs, err := s.GetAbuseReports("", 0, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(ids(rs), []string{"a", "c", "b"}) {
+			t.Fatalf("incorrect reports (should be oldest first): %v", ids(rs))
+		}
+		if rs, err := s.GetAbuseReports(api0.AbuseReportOpen, 0, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(ids(rs), []string{"a", "b"}) {
+			t.Fatalf("incorrect open reports: %v", ids(rs))
+		}
+		if rs, err := s.GetAbuseReports(api0.AbuseReportOpen, 0, 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(ids(rs), []string{"a"}) {
+			t.Fatalf("expected limit to be respected, got %v", ids(rs))
+		}
+		if rs, err := s.GetAbuseReports("", uid1, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(ids(rs), []string{"a", "c"}) {
+			t.Fatalf("incorrect reports against uid: %v", ids(rs))
+		}
+	})
+	t.Run("Update", func(t *testing.T) {
+		r, err := s.GetAbuseReport("a")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r.Status = api0.AbuseReportResolved
+		r.Note = "banned"
+		if err := s.SaveAbuseReport(r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if x, err := s.GetAbuseReport("a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if x.Status != api0.AbuseReportResolved || x.Note != "banned" || x.Reason != "cheating" {
+			t.Fatalf("incorrect updated report %+v", x)
+		}
+		if rs, err := s.GetAbuseReports(api0.AbuseReportOpen, 0, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(ids(rs), []string{"b"}) {
+			t.Fatalf("incorrect open reports: %v", ids(rs))
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		if err := s.DeleteAbuseReports(uid2); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if rs, err := s.GetAbuseReports("", 0, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(ids(rs), []string{"a"}) {
+			t.Fatalf("expected reports by and against uid to be deleted, got %v", ids(rs))
+		}
+	})
+}
+
+// TestStateStorage tests whether an EMPTY state storage instance implements the
+// interface correctly.
+func TestStateStorage(t *testing.T, s api0.StateStorage) {
+	t.Run("GetNonexistent", func(t *testing.T) {
+		buf, exists, err := s.GetState("test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exists || buf != nil {
+			t.Fatalf("state should not exist")
+		}
+	})
+	t.Run("Set", func(t *testing.T) {
+		buf := seqBytes(64, 0)
+		if err := s.SetState("test", buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.SetState("test1", []byte{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		buf[0] = 0xFF
+		if b, exists, err := s.GetState("test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !exists {
+			t.Fatalf("state should exist")
+		} else if !bytes.Equal(b, seqBytes(64, 0)) {
+			t.Fatalf("incorrect data (must copy the data)")
+		} else {
+			b[1] = 0xFF
+		}
+		if b, exists, err := s.GetState("test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !exists || !bytes.Equal(b, seqBytes(64, 0)) {
+			t.Fatalf("state leaks internal buffers")
+		}
+		if b, exists, err := s.GetState("test1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !exists || len(b) != 0 {
+			t.Fatalf("empty state should exist")
+		}
+	})
+	t.Run("Update", func(t *testing.T) {
+		if err := s.SetState("test", seqBytes(32, 1)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if b, exists, err := s.GetState("test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !exists || !bytes.Equal(b, seqBytes(32, 1)) {
+			t.Fatalf("incorrect data")
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		if err := s.SetState("test", nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.SetState("test", nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, exists, err := s.GetState("test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if exists {
+			t.Fatalf("state should not exist")
+		}
+		if _, exists, err := s.GetState("test1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !exists {
+			t.Fatalf("other state should still exist")
+		}
+	})
+	t.Run("Stress", func(t *testing.T) {
+		// concurrent writes to the same key must not tear values, and writes
+		// to other keys must not interfere
+		concurrency := 16
+		if runtime.GOOS != "linux" {
+			concurrency /= 4
+		}
+		var wg sync.WaitGroup
+		var fail atomic.Int32
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := "stress" + strconv.Itoa(i)
+				for j := 0; j < 64; j++ {
+					randSched()
+					if err := s.SetState("stress", seqBytes(256, uint8(i))); err != nil {
+						t.Logf("error: %v", err)
+						fail.Store(1)
+						return
+					}
+					if err := s.SetState(key, seqBytes(j+1, uint8(j))); err != nil {
+						t.Logf("error: %v", err)
+						fail.Store(1)
+						return
+					}
+					randSched()
+					if b, exists, err := s.GetState("stress"); err != nil {
+						t.Logf("error: %v", err)
+						fail.Store(1)
+						return
+					} else if !exists || len(b) != 256 || !bytes.Equal(b, seqBytes(256, b[0])) {
+						t.Logf("torn value for shared key")
+						fail.Store(1)
+						return
+					}
+					if b, exists, err := s.GetState(key); err != nil {
+						t.Logf("error: %v", err)
+						fail.Store(1)
+						return
+					} else if !exists || !bytes.Equal(b, seqBytes(j+1, uint8(j))) {
+						t.Logf("incorrect value for key %q", key)
+						fail.Store(1)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		if fail.Load() != 0 {
+			t.FailNow()
+		}
+	})
+}
+
+func TestServerStatsStorage(t *testing.T, s api0.ServerStatsStorage) {
+	addr1 := netip.MustParseAddrPort("192.0.2.1:37015")
+	addr2 := netip.MustParseAddrPort("192.0.2.2:37015")
+	t0 := time.Unix(1672531200, 0)
+
+	t.Run("GetNonexistent", func(t *testing.T) {
+		bs, err := s.GetServerStats(addr1, time.Hour, time.Time{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(bs) != 0 {
+			t.Fatalf("stats should not exist")
+		}
+	})
+	t.Run("Save", func(t *testing.T) {
+		var bs []api0.ServerStatsBucket
+		for i := 0; i < 4; i++ {
+			bs = append(bs, api0.ServerStatsBucket{
+				Addr:       addr1,
+				Start:      t0.Add(time.Hour * time.Duration(3-i)),
+				Resolution: time.Hour,
+				Name:       "test" + strconv.Itoa(i),
+				Samples:    60,
+				PlayerSum:  60 * i,
+				PlayerMax:  i + 1,
+				MaxPlayers: 16,
+				Maps:       map[string]int{"mp_forwardbase_kodai": 30, "mp_thaw": 30},
+			})
+		}
+		bs = append(bs, api0.ServerStatsBucket{
+			Addr:       addr1,
+			Start:      t0,
+			Resolution: time.Minute * 5,
+			Name:       "test",
+			Samples:    5,
+		}, api0.ServerStatsBucket{
+			Addr:       addr2,
+			Start:      t0,
+			Resolution: time.Hour,
+			Name:       "other",
+			Samples:    1,
+		})
+		if err := s.SaveServerStats(bs); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		bs[3].Maps["mp_thaw"] = 0
+
+		x, err := s.GetServerStats(addr1, time.Hour, time.Time{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(x) != 4 {
+			t.Fatalf("expected 4 buckets, got %d", len(x))
+		}
+		for i, b := range x {
+			if !b.Start.Equal(t0.Add(time.Hour * time.Duration(i))) {
+				t.Fatalf("buckets not ordered by start time")
+			}
+			if b.Addr != addr1 || b.Resolution != time.Hour || b.Name != "test"+strconv.Itoa(3-i) || b.Samples != 60 || b.PlayerSum != 60*(3-i) || b.PlayerMax != 4-i || b.MaxPlayers != 16 {
+				t.Fatalf("incorrect bucket %d: %+v", i, b)
+			}
+			if !reflect.DeepEqual(b.Maps, map[string]int{"mp_forwardbase_kodai": 30, "mp_thaw": 30}) {
+				t.Fatalf("incorrect maps for bucket %d (must copy the maps): %v", i, b.Maps)
+			}
+		}
+		x[0].Maps["mp_thaw"] = 0
+
+		if x, err := s.GetServerStats(addr1, time.Hour, t0.Add(time.Hour*2)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(x) != 2 || !x[0].Start.Equal(t0.Add(time.Hour*2)) {
+			t.Fatalf("expected 2 buckets starting at since, got %d", len(x))
+		}
+		if x, err := s.GetServerStats(addr1, time.Hour, t0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if x[0].Maps["mp_thaw"] != 30 {
+			t.Fatalf("stats leak internal maps")
+		}
+		if x, err := s.GetServerStats(addr1, time.Minute*5, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(x) != 1 || x[0].Samples != 5 {
+			t.Fatalf("expected 1 bucket for resolution")
+		}
+		if x, err := s.GetServerStats(addr2, time.Hour, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(x) != 1 || x[0].Name != "other" {
+			t.Fatalf("expected 1 bucket for addr")
+		}
+	})
+	t.Run("Replace", func(t *testing.T) {
+		if err := s.SaveServerStats([]api0.ServerStatsBucket{{
+			Addr:       addr1,
+			Start:      t0,
+			Resolution: time.Hour,
+			Name:       "replaced",
+			Samples:    61,
+		}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		x, err := s.GetServerStats(addr1, time.Hour, t0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(x) != 4 {
+			t.Fatalf("expected 4 buckets, got %d", len(x))
+		}
+		if x[0].Name != "replaced" || x[0].Samples != 61 || x[0].PlayerMax != 0 || len(x[0].Maps) != 0 {
+			t.Fatalf("bucket not replaced: %+v", x[0])
+		}
+	})
+	t.Run("Totals", func(t *testing.T) {
+		if err := s.SaveServerStats([]api0.ServerStatsBucket{{
+			Start:      t0.Add(time.Hour * 3),
+			Resolution: time.Hour,
+			Samples:    60,
+			PlayerMax:  100,
+		}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if x, err := s.GetServerStats(netip.AddrPort{}, time.Hour, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(x) != 1 || x[0].PlayerMax != 100 || x[0].Addr.IsValid() {
+			t.Fatalf("expected 1 bucket for totals")
+		}
+		if x, err := s.GetServerStats(addr1, time.Hour, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(x) != 4 {
+			t.Fatalf("totals should not be returned for addr")
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		if n, err := s.DeleteServerStats(time.Hour, t0.Add(time.Hour*2)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if n != 3 {
+			t.Fatalf("expected 3 deleted buckets, got %d", n)
+		}
+		if x, err := s.GetServerStats(addr1, time.Hour, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(x) != 2 || !x[0].Start.Equal(t0.Add(time.Hour*2)) {
+			t.Fatalf("expected 2 remaining buckets, got %d", len(x))
+		}
+		if x, err := s.GetServerStats(addr2, time.Hour, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(x) != 0 {
+			t.Fatalf("expected bucket for other addr to be deleted")
+		}
+		if x, err := s.GetServerStats(addr1, time.Minute*5, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(x) != 1 {
+			t.Fatalf("expected bucket for other resolution to remain")
+		}
+	})
+	t.Run("Stress", func(t *testing.T) {
+		// concurrent batches must each be saved atomically
+		concurrency := 16
+		if runtime.GOOS != "linux" {
+			concurrency /= 4
+		}
+		addr := netip.MustParseAddrPort("192.0.2.3:37015")
+		var wg sync.WaitGroup
+		var fail atomic.Int32
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 16; j++ {
+					bs := make([]api0.ServerStatsBucket, 8)
+					for k := range bs {
+						bs[k] = api0.ServerStatsBucket{
+							Addr:       addr,
+							Start:      t0.Add(time.Hour * time.Duration(k)),
+							Resolution: time.Hour,
+							Name:       "stress",
+							Samples:    i,
+						}
+					}
+					randSched()
+					if err := s.SaveServerStats(bs); err != nil {
+						t.Logf("error: %v", err)
+						fail.Store(1)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		if fail.Load() != 0 {
+			t.FailNow()
+		}
+
+		x, err := s.GetServerStats(addr, time.Hour, time.Time{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(x) != 8 {
+			t.Fatalf("expected 8 buckets, got %d", len(x))
+		}
+		for _, b := range x {
+			if b.Samples != x[0].Samples {
+				t.Fatalf("batches were not saved atomically")
+			}
+		}
+	})
+}
+
 func randSched() {
 	if rand.Int63()&1 == 1 {
 		runtime.Gosched()
@@ -647,3 +1625,754 @@ func seqBytes(n int, start uint8) []byte {
 	}
 	return b
 }
+
+// TestAccountListStorage tests whether an EMPTY account storage instance
+// implements AccountListStorage correctly.
+func TestAccountListStorage(t *testing.T, s interface {
+	api0.AccountStorage
+	api0.AccountListStorage
+}) {
+	if uids, err := s.GetAccountUIDs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(uids) != 0 {
+		t.Fatalf("expected no accounts, got %v", uids)
+	}
+	for _, uid := range []uint64{1 << 40, 10, 9} {
+		if err := s.SaveAccount(&api0.Account{UID: uid}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if uids, err := s.GetAccountUIDs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(uids, []uint64{9, 10, 1 << 40}) {
+		t.Fatalf("incorrect uids %v", uids)
+	}
+	if err := s.DeleteAccount(10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uids, err := s.GetAccountUIDs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(uids, []uint64{9, 1 << 40}) {
+		t.Fatalf("incorrect uids after delete %v", uids)
+	}
+}
+
+// TestPdataListStorage tests whether an EMPTY pdata storage instance
+// implements PdataListStorage correctly.
+func TestPdataListStorage(t *testing.T, s interface {
+	api0.PdataStorage
+	api0.PdataListStorage
+}) {
+	if uids, err := s.GetPdataUIDs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(uids) != 0 {
+		t.Fatalf("expected no pdata, got %v", uids)
+	}
+	for _, uid := range []uint64{1 << 40, 10, 9} {
+		if _, err := s.SetPdata(uid, seqBytes(16, uint8(uid))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if uids, err := s.GetPdataUIDs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(uids, []uint64{9, 10, 1 << 40}) {
+		t.Fatalf("incorrect uids %v", uids)
+	}
+	if err := s.DeletePdata(10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uids, err := s.GetPdataUIDs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(uids, []uint64{9, 1 << 40}) {
+		t.Fatalf("incorrect uids after delete %v", uids)
+	}
+}
+
+// TestPdataCompactStorage tests whether an EMPTY pdata storage instance
+// implements CompactStorage correctly.
+func TestPdataCompactStorage(t *testing.T, s interface {
+	api0.PdataStorage
+	api0.CompactStorage
+}) {
+	rng := rand.New(rand.NewSource(0))
+	for uid := uint64(1); uid <= 64; uid++ {
+		buf := make([]byte, 16384)
+		rng.Read(buf)
+		if _, err := s.SetPdata(uid, buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for uid := uint64(2); uid <= 64; uid++ {
+		if err := s.DeletePdata(uid); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n, err := s.Compact(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n < 16384*32 {
+		t.Errorf("expected at least %d bytes to be reclaimed, got %d", 16384*32, n)
+	}
+	if buf, exists, err := s.GetPdataCached(1, [sha256.Size]byte{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !exists || len(buf) != 16384 {
+		t.Errorf("expected pdata to be kept after compaction")
+	}
+	if n, err := s.Compact(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n > 16384 {
+		t.Errorf("expected little to be reclaimed after a second compaction, got %d bytes", n)
+	}
+}
+
+// TestStateListStorage tests whether an EMPTY state storage instance
+// implements StateListStorage correctly.
+func TestStateListStorage(t *testing.T, s interface {
+	api0.StateStorage
+	api0.StateListStorage
+}) {
+	if ks, err := s.GetStateKeys(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(ks) != 0 {
+		t.Fatalf("expected no keys, got %v", ks)
+	}
+	for _, k := range []string{"c", "a", "b"} {
+		if err := s.SetState(k, []byte{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if ks, err := s.GetStateKeys(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(ks, []string{"a", "b", "c"}) {
+		t.Fatalf("incorrect keys %v", ks)
+	}
+	if err := s.SetState("b", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ks, err := s.GetStateKeys(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(ks, []string{"a", "c"}) {
+		t.Fatalf("incorrect keys after delete %v", ks)
+	}
+}
+
+func TestRatingStorage(t *testing.T, s api0.RatingStorage) {
+	uid0 := uint64(999999)
+	uid1 := uint64(math.MaxUint64 >> 1)
+	now := time.Now().Truncate(time.Millisecond)
+	modes := func(rs []api0.PlayerRating) []string {
+		var x []string
+		for _, r := range rs {
+			x = append(x, r.Mode)
+		}
+		return x
+	}
+	t.Run("GetNonexistent", func(t *testing.T) {
+		if r, err := s.GetRating(uid0, "ps"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if r != nil {
+			t.Fatalf("expected no rating")
+		}
+		if rs, err := s.GetRatings(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(rs) != 0 {
+			t.Fatalf("expected no ratings")
+		}
+	})
+	t.Run("Save", func(t *testing.T) {
+		r := api0.PlayerRating{
+			UID:        uid0,
+			Mode:       "ps",
+			Rating:     1464.06,
+			Deviation:  151.52,
+			Volatility: 0.05999,
+			Matches:    3,
+			Updated:    now,
+		}
+		if err := s.SaveRatings([]api0.PlayerRating{
+			r,
+			{UID: uid0, Mode: "aitdm", Rating: 1500, Deviation: 350, Volatility: 0.06, Updated: now},
+			{UID: uid1, Mode: "ps", Rating: 1600, Deviation: 200, Volatility: 0.06, Matches: 1, Updated: now},
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if x, err := s.GetRating(uid0, "ps"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if x == nil {
+			t.Fatalf("expected rating")
+		} else if !x.Updated.Equal(r.Updated) {
+			t.Fatalf("incorrect updated time: expected %s, got %s", r.Updated, x.Updated)
+		} else if x.Updated = r.Updated; !reflect.DeepEqual(*x, r) {
+			t.Fatalf("incorrect rating: expected %+v, got %+v", r, *x)
+		}
+		if rs, err := s.GetRatings(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(modes(rs), []string{"aitdm", "ps"}) {
+			t.Fatalf("incorrect ratings (should be ordered by mode): %v", modes(rs))
+		}
+	})
+	t.Run("Update", func(t *testing.T) {
+		if err := s.SaveRatings([]api0.PlayerRating{
+			{UID: uid0, Mode: "ps", Rating: 1480, Deviation: 140, Volatility: 0.06, Matches: 4, Updated: now.Add(time.Minute)},
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if x, err := s.GetRating(uid0, "ps"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if x == nil || x.Rating != 1480 || x.Matches != 4 {
+			t.Fatalf("rating not replaced: %+v", x)
+		}
+		if rs, err := s.GetRatings(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(rs) != 2 {
+			t.Fatalf("expected 2 ratings, got %d", len(rs))
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		if err := s.DeleteRatings(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if rs, err := s.GetRatings(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(rs) != 0 {
+			t.Fatalf("expected ratings to be deleted")
+		}
+		if x, err := s.GetRating(uid1, "ps"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if x == nil {
+			t.Fatalf("expected other player's rating to be kept")
+		}
+	})
+}
+
+func TestMatchHistoryStorage(t *testing.T, s api0.MatchHistoryStorage) {
+	uid0 := uint64(999999)
+	uid1 := uint64(math.MaxUint64 >> 1)
+	uid2 := uint64(1234)
+	now := time.Now().Truncate(time.Millisecond)
+	ids := func(ms []api0.MatchRecord) []string {
+		var x []string
+		for _, m := range ms {
+			x = append(x, m.ID)
+		}
+		return x
+	}
+	t.Run("GetNonexistent", func(t *testing.T) {
+		if ms, err := s.GetMatches(uid0, time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(ms) != 0 {
+			t.Fatalf("expected no matches")
+		}
+	})
+	t.Run("Save", func(t *testing.T) {
+		m := api0.MatchRecord{
+			ID:         "a",
+			Time:       now.Add(-time.Hour),
+			ServerID:   "server",
+			ServerName: "Server",
+			Map:        "mp_glitch",
+			Mode:       "aitdm",
+			Duration:   time.Minute * 15,
+			Players: []api0.MatchPlayer{
+				{UID: uid0, Username: "player0", Team: 1, Rank: 1, Stats: map[string]int64{"kills": 10, "deaths": 2}},
+				{UID: uid1, Username: "player1", Team: 2, Rank: 2},
+			},
+		}
+		for _, x := range []api0.MatchRecord{
+			m,
+			{ID: "b", Time: now, ServerID: "server", Mode: "ps", Players: []api0.MatchPlayer{{UID: uid0, Rank: 2}, {UID: uid2, Rank: 1}}},
+			{ID: "c", Time: now.Add(-time.Minute), ServerID: "server", Mode: "ps", Players: []api0.MatchPlayer{{UID: uid1, Rank: 1}, {UID: uid2, Rank: 2}}},
+		} {
+			if err := s.SaveMatch(&x); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if ms, err := s.GetMatches(uid0, time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(ids(ms), []string{"b", "a"}) {
+			t.Fatalf("incorrect matches (should be newest first): %v", ids(ms))
+		} else if x := ms[1]; !x.Time.Equal(m.Time) {
+			t.Fatalf("incorrect time: expected %s, got %s", m.Time, x.Time)
+		} else if x.Time = m.Time; !reflect.DeepEqual(x, m) {
+			t.Fatalf("incorrect match: expected %+v, got %+v", m, x)
+		}
+		if ms, err := s.GetMatches(uid0, time.Time{}, 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(ids(ms), []string{"b"}) {
+			t.Fatalf("expected limit to be respected, got %v", ids(ms))
+		}
+		if ms, err := s.GetMatches(uid2, now, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(ids(ms), []string{"c"}) {
+			t.Fatalf("expected only matches before the provided time, got %v", ids(ms))
+		}
+	})
+	t.Run("DeletePlayer", func(t *testing.T) {
+		if err := s.DeletePlayerMatches(uid1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ms, err := s.GetMatches(uid1, time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(ms) != 0 {
+			t.Fatalf("expected player to be removed from matches, got %v", ids(ms))
+		}
+		if ms, err := s.GetMatches(uid0, time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(ms) != 2 || len(ms[1].Players) != 1 || ms[1].Players[0].UID != uid0 {
+			t.Fatalf("expected match to be kept for other players: %+v", ms)
+		}
+	})
+	t.Run("DeleteBefore", func(t *testing.T) {
+		if err := s.DeleteMatchesBefore(now.Add(-time.Minute)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ms, err := s.GetMatches(uid0, time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(ids(ms), []string{"b"}) {
+			t.Fatalf("expected old matches to be deleted, got %v", ids(ms))
+		}
+		if ms, err := s.GetMatches(uid2, time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(ids(ms), []string{"b", "c"}) {
+			t.Fatalf("expected newer matches to be kept, got %v", ids(ms))
+		}
+	})
+}
+
+// TestMuteReportStorage tests whether an EMPTY mute report storage instance
+// implements the interface correctly.
+func TestMuteReportStorage(t *testing.T, s api0.MuteReportStorage) {
+	uid0 := uint64(999999)
+	uid1 := uint64(math.MaxUint64 >> 1)
+	addr0 := netip.MustParseAddr("192.0.2.1")
+	addr1 := netip.MustParseAddr("2001:db8::1")
+	now := time.Now().Truncate(time.Millisecond)
+	t.Run("GetNonexistent", func(t *testing.T) {
+		if rs, err := s.GetMuteReports(uid0, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(rs) != 0 {
+			t.Fatalf("expected no reports")
+		}
+	})
+	t.Run("Save", func(t *testing.T) {
+		r := api0.MuteReport{
+			UID:        uid0,
+			ServerAddr: addr0,
+			Kind:       api0.MuteKindChat,
+			ServerName: "Server",
+			Reason:     "spam",
+			Time:       now.Add(-time.Hour),
+		}
+		for _, x := range []api0.MuteReport{
+			r,
+			{UID: uid0, ServerAddr: addr1, Kind: api0.MuteKindChat, Time: now},
+			{UID: uid0, ServerAddr: addr0, Kind: api0.MuteKindVoice, Time: now.Add(-time.Minute)},
+			{UID: uid1, ServerAddr: addr0, Kind: api0.MuteKindChat, Time: now},
+		} {
+			if err := s.SaveMuteReport(x); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if rs, err := s.GetMuteReports(uid0, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(rs) != 3 {
+			t.Fatalf("expected 3 reports, got %d", len(rs))
+		} else if x := rs[0]; !x.Time.Equal(r.Time) {
+			t.Fatalf("incorrect time (should be oldest first): expected %s, got %s", r.Time, x.Time)
+		} else if x.Time = r.Time; !reflect.DeepEqual(x, r) {
+			t.Fatalf("incorrect report: expected %+v, got %+v", r, x)
+		} else if rs[1].Kind != api0.MuteKindVoice || rs[2].ServerAddr != addr1 {
+			t.Fatalf("incorrect reports (should be oldest first): %+v", rs)
+		}
+		if rs, err := s.GetMuteReports(uid0, now.Add(-time.Minute)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(rs) != 2 {
+			t.Fatalf("expected only reports since the provided time, got %d", len(rs))
+		}
+	})
+	t.Run("Update", func(t *testing.T) {
+		if err := s.SaveMuteReport(api0.MuteReport{UID: uid0, ServerAddr: addr0, Kind: api0.MuteKindChat, Reason: "abuse", Time: now}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if rs, err := s.GetMuteReports(uid0, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(rs) != 3 {
+			t.Fatalf("expected report to be replaced, got %d reports", len(rs))
+		} else {
+			for _, x := range rs {
+				if x.ServerAddr == addr0 && x.Kind == api0.MuteKindChat && (x.Reason != "abuse" || !x.Time.Equal(now)) {
+					t.Fatalf("report not replaced: %+v", x)
+				}
+			}
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		if err := s.DeleteMuteReport(uid0, addr0, api0.MuteKindVoice); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if rs, err := s.GetMuteReports(uid0, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(rs) != 2 {
+			t.Fatalf("expected report to be deleted, got %d reports", len(rs))
+		} else {
+			for _, x := range rs {
+				if x.Kind != api0.MuteKindChat {
+					t.Fatalf("deleted the wrong report: %+v", rs)
+				}
+			}
+		}
+	})
+	t.Run("DeleteBefore", func(t *testing.T) {
+		if err := s.SaveMuteReport(api0.MuteReport{UID: uid1, ServerAddr: addr1, Kind: api0.MuteKindVoice, Time: now.Add(-time.Hour)}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.DeleteMuteReportsBefore(now.Add(-time.Minute)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if rs, err := s.GetMuteReports(uid1, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(rs) != 1 || rs[0].ServerAddr != addr0 {
+			t.Fatalf("expected old reports to be deleted, got %+v", rs)
+		}
+	})
+	t.Run("DeletePlayer", func(t *testing.T) {
+		if err := s.DeletePlayerMuteReports(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if rs, err := s.GetMuteReports(uid0, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(rs) != 0 {
+			t.Fatalf("expected reports to be deleted")
+		}
+		if rs, err := s.GetMuteReports(uid1, time.Time{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(rs) != 1 {
+			t.Fatalf("expected other player's reports to be kept")
+		}
+	})
+}
+
+// TestCrashStorage tests whether an EMPTY crash storage instance implements
+// the interface correctly.
+func TestCrashStorage(t *testing.T, s api0.CrashStorage) {
+	now := time.Now().Truncate(time.Millisecond)
+	fp := api0.CrashFingerprint{
+		Exception: "EXCEPTION_ACCESS_VIOLATION",
+		Module:    "engine.dll",
+		Stack:     []string{"engine.dll+0x1234", "server.dll+0x5678"},
+	}
+	sigs := func(cs []api0.CrashSignature) []string {
+		var x []string
+		for _, c := range cs {
+			x = append(x, c.Signature)
+		}
+		return x
+	}
+	t.Run("GetNonexistent", func(t *testing.T) {
+		if c, err := s.GetCrash("a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if c != nil {
+			t.Fatalf("expected no crash")
+		}
+		if cs, err := s.GetCrashes(time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(cs) != 0 {
+			t.Fatalf("expected no crashes")
+		}
+	})
+	t.Run("Record", func(t *testing.T) {
+		for _, r := range []api0.CrashReport{
+			{Signature: "a", Time: now.Add(-time.Hour), Fingerprint: fp, Version: "1.0.0", Mods: []string{"Northstar.Client@1.0.0", "Custom@0.1.0"}},
+			{Signature: "a", Time: now.Add(-time.Minute), Fingerprint: fp, Version: "1.1.0", Mods: []string{"Northstar.Client@1.1.0", "Custom@0.1.0"}},
+			{Signature: "b", Time: now.Add(-time.Hour), Fingerprint: api0.CrashFingerprint{Exception: "other"}, Version: "1.1.0"},
+			{Signature: "c", Time: now, Fingerprint: api0.CrashFingerprint{Exception: "other"}, Version: "1.1.0"},
+		} {
+			if err := s.RecordCrash(r); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		c := api0.CrashSignature{
+			Signature:   "a",
+			First:       now.Add(-time.Hour),
+			Last:        now.Add(-time.Minute),
+			Count:       2,
+			Fingerprint: fp,
+			Versions:    map[string]int{"1.0.0": 1, "1.1.0": 1},
+			Mods:        map[string]int{"Northstar.Client@1.0.0": 1, "Northstar.Client@1.1.0": 1, "Custom@0.1.0": 2},
+		}
+		if x, err := s.GetCrash("a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if x == nil {
+			t.Fatalf("expected crash")
+		} else if !x.First.Equal(c.First) || !x.Last.Equal(c.Last) {
+			t.Fatalf("incorrect times: expected %s-%s, got %s-%s", c.First, c.Last, x.First, x.Last)
+		} else if x.First, x.Last = c.First, c.Last; !reflect.DeepEqual(*x, c) {
+			t.Fatalf("incorrect crash: expected %+v, got %+v", c, *x)
+		}
+		if cs, err := s.GetCrashes(time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(sigs(cs), []string{"a", "c", "b"}) {
+			t.Fatalf("incorrect crashes (should be ordered by count, then last report): %v", sigs(cs))
+		}
+		if cs, err := s.GetCrashes(now.Add(-time.Minute), 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(sigs(cs), []string{"a"}) {
+			t.Fatalf("expected since and limit to be respected, got %v", sigs(cs))
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		if err := s.DeleteCrash("c"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if c, err := s.GetCrash("c"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if c != nil {
+			t.Fatalf("expected crash to be deleted")
+		}
+	})
+	t.Run("DeleteBefore", func(t *testing.T) {
+		if err := s.DeleteCrashesBefore(now.Add(-time.Minute)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cs, err := s.GetCrashes(time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !reflect.DeepEqual(sigs(cs), []string{"a"}) {
+			t.Fatalf("expected old crashes to be deleted, got %v", sigs(cs))
+		}
+	})
+}
+
+// TestFriendStorage tests whether an EMPTY friend storage instance implements
+// the interface correctly.
+func TestFriendStorage(t *testing.T, s api0.FriendStorage) {
+	uid0 := uint64(999999)
+	uid1 := uint64(math.MaxUint64 >> 1)
+	uid2 := uint64(1000000)
+	now := time.Now().Truncate(time.Millisecond)
+	friends := func(uid uint64) []uint64 {
+		t.Helper()
+		fs, err := s.GetFriends(uid)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var r []uint64
+		for _, f := range fs {
+			if f.UID != uid {
+				t.Fatalf("incorrect uid: expected %d, got %d", uid, f.UID)
+			}
+			r = append(r, f.Friend)
+		}
+		return r
+	}
+	t.Run("GetNonexistent", func(t *testing.T) {
+		if fs, err := s.GetFriends(uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(fs) != 0 {
+			t.Fatalf("expected no friends")
+		}
+		if f, err := s.GetFriend(uid0, uid1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if f != nil {
+			t.Fatalf("expected no friend")
+		}
+	})
+	t.Run("Save", func(t *testing.T) {
+		f := api0.Friend{UID: uid0, Friend: uid1, Status: api0.FriendStatusOutgoing, Created: now, Updated: now}
+		if err := s.SaveFriends([]api0.Friend{
+			f,
+			{UID: uid1, Friend: uid0, Status: api0.FriendStatusIncoming, Created: now, Updated: now},
+			{UID: uid0, Friend: uid2, Status: api0.FriendStatusAccepted, Created: now, Updated: now},
+			{UID: uid2, Friend: uid0, Status: api0.FriendStatusAccepted, Created: now, Updated: now},
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if x, err := s.GetFriend(uid0, uid1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if x == nil {
+			t.Fatalf("expected friend")
+		} else if x.Created.Equal(f.Created) && x.Updated.Equal(f.Updated) {
+			x.Created, x.Updated = f.Created, f.Updated
+			if !reflect.DeepEqual(*x, f) {
+				t.Fatalf("incorrect friend: expected %+v, got %+v", f, *x)
+			}
+		} else {
+			t.Fatalf("incorrect friend times: expected %s/%s, got %s/%s", f.Created, f.Updated, x.Created, x.Updated)
+		}
+		if fs := friends(uid0); !reflect.DeepEqual(fs, []uint64{uid2, uid1}) {
+			t.Fatalf("incorrect friends (should be ordered by uid): %v", fs)
+		}
+		if fs := friends(uid1); !reflect.DeepEqual(fs, []uint64{uid0}) {
+			t.Fatalf("incorrect friends: %v", fs)
+		}
+	})
+	t.Run("Update", func(t *testing.T) {
+		if err := s.SaveFriends([]api0.Friend{
+			{UID: uid0, Friend: uid1, Status: api0.FriendStatusAccepted, Created: now, Updated: now.Add(time.Minute)},
+			{UID: uid1, Friend: uid0, Status: api0.FriendStatusAccepted, Created: now, Updated: now.Add(time.Minute)},
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, uid := range []uint64{uid0, uid1} {
+			fs, err := s.GetFriends(uid)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, f := range fs {
+				if f.Friend == uid0 || f.Friend == uid1 {
+					if f.Status != api0.FriendStatusAccepted || !f.Updated.Equal(now.Add(time.Minute)) {
+						t.Fatalf("friend not updated: %+v", f)
+					}
+				}
+			}
+		}
+		if fs := friends(uid0); len(fs) != 2 {
+			t.Fatalf("expected friend to be replaced, got %v", fs)
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		if err := s.DeleteFriend(uid1, uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if fs := friends(uid0); !reflect.DeepEqual(fs, []uint64{uid2}) {
+			t.Fatalf("expected friend to be deleted, got %v", fs)
+		}
+		if fs := friends(uid1); len(fs) != 0 {
+			t.Fatalf("expected friend to be deleted in both directions, got %v", fs)
+		}
+		if err := s.DeleteFriend(uid1, uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("DeleteAll", func(t *testing.T) {
+		if err := s.SaveFriends([]api0.Friend{
+			{UID: uid1, Friend: uid2, Status: api0.FriendStatusOutgoing, Created: now, Updated: now},
+			{UID: uid2, Friend: uid1, Status: api0.FriendStatusIncoming, Created: now, Updated: now},
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.DeleteFriends(uid2); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, uid := range []uint64{uid0, uid1, uid2} {
+			if fs := friends(uid); len(fs) != 0 {
+				t.Fatalf("expected all friends of %d to be deleted, got %v for %d", uid2, fs, uid)
+			}
+		}
+	})
+}
+
+// TestBlockStorage tests whether an EMPTY block storage instance implements
+// the interface correctly.
+func TestBlockStorage(t *testing.T, s api0.BlockStorage) {
+	uid0 := uint64(999999)
+	uid1 := uint64(math.MaxUint64 >> 1)
+	uid2 := uint64(1000000)
+	now := time.Now().Truncate(time.Millisecond)
+	blocks := func(uid uint64) []uint64 {
+		t.Helper()
+		bs, err := s.GetBlocks(uid)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var r []uint64
+		for _, b := range bs {
+			if b.UID != uid {
+				t.Fatalf("incorrect uid: expected %d, got %d", uid, b.UID)
+			}
+			r = append(r, b.Blocked)
+		}
+		return r
+	}
+	blockedBy := func(uid uint64) []uint64 {
+		t.Helper()
+		uids, err := s.GetBlockedBy(uid)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return uids
+	}
+	t.Run("GetNonexistent", func(t *testing.T) {
+		if bs := blocks(uid0); len(bs) != 0 {
+			t.Fatalf("expected no blocks")
+		}
+		if uids := blockedBy(uid0); len(uids) != 0 {
+			t.Fatalf("expected no blocks")
+		}
+		if b, err := s.GetBlock(uid0, uid1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if b != nil {
+			t.Fatalf("expected no block")
+		}
+	})
+	t.Run("Save", func(t *testing.T) {
+		b := api0.Block{UID: uid0, Blocked: uid1, Created: now}
+		for _, x := range []api0.Block{
+			b,
+			{UID: uid0, Blocked: uid2, Created: now},
+			{UID: uid2, Blocked: uid1, Created: now},
+		} {
+			if err := s.SaveBlock(x); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if x, err := s.GetBlock(uid0, uid1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if x == nil {
+			t.Fatalf("expected block")
+		} else if !x.Created.Equal(b.Created) {
+			t.Fatalf("incorrect time: expected %s, got %s", b.Created, x.Created)
+		} else if x.Created = b.Created; !reflect.DeepEqual(*x, b) {
+			t.Fatalf("incorrect block: expected %+v, got %+v", b, *x)
+		}
+		if x, err := s.GetBlock(uid1, uid0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if x != nil {
+			t.Fatalf("expected block to only apply in one direction")
+		}
+		if bs := blocks(uid0); !reflect.DeepEqual(bs, []uint64{uid2, uid1}) {
+			t.Fatalf("incorrect blocks (should be ordered by uid): %v", bs)
+		}
+		if uids := blockedBy(uid1); !reflect.DeepEqual(uids, []uint64{uid0, uid2}) {
+			t.Fatalf("incorrect blocked by (should be ordered by uid): %v", uids)
+		}
+	})
+	t.Run("Update", func(t *testing.T) {
+		if err := s.SaveBlock(api0.Block{UID: uid0, Blocked: uid1, Created: now.Add(time.Minute)}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if bs := blocks(uid0); len(bs) != 2 {
+			t.Fatalf("expected block to be replaced, got %v", bs)
+		}
+		if x, err := s.GetBlock(uid0, uid1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if x == nil || !x.Created.Equal(now.Add(time.Minute)) {
+			t.Fatalf("block not replaced: %+v", x)
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		if err := s.DeleteBlock(uid0, uid1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if bs := blocks(uid0); !reflect.DeepEqual(bs, []uint64{uid2}) {
+			t.Fatalf("expected block to be deleted, got %v", bs)
+		}
+		if uids := blockedBy(uid1); !reflect.DeepEqual(uids, []uint64{uid2}) {
+			t.Fatalf("expected block to be deleted, got blocked by %v", uids)
+		}
+		if err := s.DeleteBlock(uid0, uid1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("DeleteAll", func(t *testing.T) {
+		if err := s.DeleteBlocks(uid2); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, uid := range []uint64{uid0, uid1, uid2} {
+			if bs := blocks(uid); len(bs) != 0 {
+				t.Fatalf("expected all blocks involving %d to be deleted, got %v for %d", uid2, bs, uid)
+			}
+			if uids := blockedBy(uid); len(uids) != 0 {
+				t.Fatalf("expected all blocks involving %d to be deleted, got blocked by %v for %d", uid2, uids, uid)
+			}
+		}
+	})
+}
@@ -0,0 +1,531 @@
+package api0
+
+import (
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+const (
+	// maxBanListUpload is the maximum number of bans and unbans in a single
+	// ban list upload.
+	maxBanListUpload = 500
+
+	// maxBanListSubscriptions is the maximum number of lists which can be
+	// fetched in a single ban list feed request.
+	maxBanListSubscriptions = 16
+)
+
+// BanList is an opt-in community ban list. Trusted servers contribute bans
+// to it, and any server may subscribe to it.
+type BanList struct {
+	// ID identifies the list. It must consist of lowercase letters, digits,
+	// dashes, and underscores.
+	ID string `json:"id" validate:"required,max=64"`
+
+	// Name is the display name of the list.
+	Name string `json:"name" validate:"required,max=128"`
+
+	// Description is an optional description of the list (e.g., what it's
+	// for and who maintains it).
+	Description string `json:"description,omitempty" validate:"max=1024"`
+
+	// Contributors are the names of the trusted server communities allowed to
+	// contribute bans. If empty, any trusted server may contribute. Removing a
+	// contributor excludes their existing bans from the feed.
+	Contributors []string `json:"contributors,omitempty" validate:"max=256"`
+
+	// MinSources is the number of contributors which must ban a player for
+	// them to be banned in the feed. If zero, a single contributor is enough.
+	MinSources int `json:"min_sources,omitempty" validate:"min=0,max=64"`
+
+	// Updated is when the list configuration was last changed. Subscribers
+	// syncing incrementally are sent the full list again after this changes.
+	Updated time.Time `json:"updated"`
+}
+
+// ValidBanListID checks if id is a valid ban list ID.
+func ValidBanListID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// Trusts checks if source is allowed to contribute to the list.
+func (l BanList) Trusts(source string) bool {
+	if len(l.Contributors) == 0 {
+		return true
+	}
+	for _, c := range l.Contributors {
+		if strings.EqualFold(c, source) {
+			return true
+		}
+	}
+	return false
+}
+
+// banListFeedEntry is the resolved state of a player in a ban list.
+type banListFeedEntry struct {
+	UID uint64 `json:"uid"`
+
+	// Banned is true if at least MinSources trusted contributors currently ban
+	// the player.
+	Banned bool `json:"banned"`
+
+	// Disputed is true if the player is banned, but at least one trusted
+	// contributor has revoked their ban.
+	Disputed bool `json:"disputed,omitempty"`
+
+	// Updated is when any of the sources was last changed.
+	Updated time.Time `json:"updated"`
+
+	// Sources is the provenance of the ban.
+	Sources []banListFeedSource `json:"sources"`
+}
+
+type banListFeedSource struct {
+	Source  string    `json:"source"`
+	Reason  string    `json:"reason,omitempty"`
+	Revoked bool      `json:"revoked,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// resolveBanList resolves the entries of l into the state of each player,
+// only including players changed after since (if non-zero). Entries from
+// sources which are no longer trusted by the list are ignored.
+func resolveBanList(l BanList, es []BanListEntry, since time.Time) []banListFeedEntry {
+	m := map[uint64]*banListFeedEntry{}
+	for _, e := range es {
+		if !l.Trusts(e.Source) {
+			continue
+		}
+		x, ok := m[e.UID]
+		if !ok {
+			x = &banListFeedEntry{UID: e.UID}
+			m[e.UID] = x
+		}
+		if e.Updated.After(x.Updated) {
+			x.Updated = e.Updated
+		}
+		x.Sources = append(x.Sources, banListFeedSource{
+			Source:  e.Source,
+			Reason:  e.Reason,
+			Revoked: e.Revoked,
+			Created: e.Created.UTC(),
+			Updated: e.Updated.UTC(),
+		})
+	}
+
+	minSources := l.MinSources
+	if minSources <= 0 {
+		minSources = 1
+	}
+
+	fs := make([]banListFeedEntry, 0, len(m))
+	for _, x := range m {
+		if !since.IsZero() && !x.Updated.After(since) {
+			continue
+		}
+		var active, revoked int
+		for _, s := range x.Sources {
+			if s.Revoked {
+				revoked++
+			} else {
+				active++
+			}
+		}
+		x.Banned = active >= minSources
+		x.Disputed = x.Banned && revoked != 0
+		x.Updated = x.Updated.UTC()
+		sort.Slice(x.Sources, func(i, j int) bool {
+			return x.Sources[i].Created.Before(x.Sources[j].Created)
+		})
+		fs = append(fs, *x)
+	}
+	sort.Slice(fs, func(i, j int) bool {
+		return fs[i].UID < fs[j].UID
+	})
+	return fs
+}
+
+func (h *Handler) handleServerBanList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.m().server_banlist_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.BanListStorage == nil {
+		h.m().server_banlist_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("ban lists are not enabled"))
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_banlist_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	q := r.URL.Query()
+
+	id := q.Get("id")
+	if id == "" {
+		h.m().server_banlist_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	srv := h.ServerList.GetServerByID(id)
+	if srv == nil {
+		h.m().server_banlist_requests_total.reject_server_not_found.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such game server"))
+		return
+	}
+	if srv.Addr.Addr() != raddr.Addr() {
+		h.m().server_banlist_requests_total.reject_unauthorized_ip.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
+		return
+	}
+
+	ls, err := h.banLists.Get(h.StateStorage, "banlists")
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to load ban lists from storage")
+		h.m().server_banlist_requests_total.fail_storage_error_state.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	getList := func(id string) (BanList, bool) {
+		for _, l := range ls {
+			if l.ID == id {
+				return l, true
+			}
+		}
+		return BanList{}, false
+	}
+
+	if r.Method == http.MethodGet {
+		ids := q["list"]
+		if len(ids) == 0 {
+			// list the available lists
+			if ls == nil {
+				ls = []BanList{}
+			}
+			h.m().server_banlist_requests_total.success_lists.Inc()
+			respJSON(w, r, http.StatusOK, map[string]any{
+				"success": true,
+				"lists":   ls,
+			})
+			return
+		}
+		if len(ids) > maxBanListSubscriptions {
+			h.m().server_banlist_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("at most %d lists can be requested at once", maxBanListSubscriptions))
+			return
+		}
+
+		var since time.Time
+		if v := q.Get("since"); v != "" {
+			if t, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				h.m().server_banlist_requests_total.reject_bad_request.Inc()
+				respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("since param is invalid: %v", err))
+				return
+			} else {
+				since = t
+			}
+		}
+
+		// get the time before reading the entries so anything changed while
+		// we're reading will be included next time
+		now := time.Now().UTC()
+
+		type feedList struct {
+			ID      string             `json:"id"`
+			Full    bool               `json:"full"`
+			Entries []banListFeedEntry `json:"entries"`
+		}
+		fls := make([]feedList, 0, len(ids))
+		for _, id := range ids {
+			l, ok := getList(id)
+			if !ok {
+				h.m().server_banlist_requests_total.reject_list_not_found.Inc()
+				respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("no such ban list %q", id))
+				return
+			}
+			es, err := h.BanListStorage.GetBanListEntries(l.ID)
+			if err != nil {
+				hlog.FromRequest(r).Error().
+					Err(err).
+					Str("list", l.ID).
+					Msgf("failed to load ban list entries from storage")
+				h.m().server_banlist_requests_total.fail_storage_error_banlist.Inc()
+				respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+				return
+			}
+			// if the trust configuration changed, the resolved state of
+			// unchanged entries may have too
+			lsince := since
+			if l.Updated.After(since) {
+				lsince = time.Time{}
+			}
+			fls = append(fls, feedList{
+				ID:      l.ID,
+				Full:    lsince.IsZero(),
+				Entries: resolveBanList(l, es, lsince),
+			})
+		}
+
+		h.m().server_banlist_requests_total.success_feed.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"now":     now,
+			"lists":   fls,
+		})
+		return
+	}
+
+	l, ok := getList(q.Get("list"))
+	if !ok {
+		h.m().server_banlist_requests_total.reject_list_not_found.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("no such ban list %q", q.Get("list")))
+		return
+	}
+
+	ts, err := h.trustedServers.Get(h.StateStorage, "trustedservers")
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to load trusted servers from storage")
+		h.m().server_banlist_requests_total.fail_storage_error_state.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	var source string
+	for _, t := range ts {
+		if t.Match(srv.Addr) {
+			source = t.Name
+			break
+		}
+	}
+	if source == "" || !l.Trusts(source) {
+		h.m().server_banlist_requests_total.reject_untrusted.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("server is not allowed to contribute to ban list %q", l.ID))
+		return
+	}
+
+	var req struct {
+		Bans []struct {
+			UID    uint64 `json:"uid" validate:"required"`
+			Reason string `json:"reason,omitempty" validate:"max=256"`
+		} `json:"bans" validate:"max=500"`
+		Unbans []uint64 `json:"unbans" validate:"max=500"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		h.m().server_banlist_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+	if len(req.Bans)+len(req.Unbans) > maxBanListUpload {
+		h.m().server_banlist_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("at most %d bans and unbans can be uploaded at once", maxBanListUpload))
+		return
+	}
+
+	now := time.Now()
+	es := make([]BanListEntry, 0, len(req.Bans)+len(req.Unbans))
+	for _, b := range req.Bans {
+		es = append(es, BanListEntry{
+			List:    l.ID,
+			UID:     b.UID,
+			Source:  source,
+			Reason:  b.Reason,
+			Created: now,
+			Updated: now,
+		})
+	}
+	for _, uid := range req.Unbans {
+		es = append(es, BanListEntry{
+			List:    l.ID,
+			UID:     uid,
+			Source:  source,
+			Revoked: true,
+			Created: now,
+			Updated: now,
+		})
+	}
+	for i := range es {
+		if err := h.BanListStorage.SaveBanListEntry(&es[i]); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Str("list", l.ID).
+				Uint64("uid", es[i].UID).
+				Msgf("failed to save ban list entry to storage")
+			h.m().server_banlist_requests_total.fail_storage_error_banlist.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+	}
+
+	hlog.FromRequest(r).Info().
+		Str("list", l.ID).
+		Str("source", source).
+		Int("bans", len(req.Bans)).
+		Int("unbans", len(req.Unbans)).
+		Msgf("ban list entries uploaded")
+
+	h.m().server_banlist_requests_total.success_upload.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"source":  source,
+	})
+}
+
+func (h *Handler) handleAdminBanLists(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "banlists"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	if h.BanListStorage == nil {
+		h.m().admin_requests_total.reject_disabled(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		ls, err := h.banLists.Get(h.StateStorage, "banlists")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load ban lists from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if ls == nil {
+			ls = []BanList{}
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"lists":   ls,
+		})
+		return
+	case http.MethodPost:
+		var l BanList
+		if err := decodeJSON(r, &l); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		if !ValidBanListID(l.ID) {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id must only contain lowercase letters, digits, dashes, and underscores"))
+			return
+		}
+		l.Updated = time.Now().UTC().Truncate(time.Millisecond)
+
+		if err := h.banLists.Update(h.StateStorage, "banlists", func(ls []BanList) ([]BanList, error) {
+			ls = append([]BanList(nil), ls...)
+			for i := range ls {
+				if ls[i].ID == l.ID {
+					ls[i] = l
+					return ls, nil
+				}
+			}
+			return append(ls, l), nil
+		}); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to save ban lists to storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+	case http.MethodDelete:
+		var q struct {
+			ID string `param:"id" validate:"required"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+
+		if err := h.banLists.Update(h.StateStorage, "banlists", func(ls []BanList) ([]BanList, error) {
+			ls = append([]BanList(nil), ls...)
+			for i := range ls {
+				if ls[i].ID == q.ID {
+					return append(ls[:i], ls[i+1:]...), nil
+				}
+			}
+			return ls, nil
+		}); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to save ban lists to storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if err := h.BanListStorage.DeleteBanList(q.ID); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Str("list", q.ID).
+				Msgf("failed to delete ban list entries from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
@@ -0,0 +1,126 @@
+package api0
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// Ban records a player banned by the community moderators. Atlas doesn't
+// reject banned players itself; bans are used by moderation tooling (e.g., alt
+// detection).
+type Ban struct {
+	// UID is the Origin UID of the banned account.
+	UID uint64 `json:"uid" validate:"required"`
+
+	// Reason is an optional note about why the player was banned.
+	Reason string `json:"reason,omitempty" validate:"max=512"`
+
+	// BannedAt is when the player was banned.
+	BannedAt time.Time `json:"banned_at"`
+}
+
+// getBans gets the current bans.
+func (h *Handler) getBans() ([]Ban, error) {
+	return h.bans.Get(h.StateStorage, "bans")
+}
+
+func (h *Handler) handleAdminBans(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "bans"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	var fn func(bs []Ban) []Ban
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		bs, err := h.getBans()
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load bans from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if bs == nil {
+			bs = []Ban{}
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"bans":    bs,
+		})
+		return
+	case http.MethodPost:
+		var b Ban
+		if err := decodeJSON(r, &b); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		if b.BannedAt.IsZero() {
+			b.BannedAt = time.Now().UTC().Truncate(time.Second)
+		}
+		fn = func(bs []Ban) []Ban {
+			for i := range bs {
+				if bs[i].UID == b.UID {
+					bs[i] = b
+					return bs
+				}
+			}
+			return append(bs, b)
+		}
+	case http.MethodDelete:
+		var q struct {
+			UID uint64 `param:"uid" validate:"required"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func(bs []Ban) []Ban {
+			for i := range bs {
+				if bs[i].UID == q.UID {
+					return append(bs[:i], bs[i+1:]...)
+				}
+			}
+			return bs
+		}
+	}
+
+	if err := h.bans.Update(h.StateStorage, "bans", func(bs []Ban) ([]Ban, error) {
+		return fn(append([]Ban(nil), bs...)), nil
+	}); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save bans to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
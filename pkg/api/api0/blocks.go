@@ -0,0 +1,279 @@
+package api0
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// blocksMax is the maximum number of players a player can block.
+const blocksMax = 500
+
+// blockJSON is the representation of a blocked player returned to the player.
+type blockJSON struct {
+	UID      uint64 `json:"uid,string"`
+	Username string `json:"username,omitempty"`
+	Since    int64  `json:"since"`
+}
+
+// blockSet gets the players which have either been blocked by or have blocked
+// any of uids. If BlockStorage is nil, it returns nil.
+func (h *Handler) blockSet(uids ...uint64) (map[uint64]struct{}, error) {
+	if h.BlockStorage == nil {
+		return nil, nil
+	}
+	m := map[uint64]struct{}{}
+	for _, uid := range uids {
+		bs, err := h.BlockStorage.GetBlocks(uid)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bs {
+			m[b.Blocked] = struct{}{}
+		}
+		by, err := h.BlockStorage.GetBlockedBy(uid)
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range by {
+			m[x] = struct{}{}
+		}
+	}
+	return m, nil
+}
+
+// blocked checks whether either of a and b has blocked the other.
+func (h *Handler) blocked(a, b uint64) (bool, error) {
+	if h.BlockStorage == nil {
+		return false, nil
+	}
+	for _, x := range [][2]uint64{{a, b}, {b, a}} {
+		if v, err := h.BlockStorage.GetBlock(x[0], x[1]); err != nil {
+			return false, err
+		} else if v != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// chatHiddenFrom gets the online players which either blocked or were blocked
+// by uid, grouped by the server they're connected to, for the chat relay.
+func (h *Handler) chatHiddenFrom(uid uint64, now time.Time) (map[string][]uint64, error) {
+	bs, err := h.blockSet(uid)
+	if err != nil || len(bs) == 0 {
+		return nil, err
+	}
+	m := map[string][]uint64{}
+	for x := range bs {
+		if p, ok := h.playerPresence(x, now); ok {
+			m[p.Server] = append(m[p.Server], x)
+		}
+	}
+	for _, uids := range m {
+		sort.Slice(uids, func(i, j int) bool {
+			return uids[i] < uids[j]
+		})
+	}
+	return m, nil
+}
+
+// handleClientBlocks manages a player's block list. Blocked players aren't
+// matched with the player, can't be invited to or join their party, can't
+// send them friend requests, and their relayed chat messages are marked as
+// hidden for the player.
+//
+// Query parameters:
+//   - id: the player uid
+//   - token: the player's master server token
+//
+// GET returns the "blocks" of the player. POST takes an "action" (block or
+// unblock) and the "uid" of the other player. Blocking a player also removes
+// them as a friend.
+func (h *Handler) handleClientBlocks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.m().client_blocks_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.BlockStorage == nil {
+		h.m().client_blocks_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("block lists are not enabled"))
+		return
+	}
+
+	uid, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		h.m().client_blocks_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	acct, err := h.AccountStorage.GetAccount(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read account from storage")
+		h.m().client_blocks_requests_total.fail_storage_error_account.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if acct == nil {
+		h.m().client_blocks_requests_total.reject_player_not_found.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+	if !h.checkPlayerToken(acct, r.URL.Query().Get("token")) {
+		h.m().client_blocks_requests_total.reject_masterserver_token.Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		h.handleClientBlocksAction(w, r, acct)
+		return
+	}
+
+	bs, err := h.BlockStorage.GetBlocks(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read blocks from storage")
+		h.m().client_blocks_requests_total.fail_storage_error_blocks.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	blocks := make([]blockJSON, 0, len(bs))
+	for _, b := range bs {
+		x := blockJSON{
+			UID:   b.Blocked,
+			Since: b.Created.Unix(),
+		}
+		if ba, err := h.AccountStorage.GetAccount(b.Blocked); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", b.Blocked).
+				Msgf("failed to read account from storage")
+			h.m().client_blocks_requests_total.fail_storage_error_account.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		} else if ba != nil {
+			x.Username = ba.Username
+		}
+		blocks = append(blocks, x)
+	}
+
+	h.m().client_blocks_requests_total.success_list.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"blocks":  blocks,
+	})
+}
+
+func (h *Handler) handleClientBlocksAction(w http.ResponseWriter, r *http.Request, acct *Account) {
+	var q struct {
+		Action string `param:"action" validate:"required,oneof=block|unblock"`
+		UID    uint64 `param:"uid" validate:"required"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().client_blocks_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+	if q.UID == acct.UID {
+		h.m().client_blocks_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("cannot %s yourself", q.Action))
+		return
+	}
+
+	storageError := func(err error, what string) {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", acct.UID).
+			Uint64("blocked", q.UID).
+			Msgf("failed to %s", what)
+		h.m().client_blocks_requests_total.fail_storage_error_blocks.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+	}
+
+	if q.Action == "unblock" {
+		if err := h.BlockStorage.DeleteBlock(acct.UID, q.UID); err != nil {
+			storageError(err, "delete block")
+			return
+		}
+		h.m().client_blocks_requests_total.success_unblock.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+		})
+		return
+	}
+
+	if cur, err := h.BlockStorage.GetBlock(acct.UID, q.UID); err != nil {
+		storageError(err, "read block")
+		return
+	} else if cur == nil {
+		if ba, err := h.AccountStorage.GetAccount(q.UID); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", q.UID).
+				Msgf("failed to read account from storage")
+			h.m().client_blocks_requests_total.fail_storage_error_account.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		} else if ba == nil {
+			h.m().client_blocks_requests_total.reject_player_not_found.Inc()
+			respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+			return
+		}
+		if bs, err := h.BlockStorage.GetBlocks(acct.UID); err != nil {
+			storageError(err, "read blocks")
+			return
+		} else if len(bs) >= blocksMax {
+			h.m().client_blocks_requests_total.reject_limit.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_BAD_REQUEST.MessageObjf("too many blocked players (max %d)", blocksMax))
+			return
+		}
+		if err := h.BlockStorage.SaveBlock(Block{
+			UID:     acct.UID,
+			Blocked: q.UID,
+			Created: time.Now(),
+		}); err != nil {
+			storageError(err, "save block")
+			return
+		}
+	}
+
+	if h.FriendStorage != nil {
+		h.friendsMu.Lock()
+		err := h.FriendStorage.DeleteFriend(acct.UID, q.UID)
+		h.friendsMu.Unlock()
+		if err != nil {
+			storageError(err, "delete friend")
+			return
+		}
+	}
+	h.parties.blocked(acct.UID, q.UID)
+	h.matchmaking.blocked(acct.UID, q.UID)
+
+	h.m().client_blocks_requests_total.success_block.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
@@ -0,0 +1,112 @@
+package api0
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errOriginUnavailable is returned instead of calling stryder while the Origin
+// circuit breaker is open.
+var errOriginUnavailable = errors.New("origin is unavailable (circuit breaker open)")
+
+// circuitBreaker tracks consecutive failures of a dependency. After threshold
+// consecutive failures, it opens for the cooldown, after which a single
+// request is allowed through to check whether the dependency has recovered.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// Allow checks whether a request should be attempted at t. If it returns true,
+// Success, Failure, or Cancel must be called afterwards.
+func (b *circuitBreaker) Allow(t time.Time, threshold int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if threshold <= 0 || b.failures < threshold {
+		return true
+	}
+	if b.probing || t.Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Success records a successful request.
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	b.failures = 0
+}
+
+// Failure records a failed request at t.
+func (b *circuitBreaker) Failure(t time.Time, threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if b.failures++; threshold > 0 && b.failures >= threshold {
+		b.openUntil = t.Add(cooldown)
+	}
+}
+
+// Cancel records a request with an unknown result (e.g., if it was canceled by
+// the client).
+func (b *circuitBreaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// Open checks whether the breaker is currently open (including while a probe
+// request is in progress).
+func (b *circuitBreaker) Open(threshold int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return threshold > 0 && b.failures >= threshold
+}
+
+// Failures gets the number of consecutive failures.
+func (b *circuitBreaker) Failures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures
+}
+
+// OriginStatus is the health of Origin auth based on recent requests.
+type OriginStatus string
+
+const (
+	OriginStatusOK       OriginStatus = "ok"       // the last request succeeded
+	OriginStatusDegraded OriginStatus = "degraded" // the last requests failed
+	OriginStatusDown     OriginStatus = "down"     // the circuit breaker is open
+)
+
+// OriginStatus gets the health of Origin auth.
+func (h *Handler) OriginStatus() OriginStatus {
+	switch {
+	case h.originBreaker.Open(h.OriginBreakerThreshold):
+		return OriginStatusDown
+	case h.originBreaker.Failures() != 0:
+		return OriginStatusDegraded
+	default:
+		return OriginStatusOK
+	}
+}
+
+// originBreakerCooldown gets the effective Origin circuit breaker cooldown.
+func (h *Handler) originBreakerCooldown() time.Duration {
+	if h.OriginBreakerCooldown > 0 {
+		return h.OriginBreakerCooldown
+	}
+	return time.Second * 30
+}
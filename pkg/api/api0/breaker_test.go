@@ -0,0 +1,242 @@
+package api0
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/stryder"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const threshold, cooldown = 3, time.Minute
+	t0 := time.Unix(1700000000, 0)
+
+	var b circuitBreaker
+	for i := 0; i < threshold-1; i++ {
+		if !b.Allow(t0, threshold) {
+			t.Fatalf("expected request %d to be allowed", i)
+		}
+		b.Failure(t0, threshold, cooldown)
+	}
+	if b.Open(threshold) || b.Failures() != threshold-1 {
+		t.Errorf("expected breaker to be closed before the threshold, got %d failures", b.Failures())
+	}
+	b.Success()
+	if b.Failures() != 0 {
+		t.Errorf("expected success to reset failures")
+	}
+
+	for i := 0; i < threshold; i++ {
+		b.Allow(t0, threshold)
+		b.Failure(t0, threshold, cooldown)
+	}
+	if !b.Open(threshold) {
+		t.Fatalf("expected breaker to open after %d failures", threshold)
+	}
+	if b.Allow(t0.Add(cooldown-time.Second), threshold) {
+		t.Errorf("expected requests to be skipped during the cooldown")
+	}
+
+	// a single probe is allowed after the cooldown
+	if !b.Allow(t0.Add(cooldown), threshold) {
+		t.Fatalf("expected probe after the cooldown")
+	}
+	if b.Allow(t0.Add(cooldown), threshold) {
+		t.Errorf("expected only one concurrent probe")
+	}
+	b.Cancel()
+	if !b.Allow(t0.Add(cooldown), threshold) {
+		t.Fatalf("expected another probe after a canceled one")
+	}
+	b.Failure(t0.Add(cooldown), threshold, cooldown)
+	if b.Allow(t0.Add(cooldown*2-time.Second), threshold) {
+		t.Errorf("expected failed probe to restart the cooldown")
+	}
+	if !b.Allow(t0.Add(cooldown*2), threshold) {
+		t.Fatalf("expected probe after the second cooldown")
+	}
+	b.Success()
+	if b.Open(threshold) || !b.Allow(t0.Add(cooldown*2), threshold) || !b.Allow(t0.Add(cooldown*2), threshold) {
+		t.Errorf("expected successful probe to close the breaker")
+	}
+
+	// a zero threshold disables the breaker
+	var d circuitBreaker
+	for i := 0; i < 10; i++ {
+		if !d.Allow(t0, 0) {
+			t.Fatalf("expected requests to be allowed with the breaker disabled")
+		}
+		d.Failure(t0, 0, cooldown)
+	}
+	if d.Open(0) {
+		t.Errorf("expected disabled breaker to stay closed")
+	}
+}
+
+func TestOriginDegradedAuth(t *testing.T) {
+	// fake stryder accepting tokens of the form valid-<uid> while up
+	var down atomic.Bool
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		uid, _ := strconv.ParseUint(r.URL.Query().Get("userId"), 16, 64)
+		if r.URL.Query().Get("code") != "valid-"+strconv.FormatUint(uid, 10) {
+			w.Write([]byte(`{"success":false,"status":"400","error":{"error":"invalid_grant","error_description":"code is invalid","code":100100}}`))
+			return
+		}
+		w.Write([]byte(`{"token":"x","hasOnlineAccess":"1","expiry":"14399","storeUri":"https://www.origin.com/store/titanfall/titanfall-2/standard-edition"}`))
+	}))
+	defer srv.Close()
+
+	old := stryder.Base
+	stryder.Base = srv.URL
+	defer func() { stryder.Base = old }()
+
+	setup := func(maxAge time.Duration, anyIP bool) (*Handler, *testAccountStorage) {
+		as := new(testAccountStorage)
+		h := &Handler{
+			AccountStorage:         as,
+			PdataStorage:           new(testPdataStorage),
+			OriginBreakerThreshold: 2,
+			OriginBreakerCooldown:  time.Hour,
+			DegradedAuthMaxAge:     maxAge,
+			DegradedAuthAnyIP:      anyIP,
+		}
+		return h, as
+	}
+	auth := func(t *testing.T, h *Handler, uid uint64, token, ip string) (int, map[string]any) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/client/origin_auth?id="+strconv.FormatUint(uid, 10)+"&token="+token, nil)
+		r.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		h.handleClientOriginAuth(w, r)
+
+		var obj map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &obj); err != nil {
+			t.Fatalf("invalid response: %v: %s", err, w.Body.String())
+		}
+		return w.Code, obj
+	}
+	trip := func(t *testing.T, h *Handler) {
+		t.Helper()
+		down.Store(true)
+		for i := 0; i < h.OriginBreakerThreshold; i++ {
+			if h.OriginStatus() == OriginStatusDown {
+				t.Fatalf("expected breaker to open after %d failures, opened after %d", h.OriginBreakerThreshold, i)
+			}
+			if st, _ := auth(t, h, 2000, "valid-2000", "192.0.2.1"); st != http.StatusInternalServerError && st != http.StatusServiceUnavailable {
+				t.Fatalf("expected stryder failure, got status %d", st)
+			}
+			if s := h.OriginStatus(); s != OriginStatusDegraded && i != h.OriginBreakerThreshold-1 {
+				t.Fatalf("expected origin to be degraded after a failure, got %s", s)
+			}
+		}
+		if s := h.OriginStatus(); s != OriginStatusDown {
+			t.Fatalf("expected origin to be down, got %s", s)
+		}
+	}
+
+	t.Run("Breaker", func(t *testing.T) {
+		h, _ := setup(0, false)
+		down.Store(false)
+		if st, obj := auth(t, h, 1000, "invalid", "192.0.2.1"); st != http.StatusForbidden {
+			t.Errorf("expected rejected token, got status %d: %v", st, obj)
+		}
+		if s := h.OriginStatus(); s != OriginStatusOK {
+			t.Errorf("expected rejected tokens not to count as failures, got %s", s)
+		}
+		trip(t, h)
+
+		n := requests.Load()
+		st, obj := auth(t, h, 1000, "valid-1000", "192.0.2.1")
+		if st != http.StatusServiceUnavailable {
+			t.Errorf("expected status 503 while the breaker is open, got %d", st)
+		}
+		if e, _ := obj["error"].(map[string]any); e == nil || e["retryable"] != true {
+			t.Errorf("expected retryable error, got %v", obj)
+		}
+		if requests.Load() != n {
+			t.Errorf("expected stryder not to be called while the breaker is open")
+		}
+	})
+
+	t.Run("Degraded", func(t *testing.T) {
+		h, as := setup(time.Hour, false)
+		down.Store(false)
+		for _, uid := range []uint64{1000, 1001} {
+			if st, obj := auth(t, h, uid, "valid-"+strconv.FormatUint(uid, 10), "192.0.2.1"); st != http.StatusOK || obj["stale_verified"] != nil {
+				t.Fatalf("expected normal auth, got status %d: %v", st, obj)
+			}
+		}
+		if a, _ := as.GetAccount(1000); a == nil || a.VerifiedAt.IsZero() {
+			t.Fatalf("expected account to be marked as verified")
+		}
+
+		// account 1001 was last verified too long ago
+		a, _ := as.GetAccount(1001)
+		a.VerifiedAt = time.Now().Add(-time.Hour * 2)
+		as.SaveAccount(a)
+
+		trip(t, h)
+		for _, tc := range []struct {
+			name   string
+			uid    uint64
+			token  string
+			ip     string
+			status int
+		}{
+			{"Recent", 1000, "valid-1000", "192.0.2.1", http.StatusOK},
+			{"AnyToken", 1000, "asd", "192.0.2.1", http.StatusOK},
+			{"OtherIP", 1000, "valid-1000", "192.0.2.2", http.StatusServiceUnavailable},
+			{"Old", 1001, "valid-1001", "192.0.2.1", http.StatusServiceUnavailable},
+			{"NoAccount", 1002, "valid-1002", "192.0.2.1", http.StatusServiceUnavailable},
+		} {
+			st, obj := auth(t, h, tc.uid, tc.token, tc.ip)
+			if st != tc.status {
+				t.Errorf("%s: expected status %d, got %d: %v", tc.name, tc.status, st, obj)
+				continue
+			}
+			if st == http.StatusOK {
+				if obj["stale_verified"] != true {
+					t.Errorf("%s: expected stale_verified in response", tc.name)
+				}
+				a, _ := as.GetAccount(tc.uid)
+				if !a.CheckAuthToken(obj["token"].(string), time.Now()) {
+					t.Errorf("%s: expected session to be created", tc.name)
+				}
+				for _, s := range a.Sessions(time.Now()) {
+					if s.Token == obj["token"] && !s.StaleVerified {
+						t.Errorf("%s: expected session to be tagged as stale-verified", tc.name)
+					}
+				}
+			}
+		}
+
+		// the verification time isn't updated by degraded auth
+		if a, _ := as.GetAccount(1001); time.Since(a.VerifiedAt) < time.Hour {
+			t.Errorf("expected verified time not to be updated")
+		}
+	})
+
+	t.Run("DegradedAnyIP", func(t *testing.T) {
+		h, _ := setup(time.Hour, true)
+		down.Store(false)
+		if st, _ := auth(t, h, 1000, "valid-1000", "192.0.2.1"); st != http.StatusOK {
+			t.Fatalf("expected normal auth, got status %d", st)
+		}
+		trip(t, h)
+		if st, obj := auth(t, h, 1000, "valid-1000", "192.0.2.2"); st != http.StatusOK || obj["stale_verified"] != true {
+			t.Errorf("expected stale-verified auth from another ip, got status %d: %v", st, obj)
+		}
+	})
+}
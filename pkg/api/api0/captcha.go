@@ -0,0 +1,299 @@
+package api0
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+const (
+	captchaClearanceTTL  = time.Minute * 30
+	captchaVerifyTimeout = time.Second * 10
+
+	// captchaCookie is the cookie containing the CAPTCHA clearance token. It
+	// may also be provided in the X-Atlas-Captcha-Clearance header.
+	captchaCookie = "atlas_captcha"
+)
+
+// CaptchaProvider is a CAPTCHA service.
+type CaptchaProvider string
+
+const (
+	CaptchaTurnstile CaptchaProvider = "turnstile" // Cloudflare Turnstile
+	CaptchaHCaptcha  CaptchaProvider = "hcaptcha"
+)
+
+// verifyURL gets the siteverify endpoint for the provider.
+func (p CaptchaProvider) verifyURL() string {
+	switch p {
+	case CaptchaTurnstile:
+		return "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+	case CaptchaHCaptcha:
+		return "https://api.hcaptcha.com/siteverify"
+	default:
+		return ""
+	}
+}
+
+// CaptchaConfig configures CAPTCHA verification for browser requests from IPs
+// which have been tarpitted or blocked by anomaly detection. Once solved, the
+// client receives a clearance bound to its IP which exempts it from the
+// CAPTCHA until it expires. Requests without an Origin header (i.e., from the
+// game) are not affected.
+type CaptchaConfig struct {
+	// Provider is the CAPTCHA provider. If empty, CAPTCHAs are disabled.
+	Provider CaptchaProvider
+
+	// SiteKey is the public site key returned to clients to render the
+	// widget.
+	SiteKey string
+
+	// Secret is the secret key used to verify responses.
+	Secret string
+
+	// Paths are the paths to require the CAPTCHA on.
+	Paths []string
+
+	// VerifyURL overrides the provider's siteverify endpoint.
+	VerifyURL string
+
+	// Client is the HTTP client used to verify responses. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Active checks whether the CAPTCHA may be required for path.
+func (c CaptchaConfig) Active(path string) bool {
+	if c.Provider == "" {
+		return false
+	}
+	for _, p := range c.Paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// captchaIP gets the IP address of r, or an invalid address if it can't be
+// parsed.
+func captchaIP(r *http.Request) netip.Addr {
+	a, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return a.Addr().Unmap()
+}
+
+// checkCaptcha checks if r requires a CAPTCHA, and if so, whether it has a
+// valid clearance for its IP. If not, it writes a CAPTCHA response and returns
+// false.
+func (h *Handler) checkCaptcha(w http.ResponseWriter, r *http.Request) bool {
+	c := h.Captcha
+	if !c.Active(r.URL.Path) || !h.AnomalyDetection.enabled() {
+		return true
+	}
+	if r.Method == http.MethodOptions || r.Header.Get("Origin") == "" {
+		return true
+	}
+
+	ip := captchaIP(r)
+	if !ip.IsValid() {
+		return true
+	}
+	single, subnet := anomalyPrefixes(ip)
+	if _, ok := h.anomaly.penalty(time.Now(), single, subnet); !ok {
+		return true
+	}
+
+	tok := r.Header.Get("X-Atlas-Captcha-Clearance")
+	if tok == "" {
+		if c, err := r.Cookie(captchaCookie); err == nil {
+			tok = c.Value
+		}
+	}
+	if tok != "" {
+		if b, ok := h.verifyToken("captcha", tok); ok {
+			if x, ok := netip.AddrFromSlice(b); ok && x == ip {
+				h.m().captcha_checks_total.success_clearance.Inc()
+				return true
+			}
+		}
+	}
+
+	h.m().captcha_checks_total.reject_captcha.Inc()
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+	respFailExtra(w, r, http.StatusForbidden, ErrorCode_CAPTCHA_REQUIRED.MessageObj(), map[string]any{
+		"captcha": h.captchaObj(),
+	})
+	return false
+}
+
+// captchaObj gets the information required to render the CAPTCHA widget.
+func (h *Handler) captchaObj() map[string]any {
+	return map[string]any{
+		"provider": h.Captcha.Provider,
+		"sitekey":  h.Captcha.SiteKey,
+		"submit":   "/client/captcha",
+	}
+}
+
+// verifyCaptcha verifies a CAPTCHA response token with the provider.
+func (h *Handler) verifyCaptcha(ctx context.Context, response string, ip netip.Addr) (bool, error) {
+	c := h.Captcha
+
+	u := c.VerifyURL
+	if u == "" {
+		u = c.Provider.verifyURL()
+	}
+	if u == "" {
+		return false, fmt.Errorf("unknown captcha provider %q", c.Provider)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, captchaVerifyTimeout)
+	defer cancel()
+
+	form := url.Values{
+		"secret":   {c.Secret},
+		"response": {response},
+		"sitekey":  {c.SiteKey},
+	}
+	if ip.IsValid() {
+		form.Set("remoteip", ip.String())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	hc := c.Client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("response status %d", resp.StatusCode)
+	}
+
+	var obj struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&obj); err != nil {
+		return false, fmt.Errorf("decode response: %w", err)
+	}
+	if !obj.Success {
+		for _, x := range obj.ErrorCodes {
+			switch x {
+			case "missing-input-secret", "invalid-input-secret", "sitekey-secret-mismatch", "internal-error":
+				return false, fmt.Errorf("provider error: %s", x)
+			}
+		}
+	}
+	return obj.Success, nil
+}
+
+func (h *Handler) handleClientCaptcha(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.m().client_captcha_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.Captcha.Provider == "" {
+		h.m().client_captcha_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("captcha is not enabled"))
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		h.m().client_captcha_requests_total.success_captcha.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"captcha": h.captchaObj(),
+		})
+		return
+	}
+
+	ip := captchaIP(r)
+	if !ip.IsValid() {
+		hlog.FromRequest(r).Error().
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().client_captcha_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	response := r.URL.Query().Get("response")
+	if response == "" {
+		response = r.PostFormValue("response")
+	}
+	if response == "" || len(response) > 4096 {
+		h.m().client_captcha_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("response param is required"))
+		return
+	}
+
+	ok, err := h.verifyCaptcha(r.Context(), response, ip)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to verify captcha")
+		h.m().client_captcha_requests_total.fail_provider_error.Inc()
+		respFail(w, r, http.StatusBadGateway, ErrorCode_INTERNAL_SERVER_ERROR.MessageObjf("failed to verify captcha"))
+		return
+	}
+	if !ok {
+		h.m().client_captcha_requests_total.reject_invalid_response.Inc()
+		respFailExtra(w, r, http.StatusBadRequest, ErrorCode_CAPTCHA_REQUIRED.MessageObjf("incorrect captcha response"), map[string]any{
+			"captcha": h.captchaObj(),
+		})
+		return
+	}
+
+	tok := h.signToken("captcha", ip.AsSlice(), captchaClearanceTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     captchaCookie,
+		Value:    tok,
+		Path:     "/",
+		MaxAge:   int(captchaClearanceTTL / time.Second),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode, // the server browser may be on another origin
+	})
+
+	h.m().client_captcha_requests_total.success_clearance.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":   true,
+		"clearance": tok,
+		"expires":   time.Now().Add(captchaClearanceTTL).Unix(),
+	})
+}
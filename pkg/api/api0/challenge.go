@@ -0,0 +1,343 @@
+package api0
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"math/bits"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+const (
+	challengeTTL = time.Minute * 2
+	clearanceTTL = time.Minute * 15
+
+	// challengeNoncesMax is the maximum number of solved challenges to
+	// remember for rejecting replays.
+	challengeNoncesMax = 100000
+
+	// clearanceCookie is the cookie containing the clearance token. It may
+	// also be provided in the X-Atlas-Clearance header.
+	clearanceCookie = "atlas_clearance"
+)
+
+// AttackMode configures the proof-of-work challenge required for clients to
+// access the busiest unauthenticated endpoints while under attack. Unlike
+// per-IP rate limits, every client can get through by solving a challenge, so
+// real players behind CGNAT aren't locked out by abuse from a shared address.
+// Each challenge can only be solved once, and the clearance is bound to the
+// network (/24 for IPv4, /64 for IPv6) of the client which solved it, so it
+// can't be handed out to other networks but still survives IPv6 privacy
+// address rotation and NAT address pools.
+type AttackMode struct {
+	// Enabled controls whether the challenge is required.
+	Enabled bool `json:"enabled"`
+
+	// Difficulty is the number of leading zero bits required in the
+	// solution hash. If zero, a reasonable default is used.
+	Difficulty int `json:"difficulty,omitempty"`
+
+	// Paths overrides the paths requiring the challenge. If empty, a
+	// reasonable default is used.
+	Paths []string `json:"paths,omitempty"`
+
+	// Until, if non-zero, automatically disables attack mode after the
+	// specified time.
+	Until time.Time `json:"until"`
+}
+
+// defaultAttackModePaths are the busiest unauthenticated endpoints.
+var defaultAttackModePaths = []string{
+	"/client/servers",
+	"/client/mainmenupromos",
+	"/client/motd",
+	"/accounts/lookup_uid",
+	"/accounts/get_username",
+	"/player/pdata",
+	"/player/info",
+	"/player/stats",
+	"/player/loadout",
+}
+
+// Active checks whether attack mode applies to path at t.
+func (a AttackMode) Active(path string, t time.Time) bool {
+	if !a.Enabled || (!a.Until.IsZero() && !t.Before(a.Until)) {
+		return false
+	}
+	ps := a.Paths
+	if len(ps) == 0 {
+		ps = defaultAttackModePaths
+	}
+	for _, p := range ps {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// difficulty gets the effective difficulty.
+func (a AttackMode) difficulty() int {
+	switch {
+	case a.Difficulty <= 0:
+		return 18
+	case a.Difficulty > 32:
+		return 32
+	default:
+		return a.Difficulty
+	}
+}
+
+// attackMode gets the effective attack mode configuration.
+func (h *Handler) attackMode(r *http.Request) AttackMode {
+	o, err := h.attackModeOverride.Get(h.StateStorage, "attackmode")
+	if err != nil {
+		hlog.FromRequest(r).Warn().Err(err).Msg("failed to load attack mode override, using configured attack mode")
+	} else if o != nil {
+		return *o
+	}
+	return h.cfg().AttackMode
+}
+
+// checkChallenge checks if r requires a proof-of-work challenge, and if so,
+// whether it has a valid clearance. If not, it writes a challenge response and
+// returns false.
+func (h *Handler) checkChallenge(w http.ResponseWriter, r *http.Request) bool {
+	a := h.attackMode(r)
+	if !a.Active(r.URL.Path, time.Now()) {
+		return true
+	}
+	if r.Method == http.MethodOptions {
+		return true
+	}
+
+	tok := r.Header.Get("X-Atlas-Clearance")
+	if tok == "" {
+		if c, err := r.Cookie(clearanceCookie); err == nil {
+			tok = c.Value
+		}
+	}
+	if tok != "" {
+		if b, ok := h.verifyToken("clearance", tok); ok {
+			if n := clearanceNetwork(r); n != nil && string(b) == string(n) {
+				h.m().challenge_checks_total.success_clearance.Inc()
+				return true
+			}
+		}
+	}
+
+	h.m().challenge_checks_total.reject_challenge.Inc()
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Retry-After", "1")
+	respFailExtra(w, r, http.StatusTooManyRequests, ErrorCode_CHALLENGE_REQUIRED.MessageObj(), map[string]any{
+		"challenge": h.newChallenge(a),
+	})
+	return false
+}
+
+// newChallenge creates a new signed challenge object for a.
+func (h *Handler) newChallenge(a AttackMode) map[string]any {
+	b := make([]byte, 9)
+	if _, err := rand.Read(b[1:]); err != nil {
+		panic(err)
+	}
+	b[0] = byte(a.difficulty())
+	return map[string]any{
+		"algorithm":  "sha256",
+		"challenge":  h.signToken("challenge", b, challengeTTL),
+		"difficulty": a.difficulty(),
+		"submit":     "/client/challenge",
+	}
+}
+
+// clearanceNetwork gets the network prefix of the client which clearances are
+// bound to, or nil if the remote address is invalid. A prefix is used rather
+// than the exact address so clients with IPv6 privacy addresses or behind
+// multiple NAT addresses aren't challenged repeatedly.
+func clearanceNetwork(r *http.Request) []byte {
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	ip := raddr.Addr().Unmap()
+	bits := 64
+	if ip.Is4() {
+		bits = 24
+	}
+	p, err := ip.Prefix(bits)
+	if err != nil {
+		return nil
+	}
+	return p.Addr().AsSlice()
+}
+
+// checkChallengeSolution checks whether sha256(challenge + ":" + solution)
+// has at least difficulty leading zero bits.
+func checkChallengeSolution(challenge, solution string, difficulty int) bool {
+	x := sha256.Sum256([]byte(challenge + ":" + solution))
+	var n int
+	for _, c := range x {
+		z := bits.LeadingZeros8(c)
+		if n += z; z != 8 || n >= difficulty {
+			break
+		}
+	}
+	return n >= difficulty
+}
+
+func (h *Handler) handleClientChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.m().client_challenge_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		h.m().client_challenge_requests_total.success_challenge.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success":   true,
+			"challenge": h.newChallenge(h.attackMode(r)),
+		})
+		return
+	}
+
+	challenge := r.URL.Query().Get("challenge")
+	solution := r.URL.Query().Get("solution")
+	if challenge == "" || solution == "" || len(solution) > 64 {
+		h.m().client_challenge_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("challenge and solution params are required"))
+		return
+	}
+
+	b, ok := h.verifyToken("challenge", challenge)
+	if !ok || len(b) != 9 {
+		h.m().client_challenge_requests_total.reject_invalid_challenge.Inc()
+		respFailExtra(w, r, http.StatusBadRequest, ErrorCode_CHALLENGE_REQUIRED.MessageObjf("invalid or expired challenge"), map[string]any{
+			"challenge": h.newChallenge(h.attackMode(r)),
+		})
+		return
+	}
+
+	if !checkChallengeSolution(challenge, solution, int(b[0])) {
+		h.m().client_challenge_requests_total.reject_invalid_solution.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_CHALLENGE_REQUIRED.MessageObjf("incorrect solution"))
+		return
+	}
+
+	n := clearanceNetwork(r)
+	if n == nil {
+		hlog.FromRequest(r).Error().
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().client_challenge_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	// the challenge is valid until it expires, so remember it until then
+	if !h.challengeNonces.Consume(challenge, time.Now(), challengeTTL, challengeNoncesMax) {
+		h.m().client_challenge_requests_total.reject_reused_challenge.Inc()
+		respFailExtra(w, r, http.StatusBadRequest, ErrorCode_CHALLENGE_REQUIRED.MessageObjf("challenge has already been used"), map[string]any{
+			"challenge": h.newChallenge(h.attackMode(r)),
+		})
+		return
+	}
+
+	tok := h.signToken("clearance", n, clearanceTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     clearanceCookie,
+		Value:    tok,
+		Path:     "/",
+		MaxAge:   int(clearanceTTL / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	h.m().client_challenge_requests_total.success_clearance.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":   true,
+		"clearance": tok,
+		"expires":   time.Now().Add(clearanceTTL).Unix(),
+	})
+}
+
+func (h *Handler) handleAdminAttackMode(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "attackmode"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PUT, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	var a *AttackMode
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success":   true,
+			"effective": h.attackMode(r),
+		})
+		return
+	case http.MethodPut:
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&a); err != nil || a == nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid json: %v", err))
+			return
+		}
+		if a.Difficulty < 0 || a.Difficulty > 32 {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("difficulty must be between 0 and 32"))
+			return
+		}
+	case http.MethodDelete:
+		// reset to the configured attack mode
+	}
+
+	if err := h.attackModeOverride.Update(h.StateStorage, "attackmode", func(*AttackMode) (*AttackMode, error) {
+		return a, nil
+	}); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save attack mode to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":   true,
+		"effective": h.attackMode(r),
+	})
+}
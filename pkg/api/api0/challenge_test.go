@@ -0,0 +1,134 @@
+package api0
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestChallenge(t *testing.T) {
+	h := &Handler{
+		AttackMode: AttackMode{
+			Enabled:    true,
+			Difficulty: 8,
+		},
+	}
+
+	do := func(method, path, remote, clearance string) (int, map[string]any) {
+		t.Helper()
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = remote
+		if clearance != "" {
+			r.Header.Set("X-Atlas-Clearance", clearance)
+		}
+		w := httptest.NewRecorder()
+		if h.checkChallenge(w, r) {
+			h.handleClientChallenge(w, r)
+		}
+		var res map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("failed to decode response %q: %v", w.Body.String(), err)
+		}
+		return w.Code, res
+	}
+	challenge := func() string {
+		t.Helper()
+		st, res := do(http.MethodGet, "/client/challenge", "192.0.2.1:1234", "")
+		if st != http.StatusOK {
+			t.Fatalf("get challenge: expected status 200, got %d", st)
+		}
+		c, _ := res["challenge"].(map[string]any)["challenge"].(string)
+		if c == "" {
+			t.Fatalf("get challenge: no challenge in response %v", res)
+		}
+		return c
+	}
+	solve := func(c string) string {
+		for i := 0; ; i++ {
+			if s := strconv.Itoa(i); checkChallengeSolution(c, s, 8) {
+				return s
+			}
+		}
+	}
+	submit := func(c, s, remote string) (int, string) {
+		t.Helper()
+		st, res := do(http.MethodPost, "/client/challenge?challenge="+url.QueryEscape(c)+"&solution="+url.QueryEscape(s), remote, "")
+		tok, _ := res["clearance"].(string)
+		return st, tok
+	}
+	protected := func(remote, clearance string) bool {
+		r := httptest.NewRequest(http.MethodGet, "/client/servers", nil)
+		r.RemoteAddr = remote
+		if clearance != "" {
+			r.Header.Set("X-Atlas-Clearance", clearance)
+		}
+		return h.checkChallenge(httptest.NewRecorder(), r)
+	}
+
+	if protected("192.0.2.1:1234", "") {
+		t.Errorf("expected protected path to require a challenge")
+	}
+
+	c := challenge()
+	s := solve(c)
+
+	for i := 0; ; i++ {
+		if x := "x" + strconv.Itoa(i); !checkChallengeSolution(c, x, 8) {
+			if st, tok := submit(c, x, "192.0.2.1:1234"); st != http.StatusBadRequest || tok != "" {
+				t.Errorf("incorrect solution: expected status 400 and no clearance, got %d %q", st, tok)
+			}
+			break
+		}
+	}
+
+	st, tok := submit(c, s, "192.0.2.1:1234")
+	if st != http.StatusOK || tok == "" {
+		t.Fatalf("solve challenge: expected status 200 and a clearance, got %d %q", st, tok)
+	}
+
+	if st, tok := submit(c, s, "192.0.2.1:1234"); st != http.StatusBadRequest || tok != "" {
+		t.Errorf("replayed challenge: expected status 400 and no clearance, got %d %q", st, tok)
+	}
+	if st, tok := submit(c, s, "198.51.100.1:1234"); st != http.StatusBadRequest || tok != "" {
+		t.Errorf("replayed challenge from another ip: expected status 400 and no clearance, got %d %q", st, tok)
+	}
+
+	for _, tc := range []struct {
+		remote string
+		ok     bool
+	}{
+		{"192.0.2.1:1234", true},
+		{"192.0.2.1:4321", true},
+		{"192.0.2.254:1234", true},
+		{"[::ffff:192.0.2.2]:1234", true},
+		{"192.0.3.1:1234", false},
+		{"198.51.100.1:1234", false},
+		{"[2001:db8::1]:1234", false},
+	} {
+		if protected(tc.remote, tok) != tc.ok {
+			if tc.ok {
+				t.Errorf("clearance from %s: expected to be allowed", tc.remote)
+			} else {
+				t.Errorf("clearance from %s: expected to be rejected", tc.remote)
+			}
+		}
+	}
+	if protected("192.0.2.1:1234", tok+"x") {
+		t.Errorf("expected invalid clearance to be rejected")
+	}
+
+	c = challenge()
+	if st, tok6 := submit(c, solve(c), "[2001:db8:0:1::1]:1234"); st != http.StatusOK || tok6 == "" {
+		t.Errorf("solve challenge over ipv6: expected status 200 and a clearance, got %d %q", st, tok6)
+	} else {
+		if !protected("[2001:db8:0:1:ffff::2]:1234", tok6) {
+			t.Errorf("expected ipv6 clearance to be allowed from the same /64")
+		}
+		if protected("[2001:db8:0:2::1]:1234", tok6) {
+			t.Errorf("expected ipv6 clearance to be rejected from a different /64")
+		}
+	}
+}
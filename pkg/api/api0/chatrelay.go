@@ -0,0 +1,512 @@
+package api0
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/r2northstar/atlas/pkg/websocket"
+	"github.com/rs/zerolog/hlog"
+)
+
+// ChatChannel is a relayed chat channel.
+type ChatChannel string
+
+const (
+	ChatChannelGlobal ChatChannel = "global" // global chat, shown in-game on every participating server
+	ChatChannelLobby  ChatChannel = "lobby"  // lobby chat, shown in the lobby of every participating server
+)
+
+// ChatMessage is a chat message relayed between servers.
+type ChatMessage struct {
+	Time       time.Time   `json:"time"`
+	Channel    ChatChannel `json:"channel"`
+	ServerID   string      `json:"server_id"`
+	ServerName string      `json:"server_name"`
+	UID        uint64      `json:"uid"`
+	Username   string      `json:"username,omitempty"`
+	Text       string      `json:"text"`
+}
+
+// ChatMute prevents a player's messages from being relayed.
+type ChatMute struct {
+	UID    uint64 `json:"uid" validate:"required"`
+	Reason string `json:"reason,omitempty" validate:"max=256"`
+
+	// Created is when the mute was added. If zero when adding, it is set to
+	// the current time.
+	Created time.Time `json:"created"`
+
+	// Until, if non-zero, is when the mute expires.
+	Until time.Time `json:"until"`
+}
+
+// Active checks whether m is in effect at t.
+func (m ChatMute) Active(t time.Time) bool {
+	return m.Until.IsZero() || t.Before(m.Until)
+}
+
+// chatMaxLength is the maximum length of a relayed message in bytes.
+const chatMaxLength = 256
+
+// chatRelay tracks connected chat relay servers.
+type chatRelay struct {
+	mu    sync.Mutex
+	conns map[*chatConn]struct{}
+}
+
+// chatConn is a server connected to the chat relay.
+type chatConn struct {
+	serverID string
+	channels map[ChatChannel]bool
+	send     chan []byte
+}
+
+func (c *chatRelay) add(x *chatConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conns == nil {
+		c.conns = map[*chatConn]struct{}{}
+	}
+	c.conns[x] = struct{}{}
+}
+
+func (c *chatRelay) remove(x *chatConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.conns, x)
+}
+
+// broadcast sends buf (or the one in byServer for the server the connection is
+// for) to every connection subscribed to ch other than from, returning the
+// number of connections the message was dropped for.
+func (c *chatRelay) broadcast(from *chatConn, ch ChatChannel, buf []byte, byServer map[string][]byte) (dropped int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for x := range c.conns {
+		if x == from || !x.channels[ch] {
+			continue
+		}
+		b := buf
+		if v, ok := byServer[x.serverID]; ok {
+			b = v
+		}
+		select {
+		case x.send <- b:
+		default:
+			dropped++
+		}
+	}
+	return
+}
+
+// cleanChatText normalizes s and removes control characters, returning an
+// empty string if nothing is left.
+func cleanChatText(s string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s))
+}
+
+// chatMuted checks whether uid is muted at t.
+func (h *Handler) chatMuted(uid uint64, t time.Time) (bool, error) {
+	ms, err := h.chatMutes.Get(h.StateStorage, "chatmutes")
+	if err != nil {
+		return false, err
+	}
+	for _, m := range ms {
+		if m.UID == uid && m.Active(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (h *Handler) handleServerChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodGet {
+		h.m().server_chat_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.ChatRelay {
+		h.m().server_chat_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("chat relay is not enabled"))
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_chat_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	var q struct {
+		ID       string `param:"id" validate:"required"`
+		Channels string `param:"channels"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().server_chat_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+
+	channels := map[ChatChannel]bool{
+		ChatChannelGlobal: true,
+		ChatChannelLobby:  true,
+	}
+	if q.Channels != "" {
+		for ch := range channels {
+			channels[ch] = false
+		}
+		for _, x := range strings.Split(q.Channels, ",") {
+			if _, ok := channels[ChatChannel(x)]; !ok {
+				h.m().server_chat_requests_total.reject_bad_request.Inc()
+				respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("unknown channel %q", x))
+				return
+			}
+			channels[ChatChannel(x)] = true
+		}
+	}
+
+	srv := h.ServerList.GetServerByID(q.ID)
+	if srv == nil {
+		h.m().server_chat_requests_total.reject_server_not_found.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such game server"))
+		return
+	}
+	if srv.Addr.Addr() != raddr.Addr() {
+		h.m().server_chat_requests_total.reject_unauthorized_ip.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
+		return
+	}
+
+	if !websocket.IsUpgrade(r) {
+		h.m().server_chat_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("websocket upgrade required"))
+		return
+	}
+
+	c, err := websocket.Upgrade(w, r)
+	if err != nil {
+		h.m().server_chat_requests_total.reject_bad_request.Inc()
+		hlog.FromRequest(r).Debug().Err(err).Msg("failed to upgrade chat relay websocket")
+		return
+	}
+	defer c.Close()
+	h.m().server_chat_requests_total.success.Inc()
+
+	cc := &chatConn{
+		serverID: srv.ID,
+		channels: channels,
+		send:     make(chan []byte, 64),
+	}
+	h.chatRelay.add(cc)
+	defer h.chatRelay.remove(cc)
+
+	reply := func(obj map[string]any) {
+		buf, err := json.Marshal(obj)
+		if err != nil {
+			panic(err)
+		}
+		select {
+		case cc.send <- buf:
+		default:
+			h.m().server_chat_messages_total.dropped_slow_consumer.Inc()
+		}
+	}
+
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			op, buf, err := c.Read()
+			if err != nil {
+				return
+			}
+			if op != websocket.OpText {
+				continue
+			}
+			if err := h.relayChat(cc, raddr.Addr(), buf); err != nil {
+				reply(map[string]any{
+					"type":  "error",
+					"error": err.Error(),
+				})
+			}
+		}
+	}()
+
+	tk := time.NewTicker(time.Second * 30)
+	defer tk.Stop()
+
+	for {
+		select {
+		case buf := <-cc.send:
+			if c.WriteText(buf) != nil {
+				return
+			}
+		case <-tk.C:
+			// disconnect servers which are no longer listed
+			if x := h.ServerList.GetServerByID(cc.serverID); x == nil || x.Addr.Addr() != raddr.Addr() {
+				return
+			}
+			if c.Write(websocket.OpPing, nil) != nil {
+				return
+			}
+		case <-gone:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// relayChat handles a message received from a chat relay connection. If the
+// message is rejected, the returned error is sent back to the server.
+func (h *Handler) relayChat(cc *chatConn, addr netip.Addr, buf []byte) error {
+	var req struct {
+		Channel ChatChannel `json:"channel"`
+		UID     uint64      `json:"uid" validate:"required"`
+		Text    string      `json:"text" validate:"required"`
+	}
+	if err := json.Unmarshal(buf, &req); err != nil {
+		h.m().server_chat_messages_total.reject_bad_request.Inc()
+		return fmt.Errorf("invalid message: %w", err)
+	}
+	if err := validate(req); err != nil {
+		h.m().server_chat_messages_total.reject_bad_request.Inc()
+		return err
+	}
+	if req.Channel != ChatChannelGlobal && req.Channel != ChatChannelLobby {
+		h.m().server_chat_messages_total.reject_bad_request.Inc()
+		return fmt.Errorf("unknown channel %q", req.Channel)
+	}
+	if req.Text = cleanChatText(req.Text); req.Text == "" || len(req.Text) > chatMaxLength {
+		h.m().server_chat_messages_total.reject_bad_request.Inc()
+		return fmt.Errorf("text must be between 1 and %d bytes", chatMaxLength)
+	}
+
+	srv := h.ServerList.GetServerByID(cc.serverID)
+	if srv == nil || srv.Addr.Addr() != addr {
+		h.m().server_chat_messages_total.reject_bad_request.Inc()
+		return fmt.Errorf("no such game server")
+	}
+
+	now := time.Now()
+
+	acct, err := h.AccountStorage.GetAccount(req.UID)
+	if err != nil {
+		h.m().server_chat_messages_total.fail_storage_error_account.Inc()
+		return fmt.Errorf("internal server error")
+	}
+	if acct == nil {
+		h.m().server_chat_messages_total.reject_player_not_found.Inc()
+		return fmt.Errorf("no such player %d", req.UID)
+	}
+	if acct.LastServerID != srv.ID {
+		h.m().server_chat_messages_total.reject_player_not_on_server.Inc()
+		return fmt.Errorf("player %d is not on this server", req.UID)
+	}
+
+	if muted, err := h.chatMuted(req.UID, now); err != nil {
+		h.m().server_chat_messages_total.fail_storage_error_state.Inc()
+		return fmt.Errorf("internal server error")
+	} else if muted {
+		h.m().server_chat_messages_total.reject_muted.Inc()
+		return fmt.Errorf("player %d is muted", req.UID)
+	}
+	if h.MuteReportStorage != nil {
+		if st, err := h.getMuteStatus(req.UID, now); err != nil {
+			h.m().server_chat_messages_total.fail_storage_error_mute.Inc()
+			return fmt.Errorf("internal server error")
+		} else if st[MuteKindChat].Muted {
+			h.m().server_chat_messages_total.reject_muted.Inc()
+			return fmt.Errorf("player %d is muted", req.UID)
+		}
+	}
+
+	if f := h.cfg().CleanBadWords; f != nil {
+		req.Text = f(req.Text)
+	}
+
+	m := ChatMessage{
+		Time:       now.UTC(),
+		Channel:    req.Channel,
+		ServerID:   srv.ID,
+		ServerName: srv.Name,
+		UID:        acct.UID,
+		Username:   acct.Username,
+		Text:       req.Text,
+	}
+
+	// servers should hide the message from the players in hidden_from, who
+	// have blocked or been blocked by the sender
+	hidden, err := h.chatHiddenFrom(acct.UID, now)
+	if err != nil {
+		h.m().server_chat_messages_total.fail_storage_error_blocks.Inc()
+		return fmt.Errorf("internal server error")
+	}
+	marshal := func(hiddenFrom []uint64) []byte {
+		obj, err := json.Marshal(struct {
+			Type string `json:"type"`
+			ChatMessage
+			HiddenFrom []uint64 `json:"hidden_from,omitempty"`
+		}{"message", m, hiddenFrom})
+		if err != nil {
+			panic(err)
+		}
+		return obj
+	}
+	byServer := make(map[string][]byte, len(hidden))
+	for id, uids := range hidden {
+		byServer[id] = marshal(uids)
+	}
+	if n := h.chatRelay.broadcast(cc, m.Channel, marshal(nil), byServer); n != 0 {
+		h.m().server_chat_messages_total.dropped_slow_consumer.Add(n)
+	}
+	if h.ChatBridge != nil {
+		h.ChatBridge(m)
+	}
+	h.m().server_chat_messages_total.success.Inc()
+	return nil
+}
+
+func (h *Handler) handleAdminChatMutes(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "chatmutes"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	if r.Method == http.MethodHead || r.Method == http.MethodGet {
+		ms, err := h.chatMutes.Get(h.StateStorage, "chatmutes")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load chat mutes from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if ms == nil {
+			ms = []ChatMute{}
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"mutes":   ms,
+		})
+		return
+	}
+
+	now := time.Now()
+
+	var fn func(ms []ChatMute) []ChatMute
+	switch r.Method {
+	case http.MethodPost:
+		var m ChatMute
+		if err := decodeJSON(r, &m); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		if m.Created.IsZero() {
+			m.Created = now.UTC().Truncate(time.Second)
+		}
+		fn = func(ms []ChatMute) []ChatMute {
+			for i := range ms {
+				if ms[i].UID == m.UID {
+					ms[i] = m
+					return ms
+				}
+			}
+			return append(ms, m)
+		}
+	case http.MethodDelete:
+		var q struct {
+			UID uint64 `param:"uid" validate:"required"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func(ms []ChatMute) []ChatMute {
+			for i := range ms {
+				if ms[i].UID == q.UID {
+					return append(ms[:i], ms[i+1:]...)
+				}
+			}
+			return ms
+		}
+	}
+
+	var nms []ChatMute
+	if err := h.chatMutes.Update(h.StateStorage, "chatmutes", func(ms []ChatMute) ([]ChatMute, error) {
+		// drop expired mutes while we're here
+		nms = nil
+		for _, m := range fn(append([]ChatMute(nil), ms...)) {
+			if m.Active(now) {
+				nms = append(nms, m)
+			}
+		}
+		return nms, nil
+	}); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save chat mutes to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if nms == nil {
+		nms = []ChatMute{}
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"mutes":   nms,
+	})
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/r2northstar/atlas/pkg/api/api0/api0gameserver"
 	"github.com/r2northstar/atlas/pkg/eax"
+	"github.com/r2northstar/atlas/pkg/msgpack"
 	"github.com/r2northstar/atlas/pkg/origin"
 	"github.com/r2northstar/atlas/pkg/pdata"
 	"github.com/r2northstar/atlas/pkg/stryder"
@@ -115,7 +116,7 @@ func (h *Handler) handleClientOriginAuth(w http.ResponseWriter, r *http.Request)
 
 	if !h.CheckLauncherVersion(r, true) {
 		h.m().client_originauth_requests_total.reject_versiongate.Inc()
-		respFail(w, r, http.StatusBadRequest, ErrorCode_UNSUPPORTED_VERSION.MessageObj())
+		h.respUpdateRequired(w, r, true)
 		return
 	}
 
@@ -127,7 +128,7 @@ func (h *Handler) handleClientOriginAuth(w http.ResponseWriter, r *http.Request)
 	}
 
 	uid, err := strconv.ParseUint(uidQ, 10, 64)
-	if err != nil {
+	if err != nil || IsGuestUID(uid) {
 		h.m().client_originauth_requests_total.reject_bad_request.Inc()
 		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
 		return
@@ -143,12 +144,18 @@ func (h *Handler) handleClientOriginAuth(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !h.checkAnomaly(w, r, raddr.Addr()) {
+		h.m().client_originauth_requests_total.reject_anomaly.Inc()
+		return
+	}
+
 	select {
 	case <-r.Context().Done(): // check if the request was canceled to avoid making unnecessary requests
 		return
 	default:
 	}
 
+	var staleVerified, authed bool
 	if !h.InsecureDevNoCheckPlayerAuth {
 		token := r.URL.Query().Get("token")
 		if token == "" {
@@ -157,17 +164,46 @@ func (h *Handler) handleClientOriginAuth(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		stryderStart := time.Now()
+		var stryderRes []byte
+		if stryderStart := time.Now(); h.originBreaker.Allow(stryderStart, h.OriginBreakerThreshold) {
+			stryderCtx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+			defer cancel()
 
-		stryderCtx, cancel := context.WithTimeout(r.Context(), time.Second*5)
-		defer cancel()
+			stryderRes, err = stryder.NucleusAuth(stryderCtx, token, uid)
+			h.m().client_originauth_stryder_auth_duration_seconds.UpdateDuration(stryderStart)
 
-		stryderRes, err := stryder.NucleusAuth(stryderCtx, token, uid)
-		h.m().client_originauth_stryder_auth_duration_seconds.UpdateDuration(stryderStart)
+			switch {
+			case err == nil,
+				errors.Is(err, stryder.ErrInvalidGame),
+				errors.Is(err, stryder.ErrInvalidToken),
+				errors.Is(err, stryder.ErrMultiplayerNotAllowed):
+				h.originBreaker.Success()
+			case errors.Is(err, context.Canceled):
+				h.originBreaker.Cancel()
+			default:
+				h.originBreaker.Failure(time.Now(), h.OriginBreakerThreshold, h.originBreakerCooldown())
+			}
+		} else {
+			err = errOriginUnavailable
+		}
+		if err != nil && h.DegradedAuthMaxAge > 0 && h.originBreaker.Open(h.OriginBreakerThreshold) {
+			switch {
+			case errors.Is(err, context.Canceled):
+			case errors.Is(err, stryder.ErrInvalidGame):
+			case errors.Is(err, stryder.ErrInvalidToken):
+			case errors.Is(err, stryder.ErrMultiplayerNotAllowed):
+			default:
+				// we'll check if the account was verified recently after
+				// getting it
+				staleVerified, err = true, nil
+			}
+		}
 		if err != nil {
 			switch {
 			case errors.Is(err, context.Canceled):
 				// ignore
+			case errors.Is(err, errOriginUnavailable):
+				h.m().client_originauth_requests_total.fail_origin_unavailable.Inc()
 			case errors.Is(err, stryder.ErrInvalidGame):
 				h.m().client_originauth_requests_total.reject_stryder_invalidgame.Inc()
 			case errors.Is(err, stryder.ErrInvalidToken):
@@ -191,7 +227,8 @@ func (h *Handler) handleClientOriginAuth(w http.ResponseWriter, r *http.Request)
 					Str("stryder_token", string(token)).
 					Str("stryder_resp", string(stryderRes)).
 					Msgf("invalid stryder token")
-				respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAME.MessageObj())
+				h.anomalyFailure(r, raddr.Addr(), "invalid stryder token")
+				respError(w, r, err)
 				return
 			case errors.Is(err, stryder.ErrStryder):
 				hlog.FromRequest(r).Error().
@@ -200,7 +237,10 @@ func (h *Handler) handleClientOriginAuth(w http.ResponseWriter, r *http.Request)
 					Str("stryder_token", string(token)).
 					Str("stryder_resp", string(stryderRes)).
 					Msgf("unexpected stryder error")
-				respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+				respError(w, r, err)
+				return
+			case errors.Is(err, errOriginUnavailable):
+				respFail(w, r, http.StatusServiceUnavailable, ErrorCode_INTERNAL_SERVER_ERROR.MessageObjf("stryder is down: %v", err))
 				return
 			default:
 				if !errors.Is(err, context.Canceled) {
@@ -215,6 +255,35 @@ func (h *Handler) handleClientOriginAuth(w http.ResponseWriter, r *http.Request)
 				return
 			}
 		}
+
+		if h.AuthReplayWindow > 0 {
+			max := h.AuthReplayMaxTokens
+			if max <= 0 {
+				max = 100000
+			}
+			nonce := strconv.FormatUint(uid, 10) + ":" + token
+			if !h.authNonces.Consume(nonce, time.Now(), h.AuthReplayWindow, max) {
+				hlog.FromRequest(r).Warn().
+					Uint64("uid", uid).
+					Msgf("rejected reused stryder token")
+				h.m().client_originauth_requests_total.reject_token_replay.Inc()
+				h.anomalyFailure(r, raddr.Addr(), "reused stryder token")
+				respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAME.MessageObjf("token has already been used"))
+				return
+			}
+			// the token is claimed now so concurrent replays are rejected, but
+			// it's only burned if the auth succeeds
+			defer func() {
+				if !authed {
+					h.authNonces.Release(nonce)
+				}
+			}()
+		}
+	}
+
+	if !h.checkPlayerQuota(w, r, PlayerQuotaAuth, uid) {
+		h.m().client_originauth_requests_total.reject_quota.Inc()
+		return
 	}
 
 	select {
@@ -223,7 +292,10 @@ func (h *Handler) handleClientOriginAuth(w http.ResponseWriter, r *http.Request)
 	default:
 	}
 
-	username := h.lookupUsername(r, uid)
+	var username string
+	if !staleVerified {
+		username = h.lookupUsername(r, uid) // origin is probably down too if degraded
+	}
 
 	select {
 	case <-r.Context().Done(): // check if the request was canceled to avoid making unnecessary requests
@@ -232,38 +304,110 @@ func (h *Handler) handleClientOriginAuth(w http.ResponseWriter, r *http.Request)
 	}
 
 	// note: there's small chance of race conditions here if there are multiple
-	// concurrent origin_auth calls, but since we only ever support one session
-	// at a time per uid, it's not a big deal which token gets saved (if it is
-	// ever a problem, we can change AccountStorage to support transactions)
+	// concurrent origin_auth calls, but since the session policy is only meant
+	// to curb account sharing, it's not a big deal if it's bypassed by
+	// concurrent requests (if it is ever a problem, we can change
+	// AccountStorage to support transactions)
 
-	acct, err := h.AccountStorage.GetAccount(uid)
+	acct, err := h.accountStorage(r).GetAccount(uid)
 	if err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
 			Uint64("uid", uid).
 			Msgf("failed to read account from storage")
 		h.m().client_originauth_requests_total.fail_storage_error_account.Inc()
-		respJSON(w, r, http.StatusInternalServerError, map[string]any{
-			"success": false,
-			"error":   ErrorCode_INTERNAL_SERVER_ERROR,
-			"msg":     ErrorCode_INTERNAL_SERVER_ERROR.Message(),
-		})
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
 		return
 	}
 
+	if staleVerified {
+		if acct == nil || acct.VerifiedAt.IsZero() || time.Since(acct.VerifiedAt) > h.DegradedAuthMaxAge || (!h.DegradedAuthAnyIP && !acct.HasAuthIP(raddr.Addr())) {
+			hlog.FromRequest(r).Info().
+				Uint64("uid", uid).
+				Msgf("rejected player auth while origin is unavailable since the account was not verified recently")
+			h.m().client_originauth_requests_total.reject_stale_verified.Inc()
+			respFail(w, r, http.StatusServiceUnavailable, ErrorCode_INTERNAL_SERVER_ERROR.MessageObjf("stryder is down and the account was not verified recently"))
+			return
+		}
+		hlog.FromRequest(r).Warn().
+			Uint64("uid", uid).
+			Str("username", acct.Username).
+			Time("verified_at", acct.VerifiedAt).
+			Msgf("accepting stale-verified player auth while origin is unavailable")
+	}
+
+	if !h.checkNetworkRules(r, NetworkRuleScopeAuth, raddr.Addr(), uid) {
+		h.m().client_originauth_requests_total.reject_network_rule.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_NETWORK_BLOCKED.MessageObj())
+		return
+	}
+
+	ipFlags := h.lookupIPReputation(r, raddr.Addr())
+	if ipFlags&h.IPReputationVerify != 0 {
+		var links []AccountLink
+		if h.AccountLinkStorage != nil {
+			if links, err = h.AccountLinkStorage.GetAccountLinks(uid); err != nil {
+				hlog.FromRequest(r).Error().
+					Err(err).
+					Uint64("uid", uid).
+					Msgf("failed to read account links from storage")
+				h.m().client_originauth_requests_total.fail_storage_error_link.Inc()
+				respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+				return
+			}
+		}
+		if len(links) == 0 {
+			hlog.FromRequest(r).Info().
+				Uint64("uid", uid).
+				Str("ip_flags", ipFlags.String()).
+				Msgf("rejected player auth from flagged ip since the account has no linked external accounts")
+			h.m().client_originauth_requests_total.reject_ip_reputation.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_VERIFICATION_REQUIRED.MessageObj())
+			return
+		}
+	}
+
+	var rename *UsernameChange
 	if acct != nil && username != "" && acct.Username != username {
 		hlog.FromRequest(r).Info().Uint64("uid", acct.UID).Str("username", username).Str("prev_username", acct.Username).Msg("got updated username")
+		if acct.Username != "" && !strings.EqualFold(acct.Username, username) {
+			rename = &UsernameChange{
+				UID:  acct.UID,
+				Time: time.Now(),
+				From: acct.Username,
+				To:   username,
+			}
+		}
 	}
 	if acct == nil {
 		acct = &Account{
 			UID: uid,
 		}
-		hlog.FromRequest(r).Info().Uint64("uid", acct.UID).Str("username", username).Msg("created new account")
+		if ipFlags != 0 {
+			hlog.FromRequest(r).Info().Uint64("uid", acct.UID).Str("username", username).Str("ip", raddr.Addr().String()).Str("ip_flags", ipFlags.String()).Msg("created new account from flagged ip")
+		} else {
+			hlog.FromRequest(r).Info().Uint64("uid", acct.UID).Str("username", username).Msg("created new account")
+		}
 	}
 	if username != "" {
 		acct.Username = username
 	}
+	if !staleVerified && !h.InsecureDevNoCheckPlayerAuth {
+		acct.VerifiedAt = time.Now()
+	}
+
+	if err := h.runAuthHooks(r, acct); err != nil {
+		h.m().client_originauth_requests_total.reject_hook.Inc()
+		status, obj := hookError(err)
+		respFail(w, r, status, obj)
+		return
+	}
 
+	sess := AccountSession{
+		IP:            raddr.Addr(),
+		StaleVerified: staleVerified,
+		IPFlags:       ipFlags,
+	}
 	if t, err := cryptoRandHex(32); err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
@@ -272,16 +416,31 @@ func (h *Handler) handleClientOriginAuth(w http.ResponseWriter, r *http.Request)
 		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
 		return
 	} else {
-		acct.AuthToken = t
+		sess.Token = t
 	}
 	if h.TokenExpiryTime > 0 {
-		acct.AuthTokenExpiry = time.Now().Add(h.TokenExpiryTime)
+		sess.Expiry = time.Now().Add(h.TokenExpiryTime)
 	} else {
-		acct.AuthTokenExpiry = time.Now().Add(time.Hour * 24)
+		sess.Expiry = time.Now().Add(time.Hour * 24)
+	}
+	if !h.newSession(acct, sess, time.Now()) {
+		hlog.FromRequest(r).Info().
+			Uint64("uid", acct.UID).
+			Str("session_policy", string(h.SessionPolicy)).
+			Strs("session_ips", sessionIPs(acct, time.Now())).
+			Msgf("rejected new session due to session policy")
+		h.m().client_originauth_requests_total.reject_session_policy.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_SESSION_LIMIT.MessageObj())
+		return
 	}
-	acct.AuthIP = raddr.Addr()
 
-	if err := h.AccountStorage.SaveAccount(acct); err != nil {
+	if h.isErased(uid) {
+		h.m().client_originauth_requests_total.reject_erased.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_ACCOUNT_ERASED.MessageObj())
+		return
+	}
+
+	if err := h.accountStorage(r).SaveAccount(acct); err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
 			Uint64("uid", uid).
@@ -290,19 +449,73 @@ func (h *Handler) handleClientOriginAuth(w http.ResponseWriter, r *http.Request)
 		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
 		return
 	}
+	authed = true
 
-	h.m().client_originauth_requests_total.success.Inc()
+	if rename != nil && h.UsernameHistoryStorage != nil {
+		if err := h.UsernameHistoryStorage.SaveUsernameChange(rename); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", uid).
+				Msgf("failed to save username change to storage")
+		}
+	}
+	h.recordAccountSignal(r, uid, AccountSignalIP, raddr.Addr().String())
+
+	if staleVerified {
+		h.m().client_originauth_requests_total.success_stale_verified.Inc()
+	} else {
+		h.m().client_originauth_requests_total.success.Inc()
+	}
 	h.geoCounter2(r, h.m().client_originauth_requests_map)
+	h.analyticsEvent(AnalyticsEvent{
+		Type:            AnalyticsEventPlayerAuth,
+		Player:          h.analyticsPlayer(uid),
+		LauncherVersion: h.ExtractLauncherVersion(r),
+	})
 
-	respJSON(w, r, http.StatusOK, map[string]any{
+	obj := map[string]any{
 		"success": true,
 		"token":   acct.AuthToken,
-	})
+	}
+	if staleVerified {
+		obj["stale_verified"] = true
+	}
+	respJSON(w, r, http.StatusOK, obj)
 }
 
+// usernameCacheTTL is the amount of time to cache found usernames for.
+const usernameCacheTTL = time.Hour
+
 // lookupUsername gets the username for uid according to the configured
-// UsernameSource, returning an empty string if not found or on error.
+// UsernameSource, returning an empty string if not found or on error. Found
+// usernames are cached in Cache, if provided, but the cached username is only
+// used if the lookup fails so renames are picked up on every lookup.
 func (h *Handler) lookupUsername(r *http.Request, uid uint64) (username string) {
+	if h.Cache == nil || h.UsernameSource == UsernameSourceNone {
+		return h.lookupUsernameUncached(r, uid)
+	}
+
+	key := "username:" + string(h.UsernameSource) + ":" + strconv.FormatUint(uid, 10)
+	if username = h.lookupUsernameUncached(r, uid); username != "" {
+		if err := h.cache(r).Set(key, []byte(username), usernameCacheTTL); err != nil {
+			hlog.FromRequest(r).Warn().Err(err).Msg("failed to cache username")
+		}
+		return
+	}
+
+	if buf, exists, err := h.cache(r).Get(key); err != nil {
+		hlog.FromRequest(r).Warn().Err(err).Msg("failed to get cached username")
+		h.m().cache_lookups_total.username_error.Inc()
+	} else if exists {
+		h.m().cache_lookups_total.username_hit.Inc()
+		username = string(buf)
+	} else {
+		h.m().cache_lookups_total.username_miss.Inc()
+	}
+	return
+}
+
+func (h *Handler) lookupUsernameUncached(r *http.Request, uid uint64) (username string) {
 	switch h.UsernameSource {
 	case UsernameSourceNone:
 		break
@@ -486,7 +699,7 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 
 	if !h.CheckLauncherVersion(r, true) {
 		h.m().client_authwithserver_requests_total.reject_versiongate.Inc()
-		respFail(w, r, http.StatusBadRequest, ErrorCode_UNSUPPORTED_VERSION.MessageObj())
+		h.respUpdateRequired(w, r, true)
 		return
 	}
 
@@ -500,6 +713,11 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if !h.checkAnomaly(w, r, raddr.Addr()) {
+		h.m().client_authwithserver_requests_total.reject_anomaly.Inc()
+		return
+	}
+
 	uidQ := r.URL.Query().Get("id")
 	if uidQ == "" {
 		h.m().client_authwithserver_requests_total.reject_bad_request.Inc()
@@ -517,15 +735,30 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 	playerToken := r.URL.Query().Get("playerToken")
 	server := r.URL.Query().Get("server")
 	password := r.URL.Query().Get("password")
+	joinToken := r.URL.Query().Get("joinToken")
 
+	// join tokens have already been checked against the password and
+	// allowlist (they're redeemed after the player token is checked), and
+	// party members can follow their leader without the password
+	var joined bool
 	srv := h.ServerList.GetServerByID(server)
-	if srv == nil || srv.Password != password {
-		h.m().client_authwithserver_requests_total.reject_password.Inc()
-		respFail(w, r, http.StatusUnauthorized, ErrorCode_UNAUTHORIZED_PWD.MessageObj())
-		return
+	if joinToken == "" {
+		if h.JoinTokens.Required {
+			h.m().client_authwithserver_requests_total.reject_join_token.Inc()
+			respFail(w, r, http.StatusUnauthorized, ErrorCode_UNAUTHORIZED_PWD.MessageObjf("a join token is required"))
+			return
+		}
+		if srv == nil || (srv.Password != password && !h.parties.following(uid, srv.ID)) {
+			if srv != nil {
+				h.anomalyFailure(r, raddr.Addr(), "incorrect server password")
+			}
+			h.m().client_authwithserver_requests_total.reject_password.Inc()
+			respFail(w, r, http.StatusUnauthorized, ErrorCode_UNAUTHORIZED_PWD.MessageObj())
+			return
+		}
 	}
 
-	acct, err := h.AccountStorage.GetAccount(uid)
+	acct, err := h.accountStorage(r).GetAccount(uid)
 	if err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
@@ -542,13 +775,51 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 	}
 
 	if !h.InsecureDevNoCheckPlayerAuth {
-		if playerToken != acct.AuthToken || !time.Now().Before(acct.AuthTokenExpiry) {
+		if !acct.CheckAuthToken(playerToken, time.Now()) {
 			h.m().client_authwithserver_requests_total.reject_masterserver_token.Inc()
+			h.anomalyFailure(r, raddr.Addr(), "invalid masterserver token")
 			respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
 			return
 		}
 	}
 
+	// join tokens are single-use, so don't redeem it until we know the
+	// request is actually from the player
+	if joinToken != "" {
+		if !h.redeemJoinToken(w, r, joinToken, uid, srv, raddr.Addr()) {
+			h.m().client_authwithserver_requests_total.reject_join_token.Inc()
+			return
+		}
+		defer func() {
+			if joined {
+				h.m().client_jointoken_joins_total.success.Inc()
+			} else {
+				h.m().client_jointoken_joins_total.fail.Inc()
+			}
+		}()
+	}
+
+	if !h.checkPlayerQuota(w, r, PlayerQuotaAuth, acct.UID) {
+		h.m().client_authwithserver_requests_total.reject_quota.Inc()
+		return
+	}
+
+	// if only one session is allowed, don't let the account play on two
+	// servers from different machines at once
+	if h.SessionPolicy == SessionPolicyDeny {
+		if p, ok := h.playerPresence(acct.UID, time.Now()); ok && p.Server != srv.ID && p.IP != raddr.Addr() {
+			h.m().client_authwithserver_requests_total.reject_duplicate_session.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_SESSION_LIMIT.MessageObjf("already connected to another server from a different location"))
+			return
+		}
+	}
+
+	if ac := (&serverAllowChecker{h: h, r: r, uid: acct.UID}); !ac.Allowed(srv) {
+		h.m().client_authwithserver_requests_total.reject_allowlist.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_CONNECTION_REJECTED.MessageObjf("not on the server allowlist"))
+		return
+	}
+
 	var authToken string
 	if v, err := cryptoRandHex(31); err != nil {
 		hlog.FromRequest(r).Error().
@@ -561,8 +832,23 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 		authToken = v
 	}
 
+	// servers with a signing key also get a signed pre-authorization so they
+	// can verify the connection locally
+	var preauth string
+	if srv.SigningKey != "" {
+		preauth = api0gameserver.SignPreauth(srv.SigningKey, api0gameserver.Preauth{
+			ServerID: srv.ID,
+			UID:      acct.UID,
+			Username: acct.Username,
+			Token:    authToken,
+			IP:       raddr.Addr(),
+			Expires:  time.Now().Add(serverPreauthExpiry).Unix(),
+		})
+		h.m().client_authwithserver_preauth_total.Inc()
+	}
+
 	var pbuf []byte
-	if b, exists, err := h.PdataStorage.GetPdataCached(acct.UID, [sha256.Size]byte{}); err != nil {
+	if b, exists, err := h.pdataStorage(r).GetPdataCached(acct.UID, [sha256.Size]byte{}); err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
 			Uint64("uid", acct.UID).
@@ -576,6 +862,13 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 		pbuf = b
 	}
 
+	// don't notify the game server of erased accounts
+	if h.isErased(uid) {
+		h.m().client_authwithserver_requests_total.reject_erased.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_ACCOUNT_ERASED.MessageObj())
+		return
+	}
+
 	{
 		authStart := time.Now()
 
@@ -583,7 +876,7 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 		defer cancel()
 
 		if srv.AuthPort != 0 {
-			if err := api0gameserver.AuthenticateIncomingPlayer(ctx, srv.AuthAddr(), acct.UID, acct.Username, authToken, srv.ServerAuthToken, pbuf); err != nil {
+			if err := api0gameserver.AuthenticateIncomingPlayer(ctx, srv.AuthAddr(), acct.UID, acct.Username, authToken, srv.ServerAuthToken, preauth, pbuf); err != nil {
 				h.m().client_authwithserver_gameserverauth_duration_seconds.UpdateDuration(authStart)
 				if errors.Is(err, context.DeadlineExceeded) {
 					err = fmt.Errorf("request timed out")
@@ -592,16 +885,13 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 				switch {
 				case errors.As(err, &rej):
 					h.m().client_authwithserver_requests_total.reject_gameserver.Inc()
-					respFail(w, r, http.StatusForbidden, ErrorCode_CONNECTION_REJECTED.MessageObjf("%s", rej.Reason()))
 				case errors.Is(err, api0gameserver.ErrAuthFailed):
 					h.m().client_authwithserver_requests_total.reject_gameserverauth.Inc()
-					respFail(w, r, http.StatusInternalServerError, ErrorCode_JSON_PARSE_ERROR.MessageObj()) // this is kind of misleading... but it's what the original master server did
 				case errors.Is(err, api0gameserver.ErrInvalidResponse):
 					hlog.FromRequest(r).Error().
 						Err(err).
 						Msgf("failed to make gameserver auth request")
 					h.m().client_authwithserver_requests_total.fail_gameserverauth.Inc()
-					respFail(w, r, http.StatusInternalServerError, ErrorCode_BAD_GAMESERVER_RESPONSE.MessageObj())
 				default:
 					if !errors.Is(err, context.Canceled) {
 						hlog.FromRequest(r).Error().
@@ -609,8 +899,8 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 							Msgf("failed to make gameserver auth request")
 						h.m().client_authwithserver_requests_total.fail_gameserverauth.Inc()
 					}
-					respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
 				}
+				respError(w, r, err)
 				return
 			}
 
@@ -626,6 +916,9 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 					"ip":       raddr.Addr().String(),
 					"time":     time.Now().Unix(),
 				}
+				if preauth != "" {
+					obj["preauth"] = preauth
+				}
 
 				key := connectStateKey{
 					ServerID: srv.ID,
@@ -634,6 +927,7 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 
 				ch := make(chan string, 1)
 				h.connect.Store(key, &connectState{
+					uid:   acct.UID,
 					res:   ch,
 					pdata: pbuf,
 				})
@@ -710,7 +1004,15 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 
 	acct.LastServerID = srv.ID
 
-	if err := h.AccountStorage.SaveAccount(acct); err != nil {
+	// check again in case the account was erased while waiting for the game
+	// server so it isn't re-created
+	if h.isErased(uid) {
+		h.m().client_authwithserver_requests_total.reject_erased.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_ACCOUNT_ERASED.MessageObj())
+		return
+	}
+
+	if err := h.accountStorage(r).SaveAccount(acct); err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
 			Uint64("uid", uid).
@@ -720,7 +1022,29 @@ func (h *Handler) handleClientAuthWithServer(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	h.parties.joinedServer(uid, srv.ID)
+	h.presence.Joined(uid, srv.ID, raddr.Addr(), time.Now())
+
+	if h.ServerListCanary != ServerListRankDefault {
+		if h.serverListCanary(r, uid) {
+			h.m().client_servers_variant_joins_total.canary.Inc()
+			if p := h.ServerList.csPosition(srv.ID, h.ServerListCanary); p != 0 {
+				h.m().client_servers_variant_join_position.canary.Update(float64(p))
+			}
+		} else {
+			h.m().client_servers_variant_joins_total.control.Inc()
+			if p := h.ServerList.csPosition(srv.ID, ServerListRankDefault); p != 0 {
+				h.m().client_servers_variant_join_position.control.Update(float64(p))
+			}
+		}
+	}
+
+	joined = true
 	h.m().client_authwithserver_requests_total.success.Inc()
+	h.analyticsEvent(analyticsServer(AnalyticsEvent{
+		Type:   AnalyticsEventPlayerJoin,
+		Player: h.analyticsPlayer(uid),
+	}, srv))
 	respJSON(w, r, http.StatusOK, map[string]any{
 		"success":   true,
 		"ip":        srv.Addr.Addr().String(),
@@ -748,7 +1072,7 @@ func (h *Handler) handleClientAuthWithSelf(w http.ResponseWriter, r *http.Reques
 
 	if !h.CheckLauncherVersion(r, true) {
 		h.m().client_authwithself_requests_total.reject_versiongate.Inc()
-		respFail(w, r, http.StatusBadRequest, ErrorCode_UNSUPPORTED_VERSION.MessageObj())
+		h.respUpdateRequired(w, r, true)
 		return
 	}
 
@@ -768,7 +1092,7 @@ func (h *Handler) handleClientAuthWithSelf(w http.ResponseWriter, r *http.Reques
 
 	playerToken := r.URL.Query().Get("playerToken")
 
-	acct, err := h.AccountStorage.GetAccount(uid)
+	acct, err := h.accountStorage(r).GetAccount(uid)
 	if err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
@@ -785,16 +1109,27 @@ func (h *Handler) handleClientAuthWithSelf(w http.ResponseWriter, r *http.Reques
 	}
 
 	if !h.InsecureDevNoCheckPlayerAuth {
-		if playerToken != acct.AuthToken || !time.Now().Before(acct.AuthTokenExpiry) {
+		if !acct.CheckAuthToken(playerToken, time.Now()) {
 			h.m().client_authwithself_requests_total.reject_masterserver_token.Inc()
 			respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
 			return
 		}
 	}
 
+	if !h.checkPlayerQuota(w, r, PlayerQuotaAuth, acct.UID) {
+		h.m().client_authwithself_requests_total.reject_quota.Inc()
+		return
+	}
+
 	acct.LastServerID = "self"
 
-	if err := h.AccountStorage.SaveAccount(acct); err != nil {
+	if h.isErased(uid) {
+		h.m().client_authwithself_requests_total.reject_erased.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_ACCOUNT_ERASED.MessageObj())
+		return
+	}
+
+	if err := h.accountStorage(r).SaveAccount(acct); err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
 			Uint64("uid", uid).
@@ -810,7 +1145,7 @@ func (h *Handler) handleClientAuthWithSelf(w http.ResponseWriter, r *http.Reques
 	}
 
 	// the way we encode this is utterly absurd and inefficient, but we need to do it for backwards compatibility
-	if b, exists, err := h.PdataStorage.GetPdataCached(acct.UID, [sha256.Size]byte{}); err != nil {
+	if b, exists, err := h.pdataStorage(r).GetPdataCached(acct.UID, [sha256.Size]byte{}); err != nil {
 		hlog.FromRequest(r).Error().
 			Err(err).
 			Uint64("uid", acct.UID).
@@ -862,26 +1197,131 @@ func (h *Handler) handleClientServers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	// only gate NorthstarLauncher since the list is also used by other tools
+	if strings.HasPrefix(r.Header.Get("User-Agent"), "R2Northstar/") && !h.CheckLauncherVersion(r, true) {
+		h.m().client_servers_requests_total.reject_versiongate.Inc()
+		h.respUpdateRequired(w, r, true)
+		return
+	}
 
-	var compressed bool
-	buf := h.ServerList.csGetJSON()
-	for _, e := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
-		if t, _, _ := strings.Cut(e, ";"); strings.TrimSpace(t) == "gzip" {
-			if zbuf, ok := h.ServerList.csGetJSONGzip(); ok {
-				buf = zbuf
-				w.Header().Set("Content-Encoding", "gzip")
-				compressed = true
-			} else {
-				hlog.FromRequest(r).Error().Msg("failed to gzip server list")
+	h.updateEventPins(r)
+
+	// the list can be served as either JSON or MessagePack (see csGetMsgpack)
+	w.Header().Set("Vary", "Accept, Accept-Encoding")
+
+	// if the player is authenticated, servers they're on the allowlist for are
+	// included too
+	var uid uint64
+	if uidQ := r.URL.Query().Get("id"); uidQ != "" {
+		v, err := strconv.ParseUint(uidQ, 10, 64)
+		if err != nil {
+			h.m().client_servers_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid id param"))
+			return
+		}
+		acct, err := h.accountStorage(r).GetAccount(v)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", v).
+				Msgf("failed to read account from storage")
+			h.m().client_servers_requests_total.fail_storage_error_account.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if acct == nil || (!h.InsecureDevNoCheckPlayerAuth && !acct.CheckAuthToken(r.URL.Query().Get("token"), time.Now())) {
+			h.m().client_servers_requests_total.reject_masterserver_token.Inc()
+			respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
+			return
+		}
+		uid = acct.UID
+
+		// so clients and proxies can tell guest sessions apart
+		if IsGuestUID(uid) {
+			w.Header().Set("X-Atlas-Guest", "true")
+		}
+	}
+
+	// if the player is authenticated or hooks can hide servers, the list needs
+	// to be generated for each request
+	var filter func(*Server) bool
+	if hs := h.listQueryHooks(); len(hs) != 0 || uid != 0 {
+		ac := &serverAllowChecker{h: h, r: r, uid: uid}
+		filter = func(srv *Server) bool {
+			if !ac.Visible(srv) {
+				return false
 			}
-			break
+			for _, x := range hs {
+				if !x.OnListQuery(r, srv) {
+					return false
+				}
+			}
+			return true
 		}
 	}
-	if compressed {
-		h.m().client_servers_response_size_bytes.gzip.Update(float64(len(buf)))
+
+	// clients in the canary get the alternate ranking, which isn't cached
+	rank := ServerListRankDefault
+	if h.serverListCanary(r, uid) {
+		rank = h.ServerListCanary
+		w.Header().Set("X-Atlas-Variant", "canary")
+		h.m().client_servers_variant_requests_total.canary.Inc()
 	} else {
+		w.Header().Set("X-Atlas-Variant", "control")
+		h.m().client_servers_variant_requests_total.control.Inc()
+	}
+	uncached := filter != nil || rank != ServerListRankDefault
+
+	// for the recommended sort (see serverHints)
+	if pr := h.clientPingRegion(r); pr != "" {
+		w.Header().Set("X-Atlas-Ping-Region", pr)
+	}
+
+	var buf []byte
+	if negotiateContentType(r, "application/json", msgpack.ContentType+" application/x-msgpack") == msgpack.ContentType {
+		w.Header().Set("Content-Type", msgpack.ContentType)
+
+		// note: not compressed since it's already compact and the clients
+		// which want it are the ones trying to avoid the cpu overhead
+		if uncached {
+			cb := h.ServerList.csGetBuffer()
+			defer h.ServerList.csPutBuffer(cb)
+			buf = h.ServerList.csGetFiltered(cb, true, rank, filter)
+		} else {
+			buf = h.ServerList.csGetMsgpack()
+		}
+		h.m().client_servers_response_size_bytes.msgpack.Update(float64(len(buf)))
+	} else if uncached {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		// note: not gzipped here since it isn't cached, but it may still be
+		// compressed by the http middleware
+		cb := h.ServerList.csGetBuffer()
+		defer h.ServerList.csPutBuffer(cb)
+		buf = h.ServerList.csGetFiltered(cb, false, rank, filter)
 		h.m().client_servers_response_size_bytes.none.Update(float64(len(buf)))
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		var compressed bool
+		buf = h.ServerList.csGetJSON()
+		for _, e := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+			if t, _, _ := strings.Cut(e, ";"); strings.TrimSpace(t) == "gzip" {
+				if zbuf, ok := h.ServerList.csGetJSONGzip(); ok {
+					buf = zbuf
+					w.Header().Set("Content-Encoding", "gzip")
+					compressed = true
+				} else {
+					hlog.FromRequest(r).Error().Msg("failed to gzip server list")
+				}
+				break
+			}
+		}
+		if compressed {
+			h.m().client_servers_response_size_bytes.gzip.Update(float64(len(buf)))
+		} else {
+			h.m().client_servers_response_size_bytes.none.Update(float64(len(buf)))
+		}
 	}
 
 	lver := h.ExtractLauncherVersion(r)
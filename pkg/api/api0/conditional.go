@@ -0,0 +1,102 @@
+package api0
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// conditionalCacheMax is the maximum number of response variants to track
+// before the conditional cache is reset.
+const conditionalCacheMax = 4096
+
+// conditionalCache tracks when generated responses last changed so they can
+// be served with a Last-Modified time.
+type conditionalCache struct {
+	mu sync.Mutex
+	m  map[string]conditionalEntry
+}
+
+type conditionalEntry struct {
+	hash  [sha256.Size]byte
+	since time.Time
+}
+
+// Since gets the time the content for key last changed to one with the
+// provided hash, truncated to the second. If the content hasn't been seen
+// before, now is used.
+func (c *conditionalCache) Since(key string, hash [sha256.Size]byte, now time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.m[key]; ok && e.hash == hash {
+		return e.since
+	}
+	if c.m == nil || len(c.m) >= conditionalCacheMax {
+		c.m = map[string]conditionalEntry{}
+	}
+	e := conditionalEntry{
+		hash:  hash,
+		since: now.Truncate(time.Second),
+	}
+	c.m[key] = e
+	return e.since
+}
+
+// respJSONConditional is like respJSON with http.StatusOK, but sets a weak
+// ETag and a Last-Modified time for the response, responding with 304 Not
+// Modified instead if the client already has it. The response is assumed to
+// only depend on the path, query, and Accept-Language header.
+func (h *Handler) respJSONConditional(w http.ResponseWriter, r *http.Request, obj any) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(obj); err != nil {
+		panic(err)
+	}
+	buf := b.Bytes()
+
+	hash := sha256.Sum256(buf)
+	etag := `W/"` + hex.EncodeToString(hash[:]) + `"`
+	mod := h.conditional.Since(r.URL.Path+"?"+r.URL.RawQuery+"\x00"+r.Header.Get("Accept-Language"), hash, time.Now())
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", mod.UTC().Format(http.TimeFormat))
+
+	if checkNotModified(r, etag, mod) {
+		h.m().conditional_requests_total.not_modified.Inc()
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+		h.m().conditional_requests_total.modified.Inc()
+	}
+
+	w.Header()["Content-Type"] = contentTypeJSON
+	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(buf)
+	}
+}
+
+// checkNotModified checks if a GET or HEAD request is conditional on the
+// response not matching etag or having been modified since mod. As per RFC
+// 9110, If-Modified-Since is ignored if If-None-Match is present.
+func checkNotModified(r *http.Request, etag string, mod time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !mod.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil {
+			return !mod.Truncate(time.Second).After(t)
+		}
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+package api0
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsAllowHeaders are the request headers browsers may send cross-origin.
+const corsAllowHeaders = "Accept, Accept-Language, Content-Type, If-None-Match, If-Modified-Since"
+
+// corsDefaultMethods are the methods allowed cross-origin if CORS.Methods is
+// empty.
+var corsDefaultMethods = []string{http.MethodOptions, http.MethodHead, http.MethodGet, http.MethodPost}
+
+// CORS configures which origins browser-based clients (e.g., web server
+// browsers and server owner portals) can call the API from. Admin endpoints
+// are never allowed cross-origin, regardless of the rules.
+type CORS struct {
+	// Rules contains the origins allowed for each path. Paths without a
+	// matching rule keep their built-in CORS headers (public read-only
+	// endpoints allow any origin, others don't allow cross-origin requests).
+	Rules []CORSRule
+
+	// Methods contains the methods allowed cross-origin. If empty, OPTIONS,
+	// HEAD, GET, and POST are allowed.
+	Methods []string
+
+	// MaxAge is how long browsers may cache preflight responses. If zero,
+	// they aren't cached.
+	MaxAge time.Duration
+}
+
+// CORSRule allows origins to access a path.
+type CORSRule struct {
+	// Path is the path the rule applies to. If it ends with *, it is a prefix.
+	// If multiple rules match, the most specific one is used.
+	Path string
+
+	// Origins contains the allowed origins, as scheme://host[:port], where the
+	// host may start with *. to match any subdomain, or * to allow any
+	// origin.
+	Origins []string
+}
+
+// match checks if path matches the rule, returning the length of the matched
+// part, which is one more for exact matches.
+func (c CORSRule) match(path string) (int, bool) {
+	if strings.HasSuffix(c.Path, "*") {
+		p := strings.TrimSuffix(c.Path, "*")
+		return len(p), strings.HasPrefix(path, p)
+	}
+	return len(c.Path) + 1, path == c.Path
+}
+
+// allowOrigin gets the Access-Control-Allow-Origin value for origin, if
+// allowed.
+func (c CORSRule) allowOrigin(origin string) (string, bool) {
+	origin = strings.ToLower(origin)
+	for _, o := range c.Origins {
+		if o == "*" {
+			return "*", true
+		}
+		if s, h, ok := strings.Cut(o, "://*."); ok {
+			if x := strings.TrimPrefix(origin, s+"://"); x != origin && strings.HasSuffix(x, "."+h) && !strings.ContainsAny(strings.TrimSuffix(x, "."+h), "/:") {
+				return origin, true
+			}
+			continue
+		}
+		if o == origin {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// Rule gets the most specific rule for path. Admin paths never match.
+func (c CORS) Rule(path string) (CORSRule, bool) {
+	var (
+		rule CORSRule
+		best = -1
+	)
+	if isAdminPath(path) {
+		return rule, false
+	}
+	for _, x := range c.Rules {
+		if n, ok := x.match(path); ok && n > best {
+			rule, best = x, n
+		}
+	}
+	return rule, best != -1
+}
+
+func (c CORS) methods() []string {
+	if len(c.Methods) != 0 {
+		return c.Methods
+	}
+	return corsDefaultMethods
+}
+
+// isAdminPath checks if path is an admin endpoint.
+func isAdminPath(path string) bool {
+	return path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+// ParseCORSRules parses comma-separated rules in the format
+// [tenant/]path=origin, returning the rules for tenant (or the default tenant
+// if empty). Paths must start with a slash (so the tenant is everything before
+// the first one), and may end with * to match a prefix. Multiple origins for
+// the same path are combined. Tenant-specific rules for a path replace the
+// default ones.
+func ParseCORSRules(specs []string, tenant string) ([]CORSRule, error) {
+	var (
+		origins  = map[string][]string{}
+		specific = map[string]bool{}
+	)
+	for _, spec := range specs {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		k, origin, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("cors rule %q: expected [tenant/]path=origin", spec)
+		}
+		var t, path string
+		if strings.HasPrefix(k, "/") {
+			path = k
+		} else if t, path, ok = strings.Cut(k, "/"); ok {
+			path = "/" + path
+		} else {
+			return nil, fmt.Errorf("cors rule %q: path must start with a slash", spec)
+		}
+		if strings.Contains(strings.TrimSuffix(path, "*"), "*") {
+			return nil, fmt.Errorf("cors rule %q: path may only contain * at the end", spec)
+		}
+		if isAdminPath(strings.TrimSuffix(path, "*")) {
+			return nil, fmt.Errorf("cors rule %q: admin endpoints cannot be allowed cross-origin", spec)
+		}
+		origin, err := normalizeCORSOrigin(origin)
+		if err != nil {
+			return nil, fmt.Errorf("cors rule %q: %w", spec, err)
+		}
+		if t != tenant {
+			continue
+		}
+		if t != "" && !specific[path] {
+			delete(origins, path)
+			specific[path] = true
+		} else if t == "" && specific[path] {
+			continue
+		}
+		origins[path] = append(origins[path], origin)
+	}
+
+	rules := make([]CORSRule, 0, len(origins))
+	for path, v := range origins {
+		rules = append(rules, CORSRule{Path: path, Origins: v})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Path < rules[j].Path
+	})
+	return rules, nil
+}
+
+// normalizeCORSOrigin validates and lowercases a CORSRule origin.
+func normalizeCORSOrigin(origin string) (string, error) {
+	if origin = strings.ToLower(strings.TrimSpace(origin)); origin == "*" {
+		return origin, nil
+	}
+	u, err := url.Parse(strings.Replace(origin, "://*.", "://x.", 1))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid origin %q (expected scheme://host[:port])", origin)
+	}
+	return origin, nil
+}
+
+// withCORS applies the CORS configuration to cross-origin requests, returning
+// the wrapped response writer. If false is returned, the request was a
+// preflight and has already been responded to.
+//
+// Paths without a matching rule keep the built-in CORS headers set by the
+// handlers, except for admin endpoints, where they are always removed.
+func (h *Handler) withCORS(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, bool) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return w, true
+	}
+	c := h.cfg().CORS
+	rule, ok := c.Rule(r.URL.Path)
+	if !ok && !isAdminPath(r.URL.Path) {
+		return w, true
+	}
+
+	cw := &corsResponseWriter{w: w, methods: strings.Join(c.methods(), ", ")}
+	if ok {
+		cw.origin, cw.allowed = rule.allowOrigin(origin)
+	}
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		h.m().cors_requests_total.preflight.Inc()
+		if cw.allowed {
+			m := r.Header.Get("Access-Control-Request-Method")
+			cw.allowed = false
+			for _, x := range c.methods() {
+				if strings.EqualFold(x, m) {
+					cw.allowed = true
+					break
+				}
+			}
+		}
+		if cw.preflight = true; c.MaxAge > 0 {
+			cw.maxAge = strconv.Itoa(int(c.MaxAge / time.Second))
+		}
+	}
+	switch {
+	case cw.allowed:
+		h.m().cors_requests_total.success.Inc()
+	case ok:
+		h.m().cors_requests_total.reject_disallowed.Inc()
+	default:
+		h.m().cors_requests_total.reject_admin.Inc()
+	}
+	if cw.preflight {
+		cw.WriteHeader(http.StatusNoContent)
+		return cw, false
+	}
+	return cw, true
+}
+
+// corsResponseWriter replaces the CORS headers set by handlers when the
+// response is written.
+type corsResponseWriter struct {
+	w         http.ResponseWriter
+	origin    string
+	methods   string
+	maxAge    string
+	allowed   bool
+	preflight bool
+	wrote     bool
+}
+
+func (c *corsResponseWriter) Header() http.Header {
+	return c.w.Header()
+}
+
+func (c *corsResponseWriter) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
+func (c *corsResponseWriter) WriteHeader(status int) {
+	if !c.wrote {
+		c.wrote = true
+		hdr := c.w.Header()
+		for k := range hdr {
+			if strings.HasPrefix(k, "Access-Control-") {
+				delete(hdr, k)
+			}
+		}
+		if c.allowed {
+			hdr.Set("Access-Control-Allow-Origin", c.origin)
+			hdr.Set("Access-Control-Allow-Methods", c.methods)
+			if c.preflight {
+				hdr.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+				if c.maxAge != "" {
+					hdr.Set("Access-Control-Max-Age", c.maxAge)
+				}
+			}
+		}
+		if c.origin != "*" {
+			hdr.Add("Vary", "Origin")
+		}
+	}
+	c.w.WriteHeader(status)
+}
+
+func (c *corsResponseWriter) Write(b []byte) (int, error) {
+	if !c.wrote {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.w.Write(b)
+}
@@ -0,0 +1,314 @@
+package api0
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// CrashPruneInterval is the recommended interval for calling PruneCrashes.
+const CrashPruneInterval = time.Hour
+
+// crashSignatureFrames is the number of innermost stack frames used to compute
+// a crash signature. Deeper frames tend to vary between otherwise identical
+// crashes.
+const crashSignatureFrames = 5
+
+// crashSignature computes the signature for a crash fingerprint.
+func crashSignature(f CrashFingerprint) string {
+	s := sha256.New()
+	s.Write([]byte(strings.ToLower(f.Exception) + "\x00" + strings.ToLower(f.Module)))
+	for i, x := range f.Stack {
+		if i == crashSignatureFrames {
+			break
+		}
+		s.Write([]byte("\x00" + strings.ToLower(x)))
+	}
+	return hex.EncodeToString(s.Sum(nil)[:16])
+}
+
+// crashJSON is the admin API representation of a CrashSignature.
+type crashJSON struct {
+	Signature string         `json:"signature"`
+	First     time.Time      `json:"first"`
+	Last      time.Time      `json:"last"`
+	Count     int            `json:"count"`
+	Exception string         `json:"exception,omitempty"`
+	Module    string         `json:"module,omitempty"`
+	Stack     []string       `json:"stack,omitempty"`
+	Versions  map[string]int `json:"versions,omitempty"`
+	Mods      map[string]int `json:"mods,omitempty"`
+}
+
+func newCrashJSON(c CrashSignature) crashJSON {
+	return crashJSON{
+		Signature: c.Signature,
+		First:     c.First.UTC(),
+		Last:      c.Last.UTC(),
+		Count:     c.Count,
+		Exception: c.Fingerprint.Exception,
+		Module:    c.Fingerprint.Module,
+		Stack:     c.Fingerprint.Stack,
+		Versions:  c.Versions,
+		Mods:      c.Mods,
+	}
+}
+
+// crashRetention gets the effective retention for crash signatures.
+func (h *Handler) crashRetention() time.Duration {
+	if h.CrashRetention > 0 {
+		return h.CrashRetention
+	}
+	return time.Hour * 24 * 30
+}
+
+// PruneCrashes deletes crash signatures which haven't been reported within
+// CrashRetention. It should be called every CrashPruneInterval. If
+// CrashStorage is nil, it does nothing.
+func (h *Handler) PruneCrashes(t time.Time) error {
+	if h.CrashStorage == nil {
+		return nil
+	}
+	if err := h.CrashStorage.DeleteCrashesBefore(t.Add(-h.crashRetention())); err != nil {
+		return fmt.Errorf("delete old crashes: %w", err)
+	}
+	return nil
+}
+
+func (h *Handler) handleServerCrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().server_crash_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.CrashStorage == nil {
+		h.m().server_crash_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("crash reports are not enabled"))
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_crash_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		h.m().server_crash_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	srv := h.ServerList.GetServerByID(id)
+	if srv == nil {
+		h.m().server_crash_requests_total.reject_server_not_found.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such game server"))
+		return
+	}
+	if srv.Addr.Addr() != raddr.Addr() {
+		h.m().server_crash_requests_total.reject_unauthorized_ip.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
+		return
+	}
+
+	type crashMod struct {
+		Name    string `json:"name" validate:"required,max=128"`
+		Version string `json:"version" validate:"max=32"`
+	}
+	var req struct {
+		Exception string     `json:"exception" validate:"required,max=256"`
+		Module    string     `json:"module,omitempty" validate:"max=128"`
+		Stack     []string   `json:"stack,omitempty" validate:"max=32"`
+		Version   string     `json:"version" validate:"required,max=32"`
+		Mods      []crashMod `json:"mods,omitempty" validate:"max=128"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		h.m().server_crash_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+	for i, x := range req.Stack {
+		if len(x) > 256 {
+			h.m().server_crash_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("stack frame %d is too long", i))
+			return
+		}
+	}
+
+	now := time.Now()
+	if !h.crashLimiter.Allow(raddr.Addr(), now, h.CrashRateLimit, time.Hour) {
+		h.m().server_crash_requests_total.reject_rate_limited.Inc()
+		respFail(w, r, http.StatusTooManyRequests, ErrorCode_RATE_LIMITED.MessageObjf("too many crash reports from this server"))
+		return
+	}
+
+	c := CrashReport{
+		Time: now,
+		Fingerprint: CrashFingerprint{
+			Exception: req.Exception,
+			Module:    req.Module,
+			Stack:     req.Stack,
+		},
+		Version: req.Version,
+	}
+	c.Signature = crashSignature(c.Fingerprint)
+	for _, m := range req.Mods {
+		c.Mods = append(c.Mods, m.Name+"@"+m.Version)
+	}
+
+	if err := h.CrashStorage.RecordCrash(c); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Str("signature", c.Signature).
+			Msgf("failed to save crash report to storage")
+		h.m().server_crash_requests_total.fail_storage_error_crash.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().server_crash_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":   true,
+		"signature": c.Signature,
+	})
+}
+
+func (h *Handler) handleAdminCrashes(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "crashes"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	if h.CrashStorage == nil {
+		h.m().admin_requests_total.reject_disabled(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	var q struct {
+		Signature string `param:"signature" validate:"max=64"`
+		Since     int64  `param:"since"`
+		Limit     int    `param:"limit" validate:"min=0,max=1000"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+		respError(w, r, err)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if q.Signature == "" {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("signature param is required"))
+			return
+		}
+		if err := h.CrashStorage.DeleteCrash(q.Signature); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Str("signature", q.Signature).
+				Msgf("failed to delete crash from storage")
+			h.m().admin_requests_total.fail_storage_error_crash(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+		})
+		return
+	}
+
+	if q.Signature != "" {
+		c, err := h.CrashStorage.GetCrash(q.Signature)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Str("signature", q.Signature).
+				Msgf("failed to read crash from storage")
+			h.m().admin_requests_total.fail_storage_error_crash(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if c == nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("no such crash"))
+			return
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"crash":   newCrashJSON(*c),
+		})
+		return
+	}
+
+	if q.Limit == 0 {
+		q.Limit = 25
+	}
+	since := time.Now().Add(-time.Hour * 24 * 7)
+	if q.Since != 0 {
+		since = time.UnixMilli(q.Since)
+	}
+
+	cs, err := h.CrashStorage.GetCrashes(since, q.Limit)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to read crashes from storage")
+		h.m().admin_requests_total.fail_storage_error_crash(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	crashes := make([]crashJSON, len(cs))
+	for i, c := range cs {
+		// the full breakdown is only included when getting a single crash
+		c.Mods = nil
+		crashes[i] = newCrashJSON(c)
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"crashes": crashes,
+	})
+}
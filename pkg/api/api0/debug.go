@@ -0,0 +1,26 @@
+package api0
+
+// RegistryStats contains the sizes of the in-memory state of a Handler, for
+// debugging.
+type RegistryStats struct {
+	ServerList        ServerListStats `json:"server_list"`
+	ServerHistory     int             `json:"server_history"`
+	PendingConnects   int             `json:"pending_connects"`
+	AuthNonces        int             `json:"auth_nonces"`
+	ErasureTombstones int             `json:"erasure_tombstones"`
+}
+
+// RegistryStats gets information about the size of the in-memory state.
+func (h *Handler) RegistryStats() RegistryStats {
+	st := RegistryStats{
+		ServerList:        h.ServerList.Stats(),
+		ServerHistory:     h.serverHistory.Len(),
+		AuthNonces:        h.authNonces.Len(),
+		ErasureTombstones: h.erased.Len(),
+	}
+	h.connect.Range(func(_, _ any) bool {
+		st.PendingConnects++
+		return true
+	})
+	return st
+}
@@ -0,0 +1,548 @@
+package api0
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// ServerDelistState is the moderation state of a misbehaving game server.
+// Servers are escalated from warned to delisted to banned.
+type ServerDelistState string
+
+const (
+	ServerDelistNone     ServerDelistState = ""
+	ServerDelistWarned   ServerDelistState = "warned"   // still listed, but the owner is warned
+	ServerDelistDelisted ServerDelistState = "delisted" // can register, but is unlisted (i.e., only joinable by ID)
+	ServerDelistBanned   ServerDelistState = "banned"   // registration is rejected
+)
+
+// next gets the state to escalate s to.
+func (s ServerDelistState) next() ServerDelistState {
+	switch s {
+	case ServerDelistNone:
+		return ServerDelistWarned
+	case ServerDelistWarned:
+		return ServerDelistDelisted
+	default:
+		return ServerDelistBanned
+	}
+}
+
+// ServerDelistConfig configures server delisting.
+type ServerDelistConfig struct {
+	// Warned, Delisted, and Banned are the default durations of each state.
+	// If zero, the state does not expire.
+	Warned   time.Duration
+	Delisted time.Duration
+	Banned   time.Duration
+
+	// Cooldown is how long a server remains warned after being delisted or
+	// banned, so a repeat offense is escalated further.
+	Cooldown time.Duration
+}
+
+func (c ServerDelistConfig) duration(s ServerDelistState) time.Duration {
+	switch s {
+	case ServerDelistWarned:
+		return c.Warned
+	case ServerDelistDelisted:
+		return c.Delisted
+	case ServerDelistBanned:
+		return c.Banned
+	}
+	return 0
+}
+
+// ServerDelist is a moderation action against a game server.
+type ServerDelist struct {
+	// Addr is the game server address (ip:port), or ip to match all servers
+	// on that address.
+	Addr string `json:"addr"`
+
+	// State is the state set by the last action.
+	State ServerDelistState `json:"state"`
+
+	// Reason is the reason for the last action, which is visible to the
+	// server owner.
+	Reason string `json:"reason"`
+
+	// Note is an optional note for admins.
+	Note string `json:"note,omitempty"`
+
+	// Since is when the last action was taken.
+	Since time.Time `json:"since"`
+
+	// Until is when State expires. If zero, it does not expire.
+	Until time.Time `json:"until"`
+
+	// Strikes is the number of actions taken against the server.
+	Strikes int `json:"strikes"`
+
+	// AppealID is the ID of the abuse report for the owner's latest appeal,
+	// if any.
+	AppealID string `json:"appeal_id,omitempty"`
+}
+
+// Match checks if d matches the game server address addr.
+func (d ServerDelist) Match(addr netip.AddrPort) bool {
+	return TrustedServer{Addr: d.Addr}.Match(addr)
+}
+
+// Effective gets the state of d at t, taking into account expiry and the
+// cool-down after a delisting or ban.
+func (d ServerDelist) Effective(t time.Time, cooldown time.Duration) ServerDelistState {
+	if d.Until.IsZero() || t.Before(d.Until) {
+		return d.State
+	}
+	if d.State != ServerDelistWarned && t.Before(d.Until.Add(cooldown)) {
+		return ServerDelistWarned
+	}
+	return ServerDelistNone
+}
+
+// serverDelistJSON is the API representation of a ServerDelist.
+type serverDelistJSON struct {
+	Addr     string            `json:"addr"`
+	State    ServerDelistState `json:"state"` // effective
+	Reason   string            `json:"reason"`
+	Note     string            `json:"note,omitempty"`
+	Since    time.Time         `json:"since"`
+	Until    *time.Time        `json:"until,omitempty"`
+	Strikes  int               `json:"strikes,omitempty"`
+	AppealID string            `json:"appeal_id,omitempty"`
+}
+
+// newServerDelistJSON converts d, including the admin-only fields if admin is
+// true.
+func (h *Handler) newServerDelistJSON(d ServerDelist, t time.Time, admin bool) serverDelistJSON {
+	x := serverDelistJSON{
+		Addr:     d.Addr,
+		State:    d.Effective(t, h.ServerDelists.Cooldown),
+		Reason:   d.Reason,
+		Since:    d.Since,
+		AppealID: d.AppealID,
+	}
+	if x.State == d.State {
+		if !d.Until.IsZero() {
+			u := d.Until
+			x.Until = &u
+		}
+	} else if x.State != ServerDelistNone {
+		u := d.Until.Add(h.ServerDelists.Cooldown)
+		x.Until = &u
+	}
+	if admin {
+		x.Note = d.Note
+		x.Strikes = d.Strikes
+	}
+	return x
+}
+
+// serverDelist gets the delisting for addr and its effective state. If there
+// are multiple, one for the exact address takes precedence. Errors are logged
+// and treated as not delisted.
+func (h *Handler) serverDelist(r *http.Request, addr netip.AddrPort) (ServerDelist, ServerDelistState) {
+	if h.StateStorage == nil || !addr.IsValid() {
+		return ServerDelist{}, ServerDelistNone
+	}
+	ds, err := h.serverDelists.Get(h.StateStorage, "serverdelists")
+	if err != nil {
+		hlog.FromRequest(r).Warn().Err(err).Msg("failed to load server delists, ignoring")
+		return ServerDelist{}, ServerDelistNone
+	}
+	var (
+		m  ServerDelist
+		ok bool
+	)
+	for _, d := range ds {
+		if d.Match(addr) && (!ok || d.Addr == addr.String()) {
+			m, ok = d, true
+		}
+	}
+	if !ok {
+		return ServerDelist{}, ServerDelistNone
+	}
+	return m, m.Effective(time.Now(), h.ServerDelists.Cooldown)
+}
+
+// serverDelistMessage formats the owner-visible reason for a delisting.
+func serverDelistMessage(d ServerDelist, st ServerDelistState) string {
+	msg := "server is " + string(st)
+	if st == d.State && !d.Until.IsZero() {
+		msg += " until " + d.Until.UTC().Format(time.RFC3339)
+	}
+	if d.Reason != "" {
+		msg += ": " + d.Reason
+	}
+	return msg
+}
+
+func (h *Handler) handleAdminServerDelists(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "serverdelists"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	var (
+		changed *ServerDelist
+		fn      func(ds []ServerDelist) ([]ServerDelist, error)
+	)
+	switch r.Method {
+	case http.MethodPost:
+		// if state is empty, the current state is escalated
+		var req struct {
+			Addr     string            `json:"addr" validate:"required"`
+			State    ServerDelistState `json:"state,omitempty" validate:"oneof=warned|delisted|banned"`
+			Reason   string            `json:"reason" validate:"required,max=1024"`
+			Note     string            `json:"note,omitempty" validate:"max=1024"`
+			Duration string            `json:"duration,omitempty"` // Go duration, 0 for no expiry, or empty for the default
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		if _, err := netip.ParseAddrPort(req.Addr); err != nil {
+			if _, err := netip.ParseAddr(req.Addr); err != nil {
+				h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+				respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid addr %q", req.Addr))
+				return
+			}
+		}
+		var dur *time.Duration
+		if req.Duration != "" {
+			v, err := time.ParseDuration(req.Duration)
+			if err != nil || v < 0 {
+				h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+				respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid duration %q", req.Duration))
+				return
+			}
+			dur = &v
+		}
+		fn = func(ds []ServerDelist) ([]ServerDelist, error) {
+			d := ServerDelist{Addr: req.Addr}
+			i := -1
+			for j := range ds {
+				if ds[j].Addr == req.Addr {
+					d, i = ds[j], j
+					break
+				}
+			}
+			if req.State != ServerDelistNone {
+				d.State = req.State
+			} else {
+				d.State = d.Effective(now, h.ServerDelists.Cooldown).next()
+			}
+			d.Reason = req.Reason
+			d.Note = req.Note
+			d.Since = now
+			d.Until = time.Time{}
+			d.Strikes++
+			if v := h.ServerDelists.duration(d.State); dur != nil {
+				if *dur != 0 {
+					d.Until = now.Add(*dur)
+				}
+			} else if v != 0 {
+				d.Until = now.Add(v)
+			}
+			changed = &d
+			if i == -1 {
+				return append(ds, d), nil
+			}
+			ds[i] = d
+			return ds, nil
+		}
+	case http.MethodDelete:
+		var q struct {
+			Addr string `param:"addr" validate:"required"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func(ds []ServerDelist) ([]ServerDelist, error) {
+			for i := range ds {
+				if ds[i].Addr == q.Addr {
+					d := ds[i]
+					d.State, d.Reason = ServerDelistNone, "reinstated"
+					changed = &d
+					return append(ds[:i], ds[i+1:]...), nil
+				}
+			}
+			return ds, nil
+		}
+	}
+
+	var ds []ServerDelist
+	if fn != nil {
+		if err := h.serverDelists.Update(h.StateStorage, "serverdelists", func(cur []ServerDelist) ([]ServerDelist, error) {
+			// drop expired entries while we're here
+			n := make([]ServerDelist, 0, len(cur)+1)
+			for _, d := range cur {
+				if d.Effective(now, h.ServerDelists.Cooldown) != ServerDelistNone {
+					n = append(n, d)
+				}
+			}
+			return fn(n)
+		}); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to save server delists to storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if changed != nil {
+			h.applyServerDelist(r, *changed)
+		}
+	}
+
+	ds, err := h.serverDelists.Get(h.StateStorage, "serverdelists")
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to load server delists from storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	xs := make([]serverDelistJSON, 0, len(ds))
+	for _, d := range ds {
+		if x := h.newServerDelistJSON(d, now, true); x.State != ServerDelistNone {
+			xs = append(xs, x)
+		}
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"delists": xs,
+	})
+}
+
+// applyServerDelist notifies the owner of a changed delisting, and removes
+// matching servers from the list if they are no longer allowed to be listed
+// (they will be unlisted or rejected if they re-register).
+func (h *Handler) applyServerDelist(r *http.Request, d ServerDelist) {
+	var addr netip.AddrPort
+	if a, err := netip.ParseAddrPort(d.Addr); err == nil {
+		addr = a
+	} else if a, err := netip.ParseAddr(d.Addr); err == nil {
+		addr = netip.AddrPortFrom(a, 0)
+	}
+	msg := "server was reinstated"
+	if d.State != ServerDelistNone {
+		msg = serverDelistMessage(d, d.State)
+	}
+	h.serverWebhook(addr, ServerWebhookModeration, "", msg)
+
+	hlog.FromRequest(r).Info().
+		Str("addr", d.Addr).
+		Str("state", string(d.State)).
+		Str("reason", d.Reason).
+		Msg("server delist updated")
+
+	if d.State != ServerDelistDelisted && d.State != ServerDelistBanned {
+		return
+	}
+	var ids []string
+	h.ServerList.GetLiveServers(func(s *Server) bool {
+		if d.Match(s.Addr) && (d.State == ServerDelistBanned || s.Visibility == ServerVisibilityPublic) {
+			ids = append(ids, s.ID)
+		}
+		return true
+	})
+	for _, id := range ids {
+		if srv := h.ServerList.GetServerByID(id); srv != nil && h.ServerList.DeleteServerByID(id) {
+			h.serverEvent(srv.Addr, ServerEventRejected, srv.ID, "%s", msg)
+		}
+	}
+}
+
+func (h *Handler) handleServerDelistAppeal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().server_delistappeal_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.AbuseReportStorage == nil || h.StateStorage == nil {
+		h.m().server_delistappeal_requests_total.reject_disabled.Inc()
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_delistappeal_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	var q struct {
+		Addr    string `param:"addr" validate:"required"`
+		Token   string `param:"token"`
+		Message string `param:"message" validate:"required,max=2048"`
+		Contact string `param:"contact" validate:"max=256"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().server_delistappeal_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+	addr, err := netip.ParseAddrPort(q.Addr)
+	if err != nil {
+		h.m().server_delistappeal_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("addr param must be a game server ip:port"))
+		return
+	}
+
+	// like owner_status, the owner can prove they own the server with the
+	// server auth token (if it's still listed) or the server's ip
+	srv, _ := h.ServerList.GetServerByAddr(addr)
+	if q.Token != "" {
+		if srv == nil || subtle.ConstantTimeCompare([]byte(q.Token), []byte(srv.ServerAuthToken)) != 1 {
+			h.m().server_delistappeal_requests_total.reject_unauthorized.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("invalid server auth token"))
+			return
+		}
+	} else if addr.Addr() != raddr.Addr() {
+		h.m().server_delistappeal_requests_total.reject_unauthorized.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("token param is required if not requesting from the server ip"))
+		return
+	}
+
+	d, st := h.serverDelist(r, addr)
+	if st == ServerDelistNone {
+		h.m().server_delistappeal_requests_total.reject_not_delisted.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("server is not delisted"))
+		return
+	}
+
+	if d.AppealID != "" {
+		rp, err := h.AbuseReportStorage.GetAbuseReport(d.AppealID)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Str("report", d.AppealID).
+				Msgf("failed to read abuse report from storage")
+			h.m().server_delistappeal_requests_total.fail_storage_error_report.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if rp != nil && rp.Status == AbuseReportOpen {
+			h.m().server_delistappeal_requests_total.reject_pending.Inc()
+			respFail(w, r, http.StatusConflict, ErrorCode_BAD_REQUEST.MessageObjf("an appeal is already pending"))
+			return
+		}
+	}
+
+	rid, err := cryptoRandHex(32)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to generate random report id")
+		h.m().server_delistappeal_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	rp := AbuseReport{
+		ID:         rid,
+		Time:       time.Now(),
+		ServerAddr: addr,
+		Reason:     "delist_appeal",
+		Evidence:   q.Message,
+		Context: map[string]string{
+			"delist":        d.Addr,
+			"delist_state":  string(st),
+			"delist_reason": d.Reason,
+			"strikes":       strconv.Itoa(d.Strikes),
+		},
+		Status: AbuseReportOpen,
+	}
+	if q.Contact != "" {
+		rp.Context["contact"] = q.Contact
+	}
+	if srv != nil {
+		rp.ServerID = srv.ID
+		rp.ServerName = srv.Name
+		rp.Map = srv.Map
+		rp.Playlist = srv.Playlist
+	}
+	if err := h.AbuseReportStorage.SaveAbuseReport(&rp); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save abuse report to storage")
+		h.m().server_delistappeal_requests_total.fail_storage_error_report.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	if err := h.serverDelists.Update(h.StateStorage, "serverdelists", func(ds []ServerDelist) ([]ServerDelist, error) {
+		ds = append([]ServerDelist(nil), ds...)
+		for i := range ds {
+			if ds[i].Addr == d.Addr {
+				ds[i].AppealID = rp.ID
+				return ds, nil
+			}
+		}
+		return nil, fmt.Errorf("delist for %s was removed", d.Addr)
+	}); err != nil {
+		// the appeal is still in the moderation queue
+		hlog.FromRequest(r).Warn().
+			Err(err).
+			Str("report", rp.ID).
+			Msgf("failed to save delist appeal id to storage")
+	}
+
+	hlog.FromRequest(r).Info().
+		Str("report", rp.ID).
+		Str("addr", addr.String()).
+		Str("state", string(st)).
+		Msgf("server delist appeal submitted")
+
+	h.m().server_delistappeal_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"id":      rp.ID,
+	})
+}
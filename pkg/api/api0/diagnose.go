@@ -0,0 +1,253 @@
+package api0
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0/api0gameserver"
+	"github.com/rs/zerolog/hlog"
+)
+
+// DiagnoseStatus is the result of a registration diagnostics check.
+type DiagnoseStatus string
+
+const (
+	DiagnoseStatusPass DiagnoseStatus = "pass"
+	DiagnoseStatusWarn DiagnoseStatus = "warn" // registration will succeed, but something may not be as expected
+	DiagnoseStatusFail DiagnoseStatus = "fail" // registration will fail
+	DiagnoseStatusSkip DiagnoseStatus = "skip" // check not run due to an earlier failure
+)
+
+// DiagnoseCheck is the result of a single registration diagnostics check.
+type DiagnoseCheck struct {
+	Name    string         `json:"name"`
+	Status  DiagnoseStatus `json:"status"`
+	Code    ErrorCode      `json:"code,omitempty"`
+	Message string         `json:"message,omitempty"`
+}
+
+type diagnoseReport struct {
+	checks []DiagnoseCheck
+}
+
+func (d *diagnoseReport) add(name string, status DiagnoseStatus, code ErrorCode, msg string) {
+	d.checks = append(d.checks, DiagnoseCheck{
+		Name:    name,
+		Status:  status,
+		Code:    code,
+		Message: msg,
+	})
+}
+
+func (d *diagnoseReport) ok() bool {
+	for _, c := range d.checks {
+		if c.Status == DiagnoseStatusFail {
+			return false
+		}
+	}
+	return true
+}
+
+// handleServerDiagnose runs the add_server verification pipeline for the
+// requesting IP and the provided params without registering the server.
+func (h *Handler) handleServerDiagnose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.m().server_diagnose_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_diagnose_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	var d diagnoseReport
+	q := r.URL.Query()
+
+	if h.CheckLauncherVersion(r, false) {
+		d.add("launcher_version", DiagnoseStatusPass, "", "launcher version "+strconv.Quote(h.ExtractLauncherVersion(r))+" is supported")
+	} else {
+		d.add("launcher_version", DiagnoseStatusFail, ErrorCode_UNSUPPORTED_VERSION, "launcher version "+strconv.Quote(h.ExtractLauncherVersion(r))+" is not supported, update your server")
+	}
+
+	if !h.AllowGameServerIPv6 && raddr.Addr().Is6() {
+		d.add("ip", DiagnoseStatusFail, ErrorCode_BAD_REQUEST, "ipv6 is not currently supported (ip "+raddr.Addr().String()+"), make sure the server connects to the masterserver over ipv4")
+	} else {
+		d.add("ip", DiagnoseStatusPass, "", "server ip is "+raddr.Addr().String())
+	}
+
+	var addr netip.AddrPort
+	if v := q.Get("port"); v == "" {
+		d.add("port", DiagnoseStatusFail, ErrorCode_BAD_REQUEST, "port param is required")
+	} else if n, err := strconv.ParseUint(v, 10, 16); err != nil || n == 0 {
+		d.add("port", DiagnoseStatusFail, ErrorCode_BAD_REQUEST, "port param is invalid")
+	} else {
+		addr = netip.AddrPortFrom(raddr.Addr(), uint16(n))
+		d.add("port", DiagnoseStatusPass, "", "game address is "+addr.String())
+	}
+
+	var authPort uint16
+	var authPortOK bool
+	if v := q.Get("authPort"); v == "" {
+		d.add("auth_port", DiagnoseStatusFail, ErrorCode_BAD_REQUEST, "authPort param is required")
+	} else if v == "udp" {
+		authPortOK = true
+		d.add("auth_port", DiagnoseStatusPass, "", "using udp auth via the game port")
+	} else if n, err := strconv.ParseUint(v, 10, 16); err != nil || n == 0 {
+		d.add("auth_port", DiagnoseStatusFail, ErrorCode_BAD_REQUEST, "authPort param is invalid")
+	} else {
+		authPort, authPortOK = uint16(n), true
+		d.add("auth_port", DiagnoseStatusPass, "", "auth address is "+netip.AddrPortFrom(raddr.Addr(), authPort).String())
+	}
+
+	if v := q.Get("password"); len(v) > 128 {
+		d.add("password", DiagnoseStatusFail, ErrorCode_BAD_REQUEST, "password is too long (max 128 bytes)")
+	} else if v != "" {
+		d.add("password", DiagnoseStatusPass, "", "server is password-protected")
+	}
+
+	if v := q.Get("name"); v == "" {
+		d.add("name", DiagnoseStatusFail, ErrorCode_BAD_REQUEST, "name param must not be empty")
+	} else {
+		x := v
+		if f := h.cfg().CleanBadWords; f != nil {
+			x = f(x)
+		}
+		if n := 256; len(x) > n {
+			x = x[:n]
+		}
+		if x != v {
+			d.add("name", DiagnoseStatusWarn, "", "name will be shown as "+strconv.Quote(x))
+		} else {
+			d.add("name", DiagnoseStatusPass, "", "")
+		}
+	}
+
+	if v := q.Get("description"); v != "" {
+		x := v
+		if f := h.cfg().CleanBadWords; f != nil {
+			x = f(x)
+		}
+		if n := 1024; len(x) > n {
+			x = x[:n]
+		}
+		if x != v {
+			d.add("description", DiagnoseStatusWarn, "", "description will be shown as "+strconv.Quote(x))
+		} else {
+			d.add("description", DiagnoseStatusPass, "", "")
+		}
+	}
+
+	if v := q.Get("map"); v == "mp_lobby" && q.Get("playlist") != "private_match" {
+		d.add("map", DiagnoseStatusWarn, "", "servers in the lobby are hidden from the server list unless the playlist is private_match")
+	}
+
+	if !addr.IsValid() || !authPortOK {
+		for _, c := range []string{"limits", "duplicate", "auth_probe", "game_probe"} {
+			d.add(c, DiagnoseStatusSkip, "", "invalid game or auth port")
+		}
+	} else {
+		authAddr := addr
+		if authPort != 0 {
+			authAddr = netip.AddrPortFrom(addr.Addr(), authPort)
+		}
+
+		var nSrv, nSrvIP int
+		var dup *Server
+		h.ServerList.GetLiveServers(func(s *Server) bool {
+			if s.Addr != addr {
+				if s.Addr.Addr() == addr.Addr() {
+					nSrvIP++
+				}
+				nSrv++
+				if s.AuthAddr() == authAddr {
+					dup = s
+				}
+			}
+			return true
+		})
+
+		l := h.serverListLimit()
+		switch {
+		case l.MaxServers > 0 && nSrv+1 > l.MaxServers:
+			d.add("limits", DiagnoseStatusFail, ErrorCode_INTERNAL_SERVER_ERROR, "too many servers are registered on the masterserver, try again later")
+		case l.MaxServersPerIP > 0 && nSrvIP+1 > l.MaxServersPerIP:
+			d.add("limits", DiagnoseStatusFail, ErrorCode_INTERNAL_SERVER_ERROR, "too many servers ("+strconv.Itoa(nSrvIP)+") are registered for your ip")
+		default:
+			d.add("limits", DiagnoseStatusPass, "", strconv.Itoa(nSrvIP)+" other servers are registered for your ip")
+		}
+
+		if dup != nil {
+			d.add("duplicate", DiagnoseStatusFail, ErrorCode_DUPLICATE_SERVER, "auth address "+authAddr.String()+" is already used by the server on "+dup.Addr.String()+", use a different auth port for each server")
+		} else {
+			d.add("duplicate", DiagnoseStatusPass, "", "")
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
+		defer cancel()
+
+		if authPort != 0 {
+			actx, acancel := context.WithTimeout(ctx, time.Second*5)
+			err := api0gameserver.Verify(actx, authAddr)
+			acancel()
+			switch {
+			case err == nil:
+				d.add("auth_probe", DiagnoseStatusPass, "", "auth port "+authAddr.String()+" is reachable")
+			case errors.Is(err, context.DeadlineExceeded):
+				d.add("auth_probe", DiagnoseStatusFail, ErrorCode_NO_GAMESERVER_RESPONSE, "auth port "+authAddr.String()+" (tcp) timed out, make sure it is forwarded and allowed through your firewall")
+			case errors.Is(err, api0gameserver.ErrInvalidResponse):
+				d.add("auth_probe", DiagnoseStatusFail, ErrorCode_BAD_GAMESERVER_RESPONSE, "auth port "+authAddr.String()+" (tcp) returned an invalid response: "+err.Error())
+			default:
+				d.add("auth_probe", DiagnoseStatusFail, ErrorCode_NO_GAMESERVER_RESPONSE, "failed to connect to auth port "+authAddr.String()+" (tcp): "+err.Error())
+			}
+		}
+
+		if h.NSPkt == nil {
+			d.add("game_probe", DiagnoseStatusSkip, "", "udp probes are not available")
+		} else {
+			gctx, gcancel := context.WithTimeout(ctx, time.Second*5)
+			err := h.probeUDP(gctx, addr)
+			gcancel()
+			switch {
+			case err == nil:
+				d.add("game_probe", DiagnoseStatusPass, "", "game port "+addr.String()+" is reachable")
+			case errors.Is(err, context.DeadlineExceeded):
+				d.add("game_probe", DiagnoseStatusFail, ErrorCode_NO_GAMESERVER_RESPONSE, "game port "+addr.String()+" (udp) timed out, make sure it is forwarded and allowed through your firewall")
+			default:
+				d.add("game_probe", DiagnoseStatusFail, ErrorCode_NO_GAMESERVER_RESPONSE, "failed to connect to game port "+addr.String()+" (udp): "+err.Error())
+			}
+		}
+	}
+
+	ok := d.ok()
+	if ok {
+		h.m().server_diagnose_requests_total.success_ok.Inc()
+	} else {
+		h.m().server_diagnose_requests_total.success_failed.Inc()
+	}
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"ok":      ok,
+		"checks":  d.checks,
+	})
+}
@@ -0,0 +1,216 @@
+package api0
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0/api0gameserver"
+)
+
+func TestServerDiagnose(t *testing.T) {
+	authPort := func(text string) string {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, text)
+		}))
+		t.Cleanup(s.Close)
+		return strconv.Itoa(int(netip.MustParseAddrPort(s.Listener.Addr().String()).Port()))
+	}
+	goodAuth := authPort(api0gameserver.VerifyText)
+	badAuth := authPort("asd")
+
+	h := &Handler{
+		ServerList:                   NewServerList(time.Minute, time.Minute*2, 0, ServerListConfig{}),
+		MinimumLauncherVersionServer: "1.10.0",
+		MaxServersPerIP:              2,
+		CleanBadWords: func(s string) string {
+			return strings.ReplaceAll(s, "heck", "****")
+		},
+	}
+	for i, port := range []uint16{37016, 37017} {
+		if _, err := h.ServerList.ServerHybridUpdatePut(nil, &Server{
+			Addr:     netip.AddrPortFrom(netip.MustParseAddr("127.0.0.2"), port),
+			AuthPort: 8081 + uint16(i),
+			Name:     "test",
+		}, ServerListLimit{}); err != nil {
+			t.Fatalf("register: %v", err)
+		}
+	}
+	if _, err := h.ServerList.ServerHybridUpdatePut(nil, &Server{
+		Addr:     netip.MustParseAddrPort("127.0.0.1:37016"),
+		AuthPort: 8081,
+		Name:     "test",
+	}, ServerListLimit{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		remote string
+		ua     string
+		q      url.Values
+		ok     bool
+		checks map[string]DiagnoseStatus
+	}{
+		{
+			name:   "Valid",
+			remote: "127.0.0.1:1234",
+			q:      url.Values{"port": {"37015"}, "authPort": {goodAuth}, "name": {"test"}, "description": {"a server"}},
+			ok:     true,
+			checks: map[string]DiagnoseStatus{
+				"launcher_version": DiagnoseStatusPass,
+				"ip":               DiagnoseStatusPass,
+				"port":             DiagnoseStatusPass,
+				"auth_port":        DiagnoseStatusPass,
+				"name":             DiagnoseStatusPass,
+				"description":      DiagnoseStatusPass,
+				"limits":           DiagnoseStatusPass,
+				"duplicate":        DiagnoseStatusPass,
+				"auth_probe":       DiagnoseStatusPass,
+				"game_probe":       DiagnoseStatusSkip,
+			},
+		},
+		{
+			name:   "UDPAuth",
+			remote: "127.0.0.1:1234",
+			q:      url.Values{"port": {"37015"}, "authPort": {"udp"}, "name": {"test"}},
+			ok:     true,
+			checks: map[string]DiagnoseStatus{
+				"auth_port":  DiagnoseStatusPass,
+				"auth_probe": "",
+			},
+		},
+		{
+			name:   "Warnings",
+			remote: "127.0.0.1:1234",
+			q:      url.Values{"port": {"37015"}, "authPort": {"udp"}, "name": {"what the heck"}, "description": {strings.Repeat("x", 1025)}, "map": {"mp_lobby"}, "password": {"x"}},
+			ok:     true,
+			checks: map[string]DiagnoseStatus{
+				"name":        DiagnoseStatusWarn,
+				"description": DiagnoseStatusWarn,
+				"map":         DiagnoseStatusWarn,
+				"password":    DiagnoseStatusPass,
+			},
+		},
+		{
+			name:   "OldVersion",
+			remote: "127.0.0.1:1234",
+			ua:     "R2Northstar/1.9.0",
+			q:      url.Values{"port": {"37015"}, "authPort": {"udp"}, "name": {"test"}},
+			checks: map[string]DiagnoseStatus{
+				"launcher_version": DiagnoseStatusFail,
+				"limits":           DiagnoseStatusPass,
+			},
+		},
+		{
+			name:   "IPv6",
+			remote: "[2001:db8::1]:1234",
+			q:      url.Values{"port": {"37015"}, "authPort": {"udp"}, "name": {"test"}},
+			checks: map[string]DiagnoseStatus{
+				"ip": DiagnoseStatusFail,
+			},
+		},
+		{
+			name:   "InvalidParams",
+			remote: "127.0.0.1:1234",
+			q:      url.Values{"port": {"0"}, "authPort": {"asd"}, "password": {strings.Repeat("x", 129)}},
+			checks: map[string]DiagnoseStatus{
+				"port":       DiagnoseStatusFail,
+				"auth_port":  DiagnoseStatusFail,
+				"password":   DiagnoseStatusFail,
+				"name":       DiagnoseStatusFail,
+				"limits":     DiagnoseStatusSkip,
+				"duplicate":  DiagnoseStatusSkip,
+				"auth_probe": DiagnoseStatusSkip,
+				"game_probe": DiagnoseStatusSkip,
+			},
+		},
+		{
+			name:   "MissingParams",
+			remote: "127.0.0.1:1234",
+			q:      url.Values{"name": {"test"}},
+			checks: map[string]DiagnoseStatus{
+				"port":      DiagnoseStatusFail,
+				"auth_port": DiagnoseStatusFail,
+			},
+		},
+		{
+			name:   "Limit",
+			remote: "127.0.0.2:1234",
+			q:      url.Values{"port": {"37015"}, "authPort": {"udp"}, "name": {"test"}},
+			checks: map[string]DiagnoseStatus{
+				"limits": DiagnoseStatusFail,
+			},
+		},
+		{
+			name:   "ReplaceExisting",
+			remote: "127.0.0.2:1234",
+			q:      url.Values{"port": {"37016"}, "authPort": {"udp"}, "name": {"test"}},
+			ok:     true,
+			checks: map[string]DiagnoseStatus{
+				"limits":    DiagnoseStatusPass,
+				"duplicate": DiagnoseStatusPass,
+			},
+		},
+		{
+			name:   "DuplicateAuth",
+			remote: "127.0.0.1:1234",
+			q:      url.Values{"port": {"37015"}, "authPort": {"8081"}, "name": {"test"}},
+			checks: map[string]DiagnoseStatus{
+				"duplicate": DiagnoseStatusFail,
+			},
+		},
+		{
+			name:   "BadAuthResponse",
+			remote: "127.0.0.1:1234",
+			q:      url.Values{"port": {"37015"}, "authPort": {badAuth}, "name": {"test"}},
+			checks: map[string]DiagnoseStatus{
+				"auth_probe": DiagnoseStatusFail,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/server/diagnose?"+tc.q.Encode(), nil)
+			r.RemoteAddr = tc.remote
+			if tc.ua != "" {
+				r.Header.Set("User-Agent", tc.ua)
+			} else {
+				r.Header.Set("User-Agent", "R2Northstar/1.10.0")
+			}
+			w := httptest.NewRecorder()
+			h.handleServerDiagnose(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status %d", w.Code)
+			}
+			var obj struct {
+				OK     bool            `json:"ok"`
+				Checks []DiagnoseCheck `json:"checks"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &obj); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if obj.OK != tc.ok {
+				t.Errorf("expected ok=%t, got %t", tc.ok, obj.OK)
+			}
+			checks := map[string]DiagnoseCheck{}
+			for _, c := range obj.Checks {
+				checks[c.Name] = c
+			}
+			for name, exp := range tc.checks {
+				if c := checks[name]; c.Status != exp {
+					t.Errorf("check %s: expected status %q, got %q (%s)", name, exp, c.Status, c.Message)
+				} else if exp == DiagnoseStatusFail && c.Code == "" {
+					t.Errorf("check %s: expected error code for failed check", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package api0
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// erasureTombstoneTTL is the amount of time after an account is erased during
+// which it cannot be re-created. This prevents in-flight requests which loaded
+// the account before it was erased from saving it again.
+const erasureTombstoneTTL = time.Minute * 10
+
+// erasureTombstones tracks recently erased uids. It is safe for concurrent
+// use.
+type erasureTombstones struct {
+	mu sync.Mutex
+	m  map[uint64]time.Time
+}
+
+// Add marks uid as erased at t.
+func (e *erasureTombstones) Add(uid uint64, t time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.m == nil {
+		e.m = make(map[uint64]time.Time)
+	}
+	for x, et := range e.m {
+		if t.Sub(et) > erasureTombstoneTTL {
+			delete(e.m, x)
+		}
+	}
+	e.m[uid] = t
+}
+
+// Has checks whether uid has a tombstone at t.
+func (e *erasureTombstones) Has(uid uint64, t time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	et, ok := e.m[uid]
+	return ok && t.Sub(et) <= erasureTombstoneTTL
+}
+
+// Len returns the number of tombstones, including expired ones which haven't
+// been removed yet.
+func (e *erasureTombstones) Len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.m)
+}
+
+// isErased checks whether uid was recently erased, in which case the account
+// and pdata must not be saved.
+func (h *Handler) isErased(uid uint64) bool {
+	return h.erased.Has(uid, time.Now())
+}
+
+// eraseAccount deletes all data associated with uid. It leaves a tombstone to
+// prevent the account from being re-created by in-flight requests.
+func (h *Handler) eraseAccount(uid uint64) error {
+	h.erased.Add(uid, time.Now())
+
+	if h.AccountLinkStorage != nil {
+		ls, err := h.AccountLinkStorage.GetAccountLinks(uid)
+		if err != nil {
+			return fmt.Errorf("get account links: %w", err)
+		}
+		for _, l := range ls {
+			if err := h.AccountLinkStorage.DeleteAccountLink(uid, l.Provider); err != nil {
+				return fmt.Errorf("delete %s account link: %w", l.Provider, err)
+			}
+		}
+	}
+	if h.UsernameHistoryStorage != nil {
+		if err := h.UsernameHistoryStorage.DeleteUsernameHistory(uid); err != nil {
+			return fmt.Errorf("delete username history: %w", err)
+		}
+	}
+	if h.AccountSignalStorage != nil {
+		if err := h.AccountSignalStorage.DeleteAccountSignals(uid); err != nil {
+			return fmt.Errorf("delete account signals: %w", err)
+		}
+	}
+	if h.BanListStorage != nil {
+		if err := h.BanListStorage.DeleteBanListEntries(uid); err != nil {
+			return fmt.Errorf("delete ban list entries: %w", err)
+		}
+	}
+	if h.AbuseReportStorage != nil {
+		if err := h.AbuseReportStorage.DeleteAbuseReports(uid); err != nil {
+			return fmt.Errorf("delete abuse reports: %w", err)
+		}
+	}
+	if h.MatchHistoryStorage != nil {
+		if err := h.MatchHistoryStorage.DeletePlayerMatches(uid); err != nil {
+			return fmt.Errorf("delete player matches: %w", err)
+		}
+	}
+	if h.RatingStorage != nil {
+		if err := h.RatingStorage.DeleteRatings(uid); err != nil {
+			return fmt.Errorf("delete ratings: %w", err)
+		}
+	}
+	if h.MuteReportStorage != nil {
+		if err := h.MuteReportStorage.DeletePlayerMuteReports(uid); err != nil {
+			return fmt.Errorf("delete mute reports: %w", err)
+		}
+	}
+	if h.FriendStorage != nil {
+		if err := h.FriendStorage.DeleteFriends(uid); err != nil {
+			return fmt.Errorf("delete friends: %w", err)
+		}
+	}
+	if h.BlockStorage != nil {
+		if err := h.BlockStorage.DeleteBlocks(uid); err != nil {
+			return fmt.Errorf("delete blocks: %w", err)
+		}
+	}
+	if err := h.AccountStorage.DeleteAccount(uid); err != nil {
+		return fmt.Errorf("delete account: %w", err)
+	}
+	if err := h.PdataStorage.DeletePdata(uid); err != nil {
+		return fmt.Errorf("delete pdata: %w", err)
+	}
+	if h.Cache != nil {
+		for _, src := range []UsernameSource{UsernameSourceOrigin, UsernameSourceOriginEAX, UsernameSourceOriginEAXDebug, UsernameSourceEAX, UsernameSourceEAXOrigin} {
+			if err := h.Cache.Delete("username:" + string(src) + ":" + strconv.FormatUint(uid, 10)); err != nil {
+				return fmt.Errorf("delete cached username: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *Handler) handleAccountsDeleteData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().accounts_deletedata_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// - do not ever cache
+	// - do not share between users
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate") // equivalent to no-store -- but the rest is a fallback
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var q struct {
+		UID         uint64 `param:"id" validate:"required"`
+		Confirm     uint64 `param:"confirm" validate:"required"`
+		PlayerToken string `param:"playerToken"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().accounts_deletedata_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+	uid := q.UID
+
+	// require the uid to be repeated to make accidental deletion harder
+	if q.Confirm != uid {
+		h.m().accounts_deletedata_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("confirm param must be set to the id to delete all account data"))
+		return
+	}
+
+	acct, err := h.AccountStorage.GetAccount(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read account from storage")
+		h.m().accounts_deletedata_requests_total.fail_storage_error_account.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if acct == nil {
+		h.m().accounts_deletedata_requests_total.reject_player_not_found.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+
+	if !h.checkPlayerToken(acct, q.PlayerToken) {
+		h.m().accounts_deletedata_requests_total.reject_masterserver_token.Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
+		return
+	}
+
+	if err := h.eraseAccount(uid); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to erase account data")
+		h.m().accounts_deletedata_requests_total.fail_storage_error_erase.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	hlog.FromRequest(r).Info().
+		Uint64("uid", uid).
+		Msgf("erased account data at player request")
+
+	h.m().accounts_deletedata_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
+
+func (h *Handler) handleAdminErase(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "erase"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	var q struct {
+		UID uint64 `param:"uid" validate:"required"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+		respError(w, r, err)
+		return
+	}
+	uid := q.UID
+
+	if err := h.eraseAccount(uid); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to erase account data")
+		h.m().admin_requests_total.fail_storage_error_erase(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	hlog.FromRequest(r).Info().
+		Uint64("uid", uid).
+		Msgf("erased account data at admin request")
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
@@ -0,0 +1,286 @@
+package api0
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/cache"
+)
+
+// testAccountStorage is a minimal in-memory AccountStorage.
+type testAccountStorage struct {
+	mu sync.Mutex
+	m  map[uint64]Account
+}
+
+func (s *testAccountStorage) GetUIDsByUsername(username string) ([]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var uids []uint64
+	for uid, a := range s.m {
+		if a.Username == username {
+			uids = append(uids, uid)
+		}
+	}
+	return uids, nil
+}
+
+func (s *testAccountStorage) GetAccount(uid uint64) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.m[uid]; ok {
+		return &a, nil
+	}
+	return nil, nil
+}
+
+func (s *testAccountStorage) SaveAccount(a *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = map[uint64]Account{}
+	}
+	s.m[a.UID] = *a
+	return nil
+}
+
+func (s *testAccountStorage) DeleteAccount(uid uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, uid)
+	return nil
+}
+
+// testPdataStorage is a minimal in-memory PdataStorage.
+type testPdataStorage struct {
+	mu sync.Mutex
+	m  map[uint64][]byte
+}
+
+func (s *testPdataStorage) GetPdataHash(uid uint64) ([sha256.Size]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf, ok := s.m[uid]
+	if !ok {
+		return [sha256.Size]byte{}, false, nil
+	}
+	return sha256.Sum256(buf), true, nil
+}
+
+func (s *testPdataStorage) GetPdataCached(uid uint64, sha [sha256.Size]byte) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf, ok := s.m[uid]
+	if !ok {
+		return nil, false, nil
+	}
+	if sha != ([sha256.Size]byte{}) && sha == sha256.Sum256(buf) {
+		return nil, true, nil
+	}
+	return buf, true, nil
+}
+
+func (s *testPdataStorage) SetPdata(uid uint64, buf []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = map[uint64][]byte{}
+	}
+	s.m[uid] = append([]byte(nil), buf...)
+	return len(buf), nil
+}
+
+func (s *testPdataStorage) DeletePdata(uid uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, uid)
+	return nil
+}
+
+func TestErasureTombstones(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+
+	var e erasureTombstones
+	if e.Has(1, t0) {
+		t.Errorf("expected no tombstone")
+	}
+	e.Add(1, t0)
+	if !e.Has(1, t0.Add(erasureTombstoneTTL)) {
+		t.Errorf("expected tombstone until ttl")
+	}
+	if e.Has(1, t0.Add(erasureTombstoneTTL+time.Second)) {
+		t.Errorf("expected tombstone to expire after ttl")
+	}
+	if e.Has(2, t0) {
+		t.Errorf("expected no tombstone for other uid")
+	}
+
+	e.Add(2, t0.Add(erasureTombstoneTTL+time.Second))
+	if n := e.Len(); n != 1 {
+		t.Errorf("expected expired tombstones to be removed, got %d", n)
+	}
+}
+
+func TestErase(t *testing.T) {
+	const uid = 1000
+	setup := func(t *testing.T) (*Handler, *testAccountStorage, *testPdataStorage, cache.Cache) {
+		as, ps, c := new(testAccountStorage), new(testPdataStorage), cache.NewLRU(100)
+		h := &Handler{
+			AccountStorage: as,
+			PdataStorage:   ps,
+			Cache:          c,
+			AdminSecret:    "secret",
+		}
+		for _, u := range []uint64{uid, uid + 1} {
+			if err := as.SaveAccount(&Account{
+				UID:             u,
+				Username:        "test",
+				AuthIP:          netip.MustParseAddr("192.0.2.1"),
+				AuthToken:       "token",
+				AuthTokenExpiry: time.Now().Add(time.Hour),
+			}); err != nil {
+				t.Fatalf("save account: %v", err)
+			}
+			if _, err := ps.SetPdata(u, []byte("pdata")); err != nil {
+				t.Fatalf("save pdata: %v", err)
+			}
+		}
+		if err := c.Set("username:origin:1000", []byte("test"), time.Hour); err != nil {
+			t.Fatalf("set cache: %v", err)
+		}
+		return h, as, ps, c
+	}
+	check := func(t *testing.T, h *Handler, as *testAccountStorage, ps *testPdataStorage, c cache.Cache, erased bool) {
+		t.Helper()
+		if a, _ := as.GetAccount(uid); (a == nil) != erased {
+			t.Errorf("expected account erased=%t", erased)
+		}
+		if _, ok, _ := ps.GetPdataHash(uid); ok == erased {
+			t.Errorf("expected pdata erased=%t", erased)
+		}
+		if _, ok, _ := c.Get("username:origin:1000"); ok == erased {
+			t.Errorf("expected cached username erased=%t", erased)
+		}
+		if h.isErased(uid) != erased {
+			t.Errorf("expected tombstone=%t", erased)
+		}
+		if a, _ := as.GetAccount(uid + 1); a == nil {
+			t.Errorf("expected other account to be kept")
+		}
+		if _, ok, _ := ps.GetPdataHash(uid + 1); !ok {
+			t.Errorf("expected other pdata to be kept")
+		}
+	}
+	do := func(fn http.HandlerFunc, target string, admin bool) int {
+		r := httptest.NewRequest(http.MethodPost, target, nil)
+		if admin {
+			r.Header.Set("Authorization", "Bearer secret")
+		}
+		w := httptest.NewRecorder()
+		fn(w, r)
+		return w.Code
+	}
+
+	t.Run("Player", func(t *testing.T) {
+		h, as, ps, c := setup(t)
+		for _, tc := range []struct {
+			name   string
+			q      url.Values
+			status int
+		}{
+			{"NoConfirm", url.Values{"id": {"1000"}, "playerToken": {"token"}}, http.StatusBadRequest},
+			{"WrongConfirm", url.Values{"id": {"1000"}, "confirm": {"1001"}, "playerToken": {"token"}}, http.StatusBadRequest},
+			{"NotFound", url.Values{"id": {"1002"}, "confirm": {"1002"}, "playerToken": {"token"}}, http.StatusNotFound},
+			{"NoToken", url.Values{"id": {"1000"}, "confirm": {"1000"}}, http.StatusUnauthorized},
+			{"WrongToken", url.Values{"id": {"1000"}, "confirm": {"1000"}, "playerToken": {"asd"}}, http.StatusUnauthorized},
+		} {
+			if st := do(h.handleAccountsDeleteData, "/accounts/delete_data?"+tc.q.Encode(), false); st != tc.status {
+				t.Errorf("%s: expected status %d, got %d", tc.name, tc.status, st)
+			}
+		}
+		check(t, h, as, ps, c, false)
+
+		if st := do(h.handleAccountsDeleteData, "/accounts/delete_data?id=1000&confirm=1000&playerToken=token", false); st != http.StatusOK {
+			t.Fatalf("unexpected status %d", st)
+		}
+		check(t, h, as, ps, c, true)
+
+		if st := do(h.handleAccountsDeleteData, "/accounts/delete_data?id=1000&confirm=1000&playerToken=token", false); st != http.StatusNotFound {
+			t.Errorf("expected erased account to be gone, got status %d", st)
+		}
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		h, as, ps, c := setup(t)
+		if st := do(h.handleAdminErase, "/admin/erase?uid=1000", false); st != http.StatusUnauthorized {
+			t.Errorf("expected status 401 without admin secret, got %d", st)
+		}
+		if st := do(h.handleAdminErase, "/admin/erase", true); st != http.StatusBadRequest {
+			t.Errorf("expected status 400 without uid, got %d", st)
+		}
+		check(t, h, as, ps, c, false)
+
+		if st := do(h.handleAdminErase, "/admin/erase?uid=1000", true); st != http.StatusOK {
+			t.Fatalf("unexpected status %d", st)
+		}
+		check(t, h, as, ps, c, true)
+
+		// erasing is idempotent
+		if st := do(h.handleAdminErase, "/admin/erase?uid=1000", true); st != http.StatusOK {
+			t.Errorf("expected erasing a missing account to succeed, got %d", st)
+		}
+	})
+
+	t.Run("AuthWithServer", func(t *testing.T) {
+		var requests atomic.Int32
+		gs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer gs.Close()
+
+		h, as, _, _ := setup(t)
+		h.ServerList = NewServerList(time.Minute, time.Minute*2, 0, ServerListConfig{})
+		srv, err := h.ServerList.ServerHybridUpdatePut(nil, &Server{
+			Addr:     netip.MustParseAddrPort("127.0.0.1:37015"),
+			AuthPort: netip.MustParseAddrPort(gs.Listener.Addr().String()).Port(),
+			Name:     "test",
+		}, ServerListLimit{})
+		if err != nil {
+			t.Fatalf("register: %v", err)
+		}
+		auth := func(uid uint64) int {
+			r := httptest.NewRequest(http.MethodPost, "/client/auth_with_server?id="+strconv.FormatUint(uid, 10)+"&server="+srv.ID+"&playerToken=token", nil)
+			r.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+			r.RemoteAddr = "192.0.2.1:1234"
+			w := httptest.NewRecorder()
+			h.handleClientAuthWithServer(w, r)
+			return w.Code
+		}
+
+		// the account is still there while the erasure is in progress
+		h.erased.Add(uid, time.Now())
+		if st := auth(uid); st != http.StatusForbidden {
+			t.Errorf("expected status 403 for erased account, got %d", st)
+		}
+		if n := requests.Load(); n != 0 {
+			t.Errorf("expected game server not to be notified of erased account, got %d requests", n)
+		}
+		if a, _ := as.GetAccount(uid); a == nil || a.LastServerID != "" {
+			t.Errorf("expected erased account not to be updated")
+		}
+
+		auth(uid + 1)
+		if n := requests.Load(); n == 0 {
+			t.Errorf("expected game server to be notified of other account")
+		}
+	})
+}
@@ -1,7 +1,13 @@
 package api0
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+
+	"github.com/r2northstar/atlas/pkg/api/api0/api0gameserver"
+	"github.com/r2northstar/atlas/pkg/stryder"
 )
 
 // ErrorCode represents a known Northstar error code.
@@ -25,36 +31,78 @@ const (
 )
 
 const (
-	ErrorCode_INTERNAL_SERVER_ERROR ErrorCode = "INTERNAL_SERVER_ERROR"
-	ErrorCode_BAD_REQUEST           ErrorCode = "BAD_REQUEST"
+	ErrorCode_INTERNAL_SERVER_ERROR  ErrorCode = "INTERNAL_SERVER_ERROR"
+	ErrorCode_BAD_REQUEST            ErrorCode = "BAD_REQUEST"
+	ErrorCode_UNAUTHORIZED           ErrorCode = "UNAUTHORIZED"
+	ErrorCode_CHALLENGE_REQUIRED     ErrorCode = "CHALLENGE_REQUIRED"
+	ErrorCode_UNSUPPORTED_PROVIDER   ErrorCode = "UNSUPPORTED_PROVIDER"
+	ErrorCode_INVALID_LINK           ErrorCode = "INVALID_LINK"
+	ErrorCode_LINK_PROVIDER_ERROR    ErrorCode = "LINK_PROVIDER_ERROR"
+	ErrorCode_SESSION_LIMIT          ErrorCode = "SESSION_LIMIT"
+	ErrorCode_ACCOUNT_ERASED         ErrorCode = "ACCOUNT_ERASED"
+	ErrorCode_RATE_LIMITED           ErrorCode = "RATE_LIMITED"
+	ErrorCode_VERIFICATION_REQUIRED  ErrorCode = "VERIFICATION_REQUIRED"
+	ErrorCode_NETWORK_BLOCKED        ErrorCode = "NETWORK_BLOCKED"
+	ErrorCode_IDEMPOTENCY_KEY_REUSED ErrorCode = "IDEMPOTENCY_KEY_REUSED"
+	ErrorCode_QUOTA_EXCEEDED         ErrorCode = "QUOTA_EXCEEDED"
+	ErrorCode_POLICY_REJECTED        ErrorCode = "POLICY_REJECTED"
+	ErrorCode_CAPTCHA_REQUIRED       ErrorCode = "CAPTCHA_REQUIRED"
+	ErrorCode_NOT_FOUND              ErrorCode = "NOT_FOUND"
+	ErrorCode_METHOD_NOT_ALLOWED     ErrorCode = "METHOD_NOT_ALLOWED"
 )
 
-// ErrorObj contains an error code and a message for API responses.
+// ErrorObj contains an error code and a message for API responses. It is
+// included in the "error" field of the response envelope written by respFail,
+// alongside "success" (always false) and "request_id" (the correlation ID
+// from the request logs).
 type ErrorObj struct {
-	Code    ErrorCode `json:"enum"`
-	Message string    `json:"msg"` // note: no omitempty
+	Code      ErrorCode `json:"enum"`
+	Message   string    `json:"msg"` // note: no omitempty
+	Retryable bool      `json:"retryable"`
+}
+
+// Error implements error.
+func (e ErrorObj) Error() string {
+	if e.Message == "" {
+		return string(e.Code)
+	}
+	return string(e.Code) + ": " + e.Message
 }
 
 // Obj returns an ErrorObj.
 func (n ErrorCode) Obj() ErrorObj {
 	return ErrorObj{
-		Code: n,
+		Code:      n,
+		Retryable: n.Retryable(),
 	}
 }
 
 // MessageObj is like Message, but returns an ErrorObj.
 func (n ErrorCode) MessageObj() ErrorObj {
 	return ErrorObj{
-		Code:    n,
-		Message: n.Message(),
+		Code:      n,
+		Message:   n.Message(),
+		Retryable: n.Retryable(),
 	}
 }
 
 // MessageObjf is like Messagef, but returns an ErrorObj.
 func (n ErrorCode) MessageObjf(format string, a ...interface{}) ErrorObj {
 	return ErrorObj{
-		Code:    n,
-		Message: n.Messagef(format, a...),
+		Code:      n,
+		Message:   n.Messagef(format, a...),
+		Retryable: n.Retryable(),
+	}
+}
+
+// Retryable returns whether a request failing with error code n may succeed
+// if retried later without changes.
+func (n ErrorCode) Retryable() bool {
+	switch n {
+	case ErrorCode_NO_GAMESERVER_RESPONSE, ErrorCode_STRYDER_RESPONSE, ErrorCode_INTERNAL_SERVER_ERROR, ErrorCode_LINK_PROVIDER_ERROR, ErrorCode_ACCOUNT_ERASED, ErrorCode_RATE_LIMITED, ErrorCode_QUOTA_EXCEEDED:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -91,11 +139,89 @@ func (n ErrorCode) Message() string {
 		return "Bad request"
 	case ErrorCode_CONNECTION_REJECTED:
 		return "Connection rejected"
+	case ErrorCode_UNAUTHORIZED:
+		return "Unauthorized"
+	case ErrorCode_CHALLENGE_REQUIRED:
+		return "Proof-of-work challenge required"
+	case ErrorCode_UNSUPPORTED_PROVIDER:
+		return "Account link provider is not supported"
+	case ErrorCode_INVALID_LINK:
+		return "Couldn't verify external account"
+	case ErrorCode_LINK_PROVIDER_ERROR:
+		return "Got bad response from account link provider"
+	case ErrorCode_SESSION_LIMIT:
+		return "Too many active sessions for this account"
+	case ErrorCode_ACCOUNT_ERASED:
+		return "Account data was recently deleted, try again later"
+	case ErrorCode_RATE_LIMITED:
+		return "Too many requests, try again later"
+	case ErrorCode_VERIFICATION_REQUIRED:
+		return "Link an external account to play from this network"
+	case ErrorCode_NETWORK_BLOCKED:
+		return "Connections from your network are not allowed"
+	case ErrorCode_IDEMPOTENCY_KEY_REUSED:
+		return "Idempotency key was already used for a different request"
+	case ErrorCode_QUOTA_EXCEEDED:
+		return "Write quota exceeded, try again later"
+	case ErrorCode_POLICY_REJECTED:
+		return "Rejected by server policy"
+	case ErrorCode_CAPTCHA_REQUIRED:
+		return "CAPTCHA required"
 	default:
 		return string(n)
 	}
 }
 
+// StatusError is an error with an associated response status and error
+// object. It can be returned from helpers to have errorResponse pass it
+// through unchanged.
+type StatusError struct {
+	Status int
+	Obj    ErrorObj
+}
+
+func (e StatusError) Error() string {
+	return e.Obj.Error()
+}
+
+// errorResponse maps err to a response status and error object. Errors from
+// stryder, game servers, and the server list are mapped to their Northstar
+// error codes, and unknown errors (e.g., storage errors) are mapped to
+// INTERNAL_SERVER_ERROR. The caller is responsible for logging err if it is
+// unexpected.
+func errorResponse(err error) (int, ErrorObj) {
+	var se StatusError
+	var rej api0gameserver.ConnectionRejectedError
+	switch {
+	case errors.As(err, &se):
+		return se.Status, se.Obj
+	case errors.Is(err, stryder.ErrInvalidGame),
+		errors.Is(err, stryder.ErrInvalidToken),
+		errors.Is(err, stryder.ErrMultiplayerNotAllowed):
+		return http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAME.MessageObj()
+	case errors.Is(err, stryder.ErrStryder):
+		return http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj()
+	case errors.As(err, &rej):
+		return http.StatusForbidden, ErrorCode_CONNECTION_REJECTED.MessageObjf("%s", rej.Reason())
+	case errors.Is(err, api0gameserver.ErrAuthFailed):
+		return http.StatusInternalServerError, ErrorCode_JSON_PARSE_ERROR.MessageObj() // this is kind of misleading... but it's what the original master server did
+	case errors.Is(err, api0gameserver.ErrInvalidResponse):
+		return http.StatusInternalServerError, ErrorCode_BAD_GAMESERVER_RESPONSE.MessageObj()
+	case errors.Is(err, ErrServerListUpdateWrongIP):
+		return http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("%v", err)
+	case errors.Is(err, ErrServerListUpdateServerDead):
+		return http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such server")
+	case errors.Is(err, ErrServerListDuplicateAuthAddr):
+		return http.StatusForbidden, ErrorCode_DUPLICATE_SERVER.MessageObjf("%v", err)
+	case errors.Is(err, ErrServerListLimitExceeded):
+		return http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObjf("%v", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, ErrorCode_INTERNAL_SERVER_ERROR.MessageObjf("request timed out")
+	default:
+		return http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj()
+	}
+}
+
 // Messagef returns Message() with additional text appended after ": ".
 func (n ErrorCode) Messagef(format string, a ...interface{}) string {
 	if format == "" {
@@ -0,0 +1,127 @@
+package api0
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/r2northstar/atlas/pkg/api/api0/api0gameserver"
+	"github.com/r2northstar/atlas/pkg/stryder"
+	"github.com/rs/zerolog/hlog"
+)
+
+func TestErrorResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		err    error
+		status int
+		code   ErrorCode
+	}{
+		{"Status", StatusError{http.StatusTeapot, ErrorCode_BAD_REQUEST.Obj()}, http.StatusTeapot, ErrorCode_BAD_REQUEST},
+		{"StatusWrapped", fmt.Errorf("wrapped: %w", StatusError{http.StatusConflict, ErrorCode_DUPLICATE_SERVER.Obj()}), http.StatusConflict, ErrorCode_DUPLICATE_SERVER},
+		{"BadRequest", badRequest("invalid %s", "thing"), http.StatusBadRequest, ErrorCode_BAD_REQUEST},
+		{"StryderInvalidGame", stryder.ErrInvalidGame, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAME},
+		{"StryderInvalidToken", fmt.Errorf("auth: %w", stryder.ErrInvalidToken), http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAME},
+		{"StryderMultiplayerNotAllowed", stryder.ErrMultiplayerNotAllowed, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAME},
+		{"Stryder", fmt.Errorf("%w: empty response", stryder.ErrStryder), http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR},
+		{"ConnectionRejected", fmt.Errorf("auth: %w", api0gameserver.ConnectionRejectedError("banned")), http.StatusForbidden, ErrorCode_CONNECTION_REJECTED},
+		{"GameServerAuthFailed", api0gameserver.ErrAuthFailed, http.StatusInternalServerError, ErrorCode_JSON_PARSE_ERROR},
+		{"GameServerInvalidResponse", api0gameserver.ErrInvalidResponse, http.StatusInternalServerError, ErrorCode_BAD_GAMESERVER_RESPONSE},
+		{"ServerListWrongIP", ErrServerListUpdateWrongIP, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER},
+		{"ServerListDead", ErrServerListUpdateServerDead, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER},
+		{"ServerListDuplicate", ErrServerListDuplicateAuthAddr, http.StatusForbidden, ErrorCode_DUPLICATE_SERVER},
+		{"ServerListLimit", ErrServerListLimitExceeded, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR},
+		{"Timeout", fmt.Errorf("request: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, ErrorCode_INTERNAL_SERVER_ERROR},
+		{"Unknown", errors.New("storage error"), http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR},
+	} {
+		status, obj := errorResponse(tc.err)
+		if status != tc.status || obj.Code != tc.code {
+			t.Errorf("%s: expected %d %s, got %d %s", tc.name, tc.status, tc.code, status, obj.Code)
+		}
+		if obj.Retryable != obj.Code.Retryable() {
+			t.Errorf("%s: expected retryable to match error code", tc.name)
+		}
+	}
+
+	// the internal error message must not be exposed
+	if _, obj := errorResponse(errors.New("secret storage error")); obj.Message != ErrorCode_INTERNAL_SERVER_ERROR.Message() {
+		t.Errorf("expected default message for unknown error, got %q", obj.Message)
+	}
+	if _, obj := errorResponse(api0gameserver.ConnectionRejectedError("banned")); obj.Message != ErrorCode_CONNECTION_REJECTED.Messagef("banned") {
+		t.Errorf("expected rejection reason in message, got %q", obj.Message)
+	}
+}
+
+func TestRespFail(t *testing.T) {
+	type envelope struct {
+		Success   *bool    `json:"success"`
+		Error     ErrorObj `json:"error"`
+		RequestID string   `json:"request_id"`
+		Extra     string   `json:"extra"`
+	}
+	do := func(status int, obj ErrorObj, extra map[string]any) (*httptest.ResponseRecorder, envelope) {
+		var rid string
+		h := hlog.RequestIDHandler("", "")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id, ok := hlog.IDFromRequest(r); ok {
+				rid = id.String()
+			}
+			respFailExtra(w, r, status, obj, extra)
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		var e envelope
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		if e.RequestID == "" || e.RequestID != rid {
+			t.Errorf("expected request id %q, got %q", rid, e.RequestID)
+		}
+		return w, e
+	}
+
+	w, e := do(http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"), map[string]any{"extra": "x", "success": true})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+	if e.Success == nil || *e.Success {
+		t.Errorf("expected success to be false")
+	}
+	if e.Error.Code != ErrorCode_BAD_REQUEST || e.Error.Message != "Bad request: id param is required" || e.Error.Retryable {
+		t.Errorf("incorrect error %+v", e.Error)
+	}
+	if e.Extra != "x" {
+		t.Errorf("expected extra fields to be included")
+	}
+
+	for status, exp := range map[int]bool{
+		http.StatusBadRequest:          false,
+		http.StatusInternalServerError: false,
+		http.StatusTooManyRequests:     true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusGatewayTimeout:      true,
+	} {
+		if _, e := do(status, ErrorCode_BAD_REQUEST.Obj(), nil); e.Error.Retryable != exp {
+			t.Errorf("status %d: expected retryable=%t", status, exp)
+		}
+	}
+	if _, e := do(http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj(), nil); !e.Error.Retryable {
+		t.Errorf("expected internal server error to be retryable")
+	}
+
+	// the message is always present
+	w, _ = do(http.StatusBadRequest, ErrorCode_BAD_REQUEST.Obj(), nil)
+	var raw struct {
+		Error map[string]any `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if _, ok := raw.Error["msg"]; !ok {
+		t.Errorf("expected msg to be included even if empty: %s", w.Body.String())
+	}
+}
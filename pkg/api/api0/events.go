@@ -0,0 +1,252 @@
+package api0
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// Event is a scheduled community event (e.g., a game night) managed via the
+// admin API. While it is active, its featured servers are pinned to the top of
+// the server list and its banner is included in the MOTD. To announce an event
+// in advance, add a separate MOTD.
+type Event struct {
+	// ID uniquely identifies the event. It is required.
+	ID string `json:"id" validate:"required,max=64"`
+
+	// Start and End limit when the event is active. They are required, and the
+	// end is exclusive.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Priority is used to order the banner with the other messages, highest
+	// first.
+	Priority int `json:"priority,omitempty"`
+
+	// ImageURL and URL are optional links to an image and more information.
+	ImageURL string `json:"image,omitempty"`
+	URL      string `json:"url,omitempty"`
+
+	// MOTDContent is the default banner content.
+	MOTDContent
+
+	// Locales contains localized banner content by lowercase language tag
+	// (e.g., fr, pt-br). Empty fields fall back to the default content.
+	Locales map[string]MOTDContent `json:"locales,omitempty"`
+
+	// ServerIDs and ServerNames are the featured servers, matched by ID or
+	// exact name. Names are useful since IDs change when a server
+	// re-registers.
+	ServerIDs   []string `json:"server_ids,omitempty" validate:"max=64"`
+	ServerNames []string `json:"server_names,omitempty" validate:"max=64"`
+}
+
+// Active checks whether e is ongoing at t.
+func (e Event) Active(t time.Time) bool {
+	return !t.Before(e.Start) && t.Before(e.End)
+}
+
+// motd gets the banner for e as a MOTD.
+func (e Event) motd() MOTD {
+	return MOTD{
+		ID:          "event:" + e.ID,
+		Kind:        "event",
+		Priority:    e.Priority,
+		Start:       e.Start,
+		End:         e.End,
+		ImageURL:    e.ImageURL,
+		URL:         e.URL,
+		MOTDContent: e.MOTDContent,
+		Locales:     e.Locales,
+	}
+}
+
+// validateEvents checks if es is a valid set of events.
+func validateEvents(es []Event) error {
+	ids := map[string]struct{}{}
+	for i, e := range es {
+		if err := validate(e); err != nil {
+			return fmt.Errorf("event %d: %w", i, err)
+		}
+		if _, dup := ids[e.ID]; dup {
+			return fmt.Errorf("event %q: duplicate id", e.ID)
+		}
+		ids[e.ID] = struct{}{}
+		if e.Start.IsZero() || e.End.IsZero() {
+			return fmt.Errorf("event %q: start and end are required", e.ID)
+		}
+		if !e.End.After(e.Start) {
+			return fmt.Errorf("event %q: end must be after start", e.ID)
+		}
+		for l := range e.Locales {
+			if l == "" || l != strings.ToLower(l) {
+				return fmt.Errorf("event %q: locale %q must be a non-empty lowercase language tag", e.ID, l)
+			}
+		}
+	}
+	return nil
+}
+
+// updateEventPins pins the featured servers for active events.
+func (h *Handler) updateEventPins(r *http.Request) {
+	es, err := h.events.Get(h.StateStorage, "events")
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to load events from storage")
+		return // keep the existing pins
+	}
+	var (
+		now   = time.Now()
+		ids   []string
+		names []string
+	)
+	for _, e := range es {
+		if e.Active(now) {
+			ids = append(ids, e.ServerIDs...)
+			names = append(names, e.ServerNames...)
+		}
+	}
+	h.ServerList.SetPins(ids, names)
+}
+
+// eventMOTDs gets the banners for events as MOTDs.
+func (h *Handler) eventMOTDs() ([]MOTD, error) {
+	es, err := h.events.Get(h.StateStorage, "events")
+	if err != nil {
+		return nil, err
+	}
+	var ms []MOTD
+	for _, e := range es {
+		if e.Title != "" || e.Text != "" {
+			ms = append(ms, e.motd())
+		}
+	}
+	return ms, nil
+}
+
+func (h *Handler) handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "events"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PUT, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		es, err := h.events.Get(h.StateStorage, "events")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load events from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if es == nil {
+			es = []Event{}
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"events":  es,
+		})
+		return
+	}
+
+	var fn func(es []Event) ([]Event, error)
+	switch r.Method {
+	case http.MethodPut:
+		var es []Event
+		if err := decodeJSON(r, &es); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func([]Event) ([]Event, error) {
+			return es, nil
+		}
+	case http.MethodPost:
+		var e Event
+		if err := decodeJSON(r, &e); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func(es []Event) ([]Event, error) {
+			for i := range es {
+				if es[i].ID == e.ID {
+					es[i] = e
+					return es, nil
+				}
+			}
+			return append(es, e), nil
+		}
+	case http.MethodDelete:
+		var q struct {
+			ID string `param:"id" validate:"required"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		id := q.ID
+		fn = func(es []Event) ([]Event, error) {
+			for i := range es {
+				if es[i].ID == id {
+					return append(es[:i], es[i+1:]...), nil
+				}
+			}
+			return es, nil
+		}
+	}
+
+	var errInvalid error
+	if err := h.events.Update(h.StateStorage, "events", func(es []Event) ([]Event, error) {
+		es, err := fn(append([]Event(nil), es...))
+		if err == nil {
+			if err = validateEvents(es); err != nil {
+				errInvalid = err
+			}
+		}
+		return es, err
+	}); err != nil {
+		if errInvalid != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", errInvalid))
+			return
+		}
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save events to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	h.updateEventPins(r)
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
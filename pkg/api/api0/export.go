@@ -0,0 +1,304 @@
+package api0
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/pdata"
+	"github.com/rs/zerolog/hlog"
+)
+
+// exportAccount is the account record included in data exports. It does not
+// include auth tokens.
+type exportAccount struct {
+	UID          uint64          `json:"uid"`
+	Username     string          `json:"username,omitempty"`
+	LastServerID string          `json:"last_server_id,omitempty"`
+	VerifiedAt   *time.Time      `json:"verified_at,omitempty"`
+	Sessions     []exportSession `json:"sessions,omitempty"`
+}
+
+type exportSession struct {
+	IP            string    `json:"ip,omitempty"`
+	Expiry        time.Time `json:"expiry"`
+	StaleVerified bool      `json:"stale_verified,omitempty"`
+	IPFlags       []string  `json:"ip_flags,omitempty"`
+}
+
+type exportLink struct {
+	Provider     AccountLinkProvider `json:"provider"`
+	ExternalID   string              `json:"external_id"`
+	ExternalName string              `json:"external_name,omitempty"`
+	VerifiedAt   time.Time           `json:"verified_at"`
+}
+
+type exportSignal struct {
+	Kind      AccountSignalKind `json:"kind"`
+	Hash      string            `json:"hash"`
+	FirstSeen time.Time         `json:"first_seen"`
+	LastSeen  time.Time         `json:"last_seen"`
+}
+
+func (h *Handler) handleAccountsExportData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().accounts_exportdata_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// - do not ever cache
+	// - do not share between users
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate") // equivalent to no-store -- but the rest is a fallback
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var q struct {
+		UID         uint64 `param:"id" validate:"required"`
+		PlayerToken string `param:"playerToken"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().accounts_exportdata_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+	uid := q.UID
+
+	acct, err := h.AccountStorage.GetAccount(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read account from storage")
+		h.m().accounts_exportdata_requests_total.fail_storage_error_account.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if acct == nil {
+		h.m().accounts_exportdata_requests_total.reject_player_not_found.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+
+	if !h.checkPlayerToken(acct, q.PlayerToken) {
+		h.m().accounts_exportdata_requests_total.reject_masterserver_token.Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
+		return
+	}
+
+	pbuf, pexists, err := h.PdataStorage.GetPdataCached(uid, [sha256.Size]byte{})
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read pdata from storage")
+		h.m().accounts_exportdata_requests_total.fail_storage_error_pdata.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	var links []AccountLink
+	if h.AccountLinkStorage != nil {
+		if links, err = h.AccountLinkStorage.GetAccountLinks(uid); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", uid).
+				Msgf("failed to read account links from storage")
+			h.m().accounts_exportdata_requests_total.fail_storage_error_link.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+	}
+
+	var names []UsernameChange
+	if h.UsernameHistoryStorage != nil {
+		if names, err = h.UsernameHistoryStorage.GetUsernameHistory(uid); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", uid).
+				Msgf("failed to read username history from storage")
+			h.m().accounts_exportdata_requests_total.fail_storage_error_namehistory.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+	}
+
+	var signals []AccountSignal
+	if h.AccountSignalStorage != nil {
+		if signals, err = h.AccountSignalStorage.GetAccountSignals(uid); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", uid).
+				Msgf("failed to read account signals from storage")
+			h.m().accounts_exportdata_requests_total.fail_storage_error_signal.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+	}
+
+	now := time.Now()
+
+	ea := exportAccount{
+		UID:          acct.UID,
+		Username:     acct.Username,
+		LastServerID: acct.LastServerID,
+	}
+	if !acct.VerifiedAt.IsZero() {
+		ea.VerifiedAt = &acct.VerifiedAt
+	}
+	if acct.AuthToken != "" && now.Before(acct.AuthTokenExpiry) {
+		ea.Sessions = append(ea.Sessions, exportSession{
+			IP:            exportIP(acct.AuthIP),
+			Expiry:        acct.AuthTokenExpiry,
+			StaleVerified: acct.AuthStaleVerified,
+			IPFlags:       acct.AuthIPFlags.Strings(),
+		})
+	}
+	for _, s := range acct.OtherSessions {
+		if now.Before(s.Expiry) {
+			ea.Sessions = append(ea.Sessions, exportSession{
+				IP:            exportIP(s.IP),
+				Expiry:        s.Expiry,
+				StaleVerified: s.StaleVerified,
+				IPFlags:       s.IPFlags.Strings(),
+			})
+		}
+	}
+
+	el := make([]exportLink, 0, len(links))
+	for _, l := range links {
+		el = append(el, exportLink{
+			Provider:     l.Provider,
+			ExternalID:   l.ExternalID,
+			ExternalName: l.ExternalName,
+			VerifiedAt:   l.VerifiedAt,
+		})
+	}
+
+	en := make([]nameHistoryChange, 0, len(names))
+	for _, c := range names {
+		en = append(en, nameHistoryChange{
+			Time: c.Time.UTC(),
+			From: c.From,
+			To:   c.To,
+		})
+	}
+
+	es := make([]exportSignal, 0, len(signals))
+	for _, s := range signals {
+		es = append(es, exportSignal{
+			Kind:      s.Kind,
+			Hash:      s.Hash,
+			FirstSeen: s.FirstSeen.UTC(),
+			LastSeen:  s.LastSeen.UTC(),
+		})
+	}
+
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	add := func(name string, buf []byte) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: now,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(buf)
+		return err
+	}
+	addJSON := func(name string, v any) error {
+		buf, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, append(buf, '\n'))
+	}
+	if err := func() error {
+		if err := addJSON("account.json", ea); err != nil {
+			return err
+		}
+		if err := addJSON("links.json", el); err != nil {
+			return err
+		}
+		if err := addJSON("username_history.json", en); err != nil {
+			return err
+		}
+		if err := addJSON("signals.json", es); err != nil {
+			return err
+		}
+		if pexists {
+			if err := add("pdata.bin", pbuf); err != nil {
+				return err
+			}
+
+			// include the decoded pdata and stats if possible, but still
+			// export the raw blob if it's invalid
+			var pd pdata.Pdata
+			if err := pd.UnmarshalBinary(pbuf); err != nil {
+				hash := sha256.Sum256(pbuf)
+				hlog.FromRequest(r).Warn().
+					Err(err).
+					Uint64("uid", uid).
+					Str("pdata_sha256", hex.EncodeToString(hash[:])).
+					Msgf("failed to parse pdata from storage")
+			} else {
+				for _, x := range []struct {
+					name   string
+					filter func(...string) bool
+				}{
+					{"pdata.json", nil},
+					{"stats.json", pdataFilterStats},
+				} {
+					jbuf, err := pd.MarshalJSONFilter(x.filter)
+					if err != nil {
+						return err
+					}
+					if err := add(x.name, append(jbuf, '\n')); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return zw.Close()
+	}(); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to create data export archive")
+		h.m().accounts_exportdata_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	hlog.FromRequest(r).Info().
+		Uint64("uid", uid).
+		Msgf("exported account data at player request")
+
+	h.m().accounts_exportdata_requests_total.success.Inc()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="atlas-`+strconv.FormatUint(uid, 10)+`.zip"`)
+	w.Header().Set("Content-Length", strconv.Itoa(zbuf.Len()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(zbuf.Bytes())
+}
+
+func exportIP(ip netip.Addr) string {
+	if ip.IsValid() {
+		return ip.String()
+	}
+	return ""
+}
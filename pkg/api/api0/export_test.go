@@ -0,0 +1,179 @@
+package api0
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/pdata"
+)
+
+func TestExportData(t *testing.T) {
+	as, ps := new(testAccountStorage), new(testPdataStorage)
+	h := &Handler{
+		AccountStorage: as,
+		PdataStorage:   ps,
+	}
+	for _, a := range []*Account{
+		{
+			UID:             1000,
+			Username:        "test",
+			AuthIP:          netip.MustParseAddr("192.0.2.1"),
+			AuthToken:       "secrettoken1",
+			AuthTokenExpiry: time.Now().Add(time.Hour),
+			AuthIPFlags:     IPReputationVPN,
+			OtherSessions: []AccountSession{
+				{IP: netip.MustParseAddr("192.0.2.2"), Token: "secrettoken2", Expiry: time.Now().Add(time.Minute)},
+				{IP: netip.MustParseAddr("192.0.2.3"), Token: "secrettoken3", Expiry: time.Now().Add(-time.Minute)},
+			},
+			LastServerID: "self",
+			VerifiedAt:   time.Now(),
+		},
+		{
+			UID:             1001,
+			AuthToken:       "secrettoken1",
+			AuthTokenExpiry: time.Now().Add(time.Hour),
+		},
+		{
+			UID:             1002,
+			AuthToken:       "secrettoken1",
+			AuthTokenExpiry: time.Now().Add(time.Hour),
+		},
+	} {
+		if err := as.SaveAccount(a); err != nil {
+			t.Fatalf("save account: %v", err)
+		}
+	}
+	if _, err := ps.SetPdata(1000, pdata.DefaultPdata); err != nil {
+		t.Fatalf("save pdata: %v", err)
+	}
+	if _, err := ps.SetPdata(1001, []byte("invalid")); err != nil {
+		t.Fatalf("save pdata: %v", err)
+	}
+
+	export := func(q string) (*httptest.ResponseRecorder, map[string][]byte) {
+		r := httptest.NewRequest(http.MethodPost, "/accounts/export_data?"+q, nil)
+		w := httptest.NewRecorder()
+		h.handleAccountsExportData(w, r)
+		if w.Code != http.StatusOK {
+			return w, nil
+		}
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatalf("invalid zip: %v", err)
+		}
+		fs := map[string][]byte{}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("open %s: %v", f.Name, err)
+			}
+			buf, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("read %s: %v", f.Name, err)
+			}
+			fs[f.Name] = buf
+		}
+		return w, fs
+	}
+
+	for q, status := range map[string]int{
+		"":                              http.StatusBadRequest,
+		"id=1000":                       http.StatusUnauthorized,
+		"id=1000&playerToken=asd":       http.StatusUnauthorized,
+		"id=1000&playerToken=secrettok": http.StatusUnauthorized,
+		"id=1003&playerToken=asd":       http.StatusNotFound,
+	} {
+		if w, _ := export(q); w.Code != status {
+			t.Errorf("%q: expected status %d, got %d", q, status, w.Code)
+		}
+	}
+
+	t.Run("Full", func(t *testing.T) {
+		w, fs := export("id=1000&playerToken=secrettoken2")
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/zip" {
+			t.Errorf("incorrect content type %q", ct)
+		}
+		if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="atlas-1000.zip"` {
+			t.Errorf("incorrect content disposition %q", cd)
+		}
+		for _, name := range []string{"account.json", "links.json", "username_history.json", "signals.json", "pdata.bin", "pdata.json", "stats.json"} {
+			if _, ok := fs[name]; !ok {
+				t.Errorf("missing %s", name)
+			}
+		}
+		for name, buf := range fs {
+			if bytes.Contains(buf, []byte("secrettoken")) {
+				t.Errorf("%s contains an auth token", name)
+			}
+		}
+		if !bytes.Equal(fs["pdata.bin"], pdata.DefaultPdata) {
+			t.Errorf("incorrect raw pdata")
+		}
+		for _, name := range []string{"pdata.json", "stats.json"} {
+			if !json.Valid(fs[name]) {
+				t.Errorf("%s is not valid json", name)
+			}
+		}
+		if len(fs["stats.json"]) >= len(fs["pdata.json"]) {
+			t.Errorf("expected stats to be a subset of the pdata")
+		}
+
+		var acct struct {
+			UID          uint64 `json:"uid"`
+			Username     string `json:"username"`
+			LastServerID string `json:"last_server_id"`
+			VerifiedAt   string `json:"verified_at"`
+			Sessions     []struct {
+				IP      string   `json:"ip"`
+				IPFlags []string `json:"ip_flags"`
+			} `json:"sessions"`
+		}
+		if err := json.Unmarshal(fs["account.json"], &acct); err != nil {
+			t.Fatalf("invalid account.json: %v", err)
+		}
+		if acct.UID != 1000 || acct.Username != "test" || acct.LastServerID != "self" || acct.VerifiedAt == "" {
+			t.Errorf("incorrect account %+v", acct)
+		}
+		if len(acct.Sessions) != 2 || acct.Sessions[0].IP != "192.0.2.1" || acct.Sessions[1].IP != "192.0.2.2" {
+			t.Errorf("expected current and unexpired sessions, got %+v", acct.Sessions)
+		} else if len(acct.Sessions[0].IPFlags) != 1 || len(acct.Sessions[1].IPFlags) != 0 {
+			t.Errorf("incorrect session ip flags %+v", acct.Sessions)
+		}
+		for _, name := range []string{"links.json", "username_history.json", "signals.json"} {
+			if v := string(bytes.TrimSpace(fs[name])); v != "[]" {
+				t.Errorf("%s: expected empty array without storage, got %s", name, v)
+			}
+		}
+	})
+
+	t.Run("InvalidPdata", func(t *testing.T) {
+		_, fs := export("id=1001&playerToken=secrettoken1")
+		if string(fs["pdata.bin"]) != "invalid" {
+			t.Errorf("expected raw pdata to be exported")
+		}
+		if _, ok := fs["pdata.json"]; ok {
+			t.Errorf("expected no decoded pdata for invalid pdata")
+		}
+	})
+
+	t.Run("NoPdata", func(t *testing.T) {
+		_, fs := export("id=1002&playerToken=secrettoken1")
+		if _, ok := fs["account.json"]; !ok {
+			t.Errorf("missing account.json")
+		}
+		if _, ok := fs["pdata.bin"]; ok {
+			t.Errorf("expected no pdata")
+		}
+	})
+}
@@ -0,0 +1,318 @@
+package api0
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// Features which can be rolled out gradually using feature flags. Features
+// without a flag are enabled (subject to their other options) unless they are
+// in featureDefaultDisabled.
+const (
+	FeatureMatchmaking      = "matchmaking"       // the matchmaking queue (also requires Matchmaking.MatchSize)
+	FeaturePdataPatch       = "pdata_patch"       // partial pdata writes using PATCH /accounts/write_persistence
+	FeatureServerListCanary = "serverlist_canary" // the alternate server list ranking (also requires ServerListCanary)
+)
+
+// Features contains all known feature names.
+var Features = []string{
+	FeatureMatchmaking,
+	FeaturePdataPatch,
+	FeatureServerListCanary,
+}
+
+// featureDefaultDisabled contains features which are disabled for everyone
+// until they are flagged.
+var featureDefaultDisabled = map[string]bool{
+	FeatureServerListCanary: true,
+}
+
+func isFeature(name string) bool {
+	for _, f := range Features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FeatureFlag controls the rollout of a feature.
+type FeatureFlag struct {
+	// Percent is the percentage of players the feature is enabled for. The
+	// same players stay enabled as it is increased.
+	Percent int `json:"percent"`
+
+	// UIDs contains players the feature is always enabled for (e.g., testers).
+	UIDs []uint64 `json:"uids,omitempty"`
+}
+
+// FeatureFlags contains the flags for features by name.
+type FeatureFlags map[string]FeatureFlag
+
+// ParseFeatureFlags parses comma-separated flags in the format
+// [tenant/]feature=percent, returning the flags for tenant (or the default
+// tenant if empty). Tenant-specific flags take precedence.
+func ParseFeatureFlags(specs []string, tenant string) (FeatureFlags, error) {
+	ff := FeatureFlags{}
+	specific := map[string]bool{}
+	for _, spec := range specs {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("feature flag %q: expected [tenant/]feature=percent", spec)
+		}
+		t, name, ok := strings.Cut(k, "/")
+		if !ok {
+			t, name = "", k
+		}
+		if !isFeature(name) {
+			return nil, fmt.Errorf("feature flag %q: unknown feature %q", spec, name)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(v, "%"))
+		if err != nil || n < 0 || n > 100 {
+			return nil, fmt.Errorf("feature flag %q: percent must be between 0 and 100", spec)
+		}
+		if t != tenant || (t == "" && specific[name]) {
+			continue
+		}
+		ff[name] = FeatureFlag{Percent: n}
+		if t != "" {
+			specific[name] = true
+		}
+	}
+	return ff, nil
+}
+
+// Validate checks f.
+func (f FeatureFlag) Validate() error {
+	if f.Percent < 0 || f.Percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100")
+	}
+	return nil
+}
+
+// Enabled checks if the feature is enabled for uid.
+func (f FeatureFlag) Enabled(name string, uid uint64) bool {
+	for _, x := range f.UIDs {
+		if x == uid {
+			return true
+		}
+	}
+	switch {
+	case f.Percent >= 100:
+		return true
+	case f.Percent <= 0:
+		return false
+	}
+	// hash with the name so different features aren't enabled for the same
+	// players
+	x := fnv.New32a()
+	x.Write([]byte(name))
+	x.Write([]byte{0})
+	x.Write(strconv.AppendUint(nil, uid, 10))
+	return int(x.Sum32()%100) < f.Percent
+}
+
+// featureFlag gets the effective flag for a feature, and whether it has one.
+// Flags set via the admin API take precedence over configured ones.
+func (h *Handler) featureFlag(r *http.Request, name string) (FeatureFlag, string, bool) {
+	o, err := h.featureFlagOverrides.Get(h.StateStorage, "featureflags")
+	if err != nil {
+		hlog.FromRequest(r).Warn().Err(err).Msg("failed to load feature flag overrides, using configured flags")
+	} else if f, ok := o[name]; ok {
+		return f, "admin", true
+	}
+	if f, ok := h.cfg().FeatureFlags[name]; ok {
+		return f, "config", true
+	}
+	return FeatureFlag{}, "", false
+}
+
+// featureEnabled checks if a feature is enabled for uid.
+func (h *Handler) featureEnabled(r *http.Request, name string, uid uint64) bool {
+	f, _, ok := h.featureFlag(r, name)
+	if ok && f.Enabled(name, uid) || !ok && !featureDefaultDisabled[name] {
+		h.m().feature_checks_total.enabled(name).Inc()
+		return true
+	}
+	h.m().feature_checks_total.disabled(name).Inc()
+	return false
+}
+
+// serverListCanary checks if the alternate server list ranking should be used
+// for a client. Since the list is usually requested without authentication,
+// clients are bucketed by IP address rather than uid so their list and joins
+// are attributed to the same variant, but players in the flag's UIDs are
+// always included if uid is known.
+func (h *Handler) serverListCanary(r *http.Request, uid uint64) bool {
+	if h.ServerListCanary == ServerListRankDefault {
+		return false
+	}
+	f, _, ok := h.featureFlag(r, FeatureServerListCanary)
+	if ok && uid != 0 {
+		for _, x := range f.UIDs {
+			if x == uid {
+				h.m().feature_checks_total.enabled(FeatureServerListCanary).Inc()
+				return true
+			}
+		}
+	}
+	var key uint64
+	if a, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		x := fnv.New64a()
+		x.Write(a.Addr().Unmap().AsSlice())
+		key = x.Sum64()
+	}
+	if ok && (FeatureFlag{Percent: f.Percent}).Enabled(FeatureServerListCanary, key) {
+		h.m().feature_checks_total.enabled(FeatureServerListCanary).Inc()
+		return true
+	}
+	h.m().feature_checks_total.disabled(FeatureServerListCanary).Inc()
+	return false
+}
+
+func (h *Handler) handleAdminFeatures(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "features"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PUT, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	var (
+		uid    uint64
+		hasUID bool
+	)
+	if v := r.URL.Query().Get("uid"); v != "" {
+		x, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid uid"))
+			return
+		}
+		uid, hasUID = x, true
+	}
+
+	name := r.URL.Query().Get("name")
+	if r.Method == http.MethodPut || r.Method == http.MethodDelete || name != "" {
+		if !isFeature(name) {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("unknown feature %q", name))
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var f FeatureFlag
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&f); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid json: %v", err))
+			return
+		}
+		if err := f.Validate(); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", err))
+			return
+		}
+		if err := h.featureFlagOverrides.Update(h.StateStorage, "featureflags", func(o FeatureFlags) (FeatureFlags, error) {
+			n := make(FeatureFlags, len(o)+1)
+			for k, v := range o {
+				n[k] = v
+			}
+			n[name] = f
+			return n, nil
+		}); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to save feature flags to storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		hlog.FromRequest(r).Info().
+			Str("feature", name).
+			Int("percent", f.Percent).
+			Int("uids", len(f.UIDs)).
+			Msg("set feature flag")
+	case http.MethodDelete:
+		// reset to the configured flag
+		if err := h.featureFlagOverrides.Update(h.StateStorage, "featureflags", func(o FeatureFlags) (FeatureFlags, error) {
+			n := make(FeatureFlags, len(o))
+			for k, v := range o {
+				if k != name {
+					n[k] = v
+				}
+			}
+			return n, nil
+		}); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to save feature flags to storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		hlog.FromRequest(r).Info().
+			Str("feature", name).
+			Msg("reset feature flag")
+	}
+
+	type feature struct {
+		Name    string       `json:"name"`
+		Source  string       `json:"source,omitempty"` // config, admin, or empty if not flagged
+		Flag    *FeatureFlag `json:"flag,omitempty"`
+		Enabled *bool        `json:"enabled,omitempty"` // for the uid, if provided
+	}
+	var fs []feature
+	for _, n := range Features {
+		if name != "" && n != name {
+			continue
+		}
+		x := feature{Name: n}
+		f, src, ok := h.featureFlag(r, n)
+		if ok {
+			x.Source, x.Flag = src, &f
+		}
+		if hasUID {
+			v := ok && f.Enabled(n, uid) || !ok && !featureDefaultDisabled[n]
+			x.Enabled = &v
+		}
+		fs = append(fs, x)
+	}
+	sort.Slice(fs, func(i, j int) bool {
+		return fs[i].Name < fs[j].Name
+	})
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":  true,
+		"features": fs,
+	})
+}
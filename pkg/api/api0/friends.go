@@ -0,0 +1,301 @@
+package api0
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// friendsMax is the maximum number of friends (including pending requests) a
+// player can have.
+const friendsMax = 200
+
+// friendJSON is the representation of a friend returned to the player.
+type friendJSON struct {
+	UID      uint64            `json:"uid,string"`
+	Username string            `json:"username,omitempty"`
+	Status   FriendStatus      `json:"status"`
+	Since    int64             `json:"since"`
+	Online   bool              `json:"online"`
+	Server   *friendServerJSON `json:"server,omitempty"`
+}
+
+type friendServerJSON struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Password bool   `json:"password"`
+}
+
+// handleClientFriends manages a player's friends list. Since EA's social
+// features aren't available through Northstar, this allows launchers to show
+// which server friends are playing on (from the presence tracked by
+// /server/players).
+//
+// Query parameters:
+//   - id: the player uid
+//   - token: the player's master server token
+//
+// GET returns the "friends" of the player, including pending requests. POST
+// takes an "action" (request, accept, or remove) and the "uid" of the other
+// player. Removing a pending request declines or cancels it.
+func (h *Handler) handleClientFriends(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.m().client_friends_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.FriendStorage == nil {
+		h.m().client_friends_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("friends are not enabled"))
+		return
+	}
+
+	uid, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		h.m().client_friends_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	acct, err := h.AccountStorage.GetAccount(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read account from storage")
+		h.m().client_friends_requests_total.fail_storage_error_account.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if acct == nil {
+		h.m().client_friends_requests_total.reject_player_not_found.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+	if !h.checkPlayerToken(acct, r.URL.Query().Get("token")) {
+		h.m().client_friends_requests_total.reject_masterserver_token.Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		h.handleClientFriendsAction(w, r, acct)
+		return
+	}
+
+	fs, err := h.FriendStorage.GetFriends(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read friends from storage")
+		h.m().client_friends_requests_total.fail_storage_error_friends.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	now := time.Now()
+	ac := &serverAllowChecker{h: h, r: r, uid: acct.UID}
+	friends := make([]friendJSON, 0, len(fs))
+	for _, f := range fs {
+		x := friendJSON{
+			UID:    f.Friend,
+			Status: f.Status,
+			Since:  f.Updated.Unix(),
+		}
+		if fa, err := h.AccountStorage.GetAccount(f.Friend); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", f.Friend).
+				Msgf("failed to read account from storage")
+			h.m().client_friends_requests_total.fail_storage_error_account.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		} else if fa != nil {
+			x.Username = fa.Username
+		}
+		// presence is only visible to accepted friends
+		if f.Status == FriendStatusAccepted {
+			if p, ok := h.playerPresence(f.Friend, now); ok {
+				x.Online = true
+				if srv := h.ServerList.GetServerByID(p.Server); srv != nil && ac.Allowed(srv) {
+					x.Server = &friendServerJSON{
+						ID:       srv.ID,
+						Name:     srv.Name,
+						Password: srv.Password != "",
+					}
+				}
+			}
+		}
+		friends = append(friends, x)
+	}
+
+	h.m().client_friends_requests_total.success_list.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"friends": friends,
+	})
+}
+
+func (h *Handler) handleClientFriendsAction(w http.ResponseWriter, r *http.Request, acct *Account) {
+	var q struct {
+		Action string `param:"action" validate:"required,oneof=request|accept|remove"`
+		UID    uint64 `param:"uid" validate:"required"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().client_friends_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+	if q.UID == acct.UID {
+		h.m().client_friends_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("cannot %s yourself", q.Action))
+		return
+	}
+
+	storageError := func(err error, what string) {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", acct.UID).
+			Uint64("friend", q.UID).
+			Msgf("failed to %s friend", what)
+		h.m().client_friends_requests_total.fail_storage_error_friends.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+	}
+
+	// both sides are read and written together, so don't let concurrent
+	// requests interleave
+	h.friendsMu.Lock()
+	defer h.friendsMu.Unlock()
+
+	cur, err := h.FriendStorage.GetFriend(acct.UID, q.UID)
+	if err != nil {
+		storageError(err, "read")
+		return
+	}
+
+	now := time.Now()
+	pair := func(status, other FriendStatus) []Friend {
+		created := now
+		if cur != nil {
+			created = cur.Created
+		}
+		return []Friend{
+			{UID: acct.UID, Friend: q.UID, Status: status, Created: created, Updated: now},
+			{UID: q.UID, Friend: acct.UID, Status: other, Created: created, Updated: now},
+		}
+	}
+
+	var status FriendStatus
+	switch q.Action {
+	case "request":
+		if cur != nil && cur.Status == FriendStatusIncoming {
+			// they already sent a request, so just accept it
+			if err := h.FriendStorage.SaveFriends(pair(FriendStatusAccepted, FriendStatusAccepted)); err != nil {
+				storageError(err, "save")
+				return
+			}
+			status = FriendStatusAccepted
+			break
+		}
+		if cur != nil {
+			status = cur.Status // already requested or friends
+			break
+		}
+		if blocked, err := h.blocked(acct.UID, q.UID); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", acct.UID).
+				Uint64("friend", q.UID).
+				Msgf("failed to read blocks from storage")
+			h.m().client_friends_requests_total.fail_storage_error_blocks.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		} else if blocked {
+			h.m().client_friends_requests_total.reject_blocked.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_BAD_REQUEST.MessageObjf("player is blocked"))
+			return
+		}
+		if fa, err := h.AccountStorage.GetAccount(q.UID); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", q.UID).
+				Msgf("failed to read account from storage")
+			h.m().client_friends_requests_total.fail_storage_error_account.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		} else if fa == nil {
+			h.m().client_friends_requests_total.reject_player_not_found.Inc()
+			respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+			return
+		}
+		for _, x := range []uint64{acct.UID, q.UID} {
+			fs, err := h.FriendStorage.GetFriends(x)
+			if err != nil {
+				storageError(err, "read")
+				return
+			}
+			if len(fs) >= friendsMax {
+				h.m().client_friends_requests_total.reject_limit.Inc()
+				respFail(w, r, http.StatusForbidden, ErrorCode_BAD_REQUEST.MessageObjf("too many friends (max %d)", friendsMax))
+				return
+			}
+		}
+		if err := h.FriendStorage.SaveFriends(pair(FriendStatusOutgoing, FriendStatusIncoming)); err != nil {
+			storageError(err, "save")
+			return
+		}
+		status = FriendStatusOutgoing
+
+	case "accept":
+		if cur == nil || cur.Status == FriendStatusOutgoing {
+			h.m().client_friends_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("no friend request from player"))
+			return
+		}
+		if cur.Status != FriendStatusAccepted {
+			if err := h.FriendStorage.SaveFriends(pair(FriendStatusAccepted, FriendStatusAccepted)); err != nil {
+				storageError(err, "save")
+				return
+			}
+		}
+		status = FriendStatusAccepted
+
+	case "remove":
+		if cur != nil {
+			if err := h.FriendStorage.DeleteFriend(acct.UID, q.UID); err != nil {
+				storageError(err, "delete")
+				return
+			}
+		}
+		h.m().client_friends_requests_total.success_remove.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"removed": cur != nil,
+		})
+		return
+	}
+
+	if q.Action == "accept" || (cur != nil && cur.Status == FriendStatusIncoming) {
+		h.m().client_friends_requests_total.success_accept.Inc()
+	} else {
+		h.m().client_friends_requests_total.success_request.Inc()
+	}
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"status":  status,
+	})
+}
@@ -0,0 +1,212 @@
+package api0
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// GuestUIDMin is the first UID reserved for guest accounts, which is far above
+// the range of Origin UIDs.
+const GuestUIDMin uint64 = 1 << 62
+
+// guestRateLimit is the maximum number of guest sessions which can be created
+// per minute from a single IP.
+const guestRateLimit = 10
+
+// IsGuestUID checks if uid is in the range reserved for guest accounts.
+func IsGuestUID(uid uint64) bool {
+	return uid >= GuestUIDMin
+}
+
+// GuestMode configures restricted guest sessions for events (e.g., LAN
+// parties) where Origin is unavailable. Guests get a new random UID in the
+// reserved range for each session, their username is prefixed with "guest_",
+// and their pdata is never persisted (writes succeed, but are discarded, so
+// they always get the default pdata).
+type GuestMode struct {
+	// Enabled enables /client/guest_auth.
+	Enabled bool
+
+	// TokenExpiry is how long guest tokens are valid for. If zero, it defaults
+	// to 2 hours.
+	TokenExpiry time.Duration
+
+	// Networks restricts guest auth to clients in the specified prefixes. If
+	// empty, only private and loopback addresses are allowed.
+	Networks []netip.Prefix
+}
+
+func (g GuestMode) tokenExpiry() time.Duration {
+	if g.TokenExpiry > 0 {
+		return g.TokenExpiry
+	}
+	return time.Hour * 2
+}
+
+// Allowed checks if guests are allowed to authenticate from ip.
+func (g GuestMode) Allowed(ip netip.Addr) bool {
+	if !g.Enabled {
+		return false
+	}
+	ip = ip.Unmap()
+	if len(g.Networks) == 0 {
+		return ip.IsPrivate() || ip.IsLoopback()
+	}
+	for _, p := range g.Networks {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validGuestName checks if name is a valid guest username.
+func validGuestName(name string) bool {
+	if name == "" || len(name) > 24 {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// newGuestUID generates a random guest UID.
+func newGuestUID() (uint64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return GuestUIDMin | binary.LittleEndian.Uint64(b[:])&(GuestUIDMin-1), nil
+}
+
+// handleClientGuestAuth creates a guest session when GuestMode is enabled.
+//
+// Query parameters:
+//   - name: the username to use (1-24 of A-Z, a-z, 0-9, _, -)
+//
+// The response is like /client/origin_auth, but also includes the new guest
+// "id" (as a string) and "guest": true.
+func (h *Handler) handleClientGuestAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.m().client_guestauth_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.GuestMode.Enabled {
+		h.m().client_guestauth_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("guest mode is not enabled"))
+		return
+	}
+
+	if !h.CheckLauncherVersion(r, true) {
+		h.m().client_guestauth_requests_total.reject_versiongate.Inc()
+		h.respUpdateRequired(w, r, true)
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().client_guestauth_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	if !h.GuestMode.Allowed(raddr.Addr()) {
+		h.m().client_guestauth_requests_total.reject_network.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_NETWORK_BLOCKED.MessageObjf("guest mode is not available from your network"))
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if !validGuestName(name) {
+		h.m().client_guestauth_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("name param must be 1-24 letters, digits, underscores, or hyphens"))
+		return
+	}
+
+	now := time.Now()
+	if !h.guestLimiter.Allow(raddr.Addr(), now, guestRateLimit, time.Minute) {
+		h.m().client_guestauth_requests_total.reject_rate_limited.Inc()
+		respFail(w, r, http.StatusTooManyRequests, ErrorCode_RATE_LIMITED.MessageObjf("too many guest sessions"))
+		return
+	}
+
+	uid, err := newGuestUID()
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to generate guest uid")
+		h.m().client_guestauth_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	token, err := cryptoRandHex(32)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to generate random token")
+		h.m().client_guestauth_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	acct := &Account{
+		UID:             uid,
+		Username:        "guest_" + name,
+		AuthIP:          raddr.Addr(),
+		AuthToken:       token,
+		AuthTokenExpiry: now.Add(h.GuestMode.tokenExpiry()),
+	}
+	if err := h.runAuthHooks(r, acct); err != nil {
+		h.m().client_guestauth_requests_total.reject_hook.Inc()
+		status, obj := hookError(err)
+		respFail(w, r, status, obj)
+		return
+	}
+	if err := h.accountStorage(r).SaveAccount(acct); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to save account to storage")
+		h.m().client_guestauth_requests_total.fail_storage_error_account.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	hlog.FromRequest(r).Info().
+		Uint64("uid", uid).
+		Str("username", acct.Username).
+		Msg("created guest session")
+
+	h.m().client_guestauth_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"id":      strconv.FormatUint(uid, 10),
+		"token":   token,
+		"guest":   true,
+	})
+}
@@ -0,0 +1,157 @@
+package api0
+
+import (
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/pg9182/ip2x"
+)
+
+// serverTrendWindow is how often the player count is sampled for the
+// population trend hint. The trend compares the current player count to the
+// sample taken between one and two windows ago.
+const serverTrendWindow = time.Minute * 5
+
+// serverHints are computed ranking fields included with each server in the
+// server list so clients can offer a consistent "recommended" sort.
+//
+// The recommended order is:
+//
+//  1. servers with a ping region matching the client's (as returned in the
+//     X-Atlas-Ping-Region header of the server list response) first
+//  2. then by score, highest first
+//  3. then by player count, highest first
+//  4. then by server ID, in ascending byte order
+type serverHints struct {
+	// PingRegion is a coarse continent-level zone (na, sa, eu, af, as, oc)
+	// based on the server location, for estimating whether the ping will be
+	// reasonable. It is blank if the location is unknown or the server has a
+	// password (like the region).
+	PingRegion string
+
+	// Freshness is 100 for a server which just sent a heartbeat, decreasing
+	// linearly to 0 when it would be considered dead.
+	Freshness int
+
+	// Verified is true if the identity of the server is verified (i.e., it
+	// is a trusted server or requires signed requests).
+	Verified bool
+
+	// Trend is 1 if the player count is increasing, -1 if it is decreasing,
+	// or 0 otherwise.
+	Trend int
+
+	// Score is the overall recommendation score from 0 to 650, which is the
+	// sum of:
+	//   - 0-400 for the fraction of filled slots (100 if full)
+	//   - 0-100 for the freshness
+	//   - 100 if verified
+	//   - 50 if the trend is increasing or -50 if decreasing
+	// Servers with a password always have a score of 0.
+	Score int
+}
+
+// csHints computes the hints for srv at t. The read lock must be held if srv
+// isn't a copy.
+func (s *ServerList) csHints(srv *Server, t time.Time) serverHints {
+	var h serverHints
+	if srv.Password == "" {
+		h.PingRegion = pingRegion(srv.Latitude, srv.Longitude)
+	}
+
+	h.Freshness = 100
+	if s.deadTime != 0 {
+		if h.Freshness = int(100 - 100*t.Sub(srv.LastHeartbeat)/s.deadTime); h.Freshness < 0 {
+			h.Freshness = 0
+		} else if h.Freshness > 100 {
+			h.Freshness = 100
+		}
+	}
+
+	h.Verified = srv.Attestation != "" || srv.SigningRequired
+
+	switch {
+	case srv.PlayerCount > srv.playersPrev:
+		h.Trend = 1
+	case srv.PlayerCount < srv.playersPrev:
+		h.Trend = -1
+	}
+
+	if srv.Password == "" {
+		switch {
+		case srv.MaxPlayers <= 0:
+		case srv.PlayerCount >= srv.MaxPlayers:
+			h.Score += 100
+		default:
+			h.Score += 400 * srv.PlayerCount / srv.MaxPlayers
+		}
+		h.Score += h.Freshness
+		if h.Verified {
+			h.Score += 100
+		}
+		if h.Score += 50 * h.Trend; h.Score < 0 {
+			h.Score = 0
+		}
+	}
+	return h
+}
+
+// samplePlayers updates the player count samples for the trend hint. It must
+// be called while holding the write lock whenever the server is updated.
+func (srv *Server) samplePlayers(t time.Time) {
+	if srv.playersSampled.IsZero() {
+		srv.playersPrev, srv.playersCur, srv.playersSampled = srv.PlayerCount, srv.PlayerCount, t
+		return
+	}
+	if t.Sub(srv.playersSampled) >= serverTrendWindow {
+		srv.playersPrev, srv.playersCur, srv.playersSampled = srv.playersCur, srv.PlayerCount, t
+	}
+}
+
+// pingRegion gets a coarse continent-level zone for a location. It isn't
+// exact, but it's good enough for estimating whether the latency will be
+// reasonable.
+func pingRegion(lat, lon float64) string {
+	switch {
+	case lat == 0 && lon == 0:
+		return ""
+	case lon < -30:
+		if lat >= 13 {
+			return "na"
+		}
+		return "sa"
+	case lon < 60:
+		if lat >= 36 {
+			return "eu"
+		}
+		if lon < 35 || lat < 12 {
+			return "af"
+		}
+		return "as" // middle east
+	default:
+		if lat < -10 && lon >= 110 {
+			return "oc"
+		}
+		return "as"
+	}
+}
+
+// clientPingRegion gets the ping region for the client making r, or an empty
+// string if it isn't known.
+func (h *Handler) clientPingRegion(r *http.Request) string {
+	if h.LookupIP == nil {
+		return ""
+	}
+	a, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	c, err := h.LookupIP(a.Addr())
+	if err != nil {
+		return ""
+	}
+	lat, _ := c.GetFloat32(ip2x.Latitude)
+	lon, _ := c.GetFloat32(ip2x.Longitude)
+	return pingRegion(float64(lat), float64(lon))
+}
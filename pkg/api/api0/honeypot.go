@@ -0,0 +1,307 @@
+package api0
+
+import (
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// Honeypot configures decoy paths and query parameters which are never used by
+// legitimate clients. IPs (or IPv6 /64s) which use them are tagged as
+// offenders and aggressively rate limited, and if AnomalyDetection is enabled,
+// they are tarpitted immediately (and blocked if they do it again while
+// tarpitted). This is a cheap way of identifying scanners and attack tooling.
+type Honeypot struct {
+	// Enabled controls whether decoys are checked.
+	Enabled bool
+
+	// Paths overrides the decoy paths. If empty, a reasonable default is
+	// used.
+	Paths []string
+
+	// Params overrides the decoy query parameters. If empty, a reasonable
+	// default is used.
+	Params []string
+
+	// TagDuration is how long offenders remain tagged after their last hit.
+	// If zero, it defaults to 24 hours.
+	TagDuration time.Duration
+
+	// RateLimit is the maximum number of requests per minute from a tagged
+	// offender. If zero, it defaults to 10.
+	RateLimit int
+}
+
+// defaultHoneypotPaths are paths commonly probed by scanners, and plausible
+// looking endpoints which don't exist.
+var defaultHoneypotPaths = []string{
+	"/.env",
+	"/.git/config",
+	"/wp-login.php",
+	"/xmlrpc.php",
+	"/phpmyadmin/index.php",
+	"/actuator/env",
+	"/admin/login",
+	"/client/admin_auth",
+	"/server/debug_exec",
+	"/accounts/dump",
+}
+
+// defaultHoneypotParams are query parameters which look like they might
+// enable something interesting.
+var defaultHoneypotParams = []string{
+	"debug",
+	"admin",
+	"isAdmin",
+	"cmd",
+	"exec",
+	"XDEBUG_SESSION_START",
+}
+
+func (c Honeypot) paths() []string {
+	if len(c.Paths) == 0 {
+		return defaultHoneypotPaths
+	}
+	return c.Paths
+}
+
+func (c Honeypot) params() []string {
+	if len(c.Params) == 0 {
+		return defaultHoneypotParams
+	}
+	return c.Params
+}
+
+func (c Honeypot) tagDuration() time.Duration {
+	if c.TagDuration <= 0 {
+		return time.Hour * 24
+	}
+	return c.TagDuration
+}
+
+func (c Honeypot) rateLimit() int {
+	if c.RateLimit <= 0 {
+		return 10
+	}
+	return c.RateLimit
+}
+
+// honeypotTag is a tagged offender.
+type honeypotTag struct {
+	Prefix netip.Prefix `json:"prefix"`
+	Hits   int          `json:"hits"`
+	Last   string       `json:"last"` // the last decoy hit
+	Since  time.Time    `json:"since"`
+	Until  time.Time    `json:"until"`
+}
+
+// honeypotTags tracks tagged offenders.
+type honeypotTags struct {
+	mu      sync.Mutex
+	tags    map[netip.Prefix]honeypotTag
+	limiter rateLimiter[netip.Prefix]
+}
+
+// tag tags p at t for dur due to hit, returning the updated tag.
+func (x *honeypotTags) tag(p netip.Prefix, t time.Time, dur time.Duration, hit string) honeypotTag {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.tags == nil {
+		x.tags = make(map[netip.Prefix]honeypotTag)
+	}
+
+	// forget expired tags so the map doesn't grow forever
+	for k, v := range x.tags {
+		if !t.Before(v.Until) {
+			delete(x.tags, k)
+		}
+	}
+
+	v, ok := x.tags[p]
+	if !ok {
+		v = honeypotTag{Prefix: p, Since: t}
+	}
+	v.Hits++
+	v.Last = hit
+	v.Until = t.Add(dur)
+	x.tags[p] = v
+	return v
+}
+
+// tagged checks if p is tagged at t.
+func (x *honeypotTags) tagged(p netip.Prefix, t time.Time) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	v, ok := x.tags[p]
+	if ok && !t.Before(v.Until) {
+		delete(x.tags, p)
+		return false
+	}
+	return ok
+}
+
+// list gets the active tags at t, sorted by prefix.
+func (x *honeypotTags) list(t time.Time) []honeypotTag {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	vs := []honeypotTag{}
+	for _, v := range x.tags {
+		if t.Before(v.Until) {
+			vs = append(vs, v)
+		}
+	}
+	sort.Slice(vs, func(i, j int) bool {
+		return vs[i].Prefix.Addr().Less(vs[j].Prefix.Addr())
+	})
+	return vs
+}
+
+// clear removes the tag for p, or all of them if p is invalid.
+func (x *honeypotTags) clear(p netip.Prefix) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if !p.IsValid() {
+		x.tags = nil
+		x.limiter.Clear()
+		return
+	}
+	delete(x.tags, p)
+	x.limiter.Reset(p)
+}
+
+// checkHoneypot tags the client if r uses a decoy, and rate limits tagged
+// clients. If r is rejected, it writes an error response and returns false.
+// Decoy hits are otherwise handled normally so they look like any other
+// request.
+func (h *Handler) checkHoneypot(w http.ResponseWriter, r *http.Request) bool {
+	c := h.Honeypot
+	if !c.Enabled {
+		return true
+	}
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return true
+	}
+	p, _ := anomalyPrefixes(raddr.Addr())
+	t := time.Now()
+
+	var hit string
+	for _, x := range c.paths() {
+		if x == r.URL.Path {
+			h.m().honeypot_hits_total.path.Inc()
+			hit = "path " + x
+			break
+		}
+	}
+	if hit == "" {
+		q := r.URL.Query()
+		for _, x := range c.params() {
+			if q.Has(x) {
+				h.m().honeypot_hits_total.param.Inc()
+				hit = "param " + x
+				break
+			}
+		}
+	}
+	if hit != "" {
+		v := h.honeypot.tag(p, t, c.tagDuration(), hit)
+
+		hlog.FromRequest(r).Warn().
+			Str("prefix", p.String()).
+			Str("hit", hit).
+			Int("hits", v.Hits).
+			Msgf("honeypot hit, tagging offender")
+
+		if a := h.AnomalyDetection; a.enabled() {
+			x := h.anomaly.escalate(p, t, a.duration(), "honeypot "+hit)
+			if x.Block {
+				h.m().anomaly_penalties_total.block("ip").Inc()
+			} else {
+				h.m().anomaly_penalties_total.tarpit("ip").Inc()
+			}
+		}
+	} else if !h.honeypot.tagged(p, t) {
+		return true
+	}
+
+	if !h.honeypot.limiter.Allow(p, t, c.rateLimit(), time.Minute) {
+		h.m().honeypot_checks_total.reject_rate_limited.Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(60))
+		respFail(w, r, http.StatusTooManyRequests, ErrorCode_RATE_LIMITED.MessageObj())
+		return false
+	}
+	return true
+}
+
+func (h *Handler) handleAdminHoneypot(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "honeypot"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"enabled": h.Honeypot.Enabled,
+			"tags":    h.honeypot.list(time.Now()),
+		})
+		return
+	case http.MethodDelete:
+		var q struct {
+			Prefix string `param:"prefix"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		var p netip.Prefix
+		if q.Prefix != "" {
+			var err error
+			if p, err = netip.ParsePrefix(q.Prefix); err != nil {
+				h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+				respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid prefix: %v", err))
+				return
+			}
+			p = p.Masked()
+		}
+		h.honeypot.clear(p)
+
+		hlog.FromRequest(r).Info().
+			Str("prefix", q.Prefix).
+			Msgf("cleared honeypot tags")
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
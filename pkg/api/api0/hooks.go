@@ -0,0 +1,224 @@
+package api0
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/r2northstar/atlas/pkg/pdata"
+	"github.com/rs/zerolog/hlog"
+)
+
+// Hook is a compiled-in extension which can implement custom policies without
+// changing the handlers. It should also implement at least one of AuthHook,
+// RegistrationHook, PdataWriteHook, or ListQueryHook. Hooks are called
+// synchronously from request handlers, so they should be fast and must be safe
+// for concurrent use.
+type Hook interface {
+	// HookName returns a unique name for the hook.
+	HookName() string
+}
+
+// AuthHook is called for player auth after the built-in checks pass, but
+// before the session is created. It may modify acct. If an error is returned,
+// the player is rejected (see hookError).
+type AuthHook interface {
+	Hook
+	OnAuth(r *http.Request, acct *Account) error
+}
+
+// RegistrationHook is called before a server is added to the server list (for
+// add_server and update_values). It may modify srv. If an error is returned,
+// the server is rejected (see hookError).
+type RegistrationHook interface {
+	Hook
+	OnRegister(r *http.Request, srv *Server) error
+}
+
+// PdataWriteHook is called before pdata is written by a game server, after it
+// has been checked against the pdata rules. It may modify next (e.g., to grant
+// event rewards), but not prev. If an error is returned, the write is rejected
+// (see hookError).
+type PdataWriteHook interface {
+	Hook
+	OnPdataWrite(r *http.Request, uid uint64, prev, next *pdata.Pdata) error
+}
+
+// ListQueryHook is called for each server when the server list is requested,
+// and returns false to hide it from the client. If any are enabled, the server
+// list is generated for each request rather than being cached, so it should
+// only be used if necessary.
+type ListQueryHook interface {
+	Hook
+	OnListQuery(r *http.Request, srv *Server) bool
+}
+
+var registeredHooks struct {
+	mu sync.Mutex
+	m  map[string]Hook
+}
+
+// RegisterHook registers a hook so it can be enabled by name. It is intended
+// to be called from the init function of the package implementing the hook,
+// which can then be compiled in with a blank import. It panics if a hook with
+// the same name has already been registered.
+func RegisterHook(h Hook) {
+	registeredHooks.mu.Lock()
+	defer registeredHooks.mu.Unlock()
+
+	n := h.HookName()
+	if n == "" {
+		panic("api0: hook name must not be empty")
+	}
+	if _, ok := registeredHooks.m[n]; ok {
+		panic("api0: hook " + n + " already registered")
+	}
+	if registeredHooks.m == nil {
+		registeredHooks.m = make(map[string]Hook)
+	}
+	registeredHooks.m[n] = h
+}
+
+// RegisteredHooks gets the registered hooks with the provided names in order,
+// or all of them sorted by name if none are provided.
+func RegisteredHooks(names ...string) ([]Hook, error) {
+	registeredHooks.mu.Lock()
+	defer registeredHooks.mu.Unlock()
+
+	if len(names) == 0 {
+		for n := range registeredHooks.m {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+	}
+	hs := make([]Hook, 0, len(names))
+	for _, n := range names {
+		h, ok := registeredHooks.m[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown hook %q", n)
+		}
+		hs = append(hs, h)
+	}
+	return hs, nil
+}
+
+// hookError gets the response for an error returned by a hook. If it is a
+// StatusError, it is used as-is. Otherwise, the error message is shown to the
+// client with POLICY_REJECTED.
+func hookError(err error) (int, ErrorObj) {
+	var se StatusError
+	if errors.As(err, &se) {
+		return se.Status, se.Obj
+	}
+	return http.StatusForbidden, ErrorCode_POLICY_REJECTED.MessageObjf("%v", err)
+}
+
+// runAuthHooks calls the enabled AuthHooks, stopping at the first error.
+func (h *Handler) runAuthHooks(r *http.Request, acct *Account) error {
+	for _, x := range h.Hooks {
+		if x, ok := x.(AuthHook); ok {
+			if err := x.OnAuth(r, acct); err != nil {
+				hlog.FromRequest(r).Info().
+					Err(err).
+					Str("hook", x.HookName()).
+					Uint64("uid", acct.UID).
+					Msgf("player auth rejected by hook")
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runRegisterHooks calls the enabled RegistrationHooks, stopping at the first
+// error.
+func (h *Handler) runRegisterHooks(r *http.Request, srv *Server) error {
+	for _, x := range h.Hooks {
+		if x, ok := x.(RegistrationHook); ok {
+			if err := x.OnRegister(r, srv); err != nil {
+				hlog.FromRequest(r).Info().
+					Err(err).
+					Str("hook", x.HookName()).
+					Str("server_name", srv.Name).
+					Msgf("server registration rejected by hook")
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hasPdataWriteHooks checks if any PdataWriteHooks are enabled.
+func (h *Handler) hasPdataWriteHooks() bool {
+	for _, x := range h.Hooks {
+		if _, ok := x.(PdataWriteHook); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// runPdataWriteHooks calls the enabled PdataWriteHooks on the pdata write from
+// prev to next, returning the (possibly modified) pdata to write. If there
+// aren't any hooks, next is returned as-is. If the write is rejected or an
+// error occurs, a response is written and false is returned.
+func (h *Handler) runPdataWriteHooks(w http.ResponseWriter, r *http.Request, uid uint64, prev, next []byte) ([]byte, bool) {
+	if !h.hasPdataWriteHooks() {
+		return next, true
+	}
+
+	var opd, npd pdata.Pdata
+	if err := opd.UnmarshalBinary(prev); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to parse pdata from storage for hooks")
+		h.m().accounts_writepersistence_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObjf("failed to parse stored pdata"))
+		return nil, false
+	}
+	if err := npd.UnmarshalBinary(next); err != nil {
+		h.m().accounts_writepersistence_requests_total.reject_invalid_pdata.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid pdata"))
+		return nil, false
+	}
+	for _, x := range h.Hooks {
+		if x, ok := x.(PdataWriteHook); ok {
+			if err := x.OnPdataWrite(r, uid, &opd, &npd); err != nil {
+				hlog.FromRequest(r).Info().
+					Err(err).
+					Str("hook", x.HookName()).
+					Uint64("uid", uid).
+					Msgf("pdata write rejected by hook")
+				h.m().accounts_writepersistence_requests_total.reject_hook.Inc()
+				status, obj := hookError(err)
+				respFail(w, r, status, obj)
+				return nil, false
+			}
+		}
+	}
+	buf, err := npd.MarshalBinary()
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to encode pdata modified by hooks")
+		h.m().accounts_writepersistence_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return nil, false
+	}
+	return buf, true
+}
+
+// listQueryHooks gets the enabled ListQueryHooks.
+func (h *Handler) listQueryHooks() []ListQueryHook {
+	var hs []ListQueryHook
+	for _, x := range h.Hooks {
+		if x, ok := x.(ListQueryHook); ok {
+			hs = append(hs, x)
+		}
+	}
+	return hs
+}
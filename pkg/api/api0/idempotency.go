@@ -0,0 +1,243 @@
+package api0
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+const (
+	idempotencyMaxKeyLength = 255
+	idempotencyMaxBody      = 4 << 20
+	idempotencyMaxResponse  = 64 << 10
+)
+
+// idempotencyCache stores the responses to requests with an Idempotency-Key
+// header so retries can be replayed instead of being applied again. It is
+// bounded by discarding the oldest keys when full. It is safe for concurrent
+// use.
+type idempotencyCache struct {
+	mu sync.Mutex
+	m  map[[sha256.Size]byte]*idempotencyEntry
+	q  []*idempotencyEntry // ordered by expiry since the ttl is fixed
+}
+
+type idempotencyEntry struct {
+	k    [sha256.Size]byte
+	fp   [sha256.Size]byte // of the request
+	exp  time.Time
+	done chan struct{} // closed once the response is set
+	resp *idempotencyResponse
+}
+
+type idempotencyResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// begin gets the entry for k, creating it if it doesn't exist or has expired.
+// If created, the caller must call finish once the request has been handled.
+// If there are more than max keys, the oldest ones are forgotten.
+func (c *idempotencyCache) begin(k, fp [sha256.Size]byte, t time.Time, ttl time.Duration, max int) (e *idempotencyEntry, created bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.m == nil {
+		c.m = make(map[[sha256.Size]byte]*idempotencyEntry)
+	}
+
+	var n int
+	for n < len(c.q) && (!t.Before(c.q[n].exp) || len(c.q)-n >= max) {
+		if c.m[c.q[n].k] == c.q[n] {
+			delete(c.m, c.q[n].k)
+		}
+		n++
+	}
+	if n != 0 {
+		c.q = append(c.q[:0], c.q[n:]...)
+	}
+
+	if e, ok := c.m[k]; ok && t.Before(e.exp) {
+		return e, false
+	}
+
+	e = &idempotencyEntry{
+		k:    k,
+		fp:   fp,
+		exp:  t.Add(ttl),
+		done: make(chan struct{}),
+	}
+	c.m[k] = e
+	c.q = append(c.q, e)
+	return e, true
+}
+
+// finish sets the response for e. If resp is nil, the response can't be
+// replayed, so e is forgotten and retries will be handled normally.
+func (c *idempotencyCache) finish(e *idempotencyEntry, resp *idempotencyResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e.resp = resp
+	if resp == nil && c.m[e.k] == e {
+		delete(c.m, e.k)
+	}
+	close(e.done)
+}
+
+// idempotencyRecorder passes a response through while capturing it for
+// idempotencyCache.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *idempotencyRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.header = w.ResponseWriter.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.overflow {
+		if w.body.Len()+len(b) > idempotencyMaxResponse {
+			w.overflow = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// response returns the captured response if it can be replayed. Server errors
+// and rate limits aren't replayed since retrying them may succeed.
+func (w *idempotencyRecorder) response() *idempotencyResponse {
+	if w.status == 0 || w.overflow || w.status >= 500 || w.status == http.StatusTooManyRequests {
+		return nil
+	}
+	return &idempotencyResponse{
+		status: w.status,
+		header: w.header,
+		body:   append([]byte(nil), w.body.Bytes()...),
+	}
+}
+
+// serveIdempotent calls next, but if the request has an Idempotency-Key
+// header and IdempotencyWindow is set, the response is remembered and replayed
+// for retries with the same key from the same client. Keys reused for a
+// different request are rejected.
+func (h *Handler) serveIdempotent(w http.ResponseWriter, r *http.Request, next func(http.ResponseWriter, *http.Request)) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" || h.IdempotencyWindow <= 0 {
+		next(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		next(w, r) // already idempotent
+		return
+	}
+	endpoint := r.URL.Path
+
+	if len(key) > idempotencyMaxKeyLength {
+		h.m().idempotency_requests_total.reject_bad_request(endpoint).Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("idempotency key must be at most %d characters", idempotencyMaxKeyLength))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, idempotencyMaxBody+1))
+	if err != nil {
+		h.m().idempotency_requests_total.reject_bad_request(endpoint).Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("failed to read request body"))
+		return
+	}
+	if len(body) > idempotencyMaxBody {
+		h.m().idempotency_requests_total.bypass(endpoint).Inc()
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		next(w, r)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	// keys are scoped to the client so they can't be used to get other
+	// clients' responses
+	var ip netip.Addr
+	if raddr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ip = raddr.Addr()
+	}
+	kh := sha256.New()
+	kh.Write([]byte(endpoint + "\x00" + ip.String() + "\x00" + r.Header.Get("Authorization") + "\x00" + key))
+
+	fh := sha256.New()
+	fh.Write([]byte(r.Method + "\x00" + r.URL.RawQuery + "\x00" + r.Header.Get("Content-Type") + "\x00" + strconv.Itoa(len(body)) + "\x00"))
+	fh.Write(body)
+
+	var k, fp [sha256.Size]byte
+	kh.Sum(k[:0])
+	fh.Sum(fp[:0])
+
+	max := h.IdempotencyMaxKeys
+	if max <= 0 {
+		max = 10000
+	}
+
+	for {
+		e, created := h.idempotency.begin(k, fp, time.Now(), h.IdempotencyWindow, max)
+		if created {
+			rec := &idempotencyRecorder{ResponseWriter: w}
+			defer func() {
+				h.idempotency.finish(e, rec.response())
+			}()
+			h.m().idempotency_requests_total.success_new(endpoint).Inc()
+			next(rec, r)
+			return
+		}
+		if e.fp != fp {
+			h.m().idempotency_requests_total.reject_reused(endpoint).Inc()
+			respFail(w, r, http.StatusUnprocessableEntity, ErrorCode_IDEMPOTENCY_KEY_REUSED.MessageObj())
+			return
+		}
+		select {
+		case <-e.done:
+		case <-r.Context().Done():
+			return
+		}
+		if e.resp == nil {
+			continue // not replayable, so handle it again
+		}
+		h.m().idempotency_requests_total.success_replay(endpoint).Inc()
+		hlog.FromRequest(r).Debug().Msg("replaying response for idempotency key")
+
+		for hk, hv := range e.resp.header {
+			w.Header()[hk] = hv
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(e.resp.status)
+		w.Write(e.resp.body)
+		return
+	}
+}
@@ -0,0 +1,201 @@
+package api0
+
+import (
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	var calls atomic.Int32
+	h := &Handler{IdempotencyWindow: time.Minute}
+
+	var (
+		block   chan struct{} // if non-nil, the handler waits on it
+		started chan struct{} // if non-nil, the handler signals it
+		status  = http.StatusOK
+	)
+	next := func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if started != nil {
+			started <- struct{}{}
+		}
+		if block != nil {
+			<-block
+		}
+		buf, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Call", strconv.Itoa(int(n)))
+		w.WriteHeader(status)
+		w.Write(append([]byte("call "+strconv.Itoa(int(n))+": "), buf...))
+	}
+	do := func(method, remote, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/test", strings.NewReader(body))
+		r.RemoteAddr = remote
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.serveIdempotent(w, r, next)
+		return w
+	}
+
+	t.Run("Replay", func(t *testing.T) {
+		calls.Store(0)
+		w1 := do(http.MethodPost, "192.0.2.1:1234", "replay", "a")
+		w2 := do(http.MethodPost, "192.0.2.1:4321", "replay", "a")
+		if n := calls.Load(); n != 1 {
+			t.Errorf("expected handler to be called once, got %d", n)
+		}
+		if w2.Code != w1.Code || w2.Body.String() != w1.Body.String() || w2.Header().Get("X-Call") != w1.Header().Get("X-Call") {
+			t.Errorf("replayed response doesn't match: %d %q, expected %d %q", w2.Code, w2.Body.String(), w1.Code, w1.Body.String())
+		}
+		if w1.Header().Get("Idempotent-Replayed") != "" || w2.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("expected only the replayed response to have Idempotent-Replayed set")
+		}
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		calls.Store(0)
+		do(http.MethodPost, "192.0.2.1:1234", "conflict", "a")
+		if w := do(http.MethodPost, "192.0.2.1:1234", "conflict", "b"); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), string(ErrorCode_IDEMPOTENCY_KEY_REUSED)) {
+			t.Errorf("different body: expected status 422 with IDEMPOTENCY_KEY_REUSED, got %d %q", w.Code, w.Body.String())
+		}
+		if w := do(http.MethodPut, "192.0.2.1:1234", "conflict", "a"); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("different method: expected status 422, got %d", w.Code)
+		}
+		if n := calls.Load(); n != 1 {
+			t.Errorf("expected handler to be called once, got %d", n)
+		}
+	})
+
+	t.Run("Scoped", func(t *testing.T) {
+		calls.Store(0)
+		do(http.MethodPost, "192.0.2.1:1234", "scoped", "a")
+		if w := do(http.MethodPost, "198.51.100.1:1234", "scoped", "b"); w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("different client: expected new response, got %d %q", w.Code, w.Body.String())
+		}
+		if n := calls.Load(); n != 2 {
+			t.Errorf("expected handler to be called twice, got %d", n)
+		}
+	})
+
+	t.Run("Bypass", func(t *testing.T) {
+		calls.Store(0)
+		do(http.MethodPost, "192.0.2.1:1234", "", "a")
+		do(http.MethodPost, "192.0.2.1:1234", "", "a")
+		do(http.MethodGet, "192.0.2.1:1234", "bypass", "")
+		do(http.MethodGet, "192.0.2.1:1234", "bypass", "")
+		if n := calls.Load(); n != 4 {
+			t.Errorf("expected handler to be called for each request, got %d", n)
+		}
+		if w := do(http.MethodPost, "192.0.2.1:1234", strings.Repeat("x", idempotencyMaxKeyLength+1), "a"); w.Code != http.StatusBadRequest {
+			t.Errorf("long key: expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("ServerError", func(t *testing.T) {
+		calls.Store(0)
+		status = http.StatusInternalServerError
+		do(http.MethodPost, "192.0.2.1:1234", "error", "a")
+		status = http.StatusOK
+		if w := do(http.MethodPost, "192.0.2.1:1234", "error", "a"); w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("expected failed request to be retried, got %d", w.Code)
+		}
+		if n := calls.Load(); n != 2 {
+			t.Errorf("expected handler to be called twice, got %d", n)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		calls.Store(0)
+		block, started = make(chan struct{}), make(chan struct{}, 8)
+		defer func() {
+			block, started = nil, nil
+		}()
+
+		var wg sync.WaitGroup
+		ws := make([]*httptest.ResponseRecorder, 8)
+		for i := range ws {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				ws[i] = do(http.MethodPost, "192.0.2.1:1234", "concurrent", "a")
+			}(i)
+		}
+		<-started
+		time.Sleep(time.Millisecond * 50) // let the others wait on the first
+		close(block)
+		wg.Wait()
+
+		if n := calls.Load(); n != 1 {
+			t.Errorf("expected handler to be called once, got %d", n)
+		}
+		var replayed int
+		for _, w := range ws {
+			if w.Code != http.StatusOK || w.Body.String() != ws[0].Body.String() {
+				t.Errorf("incorrect response: %d %q", w.Code, w.Body.String())
+			}
+			if w.Header().Get("Idempotent-Replayed") == "true" {
+				replayed++
+			}
+		}
+		if replayed != len(ws)-1 {
+			t.Errorf("expected %d replayed responses, got %d", len(ws)-1, replayed)
+		}
+	})
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	key := func(s string) [sha256.Size]byte {
+		return sha256.Sum256([]byte(s))
+	}
+
+	t.Run("Expiry", func(t *testing.T) {
+		var c idempotencyCache
+		e, created := c.begin(key("a"), key("a"), t0, time.Minute, 10)
+		if !created {
+			t.Fatalf("expected new entry")
+		}
+		c.finish(e, &idempotencyResponse{status: http.StatusOK})
+
+		if x, created := c.begin(key("a"), key("a"), t0.Add(time.Minute-time.Nanosecond), time.Minute, 10); created || x != e {
+			t.Errorf("expected existing entry before expiry")
+		}
+		if x, created := c.begin(key("a"), key("a"), t0.Add(time.Minute), time.Minute, 10); !created || x == e {
+			t.Errorf("expected new entry after expiry")
+		} else {
+			c.finish(x, nil)
+		}
+		if n := len(c.m); n != 0 {
+			t.Errorf("expected expired and unreplayable entries to be removed, got %d", n)
+		}
+	})
+
+	t.Run("Bounded", func(t *testing.T) {
+		var c idempotencyCache
+		for i := 0; i < 25; i++ {
+			e, created := c.begin(key(strconv.Itoa(i)), key("x"), t0.Add(time.Duration(i)), time.Minute, 10)
+			if !created {
+				t.Fatalf("expected new entry for %d", i)
+			}
+			c.finish(e, &idempotencyResponse{status: http.StatusOK})
+			if n := len(c.m); n > 10 {
+				t.Fatalf("expected at most 10 entries, got %d", n)
+			}
+		}
+		if _, created := c.begin(key("0"), key("x"), t0.Add(time.Second), time.Minute, 10); !created {
+			t.Errorf("expected oldest entry to be forgotten")
+		}
+		if _, created := c.begin(key("24"), key("x"), t0.Add(time.Second), time.Minute, 10); created {
+			t.Errorf("expected newest entry to be remembered")
+		}
+	})
+}
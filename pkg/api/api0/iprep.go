@@ -0,0 +1,115 @@
+package api0
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/pg9182/ip2x"
+	"github.com/rs/zerolog/hlog"
+)
+
+// IPReputation contains flags for IPs commonly used to hide a player's real
+// network (e.g., to evade bans with cheap VPS IPs).
+type IPReputation uint8
+
+const (
+	// IPReputationHosting is set for datacenter and hosting provider IPs.
+	IPReputationHosting IPReputation = 1 << iota
+
+	// IPReputationVPN is set for commercial VPN exit IPs.
+	IPReputationVPN
+
+	// IPReputationProxy is set for public, web, and residential proxies, and
+	// Tor exit nodes.
+	IPReputationProxy
+)
+
+var ipReputationNames = []struct {
+	flag IPReputation
+	name string
+}{
+	{IPReputationHosting, "hosting"},
+	{IPReputationVPN, "vpn"},
+	{IPReputationProxy, "proxy"},
+}
+
+// Strings returns the names of the flags set in f.
+func (f IPReputation) Strings() []string {
+	var ss []string
+	for _, x := range ipReputationNames {
+		if f&x.flag != 0 {
+			ss = append(ss, x.name)
+		}
+	}
+	return ss
+}
+
+// String returns a comma-separated list of the flags set in f.
+func (f IPReputation) String() string {
+	return strings.Join(f.Strings(), ",")
+}
+
+// ParseIPReputation parses a comma-separated list of flags.
+func ParseIPReputation(s string) (IPReputation, error) {
+	var f IPReputation
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		var ok bool
+		for _, x := range ipReputationNames {
+			if strings.EqualFold(v, x.name) {
+				f, ok = f|x.flag, true
+				break
+			}
+		}
+		if !ok {
+			return 0, fmt.Errorf("unknown ip reputation flag %q", v)
+		}
+	}
+	return f, nil
+}
+
+// IP2xReputation gets the reputation flags from the proxy type (IP2Proxy) or
+// usage type (IP2Proxy PX6+ or IP2Location DB24+) in r.
+func IP2xReputation(r ip2x.Record) IPReputation {
+	var f IPReputation
+	if v, ok := r.GetString(ip2x.ProxyType); ok {
+		switch v {
+		case "VPN":
+			f |= IPReputationVPN
+		case "TOR", "PUB", "WEB", "RES", "CPN", "EPN":
+			f |= IPReputationProxy
+		case "DCH", "SES":
+			f |= IPReputationHosting
+		}
+	}
+	if v, ok := r.GetString(ip2x.UsageType); ok {
+		for _, u := range strings.Split(v, "/") {
+			switch u {
+			case "DCH", "CDN", "SES":
+				f |= IPReputationHosting
+			}
+		}
+	}
+	return f
+}
+
+// lookupIPReputation gets the reputation flags for ip if LookupIPReputation is
+// provided. Errors are logged.
+func (h *Handler) lookupIPReputation(r *http.Request, ip netip.Addr) IPReputation {
+	if h.LookupIPReputation == nil {
+		return 0
+	}
+	f, err := h.LookupIPReputation(ip)
+	if err != nil {
+		hlog.FromRequest(r).Warn().
+			Err(err).
+			Str("ip", ip.String()).
+			Msgf("failed to look up ip reputation")
+		return 0
+	}
+	return f
+}
@@ -0,0 +1,147 @@
+package api0
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+)
+
+// testAccountLinkStorage is a minimal in-memory AccountLinkStorage.
+type testAccountLinkStorage map[uint64][]AccountLink
+
+func (s testAccountLinkStorage) GetAccountLinks(uid uint64) ([]AccountLink, error) {
+	return s[uid], nil
+}
+
+func (s testAccountLinkStorage) GetUIDByAccountLink(provider AccountLinkProvider, externalID string) (uint64, bool, error) {
+	for uid, ls := range s {
+		for _, l := range ls {
+			if l.Provider == provider && l.ExternalID == externalID {
+				return uid, true, nil
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+func (s testAccountLinkStorage) SaveAccountLink(l *AccountLink) error {
+	s[l.UID] = append(s[l.UID], *l)
+	return nil
+}
+
+func (s testAccountLinkStorage) DeleteAccountLink(uid uint64, provider AccountLinkProvider) error {
+	ls := s[uid][:0]
+	for _, l := range s[uid] {
+		if l.Provider != provider {
+			ls = append(ls, l)
+		}
+	}
+	s[uid] = ls
+	return nil
+}
+
+func TestIPReputation(t *testing.T) {
+	for _, tc := range []struct {
+		s   string
+		f   IPReputation
+		str string
+	}{
+		{"", 0, ""},
+		{" , ", 0, ""},
+		{"hosting", IPReputationHosting, "hosting"},
+		{"VPN", IPReputationVPN, "vpn"},
+		{"proxy, vpn", IPReputationVPN | IPReputationProxy, "vpn,proxy"},
+		{"hosting,vpn,proxy,vpn", IPReputationHosting | IPReputationVPN | IPReputationProxy, "hosting,vpn,proxy"},
+	} {
+		f, err := ParseIPReputation(tc.s)
+		if err != nil {
+			t.Errorf("parse %q: unexpected error: %v", tc.s, err)
+			continue
+		}
+		if f != tc.f {
+			t.Errorf("parse %q: expected %d, got %d", tc.s, tc.f, f)
+		}
+		if s := f.String(); s != tc.str {
+			t.Errorf("parse %q: expected string %q, got %q", tc.s, tc.str, s)
+		}
+		if r, err := ParseIPReputation(f.String()); err != nil || r != f {
+			t.Errorf("parse %q: expected string to round-trip, got %d (err: %v)", tc.s, r, err)
+		}
+	}
+	for _, s := range []string{"tor", "vpn,other", "vpn;proxy"} {
+		if _, err := ParseIPReputation(s); err == nil {
+			t.Errorf("parse %q: expected error", s)
+		}
+	}
+	if ss := IPReputation(0).Strings(); ss != nil {
+		t.Errorf("expected no names for zero flags, got %q", ss)
+	}
+}
+
+func TestIPReputationVerify(t *testing.T) {
+	flags := map[netip.Addr]IPReputation{
+		netip.MustParseAddr("192.0.2.2"): IPReputationHosting,
+		netip.MustParseAddr("192.0.2.3"): IPReputationVPN,
+		netip.MustParseAddr("192.0.2.4"): IPReputationProxy | IPReputationVPN,
+	}
+	as, ls := new(testAccountStorage), testAccountLinkStorage{
+		1001: {{UID: 1001, Provider: AccountLinkProviderDiscord, ExternalID: "1"}},
+	}
+	h := &Handler{
+		AccountStorage:               as,
+		AccountLinkStorage:           ls,
+		PdataStorage:                 new(testPdataStorage),
+		InsecureDevNoCheckPlayerAuth: true,
+		IPReputationVerify:           IPReputationVPN,
+		LookupIPReputation: func(a netip.Addr) (IPReputation, error) {
+			if a == netip.MustParseAddr("192.0.2.5") {
+				return IPReputationVPN, errors.New("lookup failed")
+			}
+			return flags[a], nil
+		},
+	}
+	for _, tc := range []struct {
+		name   string
+		uid    uint64
+		ip     string
+		status int
+		flags  IPReputation
+	}{
+		{"Clean", 1000, "192.0.2.1", http.StatusOK, 0},
+		{"NotVerified", 1000, "192.0.2.2", http.StatusOK, IPReputationHosting},
+		{"Verify", 1000, "192.0.2.3", http.StatusForbidden, 0},
+		{"VerifyMultiple", 1000, "192.0.2.4", http.StatusForbidden, 0},
+		{"Linked", 1001, "192.0.2.3", http.StatusOK, IPReputationVPN},
+		{"LinkedMultiple", 1001, "192.0.2.4", http.StatusOK, IPReputationProxy | IPReputationVPN},
+		{"LookupError", 1000, "192.0.2.5", http.StatusOK, 0},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/client/origin_auth?id="+strconv.FormatUint(tc.uid, 10), nil)
+		r.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+		r.RemoteAddr = tc.ip + ":1234"
+		w := httptest.NewRecorder()
+		h.handleClientOriginAuth(w, r)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.name, tc.status, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code != http.StatusOK {
+			var obj struct {
+				Error ErrorObj `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &obj); err != nil {
+				t.Errorf("%s: invalid response: %v", tc.name, err)
+			} else if obj.Error.Code != ErrorCode_VERIFICATION_REQUIRED {
+				t.Errorf("%s: expected error %s, got %s", tc.name, ErrorCode_VERIFICATION_REQUIRED, obj.Error.Code)
+			}
+			continue
+		}
+		if a, _ := as.GetAccount(tc.uid); a == nil || a.AuthIP != netip.MustParseAddr(tc.ip) || a.AuthIPFlags != tc.flags {
+			t.Errorf("%s: expected session from %s with flags %q, got %+v", tc.name, tc.ip, tc.flags, a)
+		}
+	}
+}
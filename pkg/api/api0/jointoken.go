@@ -0,0 +1,257 @@
+package api0
+
+import (
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// joinTokenMax is the maximum number of outstanding join tokens.
+const joinTokenMax = 100000
+
+// JoinTokens configures the two-step join flow, where clients get a
+// single-use connect token for a server from /client/join_token (which checks
+// the password and allowlist), then redeem it with /client/auth_with_server
+// instead of sending the password again. Atlas then notifies the game server
+// as usual (servers with a signing key can verify the signed preauth locally).
+type JoinTokens struct {
+	// Required rejects auth_with_server requests without a join token, so the
+	// server password is only accepted by /client/join_token.
+	Required bool
+
+	// Expiry is how long join tokens are valid for. If zero, it defaults to
+	// 30 seconds.
+	Expiry time.Duration
+}
+
+func (j JoinTokens) expiry() time.Duration {
+	if j.Expiry > 0 {
+		return j.Expiry
+	}
+	return time.Second * 30
+}
+
+// joinTokenStore contains outstanding join tokens. Redeemed tokens are kept
+// until they expire so reuse can be distinguished from invalid tokens. It is
+// safe for concurrent use.
+type joinTokenStore struct {
+	mu sync.Mutex
+	m  map[string]*joinToken
+}
+
+type joinToken struct {
+	uid    uint64
+	server string
+	ip     netip.Addr
+	exp    time.Time
+	used   bool
+}
+
+// joinTokenResult is the result of redeeming a join token.
+type joinTokenResult int
+
+const (
+	joinTokenOK joinTokenResult = iota
+	joinTokenNotFound
+	joinTokenExpired
+	joinTokenUsed
+	joinTokenMismatch
+)
+
+// Issue stores a new join token.
+func (s *joinTokenStore) Issue(token string, t joinToken, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.m == nil {
+		s.m = make(map[string]*joinToken)
+	}
+	if len(s.m) >= joinTokenMax {
+		for k, x := range s.m {
+			if !now.Before(x.exp) {
+				delete(s.m, k)
+			}
+		}
+		for k := range s.m {
+			if len(s.m) < joinTokenMax {
+				break
+			}
+			delete(s.m, k)
+		}
+	}
+	s.m[token] = &t
+}
+
+// Redeem marks token as used if it is valid for uid joining server from ip.
+func (s *joinTokenStore) Redeem(token string, uid uint64, server string, ip netip.Addr, now time.Time) joinTokenResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.m[token]
+	switch {
+	case !ok:
+		return joinTokenNotFound
+	case !now.Before(t.exp):
+		delete(s.m, token)
+		return joinTokenExpired
+	case t.used:
+		return joinTokenUsed
+	case t.uid != uid || t.server != server || t.ip != ip:
+		return joinTokenMismatch
+	}
+	t.used = true
+	return joinTokenOK
+}
+
+// handleClientJoinToken issues a single-use join token for a server.
+//
+// Query parameters:
+//   - id: the player uid
+//   - playerToken: the player's master server token
+//   - server: the server id
+//   - password: the server password, if any
+//
+// The response contains the "joinToken" to pass to /client/auth_with_server
+// (from the same IP) and "expiresIn" (seconds).
+func (h *Handler) handleClientJoinToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().client_jointoken_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.CheckLauncherVersion(r, true) {
+		h.m().client_jointoken_requests_total.reject_versiongate.Inc()
+		h.respUpdateRequired(w, r, true)
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().client_jointoken_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	if !h.checkAnomaly(w, r, raddr.Addr()) {
+		h.m().client_jointoken_requests_total.reject_anomaly.Inc()
+		return
+	}
+
+	uid, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		h.m().client_jointoken_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	// party members can follow their leader without the password
+	srv := h.ServerList.GetServerByID(r.URL.Query().Get("server"))
+	if srv == nil || (srv.Password != r.URL.Query().Get("password") && !h.parties.following(uid, srv.ID)) {
+		if srv != nil {
+			h.anomalyFailure(r, raddr.Addr(), "incorrect server password")
+		}
+		h.m().client_jointoken_requests_total.reject_password.Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_UNAUTHORIZED_PWD.MessageObj())
+		return
+	}
+
+	acct, err := h.accountStorage(r).GetAccount(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read account from storage")
+		h.m().client_jointoken_requests_total.fail_storage_error_account.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if acct == nil {
+		h.m().client_jointoken_requests_total.reject_player_not_found.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+
+	now := time.Now()
+	if !h.InsecureDevNoCheckPlayerAuth {
+		if !acct.CheckAuthToken(r.URL.Query().Get("playerToken"), now) {
+			h.m().client_jointoken_requests_total.reject_masterserver_token.Inc()
+			h.anomalyFailure(r, raddr.Addr(), "invalid masterserver token")
+			respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
+			return
+		}
+	}
+
+	if ac := (&serverAllowChecker{h: h, r: r, uid: acct.UID}); !ac.Allowed(srv) {
+		h.m().client_jointoken_requests_total.reject_allowlist.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_CONNECTION_REJECTED.MessageObjf("not on the server allowlist"))
+		return
+	}
+
+	token, err := cryptoRandHex(32)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to generate random token")
+		h.m().client_jointoken_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	exp := h.JoinTokens.expiry()
+	h.joinTokens.Issue(token, joinToken{
+		uid:    acct.UID,
+		server: srv.ID,
+		ip:     raddr.Addr(),
+		exp:    now.Add(exp),
+	}, now)
+
+	h.m().client_jointoken_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":   true,
+		"joinToken": token,
+		"expiresIn": int(exp / time.Second),
+	})
+}
+
+// redeemJoinToken redeems a join token for auth_with_server, responding with
+// an error and returning false if it isn't valid.
+func (h *Handler) redeemJoinToken(w http.ResponseWriter, r *http.Request, token string, uid uint64, srv *Server, ip netip.Addr) bool {
+	res := joinTokenNotFound
+	if srv != nil {
+		res = h.joinTokens.Redeem(token, uid, srv.ID, ip, time.Now())
+	}
+	switch res {
+	case joinTokenOK:
+		h.m().client_jointoken_redeem_total.success.Inc()
+		return true
+	case joinTokenExpired:
+		h.m().client_jointoken_redeem_total.reject_expired.Inc()
+	case joinTokenUsed:
+		h.m().client_jointoken_redeem_total.reject_used.Inc()
+	case joinTokenMismatch:
+		h.m().client_jointoken_redeem_total.reject_mismatch.Inc()
+	default:
+		h.m().client_jointoken_redeem_total.reject_not_found.Inc()
+	}
+	h.anomalyFailure(r, ip, "invalid join token")
+	respFail(w, r, http.StatusUnauthorized, ErrorCode_UNAUTHORIZED.MessageObjf("invalid or expired join token"))
+	return false
+}
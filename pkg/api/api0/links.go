@@ -0,0 +1,564 @@
+package api0
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/discord"
+	"github.com/r2northstar/atlas/pkg/steam"
+	"github.com/rs/zerolog/hlog"
+)
+
+// linkStateTTL is how long a user has to complete an OAuth2 account link.
+const linkStateTTL = time.Minute * 10
+
+// checkPlayerToken checks whether token is a valid masterserver token for
+// acct.
+func (h *Handler) checkPlayerToken(acct *Account, token string) bool {
+	if h.InsecureDevNoCheckPlayerAuth {
+		return true
+	}
+	return acct.CheckAuthToken(token, time.Now())
+}
+
+// linkState creates a signed OAuth2 state for linking an account to uid.
+func (h *Handler) linkState(uid uint64) string {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uid)
+	return h.signToken("link", b, linkStateTTL)
+}
+
+// verifyLinkState verifies a signed OAuth2 state, returning the uid.
+func (h *Handler) verifyLinkState(s string) (uint64, bool) {
+	b, ok := h.verifyToken("link", s)
+	if !ok || len(b) != 8 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(b), true
+}
+
+func (h *Handler) handleAccountsLinkDiscord(w http.ResponseWriter, r *http.Request) {
+	const provider = string(AccountLinkProviderDiscord)
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().accounts_link_requests_total.http_method_not_allowed(provider).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// - do not ever cache
+	// - do not share between users
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate") // equivalent to no-store -- but the rest is a fallback
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.AccountLinkStorage == nil || h.DiscordOAuth2 == nil {
+		h.m().accounts_link_requests_total.reject_disabled(provider).Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_UNSUPPORTED_PROVIDER.MessageObj())
+		return
+	}
+
+	uidQ := r.URL.Query().Get("id")
+	if uidQ == "" {
+		h.m().accounts_link_requests_total.reject_bad_request(provider).Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	uid, err := strconv.ParseUint(uidQ, 10, 64)
+	if err != nil {
+		h.m().accounts_link_requests_total.reject_bad_request(provider).Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+
+	playerToken := r.URL.Query().Get("playerToken")
+
+	acct, err := h.AccountStorage.GetAccount(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read account from storage")
+		h.m().accounts_link_requests_total.fail_storage_error_account(provider).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if acct == nil {
+		h.m().accounts_link_requests_total.reject_player_not_found(provider).Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+
+	if !h.checkPlayerToken(acct, playerToken) {
+		h.m().accounts_link_requests_total.reject_masterserver_token(provider).Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
+		return
+	}
+
+	h.m().accounts_link_requests_total.success_redirect(provider).Inc()
+	http.Redirect(w, r, h.DiscordOAuth2.AuthCodeURL(h.linkState(acct.UID)), http.StatusFound)
+}
+
+func (h *Handler) handleAccountsLinkDiscordCallback(w http.ResponseWriter, r *http.Request) {
+	const provider = string(AccountLinkProviderDiscord)
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodGet {
+		h.m().accounts_link_requests_total.http_method_not_allowed(provider).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// - do not ever cache
+	// - do not share between users
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate") // equivalent to no-store -- but the rest is a fallback
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.AccountLinkStorage == nil || h.DiscordOAuth2 == nil {
+		h.m().accounts_link_requests_total.reject_disabled(provider).Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_UNSUPPORTED_PROVIDER.MessageObj())
+		return
+	}
+
+	uid, ok := h.verifyLinkState(r.URL.Query().Get("state"))
+	if !ok {
+		h.m().accounts_link_requests_total.reject_invalid_state(provider).Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid or expired state"))
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		// the user probably denied the authorization
+		h.m().accounts_link_requests_total.reject_bad_request(provider).Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("authorization failed: %s", r.URL.Query().Get("error")))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
+	defer cancel()
+
+	tok, err := h.DiscordOAuth2.Exchange(ctx, code)
+	if err != nil {
+		if errors.Is(err, discord.ErrInvalidGrant) {
+			h.m().accounts_link_requests_total.reject_invalid_link(provider).Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_INVALID_LINK.MessageObj())
+			return
+		}
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to exchange discord authorization code")
+		h.m().accounts_link_requests_total.fail_provider_error(provider).Inc()
+		respFail(w, r, http.StatusBadGateway, ErrorCode_LINK_PROVIDER_ERROR.MessageObj())
+		return
+	}
+
+	user, err := discord.GetCurrentUser(ctx, tok)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to get discord user")
+		h.m().accounts_link_requests_total.fail_provider_error(provider).Inc()
+		respFail(w, r, http.StatusBadGateway, ErrorCode_LINK_PROVIDER_ERROR.MessageObj())
+		return
+	}
+
+	name := user.GlobalName
+	if name == "" {
+		name = user.Username
+	}
+
+	if h.isErased(uid) {
+		h.m().accounts_link_requests_total.reject_erased(provider).Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_ACCOUNT_ERASED.MessageObj())
+		return
+	}
+
+	if err := h.AccountLinkStorage.SaveAccountLink(&AccountLink{
+		UID:          uid,
+		Provider:     AccountLinkProviderDiscord,
+		ExternalID:   user.ID,
+		ExternalName: name,
+		VerifiedAt:   time.Now(),
+	}); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to save account link to storage")
+		h.m().accounts_link_requests_total.fail_storage_error_link(provider).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().accounts_link_requests_total.success(provider).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":  true,
+		"id":       strconv.FormatUint(uid, 10),
+		"provider": provider,
+		"name":     name,
+	})
+}
+
+func (h *Handler) handleAccountsLinkSteam(w http.ResponseWriter, r *http.Request) {
+	const provider = string(AccountLinkProviderSteam)
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().accounts_link_requests_total.http_method_not_allowed(provider).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// - do not ever cache
+	// - do not share between users
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate") // equivalent to no-store -- but the rest is a fallback
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.AccountLinkStorage == nil || h.SteamWebAPIKey == "" {
+		h.m().accounts_link_requests_total.reject_disabled(provider).Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_UNSUPPORTED_PROVIDER.MessageObj())
+		return
+	}
+
+	uidQ := r.URL.Query().Get("id")
+	if uidQ == "" {
+		h.m().accounts_link_requests_total.reject_bad_request(provider).Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	uid, err := strconv.ParseUint(uidQ, 10, 64)
+	if err != nil {
+		h.m().accounts_link_requests_total.reject_bad_request(provider).Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+
+	ticket := r.URL.Query().Get("ticket")
+	if ticket == "" {
+		h.m().accounts_link_requests_total.reject_bad_request(provider).Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("ticket param is required"))
+		return
+	}
+
+	playerToken := r.URL.Query().Get("playerToken")
+
+	acct, err := h.AccountStorage.GetAccount(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read account from storage")
+		h.m().accounts_link_requests_total.fail_storage_error_account(provider).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if acct == nil {
+		h.m().accounts_link_requests_total.reject_player_not_found(provider).Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+
+	if !h.checkPlayerToken(acct, playerToken) {
+		h.m().accounts_link_requests_total.reject_masterserver_token(provider).Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
+		return
+	}
+
+	appID := h.SteamAppID
+	if appID == 0 {
+		appID = steam.AppIDTitanfall2
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
+	defer cancel()
+
+	info, err := steam.AuthenticateUserTicket(ctx, h.SteamWebAPIKey, appID, ticket)
+	if err != nil {
+		if errors.Is(err, steam.ErrInvalidTicket) || errors.Is(err, steam.ErrNotOwner) {
+			h.m().accounts_link_requests_total.reject_invalid_link(provider).Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_INVALID_LINK.MessageObjf("%v", err))
+			return
+		}
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to authenticate steam ticket")
+		h.m().accounts_link_requests_total.fail_provider_error(provider).Inc()
+		respFail(w, r, http.StatusBadGateway, ErrorCode_LINK_PROVIDER_ERROR.MessageObj())
+		return
+	}
+
+	if h.isErased(uid) {
+		h.m().accounts_link_requests_total.reject_erased(provider).Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_ACCOUNT_ERASED.MessageObj())
+		return
+	}
+
+	if err := h.AccountLinkStorage.SaveAccountLink(&AccountLink{
+		UID:        uid,
+		Provider:   AccountLinkProviderSteam,
+		ExternalID: strconv.FormatUint(info.SteamID, 10),
+		VerifiedAt: time.Now(),
+	}); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to save account link to storage")
+		h.m().accounts_link_requests_total.fail_storage_error_link(provider).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().accounts_link_requests_total.success(provider).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":  true,
+		"id":       strconv.FormatUint(uid, 10),
+		"provider": provider,
+		"steamid":  strconv.FormatUint(info.SteamID, 10),
+	})
+}
+
+func (h *Handler) handleAccountsUnlink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().accounts_unlink_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// - do not ever cache
+	// - do not share between users
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate") // equivalent to no-store -- but the rest is a fallback
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.AccountLinkStorage == nil {
+		h.m().accounts_unlink_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_UNSUPPORTED_PROVIDER.MessageObj())
+		return
+	}
+
+	uidQ := r.URL.Query().Get("id")
+	if uidQ == "" {
+		h.m().accounts_unlink_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	uid, err := strconv.ParseUint(uidQ, 10, 64)
+	if err != nil {
+		h.m().accounts_unlink_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+
+	var provider AccountLinkProvider
+	switch v := AccountLinkProvider(r.URL.Query().Get("provider")); v {
+	case AccountLinkProviderDiscord, AccountLinkProviderSteam:
+		provider = v
+	case "":
+		h.m().accounts_unlink_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("provider param is required"))
+		return
+	default:
+		h.m().accounts_unlink_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_UNSUPPORTED_PROVIDER.MessageObj())
+		return
+	}
+
+	playerToken := r.URL.Query().Get("playerToken")
+
+	acct, err := h.AccountStorage.GetAccount(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read account from storage")
+		h.m().accounts_unlink_requests_total.fail_storage_error_account.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if acct == nil {
+		h.m().accounts_unlink_requests_total.reject_player_not_found.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+
+	if !h.checkPlayerToken(acct, playerToken) {
+		h.m().accounts_unlink_requests_total.reject_masterserver_token.Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
+		return
+	}
+
+	if err := h.AccountLinkStorage.DeleteAccountLink(uid, provider); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to delete account link from storage")
+		h.m().accounts_unlink_requests_total.fail_storage_error_link.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().accounts_unlink_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
+
+func (h *Handler) handleAccountsGetLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().accounts_getlinks_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// - do not ever cache
+	// - do not share between users
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate") // equivalent to no-store -- but the rest is a fallback
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.AccountLinkStorage == nil {
+		h.m().accounts_getlinks_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_UNSUPPORTED_PROVIDER.MessageObj())
+		return
+	}
+
+	uidQ := r.URL.Query().Get("id")
+	if uidQ == "" {
+		h.m().accounts_getlinks_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	uid, err := strconv.ParseUint(uidQ, 10, 64)
+	if err != nil {
+		h.m().accounts_getlinks_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+
+	acct, err := h.AccountStorage.GetAccount(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read account from storage")
+		h.m().accounts_getlinks_requests_total.fail_storage_error_account.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if acct == nil {
+		h.m().accounts_getlinks_requests_total.reject_player_not_found.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+
+	// links are visible to the player themselves, and to the game server the
+	// player is currently connected to
+	if serverID := r.URL.Query().Get("serverId"); serverID != "" {
+		raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to parse remote ip %q", r.RemoteAddr)
+			h.m().accounts_getlinks_requests_total.fail_other_error.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+
+		srv := h.ServerList.GetServerByID(serverID)
+		if srv == nil {
+			h.m().accounts_getlinks_requests_total.reject_unauthorized.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such game server"))
+			return
+		}
+		if srv.Addr.Addr() != raddr.Addr() {
+			h.m().accounts_getlinks_requests_total.reject_unauthorized.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
+			return
+		}
+		if acct.LastServerID != srv.ID {
+			h.m().accounts_getlinks_requests_total.reject_unauthorized.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
+			return
+		}
+	} else if !h.checkPlayerToken(acct, r.URL.Query().Get("playerToken")) {
+		h.m().accounts_getlinks_requests_total.reject_unauthorized.Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
+		return
+	}
+
+	links, err := h.AccountLinkStorage.GetAccountLinks(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read account links from storage")
+		h.m().accounts_getlinks_requests_total.fail_storage_error_link.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	type linkObj struct {
+		Provider   AccountLinkProvider `json:"provider"`
+		ID         string              `json:"id"`
+		Name       string              `json:"name,omitempty"`
+		VerifiedAt int64               `json:"verifiedAt"`
+	}
+	objs := make([]linkObj, 0, len(links))
+	for _, l := range links {
+		objs = append(objs, linkObj{
+			Provider:   l.Provider,
+			ID:         l.ExternalID,
+			Name:       l.ExternalName,
+			VerifiedAt: l.VerifiedAt.Unix(),
+		})
+	}
+
+	h.m().accounts_getlinks_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"id":      strconv.FormatUint(uid, 10),
+		"links":   objs,
+	})
+}
@@ -0,0 +1,215 @@
+package api0
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/r2northstar/atlas/pkg/l10n"
+	"github.com/rs/zerolog/hlog"
+)
+
+// maxTranslationLength is the maximum length of a translation override.
+const maxTranslationLength = 1024
+
+type localizerKey struct{}
+
+// withLocalizer returns r with a localizer for respFail if the client requested
+// a language (see requestLanguages) or there are translation overrides.
+// Otherwise, r is returned unchanged so most requests (e.g., game server
+// heartbeats) don't have any overhead.
+func (h *Handler) withLocalizer(r *http.Request) *http.Request {
+	if r.Header.Get("Accept-Language") == "" && !strings.Contains(r.URL.RawQuery, "lang=") {
+		if ts, _ := h.translations.Get(h.StateStorage, "translations"); len(ts) == 0 {
+			return r
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), localizerKey{}, h))
+}
+
+// localizeError translates the default message for the error code at the
+// start of obj's message into the languages requested by r, falling back to
+// English (which can also be overridden). Additional details appended by
+// MessageObjf are left as-is. If the message was translated, the
+// Content-Language header is set.
+func localizeError(w http.ResponseWriter, r *http.Request, obj ErrorObj) ErrorObj {
+	h, ok := r.Context().Value(localizerKey{}).(*Handler)
+	if !ok {
+		return obj
+	}
+	def := obj.Code.Message()
+	if !strings.HasPrefix(obj.Message, def) || (len(obj.Message) != len(def) && !strings.HasPrefix(obj.Message[len(def):], ": ")) {
+		return obj
+	}
+	ts, err := h.translations.Get(h.StateStorage, "translations")
+	if err != nil {
+		hlog.FromRequest(r).Warn().
+			Err(err).
+			Msgf("failed to load translations from storage")
+	}
+	langs := append(requestLanguages(r), "en")
+	if msg, lang, ok := l10n.Lookup(string(obj.Code), langs, ts, l10n.Default()); ok {
+		obj.Message = msg + obj.Message[len(def):]
+		w.Header().Set("Content-Language", lang)
+	}
+	return obj
+}
+
+// validateTranslations checks if c is a valid set of translation overrides.
+func validateTranslations(c l10n.Catalog) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	for l, m := range c {
+		for k, v := range m {
+			if len(v) > maxTranslationLength {
+				return fmt.Errorf("language %q: key %q: message too long", l, k)
+			}
+		}
+	}
+	return nil
+}
+
+// handleAdminTranslations manages overrides for the built-in translations of
+// player-facing error messages (see l10n.Default). Overrides are keyed by
+// lowercase language tag, then error code.
+func (h *Handler) handleAdminTranslations(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "translations"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PUT, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		ts, err := h.translations.Get(h.StateStorage, "translations")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load translations from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if ts == nil {
+			ts = l10n.Catalog{}
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success":      true,
+			"translations": ts,
+			"builtin":      l10n.Default(),
+		})
+		return
+	}
+
+	var fn func(ts l10n.Catalog) l10n.Catalog
+	switch r.Method {
+	case http.MethodPut:
+		var ts l10n.Catalog
+		if err := decodeJSON(r, &ts); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func(l10n.Catalog) l10n.Catalog {
+			return ts
+		}
+	case http.MethodPost:
+		var add l10n.Catalog
+		if err := decodeJSON(r, &add); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func(ts l10n.Catalog) l10n.Catalog {
+			for l, m := range add {
+				ts[l] = copyTranslations(ts[l])
+				for k, v := range m {
+					ts[l][k] = v
+				}
+			}
+			return ts
+		}
+	case http.MethodDelete:
+		var q struct {
+			Lang string `param:"lang" validate:"required"`
+			Key  string `param:"key"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func(ts l10n.Catalog) l10n.Catalog {
+			if q.Key == "" {
+				delete(ts, q.Lang)
+			} else if m, ok := ts[q.Lang]; ok {
+				m = copyTranslations(m)
+				if delete(m, q.Key); len(m) == 0 {
+					delete(ts, q.Lang)
+				} else {
+					ts[q.Lang] = m
+				}
+			}
+			return ts
+		}
+	}
+
+	var errInvalid error
+	if err := h.translations.Update(h.StateStorage, "translations", func(ts l10n.Catalog) (l10n.Catalog, error) {
+		nts := make(l10n.Catalog, len(ts))
+		for l, m := range ts {
+			nts[l] = m
+		}
+		nts = fn(nts)
+		if err := validateTranslations(nts); err != nil {
+			errInvalid = err
+			return nil, err
+		}
+		return nts, nil
+	}); err != nil {
+		if errInvalid != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", errInvalid))
+			return
+		}
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save translations to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
+
+// copyTranslations returns a copy of m, which may be nil.
+func copyTranslations(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
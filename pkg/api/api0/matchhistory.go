@@ -0,0 +1,171 @@
+package api0
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// MatchHistoryPruneInterval is the recommended interval for calling
+// PruneMatchHistory.
+const MatchHistoryPruneInterval = time.Hour
+
+// matchJSON is the public representation of a MatchRecord.
+type matchJSON struct {
+	ID         string            `json:"id"`
+	Time       time.Time         `json:"time"`
+	ServerID   string            `json:"server_id"`
+	ServerName string            `json:"server_name"`
+	Map        string            `json:"map,omitempty"`
+	Mode       string            `json:"mode"`
+	Duration   int               `json:"duration,omitempty"`
+	Players    []matchPlayerJSON `json:"players"`
+}
+
+type matchPlayerJSON struct {
+	UID      uint64           `json:"uid"`
+	Username string           `json:"username,omitempty"`
+	Team     int              `json:"team,omitempty"`
+	Rank     int              `json:"rank"`
+	Stats    map[string]int64 `json:"stats,omitempty"`
+}
+
+func newMatchJSON(m MatchRecord) matchJSON {
+	x := matchJSON{
+		ID:         m.ID,
+		Time:       m.Time.UTC(),
+		ServerID:   m.ServerID,
+		ServerName: m.ServerName,
+		Map:        m.Map,
+		Mode:       m.Mode,
+		Duration:   int(m.Duration / time.Second),
+		Players:    make([]matchPlayerJSON, len(m.Players)),
+	}
+	for i, p := range m.Players {
+		x.Players[i] = matchPlayerJSON{
+			UID:      p.UID,
+			Username: p.Username,
+			Team:     p.Team,
+			Rank:     p.Rank,
+			Stats:    p.Stats,
+		}
+	}
+	return x
+}
+
+// matchHistoryRetention gets the effective retention for match history.
+func (h *Handler) matchHistoryRetention() time.Duration {
+	if h.MatchHistoryRetention > 0 {
+		return h.MatchHistoryRetention
+	}
+	return time.Hour * 24 * 90
+}
+
+// PruneMatchHistory deletes matches older than MatchHistoryRetention. It
+// should be called every MatchHistoryPruneInterval. If MatchHistoryStorage is
+// nil, it does nothing.
+func (h *Handler) PruneMatchHistory(t time.Time) error {
+	if h.MatchHistoryStorage == nil {
+		return nil
+	}
+	if err := h.MatchHistoryStorage.DeleteMatchesBefore(t.Add(-h.matchHistoryRetention())); err != nil {
+		return fmt.Errorf("delete old matches: %w", err)
+	}
+	return nil
+}
+
+// parsePlayerMatchesPath parses the uid from a /player/{uid}/matches path.
+func parsePlayerMatchesPath(p string) (uid uint64, ok bool) {
+	if !strings.HasPrefix(p, "/player/") || !strings.HasSuffix(p, "/matches") {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(p, "/player/"), "/matches"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+func (h *Handler) handlePlayerMatches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.m().player_matches_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// same as the other player endpoints
+	w.Header().Set("Cache-Control", "public, max-age=15, stale-while-revalidate=15")
+	w.Header().Set("Expires", time.Now().UTC().Add(time.Second*30).Format(http.TimeFormat))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, HEAD")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.MatchHistoryStorage == nil {
+		h.m().player_matches_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("match history is not enabled"))
+		return
+	}
+
+	var q struct {
+		ID     uint64 `param:"id"`
+		Before int64  `param:"before"`
+		Limit  int    `param:"limit" validate:"min=1,max=100"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().player_matches_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+	if uid, ok := parsePlayerMatchesPath(r.URL.Path); ok {
+		q.ID = uid
+	}
+	if q.ID == 0 {
+		h.m().player_matches_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+	if q.Limit == 0 {
+		q.Limit = 20
+	}
+
+	var before time.Time
+	if q.Before != 0 {
+		before = time.UnixMilli(q.Before)
+	}
+
+	ms, err := h.MatchHistoryStorage.GetMatches(q.ID, before, q.Limit)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", q.ID).
+			Msgf("failed to read matches from storage")
+		h.m().player_matches_requests_total.fail_storage_error_match.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	matches := make([]matchJSON, len(ms))
+	for i, m := range ms {
+		matches[i] = newMatchJSON(m)
+	}
+	res := map[string]any{
+		"uid":     q.ID,
+		"matches": matches,
+	}
+	if len(ms) == q.Limit {
+		res["next"] = ms[len(ms)-1].Time.UnixMilli()
+	}
+
+	h.m().player_matches_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, res)
+}
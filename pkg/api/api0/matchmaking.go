@@ -0,0 +1,556 @@
+package api0
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/websocket"
+	"github.com/rs/zerolog/hlog"
+)
+
+// MatchmakingConfig configures the matchmaking queue.
+type MatchmakingConfig struct {
+	// MatchSize is the number of players to group into a match. If zero,
+	// matchmaking is disabled.
+	MatchSize int
+
+	// Modes are the playlists which can be queued for. If empty, any playlist
+	// can be used.
+	Modes []string
+
+	// SkillBand is the width of the rating bands players are grouped into. If
+	// zero, or RatingStorage is nil, skill isn't considered.
+	SkillBand float64
+
+	// QueueTimeout is how long players can wait for a match. If zero, it
+	// defaults to 10 minutes.
+	QueueTimeout time.Duration
+}
+
+// MatchmakingInterval is the recommended interval for calling Matchmake.
+const MatchmakingInterval = time.Second * 5
+
+// mmResultTTL is how long the result of a ticket can be retrieved.
+const mmResultTTL = time.Minute
+
+type mmState string
+
+const (
+	mmQueued    mmState = "queued"
+	mmMatched   mmState = "matched"
+	mmExpired   mmState = "expired"
+	mmCancelled mmState = "cancelled"
+)
+
+// matchmaker stores matchmaking tickets. It is safe for concurrent use.
+type matchmaker struct {
+	mu      sync.Mutex
+	seq     uint64
+	tickets map[uint64]*mmTicket // by uid (for each player in the ticket)
+}
+
+type mmTicket struct {
+	uid    uint64   // the player (or party leader) who joined the queue
+	uids   []uint64 // the players to match together, including uid
+	seq    uint64   // join order
+	region string
+	mode   string
+	band   int64
+	joined time.Time
+
+	// the following are protected by the matchmaker mutex
+	blocks  map[uint64]struct{} // players who can't be matched with uids
+	state   mmState
+	server  string    // if matched
+	updated time.Time // when state was last changed
+	done    chan struct{}
+}
+
+type mmGroupKey struct {
+	region string
+	mode   string
+	band   int64
+}
+
+// mmResult is the current state of a ticket.
+type mmResult struct {
+	State   mmState  `json:"state"`
+	Region  string   `json:"region,omitempty"`
+	Mode    string   `json:"mode,omitempty"`
+	Joined  int64    `json:"joined,omitempty"`
+	Server  string   `json:"server,omitempty"`
+	Players []uint64 `json:"players,omitempty"`
+}
+
+func (t *mmTicket) result() mmResult {
+	return mmResult{
+		State:   t.state,
+		Region:  t.region,
+		Mode:    t.mode,
+		Joined:  t.joined.Unix(),
+		Server:  t.server,
+		Players: t.uids,
+	}
+}
+
+// setState updates the ticket state. The matchmaker mutex must be held.
+func (t *mmTicket) setState(s mmState, now time.Time) {
+	if t.state == mmQueued && s != mmQueued {
+		close(t.done)
+	}
+	t.state, t.updated = s, now
+}
+
+// join adds a ticket, replacing any existing ones for the players.
+func (m *matchmaker) join(h *Handler, t *mmTicket) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tickets == nil {
+		m.tickets = make(map[uint64]*mmTicket)
+	}
+	for _, uid := range t.uids {
+		if x, ok := m.tickets[uid]; ok {
+			m.cancel(h, x, t.joined)
+		}
+	}
+	m.seq++
+	t.seq = m.seq
+	t.state, t.updated = mmQueued, t.joined
+	t.done = make(chan struct{})
+	for _, uid := range t.uids {
+		m.tickets[uid] = t
+	}
+}
+
+// leave cancels the ticket for uid (and any other players in it), returning
+// false if the player wasn't in the queue.
+func (m *matchmaker) leave(h *Handler, uid uint64, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tickets[uid]
+	if !ok || t.state != mmQueued {
+		return false
+	}
+	m.cancel(h, t, now)
+	return true
+}
+
+// cancel cancels and removes a ticket if it is queued. The mutex must be held.
+func (m *matchmaker) cancel(h *Handler, t *mmTicket, now time.Time) {
+	if t.state != mmQueued {
+		return
+	}
+	t.setState(mmCancelled, now)
+	for _, uid := range t.uids {
+		if m.tickets[uid] == t {
+			delete(m.tickets, uid)
+		}
+	}
+	h.m().client_matchmaking_tickets_total.cancelled.Inc()
+}
+
+// blocked prevents b from being matched with a's ticket.
+func (m *matchmaker) blocked(a, b uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tickets[a]; ok && t.state == mmQueued {
+		if t.blocks == nil {
+			t.blocks = map[uint64]struct{}{}
+		}
+		t.blocks[b] = struct{}{}
+	}
+}
+
+// conflicts checks whether any players in t blocked or were blocked by players
+// in x. The matchmaker mutex must be held.
+func (t *mmTicket) conflicts(x *mmTicket) bool {
+	for _, y := range [][2]*mmTicket{{t, x}, {x, t}} {
+		for _, uid := range y[1].uids {
+			if _, ok := y[0].blocks[uid]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// get gets the current ticket for uid, or nil.
+func (m *matchmaker) get(uid uint64) (*mmTicket, mmResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tickets[uid]; ok {
+		return t, t.result()
+	}
+	return nil, mmResult{}
+}
+
+// Matchmake expires old tickets and groups queued players by region, mode,
+// and skill band into matches on servers with enough free slots, without
+// matching players with anyone they've blocked or been blocked by. It is called
+// whenever a player joins the queue, but should also be called periodically
+// (see MatchmakingInterval) so waiting players are matched once servers become
+// available.
+func (h *Handler) Matchmake(now time.Time) {
+	size := h.Matchmaking.MatchSize
+	if size <= 0 {
+		return
+	}
+	timeout := h.Matchmaking.QueueTimeout
+	if timeout <= 0 {
+		timeout = time.Minute * 10
+	}
+
+	type candidate struct {
+		id    string
+		order uint64
+		key   mmGroupKey
+		free  int
+		count int
+	}
+	var cs []*candidate
+	h.ServerList.GetLiveServers(func(srv *Server) bool {
+		if srv.Visibility == ServerVisibilityPublic && srv.Password == "" && srv.VerificationDeadline.IsZero() {
+			if free := srv.MaxPlayers - srv.PlayerCount; free >= size {
+				cs = append(cs, &candidate{
+					id:    srv.ID,
+					order: srv.Order,
+					key:   mmGroupKey{region: srv.Region, mode: srv.Playlist},
+					free:  free,
+					count: srv.PlayerCount,
+				})
+			}
+		}
+		return true
+	})
+
+	// prefer filling servers which already have players
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].count != cs[j].count {
+			return cs[i].count > cs[j].count
+		}
+		return cs[i].order < cs[j].order
+	})
+
+	m := &h.matchmaking
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	groups := map[mmGroupKey][]*mmTicket{}
+	players := map[mmGroupKey]int{}
+	for uid, t := range m.tickets {
+		switch {
+		case t.state != mmQueued:
+			if now.Sub(t.updated) > mmResultTTL {
+				delete(m.tickets, uid)
+			}
+		case uid != t.uid:
+			// party members share the leader's ticket
+		case now.Sub(t.joined) > timeout:
+			t.setState(mmExpired, now)
+			h.m().client_matchmaking_tickets_total.expired.Inc()
+		default:
+			k := mmGroupKey{region: t.region, mode: t.mode, band: t.band}
+			groups[k] = append(groups[k], t)
+			players[k] += len(t.uids)
+		}
+	}
+
+	for k, ts := range groups {
+		if players[k] < size {
+			continue
+		}
+		sort.Slice(ts, func(i, j int) bool {
+			return ts[i].seq < ts[j].seq
+		})
+	servers:
+		for _, c := range cs {
+			if c.key.mode != k.mode || (k.region != "" && c.key.region != k.region) {
+				continue
+			}
+			for c.free >= size {
+				// fill the match in queue order, skipping parties which
+				// don't fit or contain blocked players
+				var n int
+				var match, rest []*mmTicket
+			tickets:
+				for _, t := range ts {
+					if n+len(t.uids) <= size {
+						for _, x := range match {
+							if t.conflicts(x) {
+								rest = append(rest, t)
+								continue tickets
+							}
+						}
+						match = append(match, t)
+						n += len(t.uids)
+					} else {
+						rest = append(rest, t)
+					}
+				}
+				if n < size {
+					break servers
+				}
+				for _, t := range match {
+					t.server = c.id
+					t.setState(mmMatched, now)
+					h.m().client_matchmaking_tickets_total.matched.Inc()
+					h.m().client_matchmaking_wait_seconds.Update(now.Sub(t.joined).Seconds())
+				}
+				ts = rest
+				c.free -= size
+			}
+		}
+	}
+}
+
+func (h *Handler) handleClientMatchmaking(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().client_matchmaking_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.Matchmaking.MatchSize <= 0 {
+		h.m().client_matchmaking_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("matchmaking is not enabled"))
+		return
+	}
+
+	uid, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		h.m().client_matchmaking_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	if !h.featureEnabled(r, FeatureMatchmaking, uid) {
+		h.m().client_matchmaking_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("matchmaking is not enabled"))
+		return
+	}
+
+	acct, err := h.AccountStorage.GetAccount(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read account from storage")
+		h.m().client_matchmaking_requests_total.fail_storage_error_account.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if acct == nil {
+		h.m().client_matchmaking_requests_total.reject_player_not_found.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+	if !h.checkPlayerToken(acct, r.URL.Query().Get("token")) {
+		h.m().client_matchmaking_requests_total.reject_masterserver_token.Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		mode := r.URL.Query().Get("mode")
+		if mode == "" || len(mode) > 64 {
+			h.m().client_matchmaking_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("mode param is required"))
+			return
+		}
+		if ms := h.Matchmaking.Modes; len(ms) != 0 {
+			var ok bool
+			for _, m := range ms {
+				if m == mode {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				h.m().client_matchmaking_requests_total.reject_bad_request.Inc()
+				respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("unsupported mode %q", mode))
+				return
+			}
+		}
+
+		region := r.URL.Query().Get("region")
+		if len(region) > 64 {
+			h.m().client_matchmaking_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("region param is too long"))
+			return
+		}
+		if region == "" {
+			if raddr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+				_, _, region, _, _ = h.lookupServerGeo(r, raddr.Addr())
+			}
+		}
+
+		// parties are queued together by the leader
+		uids, ok := h.parties.queue(uid)
+		if !ok {
+			h.m().client_matchmaking_requests_total.reject_not_leader.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_BAD_REQUEST.MessageObjf("only the party leader can join the matchmaking queue"))
+			return
+		}
+		if len(uids) > h.Matchmaking.MatchSize {
+			h.m().client_matchmaking_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("party is too large for a match"))
+			return
+		}
+
+		var band int64
+		if width := h.Matchmaking.SkillBand; h.RatingStorage != nil && width > 0 {
+			var sum float64
+			for _, x := range uids {
+				pr, err := h.getRating(x, mode)
+				if err != nil {
+					hlog.FromRequest(r).Error().
+						Err(err).
+						Uint64("uid", x).
+						Msgf("failed to read rating from storage")
+					h.m().client_matchmaking_requests_total.fail_storage_error_rating.Inc()
+					respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+					return
+				}
+				sum += pr.Rating
+			}
+			band = int64(math.Floor(sum / float64(len(uids)) / width))
+		}
+
+		blocks, err := h.blockSet(uids...)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", uid).
+				Msgf("failed to read blocks from storage")
+			h.m().client_matchmaking_requests_total.fail_storage_error_blocks.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+
+		now := time.Now()
+		h.matchmaking.join(h, &mmTicket{
+			uid:    uid,
+			uids:   uids,
+			region: region,
+			mode:   mode,
+			band:   band,
+			joined: now,
+			blocks: blocks,
+		})
+		h.Matchmake(now)
+
+		_, res := h.matchmaking.get(uid)
+		h.m().client_matchmaking_requests_total.success_join.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"ticket":  res,
+		})
+
+	case http.MethodDelete:
+		left := h.matchmaking.leave(h, uid, time.Now())
+		h.m().client_matchmaking_requests_total.success_leave.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"left":    left,
+		})
+
+	default:
+		t, res := h.matchmaking.get(uid)
+		if !websocket.IsUpgrade(r) {
+			var obj any
+			if t != nil {
+				obj = res
+			}
+			h.m().client_matchmaking_requests_total.success_status.Inc()
+			respJSON(w, r, http.StatusOK, map[string]any{
+				"success": true,
+				"ticket":  obj,
+			})
+			return
+		}
+		if t == nil {
+			h.m().client_matchmaking_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("not in the matchmaking queue"))
+			return
+		}
+		h.serveMatchmakingNotify(w, r, t, res)
+	}
+}
+
+// serveMatchmakingNotify sends the current ticket state over a WebSocket, then
+// sends the result once a ticket is no longer queued.
+func (h *Handler) serveMatchmakingNotify(w http.ResponseWriter, r *http.Request, t *mmTicket, res mmResult) {
+	c, err := websocket.Upgrade(w, r)
+	if err != nil {
+		h.m().client_matchmaking_requests_total.reject_bad_request.Inc()
+		hlog.FromRequest(r).Debug().Err(err).Msg("failed to upgrade matchmaking notification websocket")
+		return
+	}
+	defer c.Close()
+	h.m().client_matchmaking_requests_total.success_notify.Inc()
+
+	send := func(res mmResult) bool {
+		buf, err := json.Marshal(res)
+		if err != nil {
+			panic(err)
+		}
+		return c.WriteText(buf) == nil
+	}
+	if !send(res) || res.State != mmQueued {
+		return
+	}
+
+	// read (and discard) messages so we notice when the client goes away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := c.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	tk := time.NewTicker(time.Second * 30)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			h.matchmaking.mu.Lock()
+			res := t.result()
+			h.matchmaking.mu.Unlock()
+			send(res)
+			return
+		case <-tk.C:
+			if c.Write(websocket.OpPing, nil) != nil {
+				return
+			}
+		case <-gone:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
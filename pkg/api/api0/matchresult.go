@@ -0,0 +1,245 @@
+package api0
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// matchResultPlayer is a player in a match result.
+type matchResultPlayer struct {
+	UID uint64 `json:"uid" validate:"required"`
+
+	// Team is the player's team. Players on the same non-zero team are not
+	// rated against each other.
+	Team int `json:"team,omitempty"`
+
+	// Rank is the player's (or team's) placement. Lower is better, and equal
+	// ranks are draws.
+	Rank int `json:"rank"`
+
+	// Stats are optional scoreboard columns for the match history.
+	Stats map[string]int64 `json:"stats,omitempty" validate:"max=16"`
+}
+
+func (h *Handler) handleServerMatchResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().server_matchresult_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.RatingStorage == nil && h.MatchHistoryStorage == nil {
+		h.m().server_matchresult_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("match results are not enabled"))
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_matchresult_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		h.m().server_matchresult_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	srv := h.ServerList.GetServerByID(id)
+	if srv == nil {
+		h.m().server_matchresult_requests_total.reject_server_not_found.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such game server"))
+		return
+	}
+	if srv.Addr.Addr() != raddr.Addr() {
+		h.m().server_matchresult_requests_total.reject_unauthorized_ip.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
+		return
+	}
+	if !h.checkServerSignature(w, r, srv, "match_result") {
+		return
+	}
+
+	// private matches are still recorded, but aren't rated since it would be
+	// easy to farm ratings on them
+	rated := h.RatingStorage != nil && srv.Password == "" && srv.Visibility == ServerVisibilityPublic
+	if !rated && h.MatchHistoryStorage == nil {
+		h.m().server_matchresult_requests_total.reject_unrated_server.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("matches on private servers are not rated"))
+		return
+	}
+
+	var req struct {
+		Mode     string              `json:"mode,omitempty" validate:"max=64"`
+		Map      string              `json:"map,omitempty" validate:"max=64"`
+		Duration int                 `json:"duration,omitempty" validate:"min=0,max=86400"`
+		Players  []matchResultPlayer `json:"players" validate:"required,max=64"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		h.m().server_matchresult_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = srv.Playlist
+	}
+	if req.Mode == "" {
+		h.m().server_matchresult_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("mode is required"))
+		return
+	}
+	if req.Map == "" {
+		req.Map = srv.Map
+	}
+	var opposed bool
+	seen := make(map[uint64]struct{}, len(req.Players))
+	for i, p := range req.Players {
+		if _, dup := seen[p.UID]; dup {
+			h.m().server_matchresult_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("duplicate player %d", p.UID))
+			return
+		}
+		seen[p.UID] = struct{}{}
+		for k := range p.Stats {
+			if k == "" || len(k) > 32 {
+				h.m().server_matchresult_requests_total.reject_bad_request.Inc()
+				respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("stats keys must be between 1 and 32 bytes long"))
+				return
+			}
+		}
+		if i > 0 && (p.Team == 0 || p.Team != req.Players[0].Team) {
+			opposed = true
+		}
+	}
+	if !opposed {
+		h.m().server_matchresult_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("match result must contain at least two opposing players"))
+		return
+	}
+
+	now := time.Now()
+	match := MatchRecord{
+		Time:       now,
+		ServerID:   srv.ID,
+		ServerName: srv.Name,
+		Map:        req.Map,
+		Mode:       req.Mode,
+		Duration:   time.Duration(req.Duration) * time.Second,
+		Players:    make([]MatchPlayer, len(req.Players)),
+	}
+	old := make([]PlayerRating, len(req.Players))
+	for i, p := range req.Players {
+		acct, err := h.AccountStorage.GetAccount(p.UID)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", p.UID).
+				Msgf("failed to read account from storage")
+			h.m().server_matchresult_requests_total.fail_storage_error_account.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if acct == nil {
+			h.m().server_matchresult_requests_total.reject_player_not_found.Inc()
+			respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObjf("player %d not found", p.UID))
+			return
+		}
+		// players must be on the server so servers can't rate matches for
+		// arbitrary players
+		if acct.LastServerID != srv.ID {
+			h.m().server_matchresult_requests_total.reject_player_not_on_server.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("player %d is not connected to this server", p.UID))
+			return
+		}
+		match.Players[i] = MatchPlayer{
+			UID:      p.UID,
+			Username: acct.Username,
+			Team:     p.Team,
+			Rank:     p.Rank,
+			Stats:    p.Stats,
+		}
+		if rated {
+			if old[i], err = h.getRating(p.UID, req.Mode); err != nil {
+				hlog.FromRequest(r).Error().
+					Err(err).
+					Uint64("uid", p.UID).
+					Msgf("failed to read rating from storage")
+				h.m().server_matchresult_requests_total.fail_storage_error_rating.Inc()
+				respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+				return
+			}
+		}
+	}
+
+	res := map[string]any{
+		"success": true,
+	}
+
+	if rated {
+		rs := rateMatch(req.Players, old, now)
+		if err := h.RatingStorage.SaveRatings(rs); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to save ratings to storage")
+			h.m().server_matchresult_requests_total.fail_storage_error_rating.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		ratings := make(map[string]float64, len(rs))
+		for _, pr := range rs {
+			ratings[strconv.FormatUint(pr.UID, 10)] = math.Round(pr.Rating)
+		}
+		res["ratings"] = ratings
+	}
+
+	if h.MatchHistoryStorage != nil {
+		if match.ID, err = cryptoRandHex(32); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to generate random match id")
+			h.m().server_matchresult_requests_total.fail_other_error.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if err := h.MatchHistoryStorage.SaveMatch(&match); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to save match to storage")
+			h.m().server_matchresult_requests_total.fail_storage_error_match.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		res["id"] = match.ID
+	}
+
+	hlog.FromRequest(r).Info().
+		Str("server", srv.ID).
+		Str("mode", req.Mode).
+		Int("players", len(req.Players)).
+		Bool("rated", rated).
+		Msgf("match result submitted")
+
+	h.m().server_matchresult_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, res)
+}
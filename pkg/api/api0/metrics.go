@@ -12,25 +12,149 @@ import (
 // note: for results, fail_ prefix is for errors which are likely a problem with the backend, and reject_ are for client errors
 
 type apiMetrics struct {
-	set                      *metrics.Set
-	request_panics_total     *metrics.Counter
+	set                        *metrics.Set
+	request_panics_total       *metrics.Counter
+	api_version_requests_total struct {
+		unversioned *metrics.Counter
+		v1          *metrics.Counter
+		v2          *metrics.Counter
+	}
+	conditional_requests_total struct {
+		modified     *metrics.Counter
+		not_modified *metrics.Counter
+	}
+	cors_requests_total struct {
+		success           *metrics.Counter
+		preflight         *metrics.Counter
+		reject_disallowed *metrics.Counter
+		reject_admin      *metrics.Counter
+	}
 	versiongate_checks_total struct {
 		success_ok     *metrics.Counter
 		success_dev    *metrics.Counter
 		reject_old     *metrics.Counter
 		reject_invalid *metrics.Counter
+		reject_blocked *metrics.Counter
 		reject_notns   *metrics.Counter
 	}
-	accounts_writepersistence_extradata_size_bytes *metrics.Histogram // only includes successful updates
-	accounts_writepersistence_stored_size_bytes    *metrics.Histogram
-	accounts_writepersistence_requests_total       struct {
+	idempotency_requests_total struct {
+		success_new        func(endpoint string) *metrics.Counter
+		success_replay     func(endpoint string) *metrics.Counter
+		bypass             func(endpoint string) *metrics.Counter
+		reject_bad_request func(endpoint string) *metrics.Counter
+		reject_reused      func(endpoint string) *metrics.Counter
+	}
+	anomaly_checks_total struct {
+		tarpit       *metrics.Counter
+		reject_block *metrics.Counter
+	}
+	mirror_checks_total struct {
+		success                *metrics.Counter
+		fail_http_error        *metrics.Counter
+		fail_checksum_mismatch *metrics.Counter
+	}
+	anomaly_penalties_total struct {
+		tarpit func(scope string) *metrics.Counter
+		block  func(scope string) *metrics.Counter
+	}
+	honeypot_hits_total struct {
+		path  *metrics.Counter
+		param *metrics.Counter
+	}
+	retention_runs_total struct {
+		success    *metrics.Counter
+		fail_error *metrics.Counter
+	}
+	retention_deleted_total struct {
+		sessions     *metrics.Counter
+		accounts     *metrics.Counter
+		heartbeats   *metrics.Counter
+		server_stats *metrics.Counter
+	}
+	retention_reclaimed_bytes_total *metrics.Counter
+	honeypot_checks_total           struct {
+		reject_rate_limited *metrics.Counter
+	}
+	challenge_checks_total struct {
+		success_clearance *metrics.Counter
+		reject_challenge  *metrics.Counter
+	}
+	client_challenge_requests_total struct {
+		success_challenge        *metrics.Counter
+		success_clearance        *metrics.Counter
+		reject_bad_request       *metrics.Counter
+		reject_invalid_challenge *metrics.Counter
+		reject_invalid_solution  *metrics.Counter
+		reject_reused_challenge  *metrics.Counter
+		fail_other_error         *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
+	captcha_checks_total struct {
+		success_clearance *metrics.Counter
+		reject_captcha    *metrics.Counter
+	}
+	client_captcha_requests_total struct {
+		success_captcha         *metrics.Counter
+		success_clearance       *metrics.Counter
+		reject_disabled         *metrics.Counter
+		reject_bad_request      *metrics.Counter
+		reject_invalid_response *metrics.Counter
+		fail_provider_error     *metrics.Counter
+		fail_other_error        *metrics.Counter
+		http_method_not_allowed *metrics.Counter
+	}
+	player_quota_rejections_total struct {
+		reject func(quota string) *metrics.Counter
+	}
+	admin_accounts_backfill_total struct {
+		success           *metrics.Counter
+		notfound          *metrics.Counter
+		fail_origin_error *metrics.Counter
+	}
+	feature_checks_total struct {
+		enabled  func(feature string) *metrics.Counter
+		disabled func(feature string) *metrics.Counter
+	}
+	admin_requests_total struct {
+		success                    func(endpoint string) *metrics.Counter
+		reject_disabled            func(endpoint string) *metrics.Counter
+		reject_unauthorized        func(endpoint string) *metrics.Counter
+		reject_client_cert         func(endpoint string) *metrics.Counter
+		reject_bad_request         func(endpoint string) *metrics.Counter
+		fail_storage_error_state   func(endpoint string) *metrics.Counter
+		fail_storage_error_erase   func(endpoint string) *metrics.Counter
+		fail_storage_error_account func(endpoint string) *metrics.Counter
+		fail_storage_error_report  func(endpoint string) *metrics.Counter
+		fail_storage_error_crash   func(endpoint string) *metrics.Counter
+		fail_other_error           func(endpoint string) *metrics.Counter
+		fail_reload_error          func(endpoint string) *metrics.Counter
+		http_method_not_allowed    func(endpoint string) *metrics.Counter
+	}
+	accounts_writepersistence_extradata_size_bytes        *metrics.Histogram // only includes successful updates
+	accounts_writepersistence_stored_size_bytes           *metrics.Histogram
+	accounts_writepersistence_pdata_rule_violations_total struct {
+		flag   *metrics.Counter
+		reject *metrics.Counter
+	}
+	accounts_writepersistence_requests_total struct {
 		success                    *metrics.Counter
+		success_patch              *metrics.Counter
+		success_guest              *metrics.Counter
 		reject_too_much_extradata  *metrics.Counter
 		reject_too_large           *metrics.Counter
 		reject_invalid_pdata       *metrics.Counter
+		reject_invalid_patch       *metrics.Counter
+		reject_precondition_failed *metrics.Counter
 		reject_bad_request         *metrics.Counter
 		reject_player_not_found    *metrics.Counter
 		reject_unauthorized        *metrics.Counter
+		reject_erased              *metrics.Counter
+		reject_quota_player        *metrics.Counter
+		reject_quota_player_hourly *metrics.Counter
+		reject_pdata_rule          *metrics.Counter
+		reject_disabled            *metrics.Counter
+		reject_hook                *metrics.Counter
+		reject_quota_server        *metrics.Counter
 		fail_storage_error_account *metrics.Counter
 		fail_storage_error_pdata   *metrics.Counter
 		fail_other_error           *metrics.Counter
@@ -52,21 +176,137 @@ type apiMetrics struct {
 		fail_storage_error_account *metrics.Counter
 		http_method_not_allowed    *metrics.Counter
 	}
+	accounts_link_requests_total struct {
+		success                    func(provider string) *metrics.Counter
+		success_redirect           func(provider string) *metrics.Counter
+		reject_disabled            func(provider string) *metrics.Counter
+		reject_bad_request         func(provider string) *metrics.Counter
+		reject_player_not_found    func(provider string) *metrics.Counter
+		reject_masterserver_token  func(provider string) *metrics.Counter
+		reject_invalid_state       func(provider string) *metrics.Counter
+		reject_invalid_link        func(provider string) *metrics.Counter
+		reject_erased              func(provider string) *metrics.Counter
+		fail_provider_error        func(provider string) *metrics.Counter
+		fail_storage_error_account func(provider string) *metrics.Counter
+		fail_storage_error_link    func(provider string) *metrics.Counter
+		http_method_not_allowed    func(provider string) *metrics.Counter
+	}
+	accounts_unlink_requests_total struct {
+		success                    *metrics.Counter
+		reject_bad_request         *metrics.Counter
+		reject_player_not_found    *metrics.Counter
+		reject_masterserver_token  *metrics.Counter
+		fail_storage_error_account *metrics.Counter
+		fail_storage_error_link    *metrics.Counter
+		http_method_not_allowed    *metrics.Counter
+	}
+	accounts_deletedata_requests_total struct {
+		success                    *metrics.Counter
+		reject_bad_request         *metrics.Counter
+		reject_player_not_found    *metrics.Counter
+		reject_masterserver_token  *metrics.Counter
+		fail_storage_error_account *metrics.Counter
+		fail_storage_error_erase   *metrics.Counter
+		http_method_not_allowed    *metrics.Counter
+	}
+	accounts_exportdata_requests_total struct {
+		success                        *metrics.Counter
+		reject_bad_request             *metrics.Counter
+		reject_player_not_found        *metrics.Counter
+		reject_masterserver_token      *metrics.Counter
+		fail_storage_error_account     *metrics.Counter
+		fail_storage_error_pdata       *metrics.Counter
+		fail_storage_error_link        *metrics.Counter
+		fail_storage_error_namehistory *metrics.Counter
+		fail_storage_error_signal      *metrics.Counter
+		fail_other_error               *metrics.Counter
+		http_method_not_allowed        *metrics.Counter
+	}
+	accounts_getlinks_requests_total struct {
+		success                    *metrics.Counter
+		reject_bad_request         *metrics.Counter
+		reject_player_not_found    *metrics.Counter
+		reject_unauthorized        *metrics.Counter
+		fail_storage_error_account *metrics.Counter
+		fail_storage_error_link    *metrics.Counter
+		fail_other_error           *metrics.Counter
+		http_method_not_allowed    *metrics.Counter
+	}
 	client_mainmenupromos_requests_total struct {
 		success                 func(version string) *metrics.Counter
 		http_method_not_allowed *metrics.Counter
 	}
 	client_mainmenupromos_requests_map *metricsx.GeoCounter2
-	client_originauth_requests_total   struct {
+	client_motd_requests_total         struct {
+		success                  func(version string) *metrics.Counter
+		fail_storage_error_state *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
+	client_serverattestationkey_requests_total struct {
+		success                 *metrics.Counter
+		http_method_not_allowed *metrics.Counter
+	}
+	jwks_requests_total struct {
+		success                 *metrics.Counter
+		http_method_not_allowed *metrics.Counter
+	}
+	client_updatemanifest_requests_total struct {
+		success                  *metrics.Counter
+		fail_storage_error_state *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
+	client_mods_requests_total struct {
+		success                  *metrics.Counter
+		reject_bad_request       *metrics.Counter
+		fail_storage_error_state *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
+	client_servermods_requests_total struct {
+		success                  *metrics.Counter
+		reject_bad_request       *metrics.Counter
+		reject_server_not_found  *metrics.Counter
+		fail_storage_error_state *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
+	client_relays_requests_total struct {
+		success                  *metrics.Counter
+		fail_storage_error_state *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
+	client_guestauth_requests_total struct {
+		success                    *metrics.Counter
+		reject_disabled            *metrics.Counter
+		reject_versiongate         *metrics.Counter
+		reject_network             *metrics.Counter
+		reject_bad_request         *metrics.Counter
+		reject_rate_limited        *metrics.Counter
+		reject_hook                *metrics.Counter
+		fail_storage_error_account *metrics.Counter
+		fail_other_error           *metrics.Counter
+		http_method_not_allowed    *metrics.Counter
+	}
+	client_originauth_requests_total struct {
 		success                     *metrics.Counter
+		success_stale_verified      *metrics.Counter
 		reject_bad_request          *metrics.Counter
 		reject_versiongate          *metrics.Counter
 		reject_stryder_invalidgame  *metrics.Counter
 		reject_stryder_invalidtoken *metrics.Counter
 		reject_stryder_mpnotallowed *metrics.Counter
 		reject_stryder_other        *metrics.Counter
+		reject_session_policy       *metrics.Counter
+		reject_token_replay         *metrics.Counter
+		reject_quota                *metrics.Counter
+		reject_erased               *metrics.Counter
+		reject_stale_verified       *metrics.Counter
+		reject_ip_reputation        *metrics.Counter
+		reject_network_rule         *metrics.Counter
+		reject_hook                 *metrics.Counter
+		reject_anomaly              *metrics.Counter
 		fail_storage_error_account  *metrics.Counter
+		fail_storage_error_link     *metrics.Counter
 		fail_stryder_error          *metrics.Counter
+		fail_origin_unavailable     *metrics.Counter
 		fail_other_error            *metrics.Counter
 		http_method_not_allowed     *metrics.Counter
 	}
@@ -92,9 +332,15 @@ type apiMetrics struct {
 		reject_versiongate         *metrics.Counter
 		reject_player_not_found    *metrics.Counter
 		reject_masterserver_token  *metrics.Counter
+		reject_quota               *metrics.Counter
+		reject_anomaly             *metrics.Counter
 		reject_password            *metrics.Counter
+		reject_join_token          *metrics.Counter
+		reject_duplicate_session   *metrics.Counter
+		reject_allowlist           *metrics.Counter
 		reject_gameserverauth      *metrics.Counter
 		reject_gameserver          *metrics.Counter
+		reject_erased              *metrics.Counter
 		fail_gameserverauth        *metrics.Counter
 		fail_gameserverauthudp     *metrics.Counter
 		fail_storage_error_account *metrics.Counter
@@ -105,19 +351,146 @@ type apiMetrics struct {
 	client_authwithserver_gameserverauth_duration_seconds    *metrics.Histogram
 	client_authwithserver_gameserverauthudp_duration_seconds *metrics.Histogram
 	client_authwithserver_gameserverauthudp_attempts         *metrics.Histogram
-	client_authwithself_requests_total                       struct {
+	client_authwithserver_preauth_total                      *metrics.Counter
+	client_jointoken_requests_total                          struct {
 		success                    *metrics.Counter
 		reject_bad_request         *metrics.Counter
 		reject_versiongate         *metrics.Counter
+		reject_anomaly             *metrics.Counter
+		reject_password            *metrics.Counter
 		reject_player_not_found    *metrics.Counter
 		reject_masterserver_token  *metrics.Counter
+		reject_allowlist           *metrics.Counter
+		fail_storage_error_account *metrics.Counter
+		fail_other_error           *metrics.Counter
+		http_method_not_allowed    *metrics.Counter
+	}
+	client_jointoken_redeem_total struct {
+		success          *metrics.Counter
+		reject_not_found *metrics.Counter
+		reject_expired   *metrics.Counter
+		reject_used      *metrics.Counter
+		reject_mismatch  *metrics.Counter
+	}
+	client_jointoken_joins_total struct {
+		success *metrics.Counter
+		fail    *metrics.Counter
+	}
+	client_authwithself_requests_total struct {
+		success                    *metrics.Counter
+		reject_bad_request         *metrics.Counter
+		reject_versiongate         *metrics.Counter
+		reject_player_not_found    *metrics.Counter
+		reject_masterserver_token  *metrics.Counter
+		reject_quota               *metrics.Counter
+		reject_erased              *metrics.Counter
 		fail_storage_error_account *metrics.Counter
 		fail_storage_error_pdata   *metrics.Counter
 		fail_other_error           *metrics.Counter
 		http_method_not_allowed    *metrics.Counter
 	}
-	client_servers_requests_total struct {
-		success                 func(version string) *metrics.Counter
+	cache_lookups_total struct {
+		username_hit    *metrics.Counter
+		username_miss   *metrics.Counter
+		username_error  *metrics.Counter
+		servergeo_hit   *metrics.Counter
+		servergeo_miss  *metrics.Counter
+		servergeo_error *metrics.Counter
+	}
+	client_population_requests_total struct {
+		success                  *metrics.Counter
+		fail_storage_error_stats *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
+	api_status_requests_total struct {
+		success                  *metrics.Counter
+		fail_storage_error_state *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
+	client_friends_requests_total struct {
+		success_list               *metrics.Counter
+		success_request            *metrics.Counter
+		success_accept             *metrics.Counter
+		success_remove             *metrics.Counter
+		reject_disabled            *metrics.Counter
+		reject_bad_request         *metrics.Counter
+		reject_player_not_found    *metrics.Counter
+		reject_masterserver_token  *metrics.Counter
+		reject_limit               *metrics.Counter
+		reject_blocked             *metrics.Counter
+		fail_storage_error_account *metrics.Counter
+		fail_storage_error_friends *metrics.Counter
+		fail_storage_error_blocks  *metrics.Counter
+		http_method_not_allowed    *metrics.Counter
+	}
+	client_blocks_requests_total struct {
+		success_list               *metrics.Counter
+		success_block              *metrics.Counter
+		success_unblock            *metrics.Counter
+		reject_disabled            *metrics.Counter
+		reject_bad_request         *metrics.Counter
+		reject_player_not_found    *metrics.Counter
+		reject_masterserver_token  *metrics.Counter
+		reject_limit               *metrics.Counter
+		fail_storage_error_account *metrics.Counter
+		fail_storage_error_blocks  *metrics.Counter
+		http_method_not_allowed    *metrics.Counter
+	}
+	client_party_requests_total struct {
+		success_create             *metrics.Counter
+		success_join               *metrics.Counter
+		success_update             *metrics.Counter
+		success_leave              *metrics.Counter
+		success_status             *metrics.Counter
+		success_presence           *metrics.Counter
+		reject_disabled            *metrics.Counter
+		reject_bad_request         *metrics.Counter
+		reject_player_not_found    *metrics.Counter
+		reject_masterserver_token  *metrics.Counter
+		reject_party_not_found     *metrics.Counter
+		reject_party_full          *metrics.Counter
+		reject_not_leader          *metrics.Counter
+		reject_blocked             *metrics.Counter
+		fail_storage_error_account *metrics.Counter
+		fail_storage_error_blocks  *metrics.Counter
+		fail_other_error           *metrics.Counter
+		http_method_not_allowed    *metrics.Counter
+	}
+	client_matchmaking_requests_total struct {
+		success_join               *metrics.Counter
+		success_leave              *metrics.Counter
+		success_status             *metrics.Counter
+		success_notify             *metrics.Counter
+		reject_disabled            *metrics.Counter
+		reject_bad_request         *metrics.Counter
+		reject_not_leader          *metrics.Counter
+		reject_player_not_found    *metrics.Counter
+		reject_masterserver_token  *metrics.Counter
+		fail_storage_error_account *metrics.Counter
+		fail_storage_error_rating  *metrics.Counter
+		fail_storage_error_blocks  *metrics.Counter
+		fail_other_error           *metrics.Counter
+		http_method_not_allowed    *metrics.Counter
+	}
+	client_matchmaking_tickets_total struct {
+		matched   *metrics.Counter
+		expired   *metrics.Counter
+		cancelled *metrics.Counter
+	}
+	client_matchmaking_wait_seconds *metrics.Histogram
+	client_servers_requests_total   struct {
+		success                    func(version string) *metrics.Counter
+		reject_versiongate         *metrics.Counter
+		reject_bad_request         *metrics.Counter
+		reject_masterserver_token  *metrics.Counter
+		fail_storage_error_account *metrics.Counter
+		http_method_not_allowed    *metrics.Counter
+	}
+	client_servers_search_requests_total struct {
+		success                 *metrics.Counter
+		reject_bad_request      *metrics.Counter
+		reject_rate_limited     *metrics.Counter
+		fail_other_error        *metrics.Counter
 		http_method_not_allowed *metrics.Counter
 	}
 	client_servers_requests_map struct {
@@ -125,14 +498,51 @@ type apiMetrics struct {
 		other     *metricsx.GeoCounter2
 	}
 	client_servers_response_size_bytes struct {
-		gzip *metrics.Histogram
-		none *metrics.Histogram
+		gzip    *metrics.Histogram
+		none    *metrics.Histogram
+		msgpack *metrics.Histogram
+	}
+	client_servers_variant_requests_total struct {
+		control *metrics.Counter
+		canary  *metrics.Counter
+	}
+	client_servers_variant_joins_total struct {
+		control *metrics.Counter
+		canary  *metrics.Counter
+	}
+	client_servers_variant_join_position struct {
+		control *metrics.Histogram
+		canary  *metrics.Histogram
+	}
+	server_udpheartbeat_total struct {
+		success                  *metrics.Counter
+		reject_disabled          *metrics.Counter
+		reject_server_not_found  *metrics.Counter
+		reject_unsigned          *metrics.Counter
+		reject_invalid_signature *metrics.Counter
+		reject_expired           *metrics.Counter
+		reject_replayed          *metrics.Counter
+		reject_versiongate       *metrics.Counter
+		reject_unauthorized_ip   *metrics.Counter
+		fail_serverlist_error    *metrics.Counter
+	}
+	server_signature_checks_total struct {
+		success         func(endpoint string) *metrics.Counter
+		unsigned        func(endpoint string) *metrics.Counter
+		reject_missing  func(endpoint string) *metrics.Counter
+		reject_invalid  func(endpoint string) *metrics.Counter
+		reject_expired  func(endpoint string) *metrics.Counter
+		reject_replayed func(endpoint string) *metrics.Counter
 	}
 	server_upsert_requests_total struct {
 		success_updated            func(action string) *metrics.Counter
 		success_verified           func(action string) *metrics.Counter
 		reject_versiongate         func(action string) *metrics.Counter
 		reject_ipv6                func(action string) *metrics.Counter
+		reject_network_rule        func(action string) *metrics.Counter
+		reject_delist_banned       func(action string) *metrics.Counter
+		reject_hook                func(action string) *metrics.Counter
+		reject_anomaly             func(action string) *metrics.Counter
 		reject_bad_request         func(action string) *metrics.Counter
 		reject_unauthorized_ip     func(action string) *metrics.Counter
 		reject_server_not_found    func(action string) *metrics.Counter
@@ -162,10 +572,192 @@ type apiMetrics struct {
 		fail_other_error        *metrics.Counter
 		http_method_not_allowed *metrics.Counter
 	}
+	server_diagnose_requests_total struct {
+		success_ok              *metrics.Counter
+		success_failed          *metrics.Counter
+		fail_other_error        *metrics.Counter
+		http_method_not_allowed *metrics.Counter
+	}
+	server_webhook_requests_total struct {
+		success                 *metrics.Counter
+		reject_disabled         *metrics.Counter
+		reject_bad_request      *metrics.Counter
+		reject_unauthorized     *metrics.Counter
+		reject_limits_exceeded  *metrics.Counter
+		fail_storage_error      *metrics.Counter
+		fail_other_error        *metrics.Counter
+		http_method_not_allowed *metrics.Counter
+	}
+	server_webhook_deliveries_total struct {
+		success         *metrics.Counter
+		fail_http_error *metrics.Counter
+		dropped         *metrics.Counter
+	}
+	server_ownerstatus_requests_total struct {
+		success                 *metrics.Counter
+		reject_bad_request      *metrics.Counter
+		reject_unauthorized     *metrics.Counter
+		fail_other_error        *metrics.Counter
+		http_method_not_allowed *metrics.Counter
+	}
+	server_statshistory_requests_total struct {
+		success                  *metrics.Counter
+		reject_disabled          *metrics.Counter
+		reject_bad_request       *metrics.Counter
+		reject_server_not_found  *metrics.Counter
+		fail_storage_error_stats *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
+	server_applytrusted_requests_total struct {
+		success                  *metrics.Counter
+		reject_bad_request       *metrics.Counter
+		reject_server_not_found  *metrics.Counter
+		reject_unauthorized_ip   *metrics.Counter
+		reject_queue_full        *metrics.Counter
+		fail_storage_error_state *metrics.Counter
+		fail_other_error         *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
+	server_delistappeal_requests_total struct {
+		success                   *metrics.Counter
+		reject_disabled           *metrics.Counter
+		reject_bad_request        *metrics.Counter
+		reject_unauthorized       *metrics.Counter
+		reject_not_delisted       *metrics.Counter
+		reject_pending            *metrics.Counter
+		fail_storage_error_state  *metrics.Counter
+		fail_storage_error_report *metrics.Counter
+		fail_other_error          *metrics.Counter
+		http_method_not_allowed   *metrics.Counter
+	}
+	server_banlist_requests_total struct {
+		success_lists              *metrics.Counter
+		success_feed               *metrics.Counter
+		success_upload             *metrics.Counter
+		reject_disabled            *metrics.Counter
+		reject_bad_request         *metrics.Counter
+		reject_server_not_found    *metrics.Counter
+		reject_unauthorized_ip     *metrics.Counter
+		reject_list_not_found      *metrics.Counter
+		reject_untrusted           *metrics.Counter
+		fail_storage_error_state   *metrics.Counter
+		fail_storage_error_banlist *metrics.Counter
+		fail_other_error           *metrics.Counter
+		http_method_not_allowed    *metrics.Counter
+	}
+	server_matchresult_requests_total struct {
+		success                     *metrics.Counter
+		reject_disabled             *metrics.Counter
+		reject_bad_request          *metrics.Counter
+		reject_server_not_found     *metrics.Counter
+		reject_unauthorized_ip      *metrics.Counter
+		reject_unrated_server       *metrics.Counter
+		reject_player_not_found     *metrics.Counter
+		reject_player_not_on_server *metrics.Counter
+		fail_storage_error_account  *metrics.Counter
+		fail_storage_error_rating   *metrics.Counter
+		fail_storage_error_match    *metrics.Counter
+		fail_other_error            *metrics.Counter
+		http_method_not_allowed     *metrics.Counter
+	}
+	server_chat_requests_total struct {
+		success                 *metrics.Counter
+		reject_disabled         *metrics.Counter
+		reject_bad_request      *metrics.Counter
+		reject_server_not_found *metrics.Counter
+		reject_unauthorized_ip  *metrics.Counter
+		fail_other_error        *metrics.Counter
+		http_method_not_allowed *metrics.Counter
+	}
+	server_chat_messages_total struct {
+		success                     *metrics.Counter
+		reject_bad_request          *metrics.Counter
+		reject_muted                *metrics.Counter
+		reject_player_not_found     *metrics.Counter
+		reject_player_not_on_server *metrics.Counter
+		fail_storage_error_account  *metrics.Counter
+		fail_storage_error_state    *metrics.Counter
+		fail_storage_error_mute     *metrics.Counter
+		fail_storage_error_blocks   *metrics.Counter
+		dropped_slow_consumer       *metrics.Counter
+	}
+	relay_stream_updates_total  *metrics.Counter
+	relay_stream_requests_total struct {
+		success                  *metrics.Counter
+		reject_bad_request       *metrics.Counter
+		reject_unauthorized      *metrics.Counter
+		reject_client_cert       *metrics.Counter
+		reject_already_connected *metrics.Counter
+		fail_storage_error_state *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
+	server_players_requests_total struct {
+		success                    *metrics.Counter
+		reject_bad_request         *metrics.Counter
+		reject_server_not_found    *metrics.Counter
+		reject_unauthorized_ip     *metrics.Counter
+		fail_storage_error_account *metrics.Counter
+		fail_other_error           *metrics.Counter
+		http_method_not_allowed    *metrics.Counter
+	}
+	server_players_keepalives_total *metrics.Counter
+	server_players_rejected_total   *metrics.Counter
+	server_mute_requests_total      struct {
+		success                     *metrics.Counter
+		success_delete              *metrics.Counter
+		reject_disabled             *metrics.Counter
+		reject_bad_request          *metrics.Counter
+		reject_server_not_found     *metrics.Counter
+		reject_unauthorized_ip      *metrics.Counter
+		reject_player_not_found     *metrics.Counter
+		reject_player_not_on_server *metrics.Counter
+		fail_storage_error_account  *metrics.Counter
+		fail_storage_error_mute     *metrics.Counter
+		fail_other_error            *metrics.Counter
+		http_method_not_allowed     *metrics.Counter
+	}
+	server_mutestatus_requests_total struct {
+		success                 *metrics.Counter
+		reject_disabled         *metrics.Counter
+		reject_bad_request      *metrics.Counter
+		reject_server_not_found *metrics.Counter
+		reject_unauthorized_ip  *metrics.Counter
+		fail_storage_error_mute *metrics.Counter
+		fail_other_error        *metrics.Counter
+		http_method_not_allowed *metrics.Counter
+	}
+	server_crash_requests_total struct {
+		success                  *metrics.Counter
+		reject_disabled          *metrics.Counter
+		reject_bad_request       *metrics.Counter
+		reject_server_not_found  *metrics.Counter
+		reject_unauthorized_ip   *metrics.Counter
+		reject_rate_limited      *metrics.Counter
+		fail_storage_error_crash *metrics.Counter
+		fail_other_error         *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
+	server_report_requests_total struct {
+		success                     *metrics.Counter
+		success_duplicate           *metrics.Counter
+		reject_disabled             *metrics.Counter
+		reject_bad_request          *metrics.Counter
+		reject_server_not_found     *metrics.Counter
+		reject_unauthorized_ip      *metrics.Counter
+		reject_player_not_found     *metrics.Counter
+		reject_player_not_on_server *metrics.Counter
+		reject_rate_limited         *metrics.Counter
+		reject_quota                *metrics.Counter
+		fail_storage_error_account  *metrics.Counter
+		fail_storage_error_report   *metrics.Counter
+		fail_other_error            *metrics.Counter
+		http_method_not_allowed     *metrics.Counter
+	}
 	server_connect_requests_total struct {
 		success                         *metrics.Counter
 		success_reject                  *metrics.Counter
 		success_pdata                   *metrics.Counter
+		success_pdata_not_modified      *metrics.Counter
 		reject_unauthorized_ip          *metrics.Counter
 		reject_server_not_found         *metrics.Counter
 		reject_invalid_connection_token *metrics.Counter
@@ -174,6 +766,20 @@ type apiMetrics struct {
 		fail_other_error                *metrics.Counter
 		http_method_not_allowed         *metrics.Counter
 	}
+	player_ratings_requests_total struct {
+		success                   *metrics.Counter
+		reject_disabled           *metrics.Counter
+		reject_bad_request        *metrics.Counter
+		fail_storage_error_rating *metrics.Counter
+		http_method_not_allowed   *metrics.Counter
+	}
+	player_matches_requests_total struct {
+		success                  *metrics.Counter
+		reject_disabled          *metrics.Counter
+		reject_bad_request       *metrics.Counter
+		fail_storage_error_match *metrics.Counter
+		http_method_not_allowed  *metrics.Counter
+	}
 	player_pdata_requests_total struct {
 		success                  func(filter string) *metrics.Counter
 		reject_bad_request       *metrics.Counter
@@ -191,6 +797,9 @@ func (h *Handler) Metrics() *metrics.Set {
 
 func (h *Handler) WritePrometheus(w io.Writer) {
 	h.m().set.WritePrometheus(w)
+	if q := h.serverWebhookQueue(); q != nil {
+		q.WritePrometheus(w)
+	}
 }
 
 func (h *Handler) WritePrometheusGeo(w io.Writer) {
@@ -212,20 +821,219 @@ func (h *Handler) m() *apiMetrics {
 		mo := &h.metricsObj
 		mo.set = metrics.NewSet()
 		mo.request_panics_total = mo.set.NewCounter(`atlas_api0_request_panics_total`)
+		mo.api_version_requests_total.unversioned = mo.set.NewCounter(`atlas_api0_api_version_requests_total{version="unversioned"}`)
+		mo.api_version_requests_total.v1 = mo.set.NewCounter(`atlas_api0_api_version_requests_total{version="v1"}`)
+		mo.api_version_requests_total.v2 = mo.set.NewCounter(`atlas_api0_api_version_requests_total{version="v2"}`)
+		mo.conditional_requests_total.modified = mo.set.NewCounter(`atlas_api0_conditional_requests_total{result="modified"}`)
+		mo.conditional_requests_total.not_modified = mo.set.NewCounter(`atlas_api0_conditional_requests_total{result="not_modified"}`)
+		mo.cors_requests_total.success = mo.set.NewCounter(`atlas_api0_cors_requests_total{result="success"}`)
+		mo.cors_requests_total.preflight = mo.set.NewCounter(`atlas_api0_cors_requests_total{result="preflight"}`)
+		mo.cors_requests_total.reject_disallowed = mo.set.NewCounter(`atlas_api0_cors_requests_total{result="reject_disallowed"}`)
+		mo.cors_requests_total.reject_admin = mo.set.NewCounter(`atlas_api0_cors_requests_total{result="reject_admin"}`)
 		mo.versiongate_checks_total.success_ok = mo.set.NewCounter(`atlas_api0_versiongate_checks_total{result="success_ok"}`)
 		mo.versiongate_checks_total.success_dev = mo.set.NewCounter(`atlas_api0_versiongate_checks_total{result="success_dev"}`)
 		mo.versiongate_checks_total.reject_old = mo.set.NewCounter(`atlas_api0_versiongate_checks_total{result="reject_old"}`)
 		mo.versiongate_checks_total.reject_invalid = mo.set.NewCounter(`atlas_api0_versiongate_checks_total{result="reject_invalid"}`)
+		mo.versiongate_checks_total.reject_blocked = mo.set.NewCounter(`atlas_api0_versiongate_checks_total{result="reject_blocked"}`)
 		mo.versiongate_checks_total.reject_notns = mo.set.NewCounter(`atlas_api0_versiongate_checks_total{result="reject_notns"}`)
+		mo.idempotency_requests_total.success_new = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_idempotency_requests_total{result="success_new",endpoint="` + endpoint + `"}`)
+		}
+		mo.idempotency_requests_total.success_replay = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_idempotency_requests_total{result="success_replay",endpoint="` + endpoint + `"}`)
+		}
+		mo.idempotency_requests_total.bypass = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_idempotency_requests_total{result="bypass",endpoint="` + endpoint + `"}`)
+		}
+		mo.idempotency_requests_total.reject_bad_request = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_idempotency_requests_total{result="reject_bad_request",endpoint="` + endpoint + `"}`)
+		}
+		mo.idempotency_requests_total.reject_reused = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_idempotency_requests_total{result="reject_reused",endpoint="` + endpoint + `"}`)
+		}
+		mo.anomaly_checks_total.tarpit = mo.set.NewCounter(`atlas_api0_anomaly_checks_total{result="tarpit"}`)
+		mo.anomaly_checks_total.reject_block = mo.set.NewCounter(`atlas_api0_anomaly_checks_total{result="reject_block"}`)
+		mo.mirror_checks_total.success = mo.set.NewCounter(`atlas_api0_mirror_checks_total{result="success"}`)
+		mo.mirror_checks_total.fail_http_error = mo.set.NewCounter(`atlas_api0_mirror_checks_total{result="fail_http_error"}`)
+		mo.mirror_checks_total.fail_checksum_mismatch = mo.set.NewCounter(`atlas_api0_mirror_checks_total{result="fail_checksum_mismatch"}`)
+		mo.anomaly_penalties_total.tarpit = func(scope string) *metrics.Counter {
+			if scope == "" {
+				panic("invalid scope")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_anomaly_penalties_total{result="tarpit",scope="` + scope + `"}`)
+		}
+		mo.anomaly_penalties_total.block = func(scope string) *metrics.Counter {
+			if scope == "" {
+				panic("invalid scope")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_anomaly_penalties_total{result="block",scope="` + scope + `"}`)
+		}
+		mo.honeypot_hits_total.path = mo.set.NewCounter(`atlas_api0_honeypot_hits_total{kind="path"}`)
+		mo.honeypot_hits_total.param = mo.set.NewCounter(`atlas_api0_honeypot_hits_total{kind="param"}`)
+		mo.retention_runs_total.success = mo.set.NewCounter(`atlas_api0_retention_runs_total{result="success"}`)
+		mo.retention_runs_total.fail_error = mo.set.NewCounter(`atlas_api0_retention_runs_total{result="fail_error"}`)
+		mo.retention_deleted_total.sessions = mo.set.NewCounter(`atlas_api0_retention_deleted_total{job="sessions"}`)
+		mo.retention_deleted_total.accounts = mo.set.NewCounter(`atlas_api0_retention_deleted_total{job="accounts"}`)
+		mo.retention_deleted_total.heartbeats = mo.set.NewCounter(`atlas_api0_retention_deleted_total{job="heartbeats"}`)
+		mo.retention_deleted_total.server_stats = mo.set.NewCounter(`atlas_api0_retention_deleted_total{job="server_stats"}`)
+		mo.retention_reclaimed_bytes_total = mo.set.NewCounter(`atlas_api0_retention_reclaimed_bytes_total`)
+		mo.honeypot_checks_total.reject_rate_limited = mo.set.NewCounter(`atlas_api0_honeypot_checks_total{result="reject_rate_limited"}`)
+		mo.challenge_checks_total.success_clearance = mo.set.NewCounter(`atlas_api0_challenge_checks_total{result="success_clearance"}`)
+		mo.challenge_checks_total.reject_challenge = mo.set.NewCounter(`atlas_api0_challenge_checks_total{result="reject_challenge"}`)
+		mo.client_challenge_requests_total.success_challenge = mo.set.NewCounter(`atlas_api0_client_challenge_requests_total{result="success_challenge"}`)
+		mo.client_challenge_requests_total.success_clearance = mo.set.NewCounter(`atlas_api0_client_challenge_requests_total{result="success_clearance"}`)
+		mo.client_challenge_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_challenge_requests_total{result="reject_bad_request"}`)
+		mo.client_challenge_requests_total.reject_invalid_challenge = mo.set.NewCounter(`atlas_api0_client_challenge_requests_total{result="reject_invalid_challenge"}`)
+		mo.client_challenge_requests_total.reject_invalid_solution = mo.set.NewCounter(`atlas_api0_client_challenge_requests_total{result="reject_invalid_solution"}`)
+		mo.client_challenge_requests_total.reject_reused_challenge = mo.set.NewCounter(`atlas_api0_client_challenge_requests_total{result="reject_reused_challenge"}`)
+		mo.client_challenge_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_client_challenge_requests_total{result="fail_other_error"}`)
+		mo.client_challenge_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_challenge_requests_total{result="http_method_not_allowed"}`)
+		mo.captcha_checks_total.success_clearance = mo.set.NewCounter(`atlas_api0_captcha_checks_total{result="success_clearance"}`)
+		mo.captcha_checks_total.reject_captcha = mo.set.NewCounter(`atlas_api0_captcha_checks_total{result="reject_captcha"}`)
+		mo.client_captcha_requests_total.success_captcha = mo.set.NewCounter(`atlas_api0_client_captcha_requests_total{result="success_captcha"}`)
+		mo.client_captcha_requests_total.success_clearance = mo.set.NewCounter(`atlas_api0_client_captcha_requests_total{result="success_clearance"}`)
+		mo.client_captcha_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_client_captcha_requests_total{result="reject_disabled"}`)
+		mo.client_captcha_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_captcha_requests_total{result="reject_bad_request"}`)
+		mo.client_captcha_requests_total.reject_invalid_response = mo.set.NewCounter(`atlas_api0_client_captcha_requests_total{result="reject_invalid_response"}`)
+		mo.client_captcha_requests_total.fail_provider_error = mo.set.NewCounter(`atlas_api0_client_captcha_requests_total{result="fail_provider_error"}`)
+		mo.client_captcha_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_client_captcha_requests_total{result="fail_other_error"}`)
+		mo.client_captcha_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_captcha_requests_total{result="http_method_not_allowed"}`)
+		mo.player_quota_rejections_total.reject = func(quota string) *metrics.Counter {
+			if quota == "" {
+				panic("invalid quota")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_player_quota_rejections_total{result="reject",quota="` + quota + `"}`)
+		}
+		mo.admin_accounts_backfill_total.success = mo.set.NewCounter(`atlas_api0_admin_accounts_backfill_total{result="success"}`)
+		mo.admin_accounts_backfill_total.notfound = mo.set.NewCounter(`atlas_api0_admin_accounts_backfill_total{result="notfound"}`)
+		mo.admin_accounts_backfill_total.fail_origin_error = mo.set.NewCounter(`atlas_api0_admin_accounts_backfill_total{result="fail_origin_error"}`)
+		mo.feature_checks_total.enabled = func(feature string) *metrics.Counter {
+			if feature == "" {
+				panic("invalid feature")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_feature_checks_total{result="enabled",feature="` + feature + `"}`)
+		}
+		mo.feature_checks_total.disabled = func(feature string) *metrics.Counter {
+			if feature == "" {
+				panic("invalid feature")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_feature_checks_total{result="disabled",feature="` + feature + `"}`)
+		}
+		mo.admin_requests_total.success = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="success",endpoint="` + endpoint + `"}`)
+		}
+		mo.admin_requests_total.reject_disabled = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="reject_disabled",endpoint="` + endpoint + `"}`)
+		}
+		mo.admin_requests_total.reject_unauthorized = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="reject_unauthorized",endpoint="` + endpoint + `"}`)
+		}
+		mo.admin_requests_total.reject_client_cert = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="reject_client_cert",endpoint="` + endpoint + `"}`)
+		}
+		mo.admin_requests_total.reject_bad_request = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="reject_bad_request",endpoint="` + endpoint + `"}`)
+		}
+		mo.admin_requests_total.fail_storage_error_state = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="fail_storage_error_state",endpoint="` + endpoint + `"}`)
+		}
+		mo.admin_requests_total.fail_storage_error_erase = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="fail_storage_error_erase",endpoint="` + endpoint + `"}`)
+		}
+		mo.admin_requests_total.fail_storage_error_account = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="fail_storage_error_account",endpoint="` + endpoint + `"}`)
+		}
+		mo.admin_requests_total.fail_storage_error_report = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="fail_storage_error_report",endpoint="` + endpoint + `"}`)
+		}
+		mo.admin_requests_total.fail_storage_error_crash = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="fail_storage_error_crash",endpoint="` + endpoint + `"}`)
+		}
+		mo.admin_requests_total.fail_other_error = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="fail_other_error",endpoint="` + endpoint + `"}`)
+		}
+		mo.admin_requests_total.fail_reload_error = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="fail_reload_error",endpoint="` + endpoint + `"}`)
+		}
+		mo.admin_requests_total.http_method_not_allowed = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_admin_requests_total{result="http_method_not_allowed",endpoint="` + endpoint + `"}`)
+		}
 		mo.accounts_writepersistence_extradata_size_bytes = mo.set.NewHistogram(`atlas_api0_accounts_writepersistence_extradata_size_bytes`)
 		mo.accounts_writepersistence_stored_size_bytes = mo.set.NewHistogram(`atlas_api0_accounts_writepersistence_stored_size_bytes`)
+		mo.accounts_writepersistence_pdata_rule_violations_total.flag = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_pdata_rule_violations_total{result="flag"}`)
+		mo.accounts_writepersistence_pdata_rule_violations_total.reject = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_pdata_rule_violations_total{result="reject"}`)
 		mo.accounts_writepersistence_requests_total.success = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="success"}`)
+		mo.accounts_writepersistence_requests_total.success_patch = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="success_patch"}`)
+		mo.accounts_writepersistence_requests_total.success_guest = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="success_guest"}`)
 		mo.accounts_writepersistence_requests_total.reject_too_much_extradata = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_too_much_extradata"}`)
 		mo.accounts_writepersistence_requests_total.reject_too_large = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_too_large"}`)
 		mo.accounts_writepersistence_requests_total.reject_invalid_pdata = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_invalid_pdata"}`)
+		mo.accounts_writepersistence_requests_total.reject_invalid_patch = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_invalid_patch"}`)
+		mo.accounts_writepersistence_requests_total.reject_precondition_failed = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_precondition_failed"}`)
 		mo.accounts_writepersistence_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_bad_request"}`)
 		mo.accounts_writepersistence_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_player_not_found"}`)
 		mo.accounts_writepersistence_requests_total.reject_unauthorized = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_unauthorized"}`)
+		mo.accounts_writepersistence_requests_total.reject_erased = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_erased"}`)
+		mo.accounts_writepersistence_requests_total.reject_quota_player = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_quota_player"}`)
+		mo.accounts_writepersistence_requests_total.reject_quota_player_hourly = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_quota_player_hourly"}`)
+		mo.accounts_writepersistence_requests_total.reject_pdata_rule = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_pdata_rule"}`)
+		mo.accounts_writepersistence_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_disabled"}`)
+		mo.accounts_writepersistence_requests_total.reject_hook = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_hook"}`)
+		mo.accounts_writepersistence_requests_total.reject_quota_server = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="reject_quota_server"}`)
 		mo.accounts_writepersistence_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="fail_storage_error_account"}`)
 		mo.accounts_writepersistence_requests_total.fail_storage_error_pdata = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="fail_storage_error_pdata"}`)
 		mo.accounts_writepersistence_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_accounts_writepersistence_requests_total{result="fail_other_error"}`)
@@ -242,6 +1050,117 @@ func (h *Handler) m() *apiMetrics {
 		mo.accounts_getusername_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_accounts_getusername_requests_total{result="reject_player_not_found"}`)
 		mo.accounts_getusername_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_accounts_getusername_requests_total{result="fail_storage_error_account"}`)
 		mo.accounts_getusername_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_accounts_getusername_requests_total{result="http_method_not_allowed"}`)
+		mo.accounts_link_requests_total.success = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="success",provider="` + provider + `"}`)
+		}
+		mo.accounts_link_requests_total.success_redirect = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="success_redirect",provider="` + provider + `"}`)
+		}
+		mo.accounts_link_requests_total.reject_disabled = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="reject_disabled",provider="` + provider + `"}`)
+		}
+		mo.accounts_link_requests_total.reject_bad_request = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="reject_bad_request",provider="` + provider + `"}`)
+		}
+		mo.accounts_link_requests_total.reject_player_not_found = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="reject_player_not_found",provider="` + provider + `"}`)
+		}
+		mo.accounts_link_requests_total.reject_masterserver_token = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="reject_masterserver_token",provider="` + provider + `"}`)
+		}
+		mo.accounts_link_requests_total.reject_invalid_state = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="reject_invalid_state",provider="` + provider + `"}`)
+		}
+		mo.accounts_link_requests_total.reject_invalid_link = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="reject_invalid_link",provider="` + provider + `"}`)
+		}
+		mo.accounts_link_requests_total.reject_erased = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="reject_erased",provider="` + provider + `"}`)
+		}
+		mo.accounts_link_requests_total.fail_provider_error = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="fail_provider_error",provider="` + provider + `"}`)
+		}
+		mo.accounts_link_requests_total.fail_storage_error_account = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="fail_storage_error_account",provider="` + provider + `"}`)
+		}
+		mo.accounts_link_requests_total.fail_storage_error_link = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="fail_storage_error_link",provider="` + provider + `"}`)
+		}
+		mo.accounts_link_requests_total.http_method_not_allowed = func(provider string) *metrics.Counter {
+			if provider == "" {
+				panic("invalid provider")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_accounts_link_requests_total{result="http_method_not_allowed",provider="` + provider + `"}`)
+		}
+		mo.accounts_unlink_requests_total.success = mo.set.NewCounter(`atlas_api0_accounts_unlink_requests_total{result="success"}`)
+		mo.accounts_unlink_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_accounts_unlink_requests_total{result="reject_bad_request"}`)
+		mo.accounts_unlink_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_accounts_unlink_requests_total{result="reject_player_not_found"}`)
+		mo.accounts_unlink_requests_total.reject_masterserver_token = mo.set.NewCounter(`atlas_api0_accounts_unlink_requests_total{result="reject_masterserver_token"}`)
+		mo.accounts_unlink_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_accounts_unlink_requests_total{result="fail_storage_error_account"}`)
+		mo.accounts_unlink_requests_total.fail_storage_error_link = mo.set.NewCounter(`atlas_api0_accounts_unlink_requests_total{result="fail_storage_error_link"}`)
+		mo.accounts_unlink_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_accounts_unlink_requests_total{result="http_method_not_allowed"}`)
+		mo.accounts_deletedata_requests_total.success = mo.set.NewCounter(`atlas_api0_accounts_deletedata_requests_total{result="success"}`)
+		mo.accounts_deletedata_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_accounts_deletedata_requests_total{result="reject_bad_request"}`)
+		mo.accounts_deletedata_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_accounts_deletedata_requests_total{result="reject_player_not_found"}`)
+		mo.accounts_deletedata_requests_total.reject_masterserver_token = mo.set.NewCounter(`atlas_api0_accounts_deletedata_requests_total{result="reject_masterserver_token"}`)
+		mo.accounts_deletedata_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_accounts_deletedata_requests_total{result="fail_storage_error_account"}`)
+		mo.accounts_deletedata_requests_total.fail_storage_error_erase = mo.set.NewCounter(`atlas_api0_accounts_deletedata_requests_total{result="fail_storage_error_erase"}`)
+		mo.accounts_deletedata_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_accounts_deletedata_requests_total{result="http_method_not_allowed"}`)
+		mo.accounts_exportdata_requests_total.success = mo.set.NewCounter(`atlas_api0_accounts_exportdata_requests_total{result="success"}`)
+		mo.accounts_exportdata_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_accounts_exportdata_requests_total{result="reject_bad_request"}`)
+		mo.accounts_exportdata_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_accounts_exportdata_requests_total{result="reject_player_not_found"}`)
+		mo.accounts_exportdata_requests_total.reject_masterserver_token = mo.set.NewCounter(`atlas_api0_accounts_exportdata_requests_total{result="reject_masterserver_token"}`)
+		mo.accounts_exportdata_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_accounts_exportdata_requests_total{result="fail_storage_error_account"}`)
+		mo.accounts_exportdata_requests_total.fail_storage_error_pdata = mo.set.NewCounter(`atlas_api0_accounts_exportdata_requests_total{result="fail_storage_error_pdata"}`)
+		mo.accounts_exportdata_requests_total.fail_storage_error_link = mo.set.NewCounter(`atlas_api0_accounts_exportdata_requests_total{result="fail_storage_error_link"}`)
+		mo.accounts_exportdata_requests_total.fail_storage_error_namehistory = mo.set.NewCounter(`atlas_api0_accounts_exportdata_requests_total{result="fail_storage_error_namehistory"}`)
+		mo.accounts_exportdata_requests_total.fail_storage_error_signal = mo.set.NewCounter(`atlas_api0_accounts_exportdata_requests_total{result="fail_storage_error_signal"}`)
+		mo.accounts_exportdata_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_accounts_exportdata_requests_total{result="fail_other_error"}`)
+		mo.accounts_exportdata_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_accounts_exportdata_requests_total{result="http_method_not_allowed"}`)
+		mo.accounts_getlinks_requests_total.success = mo.set.NewCounter(`atlas_api0_accounts_getlinks_requests_total{result="success"}`)
+		mo.accounts_getlinks_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_accounts_getlinks_requests_total{result="reject_bad_request"}`)
+		mo.accounts_getlinks_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_accounts_getlinks_requests_total{result="reject_player_not_found"}`)
+		mo.accounts_getlinks_requests_total.reject_unauthorized = mo.set.NewCounter(`atlas_api0_accounts_getlinks_requests_total{result="reject_unauthorized"}`)
+		mo.accounts_getlinks_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_accounts_getlinks_requests_total{result="fail_storage_error_account"}`)
+		mo.accounts_getlinks_requests_total.fail_storage_error_link = mo.set.NewCounter(`atlas_api0_accounts_getlinks_requests_total{result="fail_storage_error_link"}`)
+		mo.accounts_getlinks_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_accounts_getlinks_requests_total{result="fail_other_error"}`)
+		mo.accounts_getlinks_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_accounts_getlinks_requests_total{result="http_method_not_allowed"}`)
 		mo.client_mainmenupromos_requests_total.success = func(launcher_version string) *metrics.Counter {
 			if launcher_version == "" {
 				launcher_version = "unknown"
@@ -251,15 +1170,65 @@ func (h *Handler) m() *apiMetrics {
 		mo.client_mainmenupromos_requests_total.success("unknown")
 		mo.client_mainmenupromos_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_servers_response_size_bytes{result="http_method_not_allowed"}`)
 		mo.client_mainmenupromos_requests_map = metricsx.NewGeoCounter2(`atlas_api0_client_mainmenupromos_requests_map`)
+		mo.client_motd_requests_total.success = func(launcher_version string) *metrics.Counter {
+			if launcher_version == "" {
+				launcher_version = "unknown"
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_client_motd_requests_total{result="success",launcher_version="` + launcher_version + `"}`)
+		}
+		mo.client_motd_requests_total.success("unknown")
+		mo.client_motd_requests_total.fail_storage_error_state = mo.set.NewCounter(`atlas_api0_client_motd_requests_total{result="fail_storage_error_state"}`)
+		mo.client_motd_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_motd_requests_total{result="http_method_not_allowed"}`)
+		mo.client_serverattestationkey_requests_total.success = mo.set.NewCounter(`atlas_api0_client_serverattestationkey_requests_total{result="success"}`)
+		mo.client_serverattestationkey_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_serverattestationkey_requests_total{result="http_method_not_allowed"}`)
+		mo.jwks_requests_total.success = mo.set.NewCounter(`atlas_api0_jwks_requests_total{result="success"}`)
+		mo.jwks_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_jwks_requests_total{result="http_method_not_allowed"}`)
+		mo.client_updatemanifest_requests_total.success = mo.set.NewCounter(`atlas_api0_client_updatemanifest_requests_total{result="success"}`)
+		mo.client_updatemanifest_requests_total.fail_storage_error_state = mo.set.NewCounter(`atlas_api0_client_updatemanifest_requests_total{result="fail_storage_error_state"}`)
+		mo.client_updatemanifest_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_updatemanifest_requests_total{result="http_method_not_allowed"}`)
+		mo.client_mods_requests_total.success = mo.set.NewCounter(`atlas_api0_client_mods_requests_total{result="success"}`)
+		mo.client_mods_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_mods_requests_total{result="reject_bad_request"}`)
+		mo.client_mods_requests_total.fail_storage_error_state = mo.set.NewCounter(`atlas_api0_client_mods_requests_total{result="fail_storage_error_state"}`)
+		mo.client_mods_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_mods_requests_total{result="http_method_not_allowed"}`)
+		mo.client_servermods_requests_total.success = mo.set.NewCounter(`atlas_api0_client_servermods_requests_total{result="success"}`)
+		mo.client_servermods_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_servermods_requests_total{result="reject_bad_request"}`)
+		mo.client_servermods_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_client_servermods_requests_total{result="reject_server_not_found"}`)
+		mo.client_servermods_requests_total.fail_storage_error_state = mo.set.NewCounter(`atlas_api0_client_servermods_requests_total{result="fail_storage_error_state"}`)
+		mo.client_servermods_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_servermods_requests_total{result="http_method_not_allowed"}`)
+		mo.client_relays_requests_total.success = mo.set.NewCounter(`atlas_api0_client_relays_requests_total{result="success"}`)
+		mo.client_relays_requests_total.fail_storage_error_state = mo.set.NewCounter(`atlas_api0_client_relays_requests_total{result="fail_storage_error_state"}`)
+		mo.client_relays_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_relays_requests_total{result="http_method_not_allowed"}`)
+		mo.client_guestauth_requests_total.success = mo.set.NewCounter(`atlas_api0_client_guestauth_requests_total{result="success"}`)
+		mo.client_guestauth_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_client_guestauth_requests_total{result="reject_disabled"}`)
+		mo.client_guestauth_requests_total.reject_versiongate = mo.set.NewCounter(`atlas_api0_client_guestauth_requests_total{result="reject_versiongate"}`)
+		mo.client_guestauth_requests_total.reject_network = mo.set.NewCounter(`atlas_api0_client_guestauth_requests_total{result="reject_network"}`)
+		mo.client_guestauth_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_guestauth_requests_total{result="reject_bad_request"}`)
+		mo.client_guestauth_requests_total.reject_rate_limited = mo.set.NewCounter(`atlas_api0_client_guestauth_requests_total{result="reject_rate_limited"}`)
+		mo.client_guestauth_requests_total.reject_hook = mo.set.NewCounter(`atlas_api0_client_guestauth_requests_total{result="reject_hook"}`)
+		mo.client_guestauth_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_client_guestauth_requests_total{result="fail_storage_error_account"}`)
+		mo.client_guestauth_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_client_guestauth_requests_total{result="fail_other_error"}`)
+		mo.client_guestauth_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_guestauth_requests_total{result="http_method_not_allowed"}`)
 		mo.client_originauth_requests_total.success = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="success"}`)
+		mo.client_originauth_requests_total.success_stale_verified = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="success_stale_verified"}`)
 		mo.client_originauth_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_bad_request"}`)
 		mo.client_originauth_requests_total.reject_versiongate = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_versiongate"}`)
 		mo.client_originauth_requests_total.reject_stryder_invalidgame = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_stryder_invalidgame"}`)
 		mo.client_originauth_requests_total.reject_stryder_invalidtoken = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_stryder_invalidtoken"}`)
 		mo.client_originauth_requests_total.reject_stryder_mpnotallowed = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_stryder_mpnotallowed"}`)
 		mo.client_originauth_requests_total.reject_stryder_other = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_stryder_other"}`)
+		mo.client_originauth_requests_total.reject_session_policy = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_session_policy"}`)
+		mo.client_originauth_requests_total.reject_token_replay = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_token_replay"}`)
+		mo.client_originauth_requests_total.reject_quota = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_quota"}`)
+		mo.client_originauth_requests_total.reject_erased = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_erased"}`)
+		mo.client_originauth_requests_total.reject_stale_verified = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_stale_verified"}`)
+		mo.client_originauth_requests_total.reject_ip_reputation = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_ip_reputation"}`)
+		mo.client_originauth_requests_total.reject_network_rule = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_network_rule"}`)
+		mo.client_originauth_requests_total.reject_hook = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_hook"}`)
+		mo.client_originauth_requests_total.reject_anomaly = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="reject_anomaly"}`)
 		mo.client_originauth_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="fail_storage_error_account"}`)
+		mo.client_originauth_requests_total.fail_storage_error_link = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="fail_storage_error_link"}`)
 		mo.client_originauth_requests_total.fail_stryder_error = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="fail_stryder_error"}`)
+		mo.client_originauth_requests_total.fail_origin_unavailable = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="fail_origin_unavailable"}`)
 		mo.client_originauth_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="fail_other_error"}`)
 		mo.client_originauth_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_originauth_requests_total{result="http_method_not_allowed"}`)
 		mo.client_originauth_requests_map = metricsx.NewGeoCounter2(`atlas_api0_client_originauth_requests_map`)
@@ -279,9 +1248,15 @@ func (h *Handler) m() *apiMetrics {
 		mo.client_authwithserver_requests_total.reject_versiongate = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="reject_versiongate"}`)
 		mo.client_authwithserver_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="reject_player_not_found"}`)
 		mo.client_authwithserver_requests_total.reject_masterserver_token = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="reject_masterserver_token"}`)
+		mo.client_authwithserver_requests_total.reject_quota = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="reject_quota"}`)
+		mo.client_authwithserver_requests_total.reject_anomaly = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="reject_anomaly"}`)
 		mo.client_authwithserver_requests_total.reject_password = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="reject_password"}`)
+		mo.client_authwithserver_requests_total.reject_duplicate_session = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="reject_duplicate_session"}`)
+		mo.client_authwithserver_requests_total.reject_join_token = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="reject_join_token"}`)
+		mo.client_authwithserver_requests_total.reject_allowlist = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="reject_allowlist"}`)
 		mo.client_authwithserver_requests_total.reject_gameserverauth = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="reject_gameserverauth"}`)
 		mo.client_authwithserver_requests_total.reject_gameserver = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="reject_gameserver"}`)
+		mo.client_authwithserver_requests_total.reject_erased = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="reject_erased"}`)
 		mo.client_authwithserver_requests_total.fail_gameserverauth = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="fail_gameserverauth"}`)
 		mo.client_authwithserver_requests_total.fail_gameserverauthudp = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="fail_gameserverauthudp"}`)
 		mo.client_authwithserver_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_client_authwithserver_requests_total{result="fail_storage_error_account"}`)
@@ -291,15 +1266,109 @@ func (h *Handler) m() *apiMetrics {
 		mo.client_authwithserver_gameserverauth_duration_seconds = mo.set.NewHistogram(`atlas_api0_client_authwithserver_gameserverauth_duration_seconds`)
 		mo.client_authwithserver_gameserverauthudp_duration_seconds = mo.set.NewHistogram(`atlas_api0_client_authwithserver_gameserverauthudp_duration_seconds`)
 		mo.client_authwithserver_gameserverauthudp_attempts = mo.set.NewHistogram(`atlas_api0_client_authwithserver_gameserverauthudp_attempts`)
+		mo.client_authwithserver_preauth_total = mo.set.NewCounter(`atlas_api0_client_authwithserver_preauth_total`)
+		mo.client_jointoken_requests_total.success = mo.set.NewCounter(`atlas_api0_client_jointoken_requests_total{result="success"}`)
+		mo.client_jointoken_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_jointoken_requests_total{result="reject_bad_request"}`)
+		mo.client_jointoken_requests_total.reject_versiongate = mo.set.NewCounter(`atlas_api0_client_jointoken_requests_total{result="reject_versiongate"}`)
+		mo.client_jointoken_requests_total.reject_anomaly = mo.set.NewCounter(`atlas_api0_client_jointoken_requests_total{result="reject_anomaly"}`)
+		mo.client_jointoken_requests_total.reject_password = mo.set.NewCounter(`atlas_api0_client_jointoken_requests_total{result="reject_password"}`)
+		mo.client_jointoken_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_client_jointoken_requests_total{result="reject_player_not_found"}`)
+		mo.client_jointoken_requests_total.reject_masterserver_token = mo.set.NewCounter(`atlas_api0_client_jointoken_requests_total{result="reject_masterserver_token"}`)
+		mo.client_jointoken_requests_total.reject_allowlist = mo.set.NewCounter(`atlas_api0_client_jointoken_requests_total{result="reject_allowlist"}`)
+		mo.client_jointoken_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_client_jointoken_requests_total{result="fail_storage_error_account"}`)
+		mo.client_jointoken_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_client_jointoken_requests_total{result="fail_other_error"}`)
+		mo.client_jointoken_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_jointoken_requests_total{result="http_method_not_allowed"}`)
+		mo.client_jointoken_redeem_total.success = mo.set.NewCounter(`atlas_api0_client_jointoken_redeem_total{result="success"}`)
+		mo.client_jointoken_redeem_total.reject_not_found = mo.set.NewCounter(`atlas_api0_client_jointoken_redeem_total{result="reject_not_found"}`)
+		mo.client_jointoken_redeem_total.reject_expired = mo.set.NewCounter(`atlas_api0_client_jointoken_redeem_total{result="reject_expired"}`)
+		mo.client_jointoken_redeem_total.reject_used = mo.set.NewCounter(`atlas_api0_client_jointoken_redeem_total{result="reject_used"}`)
+		mo.client_jointoken_redeem_total.reject_mismatch = mo.set.NewCounter(`atlas_api0_client_jointoken_redeem_total{result="reject_mismatch"}`)
+		mo.client_jointoken_joins_total.success = mo.set.NewCounter(`atlas_api0_client_jointoken_joins_total{result="success"}`)
+		mo.client_jointoken_joins_total.fail = mo.set.NewCounter(`atlas_api0_client_jointoken_joins_total{result="fail"}`)
 		mo.client_authwithself_requests_total.success = mo.set.NewCounter(`atlas_api0_client_authwithself_requests_total{result="success"}`)
 		mo.client_authwithself_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_authwithself_requests_total{result="reject_bad_request"}`)
 		mo.client_authwithself_requests_total.reject_versiongate = mo.set.NewCounter(`atlas_api0_client_authwithself_requests_total{result="reject_versiongate"}`)
 		mo.client_authwithself_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_client_authwithself_requests_total{result="reject_player_not_found"}`)
 		mo.client_authwithself_requests_total.reject_masterserver_token = mo.set.NewCounter(`atlas_api0_client_authwithself_requests_total{result="reject_masterserver_token"}`)
+		mo.client_authwithself_requests_total.reject_quota = mo.set.NewCounter(`atlas_api0_client_authwithself_requests_total{result="reject_quota"}`)
+		mo.client_authwithself_requests_total.reject_erased = mo.set.NewCounter(`atlas_api0_client_authwithself_requests_total{result="reject_erased"}`)
 		mo.client_authwithself_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_client_authwithself_requests_total{result="fail_storage_error_account"}`)
 		mo.client_authwithself_requests_total.fail_storage_error_pdata = mo.set.NewCounter(`atlas_api0_client_authwithself_requests_total{result="fail_storage_error_pdata"}`)
 		mo.client_authwithself_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_client_authwithself_requests_total{result="fail_other_error"}`)
 		mo.client_authwithself_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_authwithself_requests_total{result="http_method_not_allowed"}`)
+		mo.cache_lookups_total.username_hit = mo.set.NewCounter(`atlas_api0_cache_lookups_total{type="username",result="hit"}`)
+		mo.cache_lookups_total.username_miss = mo.set.NewCounter(`atlas_api0_cache_lookups_total{type="username",result="miss"}`)
+		mo.cache_lookups_total.username_error = mo.set.NewCounter(`atlas_api0_cache_lookups_total{type="username",result="error"}`)
+		mo.cache_lookups_total.servergeo_hit = mo.set.NewCounter(`atlas_api0_cache_lookups_total{type="servergeo",result="hit"}`)
+		mo.cache_lookups_total.servergeo_miss = mo.set.NewCounter(`atlas_api0_cache_lookups_total{type="servergeo",result="miss"}`)
+		mo.cache_lookups_total.servergeo_error = mo.set.NewCounter(`atlas_api0_cache_lookups_total{type="servergeo",result="error"}`)
+		mo.client_population_requests_total.success = mo.set.NewCounter(`atlas_api0_client_population_requests_total{result="success"}`)
+		mo.client_population_requests_total.fail_storage_error_stats = mo.set.NewCounter(`atlas_api0_client_population_requests_total{result="fail_storage_error_stats"}`)
+		mo.client_population_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_population_requests_total{result="http_method_not_allowed"}`)
+		mo.api_status_requests_total.success = mo.set.NewCounter(`atlas_api0_api_status_requests_total{result="success"}`)
+		mo.api_status_requests_total.fail_storage_error_state = mo.set.NewCounter(`atlas_api0_api_status_requests_total{result="fail_storage_error_state"}`)
+		mo.api_status_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_api_status_requests_total{result="http_method_not_allowed"}`)
+		mo.client_party_requests_total.success_create = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="success_create"}`)
+		mo.client_party_requests_total.success_join = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="success_join"}`)
+		mo.client_party_requests_total.success_update = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="success_update"}`)
+		mo.client_party_requests_total.success_leave = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="success_leave"}`)
+		mo.client_party_requests_total.success_status = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="success_status"}`)
+		mo.client_party_requests_total.success_presence = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="success_presence"}`)
+		mo.client_party_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="reject_disabled"}`)
+		mo.client_party_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="reject_bad_request"}`)
+		mo.client_party_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="reject_player_not_found"}`)
+		mo.client_party_requests_total.reject_masterserver_token = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="reject_masterserver_token"}`)
+		mo.client_party_requests_total.reject_party_not_found = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="reject_party_not_found"}`)
+		mo.client_party_requests_total.reject_party_full = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="reject_party_full"}`)
+		mo.client_party_requests_total.reject_not_leader = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="reject_not_leader"}`)
+		mo.client_party_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="fail_storage_error_account"}`)
+		mo.client_party_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="fail_other_error"}`)
+		mo.client_party_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="http_method_not_allowed"}`)
+		mo.client_party_requests_total.reject_blocked = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="reject_blocked"}`)
+		mo.client_party_requests_total.fail_storage_error_blocks = mo.set.NewCounter(`atlas_api0_client_party_requests_total{result="fail_storage_error_blocks"}`)
+		mo.client_friends_requests_total.success_list = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="success_list"}`)
+		mo.client_friends_requests_total.success_request = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="success_request"}`)
+		mo.client_friends_requests_total.success_accept = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="success_accept"}`)
+		mo.client_friends_requests_total.success_remove = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="success_remove"}`)
+		mo.client_friends_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="reject_disabled"}`)
+		mo.client_friends_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="reject_bad_request"}`)
+		mo.client_friends_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="reject_player_not_found"}`)
+		mo.client_friends_requests_total.reject_masterserver_token = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="reject_masterserver_token"}`)
+		mo.client_friends_requests_total.reject_limit = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="reject_limit"}`)
+		mo.client_friends_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="fail_storage_error_account"}`)
+		mo.client_friends_requests_total.fail_storage_error_friends = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="fail_storage_error_friends"}`)
+		mo.client_friends_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="http_method_not_allowed"}`)
+		mo.client_friends_requests_total.reject_blocked = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="reject_blocked"}`)
+		mo.client_friends_requests_total.fail_storage_error_blocks = mo.set.NewCounter(`atlas_api0_client_friends_requests_total{result="fail_storage_error_blocks"}`)
+		mo.client_blocks_requests_total.success_list = mo.set.NewCounter(`atlas_api0_client_blocks_requests_total{result="success_list"}`)
+		mo.client_blocks_requests_total.success_block = mo.set.NewCounter(`atlas_api0_client_blocks_requests_total{result="success_block"}`)
+		mo.client_blocks_requests_total.success_unblock = mo.set.NewCounter(`atlas_api0_client_blocks_requests_total{result="success_unblock"}`)
+		mo.client_blocks_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_client_blocks_requests_total{result="reject_disabled"}`)
+		mo.client_blocks_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_blocks_requests_total{result="reject_bad_request"}`)
+		mo.client_blocks_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_client_blocks_requests_total{result="reject_player_not_found"}`)
+		mo.client_blocks_requests_total.reject_masterserver_token = mo.set.NewCounter(`atlas_api0_client_blocks_requests_total{result="reject_masterserver_token"}`)
+		mo.client_blocks_requests_total.reject_limit = mo.set.NewCounter(`atlas_api0_client_blocks_requests_total{result="reject_limit"}`)
+		mo.client_blocks_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_client_blocks_requests_total{result="fail_storage_error_account"}`)
+		mo.client_blocks_requests_total.fail_storage_error_blocks = mo.set.NewCounter(`atlas_api0_client_blocks_requests_total{result="fail_storage_error_blocks"}`)
+		mo.client_blocks_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_blocks_requests_total{result="http_method_not_allowed"}`)
+		mo.client_matchmaking_requests_total.success_join = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="success_join"}`)
+		mo.client_matchmaking_requests_total.success_leave = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="success_leave"}`)
+		mo.client_matchmaking_requests_total.success_status = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="success_status"}`)
+		mo.client_matchmaking_requests_total.success_notify = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="success_notify"}`)
+		mo.client_matchmaking_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="reject_disabled"}`)
+		mo.client_matchmaking_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="reject_bad_request"}`)
+		mo.client_matchmaking_requests_total.reject_not_leader = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="reject_not_leader"}`)
+		mo.client_matchmaking_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="reject_player_not_found"}`)
+		mo.client_matchmaking_requests_total.reject_masterserver_token = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="reject_masterserver_token"}`)
+		mo.client_matchmaking_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="fail_storage_error_account"}`)
+		mo.client_matchmaking_requests_total.fail_storage_error_rating = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="fail_storage_error_rating"}`)
+		mo.client_matchmaking_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="fail_other_error"}`)
+		mo.client_matchmaking_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="http_method_not_allowed"}`)
+		mo.client_matchmaking_requests_total.fail_storage_error_blocks = mo.set.NewCounter(`atlas_api0_client_matchmaking_requests_total{result="fail_storage_error_blocks"}`)
+		mo.client_matchmaking_tickets_total.matched = mo.set.NewCounter(`atlas_api0_client_matchmaking_tickets_total{result="matched"}`)
+		mo.client_matchmaking_tickets_total.expired = mo.set.NewCounter(`atlas_api0_client_matchmaking_tickets_total{result="expired"}`)
+		mo.client_matchmaking_tickets_total.cancelled = mo.set.NewCounter(`atlas_api0_client_matchmaking_tickets_total{result="cancelled"}`)
+		mo.client_matchmaking_wait_seconds = mo.set.NewHistogram(`atlas_api0_client_matchmaking_wait_seconds`)
 		mo.client_servers_requests_total.success = func(launcher_version string) *metrics.Counter {
 			if launcher_version == "" {
 				launcher_version = "unknown"
@@ -307,11 +1376,73 @@ func (h *Handler) m() *apiMetrics {
 			return mo.set.GetOrCreateCounter(`atlas_api0_client_servers_requests_total{result="success",launcher_version="` + launcher_version + `"}`)
 		}
 		mo.client_servers_requests_total.success("unknown")
+		mo.client_servers_requests_total.reject_versiongate = mo.set.NewCounter(`atlas_api0_client_servers_requests_total{result="reject_versiongate"}`)
+		mo.client_servers_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_servers_requests_total{result="reject_bad_request"}`)
+		mo.client_servers_requests_total.reject_masterserver_token = mo.set.NewCounter(`atlas_api0_client_servers_requests_total{result="reject_masterserver_token"}`)
+		mo.client_servers_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_client_servers_requests_total{result="fail_storage_error_account"}`)
 		mo.client_servers_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_servers_requests_total{result="http_method_not_allowed"}`)
+		mo.client_servers_search_requests_total.success = mo.set.NewCounter(`atlas_api0_client_servers_search_requests_total{result="success"}`)
+		mo.client_servers_search_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_client_servers_search_requests_total{result="reject_bad_request"}`)
+		mo.client_servers_search_requests_total.reject_rate_limited = mo.set.NewCounter(`atlas_api0_client_servers_search_requests_total{result="reject_rate_limited"}`)
+		mo.client_servers_search_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_client_servers_search_requests_total{result="fail_other_error"}`)
+		mo.client_servers_search_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_client_servers_search_requests_total{result="http_method_not_allowed"}`)
 		mo.client_servers_requests_map.northstar = metricsx.NewGeoCounter2(`atlas_api0_client_servers_requests_map{user_agent="northstar"}`)
 		mo.client_servers_requests_map.other = metricsx.NewGeoCounter2(`atlas_api0_client_servers_requests_map{user_agent="other"}`)
 		mo.client_servers_response_size_bytes.gzip = mo.set.NewHistogram(`atlas_api0_client_servers_response_size_bytes{compression="gzip"}`)
 		mo.client_servers_response_size_bytes.none = mo.set.NewHistogram(`atlas_api0_client_servers_response_size_bytes{compression="none"}`)
+		mo.client_servers_response_size_bytes.msgpack = mo.set.NewHistogram(`atlas_api0_client_servers_response_size_bytes{compression="none",format="msgpack"}`)
+		mo.client_servers_variant_requests_total.control = mo.set.NewCounter(`atlas_api0_client_servers_variant_requests_total{variant="control"}`)
+		mo.client_servers_variant_requests_total.canary = mo.set.NewCounter(`atlas_api0_client_servers_variant_requests_total{variant="canary"}`)
+		mo.client_servers_variant_joins_total.control = mo.set.NewCounter(`atlas_api0_client_servers_variant_joins_total{variant="control"}`)
+		mo.client_servers_variant_joins_total.canary = mo.set.NewCounter(`atlas_api0_client_servers_variant_joins_total{variant="canary"}`)
+		mo.client_servers_variant_join_position.control = mo.set.NewHistogram(`atlas_api0_client_servers_variant_join_position{variant="control"}`)
+		mo.client_servers_variant_join_position.canary = mo.set.NewHistogram(`atlas_api0_client_servers_variant_join_position{variant="canary"}`)
+		mo.server_udpheartbeat_total.success = mo.set.NewCounter(`atlas_api0_server_udpheartbeat_total{result="success"}`)
+		mo.server_udpheartbeat_total.reject_disabled = mo.set.NewCounter(`atlas_api0_server_udpheartbeat_total{result="reject_disabled"}`)
+		mo.server_udpheartbeat_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_udpheartbeat_total{result="reject_server_not_found"}`)
+		mo.server_udpheartbeat_total.reject_unsigned = mo.set.NewCounter(`atlas_api0_server_udpheartbeat_total{result="reject_unsigned"}`)
+		mo.server_udpheartbeat_total.reject_invalid_signature = mo.set.NewCounter(`atlas_api0_server_udpheartbeat_total{result="reject_invalid_signature"}`)
+		mo.server_udpheartbeat_total.reject_expired = mo.set.NewCounter(`atlas_api0_server_udpheartbeat_total{result="reject_expired"}`)
+		mo.server_udpheartbeat_total.reject_replayed = mo.set.NewCounter(`atlas_api0_server_udpheartbeat_total{result="reject_replayed"}`)
+		mo.server_udpheartbeat_total.reject_versiongate = mo.set.NewCounter(`atlas_api0_server_udpheartbeat_total{result="reject_versiongate"}`)
+		mo.server_udpheartbeat_total.reject_unauthorized_ip = mo.set.NewCounter(`atlas_api0_server_udpheartbeat_total{result="reject_unauthorized_ip"}`)
+		mo.server_udpheartbeat_total.fail_serverlist_error = mo.set.NewCounter(`atlas_api0_server_udpheartbeat_total{result="fail_serverlist_error"}`)
+		mo.server_signature_checks_total.success = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_server_signature_checks_total{result="success",endpoint="` + endpoint + `"}`)
+		}
+		mo.server_signature_checks_total.unsigned = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_server_signature_checks_total{result="unsigned",endpoint="` + endpoint + `"}`)
+		}
+		mo.server_signature_checks_total.reject_missing = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_server_signature_checks_total{result="reject_missing",endpoint="` + endpoint + `"}`)
+		}
+		mo.server_signature_checks_total.reject_invalid = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_server_signature_checks_total{result="reject_invalid",endpoint="` + endpoint + `"}`)
+		}
+		mo.server_signature_checks_total.reject_expired = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_server_signature_checks_total{result="reject_expired",endpoint="` + endpoint + `"}`)
+		}
+		mo.server_signature_checks_total.reject_replayed = func(endpoint string) *metrics.Counter {
+			if endpoint == "" {
+				panic("invalid endpoint")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_server_signature_checks_total{result="reject_replayed",endpoint="` + endpoint + `"}`)
+		}
 		mo.server_upsert_requests_total.success_updated = func(action string) *metrics.Counter {
 			if action == "" {
 				panic("invalid action")
@@ -336,6 +1467,30 @@ func (h *Handler) m() *apiMetrics {
 			}
 			return mo.set.GetOrCreateCounter(`atlas_api0_server_upsert_requests_total{result="reject_ipv6",action="` + action + `"}`)
 		}
+		mo.server_upsert_requests_total.reject_network_rule = func(action string) *metrics.Counter {
+			if action == "" {
+				panic("invalid action")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_server_upsert_requests_total{result="reject_network_rule",action="` + action + `"}`)
+		}
+		mo.server_upsert_requests_total.reject_delist_banned = func(action string) *metrics.Counter {
+			if action == "" {
+				panic("invalid action")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_server_upsert_requests_total{result="reject_delist_banned",action="` + action + `"}`)
+		}
+		mo.server_upsert_requests_total.reject_hook = func(action string) *metrics.Counter {
+			if action == "" {
+				panic("invalid action")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_server_upsert_requests_total{result="reject_hook",action="` + action + `"}`)
+		}
+		mo.server_upsert_requests_total.reject_anomaly = func(action string) *metrics.Counter {
+			if action == "" {
+				panic("invalid action")
+			}
+			return mo.set.GetOrCreateCounter(`atlas_api0_server_upsert_requests_total{result="reject_anomaly",action="` + action + `"}`)
+		}
 		mo.server_upsert_requests_total.reject_bad_request = func(action string) *metrics.Counter {
 			if action == "" {
 				panic("invalid action")
@@ -450,9 +1605,157 @@ func (h *Handler) m() *apiMetrics {
 		mo.server_remove_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_remove_requests_total{result="reject_server_not_found"}`)
 		mo.server_remove_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_remove_requests_total{result="fail_other_error"}`)
 		mo.server_remove_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_remove_requests_total{result="http_method_not_allowed"}`)
+		mo.server_diagnose_requests_total.success_ok = mo.set.NewCounter(`atlas_api0_server_diagnose_requests_total{result="success_ok"}`)
+		mo.server_diagnose_requests_total.success_failed = mo.set.NewCounter(`atlas_api0_server_diagnose_requests_total{result="success_failed"}`)
+		mo.server_diagnose_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_diagnose_requests_total{result="fail_other_error"}`)
+		mo.server_diagnose_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_diagnose_requests_total{result="http_method_not_allowed"}`)
+		mo.server_webhook_requests_total.success = mo.set.NewCounter(`atlas_api0_server_webhook_requests_total{result="success"}`)
+		mo.server_webhook_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_server_webhook_requests_total{result="reject_disabled"}`)
+		mo.server_webhook_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_webhook_requests_total{result="reject_bad_request"}`)
+		mo.server_webhook_requests_total.reject_unauthorized = mo.set.NewCounter(`atlas_api0_server_webhook_requests_total{result="reject_unauthorized"}`)
+		mo.server_webhook_requests_total.reject_limits_exceeded = mo.set.NewCounter(`atlas_api0_server_webhook_requests_total{result="reject_limits_exceeded"}`)
+		mo.server_webhook_requests_total.fail_storage_error = mo.set.NewCounter(`atlas_api0_server_webhook_requests_total{result="fail_storage_error"}`)
+		mo.server_webhook_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_webhook_requests_total{result="fail_other_error"}`)
+		mo.server_webhook_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_webhook_requests_total{result="http_method_not_allowed"}`)
+		mo.server_webhook_deliveries_total.success = mo.set.NewCounter(`atlas_api0_server_webhook_deliveries_total{result="success"}`)
+		mo.server_webhook_deliveries_total.fail_http_error = mo.set.NewCounter(`atlas_api0_server_webhook_deliveries_total{result="fail_http_error"}`)
+		mo.server_webhook_deliveries_total.dropped = mo.set.NewCounter(`atlas_api0_server_webhook_deliveries_total{result="dropped"}`)
+		mo.server_ownerstatus_requests_total.success = mo.set.NewCounter(`atlas_api0_server_ownerstatus_requests_total{result="success"}`)
+		mo.server_ownerstatus_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_ownerstatus_requests_total{result="reject_bad_request"}`)
+		mo.server_ownerstatus_requests_total.reject_unauthorized = mo.set.NewCounter(`atlas_api0_server_ownerstatus_requests_total{result="reject_unauthorized"}`)
+		mo.server_ownerstatus_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_ownerstatus_requests_total{result="fail_other_error"}`)
+		mo.server_ownerstatus_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_ownerstatus_requests_total{result="http_method_not_allowed"}`)
+		mo.server_statshistory_requests_total.success = mo.set.NewCounter(`atlas_api0_server_statshistory_requests_total{result="success"}`)
+		mo.server_statshistory_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_server_statshistory_requests_total{result="reject_disabled"}`)
+		mo.server_statshistory_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_statshistory_requests_total{result="reject_bad_request"}`)
+		mo.server_statshistory_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_statshistory_requests_total{result="reject_server_not_found"}`)
+		mo.server_statshistory_requests_total.fail_storage_error_stats = mo.set.NewCounter(`atlas_api0_server_statshistory_requests_total{result="fail_storage_error_stats"}`)
+		mo.server_statshistory_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_statshistory_requests_total{result="http_method_not_allowed"}`)
+		mo.server_applytrusted_requests_total.success = mo.set.NewCounter(`atlas_api0_server_applytrusted_requests_total{result="success"}`)
+		mo.server_applytrusted_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_applytrusted_requests_total{result="reject_bad_request"}`)
+		mo.server_applytrusted_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_applytrusted_requests_total{result="reject_server_not_found"}`)
+		mo.server_applytrusted_requests_total.reject_unauthorized_ip = mo.set.NewCounter(`atlas_api0_server_applytrusted_requests_total{result="reject_unauthorized_ip"}`)
+		mo.server_applytrusted_requests_total.reject_queue_full = mo.set.NewCounter(`atlas_api0_server_applytrusted_requests_total{result="reject_queue_full"}`)
+		mo.server_applytrusted_requests_total.fail_storage_error_state = mo.set.NewCounter(`atlas_api0_server_applytrusted_requests_total{result="fail_storage_error_state"}`)
+		mo.server_applytrusted_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_applytrusted_requests_total{result="fail_other_error"}`)
+		mo.server_applytrusted_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_applytrusted_requests_total{result="http_method_not_allowed"}`)
+		mo.server_delistappeal_requests_total.success = mo.set.NewCounter(`atlas_api0_server_delistappeal_requests_total{result="success"}`)
+		mo.server_delistappeal_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_server_delistappeal_requests_total{result="reject_disabled"}`)
+		mo.server_delistappeal_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_delistappeal_requests_total{result="reject_bad_request"}`)
+		mo.server_delistappeal_requests_total.reject_unauthorized = mo.set.NewCounter(`atlas_api0_server_delistappeal_requests_total{result="reject_unauthorized"}`)
+		mo.server_delistappeal_requests_total.reject_not_delisted = mo.set.NewCounter(`atlas_api0_server_delistappeal_requests_total{result="reject_not_delisted"}`)
+		mo.server_delistappeal_requests_total.reject_pending = mo.set.NewCounter(`atlas_api0_server_delistappeal_requests_total{result="reject_pending"}`)
+		mo.server_delistappeal_requests_total.fail_storage_error_state = mo.set.NewCounter(`atlas_api0_server_delistappeal_requests_total{result="fail_storage_error_state"}`)
+		mo.server_delistappeal_requests_total.fail_storage_error_report = mo.set.NewCounter(`atlas_api0_server_delistappeal_requests_total{result="fail_storage_error_report"}`)
+		mo.server_delistappeal_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_delistappeal_requests_total{result="fail_other_error"}`)
+		mo.server_delistappeal_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_delistappeal_requests_total{result="http_method_not_allowed"}`)
+		mo.server_banlist_requests_total.success_lists = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="success_lists"}`)
+		mo.server_banlist_requests_total.success_feed = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="success_feed"}`)
+		mo.server_banlist_requests_total.success_upload = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="success_upload"}`)
+		mo.server_banlist_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="reject_disabled"}`)
+		mo.server_banlist_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="reject_bad_request"}`)
+		mo.server_banlist_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="reject_server_not_found"}`)
+		mo.server_banlist_requests_total.reject_unauthorized_ip = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="reject_unauthorized_ip"}`)
+		mo.server_banlist_requests_total.reject_list_not_found = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="reject_list_not_found"}`)
+		mo.server_banlist_requests_total.reject_untrusted = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="reject_untrusted"}`)
+		mo.server_banlist_requests_total.fail_storage_error_state = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="fail_storage_error_state"}`)
+		mo.server_banlist_requests_total.fail_storage_error_banlist = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="fail_storage_error_banlist"}`)
+		mo.server_banlist_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="fail_other_error"}`)
+		mo.server_banlist_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_banlist_requests_total{result="http_method_not_allowed"}`)
+		mo.server_matchresult_requests_total.success = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="success"}`)
+		mo.server_matchresult_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="reject_disabled"}`)
+		mo.server_matchresult_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="reject_bad_request"}`)
+		mo.server_matchresult_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="reject_server_not_found"}`)
+		mo.server_matchresult_requests_total.reject_unauthorized_ip = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="reject_unauthorized_ip"}`)
+		mo.server_matchresult_requests_total.reject_unrated_server = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="reject_unrated_server"}`)
+		mo.server_matchresult_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="reject_player_not_found"}`)
+		mo.server_matchresult_requests_total.reject_player_not_on_server = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="reject_player_not_on_server"}`)
+		mo.server_matchresult_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="fail_storage_error_account"}`)
+		mo.server_matchresult_requests_total.fail_storage_error_rating = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="fail_storage_error_rating"}`)
+		mo.server_matchresult_requests_total.fail_storage_error_match = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="fail_storage_error_match"}`)
+		mo.server_matchresult_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="fail_other_error"}`)
+		mo.server_matchresult_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_matchresult_requests_total{result="http_method_not_allowed"}`)
+		mo.server_chat_requests_total.success = mo.set.NewCounter(`atlas_api0_server_chat_requests_total{result="success"}`)
+		mo.server_chat_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_server_chat_requests_total{result="reject_disabled"}`)
+		mo.server_chat_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_chat_requests_total{result="reject_bad_request"}`)
+		mo.server_chat_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_chat_requests_total{result="reject_server_not_found"}`)
+		mo.server_chat_requests_total.reject_unauthorized_ip = mo.set.NewCounter(`atlas_api0_server_chat_requests_total{result="reject_unauthorized_ip"}`)
+		mo.server_chat_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_chat_requests_total{result="fail_other_error"}`)
+		mo.server_chat_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_chat_requests_total{result="http_method_not_allowed"}`)
+		mo.server_chat_messages_total.success = mo.set.NewCounter(`atlas_api0_server_chat_messages_total{result="success"}`)
+		mo.server_chat_messages_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_chat_messages_total{result="reject_bad_request"}`)
+		mo.server_chat_messages_total.reject_muted = mo.set.NewCounter(`atlas_api0_server_chat_messages_total{result="reject_muted"}`)
+		mo.server_chat_messages_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_server_chat_messages_total{result="reject_player_not_found"}`)
+		mo.server_chat_messages_total.reject_player_not_on_server = mo.set.NewCounter(`atlas_api0_server_chat_messages_total{result="reject_player_not_on_server"}`)
+		mo.server_chat_messages_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_server_chat_messages_total{result="fail_storage_error_account"}`)
+		mo.server_chat_messages_total.fail_storage_error_state = mo.set.NewCounter(`atlas_api0_server_chat_messages_total{result="fail_storage_error_state"}`)
+		mo.server_chat_messages_total.fail_storage_error_mute = mo.set.NewCounter(`atlas_api0_server_chat_messages_total{result="fail_storage_error_mute"}`)
+		mo.server_chat_messages_total.dropped_slow_consumer = mo.set.NewCounter(`atlas_api0_server_chat_messages_total{result="dropped_slow_consumer"}`)
+		mo.server_chat_messages_total.fail_storage_error_blocks = mo.set.NewCounter(`atlas_api0_server_chat_messages_total{result="fail_storage_error_blocks"}`)
+		mo.relay_stream_updates_total = mo.set.NewCounter(`atlas_api0_relay_stream_updates_total`)
+		mo.relay_stream_requests_total.success = mo.set.NewCounter(`atlas_api0_relay_stream_requests_total{result="success"}`)
+		mo.relay_stream_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_relay_stream_requests_total{result="reject_bad_request"}`)
+		mo.relay_stream_requests_total.reject_unauthorized = mo.set.NewCounter(`atlas_api0_relay_stream_requests_total{result="reject_unauthorized"}`)
+		mo.relay_stream_requests_total.reject_client_cert = mo.set.NewCounter(`atlas_api0_relay_stream_requests_total{result="reject_client_cert"}`)
+		mo.relay_stream_requests_total.reject_already_connected = mo.set.NewCounter(`atlas_api0_relay_stream_requests_total{result="reject_already_connected"}`)
+		mo.relay_stream_requests_total.fail_storage_error_state = mo.set.NewCounter(`atlas_api0_relay_stream_requests_total{result="fail_storage_error_state"}`)
+		mo.relay_stream_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_relay_stream_requests_total{result="http_method_not_allowed"}`)
+		mo.server_players_requests_total.success = mo.set.NewCounter(`atlas_api0_server_players_requests_total{result="success"}`)
+		mo.server_players_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_players_requests_total{result="reject_bad_request"}`)
+		mo.server_players_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_players_requests_total{result="reject_server_not_found"}`)
+		mo.server_players_requests_total.reject_unauthorized_ip = mo.set.NewCounter(`atlas_api0_server_players_requests_total{result="reject_unauthorized_ip"}`)
+		mo.server_players_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_server_players_requests_total{result="fail_storage_error_account"}`)
+		mo.server_players_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_players_requests_total{result="fail_other_error"}`)
+		mo.server_players_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_players_requests_total{result="http_method_not_allowed"}`)
+		mo.server_players_keepalives_total = mo.set.NewCounter(`atlas_api0_server_players_keepalives_total`)
+		mo.server_players_rejected_total = mo.set.NewCounter(`atlas_api0_server_players_rejected_total`)
+		mo.server_mute_requests_total.success = mo.set.NewCounter(`atlas_api0_server_mute_requests_total{result="success"}`)
+		mo.server_mute_requests_total.success_delete = mo.set.NewCounter(`atlas_api0_server_mute_requests_total{result="success_delete"}`)
+		mo.server_mute_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_server_mute_requests_total{result="reject_disabled"}`)
+		mo.server_mute_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_mute_requests_total{result="reject_bad_request"}`)
+		mo.server_mute_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_mute_requests_total{result="reject_server_not_found"}`)
+		mo.server_mute_requests_total.reject_unauthorized_ip = mo.set.NewCounter(`atlas_api0_server_mute_requests_total{result="reject_unauthorized_ip"}`)
+		mo.server_mute_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_server_mute_requests_total{result="reject_player_not_found"}`)
+		mo.server_mute_requests_total.reject_player_not_on_server = mo.set.NewCounter(`atlas_api0_server_mute_requests_total{result="reject_player_not_on_server"}`)
+		mo.server_mute_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_server_mute_requests_total{result="fail_storage_error_account"}`)
+		mo.server_mute_requests_total.fail_storage_error_mute = mo.set.NewCounter(`atlas_api0_server_mute_requests_total{result="fail_storage_error_mute"}`)
+		mo.server_mute_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_mute_requests_total{result="fail_other_error"}`)
+		mo.server_mute_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_mute_requests_total{result="http_method_not_allowed"}`)
+		mo.server_mutestatus_requests_total.success = mo.set.NewCounter(`atlas_api0_server_mutestatus_requests_total{result="success"}`)
+		mo.server_mutestatus_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_server_mutestatus_requests_total{result="reject_disabled"}`)
+		mo.server_mutestatus_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_mutestatus_requests_total{result="reject_bad_request"}`)
+		mo.server_mutestatus_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_mutestatus_requests_total{result="reject_server_not_found"}`)
+		mo.server_mutestatus_requests_total.reject_unauthorized_ip = mo.set.NewCounter(`atlas_api0_server_mutestatus_requests_total{result="reject_unauthorized_ip"}`)
+		mo.server_mutestatus_requests_total.fail_storage_error_mute = mo.set.NewCounter(`atlas_api0_server_mutestatus_requests_total{result="fail_storage_error_mute"}`)
+		mo.server_mutestatus_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_mutestatus_requests_total{result="fail_other_error"}`)
+		mo.server_mutestatus_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_mutestatus_requests_total{result="http_method_not_allowed"}`)
+		mo.server_crash_requests_total.success = mo.set.NewCounter(`atlas_api0_server_crash_requests_total{result="success"}`)
+		mo.server_crash_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_server_crash_requests_total{result="reject_disabled"}`)
+		mo.server_crash_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_crash_requests_total{result="reject_bad_request"}`)
+		mo.server_crash_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_crash_requests_total{result="reject_server_not_found"}`)
+		mo.server_crash_requests_total.reject_unauthorized_ip = mo.set.NewCounter(`atlas_api0_server_crash_requests_total{result="reject_unauthorized_ip"}`)
+		mo.server_crash_requests_total.reject_rate_limited = mo.set.NewCounter(`atlas_api0_server_crash_requests_total{result="reject_rate_limited"}`)
+		mo.server_crash_requests_total.fail_storage_error_crash = mo.set.NewCounter(`atlas_api0_server_crash_requests_total{result="fail_storage_error_crash"}`)
+		mo.server_crash_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_crash_requests_total{result="fail_other_error"}`)
+		mo.server_crash_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_crash_requests_total{result="http_method_not_allowed"}`)
+		mo.server_report_requests_total.success = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="success"}`)
+		mo.server_report_requests_total.success_duplicate = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="success_duplicate"}`)
+		mo.server_report_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="reject_disabled"}`)
+		mo.server_report_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="reject_bad_request"}`)
+		mo.server_report_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="reject_server_not_found"}`)
+		mo.server_report_requests_total.reject_unauthorized_ip = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="reject_unauthorized_ip"}`)
+		mo.server_report_requests_total.reject_player_not_found = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="reject_player_not_found"}`)
+		mo.server_report_requests_total.reject_player_not_on_server = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="reject_player_not_on_server"}`)
+		mo.server_report_requests_total.reject_rate_limited = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="reject_rate_limited"}`)
+		mo.server_report_requests_total.reject_quota = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="reject_quota"}`)
+		mo.server_report_requests_total.fail_storage_error_account = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="fail_storage_error_account"}`)
+		mo.server_report_requests_total.fail_storage_error_report = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="fail_storage_error_report"}`)
+		mo.server_report_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="fail_other_error"}`)
+		mo.server_report_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_report_requests_total{result="http_method_not_allowed"}`)
 		mo.server_connect_requests_total.success = mo.set.NewCounter(`atlas_api0_server_connect_requests_total{result="success"}`)
 		mo.server_connect_requests_total.success_reject = mo.set.NewCounter(`atlas_api0_server_connect_requests_total{result="success_reject"}`)
 		mo.server_connect_requests_total.success_pdata = mo.set.NewCounter(`atlas_api0_server_connect_requests_total{result="success_pdata"}`)
+		mo.server_connect_requests_total.success_pdata_not_modified = mo.set.NewCounter(`atlas_api0_server_connect_requests_total{result="success_pdata_not_modified"}`)
 		mo.server_connect_requests_total.reject_unauthorized_ip = mo.set.NewCounter(`atlas_api0_server_connect_requests_total{result="reject_unauthorized_ip"}`)
 		mo.server_connect_requests_total.reject_server_not_found = mo.set.NewCounter(`atlas_api0_server_connect_requests_total{result="reject_server_not_found"}`)
 		mo.server_connect_requests_total.reject_invalid_connection_token = mo.set.NewCounter(`atlas_api0_server_connect_requests_total{result="reject_invalid_connection_token"}`)
@@ -460,6 +1763,16 @@ func (h *Handler) m() *apiMetrics {
 		mo.server_connect_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_server_connect_requests_total{result="reject_bad_request"}`)
 		mo.server_connect_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_server_connect_requests_total{result="fail_other_error"}`)
 		mo.server_connect_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_server_connect_requests_total{result="http_method_not_allowed"}`)
+		mo.player_ratings_requests_total.success = mo.set.NewCounter(`atlas_api0_player_ratings_requests_total{result="success"}`)
+		mo.player_ratings_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_player_ratings_requests_total{result="reject_disabled"}`)
+		mo.player_ratings_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_player_ratings_requests_total{result="reject_bad_request"}`)
+		mo.player_ratings_requests_total.fail_storage_error_rating = mo.set.NewCounter(`atlas_api0_player_ratings_requests_total{result="fail_storage_error_rating"}`)
+		mo.player_ratings_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_player_ratings_requests_total{result="http_method_not_allowed"}`)
+		mo.player_matches_requests_total.success = mo.set.NewCounter(`atlas_api0_player_matches_requests_total{result="success"}`)
+		mo.player_matches_requests_total.reject_disabled = mo.set.NewCounter(`atlas_api0_player_matches_requests_total{result="reject_disabled"}`)
+		mo.player_matches_requests_total.reject_bad_request = mo.set.NewCounter(`atlas_api0_player_matches_requests_total{result="reject_bad_request"}`)
+		mo.player_matches_requests_total.fail_storage_error_match = mo.set.NewCounter(`atlas_api0_player_matches_requests_total{result="fail_storage_error_match"}`)
+		mo.player_matches_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_player_matches_requests_total{result="http_method_not_allowed"}`)
 		mo.player_pdata_requests_total.success = func(filter string) *metrics.Counter {
 			if filter == "" {
 				panic("invalid filter")
@@ -472,25 +1785,25 @@ func (h *Handler) m() *apiMetrics {
 		mo.player_pdata_requests_total.fail_pdata_invalid = mo.set.NewCounter(`atlas_api0_player_pdata_requests_total{result="fail_pdata_invalid"}`)
 		mo.player_pdata_requests_total.fail_other_error = mo.set.NewCounter(`atlas_api0_player_pdata_requests_total{result="fail_other_error"}`)
 		mo.player_pdata_requests_total.http_method_not_allowed = mo.set.NewCounter(`atlas_api0_player_pdata_requests_total{result="http_method_not_allowed"}`)
-	})
 
-	// ensure we initialized everything
-	var chk func(v reflect.Value, name string)
-	chk = func(v reflect.Value, name string) {
-		switch v.Kind() {
-		case reflect.Struct:
-			for i := 0; i < v.NumField(); i++ {
-				chk(v.Field(i), name+"."+v.Type().Field(i).Name)
-			}
-		case reflect.Pointer, reflect.Func:
-			if v.IsNil() {
-				panic(fmt.Errorf("check metrics: unexpected nil %q", name))
+		// ensure we initialized everything (only once, since this is called
+		// on every request)
+		var chk func(v reflect.Value, name string)
+		chk = func(v reflect.Value, name string) {
+			switch v.Kind() {
+			case reflect.Struct:
+				for i := 0; i < v.NumField(); i++ {
+					chk(v.Field(i), name+"."+v.Type().Field(i).Name)
+				}
+			case reflect.Pointer, reflect.Func:
+				if v.IsNil() {
+					panic(fmt.Errorf("check metrics: unexpected nil %q", name))
+				}
+			default:
+				panic(fmt.Errorf("check metrics: unexpected kind %s", v.Kind()))
 			}
-		default:
-			panic(fmt.Errorf("check metrics: unexpected kind %s", v.Kind()))
 		}
-	}
-	chk(reflect.ValueOf(h.metricsObj), "metricsObj")
-
+		chk(reflect.ValueOf(h.metricsObj), "metricsObj")
+	})
 	return &h.metricsObj
 }
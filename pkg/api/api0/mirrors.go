@@ -0,0 +1,275 @@
+package api0
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// mirrorCheckTimeout is the maximum amount of time to spend downloading a
+// single mirror URL.
+const mirrorCheckTimeout = time.Minute * 10
+
+// mirrorCheckConcurrency is the maximum number of mirror URLs to check at
+// once.
+const mirrorCheckConcurrency = 4
+
+// mirrorState is the last known health of a download URL.
+type mirrorState struct {
+	URL     string    `json:"url"`
+	Healthy bool      `json:"healthy"`
+	Checked time.Time `json:"checked"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// mirrorHealth tracks the health of download URLs in the update manifest and
+// mod index.
+type mirrorHealth struct {
+	mu sync.Mutex
+	st map[string]mirrorState
+}
+
+// healthy returns true if url hasn't failed its last check.
+func (m *mirrorHealth) healthy(url string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	x, ok := m.st[url]
+	return !ok || x.Healthy
+}
+
+// filter returns the healthy URLs in urls. If none are healthy, all of them
+// are returned, since it's better to give clients something to try than
+// nothing at all.
+func (m *mirrorHealth) filter(urls []string) []string {
+	var r []string
+	for _, u := range urls {
+		if u != "" && m.healthy(u) {
+			r = append(r, u)
+		}
+	}
+	if len(r) == 0 {
+		for _, u := range urls {
+			if u != "" {
+				r = append(r, u)
+			}
+		}
+	}
+	return r
+}
+
+// serveRelease returns x with dead mirrors removed.
+func (h *Handler) serveRelease(x Release) Release {
+	if us := h.mirrors.filter(append([]string{x.URL}, x.Mirrors...)); len(us) != 0 {
+		x.URL, x.Mirrors = us[0], us[1:]
+	}
+	return x
+}
+
+// serveModIndexEntry returns x with dead mirrors removed.
+func (h *Handler) serveModIndexEntry(x ModIndexEntry) ModIndexEntry {
+	if us := h.mirrors.filter(append([]string{x.URL}, x.Mirrors...)); len(us) != 0 {
+		x.URL, x.Mirrors = us[0], us[1:]
+	}
+	return x
+}
+
+// CheckMirrors downloads every URL in the update manifest and mod index,
+// verifying the checksum if one is known, and updates the mirror health used
+// to filter served URLs. Operators are notified when a mirror goes down or
+// comes back up.
+func (h *Handler) CheckMirrors(ctx context.Context) error {
+	rs, err := h.releases.Get(h.StateStorage, "releases")
+	if err != nil {
+		return fmt.Errorf("load releases: %w", err)
+	}
+	ms, err := h.modIndex.Get(h.StateStorage, "modindex")
+	if err != nil {
+		return fmt.Errorf("load mod index: %w", err)
+	}
+
+	// the same url may be listed more than once, but the checksum should
+	// always be the same
+	urls := map[string]string{}
+	for _, x := range rs {
+		for _, u := range append([]string{x.URL}, x.Mirrors...) {
+			urls[u] = x.SHA256
+		}
+	}
+	for _, x := range ms {
+		for _, u := range append([]string{x.URL}, x.Mirrors...) {
+			urls[u] = x.SHA256
+		}
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, mirrorCheckConcurrency)
+	for u, sum := range urls {
+		u, sum := u, sum
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := h.checkMirror(ctx, u, sum)
+			if ctx.Err() != nil {
+				return // don't mark it as dead if we're stopping
+			}
+			h.updateMirror(u, err)
+		}()
+	}
+	wg.Wait()
+
+	// forget urls which aren't listed anymore
+	h.mirrors.mu.Lock()
+	for u := range h.mirrors.st {
+		if _, ok := urls[u]; !ok {
+			delete(h.mirrors.st, u)
+		}
+	}
+	h.mirrors.mu.Unlock()
+
+	return nil
+}
+
+// checkMirror downloads url, checking the hex-encoded SHA-256 if sum is not
+// empty.
+func (h *Handler) checkMirror(ctx context.Context, url, sum string) error {
+	ctx, cancel := context.WithTimeout(ctx, mirrorCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "Atlas (mirror check)")
+
+	c := h.MirrorCheckClient
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		h.m().mirror_checks_total.fail_http_error.Inc()
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		h.m().mirror_checks_total.fail_http_error.Inc()
+		return fmt.Errorf("response status %d", resp.StatusCode)
+	}
+	if sum == "" {
+		h.m().mirror_checks_total.success.Inc()
+		return nil
+	}
+
+	s := sha256.New()
+	if _, err := io.Copy(s, resp.Body); err != nil {
+		h.m().mirror_checks_total.fail_http_error.Inc()
+		return fmt.Errorf("read response: %w", err)
+	}
+	if x := hex.EncodeToString(s.Sum(nil)); x != sum {
+		h.m().mirror_checks_total.fail_checksum_mismatch.Inc()
+		return fmt.Errorf("checksum mismatch (expected %s, got %s)", sum, x)
+	}
+	h.m().mirror_checks_total.success.Inc()
+	return nil
+}
+
+// updateMirror records the result of a mirror check, sending a notification if
+// the health changed.
+func (h *Handler) updateMirror(url string, err error) {
+	x := mirrorState{
+		URL:     url,
+		Healthy: err == nil,
+		Checked: time.Now().UTC(),
+	}
+	if err != nil {
+		x.Error = err.Error()
+	}
+
+	h.mirrors.mu.Lock()
+	if h.mirrors.st == nil {
+		h.mirrors.st = map[string]mirrorState{}
+	}
+	p, checked := h.mirrors.st[url]
+	h.mirrors.st[url] = x
+	h.mirrors.mu.Unlock()
+
+	switch {
+	case !x.Healthy && (!checked || p.Healthy):
+		h.notify(Notification{
+			Type:    NotificationMirrorDown,
+			Message: "download mirror " + url + " is down: " + x.Error,
+			Fields: map[string]string{
+				"url":   url,
+				"error": x.Error,
+			},
+		})
+	case x.Healthy && checked && !p.Healthy:
+		h.notify(Notification{
+			Type:    NotificationMirrorUp,
+			Message: "download mirror " + url + " is back up",
+			Fields: map[string]string{
+				"url": url,
+			},
+		})
+	}
+}
+
+func (h *Handler) handleAdminMirrors(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "mirrors"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	h.mirrors.mu.Lock()
+	mirrors := make([]mirrorState, 0, len(h.mirrors.st))
+	for _, x := range h.mirrors.st {
+		mirrors = append(mirrors, x)
+	}
+	h.mirrors.mu.Unlock()
+
+	sort.Slice(mirrors, func(i, j int) bool {
+		return mirrors[i].URL < mirrors[j].URL
+	})
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"mirrors": mirrors,
+	})
+}
@@ -0,0 +1,361 @@
+package api0
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// ModIndexEntry is a downloadable mod version in the mod index. Entries are
+// linked to servers by the name and version in the server's modinfo.
+type ModIndexEntry struct {
+	// Name is the mod name, as reported in server modinfo.
+	Name string `json:"name" validate:"required,max=128"`
+
+	// Version is the mod version, as reported in server modinfo.
+	Version string `json:"version" validate:"required,max=32"`
+
+	// SHA256 is the hex-encoded SHA-256 of the download.
+	SHA256 string `json:"sha256" validate:"required"`
+
+	// URL is the HTTPS download URL.
+	URL string `json:"url" validate:"required,max=1024"`
+
+	// Mirrors are additional HTTPS download URLs. Mirrors failing health
+	// checks are omitted when served to clients.
+	Mirrors []string `json:"mirrors,omitempty" validate:"max=8"`
+
+	// Size, if provided, is the size of the download in bytes.
+	Size int64 `json:"size,omitempty" validate:"min=0"`
+
+	// Added is when the entry was added to the index.
+	Added time.Time `json:"added"`
+}
+
+// validateModIndex checks if ms is a valid mod index.
+func validateModIndex(ms []ModIndexEntry) error {
+	keys := map[[2]string]struct{}{}
+	for i, x := range ms {
+		if err := validate(x); err != nil {
+			return fmt.Errorf("mod %d: %w", i, err)
+		}
+		k := [2]string{x.Name, x.Version}
+		if _, dup := keys[k]; dup {
+			return fmt.Errorf("mod %s@%s: duplicate name and version", x.Name, x.Version)
+		}
+		keys[k] = struct{}{}
+		for _, v := range append([]string{x.URL}, x.Mirrors...) {
+			if u, err := url.Parse(v); err != nil || u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("mod %s@%s: invalid url %q (must be https)", x.Name, x.Version, v)
+			}
+		}
+		if b, err := hex.DecodeString(x.SHA256); err != nil || len(b) != 32 {
+			return fmt.Errorf("mod %s@%s: invalid sha256", x.Name, x.Version)
+		}
+	}
+	return nil
+}
+
+// sortModIndex sorts ms by name, then version.
+func sortModIndex(ms []ModIndexEntry) {
+	sort.SliceStable(ms, func(i, j int) bool {
+		a, b := ms[i], ms[j]
+		return a.Name < b.Name || (a.Name == b.Name && a.Version < b.Version)
+	})
+}
+
+func (h *Handler) handleClientMods(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().client_mods_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, HEAD")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var q struct {
+		Name string `param:"name" validate:"max=128"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().client_mods_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+
+	ms, err := h.modIndex.Get(h.StateStorage, "modindex")
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to load mod index from storage")
+		h.m().client_mods_requests_total.fail_storage_error_state.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	// count the live servers requiring each mod version
+	required := map[[2]string]int{}
+	h.ServerList.GetLiveServers(func(s *Server) bool {
+		for _, mi := range s.ModInfo {
+			if mi.RequiredOnClient {
+				required[[2]string{mi.Name, mi.Version}]++
+			}
+		}
+		return true
+	})
+
+	type modJSON struct {
+		ModIndexEntry
+		Servers int `json:"servers"`
+	}
+	mods := []modJSON{}
+	for _, m := range ms {
+		if q.Name == "" || m.Name == q.Name {
+			mods = append(mods, modJSON{h.serveModIndexEntry(m), required[[2]string{m.Name, m.Version}]})
+		}
+	}
+
+	h.m().client_mods_requests_total.success.Inc()
+	h.respJSONConditional(w, r, map[string]any{
+		"success": true,
+		"mods":    mods,
+	})
+}
+
+func (h *Handler) handleClientServerMods(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().client_servermods_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var q struct {
+		ID string `param:"id" validate:"required"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().client_servermods_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+
+	srv := h.ServerList.GetServerByID(q.ID)
+	if srv == nil {
+		h.m().client_servermods_requests_total.reject_server_not_found.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("no such game server"))
+		return
+	}
+
+	ms, err := h.modIndex.Get(h.StateStorage, "modindex")
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to load mod index from storage")
+		h.m().client_servermods_requests_total.fail_storage_error_state.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	index := make(map[[2]string]ModIndexEntry, len(ms))
+	for _, m := range ms {
+		index[[2]string{m.Name, m.Version}] = m
+	}
+
+	// only mods required on the client need to be downloaded
+	type serverModJSON struct {
+		Name    string   `json:"name"`
+		Version string   `json:"version"`
+		Indexed bool     `json:"indexed"`
+		SHA256  string   `json:"sha256,omitempty"`
+		URL     string   `json:"url,omitempty"`
+		Mirrors []string `json:"mirrors,omitempty"`
+		Size    int64    `json:"size,omitempty"`
+	}
+	mods := []serverModJSON{}
+	for _, mi := range srv.ModInfo {
+		if !mi.RequiredOnClient {
+			continue
+		}
+		x := serverModJSON{
+			Name:    mi.Name,
+			Version: mi.Version,
+		}
+		if m, ok := index[[2]string{mi.Name, mi.Version}]; ok {
+			m = h.serveModIndexEntry(m)
+			x.Indexed = true
+			x.SHA256 = m.SHA256
+			x.URL = m.URL
+			x.Mirrors = m.Mirrors
+			x.Size = m.Size
+		}
+		mods = append(mods, x)
+	}
+
+	h.m().client_servermods_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"id":      srv.ID,
+		"mods":    mods,
+	})
+}
+
+func (h *Handler) handleAdminMods(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "mods"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PUT, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		ms, err := h.modIndex.Get(h.StateStorage, "modindex")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load mod index from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if ms == nil {
+			ms = []ModIndexEntry{}
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"mods":    ms,
+		})
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	var fn func(ms []ModIndexEntry) []ModIndexEntry
+	switch r.Method {
+	case http.MethodPut:
+		var ms []ModIndexEntry
+		if err := decodeJSON(r, &ms); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		for i := range ms {
+			if ms[i].Added.IsZero() {
+				ms[i].Added = now
+			}
+		}
+		fn = func([]ModIndexEntry) []ModIndexEntry {
+			return ms
+		}
+	case http.MethodPost:
+		var x ModIndexEntry
+		if err := decodeJSON(r, &x); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		if x.Added.IsZero() {
+			x.Added = now
+		}
+		fn = func(ms []ModIndexEntry) []ModIndexEntry {
+			for i := range ms {
+				if ms[i].Name == x.Name && ms[i].Version == x.Version {
+					ms[i] = x
+					return ms
+				}
+			}
+			return append(ms, x)
+		}
+	case http.MethodDelete:
+		var q struct {
+			Name    string `param:"name" validate:"required"`
+			Version string `param:"version"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		// if no version is specified, all versions are deleted
+		fn = func(ms []ModIndexEntry) []ModIndexEntry {
+			n := ms[:0]
+			for _, m := range ms {
+				if m.Name != q.Name || (q.Version != "" && m.Version != q.Version) {
+					n = append(n, m)
+				}
+			}
+			return n
+		}
+	}
+
+	var errInvalid error
+	var nms []ModIndexEntry
+	if err := h.modIndex.Update(h.StateStorage, "modindex", func(ms []ModIndexEntry) ([]ModIndexEntry, error) {
+		ms = fn(append([]ModIndexEntry(nil), ms...))
+		if err := validateModIndex(ms); err != nil {
+			errInvalid = err
+			return nil, err
+		}
+		sortModIndex(ms)
+		nms = ms
+		return ms, nil
+	}); err != nil {
+		if errInvalid != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", errInvalid))
+			return
+		}
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save mod index to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if nms == nil {
+		nms = []ModIndexEntry{}
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"mods":    nms,
+	})
+}
@@ -0,0 +1,342 @@
+package api0
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// MOTD is a main menu message (e.g., news or an event banner) managed via the
+// admin API.
+type MOTD struct {
+	// ID uniquely identifies the message. It is required.
+	ID string `json:"id" validate:"required,max=64"`
+
+	// Kind is an arbitrary category for the message (e.g., news, event, motd).
+	Kind string `json:"kind,omitempty"`
+
+	// Priority is used to order messages, highest first.
+	Priority int `json:"priority,omitempty"`
+
+	// Start and End, if non-zero, limit when the message is shown. The end is
+	// exclusive.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// ImageURL and URL are optional links to an image and more information.
+	ImageURL string `json:"image,omitempty"`
+	URL      string `json:"url,omitempty"`
+
+	// MOTDContent is the default content.
+	MOTDContent
+
+	// Locales contains localized content by lowercase language tag (e.g., fr,
+	// pt-br). Empty fields fall back to the default content.
+	Locales map[string]MOTDContent `json:"locales,omitempty"`
+}
+
+// MOTDContent is the localizable content of a MOTD.
+type MOTDContent struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+// Active checks whether m is scheduled to be shown at t.
+func (m MOTD) Active(t time.Time) bool {
+	return (m.Start.IsZero() || !t.Before(m.Start)) && (m.End.IsZero() || t.Before(m.End))
+}
+
+// Localize gets the content of m for the first matching language in langs.
+func (m MOTD) Localize(langs ...string) MOTDContent {
+	c := m.MOTDContent
+	for _, lang := range langs {
+		lang = strings.ToLower(lang)
+		l, ok := m.Locales[lang]
+		if !ok {
+			if base, _, cut := strings.Cut(lang, "-"); cut {
+				l, ok = m.Locales[base]
+			}
+		}
+		if ok {
+			if l.Title != "" {
+				c.Title = l.Title
+			}
+			if l.Text != "" {
+				c.Text = l.Text
+			}
+			break
+		}
+	}
+	return c
+}
+
+// validateMOTDs checks if ms is a valid set of messages.
+func validateMOTDs(ms []MOTD) error {
+	ids := map[string]struct{}{}
+	for i, m := range ms {
+		if err := validate(m); err != nil {
+			return fmt.Errorf("message %d: %w", i, err)
+		}
+		if _, dup := ids[m.ID]; dup {
+			return fmt.Errorf("message %q: duplicate id", m.ID)
+		}
+		ids[m.ID] = struct{}{}
+		if !m.Start.IsZero() && !m.End.IsZero() && !m.End.After(m.Start) {
+			return fmt.Errorf("message %q: end must be after start", m.ID)
+		}
+		for l := range m.Locales {
+			if l == "" || l != strings.ToLower(l) {
+				return fmt.Errorf("message %q: locale %q must be a non-empty lowercase language tag", m.ID, l)
+			}
+		}
+	}
+	return nil
+}
+
+// requestLanguages gets the preferred languages for r from the lang query
+// parameter and the Accept-Language header, ordered by preference.
+func requestLanguages(r *http.Request) []string {
+	var langs []string
+	if v := r.URL.Query().Get("lang"); v != "" {
+		langs = append(langs, v)
+	}
+	type qlang struct {
+		lang string
+		q    float64
+	}
+	var qls []qlang
+	for _, e := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		t, p, _ := strings.Cut(e, ";")
+		if t = strings.TrimSpace(t); t == "" || t == "*" {
+			continue
+		}
+		q := 1.0
+		if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+			if x, err := strconv.ParseFloat(p[2:], 64); err == nil {
+				q = x
+			}
+		}
+		qls = append(qls, qlang{t, q})
+	}
+	sort.SliceStable(qls, func(i, j int) bool {
+		return qls[i].q > qls[j].q
+	})
+	for _, ql := range qls {
+		langs = append(langs, ql.lang)
+	}
+	return langs
+}
+
+func (h *Handler) handleClientMOTD(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().client_motd_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// launchers poll this, so let them revalidate it with conditional requests
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Vary", "Accept-Language")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ms, err := h.motd.Get(h.StateStorage, "motd")
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to load messages from storage")
+		h.m().client_motd_requests_total.fail_storage_error_state.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if es, err := h.eventMOTDs(); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to load events from storage")
+		h.m().client_motd_requests_total.fail_storage_error_state.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	} else if len(es) != 0 {
+		ms = append(append([]MOTD(nil), ms...), es...)
+	}
+
+	type motdObj struct {
+		ID       string `json:"id"`
+		Kind     string `json:"kind,omitempty"`
+		Priority int    `json:"priority,omitempty"`
+		Title    string `json:"title"`
+		Text     string `json:"text"`
+		ImageURL string `json:"image,omitempty"`
+		URL      string `json:"url,omitempty"`
+		Start    int64  `json:"start,omitempty"`
+		End      int64  `json:"end,omitempty"`
+	}
+
+	var (
+		now   = time.Now()
+		langs = requestLanguages(r)
+		objs  = []motdObj{}
+	)
+	for _, m := range ms {
+		if !m.Active(now) {
+			continue
+		}
+		c := m.Localize(langs...)
+		o := motdObj{
+			ID:       m.ID,
+			Kind:     m.Kind,
+			Priority: m.Priority,
+			Title:    c.Title,
+			Text:     c.Text,
+			ImageURL: m.ImageURL,
+			URL:      m.URL,
+		}
+		if !m.Start.IsZero() {
+			o.Start = m.Start.Unix()
+		}
+		if !m.End.IsZero() {
+			o.End = m.End.Unix()
+		}
+		objs = append(objs, o)
+	}
+	sort.SliceStable(objs, func(i, j int) bool {
+		return objs[i].Priority > objs[j].Priority
+	})
+
+	h.m().client_motd_requests_total.success(h.ExtractLauncherVersion(r)).Inc()
+	h.respJSONConditional(w, r, map[string]any{
+		"success": true,
+		"motd":    objs,
+	})
+}
+
+func (h *Handler) handleAdminMOTD(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "motd"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PUT, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		ms, err := h.motd.Get(h.StateStorage, "motd")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load messages from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if ms == nil {
+			ms = []MOTD{}
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"motd":    ms,
+		})
+		return
+	}
+
+	var fn func(ms []MOTD) ([]MOTD, error)
+	switch r.Method {
+	case http.MethodPut:
+		var ms []MOTD
+		if err := decodeJSON(r, &ms); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func([]MOTD) ([]MOTD, error) {
+			return ms, nil
+		}
+	case http.MethodPost:
+		var m MOTD
+		if err := decodeJSON(r, &m); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func(ms []MOTD) ([]MOTD, error) {
+			for i := range ms {
+				if ms[i].ID == m.ID {
+					ms[i] = m
+					return ms, nil
+				}
+			}
+			return append(ms, m), nil
+		}
+	case http.MethodDelete:
+		var q struct {
+			ID string `param:"id" validate:"required"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		id := q.ID
+		fn = func(ms []MOTD) ([]MOTD, error) {
+			for i := range ms {
+				if ms[i].ID == id {
+					return append(ms[:i], ms[i+1:]...), nil
+				}
+			}
+			return ms, nil
+		}
+	}
+
+	var errInvalid error
+	if err := h.motd.Update(h.StateStorage, "motd", func(ms []MOTD) ([]MOTD, error) {
+		ms, err := fn(append([]MOTD(nil), ms...))
+		if err == nil {
+			if err = validateMOTDs(ms); err != nil {
+				errInvalid = err
+			}
+		}
+		return ms, err
+	}); err != nil {
+		if errInvalid != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", errInvalid))
+			return
+		}
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save messages to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
@@ -0,0 +1,305 @@
+package api0
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// MuteReportPruneInterval is the recommended interval for calling
+// PruneMuteReports.
+const MuteReportPruneInterval = time.Hour
+
+// MuteReportConfig configures how mutes reported by game servers are
+// aggregated.
+type MuteReportConfig struct {
+	// Threshold is the decayed number of distinct servers which must have
+	// muted a player for them to be considered muted. If zero, it defaults to
+	// 3.
+	Threshold float64
+
+	// HalfLife is how long it takes for a report to count for half as much.
+	// If zero, it defaults to 7 days.
+	HalfLife time.Duration
+}
+
+func (c MuteReportConfig) threshold() float64 {
+	if c.Threshold > 0 {
+		return c.Threshold
+	}
+	return 3
+}
+
+func (c MuteReportConfig) halfLife() time.Duration {
+	if c.HalfLife > 0 {
+		return c.HalfLife
+	}
+	return time.Hour * 24 * 7
+}
+
+// retention is how long reports are kept for. After 8 half-lives, a report
+// counts for less than 0.4% of a fresh one.
+func (c MuteReportConfig) retention() time.Duration {
+	return c.halfLife() * 8
+}
+
+// muteStatus is the aggregated mute status of a player for a MuteKind.
+type muteStatus struct {
+	Muted   bool    `json:"muted"`
+	Score   float64 `json:"score"`
+	Servers int     `json:"servers"`
+}
+
+// getMuteStatus aggregates the mute reports for uid at t.
+func (h *Handler) getMuteStatus(uid uint64, t time.Time) (map[MuteKind]muteStatus, error) {
+	c := h.MuteReports
+	rs, err := h.MuteReportStorage.GetMuteReports(uid, t.Add(-c.retention()))
+	if err != nil {
+		return nil, err
+	}
+	st := map[MuteKind]muteStatus{
+		MuteKindChat:  {},
+		MuteKindVoice: {},
+	}
+	for _, r := range rs {
+		x, ok := st[r.Kind]
+		if !ok {
+			continue
+		}
+		age := t.Sub(r.Time)
+		if age < 0 {
+			age = 0
+		}
+		x.Score += math.Exp2(-float64(age) / float64(c.halfLife()))
+		x.Servers++
+		st[r.Kind] = x
+	}
+	for k, x := range st {
+		x.Score = math.Round(x.Score*100) / 100
+		x.Muted = x.Score >= c.threshold()
+		st[k] = x
+	}
+	return st, nil
+}
+
+// PruneMuteReports deletes mute reports which no longer count towards a
+// player's status. It should be called every MuteReportPruneInterval. If
+// MuteReportStorage is nil, it does nothing.
+func (h *Handler) PruneMuteReports(t time.Time) error {
+	if h.MuteReportStorage == nil {
+		return nil
+	}
+	return h.MuteReportStorage.DeleteMuteReportsBefore(t.Add(-h.MuteReports.retention()))
+}
+
+func (h *Handler) handleServerMute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().server_mute_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.MuteReportStorage == nil {
+		h.m().server_mute_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("mute reports are not enabled"))
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_mute_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		h.m().server_mute_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	srv := h.ServerList.GetServerByID(id)
+	if srv == nil {
+		h.m().server_mute_requests_total.reject_server_not_found.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such game server"))
+		return
+	}
+	if srv.Addr.Addr() != raddr.Addr() {
+		h.m().server_mute_requests_total.reject_unauthorized_ip.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		var q struct {
+			UID  uint64 `param:"uid" validate:"required"`
+			Kind string `param:"kind" validate:"required,oneof=chat|voice"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().server_mute_requests_total.reject_bad_request.Inc()
+			respError(w, r, err)
+			return
+		}
+		if err := h.MuteReportStorage.DeleteMuteReport(q.UID, srv.Addr.Addr(), MuteKind(q.Kind)); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", q.UID).
+				Msgf("failed to delete mute report from storage")
+			h.m().server_mute_requests_total.fail_storage_error_mute.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		h.m().server_mute_requests_total.success_delete.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+		})
+		return
+	}
+
+	var req struct {
+		UID    uint64   `json:"uid" validate:"required"`
+		Kind   MuteKind `json:"kind" validate:"required,oneof=chat|voice"`
+		Reason string   `json:"reason,omitempty" validate:"max=256"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		h.m().server_mute_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+
+	acct, err := h.AccountStorage.GetAccount(req.UID)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", req.UID).
+			Msgf("failed to read account from storage")
+		h.m().server_mute_requests_total.fail_storage_error_account.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if acct == nil {
+		h.m().server_mute_requests_total.reject_player_not_found.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+	if acct.LastServerID != srv.ID {
+		h.m().server_mute_requests_total.reject_player_not_on_server.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_BAD_REQUEST.MessageObjf("player is not on this server"))
+		return
+	}
+
+	if err := h.MuteReportStorage.SaveMuteReport(MuteReport{
+		UID:        acct.UID,
+		ServerAddr: srv.Addr.Addr(),
+		Kind:       req.Kind,
+		ServerName: srv.Name,
+		Reason:     req.Reason,
+		Time:       time.Now(),
+	}); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", acct.UID).
+			Msgf("failed to save mute report to storage")
+		h.m().server_mute_requests_total.fail_storage_error_mute.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().server_mute_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
+
+func (h *Handler) handleServerMuteStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().server_mutestatus_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.MuteReportStorage == nil {
+		h.m().server_mutestatus_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("mute reports are not enabled"))
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_mutestatus_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	var q struct {
+		ID  string `param:"id" validate:"required"`
+		UID uint64 `param:"uid" validate:"required"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().server_mutestatus_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+
+	// only game servers can query mute status
+	srv := h.ServerList.GetServerByID(q.ID)
+	if srv == nil {
+		h.m().server_mutestatus_requests_total.reject_server_not_found.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such game server"))
+		return
+	}
+	if srv.Addr.Addr() != raddr.Addr() {
+		h.m().server_mutestatus_requests_total.reject_unauthorized_ip.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
+		return
+	}
+
+	st, err := h.getMuteStatus(q.UID, time.Now())
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", q.UID).
+			Msgf("failed to read mute reports from storage")
+		h.m().server_mutestatus_requests_total.fail_storage_error_mute.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().server_mutestatus_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"uid":     q.UID,
+		"chat":    st[MuteKindChat],
+		"voice":   st[MuteKindVoice],
+	})
+}
@@ -0,0 +1,152 @@
+package api0
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+type nameHistoryAccount struct {
+	UID      string              `json:"uid"`
+	Username string              `json:"username"`
+	History  []nameHistoryChange `json:"history"`
+}
+
+type nameHistoryChange struct {
+	Time time.Time `json:"time"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+}
+
+// handleAdminNameHistory gets the username history for an account, or for all
+// accounts which currently have or previously had a username.
+func (h *Handler) handleAdminNameHistory(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "namehistory"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	if h.UsernameHistoryStorage == nil {
+		h.m().admin_requests_total.reject_disabled(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	var q struct {
+		UID      uint64 `param:"uid"`
+		Username string `param:"username"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+		respError(w, r, err)
+		return
+	}
+	if (q.UID == 0) == (q.Username == "") {
+		h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("exactly one of uid or username is required"))
+		return
+	}
+
+	uids := []uint64{q.UID}
+	if q.Username != "" {
+		cur, err := h.AccountStorage.GetUIDsByUsername(q.Username)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Str("username", q.Username).
+				Msgf("failed to find accounts by username")
+			h.m().admin_requests_total.fail_storage_error_account(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		past, err := h.UsernameHistoryStorage.GetUIDsByPastUsername(q.Username)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Str("username", q.Username).
+				Msgf("failed to find accounts by past username")
+			h.m().admin_requests_total.fail_storage_error_account(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		seen := map[uint64]bool{}
+		uids = uids[:0]
+		for _, uid := range append(cur, past...) {
+			if !seen[uid] {
+				seen[uid] = true
+				uids = append(uids, uid)
+			}
+		}
+		sort.Slice(uids, func(i, j int) bool {
+			return uids[i] < uids[j]
+		})
+	}
+
+	as := []nameHistoryAccount{}
+	for _, uid := range uids {
+		acct, err := h.AccountStorage.GetAccount(uid)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", uid).
+				Msgf("failed to read account from storage")
+			h.m().admin_requests_total.fail_storage_error_account(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		cs, err := h.UsernameHistoryStorage.GetUsernameHistory(uid)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", uid).
+				Msgf("failed to read username history from storage")
+			h.m().admin_requests_total.fail_storage_error_account(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if acct == nil && len(cs) == 0 {
+			continue
+		}
+		a := nameHistoryAccount{
+			UID:     strconv.FormatUint(uid, 10),
+			History: []nameHistoryChange{},
+		}
+		if acct != nil {
+			a.Username = acct.Username
+		}
+		for _, c := range cs {
+			a.History = append(a.History, nameHistoryChange{
+				Time: c.Time.UTC(),
+				From: c.From,
+				To:   c.To,
+			})
+		}
+		as = append(as, a)
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":  true,
+		"accounts": as,
+	})
+}
@@ -0,0 +1,306 @@
+package api0
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pg9182/ip2x"
+	"github.com/rs/zerolog/hlog"
+)
+
+// maxNetworkRules is the maximum number of rules and overrides each.
+const maxNetworkRules = 1024
+
+// IPNetwork contains network information about an IP.
+type IPNetwork struct {
+	Country string // ISO 3166-1 alpha-2 country code, empty if unknown
+	ASN     uint32 // autonomous system number, zero if unknown
+}
+
+// IP2xNetwork gets the network information from the country code and ASN
+// fields in r.
+func IP2xNetwork(r ip2x.Record) IPNetwork {
+	var n IPNetwork
+	if v, ok := r.GetString(ip2x.CountryCode); ok && len(v) == 2 {
+		n.Country = strings.ToUpper(v)
+	}
+	if v, ok := r.GetString(ip2x.ASN); ok {
+		if x, err := strconv.ParseUint(v, 10, 32); err == nil {
+			n.ASN = uint32(x)
+		}
+	}
+	return n
+}
+
+// NetworkRuleAction is the action taken for IPs matching a NetworkRule.
+type NetworkRuleAction string
+
+const (
+	NetworkRuleAllow NetworkRuleAction = "allow"
+	NetworkRuleDeny  NetworkRuleAction = "deny"
+)
+
+// NetworkRuleScope is where a NetworkRule is applied.
+type NetworkRuleScope string
+
+const (
+	NetworkRuleScopeAny    NetworkRuleScope = ""
+	NetworkRuleScopeAuth   NetworkRuleScope = "auth"   // player auth
+	NetworkRuleScopeServer NetworkRuleScope = "server" // server registration
+)
+
+// NetworkRule allows or denies IPs by country or ASN.
+type NetworkRule struct {
+	Action NetworkRuleAction `json:"action"`
+	Scope  NetworkRuleScope  `json:"scope,omitempty"`
+
+	// Countries contains ISO 3166-1 alpha-2 country codes, or * to match all
+	// IPs (including ones which can't be looked up).
+	Countries []string `json:"countries,omitempty"`
+
+	// ASNs contains autonomous system numbers.
+	ASNs []uint32 `json:"asns,omitempty"`
+
+	// Note is an optional note about why the rule was added.
+	Note string `json:"note,omitempty"`
+}
+
+// Match checks if the rule applies to n for scope.
+func (x NetworkRule) Match(scope NetworkRuleScope, n IPNetwork) bool {
+	if x.Scope != NetworkRuleScopeAny && x.Scope != scope {
+		return false
+	}
+	for _, c := range x.Countries {
+		if c == "*" || (n.Country != "" && strings.EqualFold(c, n.Country)) {
+			return true
+		}
+	}
+	if n.ASN != 0 {
+		for _, a := range x.ASNs {
+			if a == n.ASN {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NetworkOverride exempts a player or server from deny rules (e.g., for false
+// positives).
+type NetworkOverride struct {
+	// Token, if set, exempts requests with it in the network_override param.
+	Token string `json:"token,omitempty"`
+
+	// UID, if set, exempts player auth for the account.
+	UID uint64 `json:"uid,omitempty"`
+
+	// Note is an optional note about who the override is for.
+	Note string `json:"note,omitempty"`
+
+	// Expires, if set, is when the override stops applying.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// NetworkRules contains country and ASN allow/deny rules for player auth and
+// server registration. The first rule matching an IP is used, and IPs not
+// matching any rule are allowed.
+type NetworkRules struct {
+	Rules     []NetworkRule     `json:"rules"`
+	Overrides []NetworkOverride `json:"overrides"`
+}
+
+// Validate checks if the rules and overrides in n are valid.
+func (n NetworkRules) Validate() error {
+	if len(n.Rules) > maxNetworkRules || len(n.Overrides) > maxNetworkRules {
+		return fmt.Errorf("too many rules or overrides (max %d)", maxNetworkRules)
+	}
+	for i, x := range n.Rules {
+		switch x.Action {
+		case NetworkRuleAllow, NetworkRuleDeny:
+		default:
+			return fmt.Errorf("rule %d: invalid action %q", i, x.Action)
+		}
+		switch x.Scope {
+		case NetworkRuleScopeAny, NetworkRuleScopeAuth, NetworkRuleScopeServer:
+		default:
+			return fmt.Errorf("rule %d: invalid scope %q", i, x.Scope)
+		}
+		if len(x.Countries) == 0 && len(x.ASNs) == 0 {
+			return fmt.Errorf("rule %d: at least one country or asn is required", i)
+		}
+		for _, c := range x.Countries {
+			if c != "*" && (len(c) != 2 || strings.Trim(strings.ToUpper(c), "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "") {
+				return fmt.Errorf("rule %d: invalid country code %q", i, c)
+			}
+		}
+		for _, a := range x.ASNs {
+			if a == 0 {
+				return fmt.Errorf("rule %d: invalid asn 0", i)
+			}
+		}
+		if len(x.Note) > 512 {
+			return fmt.Errorf("rule %d: note too long", i)
+		}
+	}
+	for i, o := range n.Overrides {
+		if o.Token == "" && o.UID == 0 {
+			return fmt.Errorf("override %d: token or uid is required", i)
+		}
+		if o.Token != "" && len(o.Token) < 16 {
+			return fmt.Errorf("override %d: token must be at least 16 characters", i)
+		}
+		if len(o.Token) > 128 || len(o.Note) > 512 {
+			return fmt.Errorf("override %d: token or note too long", i)
+		}
+	}
+	return nil
+}
+
+// Overridden checks if a valid override matches the uid (if non-zero) or
+// token at t.
+func (n NetworkRules) Overridden(t time.Time, uid uint64, token string) bool {
+	for _, o := range n.Overrides {
+		if o.Expires != nil && !t.Before(*o.Expires) {
+			continue
+		}
+		if uid != 0 && o.UID == uid {
+			return true
+		}
+		if token != "" && o.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(o.Token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNetworkRules checks if ip is allowed by the network rules for scope.
+// The uid is only used for player
+// auth. If the rules can't be loaded, the IP is allowed.
+func (h *Handler) checkNetworkRules(r *http.Request, scope NetworkRuleScope, ip netip.Addr, uid uint64) bool {
+	n, err := h.networkRules.Get(h.StateStorage, "netrules")
+	if err != nil {
+		hlog.FromRequest(r).Warn().Err(err).Msg("failed to load network rules, allowing request")
+		return true
+	}
+	if len(n.Rules) == 0 {
+		return true
+	}
+
+	var nw IPNetwork
+	if h.LookupIPNetwork != nil {
+		if v, err := h.LookupIPNetwork(ip); err == nil {
+			nw = v
+		} else {
+			hlog.FromRequest(r).Warn().Err(err).Str("ip", ip.String()).Msg("failed to look up ip network")
+		}
+	}
+
+	for _, x := range n.Rules {
+		if !x.Match(scope, nw) {
+			continue
+		}
+		if x.Action == NetworkRuleAllow {
+			return true
+		}
+		if n.Overridden(time.Now(), uid, r.URL.Query().Get("network_override")) {
+			hlog.FromRequest(r).Info().
+				Str("ip", ip.String()).
+				Str("country", nw.Country).
+				Uint32("asn", nw.ASN).
+				Uint64("uid", uid).
+				Msgf("network deny rule bypassed by override")
+			return true
+		}
+		hlog.FromRequest(r).Info().
+			Str("ip", ip.String()).
+			Str("country", nw.Country).
+			Uint32("asn", nw.ASN).
+			Uint64("uid", uid).
+			Str("scope", string(scope)).
+			Str("note", x.Note).
+			Msgf("rejected request due to network deny rule")
+		return false
+	}
+	return true
+}
+
+func (h *Handler) handleAdminNetworkRules(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "netrules"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PUT, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	var n NetworkRules
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		n, err := h.networkRules.Get(h.StateStorage, "netrules")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load network rules from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"rules":   n,
+			"lookup":  h.LookupIPNetwork != nil,
+		})
+		return
+	case http.MethodPut:
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&n); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid json: %v", err))
+			return
+		}
+		if err := n.Validate(); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", err))
+			return
+		}
+	case http.MethodDelete:
+		// remove all rules and overrides
+	}
+
+	if err := h.networkRules.Update(h.StateStorage, "netrules", func(NetworkRules) (NetworkRules, error) {
+		return n, nil
+	}); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save network rules to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
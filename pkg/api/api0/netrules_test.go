@@ -0,0 +1,231 @@
+package api0
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetworkRuleMatch(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rule  NetworkRule
+		scope NetworkRuleScope
+		nw    IPNetwork
+		match bool
+	}{
+		{"Country", NetworkRule{Countries: []string{"CA", "US"}}, NetworkRuleScopeAuth, IPNetwork{Country: "US"}, true},
+		{"CountryCase", NetworkRule{Countries: []string{"us"}}, NetworkRuleScopeAuth, IPNetwork{Country: "US"}, true},
+		{"CountryOther", NetworkRule{Countries: []string{"CA"}}, NetworkRuleScopeAuth, IPNetwork{Country: "US"}, false},
+		{"CountryUnknown", NetworkRule{Countries: []string{"CA"}}, NetworkRuleScopeAuth, IPNetwork{}, false},
+		{"Wildcard", NetworkRule{Countries: []string{"*"}}, NetworkRuleScopeAuth, IPNetwork{Country: "US"}, true},
+		{"WildcardUnknown", NetworkRule{Countries: []string{"*"}}, NetworkRuleScopeAuth, IPNetwork{}, true},
+		{"ASN", NetworkRule{ASNs: []uint32{64496, 64497}}, NetworkRuleScopeAuth, IPNetwork{ASN: 64497}, true},
+		{"ASNOther", NetworkRule{ASNs: []uint32{64496}}, NetworkRuleScopeAuth, IPNetwork{ASN: 64497}, false},
+		{"ASNUnknown", NetworkRule{ASNs: []uint32{64496}}, NetworkRuleScopeAuth, IPNetwork{Country: "US"}, false},
+		{"CountryOrASN", NetworkRule{Countries: []string{"CA"}, ASNs: []uint32{64496}}, NetworkRuleScopeAuth, IPNetwork{Country: "US", ASN: 64496}, true},
+		{"Scope", NetworkRule{Scope: NetworkRuleScopeAuth, Countries: []string{"*"}}, NetworkRuleScopeAuth, IPNetwork{}, true},
+		{"ScopeOther", NetworkRule{Scope: NetworkRuleScopeServer, Countries: []string{"*"}}, NetworkRuleScopeAuth, IPNetwork{}, false},
+	} {
+		if m := tc.rule.Match(tc.scope, tc.nw); m != tc.match {
+			t.Errorf("%s: expected match=%t", tc.name, tc.match)
+		}
+	}
+}
+
+func TestNetworkRulesValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		n    NetworkRules
+		err  string
+	}{
+		{"Empty", NetworkRules{}, ""},
+		{"Valid", NetworkRules{
+			Rules: []NetworkRule{
+				{Action: NetworkRuleAllow, Scope: NetworkRuleScopeServer, Countries: []string{"ca", "*"}},
+				{Action: NetworkRuleDeny, ASNs: []uint32{64496}, Note: "test"},
+			},
+			Overrides: []NetworkOverride{{UID: 1000}, {Token: strings.Repeat("x", 16)}},
+		}, ""},
+		{"Action", NetworkRules{Rules: []NetworkRule{{Action: "block", Countries: []string{"*"}}}}, "rule 0: invalid action"},
+		{"Scope", NetworkRules{Rules: []NetworkRule{{Action: NetworkRuleDeny, Scope: "client", Countries: []string{"*"}}}}, "rule 0: invalid scope"},
+		{"NoMatch", NetworkRules{Rules: []NetworkRule{{Action: NetworkRuleDeny}}}, "at least one country or asn"},
+		{"Country", NetworkRules{Rules: []NetworkRule{{Action: NetworkRuleDeny, Countries: []string{"USA"}}}}, "invalid country code"},
+		{"CountryChars", NetworkRules{Rules: []NetworkRule{{Action: NetworkRuleDeny, Countries: []string{"U1"}}}}, "invalid country code"},
+		{"ASN", NetworkRules{Rules: []NetworkRule{{Action: NetworkRuleDeny, ASNs: []uint32{0}}}}, "invalid asn"},
+		{"Note", NetworkRules{Rules: []NetworkRule{{Action: NetworkRuleDeny, ASNs: []uint32{1}, Note: strings.Repeat("x", 513)}}}, "note too long"},
+		{"TooMany", NetworkRules{Rules: make([]NetworkRule, maxNetworkRules+1)}, "too many rules"},
+		{"OverrideEmpty", NetworkRules{Overrides: []NetworkOverride{{Note: "test"}}}, "override 0: token or uid is required"},
+		{"OverrideShortToken", NetworkRules{Overrides: []NetworkOverride{{Token: "short"}}}, "at least 16 characters"},
+		{"OverrideLongToken", NetworkRules{Overrides: []NetworkOverride{{Token: strings.Repeat("x", 129)}}}, "too long"},
+	} {
+		err := tc.n.Validate()
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected error %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestNetworkRulesOverridden(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	exp := t0.Add(time.Hour)
+	n := NetworkRules{Overrides: []NetworkOverride{
+		{UID: 1000},
+		{UID: 1001, Expires: &exp},
+		{Token: "0123456789abcdef"},
+	}}
+	for _, tc := range []struct {
+		name  string
+		t     time.Time
+		uid   uint64
+		token string
+		match bool
+	}{
+		{"UID", t0, 1000, "", true},
+		{"UIDOther", t0, 1002, "", false},
+		{"UIDExpires", t0, 1001, "", true},
+		{"UIDExpired", exp, 1001, "", false},
+		{"Token", t0, 0, "0123456789abcdef", true},
+		{"TokenWrong", t0, 0, "0123456789abcde", false},
+		{"None", t0, 0, "", false},
+	} {
+		if m := n.Overridden(tc.t, tc.uid, tc.token); m != tc.match {
+			t.Errorf("%s: expected overridden=%t", tc.name, tc.match)
+		}
+	}
+}
+
+func TestNetworkRules(t *testing.T) {
+	networks := map[netip.Addr]IPNetwork{
+		netip.MustParseAddr("192.0.2.1"): {Country: "CA", ASN: 64496},
+		netip.MustParseAddr("192.0.2.2"): {Country: "US", ASN: 64497},
+		netip.MustParseAddr("192.0.2.3"): {Country: "US", ASN: 64498},
+	}
+	h := &Handler{
+		AccountStorage:               new(testAccountStorage),
+		PdataStorage:                 new(testPdataStorage),
+		StateStorage:                 new(testStateStorage),
+		AdminSecret:                  "secret",
+		InsecureDevNoCheckPlayerAuth: true,
+		LookupIPNetwork: func(a netip.Addr) (IPNetwork, error) {
+			if nw, ok := networks[a]; ok {
+				return nw, nil
+			}
+			return IPNetwork{}, errors.New("not found")
+		},
+	}
+	admin := func(method, body string) (int, map[string]any) {
+		r := httptest.NewRequest(method, "/admin/netrules", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.handleAdminNetworkRules(w, r)
+
+		var obj map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &obj); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		return w.Code, obj
+	}
+	check := func(scope NetworkRuleScope, ip string, uid uint64, override string) bool {
+		r := httptest.NewRequest(http.MethodGet, "/?network_override="+override, nil)
+		return h.checkNetworkRules(r, scope, netip.MustParseAddr(ip), uid)
+	}
+
+	if !check(NetworkRuleScopeAuth, "192.0.2.2", 1000, "") {
+		t.Errorf("expected ip to be allowed without rules")
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/admin/netrules", nil)
+	w := httptest.NewRecorder()
+	h.handleAdminNetworkRules(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without admin secret, got %d", w.Code)
+	}
+	if st, _ := admin(http.MethodPut, `{"rules":[{"action":"deny","countries":["USA"]}]}`); st != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid rules, got %d", st)
+	}
+	if st, _ := admin(http.MethodPut, `{`); st != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid json, got %d", st)
+	}
+	if st, obj := admin(http.MethodPut, `{
+		"rules": [
+			{"action": "allow", "asns": [64498]},
+			{"action": "deny", "scope": "server", "countries": ["CA"]},
+			{"action": "deny", "countries": ["US", "*"], "note": "test"}
+		],
+		"overrides": [
+			{"uid": 1001},
+			{"token": "0123456789abcdef"}
+		]
+	}`); st != http.StatusOK {
+		t.Fatalf("unexpected status %d: %v", st, obj)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		scope    NetworkRuleScope
+		ip       string
+		uid      uint64
+		override string
+		allowed  bool
+	}{
+		{"Deny", NetworkRuleScopeAuth, "192.0.2.2", 1000, "", false},
+		{"DenyServer", NetworkRuleScopeServer, "192.0.2.2", 0, "", false},
+		{"Allow", NetworkRuleScopeAuth, "192.0.2.3", 1000, "", true},
+		{"ScopeFallthrough", NetworkRuleScopeAuth, "192.0.2.1", 1000, "", false},
+		{"ScopeServer", NetworkRuleScopeServer, "192.0.2.1", 0, "", false},
+		{"Wildcard", NetworkRuleScopeAuth, "192.0.2.4", 1000, "", false},
+		{"OverrideUID", NetworkRuleScopeAuth, "192.0.2.2", 1001, "", true},
+		{"OverrideUIDServer", NetworkRuleScopeServer, "192.0.2.2", 0, "1001", false},
+		{"OverrideToken", NetworkRuleScopeServer, "192.0.2.2", 0, "0123456789abcdef", true},
+		{"OverrideTokenWrong", NetworkRuleScopeServer, "192.0.2.2", 0, "0123456789abcdeg", false},
+	} {
+		if a := check(tc.scope, tc.ip, tc.uid, tc.override); a != tc.allowed {
+			t.Errorf("%s: expected allowed=%t", tc.name, tc.allowed)
+		}
+	}
+
+	// player auth is blocked
+	for ip, status := range map[string]int{
+		"192.0.2.2": http.StatusForbidden,
+		"192.0.2.3": http.StatusOK,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/client/origin_auth?id=1000", nil)
+		r.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		h.handleClientOriginAuth(w, r)
+		if w.Code != status {
+			t.Errorf("auth from %s: expected status %d, got %d", ip, status, w.Code)
+		} else if status != http.StatusOK && !strings.Contains(w.Body.String(), string(ErrorCode_NETWORK_BLOCKED)) {
+			t.Errorf("auth from %s: expected %s error, got %s", ip, ErrorCode_NETWORK_BLOCKED, w.Body.String())
+		}
+	}
+
+	st, obj := admin(http.MethodGet, "")
+	if st != http.StatusOK {
+		t.Fatalf("unexpected status %d", st)
+	}
+	if rules, _ := obj["rules"].(map[string]any); rules == nil || len(rules["rules"].([]any)) != 3 || len(rules["overrides"].([]any)) != 2 {
+		t.Errorf("incorrect rules %v", obj["rules"])
+	}
+	if obj["lookup"] != true {
+		t.Errorf("expected lookup to be available")
+	}
+
+	if st, _ := admin(http.MethodDelete, ""); st != http.StatusOK {
+		t.Fatalf("unexpected status %d", st)
+	}
+	if !check(NetworkRuleScopeAuth, "192.0.2.2", 1000, "") {
+		t.Errorf("expected ip to be allowed after deleting rules")
+	}
+}
@@ -0,0 +1,76 @@
+package api0
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// nonceStore tracks consumed single-use tokens until they expire. It is bounded
+// by discarding the oldest tokens when full. It is safe for concurrent use.
+type nonceStore struct {
+	mu sync.Mutex
+	m  map[[sha256.Size]byte]time.Time
+	q  []nonceEntry // ordered by expiry since the ttl is fixed
+	h  int          // index of the oldest entry in q
+}
+
+type nonceEntry struct {
+	k   [sha256.Size]byte
+	exp time.Time
+}
+
+// Consume marks token as used until t+ttl, returning false if it has already
+// been used. If there are more than max tokens, the oldest ones are forgotten.
+func (s *nonceStore) Consume(token string, t time.Time, ttl time.Duration, max int) bool {
+	k := sha256.Sum256([]byte(token))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.m == nil {
+		s.m = make(map[[sha256.Size]byte]time.Time)
+	}
+
+	for s.h < len(s.q) && (!t.Before(s.q[s.h].exp) || len(s.q)-s.h >= max) {
+		if e := s.q[s.h]; s.m[e.k] == e.exp {
+			delete(s.m, e.k)
+		}
+		s.h++
+	}
+	if s.h != 0 && s.h >= len(s.q)/2 {
+		// only move the remaining entries once at least half of them have
+		// been removed so removal is amortized O(1)
+		s.q = append(s.q[:0], s.q[s.h:]...)
+		s.h = 0
+	}
+
+	if exp, ok := s.m[k]; ok && t.Before(exp) {
+		return false
+	}
+
+	exp := t.Add(ttl)
+	s.m[k] = exp
+	s.q = append(s.q, nonceEntry{k, exp})
+	return true
+}
+
+// Release forgets token if it was consumed, allowing it to be used again. This
+// is for when a request fails after consuming it for reasons other than the
+// token itself.
+func (s *nonceStore) Release(token string) {
+	k := sha256.Sum256([]byte(token))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.m, k)
+}
+
+// Len returns the number of remembered tokens, including expired ones which
+// haven't been removed yet.
+func (s *nonceStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.m)
+}
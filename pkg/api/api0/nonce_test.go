@@ -0,0 +1,75 @@
+package api0
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNonceStore(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+
+	t.Run("Reuse", func(t *testing.T) {
+		var s nonceStore
+		if !s.Consume("a", t0, time.Minute, 10) {
+			t.Fatalf("first use of token rejected")
+		}
+		if s.Consume("a", t0.Add(time.Second), time.Minute, 10) {
+			t.Errorf("reused token accepted")
+		}
+		if !s.Consume("b", t0.Add(time.Second), time.Minute, 10) {
+			t.Errorf("different token rejected")
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		var s nonceStore
+		s.Consume("a", t0, time.Minute, 10)
+		if s.Consume("a", t0.Add(time.Minute-time.Nanosecond), time.Minute, 10) {
+			t.Errorf("token accepted before expiry")
+		}
+		if !s.Consume("a", t0.Add(time.Minute), time.Minute, 10) {
+			t.Errorf("token rejected after expiry")
+		}
+		if n := s.Len(); n != 1 {
+			t.Errorf("expected expired tokens to be removed, got %d remembered", n)
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		var s nonceStore
+		s.Consume("a", t0, time.Minute, 10)
+		s.Release("a")
+		if !s.Consume("a", t0.Add(time.Second), time.Minute, 10) {
+			t.Errorf("released token rejected")
+		}
+		if s.Consume("a", t0.Add(time.Second*2), time.Minute, 10) {
+			t.Errorf("reused token accepted after being consumed again")
+		}
+		s.Release("b") // not consumed
+		if n := s.Len(); n != 1 {
+			t.Errorf("expected 1 remembered token, got %d", n)
+		}
+	})
+
+	t.Run("Bounded", func(t *testing.T) {
+		var s nonceStore
+		for i := 0; i < 25; i++ {
+			if !s.Consume(strconv.Itoa(i), t0.Add(time.Duration(i)), time.Minute, 10) {
+				t.Fatalf("token %d rejected", i)
+			}
+			if n := s.Len(); n > 10 {
+				t.Fatalf("expected at most 10 remembered tokens, got %d", n)
+			}
+			if n := len(s.q); n > 20 {
+				t.Fatalf("expected removed tokens to be compacted, got %d queued", n)
+			}
+		}
+		if !s.Consume("0", t0.Add(time.Second), time.Minute, 10) {
+			t.Errorf("expected oldest token to be forgotten")
+		}
+		if s.Consume("24", t0.Add(time.Second), time.Minute, 10) {
+			t.Errorf("expected newest token to be remembered")
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package api0
+
+import "time"
+
+// NotificationType is the type of an operational notification.
+type NotificationType string
+
+const (
+	NotificationAnomalyTarpit NotificationType = "anomaly_tarpit" // an ip or subnet was tarpitted due to repeated failures
+	NotificationAnomalyBlock  NotificationType = "anomaly_block"  // an ip or subnet was blocked due to repeated failures
+	NotificationMirrorDown    NotificationType = "mirror_down"    // a download url failed its health check
+	NotificationMirrorUp      NotificationType = "mirror_up"      // a download url which was down passed its health check
+	NotificationSLOBurn       NotificationType = "slo_burn"       // an endpoint class is consuming its error budget too quickly
+	NotificationSLOResolved   NotificationType = "slo_resolved"   // an slo burn-rate alert has resolved
+)
+
+// Notification is an operational event for operators and moderators (e.g.,
+// automatic abuse mitigations).
+type Notification struct {
+	Time    time.Time         `json:"time"`
+	Type    NotificationType  `json:"type"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// notify sends n to Notify, if set.
+func (h *Handler) notify(n Notification) {
+	if h.Notify != nil {
+		if n.Time.IsZero() {
+			n.Time = time.Now().UTC()
+		}
+		h.Notify(n)
+	}
+}
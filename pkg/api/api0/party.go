@@ -0,0 +1,654 @@
+package api0
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/websocket"
+	"github.com/rs/zerolog/hlog"
+)
+
+const (
+	partyCodeTTL   = time.Minute * 10 // how long party codes are valid
+	partyInviteTTL = time.Minute * 10 // how long invites are valid
+	partyIdleTTL   = time.Minute * 30 // how long parties without connected members are kept
+	partyCodeChars = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	partyCodeLen   = 6
+)
+
+// partyStore stores parties in memory. It is safe for concurrent use.
+type partyStore struct {
+	mu        sync.Mutex
+	parties   map[string]*party // by id
+	byUID     map[uint64]*party
+	byCode    map[string]*party
+	lastPrune time.Time
+}
+
+type party struct {
+	id          string
+	leader      uint64
+	members     []partyMember // in join order
+	code        string
+	codeExpires time.Time
+	invites     map[uint64]time.Time // uid -> expiry
+	server      string               // the server the leader last joined
+	active      time.Time            // when a member was last connected
+	changed     chan struct{}        // closed and replaced when the party changes
+	deleted     bool
+}
+
+type partyMember struct {
+	uid      uint64
+	username string
+	online   int // number of connected presence websockets
+}
+
+// partyJSON is the public representation of a party.
+type partyJSON struct {
+	ID          string            `json:"id"`
+	Leader      uint64            `json:"leader"`
+	Code        string            `json:"code,omitempty"`
+	CodeExpires int64             `json:"code_expires,omitempty"`
+	Server      string            `json:"server,omitempty"`
+	Members     []partyMemberJSON `json:"members"`
+	Invites     []uint64          `json:"invites,omitempty"`
+}
+
+type partyMemberJSON struct {
+	UID      uint64 `json:"uid"`
+	Username string `json:"username,omitempty"`
+	Online   bool   `json:"online"`
+}
+
+// json gets the current state of the party. The store mutex must be held.
+func (p *party) json(now time.Time) *partyJSON {
+	x := &partyJSON{
+		ID:      p.id,
+		Leader:  p.leader,
+		Server:  p.server,
+		Members: make([]partyMemberJSON, len(p.members)),
+	}
+	if p.code != "" && now.Before(p.codeExpires) {
+		x.Code, x.CodeExpires = p.code, p.codeExpires.Unix()
+	}
+	for i, m := range p.members {
+		x.Members[i] = partyMemberJSON{
+			UID:      m.uid,
+			Username: m.username,
+			Online:   m.online != 0,
+		}
+	}
+	for uid, exp := range p.invites {
+		if now.Before(exp) {
+			x.Invites = append(x.Invites, uid)
+		}
+	}
+	return x
+}
+
+// notify wakes up presence websockets. The store mutex must be held.
+func (p *party) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *party) member(uid uint64) *partyMember {
+	for i := range p.members {
+		if p.members[i].uid == uid {
+			return &p.members[i]
+		}
+	}
+	return nil
+}
+
+// hasMember checks whether any of the party members are in uids.
+func (p *party) hasMember(uids map[uint64]struct{}) bool {
+	for _, m := range p.members {
+		if _, ok := uids[m.uid]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// setCode generates a new party code. The store mutex must be held.
+func (s *partyStore) setCode(p *party, now time.Time) error {
+	if p.code != "" && s.byCode[p.code] == p {
+		delete(s.byCode, p.code)
+	}
+	for {
+		var b [partyCodeLen]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return err
+		}
+		for i := range b {
+			b[i] = partyCodeChars[int(b[i])%len(partyCodeChars)]
+		}
+		if x, ok := s.byCode[string(b[:])]; !ok || !now.Before(x.codeExpires) {
+			p.code, p.codeExpires = string(b[:]), now.Add(partyCodeTTL)
+			s.byCode[p.code] = p
+			return nil
+		}
+	}
+}
+
+// prune removes expired codes, invites, and idle parties. The store mutex must
+// be held.
+func (s *partyStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+	for code, p := range s.byCode {
+		if !now.Before(p.codeExpires) {
+			delete(s.byCode, code)
+		}
+	}
+	for _, p := range s.parties {
+		for uid, exp := range p.invites {
+			if !now.Before(exp) {
+				delete(p.invites, uid)
+			}
+		}
+		var online bool
+		for _, m := range p.members {
+			online = online || m.online != 0
+		}
+		if online {
+			p.active = now
+		} else if now.Sub(p.active) > partyIdleTTL {
+			for _, m := range p.members {
+				delete(s.byUID, m.uid)
+			}
+			s.remove(p)
+		}
+	}
+}
+
+// remove deletes an empty party. The store mutex must be held.
+func (s *partyStore) remove(p *party) {
+	delete(s.parties, p.id)
+	if s.byCode[p.code] == p {
+		delete(s.byCode, p.code)
+	}
+	p.deleted = true
+	p.notify()
+}
+
+// leave removes uid from its party, returning false if it wasn't in one. The
+// store mutex must be held.
+func (s *partyStore) leave(uid uint64) bool {
+	p, ok := s.byUID[uid]
+	if !ok {
+		return false
+	}
+	delete(s.byUID, uid)
+	for i, m := range p.members {
+		if m.uid == uid {
+			p.members = append(p.members[:i], p.members[i+1:]...)
+			break
+		}
+	}
+	if len(p.members) == 0 {
+		s.remove(p)
+		return true
+	}
+	if p.leader == uid {
+		p.leader = p.members[0].uid
+		p.server = ""
+	}
+	p.notify()
+	return true
+}
+
+// get gets the current state of the party uid is in, or nil.
+func (s *partyStore) get(uid uint64) *partyJSON {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.byUID[uid]; ok {
+		return p.json(time.Now())
+	}
+	return nil
+}
+
+// invitesFor gets the ids of the parties uid has been invited to.
+func (s *partyStore) invitesFor(uid uint64) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	ids := []string{}
+	for _, p := range s.parties {
+		if now.Before(p.invites[uid]) {
+			ids = append(ids, p.id)
+		}
+	}
+	return ids
+}
+
+// queue gets the members of the party led by uid. If uid isn't in a party,
+// only uid is returned. If uid is in a party but isn't the leader, ok is
+// false.
+func (s *partyStore) queue(uid uint64) (uids []uint64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.byUID[uid]
+	if !ok {
+		return []uint64{uid}, true
+	}
+	if p.leader != uid {
+		return nil, false
+	}
+	for _, m := range p.members {
+		uids = append(uids, m.uid)
+	}
+	return uids, true
+}
+
+// following checks whether uid's party leader is on the server with the
+// provided id.
+func (s *partyStore) following(uid uint64, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.byUID[uid]
+	return ok && p.leader != uid && p.server != "" && p.server == id
+}
+
+// blocked removes pending invites between a and b.
+func (s *partyStore) blocked(a, b uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, x := range [][2]uint64{{a, b}, {b, a}} {
+		if p, ok := s.byUID[x[0]]; ok {
+			if _, ok := p.invites[x[1]]; ok {
+				delete(p.invites, x[1])
+				p.notify()
+			}
+		}
+	}
+}
+
+// joinedServer records that uid joined the server with the provided id. If uid
+// is a party leader, the other members are notified.
+func (s *partyStore) joinedServer(uid uint64, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.byUID[uid]; ok && p.leader == uid && p.server != id {
+		p.server = id
+		p.notify()
+	}
+}
+
+func (h *Handler) handleClientParty(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().client_party_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.PartyMaxSize <= 0 {
+		h.m().client_party_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("parties are not enabled"))
+		return
+	}
+
+	uid, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		h.m().client_party_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	acct, err := h.AccountStorage.GetAccount(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read account from storage")
+		h.m().client_party_requests_total.fail_storage_error_account.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if acct == nil {
+		h.m().client_party_requests_total.reject_player_not_found.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+		return
+	}
+	if !h.checkPlayerToken(acct, r.URL.Query().Get("token")) {
+		h.m().client_party_requests_total.reject_masterserver_token.Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_INVALID_MASTERSERVER_TOKEN.MessageObj())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		h.handleClientPartyAction(w, r, acct)
+
+	case http.MethodDelete:
+		s := &h.parties
+		s.mu.Lock()
+		left := s.leave(uid)
+		s.mu.Unlock()
+		if left {
+			h.matchmaking.leave(h, uid, time.Now())
+		}
+		h.m().client_party_requests_total.success_leave.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"left":    left,
+		})
+
+	default:
+		if !websocket.IsUpgrade(r) {
+			h.m().client_party_requests_total.success_status.Inc()
+			respJSON(w, r, http.StatusOK, map[string]any{
+				"success": true,
+				"party":   h.parties.get(uid),
+				"invites": h.parties.invitesFor(uid),
+			})
+			return
+		}
+		h.serveClientPartyPresence(w, r, uid)
+	}
+}
+
+func (h *Handler) handleClientPartyAction(w http.ResponseWriter, r *http.Request, acct *Account) {
+	var q struct {
+		Action string `param:"action" validate:"required,oneof=create|join|invite|kick|code|leave"`
+		Code   string `param:"code" validate:"max=16"`
+		Party  string `param:"party" validate:"max=64"`
+		UID    uint64 `param:"uid"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().client_party_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+
+	fail := func(status int, msg string) {
+		h.m().client_party_requests_total.reject_bad_request.Inc()
+		respFail(w, r, status, ErrorCode_BAD_REQUEST.MessageObjf("%s", msg))
+	}
+
+	// players can't be invited to or join a party with someone they've
+	// blocked or been blocked by
+	var blocks map[uint64]struct{}
+	if q.Action == "invite" || q.Action == "join" {
+		x := acct.UID
+		if q.Action == "invite" {
+			x = q.UID
+		}
+		var err error
+		if blocks, err = h.blockSet(x); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", x).
+				Msgf("failed to read blocks from storage")
+			h.m().client_party_requests_total.fail_storage_error_blocks.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+	}
+
+	now := time.Now()
+	s := &h.parties
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.parties == nil {
+		s.parties = map[string]*party{}
+		s.byUID = map[uint64]*party{}
+		s.byCode = map[string]*party{}
+	}
+	s.prune(now)
+
+	p := s.byUID[acct.UID]
+	switch q.Action {
+	case "create":
+		if p != nil {
+			fail(http.StatusBadRequest, "already in a party")
+			return
+		}
+		id, err := cryptoRandHex(16)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to generate random party id")
+			h.m().client_party_requests_total.fail_other_error.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		p = &party{
+			id:      id,
+			leader:  acct.UID,
+			members: []partyMember{{uid: acct.UID, username: acct.Username}},
+			invites: map[uint64]time.Time{},
+			active:  now,
+			changed: make(chan struct{}),
+		}
+		if err := s.setCode(p, now); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to generate random party code")
+			h.m().client_party_requests_total.fail_other_error.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		s.parties[p.id] = p
+		s.byUID[acct.UID] = p
+		h.matchmaking.leave(h, acct.UID, now)
+		h.m().client_party_requests_total.success_create.Inc()
+
+	case "join":
+		var x *party
+		if q.Code != "" {
+			if c, ok := s.byCode[q.Code]; ok && now.Before(c.codeExpires) {
+				x = c
+			}
+		} else if q.Party != "" {
+			if c, ok := s.parties[q.Party]; ok && now.Before(c.invites[acct.UID]) {
+				x = c
+			}
+		} else {
+			fail(http.StatusBadRequest, "code or party param is required")
+			return
+		}
+		if x == nil {
+			h.m().client_party_requests_total.reject_party_not_found.Inc()
+			respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("no such party, or the code or invite has expired"))
+			return
+		}
+		if x == p {
+			break
+		}
+		if len(x.members) >= h.PartyMaxSize {
+			h.m().client_party_requests_total.reject_party_full.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_BAD_REQUEST.MessageObjf("party is full"))
+			return
+		}
+		if x.hasMember(blocks) {
+			h.m().client_party_requests_total.reject_blocked.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_BAD_REQUEST.MessageObjf("blocked by or blocking a party member"))
+			return
+		}
+		if p != nil {
+			s.leave(acct.UID)
+		}
+		h.matchmaking.leave(h, x.leader, now) // the party queue no longer has everyone
+		h.matchmaking.leave(h, acct.UID, now)
+		delete(x.invites, acct.UID)
+		x.members = append(x.members, partyMember{uid: acct.UID, username: acct.Username})
+		s.byUID[acct.UID] = x
+		x.notify()
+		p = x
+		h.m().client_party_requests_total.success_join.Inc()
+
+	case "invite", "kick", "code":
+		if p == nil {
+			fail(http.StatusBadRequest, "not in a party")
+			return
+		}
+		if p.leader != acct.UID {
+			h.m().client_party_requests_total.reject_not_leader.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_BAD_REQUEST.MessageObjf("only the party leader can %s", q.Action))
+			return
+		}
+		switch q.Action {
+		case "invite":
+			if q.UID == 0 || q.UID == acct.UID {
+				fail(http.StatusBadRequest, "uid param is required")
+				return
+			}
+			if p.member(q.UID) == nil {
+				if p.hasMember(blocks) {
+					h.m().client_party_requests_total.reject_blocked.Inc()
+					respFail(w, r, http.StatusForbidden, ErrorCode_BAD_REQUEST.MessageObjf("player is blocked by or blocking a party member"))
+					return
+				}
+				p.invites[q.UID] = now.Add(partyInviteTTL)
+			}
+		case "kick":
+			if q.UID == 0 || q.UID == acct.UID || p.member(q.UID) == nil {
+				fail(http.StatusBadRequest, "uid param must be another party member")
+				return
+			}
+			s.leave(q.UID)
+			h.matchmaking.leave(h, acct.UID, now)
+		case "code":
+			if err := s.setCode(p, now); err != nil {
+				hlog.FromRequest(r).Error().
+					Err(err).
+					Msgf("failed to generate random party code")
+				h.m().client_party_requests_total.fail_other_error.Inc()
+				respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+				return
+			}
+		}
+		p.notify()
+		h.m().client_party_requests_total.success_update.Inc()
+
+	case "leave":
+		if s.leave(acct.UID) {
+			h.matchmaking.leave(h, acct.UID, now)
+		}
+		p = nil
+		h.m().client_party_requests_total.success_leave.Inc()
+	}
+
+	var obj *partyJSON
+	if p != nil {
+		p.active = now
+		obj = p.json(now)
+	}
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"party":   obj,
+	})
+}
+
+// serveClientPartyPresence marks uid as online while connected, and sends the
+// party state over a WebSocket whenever it changes. The connection is closed
+// once uid is no longer in the party.
+func (h *Handler) serveClientPartyPresence(w http.ResponseWriter, r *http.Request, uid uint64) {
+	s := &h.parties
+	s.mu.Lock()
+	p, ok := s.byUID[uid]
+	if ok {
+		p.member(uid).online++
+		p.notify()
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		h.m().client_party_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("not in a party"))
+		return
+	}
+	defer func() {
+		s.mu.Lock()
+		if m := p.member(uid); m != nil && m.online > 0 && !p.deleted {
+			m.online--
+			p.active = time.Now()
+			p.notify()
+		}
+		s.mu.Unlock()
+	}()
+
+	c, err := websocket.Upgrade(w, r)
+	if err != nil {
+		h.m().client_party_requests_total.reject_bad_request.Inc()
+		hlog.FromRequest(r).Debug().Err(err).Msg("failed to upgrade party presence websocket")
+		return
+	}
+	defer c.Close()
+	h.m().client_party_requests_total.success_presence.Inc()
+
+	// read (and discard) messages so we notice when the client goes away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := c.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	tk := time.NewTicker(time.Second * 30)
+	defer tk.Stop()
+
+	for {
+		s.mu.Lock()
+		var obj *partyJSON
+		if !p.deleted && p.member(uid) != nil {
+			obj = p.json(time.Now())
+		}
+		changed := p.changed
+		s.mu.Unlock()
+
+		buf, err := json.Marshal(map[string]any{
+			"party": obj,
+		})
+		if err != nil {
+			panic(err)
+		}
+		if c.WriteText(buf) != nil || obj == nil {
+			return
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-changed:
+				waiting = false
+			case <-tk.C:
+				if c.Write(websocket.OpPing, nil) != nil {
+					return
+				}
+			case <-gone:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package api0
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/pdata"
+	"github.com/rs/zerolog/hlog"
+)
+
+// maxPdataRules is the maximum number of pdata rules.
+const maxPdataRules = 256
+
+// PdataRuleAction is the action taken for pdata writes violating a PdataRule.
+type PdataRuleAction string
+
+const (
+	PdataRuleFlag   PdataRuleAction = "flag"   // save the pdata, but report it
+	PdataRuleReject PdataRuleAction = "reject" // keep the previous pdata and report it
+)
+
+// PdataRule is a plausibility check for pdata written by game servers.
+type PdataRule struct {
+	Action PdataRuleAction `json:"action"`
+
+	// Field is the path of the numeric pdata field to check (see
+	// pdata.Pdata.Numbers), e.g., "gen" or "xp_match.*".
+	Field string `json:"field"`
+
+	// Min and Max, if set, are the allowed range for the value.
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+
+	// MaxIncrease and MaxDecrease, if set, limit how much the value can change
+	// in a single write.
+	MaxIncrease *float64 `json:"max_increase,omitempty"`
+	MaxDecrease *float64 `json:"max_decrease,omitempty"`
+
+	// Note is an optional note about why the rule was added.
+	Note string `json:"note,omitempty"`
+}
+
+// PdataRules contains plausibility checks for pdata writes. A write is
+// rejected if it violates any reject rule.
+type PdataRules struct {
+	Rules []PdataRule `json:"rules"`
+}
+
+// Validate checks if the rules in n are valid.
+func (n PdataRules) Validate() error {
+	if len(n.Rules) > maxPdataRules {
+		return fmt.Errorf("too many rules (max %d)", maxPdataRules)
+	}
+	var pd pdata.Pdata
+	if err := pd.UnmarshalBinary(pdata.DefaultPdata); err != nil {
+		panic(fmt.Errorf("invalid default pdata: %w", err))
+	}
+	for i, x := range n.Rules {
+		switch x.Action {
+		case PdataRuleFlag, PdataRuleReject:
+		default:
+			return fmt.Errorf("rule %d: invalid action %q", i, x.Action)
+		}
+		if _, err := pd.Numbers(x.Field); err != nil {
+			return fmt.Errorf("rule %d: invalid field: %w", i, err)
+		}
+		if x.Min == nil && x.Max == nil && x.MaxIncrease == nil && x.MaxDecrease == nil {
+			return fmt.Errorf("rule %d: at least one of min, max, max_increase, or max_decrease is required", i)
+		}
+		if x.Min != nil && x.Max != nil && *x.Min > *x.Max {
+			return fmt.Errorf("rule %d: min must not be greater than max", i)
+		}
+		if (x.MaxIncrease != nil && *x.MaxIncrease < 0) || (x.MaxDecrease != nil && *x.MaxDecrease < 0) {
+			return fmt.Errorf("rule %d: max_increase and max_decrease must not be negative", i)
+		}
+		if len(x.Note) > 512 {
+			return fmt.Errorf("rule %d: note too long", i)
+		}
+	}
+	return nil
+}
+
+// PdataRuleViolation is a value which violates a PdataRule.
+type PdataRuleViolation struct {
+	Rule  PdataRule
+	Index int // if the field matches multiple values
+	Old   float64
+	New   float64
+}
+
+func (v PdataRuleViolation) String() string {
+	field := v.Rule.Field
+	if strings.Contains(field, "*") {
+		field += "[" + strconv.Itoa(v.Index) + "]"
+	}
+	return fmt.Sprintf("%s %s: %g -> %g", v.Rule.Action, field, v.Old, v.New)
+}
+
+// Check checks the change from prev to next against the rules. At most one
+// violation is returned per rule.
+func (n PdataRules) Check(prev, next *pdata.Pdata) []PdataRuleViolation {
+	var vs []PdataRuleViolation
+	for _, x := range n.Rules {
+		ov, err := prev.Numbers(x.Field)
+		if err != nil {
+			continue
+		}
+		nv, err := next.Numbers(x.Field)
+		if err != nil || len(ov) != len(nv) {
+			continue
+		}
+		for i := range nv {
+			o, v := ov[i], nv[i]
+			if (x.Min != nil && v < *x.Min) ||
+				(x.Max != nil && v > *x.Max) ||
+				(x.MaxIncrease != nil && v-o > *x.MaxIncrease) ||
+				(x.MaxDecrease != nil && o-v > *x.MaxDecrease) {
+				vs = append(vs, PdataRuleViolation{
+					Rule:  x,
+					Index: i,
+					Old:   o,
+					New:   v,
+				})
+				break
+			}
+		}
+	}
+	return vs
+}
+
+// checkPdataRules checks a pdata write from srv (nil for own servers) against
+// n, reporting violations to the moderation queue. It returns false if the
+// write should be rejected. If the pdata can't be parsed, the write is
+// allowed.
+func (h *Handler) checkPdataRules(r *http.Request, n PdataRules, uid uint64, srv *Server, prev, next []byte) bool {
+	if len(n.Rules) == 0 {
+		return true
+	}
+
+	var opd, npd pdata.Pdata
+	if err := opd.UnmarshalBinary(prev); err != nil {
+		hlog.FromRequest(r).Warn().Err(err).Uint64("uid", uid).Msg("failed to parse previous pdata, skipping pdata rules")
+		return true
+	}
+	if err := npd.UnmarshalBinary(next); err != nil {
+		hlog.FromRequest(r).Warn().Err(err).Uint64("uid", uid).Msg("failed to parse new pdata, skipping pdata rules")
+		return true
+	}
+
+	vs := n.Check(&opd, &npd)
+	if len(vs) == 0 {
+		return true
+	}
+
+	allow := true
+	evidence := make([]string, len(vs))
+	for i, v := range vs {
+		if v.Rule.Action == PdataRuleReject {
+			allow = false
+			h.m().accounts_writepersistence_pdata_rule_violations_total.reject.Inc()
+		} else {
+			h.m().accounts_writepersistence_pdata_rule_violations_total.flag.Inc()
+		}
+		evidence[i] = v.String()
+		hlog.FromRequest(r).Warn().
+			Uint64("uid", uid).
+			Str("action", string(v.Rule.Action)).
+			Str("field", v.Rule.Field).
+			Int("index", v.Index).
+			Float64("old", v.Old).
+			Float64("new", v.New).
+			Str("note", v.Rule.Note).
+			Msgf("pdata write violates rule")
+	}
+
+	// one report per player per window is enough for a moderator to look
+	// into it
+	if h.AbuseReportStorage != nil && h.reportDupes.Allow([2]uint64{0, uid}, time.Now(), 1, h.reportRateWindow()) {
+		rid, err := cryptoRandHex(32)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to generate random report id")
+			return allow
+		}
+		rp := AbuseReport{
+			ID:       rid,
+			Time:     time.Now(),
+			Reported: uid,
+			Reason:   "pdata_rule",
+			Evidence: strings.Join(evidence, "\n"),
+			Context: map[string]string{
+				"rejected": strconv.FormatBool(!allow),
+			},
+			Status: AbuseReportOpen,
+		}
+		if srv != nil {
+			rp.ServerID = srv.ID
+			rp.ServerName = srv.Name
+			rp.ServerAddr = srv.Addr
+			rp.Map = srv.Map
+			rp.Playlist = srv.Playlist
+		}
+		if err := h.AbuseReportStorage.SaveAbuseReport(&rp); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to save pdata rule report to storage")
+		} else {
+			hlog.FromRequest(r).Info().
+				Str("report", rp.ID).
+				Uint64("reported", rp.Reported).
+				Msgf("pdata rule report submitted")
+		}
+	}
+	return allow
+}
+
+func (h *Handler) handleAdminPdataRules(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "pdatarules"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PUT, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	var n PdataRules
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		n, err := h.pdataRules.Get(h.StateStorage, "pdatarules")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load pdata rules from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"rules":   n,
+		})
+		return
+	case http.MethodPut:
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&n); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid json: %v", err))
+			return
+		}
+		if err := n.Validate(); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", err))
+			return
+		}
+	case http.MethodDelete:
+		// remove all rules
+	}
+
+	if err := h.pdataRules.Update(h.StateStorage, "pdatarules", func(PdataRules) (PdataRules, error) {
+		return n, nil
+	}); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save pdata rules to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
@@ -0,0 +1,257 @@
+package api0
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/r2northstar/atlas/pkg/pdata"
+)
+
+// testAbuseReportStorage is a minimal in-memory AbuseReportStorage.
+type testAbuseReportStorage struct {
+	mu sync.Mutex
+	rs []AbuseReport
+}
+
+func (s *testAbuseReportStorage) GetAbuseReport(id string) (*AbuseReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.rs {
+		if r.ID == id {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *testAbuseReportStorage) GetAbuseReports(status AbuseReportStatus, reported uint64, limit int) ([]AbuseReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rs []AbuseReport
+	for _, r := range s.rs {
+		if (status == "" || r.Status == status) && (reported == 0 || r.Reported == reported) && (limit <= 0 || len(rs) < limit) {
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil
+}
+
+func (s *testAbuseReportStorage) SaveAbuseReport(r *AbuseReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, x := range s.rs {
+		if x.ID == r.ID {
+			s.rs[i] = *r
+			return nil
+		}
+	}
+	s.rs = append(s.rs, *r)
+	return nil
+}
+
+func (s *testAbuseReportStorage) DeleteAbuseReports(uid uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs := s.rs[:0]
+	for _, r := range s.rs {
+		if r.Reporter != uid && r.Reported != uid {
+			rs = append(rs, r)
+		}
+	}
+	s.rs = rs
+	return nil
+}
+
+// testPdata returns the default pdata modified by fn.
+func testPdata(t *testing.T, fn func(pd *pdata.Pdata)) []byte {
+	t.Helper()
+	var pd pdata.Pdata
+	if err := pd.UnmarshalBinary(pdata.DefaultPdata); err != nil {
+		t.Fatalf("parse default pdata: %v", err)
+	}
+	fn(&pd)
+	buf, err := pd.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal pdata: %v", err)
+	}
+	return buf
+}
+
+func TestPdataRulesValidate(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	for _, tc := range []struct {
+		name string
+		n    PdataRules
+		err  string
+	}{
+		{"Empty", PdataRules{}, ""},
+		{"Valid", PdataRules{Rules: []PdataRule{
+			{Action: PdataRuleReject, Field: "gen", Min: f(0), Max: f(10)},
+			{Action: PdataRuleFlag, Field: "xp_match.*", MaxIncrease: f(100), MaxDecrease: f(0), Note: "test"},
+		}}, ""},
+		{"Action", PdataRules{Rules: []PdataRule{{Action: "block", Field: "gen", Max: f(1)}}}, "rule 0: invalid action"},
+		{"Field", PdataRules{Rules: []PdataRule{{Action: PdataRuleFlag, Field: "nonexistent", Max: f(1)}}}, "invalid field"},
+		{"FieldNotNumber", PdataRules{Rules: []PdataRule{{Action: PdataRuleFlag, Field: "xp_match", Max: f(1)}}}, "invalid field"},
+		{"NoCheck", PdataRules{Rules: []PdataRule{{Action: PdataRuleFlag, Field: "gen"}}}, "at least one of"},
+		{"Range", PdataRules{Rules: []PdataRule{{Action: PdataRuleFlag, Field: "gen", Min: f(2), Max: f(1)}}}, "min must not be greater than max"},
+		{"Negative", PdataRules{Rules: []PdataRule{{Action: PdataRuleFlag, Field: "gen", MaxIncrease: f(-1)}}}, "must not be negative"},
+		{"Note", PdataRules{Rules: []PdataRule{{Action: PdataRuleFlag, Field: "gen", Max: f(1), Note: strings.Repeat("x", 513)}}}, "note too long"},
+		{"TooMany", PdataRules{Rules: make([]PdataRule, maxPdataRules+1)}, "too many rules"},
+	} {
+		err := tc.n.Validate()
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected error %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestPdataRulesCheck(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	n := PdataRules{Rules: []PdataRule{
+		{Action: PdataRuleReject, Field: "gen", Min: f(0), Max: f(10)},
+		{Action: PdataRuleFlag, Field: "xp", MaxIncrease: f(1000), MaxDecrease: f(0)},
+		{Action: PdataRuleFlag, Field: "xp_match.*", Max: f(100)},
+	}}
+	parse := func(buf []byte) *pdata.Pdata {
+		var pd pdata.Pdata
+		if err := pd.UnmarshalBinary(buf); err != nil {
+			t.Fatalf("parse pdata: %v", err)
+		}
+		return &pd
+	}
+	prev := parse(testPdata(t, func(pd *pdata.Pdata) {
+		pd.Gen = 5
+		pd.Xp = 500
+	}))
+	for _, tc := range []struct {
+		name string
+		fn   func(pd *pdata.Pdata)
+		exp  []string
+	}{
+		{"Unchanged", func(pd *pdata.Pdata) {}, nil},
+		{"Valid", func(pd *pdata.Pdata) { pd.Gen, pd.Xp, pd.Xp_match[3] = 10, 1500, 100 }, nil},
+		{"Max", func(pd *pdata.Pdata) { pd.Gen = 11 }, []string{"reject gen: 5 -> 11"}},
+		{"Min", func(pd *pdata.Pdata) { pd.Gen = -1 }, []string{"reject gen: 5 -> -1"}},
+		{"MaxIncrease", func(pd *pdata.Pdata) { pd.Xp = 1501 }, []string{"flag xp: 500 -> 1501"}},
+		{"MaxDecrease", func(pd *pdata.Pdata) { pd.Xp = 499 }, []string{"flag xp: 500 -> 499"}},
+		{"Wildcard", func(pd *pdata.Pdata) { pd.Xp_match[3], pd.Xp_match[4] = 101, 102 }, []string{"flag xp_match.*[3]: 0 -> 101"}},
+		{"Multiple", func(pd *pdata.Pdata) { pd.Gen, pd.Xp = 11, 0 }, []string{"reject gen: 5 -> 11", "flag xp: 500 -> 0"}},
+	} {
+		next := parse(testPdata(t, func(pd *pdata.Pdata) {
+			pd.Gen, pd.Xp = prev.Gen, prev.Xp
+			tc.fn(pd)
+		}))
+		var vs []string
+		for _, v := range n.Check(prev, next) {
+			vs = append(vs, v.String())
+		}
+		if strings.Join(vs, "\n") != strings.Join(tc.exp, "\n") {
+			t.Errorf("%s: expected violations %q, got %q", tc.name, tc.exp, vs)
+		}
+	}
+}
+
+func TestPdataRules(t *testing.T) {
+	as, ps, rs := new(testAccountStorage), new(testPdataStorage), new(testAbuseReportStorage)
+	h := &Handler{
+		AccountStorage:     as,
+		PdataStorage:       ps,
+		AbuseReportStorage: rs,
+		StateStorage:       new(testStateStorage),
+		AdminSecret:        "secret",
+	}
+	for _, uid := range []uint64{1000, 1001} {
+		if err := as.SaveAccount(&Account{
+			UID:          uid,
+			AuthIP:       netip.MustParseAddr("192.0.2.1"),
+			LastServerID: "self",
+		}); err != nil {
+			t.Fatalf("save account: %v", err)
+		}
+	}
+	admin := func(method, body string) int {
+		r := httptest.NewRequest(method, "/admin/pdatarules", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.handleAdminPdataRules(w, r)
+		return w.Code
+	}
+	gen := func(uid uint64) int32 {
+		buf, ok, _ := ps.GetPdataCached(uid, [32]byte{})
+		if !ok {
+			return 0
+		}
+		var pd pdata.Pdata
+		if err := pd.UnmarshalBinary(buf); err != nil {
+			t.Fatalf("parse stored pdata: %v", err)
+		}
+		return pd.Gen
+	}
+	write := func(uid uint64, g int32) int {
+		return testWritePdata(t, h, uid, "192.0.2.1", testPdata(t, func(pd *pdata.Pdata) { pd.Gen = g })).Code
+	}
+
+	if write(1000, 50) != http.StatusOK || gen(1000) != 50 {
+		t.Fatalf("expected write to succeed without rules")
+	}
+
+	if st := admin(http.MethodPut, `{"rules":[{"action":"reject","field":"nonexistent","max":1}]}`); st != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid rules, got %d", st)
+	}
+	if st := admin(http.MethodPut, `{"rules":[
+		{"action":"reject","field":"gen","max":100},
+		{"action":"flag","field":"gen","max_increase":5}
+	]}`); st != http.StatusOK {
+		t.Fatalf("unexpected status %d", st)
+	}
+
+	if st := write(1000, 101); st != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 for rejected write, got %d", st)
+	}
+	if g := gen(1000); g != 50 {
+		t.Errorf("expected previous pdata to be kept, got gen %d", g)
+	}
+	if st := write(1000, 60); st != http.StatusOK {
+		t.Errorf("expected flagged write to succeed, got status %d", st)
+	}
+	if g := gen(1000); g != 60 {
+		t.Errorf("expected flagged pdata to be saved, got gen %d", g)
+	}
+	if st := write(1001, 5); st != http.StatusOK {
+		t.Errorf("expected valid write to succeed, got status %d", st)
+	}
+
+	// only one report per player per window
+	reports, _ := rs.GetAbuseReports("", 0, 0)
+	if len(reports) != 1 {
+		t.Fatalf("expected one report, got %d", len(reports))
+	}
+	if rp := reports[0]; rp.Reported != 1000 || rp.Reporter != 0 || rp.Reason != "pdata_rule" || rp.Context["rejected"] != "true" || rp.Status != AbuseReportOpen {
+		t.Errorf("incorrect report %+v", rp)
+	} else if !strings.Contains(rp.Evidence, "reject gen: 50 -> 101") || !strings.Contains(rp.Evidence, "flag gen: 50 -> 101") {
+		t.Errorf("incorrect report evidence %q", rp.Evidence)
+	}
+
+	// invalid stored pdata skips the rules
+	if _, err := ps.SetPdata(1001, []byte("invalid")); err != nil {
+		t.Fatalf("save pdata: %v", err)
+	}
+	if st := write(1001, 101); st != http.StatusOK {
+		t.Errorf("expected write to be allowed if the stored pdata is invalid, got status %d", st)
+	}
+
+	if st := admin(http.MethodDelete, ""); st != http.StatusOK {
+		t.Fatalf("unexpected status %d", st)
+	}
+	if st := write(1000, 101); st != http.StatusOK {
+		t.Errorf("expected write to succeed after deleting rules, got status %d", st)
+	}
+}
@@ -118,6 +118,18 @@ func (h *Handler) handlePlayer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// if the client has the current version, we don't need to read the pdata
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if hash, exists, err := h.PdataStorage.GetPdataHash(uid); err == nil && exists {
+			if etag := `W/"` + hex.EncodeToString(hash[:]) + `"`; etagMatch(inm, etag) {
+				h.m().player_pdata_requests_total.success(pdataFilterName).Inc()
+				w.Header().Set("ETag", etag)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+
 	buf, exists, err := h.PdataStorage.GetPdataCached(uid, [sha256.Size]byte{})
 	if err != nil {
 		hlog.FromRequest(r).Error().
@@ -0,0 +1,142 @@
+package api0
+
+import (
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// populationPeaksCache caches the historical player count peaks computed from
+// the server stats totals.
+type populationPeaksCache struct {
+	mu    sync.Mutex
+	t     time.Time
+	peaks map[string]any
+}
+
+// populationPeaks gets the player count peaks, recomputing them from
+// ServerStatsStorage if they are older than a minute.
+func (h *Handler) populationPeaks(t time.Time) (map[string]any, error) {
+	c := &h.populationPeaksCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.peaks != nil && t.Sub(c.t) < time.Minute {
+		return c.peaks, nil
+	}
+
+	retention := h.ServerStatsRetention
+	if retention <= 0 {
+		retention = time.Hour * 24 * 90
+	}
+
+	bs, err := h.ServerStatsStorage.GetServerStats(netip.AddrPort{}, serverStatsCoarse, t.Add(-retention).Truncate(serverStatsCoarse))
+	if err != nil {
+		return nil, err
+	}
+
+	peak := func(d time.Duration) map[string]any {
+		var max int
+		var at time.Time
+		since := t.Add(-d)
+		for _, b := range bs {
+			if !b.Start.Add(b.Resolution).Before(since) && b.PlayerMax >= max {
+				max, at = b.PlayerMax, b.Start
+			}
+		}
+		obj := map[string]any{
+			"players": max,
+		}
+		if !at.IsZero() {
+			obj["time"] = at.Unix()
+		}
+		return obj
+	}
+	c.peaks = map[string]any{
+		"day":      peak(time.Hour * 24),
+		"week":     peak(time.Hour * 24 * 7),
+		"all_time": peak(retention),
+	}
+	c.t = t
+	return c.peaks, nil
+}
+
+func (h *Handler) handleClientPopulation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().client_population_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=30")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	type group struct {
+		Servers int `json:"servers"`
+		Players int `json:"players"`
+	}
+	var (
+		players, maxPlayers, servers int
+		byRegion                     = map[string]*group{}
+		byPlaylist                   = map[string]*group{}
+		byMap                        = map[string]*group{}
+	)
+	add := func(m map[string]*group, k string, s *Server) {
+		g, ok := m[k]
+		if !ok {
+			g = new(group)
+			m[k] = g
+		}
+		g.Servers++
+		g.Players += s.PlayerCount
+	}
+	h.ServerList.GetLiveServers(func(s *Server) bool {
+		servers++
+		players += s.PlayerCount
+		maxPlayers += s.MaxPlayers
+		add(byRegion, s.Region, s)
+		add(byPlaylist, s.Playlist, s)
+		add(byMap, s.Map, s)
+		return true
+	})
+
+	obj := map[string]any{
+		"success":     true,
+		"players":     players,
+		"max_players": maxPlayers,
+		"servers":     servers,
+		"by_region":   byRegion,
+		"by_playlist": byPlaylist,
+		"by_map":      byMap,
+	}
+
+	// players with a keep-alive from their server (which may be less than
+	// the reported player counts if not all servers send them)
+	obj["online_players"] = h.presence.Count(time.Now(), h.playerPresenceTTL())
+
+	// peaks are only available if the server stats rollups are being recorded
+	if h.ServerStatsStorage != nil {
+		peaks, err := h.populationPeaks(time.Now())
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to read server stats totals from storage")
+			h.m().client_population_requests_total.fail_storage_error_stats.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		obj["peaks"] = peaks
+	}
+
+	h.m().client_population_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, obj)
+}
@@ -0,0 +1,197 @@
+package api0
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServerStatsStorage is a minimal in-memory ServerStatsStorage.
+type testServerStatsStorage struct {
+	mu sync.Mutex
+	bs []ServerStatsBucket
+	n  int // number of GetServerStats calls
+}
+
+func (s *testServerStatsStorage) GetServerStats(addr netip.AddrPort, resolution time.Duration, since time.Time) ([]ServerStatsBucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	var bs []ServerStatsBucket
+	for _, b := range s.bs {
+		if b.Addr == addr && b.Resolution == resolution && !b.Start.Before(since) {
+			bs = append(bs, b)
+		}
+	}
+	return bs, nil
+}
+
+func (s *testServerStatsStorage) SaveServerStats(bs []ServerStatsBucket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range bs {
+		var found bool
+		for i, x := range s.bs {
+			if x.Addr == b.Addr && x.Resolution == b.Resolution && x.Start.Equal(b.Start) {
+				s.bs[i], found = b, true
+			}
+		}
+		if !found {
+			s.bs = append(s.bs, b)
+		}
+	}
+	return nil
+}
+
+func (s *testServerStatsStorage) DeleteServerStats(resolution time.Duration, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	bs := s.bs[:0]
+	for _, b := range s.bs {
+		if b.Resolution == resolution && b.Start.Before(before) {
+			n++
+		} else {
+			bs = append(bs, b)
+		}
+	}
+	s.bs = bs
+	return n, nil
+}
+
+func TestPopulationPeaks(t *testing.T) {
+	t0 := time.Unix(1700000000, 0).Truncate(time.Hour)
+	st := &testServerStatsStorage{}
+	for _, b := range []struct {
+		ago     time.Duration
+		players int
+	}{
+		{time.Hour, 10},
+		{time.Hour * 2, 20},
+		{time.Hour * 48, 30},
+		{time.Hour * 24 * 30, 40},
+		{time.Hour * 24 * 100, 50}, // past retention
+	} {
+		st.bs = append(st.bs, ServerStatsBucket{
+			Start:      t0.Add(-b.ago),
+			Resolution: serverStatsCoarse,
+			Samples:    1,
+			PlayerMax:  b.players,
+		})
+	}
+	st.bs = append(st.bs, ServerStatsBucket{
+		Addr:       netip.MustParseAddrPort("192.0.2.1:37015"),
+		Start:      t0.Add(-time.Hour),
+		Resolution: serverStatsCoarse,
+		PlayerMax:  100,
+	})
+
+	h := &Handler{ServerStatsStorage: st}
+	peaks, err := h.populationPeaks(t0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for k, exp := range map[string]struct {
+		players int
+		ago     time.Duration
+	}{
+		"day":      {20, time.Hour * 2},
+		"week":     {30, time.Hour * 48},
+		"all_time": {40, time.Hour * 24 * 30},
+	} {
+		p, _ := peaks[k].(map[string]any)
+		if p["players"] != exp.players || p["time"] != t0.Add(-exp.ago).Unix() {
+			t.Errorf("%s: expected %d players at %d, got %v", k, exp.players, t0.Add(-exp.ago).Unix(), p)
+		}
+	}
+
+	// the peaks are cached for a minute
+	st.bs[0].PlayerMax = 1000
+	if _, err := h.populationPeaks(t0.Add(time.Second * 59)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if st.n != 1 {
+		t.Errorf("expected peaks to be cached, got %d storage reads", st.n)
+	}
+	if peaks, err := h.populationPeaks(t0.Add(time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if p, _ := peaks["day"].(map[string]any); p["players"] != 1000 {
+		t.Errorf("expected peaks to be updated after a minute, got %v", p)
+	}
+}
+
+func TestClientPopulation(t *testing.T) {
+	st := &testServerStatsStorage{}
+	h := &Handler{
+		ServerList:         NewServerList(time.Minute, time.Minute*2, 0, ServerListConfig{}),
+		ServerStatsStorage: st,
+	}
+	for i, s := range []Server{
+		{Region: "NA", Playlist: "ps", Map: "mp_glitch", PlayerCount: 5, MaxPlayers: 16},
+		{Region: "NA", Playlist: "aitdm", Map: "mp_glitch", PlayerCount: 3, MaxPlayers: 12},
+		{Region: "EU", Playlist: "ps", Map: "mp_forwardbase_kodai", PlayerCount: 0, MaxPlayers: 16},
+	} {
+		s.Addr = netip.AddrPortFrom(netip.MustParseAddr("192.0.2.1"), 37015+uint16(i))
+		s.AuthPort = 8081 + uint16(i)
+		s.Name = "test"
+		if _, err := h.ServerList.ServerHybridUpdatePut(nil, &s, ServerListLimit{}); err != nil {
+			t.Fatalf("register: %v", err)
+		}
+	}
+	h.presence.Joined(1, "a", netip.MustParseAddr("198.51.100.1"), time.Now())
+	h.presence.Joined(2, "a", netip.MustParseAddr("198.51.100.2"), time.Now())
+
+	if err := h.RecordServerStats(time.Now()); err != nil {
+		t.Fatalf("record server stats: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/client/population", nil)
+	w := httptest.NewRecorder()
+	h.handleClientPopulation(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+
+	type group struct {
+		Servers int `json:"servers"`
+		Players int `json:"players"`
+	}
+	var obj struct {
+		Players       int              `json:"players"`
+		MaxPlayers    int              `json:"max_players"`
+		Servers       int              `json:"servers"`
+		OnlinePlayers int              `json:"online_players"`
+		ByRegion      map[string]group `json:"by_region"`
+		ByPlaylist    map[string]group `json:"by_playlist"`
+		ByMap         map[string]group `json:"by_map"`
+		Peaks         map[string]struct {
+			Players int `json:"players"`
+		} `json:"peaks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &obj); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if obj.Players != 8 || obj.MaxPlayers != 44 || obj.Servers != 3 || obj.OnlinePlayers != 2 {
+		t.Errorf("incorrect totals %+v", obj)
+	}
+	if obj.ByRegion["NA"] != (group{2, 8}) || obj.ByRegion["EU"] != (group{1, 0}) {
+		t.Errorf("incorrect region breakdown %+v", obj.ByRegion)
+	}
+	if obj.ByPlaylist["ps"] != (group{2, 5}) || obj.ByPlaylist["aitdm"] != (group{1, 3}) {
+		t.Errorf("incorrect playlist breakdown %+v", obj.ByPlaylist)
+	}
+	if obj.ByMap["mp_glitch"] != (group{2, 8}) || obj.ByMap["mp_forwardbase_kodai"] != (group{1, 0}) {
+		t.Errorf("incorrect map breakdown %+v", obj.ByMap)
+	}
+
+	// the totals are recorded along with the per-server stats
+	for _, k := range []string{"day", "week", "all_time"} {
+		if p := obj.Peaks[k].Players; p != 8 {
+			t.Errorf("%s: expected peak of 8 players from recorded totals, got %d", k, p)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package api0
+
+import (
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// playerPresenceMaxUnknown is the maximum number of players in a keep-alive
+// which can be checked against storage if they aren't already known to be on
+// the server (e.g., after a restart).
+const playerPresenceMaxUnknown = 32
+
+// playerPresence is where a player is connected.
+type playerPresence struct {
+	Server string
+	IP     netip.Addr // the ip the player authenticated from
+	Since  time.Time  // when the player joined the server
+	Seen   time.Time  // the last keep-alive
+}
+
+// presenceStore tracks which server players are connected to. Players are
+// added when they authenticate with a server, and kept alive by the server.
+// It is safe for concurrent use.
+type presenceStore struct {
+	mu       sync.Mutex
+	m        map[uint64]*playerPresence
+	byServer map[string]map[uint64]struct{}
+}
+
+// Joined records that uid authenticated with server from ip.
+func (s *presenceStore) Joined(uid uint64, server string, ip netip.Addr, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.m == nil {
+		s.m = make(map[uint64]*playerPresence)
+		s.byServer = make(map[string]map[uint64]struct{})
+	}
+	s.remove(uid)
+	s.m[uid] = &playerPresence{
+		Server: server,
+		IP:     ip,
+		Since:  now,
+		Seen:   now,
+	}
+	if s.byServer[server] == nil {
+		s.byServer[server] = make(map[uint64]struct{})
+	}
+	s.byServer[server][uid] = struct{}{}
+}
+
+// KeepAlive updates the presence of the players connected to server,
+// returning the ones which aren't known to be on it. Players previously on
+// the server which aren't in uids are removed.
+func (s *presenceStore) KeepAlive(server string, uids []uint64, now time.Time, ttl time.Duration) (unknown []uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur := make(map[uint64]struct{}, len(uids))
+	for _, uid := range uids {
+		cur[uid] = struct{}{}
+		if p, ok := s.m[uid]; ok && p.Server == server && now.Sub(p.Seen) < ttl {
+			p.Seen = now
+		} else {
+			unknown = append(unknown, uid)
+		}
+	}
+	for uid := range s.byServer[server] {
+		if _, ok := cur[uid]; !ok {
+			s.remove(uid)
+		}
+	}
+	return unknown
+}
+
+// Get gets the presence of uid.
+func (s *presenceStore) Get(uid uint64, now time.Time, ttl time.Duration) (playerPresence, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.m[uid]; ok && now.Sub(p.Seen) < ttl {
+		return *p, true
+	}
+	return playerPresence{}, false
+}
+
+// Count returns the number of connected players, removing expired ones.
+func (s *presenceStore) Count(now time.Time, ttl time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for uid, p := range s.m {
+		if now.Sub(p.Seen) >= ttl {
+			s.remove(uid)
+		}
+	}
+	return len(s.m)
+}
+
+// remove removes uid. The mutex must be held.
+func (s *presenceStore) remove(uid uint64) {
+	if p, ok := s.m[uid]; ok {
+		delete(s.m, uid)
+		if x := s.byServer[p.Server]; x != nil {
+			if delete(x, uid); len(x) == 0 {
+				delete(s.byServer, p.Server)
+			}
+		}
+	}
+}
+
+func (h *Handler) playerPresenceTTL() time.Duration {
+	if h.PlayerPresenceTTL > 0 {
+		return h.PlayerPresenceTTL
+	}
+	return time.Minute * 2
+}
+
+// playerPresence gets the server uid is connected to, if any.
+func (h *Handler) playerPresence(uid uint64, now time.Time) (playerPresence, bool) {
+	p, ok := h.presence.Get(uid, now, h.playerPresenceTTL())
+	if ok && h.ServerList.GetServerByID(p.Server) == nil {
+		return playerPresence{}, false
+	}
+	return p, ok
+}
+
+// handleServerPlayers is a keep-alive for the players connected to a server,
+// which should be sent by the server with the full list of connected players
+// at least as often as PlayerPresenceTTL (e.g., with each heartbeat).
+//
+// Query parameters:
+//   - id: the server id
+//
+// The request body is JSON with "players" (an array of uids). The response
+// contains the "rejected" uids which haven't authenticated with the server.
+func (h *Handler) handleServerPlayers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().server_players_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_players_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		h.m().server_players_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	srv := h.ServerList.GetServerByID(id)
+	if srv == nil {
+		h.m().server_players_requests_total.reject_server_not_found.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such game server"))
+		return
+	}
+	if srv.Addr.Addr() != raddr.Addr() {
+		h.m().server_players_requests_total.reject_unauthorized_ip.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
+		return
+	}
+	if !h.checkServerSignature(w, r, srv, "players") {
+		return
+	}
+
+	var req struct {
+		Players []uint64 `json:"players" validate:"max=256"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		h.m().server_players_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	rejected := []uint64{}
+	unknown := h.presence.KeepAlive(srv.ID, req.Players, now, h.playerPresenceTTL())
+	for i, uid := range unknown {
+		// players can only be added by the server if storage says they're on
+		// it (e.g., if they joined before a restart)
+		if i >= playerPresenceMaxUnknown {
+			rejected = append(rejected, unknown[i:]...)
+			break
+		}
+		acct, err := h.AccountStorage.GetAccount(uid)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", uid).
+				Msgf("failed to read account from storage")
+			h.m().server_players_requests_total.fail_storage_error_account.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if acct == nil || acct.LastServerID != srv.ID {
+			rejected = append(rejected, uid)
+			continue
+		}
+		h.presence.Joined(uid, srv.ID, acct.AuthIP, now)
+	}
+	h.m().server_players_keepalives_total.Add(len(req.Players) - len(rejected))
+	h.m().server_players_rejected_total.Add(len(rejected))
+
+	h.m().server_players_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":  true,
+		"rejected": rejected,
+	})
+}
@@ -0,0 +1,51 @@
+package api0
+
+import (
+	"net/url"
+	"strings"
+)
+
+// queryGetter is implemented by url.Values and rawQuery.
+type queryGetter interface {
+	Get(key string) string
+}
+
+// rawQuery is an unparsed URL query string. It can be used in place of
+// url.Values when only a few values need to be looked up. Unlike
+// url.ParseQuery, it doesn't allocate unless a key or value needs to be
+// unescaped, which is important for frequent requests like heartbeats.
+type rawQuery string
+
+var (
+	_ queryGetter = url.Values(nil)
+	_ queryGetter = rawQuery("")
+)
+
+// Get gets the first value for key. It is equivalent to url.ParseQuery
+// followed by url.Values.Get.
+func (q rawQuery) Get(key string) string {
+	s := string(q)
+	for s != "" {
+		var kv string
+		kv, s, _ = strings.Cut(s, "&")
+		if kv == "" || strings.Contains(kv, ";") {
+			continue // url.ParseQuery skips these too
+		}
+		k, v, _ := strings.Cut(kv, "=")
+		if k, ok := queryUnescape(k); !ok || k != key {
+			continue
+		}
+		if v, ok := queryUnescape(v); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func queryUnescape(s string) (string, bool) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, true
+	}
+	s, err := url.QueryUnescape(s)
+	return s, err == nil
+}
@@ -0,0 +1,108 @@
+package api0
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// PlayerQuotaType is a kind of action limited per player.
+type PlayerQuotaType string
+
+const (
+	PlayerQuotaAuth       PlayerQuotaType = "auth"        // successful origin_auth, auth_with_server, and auth_with_self
+	PlayerQuotaPdataWrite PlayerQuotaType = "pdata_write" // pdata writes and patches
+	PlayerQuotaReport     PlayerQuotaType = "report"      // abuse reports filed by the player
+)
+
+// PlayerQuota is a token bucket limit for a single UID. Since it is only
+// charged after the player is authenticated, rotating IPs doesn't help, and
+// other players can't exhaust it.
+type PlayerQuota struct {
+	// PerHour is the number of actions allowed per hour on average. If zero,
+	// the action is not limited.
+	PerHour int
+
+	// Burst is the maximum number of actions allowed at once. If zero, it
+	// defaults to PerHour.
+	Burst int
+}
+
+func (q PlayerQuota) burst() int {
+	if q.Burst <= 0 {
+		return q.PerHour
+	}
+	return q.Burst
+}
+
+// PlayerQuotas configures per-player quotas.
+type PlayerQuotas struct {
+	Auth       PlayerQuota
+	PdataWrite PlayerQuota
+	Report     PlayerQuota
+}
+
+// get gets the quota for typ.
+func (q PlayerQuotas) get(typ PlayerQuotaType) PlayerQuota {
+	switch typ {
+	case PlayerQuotaAuth:
+		return q.Auth
+	case PlayerQuotaPdataWrite:
+		return q.PdataWrite
+	case PlayerQuotaReport:
+		return q.Report
+	default:
+		panic("invalid quota type")
+	}
+}
+
+// playerQuotas tracks per-player token buckets.
+type playerQuotas struct {
+	auth       tokenBucket[uint64]
+	pdataWrite tokenBucket[uint64]
+	report     tokenBucket[uint64]
+}
+
+func (p *playerQuotas) bucket(typ PlayerQuotaType) *tokenBucket[uint64] {
+	switch typ {
+	case PlayerQuotaAuth:
+		return &p.auth
+	case PlayerQuotaPdataWrite:
+		return &p.pdataWrite
+	case PlayerQuotaReport:
+		return &p.report
+	default:
+		panic("invalid quota type")
+	}
+}
+
+// checkPlayerQuota takes from the typ quota for uid. If it is exhausted, it
+// writes a QUOTA_EXCEEDED response and returns false.
+func (h *Handler) checkPlayerQuota(w http.ResponseWriter, r *http.Request, typ PlayerQuotaType, uid uint64) bool {
+	q := h.PlayerQuotas.get(typ)
+	ok, wait := h.playerQuotas.bucket(typ).Take(uid, time.Now(), q.PerHour, q.burst())
+	if ok {
+		return true
+	}
+	retry := int(wait/time.Second) + 1
+
+	hlog.FromRequest(r).Warn().
+		Uint64("uid", uid).
+		Str("quota", string(typ)).
+		Msgf("player exceeded quota")
+	h.m().player_quota_rejections_total.reject(string(typ)).Inc()
+
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	respFailExtra(w, r, http.StatusTooManyRequests, ErrorCode_QUOTA_EXCEEDED.MessageObjf("too many %s requests for this player", typ), map[string]any{
+		"quota": map[string]any{
+			"type":        typ,
+			"uid":         uid,
+			"per_hour":    q.PerHour,
+			"burst":       q.burst(),
+			"retry_after": retry,
+		},
+	})
+	return false
+}
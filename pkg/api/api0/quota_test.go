@@ -0,0 +1,201 @@
+package api0
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/pdata"
+)
+
+// testWritePdata writes buf as the pdata for uid from a listen server at ip,
+// returning the response.
+func testWritePdata(t *testing.T, h *Handler, uid uint64, ip string, buf []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	if fw, err := mw.CreateFormFile("pdata", "pdata"); err != nil {
+		t.Fatalf("create form file: %v", err)
+	} else if _, err := fw.Write(buf); err != nil {
+		t.Fatalf("write form file: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close multipart writer: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/accounts/write_persistence?id="+strconv.FormatUint(uid, 10), &b)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	h.handleAccountsWritePersistence(w, r)
+	return w
+}
+
+func TestPlayerQuota(t *testing.T) {
+	h := &Handler{
+		PlayerQuotas: PlayerQuotas{
+			Auth:   PlayerQuota{PerHour: 60, Burst: 2},
+			Report: PlayerQuota{PerHour: 2},
+		},
+	}
+	take := func(typ PlayerQuotaType, uid uint64) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		if h.checkPlayerQuota(w, httptest.NewRequest(http.MethodGet, "/", nil), typ, uid) != (w.Body.Len() == 0) {
+			t.Fatalf("expected a response to be written only if the quota is exceeded")
+		}
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := take(PlayerQuotaAuth, 1000); w.Body.Len() != 0 {
+			t.Fatalf("expected action %d to be allowed within the burst", i)
+		}
+	}
+	w := take(PlayerQuotaAuth, 1000)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 after the burst, got %d", w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "60" && ra != "59" {
+		t.Errorf("expected Retry-After of about a minute, got %q", ra)
+	}
+	var obj struct {
+		Error ErrorObj `json:"error"`
+		Quota struct {
+			Type       PlayerQuotaType `json:"type"`
+			UID        uint64          `json:"uid"`
+			PerHour    int             `json:"per_hour"`
+			Burst      int             `json:"burst"`
+			RetryAfter int             `json:"retry_after"`
+		} `json:"quota"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &obj); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if obj.Error.Code != ErrorCode_QUOTA_EXCEEDED || !obj.Error.Retryable {
+		t.Errorf("incorrect error %+v", obj.Error)
+	}
+	if q := obj.Quota; q.Type != PlayerQuotaAuth || q.UID != 1000 || q.PerHour != 60 || q.Burst != 2 || strconv.Itoa(q.RetryAfter) != w.Header().Get("Retry-After") {
+		t.Errorf("incorrect quota %+v", q)
+	}
+
+	// quotas are separate per player and type
+	if w := take(PlayerQuotaAuth, 1001); w.Body.Len() != 0 {
+		t.Errorf("expected other player to have a separate quota")
+	}
+	if w := take(PlayerQuotaReport, 1000); w.Body.Len() != 0 {
+		t.Errorf("expected other quota type to be separate")
+	}
+
+	// the burst defaults to the hourly limit
+	take(PlayerQuotaReport, 1000)
+	if w := take(PlayerQuotaReport, 1000); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected burst to default to the hourly limit, got status %d", w.Code)
+	}
+
+	// zero quotas are unlimited
+	for i := 0; i < 100; i++ {
+		if w := take(PlayerQuotaPdataWrite, 1000); w.Body.Len() != 0 {
+			t.Fatalf("expected unlimited quota to allow all actions")
+		}
+	}
+}
+
+func TestPdataWriteQuota(t *testing.T) {
+	setup := func(h *Handler) *Handler {
+		as := new(testAccountStorage)
+		h.AccountStorage = as
+		h.PdataStorage = new(testPdataStorage)
+		for i, ip := range []string{"192.0.2.1", "192.0.2.1", "192.0.2.1", "192.0.2.2"} {
+			if err := as.SaveAccount(&Account{
+				UID:          1000 + uint64(i),
+				AuthIP:       netip.MustParseAddr(ip),
+				LastServerID: "self",
+			}); err != nil {
+				t.Fatalf("save account: %v", err)
+			}
+		}
+		return h
+	}
+	quota := func(t *testing.T, w *httptest.ResponseRecorder) any {
+		var obj struct {
+			Error ErrorObj `json:"error"`
+			Quota any      `json:"quota"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &obj); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		if obj.Error.Code != ErrorCode_QUOTA_EXCEEDED {
+			t.Errorf("expected error %s, got %s", ErrorCode_QUOTA_EXCEEDED, obj.Error.Code)
+		}
+		return obj.Quota
+	}
+
+	t.Run("Window", func(t *testing.T) {
+		h := setup(&Handler{
+			PdataPlayerWriteLimit: 2,
+			PdataServerWriteLimit: 4,
+			PdataWriteWindow:      time.Minute * 5,
+		})
+		// note: writes rejected by the player quota still count towards the
+		// server quota since the server still made them
+		for _, tc := range []struct {
+			name   string
+			uid    uint64
+			ip     string
+			status int
+			quota  string
+		}{
+			{"Player", 1000, "192.0.2.1", http.StatusOK, ""},
+			{"PlayerLimit", 1000, "192.0.2.1", http.StatusOK, ""},
+			{"PlayerExceeded", 1000, "192.0.2.1", http.StatusTooManyRequests, "player"},
+			{"OtherPlayer", 1001, "192.0.2.1", http.StatusOK, ""},
+			{"ServerExceeded", 1002, "192.0.2.1", http.StatusTooManyRequests, "server"},
+			{"OtherServer", 1003, "192.0.2.2", http.StatusOK, ""},
+		} {
+			w := testWritePdata(t, h, tc.uid, tc.ip, pdata.DefaultPdata)
+			if w.Code != tc.status {
+				t.Errorf("%s: expected status %d, got %d: %s", tc.name, tc.status, w.Code, w.Body.String())
+				continue
+			}
+			if tc.quota != "" {
+				if q := quota(t, w); q != tc.quota {
+					t.Errorf("%s: expected %s quota, got %v", tc.name, tc.quota, q)
+				}
+				if ra := w.Header().Get("Retry-After"); ra != "300" {
+					t.Errorf("%s: expected Retry-After to be the window, got %q", tc.name, ra)
+				}
+			}
+		}
+		if _, ok, _ := h.PdataStorage.GetPdataHash(1002); ok {
+			t.Errorf("expected rejected write not to be saved")
+		}
+	})
+
+	t.Run("Hourly", func(t *testing.T) {
+		h := setup(&Handler{
+			PlayerQuotas: PlayerQuotas{
+				PdataWrite: PlayerQuota{PerHour: 1},
+			},
+		})
+		if w := testWritePdata(t, h, 1000, "192.0.2.1", pdata.DefaultPdata); w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+		w := testWritePdata(t, h, 1000, "192.0.2.1", pdata.DefaultPdata)
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("expected status 429, got %d", w.Code)
+		}
+		if q, _ := quota(t, w).(map[string]any); q == nil || q["type"] != string(PlayerQuotaPdataWrite) {
+			t.Errorf("expected %s quota, got %v", PlayerQuotaPdataWrite, q)
+		}
+		if w := testWritePdata(t, h, 1001, "192.0.2.1", pdata.DefaultPdata); w.Code != http.StatusOK {
+			t.Errorf("expected other player to have a separate quota, got status %d", w.Code)
+		}
+	})
+}
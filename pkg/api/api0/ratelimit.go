@@ -0,0 +1,130 @@
+package api0
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter limits the number of events per key within a sliding window. It
+// is safe for concurrent use.
+type rateLimiter[K comparable] struct {
+	mu    sync.Mutex
+	m     map[K][]time.Time
+	sweep int
+}
+
+// Allow records an event for k at t and returns true if there have been fewer
+// than limit events for k in the window before t. Otherwise, it returns false
+// without recording the event. If limit is not positive, all events are
+// allowed.
+func (l *rateLimiter[K]) Allow(k K, t time.Time, limit int, window time.Duration) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.m == nil {
+		l.m = make(map[K][]time.Time)
+	}
+
+	// occasionally forget keys without recent events so the map doesn't grow
+	// forever
+	if l.sweep++; l.sweep >= 1024 {
+		l.sweep = 0
+		for x, ts := range l.m {
+			if len(ts) == 0 || !t.Before(ts[len(ts)-1].Add(window)) {
+				delete(l.m, x)
+			}
+		}
+	}
+
+	ts := l.m[k]
+	var n int
+	for n < len(ts) && !t.Before(ts[n].Add(window)) {
+		n++
+	}
+	ts = ts[n:]
+
+	if len(ts) >= limit {
+		l.m[k] = ts
+		return false
+	}
+	l.m[k] = append(ts, t)
+	return true
+}
+
+// Reset forgets all events for k.
+func (l *rateLimiter[K]) Reset(k K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.m, k)
+}
+
+// Clear forgets all events.
+func (l *rateLimiter[K]) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.m = nil
+}
+
+// tokenBucket limits events per key with a token bucket refilled at a fixed
+// rate. It is safe for concurrent use.
+type tokenBucket[K comparable] struct {
+	mu    sync.Mutex
+	m     map[K]tokenBucketState
+	sweep int
+}
+
+type tokenBucketState struct {
+	tokens float64
+	last   time.Time
+}
+
+// Take takes a token for k at t from a bucket holding up to burst tokens
+// which is refilled with perHour tokens per hour, returning true if one was
+// available. Otherwise, it returns false and how long until the next token is
+// available. If perHour or burst is not positive, all events are allowed.
+func (b *tokenBucket[K]) Take(k K, t time.Time, perHour, burst int) (bool, time.Duration) {
+	if perHour <= 0 || burst <= 0 {
+		return true, 0
+	}
+	rate := float64(perHour) / float64(time.Hour)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.m == nil {
+		b.m = make(map[K]tokenBucketState)
+	}
+
+	// occasionally forget full buckets so the map doesn't grow forever
+	if b.sweep++; b.sweep >= 1024 {
+		b.sweep = 0
+		for x, s := range b.m {
+			if s.tokens+float64(t.Sub(s.last))*rate >= float64(burst) {
+				delete(b.m, x)
+			}
+		}
+	}
+
+	s, ok := b.m[k]
+	if !ok {
+		s = tokenBucketState{tokens: float64(burst), last: t}
+	} else if t.After(s.last) {
+		s.tokens += float64(t.Sub(s.last)) * rate
+		if s.tokens > float64(burst) {
+			s.tokens = float64(burst)
+		}
+		s.last = t
+	}
+
+	if s.tokens < 1 {
+		b.m[k] = s
+		return false, time.Duration((1 - s.tokens) / rate)
+	}
+	s.tokens--
+	b.m[k] = s
+	return true, 0
+}
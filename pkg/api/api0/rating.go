@@ -0,0 +1,134 @@
+package api0
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/glicko2"
+	"github.com/rs/zerolog/hlog"
+)
+
+// getRating gets the rating for uid in mode, or the default rating if the
+// player hasn't been rated yet.
+func (h *Handler) getRating(uid uint64, mode string) (PlayerRating, error) {
+	pr, err := h.RatingStorage.GetRating(uid, mode)
+	if err != nil {
+		return PlayerRating{}, err
+	}
+	if pr == nil {
+		d := glicko2.Default()
+		return PlayerRating{
+			UID:        uid,
+			Mode:       mode,
+			Rating:     d.Rating,
+			Deviation:  d.Deviation,
+			Volatility: d.Volatility,
+		}, nil
+	}
+	return *pr, nil
+}
+
+func (pr PlayerRating) glicko2() glicko2.Rating {
+	return glicko2.Rating{
+		Rating:     pr.Rating,
+		Deviation:  pr.Deviation,
+		Volatility: pr.Volatility,
+	}
+}
+
+// rateMatch computes the new ratings for a match, treating it as a single
+// rating period where each player played every player not on their team.
+func rateMatch(players []matchResultPlayer, old []PlayerRating, now time.Time) []PlayerRating {
+	rs := make([]PlayerRating, len(players))
+	for i, p := range players {
+		var res []glicko2.Result
+		for j, o := range players {
+			if i == j || (p.Team != 0 && p.Team == o.Team) {
+				continue
+			}
+			var score float64
+			switch {
+			case p.Rank < o.Rank:
+				score = 1
+			case p.Rank == o.Rank:
+				score = 0.5
+			}
+			res = append(res, glicko2.Result{Opponent: old[j].glicko2(), Score: score})
+		}
+		n := glicko2.Update(old[i].glicko2(), res, glicko2.DefaultTau)
+		rs[i] = old[i]
+		rs[i].Rating = n.Rating
+		rs[i].Deviation = n.Deviation
+		rs[i].Volatility = n.Volatility
+		rs[i].Matches++
+		rs[i].Updated = now
+	}
+	return rs
+}
+
+func (h *Handler) handlePlayerRatings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.m().player_ratings_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// same as the other player endpoints
+	w.Header().Set("Cache-Control", "public, max-age=15, stale-while-revalidate=15")
+	w.Header().Set("Expires", time.Now().UTC().Add(time.Second*30).Format(http.TimeFormat))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, HEAD")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.RatingStorage == nil || !h.PublicRatings {
+		h.m().player_ratings_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("public skill ratings are not enabled"))
+		return
+	}
+
+	uid, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		h.m().player_ratings_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	rs, err := h.RatingStorage.GetRatings(uid)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Uint64("uid", uid).
+			Msgf("failed to read ratings from storage")
+		h.m().player_ratings_requests_total.fail_storage_error_rating.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	type ratingJSON struct {
+		Rating    float64 `json:"rating"`
+		Deviation float64 `json:"deviation"`
+		Matches   int     `json:"matches"`
+	}
+	ratings := make(map[string]ratingJSON, len(rs))
+	for _, pr := range rs {
+		ratings[pr.Mode] = ratingJSON{
+			Rating:    math.Round(pr.Rating),
+			Deviation: math.Round(pr.Deviation),
+			Matches:   pr.Matches,
+		}
+	}
+
+	h.m().player_ratings_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"uid":     uid,
+		"ratings": ratings,
+	})
+}
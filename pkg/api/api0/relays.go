@@ -0,0 +1,458 @@
+package api0
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/websocket"
+	"github.com/rs/zerolog/hlog"
+)
+
+// relayStreamInterval is how often the server list is checked for changes to
+// stream to relays.
+const relayStreamInterval = time.Second
+
+// relayResendInterval is how often the server list is re-sent to relays even
+// if it hasn't changed, so they can tell their copy is still fresh.
+const relayResendInterval = time.Second * 15
+
+// Relay is a community-run regional relay which mirrors the server list from
+// the primary to serve list traffic closer to players.
+type Relay struct {
+	// ID uniquely identifies the relay.
+	ID string `json:"id" validate:"required,max=32"`
+
+	// Region is the region the relay serves (e.g., AU, SA, Asia).
+	Region string `json:"region" validate:"required,max=32"`
+
+	// URL is the public base URL of the relay.
+	URL string `json:"url" validate:"required,max=256"`
+
+	// TokenHash is the hex-encoded SHA-256 of the token the relay
+	// authenticates with.
+	TokenHash string `json:"token_hash"`
+
+	// Created is when the relay was added.
+	Created time.Time `json:"created"`
+}
+
+// relayHub tracks connected relays.
+type relayHub struct {
+	mu    sync.Mutex
+	conns map[string]relayConn
+}
+
+type relayConn struct {
+	Connected time.Time
+	Addr      string
+}
+
+// connect marks relay id as connected from addr. It returns false if the
+// relay is already connected.
+func (r *relayHub) connect(id, addr string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.conns[id]; ok {
+		return false
+	}
+	if r.conns == nil {
+		r.conns = map[string]relayConn{}
+	}
+	r.conns[id] = relayConn{
+		Connected: time.Now().UTC(),
+		Addr:      addr,
+	}
+	return true
+}
+
+// disconnect marks relay id as disconnected.
+func (r *relayHub) disconnect(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.conns, id)
+}
+
+// get gets the connection for relay id, if connected.
+func (r *relayHub) get(id string) (relayConn, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.conns[id]
+	return c, ok
+}
+
+// relayTokenHash hashes a relay token for storage.
+func relayTokenHash(token string) string {
+	b := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(b[:])
+}
+
+// validateRelays checks if rs is a valid set of relays.
+func validateRelays(rs []Relay) error {
+	ids := map[string]struct{}{}
+	for i, x := range rs {
+		if err := validate(x); err != nil {
+			return fmt.Errorf("relay %d: %w", i, err)
+		}
+		if _, dup := ids[x.ID]; dup {
+			return fmt.Errorf("relay %s: duplicate id", x.ID)
+		}
+		ids[x.ID] = struct{}{}
+		if u, err := url.Parse(x.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("relay %s: invalid url %q", x.ID, x.URL)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) handleRelayStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.m().relay_stream_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	var q struct {
+		ID string `param:"id" validate:"required"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().relay_stream_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+
+	rs, err := h.relays.Get(h.StateStorage, "relays")
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to load relays from storage")
+		h.m().relay_stream_requests_total.fail_storage_error_state.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	var relay *Relay
+	for i := range rs {
+		if rs[i].ID == q.ID {
+			relay = &rs[i]
+			break
+		}
+	}
+	tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if relay == nil || tok == "" || subtle.ConstantTimeCompare([]byte(relayTokenHash(tok)), []byte(relay.TokenHash)) != 1 {
+		h.m().relay_stream_requests_total.reject_unauthorized.Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_UNAUTHORIZED.MessageObj())
+		return
+	}
+	if h.RelayRequireClientCert && !h.verifyClientCert(r) {
+		h.m().relay_stream_requests_total.reject_client_cert.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED.MessageObjf("a valid client certificate is required"))
+		return
+	}
+
+	if !websocket.IsUpgrade(r) {
+		h.m().relay_stream_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("websocket upgrade required"))
+		return
+	}
+
+	if !h.relayHub.connect(relay.ID, r.RemoteAddr) {
+		h.m().relay_stream_requests_total.reject_already_connected.Inc()
+		respFail(w, r, http.StatusConflict, ErrorCode_BAD_REQUEST.MessageObjf("relay is already connected"))
+		return
+	}
+	defer h.relayHub.disconnect(relay.ID)
+
+	c, err := websocket.Upgrade(w, r)
+	if err != nil {
+		h.m().relay_stream_requests_total.reject_bad_request.Inc()
+		hlog.FromRequest(r).Debug().Err(err).Msg("failed to upgrade relay websocket")
+		return
+	}
+	defer c.Close()
+	h.m().relay_stream_requests_total.success.Inc()
+
+	hlog.FromRequest(r).Info().
+		Str("relay", relay.ID).
+		Str("region", relay.Region).
+		Msg("relay connected")
+
+	// relays only send close frames
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := c.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	tk := time.NewTicker(relayStreamInterval)
+	defer tk.Stop()
+
+	// the gzipped list is sent as-is so relays can serve it directly (it's
+	// also much smaller than the max websocket message size)
+	var last []byte
+	var sent time.Time
+	for {
+		if buf, ok := h.ServerList.csGetJSONGzip(); ok && (!bytes.Equal(buf, last) || time.Since(sent) >= relayResendInterval) {
+			// disconnect relays which were deleted or had their token rotated
+			if x, err := h.relays.Get(h.StateStorage, "relays"); err == nil {
+				var found bool
+				for _, y := range x {
+					if y.ID == relay.ID && y.TokenHash == relay.TokenHash {
+						found = true
+						break
+					}
+				}
+				if !found {
+					return
+				}
+			}
+			if c.Write(websocket.OpBinary, buf) != nil {
+				return
+			}
+			h.m().relay_stream_updates_total.Inc()
+			last, sent = buf, time.Now()
+		}
+		select {
+		case <-tk.C:
+		case <-gone:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (h *Handler) handleClientRelays(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().client_relays_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, HEAD")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	rs, err := h.relays.Get(h.StateStorage, "relays")
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to load relays from storage")
+		h.m().client_relays_requests_total.fail_storage_error_state.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	// only relays which are currently receiving updates are listed
+	type relayJSON struct {
+		ID     string `json:"id"`
+		Region string `json:"region"`
+		URL    string `json:"url"`
+	}
+	relays := []relayJSON{}
+	for _, x := range rs {
+		if _, ok := h.relayHub.get(x.ID); ok {
+			relays = append(relays, relayJSON{x.ID, x.Region, x.URL})
+		}
+	}
+
+	h.m().client_relays_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"relays":  relays,
+	})
+}
+
+func (h *Handler) handleAdminRelays(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "relays"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	type relayJSON struct {
+		ID        string     `json:"id"`
+		Region    string     `json:"region"`
+		URL       string     `json:"url"`
+		Created   time.Time  `json:"created"`
+		Connected *time.Time `json:"connected,omitempty"`
+		Addr      string     `json:"addr,omitempty"`
+	}
+	list := func(rs []Relay) []relayJSON {
+		relays := make([]relayJSON, len(rs))
+		for i, x := range rs {
+			relays[i] = relayJSON{
+				ID:      x.ID,
+				Region:  x.Region,
+				URL:     x.URL,
+				Created: x.Created,
+			}
+			if c, ok := h.relayHub.get(x.ID); ok {
+				relays[i].Connected = &c.Connected
+				relays[i].Addr = c.Addr
+			}
+		}
+		return relays
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		rs, err := h.relays.Get(h.StateStorage, "relays")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load relays from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"relays":  list(rs),
+		})
+		return
+	}
+
+	var token string
+	var fn func(rs []Relay) []Relay
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			ID     string `json:"id" validate:"required,max=32"`
+			Region string `json:"region" validate:"required,max=32"`
+			URL    string `json:"url" validate:"required,max=256"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		tok, err := cryptoRandHex(48)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to generate relay token")
+			h.m().admin_requests_total.fail_other_error(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		token = tok
+
+		// adding an existing relay replaces it and rotates the token
+		x := Relay{
+			ID:        req.ID,
+			Region:    req.Region,
+			URL:       strings.TrimSuffix(req.URL, "/"),
+			TokenHash: relayTokenHash(token),
+			Created:   time.Now().UTC().Truncate(time.Second),
+		}
+		fn = func(rs []Relay) []Relay {
+			for i := range rs {
+				if rs[i].ID == x.ID {
+					rs[i] = x
+					return rs
+				}
+			}
+			return append(rs, x)
+		}
+	case http.MethodDelete:
+		var q struct {
+			ID string `param:"id" validate:"required"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func(rs []Relay) []Relay {
+			for i := range rs {
+				if rs[i].ID == q.ID {
+					return append(rs[:i], rs[i+1:]...)
+				}
+			}
+			return rs
+		}
+	}
+
+	var errInvalid error
+	var nrs []Relay
+	if err := h.relays.Update(h.StateStorage, "relays", func(rs []Relay) ([]Relay, error) {
+		rs = fn(append([]Relay(nil), rs...))
+		if err := validateRelays(rs); err != nil {
+			errInvalid = err
+			return nil, err
+		}
+		sort.SliceStable(rs, func(i, j int) bool {
+			return rs[i].ID < rs[j].ID
+		})
+		nrs = rs
+		return rs, nil
+	}); err != nil {
+		if errInvalid != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", errInvalid))
+			return
+		}
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save relays to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	resp := map[string]any{
+		"success": true,
+		"relays":  list(nrs),
+	}
+	if token != "" {
+		// the token is only shown once since only the hash is stored
+		resp["token"] = token
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, resp)
+}
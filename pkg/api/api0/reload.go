@@ -0,0 +1,105 @@
+package api0
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// ReloadableConfig contains the Handler options which can be safely changed
+// while it is running using Reconfigure. The fields have the same meaning as
+// the corresponding ones in Handler.
+type ReloadableConfig struct {
+	MaxServers                   int
+	MaxServersPerIP              int
+	MinimumLauncherVersionClient string
+	MinimumLauncherVersionServer string
+	BlockedLauncherVersions      []string
+	LauncherUpdateURL            string
+	AttackMode                   AttackMode
+	FeatureFlags                 FeatureFlags
+	CORS                         CORS
+	CleanBadWords                func(s string) string
+}
+
+// Reconfigure replaces the reloadable options. It is safe to call while the
+// Handler is serving requests. Overrides set via the admin API remain in
+// effect.
+func (h *Handler) Reconfigure(c ReloadableConfig) {
+	c.BlockedLauncherVersions = append([]string(nil), c.BlockedLauncherVersions...)
+	c.AttackMode.Paths = append([]string(nil), c.AttackMode.Paths...)
+	ff := make(FeatureFlags, len(c.FeatureFlags))
+	for k, v := range c.FeatureFlags {
+		ff[k] = v
+	}
+	c.FeatureFlags = ff
+	c.CORS.Rules = append([]CORSRule(nil), c.CORS.Rules...)
+	c.CORS.Methods = append([]string(nil), c.CORS.Methods...)
+	h.reloadable.Store(&c)
+}
+
+// cfg gets the current reloadable options.
+func (h *Handler) cfg() ReloadableConfig {
+	if c := h.reloadable.Load(); c != nil {
+		return *c
+	}
+	return ReloadableConfig{
+		MaxServers:                   h.MaxServers,
+		MaxServersPerIP:              h.MaxServersPerIP,
+		MinimumLauncherVersionClient: h.MinimumLauncherVersionClient,
+		MinimumLauncherVersionServer: h.MinimumLauncherVersionServer,
+		BlockedLauncherVersions:      h.BlockedLauncherVersions,
+		LauncherUpdateURL:            h.LauncherUpdateURL,
+		AttackMode:                   h.AttackMode,
+		FeatureFlags:                 h.FeatureFlags,
+		CORS:                         h.CORS,
+		CleanBadWords:                h.CleanBadWords,
+	}
+}
+
+func (h *Handler) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "reload"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	if h.OnReload == nil {
+		h.m().admin_requests_total.reject_disabled(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if err := h.OnReload(); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to reload config")
+		h.m().admin_requests_total.fail_reload_error(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObjf("reload config: %v", err))
+		return
+	}
+
+	hlog.FromRequest(r).Info().
+		Msgf("reloaded config at admin request")
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
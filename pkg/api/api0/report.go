@@ -0,0 +1,355 @@
+package api0
+
+import (
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// abuseReportJSON is the admin API representation of an AbuseReport.
+type abuseReportJSON struct {
+	ID         string            `json:"id"`
+	Time       time.Time         `json:"time"`
+	ServerID   string            `json:"server_id,omitempty"`
+	ServerName string            `json:"server_name,omitempty"`
+	ServerAddr string            `json:"server_addr,omitempty"`
+	Reporter   uint64            `json:"reporter"`
+	Reported   uint64            `json:"reported"`
+	Reason     string            `json:"reason"`
+	Evidence   string            `json:"evidence,omitempty"`
+	Map        string            `json:"map,omitempty"`
+	Playlist   string            `json:"playlist,omitempty"`
+	Context    map[string]string `json:"context,omitempty"`
+	Status     AbuseReportStatus `json:"status"`
+	Note       string            `json:"note,omitempty"`
+}
+
+func newAbuseReportJSON(r AbuseReport) abuseReportJSON {
+	x := abuseReportJSON{
+		ID:         r.ID,
+		Time:       r.Time.UTC(),
+		ServerID:   r.ServerID,
+		ServerName: r.ServerName,
+		Reporter:   r.Reporter,
+		Reported:   r.Reported,
+		Reason:     r.Reason,
+		Evidence:   r.Evidence,
+		Map:        r.Map,
+		Playlist:   r.Playlist,
+		Context:    r.Context,
+		Status:     r.Status,
+		Note:       r.Note,
+	}
+	if r.ServerAddr.IsValid() {
+		x.ServerAddr = r.ServerAddr.String()
+	}
+	return x
+}
+
+// reportRateWindow gets the effective window for report rate limiting.
+func (h *Handler) reportRateWindow() time.Duration {
+	if h.ReportRateWindow > 0 {
+		return h.ReportRateWindow
+	}
+	return time.Hour
+}
+
+func (h *Handler) handleServerReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().server_report_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.AbuseReportStorage == nil {
+		h.m().server_report_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("abuse reports are not enabled"))
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_report_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		h.m().server_report_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	srv := h.ServerList.GetServerByID(id)
+	if srv == nil {
+		h.m().server_report_requests_total.reject_server_not_found.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such game server"))
+		return
+	}
+	if srv.Addr.Addr() != raddr.Addr() {
+		h.m().server_report_requests_total.reject_unauthorized_ip.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
+		return
+	}
+
+	var req struct {
+		Reporter uint64            `json:"reporter" validate:"required"`
+		Reported uint64            `json:"reported" validate:"required"`
+		Reason   string            `json:"reason" validate:"required,max=64"`
+		Evidence string            `json:"evidence,omitempty" validate:"max=4096"`
+		Context  map[string]string `json:"context,omitempty" validate:"max=16"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		h.m().server_report_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+	if req.Reporter == req.Reported {
+		h.m().server_report_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("players cannot report themselves"))
+		return
+	}
+	for k, v := range req.Context {
+		if k == "" || len(k) > 64 || len(v) > 256 {
+			h.m().server_report_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("context keys must be at most 64 bytes long and values at most 256 bytes long"))
+			return
+		}
+	}
+
+	for _, uid := range []uint64{req.Reporter, req.Reported} {
+		acct, err := h.AccountStorage.GetAccount(uid)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Uint64("uid", uid).
+				Msgf("failed to read account from storage")
+			h.m().server_report_requests_total.fail_storage_error_account.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if acct == nil {
+			h.m().server_report_requests_total.reject_player_not_found.Inc()
+			respFail(w, r, http.StatusNotFound, ErrorCode_PLAYER_NOT_FOUND.MessageObj())
+			return
+		}
+		// the reporter must be playing on the server so servers can't file
+		// reports on behalf of arbitrary players
+		if uid == req.Reporter && acct.LastServerID != srv.ID {
+			h.m().server_report_requests_total.reject_player_not_on_server.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("reporter is not connected to this server"))
+			return
+		}
+	}
+
+	now := time.Now()
+	window := h.reportRateWindow()
+
+	if !h.reportLimiter.Allow(req.Reporter, now, h.ReportRateLimit, window) {
+		h.m().server_report_requests_total.reject_rate_limited.Inc()
+		respFail(w, r, http.StatusTooManyRequests, ErrorCode_RATE_LIMITED.MessageObjf("too many reports from this player"))
+		return
+	}
+	if !h.checkPlayerQuota(w, r, PlayerQuotaReport, req.Reporter) {
+		h.m().server_report_requests_total.reject_quota.Inc()
+		return
+	}
+
+	// don't queue the same report repeatedly, but don't make the server retry
+	// it either
+	if !h.reportDupes.Allow([2]uint64{req.Reporter, req.Reported}, now, 1, window) {
+		h.m().server_report_requests_total.success_duplicate.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success":   true,
+			"duplicate": true,
+		})
+		return
+	}
+
+	rid, err := cryptoRandHex(32)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to generate random report id")
+		h.m().server_report_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	rp := AbuseReport{
+		ID:         rid,
+		Time:       now,
+		ServerID:   srv.ID,
+		ServerName: srv.Name,
+		ServerAddr: srv.Addr,
+		Reporter:   req.Reporter,
+		Reported:   req.Reported,
+		Reason:     req.Reason,
+		Evidence:   req.Evidence,
+		Map:        srv.Map,
+		Playlist:   srv.Playlist,
+		Context:    req.Context,
+		Status:     AbuseReportOpen,
+	}
+	if err := h.AbuseReportStorage.SaveAbuseReport(&rp); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save abuse report to storage")
+		h.m().server_report_requests_total.fail_storage_error_report.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	hlog.FromRequest(r).Info().
+		Str("report", rp.ID).
+		Uint64("reporter", rp.Reporter).
+		Uint64("reported", rp.Reported).
+		Str("reason", rp.Reason).
+		Msgf("abuse report submitted")
+
+	h.m().server_report_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"id":      rp.ID,
+	})
+}
+
+func (h *Handler) handleAdminReports(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "reports"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	if h.AbuseReportStorage == nil {
+		h.m().admin_requests_total.reject_disabled(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req struct {
+			ID     string            `json:"id" validate:"required"`
+			Status AbuseReportStatus `json:"status" validate:"required,oneof=open|resolved|dismissed"`
+			Note   string            `json:"note,omitempty" validate:"max=1024"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+
+		rp, err := h.AbuseReportStorage.GetAbuseReport(req.ID)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Str("report", req.ID).
+				Msgf("failed to read abuse report from storage")
+			h.m().admin_requests_total.fail_storage_error_report(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if rp == nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("no such report"))
+			return
+		}
+
+		rp.Status = req.Status
+		rp.Note = req.Note
+		if err := h.AbuseReportStorage.SaveAbuseReport(rp); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Str("report", req.ID).
+				Msgf("failed to save abuse report to storage")
+			h.m().admin_requests_total.fail_storage_error_report(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+
+		hlog.FromRequest(r).Info().
+			Str("report", rp.ID).
+			Str("status", string(rp.Status)).
+			Msgf("abuse report updated")
+
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"report":  newAbuseReportJSON(*rp),
+		})
+		return
+	}
+
+	var q struct {
+		Status AbuseReportStatus `param:"status" validate:"oneof=open|resolved|dismissed|any"`
+		UID    uint64            `param:"uid"`
+		Limit  int               `param:"limit" validate:"min=0,max=1000"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+		respError(w, r, err)
+		return
+	}
+	switch q.Status {
+	case "":
+		q.Status = AbuseReportOpen
+	case "any":
+		q.Status = ""
+	}
+	if q.Limit == 0 {
+		q.Limit = 100
+	}
+
+	rs, err := h.AbuseReportStorage.GetAbuseReports(q.Status, q.UID, q.Limit)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to read abuse reports from storage")
+		h.m().admin_requests_total.fail_storage_error_report(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	xs := make([]abuseReportJSON, 0, len(rs))
+	for _, rp := range rs {
+		xs = append(xs, newAbuseReportJSON(rp))
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+		"reports": xs,
+	})
+}
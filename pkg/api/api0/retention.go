@@ -0,0 +1,220 @@
+package api0
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// RetentionInterval is the default interval at which RunRetention should be
+// called.
+const RetentionInterval = time.Hour * 24
+
+// RetentionConfig configures the retention jobs run by RunRetention. Zero
+// durations disable the corresponding job.
+type RetentionConfig struct {
+	// Sessions is how long expired auth sessions are kept for before being
+	// removed from accounts. The expiry of the current session is kept since
+	// it is used as the last activity time of the account.
+	Sessions time.Duration
+
+	// StaleAccounts is how long accounts without any account links are kept
+	// for after their last auth before being erased along with all of their
+	// data. Accounts with active bans are never erased.
+	StaleAccounts time.Duration
+
+	// HeartbeatHistory is how long the in-memory game server heartbeat and
+	// event history is kept for. It is always limited to 24 hours.
+	HeartbeatHistory time.Duration
+
+	// ServerStats is how long 5-minute server stats buckets are kept for
+	// before only the hourly ones remain. If zero, it defaults to 48 hours.
+	ServerStats time.Duration
+
+	// Compact compacts storage implementing CompactStorage after the other
+	// jobs have run.
+	Compact bool
+}
+
+// serverStatsFineRetention gets the effective retention for 5-minute server
+// stats buckets.
+func (h *Handler) serverStatsFineRetention() time.Duration {
+	if h.Retention.ServerStats > 0 {
+		return h.Retention.ServerStats
+	}
+	return serverStatsFineRetention
+}
+
+// RunRetention prunes old data according to Retention. It should be called
+// every RetentionInterval, and only on a single instance at a time. Server
+// stats buckets are pruned by RecordServerStats instead. If AccountStorage
+// doesn't implement AccountListStorage, the account jobs are skipped.
+func (h *Handler) RunRetention(ctx context.Context, t time.Time) error {
+	err := h.runRetention(ctx, t)
+	if err != nil {
+		h.m().retention_runs_total.fail_error.Inc()
+	} else {
+		h.m().retention_runs_total.success.Inc()
+	}
+	return err
+}
+
+func (h *Handler) runRetention(ctx context.Context, t time.Time) error {
+	if d := h.Retention.HeartbeatHistory; d > 0 {
+		h.m().retention_deleted_total.heartbeats.Add(h.serverHistory.Prune(t.Add(-d)))
+	}
+
+	if h.Retention.Sessions > 0 || h.Retention.StaleAccounts > 0 || h.GuestMode.Enabled {
+		if err := h.pruneAccounts(ctx, t); err != nil {
+			return err
+		}
+	}
+
+	if h.Retention.Compact {
+		for _, s := range h.Compactors {
+			n, err := s.Compact(ctx)
+			if err != nil {
+				return fmt.Errorf("compact storage: %w", err)
+			}
+			if n > 0 {
+				h.m().retention_reclaimed_bytes_total.Add(int(n))
+			}
+		}
+	}
+	return nil
+}
+
+// pruneAccounts removes expired sessions and guest accounts, and erases stale
+// unlinked accounts.
+func (h *Handler) pruneAccounts(ctx context.Context, t time.Time) error {
+	ls, ok := h.AccountStorage.(AccountListStorage)
+	if !ok {
+		return nil
+	}
+	uids, err := ls.GetAccountUIDs()
+	if err != nil {
+		return fmt.Errorf("get account uids: %w", err)
+	}
+
+	var banned map[uint64]struct{}
+	if h.Retention.StaleAccounts > 0 && h.BanListStorage != nil {
+		if banned, err = h.bannedUIDs(); err != nil {
+			return err
+		}
+	}
+
+	for _, uid := range uids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		a, err := h.AccountStorage.GetAccount(uid)
+		if err != nil {
+			return fmt.Errorf("get account %d: %w", uid, err)
+		}
+		if a == nil {
+			continue // deleted concurrently
+		}
+
+		if IsGuestUID(uid) && !t.Before(a.AuthTokenExpiry) {
+			if err := h.AccountStorage.DeleteAccount(uid); err != nil {
+				return fmt.Errorf("delete expired guest account %d: %w", uid, err)
+			}
+			h.m().retention_deleted_total.accounts.Inc()
+			continue
+		}
+
+		if last := accountLastActive(a); h.Retention.StaleAccounts > 0 && !last.IsZero() && last.Before(t.Add(-h.Retention.StaleAccounts)) {
+			if _, ok := banned[uid]; !ok {
+				stale := true
+				if h.AccountLinkStorage != nil {
+					links, err := h.AccountLinkStorage.GetAccountLinks(uid)
+					if err != nil {
+						return fmt.Errorf("get account links for %d: %w", uid, err)
+					}
+					stale = len(links) == 0
+				}
+				if stale {
+					if err := h.eraseAccount(uid); err != nil {
+						return fmt.Errorf("erase stale account %d: %w", uid, err)
+					}
+					h.m().retention_deleted_total.accounts.Inc()
+					continue
+				}
+			}
+		}
+
+		if d := h.Retention.Sessions; d > 0 {
+			if n := pruneSessions(a, t.Add(-d)); n != 0 {
+				if err := h.AccountStorage.SaveAccount(a); err != nil {
+					return fmt.Errorf("save account %d: %w", uid, err)
+				}
+				h.m().retention_deleted_total.sessions.Add(n)
+			}
+		}
+	}
+	return nil
+}
+
+// bannedUIDs gets the UIDs with non-revoked entries in any ban list.
+func (h *Handler) bannedUIDs() (map[uint64]struct{}, error) {
+	lists, err := h.banLists.Get(h.StateStorage, "banlists")
+	if err != nil {
+		return nil, fmt.Errorf("get ban lists: %w", err)
+	}
+	banned := map[uint64]struct{}{}
+	for _, l := range lists {
+		es, err := h.BanListStorage.GetBanListEntries(l.ID)
+		if err != nil {
+			return nil, fmt.Errorf("get ban list %q entries: %w", l.ID, err)
+		}
+		for _, e := range es {
+			if !e.Revoked {
+				banned[e.UID] = struct{}{}
+			}
+		}
+	}
+	return banned, nil
+}
+
+// accountLastActive gets the last time a was authenticated or verified, or the
+// zero time if it is unknown.
+func accountLastActive(a *Account) time.Time {
+	last := a.AuthTokenExpiry
+	if a.VerifiedAt.After(last) {
+		last = a.VerifiedAt
+	}
+	for _, s := range a.OtherSessions {
+		if s.Expiry.After(last) {
+			last = s.Expiry
+		}
+	}
+	return last
+}
+
+// pruneSessions removes sessions from a which expired before t, returning the
+// number removed.
+func pruneSessions(a *Account, t time.Time) int {
+	var n int
+	if a.AuthToken != "" && a.AuthTokenExpiry.Before(t) {
+		a.AuthIP = netip.Addr{}
+		a.AuthToken = ""
+		a.AuthStaleVerified = false
+		a.AuthIPFlags = 0
+		n++
+	}
+	ss := a.OtherSessions[:0]
+	for _, s := range a.OtherSessions {
+		if s.Expiry.Before(t) {
+			n++
+		} else {
+			ss = append(ss, s)
+		}
+	}
+	if len(ss) == 0 {
+		ss = nil
+	}
+	a.OtherSessions = ss
+	return n
+}
@@ -0,0 +1,277 @@
+package api0
+
+import (
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/r2northstar/atlas/pkg/msgpack"
+	"github.com/r2northstar/atlas/pkg/nstypes"
+	"github.com/rs/zerolog/hlog"
+)
+
+const (
+	serverSearchDefaultLimit = 20  // default number of results
+	serverSearchMaxLimit     = 100 // maximum number of results
+	serverSearchMaxQuery     = 64  // maximum query length in bytes
+)
+
+// serverSearchQuery is a normalized server search query.
+type serverSearchQuery struct {
+	text     string
+	trigrams map[[3]rune]struct{} // nil if the query is too short
+}
+
+func newServerSearchQuery(q string) serverSearchQuery {
+	x := serverSearchQuery{text: searchNormalize(q)}
+	if utf8.RuneCountInString(x.text) >= 3 {
+		x.trigrams = map[[3]rune]struct{}{}
+		searchTrigrams(x.text, func(g [3]rune) {
+			x.trigrams[g] = struct{}{}
+		})
+	}
+	return x
+}
+
+// Match scores how well f matches the query from 0 (no match) to 1 (exact
+// match). Prefix and substring matches always score higher than fuzzy ones,
+// which require at least 60% of the query trigrams to be present.
+func (q serverSearchQuery) Match(f string) float64 {
+	f = searchNormalize(f)
+	switch {
+	case q.text == "" || f == "":
+		return 0
+	case f == q.text:
+		return 1
+	case strings.HasPrefix(f, q.text):
+		return 0.9
+	case strings.Contains(" "+f, " "+q.text):
+		return 0.8 // word prefix
+	case strings.Contains(f, q.text):
+		return 0.7
+	case q.trigrams == nil:
+		return 0
+	}
+	var matched [][3]rune
+	searchTrigrams(f, func(g [3]rune) {
+		if _, ok := q.trigrams[g]; ok {
+			for _, x := range matched {
+				if x == g {
+					return
+				}
+			}
+			matched = append(matched, g)
+		}
+	})
+	if c := float64(len(matched)) / float64(len(q.trigrams)); c >= 0.6 {
+		return 0.6 * c
+	}
+	return 0
+}
+
+// MatchServer scores srv against the query using the name and tags (the map,
+// playlist, region, and mod names), which are weighted lower than the name.
+func (q serverSearchQuery) MatchServer(srv *Server) float64 {
+	score := q.Match(srv.Name)
+	tag := func(f string) {
+		if v := q.Match(f) * 0.8; v > score {
+			score = v
+		}
+	}
+	tag(srv.Map)
+	if v, ok := nstypes.Map(srv.Map).Title(); ok {
+		tag(v)
+	}
+	tag(srv.Playlist)
+	if v, ok := nstypes.Playlist(srv.Playlist).Title(); ok {
+		tag(v)
+	}
+	tag(srv.Region)
+	for _, mi := range srv.ModInfo {
+		tag(mi.Name)
+	}
+	return score
+}
+
+// searchNormalize lowercases s and replaces runs of other characters than
+// letters and digits with a single space.
+func searchNormalize(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	sp := false
+	for _, c := range s {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			if sp && b.Len() != 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(unicode.ToLower(c))
+			sp = false
+		} else {
+			sp = true
+		}
+	}
+	return b.String()
+}
+
+// searchTrigrams calls fn for each trigram of s padded with a space on each
+// side (so matches at the start and end of s are weighted higher).
+func searchTrigrams(s string, fn func([3]rune)) {
+	g := [3]rune{' ', ' ', ' '}
+	n := 1
+	for _, c := range s + " " {
+		g[0], g[1], g[2] = g[1], g[2], c
+		if n++; n >= 3 {
+			fn(g)
+		}
+	}
+}
+
+// csSearch is like csGetFiltered, but only includes up to limit public
+// servers matching q, ordered by how well they match, then by player count
+// (highest first), then by ID.
+func (s *ServerList) csSearch(cb *csBuffer, mp bool, q string, limit int, fn func(*Server) bool) []byte {
+	t := s.now()
+	ss, pins := s.csCopy(cb, t, false)
+	sq := newServerSearchQuery(q)
+
+	type result struct {
+		srv   *Server
+		score float64
+	}
+	var rs []result
+	for _, srv := range ss {
+		if fn == nil || fn(srv) {
+			if v := sq.MatchServer(srv); v > 0 {
+				rs = append(rs, result{srv, v})
+			}
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		a, b := rs[i], rs[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.srv.PlayerCount != b.srv.PlayerCount {
+			return a.srv.PlayerCount > b.srv.PlayerCount
+		}
+		return a.srv.ID < b.srv.ID
+	})
+	if len(rs) > limit {
+		rs = rs[:limit]
+	}
+
+	fss := ss[:0]
+	for _, r := range rs {
+		fss = append(fss, r.srv)
+	}
+	if mp {
+		cb.buf = csAppendMsgpack(cb.buf[:0], fss, s.cfg, s.csHinter(t))
+	} else {
+		cb.buf, _ = csAppendJSON(cb.buf[:0], fss, int(s.csEst.Load()), s.cfg, pins, s.csHinter(t))
+	}
+	return cb.buf
+}
+
+// handleClientServersSearch searches the public server list by name and tags
+// (see serverSearchQuery) so launchers can implement search-as-you-type
+// without downloading the full list. The response is in the same format as
+// /client/servers.
+//
+// Query parameters:
+//   - q: the search query (required)
+//   - limit: the maximum number of results (default 20, max 100)
+func (h *Handler) handleClientServersSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().client_servers_search_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, HEAD")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().client_servers_search_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		h.m().client_servers_search_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("q param is required"))
+		return
+	}
+	if len(q) > serverSearchMaxQuery {
+		h.m().client_servers_search_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("q param is too long"))
+		return
+	}
+
+	limit := serverSearchDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > serverSearchMaxLimit {
+			h.m().client_servers_search_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("limit param must be between 1 and %d", serverSearchMaxLimit))
+			return
+		}
+	}
+
+	if !h.searchLimiter.Allow(raddr.Addr(), time.Now(), h.ServerSearchRateLimit, time.Minute) {
+		h.m().client_servers_search_requests_total.reject_rate_limited.Inc()
+		respFail(w, r, http.StatusTooManyRequests, ErrorCode_RATE_LIMITED.MessageObjf("too many searches"))
+		return
+	}
+
+	var filter func(*Server) bool
+	if hs := h.listQueryHooks(); len(hs) != 0 {
+		filter = func(srv *Server) bool {
+			for _, x := range hs {
+				if !x.OnListQuery(r, srv) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	w.Header().Set("Vary", "Accept")
+	mp := negotiateContentType(r, "application/json", msgpack.ContentType+" application/x-msgpack") == msgpack.ContentType
+	if mp {
+		w.Header().Set("Content-Type", msgpack.ContentType)
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	cb := h.ServerList.csGetBuffer()
+	defer h.ServerList.csPutBuffer(cb)
+	buf := h.ServerList.csSearch(cb, mp, q, limit, filter)
+
+	h.m().client_servers_search_requests_total.success.Inc()
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(buf)
+	}
+}
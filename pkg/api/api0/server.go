@@ -2,6 +2,8 @@ package api0
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -44,9 +46,7 @@ func (h *Handler) handleServerUpsert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Cache-Control", "private, no-cache, no-store")
-	w.Header().Set("Expires", "0")
-	w.Header().Set("Pragma", "no-cache")
+	setNoCacheHeaders(w)
 
 	if r.Method == http.MethodOptions {
 		w.Header().Set("Allow", "OPTIONS, POST")
@@ -56,7 +56,7 @@ func (h *Handler) handleServerUpsert(w http.ResponseWriter, r *http.Request) {
 
 	if !h.CheckLauncherVersion(r, false) {
 		h.m().server_upsert_requests_total.reject_versiongate(action).Inc()
-		respFail(w, r, http.StatusBadRequest, ErrorCode_UNSUPPORTED_VERSION.MessageObj())
+		h.respUpdateRequired(w, r, false)
 		return
 	}
 
@@ -78,22 +78,35 @@ func (h *Handler) handleServerUpsert(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var l ServerListLimit
-	if n := h.MaxServers; n > 0 {
-		l.MaxServers = n
-	} else if n == 0 {
-		l.MaxServers = 1000
+	if !h.checkAnomaly(w, r, raddr.Addr()) {
+		h.m().server_upsert_requests_total.reject_anomaly(action).Inc()
+		return
 	}
-	if n := h.MaxServersPerIP; n > 0 {
-		l.MaxServersPerIP = n
-	} else if n == 0 {
-		l.MaxServersPerIP = 50
+
+	// note: we don't use r.URL.Query since parsing the entire query string
+	// into a map was a significant part of the time spent handling heartbeats
+	q := rawQuery(r.URL.RawQuery)
+
+	if canUpdate {
+		if id := q.Get("id"); id != "" && !h.checkServerSignature(w, r, h.ServerList.GetServerByID(id), action) {
+			return
+		}
+	}
+
+	if canCreate && !h.checkNetworkRules(r, NetworkRuleScopeServer, raddr.Addr(), 0) {
+		h.serverWebhook(netip.AddrPortFrom(raddr.Addr(), 0), ServerWebhookBanned, "", "blocked by network rules")
+		h.m().server_upsert_requests_total.reject_network_rule(action).Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_NETWORK_BLOCKED.MessageObj())
+		return
 	}
 
+	l := h.serverListLimit()
+
 	var s *Server
 	if canCreate {
 		s = &Server{
 			LauncherVersion: h.ExtractLauncherVersion(r),
+			IPFlags:         h.lookupIPReputation(r, raddr.Addr()),
 		}
 	}
 
@@ -105,8 +118,6 @@ func (h *Handler) handleServerUpsert(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	q := r.URL.Query()
-
 	if canUpdate {
 		if v := q.Get("id"); v == "" {
 			if isUpdate {
@@ -159,47 +170,56 @@ func (h *Handler) handleServerUpsert(w http.ResponseWriter, r *http.Request) {
 		} else {
 			s.Password = v
 		}
+
+		if ok, err := parseServerSigning(q.Get("signing"), s); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to generate server signing key")
+			h.m().server_upsert_requests_total.fail_other_error(action).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		} else if !ok && isCreate {
+			h.m().server_upsert_requests_total.reject_bad_request(action).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("signing param must be optional or required"))
+			return
+		}
+
+		// note: unlike the other params, this is rejected even if we're only
+		// creating as a fallback since ignoring it would make the server public
+		if err := h.parseServerVisibility(q, s); err != nil {
+			h.m().server_upsert_requests_total.reject_bad_request(action).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", err))
+			return
+		}
+
+		if d, st := h.serverDelist(r, s.Addr); st == ServerDelistBanned {
+			msg := serverDelistMessage(d, st)
+			h.serverEvent(s.Addr, ServerEventRejected, "", "%s", msg)
+			h.m().server_upsert_requests_total.reject_delist_banned(action).Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("%s", msg))
+			return
+		} else if st == ServerDelistDelisted && s.Visibility == ServerVisibilityPublic {
+			s.Visibility = ServerVisibilityUnlisted
+		}
 	}
 
 	if canCreate || canUpdate {
-		if h.LookupIP != nil && h.GetRegion != nil {
-			if rec, err := h.LookupIP(raddr.Addr()); err == nil {
-				var lat, lon float64
-				if v, _ := rec.GetFloat32(ip2x.Latitude); v != 0 {
-					lat = float64(v)
-				}
-				if v, _ := rec.GetFloat32(ip2x.Longitude); v != 0 {
-					lon = float64(v)
-				}
+		if lat, lon, region, ok, regionOK := h.lookupServerGeo(r, raddr.Addr()); ok {
+			if canCreate {
+				s.Latitude = lat
+				s.Longitude = lon
+			}
+			if canUpdate {
+				u.Latitude = &lat
+				u.Longitude = &lon
+			}
+			if regionOK {
 				if canCreate {
-					s.Latitude = lat
-					s.Longitude = lon
+					s.Region = region
 				}
 				if canUpdate {
-					u.Latitude = &lat
-					u.Longitude = &lon
+					u.Region = &region
 				}
-
-				region, err := h.GetRegion(raddr.Addr(), rec)
-				if err == nil || region != "" { // if an error occurs, we may still have a best-effort region
-					if canCreate {
-						s.Region = region
-					}
-					if canUpdate {
-						u.Region = &region
-					}
-				}
-				if err != nil {
-					h.m().server_upsert_getregion_errors_total.Inc()
-					if region == "" {
-						hlog.FromRequest(r).Err(err).Str("ip", raddr.Addr().String()).Msg("failed to compute region, no best-effort region available")
-					} else {
-						hlog.FromRequest(r).Err(err).Str("ip", raddr.Addr().String()).Msgf("failed to compute region, using best-effort region %q", region)
-					}
-				}
-			} else {
-				h.m().server_upsert_ip2location_errors_total.Inc()
-				hlog.FromRequest(r).Err(err).Str("ip", raddr.Addr().String()).Msg("failed to lookup remote ip in ip2location database")
 			}
 		}
 	}
@@ -212,8 +232,8 @@ func (h *Handler) handleServerUpsert(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		} else {
-			if h.CleanBadWords != nil {
-				v = h.CleanBadWords(v)
+			if f := h.cfg().CleanBadWords; f != nil {
+				v = f(v)
 			}
 			if n := 256; len(v) > n { // NorthstarLauncher@v1.9.7 limits it to 63
 				v = v[:n]
@@ -227,8 +247,8 @@ func (h *Handler) handleServerUpsert(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if v := q.Get("description"); v != "" {
-			if h.CleanBadWords != nil {
-				v = h.CleanBadWords(v)
+			if f := h.cfg().CleanBadWords; f != nil {
+				v = f(v)
 			}
 			if n := 1024; len(v) > n { // NorthstarLauncher@v1.9.7 doesn't have a limit
 				v = v[:n]
@@ -334,35 +354,53 @@ func (h *Handler) handleServerUpsert(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if s != nil {
+		if err := h.runRegisterHooks(r, s); err != nil {
+			h.serverEvent(s.Addr, ServerEventRejected, "", "%s: rejected by hook: %v", action, err)
+			h.serverWebhook(s.Addr, ServerWebhookBanned, "", fmt.Sprintf("rejected by hook: %v", err))
+			h.m().server_upsert_requests_total.reject_hook(action).Inc()
+			status, obj := hookError(err)
+			respFail(w, r, status, obj)
+			return
+		}
+	}
+
 	nsrv, err := h.ServerList.ServerHybridUpdatePut(u, s, l)
 	if err != nil {
-		if errors.Is(err, ErrServerListUpdateWrongIP) {
-			h.m().server_upsert_requests_total.reject_unauthorized_ip(action).Inc()
-			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("%v", err))
-			return
+		if s != nil {
+			h.serverEvent(s.Addr, ServerEventRejected, "", "%s: %v", action, err)
 		}
-		if errors.Is(err, ErrServerListUpdateServerDead) {
-			h.m().server_upsert_requests_total.reject_server_not_found(action).Inc()
-			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such server"))
-			return
+		if !errors.Is(err, ErrServerListUpdateServerDead) {
+			h.anomalyFailure(r, raddr.Addr(), "server list rejected registration")
 		}
-		if errors.Is(err, ErrServerListDuplicateAuthAddr) {
+		switch {
+		case errors.Is(err, ErrServerListUpdateWrongIP):
+			h.m().server_upsert_requests_total.reject_unauthorized_ip(action).Inc()
+		case errors.Is(err, ErrServerListUpdateServerDead):
+			h.m().server_upsert_requests_total.reject_server_not_found(action).Inc()
+		case errors.Is(err, ErrServerListDuplicateAuthAddr):
 			h.m().server_upsert_requests_total.reject_duplicate_auth_addr(action).Inc()
-			respFail(w, r, http.StatusForbidden, ErrorCode_DUPLICATE_SERVER.MessageObjf("%v", err))
-			return
-		}
-		if errors.Is(err, ErrServerListLimitExceeded) {
+		case errors.Is(err, ErrServerListLimitExceeded):
 			h.m().server_upsert_requests_total.reject_limits_exceeded(action).Inc()
-			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObjf("%v", err))
-			return
+		default:
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to update server list")
+			h.m().server_upsert_requests_total.fail_serverlist_error(action).Inc()
 		}
-		hlog.FromRequest(r).Error().
-			Err(err).
-			Msgf("failed to update server list")
-		h.m().server_upsert_requests_total.fail_serverlist_error(action).Inc()
-		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		respError(w, r, err)
 		return
 	}
+	h.updateServerAttestation(r, nsrv)
+
+	h.serverHistory.Heartbeat(nsrv.Addr, time.Now().UTC())
+	if u == nil || u.ID != nsrv.ID {
+		if nsrv.IPFlags != 0 {
+			h.serverEvent(nsrv.Addr, ServerEventRegistered, nsrv.ID, "%s (ip flags: %s)", action, nsrv.IPFlags)
+		} else {
+			h.serverEvent(nsrv.Addr, ServerEventRegistered, nsrv.ID, "%s", action)
+		}
+	}
 
 	if !nsrv.VerificationDeadline.IsZero() {
 		verifyStart := time.Now()
@@ -386,6 +424,8 @@ func (h *Handler) handleServerUpsert(w http.ResponseWriter, r *http.Request) {
 					h.m().server_upsert_requests_total.reject_verify_autherr(action).Inc()
 				}
 				h.m().server_upsert_verify_time_seconds.failure.UpdateDuration(verifyStart)
+				h.serverEvent(nsrv.Addr, ServerEventVerificationFailed, nsrv.ID, "failed to connect to auth port %d: %v", nsrv.AuthPort, err)
+				h.anomalyFailure(r, raddr.Addr(), "server verification failed")
 				respFail(w, r, http.StatusBadGateway, code.MessageObjf("failed to connect to auth port: %v", err))
 				return
 			}
@@ -402,6 +442,8 @@ func (h *Handler) handleServerUpsert(w http.ResponseWriter, r *http.Request) {
 				obj = ErrorCode_INTERNAL_SERVER_ERROR.MessageObjf("failed to connect to game port: %v", err)
 			}
 			h.m().server_upsert_verify_time_seconds.failure.UpdateDuration(verifyStart)
+			h.serverEvent(nsrv.Addr, ServerEventVerificationFailed, nsrv.ID, "failed to connect to game port %d: %v", nsrv.Addr.Port(), err)
+			h.anomalyFailure(r, raddr.Addr(), "server verification failed")
 			respFail(w, r, http.StatusBadGateway, obj)
 			return
 		}
@@ -410,19 +452,58 @@ func (h *Handler) handleServerUpsert(w http.ResponseWriter, r *http.Request) {
 
 		if !h.ServerList.VerifyServer(nsrv.ID) {
 			h.m().server_upsert_requests_total.reject_verify_udptimeout(action).Inc()
+			h.serverEvent(nsrv.Addr, ServerEventVerificationFailed, nsrv.ID, "verification timed out")
 			respFail(w, r, http.StatusBadGateway, ErrorCode_NO_GAMESERVER_RESPONSE.MessageObjf("verification timed out"))
 			return
 		}
 
+		h.serverEvent(nsrv.Addr, ServerEventVerified, nsrv.ID, "")
+		h.analyticsEvent(analyticsServer(AnalyticsEvent{
+			Type:            AnalyticsEventServerRegistered,
+			LauncherVersion: nsrv.LauncherVersion,
+		}, nsrv))
+
 		h.m().server_upsert_requests_total.success_verified(action).Inc()
 	} else {
 		h.m().server_upsert_requests_total.success_updated(action).Inc()
 	}
-	respJSON(w, r, http.StatusOK, map[string]any{
-		"success":         true,
-		"id":              nsrv.ID,
-		"serverAuthToken": nsrv.ServerAuthToken,
-	})
+	// note: this is a struct (in the same order as the sorted map keys would
+	// be) rather than a map since it's much cheaper to encode
+	obj := struct {
+		ID              string `json:"id"`
+		ServerAuthToken string `json:"serverAuthToken"`
+		SigningKey      string `json:"signingKey,omitempty"`
+		Success         bool   `json:"success"`
+		UDPHeartbeat    uint16 `json:"udpHeartbeatPort,omitempty"`
+	}{
+		ID:              nsrv.ID,
+		ServerAuthToken: nsrv.ServerAuthToken,
+		Success:         true,
+	}
+	if isCreate {
+		obj.SigningKey = nsrv.SigningKey
+		if obj.SigningKey != "" {
+			obj.UDPHeartbeat = h.udpHeartbeatPort()
+		}
+	}
+	respJSON(w, r, http.StatusOK, obj)
+}
+
+// serverListLimit gets the effective server list limits.
+func (h *Handler) serverListLimit() ServerListLimit {
+	var l ServerListLimit
+	c := h.cfg()
+	if n := c.MaxServers; n > 0 {
+		l.MaxServers = n
+	} else if n == 0 {
+		l.MaxServers = 1000
+	}
+	if n := c.MaxServersPerIP; n > 0 {
+		l.MaxServersPerIP = n
+	} else if n == 0 {
+		l.MaxServersPerIP = 50
+	}
+	return l
 }
 
 func (h *Handler) probeUDP(ctx context.Context, addr netip.AddrPort) error {
@@ -462,6 +543,75 @@ func (h *Handler) probeUDP(ctx context.Context, addr netip.AddrPort) error {
 	return err
 }
 
+// serverGeoCacheTTL is the amount of time to cache game server location
+// lookups for.
+const serverGeoCacheTTL = time.Hour
+
+// lookupServerGeo gets the location and region of a game server ip. If the
+// location could not be looked up, ok is false. If a region (possibly a
+// best-effort one) is not available, regionOK is false.
+func (h *Handler) lookupServerGeo(r *http.Request, ip netip.Addr) (lat, lon float64, region string, ok, regionOK bool) {
+	if h.LookupIP == nil || h.GetRegion == nil {
+		return
+	}
+
+	var cached struct {
+		Latitude  float64 `json:"lat"`
+		Longitude float64 `json:"lon"`
+		Region    string  `json:"region"`
+	}
+	key := "servergeo:" + ip.String()
+	if h.Cache != nil {
+		if buf, exists, err := h.Cache.Get(key); err != nil {
+			hlog.FromRequest(r).Warn().Err(err).Msg("failed to get cached server location")
+			h.m().cache_lookups_total.servergeo_error.Inc()
+		} else if !exists {
+			h.m().cache_lookups_total.servergeo_miss.Inc()
+		} else if err := json.Unmarshal(buf, &cached); err != nil {
+			h.m().cache_lookups_total.servergeo_error.Inc()
+		} else {
+			h.m().cache_lookups_total.servergeo_hit.Inc()
+			return cached.Latitude, cached.Longitude, cached.Region, true, true
+		}
+	}
+
+	rec, err := h.LookupIP(ip)
+	if err != nil {
+		h.m().server_upsert_ip2location_errors_total.Inc()
+		hlog.FromRequest(r).Err(err).Str("ip", ip.String()).Msg("failed to lookup remote ip in ip2location database")
+		return
+	}
+	if v, _ := rec.GetFloat32(ip2x.Latitude); v != 0 {
+		lat = float64(v)
+	}
+	if v, _ := rec.GetFloat32(ip2x.Longitude); v != 0 {
+		lon = float64(v)
+	}
+	ok = true
+
+	region, err = h.GetRegion(ip, rec)
+	regionOK = err == nil || region != "" // if an error occurs, we may still have a best-effort region
+	if err != nil {
+		h.m().server_upsert_getregion_errors_total.Inc()
+		if region == "" {
+			hlog.FromRequest(r).Err(err).Str("ip", ip.String()).Msg("failed to compute region, no best-effort region available")
+		} else {
+			hlog.FromRequest(r).Err(err).Str("ip", ip.String()).Msgf("failed to compute region, using best-effort region %q", region)
+		}
+		return // don't cache best-effort regions
+	}
+
+	if h.Cache != nil {
+		cached.Latitude, cached.Longitude, cached.Region = lat, lon, region
+		if buf, err := json.Marshal(cached); err == nil {
+			if err := h.Cache.Set(key, buf, serverGeoCacheTTL); err != nil {
+				hlog.FromRequest(r).Warn().Err(err).Msg("failed to cache server location")
+			}
+		}
+	}
+	return
+}
+
 func (h *Handler) handleServerRemove(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodOptions && r.Method != http.MethodDelete {
 		h.m().server_remove_requests_total.http_method_not_allowed.Inc()
@@ -510,6 +660,10 @@ func (h *Handler) handleServerRemove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	h.ServerList.DeleteServerByID(id)
+	h.serverEvent(srv.Addr, ServerEventRemoved, srv.ID, "removed by server")
+	h.analyticsEvent(analyticsServer(AnalyticsEvent{
+		Type: AnalyticsEventServerRemoved,
+	}, srv))
 
 	h.m().server_remove_requests_total.success.Inc()
 	respJSON(w, r, http.StatusOK, map[string]any{
@@ -583,6 +737,17 @@ func (h *Handler) handleServerConnect(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method == http.MethodGet {
 		state.gotPdata.Store(true)
+
+		// servers which already have the latest pdata can skip downloading it
+		hash := sha256.Sum256(state.pdata)
+		etag := `W/"` + hex.EncodeToString(hash[:]) + `"`
+		w.Header().Set("ETag", etag)
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			h.m().server_connect_requests_total.success_pdata_not_modified.Inc()
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
 		h.m().server_connect_requests_total.success_pdata.Inc()
 		respMaybeCompress(w, r, http.StatusOK, state.pdata)
 		return
@@ -612,6 +777,16 @@ func (h *Handler) handleServerConnect(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if reject == "" {
+		// servers may provide opaque identifiers (e.g., a hash of the
+		// player's hardware) for alt detection
+		for i, v := range r.URL.Query()["hint"] {
+			if i >= 4 {
+				break
+			}
+			if len(v) <= 128 {
+				h.recordAccountSignal(r, state.uid, AccountSignalHint, v)
+			}
+		}
 		h.m().server_connect_requests_total.success.Inc()
 	} else {
 		h.m().server_connect_requests_total.success_reject.Inc()
@@ -0,0 +1,269 @@
+package api0
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+const (
+	serverHistoryMaxAddrs      = 8192
+	serverHistoryMaxHeartbeats = 60
+	serverHistoryMaxEvents     = 32
+	serverHistoryTTL           = time.Hour * 24
+)
+
+// ServerEventType is the type of a server history event.
+type ServerEventType string
+
+const (
+	ServerEventRegistered         ServerEventType = "registered"
+	ServerEventVerified           ServerEventType = "verified"
+	ServerEventVerificationFailed ServerEventType = "verification_failed"
+	ServerEventRejected           ServerEventType = "rejected"
+	ServerEventRemoved            ServerEventType = "removed"
+)
+
+// ServerEvent is an event in the history of a game server address.
+type ServerEvent struct {
+	Time    time.Time       `json:"time"`
+	Type    ServerEventType `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+// serverHistory keeps track of recent heartbeats and events for game server
+// addresses to help owners debug why their server isn't shown. It is bounded
+// and in-memory only. It is safe for concurrent use.
+type serverHistory struct {
+	mu sync.Mutex
+	m  map[netip.AddrPort]*serverHistoryEntry
+}
+
+type serverHistoryEntry struct {
+	last       time.Time
+	heartbeats []time.Time
+	events     []ServerEvent
+}
+
+// entry gets the entry for addr, creating it if required. s.mu must be held.
+func (s *serverHistory) entry(addr netip.AddrPort, t time.Time) *serverHistoryEntry {
+	if s.m == nil {
+		s.m = make(map[netip.AddrPort]*serverHistoryEntry)
+	}
+	e, ok := s.m[addr]
+	if !ok {
+		if len(s.m) >= serverHistoryMaxAddrs {
+			var oldest netip.AddrPort
+			var oldestTime time.Time
+			for a, x := range s.m {
+				if t.Sub(x.last) > serverHistoryTTL {
+					delete(s.m, a)
+				} else if oldestTime.IsZero() || x.last.Before(oldestTime) {
+					oldest, oldestTime = a, x.last
+				}
+			}
+			if len(s.m) >= serverHistoryMaxAddrs {
+				delete(s.m, oldest)
+			}
+		}
+		e = new(serverHistoryEntry)
+		s.m[addr] = e
+	}
+	e.last = t
+	return e
+}
+
+// Len returns the number of servers with history.
+func (s *serverHistory) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.m)
+}
+
+// Heartbeat records a heartbeat for addr at t.
+func (s *serverHistory) Heartbeat(addr netip.AddrPort, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entry(addr, t)
+	if len(e.heartbeats) >= serverHistoryMaxHeartbeats {
+		e.heartbeats = append(e.heartbeats[:0], e.heartbeats[len(e.heartbeats)-serverHistoryMaxHeartbeats+1:]...)
+	}
+	e.heartbeats = append(e.heartbeats, t)
+}
+
+// Prune deletes heartbeats and events before t, and addresses without any
+// remaining history, returning the number of deleted heartbeats.
+func (s *serverHistory) Prune(t time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for a, e := range s.m {
+		i := 0
+		for i < len(e.heartbeats) && e.heartbeats[i].Before(t) {
+			i++
+		}
+		n += i
+		e.heartbeats = append(e.heartbeats[:0], e.heartbeats[i:]...)
+
+		j := 0
+		for j < len(e.events) && e.events[j].Time.Before(t) {
+			j++
+		}
+		e.events = append(e.events[:0], e.events[j:]...)
+
+		if len(e.heartbeats) == 0 && len(e.events) == 0 {
+			delete(s.m, a)
+		}
+	}
+	return n
+}
+
+// Event records an event for addr.
+func (s *serverHistory) Event(addr netip.AddrPort, ev ServerEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entry(addr, ev.Time)
+	if len(e.events) >= serverHistoryMaxEvents {
+		e.events = append(e.events[:0], e.events[len(e.events)-serverHistoryMaxEvents+1:]...)
+	}
+	e.events = append(e.events, ev)
+}
+
+// Get gets a copy of the history for addr, newest first.
+func (s *serverHistory) Get(addr netip.AddrPort, t time.Time) (heartbeats []time.Time, events []ServerEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.m[addr]
+	if !ok || t.Sub(e.last) > serverHistoryTTL {
+		return nil, nil
+	}
+	heartbeats = make([]time.Time, len(e.heartbeats))
+	for i, x := range e.heartbeats {
+		heartbeats[len(heartbeats)-1-i] = x
+	}
+	events = make([]ServerEvent, len(e.events))
+	for i, x := range e.events {
+		events[len(events)-1-i] = x
+	}
+	return
+}
+
+// serverEvent records a history event for the game server address addr.
+func (h *Handler) serverEvent(addr netip.AddrPort, typ ServerEventType, id string, format string, a ...interface{}) {
+	if addr.IsValid() {
+		msg := fmt.Sprintf(format, a...)
+		h.serverHistory.Event(addr, ServerEvent{
+			Time:    time.Now().UTC(),
+			Type:    typ,
+			ID:      id,
+			Message: msg,
+		})
+		h.serverWebhookEvent(addr, typ, id, msg)
+	}
+}
+
+func (h *Handler) handleServerOwnerStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().server_ownerstatus_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_ownerstatus_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	q := r.URL.Query()
+
+	addr, err := netip.ParseAddrPort(q.Get("addr"))
+	if err != nil {
+		h.m().server_ownerstatus_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("addr param is required and must be a game server ip:port"))
+		return
+	}
+
+	srv, status := h.ServerList.GetServerByAddr(addr)
+
+	// the owner can prove they own the server with the server auth token
+	// returned by add_server, or by making the request from the server's ip
+	// (which is required to see verification failures, since the token isn't
+	// returned if verification fails)
+	if tok := q.Get("token"); tok != "" {
+		if srv == nil || subtle.ConstantTimeCompare([]byte(tok), []byte(srv.ServerAuthToken)) != 1 {
+			h.m().server_ownerstatus_requests_total.reject_unauthorized.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("invalid server auth token"))
+			return
+		}
+	} else if addr.Addr() != raddr.Addr() {
+		h.m().server_ownerstatus_requests_total.reject_unauthorized.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("token param is required if not requesting from the server ip"))
+		return
+	}
+
+	heartbeats, events := h.serverHistory.Get(addr, time.Now().UTC())
+	if heartbeats == nil {
+		heartbeats = []time.Time{}
+	}
+	if events == nil {
+		events = []ServerEvent{}
+	}
+
+	obj := map[string]any{
+		"success":    true,
+		"status":     status,
+		"heartbeats": heartbeats,
+		"events":     events,
+	}
+	if srv != nil {
+		obj["server"] = map[string]any{
+			"id":              srv.ID,
+			"name":            srv.Name,
+			"region":          srv.Region,
+			"lastHeartbeat":   srv.LastHeartbeat.UnixMilli(),
+			"map":             srv.Map,
+			"playlist":        srv.Playlist,
+			"playerCount":     srv.PlayerCount,
+			"maxPlayers":      srv.MaxPlayers,
+			"hasPassword":     srv.Password != "",
+			"launcherVersion": srv.LauncherVersion,
+		}
+	}
+	if d, st := h.serverDelist(r, addr); st != ServerDelistNone {
+		x := h.newServerDelistJSON(d, time.Now(), false)
+		obj["delist"] = x
+		if x.AppealID != "" && h.AbuseReportStorage != nil {
+			if rp, err := h.AbuseReportStorage.GetAbuseReport(x.AppealID); err == nil && rp != nil {
+				obj["delist_appeal_status"] = rp.Status
+			}
+		}
+	}
+
+	h.m().server_ownerstatus_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, obj)
+}
@@ -0,0 +1,156 @@
+package api0
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServerHistory(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	a1 := netip.MustParseAddrPort("192.0.2.1:37015")
+	a2 := netip.MustParseAddrPort("192.0.2.2:37015")
+
+	t.Run("Order", func(t *testing.T) {
+		var s serverHistory
+		s.Heartbeat(a1, t0)
+		s.Event(a1, ServerEvent{Time: t0, Type: ServerEventRegistered})
+		s.Heartbeat(a1, t0.Add(time.Second))
+		s.Event(a1, ServerEvent{Time: t0.Add(time.Second), Type: ServerEventVerified})
+
+		hb, ev := s.Get(a1, t0.Add(time.Second))
+		if len(hb) != 2 || !hb[0].Equal(t0.Add(time.Second)) || !hb[1].Equal(t0) {
+			t.Errorf("expected heartbeats newest first, got %v", hb)
+		}
+		if len(ev) != 2 || ev[0].Type != ServerEventVerified || ev[1].Type != ServerEventRegistered {
+			t.Errorf("expected events newest first, got %v", ev)
+		}
+		if hb, ev := s.Get(a2, t0); hb != nil || ev != nil {
+			t.Errorf("expected no history for other address")
+		}
+	})
+
+	t.Run("Bounded", func(t *testing.T) {
+		var s serverHistory
+		for i := 0; i < serverHistoryMaxHeartbeats*2; i++ {
+			s.Heartbeat(a1, t0.Add(time.Duration(i)*time.Second))
+		}
+		for i := 0; i < serverHistoryMaxEvents*2; i++ {
+			s.Event(a1, ServerEvent{Time: t0.Add(time.Duration(i) * time.Second), ID: strconv.Itoa(i)})
+		}
+		hb, ev := s.Get(a1, t0)
+		if len(hb) != serverHistoryMaxHeartbeats || !hb[0].Equal(t0.Add(time.Duration(serverHistoryMaxHeartbeats*2-1)*time.Second)) {
+			t.Errorf("expected newest %d heartbeats to be kept, got %d (newest %v)", serverHistoryMaxHeartbeats, len(hb), hb[0])
+		}
+		if len(ev) != serverHistoryMaxEvents || ev[0].ID != strconv.Itoa(serverHistoryMaxEvents*2-1) {
+			t.Errorf("expected newest %d events to be kept, got %d (newest %v)", serverHistoryMaxEvents, len(ev), ev[0])
+		}
+
+		for i := 0; i < serverHistoryMaxAddrs+10; i++ {
+			s.Heartbeat(netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}), 37015), t0.Add(time.Duration(i)))
+		}
+		if n := s.Len(); n != serverHistoryMaxAddrs {
+			t.Errorf("expected %d addresses, got %d", serverHistoryMaxAddrs, n)
+		}
+		if hb, _ := s.Get(netip.MustParseAddrPort("10.0.0.0:37015"), t0); hb != nil {
+			t.Errorf("expected oldest address to be evicted")
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		var s serverHistory
+		s.Heartbeat(a1, t0)
+		s.Heartbeat(a1, t0.Add(time.Minute))
+		s.Event(a1, ServerEvent{Time: t0, Type: ServerEventRegistered})
+		s.Heartbeat(a2, t0)
+
+		if hb, _ := s.Get(a1, t0.Add(time.Minute+serverHistoryTTL+time.Second)); hb != nil {
+			t.Errorf("expected history to be hidden after ttl")
+		}
+		if n := s.Prune(t0.Add(time.Second)); n != 2 {
+			t.Errorf("expected 2 pruned heartbeats, got %d", n)
+		}
+		if hb, ev := s.Get(a1, t0.Add(time.Minute)); len(hb) != 1 || len(ev) != 0 {
+			t.Errorf("expected old heartbeats and events to be pruned, got %v %v", hb, ev)
+		}
+		if n := s.Len(); n != 1 {
+			t.Errorf("expected addresses without history to be removed, got %d", n)
+		}
+	})
+}
+
+func TestServerOwnerStatus(t *testing.T) {
+	h := &Handler{
+		ServerList: NewServerList(time.Minute, time.Minute*2, 0, ServerListConfig{}),
+	}
+	srv, err := h.ServerList.ServerHybridUpdatePut(nil, &Server{
+		Addr:     netip.MustParseAddrPort("192.0.2.1:37015"),
+		AuthPort: 8081,
+		Name:     "test",
+		Map:      "mp_lobby",
+		Playlist: "ps",
+	}, ServerListLimit{})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h.serverHistory.Heartbeat(srv.Addr, time.Now().UTC())
+	h.serverEvent(srv.Addr, ServerEventRegistered, srv.ID, "%s", "add_server")
+	h.serverEvent(netip.MustParseAddrPort("192.0.2.1:37016"), ServerEventRejected, "", "other server")
+
+	do := func(remote string, q url.Values) (int, map[string]any) {
+		r := httptest.NewRequest(http.MethodGet, "/server/owner_status?"+q.Encode(), nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		h.handleServerOwnerStatus(w, r)
+		var obj map[string]any
+		json.Unmarshal(w.Body.Bytes(), &obj)
+		return w.Code, obj
+	}
+
+	for _, tc := range []struct {
+		name   string
+		remote string
+		q      url.Values
+		status int
+	}{
+		{"NoAddr", "192.0.2.1:1234", url.Values{}, http.StatusBadRequest},
+		{"InvalidAddr", "192.0.2.1:1234", url.Values{"addr": {"192.0.2.1"}}, http.StatusBadRequest},
+		{"ServerIP", "192.0.2.1:1234", url.Values{"addr": {"192.0.2.1:37015"}}, http.StatusOK},
+		{"OtherIP", "198.51.100.1:1234", url.Values{"addr": {"192.0.2.1:37015"}}, http.StatusForbidden},
+		{"Token", "198.51.100.1:1234", url.Values{"addr": {"192.0.2.1:37015"}, "token": {srv.ServerAuthToken}}, http.StatusOK},
+		{"WrongToken", "192.0.2.1:1234", url.Values{"addr": {"192.0.2.1:37015"}, "token": {"asd"}}, http.StatusForbidden},
+		{"OtherServerToken", "198.51.100.1:1234", url.Values{"addr": {"192.0.2.1:37016"}, "token": {srv.ServerAuthToken}}, http.StatusForbidden},
+	} {
+		if st, _ := do(tc.remote, tc.q); st != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.status, st)
+		}
+	}
+
+	if _, obj := do("192.0.2.1:1234", url.Values{"addr": {"192.0.2.1:37015"}}); obj["status"] != string(ServerStatusLobby) {
+		t.Errorf("expected status %q, got %v", ServerStatusLobby, obj["status"])
+	} else if s, _ := obj["server"].(map[string]any); s == nil || s["id"] != srv.ID {
+		t.Errorf("expected server info, got %v", obj["server"])
+	} else if hb, _ := obj["heartbeats"].([]any); len(hb) != 1 {
+		t.Errorf("expected 1 heartbeat, got %v", obj["heartbeats"])
+	} else if ev, _ := obj["events"].([]any); len(ev) != 1 {
+		t.Errorf("expected 1 event, got %v", obj["events"])
+	} else if e, _ := ev[0].(map[string]any); e["type"] != string(ServerEventRegistered) || e["message"] != "add_server" {
+		t.Errorf("incorrect event %v", e)
+	}
+
+	// the history is kept after the server is gone
+	h.ServerList.DeleteServerByID(srv.ID)
+	h.serverEvent(srv.Addr, ServerEventRemoved, srv.ID, "removed by server")
+	if _, obj := do("192.0.2.1:1234", url.Values{"addr": {"192.0.2.1:37015"}}); obj["status"] != string(ServerStatusGone) {
+		t.Errorf("expected status %q, got %v", ServerStatusGone, obj["status"])
+	} else if _, ok := obj["server"]; ok {
+		t.Errorf("expected no server info for removed server")
+	} else if ev, _ := obj["events"].([]any); len(ev) != 2 {
+		t.Errorf("expected 2 events, got %v", obj["events"])
+	}
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/klauspost/compress/gzip"
 	"github.com/r2northstar/atlas/pkg/metricsx"
+	"github.com/r2northstar/atlas/pkg/msgpack"
 	"github.com/r2northstar/atlas/pkg/nstypes"
 )
 
@@ -38,6 +39,7 @@ type ServerList struct {
 	servers1 map[netip.AddrPort]*Server // game addr
 	servers2 map[string]*Server         // server id
 	servers3 map[netip.AddrPort]*Server // auth addr
+	pins     atomic.Pointer[serverPins] // servers to list first, only modified while holding mu
 
 	// /client/servers json caching
 	csNext     atomic.Pointer[time.Time] // latest next update time for the /client/servers response
@@ -46,6 +48,10 @@ type ServerList struct {
 	csUpdateCv *sync.Cond                // allows other goroutines to wait for that update to complete
 	csBytes    atomic.Pointer[[]byte]    // contents of buffer must not be modified; only swapped
 	csEst      atomic.Uint64             // estimated per-server json size
+	csMsgpack  atomic.Pointer[[]byte]    // generated with csBytes; contents of buffer must not be modified; only swapped
+
+	// uncached /client/servers responses
+	csPool sync.Pool // *csBuffer pool
 
 	// /client/servers gzipped json
 	csgzPool     sync.Pool              // gzip writer pool
@@ -83,11 +89,18 @@ type Server struct {
 
 	LauncherVersion string // for metrics
 
+	IPFlags IPReputation // reputation flags for Addr at registration
+
 	Name        string
 	Region      string
 	Description string
 	Password    string // blank for none
 
+	Visibility        ServerVisibility
+	AllowUIDs         []uint64 // for ServerVisibilityAllowlist
+	AllowDiscordGuild string   // for ServerVisibilityAllowlist, blank for none
+	AllowDiscordRole  string   // for ServerVisibilityAllowlist, only valid if AllowDiscordGuild is set
+
 	Latitude  float64
 	Longitude float64
 
@@ -101,7 +114,17 @@ type Server struct {
 
 	ServerAuthToken string // used for authenticating the masterserver to the gameserver authserver
 
+	SigningKey      string // for signing gameserver requests to the masterserver, blank if not enabled
+	SigningRequired bool   // if true, unsigned requests are rejected
+
+	Attestation string // signed attestation for trusted servers, blank if not trusted
+
 	ModInfo []ServerModInfo
+
+	// player count samples for the trend hint (see samplePlayers)
+	playersPrev    int
+	playersCur     int
+	playersSampled time.Time
 }
 
 type ServerModInfo struct {
@@ -123,6 +146,9 @@ func (s Server) clone() Server {
 	m := make([]ServerModInfo, len(s.ModInfo))
 	copy(m, s.ModInfo)
 	s.ModInfo = m
+	if s.AllowUIDs != nil {
+		s.AllowUIDs = append([]uint64(nil), s.AllowUIDs...)
+	}
 	return s
 }
 
@@ -260,34 +286,283 @@ func (s *ServerList) csGetJSON() []byte {
 	defer s.csUpdateNextUpdateTime()
 
 	// get the servers in the original order
-	ss := make([]*Server, 0, len(s.servers1)) // up to the current size of the servers map
+	ss := s.csServers(nil, t, false)
+
+	// generate the json and cache it
+	//
+	// note: we write it manually to avoid copying the entire list and to avoid the perf overhead of reflection
+	buf, est := csJSON(ss, int(s.csEst.Load()), s.cfg, s.pins.Load(), s.csHinter(t))
+	mbuf := csMsgpack(ss, len(buf), s.cfg, s.csHinter(t))
+	s.csMsgpack.Store(&mbuf)
+	s.csBytes.Store(&buf)
+	s.csEst.Store(uint64(est))
+
+	return buf
+}
+
+// csServers gets the servers to include in the /client/servers response in
+// the original order, with pinned servers first, replacing the contents of dst
+// (which may be nil). Non-public servers are only included if private is true.
+// The read lock must be held.
+func (s *ServerList) csServers(dst []*Server, t time.Time, private bool) []*Server {
+	ss := dst[:0]
+	if cap(ss) < len(s.servers1) {
+		ss = make([]*Server, 0, len(s.servers1)) // up to the current size of the servers map
+	}
 	if s.servers1 != nil {
 		for _, srv := range s.servers1 {
 			if s.serverState(srv, t) == serverListStateAlive {
 				if srv.Map == "mp_lobby" && srv.Playlist != "private_match" {
 					continue // don't include non-private_match servers on lobby
 				}
+				if srv.Visibility != ServerVisibilityPublic && !private {
+					continue
+				}
 				ss = append(ss, srv)
 			}
 		}
 	}
+	pins := s.pins.Load()
 	sort.Slice(ss, func(i, j int) bool {
+		if pi, pj := pins.match(ss[i]), pins.match(ss[j]); pi != pj {
+			return pi
+		}
 		return ss[i].Order < ss[j].Order
 	})
+	return ss
+}
 
-	// generate the json and cache it
-	//
-	// note: we write it manually to avoid copying the entire list and to avoid the perf overhead of reflection
-	buf, est := csJSON(ss, int(s.csEst.Load()), s.cfg)
-	s.csBytes.Store(&buf)
-	s.csEst.Store(uint64(est))
+// serverPins matches servers to list first.
+type serverPins struct {
+	key   string
+	ids   map[string]struct{}
+	names map[string]struct{}
+}
 
-	return buf
+// match checks whether srv is pinned. It is safe to call on a nil serverPins.
+func (p *serverPins) match(srv *Server) bool {
+	if p == nil {
+		return false
+	}
+	if _, ok := p.ids[srv.ID]; ok {
+		return true
+	}
+	_, ok := p.names[srv.Name]
+	return ok
+}
+
+// SetPins sets the servers to list first (and mark as featured) by ID or
+// exact name, replacing any existing pins.
+func (s *ServerList) SetPins(ids, names []string) {
+	p := &serverPins{
+		ids:   make(map[string]struct{}, len(ids)),
+		names: make(map[string]struct{}, len(names)),
+	}
+	for _, x := range ids {
+		p.ids[x] = struct{}{}
+	}
+	for _, x := range names {
+		p.names[x] = struct{}{}
+	}
+	ks := make([]string, 0, len(p.ids)+len(p.names))
+	for x := range p.ids {
+		ks = append(ks, "i"+x)
+	}
+	for x := range p.names {
+		ks = append(ks, "n"+x)
+	}
+	sort.Strings(ks)
+	p.key = strings.Join(ks, "\x00")
+	if p.key == "" {
+		p = nil
+	}
+
+	// don't invalidate the cached list if nothing changed
+	if cur := s.pins.Load(); (cur == nil) == (p == nil) && (p == nil || cur.key == p.key) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pins.Store(p)
+	s.csForceUpdate()
+}
+
+// ServerListRank is an alternate order for the server list.
+type ServerListRank string
+
+const (
+	ServerListRankDefault    ServerListRank = ""           // registration order
+	ServerListRankPopulation ServerListRank = "population" // most players first
+	ServerListRankFill       ServerListRank = "fill"       // the fullest servers with free slots first, then full ones
+)
+
+// Valid checks if r is a known rank.
+func (r ServerListRank) Valid() bool {
+	switch r {
+	case ServerListRankDefault, ServerListRankPopulation, ServerListRankFill:
+		return true
+	}
+	return false
+}
+
+// sort reorders ss (which must already be in the default order) according to
+// r, keeping pinned servers first.
+func (r ServerListRank) sort(ss []*Server, pins *serverPins) {
+	var less func(a, b *Server) bool
+	switch r {
+	case ServerListRankPopulation:
+		less = func(a, b *Server) bool {
+			return a.PlayerCount > b.PlayerCount
+		}
+	case ServerListRankFill:
+		less = func(a, b *Server) bool {
+			if af, bf := a.PlayerCount >= a.MaxPlayers, b.PlayerCount >= b.MaxPlayers; af != bf {
+				return bf
+			}
+			return a.PlayerCount*b.MaxPlayers > b.PlayerCount*a.MaxPlayers
+		}
+	default:
+		return
+	}
+	sort.SliceStable(ss, func(i, j int) bool {
+		if pi, pj := pins.match(ss[i]), pins.match(ss[j]); pi != pj {
+			return pi
+		}
+		return less(ss[i], ss[j])
+	})
+}
+
+// csPosition gets the 1-based position of the server with the specified ID in
+// the public server list when ordered by rank, or 0 if it isn't listed.
+func (s *ServerList) csPosition(id string, rank ServerListRank) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ss := s.csServers(nil, s.now(), false)
+	rank.sort(ss, s.pins.Load())
+	for i, srv := range ss {
+		if srv.ID == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// csBuffer contains reusable buffers for generating uncached /client/servers
+// responses, which would otherwise need to allocate a copy of every server for
+// every request.
+type csBuffer struct {
+	buf  []byte
+	ss   []*Server
+	cs   []Server
+	mods []ServerModInfo
+	uids []uint64
+}
+
+// csGetBuffer gets a buffer for csGetFiltered from the pool. It must be
+// returned with csPutBuffer once the response is no longer used.
+func (s *ServerList) csGetBuffer() *csBuffer {
+	if cb, ok := s.csPool.Get().(*csBuffer); ok {
+		return cb
+	}
+	return new(csBuffer)
+}
+
+// csPutBuffer returns cb to the pool.
+func (s *ServerList) csPutBuffer(cb *csBuffer) {
+	for i := range cb.ss {
+		cb.ss[i] = nil
+	}
+	for i := range cb.cs {
+		cb.cs[i] = Server{} // don't keep server info around
+	}
+	s.csPool.Put(cb)
+}
+
+// csGetFiltered is like csGetJSON (or csGetMsgpack if mp is true), but ordered
+// by rank, and if fn is not nil, only includes servers for which fn returns
+// true. Non-public servers are passed to fn too. The response is not cached,
+// and fn is called without holding any locks. The returned buffer is owned by
+// cb.
+func (s *ServerList) csGetFiltered(cb *csBuffer, mp bool, rank ServerListRank, fn func(*Server) bool) []byte {
+	t := s.now()
+	ss, pins := s.csCopy(cb, t, fn != nil)
+
+	fss := ss[:0]
+	for _, srv := range ss {
+		if fn == nil || fn(srv) {
+			fss = append(fss, srv)
+		}
+	}
+	rank.sort(fss, pins)
+	if mp {
+		cb.buf = csAppendMsgpack(cb.buf[:0], fss, s.cfg, s.csHinter(t))
+	} else {
+		cb.buf, _ = csAppendJSON(cb.buf[:0], fss, int(s.csEst.Load()), s.cfg, pins, s.csHinter(t))
+	}
+	return cb.buf
+}
+
+// csCopy copies the listed servers at t (see csServers) into cb, returning
+// them along with the current pins.
+func (s *ServerList) csCopy(cb *csBuffer, t time.Time, private bool) ([]*Server, *serverPins) {
+	s.mu.RLock()
+	ss := s.csServers(cb.ss, t, private)
+	pins := s.pins.Load()
+
+	// copy the servers into the buffer (like Server.clone, but with the
+	// slices allocated all at once)
+	var nmods, nuids int
+	for _, srv := range ss {
+		nmods += len(srv.ModInfo)
+		nuids += len(srv.AllowUIDs)
+	}
+	if cap(cb.cs) < len(ss) {
+		cb.cs = make([]Server, len(ss))
+	}
+	if cap(cb.mods) < nmods {
+		cb.mods = make([]ServerModInfo, 0, nmods)
+	}
+	if cap(cb.uids) < nuids {
+		cb.uids = make([]uint64, 0, nuids)
+	}
+	cb.cs, cb.mods, cb.uids = cb.cs[:len(ss)], cb.mods[:0], cb.uids[:0]
+	for i, srv := range ss {
+		c := &cb.cs[i]
+		*c = *srv
+		n := len(cb.mods)
+		cb.mods = append(cb.mods, srv.ModInfo...)
+		c.ModInfo = cb.mods[n:len(cb.mods):len(cb.mods)]
+		if srv.AllowUIDs != nil {
+			n := len(cb.uids)
+			cb.uids = append(cb.uids, srv.AllowUIDs...)
+			c.AllowUIDs = cb.uids[n:len(cb.uids):len(cb.uids)]
+		}
+		ss[i] = c
+	}
+	s.mu.RUnlock()
+	cb.ss = ss
+	return ss, pins
+}
+
+// csHinter returns a function which calls csHints at t.
+func (s *ServerList) csHinter(t time.Time) func(*Server) serverHints {
+	return func(srv *Server) serverHints {
+		return s.csHints(srv, t)
+	}
+}
+
+func csJSON(ss []*Server, est int, cfg ServerListConfig, pins *serverPins, hints func(*Server) serverHints) ([]byte, int) {
+	return csAppendJSON(nil, ss, est, cfg, pins, hints)
 }
 
-func csJSON(ss []*Server, est int, cfg ServerListConfig) ([]byte, int) {
+// csAppendJSON appends the JSON server list to b, growing it if needed. It
+// returns the new estimated per-server size.
+func csAppendJSON(b []byte, ss []*Server, est int, cfg ServerListConfig, pins *serverPins, hints func(*Server) serverHints) ([]byte, int) {
 	if len(ss) == 0 {
-		return []byte(`[]`), est
+		return append(b, `[]`...), est
 	}
 
 	const (
@@ -306,7 +581,12 @@ func csJSON(ss []*Server, est int, cfg ServerListConfig) ([]byte, int) {
 
 	// note: we use a custom buffer so we can control allocations
 
-	b := make([]byte, 0, len(ss)*est+2)
+	if n := len(ss)*est + 2; cap(b)-len(b) < n {
+		bn := make([]byte, len(b), len(b)+n)
+		copy(bn, b)
+		b = bn
+	}
+	start := len(b)
 	b = append(b, '[')
 	for i, srv := range ss {
 		if r := len(ss) - i - 1; r >= 0 && cap(b)-len(b) < est*r {
@@ -322,13 +602,7 @@ func csJSON(ss []*Server, est int, cfg ServerListConfig) ([]byte, int) {
 		b = append(b, `,"id":"`...)
 		b = append(b, srv.ID...)
 		b = append(b, `","name":`...)
-		name := srv.Name
-		if cfg.AllowUwuify {
-			if _, m, d := time.Now().UTC().Date(); m == time.April && d == 1 {
-				name = uwuify(name)
-			}
-		}
-		b = appendJSONString(b, name)
+		b = appendJSONString(b, csName(srv, cfg))
 		if srv.Region != "" && srv.Password == "" {
 			b = append(b, `,"region":`...)
 			b = appendJSONString(b, srv.Region)
@@ -348,6 +622,32 @@ func csJSON(ss []*Server, est int, cfg ServerListConfig) ([]byte, int) {
 		} else {
 			b = append(b, `,"hasPassword":false`...)
 		}
+		if srv.Attestation != "" {
+			b = append(b, `,"trusted":true,"attestation":`...)
+			b = appendJSONString(b, srv.Attestation)
+		}
+		if pins.match(srv) {
+			b = append(b, `,"featured":true`...)
+		}
+		sh := hints(srv)
+		b = append(b, `,"hints":{`...)
+		if sh.PingRegion != "" {
+			b = append(b, `"pingRegion":"`...)
+			b = append(b, sh.PingRegion...)
+			b = append(b, `",`...)
+		}
+		b = append(b, `"freshness":`...)
+		b = strconv.AppendInt(b, int64(sh.Freshness), 10)
+		if sh.Verified {
+			b = append(b, `,"verified":true`...)
+		} else {
+			b = append(b, `,"verified":false`...)
+		}
+		b = append(b, `,"trend":`...)
+		b = strconv.AppendInt(b, int64(sh.Trend), 10)
+		b = append(b, `,"score":`...)
+		b = strconv.AppendInt(b, int64(sh.Score), 10)
+		b = append(b, '}')
 		b = append(b, `,"modInfo":{"Mods":[`...)
 		for j, mi := range srv.ModInfo {
 			if j != 0 {
@@ -367,7 +667,7 @@ func csJSON(ss []*Server, est int, cfg ServerListConfig) ([]byte, int) {
 	}
 	b = append(b, ']')
 
-	est = (len(b) - 2 + (len(ss) - 1)) / len(ss) // note: round up
+	est = (len(b) - start - 2 + (len(ss) - 1)) / len(ss) // note: round up
 	switch {
 	case est == 0:
 		est = estInit
@@ -379,6 +679,88 @@ func csJSON(ss []*Server, est int, cfg ServerListConfig) ([]byte, int) {
 	return b, est
 }
 
+// csGetMsgpack is like csGetJSON, but returns the MessagePack encoding of the
+// server list.
+//
+// To keep it compact, each server is encoded as an array rather than a map
+// (the first element is the array length for forwards-compatibility):
+//
+//	[
+//	  n, lastHeartbeat_ms, id, name, region|nil, description, playerCount,
+//	  maxPlayers, map, playlist, hasPassword, attestation|nil,
+//	  [[modName, modVersion, requiredOnClient], ...],
+//	  pingRegion|nil, freshness, verified, trend, score
+//	]
+//
+// See serverHints for the meaning of the hint fields.
+//
+// New fields will only ever be appended.
+func (s *ServerList) csGetMsgpack() []byte {
+	s.csGetJSON() // update if needed
+	return *s.csMsgpack.Load()
+}
+
+func csMsgpack(ss []*Server, est int, cfg ServerListConfig, hints func(*Server) serverHints) []byte {
+	return csAppendMsgpack(make([]byte, 0, est), ss, cfg, hints)
+}
+
+// csAppendMsgpack appends the MessagePack server list to b.
+func csAppendMsgpack(b []byte, ss []*Server, cfg ServerListConfig, hints func(*Server) serverHints) []byte {
+	const n = 18
+	b = msgpack.AppendArrayHeader(b, len(ss))
+	for _, srv := range ss {
+		b = msgpack.AppendArrayHeader(b, n)
+		b = msgpack.AppendInt(b, n)
+		b = msgpack.AppendInt(b, srv.LastHeartbeat.UnixMilli())
+		b = msgpack.AppendString(b, srv.ID)
+		b = msgpack.AppendString(b, csName(srv, cfg))
+		if srv.Region != "" && srv.Password == "" {
+			b = msgpack.AppendString(b, srv.Region)
+		} else {
+			b = msgpack.AppendNil(b)
+		}
+		b = msgpack.AppendString(b, srv.Description)
+		b = msgpack.AppendInt(b, int64(srv.PlayerCount))
+		b = msgpack.AppendInt(b, int64(srv.MaxPlayers))
+		b = msgpack.AppendString(b, srv.Map)
+		b = msgpack.AppendString(b, srv.Playlist)
+		b = msgpack.AppendBool(b, srv.Password != "")
+		if srv.Attestation != "" {
+			b = msgpack.AppendString(b, srv.Attestation)
+		} else {
+			b = msgpack.AppendNil(b)
+		}
+		b = msgpack.AppendArrayHeader(b, len(srv.ModInfo))
+		for _, mi := range srv.ModInfo {
+			b = msgpack.AppendArrayHeader(b, 3)
+			b = msgpack.AppendString(b, mi.Name)
+			b = msgpack.AppendString(b, mi.Version)
+			b = msgpack.AppendBool(b, mi.RequiredOnClient)
+		}
+		sh := hints(srv)
+		if sh.PingRegion != "" {
+			b = msgpack.AppendString(b, sh.PingRegion)
+		} else {
+			b = msgpack.AppendNil(b)
+		}
+		b = msgpack.AppendInt(b, int64(sh.Freshness))
+		b = msgpack.AppendBool(b, sh.Verified)
+		b = msgpack.AppendInt(b, int64(sh.Trend))
+		b = msgpack.AppendInt(b, int64(sh.Score))
+	}
+	return b
+}
+
+// csName gets the server name to show in the server list.
+func csName(srv *Server, cfg ServerListConfig) string {
+	if cfg.AllowUwuify {
+		if _, m, d := time.Now().UTC().Date(); m == time.April && d == 1 {
+			return uwuify(srv.Name)
+		}
+	}
+	return srv.Name
+}
+
 // csGetJSONGzip is like csGetJSON, but returns it gzipped with true, or false
 // if an error occurs.
 func (s *ServerList) csGetJSONGzip() ([]byte, bool) {
@@ -724,6 +1106,60 @@ func (s *ServerList) WritePrometheusGeo(w io.Writer) {
 	ctr.WritePrometheus(w)
 }
 
+// ServerListStats contains the number of servers in the server list by state
+// and the sizes of the cached responses, for debugging.
+type ServerListStats struct {
+	Pending int `json:"pending"`
+	Alive   int `json:"alive"`
+	Ghost   int `json:"ghost"`
+	Gone    int `json:"gone"` // not reaped yet
+
+	IndexedByAddr     int `json:"indexed_by_addr"`
+	IndexedByID       int `json:"indexed_by_id"`
+	IndexedByAuthAddr int `json:"indexed_by_auth_addr"`
+
+	CachedJSONBytes    int `json:"cached_json_bytes"`
+	CachedGzipBytes    int `json:"cached_gzip_bytes"`
+	CachedMsgpackBytes int `json:"cached_msgpack_bytes"`
+}
+
+// Stats gets information about the size of the server list.
+func (s *ServerList) Stats() ServerListStats {
+	t := s.now()
+
+	var st ServerListStats
+	if b := s.csBytes.Load(); b != nil {
+		st.CachedJSONBytes = len(*b)
+	}
+	if b := s.csgzBytes.Load(); b != nil {
+		st.CachedGzipBytes = len(*b)
+	}
+	if b := s.csMsgpack.Load(); b != nil {
+		st.CachedMsgpackBytes = len(*b)
+	}
+
+	// take a read lock on the server list
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, srv := range s.servers1 {
+		switch s.serverState(srv, t) {
+		case serverListStatePending:
+			st.Pending++
+		case serverListStateAlive:
+			st.Alive++
+		case serverListStateGhost:
+			st.Ghost++
+		case serverListStateGone:
+			st.Gone++
+		}
+	}
+	st.IndexedByAddr = len(s.servers1)
+	st.IndexedByID = len(s.servers2)
+	st.IndexedByAuthAddr = len(s.servers3)
+	return st
+}
+
 // GetLiveServers loops over live (i.e., not dead/ghost) servers until fn
 // returns false. The order of the servers is non-deterministic.
 func (s *ServerList) GetLiveServers(fn func(*Server) bool) {
@@ -763,6 +1199,51 @@ func (s *ServerList) GetServerByID(id string) *Server {
 	return nil
 }
 
+// ServerStatus describes whether a server is shown on the server list.
+type ServerStatus string
+
+const (
+	ServerStatusListed  ServerStatus = "listed"               // alive and shown on the server list
+	ServerStatusLobby   ServerStatus = "hidden_lobby"         // alive, but hidden since it's in the lobby outside of a private match
+	ServerStatusPending ServerStatus = "pending_verification" // registered, but not verified yet
+	ServerStatusDead    ServerStatus = "dead"                 // no recent heartbeats, but can still be revived by one
+	ServerStatusGone    ServerStatus = "gone"                 // not registered or expired
+)
+
+// GetServerByAddr returns a deep copy of the server with the game address
+// addr, including dead servers, along with its status. If it is gone, nil is
+// returned.
+func (s *ServerList) GetServerByAddr(addr netip.AddrPort) (*Server, ServerStatus) {
+	t := s.now()
+
+	// take a read lock on the server list
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.servers1 != nil {
+		if srv, ok := s.servers1[addr]; ok {
+			var st ServerStatus
+			switch s.serverState(srv, t) {
+			case serverListStatePending:
+				st = ServerStatusPending
+			case serverListStateAlive:
+				if srv.Map == "mp_lobby" && srv.Playlist != "private_match" {
+					st = ServerStatusLobby
+				} else {
+					st = ServerStatusListed
+				}
+			case serverListStateGhost:
+				st = ServerStatusDead
+			default:
+				return nil, ServerStatusGone
+			}
+			c := srv.clone()
+			return &c, st
+		}
+	}
+	return nil, ServerStatusGone
+}
+
 // DeleteServerByID deletes a server by its ID, returning true if a live server
 // was deleted.
 func (s *ServerList) DeleteServerByID(id string) bool {
@@ -851,8 +1332,12 @@ func (s *ServerList) ServerHybridUpdatePut(u *ServerUpdate, c *Server, l ServerL
 				// do the update
 				var changed bool
 				if u.Heartbeat {
+					// note: we don't call csUpdateNextUpdateTime here since
+					// it iterates over every server, and a heartbeat only
+					// moves the server's expiry later, so the existing next
+					// update time will be early at worst (which just causes
+					// an extra update, which will then recalculate it)
 					esrv.LastHeartbeat, changed = t, true
-					s.csUpdateNextUpdateTime()
 				}
 				if u.Name != nil {
 					esrv.Name, changed = *u.Name, true
@@ -881,6 +1366,7 @@ func (s *ServerList) ServerHybridUpdatePut(u *ServerUpdate, c *Server, l ServerL
 				if u.MaxPlayers != nil {
 					esrv.MaxPlayers, changed = *u.MaxPlayers, true
 				}
+				esrv.samplePlayers(t)
 				if changed {
 					s.csForceUpdate()
 				}
@@ -1003,6 +1489,8 @@ func (s *ServerList) ServerHybridUpdatePut(u *ServerUpdate, c *Server, l ServerL
 
 		// set the heartbeat time to the current time
 		nsrv.LastHeartbeat = t
+		nsrv.playersSampled = time.Time{}
+		nsrv.samplePlayers(t)
 
 		// set the verification deadline
 		if s.verifyTime != 0 {
@@ -1047,6 +1535,23 @@ func (s *ServerList) VerifyServer(id string) bool {
 	return false
 }
 
+// SetServerAttestation sets the attestation for the server with the provided
+// id. If it does not exist, false is returned.
+func (s *ServerList) SetServerAttestation(id, attestation string) bool {
+	// take a write lock on the server list
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if srv, exists := s.servers2[id]; exists {
+		if srv.Attestation != attestation {
+			srv.Attestation = attestation
+			s.csForceUpdate()
+		}
+		return true
+	}
+	return false
+}
+
 // ReapServers deletes dead servers from memory.
 func (s *ServerList) ReapServers() {
 	t := s.now()
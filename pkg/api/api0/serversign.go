@@ -0,0 +1,126 @@
+package api0
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0/api0gameserver"
+	"github.com/rs/zerolog/hlog"
+)
+
+// Game servers can opt into request signing by registering with the signing
+// param set to optional or required, in which case add_server also returns a
+// signingKey. Heartbeats, pdata writes, and match results can then be signed
+// with api0gameserver.SignRequest, which protects them against tampering by
+// proxies and against leaked server IDs (e.g., in logs) being used from the
+// same IP. If signing is required, unsigned requests for the server are
+// rejected, so a proxy can't strip the signature. Signed servers can also send
+// heartbeats over UDP (see UDPHeartbeat), and receive a signed
+// api0gameserver.Preauth with each player auth request so they can verify the
+// connection locally.
+const (
+	// serverSignatureWindow is the maximum clock skew for signed requests.
+	serverSignatureWindow = time.Minute * 5
+
+	// serverSignatureMaxBody is the maximum body size of signed requests.
+	serverSignatureMaxBody = 4 << 20
+
+	// serverSignatureMaxNonces is the maximum number of signatures
+	// remembered for replay protection.
+	serverSignatureMaxNonces = 100000
+
+	// serverPreauthExpiry is how long player pre-authorizations are valid.
+	serverPreauthExpiry = time.Second * 30
+)
+
+// parseServerSigning parses the signing param for add_server, generating a
+// signing key for s if requested.
+func parseServerSigning(v string, s *Server) (bool, error) {
+	switch v {
+	case "":
+		return true, nil
+	case "optional", "required":
+		key, err := cryptoRandHex(32)
+		if err != nil {
+			return false, err
+		}
+		s.SigningKey = key
+		s.SigningRequired = v == "required"
+		return true, nil
+	}
+	return false, nil
+}
+
+// checkServerSignature verifies the signature of a request from srv (which may
+// be nil if the server isn't known, in which case the check is skipped). If it
+// is invalid, or if it's missing and srv requires signing, it writes an error
+// response and returns false. The body is buffered so it can still be read by
+// the caller.
+func (h *Handler) checkServerSignature(w http.ResponseWriter, r *http.Request, srv *Server, endpoint string) bool {
+	if srv == nil {
+		return true
+	}
+	sig := r.Header.Get(api0gameserver.SignatureHeader)
+	if sig == "" {
+		if srv.SigningRequired {
+			h.m().server_signature_checks_total.reject_missing(endpoint).Inc()
+			respFail(w, r, http.StatusUnauthorized, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("request signature is required"))
+			return false
+		}
+		if srv.SigningKey != "" {
+			h.m().server_signature_checks_total.unsigned(endpoint).Inc()
+		}
+		return true
+	}
+	if srv.SigningKey == "" {
+		h.m().server_signature_checks_total.reject_invalid(endpoint).Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("request signing was not enabled at registration"))
+		return false
+	}
+
+	now := time.Now()
+	ts, err := strconv.ParseInt(r.Header.Get(api0gameserver.SignatureTimestampHeader), 10, 64)
+	if d := now.Sub(time.Unix(ts, 0)); err != nil || d > serverSignatureWindow || d < -serverSignatureWindow {
+		h.m().server_signature_checks_total.reject_expired(endpoint).Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("request signature timestamp is missing or outside the allowed clock skew"))
+		return false
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, serverSignatureMaxBody+1))
+		if err != nil {
+			h.m().server_signature_checks_total.reject_invalid(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("failed to read request body"))
+			return false
+		}
+		if len(body) > serverSignatureMaxBody {
+			h.m().server_signature_checks_total.reject_invalid(endpoint).Inc()
+			respFail(w, r, http.StatusRequestEntityTooLarge, ErrorCode_BAD_REQUEST.MessageObjf("signed request body is too large"))
+			return false
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	exp := api0gameserver.RequestSignature(srv.SigningKey, r.Method, r.URL.Path, r.URL.RawQuery, ts, body)
+	if !hmac.Equal([]byte(sig), []byte(exp)) {
+		hlog.FromRequest(r).Warn().
+			Str("server", srv.ID).
+			Str("endpoint", endpoint).
+			Msgf("invalid game server request signature")
+		h.m().server_signature_checks_total.reject_invalid(endpoint).Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("invalid request signature"))
+		return false
+	}
+	if !h.serverSignatureNonces.Consume(sig, now, serverSignatureWindow*2, serverSignatureMaxNonces) {
+		h.m().server_signature_checks_total.reject_replayed(endpoint).Inc()
+		respFail(w, r, http.StatusUnauthorized, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("request signature has already been used"))
+		return false
+	}
+	h.m().server_signature_checks_total.success(endpoint).Inc()
+	return true
+}
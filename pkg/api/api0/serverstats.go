@@ -0,0 +1,269 @@
+package api0
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// ServerStatsInterval is the interval at which RecordServerStats should be
+// called.
+const ServerStatsInterval = time.Minute
+
+// Server stats resolutions. Fine buckets are kept for serverStatsFineRetention
+// (or RetentionConfig.ServerStats), and coarse ones are kept for
+// Handler.ServerStatsRetention.
+const (
+	serverStatsFine          = time.Minute * 5
+	serverStatsCoarse        = time.Hour
+	serverStatsFineRetention = time.Hour * 48
+)
+
+// serverStatsRecorder contains the in-progress server stats buckets.
+type serverStatsRecorder struct {
+	mu        sync.Mutex
+	cur       map[serverStatsKey]*ServerStatsBucket
+	lastPrune time.Time
+}
+
+type serverStatsKey struct {
+	addr       netip.AddrPort
+	resolution time.Duration
+}
+
+// RecordServerStats samples the live servers at t, and saves the in-progress
+// buckets to ServerStatsStorage, deleting expired ones. It should be called
+// every ServerStatsInterval. If ServerStatsStorage is nil, it does nothing.
+func (h *Handler) RecordServerStats(t time.Time) error {
+	if h.ServerStatsStorage == nil {
+		return nil
+	}
+
+	c := &h.serverStats
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cur == nil {
+		c.cur = make(map[serverStatsKey]*ServerStatsBucket)
+	}
+
+	var ss []*Server
+	h.ServerList.GetLiveServers(func(s *Server) bool {
+		ss = append(ss, s)
+		return true
+	})
+
+	var errs []error
+	bs := make([]ServerStatsBucket, 0, (len(ss)+1)*2)
+	record := func(addr netip.AddrPort, name string, players, maxPlayers int, mapName string) {
+		for _, res := range []time.Duration{serverStatsFine, serverStatsCoarse} {
+			k := serverStatsKey{addr, res}
+			start := t.Truncate(res)
+
+			b := c.cur[k]
+			if b == nil || !b.Start.Equal(start) {
+				b = nil
+
+				// continue the bucket if it was already partially recorded
+				// (e.g., before a restart)
+				if x, err := h.ServerStatsStorage.GetServerStats(addr, res, start); err != nil {
+					errs = append(errs, err)
+				} else if len(x) != 0 && x[0].Start.Equal(start) {
+					b = &x[0]
+				}
+				if b == nil {
+					b = &ServerStatsBucket{
+						Addr:       addr,
+						Start:      start,
+						Resolution: res,
+					}
+				}
+				if b.Maps == nil {
+					b.Maps = map[string]int{}
+				}
+				c.cur[k] = b
+			}
+
+			b.Name = name
+			b.Samples++
+			b.PlayerSum += players
+			if players > b.PlayerMax {
+				b.PlayerMax = players
+			}
+			b.MaxPlayers = maxPlayers
+			if mapName != "" {
+				b.Maps[mapName]++
+			}
+			bs = append(bs, *b)
+		}
+	}
+
+	var players, maxPlayers int
+	for _, s := range ss {
+		record(s.Addr, s.Name, s.PlayerCount, s.MaxPlayers, s.Map)
+		players += s.PlayerCount
+		maxPlayers += s.MaxPlayers
+	}
+	record(netip.AddrPort{}, "", players, maxPlayers, "")
+
+	// forget buckets which aren't in-progress anymore
+	for k, b := range c.cur {
+		if !b.Start.Equal(t.Truncate(k.resolution)) {
+			delete(c.cur, k)
+		}
+	}
+
+	if len(bs) != 0 {
+		if err := h.ServerStatsStorage.SaveServerStats(bs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if t.Sub(c.lastPrune) >= time.Hour {
+		retention := h.ServerStatsRetention
+		if retention <= 0 {
+			retention = time.Hour * 24 * 90
+		}
+		if n, err := h.ServerStatsStorage.DeleteServerStats(serverStatsFine, t.Add(-h.serverStatsFineRetention())); err != nil {
+			errs = append(errs, err)
+		} else {
+			h.m().retention_deleted_total.server_stats.Add(n)
+		}
+		if n, err := h.ServerStatsStorage.DeleteServerStats(serverStatsCoarse, t.Add(-retention)); err != nil {
+			errs = append(errs, err)
+		} else {
+			h.m().retention_deleted_total.server_stats.Add(n)
+		}
+		c.lastPrune = t
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return fmt.Errorf("%w (and %d more errors)", errs[0], len(errs)-1)
+	}
+}
+
+func (h *Handler) handleServerStatsHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().server_statshistory_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if h.ServerStatsStorage == nil {
+		h.m().server_statshistory_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("server stats are not enabled"))
+		return
+	}
+
+	var q struct {
+		ID         string         `param:"id"`
+		Addr       netip.AddrPort `param:"addr"`
+		Resolution string         `param:"resolution" validate:"oneof=hour|1h|5m"`
+		Since      int64          `param:"since"`
+	}
+	if err := decodeParams(r, &q); err != nil {
+		h.m().server_statshistory_requests_total.reject_bad_request.Inc()
+		respError(w, r, err)
+		return
+	}
+
+	// since server ips aren't public, servers can also be looked up by their
+	// current id
+	var addr netip.AddrPort
+	var showAddr bool
+	if v := q.ID; v != "" {
+		srv := h.ServerList.GetServerByID(v)
+		if srv == nil {
+			h.m().server_statshistory_requests_total.reject_server_not_found.Inc()
+			respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("no such game server"))
+			return
+		}
+		addr = srv.Addr
+	} else if q.Addr.IsValid() {
+		addr, showAddr = q.Addr, true
+	} else {
+		h.m().server_statshistory_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id or addr (game server ip:port) param is required"))
+		return
+	}
+
+	var res, retention time.Duration
+	switch q.Resolution {
+	case "", "hour", "1h":
+		res, retention = serverStatsCoarse, h.ServerStatsRetention
+		if retention <= 0 {
+			retention = time.Hour * 24 * 90
+		}
+	case "5m":
+		res, retention = serverStatsFine, h.serverStatsFineRetention()
+	}
+
+	since := time.Now().Add(-retention)
+	if q.Since != 0 {
+		if x := time.Unix(q.Since, 0); x.After(since) {
+			since = x
+		}
+	}
+
+	bs, err := h.ServerStatsStorage.GetServerStats(addr, res, since.Truncate(res))
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Str("addr", addr.String()).
+			Msgf("failed to read server stats from storage")
+		h.m().server_statshistory_requests_total.fail_storage_error_stats.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	points := make([]map[string]any, 0, len(bs))
+	for _, b := range bs {
+		var avg float64
+		if b.Samples != 0 {
+			avg = float64(b.PlayerSum) / float64(b.Samples)
+		}
+		maps := b.Maps
+		if maps == nil {
+			maps = map[string]int{}
+		}
+		points = append(points, map[string]any{
+			"time":           b.Start.Unix(),
+			"name":           b.Name,
+			"uptime_seconds": int64(time.Duration(b.Samples) * ServerStatsInterval / time.Second),
+			"players_avg":    avg,
+			"players_max":    b.PlayerMax,
+			"max_players":    b.MaxPlayers,
+			"maps":           maps,
+		})
+	}
+
+	obj := map[string]any{
+		"success":    true,
+		"resolution": int64(res / time.Second),
+		"points":     points,
+	}
+	if showAddr {
+		obj["addr"] = addr.String()
+	}
+
+	h.m().server_statshistory_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, obj)
+}
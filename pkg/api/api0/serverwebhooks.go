@@ -0,0 +1,586 @@
+package api0
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/outbox"
+	"github.com/rs/zerolog/hlog"
+)
+
+// ServerWebhookCheckInterval is how often listed servers with webhooks are
+// checked for being delisted.
+const ServerWebhookCheckInterval = time.Second * 5
+
+const (
+	serverWebhookMax         = 4096
+	serverWebhookMaxURL      = 512
+	serverWebhookTimeout     = time.Second * 10
+	serverWebhookAttempts    = 3
+	serverWebhookConcurrency = 16
+	serverWebhookCooldown    = time.Minute
+)
+
+// ServerWebhook is a callback URL registered by the owner of a game server
+// address to receive lifecycle events.
+//
+// Events are POSTed as a JSON ServerWebhookEvent with the X-Atlas-Event header
+// set to the event type, and the X-Atlas-Signature header set to
+// "t=<unix time>,v1=<hex hmac-sha256 of "<unix time>.<body>" with the secret>".
+type ServerWebhook struct {
+	// Addr is the game server address.
+	Addr netip.AddrPort `json:"addr"`
+
+	// URL is the HTTPS URL to POST events to.
+	URL string `json:"url"`
+
+	// Secret is the hex-encoded HMAC-SHA256 key used to sign events.
+	Secret string `json:"secret"`
+
+	// Created is when the webhook was registered.
+	Created time.Time `json:"created"`
+}
+
+// ServerWebhookEventType is the type of a server webhook event.
+type ServerWebhookEventType string
+
+const (
+	// ServerWebhookDelisted is sent when a listed server stops being listed
+	// without removing itself (i.e., it stopped sending heartbeats).
+	ServerWebhookDelisted ServerWebhookEventType = "delisted"
+
+	// ServerWebhookVerificationFailed is sent when the master server can't
+	// verify a server is reachable.
+	ServerWebhookVerificationFailed ServerWebhookEventType = "verification_failed"
+
+	// ServerWebhookBanned is sent when a server is rejected by a network rule
+	// or a registration hook.
+	ServerWebhookBanned ServerWebhookEventType = "banned"
+
+	// ServerWebhookModeration is sent when an admin warns, delists, bans, or
+	// reinstates a server.
+	ServerWebhookModeration ServerWebhookEventType = "moderation"
+)
+
+// ServerWebhookEvent is the body of a server webhook request.
+type ServerWebhookEvent struct {
+	Type    ServerWebhookEventType `json:"type"`
+	Time    time.Time              `json:"time"`
+	Addr    netip.AddrPort         `json:"addr"`
+	ID      string                 `json:"id,omitempty"`
+	Message string                 `json:"message,omitempty"`
+}
+
+// serverWebhookMonitor tracks listed servers with webhooks to detect when they
+// are delisted, and rate-limits events.
+type serverWebhookMonitor struct {
+	mu     sync.Mutex
+	listed map[netip.AddrPort]string // server id
+	last   map[serverWebhookKey]time.Time
+
+	semInit sync.Once
+	sem     chan struct{}
+
+	queueInit sync.Once
+	queue     *outbox.Queue
+}
+
+// serverWebhookDelivery is the outbox payload for a server webhook event. The
+// secret is looked up when it is sent so removed webhooks aren't sent.
+type serverWebhookDelivery struct {
+	URL   string             `json:"url"`
+	Event ServerWebhookEvent `json:"event"`
+}
+
+type serverWebhookKey struct {
+	Addr netip.AddrPort
+	Type ServerWebhookEventType
+}
+
+// serverWebhookDialer refuses to connect to non-public addresses so owners
+// can't use webhooks to make requests to internal services.
+var serverWebhookDialer = &net.Dialer{
+	Timeout: time.Second * 5,
+	Control: func(network, address string, _ syscall.RawConn) error {
+		ap, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := ap.Addr().Unmap(); !ip.IsGlobalUnicast() || ip.IsPrivate() {
+			return fmt.Errorf("refusing to connect to non-public address %s", ip)
+		}
+		return nil
+	},
+}
+
+var serverWebhookDefaultClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:               nil,
+		DialContext:         serverWebhookDialer.DialContext,
+		TLSHandshakeTimeout: time.Second * 5,
+		MaxIdleConns:        16,
+		IdleConnTimeout:     time.Minute,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// CheckServerWebhooks sends delisted events for servers with webhooks which
+// were listed when last checked, but have since died or been removed by the
+// master server.
+func (h *Handler) CheckServerWebhooks(t time.Time) error {
+	if !h.ServerWebhooks {
+		return nil
+	}
+
+	ws, err := h.serverWebhooks.Get(h.StateStorage, "server_webhooks")
+	if err != nil {
+		return fmt.Errorf("load server webhooks: %w", err)
+	}
+
+	m := &h.serverWebhookMon
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[netip.AddrPort]struct{}, len(ws))
+	for _, wh := range ws {
+		seen[wh.Addr] = struct{}{}
+
+		srv, status := h.ServerList.GetServerByAddr(wh.Addr)
+		if status == ServerStatusListed || status == ServerStatusLobby {
+			if m.listed == nil {
+				m.listed = map[netip.AddrPort]string{}
+			}
+			m.listed[wh.Addr] = srv.ID
+			continue
+		}
+		if id, ok := m.listed[wh.Addr]; ok {
+			delete(m.listed, wh.Addr)
+			h.sendServerWebhook(wh, ServerWebhookEvent{
+				Type:    ServerWebhookDelisted,
+				Time:    t.UTC(),
+				Addr:    wh.Addr,
+				ID:      id,
+				Message: fmt.Sprintf("server is no longer listed (status: %s)", status),
+			}, false)
+		}
+	}
+	for a := range m.listed {
+		if _, ok := seen[a]; !ok {
+			delete(m.listed, a)
+		}
+	}
+	return nil
+}
+
+// serverWebhookEvent sends a webhook for a server history event, if it is
+// relevant to server owners.
+func (h *Handler) serverWebhookEvent(addr netip.AddrPort, typ ServerEventType, id, msg string) {
+	if !h.ServerWebhooks || h.StateStorage == nil {
+		return
+	}
+	switch typ {
+	case ServerEventVerificationFailed:
+		h.serverWebhook(addr, ServerWebhookVerificationFailed, id, msg)
+	case ServerEventRemoved:
+		// the server removed itself, so it wasn't delisted
+		h.serverWebhookMon.mu.Lock()
+		delete(h.serverWebhookMon.listed, addr)
+		h.serverWebhookMon.mu.Unlock()
+	}
+}
+
+// serverWebhook sends an event to the webhook for addr, if any. If addr has no
+// port, it is sent to all webhooks for the IP.
+func (h *Handler) serverWebhook(addr netip.AddrPort, typ ServerWebhookEventType, id, msg string) {
+	if !h.ServerWebhooks || h.StateStorage == nil {
+		return
+	}
+	ws, err := h.serverWebhooks.Get(h.StateStorage, "server_webhooks")
+	if err != nil {
+		return
+	}
+	for _, wh := range ws {
+		if wh.Addr == addr || (addr.Port() == 0 && wh.Addr.Addr() == addr.Addr()) {
+			h.sendServerWebhook(wh, ServerWebhookEvent{
+				Type:    typ,
+				Time:    time.Now().UTC(),
+				Addr:    wh.Addr,
+				ID:      id,
+				Message: msg,
+			}, true)
+		}
+	}
+}
+
+// sendServerWebhook delivers ev in the background, retrying on failure. If
+// limit is true, events of the same type for the same address are sent at
+// most once per cooldown. If Outbox is set, the event is persisted to it.
+// Otherwise, if too many deliveries are in progress, the event is dropped.
+func (h *Handler) sendServerWebhook(wh ServerWebhook, ev ServerWebhookEvent, limit bool) {
+	m := &h.serverWebhookMon
+
+	if limit {
+		m.mu.Lock()
+		k := serverWebhookKey{wh.Addr, ev.Type}
+		if t, ok := m.last[k]; ok && ev.Time.Sub(t) < serverWebhookCooldown {
+			m.mu.Unlock()
+			return
+		}
+		if m.last == nil || len(m.last) >= serverWebhookMax {
+			m.last = map[serverWebhookKey]time.Time{}
+		}
+		m.last[k] = ev.Time
+		m.mu.Unlock()
+	}
+
+	if q := h.serverWebhookQueue(); q != nil {
+		buf, err := json.Marshal(serverWebhookDelivery{
+			URL:   wh.URL,
+			Event: ev,
+		})
+		if err != nil {
+			panic(err)
+		}
+		// other instances will detect the same events
+		k := fmt.Sprintf("%s %s %s %s %d", ev.Addr, ev.Type, ev.ID, wh.URL, ev.Time.Unix()/int64(serverWebhookCooldown/time.Second))
+		if err := q.Enqueue(k, buf); err != nil {
+			h.m().server_webhook_deliveries_total.dropped.Inc()
+		}
+		return
+	}
+
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		panic(err)
+	}
+	key, err := hex.DecodeString(wh.Secret)
+	if err != nil {
+		return
+	}
+
+	m.semInit.Do(func() {
+		m.sem = make(chan struct{}, serverWebhookConcurrency)
+	})
+	select {
+	case m.sem <- struct{}{}:
+	default:
+		h.m().server_webhook_deliveries_total.dropped.Inc()
+		return
+	}
+
+	go func() {
+		defer func() { <-m.sem }()
+
+		delay := time.Second
+		for i := 0; i < serverWebhookAttempts; i++ {
+			if i != 0 {
+				time.Sleep(delay)
+				delay *= 2
+			}
+			if err := h.deliverServerWebhook(context.Background(), wh.URL, key, ev.Type, buf); err != nil {
+				h.m().server_webhook_deliveries_total.fail_http_error.Inc()
+				continue
+			}
+			h.m().server_webhook_deliveries_total.success.Inc()
+			return
+		}
+	}()
+}
+
+// serverWebhookQueue gets the outbox queue for server webhooks, or nil if
+// Outbox is not set.
+func (h *Handler) serverWebhookQueue() *outbox.Queue {
+	m := &h.serverWebhookMon
+	m.queueInit.Do(func() {
+		if h.Outbox != nil {
+			m.queue = &outbox.Queue{
+				Backend: h.Outbox,
+				Name:    "server_webhooks",
+				Send:    h.sendServerWebhookDelivery,
+				Options: h.OutboxOptions,
+			}
+		}
+	})
+	return m.queue
+}
+
+// RunOutbox sends persisted server webhook deliveries until ctx is canceled.
+// It does nothing if Outbox is not set.
+func (h *Handler) RunOutbox(ctx context.Context) error {
+	if q := h.serverWebhookQueue(); q != nil {
+		return q.Run(ctx)
+	}
+	return nil
+}
+
+// sendServerWebhookDelivery sends a persisted server webhook event. Client
+// errors other than rate limits are not retried.
+func (h *Handler) sendServerWebhookDelivery(ctx context.Context, payload []byte) error {
+	var d serverWebhookDelivery
+	if err := json.Unmarshal(payload, &d); err != nil {
+		return outbox.Permanent(err)
+	}
+
+	ws, err := h.serverWebhooks.Get(h.StateStorage, "server_webhooks")
+	if err != nil {
+		return fmt.Errorf("load server webhooks: %w", err)
+	}
+	var key []byte
+	for _, wh := range ws {
+		if wh.Addr == d.Event.Addr && wh.URL == d.URL {
+			if key, err = hex.DecodeString(wh.Secret); err != nil {
+				return outbox.Permanent(fmt.Errorf("invalid secret: %w", err))
+			}
+			break
+		}
+	}
+	if key == nil {
+		h.m().server_webhook_deliveries_total.dropped.Inc()
+		return nil // webhook was removed or changed
+	}
+
+	buf, err := json.Marshal(d.Event)
+	if err != nil {
+		return outbox.Permanent(err)
+	}
+	if err := h.deliverServerWebhook(ctx, d.URL, key, d.Event.Type, buf); err != nil {
+		h.m().server_webhook_deliveries_total.fail_http_error.Inc()
+		var se serverWebhookStatusError
+		if errors.As(err, &se) && se >= 400 && se <= 499 && se != http.StatusTooManyRequests {
+			return outbox.Permanent(err)
+		}
+		return err
+	}
+	h.m().server_webhook_deliveries_total.success.Inc()
+	return nil
+}
+
+type serverWebhookStatusError int
+
+func (err serverWebhookStatusError) Error() string {
+	return fmt.Sprintf("response status %d", int(err))
+}
+
+// deliverServerWebhook makes a single signed webhook request.
+func (h *Handler) deliverServerWebhook(ctx context.Context, u string, key []byte, typ ServerWebhookEventType, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, serverWebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Atlas (server webhook)")
+	req.Header.Set("X-Atlas-Event", string(typ))
+	req.Header.Set("X-Atlas-Signature", "t="+ts+",v1="+hex.EncodeToString(mac.Sum(nil)))
+
+	c := h.ServerWebhookClient
+	if c == nil {
+		c = serverWebhookDefaultClient
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return serverWebhookStatusError(resp.StatusCode)
+	}
+	return nil
+}
+
+func (h *Handler) handleServerWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		h.m().server_webhook_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PUT, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.ServerWebhooks || h.StateStorage == nil {
+		h.m().server_webhook_requests_total.reject_disabled.Inc()
+		respFail(w, r, http.StatusNotFound, ErrorCode_BAD_REQUEST.MessageObjf("server webhooks are not enabled"))
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_webhook_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	q := r.URL.Query()
+
+	addr, err := netip.ParseAddrPort(q.Get("addr"))
+	if err != nil {
+		h.m().server_webhook_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("addr param is required and must be a game server ip:port"))
+		return
+	}
+
+	// same as owner_status
+	if tok := q.Get("token"); tok != "" {
+		srv, _ := h.ServerList.GetServerByAddr(addr)
+		if srv == nil || subtle.ConstantTimeCompare([]byte(tok), []byte(srv.ServerAuthToken)) != 1 {
+			h.m().server_webhook_requests_total.reject_unauthorized.Inc()
+			respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("invalid server auth token"))
+			return
+		}
+	} else if addr.Addr() != raddr.Addr() {
+		h.m().server_webhook_requests_total.reject_unauthorized.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("token param is required if not requesting from the server ip"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		ws, err := h.serverWebhooks.Get(h.StateStorage, "server_webhooks")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load server webhooks")
+			h.m().server_webhook_requests_total.fail_storage_error.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		var obj any
+		for _, wh := range ws {
+			if wh.Addr == addr {
+				obj = map[string]any{
+					"url":     wh.URL,
+					"created": wh.Created,
+				}
+				break
+			}
+		}
+		h.m().server_webhook_requests_total.success.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"webhook": obj,
+		})
+
+	case http.MethodPut:
+		u, err := url.Parse(q.Get("url"))
+		if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil || len(q.Get("url")) > serverWebhookMaxURL {
+			h.m().server_webhook_requests_total.reject_bad_request.Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("url param is required and must be a https url of at most %d characters", serverWebhookMaxURL))
+			return
+		}
+
+		secret, err := cryptoRandHex(64)
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to generate webhook secret")
+			h.m().server_webhook_requests_total.fail_other_error.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+
+		wh := ServerWebhook{
+			Addr:    addr,
+			URL:     u.String(),
+			Secret:  secret,
+			Created: time.Now().UTC(),
+		}
+
+		errFull := errors.New("too many server webhooks")
+		if err := h.serverWebhooks.Update(h.StateStorage, "server_webhooks", func(ws []ServerWebhook) ([]ServerWebhook, error) {
+			n := make([]ServerWebhook, 0, len(ws)+1)
+			for _, x := range ws {
+				if x.Addr != addr {
+					n = append(n, x)
+				}
+			}
+			if len(n) >= serverWebhookMax {
+				return nil, errFull
+			}
+			return append(n, wh), nil
+		}); err != nil {
+			if errors.Is(err, errFull) {
+				h.m().server_webhook_requests_total.reject_limits_exceeded.Inc()
+				respFail(w, r, http.StatusServiceUnavailable, ErrorCode_INTERNAL_SERVER_ERROR.MessageObjf("%v", err))
+				return
+			}
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to save server webhook")
+			h.m().server_webhook_requests_total.fail_storage_error.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+
+		// the secret is only returned once
+		h.m().server_webhook_requests_total.success.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+			"webhook": map[string]any{
+				"url":     wh.URL,
+				"created": wh.Created,
+			},
+			"secret": wh.Secret,
+		})
+
+	case http.MethodDelete:
+		if err := h.serverWebhooks.Update(h.StateStorage, "server_webhooks", func(ws []ServerWebhook) ([]ServerWebhook, error) {
+			n := make([]ServerWebhook, 0, len(ws))
+			for _, x := range ws {
+				if x.Addr != addr {
+					n = append(n, x)
+				}
+			}
+			return n, nil
+		}); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to delete server webhook")
+			h.m().server_webhook_requests_total.fail_storage_error.Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		h.m().server_webhook_requests_total.success.Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success": true,
+		})
+	}
+}
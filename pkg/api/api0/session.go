@@ -0,0 +1,92 @@
+package api0
+
+import (
+	"fmt"
+	"time"
+)
+
+// SessionPolicy determines how concurrent auth sessions for the same account
+// are handled.
+type SessionPolicy string
+
+const (
+	// Allow up to MaxSessions concurrent sessions, invalidating the oldest
+	// session when a new one is created.
+	SessionPolicyKickOldest SessionPolicy = "kick-oldest"
+
+	// Don't allow concurrent sessions. New sessions are rejected while
+	// another session from a different IP is still valid.
+	SessionPolicyDeny SessionPolicy = "deny"
+
+	// Allow up to MaxSessions concurrent sessions, rejecting new sessions
+	// when there are already too many.
+	SessionPolicyAllow SessionPolicy = "allow"
+)
+
+// ParseSessionPolicy parses a session policy. If s is empty,
+// SessionPolicyKickOldest is returned.
+func ParseSessionPolicy(s string) (SessionPolicy, error) {
+	switch p := SessionPolicy(s); p {
+	case "":
+		return SessionPolicyKickOldest, nil
+	case SessionPolicyKickOldest, SessionPolicyDeny, SessionPolicyAllow:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown session policy %q", s)
+	}
+}
+
+// maxSessions gets the effective maximum number of concurrent sessions per
+// account.
+func (h *Handler) maxSessions() int {
+	if h.SessionPolicy == SessionPolicyDeny || h.MaxSessions <= 0 {
+		return 1
+	}
+	return h.MaxSessions
+}
+
+// newSession makes s the current auth session for acct according to the
+// session policy, returning false if the policy doesn't allow it. Existing
+// sessions from the same IP are always replaced so players can reconnect
+// after restarting their game.
+func (h *Handler) newSession(acct *Account, s AccountSession, t time.Time) bool {
+	var keep []AccountSession
+	for _, x := range acct.Sessions(t) {
+		if x.IP != s.IP {
+			keep = append(keep, x)
+		}
+	}
+
+	switch n := h.maxSessions(); h.SessionPolicy {
+	case SessionPolicyDeny, SessionPolicyAllow:
+		if len(keep) >= n {
+			return false
+		}
+	default:
+		if len(keep) >= n {
+			keep = keep[:n-1] // sessions are newest first
+		}
+	}
+
+	acct.AuthIP = s.IP
+	acct.AuthToken = s.Token
+	acct.AuthTokenExpiry = s.Expiry
+	acct.AuthStaleVerified = s.StaleVerified
+	acct.AuthIPFlags = s.IPFlags
+	if len(keep) != 0 {
+		acct.OtherSessions = keep
+	} else {
+		acct.OtherSessions = nil
+	}
+	return true
+}
+
+// sessionIPs gets the IPs of the auth sessions which are valid at t, for
+// logging.
+func sessionIPs(acct *Account, t time.Time) []string {
+	var ips []string
+	for _, s := range acct.Sessions(t) {
+		ips = append(ips, s.IP.String())
+	}
+	return ips
+}
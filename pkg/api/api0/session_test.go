@@ -0,0 +1,147 @@
+package api0
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSessionPolicy(t *testing.T) {
+	for s, exp := range map[string]SessionPolicy{
+		"":            SessionPolicyKickOldest,
+		"kick-oldest": SessionPolicyKickOldest,
+		"deny":        SessionPolicyDeny,
+		"allow":       SessionPolicyAllow,
+		"Deny":        "",
+		"asd":         "",
+	} {
+		p, err := ParseSessionPolicy(s)
+		if exp == "" {
+			if err == nil {
+				t.Errorf("ParseSessionPolicy(%q): expected error", s)
+			}
+			continue
+		}
+		if err != nil || p != exp {
+			t.Errorf("ParseSessionPolicy(%q): expected %q, got %q (err: %v)", s, exp, p, err)
+		}
+	}
+}
+
+func TestNewSession(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	sess := func(ip string, n int) AccountSession {
+		return AccountSession{
+			IP:     netip.MustParseAddr(ip),
+			Token:  "token" + strconv.Itoa(n),
+			Expiry: t0.Add(time.Hour),
+		}
+	}
+	tokens := func(acct *Account) []string {
+		var ts []string
+		for _, s := range acct.Sessions(t0) {
+			ts = append(ts, s.Token)
+		}
+		return ts
+	}
+
+	for _, tc := range []struct {
+		name     string
+		policy   SessionPolicy
+		max      int
+		existing []AccountSession // newest first
+		ok       bool
+		tokens   []string // after the new session (token0 from 192.0.2.1)
+	}{
+		{name: "KickOldestFirst", ok: true, tokens: []string{"token0"}},
+		{name: "KickOldestSingle", existing: []AccountSession{sess("192.0.2.2", 1)}, ok: true, tokens: []string{"token0"}},
+		{name: "KickOldestMulti", max: 2, existing: []AccountSession{sess("192.0.2.2", 1), sess("192.0.2.3", 2)}, ok: true, tokens: []string{"token0", "token1"}},
+		{name: "KickOldestUnderLimit", max: 3, existing: []AccountSession{sess("192.0.2.2", 1)}, ok: true, tokens: []string{"token0", "token1"}},
+		{name: "KickOldestSameIP", max: 2, existing: []AccountSession{sess("192.0.2.1", 1), sess("192.0.2.2", 2)}, ok: true, tokens: []string{"token0", "token2"}},
+
+		{name: "DenyFirst", policy: SessionPolicyDeny, ok: true, tokens: []string{"token0"}},
+		{name: "DenyOtherIP", policy: SessionPolicyDeny, max: 5, existing: []AccountSession{sess("192.0.2.2", 1)}, tokens: []string{"token1"}},
+		{name: "DenySameIP", policy: SessionPolicyDeny, existing: []AccountSession{sess("192.0.2.1", 1)}, ok: true, tokens: []string{"token0"}},
+		{name: "DenyExpired", policy: SessionPolicyDeny, existing: []AccountSession{{IP: netip.MustParseAddr("192.0.2.2"), Token: "token1", Expiry: t0}}, ok: true, tokens: []string{"token0"}},
+
+		{name: "AllowUnderLimit", policy: SessionPolicyAllow, max: 2, existing: []AccountSession{sess("192.0.2.2", 1)}, ok: true, tokens: []string{"token0", "token1"}},
+		{name: "AllowAtLimit", policy: SessionPolicyAllow, max: 2, existing: []AccountSession{sess("192.0.2.2", 1), sess("192.0.2.3", 2)}, tokens: []string{"token1", "token2"}},
+		{name: "AllowSameIP", policy: SessionPolicyAllow, max: 2, existing: []AccountSession{sess("192.0.2.1", 1), sess("192.0.2.3", 2)}, ok: true, tokens: []string{"token0", "token2"}},
+		{name: "AllowDefaultLimit", policy: SessionPolicyAllow, existing: []AccountSession{sess("192.0.2.2", 1)}, tokens: []string{"token1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				SessionPolicy: tc.policy,
+				MaxSessions:   tc.max,
+			}
+			acct := &Account{UID: 1}
+			if len(tc.existing) != 0 {
+				cur := tc.existing[0]
+				acct.AuthIP = cur.IP
+				acct.AuthToken = cur.Token
+				acct.AuthTokenExpiry = cur.Expiry
+				acct.OtherSessions = append([]AccountSession(nil), tc.existing[1:]...)
+			}
+
+			if ok := h.newSession(acct, sess("192.0.2.1", 0), t0); ok != tc.ok {
+				t.Errorf("expected ok=%t, got %t", tc.ok, ok)
+			}
+			if ts := tokens(acct); strings.Join(ts, ",") != strings.Join(tc.tokens, ",") {
+				t.Errorf("expected sessions %q, got %q", tc.tokens, ts)
+			}
+			if tc.ok && acct.AuthToken != "token0" {
+				t.Errorf("expected new session to be the current one, got %q", acct.AuthToken)
+			}
+		})
+	}
+}
+
+func TestAccountSessions(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	acct := Account{
+		AuthIP:          netip.MustParseAddr("192.0.2.1"),
+		AuthToken:       "current",
+		AuthTokenExpiry: t0.Add(time.Hour),
+		OtherSessions: []AccountSession{
+			{IP: netip.MustParseAddr("192.0.2.2"), Token: "other", Expiry: t0.Add(time.Minute)},
+			{IP: netip.MustParseAddr("192.0.2.3"), Token: "", Expiry: t0.Add(time.Hour)},
+		},
+	}
+
+	for _, tc := range []struct {
+		token string
+		t     time.Time
+		ok    bool
+	}{
+		{"current", t0, true},
+		{"other", t0, true},
+		{"", t0, false},
+		{"unknown", t0, false},
+		{"current", t0.Add(time.Minute), true},
+		{"other", t0.Add(time.Minute), false},
+		{"current", t0.Add(time.Hour), false},
+	} {
+		if ok := acct.CheckAuthToken(tc.token, tc.t); ok != tc.ok {
+			t.Errorf("CheckAuthToken(%q, t0+%s): expected %t, got %t", tc.token, tc.t.Sub(t0), tc.ok, ok)
+		}
+	}
+
+	if n := len(acct.Sessions(t0)); n != 2 {
+		t.Errorf("expected 2 valid sessions, got %d", n)
+	}
+	if ips := sessionIPs(&acct, t0.Add(time.Minute)); len(ips) != 1 || ips[0] != "192.0.2.1" {
+		t.Errorf("expected only current session ip after other session expired, got %q", ips)
+	}
+	for ip, exp := range map[string]bool{
+		"192.0.2.1": true,
+		"192.0.2.2": true,
+		"192.0.2.3": true,
+		"192.0.2.4": false,
+	} {
+		if v := acct.HasAuthIP(netip.MustParseAddr(ip)); v != exp {
+			t.Errorf("HasAuthIP(%s): expected %t, got %t", ip, exp, v)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package api0
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"time"
+)
+
+// signToken creates a URL-safe token containing payload which expires after
+// ttl. The token is only valid for the provided purpose.
+//
+// The signing key is generated randomly once per Handler, so tokens are
+// invalidated on restart.
+func (h *Handler) signToken(purpose string, payload []byte, ttl time.Duration) string {
+	b := make([]byte, 8, 8+len(payload)+sha256.Size)
+	binary.LittleEndian.PutUint64(b, uint64(time.Now().Add(ttl).Unix()))
+	b = append(b, payload...)
+	return base64.RawURLEncoding.EncodeToString(h.tokenMAC(purpose, b))
+}
+
+// verifyToken verifies a token created by signToken for the provided purpose,
+// returning the payload.
+func (h *Handler) verifyToken(purpose, token string) ([]byte, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) < 8+sha256.Size {
+		return nil, false
+	}
+	n := len(b) - sha256.Size
+	if !hmac.Equal(h.tokenMAC(purpose, b[:n:n]), b) {
+		return nil, false
+	}
+	if time.Now().Unix() > int64(binary.LittleEndian.Uint64(b)) {
+		return nil, false
+	}
+	return b[8:n], true
+}
+
+// tokenMAC appends the HMAC of purpose and b to b.
+func (h *Handler) tokenMAC(purpose string, b []byte) []byte {
+	h.tokenKeyInit.Do(func() {
+		h.tokenKey = make([]byte, 32)
+		if _, err := rand.Read(h.tokenKey); err != nil {
+			panic(err)
+		}
+	})
+	m := hmac.New(sha256.New, h.tokenKey)
+	m.Write([]byte(purpose))
+	m.Write([]byte{0})
+	m.Write(b)
+	return m.Sum(b)
+}
@@ -0,0 +1,126 @@
+package api0
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// serverListSnapshotVersion is the current server list snapshot format.
+const serverListSnapshotVersion = 1
+
+// serverListSnapshot is the serialized form of a ServerList.
+type serverListSnapshot struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	Servers []Server  `json:"servers"`
+}
+
+// WriteSnapshot writes a snapshot of the verified servers (including ghosts,
+// which can still be revived by a heartbeat) to w. It includes the server
+// auth tokens, so it must be stored securely.
+func (s *ServerList) WriteSnapshot(w io.Writer) (int, error) {
+	t := s.now()
+
+	ss := serverListSnapshot{
+		Version: serverListSnapshotVersion,
+		Time:    t.UTC(),
+		Servers: []Server{},
+	}
+
+	// take a read lock on the server list
+	s.mu.RLock()
+	if s.servers1 != nil {
+		for _, srv := range s.servers1 {
+			if st := s.serverState(srv, t); st == serverListStateAlive || st == serverListStateGhost {
+				ss.Servers = append(ss.Servers, srv.clone())
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	if err := json.NewEncoder(w).Encode(ss); err != nil {
+		return 0, err
+	}
+	return len(ss.Servers), nil
+}
+
+// RestoreSnapshot restores servers from a snapshot written by WriteSnapshot,
+// returning the number of servers restored. Heartbeat times are shifted
+// forward by the snapshot age so servers are in the same state as when the
+// snapshot was taken (i.e., live servers stay listed until they would have
+// gone dead without a heartbeat). If the snapshot is older than maxAge, it is
+// ignored. Servers conflicting with existing ones are skipped.
+func (s *ServerList) RestoreSnapshot(r io.Reader, maxAge time.Duration) (int, error) {
+	var ss serverListSnapshot
+	if err := json.NewDecoder(r).Decode(&ss); err != nil {
+		return 0, fmt.Errorf("decode snapshot: %w", err)
+	}
+	if ss.Version != serverListSnapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", ss.Version)
+	}
+
+	t := s.now()
+	age := t.Sub(ss.Time)
+	if age < 0 {
+		age = 0
+	}
+	if maxAge > 0 && age > maxAge {
+		return 0, nil
+	}
+
+	// take a write lock on the server list
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// ensure maps are initialized
+	if s.servers1 == nil {
+		s.servers1 = make(map[netip.AddrPort]*Server)
+	}
+	if s.servers2 == nil {
+		s.servers2 = make(map[string]*Server)
+	}
+	if s.servers3 == nil {
+		s.servers3 = make(map[netip.AddrPort]*Server)
+	}
+
+	var n int
+	for _, x := range ss.Servers {
+		nsrv := x.clone()
+		if !nsrv.Addr.IsValid() || nsrv.ID == "" {
+			continue
+		}
+		nsrv.LastHeartbeat = nsrv.LastHeartbeat.Add(age)
+
+		// note: existing servers registered after startup take precedence
+		if s.serverState(&nsrv, t) == serverListStateGone {
+			continue
+		}
+		if _, exists := s.servers1[nsrv.Addr]; exists {
+			continue
+		}
+		if _, exists := s.servers2[nsrv.ID]; exists {
+			continue
+		}
+		if _, exists := s.servers3[nsrv.AuthAddr()]; exists {
+			continue
+		}
+
+		// keep the original order, but ensure new servers come after
+		if nsrv.Order > s.order.Load() {
+			s.order.Store(nsrv.Order)
+		}
+
+		s.servers1[nsrv.Addr] = &nsrv
+		s.servers2[nsrv.ID] = &nsrv
+		s.servers3[nsrv.AuthAddr()] = &nsrv
+		n++
+	}
+	if n != 0 {
+		s.csUpdateNextUpdateTime()
+		s.csForceUpdate()
+	}
+	return n, nil
+}
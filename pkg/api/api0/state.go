@@ -0,0 +1,82 @@
+package api0
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// stateValue caches a JSON-encoded value stored in a StateStorage. The same
+// key must be used for every call.
+type stateValue[T any] struct {
+	mu sync.Mutex
+	v  atomic.Pointer[T]
+}
+
+// Get gets the current value, loading it from s if required. If s is nil or
+// the value doesn't exist, the zero value is returned. The returned value must
+// not be modified.
+func (c *stateValue[T]) Get(s StateStorage, key string) (T, error) {
+	if v := c.v.Load(); v != nil {
+		return *v, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.load(s, key)
+}
+
+func (c *stateValue[T]) load(s StateStorage, key string) (T, error) {
+	if v := c.v.Load(); v != nil {
+		return *v, nil
+	}
+
+	var v T
+	if s != nil {
+		buf, exists, err := s.GetState(key)
+		if err != nil {
+			return v, err
+		}
+		if exists {
+			if err := json.Unmarshal(buf, &v); err != nil {
+				return v, fmt.Errorf("decode stored %s: %w", key, err)
+			}
+		}
+	}
+	c.v.Store(&v)
+	return v, nil
+}
+
+// Update atomically replaces the current value with the result of fn and saves
+// it to s. The value passed to fn must not be modified in-place.
+func (c *stateValue[T]) Update(s StateStorage, key string, fn func(v T) (T, error)) error {
+	if s == nil {
+		return errors.New("no state storage")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cur, err := c.load(s, key)
+	if err != nil {
+		return err
+	}
+
+	v, err := fn(cur)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := s.SetState(key, buf); err != nil {
+		return err
+	}
+	c.v.Store(&v)
+	return nil
+}
@@ -0,0 +1,474 @@
+package api0
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// statusIncidentHistory is how long resolved incidents are included in the
+// status document.
+const statusIncidentHistory = time.Hour * 24 * 7
+
+// StatusIndicator is the overall status of the master server. The values
+// match the ones used by Atlassian Statuspage.
+type StatusIndicator string
+
+const (
+	StatusIndicatorNone     StatusIndicator = "none"
+	StatusIndicatorMinor    StatusIndicator = "minor"
+	StatusIndicatorMajor    StatusIndicator = "major"
+	StatusIndicatorCritical StatusIndicator = "critical"
+)
+
+// ComponentStatus is the status of a single component. The values match the
+// ones used by Atlassian Statuspage.
+type ComponentStatus string
+
+const (
+	ComponentStatusOperational         ComponentStatus = "operational"
+	ComponentStatusDegradedPerformance ComponentStatus = "degraded_performance"
+	ComponentStatusPartialOutage       ComponentStatus = "partial_outage"
+	ComponentStatusMajorOutage         ComponentStatus = "major_outage"
+)
+
+// StatusComponent is the ID of a component included in the status document.
+type StatusComponent string
+
+const (
+	StatusComponentAPI        StatusComponent = "api"         // the master server itself
+	StatusComponentServerList StatusComponent = "server_list" // game server registration and listing
+	StatusComponentAuth       StatusComponent = "auth"        // player authentication with Origin
+)
+
+var statusComponentNames = map[StatusComponent]string{
+	StatusComponentAPI:        "Master Server API",
+	StatusComponentServerList: "Server Browser",
+	StatusComponentAuth:       "Origin Authentication",
+}
+
+// statusComponents is the order of the components in the status document.
+var statusComponents = []StatusComponent{
+	StatusComponentAPI,
+	StatusComponentServerList,
+	StatusComponentAuth,
+}
+
+// IncidentStatus is the progress of an incident.
+type IncidentStatus string
+
+const (
+	IncidentStatusInvestigating IncidentStatus = "investigating"
+	IncidentStatusIdentified    IncidentStatus = "identified"
+	IncidentStatusMonitoring    IncidentStatus = "monitoring"
+	IncidentStatusResolved      IncidentStatus = "resolved"
+)
+
+// IncidentImpact is the severity of an incident.
+type IncidentImpact string
+
+const (
+	IncidentImpactNone     IncidentImpact = "none"
+	IncidentImpactMinor    IncidentImpact = "minor"
+	IncidentImpactMajor    IncidentImpact = "major"
+	IncidentImpactCritical IncidentImpact = "critical"
+)
+
+// Incident is a manually reported problem managed via the admin API. While it
+// is unresolved, its impact is applied to the affected components.
+type Incident struct {
+	// ID uniquely identifies the incident. It is required.
+	ID string `json:"id" validate:"required,max=64"`
+
+	// Name is a short summary of the incident. It is required.
+	Name string `json:"name" validate:"required,max=256"`
+
+	// Message is an optional longer description or the latest update.
+	Message string `json:"message,omitempty" validate:"max=4096"`
+
+	// Status defaults to investigating.
+	Status IncidentStatus `json:"status" validate:"oneof=investigating|identified|monitoring|resolved"`
+
+	// Impact defaults to minor.
+	Impact IncidentImpact `json:"impact" validate:"oneof=none|minor|major|critical"`
+
+	// Components are the IDs of the affected components. If empty, the
+	// incident only affects the overall status.
+	Components []StatusComponent `json:"components,omitempty" validate:"max=16"`
+
+	// CreatedAt, UpdatedAt, and ResolvedAt are set automatically.
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+// validateIncidents checks if is is a valid set of incidents.
+func validateIncidents(is []Incident) error {
+	ids := map[string]struct{}{}
+	for i, x := range is {
+		if err := validate(x); err != nil {
+			return fmt.Errorf("incident %d: %w", i, err)
+		}
+		if _, dup := ids[x.ID]; dup {
+			return fmt.Errorf("incident %q: duplicate id", x.ID)
+		}
+		ids[x.ID] = struct{}{}
+		for _, c := range x.Components {
+			if _, ok := statusComponentNames[c]; !ok {
+				return fmt.Errorf("incident %q: unknown component %q", x.ID, c)
+			}
+		}
+	}
+	return nil
+}
+
+// Status is the public status document served at /api/status. It is a stable
+// schema loosely based on the Atlassian Statuspage API so it can be consumed
+// by existing status dashboards and monitors (e.g., an Uptime Kuma JSON query
+// on status.indicator). Fields will only be added, never removed or changed.
+type Status struct {
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Status struct {
+		Indicator   StatusIndicator `json:"indicator"`
+		Description string          `json:"description"`
+	} `json:"status"`
+
+	Components []StatusComponentInfo `json:"components"`
+
+	// Incidents contains unresolved incidents and ones resolved in the last
+	// week, newest first.
+	Incidents []Incident `json:"incidents"`
+
+	Metrics struct {
+		Servers int `json:"servers"`
+		Players int `json:"players"`
+	} `json:"metrics"`
+}
+
+// StatusComponentInfo is the status of a component.
+type StatusComponentInfo struct {
+	ID     StatusComponent `json:"id"`
+	Name   string          `json:"name"`
+	Status ComponentStatus `json:"status"`
+}
+
+// Status gets the current status document.
+func (h *Handler) Status(t time.Time) (Status, error) {
+	var st Status
+	st.UpdatedAt = t.UTC()
+
+	cs := map[StatusComponent]ComponentStatus{}
+	for _, c := range statusComponents {
+		cs[c] = ComponentStatusOperational
+	}
+	switch h.OriginStatus() {
+	case OriginStatusDegraded:
+		cs[StatusComponentAuth] = ComponentStatusDegradedPerformance
+	case OriginStatusDown:
+		cs[StatusComponentAuth] = ComponentStatusMajorOutage
+	}
+	if h.ServerList != nil {
+		h.ServerList.GetLiveServers(func(srv *Server) bool {
+			st.Metrics.Servers++
+			st.Metrics.Players += srv.PlayerCount
+			return true
+		})
+	}
+
+	is, err := h.incidents.Get(h.StateStorage, "incidents")
+	if err != nil {
+		return st, fmt.Errorf("load incidents: %w", err)
+	}
+	indicator := StatusIndicatorNone
+	st.Incidents = []Incident{}
+	for _, x := range is {
+		if x.ResolvedAt != nil {
+			if t.Sub(*x.ResolvedAt) < statusIncidentHistory {
+				st.Incidents = append(st.Incidents, x)
+			}
+			continue
+		}
+		st.Incidents = append(st.Incidents, x)
+		if i := x.Impact.indicator(); i.worse(indicator) {
+			indicator = i
+		}
+		for _, c := range x.Components {
+			if s := x.Impact.componentStatus(); s.worse(cs[c]) {
+				cs[c] = s
+			}
+		}
+	}
+	sort.SliceStable(st.Incidents, func(i, j int) bool {
+		return st.Incidents[i].CreatedAt.After(st.Incidents[j].CreatedAt)
+	})
+
+	for _, c := range statusComponents {
+		st.Components = append(st.Components, StatusComponentInfo{
+			ID:     c,
+			Name:   statusComponentNames[c],
+			Status: cs[c],
+		})
+		if i := cs[c].indicator(); i.worse(indicator) {
+			indicator = i
+		}
+	}
+	st.Status.Indicator = indicator
+	st.Status.Description = indicator.description()
+	return st, nil
+}
+
+var statusIndicatorOrder = map[StatusIndicator]int{
+	StatusIndicatorNone:     0,
+	StatusIndicatorMinor:    1,
+	StatusIndicatorMajor:    2,
+	StatusIndicatorCritical: 3,
+}
+
+func (i StatusIndicator) worse(o StatusIndicator) bool {
+	return statusIndicatorOrder[i] > statusIndicatorOrder[o]
+}
+
+func (i StatusIndicator) description() string {
+	switch i {
+	case StatusIndicatorMinor:
+		return "Minor Service Outage"
+	case StatusIndicatorMajor:
+		return "Partial System Outage"
+	case StatusIndicatorCritical:
+		return "Major Service Outage"
+	default:
+		return "All Systems Operational"
+	}
+}
+
+func (s ComponentStatus) indicator() StatusIndicator {
+	switch s {
+	case ComponentStatusDegradedPerformance:
+		return StatusIndicatorMinor
+	case ComponentStatusPartialOutage:
+		return StatusIndicatorMajor
+	case ComponentStatusMajorOutage:
+		return StatusIndicatorCritical
+	default:
+		return StatusIndicatorNone
+	}
+}
+
+func (s ComponentStatus) worse(o ComponentStatus) bool {
+	return s.indicator().worse(o.indicator())
+}
+
+func (i IncidentImpact) indicator() StatusIndicator {
+	switch i {
+	case IncidentImpactMinor:
+		return StatusIndicatorMinor
+	case IncidentImpactMajor:
+		return StatusIndicatorMajor
+	case IncidentImpactCritical:
+		return StatusIndicatorCritical
+	default:
+		return StatusIndicatorNone
+	}
+}
+
+func (i IncidentImpact) componentStatus() ComponentStatus {
+	switch i {
+	case IncidentImpactMinor:
+		return ComponentStatusDegradedPerformance
+	case IncidentImpactMajor:
+		return ComponentStatusPartialOutage
+	case IncidentImpactCritical:
+		return ComponentStatusMajorOutage
+	default:
+		return ComponentStatusOperational
+	}
+}
+
+func (h *Handler) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().api_status_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=30")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	st, err := h.Status(time.Now())
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to get status")
+		h.m().api_status_requests_total.fail_storage_error_state.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().api_status_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, st)
+}
+
+func (h *Handler) handleAdminIncidents(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "incidents"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PUT, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		is, err := h.incidents.Get(h.StateStorage, "incidents")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load incidents from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if is == nil {
+			is = []Incident{}
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success":   true,
+			"incidents": is,
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+
+	var fn func(is []Incident) ([]Incident, error)
+	switch r.Method {
+	case http.MethodPut:
+		var is []Incident
+		if err := decodeJSON(r, &is); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func([]Incident) ([]Incident, error) {
+			for i := range is {
+				is[i].fill(nil, now)
+			}
+			return is, nil
+		}
+	case http.MethodPost:
+		var x Incident
+		if err := decodeJSON(r, &x); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func(is []Incident) ([]Incident, error) {
+			for i := range is {
+				if is[i].ID == x.ID {
+					x.fill(&is[i], now)
+					is[i] = x
+					return is, nil
+				}
+			}
+			x.fill(nil, now)
+			return append(is, x), nil
+		}
+	case http.MethodDelete:
+		var q struct {
+			ID string `param:"id" validate:"required"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		id := q.ID
+		fn = func(is []Incident) ([]Incident, error) {
+			for i := range is {
+				if is[i].ID == id {
+					return append(is[:i], is[i+1:]...), nil
+				}
+			}
+			return is, nil
+		}
+	}
+
+	var errInvalid error
+	if err := h.incidents.Update(h.StateStorage, "incidents", func(is []Incident) ([]Incident, error) {
+		is, err := fn(append([]Incident(nil), is...))
+		if err == nil {
+			if err = validateIncidents(is); err != nil {
+				errInvalid = err
+			}
+		}
+		return is, err
+	}); err != nil {
+		if errInvalid != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", errInvalid))
+			return
+		}
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save incidents to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
+
+// fill sets the defaults and timestamps for x, which replaces prev (if
+// non-nil) at t.
+func (x *Incident) fill(prev *Incident, t time.Time) {
+	if x.Status == "" {
+		x.Status = IncidentStatusInvestigating
+	}
+	if x.Impact == "" {
+		x.Impact = IncidentImpactMinor
+	}
+	if prev != nil {
+		x.CreatedAt, x.ResolvedAt = prev.CreatedAt, prev.ResolvedAt
+	}
+	if x.CreatedAt.IsZero() {
+		x.CreatedAt = t
+	}
+	if x.UpdatedAt.IsZero() || prev != nil {
+		x.UpdatedAt = t
+	}
+	if x.Status != IncidentStatusResolved {
+		x.ResolvedAt = nil
+	} else if x.ResolvedAt == nil {
+		rt := t
+		x.ResolvedAt = &rt
+	}
+}
@@ -1,6 +1,7 @@
 package api0
 
 import (
+	"context"
 	"crypto/sha256"
 	"net/netip"
 	"time"
@@ -24,14 +25,99 @@ type Account struct {
 	// AuthTokenExpiry is the expiry date of the current auth token.
 	AuthTokenExpiry time.Time
 
+	// AuthStaleVerified is true if the current auth session was created in
+	// degraded mode while Origin was unavailable, so the player's token wasn't
+	// verified.
+	AuthStaleVerified bool
+
+	// AuthIPFlags contains the reputation flags for AuthIP at the time the
+	// current auth session was created.
+	AuthIPFlags IPReputation
+
+	// OtherSessions contains older auth sessions which are still valid since
+	// the session policy allows concurrent sessions. It does not include the
+	// current auth session.
+	OtherSessions []AccountSession
+
 	// LastServerID is the ID of the last server the account connected to.
 	LastServerID string
+
+	// VerifiedAt is the last time the player's token was verified with
+	// stryder (and their username was looked up, if successful).
+	VerifiedAt time.Time
+}
+
+// AccountSession contains information about an auth session.
+type AccountSession struct {
+	// IP is the IP used for the auth session.
+	IP netip.Addr
+
+	// Token is the random token generated for the auth session.
+	Token string
+
+	// Expiry is the expiry date of the auth token.
+	Expiry time.Time
+
+	// StaleVerified is true if the auth session was created in degraded mode
+	// while Origin was unavailable.
+	StaleVerified bool
+
+	// IPFlags contains the reputation flags for IP at the time the auth
+	// session was created.
+	IPFlags IPReputation
 }
 
 func (a Account) IsOnOwnServer() bool {
 	return a.LastServerID == "self"
 }
 
+// Sessions gets all auth sessions for the account which have not expired at t,
+// starting with the current one.
+func (a Account) Sessions(t time.Time) []AccountSession {
+	var ss []AccountSession
+	if a.AuthToken != "" && t.Before(a.AuthTokenExpiry) {
+		ss = append(ss, AccountSession{
+			IP:            a.AuthIP,
+			Token:         a.AuthToken,
+			Expiry:        a.AuthTokenExpiry,
+			StaleVerified: a.AuthStaleVerified,
+			IPFlags:       a.AuthIPFlags,
+		})
+	}
+	for _, s := range a.OtherSessions {
+		if s.Token != "" && t.Before(s.Expiry) {
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
+
+// CheckAuthToken checks whether token is valid for any auth session at t.
+func (a Account) CheckAuthToken(token string, t time.Time) bool {
+	if token == "" {
+		return false
+	}
+	for _, s := range a.Sessions(t) {
+		if s.Token == token {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAuthIP checks whether ip was used for any auth session.
+func (a Account) HasAuthIP(ip netip.Addr) bool {
+	if a.AuthIP == ip {
+		return true
+	}
+	for _, s := range a.OtherSessions {
+		if s.IP == ip {
+			return true
+		}
+	}
+	return false
+}
+
 // AccountStorage stores information about registered users. It must be safe
 // for concurrent use.
 type AccountStorage interface {
@@ -46,6 +132,9 @@ type AccountStorage interface {
 
 	// SaveAccount creates or replaces an account by its uid.
 	SaveAccount(a *Account) error
+
+	// DeleteAccount deletes the account matching uid if it exists.
+	DeleteAccount(uid uint64) error
 }
 
 // PdataStorage stores player data for users. It should not make any assumptions
@@ -63,4 +152,678 @@ type PdataStorage interface {
 
 	// SetPdata sets the raw pdata for uid, returning the actual size stored.
 	SetPdata(uid uint64, buf []byte) (n int, err error)
+
+	// DeletePdata deletes the pdata for uid if it exists.
+	DeletePdata(uid uint64) error
+}
+
+// AccountListStorage is optionally implemented by an AccountStorage to allow
+// iterating over all accounts (e.g., to migrate them to another backend).
+type AccountListStorage interface {
+	// GetAccountUIDs gets the UIDs of all stored accounts in ascending order.
+	GetAccountUIDs() ([]uint64, error)
+}
+
+// PdataListStorage is optionally implemented by a PdataStorage to allow
+// iterating over all stored pdata.
+type PdataListStorage interface {
+	// GetPdataUIDs gets the UIDs with stored pdata in ascending order.
+	GetPdataUIDs() ([]uint64, error)
+}
+
+// CompactStorage is optionally implemented by storage backends which can
+// reclaim space from deleted data.
+type CompactStorage interface {
+	// Compact reclaims unused space, returning the approximate number of bytes
+	// reclaimed.
+	Compact(ctx context.Context) (int64, error)
+}
+
+// AccountLinkProvider is an external account provider which can be linked to
+// an account.
+type AccountLinkProvider string
+
+const (
+	AccountLinkProviderDiscord AccountLinkProvider = "discord"
+	AccountLinkProviderSteam   AccountLinkProvider = "steam"
+)
+
+// AccountLink links an account to an external account.
+type AccountLink struct {
+	// UID is the Origin UID of the account. It is required.
+	UID uint64
+
+	// Provider is the external account provider. It is required. Each account
+	// may only have one link per provider.
+	Provider AccountLinkProvider
+
+	// ExternalID is the ID of the external account. It is required and must be
+	// unique for the provider.
+	ExternalID string
+
+	// ExternalName is the last known display name of the external account. It
+	// is optional.
+	ExternalName string
+
+	// VerifiedAt is the time the link was last verified.
+	VerifiedAt time.Time
+}
+
+// AccountLinkStorage stores links between accounts and external accounts. It
+// must be safe for concurrent use.
+type AccountLinkStorage interface {
+	// GetAccountLinks gets all links for uid. If none exist, a nil/zero-length
+	// slice is returned. If another error occurs, err is non-nil.
+	GetAccountLinks(uid uint64) ([]AccountLink, error)
+
+	// GetUIDByAccountLink gets the uid linked to the provided external
+	// account. If none is, exists is false. If another error occurs, err is
+	// non-nil.
+	GetUIDByAccountLink(provider AccountLinkProvider, externalID string) (uid uint64, exists bool, err error)
+
+	// SaveAccountLink creates or replaces the link for the uid and provider,
+	// replacing any existing link to the same external account.
+	SaveAccountLink(l *AccountLink) error
+
+	// DeleteAccountLink deletes the link for the uid and provider, if any.
+	DeleteAccountLink(uid uint64, provider AccountLinkProvider) error
+}
+
+// UsernameChange records a change of a player's username.
+type UsernameChange struct {
+	// UID is the Origin UID of the account. It is required.
+	UID uint64
+
+	// Time is when the change was detected. It is required.
+	Time time.Time
+
+	// From is the previous username.
+	From string
+
+	// To is the new username.
+	To string
+}
+
+// UsernameHistoryStorage stores username changes for moderation. It must be
+// safe for concurrent use.
+type UsernameHistoryStorage interface {
+	// GetUsernameHistory gets all username changes for uid, oldest first. If
+	// there are none, a nil/zero-length slice is returned. If another error
+	// occurs, err is non-nil.
+	GetUsernameHistory(uid uint64) ([]UsernameChange, error)
+
+	// GetUIDsByPastUsername gets all UIDs which have been renamed from or to
+	// username (case insensitive). If none match, a nil/zero-length slice is
+	// returned. If another error occurs, err is non-nil.
+	GetUIDsByPastUsername(username string) ([]uint64, error)
+
+	// SaveUsernameChange records a username change.
+	SaveUsernameChange(c *UsernameChange) error
+
+	// DeleteUsernameHistory deletes all username changes for uid.
+	DeleteUsernameHistory(uid uint64) error
+}
+
+// AccountSignalKind is the kind of an AccountSignal.
+type AccountSignalKind string
+
+const (
+	// An IP address used to authenticate.
+	AccountSignalIP AccountSignalKind = "ip"
+
+	// An opaque value (e.g., a hardware identifier) provided by a game server
+	// when the player connected.
+	AccountSignalHint AccountSignalKind = "hint"
+)
+
+// AccountSignal is an observation about an account which may be shared by
+// other accounts owned by the same person.
+type AccountSignal struct {
+	// UID is the Origin UID of the account. It is required.
+	UID uint64
+
+	// Kind is the kind of signal. It is required.
+	Kind AccountSignalKind
+
+	// Hash is a hash of the observed value so the raw value (e.g., an IP
+	// address) doesn't need to be retained. It is required.
+	Hash string
+
+	// FirstSeen is when the signal was first observed for the account.
+	FirstSeen time.Time
+
+	// LastSeen is when the signal was last observed for the account.
+	LastSeen time.Time
+}
+
+// AccountSignalStorage stores account signals for alt detection. It must be
+// safe for concurrent use.
+type AccountSignalStorage interface {
+	// GetAccountSignals gets all signals for uid. If there are none, a
+	// nil/zero-length slice is returned. If another error occurs, err is
+	// non-nil.
+	GetAccountSignals(uid uint64) ([]AccountSignal, error)
+
+	// GetUIDsByAccountSignal gets up to limit (if positive) UIDs which have
+	// the signal. If none do, a nil/zero-length slice is returned. If another
+	// error occurs, err is non-nil.
+	GetUIDsByAccountSignal(kind AccountSignalKind, hash string, limit int) ([]uint64, error)
+
+	// SaveAccountSignal records a signal, updating LastSeen (but not
+	// FirstSeen) if the account already has it.
+	SaveAccountSignal(s *AccountSignal) error
+
+	// DeleteAccountSignals deletes all signals for uid.
+	DeleteAccountSignals(uid uint64) error
+
+	// DeleteAccountSignalsBefore deletes signals last seen before t.
+	DeleteAccountSignalsBefore(t time.Time) error
+}
+
+// BanListEntry is a ban contributed to a shared ban list by a trusted server
+// community.
+type BanListEntry struct {
+	// List is the ID of the ban list. It is required.
+	List string
+
+	// UID is the Origin UID of the banned player. It is required.
+	UID uint64
+
+	// Source is the name of the trusted server community which contributed
+	// the ban. It is required.
+	Source string
+
+	// Reason is an optional reason for the ban.
+	Reason string
+
+	// Revoked is true if the source has since unbanned the player. Revoked
+	// entries are kept so subscribers can sync unbans.
+	Revoked bool
+
+	// Created is when the source first banned the player.
+	Created time.Time
+
+	// Updated is when the entry was last changed.
+	Updated time.Time
+}
+
+// BanListStorage stores entries for shared ban lists. It must be safe for
+// concurrent use.
+type BanListStorage interface {
+	// GetBanListEntries gets all entries (including revoked ones) in list. If
+	// there are none, a nil/zero-length slice is returned. If another error
+	// occurs, err is non-nil.
+	GetBanListEntries(list string) ([]BanListEntry, error)
+
+	// SaveBanListEntry creates or replaces the entry for the list, uid, and
+	// source, keeping the existing Created time.
+	SaveBanListEntry(e *BanListEntry) error
+
+	// DeleteBanList deletes all entries in list.
+	DeleteBanList(list string) error
+
+	// DeleteBanListEntries deletes all entries for uid in all lists.
+	DeleteBanListEntries(uid uint64) error
+}
+
+// AbuseReportStatus is the moderation status of an abuse report.
+type AbuseReportStatus string
+
+const (
+	AbuseReportOpen      AbuseReportStatus = "open"
+	AbuseReportResolved  AbuseReportStatus = "resolved"
+	AbuseReportDismissed AbuseReportStatus = "dismissed"
+)
+
+// AbuseReport is a player report submitted by a game server.
+type AbuseReport struct {
+	// ID uniquely identifies the report. It is required.
+	ID string
+
+	// Time is when the report was submitted.
+	Time time.Time
+
+	// ServerID, ServerName, and ServerAddr identify the game server which
+	// submitted the report.
+	ServerID   string
+	ServerName string
+	ServerAddr netip.AddrPort
+
+	// Reporter is the UID of the player who made the report, or zero if it was
+	// generated by Atlas (e.g., for pdata rule violations).
+	Reporter uint64
+
+	// Reported is the UID of the reported player.
+	Reported uint64
+
+	// Reason is the reason chosen by the reporter.
+	Reason string
+
+	// Evidence is optional free-form evidence (e.g., chat logs or stats).
+	Evidence string
+
+	// Map and Playlist are the match the report was made in.
+	Map      string
+	Playlist string
+
+	// Context is optional additional match context provided by the server.
+	Context map[string]string
+
+	// Status is the moderation status of the report.
+	Status AbuseReportStatus
+
+	// Note is an optional note by the moderator who handled the report.
+	Note string
+}
+
+// AbuseReportStorage stores abuse reports for moderation. It must be safe for
+// concurrent use.
+type AbuseReportStorage interface {
+	// GetAbuseReport gets the report with the provided ID. If it doesn't
+	// exist, nil is returned. If another error occurs, err is non-nil.
+	GetAbuseReport(id string) (*AbuseReport, error)
+
+	// GetAbuseReports gets up to limit (if positive) of the oldest reports
+	// with the provided status, or any status if empty. If reported is
+	// non-zero, only reports against that UID are returned. If there are none,
+	// a nil/zero-length slice is returned. If another error occurs, err is
+	// non-nil.
+	GetAbuseReports(status AbuseReportStatus, reported uint64, limit int) ([]AbuseReport, error)
+
+	// SaveAbuseReport creates or replaces a report.
+	SaveAbuseReport(r *AbuseReport) error
+
+	// DeleteAbuseReports deletes all reports made by or against uid.
+	DeleteAbuseReports(uid uint64) error
+}
+
+// StateStorage stores small blobs of server-wide state (e.g., content managed
+// via the admin API) by key. It should not make any assumptions on the
+// contents of the stored blobs. It must be safe for concurrent use.
+type StateStorage interface {
+	// GetState gets the blob stored for key. If there is not one, exists is
+	// false. If another error occurs, err is non-nil.
+	GetState(key string) (buf []byte, exists bool, err error)
+
+	// SetState sets the blob stored for key. If buf is nil, the key is
+	// deleted.
+	SetState(key string, buf []byte) error
+}
+
+// StateListStorage is optionally implemented by a StateStorage to allow
+// iterating over all stored state.
+type StateListStorage interface {
+	// GetStateKeys gets all stored keys in ascending order.
+	GetStateKeys() ([]string, error)
+}
+
+// ServerStatsBucket contains aggregated statistics for a game server address
+// over a period of time.
+type ServerStatsBucket struct {
+	// Addr is the game server address. If it is the zero value, the bucket
+	// contains the totals for all servers.
+	Addr netip.AddrPort
+
+	// Start is the start of the period. It is required and must be a multiple
+	// of Resolution.
+	Start time.Time
+
+	// Resolution is the length of the period. It is required.
+	Resolution time.Duration
+
+	// Name is the last known server name.
+	Name string
+
+	// Samples is the number of times the server was seen alive during the
+	// period.
+	Samples int
+
+	// PlayerSum is the sum of the player count for all samples.
+	PlayerSum int
+
+	// PlayerMax is the highest player count seen.
+	PlayerMax int
+
+	// MaxPlayers is the last known maximum player count.
+	MaxPlayers int
+
+	// Maps contains the number of samples for each map.
+	Maps map[string]int
+}
+
+// ServerStatsStorage stores aggregated game server statistics. It must be safe
+// for concurrent use.
+type ServerStatsStorage interface {
+	// GetServerStats gets the buckets for addr with the provided resolution
+	// starting at or after since, ordered by start time. If there are none, a
+	// nil/zero-length slice is returned. If another error occurs, err is
+	// non-nil.
+	GetServerStats(addr netip.AddrPort, resolution time.Duration, since time.Time) ([]ServerStatsBucket, error)
+
+	// SaveServerStats creates or replaces buckets by their addr, resolution,
+	// and start time.
+	SaveServerStats(bs []ServerStatsBucket) error
+
+	// DeleteServerStats deletes buckets with the provided resolution starting
+	// before t, returning the number of deleted buckets.
+	DeleteServerStats(resolution time.Duration, before time.Time) (int, error)
+}
+
+// PlayerRating is the Glicko-2 skill rating of a player for a game mode.
+type PlayerRating struct {
+	// UID and Mode identify the rating. They are required.
+	UID  uint64
+	Mode string
+
+	// Rating, Deviation, and Volatility are the Glicko-2 parameters on the
+	// Glicko scale.
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+
+	// Matches is the number of rated matches played.
+	Matches int
+
+	// Updated is when the rating was last updated.
+	Updated time.Time
+}
+
+// RatingStorage stores player skill ratings. It must be safe for concurrent
+// use.
+type RatingStorage interface {
+	// GetRatings gets all ratings for uid ordered by mode. If there are none,
+	// a nil/zero-length slice is returned. If another error occurs, err is
+	// non-nil.
+	GetRatings(uid uint64) ([]PlayerRating, error)
+
+	// GetRating gets the rating for uid in mode. If it doesn't exist, nil is
+	// returned. If another error occurs, err is non-nil.
+	GetRating(uid uint64, mode string) (*PlayerRating, error)
+
+	// SaveRatings creates or replaces ratings by their uid and mode. All
+	// ratings are saved atomically.
+	SaveRatings(rs []PlayerRating) error
+
+	// DeleteRatings deletes all ratings for uid.
+	DeleteRatings(uid uint64) error
+}
+
+// MatchRecord is an end-of-match summary submitted by a game server.
+type MatchRecord struct {
+	// ID uniquely identifies the match. It is required.
+	ID string
+
+	// Time is when the match ended.
+	Time time.Time
+
+	// ServerID and ServerName identify the game server the match was played
+	// on.
+	ServerID   string
+	ServerName string
+
+	// Map and Mode are the map and playlist of the match.
+	Map  string
+	Mode string
+
+	// Duration is the length of the match, if known.
+	Duration time.Duration
+
+	// Players is the scoreboard.
+	Players []MatchPlayer
+}
+
+// MatchPlayer is a scoreboard entry in a MatchRecord.
+type MatchPlayer struct {
+	// UID is the player's UID. It is required.
+	UID uint64
+
+	// Username is the player's username at the time of the match.
+	Username string
+
+	// Team and Rank are the player's team (zero if none) and placement (lower
+	// is better).
+	Team int
+	Rank int
+
+	// Stats are optional scoreboard columns (e.g., kills, deaths).
+	Stats map[string]int64
+}
+
+// MatchHistoryStorage stores match summaries for player match history. It
+// must be safe for concurrent use.
+type MatchHistoryStorage interface {
+	// GetMatches gets up to limit (if positive) of the newest matches played
+	// by uid which ended before the provided time (or any time if zero),
+	// newest first. If there are none, a nil/zero-length slice is returned.
+	// If another error occurs, err is non-nil.
+	GetMatches(uid uint64, before time.Time, limit int) ([]MatchRecord, error)
+
+	// SaveMatch creates or replaces a match.
+	SaveMatch(m *MatchRecord) error
+
+	// DeleteMatchesBefore deletes matches which ended before t.
+	DeleteMatchesBefore(t time.Time) error
+
+	// DeletePlayerMatches removes uid from all matches.
+	DeletePlayerMatches(uid uint64) error
+}
+
+// MuteKind is the type of a player mute.
+type MuteKind string
+
+const (
+	MuteKindChat  MuteKind = "chat"  // text chat
+	MuteKindVoice MuteKind = "voice" // voice chat
+)
+
+// MuteReport is a mute applied to a player by a game server.
+type MuteReport struct {
+	// UID is the muted player. It is required.
+	UID uint64
+
+	// ServerAddr is the IP of the game server which muted the player. Reports
+	// are keyed by IP rather than server ID so a host only counts once, even
+	// if it re-registers or runs multiple servers.
+	ServerAddr netip.Addr
+
+	// Kind is the type of mute.
+	Kind MuteKind
+
+	// ServerName is the name of the game server at the time of the report.
+	ServerName string
+
+	// Reason is an optional free-form reason provided by the server.
+	Reason string
+
+	// Time is when the player was muted.
+	Time time.Time
+}
+
+// MuteReportStorage stores mutes reported by game servers. It must be safe
+// for concurrent use.
+type MuteReportStorage interface {
+	// GetMuteReports gets all reports for uid made at or after since, oldest
+	// first. If there are none, a nil/zero-length slice is returned. If
+	// another error occurs, err is non-nil.
+	GetMuteReports(uid uint64, since time.Time) ([]MuteReport, error)
+
+	// SaveMuteReport creates or replaces a report by its uid, server addr,
+	// and kind.
+	SaveMuteReport(r MuteReport) error
+
+	// DeleteMuteReport deletes the report for uid from addr of the provided
+	// kind, if it exists.
+	DeleteMuteReport(uid uint64, addr netip.Addr, kind MuteKind) error
+
+	// DeleteMuteReportsBefore deletes reports made before t.
+	DeleteMuteReportsBefore(t time.Time) error
+
+	// DeletePlayerMuteReports deletes all reports for uid.
+	DeletePlayerMuteReports(uid uint64) error
+}
+
+// FriendStatus is the state of a friend relationship from the perspective of
+// one of the players.
+type FriendStatus string
+
+const (
+	FriendStatusOutgoing FriendStatus = "outgoing" // request sent to the friend
+	FriendStatusIncoming FriendStatus = "incoming" // request received from the friend
+	FriendStatusAccepted FriendStatus = "accepted"
+)
+
+// Friend is one side of a friend relationship. Both sides are stored.
+type Friend struct {
+	// UID is the player the relationship belongs to.
+	UID uint64
+
+	// Friend is the other player.
+	Friend uint64
+
+	// Status is the state of the relationship for UID.
+	Status FriendStatus
+
+	// Created is when the request was sent.
+	Created time.Time
+
+	// Updated is when the status last changed.
+	Updated time.Time
+}
+
+// FriendStorage stores friend relationships. It must be safe for concurrent
+// use.
+type FriendStorage interface {
+	// GetFriends gets all relationships (including pending requests) for uid
+	// ordered by Friend. If there are none, a nil/zero-length slice is
+	// returned. If another error occurs, err is non-nil.
+	GetFriends(uid uint64) ([]Friend, error)
+
+	// GetFriend gets the relationship between uid and friend. If it doesn't
+	// exist, nil is returned. If another error occurs, err is non-nil.
+	GetFriend(uid, friend uint64) (*Friend, error)
+
+	// SaveFriends creates or replaces relationships by their UID and Friend.
+	// All relationships are saved atomically.
+	SaveFriends(fs []Friend) error
+
+	// DeleteFriend deletes the relationship between uid and friend (in both
+	// directions), if it exists.
+	DeleteFriend(uid, friend uint64) error
+
+	// DeleteFriends deletes all relationships involving uid (in both
+	// directions).
+	DeleteFriends(uid uint64) error
+}
+
+// Block is a player blocked by another player.
+type Block struct {
+	// UID is the player who blocked Blocked.
+	UID uint64
+
+	// Blocked is the blocked player.
+	Blocked uint64
+
+	// Created is when the player was blocked.
+	Created time.Time
+}
+
+// BlockStorage stores player block lists. It must be safe for concurrent use.
+type BlockStorage interface {
+	// GetBlocks gets the players blocked by uid ordered by Blocked. If there
+	// are none, a nil/zero-length slice is returned. If another error occurs,
+	// err is non-nil.
+	GetBlocks(uid uint64) ([]Block, error)
+
+	// GetBlockedBy gets the players who have blocked uid in ascending order.
+	// If there are none, a nil/zero-length slice is returned. If another error
+	// occurs, err is non-nil.
+	GetBlockedBy(uid uint64) ([]uint64, error)
+
+	// GetBlock gets whether uid has blocked blocked. If not, nil is returned.
+	// If another error occurs, err is non-nil.
+	GetBlock(uid, blocked uint64) (*Block, error)
+
+	// SaveBlock creates or replaces a block by its UID and Blocked.
+	SaveBlock(b Block) error
+
+	// DeleteBlock deletes the block of blocked by uid, if it exists.
+	DeleteBlock(uid, blocked uint64) error
+
+	// DeleteBlocks deletes all blocks involving uid (in both directions).
+	DeleteBlocks(uid uint64) error
+}
+
+// CrashFingerprint describes a game server crash.
+type CrashFingerprint struct {
+	// Exception is the exception or signal (e.g., EXCEPTION_ACCESS_VIOLATION).
+	Exception string
+
+	// Module is the module the crash occurred in (e.g., engine.dll).
+	Module string
+
+	// Stack contains the top stack frames, innermost first.
+	Stack []string
+}
+
+// CrashReport is a crash uploaded by a game server.
+type CrashReport struct {
+	// Signature identifies the crash. Reports with the same signature are
+	// aggregated. It is required.
+	Signature string
+
+	// Time is when the report was received.
+	Time time.Time
+
+	// Fingerprint describes the crash.
+	Fingerprint CrashFingerprint
+
+	// Version is the Northstar version the server was running.
+	Version string
+
+	// Mods contains the enabled mods as name@version.
+	Mods []string
+}
+
+// CrashSignature is the aggregate of all crash reports with a signature.
+type CrashSignature struct {
+	// Signature identifies the crash.
+	Signature string
+
+	// First and Last are the times of the first and last reports.
+	First time.Time
+	Last  time.Time
+
+	// Count is the number of reports.
+	Count int
+
+	// Fingerprint is the fingerprint from the last report.
+	Fingerprint CrashFingerprint
+
+	// Versions and Mods contain the number of reports by version and by mod.
+	Versions map[string]int
+	Mods     map[string]int
+}
+
+// CrashStorage stores aggregated game server crash reports. It must be safe
+// for concurrent use.
+type CrashStorage interface {
+	// RecordCrash adds r to the aggregate for its signature, creating it if
+	// it doesn't exist.
+	RecordCrash(r CrashReport) error
+
+	// GetCrashes gets up to limit (if positive) of the signatures last
+	// reported at or after since, ordered by descending count, then by
+	// descending last report time. If there are none, a nil/zero-length slice
+	// is returned. If another error occurs, err is non-nil.
+	GetCrashes(since time.Time, limit int) ([]CrashSignature, error)
+
+	// GetCrash gets the aggregate for signature. If it doesn't exist, nil is
+	// returned. If another error occurs, err is non-nil.
+	GetCrash(signature string) (*CrashSignature, error)
+
+	// DeleteCrash deletes the aggregate for signature, if it exists.
+	DeleteCrash(signature string) error
+
+	// DeleteCrashesBefore deletes signatures last reported before t.
+	DeleteCrashesBefore(t time.Time) error
 }
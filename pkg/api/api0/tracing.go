@@ -0,0 +1,149 @@
+package api0
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/cache"
+	"github.com/r2northstar/atlas/pkg/trace"
+)
+
+// The storage interfaces don't take a context, so the request handlers which
+// are worth tracing wrap them with the request context using these.
+
+// accountStorage gets AccountStorage, recording spans as children of r's span
+// if it is being traced.
+func (h *Handler) accountStorage(r *http.Request) AccountStorage {
+	if trace.FromContext(r.Context()).Recording() {
+		return tracedAccountStorage{h.AccountStorage, r.Context()}
+	}
+	return h.AccountStorage
+}
+
+// pdataStorage is like accountStorage, but for PdataStorage.
+func (h *Handler) pdataStorage(r *http.Request) PdataStorage {
+	if trace.FromContext(r.Context()).Recording() {
+		return tracedPdataStorage{h.PdataStorage, r.Context()}
+	}
+	return h.PdataStorage
+}
+
+// cache is like accountStorage, but for Cache.
+func (h *Handler) cache(r *http.Request) cache.Cache {
+	if h.Cache != nil && trace.FromContext(r.Context()).Recording() {
+		return tracedCache{h.Cache, r.Context()}
+	}
+	return h.Cache
+}
+
+func traceCall(ctx context.Context, name string, fn func() error) {
+	_, span := trace.Start(ctx, name)
+	err := fn()
+	span.SetError(err)
+	span.End()
+}
+
+type tracedAccountStorage struct {
+	s   AccountStorage
+	ctx context.Context
+}
+
+func (t tracedAccountStorage) GetUIDsByUsername(username string) (uids []uint64, err error) {
+	traceCall(t.ctx, "storage.GetUIDsByUsername", func() error {
+		uids, err = t.s.GetUIDsByUsername(username)
+		return err
+	})
+	return
+}
+
+func (t tracedAccountStorage) GetAccount(uid uint64) (a *Account, err error) {
+	traceCall(t.ctx, "storage.GetAccount", func() error {
+		a, err = t.s.GetAccount(uid)
+		return err
+	})
+	return
+}
+
+func (t tracedAccountStorage) SaveAccount(a *Account) (err error) {
+	traceCall(t.ctx, "storage.SaveAccount", func() error {
+		err = t.s.SaveAccount(a)
+		return err
+	})
+	return
+}
+
+func (t tracedAccountStorage) DeleteAccount(uid uint64) (err error) {
+	traceCall(t.ctx, "storage.DeleteAccount", func() error {
+		err = t.s.DeleteAccount(uid)
+		return err
+	})
+	return
+}
+
+type tracedPdataStorage struct {
+	s   PdataStorage
+	ctx context.Context
+}
+
+func (t tracedPdataStorage) GetPdataHash(uid uint64) (hash [sha256.Size]byte, exists bool, err error) {
+	traceCall(t.ctx, "storage.GetPdataHash", func() error {
+		hash, exists, err = t.s.GetPdataHash(uid)
+		return err
+	})
+	return
+}
+
+func (t tracedPdataStorage) GetPdataCached(uid uint64, sha [sha256.Size]byte) (buf []byte, exists bool, err error) {
+	traceCall(t.ctx, "storage.GetPdataCached", func() error {
+		buf, exists, err = t.s.GetPdataCached(uid, sha)
+		return err
+	})
+	return
+}
+
+func (t tracedPdataStorage) SetPdata(uid uint64, buf []byte) (n int, err error) {
+	traceCall(t.ctx, "storage.SetPdata", func() error {
+		n, err = t.s.SetPdata(uid, buf)
+		return err
+	})
+	return
+}
+
+func (t tracedPdataStorage) DeletePdata(uid uint64) (err error) {
+	traceCall(t.ctx, "storage.DeletePdata", func() error {
+		err = t.s.DeletePdata(uid)
+		return err
+	})
+	return
+}
+
+type tracedCache struct {
+	c   cache.Cache
+	ctx context.Context
+}
+
+func (t tracedCache) Get(key string) (buf []byte, exists bool, err error) {
+	traceCall(t.ctx, "cache.Get", func() error {
+		buf, exists, err = t.c.Get(key)
+		return err
+	})
+	return
+}
+
+func (t tracedCache) Set(key string, buf []byte, ttl time.Duration) (err error) {
+	traceCall(t.ctx, "cache.Set", func() error {
+		err = t.c.Set(key, buf, ttl)
+		return err
+	})
+	return
+}
+
+func (t tracedCache) Delete(key string) (err error) {
+	traceCall(t.ctx, "cache.Delete", func() error {
+		err = t.c.Delete(key)
+		return err
+	})
+	return
+}
@@ -0,0 +1,463 @@
+package api0
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/signkeys"
+	"github.com/rs/zerolog/hlog"
+)
+
+// maxTrustedServerApplications limits the size of the trusted server
+// application queue.
+const maxTrustedServerApplications = 500
+
+// TrustedServer is a known-good community server which launchers can badge.
+type TrustedServer struct {
+	// Addr is the game server address (ip:port), or ip to match all servers
+	// on that address.
+	Addr string `json:"addr" validate:"required"`
+
+	// Name is the name of the community or partner running the server.
+	Name string `json:"name" validate:"required,max=128"`
+
+	// Contact is optional contact information for the server owner.
+	Contact string `json:"contact,omitempty"`
+
+	// Note is an optional note for admins.
+	Note string `json:"note,omitempty"`
+
+	// TrustedAt is the time the server was added.
+	TrustedAt time.Time `json:"trusted_at"`
+}
+
+// Match checks if t matches the game server address addr.
+func (t TrustedServer) Match(addr netip.AddrPort) bool {
+	if a, err := netip.ParseAddrPort(t.Addr); err == nil {
+		return a == addr
+	}
+	if a, err := netip.ParseAddr(t.Addr); err == nil {
+		return a == addr.Addr()
+	}
+	return false
+}
+
+// TrustedServerApplication is a request from a server owner to become a trusted
+// server.
+type TrustedServerApplication struct {
+	Addr        string    `json:"addr"`
+	ServerName  string    `json:"server_name"`
+	Description string    `json:"description"`
+	Contact     string    `json:"contact"`
+	Message     string    `json:"message,omitempty"`
+	AppliedAt   time.Time `json:"applied_at"`
+}
+
+// validateTrustedServers checks if ts is a valid set of trusted servers.
+func validateTrustedServers(ts []TrustedServer) error {
+	addrs := map[string]struct{}{}
+	for i, t := range ts {
+		if err := validate(t); err != nil {
+			return fmt.Errorf("server %d: %w", i, err)
+		}
+		if _, err := netip.ParseAddrPort(t.Addr); err != nil {
+			if _, err := netip.ParseAddr(t.Addr); err != nil {
+				return fmt.Errorf("server %d: invalid addr %q", i, t.Addr)
+			}
+		}
+		if _, dup := addrs[t.Addr]; dup {
+			return fmt.Errorf("server %q: duplicate addr", t.Addr)
+		}
+		addrs[t.Addr] = struct{}{}
+	}
+	return nil
+}
+
+// signingKeys gets the keys used to sign server attestations.
+func (h *Handler) signingKeys() *signkeys.Set {
+	h.signingKeysInit.Do(func() {
+		if h.SigningKeys != nil {
+			h.signingKeysSet = h.SigningKeys
+			return
+		}
+		var (
+			k   signkeys.Key
+			err error
+		)
+		if h.ServerAttestationKey != nil {
+			k, err = signkeys.NewKey(h.ServerAttestationKey.Seed(), time.Time{})
+		} else {
+			k, err = signkeys.GenerateKey(time.Now())
+		}
+		if err != nil {
+			panic(err)
+		}
+		if h.signingKeysSet, err = signkeys.NewSet(k); err != nil {
+			panic(err)
+		}
+	})
+	return h.signingKeysSet
+}
+
+// signPayload signs the JSON object obj with the newest signing key, setting
+// kid to the key ID. The result is in the form base64url(json) + "." +
+// base64url(signature).
+func (h *Handler) signPayload(obj map[string]any) string {
+	k := h.signingKeys().Current()
+	obj["kid"] = k.ID
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf) + "." + base64.RawURLEncoding.EncodeToString(k.Sign(buf))
+}
+
+// serverAttestation creates a signed attestation for srv if it matches any of
+// ts, returning an empty string if it doesn't.
+//
+// The attestation is in the form base64url(json) + "." + base64url(signature),
+// where json is an object containing the server ID, the game server address,
+// the name of the community running it, and the signing key ID.
+func (h *Handler) serverAttestation(ts []TrustedServer, srv *Server) string {
+	for _, t := range ts {
+		if t.Match(srv.Addr) {
+			return h.signPayload(map[string]any{
+				"id":   srv.ID,
+				"addr": srv.Addr.String(),
+				"name": t.Name,
+			})
+		}
+	}
+	return ""
+}
+
+// updateServerAttestation updates the attestation for srv if required.
+func (h *Handler) updateServerAttestation(r *http.Request, srv *Server) {
+	ts, err := h.trustedServers.Get(h.StateStorage, "trustedservers")
+	if err != nil {
+		hlog.FromRequest(r).Warn().Err(err).Msg("failed to load trusted servers, not updating server attestation")
+		return
+	}
+	if att := h.serverAttestation(ts, srv); att != srv.Attestation {
+		h.ServerList.SetServerAttestation(srv.ID, att)
+	}
+}
+
+// updateServerAttestations updates the attestations for all live servers.
+func (h *Handler) updateServerAttestations(ts []TrustedServer) {
+	var ss []*Server
+	h.ServerList.GetLiveServers(func(s *Server) bool {
+		ss = append(ss, s)
+		return true
+	})
+	for _, s := range ss {
+		if att := h.serverAttestation(ts, s); att != s.Attestation {
+			h.ServerList.SetServerAttestation(s.ID, att)
+		}
+	}
+}
+
+func (h *Handler) handleClientServerAttestationKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().client_serverattestationkey_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	k := h.signingKeys().Current()
+
+	h.m().client_serverattestationkey_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":    true,
+		"algorithm":  "ed25519",
+		"public_key": base64.StdEncoding.EncodeToString(k.Public()),
+		"kid":        k.ID,
+	})
+}
+
+func (h *Handler) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().jwks_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// verifiers should refetch the key set when they see an unknown kid, so
+	// keep this short
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	h.m().jwks_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, h.signingKeys().JWKS(time.Now()))
+}
+
+func (h *Handler) handleServerApplyTrusted(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodPost {
+		h.m().server_applytrusted_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	raddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to parse remote ip %q", r.RemoteAddr)
+		h.m().server_applytrusted_requests_total.fail_other_error.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	q := r.URL.Query()
+
+	id := q.Get("id")
+	if id == "" {
+		h.m().server_applytrusted_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("id param is required"))
+		return
+	}
+
+	contact := q.Get("contact")
+	if contact == "" || len(contact) > 256 {
+		h.m().server_applytrusted_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("contact param is required and must be at most 256 bytes"))
+		return
+	}
+
+	message := q.Get("message")
+	if len(message) > 2048 {
+		h.m().server_applytrusted_requests_total.reject_bad_request.Inc()
+		respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("message param must be at most 2048 bytes"))
+		return
+	}
+
+	srv := h.ServerList.GetServerByID(id)
+	if srv == nil {
+		h.m().server_applytrusted_requests_total.reject_server_not_found.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObjf("no such game server"))
+		return
+	}
+	if srv.Addr.Addr() != raddr.Addr() {
+		h.m().server_applytrusted_requests_total.reject_unauthorized_ip.Inc()
+		respFail(w, r, http.StatusForbidden, ErrorCode_UNAUTHORIZED_GAMESERVER.MessageObj())
+		return
+	}
+
+	a := TrustedServerApplication{
+		Addr:        srv.Addr.String(),
+		ServerName:  srv.Name,
+		Description: srv.Description,
+		Contact:     contact,
+		Message:     message,
+		AppliedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+
+	var errFull bool
+	if err := h.trustedServerApplications.Update(h.StateStorage, "trustedserverapplications", func(as []TrustedServerApplication) ([]TrustedServerApplication, error) {
+		as = append([]TrustedServerApplication(nil), as...)
+		for i := range as {
+			if as[i].Addr == a.Addr {
+				as[i] = a
+				return as, nil
+			}
+		}
+		if len(as) >= maxTrustedServerApplications {
+			errFull = true
+			return nil, fmt.Errorf("application queue is full")
+		}
+		return append(as, a), nil
+	}); err != nil {
+		if errFull {
+			h.m().server_applytrusted_requests_total.reject_queue_full.Inc()
+			respFail(w, r, http.StatusServiceUnavailable, ErrorCode_INTERNAL_SERVER_ERROR.MessageObjf("too many pending applications, try again later"))
+			return
+		}
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save trusted server application to storage")
+		h.m().server_applytrusted_requests_total.fail_storage_error_state.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().server_applytrusted_requests_total.success.Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
+
+func (h *Handler) handleAdminTrustedServers(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "trustedservers"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	if r.Method == http.MethodHead || r.Method == http.MethodGet {
+		ts, err := h.trustedServers.Get(h.StateStorage, "trustedservers")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load trusted servers from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		as, err := h.trustedServerApplications.Get(h.StateStorage, "trustedserverapplications")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load trusted server applications from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if ts == nil {
+			ts = []TrustedServer{}
+		}
+		if as == nil {
+			as = []TrustedServerApplication{}
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success":      true,
+			"trusted":      ts,
+			"applications": as,
+		})
+		return
+	}
+
+	// the application is removed when it is accepted or rejected
+	var addr string
+	var fn func(ts []TrustedServer) ([]TrustedServer, error)
+	switch r.Method {
+	case http.MethodPost:
+		var t TrustedServer
+		if err := decodeJSON(r, &t); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		if t.TrustedAt.IsZero() {
+			t.TrustedAt = time.Now().UTC().Truncate(time.Second)
+		}
+		addr = t.Addr
+		fn = func(ts []TrustedServer) ([]TrustedServer, error) {
+			for i := range ts {
+				if ts[i].Addr == t.Addr {
+					ts[i] = t
+					return ts, nil
+				}
+			}
+			return append(ts, t), nil
+		}
+	case http.MethodDelete:
+		var q struct {
+			Addr string `param:"addr" validate:"required"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		addr = q.Addr
+		fn = func(ts []TrustedServer) ([]TrustedServer, error) {
+			for i := range ts {
+				if ts[i].Addr == addr {
+					return append(ts[:i], ts[i+1:]...), nil
+				}
+			}
+			return ts, nil
+		}
+	}
+
+	var errInvalid error
+	var nts []TrustedServer
+	if err := h.trustedServers.Update(h.StateStorage, "trustedservers", func(ts []TrustedServer) ([]TrustedServer, error) {
+		ts, err := fn(append([]TrustedServer(nil), ts...))
+		if err == nil {
+			if err = validateTrustedServers(ts); err != nil {
+				errInvalid = err
+			}
+		}
+		nts = ts
+		return ts, err
+	}); err != nil {
+		if errInvalid != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", errInvalid))
+			return
+		}
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save trusted servers to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	h.updateServerAttestations(nts)
+
+	if err := h.trustedServerApplications.Update(h.StateStorage, "trustedserverapplications", func(as []TrustedServerApplication) ([]TrustedServerApplication, error) {
+		for i := range as {
+			if as[i].Addr == addr {
+				return append(append([]TrustedServerApplication(nil), as[:i]...), as[i+1:]...), nil
+			}
+		}
+		return as, nil
+	}); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save trusted server applications to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success": true,
+	})
+}
@@ -0,0 +1,190 @@
+package api0
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrustedServerMatch(t *testing.T) {
+	addr := netip.MustParseAddrPort("192.0.2.1:37015")
+	for a, exp := range map[string]bool{
+		"192.0.2.1:37015": true,
+		"192.0.2.1":       true,
+		"192.0.2.1:37016": false,
+		"192.0.2.2":       false,
+		"192.0.2.0/24":    false,
+		"":                false,
+	} {
+		if v := (TrustedServer{Addr: a}).Match(addr); v != exp {
+			t.Errorf("Match(%q): expected %t, got %t", a, exp, v)
+		}
+	}
+}
+
+func TestValidateTrustedServers(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ts   []TrustedServer
+		ok   bool
+	}{
+		{"Empty", nil, true},
+		{"Valid", []TrustedServer{{Addr: "192.0.2.1:37015", Name: "a"}, {Addr: "192.0.2.1", Name: "b"}}, true},
+		{"NoName", []TrustedServer{{Addr: "192.0.2.1"}}, false},
+		{"LongName", []TrustedServer{{Addr: "192.0.2.1", Name: strings.Repeat("x", 129)}}, false},
+		{"NoAddr", []TrustedServer{{Name: "a"}}, false},
+		{"InvalidAddr", []TrustedServer{{Addr: "example.com:37015", Name: "a"}}, false},
+		{"Duplicate", []TrustedServer{{Addr: "192.0.2.1", Name: "a"}, {Addr: "192.0.2.1", Name: "b"}}, false},
+	} {
+		if err := validateTrustedServers(tc.ts); (err == nil) != tc.ok {
+			t.Errorf("%s: expected valid=%t, got error %v", tc.name, tc.ok, err)
+		}
+	}
+}
+
+func TestTrustedServers(t *testing.T) {
+	h := &Handler{
+		ServerList:   NewServerList(time.Minute, time.Minute*2, 0, ServerListConfig{}),
+		StateStorage: new(testStateStorage),
+		AdminSecret:  "secret",
+	}
+	srv, err := h.ServerList.ServerHybridUpdatePut(nil, &Server{
+		Addr:     netip.MustParseAddrPort("192.0.2.1:37015"),
+		AuthPort: 8081,
+		Name:     "test",
+	}, ServerListLimit{})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	do := func(fn http.HandlerFunc, method, remote, target, body string) (int, map[string]any) {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.RemoteAddr = remote
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		fn(w, r)
+		var obj map[string]any
+		json.Unmarshal(w.Body.Bytes(), &obj)
+		return w.Code, obj
+	}
+	apply := func(remote string, q url.Values) int {
+		st, _ := do(h.handleServerApplyTrusted, http.MethodPost, remote, "/server/apply_trusted?"+q.Encode(), "")
+		return st
+	}
+	admin := func(method, target, body string) (int, map[string]any) {
+		return do(h.handleAdminTrustedServers, method, "127.0.0.1:1234", target, body)
+	}
+	attestation := func() string {
+		return h.ServerList.GetServerByID(srv.ID).Attestation
+	}
+
+	// applications
+	for _, tc := range []struct {
+		name   string
+		remote string
+		q      url.Values
+		status int
+	}{
+		{"NoID", "192.0.2.1:1234", url.Values{"contact": {"a"}}, http.StatusBadRequest},
+		{"NoContact", "192.0.2.1:1234", url.Values{"id": {srv.ID}}, http.StatusBadRequest},
+		{"LongContact", "192.0.2.1:1234", url.Values{"id": {srv.ID}, "contact": {strings.Repeat("x", 257)}}, http.StatusBadRequest},
+		{"LongMessage", "192.0.2.1:1234", url.Values{"id": {srv.ID}, "contact": {"a"}, "message": {strings.Repeat("x", 2049)}}, http.StatusBadRequest},
+		{"UnknownServer", "192.0.2.1:1234", url.Values{"id": {"asd"}, "contact": {"a"}}, http.StatusForbidden},
+		{"WrongIP", "192.0.2.2:1234", url.Values{"id": {srv.ID}, "contact": {"a"}}, http.StatusForbidden},
+		{"Valid", "192.0.2.1:1234", url.Values{"id": {srv.ID}, "contact": {"a"}}, http.StatusOK},
+		{"Replace", "192.0.2.1:1234", url.Values{"id": {srv.ID}, "contact": {"b"}, "message": {"hello"}}, http.StatusOK},
+	} {
+		if st := apply(tc.remote, tc.q); st != tc.status {
+			t.Errorf("apply: %s: expected status %d, got %d", tc.name, tc.status, st)
+		}
+	}
+
+	if st, obj := admin(http.MethodGet, "/admin/trustedservers", ""); st != http.StatusOK {
+		t.Fatalf("list: unexpected status %d", st)
+	} else if as, _ := obj["applications"].([]any); len(as) != 1 {
+		t.Errorf("list: expected 1 application, got %v", obj["applications"])
+	} else if a, _ := as[0].(map[string]any); a["addr"] != "192.0.2.1:37015" || a["contact"] != "b" || a["message"] != "hello" || a["server_name"] != "test" {
+		t.Errorf("list: incorrect application %v", a)
+	}
+
+	// trusting the server
+	if st, _ := admin(http.MethodPost, "/admin/trustedservers", `{"addr":"192.0.2.1:37015"}`); st != http.StatusBadRequest {
+		t.Errorf("add: expected status 400 for missing name, got %d", st)
+	}
+	if st, _ := admin(http.MethodPost, "/admin/trustedservers", `{"addr":"asd","name":"Test Community"}`); st != http.StatusBadRequest {
+		t.Errorf("add: expected status 400 for invalid addr, got %d", st)
+	}
+	if att := attestation(); att != "" {
+		t.Errorf("expected no attestation before the server is trusted, got %q", att)
+	}
+	if st, _ := admin(http.MethodPost, "/admin/trustedservers", `{"addr":"192.0.2.1:37015","name":"Test Community"}`); st != http.StatusOK {
+		t.Fatalf("add: unexpected status %d", st)
+	}
+	if st, obj := admin(http.MethodGet, "/admin/trustedservers", ""); st != http.StatusOK {
+		t.Fatalf("list: unexpected status %d", st)
+	} else if ts, _ := obj["trusted"].([]any); len(ts) != 1 {
+		t.Errorf("list: expected 1 trusted server, got %v", obj["trusted"])
+	} else if as, _ := obj["applications"].([]any); len(as) != 0 {
+		t.Errorf("list: expected accepted application to be removed, got %v", as)
+	}
+
+	// verifying the attestation
+	att := attestation()
+	payload, sig, ok := strings.Cut(att, ".")
+	if !ok {
+		t.Fatalf("invalid attestation %q", att)
+	}
+	msg, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatalf("invalid attestation payload: %v", err)
+	}
+	sigb, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		t.Fatalf("invalid attestation signature: %v", err)
+	}
+	var obj struct {
+		ID   string `json:"id"`
+		Addr string `json:"addr"`
+		Name string `json:"name"`
+		Kid  string `json:"kid"`
+	}
+	if err := json.Unmarshal(msg, &obj); err != nil {
+		t.Fatalf("invalid attestation payload: %v", err)
+	}
+	if obj.ID != srv.ID || obj.Addr != "192.0.2.1:37015" || obj.Name != "Test Community" {
+		t.Errorf("incorrect attestation payload %+v", obj)
+	}
+
+	st, key := do(h.handleClientServerAttestationKey, http.MethodGet, "127.0.0.1:1234", "/client/server_attestation_key", "")
+	if st != http.StatusOK {
+		t.Fatalf("attestation key: unexpected status %d", st)
+	}
+	if key["kid"] != obj.Kid {
+		t.Errorf("attestation key: expected kid %q, got %v", obj.Kid, key["kid"])
+	}
+	pub, _ := base64.StdEncoding.DecodeString(key["public_key"].(string))
+	if len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, msg, sigb) {
+		t.Errorf("attestation signature is invalid")
+	}
+	if ed25519.Verify(pub, append(msg, ' '), sigb) {
+		t.Errorf("attestation signature is valid for a modified payload")
+	}
+
+	// untrusting the server
+	if st, _ := admin(http.MethodDelete, "/admin/trustedservers", ""); st != http.StatusBadRequest {
+		t.Errorf("delete: expected status 400 for missing addr, got %d", st)
+	}
+	if st, _ := admin(http.MethodDelete, "/admin/trustedservers?addr=192.0.2.1:37015", ""); st != http.StatusOK {
+		t.Fatalf("delete: unexpected status %d", st)
+	}
+	if att := attestation(); att != "" {
+		t.Errorf("expected attestation to be removed, got %q", att)
+	}
+}
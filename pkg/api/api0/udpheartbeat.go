@@ -0,0 +1,110 @@
+package api0
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/nspkt"
+)
+
+// UDPHeartbeat configures heartbeats over UDP (see nspkt.AtlasHeartbeat),
+// which are much cheaper than HTTP heartbeats since they don't require a
+// TCP/TLS connection. They are only available to game servers registered with
+// a signing key, which is used to sign them. Game servers fall back to HTTP
+// heartbeats if they don't receive an acknowledgement.
+type UDPHeartbeat struct {
+	// Enabled enables UDP heartbeats.
+	Enabled bool
+
+	// Port is the UDP port advertised to game servers for heartbeats. If
+	// zero, the local port of NSPkt is used.
+	Port uint16
+}
+
+// udpHeartbeatPort gets the port to advertise for UDP heartbeats, or zero if
+// they are not available.
+func (h *Handler) udpHeartbeatPort() uint16 {
+	if !h.UDPHeartbeat.Enabled {
+		return 0
+	}
+	if p := h.UDPHeartbeat.Port; p != 0 {
+		return p
+	}
+	if a, ok := h.NSPkt.LocalAddr().(*net.UDPAddr); ok {
+		return uint16(a.Port)
+	}
+	return 0
+}
+
+// HandleUDPHeartbeat handles a heartbeat from addr. It can be passed to
+// nspkt.Listener.HandleAtlasHeartbeats.
+func (h *Handler) HandleUDPHeartbeat(addr netip.AddrPort, hb *nspkt.AtlasHeartbeat) (nspkt.AtlasHeartbeatStatus, []byte) {
+	if !h.UDPHeartbeat.Enabled {
+		h.m().server_udpheartbeat_total.reject_disabled.Inc()
+		return nspkt.AtlasHeartbeatFallback, nil
+	}
+
+	srv := h.ServerList.GetServerByID(hb.ServerID)
+	if srv == nil {
+		h.m().server_udpheartbeat_total.reject_server_not_found.Inc()
+		return nspkt.AtlasHeartbeatNotFound, nil
+	}
+	if srv.SigningKey == "" {
+		h.m().server_udpheartbeat_total.reject_unsigned.Inc()
+		return nspkt.AtlasHeartbeatFallback, nil
+	}
+	key := []byte(srv.SigningKey)
+
+	now := time.Now()
+	if !hb.Verify(key) {
+		h.m().server_udpheartbeat_total.reject_invalid_signature.Inc()
+		return nspkt.AtlasHeartbeatInvalid, nil
+	}
+	if d := now.Sub(time.UnixMilli(hb.Timestamp)); d > serverSignatureWindow || d < -serverSignatureWindow {
+		h.m().server_udpheartbeat_total.reject_expired.Inc()
+		return nspkt.AtlasHeartbeatInvalid, nil
+	}
+	if !h.serverSignatureNonces.Consume("udp:"+hex.EncodeToString(hb.MAC()), now, serverSignatureWindow*2, serverSignatureMaxNonces) {
+		h.m().server_udpheartbeat_total.reject_replayed.Inc()
+		return nspkt.AtlasHeartbeatInvalid, nil
+	}
+
+	// the version gate may have changed since the server registered, so make
+	// it fall back to HTTP to get the error
+	if !h.checkLauncherVersion(nil, normalizeLauncherVersion(srv.LauncherVersion), false) {
+		h.m().server_udpheartbeat_total.reject_versiongate.Inc()
+		return nspkt.AtlasHeartbeatFallback, nil
+	}
+
+	u := &ServerUpdate{
+		ID:        srv.ID,
+		ExpectIP:  addr.Addr(),
+		Heartbeat: true,
+	}
+	if n := hb.PlayerCount; n >= 0 {
+		u.PlayerCount = &n
+	}
+	if n := hb.MaxPlayers; n >= 0 {
+		u.MaxPlayers = &n
+	}
+	nsrv, err := h.ServerList.ServerHybridUpdatePut(u, nil, h.serverListLimit())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrServerListUpdateWrongIP):
+			h.m().server_udpheartbeat_total.reject_unauthorized_ip.Inc()
+			return nspkt.AtlasHeartbeatInvalid, nil
+		case errors.Is(err, ErrServerListUpdateServerDead):
+			h.m().server_udpheartbeat_total.reject_server_not_found.Inc()
+			return nspkt.AtlasHeartbeatNotFound, nil
+		}
+		h.m().server_udpheartbeat_total.fail_serverlist_error.Inc()
+		return nspkt.AtlasHeartbeatFallback, nil
+	}
+	h.serverHistory.Heartbeat(nsrv.Addr, now.UTC())
+
+	h.m().server_udpheartbeat_total.success.Inc()
+	return nspkt.AtlasHeartbeatOK, key
+}
@@ -0,0 +1,272 @@
+package api0
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+	"golang.org/x/mod/semver"
+)
+
+// Release is a Northstar release listed in the launcher update manifest.
+type Release struct {
+	// Component is the component the release is for. Component and Channel
+	// uniquely identify the release.
+	Component string `json:"component" validate:"required,oneof=client|server"`
+
+	// Channel is the release channel (e.g., stable, beta).
+	Channel string `json:"channel" validate:"required,max=32"`
+
+	// Version is the launcher version.
+	Version string `json:"version" validate:"required,max=64"`
+
+	// URL is the HTTPS download URL.
+	URL string `json:"url" validate:"required,max=1024"`
+
+	// Mirrors are additional HTTPS download URLs. Mirrors failing health
+	// checks are omitted from the served manifest.
+	Mirrors []string `json:"mirrors,omitempty" validate:"max=8"`
+
+	// SHA256, if provided, is the hex-encoded SHA-256 of the download.
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Size, if provided, is the size of the download in bytes.
+	Size int64 `json:"size,omitempty" validate:"min=0"`
+
+	// NotesURL, if provided, links to the release notes.
+	NotesURL string `json:"notes_url,omitempty" validate:"max=1024"`
+
+	// Released is when the release was published.
+	Released time.Time `json:"released"`
+}
+
+// validateReleases checks if rs is a valid set of releases.
+func validateReleases(rs []Release) error {
+	type key struct{ component, channel string }
+	keys := map[key]struct{}{}
+	for i, x := range rs {
+		if err := validate(x); err != nil {
+			return fmt.Errorf("release %d: %w", i, err)
+		}
+		k := key{x.Component, x.Channel}
+		if _, dup := keys[k]; dup {
+			return fmt.Errorf("release %s/%s: duplicate component and channel", x.Component, x.Channel)
+		}
+		keys[k] = struct{}{}
+		if !semver.IsValid(normalizeLauncherVersion(x.Version)) {
+			return fmt.Errorf("release %s/%s: invalid version %q", x.Component, x.Channel, x.Version)
+		}
+		for _, v := range append([]string{x.URL, x.NotesURL}, x.Mirrors...) {
+			if v == "" {
+				continue
+			}
+			if u, err := url.Parse(v); err != nil || u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("release %s/%s: invalid url %q (must be https)", x.Component, x.Channel, v)
+			}
+		}
+		if x.SHA256 != "" {
+			if b, err := hex.DecodeString(x.SHA256); err != nil || len(b) != 32 {
+				return fmt.Errorf("release %s/%s: invalid sha256", x.Component, x.Channel)
+			}
+		}
+	}
+	return nil
+}
+
+// signUpdateManifest creates a signed update manifest for rs.
+//
+// The manifest is in the same form as server attestations (and signed with the
+// same key): base64url(json) + "." + base64url(signature), where json is an
+// object containing the type, the issue time, the releases, and the signing
+// key ID. Launchers should reject manifests older than ones they have already
+// seen.
+func (h *Handler) signUpdateManifest(rs []Release, t time.Time) string {
+	return h.signPayload(map[string]any{
+		"type":     "update_manifest",
+		"issued":   t.Unix(),
+		"releases": rs,
+	})
+}
+
+func (h *Handler) handleClientUpdateManifest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet {
+		h.m().client_updatemanifest_requests_total.http_method_not_allowed.Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// launchers poll this, so let proxies absorb most of it
+	w.Header().Set("Cache-Control", "public, max-age=300, stale-while-revalidate=300")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	rs, err := h.releases.Get(h.StateStorage, "releases")
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to load releases from storage")
+		h.m().client_updatemanifest_requests_total.fail_storage_error_state.Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	srs := make([]Release, len(rs))
+	for i, x := range rs {
+		srs[i] = h.serveRelease(x)
+	}
+	rs = srs
+
+	// the manifest is issued when the releases last changed so the response
+	// can be revalidated
+	buf, err := json.Marshal(rs)
+	if err != nil {
+		panic(err)
+	}
+	issued := h.conditional.Since("update_manifest", sha256.Sum256(buf), time.Now())
+
+	h.m().client_updatemanifest_requests_total.success.Inc()
+	h.respJSONConditional(w, r, map[string]any{
+		"success":  true,
+		"releases": rs,
+		"manifest": h.signUpdateManifest(rs, issued),
+	})
+}
+
+func (h *Handler) handleAdminReleases(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "releases"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PUT, POST, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		rs, err := h.releases.Get(h.StateStorage, "releases")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load releases from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		if rs == nil {
+			rs = []Release{}
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success":  true,
+			"releases": rs,
+		})
+		return
+	}
+
+	var fn func(rs []Release) ([]Release, error)
+	switch r.Method {
+	case http.MethodPut:
+		var rs []Release
+		if err := decodeJSON(r, &rs); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func([]Release) ([]Release, error) {
+			return rs, nil
+		}
+	case http.MethodPost:
+		var x Release
+		if err := decodeJSON(r, &x); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		if x.Released.IsZero() {
+			x.Released = time.Now().UTC().Truncate(time.Second)
+		}
+		fn = func(rs []Release) ([]Release, error) {
+			for i := range rs {
+				if rs[i].Component == x.Component && rs[i].Channel == x.Channel {
+					rs[i] = x
+					return rs, nil
+				}
+			}
+			return append(rs, x), nil
+		}
+	case http.MethodDelete:
+		var q struct {
+			Component string `param:"component" validate:"required"`
+			Channel   string `param:"channel" validate:"required"`
+		}
+		if err := decodeParams(r, &q); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respError(w, r, err)
+			return
+		}
+		fn = func(rs []Release) ([]Release, error) {
+			for i := range rs {
+				if rs[i].Component == q.Component && rs[i].Channel == q.Channel {
+					return append(rs[:i], rs[i+1:]...), nil
+				}
+			}
+			return rs, nil
+		}
+	}
+
+	var errInvalid error
+	var nrs []Release
+	if err := h.releases.Update(h.StateStorage, "releases", func(rs []Release) ([]Release, error) {
+		rs, err := fn(append([]Release(nil), rs...))
+		if err == nil {
+			if err = validateReleases(rs); err != nil {
+				errInvalid = err
+			}
+		}
+		nrs = rs
+		return rs, err
+	}); err != nil {
+		if errInvalid != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", errInvalid))
+			return
+		}
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save releases to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+	if nrs == nil {
+		nrs = []Release{}
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":  true,
+		"releases": nrs,
+	})
+}
@@ -0,0 +1,246 @@
+package api0
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// decodeParams decodes the query (and url-encoded form) parameters from r into
+// v, which must be a pointer to a struct, then validates it. Fields are mapped
+// using the param struct tag, and may be strings, bools, integers, or types
+// implementing encoding.TextUnmarshaler. Empty parameters are treated as
+// missing. The returned error is a StatusError for a bad request.
+func decodeParams(r *http.Request, v any) error {
+	if err := r.ParseForm(); err != nil {
+		return badRequest("invalid form: %v", err)
+	}
+	rv := reflect.ValueOf(v).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		name := rt.Field(i).Tag.Get("param")
+		if name == "" {
+			continue
+		}
+		s := r.Form.Get(name)
+		if s == "" {
+			continue
+		}
+		if err := decodeParam(rv.Field(i), s); err != nil {
+			return badRequest("%s param is invalid: %v", name, err)
+		}
+	}
+	if err := validateStruct(rv, "", true); err != nil {
+		return badRequest("%v", err)
+	}
+	return nil
+}
+
+func decodeParam(f reflect.Value, s string) error {
+	if u, ok := f.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("expected boolean")
+		}
+		f.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected integer")
+		}
+		f.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected unsigned integer")
+		}
+		f.SetUint(v)
+	default:
+		panic("decode params: unsupported field type " + f.Type().String())
+	}
+	return nil
+}
+
+// decodeJSON decodes a JSON request body (limited to 1 MiB) into v, then
+// validates it. The returned error is a StatusError for a bad request.
+func decodeJSON(r *http.Request, v any) error {
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(v); err != nil {
+		return badRequest("invalid json: %v", err)
+	}
+	if err := validate(v); err != nil {
+		return badRequest("%v", err)
+	}
+	return nil
+}
+
+// validate checks v (a struct, or a slice or pointer to one) against the rules
+// in the validate struct tags of its fields, returning an error for the first
+// failure. Nested structs, slices, and maps are validated recursively.
+//
+// Rules are comma-separated, and may be:
+//   - required: the value must not be the zero value
+//   - min=N, max=N: the range of a number, or of the length of a string,
+//     slice, or map
+//   - oneof=a|b|c: the value must be one of the listed strings or numbers
+//
+// Fields are named in errors by their json tag.
+func validate(v any) error {
+	return validateValue(reflect.ValueOf(v), "")
+}
+
+func validateValue(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return validateValue(v.Elem(), path)
+	case reflect.Struct:
+		return validateStruct(v, path, false)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		it := v.MapRange()
+		for it.Next() {
+			if err := validateValue(it.Value(), path+"["+fmt.Sprint(it.Key().Interface())+"]"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateStruct(v reflect.Value, path string, params bool) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() && !(sf.Anonymous && !params) {
+			continue // like encoding/json, unexported embedded structs are still flattened
+		}
+		f := v.Field(i)
+
+		var name string
+		if params {
+			if name = sf.Tag.Get("param"); name == "" {
+				continue
+			}
+			name += " param"
+		} else {
+			if name, _, _ = strings.Cut(sf.Tag.Get("json"), ","); name == "-" {
+				continue
+			}
+			if sf.Anonymous && name == "" {
+				// embedded struct fields are flattened
+				if err := validateValue(f, path); err != nil {
+					return err
+				}
+				continue
+			}
+			if name == "" {
+				name = sf.Name
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+		}
+
+		if tag := sf.Tag.Get("validate"); tag != "" {
+			if err := validateRules(f, tag); err != nil {
+				return fmt.Errorf("%s %w", name, err)
+			}
+		}
+		if !params {
+			if err := validateValue(f, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateRules(f reflect.Value, tag string) error {
+	for _, rule := range strings.Split(tag, ",") {
+		k, arg, _ := strings.Cut(rule, "=")
+		switch k {
+		case "required":
+			if f.IsZero() {
+				return fmt.Errorf("is required")
+			}
+		case "min", "max":
+			if f.IsZero() {
+				continue // use required to check for presence
+			}
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic("validate: invalid " + k + " rule " + strconv.Quote(arg))
+			}
+			x, unit := validateMeasure(f)
+			switch {
+			case k == "min" && x < n:
+				return fmt.Errorf("must be at least %s%s", arg, unit)
+			case k == "max" && x > n:
+				return fmt.Errorf("must be at most %s%s", arg, unit)
+			}
+		case "oneof":
+			if f.IsZero() {
+				continue
+			}
+			var ok bool
+			s := fmt.Sprint(f.Interface())
+			for _, x := range strings.Split(arg, "|") {
+				if x == s {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				return fmt.Errorf("must be one of %s", strings.ReplaceAll(arg, "|", ", "))
+			}
+		default:
+			panic("validate: unknown rule " + strconv.Quote(k))
+		}
+	}
+	return nil
+}
+
+// validateMeasure returns the numeric value of f for range checks, and the
+// unit to use in error messages if it is a length.
+func validateMeasure(f reflect.Value) (float64, string) {
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(f.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(f.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return f.Float(), ""
+	case reflect.String:
+		return float64(f.Len()), " bytes long"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(f.Len()), " items long"
+	default:
+		panic("validate: unsupported field type " + f.Type().String())
+	}
+}
+
+// badRequest returns a StatusError for a BAD_REQUEST response.
+func badRequest(format string, a ...any) error {
+	return StatusError{
+		Status: http.StatusBadRequest,
+		Obj:    ErrorCode_BAD_REQUEST.MessageObjf(format, a...),
+	}
+}
@@ -0,0 +1,171 @@
+package api0
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	type item struct {
+		Name string `json:"name" validate:"required,max=4"`
+	}
+	type embedded struct {
+		Kind string `json:"kind" validate:"oneof=a|b"`
+	}
+	type obj struct {
+		embedded
+		ID      string          `json:"id,omitempty" validate:"required"`
+		Count   int             `json:"count" validate:"min=1,max=10"`
+		Ratio   float64         `json:"ratio" validate:"max=1"`
+		Level   uint            `json:"level" validate:"oneof=1|2|3"`
+		Tags    []string        `json:"tags" validate:"max=2"`
+		Items   []item          `json:"items"`
+		ByName  map[string]item `json:"by_name"`
+		Ptr     *item           `json:"ptr"`
+		NoJSON  string          `validate:"max=1"`
+		Ignored string          `json:"-" validate:"required"`
+		private string
+	}
+	valid := func() obj {
+		return obj{ID: "x", Count: 1}
+	}
+
+	for _, tc := range []struct {
+		name string
+		fn   func(o *obj)
+		err  string
+	}{
+		{"Valid", func(o *obj) {}, ""},
+		{"ValidFull", func(o *obj) {
+			o.Kind, o.Count, o.Ratio, o.Level, o.Tags = "a", 10, 1, 3, []string{"a", "b"}
+			o.Items = []item{{"a"}}
+			o.ByName = map[string]item{"a": {"a"}}
+			o.Ptr = &item{"abcd"}
+		}, ""},
+		{"Required", func(o *obj) { o.ID = "" }, "id is required"},
+		{"Min", func(o *obj) { o.Count = -1 }, "count must be at least 1"},
+		{"Max", func(o *obj) { o.Count = 11 }, "count must be at most 10"},
+		{"MaxFloat", func(o *obj) { o.Ratio = 1.5 }, "ratio must be at most 1"},
+		{"MinZero", func(o *obj) { o.Count = 0 }, ""},
+		{"OneOf", func(o *obj) { o.Level = 4 }, "level must be one of 1, 2, 3"},
+		{"OneOfEmbedded", func(o *obj) { o.Kind = "c" }, "kind must be one of a, b"},
+		{"MaxLen", func(o *obj) { o.Tags = []string{"a", "b", "c"} }, "tags must be at most 2 items long"},
+		{"Slice", func(o *obj) { o.Items = []item{{"a"}, {}} }, "items[1].name is required"},
+		{"SliceMax", func(o *obj) { o.Items = []item{{"abcde"}} }, "items[0].name must be at most 4 bytes long"},
+		{"Map", func(o *obj) { o.ByName = map[string]item{"x": {}} }, "by_name[x].name is required"},
+		{"Pointer", func(o *obj) { o.Ptr = &item{} }, "ptr.name is required"},
+		{"FieldName", func(o *obj) { o.NoJSON = "ab" }, "NoJSON must be at most 1 bytes long"},
+	} {
+		o := valid()
+		tc.fn(&o)
+		for _, v := range []any{o, &o, []obj{o}} {
+			err := validate(v)
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("%s: unexpected error: %v", tc.name, err)
+				}
+			} else if err == nil || !strings.HasSuffix(err.Error(), tc.err) {
+				t.Errorf("%s: expected error %q, got %v", tc.name, tc.err, err)
+			}
+		}
+	}
+	if err := validate((*obj)(nil)); err != nil {
+		t.Errorf("nil: unexpected error: %v", err)
+	}
+}
+
+func TestDecodeParams(t *testing.T) {
+	type params struct {
+		ID      uint64     `param:"id" validate:"required"`
+		Name    string     `param:"name" validate:"max=4"`
+		Enabled bool       `param:"enabled"`
+		Offset  int        `param:"offset" validate:"min=-5"`
+		Tiny    int8       `param:"tiny"`
+		Addr    netip.Addr `param:"addr"`
+		Mode    string     `param:"mode" validate:"oneof=a|b"`
+		Other   string
+	}
+	for _, tc := range []struct {
+		name   string
+		method string
+		target string
+		body   string
+		err    string
+		exp    params
+	}{
+		{name: "Valid", target: "/?id=1&name=test&enabled=true&offset=-5&tiny=127&addr=192.0.2.1&mode=b&Other=x", exp: params{1, "test", true, -5, 127, netip.MustParseAddr("192.0.2.1"), "b", ""}},
+		{name: "Form", method: http.MethodPost, target: "/?id=1", body: "name=abc", exp: params{ID: 1, Name: "abc"}},
+		{name: "EmptyIsMissing", target: "/?id=1&name=&enabled=", exp: params{ID: 1}},
+		{name: "Required", target: "/?name=test", err: "id param is required"},
+		{name: "RequiredZero", target: "/?id=0", err: "id param is required"},
+		{name: "Uint", target: "/?id=-1", err: "id param is invalid: expected unsigned integer"},
+		{name: "Int", target: "/?id=1&offset=x", err: "offset param is invalid: expected integer"},
+		{name: "IntOverflow", target: "/?id=1&tiny=128", err: "tiny param is invalid: expected integer"},
+		{name: "Bool", target: "/?id=1&enabled=x", err: "enabled param is invalid: expected boolean"},
+		{name: "TextUnmarshaler", target: "/?id=1&addr=x", err: "addr param is invalid"},
+		{name: "Max", target: "/?id=1&name=abcde", err: "name param must be at most 4 bytes long"},
+		{name: "Min", target: "/?id=1&offset=-6", err: "offset param must be at least -5"},
+		{name: "OneOf", target: "/?id=1&mode=c", err: "mode param must be one of a, b"},
+	} {
+		method := tc.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		r := httptest.NewRequest(method, tc.target, strings.NewReader(tc.body))
+		if tc.body != "" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		var p params
+		err := decodeParams(r, &p)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			} else if p != tc.exp {
+				t.Errorf("%s: expected %+v, got %+v", tc.name, tc.exp, p)
+			}
+			continue
+		}
+		var se StatusError
+		if !errors.As(err, &se) || se.Status != http.StatusBadRequest || se.Obj.Code != ErrorCode_BAD_REQUEST {
+			t.Errorf("%s: expected bad request error, got %v", tc.name, err)
+		} else if !strings.Contains(se.Obj.Message, tc.err) {
+			t.Errorf("%s: expected error %q, got %q", tc.name, tc.err, se.Obj.Message)
+		}
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	type body struct {
+		Name string `json:"name" validate:"required"`
+	}
+	for _, tc := range []struct {
+		body string
+		err  string
+	}{
+		{`{"name":"x"}`, ""},
+		{`{"name":"x","other":1}`, ""},
+		{`{}`, "name is required"},
+		{`{"name":1}`, "invalid json"},
+		{`[`, "invalid json"},
+		{`{"name":"` + strings.Repeat("x", 1<<20) + `"}`, "invalid json"},
+	} {
+		var b body
+		err := decodeJSON(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body)), &b)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%.32s: unexpected error: %v", tc.body, err)
+			}
+			continue
+		}
+		var se StatusError
+		if !errors.As(err, &se) || se.Status != http.StatusBadRequest {
+			t.Errorf("%.32s: expected bad request error, got %v", tc.body, err)
+		} else if !strings.Contains(se.Obj.Message, tc.err) {
+			t.Errorf("%.32s: expected error %q, got %q", tc.body, tc.err, se.Obj.Message)
+		}
+	}
+}
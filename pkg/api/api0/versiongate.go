@@ -0,0 +1,210 @@
+package api0
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/hlog"
+	"golang.org/x/mod/semver"
+)
+
+// VersionGate contains launcher version restrictions. It can be set via the
+// admin API to override the configured restrictions at runtime (e.g., to
+// quickly fence off a critically vulnerable version).
+type VersionGate struct {
+	// MinimumClient and MinimumServer, if non-empty, override the minimum
+	// launcher versions.
+	MinimumClient string `json:"minimum_client,omitempty"`
+	MinimumServer string `json:"minimum_server,omitempty"`
+
+	// Blocked contains additional blocked versions (see
+	// Handler.BlockedLauncherVersions).
+	Blocked []string `json:"blocked,omitempty"`
+
+	// UpdateURL, if non-empty, overrides the launcher download URL.
+	UpdateURL string `json:"update_url,omitempty"`
+
+	// Message, if non-empty, overrides the error message returned to blocked
+	// clients.
+	Message string `json:"message,omitempty"`
+}
+
+// Validate checks if all versions and patterns in g are valid.
+func (g VersionGate) Validate() error {
+	for _, v := range []string{g.MinimumClient, g.MinimumServer} {
+		if v != "" && !semver.IsValid(normalizeLauncherVersion(v)) {
+			return fmt.Errorf("invalid minimum version %q", v)
+		}
+	}
+	for _, v := range g.Blocked {
+		if !semver.IsValid(normalizeLauncherVersion(v)) || semver.Build(normalizeLauncherVersion(v)) != "" {
+			return fmt.Errorf("invalid blocked version %q", v)
+		}
+	}
+	return nil
+}
+
+// versionGate gets the effective version restrictions.
+func (h *Handler) versionGate(r *http.Request) VersionGate {
+	c := h.cfg()
+	g := VersionGate{
+		MinimumClient: c.MinimumLauncherVersionClient,
+		MinimumServer: c.MinimumLauncherVersionServer,
+		Blocked:       c.BlockedLauncherVersions,
+		UpdateURL:     c.LauncherUpdateURL,
+	}
+	o, err := h.versionGateOverride.Get(h.StateStorage, "versiongate")
+	if err != nil {
+		if r != nil {
+			hlog.FromRequest(r).Warn().Err(err).Msg("failed to load version gate override, using configured restrictions")
+		}
+		return g
+	}
+	if o.MinimumClient != "" {
+		g.MinimumClient = o.MinimumClient
+	}
+	if o.MinimumServer != "" {
+		g.MinimumServer = o.MinimumServer
+	}
+	if len(o.Blocked) != 0 {
+		g.Blocked = append(append([]string(nil), g.Blocked...), o.Blocked...)
+	}
+	if o.UpdateURL != "" {
+		g.UpdateURL = o.UpdateURL
+	}
+	if o.Message != "" {
+		g.Message = o.Message
+	}
+	return g
+}
+
+// normalizeLauncherVersion adds the v prefix required by semver to v if it
+// isn't already there.
+func normalizeLauncherVersion(v string) string {
+	if v != "" && v[0] != 'v' {
+		return "v" + v
+	}
+	return v
+}
+
+// matchLauncherVersion checks if the valid semver v matches pattern, which
+// may be a full version, or a major.minor or major version to match all
+// versions with that prefix.
+func matchLauncherVersion(v, pattern string) bool {
+	pattern = normalizeLauncherVersion(pattern)
+	switch strings.Count(pattern, ".") {
+	case 0:
+		return semver.Major(v) == pattern
+	case 1:
+		return semver.MajorMinor(v) == pattern
+	default:
+		return semver.Compare(v, pattern) == 0
+	}
+}
+
+// respUpdateRequired writes an UNSUPPORTED_VERSION error response with
+// information about the required update.
+func (h *Handler) respUpdateRequired(w http.ResponseWriter, r *http.Request, client bool) {
+	g := h.versionGate(r)
+
+	obj := ErrorCode_UNSUPPORTED_VERSION.MessageObj()
+	if g.Message != "" {
+		obj = ErrorCode_UNSUPPORTED_VERSION.MessageObjf("%s", g.Message)
+	}
+
+	mver := g.MinimumServer
+	if client {
+		mver = g.MinimumClient
+	}
+
+	update := map[string]any{
+		"required": true,
+	}
+	if mver = normalizeLauncherVersion(mver); semver.IsValid(mver) {
+		update["minimum_version"] = mver[1:]
+	}
+	if g.UpdateURL != "" {
+		update["url"] = g.UpdateURL
+	}
+	respFailExtra(w, r, http.StatusBadRequest, obj, map[string]any{
+		"update": update,
+	})
+}
+
+func (h *Handler) handleAdminVersionGate(w http.ResponseWriter, r *http.Request) {
+	const endpoint = "versiongate"
+
+	if r.Method != http.MethodOptions && r.Method != http.MethodHead && r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		h.m().admin_requests_total.http_method_not_allowed(endpoint).Inc()
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache, no-store, max-age=0, must-revalidate")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PUT, DELETE")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.checkAdmin(w, r, endpoint) {
+		return
+	}
+
+	var g VersionGate
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		o, err := h.versionGateOverride.Get(h.StateStorage, "versiongate")
+		if err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msgf("failed to load version gate from storage")
+			h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+			respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+			return
+		}
+		h.m().admin_requests_total.success(endpoint).Inc()
+		respJSON(w, r, http.StatusOK, map[string]any{
+			"success":   true,
+			"override":  o,
+			"effective": h.versionGate(r),
+		})
+		return
+	case http.MethodPut:
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&g); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("invalid json: %v", err))
+			return
+		}
+		if err := g.Validate(); err != nil {
+			h.m().admin_requests_total.reject_bad_request(endpoint).Inc()
+			respFail(w, r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", err))
+			return
+		}
+	case http.MethodDelete:
+		// reset to the configured restrictions
+	}
+
+	if err := h.versionGateOverride.Update(h.StateStorage, "versiongate", func(VersionGate) (VersionGate, error) {
+		return g, nil
+	}); err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msgf("failed to save version gate to storage")
+		h.m().admin_requests_total.fail_storage_error_state(endpoint).Inc()
+		respFail(w, r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj())
+		return
+	}
+
+	h.m().admin_requests_total.success(endpoint).Inc()
+	respJSON(w, r, http.StatusOK, map[string]any{
+		"success":   true,
+		"effective": h.versionGate(r),
+	})
+}
@@ -0,0 +1,167 @@
+package api0
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// testStateStorage is a minimal in-memory StateStorage.
+type testStateStorage struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func (s *testStateStorage) GetState(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf, ok := s.m[key]
+	return buf, ok, nil
+}
+
+func (s *testStateStorage) SetState(key string, buf []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = map[string][]byte{}
+	}
+	if buf == nil {
+		delete(s.m, key)
+	} else {
+		s.m[key] = buf
+	}
+	return nil
+}
+
+func TestCheckLauncherVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		client   string
+		server   string
+		blocked  []string
+		override *VersionGate
+		ua       string
+		isClient bool
+		ok       bool
+	}{
+		{name: "NoRestrictions", ua: "R2Northstar/1.0.0", isClient: true, ok: true},
+		{name: "NoRestrictionsInvalid", ua: "R2Northstar/asd", isClient: true, ok: true},
+		{name: "NoRestrictionsNotNorthstar", ua: "Mozilla/5.0", isClient: true},
+		{name: "NoRestrictionsEmpty", ua: "", isClient: true},
+
+		{name: "ClientNewer", client: "1.10.0", ua: "R2Northstar/1.11.0", isClient: true, ok: true},
+		{name: "ClientEqual", client: "1.10.0", ua: "R2Northstar/1.10.0", isClient: true, ok: true},
+		{name: "ClientOlder", client: "1.10.0", ua: "R2Northstar/1.9.9", isClient: true},
+		{name: "ClientPrerelease", client: "1.10.0", ua: "R2Northstar/1.10.0-rc1", isClient: true},
+		{name: "ClientVPrefix", client: "v1.10.0", ua: "R2Northstar/v1.10.0", isClient: true, ok: true},
+		{name: "ClientWithSuffix", client: "1.10.0", ua: "R2Northstar/1.10.0 (something)", isClient: true, ok: true},
+		{name: "ClientDev", client: "1.10.0", ua: "R2Northstar/0.0.0+dev", isClient: true, ok: true},
+		{name: "ClientInvalidMinimum", client: "asd", ua: "R2Northstar/1.0.0", isClient: true, ok: true},
+
+		{name: "ServerThresholdForClient", server: "1.10.0", ua: "R2Northstar/1.9.0", isClient: true, ok: true},
+		{name: "ClientThresholdForServer", client: "1.10.0", ua: "R2Northstar/1.9.0", ok: true},
+		{name: "ServerOlder", client: "1.5.0", server: "1.10.0", ua: "R2Northstar/1.9.0"},
+		{name: "ServerNewer", client: "1.20.0", server: "1.10.0", ua: "R2Northstar/1.11.0", ok: true},
+
+		{name: "MalformedVersion", client: "1.10.0", ua: "R2Northstar/1.x", isClient: true},
+		{name: "MalformedEmptyVersion", client: "1.10.0", ua: "R2Northstar/", isClient: true},
+		{name: "MalformedGarbage", client: "1.10.0", ua: "R2Northstar/\x00\xff", isClient: true},
+		{name: "MalformedPrefix", client: "1.10.0", ua: "r2northstar/1.11.0", isClient: true},
+		{name: "MalformedNotNorthstar", client: "1.10.0", ua: "curl/8.0.0", isClient: true},
+		{name: "MalformedLeadingSpace", client: "1.10.0", ua: " R2Northstar/1.11.0", isClient: true},
+		{name: "MalformedEmpty", client: "1.10.0", ua: "", isClient: true},
+		{name: "MalformedBlocked", blocked: []string{"1.10.0"}, ua: "R2Northstar/asd", isClient: true},
+
+		{name: "BlockedExact", blocked: []string{"1.10.1"}, ua: "R2Northstar/1.10.1", isClient: true},
+		{name: "BlockedExactOther", blocked: []string{"1.10.1"}, ua: "R2Northstar/1.10.2", isClient: true, ok: true},
+		{name: "BlockedMinor", blocked: []string{"1.10"}, ua: "R2Northstar/1.10.5", isClient: true},
+		{name: "BlockedMinorOther", blocked: []string{"1.10"}, ua: "R2Northstar/1.11.0", isClient: true, ok: true},
+		{name: "BlockedMajor", blocked: []string{"v1"}, ua: "R2Northstar/1.30.0"},
+		{name: "BlockedDev", blocked: []string{"0.0.0"}, ua: "R2Northstar/0.0.0+dev", ok: true},
+
+		{name: "OverrideMinimum", client: "1.10.0", override: &VersionGate{MinimumClient: "1.12.0"}, ua: "R2Northstar/1.11.0", isClient: true},
+		{name: "OverrideMinimumLower", client: "1.10.0", override: &VersionGate{MinimumClient: "1.5.0"}, ua: "R2Northstar/1.6.0", isClient: true, ok: true},
+		{name: "OverrideMinimumOtherSide", client: "1.10.0", override: &VersionGate{MinimumServer: "1.12.0"}, ua: "R2Northstar/1.11.0", isClient: true, ok: true},
+		{name: "OverrideBlocked", blocked: []string{"1.9"}, override: &VersionGate{Blocked: []string{"1.11.0"}}, ua: "R2Northstar/1.11.0", isClient: true},
+		{name: "OverrideBlockedKeepsConfigured", blocked: []string{"1.9"}, override: &VersionGate{Blocked: []string{"1.11.0"}}, ua: "R2Northstar/1.9.1", isClient: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				MinimumLauncherVersionClient: tc.client,
+				MinimumLauncherVersionServer: tc.server,
+				BlockedLauncherVersions:      tc.blocked,
+				StateStorage:                 new(testStateStorage),
+			}
+			if tc.override != nil {
+				if err := h.versionGateOverride.Update(h.StateStorage, "versiongate", func(VersionGate) (VersionGate, error) {
+					return *tc.override, nil
+				}); err != nil {
+					t.Fatalf("set override: %v", err)
+				}
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("User-Agent", tc.ua)
+			if ok := h.CheckLauncherVersion(r, tc.isClient); ok != tc.ok {
+				t.Errorf("CheckLauncherVersion(%q, client=%t): expected %t, got %t", tc.ua, tc.isClient, tc.ok, ok)
+			}
+		})
+	}
+}
+
+func TestVersionGateReconfigure(t *testing.T) {
+	h := &Handler{
+		MinimumLauncherVersionClient: "1.10.0",
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("User-Agent", "R2Northstar/1.10.0")
+	if !h.CheckLauncherVersion(r, true) {
+		t.Fatalf("expected version to be allowed")
+	}
+	h.Reconfigure(ReloadableConfig{
+		MinimumLauncherVersionClient: "1.11.0",
+	})
+	if h.CheckLauncherVersion(r, true) {
+		t.Errorf("expected version to be rejected after reconfigure")
+	}
+}
+
+func TestVersionGateValidate(t *testing.T) {
+	for _, tc := range []struct {
+		g  VersionGate
+		ok bool
+	}{
+		{VersionGate{}, true},
+		{VersionGate{MinimumClient: "1.10.0", MinimumServer: "v1.9.0"}, true},
+		{VersionGate{MinimumClient: "1.10"}, true},
+		{VersionGate{MinimumClient: "asd"}, false},
+		{VersionGate{MinimumServer: "1.x"}, false},
+		{VersionGate{Blocked: []string{"1", "1.10", "1.10.1", "v1.10.1-rc1"}}, true},
+		{VersionGate{Blocked: []string{"1.10.1+dev"}}, false},
+		{VersionGate{Blocked: []string{""}}, false},
+	} {
+		if err := tc.g.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%+v: expected valid=%t, got error %v", tc.g, tc.ok, err)
+		}
+	}
+}
+
+func TestExtractLauncherVersion(t *testing.T) {
+	h := &Handler{}
+	for ua, exp := range map[string]string{
+		"":                          "",
+		"R2Northstar/1.10.0":        "1.10.0",
+		"R2Northstar/v1.10.0":       "1.10.0",
+		"R2Northstar/1.10.0 (test)": "1.10.0",
+		"R2Northstar/0.0.0+dev":     "0.0.0+dev",
+		"R2Northstar/1.x":           "",
+		"R2Northstar/":              "",
+		"curl/8.0.0":                "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("User-Agent", ua)
+		if v := h.ExtractLauncherVersion(r); v != exp {
+			t.Errorf("ExtractLauncherVersion(%q): expected %q, got %q", ua, exp, v)
+		}
+	}
+}
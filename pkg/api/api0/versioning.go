@@ -0,0 +1,362 @@
+package api0
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/r2northstar/atlas/pkg/websocket"
+	"github.com/rs/zerolog/hlog"
+)
+
+// apiVersion is the version of the API schema a request was made for.
+//
+// Unversioned requests (i.e., the paths used by NorthstarLauncher) and ones
+// prefixed by /v1 are served by the handlers as-is. Requests prefixed by /v2
+// are served by the same handlers, but JSON responses are rewritten by
+// v2ResponseWriter to use the v2 schema:
+//   - object keys are consistently snake_case
+//   - the "success" field is removed (the status code indicates it)
+//   - errors (including plain-text ones) are returned as
+//     {"error":{"code","message","status","retryable"},"request_id"}
+//   - top-level arrays are paginated with the limit and cursor query
+//     parameters as {"items","total","next_cursor"}
+type apiVersion int
+
+const (
+	apiVersionUnversioned apiVersion = iota
+	apiVersionV1
+	apiVersionV2
+)
+
+// v2PageLimit is the maximum number of items in a v2 page.
+const v2PageLimit = 1000
+
+// stripAPIVersion returns a shallow copy of r with the version prefix removed
+// from the path, if any.
+func stripAPIVersion(r *http.Request) (*http.Request, apiVersion) {
+	var ver apiVersion
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/"):
+		ver = apiVersionV1
+	case strings.HasPrefix(r.URL.Path, "/v2/"):
+		ver = apiVersionV2
+	default:
+		return r, apiVersionUnversioned
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = UnversionedPath(r.URL.Path)
+	r2.URL.RawPath = ""
+	return r2, ver
+}
+
+// UnversionedPath removes the API version prefix, if any, from an API path.
+func UnversionedPath(p string) string {
+	if strings.HasPrefix(p, "/v1/") || strings.HasPrefix(p, "/v2/") {
+		return p[len("/v1"):]
+	}
+	return p
+}
+
+// apiVersionHeaders are the X-Atlas-API-Version header values. They are
+// assigned directly since this is set for every request. They must not be
+// modified.
+var (
+	apiVersionHeader1 = []string{"1"}
+	apiVersionHeader2 = []string{"2"}
+)
+
+// apiVersionHeaderKey is the canonical form of X-Atlas-API-Version.
+var apiVersionHeaderKey = http.CanonicalHeaderKey("X-Atlas-API-Version")
+
+// setAPIVersionHeaders sets the response headers for the API version.
+func (h *Handler) setAPIVersionHeaders(w http.ResponseWriter, r *http.Request, ver apiVersion) {
+	switch ver {
+	case apiVersionUnversioned:
+		h.m().api_version_requests_total.unversioned.Inc()
+	case apiVersionV1:
+		h.m().api_version_requests_total.v1.Inc()
+	case apiVersionV2:
+		h.m().api_version_requests_total.v2.Inc()
+		w.Header()[apiVersionHeaderKey] = apiVersionHeader2
+		return
+	}
+	w.Header()[apiVersionHeaderKey] = apiVersionHeader1
+
+	if !h.APIv1Sunset.IsZero() {
+		// the original path may have a tenant prefix before the version
+		prefix, _, _ := strings.Cut(r.RequestURI, "?")
+		if ver == apiVersionV1 {
+			prefix = strings.TrimSuffix(prefix, "/v1"+r.URL.Path)
+		} else {
+			prefix = strings.TrimSuffix(prefix, r.URL.Path)
+		}
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Sunset", h.APIv1Sunset.UTC().Format(http.TimeFormat))
+		w.Header().Set("Link", "<"+prefix+"/v2"+r.URL.Path+`>; rel="successor-version"`)
+	}
+}
+
+// newV2ResponseWriter wraps w to rewrite responses to r using the v2 schema.
+// The returned request must be passed to the handler, and finish must be
+// called after it returns. Websocket upgrades are not wrapped.
+func newV2ResponseWriter(w http.ResponseWriter, r *http.Request) (*v2ResponseWriter, *http.Request) {
+	v := &v2ResponseWriter{w: w, r: r, pass: websocket.IsUpgrade(r)}
+	if !v.pass && r.Header.Get("Accept-Encoding") != "" {
+		// so the response can be rewritten (the compression middleware will
+		// still compress the rewritten one)
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.Header = r.Header.Clone()
+		r2.Header.Del("Accept-Encoding")
+		r = r2
+	}
+	return v, r
+}
+
+// v2ResponseWriter buffers JSON and error responses so they can be rewritten
+// to the v2 schema. Other responses (e.g., MessagePack) are passed through.
+type v2ResponseWriter struct {
+	w      http.ResponseWriter
+	r      *http.Request
+	status int
+	pass   bool
+	buf    bytes.Buffer
+}
+
+func (v *v2ResponseWriter) Header() http.Header {
+	return v.w.Header()
+}
+
+func (v *v2ResponseWriter) Unwrap() http.ResponseWriter {
+	return v.w
+}
+
+func (v *v2ResponseWriter) WriteHeader(status int) {
+	if v.status != 0 {
+		return
+	}
+	v.status = status
+	if !v.pass {
+		ct := v.w.Header().Get("Content-Type")
+		switch {
+		case v.w.Header().Get("Content-Encoding") != "":
+			v.pass = true
+		case status == http.StatusNoContent || status == http.StatusNotModified || status < 200:
+			v.pass = true
+		case strings.HasPrefix(ct, "application/json"):
+		case status >= 400 && (ct == "" || strings.HasPrefix(ct, "text/plain")):
+		default:
+			v.pass = true
+		}
+	}
+	if v.pass {
+		v.w.WriteHeader(status)
+	}
+}
+
+func (v *v2ResponseWriter) Write(b []byte) (int, error) {
+	if v.status == 0 {
+		v.WriteHeader(http.StatusOK)
+	}
+	if v.pass {
+		return v.w.Write(b)
+	}
+	return v.buf.Write(b)
+}
+
+// finish writes the rewritten response.
+func (v *v2ResponseWriter) finish() {
+	if v.pass || v.status == 0 {
+		return
+	}
+	hdr := v.w.Header()
+	hdr.Del("Content-Length")
+	hdr.Del("ETag")
+
+	var body any
+	if strings.HasPrefix(hdr.Get("Content-Type"), "application/json") {
+		if v.r.Method == http.MethodHead && v.buf.Len() == 0 {
+			hdr.Del("Content-Type")
+			v.w.WriteHeader(v.status)
+			return
+		}
+		dec := json.NewDecoder(&v.buf)
+		dec.UseNumber()
+		if err := dec.Decode(&body); err != nil {
+			hlog.FromRequest(v.r).Warn().Err(err).Msg("failed to decode json response for v2 schema")
+			v2RespFail(v.w, v.r, http.StatusInternalServerError, ErrorCode_INTERNAL_SERVER_ERROR.MessageObj(), nil)
+			return
+		}
+	} else {
+		// plain-text error from http.Error
+		code := ErrorCode_INTERNAL_SERVER_ERROR
+		switch v.status {
+		case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUpgradeRequired:
+			code = ErrorCode_BAD_REQUEST
+		case http.StatusUnauthorized, http.StatusForbidden:
+			code = ErrorCode_UNAUTHORIZED
+		case http.StatusNotFound:
+			code = ErrorCode_NOT_FOUND
+		case http.StatusMethodNotAllowed:
+			code = ErrorCode_METHOD_NOT_ALLOWED
+		case http.StatusTooManyRequests:
+			code = ErrorCode_RATE_LIMITED
+		}
+		v2RespFail(v.w, v.r, v.status, code.MessageObjf("%s", strings.TrimSpace(v.buf.String())), nil)
+		return
+	}
+
+	switch x := body.(type) {
+	case map[string]any:
+		if ok, _ := x["success"].(bool); !ok && x["error"] != nil {
+			var obj ErrorObj
+			if e, ok := x["error"].(map[string]any); ok {
+				c, _ := e["enum"].(string)
+				obj.Code = ErrorCode(c)
+				obj.Message, _ = e["msg"].(string)
+				obj.Retryable, _ = e["retryable"].(bool)
+			}
+			extra := make(map[string]any, len(x))
+			for k, val := range x {
+				switch k {
+				case "success", "error", "request_id":
+				default:
+					extra[k] = val
+				}
+			}
+			v2RespFail(v.w, v.r, v.status, obj, extra)
+			return
+		}
+		delete(x, "success")
+	case []any:
+		page, err := v2Paginate(v.r, x)
+		if err != nil {
+			v2RespFail(v.w, v.r, http.StatusBadRequest, ErrorCode_BAD_REQUEST.MessageObjf("%v", err), nil)
+			return
+		}
+		body = page
+	}
+	respJSON(v.w, v.r, v.status, v2Keys(body))
+}
+
+// v2RespFail writes a v2 error response.
+func v2RespFail(w http.ResponseWriter, r *http.Request, status int, obj ErrorObj, extra map[string]any) {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		obj.Retryable = true
+	}
+	m := make(map[string]any, len(extra)+2)
+	for k, v := range extra {
+		m[k] = v
+	}
+	m["error"] = map[string]any{
+		"code":      obj.Code,
+		"message":   obj.Message,
+		"status":    status,
+		"retryable": obj.Retryable,
+	}
+	if rid, ok := hlog.IDFromRequest(r); ok {
+		m["request_id"] = rid.String()
+	}
+	respJSON(w, r, status, v2Keys(m))
+}
+
+// v2Paginate returns a page of items according to the limit and cursor query
+// parameters.
+func v2Paginate(r *http.Request, items []any) (map[string]any, error) {
+	limit, offset := len(items), 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > v2PageLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", v2PageLimit)
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err == nil {
+			offset, err = strconv.Atoi(string(b))
+		}
+		if err != nil || offset < 0 {
+			return nil, errors.New("invalid cursor")
+		}
+	}
+	if offset > len(items) {
+		offset = len(items)
+	}
+	end := len(items)
+	if limit < end-offset {
+		end = offset + limit
+	}
+	page := map[string]any{
+		"items": items[offset:end],
+		"total": len(items),
+	}
+	if end < len(items) {
+		page["next_cursor"] = base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end)))
+	}
+	return page, nil
+}
+
+// v2Keys recursively converts object keys to snake_case.
+func v2Keys(x any) any {
+	switch x := x.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(x))
+		for k, v := range x {
+			m[snakeCase(k)] = v2Keys(v)
+		}
+		return m
+	case []any:
+		for i, v := range x {
+			x[i] = v2Keys(v)
+		}
+		return x
+	}
+	return x
+}
+
+// snakeCase converts a camelCase or PascalCase identifier to snake_case,
+// keeping acronyms together (e.g., serverID to server_id). Keys which aren't
+// identifiers (e.g., names or IDs used as map keys) are returned as-is.
+func snakeCase(s string) string {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return s
+	}
+	var upper bool
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_':
+		default:
+			return s
+		}
+	}
+	if !upper {
+		return s
+	}
+	isUpper := func(c byte) bool { return c >= 'A' && c <= 'Z' }
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isUpper(c) {
+			if i != 0 && s[i-1] != '_' && (!isUpper(s[i-1]) || (i+1 < len(s) && !isUpper(s[i+1]) && s[i+1] != '_' && !(s[i+1] >= '0' && s[i+1] <= '9'))) {
+				b.WriteByte('_')
+			}
+			c += 'a' - 'A'
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
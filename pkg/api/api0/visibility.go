@@ -0,0 +1,196 @@
+package api0
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/discord"
+	"github.com/rs/zerolog/hlog"
+)
+
+// ServerVisibility controls who a server is listed for.
+type ServerVisibility string
+
+const (
+	ServerVisibilityPublic    ServerVisibility = ""          // listed for everyone
+	ServerVisibilityUnlisted  ServerVisibility = "unlisted"  // not listed, but can still be joined by ID
+	ServerVisibilityAllowlist ServerVisibility = "allowlist" // only listed for (and joinable by) authenticated players on the allowlist
+)
+
+const (
+	maxServerAllowUIDs = 1024
+	discordRolesTTL    = 5 * time.Minute
+	discordRolesMax    = 10000
+)
+
+// parseServerVisibility parses the visibility params for a server
+// registration into s.
+func (h *Handler) parseServerVisibility(q queryGetter, s *Server) error {
+	switch v := ServerVisibility(q.Get("visibility")); v {
+	case "public":
+		s.Visibility = ServerVisibilityPublic
+	case ServerVisibilityPublic, ServerVisibilityUnlisted, ServerVisibilityAllowlist:
+		s.Visibility = v
+	default:
+		return fmt.Errorf("invalid visibility %q", v)
+	}
+	if v := q.Get("allowlist"); v != "" {
+		for _, x := range strings.Split(v, ",") {
+			uid, err := strconv.ParseUint(strings.TrimSpace(x), 10, 64)
+			if err != nil || uid == 0 {
+				return fmt.Errorf("invalid allowlist uid %q", x)
+			}
+			s.AllowUIDs = append(s.AllowUIDs, uid)
+		}
+		if len(s.AllowUIDs) > maxServerAllowUIDs {
+			return fmt.Errorf("too many allowlist uids (max %d)", maxServerAllowUIDs)
+		}
+	}
+	if v := q.Get("allowlistDiscord"); v != "" {
+		guild, role, ok := strings.Cut(v, ":")
+		if !ok || !isDiscordSnowflake(guild) || !isDiscordSnowflake(role) {
+			return fmt.Errorf("allowlistDiscord must be guild_id:role_id")
+		}
+		if h.DiscordBotToken == "" || h.AccountLinkStorage == nil {
+			return fmt.Errorf("discord allowlists are not enabled")
+		}
+		s.AllowDiscordGuild, s.AllowDiscordRole = guild, role
+	}
+	if s.Visibility != ServerVisibilityAllowlist && (len(s.AllowUIDs) != 0 || s.AllowDiscordGuild != "") {
+		return fmt.Errorf("allowlist params require allowlist visibility")
+	}
+	if s.Visibility == ServerVisibilityAllowlist && len(s.AllowUIDs) == 0 && s.AllowDiscordGuild == "" {
+		return fmt.Errorf("allowlist visibility requires allowlist or allowlistDiscord")
+	}
+	return nil
+}
+
+func isDiscordSnowflake(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+// serverAllowChecker checks if a player is on server allowlists, caching
+// external lookups for the duration of a request. It is not safe for
+// concurrent use.
+type serverAllowChecker struct {
+	h   *Handler
+	r   *http.Request
+	uid uint64 // zero if not authenticated
+
+	discordLoaded bool
+	discordID     string
+}
+
+// Allowed checks if the player is on srv's allowlist. Servers without one are
+// always allowed.
+func (c *serverAllowChecker) Allowed(srv *Server) bool {
+	if srv.Visibility != ServerVisibilityAllowlist {
+		return true
+	}
+	if c.uid == 0 {
+		return false
+	}
+	for _, uid := range srv.AllowUIDs {
+		if uid == c.uid {
+			return true
+		}
+	}
+	if srv.AllowDiscordGuild == "" || c.h.DiscordBotToken == "" || c.h.AccountLinkStorage == nil {
+		return false
+	}
+	if !c.discordLoaded {
+		c.discordLoaded = true
+		links, err := c.h.AccountLinkStorage.GetAccountLinks(c.uid)
+		if err != nil {
+			hlog.FromRequest(c.r).Error().
+				Err(err).
+				Uint64("uid", c.uid).
+				Msgf("failed to read account links from storage")
+			return false
+		}
+		for _, l := range links {
+			if l.Provider == AccountLinkProviderDiscord {
+				c.discordID = l.ExternalID
+			}
+		}
+	}
+	if c.discordID == "" {
+		return false
+	}
+	roles, err := c.h.discordRoles.get(c.r.Context(), c.h.DiscordBotToken, srv.AllowDiscordGuild, c.discordID)
+	if err != nil {
+		hlog.FromRequest(c.r).Warn().
+			Err(err).
+			Uint64("uid", c.uid).
+			Str("guild", srv.AllowDiscordGuild).
+			Msgf("failed to get discord guild member roles")
+		return false
+	}
+	for _, role := range roles {
+		if role == srv.AllowDiscordRole {
+			return true
+		}
+	}
+	return false
+}
+
+// Visible checks if srv should be listed for the player.
+func (c *serverAllowChecker) Visible(srv *Server) bool {
+	switch srv.Visibility {
+	case ServerVisibilityPublic:
+		return true
+	case ServerVisibilityAllowlist:
+		return c.Allowed(srv)
+	default:
+		return false
+	}
+}
+
+// discordRoleCache caches Discord guild member roles. It is safe for
+// concurrent use.
+type discordRoleCache struct {
+	mu sync.Mutex
+	m  map[[2]string]discordRoleCacheEntry
+}
+
+type discordRoleCacheEntry struct {
+	roles []string // nil if not a member
+	exp   time.Time
+}
+
+// get gets the roles for the user in the guild. Users who aren't members have
+// no roles.
+func (c *discordRoleCache) get(ctx context.Context, token, guild, user string) ([]string, error) {
+	k := [2]string{guild, user}
+	t := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.m[k]
+	c.mu.Unlock()
+	if ok && t.Before(e.exp) {
+		return e.roles, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	roles, err := discord.GetGuildMemberRoles(ctx, token, guild, user)
+	if err != nil && !errors.Is(err, discord.ErrUnknownMember) {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil || len(c.m) >= discordRolesMax {
+		c.m = make(map[[2]string]discordRoleCacheEntry)
+	}
+	c.m[k] = discordRoleCacheEntry{roles, t.Add(discordRolesTTL)}
+	return roles, nil
+}
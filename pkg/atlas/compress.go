@@ -0,0 +1,262 @@
+package atlas
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+)
+
+// compressHandler transparently compresses responses using the best encoding
+// supported by the client.
+//
+// Responses are only compressed if they don't already have a Content-Encoding,
+// have an allowed Content-Type, and are at least MinSize bytes (or are
+// explicitly flushed by the handler before then). HEAD requests, range
+// responses, and responses without a body are passed through unmodified.
+type compressHandler struct {
+	Handler http.Handler
+	MinSize int
+	Types   []string // media types, or major types with a /* suffix
+
+	gzPool sync.Pool
+	flPool sync.Pool
+}
+
+// compressEncodings contains the supported encodings in the order they are
+// preferred if the client accepts multiple with the same quality.
+var compressEncodings = []string{"gzip", "deflate"}
+
+func (h *compressHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	enc := h.negotiate(r.Header.Values("Accept-Encoding"))
+	if enc == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	cw := &compressResponse{h: h, w: w, enc: enc}
+	defer cw.close()
+
+	h.Handler.ServeHTTP(cw, r)
+}
+
+// negotiate chooses the best supported encoding from the Accept-Encoding
+// header values, returning an empty string if none are acceptable.
+func (h *compressHandler) negotiate(accept []string) string {
+	var (
+		best  string
+		bestQ float64
+	)
+	for _, enc := range compressEncodings {
+		q, wildcard := -1.0, -1.0
+		for _, a := range accept {
+			for _, x := range strings.Split(a, ",") {
+				coding, params, _ := strings.Cut(x, ";")
+				coding = strings.ToLower(strings.TrimSpace(coding))
+
+				v := 1.0
+				if k, s, ok := strings.Cut(params, "="); ok && strings.TrimSpace(k) == "q" {
+					if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+						v = f
+					}
+				}
+				switch coding {
+				case enc:
+					q = v
+				case "*":
+					wildcard = v
+				}
+			}
+		}
+		if q < 0 {
+			q = wildcard // an explicit coding takes precedence over *
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// allowed checks whether responses with the provided Content-Type should be
+// compressed.
+func (h *compressHandler) allowed(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	major, _, _ := strings.Cut(mt, "/")
+	for _, t := range h.Types {
+		if t == mt || t == major+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *compressHandler) writer(enc string, w io.Writer) io.WriteCloser {
+	switch enc {
+	case "gzip":
+		if zw, ok := h.gzPool.Get().(*gzip.Writer); ok {
+			zw.Reset(w)
+			return zw
+		}
+		return gzip.NewWriter(w)
+	case "deflate":
+		if zw, ok := h.flPool.Get().(*flate.Writer); ok {
+			zw.Reset(w)
+			return zw
+		}
+		zw, _ := flate.NewWriter(w, flate.DefaultCompression) // only errors on invalid level
+		return zw
+	default:
+		panic("unsupported encoding " + enc)
+	}
+}
+
+func (h *compressHandler) release(zw io.WriteCloser) {
+	switch zw := zw.(type) {
+	case *gzip.Writer:
+		h.gzPool.Put(zw)
+	case *flate.Writer:
+		h.flPool.Put(zw)
+	}
+}
+
+// compressResponse buffers the start of the response until it can decide
+// whether to compress it.
+type compressResponse struct {
+	h   *compressHandler
+	w   http.ResponseWriter
+	enc string
+
+	status  int            // if non-zero, WriteHeader was called
+	buf     []byte         // buffered response body until decided
+	decided bool           // whether we've written the header
+	zw      io.WriteCloser // non-nil if compressing
+}
+
+var _ http.Flusher = (*compressResponse)(nil)
+
+func (c *compressResponse) Header() http.Header {
+	return c.w.Header()
+}
+
+func (c *compressResponse) WriteHeader(status int) {
+	if c.status != 0 {
+		return
+	}
+	if status >= 100 && status <= 199 {
+		c.w.WriteHeader(status) // informational, don't buffer
+		return
+	}
+	c.status = status
+
+	hdr := c.w.Header()
+	switch {
+	case status == http.StatusNoContent || status == http.StatusNotModified:
+		c.decide(false)
+	case hdr.Get("Content-Encoding") != "" || hdr.Get("Content-Range") != "":
+		c.decide(false)
+	case !c.h.allowed(hdr.Get("Content-Type")):
+		c.decide(false)
+	default:
+		if n, err := strconv.Atoi(hdr.Get("Content-Length")); err == nil {
+			c.decide(n >= c.h.MinSize)
+		}
+	}
+}
+
+func (c *compressResponse) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		if c.w.Header().Get("Content-Type") == "" {
+			c.w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.decided {
+		c.buf = append(c.buf, b...)
+		if len(c.buf) >= c.h.MinSize {
+			if err := c.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if c.zw != nil {
+		return c.zw.Write(b)
+	}
+	return c.w.Write(b)
+}
+
+func (c *compressResponse) Flush() {
+	if !c.decided && c.status != 0 {
+		c.decide(true)
+	}
+	if fl, ok := c.zw.(interface{ Flush() error }); ok {
+		fl.Flush()
+	}
+	if fl, ok := c.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (c *compressResponse) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
+// decide writes the header and the buffered body, compressing the response if
+// compress is true.
+func (c *compressResponse) decide(compress bool) error {
+	if c.decided {
+		return nil
+	}
+	c.decided = true
+
+	if compress {
+		hdr := c.w.Header()
+		hdr.Set("Content-Encoding", c.enc)
+		hdr.Del("Content-Length")
+		if etag := hdr.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			hdr.Set("ETag", "W/"+etag) // to avoid breaking caching proxies since strong ETags must be unique if Content-Encoding is different
+		}
+		c.zw = c.h.writer(c.enc, c.w)
+	}
+	c.w.WriteHeader(c.status)
+
+	buf := c.buf
+	c.buf = nil
+	if len(buf) != 0 {
+		if c.zw != nil {
+			_, err := c.zw.Write(buf)
+			return err
+		}
+		_, err := c.w.Write(buf)
+		return err
+	}
+	return nil
+}
+
+// close finishes the response after the handler returns.
+func (c *compressResponse) close() {
+	if c.status == 0 {
+		return // nothing written, so let net/http handle it
+	}
+	if !c.decided {
+		c.w.Header().Set("Content-Length", strconv.Itoa(len(c.buf)))
+		c.decide(false)
+	}
+	if c.zw != nil {
+		c.zw.Close()
+		c.h.release(c.zw)
+		c.zw = nil
+	}
+}
@@ -0,0 +1,167 @@
+package atlas
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressNegotiate(t *testing.T) {
+	h := &compressHandler{}
+	for _, tc := range []struct {
+		accept []string
+		enc    string
+	}{
+		{nil, ""},
+		{[]string{""}, ""},
+		{[]string{"identity"}, ""},
+		{[]string{"br"}, ""},
+		{[]string{"gzip"}, "gzip"},
+		{[]string{"GZIP"}, "gzip"},
+		{[]string{"deflate"}, "deflate"},
+		{[]string{"deflate, gzip"}, "gzip"},
+		{[]string{"deflate", "gzip"}, "gzip"},
+		{[]string{"gzip;q=0, deflate"}, "deflate"},
+		{[]string{"gzip;q=0.5, deflate;q=0.8"}, "deflate"},
+		{[]string{"gzip; q=0.8, deflate; q=0.5"}, "gzip"},
+		{[]string{"*"}, "gzip"},
+		{[]string{"*;q=0"}, ""},
+		{[]string{"gzip;q=0, *"}, "deflate"},
+		{[]string{"gzip;q=0, deflate;q=0"}, ""},
+		{[]string{"br, gzip;q=invalid"}, "gzip"},
+	} {
+		if enc := h.negotiate(tc.accept); enc != tc.enc {
+			t.Errorf("negotiate(%q): expected %q, got %q", tc.accept, tc.enc, enc)
+		}
+	}
+}
+
+func TestCompressHandler(t *testing.T) {
+	large := strings.Repeat(`{"compressible":true}`, 100)
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		accept string
+		range_ bool
+		status int
+		header map[string]string
+		body   string
+		flush  bool
+		enc    string
+		etag   string
+	}{
+		{name: "Large", accept: "gzip", header: map[string]string{"Content-Type": "application/json"}, body: large, enc: "gzip"},
+		{name: "LargeDeflate", accept: "deflate", header: map[string]string{"Content-Type": "application/json"}, body: large, enc: "deflate"},
+		{name: "LargeUnsupported", accept: "br", header: map[string]string{"Content-Type": "application/json"}, body: large},
+		{name: "LargeNoAccept", header: map[string]string{"Content-Type": "application/json"}, body: large},
+		{name: "LargeContentLength", accept: "gzip", header: map[string]string{"Content-Type": "application/json", "Content-Length": "2100"}, body: large, enc: "gzip"},
+		{name: "Small", accept: "gzip", header: map[string]string{"Content-Type": "application/json"}, body: `{}`},
+		{name: "SmallContentLength", accept: "gzip", header: map[string]string{"Content-Type": "application/json", "Content-Length": "2"}, body: `{}`},
+		{name: "SmallFlushed", accept: "gzip", header: map[string]string{"Content-Type": "text/event-stream"}, body: "data: x\n\n", flush: true, enc: "gzip"},
+		{name: "TypeWildcard", accept: "gzip", header: map[string]string{"Content-Type": "text/html; charset=utf-8"}, body: large, enc: "gzip"},
+		{name: "TypeParams", accept: "gzip", header: map[string]string{"Content-Type": "application/json; charset=utf-8"}, body: large, enc: "gzip"},
+		{name: "TypeNotAllowed", accept: "gzip", header: map[string]string{"Content-Type": "image/png"}, body: large},
+		{name: "TypeInvalid", accept: "gzip", header: map[string]string{"Content-Type": ";;"}, body: large},
+		{name: "TypeDetected", accept: "gzip", body: large, enc: "gzip"},
+		{name: "AlreadyEncoded", accept: "gzip", header: map[string]string{"Content-Type": "application/json", "Content-Encoding": "br"}, body: large},
+		{name: "Head", method: http.MethodHead, accept: "gzip", header: map[string]string{"Content-Type": "application/json"}, body: large},
+		{name: "Range", accept: "gzip", range_: true, header: map[string]string{"Content-Type": "application/json"}, body: large},
+		{name: "NotModified", accept: "gzip", status: http.StatusNotModified, header: map[string]string{"Content-Type": "application/json", "ETag": `"x"`}, etag: `"x"`},
+		{name: "NoContent", accept: "gzip", status: http.StatusNoContent, header: map[string]string{"Content-Type": "application/json"}},
+		{name: "WeakETag", accept: "gzip", header: map[string]string{"Content-Type": "application/json", "ETag": `W/"x"`}, body: large, enc: "gzip", etag: `W/"x"`},
+		{name: "StrongETag", accept: "gzip", header: map[string]string{"Content-Type": "application/json", "ETag": `"x"`}, body: large, enc: "gzip", etag: `W/"x"`},
+		{name: "StrongETagUncompressed", accept: "gzip", header: map[string]string{"Content-Type": "application/json", "ETag": `"x"`}, body: `{}`, etag: `"x"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &compressHandler{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					for k, v := range tc.header {
+						w.Header().Set(k, v)
+					}
+					if tc.status != 0 {
+						w.WriteHeader(tc.status)
+					}
+					if tc.body != "" && r.Method != http.MethodHead {
+						io.WriteString(w, tc.body)
+					}
+					if tc.flush {
+						w.(http.Flusher).Flush()
+					}
+				}),
+				MinSize: 1024,
+				Types:   []string{"application/json", "text/*"},
+			}
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/", nil)
+			if tc.accept != "" {
+				r.Header.Set("Accept-Encoding", tc.accept)
+			}
+			if tc.range_ {
+				r.Header.Set("Range", "bytes=0-")
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			status := tc.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if w.Code != status {
+				t.Errorf("expected status %d, got %d", status, w.Code)
+			}
+			if v := w.Header().Get("Vary"); v != "Accept-Encoding" {
+				t.Errorf("expected Vary to be Accept-Encoding, got %q", v)
+			}
+			if v := w.Header().Get("ETag"); v != tc.etag {
+				t.Errorf("expected ETag %q, got %q", tc.etag, v)
+			}
+
+			enc := w.Header().Get("Content-Encoding")
+			if ce := tc.header["Content-Encoding"]; ce != "" {
+				if enc != ce {
+					t.Errorf("expected existing Content-Encoding %q to be kept, got %q", ce, enc)
+				}
+				enc = ""
+			} else if enc != tc.enc {
+				t.Errorf("expected Content-Encoding %q, got %q", tc.enc, enc)
+			}
+			if enc != "" && w.Header().Get("Content-Length") != "" {
+				t.Errorf("expected Content-Length to be removed for compressed response")
+			}
+
+			var rd io.Reader = w.Body
+			switch enc {
+			case "gzip":
+				zr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatalf("invalid gzip response: %v", err)
+				}
+				rd = zr
+			case "deflate":
+				rd = flate.NewReader(w.Body)
+			}
+			buf, err := io.ReadAll(rd)
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+
+			exp := tc.body
+			if method == http.MethodHead {
+				exp = ""
+			}
+			if !bytes.Equal(buf, []byte(exp)) {
+				t.Errorf("incorrect response body (%d bytes, expected %d)", len(buf), len(exp))
+			}
+		})
+	}
+}
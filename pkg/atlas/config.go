@@ -23,7 +23,8 @@ type UIDGID [2]int
 
 // Config contains the configuration for Atlas. The env struct tag contains the
 // environment variable name and the default value if missing, or empty (if not
-// ?=). All string arrays are comma-separated.
+// ?=). All string arrays are comma-separated. See [Server.Reload] for the
+// options which can be changed without restarting.
 type Config struct {
 	// The addresses to listen on (comma-separated).
 	Addr []string `env:"ATLAS_ADDR?=:8080"`
@@ -35,6 +36,28 @@ type Config struct {
 	// port is 0, a random one is chosen.
 	AddrUDP netip.AddrPort `env:"ATLAS_ADDR_UDP=:0"`
 
+	// The address to listen on for debugging endpoints (pprof under
+	// /debug/pprof/, /debug/runtime, /debug/registry, and /debug/nspkt).
+	// Requests are only allowed from loopback addresses unless they use the
+	// admin secret as a bearer token. If not provided, the debug endpoints are
+	// disabled.
+	AddrDebug string `env:"ATLAS_ADDR_DEBUG"`
+
+	// The addresses to listen on with TLS, requiring client certificates
+	// signed by the mTLS client CA (comma-separated). This is intended for
+	// cluster-internal admin and relay connections.
+	AddrMTLS []string `env:"ATLAS_ADDR_MTLS"`
+
+	// Fault injection rules for resilience testing, in the form
+	// target:key=value[,key=value...][;...]. The first rule matching an
+	// operation is used. Targets are origin, stryder, http (other outgoing
+	// requests), cache, storage, storage.accounts, storage.pdata, a specific
+	// method (e.g., storage.pdata.SetPdata, cache.Get), or *. The options are
+	// delay and jitter (durations) and fail (a probability from 0 to 1). If
+	// set, the rules can also be replaced at runtime via /debug/faults. This
+	// must not be used in production.
+	Faults string `env:"ATLAS_FAULTS"`
+
 	// Whether to trust Cloudflare headers like CF-Connecting-IP.
 	//
 	// This is not safe to use unless you:
@@ -46,6 +69,18 @@ type Config struct {
 	// header. If not provided, all hostnames are allowed.
 	Host []string `env:"ATLAS_HOST"`
 
+	// Whether to compress responses with gzip or deflate if supported by the
+	// client. Responses which are already compressed by the handler are not
+	// affected.
+	Compress bool `env:"ATLAS_COMPRESS=true"`
+
+	// The minimum response size in bytes to compress.
+	CompressMinSize int `env:"ATLAS_COMPRESS_MIN_SIZE=1024"`
+
+	// Comma-separated list of media types (or major types like text/*) to
+	// compress.
+	CompressTypes []string `env:"ATLAS_COMPRESS_TYPES=text/*,application/json,application/javascript,application/xml,image/svg+xml"`
+
 	// Comma-separated list of paths to SSL server certificates to use for SSL.
 	// The .crt and .key extensions will be appended automatically. If not
 	// provided, SSL is disabled. If a path begins with @, it is treated as a
@@ -53,10 +88,19 @@ type Config struct {
 	// $CREDENTIALS_DIRECTORY/mycert.{crt,key}).
 	ServerCerts []string `env:"ATLAS_SERVER_CERTS" sdcreds:"expand,list"`
 
-	// Comma-separated list of paths to SSL CA certificates to use for SSL
-	// client authentication. No effect is ServerCerts is not provided. If not
-	// provided, clients are not required to use SSL client authentication.
-	// TODO: ClientCerts []string `env:"ATLAS_CLIENT_CERTS"`
+	// Comma-separated list of paths to PEM-encoded private CA certificates to
+	// use for SSL client authentication on AddrMTLS. Requests to the other
+	// addresses are not required to use SSL client authentication.
+	MTLSClientCA []string `env:"ATLAS_MTLS_CLIENT_CA" sdcreds:"expand,list"`
+
+	// Comma-separated list of paths to CRLs (PEM or DER) to check mTLS client
+	// certificates against. They must be signed by one of MTLSClientCA, and
+	// are reloaded on SIGHUP.
+	MTLSClientCRL []string `env:"ATLAS_MTLS_CLIENT_CRL" sdcreds:"expand,list"`
+
+	// If provided, mTLS client certificates must have one of these common
+	// names or DNS names (comma-separated).
+	MTLSClientNames []string `env:"ATLAS_MTLS_CLIENT_NAMES"`
 
 	// The minimum log level (e.g., trace, debug, info, warn, error, fatal).
 	//
@@ -84,6 +128,38 @@ type Config struct {
 	// The owner for the log file. Not supported on Windows.
 	LogFileChown *UIDGID `env:"ATLAS_LOG_FILE_CHOWN"`
 
+	// Where to write HTTP access logs as JSON lines, separately from the
+	// application log. If empty, they are written to the application log
+	// without sampling or redaction. If "-", they are written to stdout.
+	// Otherwise, it is a file, which is reopened on SIGHUP.
+	AccessLog string `env:"ATLAS_ACCESS_LOG"`
+
+	// The size in MiB after which the access log file is rotated. If zero,
+	// it is not rotated by Atlas.
+	AccessLogMaxSize int `env:"ATLAS_ACCESS_LOG_MAX_SIZE"`
+
+	// The number of rotated access log files to keep.
+	AccessLogMaxBackups int `env:"ATLAS_ACCESS_LOG_MAX_BACKUPS=5"`
+
+	// The permissions for the access log file.
+	AccessLogChmod fs.FileMode `env:"ATLAS_ACCESS_LOG_CHMOD"`
+
+	// The number of bits of client IPv4 and IPv6 addresses to keep in the
+	// access log.
+	AccessLogIPv4Prefix int `env:"ATLAS_ACCESS_LOG_IPV4_PREFIX=32"`
+	AccessLogIPv6Prefix int `env:"ATLAS_ACCESS_LOG_IPV6_PREFIX=128"`
+
+	// Query parameters to redact from access log URIs.
+	AccessLogRedactQuery []string `env:"ATLAS_ACCESS_LOG_REDACT_QUERY?=token,playerToken,code,state"`
+
+	// Whether to remove user agents from the access log.
+	AccessLogRedactUserAgent bool `env:"ATLAS_ACCESS_LOG_REDACT_USER_AGENT"`
+
+	// Comma-separated sampling rates for successful requests in the access
+	// log, as /path/prefix=percent (e.g., /client/servers=10). The path is
+	// matched without the tenant prefix.
+	AccessLogSample []string `env:"ATLAS_ACCESS_LOG_SAMPLE"`
+
 	// Maps source IP prefixes to another IP (useful for controlling server
 	// registration IPs when running within a LAN and port forwarding during
 	// development). Comma-separated list of prefix=ip (example:
@@ -100,12 +176,132 @@ type Config struct {
 	// The amount of time for player masterserver auth tokens to be valid for.
 	API0_TokenExpiryTime time.Duration `env:"ATLAS_API0_TOKEN_EXPIRY_TIME=24h"`
 
+	// How to handle concurrent masterserver auth sessions for the same
+	// account.
+	//  - kick-oldest (allow up to MaxSessions, invalidating the oldest)
+	//  - deny (reject new sessions from other IPs while one is active)
+	//  - allow (allow up to MaxSessions, rejecting new sessions)
+	API0_SessionPolicy string `env:"ATLAS_API0_SESSION_POLICY=kick-oldest"`
+
+	// The maximum number of concurrent masterserver auth sessions per account
+	// for the kick-oldest and allow session policies.
+	API0_MaxSessions int `env:"ATLAS_API0_MAX_SESSIONS=1"`
+
+	// How long to remember stryder tokens used for masterserver auth to reject
+	// reuse. If zero, replay protection is disabled.
+	API0_AuthReplayWindow time.Duration `env:"ATLAS_API0_AUTH_REPLAY_WINDOW"`
+
+	// The maximum number of used stryder tokens to remember. If zero, a
+	// reasonable default is used.
+	API0_AuthReplayMaxTokens int `env:"ATLAS_API0_AUTH_REPLAY_MAX_TOKENS"`
+
+	// How long to remember responses to server registration, pdata write, and
+	// ban requests with an Idempotency-Key header so retries are replayed
+	// instead of being applied again. If zero, idempotency keys are ignored.
+	API0_IdempotencyWindow time.Duration `env:"ATLAS_API0_IDEMPOTENCY_WINDOW=10m"`
+
+	// The maximum number of idempotency keys to remember. If zero, a
+	// reasonable default is used.
+	API0_IdempotencyMaxKeys int `env:"ATLAS_API0_IDEMPOTENCY_MAX_KEYS"`
+
+	// The maximum number of players in a party. If zero, parties are
+	// disabled.
+	API0_PartyMaxSize int `env:"ATLAS_API0_PARTY_MAX_SIZE"`
+
+	// The number of players to group into a match from the matchmaking queue.
+	// If zero, matchmaking is disabled.
+	API0_Matchmaking_MatchSize int `env:"ATLAS_API0_MATCHMAKING_MATCH_SIZE"`
+
+	// The playlists players can queue for. If empty, any playlist can be used.
+	API0_Matchmaking_Modes []string `env:"ATLAS_API0_MATCHMAKING_MODES"`
+
+	// How long players can wait in the matchmaking queue. If zero, a
+	// reasonable default is used.
+	API0_Matchmaking_QueueTimeout time.Duration `env:"ATLAS_API0_MATCHMAKING_QUEUE_TIMEOUT"`
+
+	// The width of the skill rating bands players are grouped into by the
+	// matchmaking queue. If zero, or ratings are disabled, skill isn't
+	// considered.
+	API0_Matchmaking_SkillBand int `env:"ATLAS_API0_MATCHMAKING_SKILL_BAND"`
+
+	// Comma-separated feature flags for gradually rolling out features, as
+	// [tenant/]feature=percent (e.g., matchmaking=10,isolated/matchmaking=100).
+	// Players are consistently chosen by UID. Features without a flag are
+	// enabled, except for serverlist_canary. The features are matchmaking,
+	// pdata_patch, and serverlist_canary. Flags can be overridden per-tenant
+	// at runtime via /admin/features.
+	API0_FeatureFlags []string `env:"ATLAS_API0_FEATURE_FLAGS"`
+
+	// Comma-separated CORS rules allowing browser-based clients to call the API
+	// directly, as [tenant/]path=origin, where the path may end with * to
+	// match a prefix, and the origin is scheme://host[:port], optionally with
+	// a *. subdomain wildcard, or * (e.g.,
+	// /client/*=https://browser.example.com,isolated/server/*=https://*.example.com).
+	// Paths without a rule keep the built-in CORS headers. Admin endpoints are
+	// never allowed cross-origin.
+	API0_CORS []string `env:"ATLAS_API0_CORS"`
+
+	// The methods allowed for cross-origin requests matching API0_CORS.
+	API0_CORS_Methods []string `env:"ATLAS_API0_CORS_METHODS=OPTIONS,HEAD,GET,POST"`
+
+	// How long browsers may cache CORS preflight responses for.
+	API0_CORS_MaxAge time.Duration `env:"ATLAS_API0_CORS_MAX_AGE=24h"`
+
+	// The number of consecutive stryder auth failures after which Origin is
+	// considered unavailable. If zero, the circuit breaker is disabled.
+	API0_OriginBreakerThreshold int `env:"ATLAS_API0_ORIGIN_BREAKER_THRESHOLD=5"`
+
+	// How long to skip stryder auth for once Origin is considered unavailable
+	// before trying again.
+	API0_OriginBreakerCooldown time.Duration `env:"ATLAS_API0_ORIGIN_BREAKER_COOLDOWN=30s"`
+
+	// If non-zero, allow players to authenticate while Origin is unavailable
+	// if their account was verified within this duration. The request must
+	// come from an IP previously used by the account unless
+	// ATLAS_API0_DEGRADED_AUTH_ANY_IP is set.
+	API0_DegradedAuthMaxAge time.Duration `env:"ATLAS_API0_DEGRADED_AUTH_MAX_AGE"`
+
+	// Allow degraded auth from IPs not previously used by the account.
+	API0_DegradedAuthAnyIP bool `env:"ATLAS_API0_DEGRADED_AUTH_ANY_IP"`
+
+	// Whether to allow players to get restricted guest sessions at
+	// /client/guest_auth without Origin (e.g., for LAN parties). Guest pdata
+	// is not persisted.
+	API0_GuestMode bool `env:"ATLAS_API0_GUEST_MODE"`
+
+	// How long guest tokens are valid for.
+	API0_GuestMode_TokenExpiry time.Duration `env:"ATLAS_API0_GUEST_MODE_TOKEN_EXPIRY=2h"`
+
+	// The IP prefixes guest sessions can be created from. If empty, only
+	// private and loopback addresses are allowed.
+	API0_GuestMode_Networks []string `env:"ATLAS_API0_GUEST_MODE_NETWORKS"`
+
+	// How long player presence (sent by game servers to /server/players) is
+	// kept without a keep-alive.
+	API0_PlayerPresenceTTL time.Duration `env:"ATLAS_API0_PLAYER_PRESENCE_TTL=2m"`
+
+	// Whether to require clients to get a single-use join token from
+	// /client/join_token (which checks the server password) before
+	// /client/auth_with_server, which will then no longer accept passwords.
+	API0_JoinTokenRequired bool `env:"ATLAS_API0_JOIN_TOKEN_REQUIRED"`
+
+	// How long join tokens are valid for.
+	API0_JoinTokenExpiry time.Duration `env:"ATLAS_API0_JOIN_TOKEN_EXPIRY=30s"`
+
 	// Don't check player masterserver auth tokens, disable stryder auth.
 	API0_InsecureDevNoCheckPlayerAuth bool `env:"ATLAS_API0_INSECURE_DEV_NO_CHECK_PLAYER_AUTH"`
 
 	// Whether to allow games to register via IPv6. Not recommended.
 	API0_AllowGameServerIPv6 bool `env:"ATLAS_API0_ALLOW_GAME_SERVER_IPV6"`
 
+	// Whether to accept heartbeats over UDP (on ATLAS_ADDR_UDP) from game
+	// servers registered with a signing key.
+	API0_UDPHeartbeat bool `env:"ATLAS_API0_UDP_HEARTBEAT"`
+
+	// The public UDP port to advertise to game servers for heartbeats if it
+	// is different from the one in ATLAS_ADDR_UDP (e.g., if behind NAT).
+	API0_UDPHeartbeat_Port int `env:"ATLAS_API0_UDP_HEARTBEAT_PORT"`
+
 	// Minimum launcher semver to allow for servers or authenticated clients.
 	// Dev versions are always allowed. If not provided, all client versions are
 	// allowed.
@@ -121,6 +317,15 @@ type Config struct {
 	// not provided, API0_MinimumLauncherVersion is used.
 	API0_MinimumLauncherVersionServer string `env:"ATLAS_API0_MINIMUM_LAUNCHER_VERSION_SERVER"`
 
+	// Specific launcher versions to deny authentication and server
+	// registration. Each one may be a full version, or a major.minor or major
+	// version to block all versions with that prefix. Dev versions are always
+	// allowed.
+	API0_BlockedLauncherVersions []string `env:"ATLAS_API0_BLOCKED_LAUNCHER_VERSIONS"`
+
+	// The URL to send clients to when they need to update their launcher.
+	API0_LauncherUpdateURL string `env:"ATLAS_API0_LAUNCHER_UPDATE_URL?=https://github.com/R2Northstar/Northstar/releases/latest"`
+
 	// Region mapping to use for server list. If set to an empty string or
 	// "none", region maps are disabled. Options: none, default.
 	API0_RegionMap string `env:"ATLAS_API0_REGION_MAP?=default"`
@@ -139,22 +344,317 @@ type Config struct {
 	// it can't be added again without re-verifying).
 	API0_ServerList_GhostTime time.Duration `env:"ATLAS_API0_SERVERLIST_GHOST_TIME=2m"`
 
+	// The file to periodically write server list snapshots to (tenants use the
+	// same path suffixed with .name), including on shutdown. Snapshots contain
+	// server auth tokens. If empty, snapshots are disabled.
+	API0_ServerList_Snapshot string `env:"ATLAS_API0_SERVERLIST_SNAPSHOT"`
+
+	// How often to write server list snapshots.
+	API0_ServerList_SnapshotInterval time.Duration `env:"ATLAS_API0_SERVERLIST_SNAPSHOT_INTERVAL=15s"`
+
+	// Whether to restore the server list from the latest snapshot on startup,
+	// so servers stay listed after a crash or failover instead of needing to
+	// re-register.
+	API0_ServerList_SnapshotRestore bool `env:"ATLAS_API0_SERVERLIST_SNAPSHOT_RESTORE"`
+
+	// The maximum age of a snapshot to restore.
+	API0_ServerList_SnapshotMaxAge time.Duration `env:"ATLAS_API0_SERVERLIST_SNAPSHOT_MAX_AGE=10m"`
+
 	// Experimental option to use deterministic server ID generation based on
 	// the provided secret and the server info. The secret is used to prevent
 	// brute-forcing server IDs from the ID and known server info. If it begins
 	// with @, it is treated as the name of a systemd credential to load.
 	API0_ServerList_ExperimentalDeterministicServerIDSecret string `env:"ATLAS_API0_SERVERLIST_EXPERIMENTAL_DETERMINISTIC_SERVER_ID_SECRET" sdcreds:"load,trimspace"`
 
+	// An alternate server list ranking to serve to the percentage of clients
+	// (chosen by IP) set by the serverlist_canary feature flag, for comparing
+	// the atlas_api0_client_servers_variant_* metrics:
+	//  - population (most players first)
+	//  - fill (fullest servers with free slots first)
+	API0_ServerList_Canary string `env:"ATLAS_API0_SERVERLIST_CANARY"`
+
+	// The maximum number of server searches from a single IP per minute. If
+	// zero, searches are not rate limited.
+	API0_ServerList_SearchRateLimit int `env:"ATLAS_API0_SERVERLIST_SEARCH_RATE_LIMIT=60"`
+
 	// The storage to use for accounts:
 	//  - memory
 	//  - sqlite3:/path/to/atlas.db
+	//  - kv:/path/to/datadir (embedded, stored in accounts.kv)
 	API0_Storage_Accounts string `env:"ATLAS_API0_STORAGE_ACCOUNTS=memory"`
 
+	// The cache to use for username and game server location lookups:
+	//  - none
+	//  - memory[:max_entries] (in-process LRU, default 100000 entries)
+	//  - redis://[[username]:password@]host[:port][/db][?prefix=atlas:&timeout=1s]
+	API0_Cache string `env:"ATLAS_API0_CACHE=memory"`
+
 	// The storage to use for pdata:
 	//  - memory:compress
 	//  - sqlite3:/path/to/pdata.db
+	//  - kv:/path/to/datadir (embedded and compressed, stored in pdata.kv)
+	//  - shard:name=spec;name=spec... (consistent hashing across multiple
+	//    backends by shard name; players without pdata on their shard are
+	//    looked up on the others, and use atlasctl rebalance-pdata after
+	//    adding or removing shards)
 	API0_Storage_Pdata string `env:"ATLAS_API0_STORAGE_PDATA=memory:compress"`
 
+	// Secondary storage backends (in the same format as the primary ones) to
+	// also write accounts (including state and ban lists) and pdata to while
+	// migrating to them with atlasctl migrate-storage. Reads only use the
+	// primary storage, and write errors for the secondary storage are logged
+	// but otherwise ignored.
+	API0_Storage_Accounts_DualWrite string `env:"ATLAS_API0_STORAGE_ACCOUNTS_DUALWRITE"`
+	API0_Storage_Pdata_DualWrite    string `env:"ATLAS_API0_STORAGE_PDATA_DUALWRITE"`
+
+	// Whether to buffer pdata writes in memory and flush them to the pdata
+	// storage in batches, coalescing repeated writes for the same player:
+	//  - none
+	//  - memory (buffered writes are lost on crash)
+	//  - journal:/path/to/pdata.journal (buffered writes are synced to a
+	//    journal before responding and recovered from it on startup)
+	API0_Storage_Pdata_WriteBehind string `env:"ATLAS_API0_STORAGE_PDATA_WRITEBEHIND=none"`
+
+	// How often to flush buffered pdata writes.
+	API0_Storage_Pdata_WriteBehind_Interval time.Duration `env:"ATLAS_API0_STORAGE_PDATA_WRITEBEHIND_INTERVAL=1s"`
+
+	// The maximum number of players with buffered pdata writes. Once reached,
+	// writes for other players wait for the buffer to be flushed.
+	API0_Storage_Pdata_WriteBehind_MaxPending int `env:"ATLAS_API0_STORAGE_PDATA_WRITEBEHIND_MAX_PENDING=1000"`
+
+	// Comma-separated list of additional isolated communities to serve, as
+	// name=hostname or name=/path/prefix. Requests for the hostname (which
+	// must also be allowed by Host if it is set) or under the path prefix are
+	// routed to the tenant, which has a separate server list, bad words list,
+	// and storage (and therefore accounts, pdata, bans, ban lists, and
+	// reports). Other options, including the admin secret and hooks, are
+	// shared with the default tenant. Account links, analytics,
+	// notifications, and write-behind are not supported for tenants.
+	Tenants []string `env:"ATLAS_TENANTS"`
+
+	// The storage to use for tenant accounts and pdata, in the same format as
+	// API0_Storage_Accounts and API0_Storage_Pdata. {tenant} is replaced with
+	// the tenant name, and must be used if there are multiple tenants.
+	Tenant_Storage_Accounts string `env:"ATLAS_TENANT_STORAGE_ACCOUNTS=memory"`
+	Tenant_Storage_Pdata    string `env:"ATLAS_TENANT_STORAGE_PDATA=memory:compress"`
+
+	// The path to a file containing words to filter from tenant server names
+	// and descriptions (see API0_BadWords). {tenant} is replaced with the
+	// tenant name. It is reloaded on SIGHUP.
+	Tenant_BadWords string `env:"ATLAS_TENANT_BADWORDS"`
+
+	// The AES keys to encrypt pdata and account PII with in sqlite3 storage,
+	// in the form id:base64key[,id:base64key...]. New records are encrypted
+	// with the first key, and existing records are re-encrypted with it in
+	// the background on startup. Keys must not be removed until this is done.
+	API0_Storage_EncryptionKeys string `env:"ATLAS_API0_STORAGE_ENCRYPTION_KEYS" sdcreds:"load,trimspace"`
+
+	// Whether to record per-server statistics history (player counts,
+	// uptime, maps) to the accounts storage for /server/stats_history.
+	API0_ServerStats bool `env:"ATLAS_API0_SERVER_STATS"`
+
+	// The amount of time to keep hourly server statistics for.
+	API0_ServerStats_Retention time.Duration `env:"ATLAS_API0_SERVER_STATS_RETENTION=2160h"`
+
+	// The amount of time to keep 5-minute server statistics for before only
+	// the hourly ones remain.
+	API0_Retention_ServerStats time.Duration `env:"ATLAS_API0_RETENTION_SERVER_STATS=48h"`
+
+	// How often to run the retention jobs.
+	API0_Retention_Interval time.Duration `env:"ATLAS_API0_RETENTION_INTERVAL=24h"`
+
+	// The amount of time to keep expired auth sessions on accounts for. If
+	// zero, they are kept until replaced.
+	API0_Retention_Sessions time.Duration `env:"ATLAS_API0_RETENTION_SESSIONS"`
+
+	// The amount of time after the last auth to erase accounts without any
+	// account links (along with all of their data, including pdata). Banned
+	// accounts are kept. If zero, stale accounts are kept forever.
+	API0_Retention_StaleAccounts time.Duration `env:"ATLAS_API0_RETENTION_STALE_ACCOUNTS"`
+
+	// The amount of time to keep game server heartbeat history for. It is
+	// never kept for more than 24h.
+	API0_Retention_HeartbeatHistory time.Duration `env:"ATLAS_API0_RETENTION_HEARTBEAT_HISTORY"`
+
+	// Whether to compact the storage (VACUUM for sqlite3, log compaction for
+	// kv) after running the retention jobs.
+	API0_Retention_Compact bool `env:"ATLAS_API0_RETENTION_COMPACT"`
+
+	// How often to correlate banned accounts with possible alts using recorded
+	// IPs, server-provided hints, and username patterns. If zero, alt detection
+	// only runs when requested via the admin API. Results are only reported,
+	// never acted on automatically.
+	API0_AltDetectionInterval time.Duration `env:"ATLAS_API0_ALT_DETECTION_INTERVAL=6h"`
+
+	// The amount of time to keep account signals used for alt detection after
+	// they were last seen.
+	API0_AccountSignalRetention time.Duration `env:"ATLAS_API0_ACCOUNT_SIGNAL_RETENTION=2160h"`
+
+	// Comma-separated IP reputation flags (hosting, vpn, proxy) for which
+	// players must have a linked external account to authenticate. Requires
+	// account linking and IP2Proxy or an IP2Location database with the usage
+	// type field.
+	API0_IPReputationVerify string `env:"ATLAS_API0_IP_REPUTATION_VERIFY"`
+
+	// The maximum number of abuse reports a player can make within the report
+	// rate window. If zero, reports are not rate limited.
+	API0_ReportRateLimit int `env:"ATLAS_API0_REPORT_RATE_LIMIT=5"`
+
+	// The window for the report rate limit. Duplicate reports of the same
+	// player by the same reporter within the window are ignored.
+	API0_ReportRateWindow time.Duration `env:"ATLAS_API0_REPORT_RATE_WINDOW=1h"`
+
+	// The default durations of server delisting actions made with
+	// /admin/serverdelists. If zero, the action does not expire.
+	API0_ServerDelist_Warned   time.Duration `env:"ATLAS_API0_SERVER_DELIST_WARNED=168h"`
+	API0_ServerDelist_Delisted time.Duration `env:"ATLAS_API0_SERVER_DELIST_DELISTED=72h"`
+	API0_ServerDelist_Banned   time.Duration `env:"ATLAS_API0_SERVER_DELIST_BANNED"`
+
+	// How long a server remains warned after a delisting or ban expires, so
+	// repeat offenses are escalated further.
+	API0_ServerDelist_Cooldown time.Duration `env:"ATLAS_API0_SERVER_DELIST_COOLDOWN=720h"`
+
+	// Whether to store per-mode Glicko-2 skill ratings updated from match
+	// results submitted by game servers to /server/match_result.
+	API0_Ratings bool `env:"ATLAS_API0_RATINGS"`
+
+	// Whether to expose skill ratings via /player/ratings.
+	API0_Ratings_Public bool `env:"ATLAS_API0_RATINGS_PUBLIC"`
+
+	// Whether to store match summaries submitted by game servers to
+	// /server/match_result for /player/{uid}/matches.
+	API0_MatchHistory bool `env:"ATLAS_API0_MATCH_HISTORY"`
+
+	// The amount of time to keep match history for.
+	API0_MatchHistory_Retention time.Duration `env:"ATLAS_API0_MATCH_HISTORY_RETENTION=2160h"`
+
+	// Whether to accept chat and voice mutes reported by game servers to
+	// /server/mute, and to expose the aggregated status to game servers via
+	// /server/mute_status. Players muted by enough servers are also muted in
+	// the chat relay.
+	API0_MuteReports bool `env:"ATLAS_API0_MUTE_REPORTS"`
+
+	// The decayed number of distinct servers (by IP) which must have muted a
+	// player for them to be considered muted.
+	API0_MuteReports_Threshold int `env:"ATLAS_API0_MUTE_REPORTS_THRESHOLD=3"`
+
+	// How long it takes for a mute report to count for half as much.
+	API0_MuteReports_HalfLife time.Duration `env:"ATLAS_API0_MUTE_REPORTS_HALF_LIFE=168h"`
+
+	// How often to download the release and mod index URLs (including mirrors)
+	// to verify they are reachable and match their checksums. URLs failing
+	// the check are omitted from /client/update_manifest and /client/mods, and
+	// changes are sent to API0_Notify. If zero, mirrors are not checked.
+	API0_MirrorCheckInterval time.Duration `env:"ATLAS_API0_MIRROR_CHECK_INTERVAL=6h"`
+
+	// Whether to accept crash reports uploaded by game servers to
+	// /server/crash, which are aggregated by signature for /admin/crashes.
+	API0_Crashes bool `env:"ATLAS_API0_CRASHES"`
+
+	// The amount of time to keep crash signatures for after the last report.
+	API0_Crashes_Retention time.Duration `env:"ATLAS_API0_CRASHES_RETENTION=720h"`
+
+	// The maximum number of crash reports from a single server IP per hour.
+	API0_Crashes_RateLimit int `env:"ATLAS_API0_CRASHES_RATE_LIMIT=10"`
+
+	// Whether to store friends lists for /client/friends, which shows the
+	// servers accepted friends are connected to.
+	API0_Friends bool `env:"ATLAS_API0_FRIENDS"`
+
+	// Whether to store player block lists for /client/blocks, which prevent
+	// blocked players from being matched, partied, or friended with the
+	// player, and mark their relayed chat messages as hidden.
+	API0_Blocks bool `env:"ATLAS_API0_BLOCKS"`
+
+	// Whether to enable the cross-server chat relay at /server/chat. Player
+	// mutes are managed with /admin/chatmutes.
+	API0_ChatRelay bool `env:"ATLAS_API0_CHAT_RELAY"`
+
+	// The sink to forward relayed chat messages to (same format as
+	// API0_Notify):
+	//  - none
+	//  - discord:https://discord.com/api/webhooks/... (Discord webhook)
+	//  - http:https://example.com/path (JSON)
+	API0_ChatRelay_Bridge string `env:"ATLAS_API0_CHAT_RELAY_BRIDGE=none"`
+
+	// Whether to allow server owners to register a HTTPS URL at
+	// /server/webhook to receive signed events when their server is delisted,
+	// fails verification, or is banned. Requires state storage.
+	API0_ServerWebhooks bool `env:"ATLAS_API0_SERVER_WEBHOOKS"`
+
+	// The maximum number of pdata writes for a single player, and from a single
+	// game server, within the pdata write window. If zero, writes are not
+	// limited.
+	API0_PdataPlayerWriteLimit int `env:"ATLAS_API0_PDATA_PLAYER_WRITE_LIMIT=20"`
+	API0_PdataServerWriteLimit int `env:"ATLAS_API0_PDATA_SERVER_WRITE_LIMIT=600"`
+
+	// The window for the pdata write limits.
+	API0_PdataWriteWindow time.Duration `env:"ATLAS_API0_PDATA_WRITE_WINDOW=1m"`
+
+	// The average number of successful authentications, pdata writes, and
+	// abuse reports allowed per player per hour, enforced with a token bucket
+	// after the player is authenticated. If zero, the action is not limited.
+	API0_PlayerQuota_Auth       int `env:"ATLAS_API0_PLAYER_QUOTA_AUTH=120"`
+	API0_PlayerQuota_PdataWrite int `env:"ATLAS_API0_PLAYER_QUOTA_PDATA_WRITE=1200"`
+	API0_PlayerQuota_Report     int `env:"ATLAS_API0_PLAYER_QUOTA_REPORT=20"`
+
+	// The number of auth failures or registration rejections from a single
+	// IPv4 address or IPv6 /64 within the anomaly window before it is
+	// tarpitted, then blocked if it continues. If zero, IPs are not
+	// penalized.
+	API0_Anomaly_IPThreshold int `env:"ATLAS_API0_ANOMALY_IP_THRESHOLD"`
+
+	// Like API0_Anomaly_IPThreshold, but for IPv4 /24 and IPv6 /48 subnets.
+	API0_Anomaly_SubnetThreshold int `env:"ATLAS_API0_ANOMALY_SUBNET_THRESHOLD"`
+
+	// The sliding window for counting failures for anomaly detection.
+	API0_Anomaly_Window time.Duration `env:"ATLAS_API0_ANOMALY_WINDOW=5m"`
+
+	// The delay added to requests from tarpitted IPs.
+	API0_Anomaly_TarpitDelay time.Duration `env:"ATLAS_API0_ANOMALY_TARPIT_DELAY=3s"`
+
+	// How long anomaly tarpits and blocks last.
+	API0_Anomaly_Duration time.Duration `env:"ATLAS_API0_ANOMALY_DURATION=15m"`
+
+	// Whether to tag and aggressively rate limit IPs which request decoy paths
+	// or use decoy query parameters. If anomaly detection is enabled, they are
+	// also tarpitted immediately.
+	API0_Honeypot bool `env:"ATLAS_API0_HONEYPOT"`
+
+	// Overrides the default honeypot decoy paths and query parameters.
+	API0_Honeypot_Paths  []string `env:"ATLAS_API0_HONEYPOT_PATHS"`
+	API0_Honeypot_Params []string `env:"ATLAS_API0_HONEYPOT_PARAMS"`
+
+	// How long honeypot offenders remain tagged after their last hit.
+	API0_Honeypot_TagDuration time.Duration `env:"ATLAS_API0_HONEYPOT_TAG_DURATION=24h"`
+
+	// The maximum number of requests per minute from tagged honeypot
+	// offenders.
+	API0_Honeypot_RateLimit int `env:"ATLAS_API0_HONEYPOT_RATE_LIMIT=10"`
+
+	// The sink to send operational notifications (e.g., anomaly tarpits and
+	// blocks) to:
+	//  - none
+	//  - discord:https://discord.com/api/webhooks/... (Discord webhook)
+	//  - http:https://example.com/path (JSON)
+	API0_Notify string `env:"ATLAS_API0_NOTIFY=none"`
+
+	// The sink to export anonymized analytics events (player auth/join,
+	// server registration/removal) to:
+	//  - none
+	//  - http:https://example.com/path (batches of newline-delimited JSON)
+	API0_Analytics string `env:"ATLAS_API0_ANALYTICS=none"`
+
+	// The bearer token to send to the http analytics sink. If it begins with
+	// @, it is treated as the name of a systemd credential to load.
+	API0_Analytics_Token string `env:"ATLAS_API0_ANALYTICS_TOKEN" sdcreds:"load,trimspace"`
+
+	// The secret used to derive pseudonymous player identifiers for
+	// analytics. If not provided, a random one is generated on startup, and
+	// identifiers will change after restarting. If it begins with @, it is
+	// treated as the name of a systemd credential to load.
+	API0_Analytics_Key string `env:"ATLAS_API0_ANALYTICS_KEY" sdcreds:"load,trimspace"`
+
 	// The source to use for mainmenupromos:
 	//  - none
 	//  - file:/path/to/mainmenupromos.json
@@ -165,6 +665,108 @@ type Config struct {
 	// API0_MinimumLauncherVersion.
 	API0_MainMenuPromos_UpdateNeeded string `env:"ATLAS_API0_MAINMENUPROMOS_UPDATENEEDED=none"`
 
+	// Whether to require a proof-of-work challenge on the busiest
+	// unauthenticated endpoints. This can also be toggled at runtime via the
+	// admin API.
+	API0_AttackMode bool `env:"ATLAS_API0_ATTACK_MODE"`
+
+	// The number of leading zero bits required for the attack mode challenge.
+	// If zero, a reasonable default is used.
+	API0_AttackMode_Difficulty int `env:"ATLAS_API0_ATTACK_MODE_DIFFICULTY"`
+
+	// The paths to require the attack mode challenge on. If not provided, a
+	// reasonable default is used.
+	API0_AttackMode_Paths []string `env:"ATLAS_API0_ATTACK_MODE_PATHS"`
+
+	// The CAPTCHA provider to require on browser requests from IPs penalized
+	// by anomaly detection:
+	//  - none
+	//  - turnstile (Cloudflare Turnstile)
+	//  - hcaptcha
+	API0_Captcha string `env:"ATLAS_API0_CAPTCHA=none"`
+
+	// The public site key for the CAPTCHA widget.
+	API0_Captcha_SiteKey string `env:"ATLAS_API0_CAPTCHA_SITEKEY"`
+
+	// The secret key for verifying CAPTCHA responses. If it begins with @, it
+	// is treated as the name of a systemd credential to load.
+	API0_Captcha_Secret string `env:"ATLAS_API0_CAPTCHA_SECRET" sdcreds:"load,trimspace"`
+
+	// The browser-facing paths to require the CAPTCHA on.
+	API0_Captcha_Paths []string `env:"ATLAS_API0_CAPTCHA_PATHS=/client/servers,/server/owner_status,/server/stats_history,/server/webhook"`
+
+	// The path to a file containing words to filter from server names,
+	// descriptions, and relayed chat, one per line. Words are matched case-insensitively and
+	// replaced with asterisks. Blank lines and lines starting with # are
+	// ignored. It is reloaded on SIGHUP.
+	API0_BadWords string `env:"ATLAS_API0_BADWORDS"`
+
+	// The names of the hooks compiled in with api0.RegisterHook to enable, in
+	// the order they are called. If not provided, all registered hooks are
+	// enabled.
+	API0_Hooks []string `env:"ATLAS_API0_HOOKS"`
+
+	// The bearer token required to use the admin API (/admin/*). If not
+	// provided, the admin API is disabled. If it begins with @, it is treated
+	// as the name of a systemd credential to load.
+	API0_AdminSecret string `env:"ATLAS_API0_ADMIN_SECRET" sdcreds:"load,trimspace"`
+
+	// Whether to require a valid mTLS client certificate (i.e., a request to
+	// one of the mTLS addresses) for the admin API in addition to the admin
+	// secret.
+	API0_AdminRequireClientCert bool `env:"ATLAS_API0_ADMIN_REQUIRE_CLIENT_CERT"`
+
+	// Whether to require a valid mTLS client certificate for relay server
+	// list streams in addition to the relay token.
+	API0_RelayRequireClientCert bool `env:"ATLAS_API0_RELAY_REQUIRE_CLIENT_CERT"`
+
+	// The date (RFC3339 or YYYY-MM-DD) to advertise in the Sunset header for
+	// the legacy API (i.e., /v1/* and the unversioned paths used by
+	// NorthstarLauncher). If provided, legacy responses are also marked as
+	// deprecated with a link to the /v2/* equivalent. Legacy requests are
+	// still served after it.
+	API0_V1Sunset time.Time `env:"ATLAS_API0_V1_SUNSET"`
+
+	// The base64-encoded ed25519 seed used to sign trusted server
+	// attestations. If not provided, a random key is generated on startup.
+	API0_ServerAttestationKey string `env:"ATLAS_API0_SERVER_ATTESTATION_KEY" sdcreds:"load,trimspace"`
+
+	// The path to a JSON file containing the rotating ed25519 signing keys
+	// used to sign trusted server attestations and the update manifest
+	// instead of API0_ServerAttestationKey. The file is managed with
+	// `atlasctl rotate-signing-key`, and reloaded on SIGHUP. Signatures use
+	// the newest key, and the active keys are published at
+	// /.well-known/jwks.json.
+	API0_SigningKeys string `env:"ATLAS_API0_SIGNING_KEYS"`
+
+	// The OAuth2 client ID for linking Discord accounts. If not provided,
+	// Discord account linking is disabled.
+	API0_Link_DiscordClientID string `env:"ATLAS_API0_LINK_DISCORD_CLIENT_ID"`
+
+	// The OAuth2 client secret for linking Discord accounts. If it begins with
+	// @, it is treated as the name of a systemd credential to load.
+	API0_Link_DiscordClientSecret string `env:"ATLAS_API0_LINK_DISCORD_CLIENT_SECRET" sdcreds:"load,trimspace"`
+
+	// The OAuth2 redirect URL for linking Discord accounts. It must point to
+	// /accounts/link/discord/callback on this server, and must be registered
+	// with the Discord application.
+	API0_Link_DiscordRedirectURL string `env:"ATLAS_API0_LINK_DISCORD_REDIRECT_URL"`
+
+	// The Discord bot token for checking the guild roles of players with
+	// linked Discord accounts for server allowlists. If not provided, servers
+	// can only allowlist UIDs. Discord linking must be enabled. If it begins
+	// with @, it is treated as the name of a systemd credential to load.
+	API0_Link_DiscordBotToken string `env:"ATLAS_API0_LINK_DISCORD_BOT_TOKEN" sdcreds:"load,trimspace"`
+
+	// The Steam Web API key for verifying Steam session tickets. If not
+	// provided, Steam account linking is disabled. If it begins with @, it is
+	// treated as the name of a systemd credential to load.
+	API0_Link_SteamWebAPIKey string `env:"ATLAS_API0_LINK_STEAM_WEB_API_KEY" sdcreds:"load,trimspace"`
+
+	// The Steam app ID to verify session tickets for. If zero, Titanfall 2 is
+	// used.
+	API0_Link_SteamAppID int `env:"ATLAS_API0_LINK_STEAM_APP_ID"`
+
 	// Sets the source used for resolving usernames. If not specified, "origin"
 	// is used if OriginEmail is provided, otherwise, "none" is used.
 	//  - none (don't get usernames)
@@ -222,7 +824,7 @@ type Config struct {
 	// The path to use for static website files. If a file named redirects.json
 	// exists, it is read at startup, reloaded on SIGHUP, and used as a mapping
 	// of top-level names to URLs. Custom error pages can be named
-	// {status}.html.
+	// {status}.html. If not set, a built-in status page is served at /.
 	Web string `env:"ATLAS_WEB"`
 
 	// For the Funny:tm:
@@ -236,10 +838,119 @@ type Config struct {
 	// info, geo metrics will be disabled too.
 	IP2Location string `env:"ATLAS_IP2LOCATION"`
 
+	// The path to the IP2Proxy database, which should contain at least the
+	// proxy type field. It can be replaced and reloaded like the IP2Location
+	// database. If provided, auth sessions and server registrations are
+	// flagged if the IP is a VPN, proxy, or hosting provider. If the
+	// IP2Location database has the usage type field, it is also used to flag
+	// hosting providers.
+	IP2Proxy string `env:"ATLAS_IP2PROXY"`
+
+	// The lease backend to use for electing a single instance to run
+	// background jobs which write to shared storage (server stats recording,
+	// pruning, and alt detection) when running multiple instances:
+	//  - none (every instance runs them)
+	//  - storage (the account storage, which must be sqlite3)
+	//  - redis://[[username]:password@]host[:port][/db][?prefix=atlas:&timeout=1s]
+	//
+	// Jobs for in-memory state (e.g., the server list reaper, matchmaking,
+	// mirror checks, and snapshots) always run on every instance.
+	LeaderElection string `env:"ATLAS_LEADER_ELECTION=none"`
+
+	// The unique ID of this instance for leader election. If not provided, it
+	// is generated from the hostname and PID.
+	LeaderElectionID string `env:"ATLAS_LEADER_ELECTION_ID"`
+
+	// How long the leader lease is held without being renewed, which is the
+	// maximum time for another instance to take over if the leader goes away.
+	LeaderElectionTTL time.Duration `env:"ATLAS_LEADER_ELECTION_TTL=15s"`
+
+	// The backend to persist operational notifications (API0_Notify) and
+	// server webhooks to until they are delivered, so they survive restarts
+	// and unreliable receivers:
+	//  - none (they are kept in memory and dropped after a few failures)
+	//  - storage (the account storage, which must be sqlite3)
+	//
+	// Failed deliveries are retried with exponential backoff, and events are
+	// deduplicated across instances sharing the storage.
+	Outbox string `env:"ATLAS_OUTBOX=none"`
+
+	// The number of delivery attempts before an outbox item is dead-lettered.
+	OutboxMaxAttempts int `env:"ATLAS_OUTBOX_MAX_ATTEMPTS=10"`
+
+	// How long delivered and dead-lettered outbox items are kept, which is
+	// also how long they are deduplicated for.
+	OutboxRetention time.Duration `env:"ATLAS_OUTBOX_RETENTION=24h"`
+
+	// Where to export OpenTelemetry trace spans for requests, including the
+	// storage, cache, and Origin/EAX/gameserver calls made while handling them:
+	//  - none
+	//  - otlp:URL (OTLP/HTTP JSON, e.g., otlp:http://localhost:4318/v1/traces)
+	//
+	// Trace context is propagated using the W3C traceparent header, which is
+	// also set on responses.
+	Tracing string `env:"ATLAS_TRACING=none"`
+
+	// Additional headers (Name=Value) to send to the trace exporter.
+	TracingHeaders []string `env:"ATLAS_TRACING_HEADERS" sdcreds:"load,trimspace"`
+
+	// The percentage of new traces to sample.
+	TracingSamplePercent int `env:"ATLAS_TRACING_SAMPLE_PERCENT=100"`
+
+	// Whether to use the sampling decision from the traceparent header of
+	// incoming requests. This should only be enabled if all clients are
+	// trusted, since otherwise they can force traces to be recorded.
+	TracingTrustRemote bool `env:"ATLAS_TRACING_TRUST_REMOTE"`
+
+	// Comma-separated service level objectives for classes of endpoints, as
+	// name=target%[/latency]@/path/prefix[|/path/prefix...]. A request is bad
+	// if it returns a 5xx status or takes longer than the latency. Paths are
+	// matched without the tenant prefix, and requests for all tenants count
+	// towards the same objectives.
+	//
+	// Burn-rate alerts (page if the budget would be used up within 2 days,
+	// ticket within 5 days) are sent as notifications (API0_Notify).
+	SLO []string `env:"ATLAS_SLO?=auth=99.5%/2s@/client/origin_auth|/client/auth_with_server|/client/auth_with_self,serverlist=99.9%/500ms@/client/servers,server=99.9%/1s@/server"`
+
+	// The period the SLO error budget applies to.
+	SLOPeriod time.Duration `env:"ATLAS_SLO_PERIOD=720h"`
+
+	// The Vault server address for resolving secret references. Options which
+	// can be loaded from systemd credentials can also be set to a reference in
+	// the form secret:provider:ref, which is resolved at startup and on reload.
+	// The providers are:
+	//
+	//  - vault:path#key (HashiCorp Vault KV v1 or v2, e.g., secret:vault:secret/data/atlas#origin_password)
+	//  - sops:path#key (SOPS-encrypted file, where key is dot-separated, e.g., secret:sops:/etc/atlas/secrets.yaml#origin.password)
+	//  - file:name (file in SecretsDir or an absolute path, e.g., secret:file:origin_password)
+	//  - envfile:path#KEY (KEY=VALUE env file, e.g., secret:envfile:/etc/atlas/secrets.env#ORIGIN_PASSWORD)
+	SecretsVaultAddr string `env:"ATLAS_SECRETS_VAULT_ADDR"`
+
+	// The Vault token for resolving secret references. If it begins with @, it
+	// is treated as the name of a systemd credential to load. It may also be a
+	// file, sops, or envfile secret reference.
+	SecretsVaultToken string `env:"ATLAS_SECRETS_VAULT_TOKEN" sdcreds:"load,trimspace"`
+
+	// The Vault Enterprise namespace for resolving secret references.
+	SecretsVaultNamespace string `env:"ATLAS_SECRETS_VAULT_NAMESPACE"`
+
+	// The path to the sops binary for resolving secret references. If not
+	// provided, it is looked up in the PATH.
+	SecretsSOPS string `env:"ATLAS_SECRETS_SOPS"`
+
+	// The directory containing file secrets (e.g., docker secrets).
+	SecretsDir string `env:"ATLAS_SECRETS_DIR=/run/secrets"`
+
+	// The timeout for resolving each secret reference.
+	SecretsTimeout time.Duration `env:"ATLAS_SECRETS_TIMEOUT=30s"`
+
+	// The interval to re-fetch secret references at. Only the Origin
+	// credentials are updated without a restart. If zero, secrets are only
+	// re-fetched on SIGHUP.
+	SecretsRefresh time.Duration `env:"ATLAS_SECRETS_REFRESH"`
+
 	// For sd-notify.
 	NotifySocket string `env:"NOTIFY_SOCKET"`
-
-	// TODO: BadWords
 }
 
 // UnmarshalEnv unmarshals an array of environment variables into c, setting
@@ -323,7 +1034,19 @@ func (c *Config) UnmarshalEnv(es []string, incremental bool) error {
 				return fmt.Errorf("env %s (%T): parse %q: %w", key, cvf.Interface(), val, err)
 			}
 		case time.Duration:
-			if v, err := time.ParseDuration(val); err == nil {
+			if val == "" {
+				cvf.Set(reflect.ValueOf(time.Duration(0)))
+			} else if v, err := time.ParseDuration(val); err == nil {
+				cvf.Set(reflect.ValueOf(v))
+			} else {
+				return fmt.Errorf("env %s (%T): parse %q: %w", key, cvf.Interface(), val, err)
+			}
+		case time.Time:
+			if val == "" {
+				cvf.Set(reflect.ValueOf(time.Time{}))
+			} else if v, err := time.Parse(time.RFC3339, val); err == nil {
+				cvf.Set(reflect.ValueOf(v))
+			} else if v, err1 := time.Parse("2006-01-02", val); err1 == nil {
 				cvf.Set(reflect.ValueOf(v))
 			} else {
 				return fmt.Errorf("env %s (%T): parse %q: %w", key, cvf.Interface(), val, err)
@@ -363,7 +1086,7 @@ func (c *Config) UnmarshalEnv(es []string, incremental bool) error {
 			return fmt.Errorf("unknown environment variable %q", key)
 		}
 	}
-	return nil
+	return c.resolveSecrets()
 }
 
 func parseUIDGID(s string) (UIDGID, error) {
@@ -0,0 +1,136 @@
+package atlas
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/fault"
+	"github.com/r2northstar/atlas/pkg/nspkt"
+)
+
+// debugHandler serves pprof, runtime introspection, and registry size
+// endpoints. Requests are only allowed from loopback addresses, or if they use
+// secret (if non-empty) as a bearer token.
+func (s *Server) debugHandler(secret string) http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc("/debug/pprof/", pprof.Index) // also handles named profiles like goroutine?debug=2
+	m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	m.HandleFunc("/debug/runtime", s.serveDebugRuntime)
+	m.HandleFunc("/debug/registry", s.serveDebugRegistry)
+	m.Handle("/debug/nspkt", nspkt.DebugMonitorHandler(s.API0.NSPkt))
+	if s.Faults != nil {
+		m.HandleFunc("/debug/faults", s.serveDebugFaults)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "private, no-cache, no-store")
+		w.Header().Set("Expires", "0")
+		w.Header().Set("Pragma", "no-cache")
+
+		if ap, err := netip.ParseAddrPort(r.RemoteAddr); err != nil || !ap.Addr().IsLoopback() {
+			if tok := r.Header.Get("Authorization"); secret == "" || !strings.HasPrefix(tok, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(tok, "Bearer ")), []byte(secret)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="atlas-debug"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+		m.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) serveDebugRuntime(w http.ResponseWriter, r *http.Request) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	obj := map[string]any{
+		"go_version": runtime.Version(),
+		"goos":       runtime.GOOS,
+		"goarch":     runtime.GOARCH,
+		"num_cpu":    runtime.NumCPU(),
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"goroutines": runtime.NumGoroutine(),
+		"uptime":     time.Since(s.started).Truncate(time.Second).String(),
+		"memory": map[string]any{
+			"heap_alloc_bytes":   ms.HeapAlloc,
+			"heap_inuse_bytes":   ms.HeapInuse,
+			"heap_objects":       ms.HeapObjects,
+			"stack_inuse_bytes":  ms.StackInuse,
+			"sys_bytes":          ms.Sys,
+			"num_gc":             ms.NumGC,
+			"gc_pause_total":     time.Duration(ms.PauseTotalNs).String(),
+			"next_gc_bytes":      ms.NextGC,
+			"total_alloc_bytes":  ms.TotalAlloc,
+			"last_gc_unix_milli": time.Unix(0, int64(ms.LastGC)).UnixMilli(),
+		},
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		vcs := map[string]string{}
+		for _, x := range bi.Settings {
+			if strings.HasPrefix(x.Key, "vcs.") {
+				vcs[strings.TrimPrefix(x.Key, "vcs.")] = x.Value
+			}
+		}
+		obj["build"] = map[string]any{
+			"path":    bi.Path,
+			"version": bi.Main.Version,
+			"vcs":     vcs,
+		}
+	}
+	serveDebugJSON(w, obj)
+}
+
+func (s *Server) serveDebugRegistry(w http.ResponseWriter, r *http.Request) {
+	serveDebugJSON(w, s.API0.RegistryStats())
+}
+
+// serveDebugFaults gets the current fault injection rules, or replaces them
+// with the request body on PUT.
+func (s *Server) serveDebugFaults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		buf, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rs, err := fault.ParseRules(string(buf))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.Faults.SetRules(rs)
+		s.Logger.Warn().Str("rules", strings.TrimSpace(string(buf))).Msg("updated fault injection rules")
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	for _, x := range s.Faults.Rules() {
+		io.WriteString(w, x.String()+";\n")
+	}
+}
+
+func serveDebugJSON(w http.ResponseWriter, obj any) {
+	buf, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(buf, '\n'))
+}
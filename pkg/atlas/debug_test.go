@@ -0,0 +1,162 @@
+package atlas
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+)
+
+func TestDebugHandler(t *testing.T) {
+	var c Config
+	if err := c.UnmarshalEnv([]string{
+		"ATLAS_LOG_STDOUT=false",
+		"ATLAS_API0_ADMIN_SECRET=secret",
+	}, false); err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+	s, err := NewServer(&c)
+	if err != nil {
+		t.Fatalf("initialize server: %v", err)
+	}
+	if s.Debug == nil {
+		t.Fatalf("expected debug handler")
+	}
+
+	do := func(h http.Handler, path, remote, auth string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = remote
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Guard", func(t *testing.T) {
+		for _, tc := range []struct {
+			name   string
+			remote string
+			auth   string
+			status int
+		}{
+			{"Loopback4", "127.0.0.1:1234", "", http.StatusOK},
+			{"Loopback6", "[::1]:1234", "", http.StatusOK},
+			{"LoopbackWrongSecret", "127.0.0.1:1234", "Bearer asd", http.StatusOK},
+			{"Remote", "192.0.2.1:1234", "", http.StatusUnauthorized},
+			{"RemoteSecret", "192.0.2.1:1234", "Bearer secret", http.StatusOK},
+			{"RemoteWrongSecret", "192.0.2.1:1234", "Bearer asd", http.StatusUnauthorized},
+			{"RemotePrefixSecret", "192.0.2.1:1234", "Bearer secre", http.StatusUnauthorized},
+			{"RemoteNotBearer", "192.0.2.1:1234", "secret", http.StatusUnauthorized},
+			{"RemoteBasic", "192.0.2.1:1234", "Basic secret", http.StatusUnauthorized},
+			{"InvalidRemote", "invalid", "", http.StatusUnauthorized},
+		} {
+			w := do(s.Debug, "/debug/registry", tc.remote, tc.auth)
+			if w.Code != tc.status {
+				t.Errorf("%s: expected status %d, got %d", tc.name, tc.status, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("%s: expected WWW-Authenticate header", tc.name)
+			}
+			if cc := w.Header().Get("Cache-Control"); !strings.Contains(cc, "no-store") {
+				t.Errorf("%s: expected response not to be cached, got Cache-Control %q", tc.name, cc)
+			}
+		}
+
+		// without an admin secret, only loopback is allowed
+		h := s.debugHandler("")
+		if w := do(h, "/debug/registry", "192.0.2.1:1234", "Bearer "); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected empty secret to be rejected, got status %d", w.Code)
+		}
+		if w := do(h, "/debug/registry", "127.0.0.1:1234", ""); w.Code != http.StatusOK {
+			t.Errorf("expected loopback to be allowed without a secret, got status %d", w.Code)
+		}
+	})
+
+	t.Run("Pprof", func(t *testing.T) {
+		for _, path := range []string{
+			"/debug/pprof/",
+			"/debug/pprof/cmdline",
+			"/debug/pprof/goroutine?debug=1",
+			"/debug/pprof/heap?debug=1",
+		} {
+			if w := do(s.Debug, path, "127.0.0.1:1234", ""); w.Code != http.StatusOK {
+				t.Errorf("%s: expected status 200, got %d", path, w.Code)
+			}
+		}
+		if w := do(s.Debug, "/debug/pprof/", "192.0.2.1:1234", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected pprof to be guarded, got status %d", w.Code)
+		}
+		if w := do(s.Debug, "/debug/other", "127.0.0.1:1234", ""); w.Code != http.StatusNotFound {
+			t.Errorf("expected unknown path to be not found, got status %d", w.Code)
+		}
+	})
+
+	t.Run("Runtime", func(t *testing.T) {
+		w := do(s.Debug, "/debug/runtime", "127.0.0.1:1234", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("incorrect content type %q", ct)
+		}
+		var obj struct {
+			GoVersion  string         `json:"go_version"`
+			Goroutines int            `json:"goroutines"`
+			Uptime     string         `json:"uptime"`
+			Memory     map[string]any `json:"memory"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &obj); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		if obj.GoVersion == "" || obj.Goroutines == 0 || obj.Uptime == "" {
+			t.Errorf("incorrect runtime info %+v", obj)
+		}
+		if _, ok := obj.Memory["heap_alloc_bytes"]; !ok {
+			t.Errorf("expected memory stats, got %v", obj.Memory)
+		}
+	})
+
+	t.Run("Registry", func(t *testing.T) {
+		registry := func() api0.RegistryStats {
+			t.Helper()
+			w := do(s.Debug, "/debug/registry", "127.0.0.1:1234", "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status %d", w.Code)
+			}
+			var st api0.RegistryStats
+			if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			return st
+		}
+		if st := registry(); st.ServerList.Alive != 0 || st.ServerList.IndexedByAddr != 0 {
+			t.Errorf("expected empty server list, got %+v", st.ServerList)
+		}
+		if _, err := s.API0.ServerList.ServerHybridUpdatePut(nil, &api0.Server{
+			Addr:     netip.MustParseAddrPort("192.0.2.1:37015"),
+			AuthPort: 8081,
+			Name:     "test",
+		}, api0.ServerListLimit{}); err != nil {
+			t.Fatalf("add server: %v", err)
+		}
+		if st := registry(); st.ServerList.Pending != 1 || st.ServerList.IndexedByAddr != 1 || st.ServerList.IndexedByID != 1 || st.ServerList.IndexedByAuthAddr != 1 {
+			t.Errorf("expected one pending server, got %+v", st.ServerList)
+		}
+	})
+
+	t.Run("NSPkt", func(t *testing.T) {
+		w := do(s.Debug, "/debug/nspkt", "127.0.0.1:1234", "")
+		if w.Code != http.StatusOK {
+			t.Errorf("unexpected status %d", w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("incorrect content type %q", ct)
+		}
+	})
+}
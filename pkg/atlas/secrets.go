@@ -0,0 +1,113 @@
+package atlas
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/secrets"
+)
+
+// secretConfig contains options which are updated when secrets are refreshed.
+type secretConfig struct {
+	OriginEmail    string
+	OriginPassword string
+	OriginTOTP     string
+}
+
+func newSecretConfig(c *Config) *secretConfig {
+	return &secretConfig{
+		OriginEmail:    c.OriginEmail,
+		OriginPassword: c.OriginPassword,
+		OriginTOTP:     c.OriginTOTP,
+	}
+}
+
+// secretsResolver creates a resolver for secret references using the
+// providers configured in c.
+func (c *Config) secretsResolver() secrets.Resolver {
+	r := secrets.Resolver{
+		"sops":    &secrets.SOPS{Binary: c.SecretsSOPS},
+		"file":    &secrets.Dir{Path: c.SecretsDir},
+		"envfile": secrets.EnvFile{},
+	}
+	if c.SecretsVaultAddr != "" {
+		r["vault"] = &secrets.Vault{
+			Addr:      c.SecretsVaultAddr,
+			Token:     c.SecretsVaultToken,
+			Namespace: c.SecretsVaultNamespace,
+		}
+	}
+	return r
+}
+
+// resolveSecrets resolves secret references in options which can be loaded
+// from systemd credentials.
+func (c *Config) resolveSecrets() error {
+	timeout := c.SecretsTimeout
+	if timeout <= 0 {
+		timeout = time.Second * 30
+	}
+	resolve := func(r secrets.Resolver, key, v string) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		v, err := r.Resolve(ctx, v)
+		if err != nil {
+			return "", fmt.Errorf("env %s: %w", key, err)
+		}
+		return v, nil
+	}
+
+	// the vault token itself can't come from vault
+	if secrets.IsRef(c.SecretsVaultToken) {
+		r := c.secretsResolver()
+		delete(r, "vault")
+		v, err := resolve(r, "ATLAS_SECRETS_VAULT_TOKEN", c.SecretsVaultToken)
+		if err != nil {
+			return err
+		}
+		c.SecretsVaultToken = v
+	}
+
+	var r secrets.Resolver
+	cv := reflect.ValueOf(c).Elem()
+	for _, ctf := range reflect.VisibleFields(cv.Type()) {
+		if mode, _, _ := strings.Cut(ctf.Tag.Get("sdcreds"), ","); mode != "load" {
+			continue
+		}
+		cvf := cv.FieldByIndex(ctf.Index)
+		if cvf.Kind() != reflect.String || !secrets.IsRef(cvf.String()) {
+			continue
+		}
+		if r == nil {
+			r = c.secretsResolver()
+		}
+		key, _, _ := strings.Cut(ctf.Tag.Get("env"), "=")
+		v, err := resolve(r, strings.TrimSuffix(key, "?"), cvf.String())
+		if err != nil {
+			return err
+		}
+		cvf.SetString(v)
+	}
+	return nil
+}
+
+// refreshSecrets re-fetches secret references using LoadConfig and applies
+// the options in secretConfig.
+func (s *Server) refreshSecrets() error {
+	if s.LoadConfig == nil {
+		return nil
+	}
+	c, err := s.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if sc := newSecretConfig(c); *sc != *s.secrets.Load() {
+		s.secrets.Store(sc)
+		s.Logger.Info().Msg("refreshed secrets")
+	}
+	return nil
+}
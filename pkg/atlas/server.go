@@ -3,7 +3,9 @@ package atlas
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,11 +14,14 @@ import (
 	"net"
 	"net/http"
 	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
@@ -24,13 +29,30 @@ import (
 	"github.com/pg9182/ip2x"
 	"github.com/r2northstar/atlas/db/atlasdb"
 	"github.com/r2northstar/atlas/db/pdatadb"
+	"github.com/r2northstar/atlas/pkg/accesslog"
+	"github.com/r2northstar/atlas/pkg/analytics"
 	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/cache"
 	"github.com/r2northstar/atlas/pkg/cloudflare"
+	"github.com/r2northstar/atlas/pkg/discord"
 	"github.com/r2northstar/atlas/pkg/eax"
+	"github.com/r2northstar/atlas/pkg/fault"
+	"github.com/r2northstar/atlas/pkg/keyring"
+	"github.com/r2northstar/atlas/pkg/kvstore"
+	"github.com/r2northstar/atlas/pkg/leader"
 	"github.com/r2northstar/atlas/pkg/memstore"
+	"github.com/r2northstar/atlas/pkg/mtls"
+	"github.com/r2northstar/atlas/pkg/notify"
 	"github.com/r2northstar/atlas/pkg/nspkt"
 	"github.com/r2northstar/atlas/pkg/origin"
+	"github.com/r2northstar/atlas/pkg/outbox"
+	"github.com/r2northstar/atlas/pkg/pdatashard"
 	"github.com/r2northstar/atlas/pkg/regionmap"
+	"github.com/r2northstar/atlas/pkg/signkeys"
+	"github.com/r2northstar/atlas/pkg/slo"
+	"github.com/r2northstar/atlas/pkg/storagemigrate"
+	"github.com/r2northstar/atlas/pkg/trace"
+	"github.com/r2northstar/atlas/pkg/writebehind"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
 	"golang.org/x/mod/semver"
@@ -42,31 +64,58 @@ type Server struct {
 	Addr          []string
 	AddrTLS       []string
 	AddrUDP       netip.AddrPort
+	AddrDebug     string
+	AddrMTLS      []string
 	Handler       http.Handler
+	Debug         http.Handler
 	Web           http.Handler
 	Redirects     map[string]string
 	NotifySocket  string
 	MetricsSecret string
 	API0          *api0.Handler
+	Tenants       []*Tenant
+	Analytics     *analytics.HTTPExporter
+	Notify        *notify.Webhook
+	ChatBridge    *notify.Webhook
+	WriteBehind   *writebehind.PdataStorage
+	Leader        *leader.Elector
+	Tracing       *trace.OTLPExporter
+	SLO           *slo.Tracker
+	AccessLog     *accesslog.Logger
 	Middleware    []func(http.Handler) http.Handler
 	TLSConfig     *tls.Config
-
-	reload []func()
-	closed bool
+	MTLSConfig    *tls.Config
+
+	// Faults, if non-nil, injects faults into dependencies for resilience
+	// testing.
+	Faults *fault.Injector
+
+	// LoadConfig, if provided, loads the current configuration when reloading.
+	// Only options supported by Reload are applied.
+	LoadConfig func() (*Config, error)
+
+	reload            []func()
+	reconfigure       []func(*Config)
+	reloadMu          sync.Mutex
+	rotateKeys        []func(context.Context) (int, error)
+	retentionInterval time.Duration
+	detectAlts        time.Duration
+	mirrorCheck       time.Duration
+	snapshot          string
+	snapshotInterval  time.Duration
+	secrets           atomic.Pointer[secretConfig]
+	secretsRefresh    time.Duration
+	snapshotMaxAge    time.Duration
+	closed            bool
+	started           time.Time
 }
 
 // NewServer configures a new server using c, which is assumed to be initialized
 // to default or configured values (as done by UnmarshalEnv). It will perform
 // any additional config checks as required.
 func NewServer(c *Config) (*Server, error) {
-	if c.API0_MinimumLauncherVersion != "" && !semver.IsValid("v"+strings.TrimPrefix(c.API0_MinimumLauncherVersion, "v")) {
-		return nil, fmt.Errorf("invalid minimum launcher version semver %q", c.API0_MinimumLauncherVersion)
-	}
-	if c.API0_MinimumLauncherVersionClient != "" && !semver.IsValid("v"+strings.TrimPrefix(c.API0_MinimumLauncherVersionClient, "v")) {
-		return nil, fmt.Errorf("invalid minimum launcher client version semver %q", c.API0_MinimumLauncherVersionClient)
-	}
-	if c.API0_MinimumLauncherVersionServer != "" && !semver.IsValid("v"+strings.TrimPrefix(c.API0_MinimumLauncherVersionServer, "v")) {
-		return nil, fmt.Errorf("invalid minimum launcher server version semver %q", c.API0_MinimumLauncherVersionServer)
+	if err := checkReloadableConfig(c); err != nil {
+		return nil, err
 	}
 
 	var s Server
@@ -75,9 +124,16 @@ func NewServer(c *Config) (*Server, error) {
 	s.Addr = c.Addr
 	s.AddrTLS = c.AddrTLS
 	s.AddrUDP = c.AddrUDP
+	s.AddrDebug = c.AddrDebug
 
 	s.NotifySocket = c.NotifySocket
 
+	s.secrets.Store(newSecretConfig(c))
+	s.secretsRefresh = c.SecretsRefresh
+	s.reconfigure = append(s.reconfigure, func(c *Config) {
+		s.secrets.Store(newSecretConfig(c))
+	})
+
 	if c.Web != "" {
 		if p, err := filepath.Abs(c.Web); err == nil {
 			var redirects sync.Map
@@ -175,23 +231,27 @@ func NewServer(c *Config) (*Server, error) {
 		}
 	}
 
-	if l, fn, err := configureLogging(c); err == nil {
+	if l, fn, fnc, err := configureLogging(c); err == nil {
 		s.Logger = l
 		s.reload = append(s.reload, fn)
+		s.reconfigure = append(s.reconfigure, fnc)
 	} else {
 		return nil, fmt.Errorf("initialize logging: %w", err)
 	}
 
 	defer func() {
 		if !success {
-			if s.API0 != nil {
-				if s.API0.AccountStorage != nil {
-					if c, ok := s.API0.AccountStorage.(io.Closer); ok {
+			for _, h := range s.api0Handlers() {
+				if h == nil {
+					continue
+				}
+				if h.AccountStorage != nil {
+					if c, ok := h.AccountStorage.(io.Closer); ok {
 						c.Close()
 					}
 				}
-				if s.API0.PdataStorage != nil {
-					if c, ok := s.API0.PdataStorage.(io.Closer); ok {
+				if h.PdataStorage != nil {
+					if c, ok := h.PdataStorage.(io.Closer); ok {
 						c.Close()
 					}
 				}
@@ -252,7 +312,44 @@ func NewServer(c *Config) (*Server, error) {
 		}))
 	}
 
+	if t, x, err := configureTracing(c, s.Logger.With().Str("component", "trace").Logger()); err != nil {
+		return nil, fmt.Errorf("initialize tracing: %w", err)
+	} else if t != nil {
+		s.Tracing = x
+		m.Add(t.Middleware)
+	}
+
+	if len(c.SLO) != 0 {
+		s.SLO = &slo.Tracker{
+			Period: c.SLOPeriod,
+			AlertHook: func(a slo.Alert) {
+				s.notifySLO(a)
+			},
+		}
+		for _, x := range c.SLO {
+			o, err := slo.ParseObjective(x)
+			if err != nil {
+				return nil, fmt.Errorf("initialize slo: %w", err)
+			}
+			s.SLO.Objectives = append(s.SLO.Objectives, o)
+		}
+	}
+
+	if x, reopen, err := configureAccessLog(c, s.untenantedPath); err != nil {
+		return nil, fmt.Errorf("initialize access log: %w", err)
+	} else if x != nil {
+		s.AccessLog = x
+		s.reload = append(s.reload, reopen)
+	}
+
 	m.Add(hlog.AccessHandler(func(r *http.Request, status, size int, duration time.Duration) {
+		if s.SLO != nil {
+			s.SLO.Observe(s.untenantedPath(r), status, duration)
+		}
+		if s.AccessLog != nil {
+			s.AccessLog.Log(r, status, size, duration)
+			return
+		}
 		e := s.Logger.Info()
 		if rid, ok := hlog.IDFromRequest(r); ok {
 			e = e.Stringer("rid", rid)
@@ -269,38 +366,49 @@ func NewServer(c *Config) (*Server, error) {
 			Msg("handle request")
 	}))
 
+	if c.Compress {
+		m.Add(func(h http.Handler) http.Handler {
+			return &compressHandler{
+				Handler: h,
+				MinSize: c.CompressMinSize,
+				Types:   c.CompressTypes,
+			}
+		})
+	}
+
 	m.Add(hlog.NewHandler(s.Logger.With().Str("component", "api0").Logger()))
 	m.Add(hlog.RequestIDHandler("rid", ""))
 
-	s.API0 = &api0.Handler{
-		NSPkt: nspkt.NewListener(),
-		ServerList: api0.NewServerList(c.API0_ServerList_DeadTime, c.API0_ServerList_GhostTime, c.API0_ServerList_VerifyTime, api0.ServerListConfig{
-			ExperimentalDeterministicServerIDSecret: c.API0_ServerList_ExperimentalDeterministicServerIDSecret,
-			AllowUwuify:                             c.AllowJokes,
-		}),
-		MaxServers:                   c.API0_MaxServers,
-		MaxServersPerIP:              c.API0_MaxServersPerIP,
-		InsecureDevNoCheckPlayerAuth: c.API0_InsecureDevNoCheckPlayerAuth,
-		MinimumLauncherVersionClient: c.API0_MinimumLauncherVersionClient,
-		MinimumLauncherVersionServer: c.API0_MinimumLauncherVersionServer,
-		TokenExpiryTime:              c.API0_TokenExpiryTime,
-		AllowGameServerIPv6:          c.API0_AllowGameServerIPv6,
-	}
-	if v := c.API0_MinimumLauncherVersion; v != "" {
-		if s.API0.MinimumLauncherVersionClient == "" {
-			s.API0.MinimumLauncherVersionClient = v
-		}
-		if s.API0.MinimumLauncherVersionServer == "" {
-			s.API0.MinimumLauncherVersionServer = v
-		}
+	if x, err := s.newAPI0Handler(c, ""); err == nil {
+		s.API0 = x
+	} else {
+		return nil, err
 	}
+	s.API0.NSPkt = nspkt.NewListener()
 
 	s.API0.NotFound = new(middlewares).
 		Add(hlog.NewHandler(s.Logger)).
 		Add(hlog.RequestIDHandler("rid", "")).
 		Then(http.HandlerFunc(s.serveRest))
 
-	if org, err := configureOrigin(c, s.Logger.With().Str("component", "origin").Logger()); err == nil {
+	if f, err := configureFaults(c); err == nil {
+		if f != nil {
+			s.Logger.Warn().Str("rules", c.Faults).Msg("fault injection is enabled; this must not be used in production")
+			s.Faults = f
+			http.DefaultClient.Transport = f.Transport(http.DefaultClient.Transport, faultHTTPOp)
+		}
+	} else {
+		return nil, fmt.Errorf("initialize fault injection: %w", err)
+	}
+	if hs, err := api0.RegisteredHooks(c.API0_Hooks...); err == nil {
+		for _, h := range hs {
+			s.Logger.Info().Str("hook", h.HookName()).Msg("enabled api0 hook")
+		}
+		s.API0.Hooks = hs
+	} else {
+		return nil, fmt.Errorf("initialize hooks: %w", err)
+	}
+	if org, err := configureOrigin(c, s.secrets.Load, s.Logger.With().Str("component", "origin").Logger()); err == nil {
 		s.API0.OriginAuthMgr = org
 	} else {
 		return nil, fmt.Errorf("initialize origin auth: %w", err)
@@ -310,21 +418,146 @@ func NewServer(c *Config) (*Server, error) {
 	} else {
 		return nil, fmt.Errorf("initialize eax: %w", err)
 	}
-	if x, err := configureUsernameSource(c); err == nil {
-		s.API0.UsernameSource = x
-	} else {
-		return nil, fmt.Errorf("initialize username lookup: %w", err)
-	}
 	if astore, err := configureAccountStorage(c); err == nil {
 		s.API0.AccountStorage = astore
 	} else {
 		return nil, fmt.Errorf("initialize account storage: %w", err)
 	}
+	if x, err := configureCache(c); err == nil {
+		s.API0.Cache = x
+	} else {
+		return nil, fmt.Errorf("initialize cache: %w", err)
+	}
+	if x, err := configureLeader(c, s.API0.AccountStorage, s.Logger.With().Str("component", "leader").Logger()); err == nil {
+		s.Leader = x
+	} else {
+		return nil, fmt.Errorf("initialize leader election: %w", err)
+	}
+	if err := configureAccountStorageFeatures(c, s.API0); err != nil {
+		return nil, fmt.Errorf("initialize account storage: %w", err)
+	}
+	if s.API0.AccountSignalStorage != nil {
+		s.detectAlts = c.API0_AltDetectionInterval
+	}
+	s.mirrorCheck = c.API0_MirrorCheckInterval
+	s.retentionInterval = c.API0_Retention_Interval
+	if x, err := configureAnalytics(c, s.Logger.With().Str("component", "analytics").Logger()); err == nil {
+		if x != nil {
+			s.Analytics = x
+			s.API0.Analytics = func(ev api0.AnalyticsEvent) {
+				x.Publish(ev)
+			}
+		}
+	} else {
+		return nil, fmt.Errorf("initialize analytics: %w", err)
+	}
+	if x, err := configureNotify(c.API0_Notify, s.Logger.With().Str("component", "notify").Logger()); err == nil {
+		if x != nil {
+			x.Outbox = s.API0.Outbox
+			x.OutboxOptions = s.API0.OutboxOptions
+			x.Logger = s.Logger.With().Str("component", "notify").Logger()
+			s.Notify = x
+			s.API0.Notify = func(n api0.Notification) {
+				m := notify.Message{
+					Time: n.Time,
+					Type: string(n.Type),
+					Text: n.Message,
+				}
+				ks := make([]string, 0, len(n.Fields))
+				for k := range n.Fields {
+					ks = append(ks, k)
+				}
+				sort.Strings(ks)
+				for _, k := range ks {
+					m.Fields = append(m.Fields, notify.Field{Name: k, Value: n.Fields[k]})
+				}
+				x.Publish(m)
+			}
+		}
+	} else {
+		return nil, fmt.Errorf("initialize notifications: %w", err)
+	}
+	if x, err := configureNotify(c.API0_ChatRelay_Bridge, s.Logger.With().Str("component", "chatbridge").Logger()); err == nil {
+		if x != nil {
+			x.Name = "chatbridge"
+			s.ChatBridge = x
+			s.API0.ChatBridge = func(m api0.ChatMessage) {
+				x.Publish(notify.Message{
+					Time: m.Time,
+					Type: "[" + string(m.Channel) + "] " + m.Username,
+					Text: m.Text,
+					Fields: []notify.Field{
+						{Name: "server", Value: m.ServerName},
+						{Name: "uid", Value: strconv.FormatUint(m.UID, 10)},
+					},
+				})
+			}
+		}
+	} else {
+		return nil, fmt.Errorf("initialize chat bridge: %w", err)
+	}
+	if err := configureAccountLinks(c, s.API0); err != nil {
+		return nil, fmt.Errorf("configure account links: %w", err)
+	}
+	if fn := c.API0_SigningKeys; fn != "" {
+		if c.API0_ServerAttestationKey != "" {
+			return nil, fmt.Errorf("initialize signing keys: server attestation key must not be set when using signing keys")
+		}
+		ks, err := signkeys.ReadFile(fn)
+		if err != nil {
+			return nil, fmt.Errorf("initialize signing keys: %w", err)
+		}
+		set, err := signkeys.NewSet(ks...)
+		if err != nil {
+			return nil, fmt.Errorf("initialize signing keys: %w", err)
+		}
+		s.reload = append(s.reload, func() {
+			if ks, err := signkeys.ReadFile(fn); err != nil {
+				s.Logger.Err(err).Msg("failed to reload signing keys")
+			} else if err := set.Replace(ks); err != nil {
+				s.Logger.Err(err).Msg("failed to reload signing keys")
+			} else {
+				s.Logger.Info().Str("kid", set.Current().ID).Msg("reloaded signing keys")
+			}
+		})
+		s.API0.SigningKeys = set
+	}
 	if pstore, err := configurePdataStorage(c); err == nil {
 		s.API0.PdataStorage = pstore
 	} else {
 		return nil, fmt.Errorf("initialize pdata storage: %w", err)
 	}
+	s.API0.Compactors = storageCompactors(s.API0.AccountStorage, s.API0.PdataStorage)
+	setStorageLogger(s.Logger.With().Str("component", "storage").Logger(), s.API0.AccountStorage, s.API0.PdataStorage)
+	if c.API0_Storage_EncryptionKeys != "" {
+		for _, x := range []any{s.API0.AccountStorage, s.API0.PdataStorage} {
+			if r, ok := x.(interface {
+				RotateKeys(context.Context) (int, error)
+			}); ok {
+				s.rotateKeys = append(s.rotateKeys, r.RotateKeys)
+			}
+		}
+	}
+	if err := configureDualWrite(c, s.API0, s.Logger.With().Str("component", "dualwrite").Logger()); err != nil {
+		return nil, fmt.Errorf("initialize dual-write storage: %w", err)
+	}
+	if x, err := configureWriteBehind(c, s.API0.PdataStorage, s.Logger.With().Str("component", "writebehind").Logger()); err == nil {
+		if x != nil {
+			s.WriteBehind = x
+			s.API0.PdataStorage = x
+		}
+	} else {
+		return nil, fmt.Errorf("initialize write-behind pdata storage: %w", err)
+	}
+	if f := s.Faults; f != nil {
+		// wrap these last so the optional interfaces are detected on the
+		// underlying storage
+		s.API0.AccountStorage = f.AccountStorage(s.API0.AccountStorage)
+		s.API0.PdataStorage = f.PdataStorage(s.API0.PdataStorage)
+		if s.API0.Cache != nil {
+			s.API0.Cache = f.Cache(s.API0.Cache)
+		}
+	}
 	if mmp, err := configureMainMenuPromos(c); err == nil {
 		s.API0.MainMenuPromos = mmp
 	} else {
@@ -350,10 +583,57 @@ func NewServer(c *Config) (*Server, error) {
 	} else {
 		return nil, fmt.Errorf("initialize region map: %w", err)
 	}
+	if ip2p, err := configureIP2Proxy(c); err == nil {
+		if ip2p != nil {
+			s.reload = append(s.reload, func() {
+				if err := ip2p.Load(""); err != nil {
+					s.Logger.Err(err).Msg("failed to reload ip2proxy database")
+				}
+			})
+		}
+		s.API0.LookupIPReputation = ipReputationLookup(ip2p, s.API0.LookupIP)
+		s.API0.LookupIPNetwork = ipNetworkLookup(ip2p, s.API0.LookupIP)
+	} else {
+		return nil, fmt.Errorf("initialize ip2proxy: %w", err)
+	}
+	if err := configureIPReputationVerify(c, s.API0); err != nil {
+		return nil, fmt.Errorf("configure ip reputation verification: %w", err)
+	}
 
 	s.MetricsSecret = c.MetricsSecret
 
-	s.Handler = m.Then(s.API0)
+	mv, err := configureMTLS(c)
+	if err != nil {
+		return nil, fmt.Errorf("initialize mtls: %w", err)
+	}
+	if mv != nil {
+		s.reload = append(s.reload, func() {
+			if err := mv.Reload(); err != nil {
+				s.Logger.Err(err).Msg("failed to reload mtls crls")
+			}
+		})
+		s.API0.VerifyClientCert = mv.Verified
+	} else if c.API0_AdminRequireClientCert || c.API0_RelayRequireClientCert {
+		return nil, fmt.Errorf("initialize mtls: client certificates are required, but no client ca was provided")
+	}
+	if err := s.configureTenants(c, s.API0); err != nil {
+		return nil, fmt.Errorf("initialize tenants: %w", err)
+	}
+	if c.API0_ServerList_Snapshot != "" {
+		s.snapshot = c.API0_ServerList_Snapshot
+		s.snapshotInterval = c.API0_ServerList_SnapshotInterval
+		s.snapshotMaxAge = c.API0_ServerList_SnapshotMaxAge
+		if c.API0_ServerList_SnapshotRestore {
+			if err := s.restoreSnapshots(); err != nil {
+				return nil, fmt.Errorf("initialize server list: %w", err)
+			}
+		}
+	}
+
+	s.API0.NSPkt.HandleAtlasHeartbeats(udpHeartbeatHandler(s.API0, s.Tenants))
+
+	s.Handler = m.Then(tenantHandler(s.API0, s.Tenants))
+	s.Debug = s.debugHandler(c.API0_AdminSecret)
 
 	if cfg, err := configureServerTLS(c); err == nil {
 		s.TLSConfig = cfg
@@ -375,10 +655,177 @@ func NewServer(c *Config) (*Server, error) {
 		}
 	}
 
+	if len(c.AddrMTLS) != 0 {
+		if mv == nil {
+			return nil, fmt.Errorf("initialize mtls: no client ca provided for mtls addresses")
+		}
+		s.AddrMTLS = c.AddrMTLS
+		s.MTLSConfig = mv.ServerConfig(s.TLSConfig)
+	}
+
 	success = true
 	return &s, nil
 }
 
+// newAPI0Handler creates an api0 handler with the options from c for tenant
+// (or the default tenant if empty). It doesn't open storage or initialize
+// dependencies shared between tenants. The reloadable options are applied and
+// updated on reload.
+func (s *Server) newAPI0Handler(c *Config, tenant string) (*api0.Handler, error) {
+	if !api0.ServerListRank(c.API0_ServerList_Canary).Valid() {
+		return nil, fmt.Errorf("invalid server list canary ranking %q", c.API0_ServerList_Canary)
+	}
+
+	var guestNetworks []netip.Prefix
+	for _, x := range c.API0_GuestMode_Networks {
+		pfx, err := netip.ParsePrefix(x)
+		if err != nil {
+			return nil, fmt.Errorf("parse guest mode network %q: %w", x, err)
+		}
+		guestNetworks = append(guestNetworks, pfx)
+	}
+
+	if c.API0_UDPHeartbeat_Port < 0 || c.API0_UDPHeartbeat_Port > 65535 {
+		return nil, fmt.Errorf("invalid udp heartbeat port %d", c.API0_UDPHeartbeat_Port)
+	}
+
+	rc := api0ReloadableConfig(c, tenant)
+	h := &api0.Handler{
+		ServerList: api0.NewServerList(c.API0_ServerList_DeadTime, c.API0_ServerList_GhostTime, c.API0_ServerList_VerifyTime, api0.ServerListConfig{
+			ExperimentalDeterministicServerIDSecret: c.API0_ServerList_ExperimentalDeterministicServerIDSecret,
+			AllowUwuify:                             c.AllowJokes,
+		}),
+		MaxServers:                   rc.MaxServers,
+		MaxServersPerIP:              rc.MaxServersPerIP,
+		InsecureDevNoCheckPlayerAuth: c.API0_InsecureDevNoCheckPlayerAuth,
+		MinimumLauncherVersionClient: rc.MinimumLauncherVersionClient,
+		MinimumLauncherVersionServer: rc.MinimumLauncherVersionServer,
+		BlockedLauncherVersions:      rc.BlockedLauncherVersions,
+		LauncherUpdateURL:            rc.LauncherUpdateURL,
+		TokenExpiryTime:              c.API0_TokenExpiryTime,
+		MaxSessions:                  c.API0_MaxSessions,
+		AuthReplayWindow:             c.API0_AuthReplayWindow,
+		AuthReplayMaxTokens:          c.API0_AuthReplayMaxTokens,
+		IdempotencyWindow:            c.API0_IdempotencyWindow,
+		IdempotencyMaxKeys:           c.API0_IdempotencyMaxKeys,
+		PdataPlayerWriteLimit:        c.API0_PdataPlayerWriteLimit,
+		PdataServerWriteLimit:        c.API0_PdataServerWriteLimit,
+		PdataWriteWindow:             c.API0_PdataWriteWindow,
+		OriginBreakerThreshold:       c.API0_OriginBreakerThreshold,
+		OriginBreakerCooldown:        c.API0_OriginBreakerCooldown,
+		DegradedAuthMaxAge:           c.API0_DegradedAuthMaxAge,
+		DegradedAuthAnyIP:            c.API0_DegradedAuthAnyIP,
+		GuestMode: api0.GuestMode{
+			Enabled:     c.API0_GuestMode,
+			TokenExpiry: c.API0_GuestMode_TokenExpiry,
+			Networks:    guestNetworks,
+		},
+		PlayerPresenceTTL: c.API0_PlayerPresenceTTL,
+		JoinTokens: api0.JoinTokens{
+			Required: c.API0_JoinTokenRequired,
+			Expiry:   c.API0_JoinTokenExpiry,
+		},
+		AllowGameServerIPv6: c.API0_AllowGameServerIPv6,
+		UDPHeartbeat: api0.UDPHeartbeat{
+			Enabled: c.API0_UDPHeartbeat,
+			Port:    uint16(c.API0_UDPHeartbeat_Port),
+		},
+		AdminSecret:            c.API0_AdminSecret,
+		AdminRequireClientCert: c.API0_AdminRequireClientCert,
+		RelayRequireClientCert: c.API0_RelayRequireClientCert,
+		APIv1Sunset:            c.API0_V1Sunset,
+		ServerStatsRetention:   c.API0_ServerStats_Retention,
+		Retention: api0.RetentionConfig{
+			Sessions:         c.API0_Retention_Sessions,
+			StaleAccounts:    c.API0_Retention_StaleAccounts,
+			HeartbeatHistory: c.API0_Retention_HeartbeatHistory,
+			ServerStats:      c.API0_Retention_ServerStats,
+			Compact:          c.API0_Retention_Compact,
+		},
+		AttackMode:            rc.AttackMode,
+		FeatureFlags:          rc.FeatureFlags,
+		CORS:                  rc.CORS,
+		CleanBadWords:         rc.CleanBadWords,
+		ServerListCanary:      api0.ServerListRank(c.API0_ServerList_Canary),
+		ServerSearchRateLimit: c.API0_ServerList_SearchRateLimit,
+		ServerDelists: api0.ServerDelistConfig{
+			Warned:   c.API0_ServerDelist_Warned,
+			Delisted: c.API0_ServerDelist_Delisted,
+			Banned:   c.API0_ServerDelist_Banned,
+			Cooldown: c.API0_ServerDelist_Cooldown,
+		},
+		PlayerQuotas: api0.PlayerQuotas{
+			Auth:       api0.PlayerQuota{PerHour: c.API0_PlayerQuota_Auth},
+			PdataWrite: api0.PlayerQuota{PerHour: c.API0_PlayerQuota_PdataWrite},
+			Report:     api0.PlayerQuota{PerHour: c.API0_PlayerQuota_Report},
+		},
+		AnomalyDetection: api0.AnomalyDetection{
+			IPThreshold:     c.API0_Anomaly_IPThreshold,
+			SubnetThreshold: c.API0_Anomaly_SubnetThreshold,
+			Window:          c.API0_Anomaly_Window,
+			TarpitDelay:     c.API0_Anomaly_TarpitDelay,
+			Duration:        c.API0_Anomaly_Duration,
+		},
+		Honeypot: api0.Honeypot{
+			Enabled:     c.API0_Honeypot,
+			Paths:       c.API0_Honeypot_Paths,
+			Params:      c.API0_Honeypot_Params,
+			TagDuration: c.API0_Honeypot_TagDuration,
+			RateLimit:   c.API0_Honeypot_RateLimit,
+		},
+		Matchmaking: api0.MatchmakingConfig{
+			MatchSize:    c.API0_Matchmaking_MatchSize,
+			Modes:        c.API0_Matchmaking_Modes,
+			QueueTimeout: c.API0_Matchmaking_QueueTimeout,
+			SkillBand:    float64(c.API0_Matchmaking_SkillBand),
+		},
+		PartyMaxSize:   c.API0_PartyMaxSize,
+		ChatRelay:      c.API0_ChatRelay,
+		ServerWebhooks: c.API0_ServerWebhooks,
+		OnReload:       s.Reload,
+	}
+	if x, err := configureUsernameSource(c); err == nil {
+		h.UsernameSource = x
+	} else {
+		return nil, fmt.Errorf("initialize username lookup: %w", err)
+	}
+	if x, err := api0.ParseSessionPolicy(c.API0_SessionPolicy); err == nil {
+		h.SessionPolicy = x
+	} else {
+		return nil, fmt.Errorf("initialize session policy: %w", err)
+	}
+	switch c.API0_Captcha {
+	case "none":
+	case string(api0.CaptchaTurnstile), string(api0.CaptchaHCaptcha):
+		if c.API0_Captcha_SiteKey == "" || c.API0_Captcha_Secret == "" {
+			return nil, fmt.Errorf("initialize captcha: site key and secret are required")
+		}
+		h.Captcha = api0.CaptchaConfig{
+			Provider: api0.CaptchaProvider(c.API0_Captcha),
+			SiteKey:  c.API0_Captcha_SiteKey,
+			Secret:   c.API0_Captcha_Secret,
+			Paths:    c.API0_Captcha_Paths,
+		}
+	default:
+		return nil, fmt.Errorf("initialize captcha: unknown provider %q", c.API0_Captcha)
+	}
+	if v := c.API0_ServerAttestationKey; v != "" {
+		if b, err := base64.StdEncoding.DecodeString(v); err != nil || len(b) != ed25519.SeedSize {
+			return nil, fmt.Errorf("initialize server attestation key: invalid base64-encoded ed25519 seed")
+		} else {
+			h.ServerAttestationKey = ed25519.NewKeyFromSeed(b)
+		}
+	}
+	if v := c.API0_Analytics_Key; v != "" {
+		h.AnalyticsKey = []byte(v)
+	}
+	h.Reconfigure(rc)
+	s.reconfigure = append(s.reconfigure, func(c *Config) {
+		h.Reconfigure(api0ReloadableConfig(c, tenant))
+	})
+	return h, nil
+}
+
 func configureServerTLS(c *Config) (*tls.Config, error) {
 	var t tls.Config
 	if len(c.ServerCerts) != 0 {
@@ -389,12 +836,22 @@ func configureServerTLS(c *Config) (*tls.Config, error) {
 			}
 			t.Certificates = append(t.Certificates, cert)
 		}
-	} else if len(c.AddrTLS) != 0 {
+	} else if len(c.AddrTLS) != 0 || len(c.AddrMTLS) != 0 {
 		return nil, fmt.Errorf("no tls certificates provided")
 	}
 	return &t, nil
 }
 
+func configureMTLS(c *Config) (*mtls.Verifier, error) {
+	if len(c.MTLSClientCA) == 0 {
+		if len(c.MTLSClientCRL) != 0 || len(c.MTLSClientNames) != 0 {
+			return nil, fmt.Errorf("no client ca provided")
+		}
+		return nil, nil
+	}
+	return mtls.NewVerifier(c.MTLSClientCA, c.MTLSClientCRL, c.MTLSClientNames)
+}
+
 func configureDevMapIP(c *Config) (func(http.Handler) http.Handler, error) {
 	if len(c.DevMapIP) == 0 {
 		return nil, nil
@@ -447,19 +904,22 @@ func configureDevMapIP(c *Config) (func(http.Handler) http.Handler, error) {
 	}, nil
 }
 
-func configureLogging(c *Config) (l zerolog.Logger, reopen func(), err error) {
+func configureLogging(c *Config) (l zerolog.Logger, reopen func(), relevel func(*Config), err error) {
 	var outputs []io.Writer
+	var stdout, file *zerologWriterLevel
 	if c.LogStdout {
 		if c.LogStdoutPretty {
-			outputs = append(outputs, newZerologWriterLevel(zerolog.ConsoleWriter{
+			stdout = newZerologWriterLevel(zerolog.ConsoleWriter{
 				Out: os.Stdout,
-			}, c.LogStdoutLevel))
+			}, c.LogStdoutLevel)
 		} else {
-			outputs = append(outputs, newZerologWriterLevel(os.Stdout, c.LogStdoutLevel))
+			stdout = newZerologWriterLevel(os.Stdout, c.LogStdoutLevel)
 		}
+		outputs = append(outputs, stdout)
 	}
 	if fn := c.LogFile; fn != "" {
 		x := newZerologWriterLevel(nil, c.LogFileLevel)
+		file = x
 		if fn, err = filepath.Abs(fn); err != nil {
 			err = fmt.Errorf("resolve log file: %w", err)
 			return
@@ -490,22 +950,98 @@ func configureLogging(c *Config) (l zerolog.Logger, reopen func(), err error) {
 		outputs = append(outputs, x)
 		reopen()
 	}
-	l = zerolog.New(zerolog.MultiLevelWriter(outputs...)).
-		Level(c.LogLevel).
+
+	// note: we filter the level in the writer rather than the logger so it
+	// can be changed after the logger is copied
+	root := newZerologWriterLevel(zerolog.MultiLevelWriter(outputs...), c.LogLevel)
+	relevel = func(c *Config) {
+		root.SetLevel(c.LogLevel)
+		if stdout != nil {
+			stdout.SetLevel(c.LogStdoutLevel)
+		}
+		if file != nil {
+			file.SetLevel(c.LogFileLevel)
+		}
+	}
+	l = zerolog.New(root).
 		With().
 		Timestamp().
 		Logger()
 	return
 }
 
-func configureOrigin(c *Config, l zerolog.Logger) (*origin.AuthMgr, error) {
+func configureAccessLog(c *Config, path func(*http.Request) string) (*accesslog.Logger, func(), error) {
+	if c.AccessLog == "" {
+		return nil, nil, nil
+	}
+	l := &accesslog.Logger{
+		IPv4Prefix:      c.AccessLogIPv4Prefix,
+		IPv6Prefix:      c.AccessLogIPv6Prefix,
+		RedactQuery:     c.AccessLogRedactQuery,
+		RedactUserAgent: c.AccessLogRedactUserAgent,
+		Path:            path,
+	}
+	for _, x := range c.AccessLogSample {
+		v, err := accesslog.ParseSample(x)
+		if err != nil {
+			return nil, nil, err
+		}
+		l.Sample = append(l.Sample, v)
+	}
+	if c.AccessLog == "-" {
+		l.Output = os.Stdout
+		return l, nil, nil
+	}
+	fn, err := filepath.Abs(c.AccessLog)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolve access log file: %w", err)
+	}
+	f := &accesslog.File{
+		Path:       fn,
+		Mode:       c.AccessLogChmod,
+		MaxSize:    int64(c.AccessLogMaxSize) << 20,
+		MaxBackups: c.AccessLogMaxBackups,
+	}
+	l.Output = f
+	return l, func() {
+		if err := f.Reopen(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to reopen access log: %v\n", err)
+		}
+	}, nil
+}
+
+func configureFaults(c *Config) (*fault.Injector, error) {
+	if c.Faults == "" {
+		return nil, nil
+	}
+	rs, err := fault.ParseRules(c.Faults)
+	if err != nil {
+		return nil, err
+	}
+	return fault.NewInjector(rs...), nil
+}
+
+// faultHTTPOp gets the fault injection operation for an outgoing request.
+func faultHTTPOp(r *http.Request) string {
+	switch h := r.URL.Hostname(); {
+	case strings.HasSuffix(h, ".stryder.respawn.com"):
+		return "stryder"
+	case strings.HasSuffix(h, ".ea.com"), strings.HasSuffix(h, ".origin.com"):
+		return "origin"
+	default:
+		return "http"
+	}
+}
+
+func configureOrigin(c *Config, creds func() *secretConfig, l zerolog.Logger) (*origin.AuthMgr, error) {
 	if c.OriginEmail == "" {
 		return nil, nil
 	}
 	var mu sync.Mutex
 	mgr := &origin.AuthMgr{
 		Credentials: func() (email, password, otpsecret string, err error) {
-			return c.OriginEmail, c.OriginPassword, c.OriginTOTP, nil
+			sc := creds()
+			return sc.OriginEmail, sc.OriginPassword, sc.OriginTOTP, nil
 		},
 		Backoff: expbackoff,
 		Updated: func(as origin.AuthState, err error) {
@@ -677,86 +1213,553 @@ func configureUsernameSource(c *Config) (api0.UsernameSource, error) {
 	}
 }
 
-func configureAccountStorage(c *Config) (api0.AccountStorage, error) {
-	switch typ, arg, _ := strings.Cut(c.API0_Storage_Accounts, ":"); typ {
-	case "memory":
-		if arg != "" {
-			return nil, fmt.Errorf("memory: invalid argument %q", arg)
+func configureAccountLinks(c *Config, h *api0.Handler) error {
+	if c.API0_Link_DiscordClientID == "" && c.API0_Link_DiscordBotToken == "" && c.API0_Link_SteamWebAPIKey == "" {
+		return nil
+	}
+	if x, ok := h.AccountStorage.(api0.AccountLinkStorage); ok {
+		h.AccountLinkStorage = x
+	} else {
+		return fmt.Errorf("account storage %q does not support account links", c.API0_Storage_Accounts)
+	}
+	if c.API0_Link_DiscordClientID != "" {
+		if c.API0_Link_DiscordClientSecret == "" {
+			return fmt.Errorf("discord client secret is required")
 		}
-		return memstore.NewAccountStore(), nil
-	case "sqlite3":
-		p, err := filepath.Abs(arg)
-		if err != nil {
-			return nil, fmt.Errorf("sqlite3: resolve %q: %w", arg, err)
+		if c.API0_Link_DiscordRedirectURL == "" {
+			return fmt.Errorf("discord redirect url is required")
 		}
-		s, err := atlasdb.Open(p)
-		if err != nil {
-			return nil, fmt.Errorf("sqlite3: %w", err)
+		h.DiscordOAuth2 = &discord.OAuth2{
+			ClientID:     c.API0_Link_DiscordClientID,
+			ClientSecret: c.API0_Link_DiscordClientSecret,
+			RedirectURL:  c.API0_Link_DiscordRedirectURL,
 		}
-		if cur, to, err := s.Version(); err != nil {
-			return nil, fmt.Errorf("sqlite3: migrate: %w", err)
-		} else if cur > to {
-			return nil, fmt.Errorf("sqlite3: migrate: database version %d is too new", cur)
-		} else if cur != to {
-			if err := s.MigrateUp(context.Background(), to); err != nil {
-				return nil, fmt.Errorf("sqlite3: migrate (%d to %d): %w", cur, to, err)
-			}
+		h.DiscordBotToken = c.API0_Link_DiscordBotToken
+	} else if c.API0_Link_DiscordBotToken != "" {
+		return fmt.Errorf("discord bot token requires discord linking")
+	}
+	if c.API0_Link_SteamWebAPIKey != "" {
+		if c.API0_Link_SteamAppID < 0 || c.API0_Link_SteamAppID > math.MaxUint32 {
+			return fmt.Errorf("invalid steam app id %d", c.API0_Link_SteamAppID)
 		}
-		return s, nil
-	default:
-		return nil, fmt.Errorf("unknown type %q", typ)
+		h.SteamWebAPIKey = c.API0_Link_SteamWebAPIKey
+		h.SteamAppID = uint32(c.API0_Link_SteamAppID)
 	}
+	return nil
 }
 
-func configurePdataStorage(c *Config) (api0.PdataStorage, error) {
-	switch typ, arg, _ := strings.Cut(c.API0_Storage_Pdata, ":"); typ {
-	case "memory":
-		switch arg {
-		case "":
-			return memstore.NewPdataStore(false), nil
-		case "compress":
-			return memstore.NewPdataStore(true), nil
-		default:
-			return nil, fmt.Errorf("memory: invalid argument %q", arg)
+func configureAnalytics(c *Config, l zerolog.Logger) (*analytics.HTTPExporter, error) {
+	switch typ, arg, _ := strings.Cut(c.API0_Analytics, ":"); typ {
+	case "none":
+		if arg != "" {
+			return nil, fmt.Errorf("none: invalid argument %q", arg)
 		}
-	case "sqlite3":
-		p, err := filepath.Abs(arg)
-		if err != nil {
-			return nil, fmt.Errorf("sqlite3: resolve %q: %w", arg, err)
+		return nil, nil
+	case "http":
+		if u, err := url.Parse(arg); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("http: invalid url %q", arg)
 		}
-		s, err := pdatadb.Open(p)
-		if err != nil {
-			return nil, fmt.Errorf("sqlite3: %w", err)
+		e := &analytics.HTTPExporter{
+			URL: arg,
+			ErrorHook: func(n int, err error) {
+				l.Warn().Err(err).Int("events", n).Msg("failed to export analytics events")
+			},
 		}
-		if cur, to, err := s.Version(); err != nil {
-			return nil, fmt.Errorf("sqlite3: migrate: %w", err)
-		} else if cur > to {
-			return nil, fmt.Errorf("sqlite3: migrate: database version %d is too new", cur)
-		} else if cur != to {
-			if err := s.MigrateUp(context.Background(), to); err != nil {
-				return nil, fmt.Errorf("sqlite3: migrate (%d to %d): %w", cur, to, err)
-			}
+		if v := c.API0_Analytics_Token; v != "" {
+			e.Header = http.Header{"Authorization": {"Bearer " + v}}
 		}
-		return s, nil
+		return e, nil
 	default:
 		return nil, fmt.Errorf("unknown type %q", typ)
 	}
 }
 
-func configureMainMenuPromos(c *Config) (func(*http.Request) api0.MainMenuPromos, error) {
-	switch typ, arg, _ := strings.Cut(c.API0_MainMenuPromos, ":"); typ {
+func configureTracing(c *Config, l zerolog.Logger) (*trace.Tracer, *trace.OTLPExporter, error) {
+	typ, arg, _ := strings.Cut(c.Tracing, ":")
+	switch typ {
 	case "none":
-		return nil, nil
-	case "file":
-		p, err := filepath.Abs(arg)
-		if err != nil {
-			return nil, fmt.Errorf("file: resolve %q: %w", arg, err)
+		if arg != "" {
+			return nil, nil, fmt.Errorf("none: invalid argument %q", arg)
 		}
-		fn := func(*http.Request) api0.MainMenuPromos {
-			var mmp api0.MainMenuPromos
-			if buf, err1 := os.ReadFile(p); err1 != nil {
-				err = err1
-			} else if err = json.Unmarshal(buf, &mmp); err != nil {
+		return nil, nil, nil
+	case "otlp":
+		if u, err := url.Parse(arg); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, nil, fmt.Errorf("%s: invalid url", typ)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown type %q", typ)
+	}
+	if c.TracingSamplePercent < 0 || c.TracingSamplePercent > 100 {
+		return nil, nil, fmt.Errorf("sample percent must be between 0 and 100")
+	}
+	hdr := http.Header{}
+	for _, x := range c.TracingHeaders {
+		k, v, ok := strings.Cut(x, "=")
+		if !ok || k == "" {
+			return nil, nil, fmt.Errorf("invalid header %q", x)
+		}
+		hdr.Add(k, v)
+	}
+	host, _ := os.Hostname()
+	e := &trace.OTLPExporter{
+		URL:    arg,
+		Header: hdr,
+		Resource: []trace.Attr{
+			trace.String("service.name", "atlas"),
+			trace.String("host.name", host),
+			trace.Int("process.pid", os.Getpid()),
+		},
+		ErrorHook: func(n int, err error) {
+			l.Warn().Err(err).Int("spans", n).Msg("failed to export spans")
+		},
+	}
+	return &trace.Tracer{
+		Exporter:    e,
+		SampleRatio: float64(c.TracingSamplePercent) / 100,
+		TrustRemote: c.TracingTrustRemote,
+	}, e, nil
+}
+
+func configureNotify(spec string, l zerolog.Logger) (*notify.Webhook, error) {
+	typ, arg, _ := strings.Cut(spec, ":")
+	var f notify.Format
+	switch typ {
+	case "none":
+		if arg != "" {
+			return nil, fmt.Errorf("none: invalid argument %q", arg)
+		}
+		return nil, nil
+	case "discord":
+		f = notify.FormatDiscord
+	case "http":
+		f = notify.FormatJSON
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+	if u, err := url.Parse(arg); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s: invalid url", typ)
+	}
+	return &notify.Webhook{
+		URL:    arg,
+		Format: f,
+		ErrorHook: func(m notify.Message, err error) {
+			l.Warn().Err(err).Str("type", m.Type).Msg("failed to send notification")
+		},
+	}, nil
+}
+
+func configureCache(c *Config) (cache.Cache, error) {
+	switch typ, arg, _ := strings.Cut(c.API0_Cache, ":"); typ {
+	case "none":
+		if arg != "" {
+			return nil, fmt.Errorf("none: invalid argument %q", arg)
+		}
+		return nil, nil
+	case "memory":
+		n := 100000
+		if arg != "" {
+			v, err := strconv.Atoi(arg)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("memory: invalid max entries %q", arg)
+			}
+			n = v
+		}
+		return cache.NewLRU(n), nil
+	case "redis":
+		x, err := cache.NewRedis(c.API0_Cache)
+		if err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		return x, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+}
+
+func configureLeader(c *Config, astore api0.AccountStorage, l zerolog.Logger) (*leader.Elector, error) {
+	e := &leader.Elector{
+		Name:   "jobs",
+		ID:     c.LeaderElectionID,
+		TTL:    c.LeaderElectionTTL,
+		Logger: l,
+	}
+	if e.ID == "" {
+		e.ID = leader.DefaultID()
+	}
+	if e.TTL <= 0 {
+		return nil, fmt.Errorf("invalid ttl %s", e.TTL)
+	}
+	switch typ, _, _ := strings.Cut(c.LeaderElection, ":"); typ {
+	case "", "none":
+		return nil, nil
+	case "storage":
+		if c.LeaderElection != typ {
+			return nil, fmt.Errorf("storage: unexpected argument")
+		}
+		b, ok := astore.(leader.Backend)
+		if !ok {
+			return nil, fmt.Errorf("storage: account storage %T does not support leases", astore)
+		}
+		e.Backend = b
+	case "redis":
+		x, err := cache.NewRedis(c.LeaderElection)
+		if err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		e.Backend = x
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+	return e, nil
+}
+
+func configureAccountStorage(c *Config) (api0.AccountStorage, error) {
+	return OpenAccountStorage(c.API0_Storage_Accounts, c.API0_Storage_EncryptionKeys)
+}
+
+// OpenAccountStorage opens and migrates the account storage specified in the
+// same format as [Config.API0_Storage_Accounts].
+func OpenAccountStorage(spec, encryptionKeys string) (api0.AccountStorage, error) {
+	switch typ, arg, _ := strings.Cut(spec, ":"); typ {
+	case "memory":
+		if arg != "" {
+			return nil, fmt.Errorf("memory: invalid argument %q", arg)
+		}
+		return memstore.NewAccountStore(), nil
+	case "kv":
+		p, err := openKVDir(arg, encryptionKeys)
+		if err != nil {
+			return nil, fmt.Errorf("kv: %w", err)
+		}
+		s, err := kvstore.OpenAccountStore(filepath.Join(p, "accounts.kv"))
+		if err != nil {
+			return nil, fmt.Errorf("kv: %w", err)
+		}
+		return s, nil
+	case "sqlite3":
+		p, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite3: resolve %q: %w", arg, err)
+		}
+		s, err := atlasdb.Open(p)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite3: %w", err)
+		}
+		if s.Keyring, err = openStorageKeyring(encryptionKeys); err != nil {
+			return nil, fmt.Errorf("sqlite3: %w", err)
+		}
+		if cur, to, err := s.Version(); err != nil {
+			return nil, fmt.Errorf("sqlite3: migrate: %w", err)
+		} else if cur > to {
+			return nil, fmt.Errorf("sqlite3: migrate: database version %d is too new", cur)
+		} else if cur != to {
+			if err := s.MigrateUp(context.Background(), to); err != nil {
+				return nil, fmt.Errorf("sqlite3: migrate (%d to %d): %w", cur, to, err)
+			}
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+}
+
+// openKVDir resolves and creates the data directory for kv storage.
+func openKVDir(dir, encryptionKeys string) (string, error) {
+	if encryptionKeys != "" {
+		return "", fmt.Errorf("encryption keys are not supported")
+	}
+	if dir == "" {
+		return "", fmt.Errorf("data directory is required")
+	}
+	p, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("resolve %q: %w", dir, err)
+	}
+	if err := os.MkdirAll(p, 0700); err != nil {
+		return "", fmt.Errorf("create data directory: %w", err)
+	}
+	return p, nil
+}
+
+func openStorageKeyring(encryptionKeys string) (*keyring.Keyring, error) {
+	if encryptionKeys == "" {
+		return nil, nil
+	}
+	k, err := keyring.Parse(encryptionKeys)
+	if err != nil {
+		return nil, fmt.Errorf("parse encryption keys: %w", err)
+	}
+	return k, nil
+}
+
+// configureAccountStorageFeatures enables the features supported by the
+// optional interfaces implemented by the account storage of h.
+func configureAccountStorageFeatures(c *Config, h *api0.Handler) error {
+	if x, ok := h.AccountStorage.(api0.StateStorage); ok {
+		h.StateStorage = x
+	}
+	switch c.Outbox {
+	case "none":
+	case "storage":
+		if x, ok := h.AccountStorage.(outbox.Backend); ok {
+			h.Outbox = x
+			h.OutboxOptions = outbox.Options{
+				MaxAttempts: c.OutboxMaxAttempts,
+				Retention:   c.OutboxRetention,
+			}
+		} else {
+			return fmt.Errorf("outbox: account storage does not support an outbox")
+		}
+	default:
+		return fmt.Errorf("outbox: unknown type %q", c.Outbox)
+	}
+	if x, ok := h.AccountStorage.(api0.UsernameHistoryStorage); ok {
+		h.UsernameHistoryStorage = x
+	}
+	if x, ok := h.AccountStorage.(api0.AccountSignalStorage); ok {
+		h.AccountSignalStorage = x
+		h.AccountSignalRetention = c.API0_AccountSignalRetention
+	}
+	if x, ok := h.AccountStorage.(api0.BanListStorage); ok {
+		h.BanListStorage = x
+	}
+	if x, ok := h.AccountStorage.(api0.AbuseReportStorage); ok {
+		h.AbuseReportStorage = x
+		h.ReportRateLimit = c.API0_ReportRateLimit
+		h.ReportRateWindow = c.API0_ReportRateWindow
+	}
+	if c.API0_ServerStats {
+		if x, ok := h.AccountStorage.(api0.ServerStatsStorage); ok {
+			h.ServerStatsStorage = x
+		} else {
+			return fmt.Errorf("server stats: account storage does not support server stats")
+		}
+	}
+	if c.API0_Ratings {
+		if x, ok := h.AccountStorage.(api0.RatingStorage); ok {
+			h.RatingStorage = x
+			h.PublicRatings = c.API0_Ratings_Public
+		} else {
+			return fmt.Errorf("ratings: account storage does not support ratings")
+		}
+	}
+	if c.API0_MatchHistory {
+		if x, ok := h.AccountStorage.(api0.MatchHistoryStorage); ok {
+			h.MatchHistoryStorage = x
+			h.MatchHistoryRetention = c.API0_MatchHistory_Retention
+		} else {
+			return fmt.Errorf("match history: account storage does not support match history")
+		}
+	}
+	if c.API0_MuteReports {
+		if x, ok := h.AccountStorage.(api0.MuteReportStorage); ok {
+			h.MuteReportStorage = x
+			h.MuteReports = api0.MuteReportConfig{
+				Threshold: float64(c.API0_MuteReports_Threshold),
+				HalfLife:  c.API0_MuteReports_HalfLife,
+			}
+		} else {
+			return fmt.Errorf("mute reports: account storage does not support mute reports")
+		}
+	}
+	if c.API0_Crashes {
+		if x, ok := h.AccountStorage.(api0.CrashStorage); ok {
+			h.CrashStorage = x
+			h.CrashRetention = c.API0_Crashes_Retention
+			h.CrashRateLimit = c.API0_Crashes_RateLimit
+		} else {
+			return fmt.Errorf("crashes: account storage does not support crash reports")
+		}
+	}
+	if c.API0_Friends {
+		if x, ok := h.AccountStorage.(api0.FriendStorage); ok {
+			h.FriendStorage = x
+		} else {
+			return fmt.Errorf("friends: account storage does not support friends")
+		}
+	}
+	if c.API0_Blocks {
+		if x, ok := h.AccountStorage.(api0.BlockStorage); ok {
+			h.BlockStorage = x
+		} else {
+			return fmt.Errorf("blocks: account storage does not support block lists")
+		}
+	}
+	return nil
+}
+
+func configurePdataStorage(c *Config) (api0.PdataStorage, error) {
+	return OpenPdataStorage(c.API0_Storage_Pdata, c.API0_Storage_EncryptionKeys)
+}
+
+// OpenPdataStorage opens and migrates the pdata storage specified in the same
+// format as [Config.API0_Storage_Pdata].
+func OpenPdataStorage(spec, encryptionKeys string) (api0.PdataStorage, error) {
+	switch typ, arg, _ := strings.Cut(spec, ":"); typ {
+	case "memory":
+		switch arg {
+		case "":
+			return memstore.NewPdataStore(false), nil
+		case "compress":
+			return memstore.NewPdataStore(true), nil
+		default:
+			return nil, fmt.Errorf("memory: invalid argument %q", arg)
+		}
+	case "kv":
+		p, err := openKVDir(arg, encryptionKeys)
+		if err != nil {
+			return nil, fmt.Errorf("kv: %w", err)
+		}
+		s, err := kvstore.OpenPdataStore(filepath.Join(p, "pdata.kv"))
+		if err != nil {
+			return nil, fmt.Errorf("kv: %w", err)
+		}
+		return s, nil
+	case "sqlite3":
+		p, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite3: resolve %q: %w", arg, err)
+		}
+		s, err := pdatadb.Open(p)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite3: %w", err)
+		}
+		if s.Keyring, err = openStorageKeyring(encryptionKeys); err != nil {
+			return nil, fmt.Errorf("sqlite3: %w", err)
+		}
+		if cur, to, err := s.Version(); err != nil {
+			return nil, fmt.Errorf("sqlite3: migrate: %w", err)
+		} else if cur > to {
+			return nil, fmt.Errorf("sqlite3: migrate: database version %d is too new", cur)
+		} else if cur != to {
+			if err := s.MigrateUp(context.Background(), to); err != nil {
+				return nil, fmt.Errorf("sqlite3: migrate (%d to %d): %w", cur, to, err)
+			}
+		}
+		return s, nil
+	case "shard":
+		var shards []pdatashard.Shard
+		for _, x := range strings.Split(arg, ";") {
+			name, spec, ok := strings.Cut(x, "=")
+			if !ok {
+				return nil, fmt.Errorf("shard: invalid shard %q: expected name=spec", x)
+			}
+			if strings.HasPrefix(spec, "shard:") {
+				return nil, fmt.Errorf("shard: shard %q: nested shards are not supported", name)
+			}
+			s, err := OpenPdataStorage(spec, encryptionKeys)
+			if err != nil {
+				for _, sh := range shards {
+					if c, ok := sh.Storage.(io.Closer); ok {
+						c.Close()
+					}
+				}
+				return nil, fmt.Errorf("shard: shard %q: %w", name, err)
+			}
+			shards = append(shards, pdatashard.Shard{Name: name, Storage: s})
+		}
+		s, err := pdatashard.New(true, shards...)
+		if err != nil {
+			return nil, fmt.Errorf("shard: %w", err)
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+}
+
+func configureDualWrite(c *Config, h *api0.Handler, l zerolog.Logger) error {
+	onError := func(err error) {
+		l.Warn().Err(err).Msg("failed to write to secondary storage")
+	}
+	if v := c.API0_Storage_Accounts_DualWrite; v != "" {
+		x, err := OpenAccountStorage(v, c.API0_Storage_EncryptionKeys)
+		if err != nil {
+			return fmt.Errorf("accounts: %w", err)
+		}
+		if h.StateStorage != nil {
+			if y, ok := x.(api0.StateStorage); ok {
+				h.StateStorage = storagemigrate.DualWriteState(h.StateStorage, y, onError)
+			} else {
+				return fmt.Errorf("accounts: secondary storage does not support state")
+			}
+		}
+		if h.BanListStorage != nil {
+			if y, ok := x.(api0.BanListStorage); ok {
+				h.BanListStorage = storagemigrate.DualWriteBanLists(h.BanListStorage, y, onError)
+			} else {
+				return fmt.Errorf("accounts: secondary storage does not support ban lists")
+			}
+		}
+		h.AccountStorage = storagemigrate.DualWriteAccounts(h.AccountStorage, x, onError)
+	}
+	if v := c.API0_Storage_Pdata_DualWrite; v != "" {
+		x, err := OpenPdataStorage(v, c.API0_Storage_EncryptionKeys)
+		if err != nil {
+			return fmt.Errorf("pdata: %w", err)
+		}
+		h.PdataStorage = storagemigrate.DualWritePdata(h.PdataStorage, x, onError)
+	}
+	return nil
+}
+
+// storageCompactors gets the storage in xs which can be compacted.
+func storageCompactors(xs ...any) []api0.CompactStorage {
+	var cs []api0.CompactStorage
+	for _, x := range xs {
+		if c, ok := x.(api0.CompactStorage); ok {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// setStorageLogger sets the logger for storage backends which log errors.
+func setStorageLogger(l zerolog.Logger, xs ...any) {
+	for _, x := range xs {
+		if x, ok := x.(interface{ DB() *kvstore.DB }); ok {
+			x.DB().Logger = l
+		}
+	}
+}
+
+func configureWriteBehind(c *Config, s api0.PdataStorage, l zerolog.Logger) (*writebehind.PdataStorage, error) {
+	cfg := writebehind.Config{
+		MaxPending: c.API0_Storage_Pdata_WriteBehind_MaxPending,
+		Interval:   c.API0_Storage_Pdata_WriteBehind_Interval,
+		ErrorHook: func(err error) {
+			l.Warn().Err(err).Msg("failed to flush buffered pdata writes")
+		},
+	}
+	switch typ, arg, _ := strings.Cut(c.API0_Storage_Pdata_WriteBehind, ":"); typ {
+	case "", "none":
+		return nil, nil
+	case "memory":
+	case "journal":
+		if arg == "" {
+			return nil, fmt.Errorf("journal path required")
+		}
+		cfg.Journal = arg
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+	return writebehind.New(s, cfg)
+}
+
+func configureMainMenuPromos(c *Config) (func(*http.Request) api0.MainMenuPromos, error) {
+	switch typ, arg, _ := strings.Cut(c.API0_MainMenuPromos, ":"); typ {
+	case "none":
+		return nil, nil
+	case "file":
+		p, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, fmt.Errorf("file: resolve %q: %w", arg, err)
+		}
+		fn := func(*http.Request) api0.MainMenuPromos {
+			var mmp api0.MainMenuPromos
+			if buf, err1 := os.ReadFile(p); err1 != nil {
+				err = err1
+			} else if err = json.Unmarshal(buf, &mmp); err != nil {
 				err = err1
 			}
 			return mmp
@@ -811,6 +1814,91 @@ func configureIP2Location(c *Config) (*ip2xMgr, error) {
 	return mgr, mgr.Load(c.IP2Location)
 }
 
+func configureIP2Proxy(c *Config) (*ip2xMgr, error) {
+	if c.IP2Proxy == "" {
+		return nil, nil
+	}
+	mgr := &ip2xMgr{product: ip2x.IP2Proxy}
+	return mgr, mgr.Load(c.IP2Proxy)
+}
+
+// ipReputationLookup combines the flags from the IP2Proxy database and the
+// usage type from the IP2Location lookup, if provided. If neither are, nil is
+// returned.
+func ipReputationLookup(ip2p *ip2xMgr, ip2l func(netip.Addr) (ip2x.Record, error)) func(netip.Addr) (api0.IPReputation, error) {
+	if ip2p == nil && ip2l == nil {
+		return nil
+	}
+	return func(a netip.Addr) (api0.IPReputation, error) {
+		var f api0.IPReputation
+		if ip2p != nil {
+			r, err := ip2p.LookupFields(a)
+			if err != nil {
+				return 0, fmt.Errorf("ip2proxy: %w", err)
+			}
+			f |= api0.IP2xReputation(r)
+		}
+		if ip2l != nil {
+			r, err := ip2l(a)
+			if err != nil {
+				return 0, fmt.Errorf("ip2location: %w", err)
+			}
+			f |= api0.IP2xReputation(r)
+		}
+		return f, nil
+	}
+}
+
+// ipNetworkLookup gets the country and ASN from the IP2Location lookup, falling
+// back to the IP2Proxy database for missing fields. If neither are provided,
+// nil is returned.
+func ipNetworkLookup(ip2p *ip2xMgr, ip2l func(netip.Addr) (ip2x.Record, error)) func(netip.Addr) (api0.IPNetwork, error) {
+	if ip2p == nil && ip2l == nil {
+		return nil
+	}
+	return func(a netip.Addr) (api0.IPNetwork, error) {
+		var n api0.IPNetwork
+		if ip2l != nil {
+			r, err := ip2l(a)
+			if err != nil {
+				return n, fmt.Errorf("ip2location: %w", err)
+			}
+			n = api0.IP2xNetwork(r)
+		}
+		if ip2p != nil && (n.Country == "" || n.ASN == 0) {
+			r, err := ip2p.LookupFields(a)
+			if err != nil {
+				return n, fmt.Errorf("ip2proxy: %w", err)
+			}
+			p := api0.IP2xNetwork(r)
+			if n.Country == "" {
+				n.Country = p.Country
+			}
+			if n.ASN == 0 {
+				n.ASN = p.ASN
+			}
+		}
+		return n, nil
+	}
+}
+
+func configureIPReputationVerify(c *Config, h *api0.Handler) error {
+	f, err := api0.ParseIPReputation(c.API0_IPReputationVerify)
+	if err != nil {
+		return err
+	}
+	if f != 0 {
+		if h.LookupIPReputation == nil {
+			return fmt.Errorf("ip2proxy or ip2location database is required")
+		}
+		if h.AccountLinkStorage == nil {
+			return fmt.Errorf("account linking is required")
+		}
+	}
+	h.IPReputationVerify = f
+	return nil
+}
+
 func configureRegionMap(c *Config) (fn func(netip.Addr, ip2x.Record) (string, error), err error) {
 	switch m := c.API0_RegionMap; m {
 	case "", "none":
@@ -869,6 +1957,7 @@ func (s *Server) Run(ctx context.Context) error {
 	if s.closed {
 		return http.ErrServerClosed
 	}
+	s.started = time.Now()
 
 	go func() {
 		tk := time.NewTicker(time.Minute * 5)
@@ -879,11 +1968,287 @@ func (s *Server) Run(ctx context.Context) error {
 			case <-ctx.Done():
 				return
 			case <-tk.C:
-				s.API0.ServerList.ReapServers()
+				for _, h := range s.api0Handlers() {
+					h.ServerList.ReapServers()
+				}
 			}
 		}
 	}()
 
+	if s.snapshot != "" && s.snapshotInterval > 0 {
+		go func() {
+			tk := time.NewTicker(s.snapshotInterval)
+			defer tk.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-tk.C:
+					if err := s.writeSnapshots(); err != nil {
+						s.Logger.Error().Err(err).Msg("failed to write server list snapshot")
+					}
+				}
+			}
+		}()
+	}
+
+	if s.secretsRefresh > 0 {
+		go func() {
+			tk := time.NewTicker(s.secretsRefresh)
+			defer tk.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-tk.C:
+					if err := s.refreshSecrets(); err != nil {
+						s.Logger.Error().Err(err).Msg("failed to refresh secrets")
+					}
+				}
+			}
+		}()
+	}
+
+	if s.Analytics != nil {
+		go s.Analytics.Run(ctx)
+	}
+
+	if s.Notify != nil {
+		go s.Notify.Run(ctx)
+	}
+
+	if s.ChatBridge != nil {
+		go s.ChatBridge.Run(ctx)
+	}
+
+	if s.WriteBehind != nil {
+		go s.WriteBehind.Run(ctx)
+	}
+
+	if s.Tracing != nil {
+		go s.Tracing.Run(ctx)
+	}
+
+	if s.SLO != nil {
+		go s.SLO.Run(ctx)
+	}
+
+	leaderDone := make(chan struct{})
+	if s.Leader != nil {
+		go func() {
+			defer close(leaderDone)
+			if err := s.Leader.Run(ctx); err != nil {
+				s.Logger.Error().Err(err).Msg("failed to run leader election")
+			}
+		}()
+	} else {
+		close(leaderDone)
+	}
+
+	for _, fn := range s.rotateKeys {
+		go func(fn func(context.Context) (int, error)) {
+			if n, err := fn(ctx); err != nil {
+				s.Logger.Error().Err(err).Msg("failed to re-encrypt stored data")
+			} else if n != 0 {
+				s.Logger.Info().Msgf("re-encrypted %d stored records with the primary key", n)
+			}
+		}(fn)
+	}
+
+	for _, h := range s.api0Handlers() {
+		h := h
+		if h.Outbox != nil {
+			go func() {
+				if err := h.RunOutbox(ctx); err != nil {
+					s.Logger.Error().Err(err).Msg("failed to run outbox")
+				}
+			}()
+		}
+		if h.ServerStatsStorage != nil {
+			go func() {
+				tk := time.NewTicker(api0.ServerStatsInterval)
+				defer tk.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case t := <-tk.C:
+						if !s.Leader.IsLeader() {
+							continue
+						}
+						if err := h.RecordServerStats(t); err != nil {
+							s.Logger.Error().Err(err).Msg("failed to record server stats")
+						}
+					}
+				}
+			}()
+		}
+
+		if h.MatchHistoryStorage != nil {
+			go func() {
+				tk := time.NewTicker(api0.MatchHistoryPruneInterval)
+				defer tk.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case t := <-tk.C:
+						if !s.Leader.IsLeader() {
+							continue
+						}
+						if err := h.PruneMatchHistory(t); err != nil {
+							s.Logger.Error().Err(err).Msg("failed to prune match history")
+						}
+					}
+				}
+			}()
+		}
+
+		if h.MuteReportStorage != nil {
+			go func() {
+				tk := time.NewTicker(api0.MuteReportPruneInterval)
+				defer tk.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case t := <-tk.C:
+						if !s.Leader.IsLeader() {
+							continue
+						}
+						if err := h.PruneMuteReports(t); err != nil {
+							s.Logger.Error().Err(err).Msg("failed to prune mute reports")
+						}
+					}
+				}
+			}()
+		}
+
+		if r := h.Retention; r.Sessions > 0 || r.StaleAccounts > 0 || r.HeartbeatHistory > 0 || r.Compact {
+			go func() {
+				iv := s.retentionInterval
+				if iv <= 0 {
+					iv = api0.RetentionInterval
+				}
+				tk := time.NewTicker(iv)
+				defer tk.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case t := <-tk.C:
+						if !s.Leader.IsLeader() {
+							continue
+						}
+						if err := h.RunRetention(ctx, t); err != nil {
+							s.Logger.Error().Err(err).Msg("failed to run retention jobs")
+						}
+					}
+				}
+			}()
+		}
+
+		if h.CrashStorage != nil {
+			go func() {
+				tk := time.NewTicker(api0.CrashPruneInterval)
+				defer tk.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case t := <-tk.C:
+						if !s.Leader.IsLeader() {
+							continue
+						}
+						if err := h.PruneCrashes(t); err != nil {
+							s.Logger.Error().Err(err).Msg("failed to prune crashes")
+						}
+					}
+				}
+			}()
+		}
+
+		if h.Matchmaking.MatchSize > 0 {
+			go func() {
+				tk := time.NewTicker(api0.MatchmakingInterval)
+				defer tk.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case t := <-tk.C:
+						h.Matchmake(t)
+					}
+				}
+			}()
+		}
+
+		if h.AccountSignalStorage != nil && s.detectAlts > 0 {
+			go func() {
+				tk := time.NewTicker(s.detectAlts)
+				defer tk.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case t := <-tk.C:
+						if !s.Leader.IsLeader() {
+							continue
+						}
+						if err := h.DetectAlts(t); err != nil {
+							s.Logger.Error().Err(err).Msg("failed to run alt detection")
+						}
+					}
+				}
+			}()
+		}
+
+		if h.ServerWebhooks && h.StateStorage != nil {
+			go func() {
+				tk := time.NewTicker(api0.ServerWebhookCheckInterval)
+				defer tk.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case t := <-tk.C:
+						if err := h.CheckServerWebhooks(t); err != nil {
+							s.Logger.Error().Err(err).Msg("failed to check server webhooks")
+						}
+					}
+				}
+			}()
+		}
+
+		if s.mirrorCheck > 0 {
+			go func() {
+				tk := time.NewTicker(s.mirrorCheck)
+				defer tk.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tk.C:
+						if err := h.CheckMirrors(ctx); err != nil && ctx.Err() == nil {
+							s.Logger.Error().Err(err).Msg("failed to check download mirrors")
+						}
+					}
+				}
+			}()
+		}
+	}
+
 	var hs []*http.Server
 	var as []string
 	for _, a := range s.Addr {
@@ -901,9 +2266,24 @@ func (s *Server) Run(ctx context.Context) error {
 		})
 		as = append(as, "https://"+a)
 	}
+	for _, a := range s.AddrMTLS {
+		hs = append(hs, &http.Server{
+			Addr:      a,
+			Handler:   s.Handler,
+			TLSConfig: s.MTLSConfig,
+		})
+		as = append(as, "https://"+a+" (mtls)")
+	}
 	if len(hs) == 0 {
 		return fmt.Errorf("no listen addresses provided")
 	}
+	if a := s.AddrDebug; a != "" && s.Debug != nil {
+		hs = append(hs, &http.Server{
+			Addr:    a,
+			Handler: s.Debug,
+		})
+		as = append(as, "http://"+a+" (debug)")
+	}
 	s.Logger.Log().Msgf("starting server on %s", strings.Join(as, ", "))
 
 	errch := make(chan error, len(hs))
@@ -948,11 +2328,24 @@ func (s *Server) Run(ctx context.Context) error {
 		}
 		wg.Wait()
 
-		if c, ok := s.API0.AccountStorage.(io.Closer); ok {
-			c.Close()
+		if s.snapshot != "" {
+			if err := s.writeSnapshots(); err != nil {
+				s.Logger.Error().Err(err).Msg("failed to write server list snapshot")
+			}
 		}
-		if c, ok := s.API0.PdataStorage.(io.Closer); ok {
-			c.Close()
+
+		// wait for the lease to be released before closing the storage
+		<-leaderDone
+
+		for _, h := range s.api0Handlers() {
+			if c, ok := h.AccountStorage.(io.Closer); ok {
+				c.Close()
+			}
+			if c, ok := h.PdataStorage.(io.Closer); ok {
+				if err := c.Close(); err != nil {
+					s.Logger.Error().Err(err).Msg("failed to close pdata storage")
+				}
+			}
 		}
 		return nil
 	case err := <-errch:
@@ -962,18 +2355,140 @@ func (s *Server) Run(ctx context.Context) error {
 }
 
 func (s *Server) HandleSIGHUP() {
+	if err := s.Reload(); err != nil {
+		s.Logger.Error().Err(err).Msg("failed to reload config")
+	}
+}
+
+// Reload reopens log files and reloads external files (e.g., the IP2Location
+// database). If LoadConfig is set, it also applies the new values of the
+// following options without dropping any state:
+//
+//   - log levels
+//   - server limits
+//   - launcher version restrictions
+//   - attack mode
+//   - bad words
+//
+// Other options require a restart. If the new config is invalid, the existing
+// options are kept, but files are still reloaded.
+func (s *Server) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
 	if s.closed {
-		return
+		return nil
 	}
 
 	s.sdnotify("RELOADING=1")
 	defer s.sdnotify("READY=1")
 
+	var err error
+	if s.LoadConfig != nil {
+		if c, err1 := s.LoadConfig(); err1 != nil {
+			err = fmt.Errorf("load config: %w", err1)
+		} else if err1 := checkReloadableConfig(c); err1 != nil {
+			err = err1
+		} else {
+			for _, fn := range s.reconfigure {
+				fn(c)
+			}
+			s.Logger.Info().Msg("reloaded config")
+		}
+	}
 	for _, fn := range s.reload {
 		if fn != nil {
 			fn()
 		}
 	}
+	return err
+}
+
+// checkReloadableConfig checks the options applied by Reload.
+func checkReloadableConfig(c *Config) error {
+	if c.API0_MinimumLauncherVersion != "" && !semver.IsValid("v"+strings.TrimPrefix(c.API0_MinimumLauncherVersion, "v")) {
+		return fmt.Errorf("invalid minimum launcher version semver %q", c.API0_MinimumLauncherVersion)
+	}
+	if c.API0_MinimumLauncherVersionClient != "" && !semver.IsValid("v"+strings.TrimPrefix(c.API0_MinimumLauncherVersionClient, "v")) {
+		return fmt.Errorf("invalid minimum launcher client version semver %q", c.API0_MinimumLauncherVersionClient)
+	}
+	if c.API0_MinimumLauncherVersionServer != "" && !semver.IsValid("v"+strings.TrimPrefix(c.API0_MinimumLauncherVersionServer, "v")) {
+		return fmt.Errorf("invalid minimum launcher server version semver %q", c.API0_MinimumLauncherVersionServer)
+	}
+	if _, err := api0.ParseFeatureFlags(c.API0_FeatureFlags, ""); err != nil {
+		return err
+	}
+	if _, err := api0.ParseCORSRules(c.API0_CORS, ""); err != nil {
+		return err
+	}
+	for _, m := range c.API0_CORS_Methods {
+		if m == "" || strings.ToUpper(m) != m {
+			return fmt.Errorf("invalid cors method %q (must be uppercase)", m)
+		}
+	}
+	if c.API0_CORS_MaxAge < 0 {
+		return fmt.Errorf("invalid cors max age %s", c.API0_CORS_MaxAge)
+	}
+	if fn := c.API0_BadWords; fn != "" {
+		if _, err := loadBadWords(fn); err != nil {
+			return fmt.Errorf("load bad words: %w", err)
+		}
+	}
+	if fn := c.Tenant_BadWords; fn != "" {
+		ts, err := parseTenants(c.Tenants)
+		if err != nil {
+			return err
+		}
+		for _, t := range ts {
+			if _, err := loadBadWords(strings.ReplaceAll(fn, "{tenant}", t.Name)); err != nil {
+				return fmt.Errorf("tenant %q: load bad words: %w", t.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// api0ReloadableConfig gets the reloadable api0 options from c for tenant (or
+// the default tenant if empty). The config must have already passed
+// checkReloadableConfig.
+func api0ReloadableConfig(c *Config, tenant string) api0.ReloadableConfig {
+	ff, _ := api0.ParseFeatureFlags(c.API0_FeatureFlags, tenant)
+	cr, _ := api0.ParseCORSRules(c.API0_CORS, tenant)
+	rc := api0.ReloadableConfig{
+		MaxServers:                   c.API0_MaxServers,
+		MaxServersPerIP:              c.API0_MaxServersPerIP,
+		MinimumLauncherVersionClient: c.API0_MinimumLauncherVersionClient,
+		MinimumLauncherVersionServer: c.API0_MinimumLauncherVersionServer,
+		BlockedLauncherVersions:      c.API0_BlockedLauncherVersions,
+		LauncherUpdateURL:            c.API0_LauncherUpdateURL,
+		AttackMode: api0.AttackMode{
+			Enabled:    c.API0_AttackMode,
+			Difficulty: c.API0_AttackMode_Difficulty,
+			Paths:      c.API0_AttackMode_Paths,
+		},
+		FeatureFlags: ff,
+		CORS: api0.CORS{
+			Rules:   cr,
+			Methods: c.API0_CORS_Methods,
+			MaxAge:  c.API0_CORS_MaxAge,
+		},
+	}
+	bw := c.API0_BadWords
+	if tenant != "" {
+		bw = strings.ReplaceAll(c.Tenant_BadWords, "{tenant}", tenant)
+	}
+	if bw != "" {
+		rc.CleanBadWords, _ = loadBadWords(bw)
+	}
+	if v := c.API0_MinimumLauncherVersion; v != "" {
+		if rc.MinimumLauncherVersionClient == "" {
+			rc.MinimumLauncherVersionClient = v
+		}
+		if rc.MinimumLauncherVersionServer == "" {
+			rc.MinimumLauncherVersionServer = v
+		}
+	}
+	return rc
 }
 
 // serveRest handles endpoints not handled by the API.
@@ -993,7 +2508,37 @@ func (s *Server) serveRest(w http.ResponseWriter, r *http.Request) {
 			ms = append(ms, s.API0.WritePrometheus)
 			ms = append(ms, s.API0.NSPkt.WritePrometheus)
 		}
+		if internal && s.Analytics != nil {
+			ms = append(ms, s.Analytics.WritePrometheus)
+		}
+		if internal && s.Notify != nil {
+			ms = append(ms, s.Notify.WritePrometheus)
+		}
+		if internal && s.ChatBridge != nil {
+			ms = append(ms, s.ChatBridge.WritePrometheus)
+		}
+		if internal && s.WriteBehind != nil {
+			ms = append(ms, s.WriteBehind.WritePrometheus)
+		}
+		if internal && s.Leader != nil {
+			ms = append(ms, s.Leader.WritePrometheus)
+		}
+		if internal && s.Tracing != nil {
+			ms = append(ms, s.Tracing.WritePrometheus)
+		}
+		if internal && s.SLO != nil {
+			ms = append(ms, s.SLO.WritePrometheus)
+		}
+		if internal && s.AccessLog != nil {
+			ms = append(ms, s.AccessLog.WritePrometheus)
+		}
 		ms = append(ms, s.API0.ServerList.WritePrometheus)
+		for _, t := range s.Tenants {
+			if internal {
+				ms = append(ms, tenantMetrics(t.Name, t.API0.WritePrometheus))
+			}
+			ms = append(ms, tenantMetrics(t.Name, t.API0.ServerList.WritePrometheus))
+		}
 		if internal && geo {
 			ms = append(ms, s.API0.WritePrometheusGeo)
 			ms = append(ms, s.API0.ServerList.WritePrometheusGeo)
@@ -1027,14 +2572,51 @@ func (s *Server) serveRest(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Pragma", "no-cache")
 
 	if r.URL.Path == "/" {
-		w.WriteHeader(http.StatusOK)
-		io.WriteString(w, "Go away.\n")
+		s.serveStatusPage(w, r)
 		return
 	}
 
 	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 }
 
+// notifySLO sends a notification for an SLO burn-rate alert.
+func (s *Server) notifySLO(a slo.Alert) {
+	e := s.Logger.Warn()
+	if !a.Firing {
+		e = s.Logger.Info()
+	}
+	e.
+		Str("component", "slo").
+		Str("objective", a.Objective).
+		Str("severity", string(a.Severity)).
+		Bool("firing", a.Firing).
+		Float64("burn_rate", a.BurnRate).
+		Float64("budget_remaining", a.Budget).
+		Msg("slo alert")
+
+	if s.API0 == nil || s.API0.Notify == nil {
+		return
+	}
+	n := api0.Notification{
+		Time: time.Now().UTC(),
+		Fields: map[string]string{
+			"objective":        a.Objective,
+			"severity":         string(a.Severity),
+			"burn_rate":        strconv.FormatFloat(a.BurnRate, 'f', 1, 64),
+			"window":           a.Window.String(),
+			"budget_remaining": strconv.FormatFloat(a.Budget*100, 'f', 1, 64) + "%",
+		},
+	}
+	if a.Firing {
+		n.Type = api0.NotificationSLOBurn
+		n.Message = fmt.Sprintf("%s slo error budget is burning at %.1fx over %s (%s)", a.Objective, a.BurnRate, a.Window, a.Severity)
+	} else {
+		n.Type = api0.NotificationSLOResolved
+		n.Message = fmt.Sprintf("%s slo error budget burn rate over %s has recovered (%s)", a.Objective, a.Window, a.Severity)
+	}
+	s.API0.Notify(n)
+}
+
 func (s *Server) sdnotify(state string) (bool, error) {
 	if s.NotifySocket == "" {
 		return false, nil
@@ -0,0 +1,68 @@
+package atlas
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadableConfigBadWords(t *testing.T) {
+	dir := t.TempDir()
+	for name, words := range map[string]string{
+		"badwords.txt":          "heck\n",
+		"isolated-badwords.txt": "# comment\n\ndarn\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(words), 0644); err != nil {
+			t.Fatalf("write bad words: %v", err)
+		}
+	}
+
+	var c Config
+	if err := c.UnmarshalEnv([]string{
+		"ATLAS_API0_BADWORDS=" + filepath.Join(dir, "badwords.txt"),
+		"ATLAS_TENANTS=isolated=/isolated",
+		"ATLAS_TENANT_BADWORDS=" + filepath.Join(dir, "{tenant}-badwords.txt"),
+	}, false); err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+	if err := checkReloadableConfig(&c); err != nil {
+		t.Fatalf("check config: %v", err)
+	}
+
+	clean := func(tenant, s string) string {
+		f := api0ReloadableConfig(&c, tenant).CleanBadWords
+		if f == nil {
+			return s
+		}
+		return f(s)
+	}
+	if v := clean("", "what the Heck, darn"); v != "what the ****, darn" {
+		t.Errorf("default tenant: incorrect filtered string %q", v)
+	}
+	if v := clean("isolated", "what the Heck, darn"); v != "what the Heck, ****" {
+		t.Errorf("isolated tenant: incorrect filtered string %q", v)
+	}
+
+	// changes are picked up when the config is reloaded
+	if err := os.WriteFile(filepath.Join(dir, "badwords.txt"), []byte("heck\ndarn\n"), 0644); err != nil {
+		t.Fatalf("write bad words: %v", err)
+	}
+	if v := clean("", "what the Heck, darn"); v != "what the ****, ****" {
+		t.Errorf("default tenant: incorrect filtered string after update %q", v)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "isolated-badwords.txt")); err != nil {
+		t.Fatalf("remove bad words: %v", err)
+	}
+	if err := checkReloadableConfig(&c); err == nil {
+		t.Errorf("expected error for missing tenant bad words file")
+	}
+	c.Tenant_BadWords = ""
+	if err := checkReloadableConfig(&c); err != nil {
+		t.Errorf("check config: %v", err)
+	}
+	c.API0_BadWords = filepath.Join(dir, "missing.txt")
+	if err := checkReloadableConfig(&c); err == nil {
+		t.Errorf("expected error for missing bad words file")
+	}
+}
@@ -0,0 +1,81 @@
+package atlas
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+)
+
+// snapshotPath gets the server list snapshot path for the api0 handler h.
+func (s *Server) snapshotPath(h *api0.Handler) string {
+	if h == s.API0 {
+		return s.snapshot
+	}
+	for _, t := range s.Tenants {
+		if t.API0 == h {
+			return s.snapshot + "." + t.Name
+		}
+	}
+	panic("unknown handler")
+}
+
+// writeSnapshots atomically writes server list snapshots for all handlers.
+func (s *Server) writeSnapshots() error {
+	for _, h := range s.api0Handlers() {
+		fn := s.snapshotPath(h)
+		if err := func() error {
+			f, err := os.CreateTemp(filepath.Dir(fn), "."+filepath.Base(fn)+".*.tmp")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			if _, err := h.ServerList.WriteSnapshot(f); err != nil {
+				return err
+			}
+			if err := f.Sync(); err != nil {
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			return os.Rename(f.Name(), fn)
+		}(); err != nil {
+			return fmt.Errorf("write snapshot %q: %w", fn, err)
+		}
+	}
+	return nil
+}
+
+// restoreSnapshots restores the server list for all handlers from the latest
+// snapshots, if they exist.
+func (s *Server) restoreSnapshots() error {
+	for _, h := range s.api0Handlers() {
+		fn := s.snapshotPath(h)
+		if err := func() error {
+			f, err := os.Open(fn)
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			defer f.Close()
+
+			n, err := h.ServerList.RestoreSnapshot(f, s.snapshotMaxAge)
+			if err != nil {
+				return err
+			}
+			s.Logger.Info().Str("snapshot", fn).Msgf("restored %d servers from snapshot", n)
+			return nil
+		}(); err != nil {
+			return fmt.Errorf("restore snapshot %q: %w", fn, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package atlas
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+)
+
+//go:embed status.html
+var statusHTML string
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"duration": func(d time.Duration) string {
+		d = d.Truncate(time.Minute)
+		if d < time.Hour*24 {
+			return d.String()
+		}
+		return strconv.Itoa(int(d/(time.Hour*24))) + "d" + (d % (time.Hour * 24)).String()
+	},
+}).Parse(statusHTML))
+
+// statusInfo is a summary of the state of the master server.
+type statusInfo struct {
+	api0.Status
+	Version string
+	Uptime  time.Duration
+}
+
+// status gets the current statusInfo.
+func (s *Server) status() (statusInfo, error) {
+	st, err := s.API0.Status(time.Now())
+	if err != nil {
+		return statusInfo{}, err
+	}
+	return statusInfo{
+		Status:  st,
+		Version: buildVersion(),
+		Uptime:  time.Since(s.started),
+	}, nil
+}
+
+// buildVersion gets the module version and vcs revision Atlas was built with.
+func buildVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	v := bi.Main.Version
+	for _, x := range bi.Settings {
+		if x.Key == "vcs.revision" && len(x.Value) >= 7 {
+			v += " (" + x.Value[:7] + ")"
+		}
+	}
+	return v
+}
+
+// serveStatusPage serves a human-readable status page which doesn't require
+// JavaScript.
+func (s *Server) serveStatusPage(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	if st, err := s.status(); err != nil {
+		s.Logger.Error().Err(err).Msg("failed to get status")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	} else if err := statusTemplate.Execute(&b, st); err != nil {
+		s.Logger.Error().Err(err).Msg("failed to render status page")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		b.WriteTo(w)
+	}
+}
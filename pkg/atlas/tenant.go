@@ -0,0 +1,270 @@
+package atlas
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/nspkt"
+)
+
+// Tenant is an additional isolated community served by the same process (see
+// Config.Tenants). It has a separate server list, bad words list, and storage
+// (and therefore accounts, pdata, bans, ban lists, and reports).
+type Tenant struct {
+	Name   string
+	Host   string // if set, requests for this hostname are routed to the tenant
+	Prefix string // if set, requests under this path prefix are routed to the tenant with it stripped
+	API0   *api0.Handler
+}
+
+var tenantNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// parseTenants parses tenants in the format of Config.Tenants.
+func parseTenants(specs []string) ([]*Tenant, error) {
+	var ts []*Tenant
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		name, target, ok := strings.Cut(spec, "=")
+		if !ok || target == "" {
+			return nil, fmt.Errorf("tenant %q: expected name=hostname or name=/prefix", spec)
+		}
+		if !tenantNameRe.MatchString(name) {
+			return nil, fmt.Errorf("tenant %q: invalid name", name)
+		}
+		t := &Tenant{Name: name}
+		if strings.HasPrefix(target, "/") {
+			t.Prefix = strings.TrimRight(target, "/")
+			if t.Prefix == "" {
+				return nil, fmt.Errorf("tenant %q: prefix must not be the root", name)
+			}
+		} else {
+			t.Host = strings.ToLower(target)
+		}
+		for _, k := range []string{"name " + t.Name, "host " + t.Host, "prefix " + t.Prefix} {
+			if strings.HasSuffix(k, " ") {
+				continue // not set
+			}
+			if seen[k] {
+				return nil, fmt.Errorf("tenant %q: duplicate %s", name, k)
+			}
+			seen[k] = true
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// tenantSpec replaces {tenant} in spec with the tenant name. If there are
+// multiple tenants, non-memory specs must contain it so tenants don't share
+// storage.
+func tenantSpec(spec, name string, n int) (string, error) {
+	if n > 1 && !strings.Contains(spec, "{tenant}") && !strings.HasPrefix(spec, "memory") {
+		return "", fmt.Errorf("%q must contain {tenant} when there are multiple tenants", spec)
+	}
+	return strings.ReplaceAll(spec, "{tenant}", name), nil
+}
+
+// configureTenants creates the tenants in c, sharing the non-tenant-specific
+// options with base.
+func (s *Server) configureTenants(c *Config, base *api0.Handler) error {
+	ts, err := parseTenants(c.Tenants)
+	if err != nil {
+		return err
+	}
+	for _, t := range ts {
+		h, err := s.newAPI0Handler(c, t.Name)
+		if err != nil {
+			return fmt.Errorf("tenant %q: %w", t.Name, err)
+		}
+		shareAPI0(h, base)
+		t.API0 = h
+		s.Tenants = append(s.Tenants, t)
+
+		if spec, err := tenantSpec(c.Tenant_Storage_Accounts, t.Name, len(ts)); err != nil {
+			return fmt.Errorf("tenant %q: account storage: %w", t.Name, err)
+		} else if h.AccountStorage, err = OpenAccountStorage(spec, c.API0_Storage_EncryptionKeys); err != nil {
+			return fmt.Errorf("tenant %q: account storage: %w", t.Name, err)
+		}
+		if spec, err := tenantSpec(c.Tenant_Storage_Pdata, t.Name, len(ts)); err != nil {
+			return fmt.Errorf("tenant %q: pdata storage: %w", t.Name, err)
+		} else if h.PdataStorage, err = OpenPdataStorage(spec, c.API0_Storage_EncryptionKeys); err != nil {
+			return fmt.Errorf("tenant %q: pdata storage: %w", t.Name, err)
+		}
+		h.Compactors = storageCompactors(h.AccountStorage, h.PdataStorage)
+		setStorageLogger(s.Logger.With().Str("component", "storage").Str("tenant", t.Name).Logger(), h.AccountStorage, h.PdataStorage)
+		if err := configureAccountStorageFeatures(c, h); err != nil {
+			return fmt.Errorf("tenant %q: account storage: %w", t.Name, err)
+		}
+		if err := configureAccountLinks(c, h); err != nil {
+			return fmt.Errorf("tenant %q: account links: %w", t.Name, err)
+		}
+		if err := configureIPReputationVerify(c, h); err != nil {
+			return fmt.Errorf("tenant %q: ip reputation verification: %w", t.Name, err)
+		}
+
+		s.Logger.Info().
+			Str("tenant", t.Name).
+			Str("host", t.Host).
+			Str("prefix", t.Prefix).
+			Msg("configured tenant")
+	}
+	return nil
+}
+
+// shareAPI0 copies the dependencies shared between tenants from base to h.
+func shareAPI0(h, base *api0.Handler) {
+	h.NSPkt = base.NSPkt
+	h.OriginAuthMgr = base.OriginAuthMgr
+	h.EAXClient = base.EAXClient
+	h.Cache = base.Cache
+	h.Hooks = base.Hooks
+	h.Analytics = base.Analytics
+	h.Notify = base.Notify
+	h.ChatBridge = base.ChatBridge
+	h.SigningKeys = base.SigningKeys
+	h.VerifyClientCert = base.VerifyClientCert
+	h.LookupIP = base.LookupIP
+	h.GetRegion = base.GetRegion
+	h.LookupIPReputation = base.LookupIPReputation
+	h.LookupIPNetwork = base.LookupIPNetwork
+	h.MainMenuPromos = base.MainMenuPromos
+	h.MirrorCheckClient = base.MirrorCheckClient
+	h.ServerWebhookClient = base.ServerWebhookClient
+	h.NotFound = base.NotFound
+}
+
+// api0Handlers gets the api0 handlers for the default tenant and any
+// additional ones.
+func (s *Server) api0Handlers() []*api0.Handler {
+	hs := []*api0.Handler{s.API0}
+	for _, t := range s.Tenants {
+		hs = append(hs, t.API0)
+	}
+	return hs
+}
+
+// tenantHandler routes requests for tenants to their handler, and others to
+// next.
+func tenantHandler(next http.Handler, ts []*Tenant) http.Handler {
+	if len(ts) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		for _, t := range ts {
+			if t.Host != "" && strings.EqualFold(host, t.Host) {
+				t.API0.ServeHTTP(w, r)
+				return
+			}
+			if t.Prefix != "" && (r.URL.Path == t.Prefix || strings.HasPrefix(r.URL.Path, t.Prefix+"/")) {
+				http.StripPrefix(t.Prefix, t.API0).ServeHTTP(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// udpHeartbeatHandler routes UDP heartbeats to the handler (next or a
+// tenant's) with the server, since they share the UDP socket.
+func udpHeartbeatHandler(next *api0.Handler, ts []*Tenant) nspkt.AtlasHeartbeatHandler {
+	return func(addr netip.AddrPort, hb *nspkt.AtlasHeartbeat) (nspkt.AtlasHeartbeatStatus, []byte) {
+		status, key := next.HandleUDPHeartbeat(addr, hb)
+		for _, t := range ts {
+			if status != nspkt.AtlasHeartbeatNotFound {
+				break
+			}
+			status, key = t.API0.HandleUDPHeartbeat(addr, hb)
+		}
+		return status, key
+	}
+}
+
+// untenantedPath gets the path of r with the tenant and API version prefixes,
+// if any, removed.
+func (s *Server) untenantedPath(r *http.Request) string {
+	for _, t := range s.Tenants {
+		if t.Prefix != "" && (r.URL.Path == t.Prefix || strings.HasPrefix(r.URL.Path, t.Prefix+"/")) {
+			return api0.UnversionedPath(strings.TrimPrefix(r.URL.Path, t.Prefix))
+		}
+	}
+	return api0.UnversionedPath(r.URL.Path)
+}
+
+// tenantMetrics wraps fn to add a tenant label to the metrics it writes.
+func tenantMetrics(name string, fn func(io.Writer)) func(io.Writer) {
+	return func(w io.Writer) {
+		var b bytes.Buffer
+		fn(&b)
+		for _, line := range bytes.SplitAfter(b.Bytes(), []byte{'\n'}) {
+			if len(line) == 0 || line[0] == '#' {
+				continue
+			}
+			i := bytes.IndexAny(line, "{ ")
+			if i == -1 {
+				continue
+			}
+			w.Write(line[:i])
+			io.WriteString(w, `{tenant="`+name+`"`)
+			if line[i] == '{' {
+				if line[i+1] != '}' {
+					io.WriteString(w, ",")
+				}
+				w.Write(line[i+1:])
+			} else {
+				io.WriteString(w, "}")
+				w.Write(line[i:])
+			}
+		}
+	}
+}
+
+// loadBadWords loads a list of words to filter from server names and
+// descriptions from the file at fn, which contains one word per line. Blank
+// lines and lines starting with # are ignored. Words are matched
+// case-insensitively and replaced with asterisks.
+func loadBadWords(fn string) (func(string) string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ws []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if w := strings.TrimSpace(sc.Text()); w != "" && !strings.HasPrefix(w, "#") {
+			ws = append(ws, regexp.QuoteMeta(w))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(ws) == 0 {
+		return nil, nil
+	}
+	re, err := regexp.Compile(`(?i)` + strings.Join(ws, "|"))
+	if err != nil {
+		return nil, err
+	}
+	return func(s string) string {
+		return re.ReplaceAllStringFunc(s, func(m string) string {
+			return strings.Repeat("*", len([]rune(m)))
+		})
+	}, nil
+}
@@ -0,0 +1,93 @@
+package atlas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTenantGates(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "badwords.txt"), []byte("heck\n"), 0644); err != nil {
+		t.Fatalf("write bad words: %v", err)
+	}
+
+	env := []string{
+		"ATLAS_LOG_STDOUT=false",
+		"ATLAS_TENANTS=isolated=/isolated",
+		"ATLAS_API0_MINIMUM_LAUNCHER_VERSION=1.10.0",
+		"ATLAS_API0_GUEST_MODE=true",
+		"ATLAS_API0_GUEST_MODE_NETWORKS=198.51.100.0/24",
+		"ATLAS_API0_BADWORDS=" + filepath.Join(dir, "badwords.txt"),
+		"ATLAS_TENANT_BADWORDS=" + filepath.Join(dir, "badwords.txt"),
+	}
+	config := func(extra ...string) *Config {
+		var c Config
+		if err := c.UnmarshalEnv(append(append([]string(nil), env...), extra...), false); err != nil {
+			t.Fatalf("parse config: %v", err)
+		}
+		return &c
+	}
+
+	s, err := NewServer(config())
+	if err != nil {
+		t.Fatalf("initialize server: %v", err)
+	}
+	if len(s.Tenants) != 1 {
+		t.Fatalf("expected 1 tenant, got %d", len(s.Tenants))
+	}
+
+	do := func(prefix, path, version string) (int, string) {
+		r := httptest.NewRequest(http.MethodGet, prefix+path, nil)
+		r.Header.Set("User-Agent", "R2Northstar/"+version)
+		w := httptest.NewRecorder()
+		s.Handler.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	type gate struct {
+		name    string
+		path    string
+		version string
+		status  int
+		body    string
+	}
+	check := func(gates []gate) {
+		t.Helper()
+		for _, g := range gates {
+			for _, prefix := range []string{"", "/isolated"} {
+				tenant := "default"
+				if prefix != "" {
+					tenant = strings.TrimPrefix(prefix, "/")
+				}
+				status, body := do(prefix, g.path, g.version)
+				if status != g.status || !strings.Contains(body, g.body) {
+					t.Errorf("%s: %s: expected status %d with %q in body, got %d: %s", tenant, g.name, g.status, g.body, status, body)
+				}
+			}
+		}
+	}
+
+	check([]gate{
+		{"old client version", "/client/origin_auth?id=1&token=x", "1.9.0", http.StatusBadRequest, "UNSUPPORTED_VERSION"},
+		{"new client version", "/client/origin_auth", "1.10.0", http.StatusBadRequest, "id param is required"},
+		{"old guest client version", "/client/guest_auth?name=guest", "1.9.0", http.StatusBadRequest, "UNSUPPORTED_VERSION"},
+		{"guest network", "/client/guest_auth?name=guest", "1.10.0", http.StatusForbidden, "NETWORK_BLOCKED"},
+		{"bad words", "/server/diagnose?name=what+the+heck", "1.10.0", http.StatusOK, `what the ****`},
+	})
+
+	// tenants are reconfigured along with the default handler
+	s.LoadConfig = func() (*Config, error) {
+		return config("ATLAS_API0_MINIMUM_LAUNCHER_VERSION=1.11.0"), nil
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	check([]gate{
+		{"old client version after reload", "/client/origin_auth?id=1&token=x", "1.10.0", http.StatusBadRequest, "UNSUPPORTED_VERSION"},
+		{"new client version after reload", "/client/origin_auth", "1.11.0", http.StatusBadRequest, "id param is required"},
+	})
+}
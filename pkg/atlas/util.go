@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"net/netip"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/pg9182/ip2x"
 	"github.com/rs/zerolog"
@@ -14,9 +16,10 @@ import (
 
 // ip2xMgr wraps a file-backed IP2Location database.
 type ip2xMgr struct {
-	file *os.File
-	db   *ip2x.DB
-	mu   sync.RWMutex
+	product ip2x.DBProduct // defaults to ip2x.IP2Location
+	file    *os.File
+	db      *ip2x.DB
+	mu      sync.RWMutex
 }
 
 // Load replaces the currently loaded database with the specified file. If name
@@ -42,9 +45,13 @@ func (m *ip2xMgr) Load(name string) error {
 		return err
 	}
 
-	if p, _ := db.Info(); p != ip2x.IP2Location {
+	product := m.product
+	if product == 0 {
+		product = ip2x.IP2Location
+	}
+	if p, _ := db.Info(); p != product {
 		f.Close()
-		return fmt.Errorf("not an ip2location database")
+		return fmt.Errorf("not an %s database", strings.ToLower(product.String()))
 	}
 
 	m.mu.Lock()
@@ -67,15 +74,17 @@ func (m *ip2xMgr) LookupFields(ip netip.Addr) (ip2x.Record, error) {
 }
 
 type zerologWriterLevel struct {
-	w io.Writer // or zerolog.LevelWriter
-	l zerolog.Level
+	w io.Writer    // or zerolog.LevelWriter
+	l atomic.Int32 // zerolog.Level
 	m sync.Mutex
 }
 
 var _ zerolog.LevelWriter = (*zerologWriterLevel)(nil)
 
 func newZerologWriterLevel(w io.Writer, l zerolog.Level) *zerologWriterLevel {
-	return &zerologWriterLevel{w: w, l: l}
+	wl := &zerologWriterLevel{w: w}
+	wl.SetLevel(l)
+	return wl
 }
 
 func (wl *zerologWriterLevel) Write(p []byte) (n int, err error) {
@@ -88,7 +97,7 @@ func (wl *zerologWriterLevel) Write(p []byte) (n int, err error) {
 }
 
 func (wl *zerologWriterLevel) WriteLevel(l zerolog.Level, p []byte) (n int, err error) {
-	if l >= wl.l {
+	if l >= zerolog.Level(wl.l.Load()) {
 		wl.m.Lock()
 		defer wl.m.Unlock()
 		if wl.w != nil {
@@ -101,6 +110,10 @@ func (wl *zerologWriterLevel) WriteLevel(l zerolog.Level, p []byte) (n int, err
 	return len(p), nil
 }
 
+func (wl *zerologWriterLevel) SetLevel(l zerolog.Level) {
+	wl.l.Store(int32(l))
+}
+
 func (wl *zerologWriterLevel) SwapWriter(fn func(io.Writer) io.Writer) {
 	wl.m.Lock()
 	defer wl.m.Unlock()
@@ -0,0 +1,159 @@
+package atlasclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+)
+
+// Admin makes a request to an arbitrary admin endpoint (e.g., /admin/bans),
+// encoding body (if non-nil) as JSON and decoding the response into res (if
+// non-nil). GET, HEAD, PUT, and DELETE requests are retried.
+func (c *Client) Admin(ctx context.Context, method, path string, query url.Values, body, res any) error {
+	r := request{
+		method: method,
+		path:   path,
+	}
+	if body != nil {
+		var err error
+		if r, err = jsonRequest(method, path, body); err != nil {
+			return err
+		}
+	}
+	r.query = query
+	r.admin = true
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		r.idempotent = true
+	}
+	return c.do(ctx, r, res)
+}
+
+// adminList gets the list in the named field of the response from an admin
+// endpoint.
+func adminList[T any](ctx context.Context, c *Client, path, field string) ([]T, error) {
+	var res map[string]json.RawMessage
+	if err := c.Admin(ctx, http.MethodGet, path, nil, nil, &res); err != nil {
+		return nil, err
+	}
+	var xs []T
+	if err := json.Unmarshal(res[field], &xs); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return xs, nil
+}
+
+// MOTD gets all messages of the day, including inactive ones.
+func (c *Client) MOTD(ctx context.Context) ([]api0.MOTD, error) {
+	return adminList[api0.MOTD](ctx, c, "/admin/motd", "motd")
+}
+
+// SetMOTD replaces all messages of the day.
+func (c *Client) SetMOTD(ctx context.Context, ms []api0.MOTD) error {
+	if ms == nil {
+		ms = []api0.MOTD{}
+	}
+	return c.Admin(ctx, http.MethodPut, "/admin/motd", nil, ms, nil)
+}
+
+// PutMOTD adds or replaces a message of the day by ID.
+func (c *Client) PutMOTD(ctx context.Context, m api0.MOTD) error {
+	return c.Admin(ctx, http.MethodPost, "/admin/motd", nil, m, nil)
+}
+
+// DeleteMOTD deletes a message of the day by ID.
+func (c *Client) DeleteMOTD(ctx context.Context, id string) error {
+	return c.Admin(ctx, http.MethodDelete, "/admin/motd", url.Values{
+		"id": {id},
+	}, nil, nil)
+}
+
+// Bans gets the current bans.
+func (c *Client) Bans(ctx context.Context) ([]api0.Ban, error) {
+	return adminList[api0.Ban](ctx, c, "/admin/bans", "bans")
+}
+
+// AddBan adds or replaces a ban by UID.
+func (c *Client) AddBan(ctx context.Context, b api0.Ban) error {
+	return c.Admin(ctx, http.MethodPost, "/admin/bans", nil, b, nil)
+}
+
+// RemoveBan removes a ban.
+func (c *Client) RemoveBan(ctx context.Context, uid uint64) error {
+	return c.Admin(ctx, http.MethodDelete, "/admin/bans", url.Values{
+		"uid": {strconv.FormatUint(uid, 10)},
+	}, nil, nil)
+}
+
+// Releases gets the launcher releases in the update manifest.
+func (c *Client) Releases(ctx context.Context) ([]api0.Release, error) {
+	return adminList[api0.Release](ctx, c, "/admin/releases", "releases")
+}
+
+// SetReleases replaces all launcher releases.
+func (c *Client) SetReleases(ctx context.Context, rs []api0.Release) error {
+	if rs == nil {
+		rs = []api0.Release{}
+	}
+	return c.Admin(ctx, http.MethodPut, "/admin/releases", nil, rs, nil)
+}
+
+// PutRelease adds or replaces the release for a component and channel.
+func (c *Client) PutRelease(ctx context.Context, r api0.Release) error {
+	return c.Admin(ctx, http.MethodPost, "/admin/releases", nil, r, nil)
+}
+
+// DeleteRelease deletes the release for a component and channel.
+func (c *Client) DeleteRelease(ctx context.Context, component, channel string) error {
+	return c.Admin(ctx, http.MethodDelete, "/admin/releases", url.Values{
+		"component": {component},
+		"channel":   {channel},
+	}, nil, nil)
+}
+
+// Mods gets the mod index.
+func (c *Client) Mods(ctx context.Context) ([]api0.ModIndexEntry, error) {
+	return adminList[api0.ModIndexEntry](ctx, c, "/admin/mods", "mods")
+}
+
+// SetMods replaces the mod index.
+func (c *Client) SetMods(ctx context.Context, ms []api0.ModIndexEntry) error {
+	if ms == nil {
+		ms = []api0.ModIndexEntry{}
+	}
+	return c.Admin(ctx, http.MethodPut, "/admin/mods", nil, ms, nil)
+}
+
+// PutMod adds or replaces a mod index entry by name and version.
+func (c *Client) PutMod(ctx context.Context, m api0.ModIndexEntry) error {
+	return c.Admin(ctx, http.MethodPost, "/admin/mods", nil, m, nil)
+}
+
+// DeleteMod deletes a mod from the index. If version is empty, all versions
+// are deleted.
+func (c *Client) DeleteMod(ctx context.Context, name, version string) error {
+	q := url.Values{
+		"name": {name},
+	}
+	if version != "" {
+		q.Set("version", version)
+	}
+	return c.Admin(ctx, http.MethodDelete, "/admin/mods", q, nil, nil)
+}
+
+// EraseAccount erases all data for a player.
+func (c *Client) EraseAccount(ctx context.Context, uid uint64) error {
+	return c.Admin(ctx, http.MethodPost, "/admin/erase", url.Values{
+		"uid": {strconv.FormatUint(uid, 10)},
+	}, nil, nil)
+}
+
+// Reload reloads the Atlas configuration.
+func (c *Client) Reload(ctx context.Context) error {
+	return c.Admin(ctx, http.MethodPost, "/admin/reload", nil, nil, nil)
+}
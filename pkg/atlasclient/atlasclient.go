@@ -0,0 +1,630 @@
+// Package atlasclient is a typed client for the Atlas API, for tools like
+// bots, dashboards, and server hosting utilities.
+package atlasclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultUserAgent is the User-Agent used if none is set. Atlas checks the
+// launcher version for some endpoints, so it is in the same form as the one
+// sent by the game.
+const DefaultUserAgent = "R2Northstar/0.0.0+dev atlasclient"
+
+// Error is returned when Atlas responds with an error.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("atlas responded with status %d", e.Status)
+	}
+	if e.Message == "" {
+		return fmt.Sprintf("atlas responded with status %d (%s)", e.Status, e.Code)
+	}
+	return fmt.Sprintf("atlas responded with status %d: %s (%s)", e.Status, e.Message, e.Code)
+}
+
+// IsCode checks whether err is an Error with the provided error code (e.g.,
+// PLAYER_NOT_FOUND).
+func IsCode(err error, code string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// Client makes requests to an Atlas instance. It is safe for concurrent use,
+// but the fields must not be changed after the first request.
+type Client struct {
+	// BaseURL is the URL of the Atlas instance, including the tenant prefix if
+	// any (e.g., https://northstar.tf). It is required.
+	BaseURL string
+
+	// HTTPClient is the client to use for requests. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// UserAgent, if provided, overrides DefaultUserAgent.
+	UserAgent string
+
+	// AdminSecret is the secret for the admin API. It is only sent to admin
+	// endpoints.
+	AdminSecret string
+
+	// MaxRetries is the maximum number of times to retry idempotent requests
+	// after a network error or a 429, 502, 503, or 504 response. If zero, it
+	// defaults to 3. If negative, requests are not retried.
+	MaxRetries int
+
+	// RetryDelay is the delay before the first retry, which is doubled for
+	// each subsequent one. A Retry-After header takes precedence if it is
+	// longer. If zero, it defaults to 250ms.
+	RetryDelay time.Duration
+}
+
+// request is an API request.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	ctype  string
+	body   []byte
+	admin  bool
+
+	// idempotent requests are retried
+	idempotent bool
+
+	// raw, if set, receives the response body as-is instead of it being
+	// checked for an error object and decoded into res.
+	raw *[]byte
+}
+
+// do makes an API request, decoding the response JSON into res if it is
+// successful.
+func (c *Client) do(ctx context.Context, r request, res any) error {
+	retries := c.MaxRetries
+	if retries == 0 {
+		retries = 3
+	}
+	delay := c.RetryDelay
+	if delay <= 0 {
+		delay = time.Millisecond * 250
+	}
+	if !r.idempotent {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		retry, after, err := c.attempt(ctx, r, res)
+		if !retry || attempt >= retries || ctx.Err() != nil {
+			return err
+		}
+		if after < delay {
+			after = delay
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(after):
+		}
+		delay *= 2
+	}
+}
+
+// attempt makes a single request, returning whether it can be retried and
+// the Retry-After delay, if any.
+func (c *Client) attempt(ctx context.Context, r request, res any) (bool, time.Duration, error) {
+	u := strings.TrimSuffix(c.BaseURL, "/") + r.path
+	if len(r.query) != 0 {
+		u += "?" + r.query.Encode()
+	}
+
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, u, body)
+	if err != nil {
+		return false, 0, err
+	}
+	if r.ctype != "" {
+		req.Header.Set("Content-Type", r.ctype)
+	}
+	if v := c.UserAgent; v != "" {
+		req.Header.Set("User-Agent", v)
+	} else {
+		req.Header.Set("User-Agent", DefaultUserAgent)
+	}
+	if r.admin {
+		req.Header.Set("Authorization", "Bearer "+c.AdminSecret)
+	}
+
+	cl := c.HTTPClient
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+
+	resp, err := cl.Do(req)
+	if err != nil {
+		return true, 0, err
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return true, 0, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		var after time.Duration
+		if v, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && v > 0 {
+			after = time.Duration(v) * time.Second
+		}
+		return true, after, respError(resp.StatusCode, buf)
+	}
+
+	if r.raw != nil {
+		if resp.StatusCode != http.StatusOK {
+			return false, 0, respError(resp.StatusCode, buf)
+		}
+		*r.raw = buf
+		return false, 0, nil
+	}
+
+	// write_persistence responds with null on success
+	if resp.StatusCode == http.StatusOK && string(bytes.TrimSpace(buf)) == "null" {
+		return false, 0, nil
+	}
+
+	var obj struct {
+		Success bool `json:"success"`
+	}
+	if err := json.Unmarshal(buf, &obj); err != nil || !obj.Success || resp.StatusCode != http.StatusOK {
+		return false, 0, respError(resp.StatusCode, buf)
+	}
+	if res != nil {
+		if err := json.Unmarshal(buf, res); err != nil {
+			return false, 0, fmt.Errorf("decode response: %w", err)
+		}
+	}
+	return false, 0, nil
+}
+
+// respError gets the error from an error response.
+func respError(status int, buf []byte) error {
+	var obj struct {
+		Error struct {
+			Enum string `json:"enum"`
+			Msg  string `json:"msg"`
+		} `json:"error"`
+	}
+	json.Unmarshal(buf, &obj)
+	return &Error{
+		Status:  status,
+		Code:    obj.Error.Enum,
+		Message: obj.Error.Msg,
+	}
+}
+
+// jsonRequest creates a request with a JSON body.
+func jsonRequest(method, path string, obj any) (request, error) {
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		return request{}, fmt.Errorf("encode request: %w", err)
+	}
+	return request{
+		method: method,
+		path:   path,
+		ctype:  "application/json",
+		body:   buf,
+	}, nil
+}
+
+// Player is an authenticated player.
+type Player struct {
+	// UID is the player's Origin UID.
+	UID uint64
+
+	// Token is the masterserver token returned by OriginAuth.
+	Token string
+}
+
+func (p *Player) query(q url.Values) url.Values {
+	if q == nil {
+		q = url.Values{}
+	}
+	if p != nil {
+		q.Set("id", strconv.FormatUint(p.UID, 10))
+		q.Set("token", p.Token)
+	}
+	return q
+}
+
+// Server is a server in the server list.
+type Server struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Region        string    `json:"region,omitempty"`
+	Description   string    `json:"description"`
+	PlayerCount   int       `json:"playerCount"`
+	MaxPlayers    int       `json:"maxPlayers"`
+	Map           string    `json:"map"`
+	Playlist      string    `json:"playlist"`
+	HasPassword   bool      `json:"hasPassword"`
+	Trusted       bool      `json:"trusted,omitempty"`
+	Attestation   string    `json:"attestation,omitempty"`
+	Featured      bool      `json:"featured,omitempty"`
+	ModInfo       ModInfo   `json:"modInfo"`
+	LastHeartbeat time.Time `json:"-"`
+}
+
+// ModInfo contains the mods used by a server.
+type ModInfo struct {
+	Mods []Mod `json:"Mods"`
+}
+
+// Mod is a mod used by a server.
+type Mod struct {
+	Name             string `json:"Name"`
+	Version          string `json:"Version"`
+	RequiredOnClient bool   `json:"RequiredOnClient"`
+}
+
+func (s *Server) UnmarshalJSON(b []byte) error {
+	type server Server
+	var obj struct {
+		*server
+		LastHeartbeat int64 `json:"lastHeartbeat"`
+	}
+	obj.server = (*server)(s)
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+	s.LastHeartbeat = time.UnixMilli(obj.LastHeartbeat)
+	return nil
+}
+
+// Servers gets the server list. If p is provided, servers with an allowlist
+// including the player are also listed.
+func (c *Client) Servers(ctx context.Context, p *Player) ([]Server, error) {
+	var raw []byte
+	if err := c.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/client/servers",
+		query:      p.query(nil),
+		idempotent: true,
+		raw:        &raw,
+	}, nil); err != nil {
+		return nil, err
+	}
+	var ss []Server
+	if err := json.Unmarshal(raw, &ss); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return ss, nil
+}
+
+// OriginAuth authenticates a player with an Origin (Stryder) token,
+// returning the player with their masterserver token. Tokens can only be used
+// once, so this request is not retried.
+func (c *Client) OriginAuth(ctx context.Context, uid uint64, originToken string) (*Player, error) {
+	var res struct {
+		Token string `json:"token"`
+	}
+	if err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/client/origin_auth",
+		query: url.Values{
+			"id":    {strconv.FormatUint(uid, 10)},
+			"token": {originToken},
+		},
+	}, &res); err != nil {
+		return nil, err
+	}
+	return &Player{UID: uid, Token: res.Token}, nil
+}
+
+// ServerConnection contains the information required to connect to a game
+// server.
+type ServerConnection struct {
+	// Addr is the game server address.
+	Addr netip.AddrPort
+
+	// AuthToken is the token to connect to the game server with.
+	AuthToken string
+}
+
+// AuthWithServer authenticates the player with a game server.
+func (c *Client) AuthWithServer(ctx context.Context, p Player, serverID, password string) (*ServerConnection, error) {
+	q := url.Values{
+		"id":          {strconv.FormatUint(p.UID, 10)},
+		"playerToken": {p.Token},
+		"server":      {serverID},
+	}
+	if password != "" {
+		q.Set("password", password)
+	}
+	var res struct {
+		IP        string `json:"ip"`
+		Port      uint16 `json:"port"`
+		AuthToken string `json:"authToken"`
+	}
+	if err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/client/auth_with_server",
+		query:  q,
+	}, &res); err != nil {
+		return nil, err
+	}
+	ip, err := netip.ParseAddr(res.IP)
+	if err != nil {
+		return nil, fmt.Errorf("decode response: invalid ip: %w", err)
+	}
+	return &ServerConnection{
+		Addr:      netip.AddrPortFrom(ip, res.Port),
+		AuthToken: res.AuthToken,
+	}, nil
+}
+
+// AuthWithSelf authenticates the player for a local (listen) server,
+// returning their raw pdata.
+func (c *Client) AuthWithSelf(ctx context.Context, p Player) ([]byte, error) {
+	var res struct {
+		PersistentData []int `json:"persistentData"`
+	}
+	if err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/client/auth_with_self",
+		query: url.Values{
+			"id":          {strconv.FormatUint(p.UID, 10)},
+			"playerToken": {p.Token},
+		},
+	}, &res); err != nil {
+		return nil, err
+	}
+	b := make([]byte, len(res.PersistentData))
+	for i, x := range res.PersistentData {
+		b[i] = byte(x)
+	}
+	return b, nil
+}
+
+// ServerInfo is the information about a game server sent when registering
+// or updating it.
+type ServerInfo struct {
+	Name        string
+	Description string
+	Password    string
+	Map         string
+	Playlist    string
+	PlayerCount int
+	MaxPlayers  int
+	Visibility  string   // blank for public
+	Allowlist   []uint64 // for the allowlist visibility
+}
+
+func (i ServerInfo) query() url.Values {
+	q := url.Values{
+		"name":        {i.Name},
+		"description": {i.Description},
+		"password":    {i.Password},
+		"map":         {i.Map},
+		"playlist":    {i.Playlist},
+		"playerCount": {strconv.Itoa(i.PlayerCount)},
+		"maxPlayers":  {strconv.Itoa(i.MaxPlayers)},
+	}
+	if i.Visibility != "" {
+		q.Set("visibility", i.Visibility)
+	}
+	if len(i.Allowlist) != 0 {
+		uids := make([]string, len(i.Allowlist))
+		for j, uid := range i.Allowlist {
+			uids[j] = strconv.FormatUint(uid, 10)
+		}
+		q.Set("allowlist", strings.Join(uids, ","))
+	}
+	return q
+}
+
+// RegisteredServer is a game server added to the server list.
+type RegisteredServer struct {
+	ID              string `json:"id"`
+	ServerAuthToken string `json:"serverAuthToken"`
+}
+
+// RegisterServer adds a game server listening on the request's source IP to
+// the server list. Atlas verifies the server before responding. If authPort
+// is zero, the server is authenticated over UDP on the game port.
+func (c *Client) RegisterServer(ctx context.Context, port, authPort uint16, info ServerInfo, mods []Mod) (*RegisteredServer, error) {
+	q := info.query()
+	q.Set("port", strconv.Itoa(int(port)))
+	if authPort != 0 {
+		q.Set("authPort", strconv.Itoa(int(authPort)))
+	} else {
+		q.Set("authPort", "udp")
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if fw, err := mw.CreateFormFile("modinfo", "modinfo.json"); err != nil {
+		return nil, err
+	} else {
+		if mods == nil {
+			mods = []Mod{}
+		}
+		if err := json.NewEncoder(fw).Encode(ModInfo{Mods: mods}); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var res RegisteredServer
+	if err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/server/add_server",
+		query:  q,
+		ctype:  mw.FormDataContentType(),
+		body:   body.Bytes(),
+	}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Heartbeat sends a heartbeat for a game server with the current player
+// count.
+func (c *Client) Heartbeat(ctx context.Context, serverID string, playerCount int) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/server/heartbeat",
+		query: url.Values{
+			"id":          {serverID},
+			"playerCount": {strconv.Itoa(playerCount)},
+		},
+		idempotent: true,
+	}, nil)
+}
+
+// UpdateServer updates the information for a game server.
+func (c *Client) UpdateServer(ctx context.Context, serverID string, info ServerInfo) error {
+	q := info.query()
+	q.Set("id", serverID)
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/server/update_values",
+		query:      q,
+		idempotent: true,
+	}, nil)
+}
+
+// RemoveServer removes a game server from the server list.
+func (c *Client) RemoveServer(ctx context.Context, serverID string) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/server/remove_server",
+		query: url.Values{
+			"id": {serverID},
+		},
+		idempotent: true,
+	}, nil)
+}
+
+// GetPdata gets the public pdata for a player, decoding it into v, which
+// should be a struct containing the fields of interest (in the same form as
+// the JSON encoding of pdata.Pdata).
+func (c *Client) GetPdata(ctx context.Context, uid uint64, v any) error {
+	var raw []byte
+	if err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/player/pdata",
+		query: url.Values{
+			"id": {strconv.FormatUint(uid, 10)},
+		},
+		idempotent: true,
+		raw:        &raw,
+	}, nil); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// WritePersistence saves the raw pdata for a player on a game server.
+func (c *Client) WritePersistence(ctx context.Context, serverID string, uid uint64, pdata []byte) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if fw, err := mw.CreateFormFile("pdata", "file.pdata"); err != nil {
+		return err
+	} else if _, err := fw.Write(pdata); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/accounts/write_persistence",
+		query: url.Values{
+			"id":       {strconv.FormatUint(uid, 10)},
+			"serverId": {serverID},
+		},
+		ctype:      mw.FormDataContentType(),
+		body:       body.Bytes(),
+		idempotent: true,
+	}, nil)
+}
+
+// PatchPersistence updates the specified pdata fields (in the same form as
+// the JSON encoding of pdata.Pdata) for a player on a game server.
+func (c *Client) PatchPersistence(ctx context.Context, serverID string, uid uint64, patch any) error {
+	r, err := jsonRequest(http.MethodPatch, "/accounts/write_persistence", patch)
+	if err != nil {
+		return err
+	}
+	r.query = url.Values{
+		"id":       {strconv.FormatUint(uid, 10)},
+		"serverId": {serverID},
+	}
+	r.idempotent = true
+	return c.do(ctx, r, nil)
+}
+
+// GetUsername gets the last known username for a player, returning an empty
+// string if it isn't known.
+func (c *Client) GetUsername(ctx context.Context, uid uint64) (string, error) {
+	var res struct {
+		Matches []string `json:"matches"`
+	}
+	if err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/accounts/get_username",
+		query: url.Values{
+			"uid": {strconv.FormatUint(uid, 10)},
+		},
+		idempotent: true,
+	}, &res); err != nil {
+		return "", err
+	}
+	if len(res.Matches) == 0 {
+		return "", nil
+	}
+	return res.Matches[0], nil
+}
+
+// LookupUID gets the UIDs of players with the specified username.
+func (c *Client) LookupUID(ctx context.Context, username string) ([]uint64, error) {
+	var res struct {
+		Matches []uint64 `json:"matches"`
+	}
+	if err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/accounts/lookup_uid",
+		query: url.Values{
+			"username": {username},
+		},
+		idempotent: true,
+	}, &res); err != nil {
+		return nil, err
+	}
+	return res.Matches, nil
+}
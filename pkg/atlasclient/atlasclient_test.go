@@ -0,0 +1,234 @@
+package atlasclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/atlasclient"
+	"github.com/r2northstar/atlas/pkg/fakeserver"
+	"github.com/r2northstar/atlas/pkg/memstore"
+	"github.com/r2northstar/atlas/pkg/nspkt"
+	"github.com/r2northstar/atlas/pkg/pdata"
+)
+
+func newMasterServer(t *testing.T) (*api0.Handler, *httptest.Server) {
+	as := memstore.NewAccountStore()
+	h := &api0.Handler{
+		AccountStorage:               as,
+		StateStorage:                 as,
+		PdataStorage:                 memstore.NewPdataStore(false),
+		ServerList:                   api0.NewServerList(time.Minute, time.Minute*2, time.Second*5, api0.ServerListConfig{}),
+		NSPkt:                        nspkt.NewListener(),
+		AdminSecret:                  "secret",
+		InsecureDevNoCheckPlayerAuth: true,
+	}
+	go h.NSPkt.ListenAndServe(netip.MustParseAddrPort("127.0.0.1:0"))
+	t.Cleanup(h.NSPkt.Close)
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return h, srv
+}
+
+func TestClient(t *testing.T) {
+	h, ms := newMasterServer(t)
+	ctx := context.Background()
+	c := &atlasclient.Client{BaseURL: ms.URL}
+
+	// the fake server is only used to respond to verification at first
+	gs := &fakeserver.Server{
+		MasterServer: ms.URL,
+		Info: fakeserver.Info{
+			Name:       "fake",
+			Map:        "mp_forwardbase_kodai",
+			Playlist:   "aitdm",
+			MaxPlayers: 16,
+		},
+	}
+	if err := gs.Listen(netip.MustParseAddr("127.0.0.1")); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer gs.Close()
+
+	info := atlasclient.ServerInfo{
+		Name:       "test",
+		Map:        "mp_forwardbase_kodai",
+		Playlist:   "aitdm",
+		MaxPlayers: 16,
+	}
+	reg, err := c.RegisterServer(ctx, gs.GameAddr().Port(), gs.AuthAddr().Port(), info, []atlasclient.Mod{
+		{Name: "Northstar.Custom", Version: "1.0.0", RequiredOnClient: true},
+	})
+	if err != nil {
+		t.Fatalf("register server: %v", err)
+	}
+	if reg.ID == "" || reg.ServerAuthToken == "" {
+		t.Errorf("incorrect registration %+v", reg)
+	}
+
+	info.PlayerCount = 2
+	if err := c.UpdateServer(ctx, reg.ID, info); err != nil {
+		t.Errorf("update server: %v", err)
+	}
+	if err := c.Heartbeat(ctx, reg.ID, 3); err != nil {
+		t.Errorf("heartbeat: %v", err)
+	}
+
+	ss, err := c.Servers(ctx, nil)
+	if err != nil {
+		t.Fatalf("list servers: %v", err)
+	}
+	if len(ss) != 1 || ss[0].ID != reg.ID || ss[0].Name != "test" || ss[0].PlayerCount != 3 || len(ss[0].ModInfo.Mods) != 1 || !ss[0].ModInfo.Mods[0].RequiredOnClient || time.Since(ss[0].LastHeartbeat) > time.Minute {
+		t.Errorf("incorrect server list %+v", ss)
+	}
+
+	if err := c.RemoveServer(ctx, reg.ID); err != nil {
+		t.Errorf("remove server: %v", err)
+	}
+	if h.ServerList.GetServerByID(reg.ID) != nil {
+		t.Errorf("server not removed")
+	}
+
+	// the fake server needs to be registered itself to authenticate players
+	if err := gs.Register(ctx); err != nil {
+		t.Fatalf("register fake server: %v", err)
+	}
+
+	p, err := c.OriginAuth(ctx, 1, "x")
+	if err != nil {
+		t.Fatalf("origin auth: %v", err)
+	}
+	if p.UID != 1 || p.Token == "" {
+		t.Errorf("incorrect player %+v", p)
+	}
+	if _, err := c.AuthWithServer(ctx, *p, "nonexistent", ""); err == nil {
+		t.Errorf("expected auth with a nonexistent server to fail")
+	}
+	conn, err := c.AuthWithServer(ctx, *p, gs.ID(), "")
+	if err != nil {
+		t.Fatalf("auth with server: %v", err)
+	}
+	if conn.Addr != gs.GameAddr() || conn.AuthToken == "" {
+		t.Errorf("incorrect server connection %+v", conn)
+	}
+	if raw, err := c.AuthWithSelf(ctx, *p); err != nil {
+		t.Errorf("auth with self: %v", err)
+	} else if len(raw) == 0 {
+		t.Errorf("no pdata returned by auth with self")
+	}
+
+	pd := pdata.Pdata{}
+	if err := pd.UnmarshalBinary(pdata.DefaultPdata); err != nil {
+		t.Fatalf("decode default pdata: %v", err)
+	}
+	pd.Xp = 1234
+	buf, err := pd.MarshalBinary()
+	if err != nil {
+		t.Fatalf("encode pdata: %v", err)
+	}
+	if err := c.WritePersistence(ctx, gs.ID(), 1, buf); err != nil {
+		t.Fatalf("write persistence: %v", err)
+	}
+	if err := c.PatchPersistence(ctx, gs.ID(), 1, map[string]any{"gen": 2}); err != nil {
+		t.Fatalf("patch persistence: %v", err)
+	}
+	var x struct {
+		Xp  int32 `json:"xp"`
+		Gen int32 `json:"gen"`
+	}
+	if err := c.GetPdata(ctx, 1, &x); err != nil {
+		t.Fatalf("get pdata: %v", err)
+	} else if x.Xp != 1234 || x.Gen != 2 {
+		t.Errorf("incorrect pdata xp=%d gen=%d", x.Xp, x.Gen)
+	}
+	if err := c.GetPdata(ctx, 2, &x); !atlasclient.IsCode(err, "PLAYER_NOT_FOUND") {
+		t.Errorf("expected PLAYER_NOT_FOUND for an unknown player, got %v", err)
+	}
+	if name, err := c.GetUsername(ctx, 1); err != nil || name != "" {
+		t.Errorf("get username: expected no username, got %q %v", name, err)
+	}
+}
+
+func TestClientAdmin(t *testing.T) {
+	_, ms := newMasterServer(t)
+	ctx := context.Background()
+
+	if _, err := (&atlasclient.Client{BaseURL: ms.URL, AdminSecret: "wrong"}).Bans(ctx); !atlasclient.IsCode(err, "UNAUTHORIZED") {
+		t.Errorf("expected UNAUTHORIZED with the wrong secret, got %v", err)
+	}
+
+	c := &atlasclient.Client{BaseURL: ms.URL, AdminSecret: "secret"}
+	if err := c.AddBan(ctx, api0.Ban{UID: 1, Reason: "cheating"}); err != nil {
+		t.Fatalf("add ban: %v", err)
+	}
+	if bs, err := c.Bans(ctx); err != nil {
+		t.Fatalf("get bans: %v", err)
+	} else if len(bs) != 1 || bs[0].UID != 1 || bs[0].Reason != "cheating" || bs[0].BannedAt.IsZero() {
+		t.Errorf("incorrect bans %+v", bs)
+	}
+	if err := c.RemoveBan(ctx, 1); err != nil {
+		t.Fatalf("remove ban: %v", err)
+	}
+	if bs, err := c.Bans(ctx); err != nil || len(bs) != 0 {
+		t.Errorf("expected no bans, got %+v %v", bs, err)
+	}
+
+	if err := c.PutMOTD(ctx, api0.MOTD{ID: "a", MOTDContent: api0.MOTDContent{Title: "Hello"}}); err != nil {
+		t.Fatalf("put motd: %v", err)
+	}
+	if m, err := c.MOTD(ctx); err != nil || len(m) != 1 || m[0].Title != "Hello" {
+		t.Errorf("incorrect motd %+v %v", m, err)
+	}
+	if err := c.SetMOTD(ctx, nil); err != nil {
+		t.Fatalf("set motd: %v", err)
+	}
+	if m, err := c.MOTD(ctx); err != nil || len(m) != 0 {
+		t.Errorf("expected no motd, got %+v %v", m, err)
+	}
+	if err := c.PutMOTD(ctx, api0.MOTD{}); !atlasclient.IsCode(err, "BAD_REQUEST") {
+		t.Errorf("expected BAD_REQUEST for an invalid motd, got %v", err)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	var n atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"success":true,"matches":["test"]}`))
+	}))
+	defer srv.Close()
+
+	c := &atlasclient.Client{BaseURL: srv.URL, RetryDelay: time.Millisecond}
+	if name, err := c.GetUsername(context.Background(), 1); err != nil || name != "test" {
+		t.Errorf("expected retried request to succeed, got %q %v", name, err)
+	}
+	if v := n.Load(); v != 3 {
+		t.Errorf("expected 3 attempts, got %d", v)
+	}
+
+	n.Store(0)
+	if _, err := c.OriginAuth(context.Background(), 1, "x"); err == nil {
+		t.Errorf("expected non-idempotent request to fail without retrying")
+	}
+	if v := n.Load(); v != 1 {
+		t.Errorf("expected 1 attempt, got %d", v)
+	}
+
+	n.Store(0)
+	c.MaxRetries = -1
+	if _, err := c.GetUsername(context.Background(), 1); err == nil {
+		t.Errorf("expected request to fail with retries disabled")
+	}
+	if v := n.Load(); v != 1 {
+		t.Errorf("expected 1 attempt, got %d", v)
+	}
+}
@@ -0,0 +1,112 @@
+// Package cache implements shared caches for expensive lookups.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache is a key-value cache with expiry. It must be safe for concurrent use.
+// Caches are best-effort, so values may be evicted at any time.
+type Cache interface {
+	// Get gets the value for key. If it does not exist or has expired, exists
+	// is false. If another error occurs, err is non-nil.
+	Get(key string) (buf []byte, exists bool, err error)
+
+	// Set sets the value for key, expiring after ttl. The cache must not
+	// retain buf.
+	Set(key string, buf []byte, ttl time.Duration) error
+
+	// Delete deletes the value for key if it exists.
+	Delete(key string) error
+}
+
+// LRU is an in-process least-recently-used cache.
+type LRU struct {
+	max int
+
+	mu sync.Mutex
+	ll *list.List
+	m  map[string]*list.Element
+}
+
+type lruEntry struct {
+	key string
+	buf []byte
+	exp time.Time
+}
+
+// NewLRU creates a new LRU cache holding up to max entries.
+func NewLRU(max int) *LRU {
+	return &LRU{
+		max: max,
+		ll:  list.New(),
+		m:   make(map[string]*list.Element),
+	}
+}
+
+func (c *LRU) Get(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.m[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*lruEntry)
+	if !time.Now().Before(e.exp) {
+		c.ll.Remove(el)
+		delete(c.m, key)
+		return nil, false, nil
+	}
+	c.ll.MoveToFront(el)
+	return append([]byte{}, e.buf...), true, nil
+}
+
+func (c *LRU) Set(key string, buf []byte, ttl time.Duration) error {
+	if c.max <= 0 {
+		return nil
+	}
+
+	e := &lruEntry{
+		key: key,
+		buf: append([]byte{}, buf...),
+		exp: time.Now().Add(ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.m[key]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return nil
+	}
+	for c.ll.Len() >= c.max {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.m, el.Value.(*lruEntry).key)
+	}
+	c.m[key] = c.ll.PushFront(e)
+	return nil
+}
+
+func (c *LRU) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.m[key]; ok {
+		c.ll.Remove(el)
+		delete(c.m, key)
+	}
+	return nil
+}
+
+// Len returns the number of entries in the cache, including expired ones
+// which haven't been evicted yet.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,175 @@
+package cache_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/cache"
+	"github.com/r2northstar/atlas/pkg/cache/cachetest"
+	"github.com/r2northstar/atlas/pkg/leader/leadertest"
+)
+
+func TestLRU(t *testing.T) {
+	cachetest.TestCache(t, cache.NewLRU(16))
+
+	c := cache.NewLRU(2)
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+	c.Get("a")
+	c.Set("c", []byte("3"), time.Minute)
+	if _, ok, _ := c.Get("b"); ok {
+		t.Errorf("least recently used entry should have been evicted")
+	}
+	if _, ok, _ := c.Get("a"); !ok {
+		t.Errorf("recently used entry should not have been evicted")
+	}
+	if n := c.Len(); n != 2 {
+		t.Errorf("expected 2 entries, got %d", n)
+	}
+}
+
+func TestRedis(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	var mu sync.Mutex
+	m := map[string]string{}
+	exp := map[string]time.Time{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					var n int
+					if _, err := fmt.Fscanf(br, "*%d\r\n", &n); err != nil {
+						return
+					}
+					args := make([]string, n)
+					for i := range args {
+						var l int
+						if _, err := fmt.Fscanf(br, "$%d\r\n", &l); err != nil {
+							return
+						}
+						buf := make([]byte, l+2)
+						if _, err := io.ReadFull(br, buf); err != nil {
+							return
+						}
+						args[i] = string(buf[:l])
+					}
+					mu.Lock()
+					switch args[0] {
+					case "AUTH":
+						if args[1] == "user" && args[2] == "pass" {
+							io.WriteString(conn, "+OK\r\n")
+						} else {
+							io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+						}
+					case "SELECT":
+						io.WriteString(conn, "+OK\r\n")
+					case "GET":
+						if v, ok := m[args[1]]; ok && time.Now().Before(exp[args[1]]) {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							io.WriteString(conn, "$-1\r\n")
+						}
+					case "SET":
+						ms, _ := strconv.Atoi(args[4])
+						m[args[1]] = args[2]
+						exp[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+						io.WriteString(conn, "+OK\r\n")
+					case "DEL":
+						delete(m, args[1])
+						io.WriteString(conn, ":1\r\n")
+					case "EVAL":
+						// emulate the lease scripts
+						k, holder := args[3], args[4]
+						v, ok := m[k]
+						ok = ok && time.Now().Before(exp[k])
+						if strings.Contains(args[1], "'SET'") {
+							if !ok || v == holder {
+								ms, _ := strconv.Atoi(args[5])
+								m[k], v = holder, holder
+								exp[k] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+							}
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+						} else if ok && v == holder {
+							delete(m, k)
+							io.WriteString(conn, ":1\r\n")
+						} else {
+							io.WriteString(conn, ":0\r\n")
+						}
+					default:
+						io.WriteString(conn, "-ERR unknown command\r\n")
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+
+	c, err := cache.NewRedis("redis://user:pass@" + ln.Addr().String() + "/1?prefix=test:")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+	cachetest.TestCache(t, c)
+	leadertest.TestBackend(t, c)
+
+	mu.Lock()
+	if _, ok := m["test:x"]; !ok {
+		t.Errorf("key prefix not used")
+	}
+	mu.Unlock()
+
+	c, err = cache.NewRedis("redis://user:wrong@" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := c.Get("x"); err == nil {
+		t.Errorf("expected auth error")
+	}
+}
+
+// TestRedisExternal runs the conformance tests against a real Redis server if
+// ATLAS_TEST_REDIS (a URL accepted by NewRedis) is set.
+func TestRedisExternal(t *testing.T) {
+	u := os.Getenv("ATLAS_TEST_REDIS")
+	if u == "" {
+		t.Skip("ATLAS_TEST_REDIS not set")
+	}
+	x, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("invalid url: %v", err)
+	}
+
+	// use a unique prefix so we don't conflict with existing keys
+	q := x.Query()
+	q.Set("prefix", q.Get("prefix")+"test"+strconv.FormatInt(time.Now().UnixNano(), 36)+":")
+	x.RawQuery = q.Encode()
+
+	c, err := cache.NewRedis(x.String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+
+	cachetest.TestCache(t, c)
+	leadertest.TestBackend(t, c)
+}
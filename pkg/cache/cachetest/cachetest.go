@@ -0,0 +1,104 @@
+// Package cachetest contains conformance tests for cache implementations.
+package cachetest
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/cache"
+)
+
+// TestCache tests whether an EMPTY cache instance implements the interface
+// correctly. The cache must be able to hold at least 16 entries.
+func TestCache(t *testing.T, c cache.Cache) {
+	t.Run("Basic", func(t *testing.T) {
+		if _, ok, err := c.Get("x"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if ok {
+			t.Fatalf("value should not exist")
+		}
+
+		buf := []byte("test\r\nvalue")
+		if err := c.Set("x", buf, time.Minute); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		buf[0] = 'T'
+		if v, ok, err := c.Get("x"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !ok || string(v) != "test\r\nvalue" {
+			t.Fatalf("incorrect value %q (must copy the value)", v)
+		}
+
+		if err := c.Set("y", []byte("1"), time.Millisecond*10); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		time.Sleep(time.Millisecond * 20)
+		if _, ok, err := c.Get("y"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if ok {
+			t.Fatalf("value should have expired")
+		}
+
+		if err := c.Set("z", []byte("1"), time.Minute); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := c.Delete("z"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok, err := c.Get("z"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if ok {
+			t.Fatalf("value should have been deleted")
+		}
+	})
+	t.Run("Stress", func(t *testing.T) {
+		// concurrent writes to the same key must not tear values
+		var wg sync.WaitGroup
+		var fail atomic.Int32
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := "stress" + strconv.Itoa(i)
+				for j := 0; j < 64; j++ {
+					v := strconv.Itoa(i) + ":" + strconv.Itoa(j)
+					if err := c.Set("stress", []byte(v+v), time.Minute); err != nil {
+						t.Logf("error: %v", err)
+						fail.Store(1)
+						return
+					}
+					if err := c.Set(key, []byte(v), time.Minute); err != nil {
+						t.Logf("error: %v", err)
+						fail.Store(1)
+						return
+					}
+					if b, ok, err := c.Get("stress"); err != nil {
+						t.Logf("error: %v", err)
+						fail.Store(1)
+						return
+					} else if ok && (len(b)%2 != 0 || string(b[:len(b)/2]) != string(b[len(b)/2:])) {
+						t.Logf("torn value %q for shared key", b)
+						fail.Store(1)
+						return
+					}
+					if b, ok, err := c.Get(key); err != nil {
+						t.Logf("error: %v", err)
+						fail.Store(1)
+						return
+					} else if ok && string(b) != v {
+						t.Logf("incorrect value %q for key %q", b, key)
+						fail.Store(1)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		if fail.Load() != 0 {
+			t.FailNow()
+		}
+	})
+}
@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redis is a cache backed by a Redis server. It implements the small subset of
+// the RESP2 protocol required for GET, SET, DEL, and EVAL. It can also be used
+// as a lease backend for leader election.
+type Redis struct {
+	addr     string
+	username string
+	password string
+	db       int
+	prefix   string
+	timeout  time.Duration
+
+	pool chan *redisConn
+}
+
+type redisConn struct {
+	c  net.Conn
+	br *bufio.Reader
+	bw *bufio.Writer
+}
+
+// ErrRedis is returned for error replies from the server.
+var ErrRedis = errors.New("redis error")
+
+// NewRedis creates a new Redis cache from a URL in the form
+// redis://[[username]:password@]host[:port][/db][?prefix=atlas:&timeout=1s].
+func NewRedis(u string) (*Redis, error) {
+	x, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if x.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported scheme %q", x.Scheme)
+	}
+	c := &Redis{
+		addr:    x.Host,
+		prefix:  "atlas:",
+		timeout: time.Second,
+		pool:    make(chan *redisConn, 16),
+	}
+	if x.Port() == "" {
+		c.addr = net.JoinHostPort(x.Hostname(), "6379")
+	}
+	if x.User != nil {
+		c.username = x.User.Username()
+		c.password, _ = x.User.Password()
+	}
+	if v := strings.Trim(x.Path, "/"); v != "" {
+		if c.db, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid db %q", v)
+		}
+	}
+	q := x.Query()
+	if q.Has("prefix") {
+		c.prefix = q.Get("prefix")
+	}
+	if v := q.Get("timeout"); v != "" {
+		if c.timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid timeout %q", v)
+		}
+	}
+	return c, nil
+}
+
+func (c *Redis) Get(key string) ([]byte, bool, error) {
+	v, err := c.do("GET", c.prefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+	if v == nil {
+		return nil, false, nil
+	}
+	buf, ok := v.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("%w: unexpected reply type %T", ErrRedis, v)
+	}
+	return buf, true, nil
+}
+
+func (c *Redis) Set(key string, buf []byte, ttl time.Duration) error {
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		return c.Delete(key)
+	}
+	_, err := c.do("SET", c.prefix+key, string(buf), "PX", strconv.FormatInt(ms, 10))
+	return err
+}
+
+func (c *Redis) Delete(key string) error {
+	_, err := c.do("DEL", c.prefix+key)
+	return err
+}
+
+// redisAcquireLease atomically acquires or renews a lease, returning the
+// current holder.
+const redisAcquireLease = `local v = redis.call('GET', KEYS[1])
+if v == false or v == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return ARGV[1]
+end
+return v`
+
+// redisReleaseLease atomically deletes a lease if it is held by the holder.
+const redisReleaseLease = `if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`
+
+// AcquireLease acquires or renews a lease for leader election. Lease keys are
+// prefixed with "lease:" in addition to the configured prefix.
+func (c *Redis) AcquireLease(name, holder string, ttl time.Duration) (string, error) {
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		return "", fmt.Errorf("invalid lease ttl %s", ttl)
+	}
+	v, err := c.do("EVAL", redisAcquireLease, "1", c.prefix+"lease:"+name, holder, strconv.FormatInt(ms, 10))
+	if err != nil {
+		return "", err
+	}
+	buf, ok := v.([]byte)
+	if !ok {
+		return "", fmt.Errorf("%w: unexpected reply type %T", ErrRedis, v)
+	}
+	return string(buf), nil
+}
+
+func (c *Redis) ReleaseLease(name, holder string) error {
+	_, err := c.do("EVAL", redisReleaseLease, "1", c.prefix+"lease:"+name, holder)
+	return err
+}
+
+// do runs a command, returning a []byte, int64, string, or nil.
+func (c *Redis) do(args ...string) (any, error) {
+	conn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	v, err := conn.do(c.timeout, args...)
+	if err != nil && !errors.Is(err, ErrRedis) {
+		conn.c.Close()
+		return nil, err
+	}
+	c.put(conn)
+	return v, err
+}
+
+func (c *Redis) get() (*redisConn, error) {
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	default:
+	}
+	nc, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{
+		c:  nc,
+		br: bufio.NewReader(nc),
+		bw: bufio.NewWriter(nc),
+	}
+	if c.password != "" {
+		var err error
+		if c.username != "" {
+			_, err = conn.do(c.timeout, "AUTH", c.username, c.password)
+		} else {
+			_, err = conn.do(c.timeout, "AUTH", c.password)
+		}
+		if err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+	}
+	if c.db != 0 {
+		if _, err := conn.do(c.timeout, "SELECT", strconv.Itoa(c.db)); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("select db: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (c *Redis) put(conn *redisConn) {
+	select {
+	case c.pool <- conn:
+	default:
+		conn.c.Close()
+	}
+}
+
+// Close closes idle connections.
+func (c *Redis) Close() error {
+	for {
+		select {
+		case conn := <-c.pool:
+			conn.c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (r *redisConn) do(timeout time.Duration, args ...string) (any, error) {
+	if err := r.c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(r.bw, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(r.bw, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := r.bw.Flush(); err != nil {
+		return nil, err
+	}
+	return r.read()
+}
+
+func (r *redisConn) read() (any, error) {
+	line, err := r.br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("invalid reply line %q", line)
+	}
+	typ, val := line[0], line[1:len(line)-2]
+	switch typ {
+	case '+':
+		return val, nil
+	case '-':
+		return nil, fmt.Errorf("%w: %s", ErrRedis, val)
+	case ':':
+		return strconv.ParseInt(val, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk string length %q", val)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r.br, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	default:
+		return nil, fmt.Errorf("unsupported reply type %q", typ)
+	}
+}
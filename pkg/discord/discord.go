@@ -0,0 +1,186 @@
+// Package discord is a client for the parts of the Discord API used for
+// linking Discord accounts via OAuth2 and checking guild roles.
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrDiscord         = errors.New("discord api error")
+	ErrInvalidResponse = errors.New("invalid discord api response")
+	ErrInvalidGrant    = errors.New("invalid or expired authorization code")
+	ErrUnknownMember   = errors.New("user is not a member of the guild")
+)
+
+// Base is the base URL for the Discord API.
+var Base = "https://discord.com/api/v10"
+
+// AuthorizeURL is the URL to send users to for OAuth2 authorization.
+var AuthorizeURL = "https://discord.com/oauth2/authorize"
+
+// OAuth2 contains the configuration for a Discord OAuth2 application.
+type OAuth2 struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// User contains information about a Discord user.
+type User struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+}
+
+// AuthCodeURL returns the URL to redirect a user to in order to authorize the
+// identify scope with the provided state.
+func (o *OAuth2) AuthCodeURL(state string) string {
+	return AuthorizeURL + "?" + (url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.ClientID},
+		"scope":         {"identify"},
+		"state":         {state},
+		"redirect_uri":  {o.RedirectURL},
+		"prompt":        {"none"},
+	}).Encode()
+}
+
+// Exchange exchanges an authorization code for an access token.
+func (o *OAuth2) Exchange(ctx context.Context, code string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, Base+"/oauth2/token", strings.NewReader((url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.RedirectURL},
+	}).Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(o.ClientID, o.ClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	return exchange(resp)
+}
+
+func exchange(resp *http.Response) (string, error) {
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return "", err
+	}
+
+	var obj struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		Error       string `json:"error"`
+	}
+	if err := json.Unmarshal(buf, &obj); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("%w: response status %d (%s)", ErrDiscord, resp.StatusCode, resp.Status)
+		}
+		return "", fmt.Errorf("%w: invalid json: %v", ErrInvalidResponse, err)
+	}
+	if obj.Error == "invalid_grant" {
+		return "", ErrInvalidGrant
+	}
+	if obj.Error != "" {
+		return "", fmt.Errorf("%w: token error %q", ErrDiscord, obj.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: response status %d (%s)", ErrDiscord, resp.StatusCode, resp.Status)
+	}
+	if obj.AccessToken == "" || !strings.EqualFold(obj.TokenType, "Bearer") {
+		return "", fmt.Errorf("%w: missing bearer token", ErrInvalidResponse)
+	}
+	return obj.AccessToken, nil
+}
+
+// GetCurrentUser gets the user associated with the provided access token.
+func GetCurrentUser(ctx context.Context, token string) (*User, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, Base+"/users/@me", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return getCurrentUser(resp)
+}
+
+func getCurrentUser(resp *http.Response) (*User, error) {
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: response status %d (%s)", ErrDiscord, resp.StatusCode, resp.Status)
+	}
+
+	var obj User
+	if err := json.Unmarshal(buf, &obj); err != nil {
+		return nil, fmt.Errorf("%w: invalid json: %v", ErrInvalidResponse, err)
+	}
+	if obj.ID == "" {
+		return nil, fmt.Errorf("%w: missing user id", ErrInvalidResponse)
+	}
+	return &obj, nil
+}
+
+// GetGuildMemberRoles gets the role IDs of a guild member using a bot token.
+// If the user isn't a member of the guild, ErrUnknownMember is returned.
+func GetGuildMemberRoles(ctx context.Context, botToken, guildID, userID string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, Base+"/guilds/"+url.PathEscape(guildID)+"/members/"+url.PathEscape(userID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bot "+botToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return getGuildMemberRoles(resp)
+}
+
+func getGuildMemberRoles(resp *http.Response) ([]string, error) {
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrUnknownMember
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: response status %d (%s)", ErrDiscord, resp.StatusCode, resp.Status)
+	}
+
+	var obj struct {
+		Roles []string `json:"roles"`
+	}
+	if err := json.Unmarshal(buf, &obj); err != nil {
+		return nil, fmt.Errorf("%w: invalid json: %v", ErrInvalidResponse, err)
+	}
+	if obj.Roles == nil {
+		return nil, fmt.Errorf("%w: missing roles", ErrInvalidResponse)
+	}
+	return obj.Roles, nil
+}
@@ -0,0 +1,127 @@
+package discord
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExchange(t *testing.T) {
+	testExchange(t, "Success", 200,
+		`{"access_token":"6qrZcUqja7812RVdnEKjpzOL4CvHBFG","token_type":"Bearer","expires_in":604800,"refresh_token":"D43f5y0ahjqew82jZ4NViEr2YafMKhue","scope":"identify"}`,
+		"6qrZcUqja7812RVdnEKjpzOL4CvHBFG", nil)
+	testExchange(t, "InvalidGrant", 400,
+		`{"error":"invalid_grant","error_description":"Invalid \"code\" in request."}`,
+		"", ErrInvalidGrant)
+	testExchange(t, "InvalidClient", 401,
+		`{"error":"invalid_client"}`,
+		"", ErrDiscord)
+	testExchange(t, "ServerError", 502,
+		`<html><body>Bad Gateway</body></html>`,
+		"", ErrDiscord)
+	testExchange(t, "InvalidJSON", 200,
+		`fake`,
+		"", ErrInvalidResponse)
+	testExchange(t, "MissingToken", 200,
+		`{"token_type":"Bearer"}`,
+		"", ErrInvalidResponse)
+}
+
+func testExchange(t *testing.T, name string, status int, resp string, res string, rerr error) {
+	t.Run(name, func(t *testing.T) {
+		x, err := exchange(&http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       io.NopCloser(strings.NewReader(resp)),
+		})
+		if rerr == nil {
+			if err != nil {
+				t.Errorf("unexpected error (resp %q): %v", resp, err)
+			}
+		} else if !errors.Is(err, rerr) {
+			t.Errorf("expected error %q, got %q", rerr, err)
+		}
+		if x != res {
+			t.Errorf("expected %q, got %q", res, x)
+		}
+	})
+}
+
+func TestGetCurrentUser(t *testing.T) {
+	testGetCurrentUser(t, "Success", 200,
+		`{"id":"80351110224678912","username":"nelly","global_name":"Nelly","avatar":null,"discriminator":"0"}`,
+		&User{ID: "80351110224678912", Username: "nelly", GlobalName: "Nelly"}, nil)
+	testGetCurrentUser(t, "Unauthorized", 401,
+		`{"message":"401: Unauthorized","code":0}`,
+		nil, ErrDiscord)
+	testGetCurrentUser(t, "InvalidJSON", 200,
+		`fake`,
+		nil, ErrInvalidResponse)
+	testGetCurrentUser(t, "MissingID", 200,
+		`{"username":"nelly"}`,
+		nil, ErrInvalidResponse)
+}
+
+func testGetCurrentUser(t *testing.T, name string, status int, resp string, res *User, rerr error) {
+	t.Run(name, func(t *testing.T) {
+		x, err := getCurrentUser(&http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       io.NopCloser(strings.NewReader(resp)),
+		})
+		if rerr == nil {
+			if err != nil {
+				t.Errorf("unexpected error (resp %q): %v", resp, err)
+			}
+		} else if !errors.Is(err, rerr) {
+			t.Errorf("expected error %q, got %q", rerr, err)
+		}
+		if !reflect.DeepEqual(x, res) {
+			t.Errorf("expected %#v, got %#v", res, x)
+		}
+	})
+}
+
+func TestGetGuildMemberRoles(t *testing.T) {
+	testGetGuildMemberRoles(t, "Success", 200,
+		`{"user":{"id":"80351110224678912"},"roles":["41771983423143936","41771983423143937"],"joined_at":"2015-04-26T06:26:56.936000+00:00"}`,
+		[]string{"41771983423143936", "41771983423143937"}, nil)
+	testGetGuildMemberRoles(t, "NoRoles", 200,
+		`{"user":{"id":"80351110224678912"},"roles":[]}`,
+		[]string{}, nil)
+	testGetGuildMemberRoles(t, "UnknownMember", 404,
+		`{"message":"Unknown Member","code":10007}`,
+		nil, ErrUnknownMember)
+	testGetGuildMemberRoles(t, "Unauthorized", 401,
+		`{"message":"401: Unauthorized","code":0}`,
+		nil, ErrDiscord)
+	testGetGuildMemberRoles(t, "InvalidJSON", 200,
+		`fake`,
+		nil, ErrInvalidResponse)
+	testGetGuildMemberRoles(t, "MissingRoles", 200,
+		`{"user":{"id":"80351110224678912"}}`,
+		nil, ErrInvalidResponse)
+}
+
+func testGetGuildMemberRoles(t *testing.T, name string, status int, resp string, res []string, rerr error) {
+	t.Run(name, func(t *testing.T) {
+		x, err := getGuildMemberRoles(&http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       io.NopCloser(strings.NewReader(resp)),
+		})
+		if rerr == nil {
+			if err != nil {
+				t.Errorf("unexpected error (resp %q): %v", resp, err)
+			}
+		} else if !errors.Is(err, rerr) {
+			t.Errorf("expected error %q, got %q", rerr, err)
+		}
+		if !reflect.DeepEqual(x, res) {
+			t.Errorf("expected %#v, got %#v", res, x)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package e2e
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/memstore"
+	"github.com/r2northstar/atlas/pkg/msgpack"
+)
+
+// BenchmarkHeartbeat measures the api0 handler for game server heartbeats
+// (excluding the HTTP server and middleware). It should sustain at least 50k
+// heartbeats/sec with -cpu 1.
+func BenchmarkHeartbeat(b *testing.B) {
+	h := &api0.Handler{
+		ServerList:     api0.NewServerList(time.Minute, time.Minute*2, 0, api0.ServerListConfig{}),
+		AccountStorage: memstore.NewAccountStore(),
+		PdataStorage:   memstore.NewPdataStore(false),
+		MaxServers:     -1,
+	}
+
+	const n = 1000
+	reqs := make([]*http.Request, n)
+	for i := range reqs {
+		addr := netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}), 37015)
+		s, err := h.ServerList.ServerHybridUpdatePut(nil, &api0.Server{
+			Addr:       addr,
+			AuthPort:   8081,
+			Name:       "server " + strconv.Itoa(i),
+			Map:        "mp_lf_pillars",
+			Playlist:   "private_match",
+			MaxPlayers: 16,
+		}, api0.ServerListLimit{})
+		if err != nil {
+			b.Fatalf("register: %v", err)
+		}
+		reqs[i] = httptest.NewRequest(http.MethodPost, "/server/heartbeat?"+url.Values{
+			"id":          {s.ID},
+			"port":        {"37015"},
+			"authPort":    {"8081"},
+			"name":        {"server " + strconv.Itoa(i)},
+			"description": {"a description"},
+			"map":         {"mp_lf_pillars"},
+			"playlist":    {"private_match"},
+			"playerCount": {"3"},
+			"maxPlayers":  {"16"},
+		}.Encode(), nil)
+		reqs[i].RemoteAddr = addr.Addr().String() + ":12345"
+		reqs[i].Header.Set("User-Agent", "R2Northstar/1.20.0+dev")
+	}
+
+	w := &discardResponseWriter{h: http.Header{}}
+	check := httptest.NewRecorder()
+	h.ServeHTTP(check, reqs[0])
+	if check.Code != http.StatusOK || !strings.Contains(check.Body.String(), `"success":true`) {
+		b.Fatalf("heartbeat failed: %d %s", check.Code, check.Body.String())
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		for k := range w.h {
+			delete(w.h, k)
+		}
+		h.ServeHTTP(w, reqs[i%n])
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "heartbeats/s")
+}
+
+type discardResponseWriter struct {
+	h http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.h }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(int)             {}
+
+// BenchmarkServerList measures the api0 handler for /client/servers with 1000
+// servers for each response type.
+func BenchmarkServerList(b *testing.B) {
+	as := memstore.NewAccountStore()
+	if err := as.SaveAccount(&api0.Account{
+		UID:             1000000001,
+		AuthToken:       "token",
+		AuthTokenExpiry: time.Now().Add(time.Hour),
+	}); err != nil {
+		b.Fatalf("save account: %v", err)
+	}
+	h := &api0.Handler{
+		ServerList:     api0.NewServerList(time.Minute, time.Minute*2, 0, api0.ServerListConfig{}),
+		AccountStorage: as,
+		PdataStorage:   memstore.NewPdataStore(false),
+		MaxServers:     -1,
+	}
+	for i := 0; i < 1000; i++ {
+		if _, err := h.ServerList.ServerHybridUpdatePut(nil, &api0.Server{
+			Addr:        netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}), 37015),
+			AuthPort:    8081,
+			Name:        "server " + strconv.Itoa(i),
+			Description: "a description with \"quotes\" and unicode —",
+			Map:         "mp_lf_pillars",
+			Playlist:    "private_match",
+			PlayerCount: i % 16,
+			MaxPlayers:  16,
+			ModInfo: []api0.ServerModInfo{
+				{Name: "Northstar.Client", Version: "1.20.0", RequiredOnClient: true},
+				{Name: "Northstar.Custom", Version: "1.20.0", RequiredOnClient: true},
+			},
+		}, api0.ServerListLimit{}); err != nil {
+			b.Fatalf("register: %v", err)
+		}
+	}
+
+	for _, c := range []struct {
+		name   string
+		query  string
+		accept string
+		gzip   bool
+	}{
+		{name: "JSON"},
+		{name: "JSONGzip", gzip: true},
+		{name: "Msgpack", accept: msgpack.ContentType},
+		{name: "FilteredJSON", query: "?id=1000000001&token=token"},
+		{name: "FilteredMsgpack", query: "?id=1000000001&token=token", accept: msgpack.ContentType},
+	} {
+		c := c
+		b.Run(c.name, func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, "/client/servers"+c.query, nil)
+			r.RemoteAddr = "192.0.2.1:12345"
+			if c.accept != "" {
+				r.Header.Set("Accept", c.accept)
+			}
+			if c.gzip {
+				r.Header.Set("Accept-Encoding", "gzip")
+			}
+
+			check := httptest.NewRecorder()
+			h.ServeHTTP(check, r)
+			if check.Code != http.StatusOK || check.Body.Len() < 1000 {
+				b.Fatalf("list failed: %d %.1024s", check.Code, check.Body.String())
+			}
+
+			w := &discardResponseWriter{h: http.Header{}}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for k := range w.h {
+					delete(w.h, k)
+				}
+				h.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package e2e_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files for TestCompat")
+
+// compatVolatile contains response fields which change between runs, and are
+// replaced with a placeholder in golden files.
+var compatVolatile = map[string]bool{
+	"id":              true,
+	"ip":              true,
+	"port":            true,
+	"authPort":        true,
+	"token":           true,
+	"authToken":       true,
+	"serverAuthToken": true,
+	"lastHeartbeat":   true,
+	"request_id":      true,
+	"persistentData":  true,
+}
+
+// TestCompat checks that the responses to requests made like the stock
+// NorthstarLauncher and game server (i.e., to the unversioned paths of the
+// original master server) match the golden files in testdata/compat. Run it
+// with -update to regenerate them after an intentional change.
+func TestCompat(t *testing.T) {
+	fakeStryder(t)
+	a := startAtlas(t, t.TempDir())
+	ctx := context.Background()
+
+	const uid = 1005
+	srv := a.startServer(t, "compat server", nil)
+
+	var token string
+	for _, tc := range []struct {
+		Name   string
+		Method string
+		Path   func() string
+		Body   func() (string, io.Reader)
+		Check  func(res map[string]any)
+	}{
+		{
+			Name:   "mainmenupromos",
+			Method: http.MethodGet,
+			Path:   func() string { return "/client/mainmenupromos" },
+		},
+		{
+			Name:   "origin_auth_missing_token",
+			Method: http.MethodGet,
+			Path:   func() string { return "/client/origin_auth?id=" + strconv.Itoa(uid) },
+		},
+		{
+			Name:   "origin_auth",
+			Method: http.MethodGet,
+			Path:   func() string { return "/client/origin_auth?id=" + strconv.Itoa(uid) + "&token=valid-" + strconv.Itoa(uid) },
+			Check: func(res map[string]any) {
+				token, _ = res["token"].(string)
+			},
+		},
+		{
+			Name:   "servers",
+			Method: http.MethodGet,
+			Path:   func() string { return "/client/servers" },
+		},
+		{
+			Name:   "auth_with_server_invalid_token",
+			Method: http.MethodPost,
+			Path: func() string {
+				return "/client/auth_with_server?id=" + strconv.Itoa(uid) + "&playerToken=invalid&server=" + srv.ID() + "&password="
+			},
+		},
+		{
+			Name:   "auth_with_server",
+			Method: http.MethodPost,
+			Path: func() string {
+				return "/client/auth_with_server?id=" + strconv.Itoa(uid) + "&playerToken=" + token + "&server=" + srv.ID() + "&password="
+			},
+		},
+		{
+			Name:   "auth_with_self",
+			Method: http.MethodPost,
+			Path:   func() string { return "/client/auth_with_self?id=" + strconv.Itoa(uid) + "&playerToken=" + token },
+		},
+		{
+			Name:   "write_persistence",
+			Method: http.MethodPost,
+			Path:   func() string { return "/accounts/write_persistence?id=" + strconv.Itoa(uid) + "&serverId=" + srv.ID() },
+			Body: func() (string, io.Reader) {
+				ps := srv.Players()
+				if len(ps) == 0 {
+					t.Fatalf("write_persistence: no players authenticated")
+				}
+				var b bytes.Buffer
+				mw := multipart.NewWriter(&b)
+				fw, _ := mw.CreateFormFile("pdata", "file.pdata")
+				fw.Write(ps[len(ps)-1].Pdata)
+				mw.Close()
+				return mw.FormDataContentType(), &b
+			},
+		},
+		{
+			Name:   "get_username",
+			Method: http.MethodGet,
+			Path:   func() string { return "/accounts/get_username?uid=" + strconv.Itoa(uid) },
+		},
+		{
+			Name:   "lookup_uid",
+			Method: http.MethodGet,
+			Path:   func() string { return "/accounts/lookup_uid?username=nonexistent" },
+		},
+		{
+			Name:   "update_values",
+			Method: http.MethodPost,
+			Path: func() string {
+				return "/server/update_values?id=" + srv.ID() + "&name=compat+server&description=&map=mp_glitch&playlist=ps&playerCount=1&maxPlayers=16&password="
+			},
+		},
+		{
+			Name:   "heartbeat",
+			Method: http.MethodPost,
+			Path:   func() string { return "/server/heartbeat?id=" + srv.ID() + "&playerCount=1" },
+		},
+		{
+			Name:   "remove_server",
+			Method: http.MethodDelete,
+			Path:   func() string { return "/server/remove_server?id=" + srv.ID() },
+		},
+		{
+			Name:   "remove_server_unknown",
+			Method: http.MethodDelete,
+			Path:   func() string { return "/server/remove_server?id=" + srv.ID() },
+		},
+	} {
+		var (
+			ct   string
+			body io.Reader
+		)
+		if tc.Body != nil {
+			ct, body = tc.Body()
+		}
+		req, err := http.NewRequestWithContext(ctx, tc.Method, a.URL+tc.Path(), body)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.Name, err)
+		}
+		req.Header.Set("User-Agent", userAgent)
+		if ct != "" {
+			req.Header.Set("Content-Type", ct)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.Name, err)
+		}
+		buf, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: read response: %v", tc.Name, err)
+		}
+
+		var res any
+		if err := json.Unmarshal(buf, &res); err != nil {
+			t.Errorf("%s: response is not json: %q", tc.Name, buf)
+			continue
+		}
+		if m, ok := res.(map[string]any); ok && tc.Check != nil {
+			tc.Check(m)
+		}
+
+		var b bytes.Buffer
+		e := json.NewEncoder(&b)
+		e.SetEscapeHTML(false)
+		e.SetIndent("", "  ")
+		if err := e.Encode(map[string]any{
+			"status":       resp.StatusCode,
+			"content_type": resp.Header.Get("Content-Type"),
+			"body":         compatNormalize(res),
+		}); err != nil {
+			t.Fatalf("%s: encode golden: %v", tc.Name, err)
+		}
+		got := b.Bytes()
+
+		fn := filepath.Join("testdata", "compat", tc.Name+".json")
+		if *updateGolden {
+			if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+				t.Fatalf("%s: %v", tc.Name, err)
+			}
+			if err := os.WriteFile(fn, got, 0644); err != nil {
+				t.Fatalf("%s: %v", tc.Name, err)
+			}
+			continue
+		}
+		exp, err := os.ReadFile(fn)
+		if err != nil {
+			t.Errorf("%s: read golden file (run with -update to create it): %v", tc.Name, err)
+			continue
+		}
+		if !bytes.Equal(got, exp) {
+			t.Errorf("%s: response does not match %s:\n--- expected\n%s\n--- got\n%s", tc.Name, fn, exp, got)
+		}
+	}
+}
+
+// compatNormalize replaces volatile values in a JSON response with
+// placeholders which still indicate the type.
+func compatNormalize(x any) any {
+	switch x := x.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(x))
+		for k, v := range x {
+			if compatVolatile[k] && v != nil {
+				switch v.(type) {
+				case string:
+					v = "<string>"
+				case float64:
+					v = "<number>"
+				case []any:
+					v = "<array>"
+				}
+			}
+			m[k] = compatNormalize(v)
+		}
+		return m
+	case []any:
+		a := make([]any, len(x))
+		for i, v := range x {
+			a[i] = compatNormalize(v)
+		}
+		return a
+	}
+	return x
+}
@@ -0,0 +1,8 @@
+// Package e2e contains end-to-end tests which run Atlas with the SQLite
+// storage backends against a fake Stryder API and fake game servers.
+//
+// TestCompat checks the responses to stock NorthstarLauncher requests against
+// the golden files in testdata/compat to ensure compatibility with the original
+// master server API is maintained. Run go test -run TestCompat -update to
+// regenerate them after an intentional change.
+package e2e
@@ -0,0 +1,2270 @@
+package e2e_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/atlas"
+	"github.com/r2northstar/atlas/pkg/edgerelay"
+	"github.com/r2northstar/atlas/pkg/fakeserver"
+	"github.com/r2northstar/atlas/pkg/leader"
+	"github.com/r2northstar/atlas/pkg/pdata"
+	"github.com/r2northstar/atlas/pkg/stryder"
+	"github.com/r2northstar/atlas/pkg/websocket"
+)
+
+const (
+	adminSecret = "e2e-admin-secret"
+	userAgent   = "R2Northstar/0.0.0+dev"
+)
+
+// fakeStryder accepts nucleus tokens of the form valid-<uid>.
+func fakeStryder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, _ := strconv.ParseUint(r.URL.Query().Get("userId"), 16, 64)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/nucleus-oauth.php" || r.URL.Query().Get("code") != "valid-"+strconv.FormatUint(uid, 10) {
+			w.Write([]byte(`{"success":false,"status":"400","error":"{\"error\":\"invalid_grant\",\"error_description\":\"code is invalid\",\"code\":100100}"}`))
+			return
+		}
+		w.Write([]byte(`{"token":"x","hasOnlineAccess":"1","expiry":"14399","storeUri":"https://www.origin.com/store/titanfall/titanfall-2/standard-edition"}`))
+	}))
+	old := stryder.Base
+	stryder.Base = srv.URL
+	t.Cleanup(func() {
+		stryder.Base = old
+		srv.Close()
+	})
+}
+
+// atlasInstance is a running Atlas server.
+type atlasInstance struct {
+	*atlas.Server
+	URL  string
+	Stop func()
+}
+
+// startAtlas starts Atlas using the SQLite databases in dir.
+func startAtlas(t *testing.T, dir string) *atlasInstance {
+	if err := os.WriteFile(filepath.Join(dir, "badwords.txt"), []byte("heck\n"), 0644); err != nil {
+		t.Fatalf("write bad words: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secrets.env"), []byte("ADMIN_SECRET="+adminSecret+"\n"), 0600); err != nil {
+		t.Fatalf("write secrets: %v", err)
+	}
+
+	var c atlas.Config
+	if err := c.UnmarshalEnv([]string{
+		"ATLAS_ADDR=127.0.0.1:0",
+		"ATLAS_ADDR_UDP=127.0.0.1:0",
+		"ATLAS_LOG_STDOUT=false",
+		"ATLAS_API0_ADMIN_SECRET=secret:envfile:" + filepath.Join(dir, "secrets.env") + "#ADMIN_SECRET",
+		"ATLAS_API0_STORAGE_ACCOUNTS=sqlite3:" + filepath.Join(dir, "atlas.db"),
+		"ATLAS_API0_STORAGE_PDATA=sqlite3:" + filepath.Join(dir, "pdata.db"),
+		"ATLAS_TENANTS=isolated=/isolated",
+		"ATLAS_TENANT_STORAGE_ACCOUNTS=sqlite3:" + filepath.Join(dir, "{tenant}-atlas.db"),
+		"ATLAS_TENANT_STORAGE_PDATA=sqlite3:" + filepath.Join(dir, "{tenant}-pdata.db"),
+		"ATLAS_API0_SERVERLIST_VERIFY_TIME=5s",
+		"ATLAS_API0_REGION_MAP=none",
+		"ATLAS_API0_MATCHMAKING_MATCH_SIZE=2",
+		"ATLAS_API0_RATINGS=true",
+		"ATLAS_API0_RATINGS_PUBLIC=true",
+		"ATLAS_API0_MATCH_HISTORY=true",
+		"ATLAS_API0_PARTY_MAX_SIZE=4",
+		"ATLAS_API0_FRIENDS=true",
+		"ATLAS_API0_BLOCKS=true",
+		"ATLAS_API0_CHAT_RELAY=true",
+		"ATLAS_API0_SERVER_WEBHOOKS=true",
+		"ATLAS_OUTBOX=storage",
+		"ATLAS_TRACING=otlp:http://127.0.0.1:1/v1/traces",
+		"ATLAS_ACCESS_LOG=" + filepath.Join(dir, "access.log"),
+		"ATLAS_ACCESS_LOG_IPV4_PREFIX=24",
+		"ATLAS_ACCESS_LOG_SAMPLE=/client/servers=0",
+		"ATLAS_API0_SERVERLIST_CANARY=population",
+		"ATLAS_API0_V1_SUNSET=2030-01-01",
+		"ATLAS_API0_ANOMALY_IP_THRESHOLD=50",
+		"ATLAS_API0_HONEYPOT=true",
+		"ATLAS_API0_HONEYPOT_RATE_LIMIT=1000",
+		"ATLAS_API0_CAPTCHA=turnstile",
+		"ATLAS_API0_CAPTCHA_SITEKEY=e2e-sitekey",
+		"ATLAS_API0_CAPTCHA_SECRET=e2e-captcha-secret",
+		"ATLAS_API0_MUTE_REPORTS=true",
+		"ATLAS_API0_MUTE_REPORTS_THRESHOLD=1",
+		"ATLAS_API0_CRASHES=true",
+		"ATLAS_API0_GUEST_MODE=true",
+		"ATLAS_API0_CORS=/client/population=https://browser.example.com,isolated/client/population=https://*.example.org",
+		"ATLAS_API0_SERVERLIST_SNAPSHOT=" + filepath.Join(dir, "servers.snapshot"),
+		"ATLAS_API0_SERVERLIST_SNAPSHOT_RESTORE=true",
+		"ATLAS_LEADER_ELECTION=storage",
+		"ATLAS_LEADER_ELECTION_TTL=300ms",
+		"ATLAS_API0_BADWORDS=" + filepath.Join(dir, "badwords.txt"),
+		"ATLAS_USERNAMESOURCE=none",
+		"EAX_UPDATE_VERSION=2.0.0",
+	}, false); err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+
+	s, err := atlas.NewServer(&c)
+	if err != nil {
+		t.Fatalf("initialize server: %v", err)
+	}
+
+	ln := make(chan error, 1)
+	go func() {
+		ln <- s.API0.NSPkt.ListenAndServe(c.AddrUDP)
+	}()
+	select {
+	case err := <-ln:
+		t.Fatalf("listen udp: %v", err)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	srv := httptest.NewServer(s.Handler)
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			srv.Close()
+			s.API0.NSPkt.Close()
+			if c, ok := s.API0.AccountStorage.(io.Closer); ok {
+				c.Close()
+			}
+			if c, ok := s.API0.PdataStorage.(io.Closer); ok {
+				c.Close()
+			}
+		})
+	}
+	t.Cleanup(stop)
+	return &atlasInstance{s, srv.URL, stop}
+}
+
+// do makes a request to Atlas, decoding the JSON response into res (if
+// provided) and returning the status code.
+func (a *atlasInstance) do(t *testing.T, method, path string, body any, admin bool, res any) int {
+	t.Helper()
+
+	var rd io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("%s %s: encode body: %v", method, path, err)
+		}
+		rd = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, a.URL+path, rd)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if admin {
+		req.Header.Set("Authorization", "Bearer "+adminSecret)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: read response: %v", method, path, err)
+	}
+	if res != nil {
+		if err := json.Unmarshal(buf, res); err != nil {
+			t.Fatalf("%s %s: decode response %q: %v", method, path, buf, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// originAuth authenticates uid with the nucleus token, returning the
+// masterserver token.
+func (a *atlasInstance) originAuth(t *testing.T, uid uint64, token string) (string, bool) {
+	t.Helper()
+
+	var res struct {
+		Success bool   `json:"success"`
+		Token   string `json:"token"`
+	}
+	a.do(t, http.MethodGet, "/client/origin_auth?id="+strconv.FormatUint(uid, 10)+"&token="+token, nil, false, &res)
+	return res.Token, res.Success
+}
+
+// authWithServer authenticates uid with a game server.
+func (a *atlasInstance) authWithServer(t *testing.T, uid uint64, token string, s *fakeserver.Server) bool {
+	t.Helper()
+
+	var res struct {
+		Success bool `json:"success"`
+	}
+	a.do(t, http.MethodPost, "/client/auth_with_server?id="+strconv.FormatUint(uid, 10)+"&server="+s.ID()+"&playerToken="+token, nil, false, &res)
+	return res.Success
+}
+
+// startServer starts and registers a fake game server.
+func (a *atlasInstance) startServer(t *testing.T, name string, reject func(fakeserver.Player) string) *fakeserver.Server {
+	t.Helper()
+	return a.startServerInfo(t, fakeserver.Info{Name: name}, reject)
+}
+
+func (a *atlasInstance) startServerInfo(t *testing.T, info fakeserver.Info, reject func(fakeserver.Player) string) *fakeserver.Server {
+	t.Helper()
+
+	info.Map = "mp_forwardbase_kodai"
+	info.Playlist = "aitdm"
+	info.MaxPlayers = 16
+
+	s := &fakeserver.Server{
+		MasterServer: a.URL,
+		Info:         info,
+		Reject:       reject,
+	}
+	if err := s.Listen(netip.MustParseAddr("127.0.0.1")); err != nil {
+		t.Fatalf("listen game server: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	if err := s.Register(context.Background()); err != nil {
+		t.Fatalf("register game server %q: %v", info.Name, err)
+	}
+	return s
+}
+
+// e2eHook hides servers named "hidden server" and rejects auth for
+// e2eHookRejectedUID.
+type e2eHook struct{}
+
+const e2eHookRejectedUID = 1000000009
+
+func init() {
+	api0.RegisterHook(e2eHook{})
+}
+
+func (e2eHook) HookName() string { return "e2e" }
+
+func (e2eHook) OnAuth(r *http.Request, acct *api0.Account) error {
+	if acct.UID == e2eHookRejectedUID {
+		return errors.New("not whitelisted")
+	}
+	return nil
+}
+
+func (e2eHook) OnListQuery(r *http.Request, srv *api0.Server) bool {
+	return srv.Name != "hidden server"
+}
+
+func TestE2E(t *testing.T) {
+	fakeStryder(t)
+
+	dir := t.TempDir()
+	a := startAtlas(t, dir)
+	ctx := context.Background()
+
+	// the servers and players are shared by the subtests, which run in order
+	// and build on the state left by the previous ones
+
+	const (
+		player1 = 1000000001
+		player2 = 1000000002
+	)
+	var (
+		bannedMu sync.Mutex
+		banned   = map[uint64]bool{}
+	)
+	communitySrv := a.startServer(t, "community server", nil)
+	subscriberSrv := a.startServer(t, "subscriber server", func(p fakeserver.Player) string {
+		bannedMu.Lock()
+		defer bannedMu.Unlock()
+		if banned[p.UID] {
+			return "you are banned"
+		}
+		return ""
+	})
+	hiddenSrv := a.startServer(t, "hidden server", nil)
+	scrimSrv := a.startServerInfo(t, fakeserver.Info{
+		Name:       "scrim server",
+		Visibility: "allowlist",
+		Allowlist:  []uint64{player1},
+	}, nil)
+
+	var servers []struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Hints *struct {
+			Freshness int  `json:"freshness"`
+			Verified  bool `json:"verified"`
+			Score     int  `json:"score"`
+		} `json:"hints"`
+	}
+
+	t.Run("Servers", func(t *testing.T) {
+		if status := a.do(t, http.MethodGet, "/client/servers", nil, false, &servers); status != http.StatusOK {
+			t.Fatalf("list servers: status %d", status)
+		}
+		listed := map[string]string{}
+		for _, s := range servers {
+			listed[s.ID] = s.Name
+			if s.Hints == nil {
+				t.Errorf("server %s: missing sorting hints", s.ID)
+			} else if s.Hints.Freshness <= 0 || s.Hints.Score < s.Hints.Freshness {
+				t.Errorf("server %s: incorrect sorting hints %+v", s.ID, *s.Hints)
+			}
+		}
+		if listed[communitySrv.ID()] != "community server" || listed[subscriberSrv.ID()] != "subscriber server" {
+			t.Errorf("registered servers not listed: %v", listed)
+		}
+		if _, ok := listed[hiddenSrv.ID()]; ok {
+			t.Errorf("server hidden by hook was listed")
+		}
+		if _, ok := listed[scrimSrv.ID()]; ok {
+			t.Errorf("allowlisted server was listed for an anonymous request")
+		}
+
+		servers = nil
+		if status := a.do(t, http.MethodGet, "/isolated/client/servers", nil, false, &servers); status != http.StatusOK {
+			t.Fatalf("list tenant servers: status %d", status)
+		}
+		if len(servers) != 0 {
+			t.Errorf("servers leaked into tenant server list: %v", servers)
+		}
+	})
+
+	t.Run("ServerSearch", func(t *testing.T) {
+		for q, exp := range map[string]string{
+			"community":   communitySrv.ID(),
+			"comunity":    communitySrv.ID(), // typo
+			"subscr":      subscriberSrv.ID(),
+			"hidden":      "",
+			"scrim":       "",
+			"zzzzzzzzzzz": "",
+		} {
+			servers = nil
+			if status := a.do(t, http.MethodGet, "/client/servers/search?q="+url.QueryEscape(q), nil, false, &servers); status != http.StatusOK {
+				t.Errorf("search %q: status %d", q, status)
+			} else if exp == "" && len(servers) != 0 {
+				t.Errorf("search %q: expected no results, got %v", q, servers)
+			} else if exp != "" && (len(servers) == 0 || servers[0].ID != exp) {
+				t.Errorf("search %q: expected %s first, got %v", q, exp, servers)
+			}
+		}
+		if status := a.do(t, http.MethodGet, "/client/servers/search", nil, false, nil); status != http.StatusBadRequest {
+			t.Errorf("search without query: expected status 400, got %d", status)
+		}
+	})
+
+	t.Run("Localization", func(t *testing.T) {
+		var failed struct {
+			Error struct {
+				Message string `json:"msg"`
+			} `json:"error"`
+		}
+		for _, c := range []struct {
+			lang, exp string
+		}{
+			{"", "Bad request: q param is required"},
+			{"fr", "Requête invalide: q param is required"},
+			{"pt-BR", "Solicitação inválida: q param is required"},
+			{"xx", "Bad request: q param is required"},
+		} {
+			a.do(t, http.MethodGet, "/client/servers/search?lang="+c.lang, nil, false, &failed)
+			if failed.Error.Message != c.exp {
+				t.Errorf("lang %q: expected message %q, got %q", c.lang, c.exp, failed.Error.Message)
+			}
+		}
+		if status := a.do(t, http.MethodPost, "/admin/translations", map[string]map[string]string{
+			"fr": {"BAD_REQUEST": "Mauvaise requête"},
+		}, true, nil); status != http.StatusOK {
+			t.Errorf("override translation: status %d", status)
+		}
+		if status := a.do(t, http.MethodPost, "/admin/translations", map[string]map[string]string{
+			"FR": {"BAD_REQUEST": "x"},
+		}, true, nil); status != http.StatusBadRequest {
+			t.Errorf("override translation with invalid language: expected status 400, got %d", status)
+		}
+		a.do(t, http.MethodGet, "/client/servers/search?lang=fr", nil, false, &failed)
+		if failed.Error.Message != "Mauvaise requête: q param is required" {
+			t.Errorf("translation override not used, got %q", failed.Error.Message)
+		}
+		if status := a.do(t, http.MethodDelete, "/admin/translations?lang=fr", nil, true, nil); status != http.StatusOK {
+			t.Errorf("delete translation override: status %d", status)
+		}
+	})
+
+	t.Run("GuestMode", func(t *testing.T) {
+		var guest struct {
+			ID    string `json:"id"`
+			Token string `json:"token"`
+			Guest bool   `json:"guest"`
+		}
+		if status := a.do(t, http.MethodGet, "/client/guest_auth?name=lan-player", nil, false, &guest); status != http.StatusOK {
+			t.Errorf("guest auth: status %d", status)
+		} else if guestUID, err := strconv.ParseUint(guest.ID, 10, 64); err != nil || !api0.IsGuestUID(guestUID) || guest.Token == "" || !guest.Guest {
+			t.Errorf("guest auth: incorrect response %+v", guest)
+		} else {
+			resp, err := http.Get(a.URL + "/client/servers?id=" + guest.ID + "&token=" + guest.Token)
+			if err != nil {
+				t.Fatalf("list servers as guest: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Atlas-Guest") != "true" {
+				t.Errorf("list servers as guest: expected guest session to be marked (status %d)", resp.StatusCode)
+			}
+			if status := a.do(t, http.MethodGet, "/client/origin_auth?id="+guest.ID+"&token=x", nil, false, nil); status != http.StatusNotFound {
+				t.Errorf("origin auth with guest uid: expected status 404, got %d", status)
+			}
+		}
+		if status := a.do(t, http.MethodGet, "/client/guest_auth?name=no+spaces", nil, false, nil); status != http.StatusBadRequest {
+			t.Errorf("guest auth with invalid name: expected status 400, got %d", status)
+		}
+	})
+
+	t.Run("CORS", func(t *testing.T) {
+		cors := func(method, path, origin string) *http.Response {
+			req, err := http.NewRequest(method, a.URL+path, nil)
+			if err != nil {
+				t.Fatalf("cors: %v", err)
+			}
+			req.Header.Set("Origin", origin)
+			if method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("cors: %s %s: %v", method, path, err)
+			}
+			resp.Body.Close()
+			return resp
+		}
+		for _, c := range []struct {
+			method, path, origin, allow string
+		}{
+			{http.MethodGet, "/client/population", "https://browser.example.com", "https://browser.example.com"},
+			{http.MethodGet, "/client/population", "https://other.example.com", ""},
+			{http.MethodOptions, "/client/population", "https://browser.example.com", "https://browser.example.com"},
+			{http.MethodGet, "/isolated/client/population", "https://portal.example.org", "https://portal.example.org"},
+			{http.MethodGet, "/isolated/client/population", "https://browser.example.com", ""},
+			{http.MethodGet, "/api/status", "https://other.example.com", "*"},
+			{http.MethodOptions, "/admin/translations", "https://browser.example.com", ""},
+		} {
+			resp := cors(c.method, c.path, c.origin)
+			if act := resp.Header.Get("Access-Control-Allow-Origin"); act != c.allow {
+				t.Errorf("cors: %s %s from %s: expected allowed origin %q, got %q", c.method, c.path, c.origin, c.allow, act)
+			}
+			if c.method == http.MethodOptions && c.allow != "" && (resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Max-Age") != "86400") {
+				t.Errorf("cors: %s %s from %s: incorrect preflight response (status %d)", c.method, c.path, c.origin, resp.StatusCode)
+			}
+		}
+	})
+
+	t.Run("StatusPage", func(t *testing.T) {
+		if resp, err := http.Get(a.URL + "/"); err != nil {
+			t.Fatalf("get status page: %v", err)
+		} else {
+			buf, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+				t.Errorf("get status page: status %d (%s)", resp.StatusCode, resp.Header.Get("Content-Type"))
+			}
+			if !bytes.Contains(buf, []byte("<tr><th>Servers</th><td>4</td></tr>")) || !bytes.Contains(buf, []byte(`<td class="operational">operational</td>`)) || bytes.Contains(buf, []byte("<script")) {
+				t.Errorf("incorrect status page %q", buf)
+			}
+		}
+	})
+
+	t.Run("StatusAPI", func(t *testing.T) {
+		type statusDoc struct {
+			Status struct {
+				Indicator string `json:"indicator"`
+			} `json:"status"`
+			Components []struct {
+				ID     string `json:"id"`
+				Status string `json:"status"`
+			} `json:"components"`
+			Incidents []struct {
+				ID         string     `json:"id"`
+				Status     string     `json:"status"`
+				CreatedAt  time.Time  `json:"created_at"`
+				ResolvedAt *time.Time `json:"resolved_at"`
+			} `json:"incidents"`
+			Metrics struct {
+				Servers int `json:"servers"`
+			} `json:"metrics"`
+		}
+		componentStatus := func(d statusDoc, id string) string {
+			for _, c := range d.Components {
+				if c.ID == id {
+					return c.Status
+				}
+			}
+			return ""
+		}
+
+		var sd statusDoc
+		if status := a.do(t, http.MethodGet, "/api/status", nil, false, &sd); status != http.StatusOK {
+			t.Fatalf("get status: status %d", status)
+		}
+		if sd.Status.Indicator != "none" || componentStatus(sd, "auth") != "operational" || len(sd.Incidents) != 0 || sd.Metrics.Servers != 4 {
+			t.Errorf("incorrect status %+v", sd)
+		}
+		if status := a.do(t, http.MethodPost, "/admin/incidents", map[string]any{
+			"id":         "login",
+			"name":       "Login issues",
+			"impact":     "major",
+			"components": []string{"auth"},
+		}, true, nil); status != http.StatusOK {
+			t.Fatalf("create incident: status %d", status)
+		}
+		if status := a.do(t, http.MethodPost, "/admin/incidents", map[string]any{
+			"id":         "bad",
+			"name":       "Bad",
+			"components": []string{"nope"},
+		}, true, nil); status != http.StatusBadRequest {
+			t.Errorf("create incident with unknown component: status %d", status)
+		}
+		sd = statusDoc{}
+		a.do(t, http.MethodGet, "/api/status", nil, false, &sd)
+		if sd.Status.Indicator != "major" || componentStatus(sd, "auth") != "partial_outage" || componentStatus(sd, "api") != "operational" || len(sd.Incidents) != 1 || sd.Incidents[0].Status != "investigating" {
+			t.Errorf("incorrect status with incident %+v", sd)
+		}
+		created := sd.Incidents[0].CreatedAt
+		if status := a.do(t, http.MethodPost, "/admin/incidents", map[string]any{
+			"id":         "login",
+			"name":       "Login issues",
+			"status":     "resolved",
+			"impact":     "major",
+			"components": []string{"auth"},
+		}, true, nil); status != http.StatusOK {
+			t.Fatalf("resolve incident: status %d", status)
+		}
+		sd = statusDoc{}
+		a.do(t, http.MethodGet, "/api/status", nil, false, &sd)
+		if sd.Status.Indicator != "none" || componentStatus(sd, "auth") != "operational" || len(sd.Incidents) != 1 || sd.Incidents[0].ResolvedAt == nil || !sd.Incidents[0].CreatedAt.Equal(created) {
+			t.Errorf("incorrect status with resolved incident %+v", sd)
+		}
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		if status := a.do(t, http.MethodPost, "/admin/events", map[string]any{
+			"id":           "game-night",
+			"start":        time.Now().Add(-time.Hour),
+			"end":          time.Now().Add(time.Hour),
+			"title":        "Game Night",
+			"text":         "Join the featured server!",
+			"server_names": []string{"subscriber server"},
+		}, true, nil); status != http.StatusOK {
+			t.Fatalf("create event: status %d", status)
+		}
+		var featured []struct {
+			ID       string `json:"id"`
+			Featured bool   `json:"featured"`
+		}
+		if status := a.do(t, http.MethodGet, "/client/servers", nil, false, &featured); status != http.StatusOK {
+			t.Fatalf("list servers: status %d", status)
+		}
+		if len(featured) == 0 || featured[0].ID != subscriberSrv.ID() || !featured[0].Featured {
+			t.Errorf("featured server not pinned to the top: %+v", featured)
+		}
+		var motd struct {
+			MOTD []struct {
+				ID    string `json:"id"`
+				Title string `json:"title"`
+			} `json:"motd"`
+		}
+		if status := a.do(t, http.MethodGet, "/client/motd", nil, false, &motd); status != http.StatusOK {
+			t.Fatalf("get motd: status %d", status)
+		}
+		if len(motd.MOTD) != 1 || motd.MOTD[0].Title != "Game Night" {
+			t.Errorf("event banner not in motd: %+v", motd.MOTD)
+		}
+		if status := a.do(t, http.MethodDelete, "/admin/events?id=game-night", nil, true, nil); status != http.StatusOK {
+			t.Fatalf("delete event: status %d", status)
+		}
+		featured = nil
+		if status := a.do(t, http.MethodGet, "/client/servers", nil, false, &featured); status != http.StatusOK {
+			t.Fatalf("list servers: status %d", status)
+		}
+		for _, s := range featured {
+			if s.Featured {
+				t.Errorf("server still featured after the event was deleted: %+v", s)
+			}
+		}
+	})
+
+	var token1, token2 string
+	if !t.Run("Auth", func(t *testing.T) {
+		if _, ok := a.originAuth(t, player1, "valid-123"); ok {
+			t.Errorf("origin auth succeeded with an invalid stryder token")
+		}
+		var ok bool
+		token1, ok = a.originAuth(t, player1, "valid-"+strconv.FormatUint(player1, 10))
+		if !ok {
+			t.Fatalf("origin auth failed for player 1")
+		}
+		token2, ok = a.originAuth(t, player2, "valid-"+strconv.FormatUint(player2, 10))
+		if !ok {
+			t.Fatalf("origin auth failed for player 2")
+		}
+		if _, ok := a.originAuth(t, e2eHookRejectedUID, "valid-"+strconv.FormatUint(e2eHookRejectedUID, 10)); ok {
+			t.Errorf("origin auth succeeded for a player rejected by a hook")
+		}
+	}) {
+		t.FailNow()
+	}
+
+	t.Run("Allowlists", func(t *testing.T) {
+		for _, p := range []struct {
+			uid    uint64
+			token  string
+			listed bool
+		}{
+			{player1, token1, true},
+			{player2, token2, false},
+		} {
+			servers = nil
+			if status := a.do(t, http.MethodGet, "/client/servers?id="+strconv.FormatUint(p.uid, 10)+"&token="+p.token, nil, false, &servers); status != http.StatusOK {
+				t.Fatalf("list servers as %d: status %d", p.uid, status)
+			}
+			var ok bool
+			for _, s := range servers {
+				if s.ID == scrimSrv.ID() {
+					ok = true
+				}
+			}
+			if ok != p.listed {
+				t.Errorf("player %d: expected allowlisted server listed=%t", p.uid, p.listed)
+			}
+		}
+		if status := a.do(t, http.MethodGet, "/client/servers?id="+strconv.FormatUint(player1, 10)+"&token=wrong", nil, false, nil); status != http.StatusUnauthorized {
+			t.Errorf("list servers with an invalid token: expected status 401, got %d", status)
+		}
+		if a.authWithServer(t, player2, token2, scrimSrv) {
+			t.Errorf("auth with server succeeded for a player not on the allowlist")
+		}
+		if !a.authWithServer(t, player1, token1, scrimSrv) {
+			t.Errorf("auth with server failed for a player on the allowlist")
+		}
+	})
+
+	mmQuery := func(uid uint64, token string) string {
+		return "/client/matchmaking?mode=aitdm&id=" + strconv.FormatUint(uid, 10) + "&token=" + token
+	}
+	t.Run("Matchmaking", func(t *testing.T) {
+		if err := communitySrv.Update(ctx, func(i *fakeserver.Info) {
+			i.PlayerCount = 1
+		}); err != nil {
+			t.Errorf("update server: %v", err)
+		}
+		if status := a.do(t, http.MethodPost, mmQuery(player1, "wrong"), nil, false, nil); status != http.StatusUnauthorized {
+			t.Errorf("join matchmaking with an invalid token: expected status 401, got %d", status)
+		}
+		if status := a.do(t, http.MethodPost, mmQuery(player1, token1), nil, false, nil); status != http.StatusOK {
+			t.Fatalf("join matchmaking: status %d", status)
+		}
+		mmConn, err := websocket.Dial(ctx, a.URL+mmQuery(player1, token1), nil)
+		if err != nil {
+			t.Fatalf("connect to matchmaking notifications: %v", err)
+		}
+		defer mmConn.Close()
+		mmRead := func() (res struct {
+			State  string `json:"state"`
+			Server string `json:"server"`
+		}) {
+			t.Helper()
+			if _, msg, err := mmConn.Read(); err != nil {
+				t.Fatalf("read matchmaking notification: %v", err)
+			} else if err := json.Unmarshal(msg, &res); err != nil {
+				t.Fatalf("decode matchmaking notification: %v", err)
+			}
+			return
+		}
+		if res := mmRead(); res.State != "queued" {
+			t.Errorf("expected queued matchmaking state, got %+v", res)
+		}
+		if status := a.do(t, http.MethodPost, mmQuery(player2, token2), nil, false, nil); status != http.StatusOK {
+			t.Fatalf("join matchmaking: status %d", status)
+		}
+		if res := mmRead(); res.State != "matched" || res.Server != communitySrv.ID() {
+			t.Errorf("expected match on the most populated server %s, got %+v", communitySrv.ID(), res)
+		}
+	})
+
+	var ps []fakeserver.Player
+	if !t.Run("Join", func(t *testing.T) {
+		if a.authWithServer(t, player1, "wrong", communitySrv) {
+			t.Errorf("auth with server succeeded with an invalid masterserver token")
+		}
+		if !a.authWithServer(t, player1, token1, communitySrv) {
+			t.Fatalf("auth with server failed for player 1")
+		}
+		ps = communitySrv.Players()
+		if len(ps) != 1 || ps[0].UID != player1 {
+			t.Fatalf("player 1 not authenticated on the game server: %+v", ps)
+		}
+	}) {
+		t.FailNow()
+	}
+
+	checkXp := func(t *testing.T, a *atlasInstance, exp int32) {
+		t.Helper()
+
+		var res struct {
+			Xp int32 `json:"xp"`
+		}
+		if status := a.do(t, http.MethodGet, "/player/pdata?id="+strconv.FormatUint(player1, 10), nil, false, &res); status != http.StatusOK {
+			t.Fatalf("get pdata: status %d", status)
+		}
+		if res.Xp != exp {
+			t.Errorf("expected xp %d, got %d", exp, res.Xp)
+		}
+	}
+	t.Run("PdataWrite", func(t *testing.T) {
+		var pd pdata.Pdata
+		if err := pd.UnmarshalBinary(ps[0].Pdata); err != nil {
+			t.Fatalf("decode pdata sent to game server: %v", err)
+		}
+		pd.Xp = 12345
+		buf, err := pd.MarshalBinary()
+		if err != nil {
+			t.Fatalf("encode pdata: %v", err)
+		}
+		if err := communitySrv.WritePersistence(ctx, player1, buf); err != nil {
+			t.Fatalf("write persistence: %v", err)
+		}
+		if err := subscriberSrv.WritePersistence(ctx, player1, buf); err == nil {
+			t.Errorf("write persistence succeeded from a server the player isn't on")
+		}
+		checkXp(t, a, 12345)
+
+		if err := communitySrv.PatchPersistence(ctx, player1, map[string]any{"xp": 23456}); err != nil {
+			t.Fatalf("patch persistence: %v", err)
+		}
+		if err := communitySrv.PatchPersistence(ctx, player1, map[string]any{"xp": "nope"}); err == nil {
+			t.Errorf("patch persistence succeeded with an invalid patch")
+		}
+		checkXp(t, a, 23456)
+	})
+
+	t.Run("PdataRules", func(t *testing.T) {
+		if status := a.do(t, http.MethodPut, "/admin/pdatarules", map[string]any{
+			"rules": []map[string]any{{
+				"action":       "reject",
+				"field":        "xp",
+				"max_increase": 100000,
+			}},
+		}, true, nil); status != http.StatusOK {
+			t.Fatalf("set pdata rules: status %d", status)
+		}
+		if err := communitySrv.PatchPersistence(ctx, player1, map[string]any{"xp": 234567}); err == nil {
+			t.Errorf("patch persistence succeeded despite violating a pdata rule")
+		}
+		checkXp(t, a, 23456)
+		if status := a.do(t, http.MethodDelete, "/admin/pdatarules", nil, true, nil); status != http.StatusOK {
+			t.Fatalf("delete pdata rules: status %d", status)
+		}
+	})
+
+	t.Run("ConditionalPdata", func(t *testing.T) {
+		if resp, err := http.Head(a.URL + "/player/pdata?id=" + strconv.FormatUint(player1, 10)); err != nil {
+			t.Fatalf("head pdata: %v", err)
+		} else if etag := resp.Header.Get("ETag"); etag == "" {
+			t.Errorf("no etag for pdata")
+		} else {
+			req, _ := http.NewRequest(http.MethodGet, a.URL+"/player/pdata?id="+strconv.FormatUint(player1, 10), nil)
+			req.Header.Set("If-None-Match", etag)
+			if resp, err := http.DefaultClient.Do(req); err != nil {
+				t.Fatalf("get pdata: %v", err)
+			} else if resp.Body.Close(); resp.StatusCode != http.StatusNotModified {
+				t.Errorf("expected pdata to be not modified, got status %d", resp.StatusCode)
+			}
+		}
+	})
+
+	t.Run("Bans", func(t *testing.T) {
+		if status := a.do(t, http.MethodPost, "/admin/bans", map[string]any{
+			"uid":    player2,
+			"reason": "cheating",
+		}, true, nil); status != http.StatusOK {
+			t.Fatalf("add ban: status %d", status)
+		}
+		var bans struct {
+			Bans []struct {
+				UID uint64 `json:"uid"`
+			} `json:"bans"`
+		}
+		a.do(t, http.MethodGet, "/admin/bans", nil, true, &bans)
+		if len(bans.Bans) != 1 || bans.Bans[0].UID != player2 {
+			t.Errorf("ban not saved: %+v", bans)
+		}
+		bans.Bans = nil
+		if status := a.do(t, http.MethodGet, "/isolated/admin/bans", nil, true, &bans); status != http.StatusOK {
+			t.Errorf("list tenant bans: status %d", status)
+		} else if len(bans.Bans) != 0 {
+			t.Errorf("ban leaked into tenant: %+v", bans)
+		}
+
+		if status := a.do(t, http.MethodPost, "/admin/trustedservers", map[string]any{
+			"addr": communitySrv.GameAddr().String(),
+			"name": "community",
+		}, true, nil); status != http.StatusOK {
+			t.Fatalf("add trusted server: status %d", status)
+		}
+		if status := a.do(t, http.MethodPost, "/admin/banlists", map[string]any{
+			"id":   "community",
+			"name": "Community",
+		}, true, nil); status != http.StatusOK {
+			t.Fatalf("add ban list: status %d", status)
+		}
+		if status := a.do(t, http.MethodPost, "/server/banlist?id="+communitySrv.ID()+"&list=community", map[string]any{
+			"bans": []map[string]any{{"uid": player2, "reason": "cheating"}},
+		}, false, nil); status != http.StatusOK {
+			t.Fatalf("upload ban list entries: status %d", status)
+		}
+		if status := a.do(t, http.MethodPost, "/server/banlist?id="+subscriberSrv.ID()+"&list=community", map[string]any{
+			"bans": []map[string]any{{"uid": player1}},
+		}, false, nil); status != http.StatusForbidden {
+			t.Errorf("untrusted server uploading ban list entries: expected status 403, got %d", status)
+		}
+
+		var feed struct {
+			Lists []struct {
+				Entries []struct {
+					UID    uint64 `json:"uid"`
+					Banned bool   `json:"banned"`
+				} `json:"entries"`
+			} `json:"lists"`
+		}
+		if status := a.do(t, http.MethodGet, "/server/banlist?id="+subscriberSrv.ID()+"&list=community", nil, false, &feed); status != http.StatusOK {
+			t.Fatalf("get ban list feed: status %d", status)
+		}
+		bannedMu.Lock()
+		for _, l := range feed.Lists {
+			for _, e := range l.Entries {
+				banned[e.UID] = e.Banned
+			}
+		}
+		bannedMu.Unlock()
+
+		if a.authWithServer(t, player2, token2, subscriberSrv) {
+			t.Errorf("banned player authenticated with subscribed server")
+		}
+		if !a.authWithServer(t, player2, token2, communitySrv) {
+			t.Errorf("banned player not authenticated with the server which didn't subscribe")
+		}
+		if !a.authWithServer(t, player1, token1, subscriberSrv) {
+			t.Errorf("unbanned player not authenticated with subscribed server")
+		}
+	})
+
+	t.Run("MatchResults", func(t *testing.T) {
+		matchResult := map[string]any{
+			"mode":     "aitdm",
+			"duration": 600,
+			"players": []map[string]any{
+				{"uid": player1, "team": 1, "rank": 1, "stats": map[string]int{"kills": 20}},
+				{"uid": player2, "team": 2, "rank": 2, "stats": map[string]int{"kills": 5}},
+			},
+		}
+		if status := a.do(t, http.MethodPost, "/server/match_result?id="+communitySrv.ID(), matchResult, false, nil); status != http.StatusForbidden {
+			t.Errorf("match result for a player on another server: expected status 403, got %d", status)
+		}
+		if !a.authWithServer(t, player1, token1, communitySrv) {
+			t.Fatalf("auth with server failed for player 1")
+		}
+		if status := a.do(t, http.MethodPost, "/server/match_result?id="+communitySrv.ID(), matchResult, false, nil); status != http.StatusOK {
+			t.Fatalf("submit match result: status %d", status)
+		}
+		for _, x := range []struct {
+			uid    uint64
+			better bool
+		}{{player1, true}, {player2, false}} {
+			var res struct {
+				Ratings map[string]struct {
+					Rating  float64 `json:"rating"`
+					Matches int     `json:"matches"`
+				} `json:"ratings"`
+			}
+			if status := a.do(t, http.MethodGet, "/player/ratings?id="+strconv.FormatUint(x.uid, 10), nil, false, &res); status != http.StatusOK {
+				t.Fatalf("get player ratings: status %d", status)
+			}
+			if r, ok := res.Ratings["aitdm"]; !ok || r.Matches != 1 || (r.Rating > 1500) != x.better {
+				t.Errorf("incorrect rating for player %d after match: %+v", x.uid, res.Ratings)
+			}
+		}
+		var history struct {
+			Matches []struct {
+				ServerID string `json:"server_id"`
+				Mode     string `json:"mode"`
+				Duration int    `json:"duration"`
+				Players  []struct {
+					UID   uint64         `json:"uid"`
+					Stats map[string]int `json:"stats"`
+				} `json:"players"`
+			} `json:"matches"`
+		}
+		if status := a.do(t, http.MethodGet, "/player/"+strconv.FormatUint(player2, 10)+"/matches", nil, false, &history); status != http.StatusOK {
+			t.Fatalf("get match history: status %d", status)
+		}
+		if len(history.Matches) != 1 {
+			t.Errorf("expected one match in history, got %+v", history.Matches)
+		} else if m := history.Matches[0]; m.ServerID != communitySrv.ID() || m.Mode != "aitdm" || m.Duration != 600 || len(m.Players) != 2 || m.Players[0].Stats["kills"] != 20 {
+			t.Errorf("incorrect match in history: %+v", m)
+		}
+	})
+
+	partyQuery := func(uid uint64, token, params string) string {
+		return "/client/party?id=" + strconv.FormatUint(uid, 10) + "&token=" + token + params
+	}
+	privateSrv := a.startServerInfo(t, fakeserver.Info{Name: "private server", Password: "hunter2"}, nil)
+	t.Run("Parties", func(t *testing.T) {
+		type partyState struct {
+			Party *struct {
+				Leader  uint64 `json:"leader"`
+				Code    string `json:"code"`
+				Server  string `json:"server"`
+				Members []struct {
+					UID    uint64 `json:"uid"`
+					Online bool   `json:"online"`
+				} `json:"members"`
+			} `json:"party"`
+		}
+		var party partyState
+		if status := a.do(t, http.MethodPost, partyQuery(player1, token1, "&action=create"), nil, false, &party); status != http.StatusOK {
+			t.Fatalf("create party: status %d", status)
+		} else if party.Party == nil || party.Party.Leader != player1 || party.Party.Code == "" {
+			t.Fatalf("incorrect party after create: %+v", party.Party)
+		}
+		if status := a.do(t, http.MethodPost, partyQuery(player2, token2, "&action=join&code=AAAAAA"), nil, false, nil); status != http.StatusNotFound {
+			t.Errorf("join party with an invalid code: expected status 404, got %d", status)
+		}
+		if status := a.do(t, http.MethodPost, partyQuery(player2, token2, "&action=join&code="+party.Party.Code), nil, false, &party); status != http.StatusOK {
+			t.Fatalf("join party: status %d", status)
+		} else if party.Party == nil || len(party.Party.Members) != 2 {
+			t.Fatalf("incorrect party after join: %+v", party.Party)
+		}
+
+		partyConn, err := websocket.Dial(ctx, a.URL+partyQuery(player2, token2, ""), nil)
+		if err != nil {
+			t.Fatalf("connect to party presence: %v", err)
+		}
+		defer partyConn.Close()
+		partyRead := func() (res partyState) {
+			t.Helper()
+			if _, msg, err := partyConn.Read(); err != nil {
+				t.Fatalf("read party presence: %v", err)
+			} else if err := json.Unmarshal(msg, &res); err != nil {
+				t.Fatalf("decode party presence: %v", err)
+			}
+			return
+		}
+		if res := partyRead(); res.Party == nil || len(res.Party.Members) != 2 || res.Party.Members[0].Online || !res.Party.Members[1].Online {
+			t.Errorf("expected party member to be online: %+v", res.Party)
+		}
+
+		if status := a.do(t, http.MethodPost, mmQuery(player2, token2), nil, false, nil); status != http.StatusForbidden {
+			t.Errorf("party member joining matchmaking: expected status 403, got %d", status)
+		}
+		var partyTicket struct {
+			Ticket struct {
+				State   string   `json:"state"`
+				Players []uint64 `json:"players"`
+			} `json:"ticket"`
+		}
+		if status := a.do(t, http.MethodPost, mmQuery(player1, token1), nil, false, &partyTicket); status != http.StatusOK {
+			t.Fatalf("join matchmaking as party leader: status %d", status)
+		} else if partyTicket.Ticket.State != "matched" || len(partyTicket.Ticket.Players) != 2 {
+			t.Errorf("expected party to be matched together: %+v", partyTicket.Ticket)
+		}
+
+		if a.authWithServer(t, player2, token2, privateSrv) {
+			t.Errorf("party member joined a private server without the password before the leader")
+		}
+		var leaderAuth struct {
+			Success bool `json:"success"`
+		}
+		a.do(t, http.MethodPost, "/client/auth_with_server?id="+strconv.FormatUint(player1, 10)+"&server="+privateSrv.ID()+"&playerToken="+token1+"&password=hunter2", nil, false, &leaderAuth)
+		if !leaderAuth.Success {
+			t.Fatalf("auth with private server failed for the party leader")
+		}
+		if res := partyRead(); res.Party == nil || res.Party.Server != privateSrv.ID() {
+			t.Errorf("expected party members to be notified of the leader's server: %+v", res.Party)
+		}
+		if !a.authWithServer(t, player2, token2, privateSrv) {
+			t.Errorf("party member could not follow the leader to a private server")
+		}
+
+		if status := a.do(t, http.MethodDelete, partyQuery(player2, token2, ""), nil, false, nil); status != http.StatusOK {
+			t.Fatalf("leave party: status %d", status)
+		}
+		if res := partyRead(); res.Party != nil {
+			t.Errorf("expected presence to end after leaving the party: %+v", res.Party)
+		}
+	})
+
+	t.Run("JoinTokens", func(t *testing.T) {
+		joinToken := func(uid uint64, token, password string) (string, int) {
+			var res struct {
+				JoinToken string `json:"joinToken"`
+				ExpiresIn int    `json:"expiresIn"`
+			}
+			st := a.do(t, http.MethodPost, "/client/join_token?id="+strconv.FormatUint(uid, 10)+"&server="+privateSrv.ID()+"&playerToken="+token+"&password="+password, nil, false, &res)
+			if st == http.StatusOK && res.ExpiresIn <= 0 {
+				t.Errorf("expected join token expiry, got %d", res.ExpiresIn)
+			}
+			return res.JoinToken, st
+		}
+		joinWithToken := func(uid uint64, token, jt string) int {
+			return a.do(t, http.MethodPost, "/client/auth_with_server?id="+strconv.FormatUint(uid, 10)+"&server="+privateSrv.ID()+"&playerToken="+token+"&joinToken="+jt, nil, false, nil)
+		}
+		if _, st := joinToken(player1, token1, "wrong"); st != http.StatusUnauthorized {
+			t.Errorf("join token with wrong password: expected status 401, got %d", st)
+		}
+		if _, st := joinToken(player1, "wrong", "hunter2"); st != http.StatusUnauthorized {
+			t.Errorf("join token with wrong player token: expected status 401, got %d", st)
+		}
+		if jt, st := joinToken(player1, token1, "hunter2"); st != http.StatusOK || jt == "" {
+			t.Errorf("join token: expected status 200 and a token, got %d %q", st, jt)
+		} else {
+			if st := joinWithToken(player2, token2, jt); st != http.StatusUnauthorized {
+				t.Errorf("join token for a different player: expected status 401, got %d", st)
+			}
+			if st := joinWithToken(player1, "wrong", jt); st != http.StatusUnauthorized {
+				t.Errorf("join token with wrong player token: expected status 401, got %d", st)
+			}
+			if st := joinWithToken(player1, token1, jt); st != http.StatusOK {
+				t.Errorf("auth with server using join token: expected status 200, got %d", st)
+			}
+			if st := joinWithToken(player1, token1, jt); st != http.StatusUnauthorized {
+				t.Errorf("reused join token: expected status 401, got %d", st)
+			}
+		}
+		if st := joinWithToken(player1, token1, "invalid"); st != http.StatusUnauthorized {
+			t.Errorf("invalid join token: expected status 401, got %d", st)
+		}
+	})
+
+	friendsQuery := func(uid uint64, token, params string) string {
+		return "/client/friends?id=" + strconv.FormatUint(uid, 10) + "&token=" + token + params
+	}
+	type friendsList struct {
+		Friends []struct {
+			UID    uint64 `json:"uid,string"`
+			Status string `json:"status"`
+			Online bool   `json:"online"`
+			Server *struct {
+				ID string `json:"id"`
+			} `json:"server"`
+		} `json:"friends"`
+	}
+	friendAction := func(t *testing.T, uid uint64, token, action string, friend uint64) (string, int) {
+		var res struct {
+			Status string `json:"status"`
+		}
+		st := a.do(t, http.MethodPost, friendsQuery(uid, token, "&action="+action+"&uid="+strconv.FormatUint(friend, 10)), nil, false, &res)
+		return res.Status, st
+	}
+	t.Run("Friends", func(t *testing.T) {
+		if _, st := friendAction(t, player1, "wrong", "request", player2); st != http.StatusUnauthorized {
+			t.Errorf("friend request with wrong player token: expected status 401, got %d", st)
+		}
+		if _, st := friendAction(t, player1, token1, "request", player1); st != http.StatusBadRequest {
+			t.Errorf("friend request to self: expected status 400, got %d", st)
+		}
+		if _, st := friendAction(t, player2, token2, "accept", player1); st != http.StatusBadRequest {
+			t.Errorf("accept nonexistent friend request: expected status 400, got %d", st)
+		}
+		if s, st := friendAction(t, player1, token1, "request", player2); st != http.StatusOK || s != "outgoing" {
+			t.Errorf("friend request: expected status 200 outgoing, got %d %q", st, s)
+		}
+		var friends friendsList
+		if st := a.do(t, http.MethodGet, friendsQuery(player2, token2, ""), nil, false, &friends); st != http.StatusOK {
+			t.Fatalf("list friends: status %d", st)
+		} else if len(friends.Friends) != 1 || friends.Friends[0].UID != player1 || friends.Friends[0].Status != "incoming" || friends.Friends[0].Online {
+			t.Errorf("expected incoming friend request without presence: %+v", friends.Friends)
+		}
+		if s, st := friendAction(t, player2, token2, "accept", player1); st != http.StatusOK || s != "accepted" {
+			t.Errorf("accept friend request: expected status 200 accepted, got %d %q", st, s)
+		}
+		if st := a.do(t, http.MethodGet, friendsQuery(player2, token2, ""), nil, false, &friends); st != http.StatusOK {
+			t.Fatalf("list friends: status %d", st)
+		} else if len(friends.Friends) != 1 || friends.Friends[0].Status != "accepted" || !friends.Friends[0].Online || friends.Friends[0].Server == nil || friends.Friends[0].Server.ID != privateSrv.ID() {
+			t.Errorf("expected accepted friend on the private server: %+v", friends.Friends)
+		}
+		if _, st := friendAction(t, player1, token1, "remove", player2); st != http.StatusOK {
+			t.Errorf("remove friend: expected status 200, got %d", st)
+		}
+		if st := a.do(t, http.MethodGet, friendsQuery(player2, token2, ""), nil, false, &friends); st != http.StatusOK {
+			t.Fatalf("list friends: status %d", st)
+		} else if len(friends.Friends) != 0 {
+			t.Errorf("expected friend to be removed for both players: %+v", friends.Friends)
+		}
+	})
+
+	t.Run("BlockLists", func(t *testing.T) {
+		blockAction := func(uid uint64, token, action string, blocked uint64) int {
+			return a.do(t, http.MethodPost, "/client/blocks?id="+strconv.FormatUint(uid, 10)+"&token="+token+"&action="+action+"&uid="+strconv.FormatUint(blocked, 10), nil, false, nil)
+		}
+		if st := blockAction(player2, "wrong", "block", player1); st != http.StatusUnauthorized {
+			t.Errorf("block with wrong player token: expected status 401, got %d", st)
+		}
+		if _, st := friendAction(t, player1, token1, "request", player2); st != http.StatusOK {
+			t.Errorf("friend request: expected status 200, got %d", st)
+		}
+		if st := blockAction(player2, token2, "block", player1); st != http.StatusOK {
+			t.Fatalf("block player: status %d", st)
+		}
+		var blocks struct {
+			Blocks []struct {
+				UID uint64 `json:"uid,string"`
+			} `json:"blocks"`
+		}
+		if st := a.do(t, http.MethodGet, "/client/blocks?id="+strconv.FormatUint(player2, 10)+"&token="+token2, nil, false, &blocks); st != http.StatusOK {
+			t.Fatalf("list blocks: status %d", st)
+		} else if len(blocks.Blocks) != 1 || blocks.Blocks[0].UID != player1 {
+			t.Errorf("incorrect blocks: %+v", blocks.Blocks)
+		}
+		var friends friendsList
+		if st := a.do(t, http.MethodGet, friendsQuery(player2, token2, ""), nil, false, &friends); st != http.StatusOK {
+			t.Fatalf("list friends: status %d", st)
+		} else if len(friends.Friends) != 0 {
+			t.Errorf("expected blocking to remove the friend request: %+v", friends.Friends)
+		}
+		if _, st := friendAction(t, player1, token1, "request", player2); st != http.StatusForbidden {
+			t.Errorf("friend request to a player who blocked us: expected status 403, got %d", st)
+		}
+		if status := a.do(t, http.MethodPost, partyQuery(player1, token1, "&action=invite&uid="+strconv.FormatUint(player2, 10)), nil, false, nil); status != http.StatusForbidden {
+			t.Errorf("party invite for a player who blocked us: expected status 403, got %d", status)
+		}
+		var mmBlocked struct {
+			Ticket struct {
+				State string `json:"state"`
+			} `json:"ticket"`
+		}
+		if status := a.do(t, http.MethodPost, mmQuery(player1, token1), nil, false, nil); status != http.StatusOK {
+			t.Fatalf("join matchmaking: status %d", status)
+		}
+		if status := a.do(t, http.MethodPost, mmQuery(player2, token2), nil, false, &mmBlocked); status != http.StatusOK {
+			t.Fatalf("join matchmaking: status %d", status)
+		} else if mmBlocked.Ticket.State != "queued" {
+			t.Errorf("expected blocked players not to be matched together: %+v", mmBlocked.Ticket)
+		}
+		for _, x := range []struct {
+			uid   uint64
+			token string
+		}{{player1, token1}, {player2, token2}} {
+			if status := a.do(t, http.MethodDelete, mmQuery(x.uid, x.token), nil, false, nil); status != http.StatusOK {
+				t.Fatalf("leave matchmaking: status %d", status)
+			}
+		}
+		if st := blockAction(player2, token2, "unblock", player1); st != http.StatusOK {
+			t.Fatalf("unblock player: status %d", st)
+		}
+		if _, st := friendAction(t, player1, token1, "request", player2); st != http.StatusOK {
+			t.Errorf("friend request after unblocking: expected status 200, got %d", st)
+		}
+		if _, st := friendAction(t, player1, token1, "remove", player2); st != http.StatusOK {
+			t.Errorf("remove friend request: expected status 200, got %d", st)
+		}
+	})
+
+	// the chat relay connections are also used to check mute reports
+	chatDial := func(s *fakeserver.Server) *websocket.Conn {
+		c, err := websocket.Dial(ctx, a.URL+"/server/chat?id="+s.ID()+"&channels=global", nil)
+		if err != nil {
+			t.Fatalf("connect to chat relay: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	type chatEvent struct {
+		Type       string `json:"type"`
+		Error      string `json:"error"`
+		UID        uint64 `json:"uid"`
+		ServerName string `json:"server_name"`
+		Text       string `json:"text"`
+	}
+	chatRead := func(t *testing.T, c *websocket.Conn) (res chatEvent) {
+		t.Helper()
+		if _, msg, err := c.Read(); err != nil {
+			t.Fatalf("read chat relay: %v", err)
+		} else if err := json.Unmarshal(msg, &res); err != nil {
+			t.Fatalf("decode chat relay: %v", err)
+		}
+		return
+	}
+	chatPrivate, chatCommunity := chatDial(privateSrv), chatDial(communitySrv)
+	time.Sleep(time.Millisecond * 50) // let both connections register
+	t.Run("ChatRelay", func(t *testing.T) {
+		if err := chatPrivate.WriteText([]byte(`{"channel":"global","uid":` + strconv.FormatUint(player1, 10) + `,"text":"what the heck"}`)); err != nil {
+			t.Fatalf("send chat: %v", err)
+		}
+		if res := chatRead(t, chatCommunity); res.Type != "message" || res.UID != player1 || res.ServerName != "private server" || res.Text != "what the ****" {
+			t.Errorf("incorrect relayed chat message: %+v", res)
+		}
+		if err := chatCommunity.WriteText([]byte(`{"channel":"global","uid":` + strconv.FormatUint(player1, 10) + `,"text":"hi"}`)); err != nil {
+			t.Fatalf("send chat: %v", err)
+		}
+		if res := chatRead(t, chatCommunity); res.Type != "error" {
+			t.Errorf("expected chat from a player on another server to be rejected: %+v", res)
+		}
+
+		if status := a.do(t, http.MethodPost, "/admin/chatmutes", map[string]any{"uid": player2, "reason": "spam"}, true, nil); status != http.StatusOK {
+			t.Fatalf("mute player: status %d", status)
+		}
+		if err := chatPrivate.WriteText([]byte(`{"channel":"global","uid":` + strconv.FormatUint(player2, 10) + `,"text":"spam"}`)); err != nil {
+			t.Fatalf("send chat: %v", err)
+		}
+		if res := chatRead(t, chatPrivate); res.Type != "error" || res.Error != "player "+strconv.FormatUint(player2, 10)+" is muted" {
+			t.Errorf("expected chat from a muted player to be rejected: %+v", res)
+		}
+	})
+
+	t.Run("MuteReports", func(t *testing.T) {
+		type muteStatus struct {
+			Chat struct {
+				Muted   bool `json:"muted"`
+				Servers int  `json:"servers"`
+			} `json:"chat"`
+			Voice struct {
+				Muted bool `json:"muted"`
+			} `json:"voice"`
+		}
+		muteStatusPath := "/server/mute_status?id=" + communitySrv.ID() + "&uid=" + strconv.FormatUint(player1, 10)
+		if status := a.do(t, http.MethodPost, "/server/mute?id="+communitySrv.ID(), map[string]any{"uid": player1, "kind": "chat"}, false, nil); status != http.StatusForbidden {
+			t.Errorf("mute report for a player on another server: expected status 403, got %d", status)
+		}
+		if status := a.do(t, http.MethodPost, "/server/mute?id="+privateSrv.ID(), map[string]any{"uid": player1, "kind": "chat", "reason": "spam"}, false, nil); status != http.StatusOK {
+			t.Fatalf("report mute: status %d", status)
+		}
+		var mute muteStatus
+		if status := a.do(t, http.MethodGet, muteStatusPath, nil, false, &mute); status != http.StatusOK {
+			t.Fatalf("get mute status: status %d", status)
+		} else if !mute.Chat.Muted || mute.Chat.Servers != 1 || mute.Voice.Muted {
+			t.Errorf("incorrect mute status after report: %+v", mute)
+		}
+		if err := chatPrivate.WriteText([]byte(`{"channel":"global","uid":` + strconv.FormatUint(player1, 10) + `,"text":"hello"}`)); err != nil {
+			t.Fatalf("send chat: %v", err)
+		}
+		if res := chatRead(t, chatPrivate); res.Type != "error" {
+			t.Errorf("expected chat from a player muted by servers to be rejected: %+v", res)
+		}
+		if status := a.do(t, http.MethodDelete, "/server/mute?id="+privateSrv.ID()+"&uid="+strconv.FormatUint(player1, 10)+"&kind=chat", nil, false, nil); status != http.StatusOK {
+			t.Fatalf("retract mute: status %d", status)
+		}
+		if status := a.do(t, http.MethodGet, muteStatusPath, nil, false, &mute); status != http.StatusOK {
+			t.Fatalf("get mute status: status %d", status)
+		} else if mute.Chat.Muted {
+			t.Errorf("expected player to be unmuted after the report was retracted: %+v", mute)
+		}
+	})
+
+	t.Run("CrashReports", func(t *testing.T) {
+		var crashSig string
+		for i, v := range []string{"1.20.0", "1.21.0"} {
+			var res struct {
+				Signature string `json:"signature"`
+			}
+			if status := a.do(t, http.MethodPost, "/server/crash?id="+communitySrv.ID(), map[string]any{
+				"exception": "EXCEPTION_ACCESS_VIOLATION",
+				"module":    "engine.dll",
+				"stack":     []string{"engine.dll+0x1234", "server.dll+0x5678", "a", "b", "c", "deep" + strconv.Itoa(i)},
+				"version":   v,
+				"mods":      []map[string]any{{"name": "Northstar.Custom", "version": v}},
+			}, false, &res); status != http.StatusOK {
+				t.Fatalf("upload crash: status %d", status)
+			} else if crashSig != "" && res.Signature != crashSig {
+				t.Errorf("expected crashes differing only in deep frames to have the same signature")
+			}
+			crashSig = res.Signature
+		}
+		var crashes struct {
+			Crashes []struct {
+				Signature string         `json:"signature"`
+				Count     int            `json:"count"`
+				Versions  map[string]int `json:"versions"`
+			} `json:"crashes"`
+		}
+		if status := a.do(t, http.MethodGet, "/admin/crashes", nil, true, &crashes); status != http.StatusOK {
+			t.Fatalf("get top crashes: status %d", status)
+		} else if len(crashes.Crashes) != 1 || crashes.Crashes[0].Signature != crashSig || crashes.Crashes[0].Count != 2 || crashes.Crashes[0].Versions["1.21.0"] != 1 {
+			t.Errorf("incorrect top crashes: %+v", crashes.Crashes)
+		}
+	})
+
+	t.Run("UpdateManifest", func(t *testing.T) {
+		if status := a.do(t, http.MethodPost, "/admin/releases", map[string]any{
+			"component": "client",
+			"channel":   "stable",
+			"version":   "v1.20",
+			"url":       "http://example.com/Northstar.zip",
+		}, true, nil); status != http.StatusBadRequest {
+			t.Errorf("expected non-https release url to be rejected, got status %d", status)
+		}
+		if status := a.do(t, http.MethodPost, "/admin/releases", map[string]any{
+			"component": "client",
+			"channel":   "stable",
+			"version":   "v1.20.0",
+			"url":       "https://example.com/Northstar.release.v1.20.0.zip",
+		}, true, nil); status != http.StatusOK {
+			t.Fatalf("add release: status %d", status)
+		}
+		var manifest struct {
+			Releases []struct {
+				Component string `json:"component"`
+				Version   string `json:"version"`
+			} `json:"releases"`
+			Manifest string `json:"manifest"`
+		}
+		if status := a.do(t, http.MethodGet, "/client/update_manifest", nil, false, &manifest); status != http.StatusOK {
+			t.Fatalf("get update manifest: status %d", status)
+		} else if len(manifest.Releases) != 1 || manifest.Releases[0].Version != "v1.20.0" {
+			t.Errorf("incorrect releases: %+v", manifest.Releases)
+		}
+		for _, path := range []string{"/client/motd", "/client/update_manifest", "/client/mods"} {
+			conditional := func(hdr, val string) *http.Response {
+				req, err := http.NewRequest(http.MethodGet, a.URL+path, nil)
+				if err != nil {
+					t.Fatalf("conditional request: %v", err)
+				}
+				if hdr != "" {
+					req.Header.Set(hdr, val)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("conditional request: %s: %v", path, err)
+				}
+				resp.Body.Close()
+				return resp
+			}
+			resp := conditional("", "")
+			etag, mod := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+			if resp.StatusCode != http.StatusOK || etag == "" || mod == "" {
+				t.Errorf("get %s: expected etag and last-modified (status %d)", path, resp.StatusCode)
+				continue
+			}
+			if resp := conditional("If-None-Match", etag); resp.StatusCode != http.StatusNotModified || resp.Header.Get("ETag") != etag {
+				t.Errorf("get %s: if-none-match: expected status 304, got %d", path, resp.StatusCode)
+			}
+			if resp := conditional("If-Modified-Since", mod); resp.StatusCode != http.StatusNotModified {
+				t.Errorf("get %s: if-modified-since: expected status 304, got %d", path, resp.StatusCode)
+			}
+			if resp := conditional("If-None-Match", `W/"stale"`); resp.StatusCode != http.StatusOK {
+				t.Errorf("get %s: stale if-none-match: expected status 200, got %d", path, resp.StatusCode)
+			}
+		}
+		var manifestKey struct {
+			PublicKey []byte `json:"public_key"`
+		}
+		if status := a.do(t, http.MethodGet, "/client/server_attestation_key", nil, false, &manifestKey); status != http.StatusOK {
+			t.Fatalf("get attestation key: status %d", status)
+		}
+		if p, s, ok := strings.Cut(manifest.Manifest, "."); !ok {
+			t.Errorf("invalid manifest %q", manifest.Manifest)
+		} else if buf, err := base64.RawURLEncoding.DecodeString(p); err != nil {
+			t.Errorf("invalid manifest %q: %v", manifest.Manifest, err)
+		} else if sig, err := base64.RawURLEncoding.DecodeString(s); err != nil {
+			t.Errorf("invalid manifest %q: %v", manifest.Manifest, err)
+		} else if !ed25519.Verify(ed25519.PublicKey(manifestKey.PublicKey), buf, sig) {
+			t.Errorf("manifest signature does not verify")
+		} else {
+			var jwks struct {
+				Keys []struct {
+					Kty string `json:"kty"`
+					Crv string `json:"crv"`
+					X   string `json:"x"`
+					Kid string `json:"kid"`
+				} `json:"keys"`
+			}
+			var payload struct {
+				Kid string `json:"kid"`
+			}
+			if status := a.do(t, http.MethodGet, "/.well-known/jwks.json", nil, false, &jwks); status != http.StatusOK {
+				t.Errorf("get jwks: status %d", status)
+			} else if err := json.Unmarshal(buf, &payload); err != nil || payload.Kid == "" {
+				t.Errorf("manifest %q does not have a kid", buf)
+			} else if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != payload.Kid || jwks.Keys[0].Kty != "OKP" || jwks.Keys[0].Crv != "Ed25519" {
+				t.Errorf("incorrect jwks %+v for kid %q", jwks, payload.Kid)
+			} else if x, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].X); err != nil || !ed25519.Verify(ed25519.PublicKey(x), buf, sig) {
+				t.Errorf("manifest signature does not verify with jwks key")
+			}
+		}
+	})
+
+	t.Run("ModIndex", func(t *testing.T) {
+		moddedSrv := &fakeserver.Server{
+			MasterServer: a.URL,
+			Info: fakeserver.Info{
+				Name:       "modded server",
+				Map:        "mp_forwardbase_kodai",
+				Playlist:   "aitdm",
+				MaxPlayers: 16,
+			},
+			Mods: []fakeserver.Mod{
+				{Name: "Northstar.Custom", Version: "1.20.0"},
+				{Name: "Example.Weapons", Version: "2.0.0", RequiredOnClient: true},
+				{Name: "Example.Maps", Version: "1.0.0", RequiredOnClient: true},
+			},
+		}
+		if err := moddedSrv.Listen(netip.MustParseAddr("127.0.0.1")); err != nil {
+			t.Fatalf("listen game server: %v", err)
+		}
+		t.Cleanup(func() { moddedSrv.Close() })
+		if err := moddedSrv.Register(ctx); err != nil {
+			t.Fatalf("register modded server: %v", err)
+		}
+		if status := a.do(t, http.MethodPost, "/admin/mods", map[string]any{
+			"name":    "Example.Weapons",
+			"version": "2.0.0",
+			"sha256":  "6b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4b",
+			"url":     "https://example.com/Example.Weapons-2.0.0.zip",
+			"mirrors": []string{"https://mirror.example.com/Example.Weapons-2.0.0.zip"},
+		}, true, nil); status != http.StatusOK {
+			t.Fatalf("add mod: status %d", status)
+		}
+		var serverMods struct {
+			Mods []struct {
+				Name    string   `json:"name"`
+				Indexed bool     `json:"indexed"`
+				URL     string   `json:"url"`
+				Mirrors []string `json:"mirrors"`
+			} `json:"mods"`
+		}
+		if status := a.do(t, http.MethodGet, "/client/server_mods?id="+moddedSrv.ID(), nil, false, &serverMods); status != http.StatusOK {
+			t.Fatalf("get server mods: status %d", status)
+		} else if len(serverMods.Mods) != 2 || serverMods.Mods[0].Name != "Example.Weapons" || !serverMods.Mods[0].Indexed || serverMods.Mods[0].URL == "" || len(serverMods.Mods[0].Mirrors) != 1 || serverMods.Mods[1].Indexed {
+			t.Errorf("incorrect server mods: %+v", serverMods.Mods)
+		}
+		var mods struct {
+			Mods []struct {
+				Name    string `json:"name"`
+				Servers int    `json:"servers"`
+			} `json:"mods"`
+		}
+		if status := a.do(t, http.MethodGet, "/client/mods", nil, false, &mods); status != http.StatusOK {
+			t.Fatalf("get mod index: status %d", status)
+		} else if len(mods.Mods) != 1 || mods.Mods[0].Servers != 1 {
+			t.Errorf("incorrect mod index: %+v", mods.Mods)
+		}
+		if err := moddedSrv.Remove(ctx); err != nil {
+			t.Errorf("remove server: %v", err)
+		}
+
+		// conditional requests still work when the response is large enough to
+		// be compressed
+		for i := 0; i < 10; i++ {
+			name := "Example.Large" + strconv.Itoa(i)
+			if status := a.do(t, http.MethodPost, "/admin/mods", map[string]any{
+				"name":    name,
+				"version": "1.0.0",
+				"sha256":  "6b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4b",
+				"url":     "https://example.com/" + name + "-1.0.0.zip",
+				"mirrors": []string{"https://mirror.example.com/" + name + "-1.0.0.zip"},
+			}, true, nil); status != http.StatusOK {
+				t.Fatalf("add mod: status %d", status)
+			}
+		}
+		compressed := func(inm string) (*http.Response, []byte) {
+			req, err := http.NewRequest(http.MethodGet, a.URL+"/client/mods", nil)
+			if err != nil {
+				t.Fatalf("compressed request: %v", err)
+			}
+			req.Header.Set("Accept-Encoding", "gzip") // set explicitly so the transport doesn't decompress it
+			if inm != "" {
+				req.Header.Set("If-None-Match", inm)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("compressed request: %v", err)
+			}
+			defer resp.Body.Close()
+			buf, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("compressed request: %v", err)
+			}
+			return resp, buf
+		}
+		if resp, buf := compressed(""); resp.StatusCode != http.StatusOK {
+			t.Errorf("get compressed mod index: expected status 200, got %d", resp.StatusCode)
+		} else if enc := resp.Header.Get("Content-Encoding"); enc != "gzip" {
+			t.Errorf("get compressed mod index: expected gzip response, got %q (%d bytes)", enc, len(buf))
+		} else if etag := resp.Header.Get("ETag"); !strings.HasPrefix(etag, `W/"`) {
+			t.Errorf("get compressed mod index: expected weak etag, got %q", etag)
+		} else {
+			if resp, buf := compressed(etag); resp.StatusCode != http.StatusNotModified || len(buf) != 0 {
+				t.Errorf("get compressed mod index: if-none-match: expected status 304 with no body, got %d (%d bytes)", resp.StatusCode, len(buf))
+			} else if v := resp.Header.Get("ETag"); v != etag {
+				t.Errorf("get compressed mod index: if-none-match: expected etag %q, got %q", etag, v)
+			}
+			if resp, _ := compressed(`W/"stale"`); resp.StatusCode != http.StatusOK {
+				t.Errorf("get compressed mod index: stale if-none-match: expected status 200, got %d", resp.StatusCode)
+			}
+		}
+	})
+
+	t.Run("EdgeRelays", func(t *testing.T) {
+		var relayAdd struct {
+			Token string `json:"token"`
+		}
+		if status := a.do(t, http.MethodPost, "/admin/relays", map[string]any{
+			"id":     "au1",
+			"region": "AU",
+			"url":    "http://au1.example.com",
+		}, true, &relayAdd); status != http.StatusOK || relayAdd.Token == "" {
+			t.Fatalf("add relay: status %d", status)
+		}
+		relay := &edgerelay.Relay{
+			Primary: a.URL,
+			ID:      "au1",
+			Token:   relayAdd.Token,
+		}
+		relayCtx, relayCancel := context.WithCancel(ctx)
+		relayDone := make(chan struct{})
+		go func() {
+			defer close(relayDone)
+			relay.Run(relayCtx)
+		}()
+		relaySrv := httptest.NewServer(relay)
+		relayClient := &http.Client{
+			// requests not served by the relay are redirected to the primary
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		for i := 0; ; i++ {
+			var relays struct {
+				Relays []struct {
+					ID string `json:"id"`
+				} `json:"relays"`
+			}
+			if status := a.do(t, http.MethodGet, "/client/relays", nil, false, &relays); status != http.StatusOK {
+				t.Fatalf("list relays: status %d", status)
+			} else if len(relays.Relays) == 1 && relays.Relays[0].ID == "au1" {
+				break
+			}
+			if i == 50 {
+				t.Fatalf("relay did not connect")
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		for i := 0; ; i++ {
+			resp, err := relayClient.Get(relaySrv.URL + "/client/servers")
+			if err != nil {
+				t.Fatalf("get relayed server list: %v", err)
+			}
+			buf, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK && bytes.Contains(buf, []byte(`"community server"`)) {
+				break
+			}
+			if i == 50 {
+				t.Fatalf("relay did not serve the server list")
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		relaySrv.Close()
+		relayCancel()
+		<-relayDone
+	})
+
+	t.Run("LeaderElection", func(t *testing.T) {
+		leaderCtx, leaderCancel := context.WithCancel(ctx)
+		leaderDone := make(chan struct{})
+		go func() {
+			defer close(leaderDone)
+			a.Leader.Run(leaderCtx)
+		}()
+		other := &leader.Elector{
+			Backend: a.Leader.Backend,
+			Name:    a.Leader.Name,
+			ID:      "other",
+			TTL:     a.Leader.TTL,
+		}
+		otherCtx, otherCancel := context.WithCancel(ctx)
+		defer otherCancel()
+		for i := 0; !a.Leader.IsLeader(); i++ {
+			if i == 50 {
+				t.Fatalf("instance did not become leader")
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+		go other.Run(otherCtx)
+		for i := 0; other.Leader() != a.Leader.ID; i++ {
+			if i == 50 {
+				t.Fatalf("other instance did not see the leader")
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+		if other.IsLeader() {
+			t.Errorf("other instance should not be the leader")
+		}
+		leaderCancel()
+		<-leaderDone
+		for i := 0; !other.IsLeader(); i++ {
+			if i == 50 {
+				t.Fatalf("other instance did not take over")
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+		otherCancel()
+	})
+
+	t.Run("ServerWebhooks", func(t *testing.T) {
+		type webhookReq struct {
+			Event, Signature string
+			Body             []byte
+		}
+		webhookReqs := make(chan webhookReq, 8)
+		webhookSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			buf, _ := io.ReadAll(r.Body)
+			webhookReqs <- webhookReq{r.Header.Get("X-Atlas-Event"), r.Header.Get("X-Atlas-Signature"), buf}
+		}))
+		defer webhookSrv.Close()
+		a.API0.ServerWebhookClient = webhookSrv.Client()
+
+		outboxCtx, outboxCancel := context.WithCancel(ctx)
+		defer outboxCancel()
+		go a.API0.RunOutbox(outboxCtx)
+
+		webhookGameSrv := a.startServer(t, "webhook server", nil)
+		webhookPath := "/server/webhook?addr=" + webhookGameSrv.GameAddr().String() + "&token=" + webhookGameSrv.ServerAuthToken()
+		if st := a.do(t, http.MethodPut, webhookPath+"&url=http://example.com", nil, false, nil); st != http.StatusBadRequest {
+			t.Errorf("set insecure webhook: expected status 400, got %d", st)
+		}
+		if st := a.do(t, http.MethodPut, "/server/webhook?addr="+webhookGameSrv.GameAddr().String()+"&token=wrong&url="+webhookSrv.URL, nil, false, nil); st != http.StatusForbidden {
+			t.Errorf("set webhook with wrong token: expected status 403, got %d", st)
+		}
+		var webhookRes struct {
+			Secret string `json:"secret"`
+		}
+		if st := a.do(t, http.MethodPut, webhookPath+"&url="+webhookSrv.URL, nil, false, &webhookRes); st != http.StatusOK || webhookRes.Secret == "" {
+			t.Fatalf("set webhook: status %d, secret %q", st, webhookRes.Secret)
+		}
+		var webhookGet struct {
+			Webhook struct {
+				URL string `json:"url"`
+			} `json:"webhook"`
+			Secret string `json:"secret"`
+		}
+		if st := a.do(t, http.MethodGet, webhookPath, nil, false, &webhookGet); st != http.StatusOK || webhookGet.Webhook.URL != webhookSrv.URL || webhookGet.Secret != "" {
+			t.Errorf("get webhook: status %d, incorrect response %+v", st, webhookGet)
+		}
+
+		denyServers := api0.NetworkRules{
+			Rules:     []api0.NetworkRule{{Action: api0.NetworkRuleDeny, Scope: api0.NetworkRuleScopeServer, Countries: []string{"*"}}},
+			Overrides: []api0.NetworkOverride{},
+		}
+		if st := a.do(t, http.MethodPut, "/admin/netrules", denyServers, true, nil); st != http.StatusOK {
+			t.Fatalf("put network rules: status %d", st)
+		}
+		if err := webhookGameSrv.Register(ctx); err == nil {
+			t.Errorf("expected registration to be blocked")
+		}
+		if st := a.do(t, http.MethodDelete, "/admin/netrules", nil, true, nil); st != http.StatusOK {
+			t.Fatalf("delete network rules: status %d", st)
+		}
+		select {
+		case req := <-webhookReqs:
+			var ev api0.ServerWebhookEvent
+			if err := json.Unmarshal(req.Body, &ev); err != nil {
+				t.Errorf("decode webhook: %v", err)
+			} else if req.Event != "banned" || ev.Type != api0.ServerWebhookBanned || ev.Addr != webhookGameSrv.GameAddr() {
+				t.Errorf("incorrect webhook %s %+v", req.Event, ev)
+			}
+			key, _ := hex.DecodeString(webhookRes.Secret)
+			ts, sig, _ := strings.Cut(strings.TrimPrefix(req.Signature, "t="), ",v1=")
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(ts + "."))
+			mac.Write(req.Body)
+			if hex.EncodeToString(mac.Sum(nil)) != sig {
+				t.Errorf("incorrect webhook signature %q", req.Signature)
+			}
+		case <-time.After(time.Second * 5):
+			t.Errorf("banned webhook not received")
+		}
+		for i := 0; ; i++ {
+			var buf bytes.Buffer
+			a.API0.WritePrometheus(&buf)
+			if strings.Contains(buf.String(), `atlas_outbox_items_total{queue="server_webhooks",result="delivered"} 1`) {
+				break
+			}
+			if i == 100 {
+				t.Errorf("banned webhook not delivered through the outbox")
+				break
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+
+		if st := a.do(t, http.MethodDelete, webhookPath, nil, false, nil); st != http.StatusOK {
+			t.Errorf("delete webhook: status %d", st)
+		}
+		if err := webhookGameSrv.Remove(ctx); err != nil {
+			t.Errorf("remove server: %v", err)
+		}
+	})
+
+	t.Run("Captcha", func(t *testing.T) {
+		captchaSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			json.NewEncoder(w).Encode(map[string]any{
+				"success": r.PostForm.Get("secret") == "e2e-captcha-secret" && r.PostForm.Get("response") == "solved",
+			})
+		}))
+		defer captchaSrv.Close()
+		a.API0.Captcha.VerifyURL = captchaSrv.URL
+
+		captchaGet := func(origin bool, clearance string) (int, string) {
+			req, err := http.NewRequest(http.MethodGet, a.URL+"/client/servers", nil)
+			if err != nil {
+				t.Fatalf("create request: %v", err)
+			}
+			req.Header.Set("User-Agent", userAgent)
+			if origin {
+				req.Header.Set("Origin", "https://browser.example.com")
+			}
+			if clearance != "" {
+				req.Header.Set("X-Atlas-Captcha-Clearance", clearance)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("get servers: %v", err)
+			}
+			defer resp.Body.Close()
+			buf, _ := io.ReadAll(resp.Body)
+			return resp.StatusCode, string(buf)
+		}
+		if st, _ := captchaGet(true, ""); st != http.StatusOK {
+			t.Errorf("expected no captcha before being penalized, got status %d", st)
+		}
+		for i := 0; ; i++ {
+			if st, _ := captchaGet(true, ""); st == http.StatusForbidden {
+				break
+			}
+			if i == 100 {
+				t.Fatalf("ip was not penalized")
+			}
+			a.authWithServer(t, player1, "wrong", communitySrv)
+		}
+		if st, _ := captchaGet(false, ""); st != http.StatusOK {
+			t.Errorf("expected no captcha for non-browser requests, got status %d", st)
+		}
+		if st, body := captchaGet(true, ""); st != http.StatusForbidden || !strings.Contains(body, "CAPTCHA_REQUIRED") || !strings.Contains(body, "e2e-sitekey") {
+			t.Errorf("expected captcha to be required, got status %d: %s", st, body)
+		}
+		if st := a.do(t, http.MethodPost, "/client/captcha?response=wrong", nil, false, nil); st != http.StatusBadRequest {
+			t.Errorf("submit incorrect captcha: expected status 400, got %d", st)
+		}
+		var captchaRes struct {
+			Clearance string `json:"clearance"`
+		}
+		if st := a.do(t, http.MethodPost, "/client/captcha?response=solved", nil, false, &captchaRes); st != http.StatusOK || captchaRes.Clearance == "" {
+			t.Fatalf("submit captcha: status %d, clearance %q", st, captchaRes.Clearance)
+		}
+		if st, body := captchaGet(true, captchaRes.Clearance); st != http.StatusOK {
+			t.Errorf("expected clearance to be accepted, got status %d: %s", st, body)
+		}
+		if st := a.do(t, http.MethodDelete, "/admin/anomalies", nil, true, nil); st != http.StatusOK {
+			t.Errorf("clear anomalies: status %d", st)
+		}
+		if st, _ := captchaGet(true, ""); st != http.StatusOK {
+			t.Errorf("expected no captcha after clearing penalties, got status %d", st)
+		}
+	})
+
+	t.Run("PlayerQuotas", func(t *testing.T) {
+		if !a.authWithServer(t, player1, token1, communitySrv) {
+			t.Fatalf("player 1 failed to authenticate with the community server")
+		}
+		a.API0.PlayerQuotas.PdataWrite = api0.PlayerQuota{PerHour: 1, Burst: 2}
+		for i := 0; i < 2; i++ {
+			if err := communitySrv.PatchPersistence(ctx, player1, map[string]any{"xp": 23456}); err != nil {
+				t.Fatalf("patch persistence within quota: %v", err)
+			}
+		}
+		if err := communitySrv.PatchPersistence(ctx, player1, map[string]any{"xp": 23456}); err == nil || !strings.Contains(err.Error(), "QUOTA_EXCEEDED") {
+			t.Errorf("expected pdata write quota to be exceeded, got %v", err)
+		}
+		a.API0.PlayerQuotas.PdataWrite = api0.PlayerQuota{}
+	})
+
+	t.Run("AccountLookup", func(t *testing.T) {
+		var accountsRes struct {
+			Accounts []struct {
+				UID      string `json:"uid"`
+				Found    bool   `json:"found"`
+				Sessions int    `json:"sessions"`
+			} `json:"accounts"`
+		}
+		if st := a.do(t, http.MethodPost, "/admin/accounts", map[string]any{"uids": []uint64{player1, 999, player1}}, true, &accountsRes); st != http.StatusOK {
+			t.Errorf("account lookup: status %d", st)
+		} else if len(accountsRes.Accounts) != 2 || accountsRes.Accounts[0].UID != strconv.FormatUint(player1, 10) || !accountsRes.Accounts[0].Found || accountsRes.Accounts[0].Sessions == 0 || accountsRes.Accounts[1].Found {
+			t.Errorf("incorrect account lookup %+v", accountsRes.Accounts)
+		}
+		if st := a.do(t, http.MethodPost, "/admin/accounts", map[string]any{"uids": []uint64{}}, true, nil); st != http.StatusBadRequest {
+			t.Errorf("account lookup without uids: expected status 400, got %d", st)
+		}
+		if st := a.do(t, http.MethodPost, "/admin/accounts", map[string]any{"uids": []uint64{player1}}, false, nil); st != http.StatusUnauthorized && st != http.StatusForbidden {
+			t.Errorf("account lookup without admin: expected it to be rejected, got status %d", st)
+		}
+	})
+
+	t.Run("FeatureFlags", func(t *testing.T) {
+		if st := a.do(t, http.MethodPut, "/admin/features?name=pdata_patch", map[string]any{"percent": 0}, true, nil); st != http.StatusOK {
+			t.Errorf("disable pdata patches: status %d", st)
+		}
+		if err := communitySrv.PatchPersistence(ctx, player1, map[string]any{"xp": 23456}); err == nil || !strings.Contains(err.Error(), "not enabled") {
+			t.Errorf("expected pdata patch to be rejected while disabled, got %v", err)
+		}
+		if st := a.do(t, http.MethodPut, "/admin/features?name=pdata_patch", map[string]any{"percent": 0, "uids": []uint64{player1}}, true, nil); st != http.StatusOK {
+			t.Errorf("enable pdata patches for player: status %d", st)
+		}
+		if err := communitySrv.PatchPersistence(ctx, player1, map[string]any{"xp": 23456}); err != nil {
+			t.Errorf("expected pdata patch to be allowed for player, got %v", err)
+		}
+		var featuresRes struct {
+			Features []struct {
+				Name    string `json:"name"`
+				Source  string `json:"source"`
+				Enabled *bool  `json:"enabled"`
+			} `json:"features"`
+		}
+		if st := a.do(t, http.MethodGet, "/admin/features?uid=999", nil, true, &featuresRes); st != http.StatusOK {
+			t.Errorf("get feature flags: status %d", st)
+		} else {
+			for _, f := range featuresRes.Features {
+				if f.Enabled == nil {
+					t.Errorf("expected feature %s to be evaluated for uid", f.Name)
+				} else if exp := f.Name != "pdata_patch" && f.Name != "serverlist_canary"; *f.Enabled != exp || (f.Name == "pdata_patch") != (f.Source == "admin") {
+					t.Errorf("incorrect feature %s (source %q, enabled %t)", f.Name, f.Source, *f.Enabled)
+				}
+			}
+		}
+		if st := a.do(t, http.MethodGet, "/isolated/admin/features?name=pdata_patch&uid=999", nil, true, &featuresRes); st != http.StatusOK {
+			t.Errorf("get tenant feature flags: status %d", st)
+		} else if len(featuresRes.Features) != 1 || featuresRes.Features[0].Source != "" || !*featuresRes.Features[0].Enabled {
+			t.Errorf("expected tenant feature flags to be independent, got %+v", featuresRes.Features)
+		}
+		if st := a.do(t, http.MethodPut, "/admin/features?name=nonexistent", map[string]any{"percent": 100}, true, nil); st != http.StatusBadRequest {
+			t.Errorf("set unknown feature: expected status 400, got %d", st)
+		}
+		if st := a.do(t, http.MethodDelete, "/admin/features?name=pdata_patch", nil, true, nil); st != http.StatusOK {
+			t.Errorf("reset pdata patches: status %d", st)
+		}
+	})
+
+	t.Run("ServerListCanary", func(t *testing.T) {
+		serverListVariant := func() (string, int) {
+			resp, err := http.Get(a.URL + "/client/servers")
+			if err != nil {
+				t.Errorf("list servers: %v", err)
+				return "", 0
+			}
+			defer resp.Body.Close()
+			var ss []json.RawMessage
+			if err := json.NewDecoder(resp.Body).Decode(&ss); err != nil {
+				t.Errorf("list servers: %v", err)
+			}
+			return resp.Header.Get("X-Atlas-Variant"), len(ss)
+		}
+		controlVariant, controlServers := serverListVariant()
+		if controlVariant != "control" {
+			t.Errorf("expected control server list variant by default, got %q", controlVariant)
+		}
+		if st := a.do(t, http.MethodPut, "/admin/features?name=serverlist_canary", map[string]any{"percent": 100}, true, nil); st != http.StatusOK {
+			t.Errorf("enable server list canary: status %d", st)
+		}
+		if v, n := serverListVariant(); v != "canary" || n != controlServers {
+			t.Errorf("expected canary server list variant with %d servers, got %q with %d", controlServers, v, n)
+		}
+		if st := a.do(t, http.MethodDelete, "/admin/features?name=serverlist_canary", nil, true, nil); st != http.StatusOK {
+			t.Errorf("reset server list canary: status %d", st)
+		}
+	})
+
+	t.Run("APIVersions", func(t *testing.T) {
+		getVersioned := func(path string, res any) *http.Response {
+			resp, err := http.Get(a.URL + path)
+			if err != nil {
+				t.Errorf("get %s: %v", path, err)
+				return nil
+			}
+			defer resp.Body.Close()
+			if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+				t.Errorf("get %s: decode response: %v", path, err)
+			}
+			return resp
+		}
+		var v1Servers []map[string]any
+		if resp := getVersioned("/v1/client/servers", &v1Servers); resp != nil {
+			if v := resp.Header.Get("X-Atlas-API-Version"); v != "1" {
+				t.Errorf("v1: incorrect api version %q", v)
+			}
+			if v := resp.Header.Get("Sunset"); v != "Tue, 01 Jan 2030 00:00:00 GMT" {
+				t.Errorf("v1: incorrect sunset %q", v)
+			}
+			if v := resp.Header.Get("Link"); v != `</v2/client/servers>; rel="successor-version"` {
+				t.Errorf("v1: incorrect successor link %q", v)
+			}
+		}
+		var unversionedServers []map[string]any
+		if resp := getVersioned("/isolated/client/servers", &unversionedServers); resp != nil {
+			if v := resp.Header.Get("Link"); v != `</isolated/v2/client/servers>; rel="successor-version"` {
+				t.Errorf("unversioned: incorrect successor link %q", v)
+			}
+		}
+		var v2Servers struct {
+			Items      []map[string]any `json:"items"`
+			Total      int              `json:"total"`
+			NextCursor string           `json:"next_cursor"`
+		}
+		if resp := getVersioned("/v2/client/servers?limit=1", &v2Servers); resp != nil {
+			if v := resp.Header.Get("Sunset"); v != "" {
+				t.Errorf("v2: unexpected sunset %q", v)
+			}
+			if v2Servers.Total != len(v1Servers) || len(v2Servers.Items) != 1 || (v2Servers.Total > 1) != (v2Servers.NextCursor != "") {
+				t.Errorf("v2: incorrect page %+v for %d servers", v2Servers, len(v1Servers))
+			} else if _, ok := v2Servers.Items[0]["player_count"]; !ok {
+				t.Errorf("v2: expected snake_case keys, got %v", v2Servers.Items[0])
+			}
+		}
+		for path, exp := range map[string]string{
+			"/v2/client/servers?limit=0":       "BAD_REQUEST",
+			"/v2/accounts/get_username?uid=xx": "PLAYER_NOT_FOUND",
+			"/v2/client/nonexistent":           "NOT_FOUND",
+		} {
+			var res map[string]any
+			getVersioned(path, &res)
+			if e, _ := res["error"].(map[string]any); e == nil || e["code"] != exp || e["status"] == nil {
+				t.Errorf("%s: expected typed %s error, got %v", path, exp, res)
+			} else if _, ok := res["success"]; ok {
+				t.Errorf("%s: unexpected success field in v2 response", path)
+			}
+		}
+	})
+
+	t.Run("ServerDelists", func(t *testing.T) {
+		delistSrv := a.startServer(t, "delisted server", nil)
+		delistAddr := delistSrv.GameAddr().String()
+		delistState := func() string {
+			var res struct {
+				Delists []struct {
+					Addr  string `json:"addr"`
+					State string `json:"state"`
+				} `json:"delists"`
+			}
+			if st := a.do(t, http.MethodGet, "/admin/serverdelists", nil, true, &res); st != http.StatusOK {
+				t.Errorf("get server delists: status %d", st)
+			}
+			for _, d := range res.Delists {
+				if d.Addr == delistAddr {
+					return d.State
+				}
+			}
+			return ""
+		}
+		delistListed := func() bool {
+			var ss []struct {
+				ID string `json:"id"`
+			}
+			a.do(t, http.MethodGet, "/client/servers", nil, false, &ss)
+			for _, s := range ss {
+				if s.ID == delistSrv.ID() {
+					return true
+				}
+			}
+			return false
+		}
+		for _, exp := range []string{"warned", "delisted"} {
+			if st := a.do(t, http.MethodPost, "/admin/serverdelists", map[string]any{"addr": delistAddr, "reason": "spamming chat"}, true, nil); st != http.StatusOK {
+				t.Errorf("escalate server delist: status %d", st)
+			} else if v := delistState(); v != exp {
+				t.Errorf("expected server delist to be escalated to %s, got %q", exp, v)
+			}
+		}
+		if delistListed() {
+			t.Errorf("expected delisted server to be removed from the server list")
+		}
+		if err := delistSrv.Register(ctx); err != nil {
+			t.Errorf("expected delisted server to be able to re-register: %v", err)
+		} else if delistListed() {
+			t.Errorf("expected re-registered delisted server to be unlisted")
+		}
+		var delistStatusRes struct {
+			Delist struct {
+				State  string `json:"state"`
+				Reason string `json:"reason"`
+				Until  string `json:"until"`
+			} `json:"delist"`
+		}
+		if st := a.do(t, http.MethodGet, "/server/owner_status?addr="+delistAddr, nil, false, &delistStatusRes); st != http.StatusOK {
+			t.Errorf("delisted server owner status: status %d", st)
+		} else if d := delistStatusRes.Delist; d.State != "delisted" || d.Reason != "spamming chat" || d.Until == "" {
+			t.Errorf("incorrect delisted server owner status %+v", d)
+		}
+		var appealRes struct {
+			ID string `json:"id"`
+		}
+		if st := a.do(t, http.MethodPost, "/server/delist_appeal?addr="+delistAddr+"&message=it+was+a+bot&contact=owner", nil, false, &appealRes); st != http.StatusOK || appealRes.ID == "" {
+			t.Errorf("delist appeal: status %d, id %q", st, appealRes.ID)
+		} else {
+			var reportsRes struct {
+				Reports []struct {
+					ID         string            `json:"id"`
+					Reason     string            `json:"reason"`
+					ServerAddr string            `json:"server_addr"`
+					Context    map[string]string `json:"context"`
+				} `json:"reports"`
+			}
+			var found bool
+			if st := a.do(t, http.MethodGet, "/admin/reports?limit=1000", nil, true, &reportsRes); st != http.StatusOK {
+				t.Errorf("get reports: status %d", st)
+			}
+			for _, rp := range reportsRes.Reports {
+				if rp.ID == appealRes.ID {
+					found = true
+					if rp.Reason != "delist_appeal" || rp.ServerAddr != delistAddr || rp.Context["delist_state"] != "delisted" || rp.Context["contact"] != "owner" {
+						t.Errorf("incorrect delist appeal report %+v", rp)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("expected delist appeal %s in the moderation queue", appealRes.ID)
+			}
+		}
+		if st := a.do(t, http.MethodPost, "/server/delist_appeal?addr="+delistAddr+"&message=again", nil, false, nil); st != http.StatusConflict {
+			t.Errorf("duplicate delist appeal: expected status 409, got %d", st)
+		}
+		if st := a.do(t, http.MethodPost, "/admin/serverdelists", map[string]any{"addr": delistAddr, "state": "banned", "reason": "repeat offender"}, true, nil); st != http.StatusOK {
+			t.Errorf("ban server: status %d", st)
+		}
+		if err := delistSrv.Register(ctx); err == nil || !strings.Contains(err.Error(), "repeat offender") {
+			t.Errorf("expected banned server registration to be rejected with the reason, got %v", err)
+		}
+		if st := a.do(t, http.MethodPost, "/admin/serverdelists", map[string]any{"addr": "invalid", "reason": "x"}, true, nil); st != http.StatusBadRequest {
+			t.Errorf("delist invalid addr: expected status 400, got %d", st)
+		}
+		if st := a.do(t, http.MethodDelete, "/admin/serverdelists?addr="+delistAddr, nil, true, nil); st != http.StatusOK {
+			t.Errorf("reinstate server: status %d", st)
+		} else if v := delistState(); v != "" {
+			t.Errorf("expected server to be reinstated, got %q", v)
+		}
+		if err := delistSrv.Register(ctx); err != nil {
+			t.Errorf("expected reinstated server to be able to register: %v", err)
+		} else if !delistListed() {
+			t.Errorf("expected reinstated server to be listed")
+		}
+		if st := a.do(t, http.MethodPost, "/server/delist_appeal?addr="+delistAddr+"&message=x", nil, false, nil); st != http.StatusBadRequest {
+			t.Errorf("appeal for reinstated server: expected status 400, got %d", st)
+		}
+	})
+
+	t.Run("Honeypot", func(t *testing.T) {
+		if st := a.do(t, http.MethodGet, "/client/servers?debug=1", nil, false, nil); st != http.StatusOK {
+			t.Errorf("server list with decoy param: expected it to be handled normally, got status %d", st)
+		}
+		if st := a.do(t, http.MethodGet, "/.env", nil, false, nil); st != http.StatusNotFound {
+			t.Errorf("decoy path: expected status 404, got %d", st)
+		}
+		var honeypotRes struct {
+			Tags []struct {
+				Prefix string `json:"prefix"`
+				Hits   int    `json:"hits"`
+				Last   string `json:"last"`
+			} `json:"tags"`
+		}
+		if st := a.do(t, http.MethodGet, "/admin/honeypot", nil, true, &honeypotRes); st != http.StatusOK {
+			t.Errorf("get honeypot tags: status %d", st)
+		} else if len(honeypotRes.Tags) != 1 || honeypotRes.Tags[0].Prefix != "127.0.0.1/32" || honeypotRes.Tags[0].Hits != 2 || honeypotRes.Tags[0].Last != "path /.env" {
+			t.Errorf("incorrect honeypot tags %+v", honeypotRes.Tags)
+		}
+		var honeypotAnomalies struct {
+			Penalties []struct {
+				Prefix string `json:"prefix"`
+				Block  bool   `json:"block"`
+				Reason string `json:"reason"`
+			} `json:"penalties"`
+		}
+		if st := a.do(t, http.MethodGet, "/admin/anomalies", nil, true, &honeypotAnomalies); st != http.StatusOK {
+			t.Errorf("get anomalies: status %d", st)
+		} else if p := honeypotAnomalies.Penalties; len(p) != 1 || p[0].Prefix != "127.0.0.1/32" || !p[0].Block || !strings.HasPrefix(p[0].Reason, "honeypot") {
+			t.Errorf("expected honeypot offender to be blocked by the anomaly detector, got %+v", p)
+		}
+		if st := a.do(t, http.MethodDelete, "/admin/anomalies?prefix=127.0.0.1/32", nil, true, nil); st != http.StatusOK {
+			t.Errorf("clear anomalies: status %d", st)
+		}
+		if st := a.do(t, http.MethodDelete, "/admin/honeypot?prefix=127.0.0.1/32", nil, true, nil); st != http.StatusOK {
+			t.Errorf("clear honeypot tags: status %d", st)
+		}
+		if st := a.do(t, http.MethodGet, "/admin/honeypot", nil, true, &honeypotRes); st != http.StatusOK || len(honeypotRes.Tags) != 0 {
+			t.Errorf("expected honeypot tags to be cleared, got status %d with %+v", st, honeypotRes.Tags)
+		}
+	})
+
+	t.Run("ServerSigning", func(t *testing.T) {
+		signedSrv := &fakeserver.Server{
+			MasterServer: a.URL,
+			Info:         fakeserver.Info{Name: "signed server", Map: "mp_glitch", Playlist: "aitdm", MaxPlayers: 16},
+			Signing:      "required",
+		}
+		if err := signedSrv.Listen(netip.MustParseAddr("127.0.0.1")); err != nil {
+			t.Fatalf("listen game server: %v", err)
+		}
+		t.Cleanup(func() { signedSrv.Close() })
+		if err := signedSrv.Register(ctx); err != nil {
+			t.Errorf("register signed server: %v", err)
+		} else if signedSrv.SigningKey() == "" {
+			t.Errorf("expected a signing key to be issued")
+		} else {
+			if err := signedSrv.Heartbeat(ctx); err != nil {
+				t.Errorf("signed heartbeat: %v", err)
+			}
+			if st := a.do(t, http.MethodPost, "/server/heartbeat?id="+signedSrv.ID()+"&playerCount=0", nil, false, nil); st != http.StatusUnauthorized {
+				t.Errorf("unsigned heartbeat for server requiring signing: expected status 401, got %d", st)
+			}
+			if err := signedSrv.Remove(ctx); err != nil {
+				t.Errorf("remove signed server: %v", err)
+			}
+		}
+		if st := a.do(t, http.MethodPost, "/server/add_server?port=1&authPort=2&name=x&signing=maybe", nil, false, nil); st != http.StatusBadRequest {
+			t.Errorf("add server with invalid signing param: expected status 400, got %d", st)
+		}
+	})
+
+	t.Run("Tracing", func(t *testing.T) {
+		var traceBody bytes.Buffer
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(&traceBody, r.Body) // requests are sent sequentially
+		}))
+		a.Tracing.URL = collector.URL
+		{
+			const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+			req, _ := http.NewRequest(http.MethodPost, a.URL+"/client/auth_with_server?id="+strconv.FormatUint(player1, 10)+"&server="+communitySrv.ID()+"&playerToken="+token1, nil)
+			req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+			if resp, err := http.DefaultClient.Do(req); err != nil {
+				t.Errorf("traced auth with server: %v", err)
+			} else {
+				resp.Body.Close()
+				if v := resp.Header.Get("traceparent"); !strings.HasPrefix(v, "00-"+traceID+"-") {
+					t.Errorf("incorrect response traceparent %q", v)
+				}
+			}
+			traceCtx, traceCancel := context.WithCancel(ctx)
+			traceCancel()
+			a.Tracing.Run(traceCtx) // flush
+			for _, x := range []string{
+				`"traceId":"` + traceID + `"`,
+				`"name":"POST /client/auth_with_server"`,
+				`"name":"storage.GetAccount"`,
+				`"name":"gameserver.AuthenticateIncomingPlayer"`,
+			} {
+				if !bytes.Contains(traceBody.Bytes(), []byte(x)) {
+					t.Errorf("expected %s in exported spans", x)
+				}
+			}
+		}
+		collector.Close()
+	})
+
+	t.Run("SLO", func(t *testing.T) {
+		{
+			var buf bytes.Buffer
+			a.SLO.WritePrometheus(&buf)
+			if !strings.Contains(buf.String(), `atlas_slo_requests_total{objective="auth",result="good"} `) || strings.Contains(buf.String(), `atlas_slo_requests_total{objective="auth",result="good"} 0`+"\n") {
+				t.Errorf("expected auth requests to be tracked:\n%s", buf.String())
+			}
+		}
+	})
+
+	t.Run("AccessLog", func(t *testing.T) {
+		if buf, err := os.ReadFile(filepath.Join(dir, "access.log")); err != nil {
+			t.Errorf("read access log: %v", err)
+		} else {
+			if !bytes.Contains(buf, []byte(`"request_uri":"/client/auth_with_server?id=`)) || !bytes.Contains(buf, []byte(`playerToken=REDACTED`)) {
+				t.Errorf("expected redacted auth requests in access log")
+			}
+			if bytes.Contains(buf, []byte(token1)) {
+				t.Errorf("expected player token to be redacted from access log")
+			}
+			if !bytes.Contains(buf, []byte(`"request_ip":"127.0.0.0"`)) {
+				t.Errorf("expected truncated ips in access log")
+			}
+			for _, line := range bytes.Split(buf, []byte{'\n'}) {
+				if bytes.Contains(line, []byte(`"request_uri":"/client/servers"`)) && bytes.Contains(line, []byte(`"response_status":200`)) {
+					t.Errorf("expected successful server list requests to be sampled out of access log")
+					break
+				}
+			}
+		}
+	})
+
+	t.Run("Teardown", func(t *testing.T) {
+		if err := communitySrv.Remove(ctx); err != nil {
+			t.Errorf("remove server: %v", err)
+		}
+		if status := a.do(t, http.MethodGet, "/client/servers", nil, false, &servers); status != http.StatusOK {
+			t.Fatalf("list servers: status %d", status)
+		}
+		for _, s := range servers {
+			if s.Name == "community server" {
+				t.Errorf("removed server still listed")
+			}
+		}
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		// note: Run isn't used here, so the snapshot written on shutdown is
+		// simulated
+		if f, err := os.Create(filepath.Join(dir, "servers.snapshot")); err != nil {
+			t.Fatalf("create snapshot: %v", err)
+		} else if n, err := a.API0.ServerList.WriteSnapshot(f); err != nil || n == 0 {
+			t.Fatalf("write snapshot: %d servers, err %v", n, err)
+		} else {
+			f.Close()
+		}
+
+		a.Stop()
+		b := startAtlas(t, dir)
+		checkXp(t, b, 23456)
+
+		if status := b.do(t, http.MethodGet, "/client/servers", nil, false, &servers); status != http.StatusOK {
+			t.Fatalf("list servers after restart: status %d", status)
+		}
+		var restored bool
+		for _, s := range servers {
+			if s.Name == "subscriber server" && s.ID == subscriberSrv.ID() {
+				restored = true
+			}
+		}
+		if !restored {
+			t.Errorf("expected servers to be restored from snapshot after restart")
+		}
+
+		if _, ok := b.originAuth(t, player1, "valid-"+strconv.FormatUint(player1, 10)); !ok {
+			t.Errorf("origin auth failed after restart")
+		}
+	})
+}
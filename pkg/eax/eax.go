@@ -11,6 +11,8 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+
+	"github.com/r2northstar/atlas/pkg/trace"
 )
 
 type Client struct {
@@ -116,7 +118,13 @@ func (c *Client) gql1(ctx context.Context, ver bool, query string, out any) erro
 	return nil
 }
 
-func (c *Client) do(r *http.Request) (*http.Response, error) {
+func (c *Client) do(r *http.Request) (resp *http.Response, err error) {
+	_, span := trace.StartClient(r.Context(), "eax "+r.URL.Path)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
 	if c.Client == nil {
 		return http.DefaultClient.Do(r)
 	}
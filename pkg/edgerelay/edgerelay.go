@@ -0,0 +1,227 @@
+// Package edgerelay implements a regional relay which mirrors the server list
+// streamed from a primary Atlas instance and serves it locally.
+package edgerelay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/websocket"
+	"github.com/rs/zerolog"
+)
+
+// Relay mirrors the server list from a primary Atlas instance. It serves
+// /client/servers from the latest list, and redirects all other requests to
+// the primary. It is safe for concurrent use.
+type Relay struct {
+	// Primary is the base URL of the primary Atlas instance (e.g.,
+	// https://northstar.tf). It is required.
+	Primary string
+
+	// ID is the relay ID registered with the primary. It is required.
+	ID string
+
+	// Token is the token returned when the relay was registered with the
+	// primary. It is required.
+	Token string
+
+	// MaxAge is the maximum age of the server list before /client/servers
+	// requests are redirected to the primary instead. If zero, it defaults to
+	// 30 seconds.
+	MaxAge time.Duration
+
+	// TLSConfig, if provided, is used for connections to the primary (e.g.,
+	// to present a client certificate for mTLS).
+	TLSConfig *tls.Config
+
+	// Logger is used for connection errors.
+	Logger zerolog.Logger
+
+	list atomic.Pointer[serverList]
+
+	metrics struct {
+		connects_total   atomic.Uint64
+		disconnects      atomic.Uint64
+		updates_total    atomic.Uint64
+		update_err_total atomic.Uint64
+		served_total     atomic.Uint64
+		redirected_total atomic.Uint64
+	}
+}
+
+type serverList struct {
+	json    []byte
+	gzip    []byte
+	updated time.Time
+}
+
+// Run connects to the primary and receives server list updates until ctx is
+// canceled, reconnecting with a backoff if the connection fails.
+func (r *Relay) Run(ctx context.Context) error {
+	if r.Primary == "" || r.ID == "" || r.Token == "" {
+		return errors.New("primary, id, and token are required")
+	}
+	u, err := url.Parse(strings.TrimSuffix(r.Primary, "/") + "/relay/stream")
+	if err != nil {
+		return fmt.Errorf("parse primary url: %w", err)
+	}
+	u.RawQuery = url.Values{"id": {r.ID}}.Encode()
+
+	var backoff time.Duration
+	for {
+		start := time.Now()
+		err := r.stream(ctx, u.String())
+		if ctx.Err() != nil {
+			return nil
+		}
+		r.metrics.disconnects.Add(1)
+
+		// reset the backoff if the connection was up for a while
+		if time.Since(start) > time.Minute {
+			backoff = 0
+		}
+		if backoff += time.Second; backoff > time.Second*30 {
+			backoff = time.Second * 30
+		}
+		r.Logger.Warn().Err(err).Msgf("disconnected from primary, reconnecting in %s", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (r *Relay) stream(ctx context.Context, u string) error {
+	cl := http.DefaultClient
+	if r.TLSConfig != nil {
+		cl = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: r.TLSConfig,
+		}}
+		defer cl.CloseIdleConnections()
+	}
+	c, err := websocket.DialClient(ctx, cl, u, http.Header{
+		"Authorization": {"Bearer " + r.Token},
+		"User-Agent":    {"Atlas (edge relay)"},
+	})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	r.metrics.connects_total.Add(1)
+	r.Logger.Info().Msg("connected to primary")
+
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+
+	for {
+		op, buf, err := c.Read()
+		if err != nil {
+			return err
+		}
+		if op != websocket.OpBinary {
+			continue
+		}
+		if err := r.update(buf); err != nil {
+			r.metrics.update_err_total.Add(1)
+			r.Logger.Error().Err(err).Msg("failed to apply server list update")
+			continue
+		}
+		r.metrics.updates_total.Add(1)
+	}
+}
+
+// update replaces the server list with the gzipped JSON in zbuf.
+func (r *Relay) update(zbuf []byte) error {
+	zr, err := gzip.NewReader(bytes.NewReader(zbuf))
+	if err != nil {
+		return err
+	}
+	buf, err := io.ReadAll(zr)
+	if err != nil {
+		return err
+	}
+	if len(buf) == 0 || buf[0] != '[' {
+		return errors.New("not a server list")
+	}
+	r.list.Store(&serverList{
+		json:    buf,
+		gzip:    zbuf,
+		updated: time.Now(),
+	})
+	return nil
+}
+
+func (r *Relay) maxAge() time.Duration {
+	if r.MaxAge > 0 {
+		return r.MaxAge
+	}
+	return time.Second * 30
+}
+
+// ServeHTTP serves the server list, redirecting other requests to the
+// primary.
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// authenticated requests and msgpack lists are generated per-request by
+	// the primary
+	if req.URL.Path == "/client/servers" && (req.Method == http.MethodGet || req.Method == http.MethodHead) && !req.URL.Query().Has("id") && !strings.Contains(req.Header.Get("Accept"), "msgpack") {
+		if l := r.list.Load(); l != nil && time.Since(l.updated) < r.maxAge() {
+			r.metrics.served_total.Add(1)
+
+			w.Header().Set("Cache-Control", "private, no-cache, no-store")
+			w.Header().Set("Expires", "0")
+			w.Header().Set("Pragma", "no-cache")
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("Vary", "Accept, Accept-Encoding")
+
+			buf := l.json
+			for _, e := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+				if t, _, _ := strings.Cut(e, ";"); strings.TrimSpace(t) == "gzip" {
+					w.Header().Set("Content-Encoding", "gzip")
+					buf = l.gzip
+					break
+				}
+			}
+			w.WriteHeader(http.StatusOK)
+			if req.Method != http.MethodHead {
+				w.Write(buf)
+			}
+			return
+		}
+	}
+
+	// redirect rather than proxying so the primary sees the real client ip
+	r.metrics.redirected_total.Add(1)
+	http.Redirect(w, req, strings.TrimSuffix(r.Primary, "/")+req.URL.RequestURI(), http.StatusTemporaryRedirect)
+}
+
+// WritePrometheus writes prometheus text metrics to w.
+func (r *Relay) WritePrometheus(w io.Writer) {
+	var age float64
+	if l := r.list.Load(); l != nil {
+		age = time.Since(l.updated).Seconds()
+	}
+	fmt.Fprintln(w, `atlas_edgerelay_connects_total`, r.metrics.connects_total.Load())
+	fmt.Fprintln(w, `atlas_edgerelay_disconnects_total`, r.metrics.disconnects.Load())
+	fmt.Fprintln(w, `atlas_edgerelay_updates_total{result="success"}`, r.metrics.updates_total.Load())
+	fmt.Fprintln(w, `atlas_edgerelay_updates_total{result="fail_decode"}`, r.metrics.update_err_total.Load())
+	fmt.Fprintln(w, `atlas_edgerelay_requests_total{result="served"}`, r.metrics.served_total.Load())
+	fmt.Fprintln(w, `atlas_edgerelay_requests_total{result="redirected"}`, r.metrics.redirected_total.Load())
+	fmt.Fprintln(w, `atlas_edgerelay_list_age_seconds`, age)
+}
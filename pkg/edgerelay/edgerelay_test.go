@@ -0,0 +1,61 @@
+package edgerelay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRelayServeHTTP(t *testing.T) {
+	r := &Relay{
+		Primary: "https://primary.example.com/",
+		MaxAge:  time.Minute,
+	}
+
+	get := func(path string, hdr ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("/client/servers"); w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "https://primary.example.com/client/servers" {
+		t.Errorf("expected redirect to primary without a list, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	var zb bytes.Buffer
+	zw := gzip.NewWriter(&zb)
+	zw.Write([]byte(`[{"name":"test"}]`))
+	zw.Close()
+
+	if err := r.update([]byte("not gzip")); err == nil {
+		t.Errorf("expected error for invalid update")
+	}
+	if err := r.update(zb.Bytes()); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	if w := get("/client/servers"); w.Code != http.StatusOK || w.Body.String() != `[{"name":"test"}]` {
+		t.Errorf("incorrect list: %d %q", w.Code, w.Body.String())
+	}
+	if w := get("/client/servers", "Accept-Encoding", "gzip"); w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" || !bytes.Equal(w.Body.Bytes(), zb.Bytes()) {
+		t.Errorf("expected gzipped list to be served as-is")
+	}
+	if w := get("/client/servers?id=1&token=x"); w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "https://primary.example.com/client/servers?id=1&token=x" {
+		t.Errorf("expected authenticated list request to be redirected, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := get("/client/origin_auth?id=1"); w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("expected other requests to be redirected, got %d", w.Code)
+	}
+
+	r.list.Load().updated = time.Now().Add(-time.Hour)
+	if w := get("/client/servers"); w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("expected redirect with a stale list, got %d", w.Code)
+	}
+}
@@ -0,0 +1,770 @@
+// Package fakeserver simulates the game server side of the master server
+// protocol (registration, verification, heartbeats, and player auth) for
+// integration tests and server hosting tools.
+package fakeserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0/api0gameserver"
+	"github.com/r2northstar/atlas/pkg/nspkt"
+)
+
+// ErrNotRegistered is returned by methods requiring the server to be
+// registered if it isn't.
+var ErrNotRegistered = errors.New("server not registered")
+
+// Mod is a mod reported in the server's modinfo.
+type Mod struct {
+	Name             string
+	Version          string
+	RequiredOnClient bool
+}
+
+// Player is a player authenticated by the master server.
+type Player struct {
+	UID       uint64
+	Username  string
+	AuthToken string
+	Pdata     []byte
+
+	// Preauth is the verified pre-authorization sent by the master server if
+	// the server has a signing key.
+	Preauth *api0gameserver.Preauth
+}
+
+// APIError is returned when the master server responds with an error.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("master server responded with status %d", e.Status)
+	}
+	return fmt.Sprintf("master server responded with status %d: %s (%s)", e.Status, e.Message, e.Code)
+}
+
+// Server is a fake game server. It must be started with Listen before being
+// registered. All fields must be set before calling Listen, but the
+// server info can be changed later with Update.
+type Server struct {
+	// MasterServer is the base URL of the master server (e.g.,
+	// http://127.0.0.1:8080). It is required.
+	MasterServer string
+
+	// Client is the HTTP client to use for master server requests. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+
+	// UserAgent is the User-Agent sent to the master server. If empty, it
+	// defaults to a R2Northstar dev version.
+	UserAgent string
+
+	// Info is the initial server info.
+	Info Info
+
+	// Mods is the modinfo to send with the registration.
+	Mods []Mod
+
+	// AuthUDP uses the game port for player auth instead of a separate HTTP
+	// auth port, and doesn't start the auth server.
+	AuthUDP bool
+
+	// VerifyText overrides the response to auth server verification requests
+	// (e.g., to simulate a broken server). If empty,
+	// [api0gameserver.VerifyText] is used.
+	VerifyText string
+
+	// NoUDPReply, if true, causes the game port to ignore connect packets,
+	// which will cause verification to fail.
+	NoUDPReply bool
+
+	// Reject, if provided, is called for each player authentication request,
+	// and if it returns a non-empty string, the player is rejected with it
+	// as the reason.
+	Reject func(p Player) string
+
+	// Signing, if optional or required, requests a signing key at
+	// registration, which is used to sign heartbeats, updates, and pdata
+	// writes.
+	Signing string
+
+	// UDPHeartbeat, if true, sends heartbeats over UDP if the master server
+	// supports it (which requires Signing), falling back to HTTP if they
+	// aren't acknowledged.
+	UDPHeartbeat bool
+
+	mu      sync.Mutex
+	info    Info
+	id      string
+	token   string
+	key     string
+	hbPort  uint16
+	hbAck   chan heartbeatAck
+	hbStats [2]int // acked, fell back
+	players []Player
+	udp     *net.UDPConn
+	auth    *http.Server
+	authLn  net.Listener
+	done    chan struct{}
+}
+
+// Info contains the server info sent to the master server.
+type Info struct {
+	Name        string
+	Description string
+	Password    string
+	Map         string
+	Playlist    string
+	PlayerCount int
+	MaxPlayers  int
+
+	Visibility string   // blank for public
+	Allowlist  []uint64 // for the allowlist visibility
+}
+
+// Listen binds the game (UDP) and auth (HTTP) ports on ip, using random
+// ports, and starts serving them until Close is called.
+func (s *Server) Listen(ip netip.Addr) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.udp != nil {
+		return fmt.Errorf("already listening")
+	}
+	s.info = s.Info
+
+	udp, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, 0)))
+	if err != nil {
+		return fmt.Errorf("listen game port: %w", err)
+	}
+
+	var ln net.Listener
+	if !s.AuthUDP {
+		if ln, err = net.Listen("tcp", netip.AddrPortFrom(ip, 0).String()); err != nil {
+			udp.Close()
+			return fmt.Errorf("listen auth port: %w", err)
+		}
+	}
+
+	s.udp = udp
+	s.done = make(chan struct{})
+	go s.serveUDP(udp, s.done)
+
+	if ln != nil {
+		s.authLn = ln
+		s.auth = &http.Server{
+			Handler:           http.HandlerFunc(s.serveAuth),
+			ReadHeaderTimeout: time.Second * 10,
+		}
+		go s.auth.Serve(ln)
+	}
+	return nil
+}
+
+// Close stops the game and auth ports. It does not remove the server from the
+// master server.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	udp, auth, done := s.udp, s.auth, s.done
+	s.udp, s.auth, s.authLn, s.done = nil, nil, nil, nil
+	s.mu.Unlock()
+
+	if udp == nil {
+		return nil
+	}
+	err := udp.Close()
+	<-done
+	if auth != nil {
+		if e := auth.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// GameAddr gets the address of the game port, or an invalid address if not
+// listening.
+func (s *Server) GameAddr() netip.AddrPort {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.udp == nil {
+		return netip.AddrPort{}
+	}
+	return s.udp.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+// AuthAddr gets the address of the auth port, or an invalid address if not
+// listening or using UDP auth.
+func (s *Server) AuthAddr() netip.AddrPort {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.authLn == nil {
+		return netip.AddrPort{}
+	}
+	return s.authLn.Addr().(*net.TCPAddr).AddrPort()
+}
+
+// ID gets the server ID assigned by the master server, or an empty string if
+// it is not registered.
+func (s *Server) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// ServerAuthToken gets the token used by the master server to authenticate
+// itself to the auth server.
+func (s *Server) ServerAuthToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// Players gets the players authenticated by the master server so far.
+func (s *Server) Players() []Player {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Player(nil), s.players...)
+}
+
+func (s *Server) serveUDP(conn *net.UDPConn, done chan<- struct{}) {
+	defer close(done)
+
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		data, ok := nspkt.DecryptPacket(buf[:n])
+		if !ok {
+			continue
+		}
+
+		if ts, status, ok := nspkt.ParseAtlasHeartbeatAck(data, []byte(s.SigningKey())); ok {
+			s.mu.Lock()
+			ch := s.hbAck
+			s.mu.Unlock()
+			if ch != nil {
+				select {
+				case ch <- heartbeatAck{ts, status}:
+				default:
+				}
+			}
+			continue
+		}
+
+		// 4: i32 = -1
+		// 1: u8  = 'H'
+		// 8: str = "connect\0"
+		// 8: u64 = uid
+		// 1: u8  = 2
+		if len(data) < 4+1+8+8 || binary.LittleEndian.Uint32(data) != 0xFFFFFFFF || data[4] != 'H' || string(data[5:13]) != "connect\x00" {
+			continue
+		}
+		uid := binary.LittleEndian.Uint64(data[13:])
+
+		if s.NoUDPReply {
+			continue
+		}
+
+		var b []byte
+		b = append(b, "\xFF\xFF\xFF\xFF"...)
+		b = append(b, 'I')
+		b = binary.LittleEndian.AppendUint32(b, 0) // challenge
+		b = binary.LittleEndian.AppendUint64(b, uid)
+		b = append(b, "connect\x00"...)
+		b = append(b, 0, 0, 0, 0)
+
+		var nonce [12]byte
+		if _, err := rand.Read(nonce[:]); err != nil {
+			continue
+		}
+		conn.WriteToUDPAddrPort(nspkt.EncryptPacket(nonce[:], b), addr)
+	}
+}
+
+func (s *Server) serveAuth(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/verify":
+		v := s.VerifyText
+		if v == "" {
+			v = api0gameserver.VerifyText
+		}
+		w.Write([]byte(v))
+	case "/authenticate_incoming_player":
+		q := r.URL.Query()
+		if q.Get("serverAuthToken") != s.ServerAuthToken() {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"success":false}`))
+			return
+		}
+		uid, err := strconv.ParseUint(q.Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		pdata, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "failed to read pdata", http.StatusBadRequest)
+			return
+		}
+		p := Player{
+			UID:       uid,
+			Username:  q.Get("username"),
+			AuthToken: q.Get("authToken"),
+			Pdata:     pdata,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if key := s.SigningKey(); key != "" {
+			pa, err := api0gameserver.VerifyPreauth(key, r.Header.Get(api0gameserver.PreauthHeader), time.Now())
+			if err != nil || pa.ServerID != s.ID() || pa.UID != p.UID || pa.Token != p.AuthToken {
+				json.NewEncoder(w).Encode(map[string]any{
+					"success": false,
+					"reject":  "invalid preauth",
+				})
+				return
+			}
+			p.Preauth = &pa
+		}
+		if s.Reject != nil {
+			if reason := s.Reject(p); reason != "" {
+				json.NewEncoder(w).Encode(map[string]any{
+					"success": false,
+					"reject":  reason,
+				})
+				return
+			}
+		}
+		s.mu.Lock()
+		s.players = append(s.players, p)
+		s.mu.Unlock()
+		w.Write([]byte(`{"success":true}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Register adds the server to the master server, which will verify it before
+// responding.
+func (s *Server) Register(ctx context.Context) error {
+	game, auth := s.GameAddr(), s.AuthAddr()
+	if !game.IsValid() {
+		return fmt.Errorf("not listening")
+	}
+
+	s.mu.Lock()
+	info := s.info
+	s.mu.Unlock()
+
+	q := info.query()
+	q.Set("port", strconv.Itoa(int(game.Port())))
+	if auth.IsValid() {
+		q.Set("authPort", strconv.Itoa(int(auth.Port())))
+	} else {
+		q.Set("authPort", "udp")
+	}
+	if s.Signing != "" {
+		q.Set("signing", s.Signing)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if fw, err := mw.CreateFormFile("modinfo", "modinfo.json"); err != nil {
+		return err
+	} else {
+		type mod struct {
+			Name             string `json:"Name"`
+			Version          string `json:"Version"`
+			RequiredOnClient bool   `json:"RequiredOnClient"`
+		}
+		ms := []mod{}
+		for _, m := range s.Mods {
+			ms = append(ms, mod(m))
+		}
+		if err := json.NewEncoder(fw).Encode(map[string]any{"Mods": ms}); err != nil {
+			return err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	var obj struct {
+		ID              string `json:"id"`
+		ServerAuthToken string `json:"serverAuthToken"`
+		SigningKey      string `json:"signingKey"`
+		UDPHeartbeat    uint16 `json:"udpHeartbeatPort"`
+	}
+	if err := s.do(ctx, http.MethodPost, "/server/add_server", q, mw.FormDataContentType(), &body, &obj); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.id, s.token, s.key, s.hbPort = obj.ID, obj.ServerAuthToken, obj.SigningKey, obj.UDPHeartbeat
+	s.mu.Unlock()
+	return nil
+}
+
+// Heartbeat sends a heartbeat with the current player count, over UDP if
+// enabled and supported.
+func (s *Server) Heartbeat(ctx context.Context) error {
+	s.mu.Lock()
+	id, n, key, port := s.id, s.info.PlayerCount, s.key, s.hbPort
+	s.mu.Unlock()
+
+	if id == "" {
+		return ErrNotRegistered
+	}
+	if s.UDPHeartbeat && key != "" && port != 0 {
+		ok := s.heartbeatUDP(ctx, id, n, key, port)
+
+		s.mu.Lock()
+		if ok {
+			s.hbStats[0]++
+		} else {
+			s.hbStats[1]++
+		}
+		s.mu.Unlock()
+
+		if ok {
+			return nil
+		}
+	}
+	return s.do(ctx, http.MethodPost, "/server/heartbeat", url.Values{
+		"id":          {id},
+		"playerCount": {strconv.Itoa(n)},
+	}, "", nil, nil)
+}
+
+// udpHeartbeatTimeout is how long to wait for a UDP heartbeat to be
+// acknowledged before falling back to HTTP.
+const udpHeartbeatTimeout = time.Second
+
+type heartbeatAck struct {
+	ts     int64
+	status nspkt.AtlasHeartbeatStatus
+}
+
+// heartbeatUDP sends a heartbeat to the master server over UDP, returning
+// true if it was acknowledged.
+func (s *Server) heartbeatUDP(ctx context.Context, id string, n int, key string, port uint16) bool {
+	u, err := url.Parse(s.MasterServer)
+	if err != nil {
+		return false
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(u.Hostname(), strconv.Itoa(int(port))))
+	if err != nil {
+		return false
+	}
+
+	ch := make(chan heartbeatAck, 1)
+	s.mu.Lock()
+	conn := s.udp
+	s.hbAck = ch
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if s.hbAck == ch {
+			s.hbAck = nil
+		}
+		s.mu.Unlock()
+	}()
+
+	if conn == nil {
+		return false
+	}
+
+	var nonce [12]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return false
+	}
+	hb := nspkt.AtlasHeartbeat{
+		ServerID:    id,
+		Timestamp:   time.Now().UnixMilli(),
+		PlayerCount: n,
+		MaxPlayers:  -1,
+	}
+	if _, err := conn.WriteToUDP(nspkt.EncryptPacket(nonce[:], nspkt.AppendAtlasHeartbeat(nil, []byte(key), hb)), addr); err != nil {
+		return false
+	}
+
+	tm := time.NewTimer(udpHeartbeatTimeout)
+	defer tm.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-tm.C:
+			return false
+		case ack := <-ch:
+			if ack.ts == hb.Timestamp {
+				return ack.status == nspkt.AtlasHeartbeatOK
+			}
+		}
+	}
+}
+
+// UDPHeartbeats gets the number of heartbeats which were acknowledged over
+// UDP, and the number which fell back to HTTP.
+func (s *Server) UDPHeartbeats() (acked, fellBack int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hbStats[0], s.hbStats[1]
+}
+
+// KeepAlive sends the uids of the players authenticated by the master server
+// as the connected players, returning the ones which were rejected.
+func (s *Server) KeepAlive(ctx context.Context) ([]uint64, error) {
+	s.mu.Lock()
+	id := s.id
+	uids := make([]uint64, len(s.players))
+	for i, p := range s.players {
+		uids[i] = p.UID
+	}
+	s.mu.Unlock()
+
+	if id == "" {
+		return nil, ErrNotRegistered
+	}
+
+	buf, err := json.Marshal(map[string]any{
+		"players": uids,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var obj struct {
+		Rejected []uint64 `json:"rejected"`
+	}
+	if err := s.do(ctx, http.MethodPost, "/server/players", url.Values{
+		"id": {id},
+	}, "application/json", bytes.NewReader(buf), &obj); err != nil {
+		return nil, err
+	}
+	return obj.Rejected, nil
+}
+
+// Update changes the server info and sends it to the master server. If the
+// server isn't registered yet, the info is only updated locally.
+func (s *Server) Update(ctx context.Context, fn func(*Info)) error {
+	s.mu.Lock()
+	fn(&s.info)
+	id, info := s.id, s.info
+	s.mu.Unlock()
+
+	if id == "" {
+		return nil
+	}
+	q := info.query()
+	q.Set("id", id)
+	return s.do(ctx, http.MethodPost, "/server/update_values", q, "", nil, nil)
+}
+
+// Remove removes the server from the master server.
+func (s *Server) Remove(ctx context.Context) error {
+	s.mu.Lock()
+	id := s.id
+	s.mu.Unlock()
+
+	if id == "" {
+		return ErrNotRegistered
+	}
+	if err := s.do(ctx, http.MethodDelete, "/server/remove_server", url.Values{
+		"id": {id},
+	}, "", nil, nil); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.id == id {
+		s.id, s.token, s.key, s.hbPort = "", "", "", 0
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// WritePersistence saves the pdata for a player authenticated on the server.
+func (s *Server) WritePersistence(ctx context.Context, uid uint64, pdata []byte) error {
+	s.mu.Lock()
+	id := s.id
+	s.mu.Unlock()
+
+	if id == "" {
+		return ErrNotRegistered
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if fw, err := mw.CreateFormFile("pdata", "file.pdata"); err != nil {
+		return err
+	} else if _, err := fw.Write(pdata); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	return s.do(ctx, http.MethodPost, "/accounts/write_persistence", url.Values{
+		"id":       {strconv.FormatUint(uid, 10)},
+		"serverId": {id},
+	}, mw.FormDataContentType(), &body, nil)
+}
+
+// PatchPersistence updates the specified pdata fields (in the same form as
+// the JSON encoding of pdata.Pdata) for a player.
+func (s *Server) PatchPersistence(ctx context.Context, uid uint64, patch any) error {
+	s.mu.Lock()
+	id := s.id
+	s.mu.Unlock()
+
+	if id == "" {
+		return ErrNotRegistered
+	}
+
+	buf, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	return s.do(ctx, http.MethodPatch, "/accounts/write_persistence", url.Values{
+		"id":       {strconv.FormatUint(uid, 10)},
+		"serverId": {id},
+	}, "application/json", bytes.NewReader(buf), nil)
+}
+
+// Run sends heartbeats at the specified interval until ctx is canceled or a
+// heartbeat fails, in which case the error is returned.
+func (s *Server) Run(ctx context.Context, interval time.Duration) error {
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tk.C:
+			if err := s.Heartbeat(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (i Info) query() url.Values {
+	q := url.Values{
+		"name":        {i.Name},
+		"description": {i.Description},
+		"password":    {i.Password},
+		"map":         {i.Map},
+		"playlist":    {i.Playlist},
+		"playerCount": {strconv.Itoa(i.PlayerCount)},
+		"maxPlayers":  {strconv.Itoa(i.MaxPlayers)},
+	}
+	if i.Visibility != "" {
+		q.Set("visibility", i.Visibility)
+	}
+	if len(i.Allowlist) != 0 {
+		uids := make([]string, len(i.Allowlist))
+		for j, uid := range i.Allowlist {
+			uids[j] = strconv.FormatUint(uid, 10)
+		}
+		q.Set("allowlist", strings.Join(uids, ","))
+	}
+	return q
+}
+
+// SigningKey gets the request signing key issued at registration, if any.
+func (s *Server) SigningKey() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.key
+}
+
+func (s *Server) do(ctx context.Context, method, path string, q url.Values, ct string, body io.Reader, res any) error {
+	var reqBody []byte
+	if body != nil {
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		reqBody = b
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(s.MasterServer, "/")+path+"?"+q.Encode(), bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	if key := s.SigningKey(); key != "" {
+		api0gameserver.SignRequest(req, key, path, reqBody, time.Now())
+	}
+	if ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	if v := s.UserAgent; v != "" {
+		req.Header.Set("User-Agent", v)
+	} else {
+		req.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+	}
+
+	cl := s.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+
+	resp, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	// write_persistence responds with null on success
+	if resp.StatusCode == http.StatusOK && string(bytes.TrimSpace(buf)) == "null" {
+		return nil
+	}
+
+	var obj struct {
+		Success bool `json:"success"`
+		Error   struct {
+			Enum string `json:"enum"`
+			Msg  string `json:"msg"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(buf, &obj); err != nil || !obj.Success {
+		return &APIError{
+			Status:  resp.StatusCode,
+			Code:    obj.Error.Enum,
+			Message: obj.Error.Msg,
+		}
+	}
+	if res != nil {
+		if err := json.Unmarshal(buf, res); err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
+	}
+	return nil
+}
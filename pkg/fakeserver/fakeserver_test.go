@@ -0,0 +1,445 @@
+package fakeserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/api/api0/api0gameserver"
+	"github.com/r2northstar/atlas/pkg/fakeserver"
+	"github.com/r2northstar/atlas/pkg/memstore"
+	"github.com/r2northstar/atlas/pkg/nspkt"
+)
+
+func newMasterServer(t *testing.T) (*api0.Handler, *httptest.Server) {
+	as := memstore.NewAccountStore()
+	h := &api0.Handler{
+		AccountStorage:               as,
+		StateStorage:                 as,
+		PdataStorage:                 memstore.NewPdataStore(false),
+		ServerList:                   api0.NewServerList(time.Minute, time.Minute*2, time.Second*5, api0.ServerListConfig{}),
+		NSPkt:                        nspkt.NewListener(),
+		InsecureDevNoCheckPlayerAuth: true,
+	}
+	go h.NSPkt.ListenAndServe(netip.MustParseAddrPort("127.0.0.1:0"))
+	t.Cleanup(h.NSPkt.Close)
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return h, srv
+}
+
+func TestServer(t *testing.T) {
+	h, ms := newMasterServer(t)
+	ctx := context.Background()
+
+	s := &fakeserver.Server{
+		MasterServer: ms.URL,
+		Info: fakeserver.Info{
+			Name:       "test",
+			Map:        "mp_forwardbase_kodai",
+			Playlist:   "ps",
+			MaxPlayers: 16,
+		},
+		Mods: []fakeserver.Mod{{Name: "Northstar.Custom", Version: "1.0.0", RequiredOnClient: true}},
+		Reject: func(p fakeserver.Player) string {
+			if p.UID == 2 {
+				return "banned"
+			}
+			return ""
+		},
+	}
+	if err := s.Listen(netip.MustParseAddr("127.0.0.1")); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Heartbeat(ctx); !errors.Is(err, fakeserver.ErrNotRegistered) {
+		t.Errorf("expected heartbeat before registration to fail, got %v", err)
+	}
+	if err := s.Register(ctx); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	srv := h.ServerList.GetServerByID(s.ID())
+	if srv == nil {
+		t.Fatalf("server not in list")
+	}
+	if srv.Name != "test" || srv.MaxPlayers != 16 || len(srv.ModInfo) != 1 || srv.ModInfo[0].Name != "Northstar.Custom" {
+		t.Errorf("incorrect server info %+v", srv)
+	}
+	if srv.VerificationDeadline != (time.Time{}) {
+		t.Errorf("server not verified")
+	}
+
+	if err := s.Update(ctx, func(i *fakeserver.Info) {
+		i.PlayerCount = 3
+		i.Map = "mp_glitch"
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err := s.Heartbeat(ctx); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if srv := h.ServerList.GetServerByID(s.ID()); srv.PlayerCount != 3 || srv.Map != "mp_glitch" {
+		t.Errorf("server info not updated: %+v", srv)
+	}
+
+	for _, uid := range []string{"1", "2"} {
+		req, _ := http.NewRequest(http.MethodGet, ms.URL+"/client/origin_auth?id="+uid+"&token=x", nil)
+		req.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+		resp, err := ms.Client().Do(req)
+		if err != nil {
+			t.Fatalf("origin auth: %v", err)
+		}
+		var obj struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(resp.Body).Decode(&obj)
+		resp.Body.Close()
+
+		req, _ = http.NewRequest(http.MethodPost, ms.URL+"/client/auth_with_server?id="+uid+"&server="+s.ID()+"&playerToken="+obj.Token, nil)
+		req.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+		resp, err = ms.Client().Do(req)
+		if err != nil {
+			t.Fatalf("auth with server: %v", err)
+		}
+		resp.Body.Close()
+		if ok := resp.StatusCode == http.StatusOK; ok != (uid == "1") {
+			t.Errorf("uid %s: unexpected status %d", uid, resp.StatusCode)
+		}
+	}
+	if ps := s.Players(); len(ps) != 1 || ps[0].UID != 1 || ps[0].AuthToken == "" || len(ps[0].Pdata) == 0 {
+		t.Errorf("incorrect players %+v", ps)
+	}
+
+	if err := s.Remove(ctx); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if h.ServerList.GetServerByID(srv.ID) != nil {
+		t.Errorf("server not removed")
+	}
+}
+
+func TestServerVerifyFailure(t *testing.T) {
+	_, ms := newMasterServer(t)
+
+	s := &fakeserver.Server{
+		MasterServer: ms.URL,
+		Info:         fakeserver.Info{Name: "test"},
+		VerifyText:   "I am not a northstar server",
+	}
+	if err := s.Listen(netip.MustParseAddr("127.0.0.1")); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer s.Close()
+
+	var ae *fakeserver.APIError
+	if err := s.Register(context.Background()); !errors.As(err, &ae) || ae.Code != string(api0.ErrorCode_BAD_GAMESERVER_RESPONSE) {
+		t.Errorf("expected bad gameserver response error, got %v", err)
+	}
+}
+
+func TestServerSigning(t *testing.T) {
+	h, ms := newMasterServer(t)
+	ctx := context.Background()
+
+	s := &fakeserver.Server{
+		MasterServer: ms.URL,
+		Info:         fakeserver.Info{Name: "test", MaxPlayers: 16},
+		Signing:      "required",
+	}
+	if err := s.Listen(netip.MustParseAddr("127.0.0.1")); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Register(ctx); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if s.SigningKey() == "" {
+		t.Fatalf("expected signing key to be issued")
+	}
+	if srv := h.ServerList.GetServerByID(s.ID()); srv == nil || srv.SigningKey != s.SigningKey() || !srv.SigningRequired {
+		t.Fatalf("incorrect server signing info %+v", srv)
+	}
+	if err := s.Heartbeat(ctx); err != nil {
+		t.Errorf("signed heartbeat: %v", err)
+	}
+	if err := s.Update(ctx, func(i *fakeserver.Info) { i.PlayerCount = 2 }); err != nil {
+		t.Errorf("signed update: %v", err)
+	}
+
+	{
+		req, _ := http.NewRequest(http.MethodGet, ms.URL+"/client/origin_auth?id=1&token=x", nil)
+		req.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+		resp, err := ms.Client().Do(req)
+		if err != nil {
+			t.Fatalf("origin auth: %v", err)
+		}
+		var obj struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(resp.Body).Decode(&obj)
+		resp.Body.Close()
+
+		req, _ = http.NewRequest(http.MethodPost, ms.URL+"/client/auth_with_server?id=1&server="+s.ID()+"&playerToken="+obj.Token, nil)
+		req.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+		resp, err = ms.Client().Do(req)
+		if err != nil {
+			t.Fatalf("auth with server: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("auth with server: unexpected status %d", resp.StatusCode)
+		}
+		if ps := s.Players(); len(ps) != 1 || ps[0].Preauth == nil || ps[0].Preauth.UID != 1 || !ps[0].Preauth.IP.IsLoopback() {
+			t.Errorf("expected player with verified preauth, got %+v", ps)
+		}
+		pa := api0gameserver.Preauth{ServerID: s.ID(), UID: 1, Token: "x", Expires: time.Now().Add(-time.Second).Unix()}
+		if _, err := api0gameserver.VerifyPreauth(s.SigningKey(), api0gameserver.SignPreauth(s.SigningKey(), pa), time.Now()); !errors.Is(err, api0gameserver.ErrPreauthExpired) {
+			t.Errorf("expired preauth: expected ErrPreauthExpired, got %v", err)
+		}
+		if _, err := api0gameserver.VerifyPreauth("wrong", api0gameserver.SignPreauth(s.SigningKey(), pa), time.Now()); !errors.Is(err, api0gameserver.ErrPreauthInvalid) {
+			t.Errorf("preauth with wrong key: expected ErrPreauthInvalid, got %v", err)
+		}
+	}
+
+	heartbeat := func(sign func(*http.Request)) int {
+		req, _ := http.NewRequest(http.MethodPost, ms.URL+"/server/heartbeat?id="+s.ID()+"&playerCount=1", nil)
+		req.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+		if sign != nil {
+			sign(req)
+		}
+		resp, err := ms.Client().Do(req)
+		if err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if st := heartbeat(nil); st != http.StatusUnauthorized {
+		t.Errorf("unsigned heartbeat: expected status 401, got %d", st)
+	}
+	if st := heartbeat(func(r *http.Request) {
+		api0gameserver.SignRequest(r, "wrong", "/server/heartbeat", nil, time.Now())
+	}); st != http.StatusUnauthorized {
+		t.Errorf("heartbeat with wrong key: expected status 401, got %d", st)
+	}
+	if st := heartbeat(func(r *http.Request) {
+		api0gameserver.SignRequest(r, s.SigningKey(), "/server/heartbeat", nil, time.Now().Add(-time.Hour))
+	}); st != http.StatusUnauthorized {
+		t.Errorf("heartbeat with old timestamp: expected status 401, got %d", st)
+	}
+	var sig, ts string
+	if st := heartbeat(func(r *http.Request) {
+		api0gameserver.SignRequest(r, s.SigningKey(), "/server/heartbeat", nil, time.Now())
+		sig, ts = r.Header.Get(api0gameserver.SignatureHeader), r.Header.Get(api0gameserver.SignatureTimestampHeader)
+	}); st != http.StatusOK {
+		t.Errorf("signed heartbeat: expected status 200, got %d", st)
+	}
+	if st := heartbeat(func(r *http.Request) {
+		r.Header.Set(api0gameserver.SignatureHeader, sig)
+		r.Header.Set(api0gameserver.SignatureTimestampHeader, ts)
+	}); st != http.StatusUnauthorized {
+		t.Errorf("replayed heartbeat: expected status 401, got %d", st)
+	}
+}
+
+func TestServerUDPHeartbeat(t *testing.T) {
+	h, ms := newMasterServer(t)
+	h.UDPHeartbeat.Enabled = true
+	h.NSPkt.HandleAtlasHeartbeats(h.HandleUDPHeartbeat)
+	ctx := context.Background()
+
+	s := &fakeserver.Server{
+		MasterServer: ms.URL,
+		Info:         fakeserver.Info{Name: "test", MaxPlayers: 16},
+		Signing:      "optional",
+		UDPHeartbeat: true,
+	}
+	if err := s.Listen(netip.MustParseAddr("127.0.0.1")); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Register(ctx); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		s.Update(ctx, func(i *fakeserver.Info) { i.PlayerCount++ })
+		if err := s.Heartbeat(ctx); err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+		if acked, fellBack := s.UDPHeartbeats(); acked != i || fellBack != 0 {
+			t.Fatalf("expected %d acked udp heartbeats, got %d (%d fell back)", i, acked, fellBack)
+		}
+	}
+
+	h.NSPkt.HandleAtlasHeartbeats(func(addr netip.AddrPort, hb *nspkt.AtlasHeartbeat) (nspkt.AtlasHeartbeatStatus, []byte) {
+		return nspkt.AtlasHeartbeatFallback, nil
+	})
+	s.Update(ctx, func(i *fakeserver.Info) { i.PlayerCount = 0 })
+	if err := s.Heartbeat(ctx); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if acked, fellBack := s.UDPHeartbeats(); acked != 3 || fellBack != 1 {
+		t.Fatalf("expected heartbeat to fall back to http, got %d acked, %d fell back", acked, fellBack)
+	}
+
+	key := []byte(s.SigningKey())
+	hb := nspkt.AtlasHeartbeat{ServerID: s.ID(), Timestamp: time.Now().UnixMilli(), PlayerCount: 5, MaxPlayers: -1}
+	if status, _ := h.HandleUDPHeartbeat(s.GameAddr(), mustParseHeartbeat(t, nspkt.AppendAtlasHeartbeat(nil, key, hb))); status != nspkt.AtlasHeartbeatOK {
+		t.Errorf("valid heartbeat: expected ok, got %s", status)
+	}
+	if srv := h.ServerList.GetServerByID(s.ID()); srv == nil || srv.PlayerCount != 5 {
+		t.Errorf("expected udp heartbeat to update player count, got %+v", srv)
+	}
+	if status, _ := h.HandleUDPHeartbeat(s.GameAddr(), mustParseHeartbeat(t, nspkt.AppendAtlasHeartbeat(nil, key, hb))); status != nspkt.AtlasHeartbeatInvalid {
+		t.Errorf("replayed heartbeat: expected invalid, got %s", status)
+	}
+	if status, _ := h.HandleUDPHeartbeat(s.GameAddr(), mustParseHeartbeat(t, nspkt.AppendAtlasHeartbeat(nil, []byte("wrong"), hb))); status != nspkt.AtlasHeartbeatInvalid {
+		t.Errorf("heartbeat with wrong key: expected invalid, got %s", status)
+	}
+	hb.Timestamp = time.Now().Add(-time.Hour).UnixMilli()
+	if status, _ := h.HandleUDPHeartbeat(s.GameAddr(), mustParseHeartbeat(t, nspkt.AppendAtlasHeartbeat(nil, key, hb))); status != nspkt.AtlasHeartbeatInvalid {
+		t.Errorf("heartbeat with old timestamp: expected invalid, got %s", status)
+	}
+	hb.Timestamp, hb.ServerID = time.Now().UnixMilli(), "nonexistent"
+	if status, _ := h.HandleUDPHeartbeat(s.GameAddr(), mustParseHeartbeat(t, nspkt.AppendAtlasHeartbeat(nil, key, hb))); status != nspkt.AtlasHeartbeatNotFound {
+		t.Errorf("heartbeat for unknown server: expected not found, got %s", status)
+	}
+}
+
+func mustParseHeartbeat(t *testing.T, b []byte) *nspkt.AtlasHeartbeat {
+	hb, ok := nspkt.ParseAtlasHeartbeat(b)
+	if !ok {
+		t.Fatalf("failed to parse heartbeat %q", b)
+	}
+	return hb
+}
+
+func TestServerPresence(t *testing.T) {
+	h, ms := newMasterServer(t)
+	ctx := context.Background()
+
+	s := &fakeserver.Server{
+		MasterServer: ms.URL,
+		Info:         fakeserver.Info{Name: "test", MaxPlayers: 16},
+	}
+	if err := s.Listen(netip.MustParseAddr("127.0.0.1")); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Register(ctx); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if st := authPlayer(t, ms, 1, s.ID()); st != http.StatusOK {
+		t.Fatalf("auth with server: unexpected status %d", st)
+	}
+
+	online := func() int {
+		var obj struct {
+			OnlinePlayers int `json:"online_players"`
+		}
+		resp, err := ms.Client().Get(ms.URL + "/client/population")
+		if err != nil {
+			t.Fatalf("population: %v", err)
+		}
+		json.NewDecoder(resp.Body).Decode(&obj)
+		resp.Body.Close()
+		return obj.OnlinePlayers
+	}
+	keepAlive := func(body string) []uint64 {
+		req, _ := http.NewRequest(http.MethodPost, ms.URL+"/server/players?id="+s.ID(), strings.NewReader(body))
+		req.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+		resp, err := ms.Client().Do(req)
+		if err != nil {
+			t.Fatalf("keep-alive: %v", err)
+		}
+		var obj struct {
+			Rejected []uint64 `json:"rejected"`
+		}
+		json.NewDecoder(resp.Body).Decode(&obj)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("keep-alive: unexpected status %d", resp.StatusCode)
+		}
+		return obj.Rejected
+	}
+
+	if n := online(); n != 1 {
+		t.Errorf("expected 1 online player after auth, got %d", n)
+	}
+	if rej, err := s.KeepAlive(ctx); err != nil || len(rej) != 0 {
+		t.Errorf("keep-alive: unexpected rejected players %v (err: %v)", rej, err)
+	}
+	if rej := keepAlive(`{"players":[1,42]}`); len(rej) != 1 || rej[0] != 42 {
+		t.Errorf("expected player who didn't join the server to be rejected, got %v", rej)
+	}
+	if n := online(); n != 1 {
+		t.Errorf("expected 1 online player, got %d", n)
+	}
+	if rej := keepAlive(`{"players":[]}`); len(rej) != 0 {
+		t.Errorf("unexpected rejected players %v", rej)
+	}
+	if n := online(); n != 0 {
+		t.Errorf("expected disconnected player to be removed, got %d online players", n)
+	}
+
+	// players on storage are still accepted (e.g., after a restart)
+	if rej := keepAlive(`{"players":[1]}`); len(rej) != 0 {
+		t.Errorf("expected player with the server as their last server to be accepted, got rejected %v", rej)
+	}
+	if n := online(); n != 1 {
+		t.Errorf("expected 1 online player, got %d", n)
+	}
+
+	h.SessionPolicy = api0.SessionPolicyDeny
+	s2 := &fakeserver.Server{
+		MasterServer: ms.URL,
+		Info:         fakeserver.Info{Name: "test 2", MaxPlayers: 16},
+	}
+	if err := s2.Listen(netip.MustParseAddr("127.0.0.1")); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer s2.Close()
+	if err := s2.Register(ctx); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if st := authPlayer(t, ms, 1, s2.ID()); st != http.StatusOK {
+		t.Errorf("switching servers from the same ip with the deny session policy: unexpected status %d", st)
+	}
+}
+
+func authPlayer(t *testing.T, ms *httptest.Server, uid uint64, server string) int {
+	req, _ := http.NewRequest(http.MethodGet, ms.URL+"/client/origin_auth?id="+strconv.FormatUint(uid, 10)+"&token=x", nil)
+	req.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+	resp, err := ms.Client().Do(req)
+	if err != nil {
+		t.Fatalf("origin auth: %v", err)
+	}
+	var obj struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(resp.Body).Decode(&obj)
+	resp.Body.Close()
+
+	req, _ = http.NewRequest(http.MethodPost, ms.URL+"/client/auth_with_server?id="+strconv.FormatUint(uid, 10)+"&server="+server+"&playerToken="+obj.Token, nil)
+	req.Header.Set("User-Agent", "R2Northstar/0.0.0+dev")
+	resp, err = ms.Client().Do(req)
+	if err != nil {
+		t.Fatalf("auth with server: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -0,0 +1,217 @@
+// Package fault implements fault injection for testing resilience to slow or
+// failing dependencies. It must not be used in production.
+package fault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInjected is matched by all errors returned by an [Injector].
+var ErrInjected = errors.New("injected fault")
+
+// Error is returned for an injected failure.
+type Error struct {
+	Op string
+}
+
+func (err *Error) Error() string {
+	return "fault: injected failure for " + err.Op
+}
+
+func (err *Error) Is(other error) bool {
+	return other == ErrInjected
+}
+
+// Rule configures the faults injected for an operation.
+type Rule struct {
+	// Target is the operation to inject faults into. It matches operations
+	// equal to it or prefixed by it followed by a dot. If it is "*", it
+	// matches all operations.
+	Target string
+
+	// Delay is the amount of time to wait before each operation.
+	Delay time.Duration
+
+	// Jitter is the maximum random additional delay.
+	Jitter time.Duration
+
+	// Fail is the probability (0-1) of failing the operation after the delay.
+	Fail float64
+}
+
+// Match checks if the rule applies to op.
+func (r Rule) Match(op string) bool {
+	return r.Target == "*" || op == r.Target || strings.HasPrefix(op, r.Target+".")
+}
+
+// String formats the rule in the form accepted by [ParseRules].
+func (r Rule) String() string {
+	var b strings.Builder
+	b.WriteString(r.Target)
+	b.WriteByte(':')
+	if r.Delay != 0 {
+		b.WriteString("delay=")
+		b.WriteString(r.Delay.String())
+		b.WriteByte(',')
+	}
+	if r.Jitter != 0 {
+		b.WriteString("jitter=")
+		b.WriteString(r.Jitter.String())
+		b.WriteByte(',')
+	}
+	if r.Fail != 0 {
+		b.WriteString("fail=")
+		b.WriteString(strconv.FormatFloat(r.Fail, 'g', -1, 64))
+		b.WriteByte(',')
+	}
+	return strings.TrimRight(b.String(), ",")
+}
+
+// ParseRules parses rules in the form target:key=value[,key=value...][;...],
+// where the keys are delay, jitter (durations), and fail (a probability).
+func ParseRules(s string) ([]Rule, error) {
+	var rs []Rule
+	for _, x := range strings.Split(s, ";") {
+		if x = strings.TrimSpace(x); x == "" {
+			continue
+		}
+		target, opts, ok := strings.Cut(x, ":")
+		if target = strings.TrimSpace(target); !ok || target == "" {
+			return nil, fmt.Errorf("rule %q: expected target:options", x)
+		}
+		r := Rule{Target: target}
+		for _, opt := range strings.Split(opts, ",") {
+			if opt = strings.TrimSpace(opt); opt == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(opt, "=")
+			if !ok {
+				return nil, fmt.Errorf("rule %q: option %q: expected key=value", x, opt)
+			}
+			var err error
+			switch k {
+			case "delay":
+				r.Delay, err = time.ParseDuration(v)
+			case "jitter":
+				r.Jitter, err = time.ParseDuration(v)
+			case "fail":
+				r.Fail, err = strconv.ParseFloat(v, 64)
+				if err == nil && (r.Fail < 0 || r.Fail > 1) {
+					err = fmt.Errorf("probability out of range")
+				}
+			default:
+				err = fmt.Errorf("unknown option")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: option %q: %w", x, opt, err)
+			}
+			if r.Delay < 0 || r.Jitter < 0 {
+				return nil, fmt.Errorf("rule %q: option %q: negative duration", x, opt)
+			}
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+// Injector injects faults into operations according to a set of rules. The
+// zero value does not inject any faults. It is safe for concurrent use.
+type Injector struct {
+	rules atomic.Pointer[[]Rule]
+}
+
+// NewInjector creates a new Injector with the provided rules.
+func NewInjector(rs ...Rule) *Injector {
+	f := new(Injector)
+	f.SetRules(rs)
+	return f
+}
+
+// SetRules replaces the current rules. The first matching rule is used for
+// each operation.
+func (f *Injector) SetRules(rs []Rule) {
+	rs = append([]Rule(nil), rs...)
+	f.rules.Store(&rs)
+}
+
+// Rules returns a copy of the current rules.
+func (f *Injector) Rules() []Rule {
+	if rs := f.rules.Load(); rs != nil {
+		return append([]Rule(nil), *rs...)
+	}
+	return nil
+}
+
+// Inject applies the first rule matching op, if any, returning an error if the
+// operation should fail.
+func (f *Injector) Inject(op string) error {
+	return f.InjectContext(context.Background(), op)
+}
+
+// InjectContext is like Inject, but stops waiting if ctx is cancelled.
+func (f *Injector) InjectContext(ctx context.Context, op string) error {
+	if f == nil {
+		return nil
+	}
+	rs := f.rules.Load()
+	if rs == nil {
+		return nil
+	}
+	for _, r := range *rs {
+		if !r.Match(op) {
+			continue
+		}
+		d := r.Delay
+		if r.Jitter > 0 {
+			d += time.Duration(rand.Int63n(int64(r.Jitter)))
+		}
+		if d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+		}
+		if r.Fail > 0 && rand.Float64() < r.Fail {
+			return &Error{Op: op}
+		}
+		return nil
+	}
+	return nil
+}
+
+// Transport wraps rt (or http.DefaultTransport if nil), injecting faults into
+// each request under the operation returned by op. If op returns an empty
+// string, the request is passed through as-is.
+func (f *Injector) Transport(rt http.RoundTripper, op func(*http.Request) string) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if o := op(r); o != "" {
+			if err := f.InjectContext(r.Context(), o); err != nil {
+				if r.Body != nil {
+					r.Body.Close()
+				}
+				return nil, err
+			}
+		}
+		return rt.RoundTrip(r)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
@@ -0,0 +1,178 @@
+package fault
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/cache"
+	"github.com/r2northstar/atlas/pkg/memstore"
+)
+
+func TestParseRules(t *testing.T) {
+	for _, tc := range []struct {
+		In  string
+		Out string
+		Err bool
+	}{
+		{"", "", false},
+		{"origin:delay=2s", "origin:delay=2s;", false},
+		{" storage.pdata : fail=0.5 , delay=100ms ; cache:jitter=1s;", "storage.pdata:delay=100ms,fail=0.5;cache:jitter=1s;", false},
+		{"*:", "*:;", false},
+		{"origin", "", true},
+		{":fail=1", "", true},
+		{"origin:fail=2", "", true},
+		{"origin:delay=-1s", "", true},
+		{"origin:delay", "", true},
+		{"origin:status=500", "", true},
+	} {
+		rs, err := ParseRules(tc.In)
+		if tc.Err {
+			if err == nil {
+				t.Errorf("parse %q: expected error", tc.In)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parse %q: unexpected error: %v", tc.In, err)
+			continue
+		}
+		var out string
+		for _, r := range rs {
+			out += r.String() + ";"
+		}
+		if out != tc.Out {
+			t.Errorf("parse %q: expected %q, got %q", tc.In, tc.Out, out)
+		}
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	for _, tc := range []struct {
+		Target string
+		Op     string
+		Match  bool
+	}{
+		{"*", "origin", true},
+		{"origin", "origin", true},
+		{"storage", "storage.pdata.SetPdata", true},
+		{"storage.pdata", "storage.pdata.SetPdata", true},
+		{"storage.pdata", "storage.pdatax", false},
+		{"storage.pdata.SetPdata", "storage.pdata", false},
+		{"cache.Get", "cache.Set", false},
+	} {
+		if m := (Rule{Target: tc.Target}).Match(tc.Op); m != tc.Match {
+			t.Errorf("rule %q matching op %q: expected %t, got %t", tc.Target, tc.Op, tc.Match, m)
+		}
+	}
+}
+
+func TestInjector(t *testing.T) {
+	var nilf *Injector
+	if err := nilf.Inject("origin"); err != nil {
+		t.Errorf("nil injector: unexpected error: %v", err)
+	}
+
+	f := NewInjector(
+		Rule{Target: "storage.pdata.GetPdataHash"},
+		Rule{Target: "storage", Fail: 1},
+		Rule{Target: "origin", Delay: time.Hour},
+		Rule{Target: "cache", Delay: time.Millisecond * 20},
+	)
+	if err := f.Inject("storage.pdata.GetPdataHash"); err != nil {
+		t.Errorf("first matching rule should be used, got error: %v", err)
+	}
+	if err := f.Inject("storage.pdata.SetPdata"); !errors.Is(err, ErrInjected) {
+		t.Errorf("expected injected error, got %v", err)
+	}
+	if err := f.Inject("http"); err != nil {
+		t.Errorf("unmatched op: unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	if err := f.Inject("cache.Get"); err != nil {
+		t.Errorf("delayed op: unexpected error: %v", err)
+	} else if d := time.Since(start); d < time.Millisecond*20 {
+		t.Errorf("expected delay of at least 20ms, got %s", d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if err := f.InjectContext(ctx, "origin"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected delay to be interrupted by context, got %v", err)
+	}
+
+	f.SetRules(nil)
+	if err := f.Inject("storage.pdata.SetPdata"); err != nil {
+		t.Errorf("cleared rules: unexpected error: %v", err)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+
+	f := NewInjector(Rule{Target: "origin", Fail: 1})
+	c := &http.Client{
+		Transport: f.Transport(nil, func(r *http.Request) string {
+			return r.URL.Query().Get("op")
+		}),
+	}
+
+	if resp, err := c.Get(s.URL + "?op=stryder"); err != nil {
+		t.Errorf("unmatched op: unexpected error: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+	if resp, err := c.Get(s.URL); err != nil {
+		t.Errorf("no op: unexpected error: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+	if _, err := c.Get(s.URL + "?op=origin"); !errors.Is(err, ErrInjected) {
+		t.Errorf("expected injected error, got %v", err)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	f := NewInjector()
+
+	c := f.Cache(cache.NewLRU(16))
+	as := f.AccountStorage(memstore.NewAccountStore())
+	ps := f.PdataStorage(memstore.NewPdataStore(false))
+
+	if err := c.Set("a", []byte("b"), time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ps.SetPdata(1, []byte("pdata")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f.SetRules([]Rule{{Target: "cache.Get", Fail: 1}, {Target: "storage.pdata", Fail: 1}})
+
+	if err := c.Set("a", []byte("c"), time.Minute); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, err := c.Get("a"); !errors.Is(err, ErrInjected) {
+		t.Errorf("expected injected error, got %v", err)
+	}
+	if _, err := as.GetAccount(1); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, err := ps.GetPdataCached(1, [32]byte{}); !errors.Is(err, ErrInjected) {
+		t.Errorf("expected injected error, got %v", err)
+	}
+
+	f.SetRules(nil)
+	if buf, _, err := c.Get("a"); err != nil || string(buf) != "c" {
+		t.Errorf("expected cached value, got %q (err: %v)", buf, err)
+	}
+	if buf, _, err := ps.GetPdataCached(1, [32]byte{}); err != nil || string(buf) != "pdata" {
+		t.Errorf("expected pdata, got %q (err: %v)", buf, err)
+	}
+}
@@ -0,0 +1,135 @@
+package fault
+
+import (
+	"crypto/sha256"
+	"io"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/cache"
+)
+
+// Cache wraps c, injecting faults under cache.{Get,Set,Delete}.
+func (f *Injector) Cache(c cache.Cache) cache.Cache {
+	return faultCache{c, f}
+}
+
+type faultCache struct {
+	c cache.Cache
+	f *Injector
+}
+
+func (x faultCache) Get(key string) ([]byte, bool, error) {
+	if err := x.f.Inject("cache.Get"); err != nil {
+		return nil, false, err
+	}
+	return x.c.Get(key)
+}
+
+func (x faultCache) Set(key string, buf []byte, ttl time.Duration) error {
+	if err := x.f.Inject("cache.Set"); err != nil {
+		return err
+	}
+	return x.c.Set(key, buf, ttl)
+}
+
+func (x faultCache) Delete(key string) error {
+	if err := x.f.Inject("cache.Delete"); err != nil {
+		return err
+	}
+	return x.c.Delete(key)
+}
+
+// AccountStorage wraps s, injecting faults under storage.accounts.<method>.
+// Optional interfaces other than [io.Closer] implemented by s are not exposed
+// by the wrapper.
+func (f *Injector) AccountStorage(s api0.AccountStorage) api0.AccountStorage {
+	return faultAccountStorage{s, f}
+}
+
+type faultAccountStorage struct {
+	s api0.AccountStorage
+	f *Injector
+}
+
+func (x faultAccountStorage) GetUIDsByUsername(username string) ([]uint64, error) {
+	if err := x.f.Inject("storage.accounts.GetUIDsByUsername"); err != nil {
+		return nil, err
+	}
+	return x.s.GetUIDsByUsername(username)
+}
+
+func (x faultAccountStorage) GetAccount(uid uint64) (*api0.Account, error) {
+	if err := x.f.Inject("storage.accounts.GetAccount"); err != nil {
+		return nil, err
+	}
+	return x.s.GetAccount(uid)
+}
+
+func (x faultAccountStorage) SaveAccount(a *api0.Account) error {
+	if err := x.f.Inject("storage.accounts.SaveAccount"); err != nil {
+		return err
+	}
+	return x.s.SaveAccount(a)
+}
+
+func (x faultAccountStorage) DeleteAccount(uid uint64) error {
+	if err := x.f.Inject("storage.accounts.DeleteAccount"); err != nil {
+		return err
+	}
+	return x.s.DeleteAccount(uid)
+}
+
+func (x faultAccountStorage) Close() error {
+	if c, ok := x.s.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// PdataStorage wraps s, injecting faults under storage.pdata.<method>.
+// Optional interfaces other than [io.Closer] implemented by s are not exposed
+// by the wrapper.
+func (f *Injector) PdataStorage(s api0.PdataStorage) api0.PdataStorage {
+	return faultPdataStorage{s, f}
+}
+
+type faultPdataStorage struct {
+	s api0.PdataStorage
+	f *Injector
+}
+
+func (x faultPdataStorage) GetPdataHash(uid uint64) ([sha256.Size]byte, bool, error) {
+	if err := x.f.Inject("storage.pdata.GetPdataHash"); err != nil {
+		return [sha256.Size]byte{}, false, err
+	}
+	return x.s.GetPdataHash(uid)
+}
+
+func (x faultPdataStorage) GetPdataCached(uid uint64, sha [sha256.Size]byte) ([]byte, bool, error) {
+	if err := x.f.Inject("storage.pdata.GetPdataCached"); err != nil {
+		return nil, false, err
+	}
+	return x.s.GetPdataCached(uid, sha)
+}
+
+func (x faultPdataStorage) SetPdata(uid uint64, buf []byte) (int, error) {
+	if err := x.f.Inject("storage.pdata.SetPdata"); err != nil {
+		return 0, err
+	}
+	return x.s.SetPdata(uid, buf)
+}
+
+func (x faultPdataStorage) DeletePdata(uid uint64) error {
+	if err := x.f.Inject("storage.pdata.DeletePdata"); err != nil {
+		return err
+	}
+	return x.s.DeletePdata(uid)
+}
+
+func (x faultPdataStorage) Close() error {
+	if c, ok := x.s.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Package glicko2 implements the Glicko-2 rating system.
+//
+// See http://www.glicko.net/glicko/glicko2.pdf.
+package glicko2
+
+import "math"
+
+// Defaults for new players.
+const (
+	DefaultRating     = 1500
+	DefaultDeviation  = 350
+	DefaultVolatility = 0.06
+)
+
+// DefaultTau is a reasonable system constant constraining volatility changes.
+const DefaultTau = 0.5
+
+const (
+	scale   = 173.7178
+	epsilon = 0.000001
+)
+
+// Rating is a player rating on the original Glicko scale.
+type Rating struct {
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+}
+
+// Default returns the rating for a new player.
+func Default() Rating {
+	return Rating{DefaultRating, DefaultDeviation, DefaultVolatility}
+}
+
+// Result is the outcome of a game against an opponent in a rating period.
+type Result struct {
+	Opponent Rating
+	Score    float64 // 1 for a win, 0.5 for a draw, 0 for a loss
+}
+
+// Update computes the new rating for r after the results in a rating period,
+// using tau as the system constant. If there are no results, only the
+// deviation is increased. The deviation is capped at DefaultDeviation.
+func Update(r Rating, results []Result, tau float64) Rating {
+	mu := (r.Rating - DefaultRating) / scale
+	phi := r.Deviation / scale
+	sigma := r.Volatility
+
+	if len(results) == 0 {
+		return Rating{r.Rating, capDeviation(math.Sqrt(phi*phi+sigma*sigma) * scale), sigma}
+	}
+
+	var vinv, dsum float64
+	for _, x := range results {
+		muj := (x.Opponent.Rating - DefaultRating) / scale
+		phij := x.Opponent.Deviation / scale
+		g := 1 / math.Sqrt(1+3*phij*phij/(math.Pi*math.Pi))
+		e := 1 / (1 + math.Exp(-g*(mu-muj)))
+		vinv += g * g * e * (1 - e)
+		dsum += g * (x.Score - e)
+	}
+	v := 1 / vinv
+	delta := v * dsum
+
+	// volatility (Illinois algorithm)
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(tau*tau)
+	}
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*tau) < 0 {
+			k++
+		}
+		B = a - k*tau
+	}
+	fA, fB := f(A), f(B)
+	for i := 0; math.Abs(B-A) > epsilon && i < 100; i++ {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	nsigma := math.Exp(A / 2)
+
+	pphi := math.Sqrt(phi*phi + nsigma*nsigma)
+	nphi := 1 / math.Sqrt(1/(pphi*pphi)+1/v)
+	nmu := mu + nphi*nphi*dsum
+
+	return Rating{
+		Rating:     nmu*scale + DefaultRating,
+		Deviation:  capDeviation(nphi * scale),
+		Volatility: nsigma,
+	}
+}
+
+func capDeviation(d float64) float64 {
+	if d > DefaultDeviation {
+		return DefaultDeviation
+	}
+	return d
+}
@@ -0,0 +1,48 @@
+package glicko2
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	// example from the paper
+	r := Update(Rating{1500, 200, 0.06}, []Result{
+		{Rating{1400, 30, 0.06}, 1},
+		{Rating{1550, 100, 0.06}, 0},
+		{Rating{1700, 300, 0.06}, 0},
+	}, 0.5)
+	if math.Abs(r.Rating-1464.06) > 0.01 {
+		t.Errorf("incorrect rating %f", r.Rating)
+	}
+	if math.Abs(r.Deviation-151.52) > 0.01 {
+		t.Errorf("incorrect deviation %f", r.Deviation)
+	}
+	if math.Abs(r.Volatility-0.05999) > 0.00001 {
+		t.Errorf("incorrect volatility %f", r.Volatility)
+	}
+}
+
+func TestUpdateNoResults(t *testing.T) {
+	r := Update(Rating{1500, 200, 0.06}, nil, 0.5)
+	if r.Rating != 1500 || r.Volatility != 0.06 {
+		t.Errorf("rating or volatility changed without results: %+v", r)
+	}
+	if math.Abs(r.Deviation-200.27) > 0.01 {
+		t.Errorf("incorrect deviation %f", r.Deviation)
+	}
+	if r := Update(Default(), nil, 0.5); r.Deviation != DefaultDeviation {
+		t.Errorf("deviation not capped: %f", r.Deviation)
+	}
+}
+
+func TestUpdateSymmetric(t *testing.T) {
+	a := Update(Default(), []Result{{Default(), 1}}, DefaultTau)
+	b := Update(Default(), []Result{{Default(), 0}}, DefaultTau)
+	if a.Rating <= DefaultRating || b.Rating >= DefaultRating {
+		t.Fatalf("winner should gain and loser should lose rating: %+v %+v", a, b)
+	}
+	if math.Abs((a.Rating-DefaultRating)-(DefaultRating-b.Rating)) > 0.0001 {
+		t.Errorf("rating changes not symmetric: %+v %+v", a, b)
+	}
+}
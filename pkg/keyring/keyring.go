@@ -0,0 +1,172 @@
+// Package keyring implements AES-GCM envelope encryption for data at rest with
+// support for key rotation.
+//
+// Each sealed record is encrypted with a random data key, which is itself
+// encrypted with a named key-encryption key from the keyring. Rotating keys
+// only requires re-wrapping the data key.
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	dataKeySize = 32
+	nonceSize   = 12
+	tagSize     = 16
+	headerSize  = nonceSize + dataKeySize + tagSize
+)
+
+// ErrUnknownKey is returned when a record was sealed with a key which is not
+// in the keyring.
+var ErrUnknownKey = errors.New("unknown key id")
+
+// Keyring contains named AES key-encryption keys. It is safe for concurrent
+// use.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// New creates a new keyring from AES-128/192/256 keys by ID. New records are
+// sealed with the primary key, which must exist.
+func New(primary string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{
+		primary: primary,
+		keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		if id == "" || strings.ContainsAny(id, ":,") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		a, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		k.keys[id] = a
+	}
+	if _, ok := k.keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q does not exist", primary)
+	}
+	return k, nil
+}
+
+// Parse parses a keyring in the form id:base64key[,id:base64key...]. The first
+// key is the primary one.
+func Parse(s string) (*Keyring, error) {
+	var primary string
+	keys := map[string][]byte{}
+	for _, x := range strings.Split(s, ",") {
+		id, b64, ok := strings.Cut(strings.TrimSpace(x), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid key: expected id:base64key")
+		}
+		if _, exists := keys[id]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		if primary == "" {
+			primary = id
+		}
+		keys[id] = key
+	}
+	return New(primary, keys)
+}
+
+// Primary returns the ID of the key used for new records.
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// Seal encrypts and authenticates buf and authenticates ad using the primary
+// key, returning the key ID and the sealed record. The ad should identify the
+// record to prevent ciphertexts from being swapped between records.
+func (k *Keyring) Seal(ad, buf []byte) (id string, sealed []byte, err error) {
+	dk := make([]byte, dataKeySize)
+	if _, err := rand.Read(dk); err != nil {
+		return "", nil, err
+	}
+	d, err := newAEAD(dk)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// wrapped key nonce | wrapped key | record nonce | record
+	sealed = make([]byte, headerSize+nonceSize, headerSize+nonceSize+len(buf)+tagSize)
+	if _, err := rand.Read(sealed[:nonceSize]); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(sealed[headerSize:]); err != nil {
+		return "", nil, err
+	}
+	k.keys[k.primary].Seal(sealed[nonceSize:nonceSize], sealed[:nonceSize], dk, ad)
+	return k.primary, d.Seal(sealed, sealed[headerSize:], buf, ad), nil
+}
+
+// Open decrypts a record sealed with the specified key ID.
+func (k *Keyring) Open(id string, ad, sealed []byte) ([]byte, error) {
+	dk, err := k.unwrap(id, ad, sealed)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newAEAD(dk)
+	if err != nil {
+		return nil, err
+	}
+	body := sealed[headerSize:]
+	if len(body) < nonceSize+tagSize {
+		return nil, fmt.Errorf("sealed record too short")
+	}
+	buf, err := d.Open(nil, body[:nonceSize], body[nonceSize:], ad)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt record: %w", err)
+	}
+	return buf, nil
+}
+
+// Rewrap re-encrypts the data key of a record sealed with the specified key ID
+// using the primary key without decrypting the record itself.
+func (k *Keyring) Rewrap(id string, ad, sealed []byte) (newID string, resealed []byte, err error) {
+	dk, err := k.unwrap(id, ad, sealed)
+	if err != nil {
+		return "", nil, err
+	}
+	resealed = make([]byte, headerSize, len(sealed))
+	if _, err := rand.Read(resealed[:nonceSize]); err != nil {
+		return "", nil, err
+	}
+	k.keys[k.primary].Seal(resealed[nonceSize:nonceSize], resealed[:nonceSize], dk, ad)
+	return k.primary, append(resealed, sealed[headerSize:]...), nil
+}
+
+func (k *Keyring) unwrap(id string, ad, sealed []byte) ([]byte, error) {
+	a, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	if len(sealed) < headerSize {
+		return nil, fmt.Errorf("sealed record too short")
+	}
+	dk, err := a.Open(nil, sealed[:nonceSize], sealed[nonceSize:headerSize], ad)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt data key: %w", err)
+	}
+	return dk, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
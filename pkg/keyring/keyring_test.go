@@ -0,0 +1,66 @@
+package keyring
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestKeyring(t *testing.T) {
+	k1, err := Parse("a:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	k2, err := Parse("b:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=, a:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if v := k2.Primary(); v != "b" {
+		t.Errorf("expected primary key b, got %q", v)
+	}
+
+	ad, buf := []byte("test:1"), []byte("hello world")
+
+	id, sealed, err := k1.Seal(ad, buf)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if id != "a" {
+		t.Errorf("expected key a, got %q", id)
+	}
+	if bytes.Contains(sealed, buf) {
+		t.Errorf("sealed record contains plaintext")
+	}
+	if v, err := k1.Open(id, ad, sealed); err != nil || !bytes.Equal(v, buf) {
+		t.Errorf("open: got %q, %v", v, err)
+	}
+	if _, err := k1.Open(id, []byte("test:2"), sealed); err == nil {
+		t.Errorf("expected error opening record with incorrect ad")
+	}
+
+	id2, resealed, err := k2.Rewrap(id, ad, sealed)
+	if err != nil {
+		t.Fatalf("rewrap: %v", err)
+	}
+	if id2 != "b" {
+		t.Errorf("expected key b, got %q", id2)
+	}
+	if v, err := k2.Open(id2, ad, resealed); err != nil || !bytes.Equal(v, buf) {
+		t.Errorf("open rewrapped: got %q, %v", v, err)
+	}
+	if _, err := k1.Open(id2, ad, resealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected unknown key error, got %v", err)
+	}
+
+	for _, s := range []string{
+		"",
+		"a",
+		"a:invalid",
+		"a:AAAA",
+		"a:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=,a:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
@@ -0,0 +1,1147 @@
+package kvstore
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/outbox"
+)
+
+// AccountStore stores accounts and other atlas data in a DB.
+type AccountStore struct {
+	db *DB
+}
+
+// OpenAccountStore opens or creates an AccountStore at the provided filename.
+func OpenAccountStore(name string) (*AccountStore, error) {
+	db, err := Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &AccountStore{db}, nil
+}
+
+// DB gets the underlying DB.
+func (s *AccountStore) DB() *DB {
+	return s.db
+}
+
+func (s *AccountStore) Close() error {
+	return s.db.Close()
+}
+
+// getJSON gets and decodes the value of k into v, returning false if it
+// doesn't exist.
+func getJSON(tx *Tx, k string, v any) (bool, error) {
+	buf, ok, err := tx.Get(k)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, decode(buf, v)
+}
+
+// putJSON encodes and sets v as the value of k.
+func putJSON(tx *Tx, k string, v any) error {
+	buf, err := encode(v)
+	if err != nil {
+		return err
+	}
+	tx.Put(k, buf)
+	return nil
+}
+
+// scanJSON decodes each value starting with prefix into a new T and calls fn
+// with it until it returns false.
+func scanJSON[T any](tx *Tx, prefix string, fn func(k string, v T) bool) error {
+	var derr error
+	if err := tx.Scan(prefix, func(k string, buf []byte) bool {
+		var v T
+		if derr = decode(buf, &v); derr != nil {
+			return false
+		}
+		return fn(k, v)
+	}); err != nil {
+		return err
+	}
+	return derr
+}
+
+// scanUIDs gets the uids at the end of the keys starting with prefix.
+func scanUIDs(tx *Tx, prefix string, limit int) ([]uint64, error) {
+	var uids []uint64
+	var err error
+	tx.Keys(prefix, func(k string) bool {
+		if limit > 0 && len(uids) >= limit {
+			return false
+		}
+		var uid uint64
+		if uid, err = parseU64(k[len(prefix):]); err != nil {
+			return false
+		}
+		uids = append(uids, uid)
+		return true
+	})
+	return uids, err
+}
+
+// deleteIndexed deletes the index entries starting with prefix and the keys
+// they refer to.
+func deleteIndexed(tx *Tx, prefix string) error {
+	return tx.Scan(prefix, func(k string, v []byte) bool {
+		tx.Delete(string(v))
+		tx.Delete(k)
+		return true
+	})
+}
+
+func accountKey(uid uint64) string {
+	return key("a", u64(uid))
+}
+
+func accountUsernameKey(username string, uid uint64) string {
+	return key("au", strings.ToLower(username), u64(uid))
+}
+
+func (s *AccountStore) GetUIDsByUsername(username string) (uids []uint64, err error) {
+	if username != "" {
+		err = s.db.View(func(tx *Tx) error {
+			uids, err = scanUIDs(tx, prefix("au", strings.ToLower(username)), 0)
+			return err
+		})
+	}
+	return
+}
+
+func (s *AccountStore) GetAccountUIDs() (uids []uint64, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		uids, err = scanUIDs(tx, prefix("a"), 0)
+		return err
+	})
+	return
+}
+
+func (s *AccountStore) GetAccount(uid uint64) (*api0.Account, error) {
+	var a api0.Account
+	var ok bool
+	if err := s.db.View(func(tx *Tx) (err error) {
+		ok, err = getJSON(tx, accountKey(uid), &a)
+		return
+	}); err != nil || !ok {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *AccountStore) SaveAccount(a *api0.Account) error {
+	if a == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *Tx) error {
+		var o api0.Account
+		if ok, err := getJSON(tx, accountKey(a.UID), &o); err != nil {
+			return err
+		} else if ok && o.Username != "" {
+			tx.Delete(accountUsernameKey(o.Username, o.UID))
+		}
+		if a.Username != "" {
+			tx.Put(accountUsernameKey(a.Username, a.UID), nil)
+		}
+		return putJSON(tx, accountKey(a.UID), a)
+	})
+}
+
+func (s *AccountStore) DeleteAccount(uid uint64) error {
+	return s.db.Update(func(tx *Tx) error {
+		var o api0.Account
+		if ok, err := getJSON(tx, accountKey(uid), &o); err != nil || !ok {
+			return err
+		}
+		if o.Username != "" {
+			tx.Delete(accountUsernameKey(o.Username, o.UID))
+		}
+		tx.Delete(accountKey(uid))
+		return nil
+	})
+}
+
+func accountLinkKey(uid uint64, provider api0.AccountLinkProvider) string {
+	return key("l", u64(uid), string(provider))
+}
+
+func accountLinkExternalKey(provider api0.AccountLinkProvider, externalID string) string {
+	return key("lx", string(provider), externalID)
+}
+
+func (s *AccountStore) GetAccountLinks(uid uint64) (ls []api0.AccountLink, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		return scanJSON(tx, prefix("l", u64(uid)), func(_ string, l api0.AccountLink) bool {
+			ls = append(ls, l)
+			return true
+		})
+	})
+	return
+}
+
+func (s *AccountStore) GetUIDByAccountLink(provider api0.AccountLinkProvider, externalID string) (uid uint64, exists bool, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		buf, ok, err := tx.Get(accountLinkExternalKey(provider, externalID))
+		if err != nil || !ok {
+			return err
+		}
+		uid, err = parseU64(string(buf))
+		exists = err == nil
+		return err
+	})
+	return
+}
+
+func (s *AccountStore) SaveAccountLink(l *api0.AccountLink) error {
+	if l == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *Tx) error {
+		// remove the existing link to the external account
+		if buf, ok, err := tx.Get(accountLinkExternalKey(l.Provider, l.ExternalID)); err != nil {
+			return err
+		} else if ok {
+			uid, err := parseU64(string(buf))
+			if err != nil {
+				return err
+			}
+			tx.Delete(accountLinkKey(uid, l.Provider))
+		}
+
+		// remove the existing link for the uid
+		var o api0.AccountLink
+		if ok, err := getJSON(tx, accountLinkKey(l.UID, l.Provider), &o); err != nil {
+			return err
+		} else if ok {
+			tx.Delete(accountLinkExternalKey(o.Provider, o.ExternalID))
+		}
+
+		tx.Put(accountLinkExternalKey(l.Provider, l.ExternalID), []byte(u64(l.UID)))
+		return putJSON(tx, accountLinkKey(l.UID, l.Provider), l)
+	})
+}
+
+func (s *AccountStore) DeleteAccountLink(uid uint64, provider api0.AccountLinkProvider) error {
+	return s.db.Update(func(tx *Tx) error {
+		var o api0.AccountLink
+		if ok, err := getJSON(tx, accountLinkKey(uid, provider), &o); err != nil || !ok {
+			return err
+		}
+		tx.Delete(accountLinkExternalKey(o.Provider, o.ExternalID))
+		tx.Delete(accountLinkKey(uid, provider))
+		return nil
+	})
+}
+
+func usernameHistoryKey(uid uint64) string {
+	return key("h", u64(uid))
+}
+
+func usernameHistoryIndexKey(username string, uid uint64) string {
+	return key("hx", strings.ToLower(username), u64(uid))
+}
+
+func (s *AccountStore) GetUsernameHistory(uid uint64) (cs []api0.UsernameChange, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		_, err := getJSON(tx, usernameHistoryKey(uid), &cs)
+		return err
+	})
+	return
+}
+
+func (s *AccountStore) GetUIDsByPastUsername(username string) (uids []uint64, err error) {
+	if username != "" {
+		err = s.db.View(func(tx *Tx) error {
+			uids, err = scanUIDs(tx, prefix("hx", strings.ToLower(username)), 0)
+			return err
+		})
+	}
+	return
+}
+
+func (s *AccountStore) SaveUsernameChange(c *api0.UsernameChange) error {
+	if c == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *Tx) error {
+		var cs []api0.UsernameChange
+		if _, err := getJSON(tx, usernameHistoryKey(c.UID), &cs); err != nil {
+			return err
+		}
+		cs = append(cs, *c)
+		sort.SliceStable(cs, func(i, j int) bool {
+			return cs[i].Time.Before(cs[j].Time)
+		})
+		for _, x := range []string{c.From, c.To} {
+			if x != "" {
+				tx.Put(usernameHistoryIndexKey(x, c.UID), nil)
+			}
+		}
+		return putJSON(tx, usernameHistoryKey(c.UID), cs)
+	})
+}
+
+func (s *AccountStore) DeleteUsernameHistory(uid uint64) error {
+	return s.db.Update(func(tx *Tx) error {
+		var cs []api0.UsernameChange
+		if ok, err := getJSON(tx, usernameHistoryKey(uid), &cs); err != nil || !ok {
+			return err
+		}
+		for _, c := range cs {
+			for _, x := range []string{c.From, c.To} {
+				if x != "" {
+					tx.Delete(usernameHistoryIndexKey(x, uid))
+				}
+			}
+		}
+		tx.Delete(usernameHistoryKey(uid))
+		return nil
+	})
+}
+
+func accountSignalKey(uid uint64, kind api0.AccountSignalKind, hash string) string {
+	return key("s", u64(uid), string(kind), hash)
+}
+
+func accountSignalIndexKey(kind api0.AccountSignalKind, hash string, uid uint64) string {
+	return key("sx", string(kind), hash, u64(uid))
+}
+
+func (s *AccountStore) GetAccountSignals(uid uint64) (ss []api0.AccountSignal, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		return scanJSON(tx, prefix("s", u64(uid)), func(_ string, x api0.AccountSignal) bool {
+			ss = append(ss, x)
+			return true
+		})
+	})
+	return
+}
+
+func (s *AccountStore) GetUIDsByAccountSignal(kind api0.AccountSignalKind, hash string, limit int) (uids []uint64, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		uids, err = scanUIDs(tx, prefix("sx", string(kind), hash), limit)
+		return err
+	})
+	return
+}
+
+func (s *AccountStore) SaveAccountSignal(x *api0.AccountSignal) error {
+	if x == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *Tx) error {
+		v := *x
+		var o api0.AccountSignal
+		if ok, err := getJSON(tx, accountSignalKey(x.UID, x.Kind, x.Hash), &o); err != nil {
+			return err
+		} else if ok {
+			v.FirstSeen = o.FirstSeen
+		}
+		tx.Put(accountSignalIndexKey(x.Kind, x.Hash, x.UID), nil)
+		return putJSON(tx, accountSignalKey(x.UID, x.Kind, x.Hash), v)
+	})
+}
+
+func (s *AccountStore) deleteAccountSignals(prefix string, fn func(x api0.AccountSignal) bool) error {
+	return s.db.Update(func(tx *Tx) error {
+		return scanJSON(tx, prefix, func(k string, x api0.AccountSignal) bool {
+			if fn(x) {
+				tx.Delete(accountSignalIndexKey(x.Kind, x.Hash, x.UID))
+				tx.Delete(k)
+			}
+			return true
+		})
+	})
+}
+
+func (s *AccountStore) DeleteAccountSignals(uid uint64) error {
+	return s.deleteAccountSignals(prefix("s", u64(uid)), func(api0.AccountSignal) bool {
+		return true
+	})
+}
+
+func (s *AccountStore) DeleteAccountSignalsBefore(t time.Time) error {
+	return s.deleteAccountSignals(prefix("s"), func(x api0.AccountSignal) bool {
+		return x.LastSeen.Before(t)
+	})
+}
+
+func banListKey(list string, uid uint64, source string) string {
+	return key("b", list, u64(uid), source)
+}
+
+func banListUIDKey(uid uint64, list, source string) string {
+	return key("bu", u64(uid), list, source)
+}
+
+func (s *AccountStore) GetBanListEntries(list string) (es []api0.BanListEntry, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		return scanJSON(tx, prefix("b", list), func(_ string, e api0.BanListEntry) bool {
+			es = append(es, e)
+			return true
+		})
+	})
+	return
+}
+
+func (s *AccountStore) SaveBanListEntry(e *api0.BanListEntry) error {
+	if e == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *Tx) error {
+		k := banListKey(e.List, e.UID, e.Source)
+		v := *e
+		var o api0.BanListEntry
+		if ok, err := getJSON(tx, k, &o); err != nil {
+			return err
+		} else if ok {
+			v.Created = o.Created
+		}
+		tx.Put(banListUIDKey(e.UID, e.List, e.Source), []byte(k))
+		return putJSON(tx, k, v)
+	})
+}
+
+func (s *AccountStore) DeleteBanList(list string) error {
+	return s.db.Update(func(tx *Tx) error {
+		return scanJSON(tx, prefix("b", list), func(k string, e api0.BanListEntry) bool {
+			tx.Delete(banListUIDKey(e.UID, e.List, e.Source))
+			tx.Delete(k)
+			return true
+		})
+	})
+}
+
+func (s *AccountStore) DeleteBanListEntries(uid uint64) error {
+	return s.db.Update(func(tx *Tx) error {
+		return deleteIndexed(tx, prefix("bu", u64(uid)))
+	})
+}
+
+func abuseReportKey(id string) string {
+	return key("r", id)
+}
+
+func (s *AccountStore) GetAbuseReport(id string) (*api0.AbuseReport, error) {
+	var r api0.AbuseReport
+	var ok bool
+	if err := s.db.View(func(tx *Tx) (err error) {
+		ok, err = getJSON(tx, abuseReportKey(id), &r)
+		return
+	}); err != nil || !ok {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *AccountStore) GetAbuseReports(status api0.AbuseReportStatus, reported uint64, limit int) (rs []api0.AbuseReport, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		return scanJSON(tx, prefix("r"), func(_ string, r api0.AbuseReport) bool {
+			if (status == "" || r.Status == status) && (reported == 0 || r.Reported == reported) {
+				rs = append(rs, r)
+			}
+			return true
+		})
+	})
+	sort.SliceStable(rs, func(i, j int) bool {
+		return rs[i].Time.Before(rs[j].Time)
+	})
+	if limit > 0 && len(rs) > limit {
+		rs = rs[:limit]
+	}
+	return
+}
+
+func (s *AccountStore) SaveAbuseReport(r *api0.AbuseReport) error {
+	if r == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *Tx) error {
+		return putJSON(tx, abuseReportKey(r.ID), r)
+	})
+}
+
+func (s *AccountStore) DeleteAbuseReports(uid uint64) error {
+	return s.db.Update(func(tx *Tx) error {
+		return scanJSON(tx, prefix("r"), func(k string, r api0.AbuseReport) bool {
+			if r.Reporter == uid || r.Reported == uid {
+				tx.Delete(k)
+			}
+			return true
+		})
+	})
+}
+
+func ratingKey(uid uint64, mode string) string {
+	return key("g", u64(uid), mode)
+}
+
+func (s *AccountStore) GetRatings(uid uint64) (rs []api0.PlayerRating, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		return scanJSON(tx, prefix("g", u64(uid)), func(_ string, r api0.PlayerRating) bool {
+			rs = append(rs, r)
+			return true
+		})
+	})
+	return
+}
+
+func (s *AccountStore) GetRating(uid uint64, mode string) (*api0.PlayerRating, error) {
+	var r api0.PlayerRating
+	var ok bool
+	if err := s.db.View(func(tx *Tx) (err error) {
+		ok, err = getJSON(tx, ratingKey(uid, mode), &r)
+		return
+	}); err != nil || !ok {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *AccountStore) SaveRatings(rs []api0.PlayerRating) error {
+	return s.db.Update(func(tx *Tx) error {
+		for _, r := range rs {
+			if err := putJSON(tx, ratingKey(r.UID, r.Mode), r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *AccountStore) DeleteRatings(uid uint64) error {
+	return s.db.Update(func(tx *Tx) error {
+		tx.Keys(prefix("g", u64(uid)), func(k string) bool {
+			tx.Delete(k)
+			return true
+		})
+		return nil
+	})
+}
+
+func friendKey(uid, friend uint64) string {
+	return key("f", u64(uid), u64(friend))
+}
+
+func (s *AccountStore) GetFriends(uid uint64) (fs []api0.Friend, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		return scanJSON(tx, prefix("f", u64(uid)), func(_ string, f api0.Friend) bool {
+			fs = append(fs, f)
+			return true
+		})
+	})
+	return
+}
+
+func (s *AccountStore) GetFriend(uid, friend uint64) (*api0.Friend, error) {
+	var f api0.Friend
+	var ok bool
+	if err := s.db.View(func(tx *Tx) (err error) {
+		ok, err = getJSON(tx, friendKey(uid, friend), &f)
+		return
+	}); err != nil || !ok {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (s *AccountStore) SaveFriends(fs []api0.Friend) error {
+	return s.db.Update(func(tx *Tx) error {
+		for _, f := range fs {
+			if err := putJSON(tx, friendKey(f.UID, f.Friend), f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *AccountStore) DeleteFriend(uid, friend uint64) error {
+	return s.db.Update(func(tx *Tx) error {
+		tx.Delete(friendKey(uid, friend))
+		tx.Delete(friendKey(friend, uid))
+		return nil
+	})
+}
+
+func (s *AccountStore) DeleteFriends(uid uint64) error {
+	return s.db.Update(func(tx *Tx) error {
+		return scanJSON(tx, prefix("f", u64(uid)), func(k string, f api0.Friend) bool {
+			tx.Delete(friendKey(f.Friend, uid))
+			tx.Delete(k)
+			return true
+		})
+	})
+}
+
+func blockKey(uid, blocked uint64) string {
+	return key("k", u64(uid), u64(blocked))
+}
+
+func blockIndexKey(blocked, uid uint64) string {
+	return key("kx", u64(blocked), u64(uid))
+}
+
+func (s *AccountStore) GetBlocks(uid uint64) (bs []api0.Block, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		return scanJSON(tx, prefix("k", u64(uid)), func(_ string, b api0.Block) bool {
+			bs = append(bs, b)
+			return true
+		})
+	})
+	return
+}
+
+func (s *AccountStore) GetBlockedBy(uid uint64) (uids []uint64, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		uids, err = scanUIDs(tx, prefix("kx", u64(uid)), 0)
+		return err
+	})
+	return
+}
+
+func (s *AccountStore) GetBlock(uid, blocked uint64) (*api0.Block, error) {
+	var b api0.Block
+	var ok bool
+	if err := s.db.View(func(tx *Tx) (err error) {
+		ok, err = getJSON(tx, blockKey(uid, blocked), &b)
+		return
+	}); err != nil || !ok {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (s *AccountStore) SaveBlock(b api0.Block) error {
+	return s.db.Update(func(tx *Tx) error {
+		tx.Put(blockIndexKey(b.Blocked, b.UID), nil)
+		return putJSON(tx, blockKey(b.UID, b.Blocked), b)
+	})
+}
+
+func (s *AccountStore) DeleteBlock(uid, blocked uint64) error {
+	return s.db.Update(func(tx *Tx) error {
+		tx.Delete(blockKey(uid, blocked))
+		tx.Delete(blockIndexKey(blocked, uid))
+		return nil
+	})
+}
+
+func (s *AccountStore) DeleteBlocks(uid uint64) error {
+	return s.db.Update(func(tx *Tx) error {
+		blocked, err := scanUIDs(tx, prefix("k", u64(uid)), 0)
+		if err != nil {
+			return err
+		}
+		by, err := scanUIDs(tx, prefix("kx", u64(uid)), 0)
+		if err != nil {
+			return err
+		}
+		for _, x := range blocked {
+			tx.Delete(blockKey(uid, x))
+			tx.Delete(blockIndexKey(x, uid))
+		}
+		for _, x := range by {
+			tx.Delete(blockKey(x, uid))
+			tx.Delete(blockIndexKey(uid, x))
+		}
+		return nil
+	})
+}
+
+func matchKey(id string) string {
+	return key("m", id)
+}
+
+func matchPlayerKey(uid uint64, t time.Time, id string) string {
+	return key("mp", u64(uid), tsDesc(t), id)
+}
+
+func matchTimeKey(t time.Time, id string) string {
+	return key("mt", ts(t), id)
+}
+
+// putMatch saves x, replacing the indexes for the existing match o, if any.
+func putMatch(tx *Tx, o, x *api0.MatchRecord) error {
+	if o != nil {
+		for _, p := range o.Players {
+			tx.Delete(matchPlayerKey(p.UID, o.Time, o.ID))
+		}
+		tx.Delete(matchTimeKey(o.Time, o.ID))
+	}
+	k := matchKey(x.ID)
+	for _, p := range x.Players {
+		tx.Put(matchPlayerKey(p.UID, x.Time, x.ID), []byte(k))
+	}
+	tx.Put(matchTimeKey(x.Time, x.ID), []byte(k))
+	return putJSON(tx, k, x)
+}
+
+func (s *AccountStore) GetMatches(uid uint64, before time.Time, limit int) (ms []api0.MatchRecord, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		p := prefix("mp", u64(uid))
+		var err error
+		tx.Keys(p, func(k string) bool {
+			if limit > 0 && len(ms) >= limit {
+				return false
+			}
+			var x api0.MatchRecord
+			var ok bool
+			if ok, err = getJSON(tx, matchKey(lastPart(k)), &x); err != nil {
+				return false
+			}
+			if ok && (before.IsZero() || x.Time.Before(before)) {
+				ms = append(ms, x)
+			}
+			return true
+		})
+		return err
+	})
+	return
+}
+
+func (s *AccountStore) SaveMatch(x *api0.MatchRecord) error {
+	if x == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *Tx) error {
+		var o api0.MatchRecord
+		if ok, err := getJSON(tx, matchKey(x.ID), &o); err != nil {
+			return err
+		} else if ok {
+			return putMatch(tx, &o, x)
+		}
+		return putMatch(tx, nil, x)
+	})
+}
+
+func (s *AccountStore) DeleteMatchesBefore(t time.Time) error {
+	return s.db.Update(func(tx *Tx) error {
+		var ms []api0.MatchRecord
+		end := matchTimeKey(t, "")
+		var err error
+		tx.Keys(prefix("mt"), func(k string) bool {
+			if k >= end {
+				return false
+			}
+			var x api0.MatchRecord
+			var ok bool
+			if ok, err = getJSON(tx, matchKey(lastPart(k)), &x); err != nil {
+				return false
+			}
+			if ok {
+				ms = append(ms, x)
+			}
+			tx.Delete(k)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for _, x := range ms {
+			for _, p := range x.Players {
+				tx.Delete(matchPlayerKey(p.UID, x.Time, x.ID))
+			}
+			tx.Delete(matchKey(x.ID))
+		}
+		return nil
+	})
+}
+
+func (s *AccountStore) DeletePlayerMatches(uid uint64) error {
+	return s.db.Update(func(tx *Tx) error {
+		var err error
+		tx.Keys(prefix("mp", u64(uid)), func(k string) bool {
+			tx.Delete(k)
+
+			var x api0.MatchRecord
+			var ok bool
+			if ok, err = getJSON(tx, matchKey(lastPart(k)), &x); err != nil || !ok {
+				return err == nil
+			}
+			ps := x.Players[:0]
+			for _, p := range x.Players {
+				if p.UID != uid {
+					ps = append(ps, p)
+				}
+			}
+			x.Players = ps
+			err = putJSON(tx, matchKey(x.ID), x)
+			return err == nil
+		})
+		return err
+	})
+}
+
+func muteReportKey(uid uint64, addr netip.Addr, kind api0.MuteKind) string {
+	return key("mr", u64(uid), addr.String(), string(kind))
+}
+
+func (s *AccountStore) GetMuteReports(uid uint64, since time.Time) (rs []api0.MuteReport, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		return scanJSON(tx, prefix("mr", u64(uid)), func(_ string, r api0.MuteReport) bool {
+			if !r.Time.Before(since) {
+				rs = append(rs, r)
+			}
+			return true
+		})
+	})
+	sort.SliceStable(rs, func(i, j int) bool {
+		return rs[i].Time.Before(rs[j].Time)
+	})
+	return
+}
+
+func (s *AccountStore) SaveMuteReport(r api0.MuteReport) error {
+	return s.db.Update(func(tx *Tx) error {
+		return putJSON(tx, muteReportKey(r.UID, r.ServerAddr, r.Kind), r)
+	})
+}
+
+func (s *AccountStore) DeleteMuteReport(uid uint64, addr netip.Addr, kind api0.MuteKind) error {
+	return s.db.Update(func(tx *Tx) error {
+		tx.Delete(muteReportKey(uid, addr, kind))
+		return nil
+	})
+}
+
+func (s *AccountStore) DeleteMuteReportsBefore(t time.Time) error {
+	return s.db.Update(func(tx *Tx) error {
+		return scanJSON(tx, prefix("mr"), func(k string, r api0.MuteReport) bool {
+			if r.Time.Before(t) {
+				tx.Delete(k)
+			}
+			return true
+		})
+	})
+}
+
+func (s *AccountStore) DeletePlayerMuteReports(uid uint64) error {
+	return s.db.Update(func(tx *Tx) error {
+		tx.Keys(prefix("mr", u64(uid)), func(k string) bool {
+			tx.Delete(k)
+			return true
+		})
+		return nil
+	})
+}
+
+func crashKey(signature string) string {
+	return key("c", signature)
+}
+
+func (s *AccountStore) RecordCrash(r api0.CrashReport) error {
+	return s.db.Update(func(tx *Tx) error {
+		var c api0.CrashSignature
+		if ok, err := getJSON(tx, crashKey(r.Signature), &c); err != nil {
+			return err
+		} else if !ok {
+			c = api0.CrashSignature{
+				Signature: r.Signature,
+				First:     r.Time,
+			}
+		}
+		if c.Versions == nil {
+			c.Versions = map[string]int{}
+		}
+		if c.Mods == nil {
+			c.Mods = map[string]int{}
+		}
+		c.Last = r.Time
+		c.Count++
+		c.Fingerprint = r.Fingerprint
+		c.Versions[r.Version]++
+		for _, x := range r.Mods {
+			c.Mods[x]++
+		}
+		return putJSON(tx, crashKey(r.Signature), c)
+	})
+}
+
+func (s *AccountStore) GetCrashes(since time.Time, limit int) (cs []api0.CrashSignature, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		return scanJSON(tx, prefix("c"), func(_ string, c api0.CrashSignature) bool {
+			if !c.Last.Before(since) {
+				cs = append(cs, c)
+			}
+			return true
+		})
+	})
+	sort.SliceStable(cs, func(i, j int) bool {
+		if cs[i].Count != cs[j].Count {
+			return cs[i].Count > cs[j].Count
+		}
+		return cs[i].Last.After(cs[j].Last)
+	})
+	if limit > 0 && len(cs) > limit {
+		cs = cs[:limit]
+	}
+	return
+}
+
+func (s *AccountStore) GetCrash(signature string) (*api0.CrashSignature, error) {
+	var c api0.CrashSignature
+	var ok bool
+	if err := s.db.View(func(tx *Tx) (err error) {
+		ok, err = getJSON(tx, crashKey(signature), &c)
+		return
+	}); err != nil || !ok {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *AccountStore) DeleteCrash(signature string) error {
+	return s.db.Update(func(tx *Tx) error {
+		tx.Delete(crashKey(signature))
+		return nil
+	})
+}
+
+func (s *AccountStore) DeleteCrashesBefore(t time.Time) error {
+	return s.db.Update(func(tx *Tx) error {
+		return scanJSON(tx, prefix("c"), func(k string, c api0.CrashSignature) bool {
+			if c.Last.Before(t) {
+				tx.Delete(k)
+			}
+			return true
+		})
+	})
+}
+
+func stateKey(k string) string {
+	return key("st", k)
+}
+
+func (s *AccountStore) GetState(k string) (buf []byte, exists bool, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		buf, exists, err = tx.Get(stateKey(k))
+		return err
+	})
+	return
+}
+
+func (s *AccountStore) GetStateKeys() (ks []string, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		p := prefix("st")
+		tx.Keys(p, func(k string) bool {
+			ks = append(ks, k[len(p):])
+			return true
+		})
+		return nil
+	})
+	return
+}
+
+func (s *AccountStore) SetState(k string, buf []byte) error {
+	return s.db.Update(func(tx *Tx) error {
+		if buf == nil {
+			tx.Delete(stateKey(k))
+		} else {
+			tx.Put(stateKey(k), append([]byte{}, buf...))
+		}
+		return nil
+	})
+}
+
+func serverStatsKey(addr netip.AddrPort, resolution time.Duration, start time.Time) string {
+	return key("ss", addrPortKey(addr), u64(uint64(resolution)), ts(start))
+}
+
+func addrPortKey(addr netip.AddrPort) string {
+	if !addr.IsValid() {
+		return ""
+	}
+	return addr.String()
+}
+
+func (s *AccountStore) GetServerStats(addr netip.AddrPort, resolution time.Duration, since time.Time) (bs []api0.ServerStatsBucket, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		start := serverStatsKey(addr, resolution, since)
+		return scanJSON(tx, prefix("ss", addrPortKey(addr), u64(uint64(resolution))), func(k string, b api0.ServerStatsBucket) bool {
+			if k >= start {
+				bs = append(bs, b)
+			}
+			return true
+		})
+	})
+	return
+}
+
+func (s *AccountStore) SaveServerStats(bs []api0.ServerStatsBucket) error {
+	return s.db.Update(func(tx *Tx) error {
+		for _, b := range bs {
+			if err := putJSON(tx, serverStatsKey(b.Addr, b.Resolution, b.Start), b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *AccountStore) DeleteServerStats(resolution time.Duration, before time.Time) (n int, err error) {
+	err = s.db.Update(func(tx *Tx) error {
+		n = 0
+		return scanJSON(tx, prefix("ss"), func(k string, b api0.ServerStatsBucket) bool {
+			if b.Resolution == resolution && b.Start.Before(before) {
+				tx.Delete(k)
+				n++
+			}
+			return true
+		})
+	})
+	return
+}
+
+type lease struct {
+	Holder  string
+	Expires time.Time
+}
+
+func leaseKey(name string) string {
+	return key("ls", name)
+}
+
+// AcquireLease acquires or renews a lease for leader election.
+func (s *AccountStore) AcquireLease(name, holder string, ttl time.Duration) (cur string, err error) {
+	err = s.db.Update(func(tx *Tx) error {
+		now := time.Now()
+		var l lease
+		if ok, err := getJSON(tx, leaseKey(name), &l); err != nil {
+			return err
+		} else if ok && l.Holder != holder && now.Before(l.Expires) {
+			cur = l.Holder
+			return nil
+		}
+		cur = holder
+		return putJSON(tx, leaseKey(name), lease{holder, now.Add(ttl)})
+	})
+	return
+}
+
+func (s *AccountStore) ReleaseLease(name, holder string) error {
+	return s.db.Update(func(tx *Tx) error {
+		var l lease
+		if ok, err := getJSON(tx, leaseKey(name), &l); err != nil || !ok {
+			return err
+		}
+		if l.Holder == holder {
+			tx.Delete(leaseKey(name))
+		}
+		return nil
+	})
+}
+
+func outboxKey(id string) string {
+	return key("o", id)
+}
+
+func outboxDedupKey(queue, k string) string {
+	return key("ok", queue, k)
+}
+
+func outboxPendingKey(queue string, created time.Time, id string) string {
+	return key("op", queue, ts(created), id)
+}
+
+func (s *AccountStore) PutOutbox(it outbox.Item) (added bool, err error) {
+	err = s.db.Update(func(tx *Tx) error {
+		k := outboxKey(it.ID)
+		if tx.Has(k) {
+			return nil
+		}
+		if it.Key != "" {
+			if tx.Has(outboxDedupKey(it.Queue, it.Key)) {
+				return nil
+			}
+			tx.Put(outboxDedupKey(it.Queue, it.Key), []byte(k))
+		}
+		if it.State == outbox.StatePending {
+			tx.Put(outboxPendingKey(it.Queue, it.Created, it.ID), []byte(k))
+		}
+		added = true
+		return putJSON(tx, k, it)
+	})
+	return
+}
+
+func (s *AccountStore) ClaimOutbox(queue string, now, until time.Time, n int) (its []outbox.Item, err error) {
+	err = s.db.Update(func(tx *Tx) error {
+		var err error
+		tx.Keys(prefix("op", queue), func(k string) bool {
+			if len(its) >= n {
+				return false
+			}
+			var it outbox.Item
+			var ok bool
+			if ok, err = getJSON(tx, outboxKey(lastPart(k)), &it); err != nil {
+				return false
+			}
+			if !ok || it.Next.After(now) {
+				return true
+			}
+			it.Next = until
+			if err = putJSON(tx, outboxKey(it.ID), it); err != nil {
+				return false
+			}
+			its = append(its, it)
+			return true
+		})
+		return err
+	})
+	return
+}
+
+func (s *AccountStore) UpdateOutbox(it outbox.Item) error {
+	return s.db.Update(func(tx *Tx) error {
+		var o outbox.Item
+		if ok, err := getJSON(tx, outboxKey(it.ID), &o); err != nil || !ok {
+			return err
+		}
+		if o.State == outbox.StatePending && it.State != outbox.StatePending {
+			tx.Delete(outboxPendingKey(o.Queue, o.Created, o.ID))
+		}
+		if o.State != outbox.StatePending && it.State == outbox.StatePending {
+			tx.Put(outboxPendingKey(o.Queue, o.Created, o.ID), []byte(outboxKey(o.ID)))
+		}
+		o.State, o.Attempts, o.Next, o.Error = it.State, it.Attempts, it.Next, it.Error
+		return putJSON(tx, outboxKey(o.ID), o)
+	})
+}
+
+func (s *AccountStore) PruneOutbox(queue string, before time.Time) (n int, err error) {
+	err = s.db.Update(func(tx *Tx) error {
+		n = 0
+		return scanJSON(tx, prefix("o"), func(k string, it outbox.Item) bool {
+			if it.Queue == queue && it.State != outbox.StatePending && it.Next.Before(before) {
+				if it.Key != "" {
+					tx.Delete(outboxDedupKey(it.Queue, it.Key))
+				}
+				tx.Delete(k)
+				n++
+			}
+			return true
+		})
+	})
+	return
+}
+
+func (s *AccountStore) Compact(ctx context.Context) (int64, error) {
+	return compact(s.db)
+}
+
+// compact compacts db, returning the number of bytes reclaimed.
+func compact(db *DB) (int64, error) {
+	before := db.Size()
+	if err := db.Compact(); err != nil {
+		return 0, err
+	}
+	return before - db.Size(), nil
+}
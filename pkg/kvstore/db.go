@@ -0,0 +1,561 @@
+// Package kvstore implements embedded storage for atlas in a pure-Go
+// append-only key-value log, so Atlas can run as a single static binary with
+// a data directory while still being durable.
+//
+// Each transaction is appended to the log as a single checksummed record and
+// synced before it is applied, so committed transactions survive crashes and a
+// partially-written record at the end of the log is discarded when it is next
+// opened. All keys and their locations in the log are kept in memory, and the
+// log is compacted when more than half of it is overwritten data. Only one
+// process may open a log at a time, which is enforced with an exclusive lock on
+// a separate lock file next to it.
+package kvstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// ErrClosed is returned when using a closed DB.
+var ErrClosed = errors.New("kvstore: db is closed")
+
+// ErrLocked is returned when opening a DB which is already open.
+var ErrLocked = errors.New("kvstore: db is already open in another process")
+
+const (
+	magic        = "ATLASKV\x01"
+	headerSize   = 8       // crc32c | payload length
+	maxRecord    = 1 << 30 // sanity limit for payload lengths
+	compactMin   = 4 << 20 // minimum garbage before compacting automatically
+	compactBatch = 1 << 20 // target record size while compacting
+
+	opPut    = 1
+	opDelete = 2
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// loc is the location of a value in the log.
+type loc struct {
+	off int64
+	n   int64
+}
+
+// DB is a key-value log. It is safe for concurrent use, but can only be
+// opened by one process at a time.
+type DB struct {
+	// Logger is used to log errors from automatic compaction.
+	Logger zerolog.Logger
+
+	mu      sync.RWMutex
+	name    string
+	lock    *os.File // held open for the exclusive lock
+	f       *os.File
+	end     int64 // end of the last valid record
+	index   *index
+	live    int64 // approximate bytes used by current values
+	garbage int64 // approximate bytes used by overwritten values
+	err     error // if set, the log is in an unknown state
+}
+
+// Open opens or creates the DB at the provided filename. If the log ends with
+// a partially-written record, it is truncated. If the DB is already open, it
+// returns ErrLocked.
+func Open(name string) (*DB, error) {
+	// the log itself can't be locked since it's replaced when compacting
+	lock, err := os.OpenFile(name+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("lock %q: %w", name, err)
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	db := &DB{
+		name:  name,
+		lock:  lock,
+		f:     f,
+		index: newIndex(),
+	}
+	if err := db.load(); err != nil {
+		f.Close()
+		lock.Close()
+		return nil, fmt.Errorf("load %q: %w", name, err)
+	}
+	return db, nil
+}
+
+// load replays the log into the index.
+func (db *DB) load() error {
+	fi, err := db.f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		if _, err := db.f.WriteAt([]byte(magic), 0); err != nil {
+			return err
+		}
+		if err := db.f.Sync(); err != nil {
+			return err
+		}
+		if err := syncDir(filepath.Dir(db.name)); err != nil {
+			return err
+		}
+		db.end = int64(len(magic))
+		return nil
+	}
+
+	r := bufio.NewReaderSize(io.NewSectionReader(db.f, 0, fi.Size()), 1<<16)
+
+	hdr := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, hdr[:len(magic)]); err != nil || string(hdr[:len(magic)]) != magic {
+		return fmt.Errorf("not a kvstore log")
+	}
+	db.end = int64(len(magic))
+
+	var buf []byte
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			break // EOF or torn header
+		}
+		sum, n := binary.LittleEndian.Uint32(hdr[0:]), binary.LittleEndian.Uint32(hdr[4:])
+		if n > maxRecord || db.end+headerSize+int64(n) > fi.Size() {
+			break // torn record
+		}
+		if cap(buf) < int(n) {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			break
+		}
+		if crc32.Checksum(buf, crcTable) != sum {
+			break // torn record
+		}
+		if err := db.replay(db.end+headerSize, buf); err != nil {
+			return fmt.Errorf("record at offset %d: %w", db.end, err)
+		}
+		db.end += headerSize + int64(n)
+	}
+	if db.end != fi.Size() {
+		if err := db.f.Truncate(db.end); err != nil {
+			return fmt.Errorf("truncate torn record: %w", err)
+		}
+		if err := db.f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replay applies the operations in the record payload buf, which starts at
+// off in the log.
+func (db *DB) replay(off int64, buf []byte) error {
+	for i := 0; i < len(buf); {
+		op := buf[i]
+		i++
+		kn, x := binary.Uvarint(buf[i:])
+		if x <= 0 || uint64(len(buf)-i-x) < kn {
+			return fmt.Errorf("invalid key length")
+		}
+		i += x
+		key := string(buf[i : i+int(kn)])
+		i += int(kn)
+		switch op {
+		case opPut:
+			vn, x := binary.Uvarint(buf[i:])
+			if x <= 0 || uint64(len(buf)-i-x) < vn {
+				return fmt.Errorf("invalid value length")
+			}
+			i += x
+			db.apply(key, loc{off + int64(i), int64(vn)}, true)
+			i += int(vn)
+		case opDelete:
+			db.apply(key, loc{}, false)
+		default:
+			return fmt.Errorf("invalid op %d", op)
+		}
+	}
+	return nil
+}
+
+// apply updates the index for a put or delete.
+func (db *DB) apply(key string, l loc, put bool) {
+	var o loc
+	var ok bool
+	if put {
+		o, ok = db.index.set(key, l)
+		db.live += int64(len(key)) + l.n
+	} else {
+		o, ok = db.index.delete(key)
+	}
+	if ok {
+		db.live -= int64(len(key)) + o.n
+		db.garbage += int64(len(key)) + o.n
+	}
+}
+
+func (db *DB) read(l loc) ([]byte, error) {
+	b := make([]byte, l.n)
+	if _, err := db.f.ReadAt(b, l.off); err != nil {
+		return nil, fmt.Errorf("read value: %w", err)
+	}
+	return b, nil
+}
+
+// Len returns the number of keys in the DB.
+func (db *DB) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.index.len
+}
+
+// Size gets the size of the log in bytes.
+func (db *DB) Size() int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.end
+}
+
+// View runs fn in a read-only transaction.
+func (db *DB) View(fn func(tx *Tx) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.f == nil {
+		return ErrClosed
+	}
+	return fn(&Tx{db: db})
+}
+
+// Update runs fn in a read-write transaction. If fn returns an error, the
+// changes are discarded. Otherwise, they are synced to disk before Update
+// returns. If the log needs to be compacted afterwards and compaction fails,
+// the error is logged and compaction is retried on the next update.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.f == nil {
+		return ErrClosed
+	}
+	if db.err != nil {
+		return db.err
+	}
+	tx := &Tx{db: db, w: map[string]*[]byte{}}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := db.commit(tx.w); err != nil {
+		return err
+	}
+	if db.garbage > compactMin && db.garbage > db.live {
+		if err := db.compact(); err != nil {
+			db.Logger.Warn().Err(err).Str("name", db.name).Msg("failed to compact log")
+		}
+	}
+	return nil
+}
+
+// commit appends the pending writes (nil for deletions) to the log.
+func (db *DB) commit(w map[string]*[]byte) error {
+	if len(w) == 0 {
+		return nil
+	}
+	ks := make([]string, 0, len(w))
+	for k := range w {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+
+	buf := make([]byte, headerSize, 256)
+	locs := make([]loc, len(ks))
+	for i, k := range ks {
+		if v := w[k]; v != nil {
+			buf = append(buf, opPut)
+			buf = binary.AppendUvarint(buf, uint64(len(k)))
+			buf = append(buf, k...)
+			buf = binary.AppendUvarint(buf, uint64(len(*v)))
+			locs[i] = loc{db.end + int64(len(buf)), int64(len(*v))}
+			buf = append(buf, *v...)
+		} else {
+			buf = append(buf, opDelete)
+			buf = binary.AppendUvarint(buf, uint64(len(k)))
+			buf = append(buf, k...)
+		}
+	}
+	if len(buf)-headerSize > maxRecord {
+		return fmt.Errorf("transaction too large (%d bytes)", len(buf)-headerSize)
+	}
+	binary.LittleEndian.PutUint32(buf[0:], crc32.Checksum(buf[headerSize:], crcTable))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(buf)-headerSize))
+
+	if _, err := db.f.WriteAt(buf, db.end); err != nil {
+		return db.rollback(fmt.Errorf("write: %w", err))
+	}
+	if err := db.f.Sync(); err != nil {
+		return db.rollback(fmt.Errorf("sync: %w", err))
+	}
+	db.end += int64(len(buf))
+
+	for i, k := range ks {
+		db.apply(k, locs[i], w[k] != nil)
+	}
+	return nil
+}
+
+// rollback attempts to truncate a partially-written record, marking the DB as
+// failed if it can't be.
+func (db *DB) rollback(err error) error {
+	if terr := db.f.Truncate(db.end); terr != nil {
+		db.err = fmt.Errorf("kvstore: log is in an unknown state after failed write (%v): %w", err, terr)
+	}
+	return err
+}
+
+// Compact rewrites the log with only the current values.
+func (db *DB) Compact() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.f == nil {
+		return ErrClosed
+	}
+	if db.err != nil {
+		return db.err
+	}
+	return db.compact()
+}
+
+func (db *DB) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(db.name), "."+filepath.Base(db.name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer func() {
+		tmp.Close()
+	}()
+
+	w := bufio.NewWriterSize(tmp, 1<<16)
+	if _, err := w.WriteString(magic); err != nil {
+		return err
+	}
+	var (
+		end  = int64(len(magic))
+		buf  = make([]byte, headerSize, compactBatch+1024)
+		locs = make([]loc, 0, db.index.len)
+	)
+	flush := func() error {
+		if len(buf) == headerSize {
+			return nil
+		}
+		binary.LittleEndian.PutUint32(buf[0:], crc32.Checksum(buf[headerSize:], crcTable))
+		binary.LittleEndian.PutUint32(buf[4:], uint32(len(buf)-headerSize))
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		end += int64(len(buf))
+		buf = buf[:headerSize]
+		return nil
+	}
+	for n := db.index.head.next[0]; n != nil; n = n.next[0] {
+		v, err := db.read(n.loc)
+		if err != nil {
+			return err
+		}
+		if len(buf) > headerSize && len(buf)+len(n.key)+len(v) > compactBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		buf = append(buf, opPut)
+		buf = binary.AppendUvarint(buf, uint64(len(n.key)))
+		buf = append(buf, n.key...)
+		buf = binary.AppendUvarint(buf, uint64(len(v)))
+		locs = append(locs, loc{end + int64(len(buf)), int64(len(v))})
+		buf = append(buf, v...)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), db.name); err != nil {
+		return err
+	}
+
+	// the old log is closed by the defer
+	db.f, tmp = tmp, db.f
+	db.end = end
+	i := 0
+	for n := db.index.head.next[0]; n != nil; n = n.next[0] {
+		n.loc = locs[i]
+		i++
+	}
+	db.garbage = 0
+
+	// if this fails, the rename may not be durable yet, but it doesn't matter
+	// since both logs contain the same data
+	_ = syncDir(filepath.Dir(db.name))
+	return nil
+}
+
+// Close closes the DB.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.f == nil {
+		return ErrClosed
+	}
+	err := db.f.Close()
+	db.f = nil
+	if lerr := db.lock.Close(); err == nil {
+		err = lerr
+	}
+	return err
+}
+
+func syncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
+
+// Tx is a transaction. It must not be used after the function it was passed
+// to returns.
+type Tx struct {
+	db *DB
+	w  map[string]*[]byte // pending writes, nil if read-only
+}
+
+// Get gets the value of key. The returned slice may be modified.
+func (tx *Tx) Get(key string) ([]byte, bool, error) {
+	if v, ok := tx.w[key]; ok {
+		if v == nil {
+			return nil, false, nil
+		}
+		return append([]byte{}, *v...), true, nil
+	}
+	l, ok := tx.db.index.get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	v, err := tx.db.read(l)
+	return v, err == nil, err
+}
+
+// Has checks whether key exists.
+func (tx *Tx) Has(key string) bool {
+	if v, ok := tx.w[key]; ok {
+		return v != nil
+	}
+	_, ok := tx.db.index.get(key)
+	return ok
+}
+
+// Put sets the value of key. The value must not be modified afterwards.
+func (tx *Tx) Put(key string, val []byte) {
+	if tx.w == nil {
+		panic("kvstore: put in read-only transaction")
+	}
+	if val == nil {
+		val = []byte{}
+	}
+	tx.w[key] = &val
+}
+
+// Delete deletes key if it exists.
+func (tx *Tx) Delete(key string) {
+	if tx.w == nil {
+		panic("kvstore: delete in read-only transaction")
+	}
+	tx.w[key] = nil
+}
+
+// Keys calls fn for each key starting with prefix in ascending order until it
+// returns false. Keys may be modified by fn.
+func (tx *Tx) Keys(prefix string, fn func(key string) bool) {
+	// pending writes with the prefix, in order
+	var pk []string
+	for k := range tx.w {
+		if strings.HasPrefix(k, prefix) {
+			pk = append(pk, k)
+		}
+	}
+	sort.Strings(pk)
+
+	// fn may modify the index in a read-write transaction, so collect the
+	// keys first
+	var ks []string
+	for n := tx.db.index.seek(prefix, nil); n != nil && strings.HasPrefix(n.key, prefix); n = n.next[0] {
+		if tx.w == nil {
+			if !fn(n.key) {
+				return
+			}
+			continue
+		}
+		ks = append(ks, n.key)
+	}
+	if tx.w == nil {
+		return
+	}
+	for len(ks) != 0 || len(pk) != 0 {
+		var k string
+		switch {
+		case len(pk) == 0 || (len(ks) != 0 && ks[0] < pk[0]):
+			k, ks = ks[0], ks[1:]
+		case len(ks) == 0 || pk[0] < ks[0]:
+			k, pk = pk[0], pk[1:]
+		default:
+			k, ks, pk = ks[0], ks[1:], pk[1:]
+		}
+		if v, ok := tx.w[k]; ok && v == nil {
+			continue
+		}
+		if !fn(k) {
+			return
+		}
+	}
+}
+
+// Scan calls fn for each key starting with prefix and its value in ascending
+// order until it returns false.
+func (tx *Tx) Scan(prefix string, fn func(key string, val []byte) bool) error {
+	var err error
+	tx.Keys(prefix, func(key string) bool {
+		var v []byte
+		if v, _, err = tx.Get(key); err != nil {
+			return false
+		}
+		return fn(key, v)
+	})
+	return err
+}
@@ -0,0 +1,99 @@
+package kvstore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sep separates the components of keys. Components may contain anything else,
+// but only the last component of a key may be variable-length if the key is
+// parsed.
+const sep = "\x00"
+
+// key joins key components.
+func key(parts ...string) string {
+	return strings.Join(parts, sep)
+}
+
+// prefix joins key components into a prefix for scanning.
+func prefix(parts ...string) string {
+	return key(parts...) + sep
+}
+
+// lastPart gets the last component of a key.
+func lastPart(k string) string {
+	return k[strings.LastIndex(k, sep)+1:]
+}
+
+// u64 encodes x as fixed-width hex, so it sorts numerically.
+func u64(x uint64) string {
+	var b [8]byte
+	for i := range b {
+		b[i] = byte(x >> (56 - 8*i))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// parseU64 decodes a value encoded by u64.
+func parseU64(s string) (uint64, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("invalid key component %q", s)
+	}
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// ts encodes t so it sorts chronologically.
+func ts(t time.Time) string {
+	return u64(uint64(t.Unix())^1<<63) + u64(uint64(t.Nanosecond()))[8:]
+}
+
+// tsDesc encodes t so it sorts in reverse chronological order.
+func tsDesc(t time.Time) string {
+	return u64(^(uint64(t.Unix()) ^ 1<<63)) + u64(^uint64(t.Nanosecond()))[8:]
+}
+
+func encode(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// decode decodes a value encoded by encode into v, which must be a pointer.
+func decode(buf []byte, v any) error {
+	if err := json.Unmarshal(buf, v); err != nil {
+		return fmt.Errorf("decode %T: %w", v, err)
+	}
+	localize(reflect.ValueOf(v))
+	return nil
+}
+
+// localize converts non-zero times in v to local time, since they are decoded
+// from JSON with a fixed offset, but the other storage backends return local
+// times.
+func localize(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			localize(v.Elem())
+		}
+	case reflect.Struct:
+		if t, ok := v.Addr().Interface().(*time.Time); ok {
+			if !t.IsZero() {
+				*t = t.Local()
+			}
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				localize(v.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			localize(v.Index(i))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package kvstore
+
+// index is an ordered in-memory index of the keys in the log, implemented as
+// a skip list. It is not safe for concurrent modification, but reads may run
+// concurrently with each other.
+type index struct {
+	head  node
+	level int
+	len   int
+	rnd   uint64
+}
+
+const indexMaxLevel = 24
+
+type node struct {
+	key  string
+	loc  loc
+	next []*node
+}
+
+func newIndex() *index {
+	return &index{
+		head:  node{next: make([]*node, indexMaxLevel)},
+		level: 1,
+		rnd:   0x9e3779b97f4a7c15,
+	}
+}
+
+// seek returns the first node with a key >= key, filling prev (if non-nil)
+// with the last node before it at each level.
+func (x *index) seek(key string, prev *[indexMaxLevel]*node) *node {
+	n := &x.head
+	for i := x.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].key < key {
+			n = n.next[i]
+		}
+		if prev != nil {
+			prev[i] = n
+		}
+	}
+	return n.next[0]
+}
+
+// get gets the location of key.
+func (x *index) get(key string) (loc, bool) {
+	if n := x.seek(key, nil); n != nil && n.key == key {
+		return n.loc, true
+	}
+	return loc{}, false
+}
+
+// set sets the location of key, returning the old one, if any.
+func (x *index) set(key string, l loc) (loc, bool) {
+	var prev [indexMaxLevel]*node
+	if n := x.seek(key, &prev); n != nil && n.key == key {
+		o := n.loc
+		n.loc = l
+		return o, true
+	}
+	lvl := x.randomLevel()
+	if lvl > x.level {
+		for i := x.level; i < lvl; i++ {
+			prev[i] = &x.head
+		}
+		x.level = lvl
+	}
+	n := &node{
+		key:  key,
+		loc:  l,
+		next: make([]*node, lvl),
+	}
+	for i := 0; i < lvl; i++ {
+		n.next[i] = prev[i].next[i]
+		prev[i].next[i] = n
+	}
+	x.len++
+	return loc{}, false
+}
+
+// delete removes key, returning its old location, if any.
+func (x *index) delete(key string) (loc, bool) {
+	var prev [indexMaxLevel]*node
+	n := x.seek(key, &prev)
+	if n == nil || n.key != key {
+		return loc{}, false
+	}
+	for i := 0; i < len(n.next); i++ {
+		prev[i].next[i] = n.next[i]
+	}
+	for x.level > 1 && x.head.next[x.level-1] == nil {
+		x.level--
+	}
+	x.len--
+	return n.loc, true
+}
+
+func (x *index) randomLevel() int {
+	// xorshift64
+	x.rnd ^= x.rnd << 13
+	x.rnd ^= x.rnd >> 7
+	x.rnd ^= x.rnd << 17
+	lvl, r := 1, x.rnd
+	for lvl < indexMaxLevel && r&3 == 0 {
+		lvl++
+		r >>= 2
+	}
+	return lvl
+}
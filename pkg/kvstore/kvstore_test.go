@@ -0,0 +1,279 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/r2northstar/atlas/pkg/api/api0/api0testutil"
+	"github.com/r2northstar/atlas/pkg/leader/leadertest"
+	"github.com/r2northstar/atlas/pkg/outbox/outboxtest"
+)
+
+func openAccountStore(t *testing.T) *AccountStore {
+	s, err := OpenAccountStore(filepath.Join(t.TempDir(), "accounts.kv"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func openPdataStore(t *testing.T) *PdataStore {
+	s, err := OpenPdataStore(filepath.Join(t.TempDir(), "pdata.kv"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestAccountStore(t *testing.T) {
+	api0testutil.TestAccountStorage(t, openAccountStore(t))
+}
+
+func TestPdataStore(t *testing.T) {
+	api0testutil.TestPdataStorage(t, openPdataStore(t))
+}
+
+func TestAccountLinkStore(t *testing.T) {
+	api0testutil.TestAccountLinkStorage(t, openAccountStore(t))
+}
+
+func TestUsernameHistoryStore(t *testing.T) {
+	api0testutil.TestUsernameHistoryStorage(t, openAccountStore(t))
+}
+
+func TestAccountSignalStore(t *testing.T) {
+	api0testutil.TestAccountSignalStorage(t, openAccountStore(t))
+}
+
+func TestBanListStore(t *testing.T) {
+	api0testutil.TestBanListStorage(t, openAccountStore(t))
+}
+
+func TestAbuseReportStore(t *testing.T) {
+	api0testutil.TestAbuseReportStorage(t, openAccountStore(t))
+}
+
+func TestRatingStore(t *testing.T) {
+	api0testutil.TestRatingStorage(t, openAccountStore(t))
+}
+
+func TestMatchHistoryStore(t *testing.T) {
+	api0testutil.TestMatchHistoryStorage(t, openAccountStore(t))
+}
+
+func TestMuteReportStore(t *testing.T) {
+	api0testutil.TestMuteReportStorage(t, openAccountStore(t))
+}
+
+func TestFriendStore(t *testing.T) {
+	api0testutil.TestFriendStorage(t, openAccountStore(t))
+}
+
+func TestBlockStore(t *testing.T) {
+	api0testutil.TestBlockStorage(t, openAccountStore(t))
+}
+
+func TestCrashStore(t *testing.T) {
+	api0testutil.TestCrashStorage(t, openAccountStore(t))
+}
+
+func TestStateStore(t *testing.T) {
+	api0testutil.TestStateStorage(t, openAccountStore(t))
+}
+
+func TestServerStatsStore(t *testing.T) {
+	api0testutil.TestServerStatsStorage(t, openAccountStore(t))
+}
+
+func TestAccountListStore(t *testing.T) {
+	api0testutil.TestAccountListStorage(t, openAccountStore(t))
+}
+
+func TestPdataListStore(t *testing.T) {
+	api0testutil.TestPdataListStorage(t, openPdataStore(t))
+}
+
+func TestPdataCompactStore(t *testing.T) {
+	api0testutil.TestPdataCompactStorage(t, openPdataStore(t))
+}
+
+func TestStateListStore(t *testing.T) {
+	api0testutil.TestStateListStorage(t, openAccountStore(t))
+}
+
+func TestLeaseStore(t *testing.T) {
+	leadertest.TestBackend(t, openAccountStore(t))
+}
+
+func TestOutboxStore(t *testing.T) {
+	outboxtest.TestBackend(t, openAccountStore(t))
+}
+
+func TestDB(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.kv")
+
+	db, err := Open(name)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			tx.Put(fmt.Sprintf("k%03d", i), []byte(fmt.Sprint(i)))
+			if i%2 == 1 {
+				tx.Delete(fmt.Sprintf("k%03d", i-1))
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+	if err := db.Update(func(tx *Tx) error {
+		tx.Put("k999", []byte("discarded"))
+		return fmt.Errorf("rollback")
+	}); err == nil {
+		t.Fatalf("expected error from update")
+	}
+	check := func(t *testing.T, db *DB) {
+		t.Helper()
+		if n := db.Len(); n != 50 {
+			t.Errorf("expected 50 keys, got %d", n)
+		}
+		if err := db.View(func(tx *Tx) error {
+			var i int
+			if err := tx.Scan("k", func(k string, v []byte) bool {
+				if exp := fmt.Sprintf("k%03d", i*2+1); k != exp || string(v) != fmt.Sprint(i*2+1) {
+					t.Errorf("scan: expected %q, got %q=%q", exp, k, v)
+				}
+				i++
+				return true
+			}); err != nil {
+				return err
+			}
+			if tx.Has("k999") {
+				t.Errorf("expected failed transaction to be discarded")
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("view: %v", err)
+		}
+	}
+	check(t, db)
+
+	t.Run("Pending", func(t *testing.T) {
+		if err := db.Update(func(tx *Tx) error {
+			tx.Put("k000", []byte("x"))
+			tx.Delete("k001")
+			var ks []string
+			tx.Keys("k00", func(k string) bool {
+				ks = append(ks, k)
+				return true
+			})
+			if fmt.Sprint(ks) != "[k000 k003 k005 k007 k009]" {
+				t.Errorf("incorrect keys with pending writes: %q", ks)
+			}
+			if v, ok, _ := tx.Get("k000"); !ok || string(v) != "x" {
+				t.Errorf("expected pending write to be visible")
+			}
+			return fmt.Errorf("rollback")
+		}); err == nil {
+			t.Fatalf("expected error from update")
+		}
+	})
+
+	t.Run("Reopen", func(t *testing.T) {
+		if err := db.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		if db, err = Open(name); err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		check(t, db)
+	})
+
+	t.Run("Locked", func(t *testing.T) {
+		if x, err := Open(name); !errors.Is(err, ErrLocked) {
+			if x != nil {
+				x.Close()
+			}
+			t.Fatalf("expected ErrLocked when opening an open db, got %v", err)
+		}
+		check(t, db)
+	})
+
+	t.Run("Torn", func(t *testing.T) {
+		if err := db.Update(func(tx *Tx) error {
+			tx.Put("k999", bytes.Repeat([]byte{'x'}, 100))
+			return nil
+		}); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(name, fi.Size()-10); err != nil {
+			t.Fatal(err)
+		}
+		if db, err = Open(name); err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		check(t, db)
+		if err := db.Update(func(tx *Tx) error {
+			tx.Put("k999", []byte("y"))
+			return nil
+		}); err != nil {
+			t.Fatalf("update after truncation: %v", err)
+		}
+		db.Close()
+		if db, err = Open(name); err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		if err := db.Update(func(tx *Tx) error {
+			if v, ok, err := tx.Get("k999"); err != nil || !ok || string(v) != "y" {
+				t.Errorf("expected write after truncation to persist, got %q (err: %v)", v, err)
+			}
+			tx.Delete("k999")
+			return nil
+		}); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	})
+
+	t.Run("Compact", func(t *testing.T) {
+		before, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Compact(); err != nil {
+			t.Fatalf("compact: %v", err)
+		}
+		after, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after.Size() >= before.Size() {
+			t.Errorf("expected compaction to shrink the log (%d -> %d bytes)", before.Size(), after.Size())
+		}
+		check(t, db)
+
+		db.Close()
+		if db, err = Open(name); err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		check(t, db)
+	})
+
+	db.Close()
+	if err := db.View(func(*Tx) error { return nil }); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package kvstore
+
+import "os"
+
+// lockFile does nothing since file locking isn't supported on this platform.
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,140 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// PdataStore stores gzip-compressed pdata in a DB.
+type PdataStore struct {
+	db    *DB
+	gzipW sync.Pool
+	gzipR sync.Pool
+}
+
+// OpenPdataStore opens or creates a PdataStore at the provided filename.
+func OpenPdataStore(name string) (*PdataStore, error) {
+	db, err := Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &PdataStore{db: db}, nil
+}
+
+// DB gets the underlying DB.
+func (s *PdataStore) DB() *DB {
+	return s.db
+}
+
+func (s *PdataStore) Close() error {
+	return s.db.Close()
+}
+
+// the hash is stored separately so it can be checked without reading the
+// pdata
+func pdataKey(uid uint64) string {
+	return key("p", u64(uid))
+}
+
+func pdataHashKey(uid uint64) string {
+	return key("ph", u64(uid))
+}
+
+func (s *PdataStore) GetPdataHash(uid uint64) (hash [sha256.Size]byte, exists bool, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		var buf []byte
+		if buf, exists, err = tx.Get(pdataHashKey(uid)); err == nil && exists {
+			copy(hash[:], buf)
+		}
+		return err
+	})
+	return
+}
+
+func (s *PdataStore) GetPdataUIDs() (uids []uint64, err error) {
+	err = s.db.View(func(tx *Tx) error {
+		uids, err = scanUIDs(tx, prefix("ph"), 0)
+		return err
+	})
+	return
+}
+
+func (s *PdataStore) GetPdataCached(uid uint64, sha [sha256.Size]byte) (buf []byte, exists bool, err error) {
+	var zbuf []byte
+	if err = s.db.View(func(tx *Tx) error {
+		var h []byte
+		if h, exists, err = tx.Get(pdataHashKey(uid)); err != nil || !exists {
+			return err
+		}
+		if sha != [sha256.Size]byte{} && bytes.Equal(h, sha[:]) {
+			return nil
+		}
+		zbuf, _, err = tx.Get(pdataKey(uid))
+		return err
+	}); err != nil || zbuf == nil {
+		return nil, exists, err
+	}
+
+	var zr *gzip.Reader
+	if o := s.gzipR.Get(); o == nil {
+		if zr, err = gzip.NewReader(bytes.NewReader(zbuf)); err != nil {
+			return nil, exists, err
+		}
+	} else {
+		zr = o.(*gzip.Reader)
+		if err = zr.Reset(bytes.NewReader(zbuf)); err != nil {
+			return nil, exists, err
+		}
+	}
+	defer s.gzipR.Put(zr)
+
+	if buf, err = io.ReadAll(zr); err != nil {
+		return nil, exists, err
+	}
+	return buf, exists, nil
+}
+
+func (s *PdataStore) SetPdata(uid uint64, buf []byte) (int, error) {
+	var zbuf bytes.Buffer
+	zw, _ := s.gzipW.Get().(*gzip.Writer)
+	if zw == nil {
+		zw = gzip.NewWriter(&zbuf)
+	} else {
+		zw.Reset(&zbuf)
+	}
+	defer s.gzipW.Put(zw)
+
+	if _, err := zw.Write(buf); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+
+	hash := sha256.Sum256(buf)
+	if err := s.db.Update(func(tx *Tx) error {
+		tx.Put(pdataHashKey(uid), hash[:])
+		tx.Put(pdataKey(uid), zbuf.Bytes())
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return zbuf.Len(), nil
+}
+
+func (s *PdataStore) DeletePdata(uid uint64) error {
+	return s.db.Update(func(tx *Tx) error {
+		tx.Delete(pdataHashKey(uid))
+		tx.Delete(pdataKey(uid))
+		return nil
+	})
+}
+
+func (s *PdataStore) Compact(ctx context.Context) (int64, error) {
+	return compact(s.db)
+}
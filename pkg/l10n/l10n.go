@@ -0,0 +1,120 @@
+// Package l10n contains message catalogs for player-facing strings.
+package l10n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//go:embed catalogs/*.json
+var catalogFS embed.FS
+
+// Catalog contains messages by key for each lowercase language tag (e.g., fr,
+// pt-br).
+type Catalog map[string]map[string]string
+
+var (
+	defaultOnce sync.Once
+	defaultCat  Catalog
+)
+
+// Default returns the built-in catalog. The keys are API error codes (e.g.,
+// RATE_LIMITED). English isn't included since it is the default for the
+// messages themselves. It must not be modified.
+func Default() Catalog {
+	defaultOnce.Do(func() {
+		es, err := catalogFS.ReadDir("catalogs")
+		if err != nil {
+			panic(fmt.Errorf("l10n: read catalogs: %w", err))
+		}
+		defaultCat = Catalog{}
+		for _, e := range es {
+			buf, err := catalogFS.ReadFile(path.Join("catalogs", e.Name()))
+			if err != nil {
+				panic(fmt.Errorf("l10n: read catalog %q: %w", e.Name(), err))
+			}
+			var m map[string]string
+			if err := json.Unmarshal(buf, &m); err != nil {
+				panic(fmt.Errorf("l10n: parse catalog %q: %w", e.Name(), err))
+			}
+			defaultCat[strings.TrimSuffix(e.Name(), ".json")] = m
+		}
+	})
+	return defaultCat
+}
+
+// Languages gets the sorted language tags in c.
+func (c Catalog) Languages() []string {
+	ls := make([]string, 0, len(c))
+	for l := range c {
+		ls = append(ls, l)
+	}
+	sort.Strings(ls)
+	return ls
+}
+
+// Validate checks that the language tags in c are lowercase and valid-looking,
+// and the keys and messages are non-empty.
+func (c Catalog) Validate() error {
+	for l, m := range c {
+		if !ValidLanguage(l) {
+			return fmt.Errorf("invalid language tag %q (must be lowercase, e.g., pt-br)", l)
+		}
+		for k, v := range m {
+			if k == "" {
+				return fmt.Errorf("language %q: empty key", l)
+			}
+			if v == "" {
+				return fmt.Errorf("language %q: key %q: empty message", l, k)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidLanguage checks if l is a lowercase language tag consisting of
+// alphanumeric subtags of up to 8 characters separated by hyphens.
+func ValidLanguage(l string) bool {
+	if l == "" || len(l) > 35 {
+		return false
+	}
+	for _, s := range strings.Split(l, "-") {
+		if s == "" || len(s) > 8 {
+			return false
+		}
+		for _, c := range s {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Lookup gets the message for key in the first of langs which has it in any of
+// cs (earlier catalogs taking precedence), falling back from regional variants
+// to the base language (e.g., pt-br to pt) before trying the next language. It
+// returns the language of the message.
+func Lookup(key string, langs []string, cs ...Catalog) (msg, lang string, ok bool) {
+	for _, lang := range langs {
+		lang = strings.ToLower(lang)
+		for {
+			for _, c := range cs {
+				if msg, ok := c[lang][key]; ok {
+					return msg, lang, true
+				}
+			}
+			i := strings.LastIndexByte(lang, '-')
+			if i == -1 {
+				break
+			}
+			lang = lang[:i]
+		}
+	}
+	return "", "", false
+}
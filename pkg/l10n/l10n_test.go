@@ -0,0 +1,79 @@
+package l10n
+
+import "testing"
+
+func TestDefault(t *testing.T) {
+	c := Default()
+	if len(c) == 0 {
+		t.Fatalf("no built-in catalogs")
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("invalid built-in catalog: %v", err)
+	}
+
+	// all languages should have the same keys
+	keys := map[string]int{}
+	for _, m := range c {
+		for k := range m {
+			keys[k]++
+		}
+	}
+	for k, n := range keys {
+		if n != len(c) {
+			for _, l := range c.Languages() {
+				if _, ok := c[l][k]; !ok {
+					t.Errorf("language %q: missing %q", l, k)
+				}
+			}
+		}
+	}
+}
+
+func TestValidLanguage(t *testing.T) {
+	for l, exp := range map[string]bool{
+		"":            false,
+		"en":          true,
+		"pt-br":       true,
+		"zh-hans-c":   true,
+		"PT-BR":       false,
+		"pt_br":       false,
+		"pt-":         false,
+		"toolonglang": false,
+	} {
+		if act := ValidLanguage(l); act != exp {
+			t.Errorf("%q: expected %t, got %t", l, exp, act)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	base := Catalog{
+		"fr": {"A": "fr a", "B": "fr b"},
+		"pt": {"A": "pt a"},
+	}
+	override := Catalog{
+		"fr":    {"B": "fr b override"},
+		"pt-br": {"B": "pt-br b"},
+	}
+	for _, c := range []struct {
+		key   string
+		langs []string
+		msg   string
+		lang  string
+	}{
+		{"A", []string{"fr"}, "fr a", "fr"},
+		{"B", []string{"fr"}, "fr b override", "fr"},
+		{"A", []string{"FR-ca"}, "fr a", "fr"},
+		{"A", []string{"pt-br"}, "pt a", "pt"},
+		{"B", []string{"pt-br"}, "pt-br b", "pt-br"},
+		{"A", []string{"de", "pt"}, "pt a", "pt"},
+		{"A", []string{"de"}, "", ""},
+		{"C", []string{"fr"}, "", ""},
+		{"A", nil, "", ""},
+	} {
+		msg, lang, ok := Lookup(c.key, c.langs, override, base)
+		if msg != c.msg || lang != c.lang || ok != (c.msg != "") {
+			t.Errorf("%s %q: expected %q (%s), got %q (%s, %t)", c.key, c.langs, c.msg, c.lang, msg, lang, ok)
+		}
+	}
+}
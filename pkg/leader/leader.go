@@ -0,0 +1,245 @@
+// Package leader implements lease-based leader election for running singleton
+// background jobs when multiple instances share the same storage.
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Backend stores leases. It must be safe for concurrent use by multiple
+// instances.
+type Backend interface {
+	// AcquireLease atomically acquires or renews the named lease for holder
+	// for ttl if it is not held, has expired, or is already held by holder. It
+	// returns the current holder of the lease.
+	AcquireLease(name, holder string, ttl time.Duration) (string, error)
+
+	// ReleaseLease releases the named lease if it is held by holder.
+	ReleaseLease(name, holder string) error
+}
+
+// Elector campaigns for a lease. The zero value is not usable; the Backend,
+// Name, and ID must be set.
+//
+// Since leadership is only known to be held until the lease expires, an
+// instance will stop acting as the leader once its last successful renewal
+// would have expired, even if it can't reach the backend. Instance clocks are
+// assumed to be roughly in sync.
+type Elector struct {
+	// Backend stores the lease.
+	Backend Backend
+
+	// Name is the name of the lease.
+	Name string
+
+	// ID uniquely identifies this instance.
+	ID string
+
+	// TTL is how long the lease is held for without being renewed, which is
+	// the maximum time before another instance takes over if the leader goes
+	// away. It is renewed every third of the TTL. If zero, it defaults to 15
+	// seconds.
+	TTL time.Duration
+
+	// Logger is used to log leadership changes and errors.
+	Logger zerolog.Logger
+
+	mu     sync.Mutex
+	until  time.Time // when our lease expires, if we hold it
+	holder string    // the last known holder
+
+	metrics struct {
+		transitions_total struct {
+			acquired atomic.Uint64
+			lost     atomic.Uint64
+		}
+		errors_total atomic.Uint64
+	}
+}
+
+// DefaultID generates an instance ID from the hostname and PID, with a random
+// suffix to prevent conflicts when an instance restarts before its old lease
+// expires.
+func DefaultID() string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		h = "unknown"
+	}
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return h + ":" + strconv.Itoa(os.Getpid()) + ":" + hex.EncodeToString(b[:])
+}
+
+func (e *Elector) ttl() time.Duration {
+	if e.TTL > 0 {
+		return e.TTL
+	}
+	return time.Second * 15
+}
+
+// IsLeader returns true if this instance currently holds the lease. It is
+// safe to call on a nil Elector, in which case it always returns true (i.e.,
+// there is only a single instance).
+func (e *Elector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return time.Now().Before(e.until)
+}
+
+// Leader returns the ID of the last known leader, or an empty string if it is
+// not known yet.
+func (e *Elector) Leader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.holder == e.ID && !time.Now().Before(e.until) {
+		return ""
+	}
+	return e.holder
+}
+
+// Run campaigns for and renews the lease until ctx is canceled, then releases
+// it if held.
+func (e *Elector) Run(ctx context.Context) error {
+	if e.Backend == nil || e.Name == "" || e.ID == "" {
+		return fmt.Errorf("leader: backend, name, and id are required")
+	}
+
+	tk := time.NewTicker(e.ttl() / 3)
+	defer tk.Stop()
+
+	for {
+		e.campaign()
+
+		select {
+		case <-ctx.Done():
+			e.release()
+			return nil
+		case <-tk.C:
+		}
+	}
+}
+
+// campaign attempts to acquire or renew the lease once.
+func (e *Elector) campaign() {
+	ttl := e.ttl()
+	start := time.Now()
+	holder, err := e.Backend.AcquireLease(e.Name, e.ID, ttl)
+
+	e.mu.Lock()
+	was := start.Before(e.until)
+	if err != nil {
+		e.metrics.errors_total.Add(1)
+	} else {
+		e.holder = holder
+		if holder == e.ID {
+			// the lease was acquired at some point after start
+			e.until = start.Add(ttl)
+		} else {
+			e.until = time.Time{}
+		}
+	}
+	now := time.Now().Before(e.until)
+	e.mu.Unlock()
+
+	if err != nil {
+		e.Logger.Warn().Err(err).Str("lease", e.Name).Msg("failed to renew leader lease")
+	}
+	if now != was {
+		if now {
+			e.metrics.transitions_total.acquired.Add(1)
+			e.Logger.Info().Str("lease", e.Name).Str("id", e.ID).Msg("became leader")
+		} else {
+			e.metrics.transitions_total.lost.Add(1)
+			e.Logger.Warn().Str("lease", e.Name).Str("id", e.ID).Str("leader", holder).Msg("lost leadership")
+		}
+	}
+}
+
+// release releases the lease if held, so another instance can take over
+// immediately.
+func (e *Elector) release() {
+	e.mu.Lock()
+	held := time.Now().Before(e.until)
+	e.until = time.Time{}
+	e.mu.Unlock()
+
+	if held {
+		if err := e.Backend.ReleaseLease(e.Name, e.ID); err != nil {
+			e.Logger.Warn().Err(err).Str("lease", e.Name).Msg("failed to release leader lease")
+		} else {
+			e.Logger.Info().Str("lease", e.Name).Msg("released leader lease")
+		}
+	}
+}
+
+// WritePrometheus writes prometheus text metrics to w.
+func (e *Elector) WritePrometheus(w io.Writer) {
+	var leader int
+	if e.IsLeader() {
+		leader = 1
+	}
+	fmt.Fprintln(w, `atlas_leader_is_leader`, leader)
+	if h := e.Leader(); h != "" {
+		fmt.Fprintln(w, `atlas_leader_info{leader=`+strconv.Quote(h)+`}`, 1)
+	}
+	fmt.Fprintln(w, `atlas_leader_transitions_total{result="acquired"}`, e.metrics.transitions_total.acquired.Load())
+	fmt.Fprintln(w, `atlas_leader_transitions_total{result="lost"}`, e.metrics.transitions_total.lost.Load())
+	fmt.Fprintln(w, `atlas_leader_errors_total`, e.metrics.errors_total.Load())
+}
+
+// Memory is an in-process lease Backend, for testing.
+type Memory struct {
+	mu     sync.Mutex
+	leases map[string]memoryLease
+}
+
+type memoryLease struct {
+	holder  string
+	expires time.Time
+}
+
+var _ Backend = (*Memory)(nil)
+
+func (m *Memory) AcquireLease(name, holder string, ttl time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if l, ok := m.leases[name]; ok && l.holder != holder && now.Before(l.expires) {
+		return l.holder, nil
+	}
+	if m.leases == nil {
+		m.leases = map[string]memoryLease{}
+	}
+	m.leases[name] = memoryLease{holder, now.Add(ttl)}
+	return holder, nil
+}
+
+func (m *Memory) ReleaseLease(name, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l, ok := m.leases[name]; ok && l.holder == holder {
+		delete(m.leases, name)
+	}
+	return nil
+}
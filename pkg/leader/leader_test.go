@@ -0,0 +1,75 @@
+package leader_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/leader"
+	"github.com/r2northstar/atlas/pkg/leader/leadertest"
+)
+
+func TestMemory(t *testing.T) {
+	leadertest.TestBackend(t, new(leader.Memory))
+}
+
+func TestElector(t *testing.T) {
+	var b leader.Memory
+
+	if !(*leader.Elector)(nil).IsLeader() {
+		t.Errorf("nil elector should always be the leader")
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+
+	e1 := &leader.Elector{Backend: &b, Name: "jobs", ID: "1", TTL: time.Millisecond * 150}
+	e2 := &leader.Elector{Backend: &b, Name: "jobs", ID: "2", TTL: time.Millisecond * 150}
+
+	done1 := make(chan struct{})
+	go func() {
+		defer close(done1)
+		e1.Run(ctx1)
+	}()
+	waitFor(t, "first instance to become leader", e1.IsLeader)
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	go e2.Run(ctx2)
+	waitFor(t, "second instance to see the leader", func() bool {
+		return e2.Leader() == "1"
+	})
+	if e2.IsLeader() {
+		t.Fatalf("second instance should not be the leader")
+	}
+
+	var buf bytes.Buffer
+	e1.WritePrometheus(&buf)
+	if m := buf.String(); !strings.Contains(m, "atlas_leader_is_leader 1\n") || !strings.Contains(m, `atlas_leader_info{leader="1"} 1`) {
+		t.Errorf("incorrect leader metrics:\n%s", m)
+	}
+
+	// failover after the leader goes away
+	cancel1()
+	<-done1
+	if e1.IsLeader() {
+		t.Errorf("first instance should not be the leader after stopping")
+	}
+	waitFor(t, "second instance to take over", e2.IsLeader)
+	if l := e2.Leader(); l != "2" {
+		t.Errorf("expected leader 2, got %q", l)
+	}
+}
+
+func waitFor(t *testing.T, what string, fn func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
@@ -0,0 +1,49 @@
+// Package leadertest contains conformance tests for lease backends.
+package leadertest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/leader"
+)
+
+// TestBackend tests whether an EMPTY lease backend implements the interface
+// correctly.
+func TestBackend(t *testing.T, b leader.Backend) {
+	acquire := func(t *testing.T, name, holder string, ttl time.Duration, exp string) {
+		t.Helper()
+		if h, err := b.AcquireLease(name, holder, ttl); err != nil {
+			t.Fatalf("acquire %q for %q: unexpected error: %v", name, holder, err)
+		} else if h != exp {
+			t.Fatalf("acquire %q for %q: expected holder %q, got %q", name, holder, exp, h)
+		}
+	}
+	release := func(t *testing.T, name, holder string) {
+		t.Helper()
+		if err := b.ReleaseLease(name, holder); err != nil {
+			t.Fatalf("release %q for %q: unexpected error: %v", name, holder, err)
+		}
+	}
+
+	t.Run("Acquire", func(t *testing.T) {
+		acquire(t, "a", "x", time.Minute, "x")
+		acquire(t, "a", "y", time.Minute, "x")
+		acquire(t, "a", "x", time.Minute, "x") // renew
+		acquire(t, "b", "y", time.Minute, "y")
+	})
+	t.Run("Release", func(t *testing.T) {
+		release(t, "a", "y") // not the holder
+		acquire(t, "a", "y", time.Minute, "x")
+		release(t, "a", "x")
+		acquire(t, "a", "y", time.Minute, "y")
+		release(t, "c", "x") // not held
+	})
+	t.Run("Expire", func(t *testing.T) {
+		acquire(t, "d", "x", time.Millisecond*50, "x")
+		acquire(t, "d", "y", time.Minute, "x")
+		time.Sleep(time.Millisecond * 100)
+		acquire(t, "d", "y", time.Minute, "y")
+		acquire(t, "d", "x", time.Minute, "y")
+	})
+}
@@ -0,0 +1,362 @@
+// Package legacyimport imports data from the original Northstar master server
+// into Atlas storage.
+package legacyimport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/pdata"
+)
+
+// maxIssues is the maximum number of issues to keep in a Report.
+const maxIssues = 1000
+
+// Importer imports legacy data into Atlas storage. The state and ban list
+// storage are taken from Accounts if it implements them.
+type Importer struct {
+	Accounts api0.AccountStorage
+	Pdata    api0.PdataStorage
+
+	// DryRun checks and reports what would be imported without writing
+	// anything.
+	DryRun bool
+
+	// Overwrite replaces existing accounts and pdata instead of skipping
+	// them.
+	Overwrite bool
+
+	// BanList is the ID of the ban list to import bans into. It is created if
+	// it doesn't exist.
+	BanList string
+
+	// BanSource is the contributor name to import bans as. If empty, it
+	// defaults to "legacy-import".
+	BanSource string
+
+	// Time is used for ban timestamps and to decide whether auth tokens are
+	// still usable. If zero, the current time is used.
+	Time time.Time
+
+	// Progress, if provided, is called after every record.
+	Progress func(*Report)
+
+	// Report contains the results of the imports done so far.
+	Report Report
+}
+
+// Report describes how legacy records were mapped to Atlas records.
+type Report struct {
+	// Counts contains the number of records with each outcome, keyed by
+	// kind/outcome (e.g., accounts/imported).
+	Counts map[string]int `json:"counts"`
+
+	// Issues contains the first issues with individual records.
+	Issues []Issue `json:"issues,omitempty"`
+
+	// Truncated is the number of issues not included in Issues.
+	Truncated int `json:"truncated,omitempty"`
+}
+
+// Issue is a problem with a legacy record.
+type Issue struct {
+	Kind    string `json:"kind"`
+	UID     uint64 `json:"uid,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (r *Report) count(kind, outcome string) {
+	if r.Counts == nil {
+		r.Counts = map[string]int{}
+	}
+	r.Counts[kind+"/"+outcome]++
+}
+
+func (r *Report) issue(x Issue) {
+	if len(r.Issues) < maxIssues {
+		r.Issues = append(r.Issues, x)
+	} else {
+		r.Truncated++
+	}
+}
+
+// Outcomes gets the sorted keys of Counts.
+func (r *Report) Outcomes() []string {
+	ks := make([]string, 0, len(r.Counts))
+	for k := range r.Counts {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+func (im *Importer) now() time.Time {
+	if im.Time.IsZero() {
+		return time.Now()
+	}
+	return im.Time
+}
+
+func (im *Importer) progress() {
+	if im.Progress != nil {
+		im.Progress(&im.Report)
+	}
+}
+
+// northstarAccount is a row from the accounts table of a NorthstarMasterServer
+// playerdata.db. Older versions don't have all columns.
+type northstarAccount struct {
+	ID                             uint64  `db:"id"`
+	CurrentAuthToken               *string `db:"currentAuthToken"`
+	CurrentAuthTokenExpirationTime *int64  `db:"currentAuthTokenExpirationTime"`
+	CurrentServerID                *string `db:"currentServerId"`
+	PersistentDataBaseline         []byte  `db:"persistentDataBaseline"`
+	LastAuthIP                     *string `db:"lastAuthIp"`
+	Username                       *string `db:"username"`
+}
+
+// ImportNorthstarDB imports accounts and pdata from a NorthstarMasterServer
+// playerdata.db (which must be opened with the sqlite3 driver). Pdata which is
+// invalid, has trailing junk, or is the default is not imported.
+func (im *Importer) ImportNorthstarDB(ctx context.Context, db *sqlx.DB) error {
+	rows, err := db.Unsafe().QueryxContext(ctx, `SELECT * FROM accounts ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("query northstar db: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n northstarAccount
+		if err := rows.StructScan(&n); err != nil {
+			return fmt.Errorf("query northstar db: scan row: %w", err)
+		}
+		if err := im.importNorthstarAccount(&n); err != nil {
+			return fmt.Errorf("import uid %d: %w", n.ID, err)
+		}
+		im.progress()
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("query northstar db: %w", err)
+	}
+	return nil
+}
+
+func (im *Importer) importNorthstarAccount(n *northstarAccount) error {
+	r := &im.Report
+	if n.ID == 0 {
+		r.count("accounts", "invalid_uid")
+		r.issue(Issue{Kind: "accounts", Message: "uid is zero"})
+		return nil
+	}
+
+	if im.Accounts != nil {
+		a := api0.Account{
+			UID: n.ID,
+		}
+		if n.Username != nil {
+			a.Username = *n.Username
+		}
+		if n.LastAuthIP != nil && *n.LastAuthIP != "" {
+			if v, err := netip.ParseAddr(*n.LastAuthIP); err == nil {
+				a.AuthIP = v
+			} else {
+				r.issue(Issue{Kind: "accounts", UID: n.ID, Message: fmt.Sprintf("ignoring invalid last auth ip %q", *n.LastAuthIP)})
+			}
+		}
+		if n.CurrentAuthToken != nil && n.CurrentAuthTokenExpirationTime != nil {
+			// the tokens are only useful if they're still valid, and ones
+			// with far-future expiry dates shouldn't be trusted
+			if v := time.UnixMilli(*n.CurrentAuthTokenExpirationTime); v.After(im.now()) && v.Sub(im.now()) < time.Hour*24 {
+				a.AuthToken = *n.CurrentAuthToken
+				a.AuthTokenExpiry = v
+			}
+		}
+		if n.CurrentServerID != nil {
+			a.LastServerID = *n.CurrentServerID
+		}
+
+		if ex, err := im.Accounts.GetAccount(a.UID); err != nil {
+			return fmt.Errorf("get existing account: %w", err)
+		} else if ex != nil && !im.Overwrite {
+			r.count("accounts", "skipped_exists")
+		} else {
+			if !im.DryRun {
+				if err := im.Accounts.SaveAccount(&a); err != nil {
+					return fmt.Errorf("save account: %w", err)
+				}
+			}
+			if ex != nil {
+				r.count("accounts", "replaced")
+			} else {
+				r.count("accounts", "imported")
+			}
+		}
+	}
+
+	if im.Pdata != nil {
+		var pd pdata.Pdata
+		switch err := pd.UnmarshalBinary(n.PersistentDataBaseline); {
+		case len(n.PersistentDataBaseline) == 0:
+			r.count("pdata", "skipped_empty")
+			return nil
+		case err != nil:
+			r.count("pdata", "skipped_invalid")
+			r.issue(Issue{Kind: "pdata", UID: n.ID, Message: fmt.Sprintf("invalid pdata: %v", err)})
+			return nil
+		case len(pd.ExtraData) >= 140:
+			r.count("pdata", "skipped_junk")
+			r.issue(Issue{Kind: "pdata", UID: n.ID, Message: fmt.Sprintf("%d bytes of junk after pdata", len(pd.ExtraData))})
+			return nil
+		case bytes.Equal(n.PersistentDataBaseline, pdata.DefaultPdata):
+			r.count("pdata", "skipped_default")
+			return nil
+		}
+
+		if _, exists, err := im.Pdata.GetPdataHash(n.ID); err != nil {
+			return fmt.Errorf("get existing pdata: %w", err)
+		} else if exists && !im.Overwrite {
+			r.count("pdata", "skipped_exists")
+		} else {
+			if !im.DryRun {
+				if _, err := im.Pdata.SetPdata(n.ID, n.PersistentDataBaseline); err != nil {
+					return fmt.Errorf("save pdata: %w", err)
+				}
+			}
+			if exists {
+				r.count("pdata", "replaced")
+			} else {
+				r.count("pdata", "imported")
+			}
+		}
+	}
+	return nil
+}
+
+// ImportBanList imports bans from a Northstar banlist.txt, which contains one
+// UID per line, optionally followed by a // comment which is used as the ban
+// reason. Blank and comment-only lines are ignored.
+func (im *Importer) ImportBanList(ctx context.Context, rd io.Reader) error {
+	if !api0.ValidBanListID(im.BanList) {
+		return fmt.Errorf("invalid ban list id %q", im.BanList)
+	}
+	ss, ok := im.Accounts.(api0.StateStorage)
+	if !ok {
+		return fmt.Errorf("account storage does not support state")
+	}
+	bs, ok := im.Accounts.(api0.BanListStorage)
+	if !ok {
+		return fmt.Errorf("account storage does not support ban lists")
+	}
+	source := im.BanSource
+	if source == "" {
+		source = "legacy-import"
+	}
+	if err := im.ensureBanList(ss, source); err != nil {
+		return err
+	}
+
+	es, err := bs.GetBanListEntries(im.BanList)
+	if err != nil {
+		return fmt.Errorf("get existing ban list entries: %w", err)
+	}
+	existing := map[uint64]bool{}
+	for _, e := range es {
+		if e.Source == source {
+			existing[e.UID] = true
+		}
+	}
+
+	r := &im.Report
+	sc := bufio.NewScanner(rd)
+	for line := 1; sc.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		uidStr, reason, _ := strings.Cut(sc.Text(), "//")
+		uidStr, reason = strings.TrimSpace(uidStr), strings.TrimSpace(reason)
+		if uidStr == "" {
+			continue
+		}
+		uid, err := strconv.ParseUint(uidStr, 10, 64)
+		if err != nil || uid == 0 {
+			r.count("bans", "invalid_uid")
+			r.issue(Issue{Kind: "bans", Line: line, Message: fmt.Sprintf("invalid uid %q", uidStr)})
+			continue
+		}
+		if existing[uid] {
+			r.count("bans", "skipped_exists")
+			continue
+		}
+		existing[uid] = true
+
+		if !im.DryRun {
+			t := im.now()
+			if err := bs.SaveBanListEntry(&api0.BanListEntry{
+				List:    im.BanList,
+				UID:     uid,
+				Source:  source,
+				Reason:  reason,
+				Created: t,
+				Updated: t,
+			}); err != nil {
+				return fmt.Errorf("save ban list entry for %d: %w", uid, err)
+			}
+		}
+		r.count("bans", "imported")
+		im.progress()
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read ban list: %w", err)
+	}
+	return nil
+}
+
+// ensureBanList creates BanList if it doesn't exist, and reports if source
+// isn't trusted by it.
+func (im *Importer) ensureBanList(ss api0.StateStorage, source string) error {
+	var ls []api0.BanList
+	if buf, exists, err := ss.GetState("banlists"); err != nil {
+		return fmt.Errorf("get ban lists: %w", err)
+	} else if exists {
+		if err := json.Unmarshal(buf, &ls); err != nil {
+			return fmt.Errorf("decode ban lists: %w", err)
+		}
+	}
+	for _, l := range ls {
+		if l.ID == im.BanList {
+			if !l.Trusts(source) {
+				im.Report.issue(Issue{Kind: "bans", Message: fmt.Sprintf("ban list %q does not trust %q, so imported bans will not be included in the feed", l.ID, source)})
+			}
+			return nil
+		}
+	}
+	im.Report.count("banlists", "created")
+	if im.DryRun {
+		return nil
+	}
+	ls = append(ls, api0.BanList{
+		ID:      im.BanList,
+		Name:    "Imported bans",
+		Updated: im.now(),
+	})
+	buf, err := json.Marshal(ls)
+	if err != nil {
+		return fmt.Errorf("encode ban lists: %w", err)
+	}
+	if err := ss.SetState("banlists", buf); err != nil {
+		return fmt.Errorf("save ban lists: %w", err)
+	}
+	return nil
+}
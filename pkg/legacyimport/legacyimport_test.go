@@ -0,0 +1,164 @@
+package legacyimport
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/memstore"
+	"github.com/r2northstar/atlas/pkg/pdata"
+)
+
+func TestImportNorthstarDB(t *testing.T) {
+	db, err := sqlx.Connect("sqlite3", filepath.Join(t.TempDir(), "playerdata.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	custom := append([]byte(nil), pdata.DefaultPdata...)
+	custom[len(custom)-1] ^= 1 // still valid, but not the default
+
+	db.MustExec(`CREATE TABLE accounts (id TEXT PRIMARY KEY, currentAuthToken TEXT, currentAuthTokenExpirationTime INTEGER, currentServerId TEXT, persistentDataBaseline BLOB NOT NULL, lastAuthIp TEXT, username TEXT, extra TEXT)`)
+	for _, x := range []struct {
+		uid    uint64
+		expiry time.Time
+		pdata  []byte
+		ip     string
+	}{
+		{1, now.Add(time.Hour), custom, "127.0.0.1"},
+		{2, now.Add(-time.Hour), pdata.DefaultPdata, "invalid"},
+		{3, now.Add(time.Hour * 48), []byte("junk"), ""},
+		{4, now, append(append([]byte(nil), custom...), make([]byte, 200)...), ""},
+	} {
+		db.MustExec(`INSERT INTO accounts VALUES (?, ?, ?, 'self', ?, ?, ?, 'x')`, x.uid, "token", x.expiry.UnixMilli(), x.pdata, x.ip, "user")
+	}
+
+	as, ps := memstore.NewAccountStore(), memstore.NewPdataStore(false)
+	if err := as.SaveAccount(&api0.Account{UID: 4, Username: "existing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dry := &Importer{Accounts: as, Pdata: ps, DryRun: true, Time: now}
+	if err := dry.ImportNorthstarDB(context.Background(), db); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if a, _ := as.GetAccount(1); a != nil {
+		t.Errorf("expected dry run not to write accounts")
+	}
+
+	im := &Importer{Accounts: as, Pdata: ps, Time: now}
+	if err := im.ImportNorthstarDB(context.Background(), db); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	for _, r := range []*Report{&dry.Report, &im.Report} {
+		for k, v := range map[string]int{
+			"accounts/imported":       3,
+			"accounts/skipped_exists": 1,
+			"pdata/imported":          1,
+			"pdata/skipped_default":   1,
+			"pdata/skipped_invalid":   1,
+			"pdata/skipped_junk":      1,
+		} {
+			if r.Counts[k] != v {
+				t.Errorf("expected %s=%d, got %d", k, v, r.Counts[k])
+			}
+		}
+		if len(r.Issues) != 3 {
+			t.Errorf("expected 3 issues, got %v", r.Issues)
+		}
+	}
+
+	if a, err := as.GetAccount(1); err != nil || a == nil {
+		t.Fatalf("get account: %v", err)
+	} else if a.Username != "user" || a.AuthIP.String() != "127.0.0.1" || a.AuthToken != "token" || a.LastServerID != "self" {
+		t.Errorf("incorrect account %+v", a)
+	}
+	if a, err := as.GetAccount(2); err != nil || a == nil {
+		t.Fatalf("get account: %v", err)
+	} else if a.AuthToken != "" || a.AuthIP.IsValid() {
+		t.Errorf("expected expired token and invalid ip not to be imported, got %+v", a)
+	}
+	if a, err := as.GetAccount(3); err != nil || a == nil {
+		t.Fatalf("get account: %v", err)
+	} else if a.AuthToken != "" {
+		t.Errorf("expected token with far-future expiry not to be imported")
+	}
+	if a, err := as.GetAccount(4); err != nil || a == nil || a.Username != "existing" {
+		t.Errorf("expected existing account to be kept")
+	}
+	if buf, exists, err := ps.GetPdataCached(1, [32]byte{}); err != nil || !exists || !bytes.Equal(buf, custom) {
+		t.Errorf("expected pdata to be imported")
+	}
+	for _, uid := range []uint64{2, 3, 4} {
+		if _, exists, _ := ps.GetPdataHash(uid); exists {
+			t.Errorf("expected pdata for %d not to be imported", uid)
+		}
+	}
+
+	im = &Importer{Accounts: as, Pdata: ps, Overwrite: true, Time: now}
+	if err := im.ImportNorthstarDB(context.Background(), db); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if im.Report.Counts["accounts/replaced"] != 4 || im.Report.Counts["pdata/replaced"] != 1 {
+		t.Errorf("expected records to be replaced, got %v", im.Report.Counts)
+	}
+}
+
+func TestImportBanList(t *testing.T) {
+	as := memstore.NewAccountStore()
+	in := "// banned players\n1\n2 // cheating\n\nnope\n1\n"
+
+	dry := &Importer{Accounts: as, BanList: "legacy", DryRun: true}
+	if err := dry.ImportBanList(context.Background(), strings.NewReader(in)); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if _, exists, _ := as.GetState("banlists"); exists {
+		t.Errorf("expected dry run not to create the ban list")
+	}
+
+	im := &Importer{Accounts: as, BanList: "legacy"}
+	if err := im.ImportBanList(context.Background(), strings.NewReader(in)); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	for _, r := range []*Report{&dry.Report, &im.Report} {
+		if r.Counts["bans/imported"] != 2 || r.Counts["bans/skipped_exists"] != 1 || r.Counts["bans/invalid_uid"] != 1 || r.Counts["banlists/created"] != 1 {
+			t.Errorf("incorrect counts %v", r.Counts)
+		}
+		if len(r.Issues) != 1 || r.Issues[0].Line != 5 {
+			t.Errorf("incorrect issues %v", r.Issues)
+		}
+	}
+
+	es, err := as.GetBanListEntries("legacy")
+	if err != nil {
+		t.Fatalf("get entries: %v", err)
+	}
+	if len(es) != 2 || es[0].Source != "legacy-import" {
+		t.Fatalf("incorrect entries %+v", es)
+	}
+	for _, e := range es {
+		if e.UID == 2 && e.Reason != "cheating" {
+			t.Errorf("expected comment to be used as the reason, got %q", e.Reason)
+		}
+	}
+
+	im = &Importer{Accounts: as, BanList: "legacy"}
+	if err := im.ImportBanList(context.Background(), strings.NewReader(in)); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if im.Report.Counts["bans/imported"] != 0 || im.Report.Counts["banlists/created"] != 0 {
+		t.Errorf("expected import to be idempotent, got %v", im.Report.Counts)
+	}
+
+	if err := (&Importer{Accounts: as, BanList: "Invalid"}).ImportBanList(context.Background(), strings.NewReader(in)); err == nil {
+		t.Errorf("expected error for invalid ban list id")
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"io"
+	"net/netip"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/klauspost/compress/gzip"
 	"github.com/r2northstar/atlas/pkg/api/api0"
@@ -15,6 +18,45 @@ import (
 // AccountStore stores accounts in-memory.
 type AccountStore struct {
 	accounts sync.Map
+
+	linksMu sync.RWMutex
+	links   map[uint64]map[api0.AccountLinkProvider]api0.AccountLink
+	linksTo map[api0.AccountLinkProvider]map[string]uint64
+
+	namesMu sync.RWMutex
+	names   map[uint64][]api0.UsernameChange
+
+	signalsMu sync.RWMutex
+	signals   map[accountSignalKey]map[uint64]api0.AccountSignal
+
+	banListsMu sync.RWMutex
+	banLists   map[string]map[banListKey]api0.BanListEntry
+
+	reportsMu sync.RWMutex
+	reports   map[string]api0.AbuseReport
+
+	ratingsMu sync.RWMutex
+	ratings   map[uint64]map[string]api0.PlayerRating
+
+	matchesMu sync.RWMutex
+	matches   map[string]api0.MatchRecord
+
+	mutesMu sync.RWMutex
+	mutes   map[muteReportKey]api0.MuteReport
+
+	friendsMu sync.RWMutex
+	friends   map[uint64]map[uint64]api0.Friend
+
+	blocksMu sync.RWMutex
+	blocks   map[uint64]map[uint64]api0.Block
+
+	crashesMu sync.RWMutex
+	crashes   map[string]api0.CrashSignature
+
+	state sync.Map
+
+	statsMu sync.RWMutex
+	stats   map[netip.AddrPort]map[serverStatsKey]api0.ServerStatsBucket
 }
 
 // NewPdataStore creates a new MemoryPdataStore.
@@ -35,22 +77,915 @@ func (m *AccountStore) GetUIDsByUsername(username string) ([]uint64, error) {
 	return uids, nil
 }
 
+func (m *AccountStore) GetAccountUIDs() ([]uint64, error) {
+	var uids []uint64
+	m.accounts.Range(func(k, _ any) bool {
+		uids = append(uids, k.(uint64))
+		return true
+	})
+	sort.Slice(uids, func(i, j int) bool {
+		return uids[i] < uids[j]
+	})
+	return uids, nil
+}
+
 func (m *AccountStore) GetAccount(uid uint64) (*api0.Account, error) {
 	v, ok := m.accounts.Load(uid)
 	if !ok {
 		return nil, nil
 	}
 	a := v.(api0.Account)
+	a.OtherSessions = append([]api0.AccountSession(nil), a.OtherSessions...)
 	return &a, nil
 }
 
 func (m *AccountStore) SaveAccount(a *api0.Account) error {
 	if a != nil {
-		m.accounts.Store(a.UID, *a)
+		v := *a
+		v.OtherSessions = append([]api0.AccountSession(nil), v.OtherSessions...)
+		m.accounts.Store(a.UID, v)
+	}
+	return nil
+}
+
+func (m *AccountStore) DeleteAccount(uid uint64) error {
+	m.accounts.Delete(uid)
+	return nil
+}
+
+func (m *AccountStore) GetAccountLinks(uid uint64) ([]api0.AccountLink, error) {
+	m.linksMu.RLock()
+	defer m.linksMu.RUnlock()
+
+	var ls []api0.AccountLink
+	for _, l := range m.links[uid] {
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+func (m *AccountStore) GetUIDByAccountLink(provider api0.AccountLinkProvider, externalID string) (uint64, bool, error) {
+	m.linksMu.RLock()
+	defer m.linksMu.RUnlock()
+
+	uid, ok := m.linksTo[provider][externalID]
+	return uid, ok, nil
+}
+
+func (m *AccountStore) SaveAccountLink(l *api0.AccountLink) error {
+	if l == nil {
+		return nil
+	}
+
+	m.linksMu.Lock()
+	defer m.linksMu.Unlock()
+
+	if m.links == nil {
+		m.links = map[uint64]map[api0.AccountLinkProvider]api0.AccountLink{}
+	}
+	if m.linksTo == nil {
+		m.linksTo = map[api0.AccountLinkProvider]map[string]uint64{}
+	}
+	if m.links[l.UID] == nil {
+		m.links[l.UID] = map[api0.AccountLinkProvider]api0.AccountLink{}
+	}
+	if m.linksTo[l.Provider] == nil {
+		m.linksTo[l.Provider] = map[string]uint64{}
+	}
+
+	// remove the existing link to the external account
+	if uid, ok := m.linksTo[l.Provider][l.ExternalID]; ok {
+		delete(m.links[uid], l.Provider)
+	}
+
+	// remove the existing link for the uid
+	if o, ok := m.links[l.UID][l.Provider]; ok {
+		delete(m.linksTo[l.Provider], o.ExternalID)
+	}
+
+	m.links[l.UID][l.Provider] = *l
+	m.linksTo[l.Provider][l.ExternalID] = l.UID
+	return nil
+}
+
+func (m *AccountStore) DeleteAccountLink(uid uint64, provider api0.AccountLinkProvider) error {
+	m.linksMu.Lock()
+	defer m.linksMu.Unlock()
+
+	if o, ok := m.links[uid][provider]; ok {
+		delete(m.linksTo[provider], o.ExternalID)
+		delete(m.links[uid], provider)
+	}
+	return nil
+}
+
+func (m *AccountStore) GetUsernameHistory(uid uint64) ([]api0.UsernameChange, error) {
+	m.namesMu.RLock()
+	defer m.namesMu.RUnlock()
+
+	return append([]api0.UsernameChange(nil), m.names[uid]...), nil
+}
+
+func (m *AccountStore) GetUIDsByPastUsername(username string) ([]uint64, error) {
+	m.namesMu.RLock()
+	defer m.namesMu.RUnlock()
+
+	var uids []uint64
+	if username != "" {
+		for uid, cs := range m.names {
+			for _, c := range cs {
+				if strings.EqualFold(c.From, username) || strings.EqualFold(c.To, username) {
+					uids = append(uids, uid)
+					break
+				}
+			}
+		}
+	}
+	return uids, nil
+}
+
+func (m *AccountStore) SaveUsernameChange(c *api0.UsernameChange) error {
+	if c == nil {
+		return nil
+	}
+
+	m.namesMu.Lock()
+	defer m.namesMu.Unlock()
+
+	if m.names == nil {
+		m.names = map[uint64][]api0.UsernameChange{}
+	}
+	cs := append(m.names[c.UID], *c)
+	sort.SliceStable(cs, func(i, j int) bool {
+		return cs[i].Time.Before(cs[j].Time)
+	})
+	m.names[c.UID] = cs
+	return nil
+}
+
+func (m *AccountStore) DeleteUsernameHistory(uid uint64) error {
+	m.namesMu.Lock()
+	defer m.namesMu.Unlock()
+
+	delete(m.names, uid)
+	return nil
+}
+
+type accountSignalKey struct {
+	Kind api0.AccountSignalKind
+	Hash string
+}
+
+func (m *AccountStore) GetAccountSignals(uid uint64) ([]api0.AccountSignal, error) {
+	m.signalsMu.RLock()
+	defer m.signalsMu.RUnlock()
+
+	var ss []api0.AccountSignal
+	for _, x := range m.signals {
+		if s, ok := x[uid]; ok {
+			ss = append(ss, s)
+		}
+	}
+	return ss, nil
+}
+
+func (m *AccountStore) GetUIDsByAccountSignal(kind api0.AccountSignalKind, hash string, limit int) ([]uint64, error) {
+	m.signalsMu.RLock()
+	defer m.signalsMu.RUnlock()
+
+	var uids []uint64
+	for uid := range m.signals[accountSignalKey{kind, hash}] {
+		if limit > 0 && len(uids) >= limit {
+			break
+		}
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
+func (m *AccountStore) SaveAccountSignal(s *api0.AccountSignal) error {
+	if s == nil {
+		return nil
+	}
+
+	m.signalsMu.Lock()
+	defer m.signalsMu.Unlock()
+
+	k := accountSignalKey{s.Kind, s.Hash}
+	if m.signals == nil {
+		m.signals = map[accountSignalKey]map[uint64]api0.AccountSignal{}
+	}
+	if m.signals[k] == nil {
+		m.signals[k] = map[uint64]api0.AccountSignal{}
+	}
+	v := *s
+	if o, ok := m.signals[k][s.UID]; ok {
+		v.FirstSeen = o.FirstSeen
+	}
+	m.signals[k][s.UID] = v
+	return nil
+}
+
+func (m *AccountStore) DeleteAccountSignals(uid uint64) error {
+	m.signalsMu.Lock()
+	defer m.signalsMu.Unlock()
+
+	for k, x := range m.signals {
+		if delete(x, uid); len(x) == 0 {
+			delete(m.signals, k)
+		}
+	}
+	return nil
+}
+
+func (m *AccountStore) DeleteAccountSignalsBefore(t time.Time) error {
+	m.signalsMu.Lock()
+	defer m.signalsMu.Unlock()
+
+	for k, x := range m.signals {
+		for uid, s := range x {
+			if s.LastSeen.Before(t) {
+				delete(x, uid)
+			}
+		}
+		if len(x) == 0 {
+			delete(m.signals, k)
+		}
+	}
+	return nil
+}
+
+type banListKey struct {
+	UID    uint64
+	Source string
+}
+
+func (m *AccountStore) GetBanListEntries(list string) ([]api0.BanListEntry, error) {
+	m.banListsMu.RLock()
+	defer m.banListsMu.RUnlock()
+
+	var es []api0.BanListEntry
+	for _, e := range m.banLists[list] {
+		es = append(es, e)
+	}
+	return es, nil
+}
+
+func (m *AccountStore) SaveBanListEntry(e *api0.BanListEntry) error {
+	if e == nil {
+		return nil
+	}
+
+	m.banListsMu.Lock()
+	defer m.banListsMu.Unlock()
+
+	k := banListKey{e.UID, e.Source}
+	if m.banLists == nil {
+		m.banLists = map[string]map[banListKey]api0.BanListEntry{}
+	}
+	if m.banLists[e.List] == nil {
+		m.banLists[e.List] = map[banListKey]api0.BanListEntry{}
+	}
+	v := *e
+	if o, ok := m.banLists[e.List][k]; ok {
+		v.Created = o.Created
+	}
+	m.banLists[e.List][k] = v
+	return nil
+}
+
+func (m *AccountStore) DeleteBanList(list string) error {
+	m.banListsMu.Lock()
+	defer m.banListsMu.Unlock()
+
+	delete(m.banLists, list)
+	return nil
+}
+
+func (m *AccountStore) DeleteBanListEntries(uid uint64) error {
+	m.banListsMu.Lock()
+	defer m.banListsMu.Unlock()
+
+	for list, x := range m.banLists {
+		for k := range x {
+			if k.UID == uid {
+				delete(x, k)
+			}
+		}
+		if len(x) == 0 {
+			delete(m.banLists, list)
+		}
+	}
+	return nil
+}
+
+func cloneAbuseReport(r api0.AbuseReport) api0.AbuseReport {
+	if r.Context != nil {
+		c := make(map[string]string, len(r.Context))
+		for k, v := range r.Context {
+			c[k] = v
+		}
+		r.Context = c
+	}
+	return r
+}
+
+func (m *AccountStore) GetAbuseReport(id string) (*api0.AbuseReport, error) {
+	m.reportsMu.RLock()
+	defer m.reportsMu.RUnlock()
+
+	if r, ok := m.reports[id]; ok {
+		r = cloneAbuseReport(r)
+		return &r, nil
+	}
+	return nil, nil
+}
+
+func (m *AccountStore) GetAbuseReports(status api0.AbuseReportStatus, reported uint64, limit int) ([]api0.AbuseReport, error) {
+	m.reportsMu.RLock()
+	defer m.reportsMu.RUnlock()
+
+	var rs []api0.AbuseReport
+	for _, r := range m.reports {
+		if status != "" && r.Status != status {
+			continue
+		}
+		if reported != 0 && r.Reported != reported {
+			continue
+		}
+		rs = append(rs, cloneAbuseReport(r))
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		return rs[i].Time.Before(rs[j].Time)
+	})
+	if limit > 0 && len(rs) > limit {
+		rs = rs[:limit]
+	}
+	return rs, nil
+}
+
+func (m *AccountStore) SaveAbuseReport(r *api0.AbuseReport) error {
+	if r == nil {
+		return nil
+	}
+
+	m.reportsMu.Lock()
+	defer m.reportsMu.Unlock()
+
+	if m.reports == nil {
+		m.reports = map[string]api0.AbuseReport{}
+	}
+	m.reports[r.ID] = cloneAbuseReport(*r)
+	return nil
+}
+
+func (m *AccountStore) DeleteAbuseReports(uid uint64) error {
+	m.reportsMu.Lock()
+	defer m.reportsMu.Unlock()
+
+	for id, r := range m.reports {
+		if r.Reporter == uid || r.Reported == uid {
+			delete(m.reports, id)
+		}
+	}
+	return nil
+}
+
+func (m *AccountStore) GetRatings(uid uint64) ([]api0.PlayerRating, error) {
+	m.ratingsMu.RLock()
+	defer m.ratingsMu.RUnlock()
+
+	var rs []api0.PlayerRating
+	for _, r := range m.ratings[uid] {
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].Mode < rs[j].Mode
+	})
+	return rs, nil
+}
+
+func (m *AccountStore) GetRating(uid uint64, mode string) (*api0.PlayerRating, error) {
+	m.ratingsMu.RLock()
+	defer m.ratingsMu.RUnlock()
+
+	if r, ok := m.ratings[uid][mode]; ok {
+		return &r, nil
+	}
+	return nil, nil
+}
+
+func (m *AccountStore) SaveRatings(rs []api0.PlayerRating) error {
+	m.ratingsMu.Lock()
+	defer m.ratingsMu.Unlock()
+
+	if m.ratings == nil {
+		m.ratings = map[uint64]map[string]api0.PlayerRating{}
+	}
+	for _, r := range rs {
+		x, ok := m.ratings[r.UID]
+		if !ok {
+			x = map[string]api0.PlayerRating{}
+			m.ratings[r.UID] = x
+		}
+		x[r.Mode] = r
+	}
+	return nil
+}
+
+func (m *AccountStore) DeleteRatings(uid uint64) error {
+	m.ratingsMu.Lock()
+	defer m.ratingsMu.Unlock()
+
+	delete(m.ratings, uid)
+	return nil
+}
+
+func cloneMatch(x api0.MatchRecord) api0.MatchRecord {
+	ps := make([]api0.MatchPlayer, len(x.Players))
+	for i, p := range x.Players {
+		if p.Stats != nil {
+			st := make(map[string]int64, len(p.Stats))
+			for k, v := range p.Stats {
+				st[k] = v
+			}
+			p.Stats = st
+		}
+		ps[i] = p
+	}
+	x.Players = ps
+	return x
+}
+
+func (m *AccountStore) GetMatches(uid uint64, before time.Time, limit int) ([]api0.MatchRecord, error) {
+	m.matchesMu.RLock()
+	defer m.matchesMu.RUnlock()
+
+	var ms []api0.MatchRecord
+	for _, x := range m.matches {
+		if !before.IsZero() && !x.Time.Before(before) {
+			continue
+		}
+		for _, p := range x.Players {
+			if p.UID == uid {
+				ms = append(ms, cloneMatch(x))
+				break
+			}
+		}
+	}
+	sort.SliceStable(ms, func(i, j int) bool {
+		return ms[i].Time.After(ms[j].Time)
+	})
+	if limit > 0 && len(ms) > limit {
+		ms = ms[:limit]
+	}
+	return ms, nil
+}
+
+func (m *AccountStore) SaveMatch(x *api0.MatchRecord) error {
+	if x == nil {
+		return nil
+	}
+
+	m.matchesMu.Lock()
+	defer m.matchesMu.Unlock()
+
+	if m.matches == nil {
+		m.matches = map[string]api0.MatchRecord{}
+	}
+	m.matches[x.ID] = cloneMatch(*x)
+	return nil
+}
+
+func (m *AccountStore) DeleteMatchesBefore(t time.Time) error {
+	m.matchesMu.Lock()
+	defer m.matchesMu.Unlock()
+
+	for id, x := range m.matches {
+		if x.Time.Before(t) {
+			delete(m.matches, id)
+		}
+	}
+	return nil
+}
+
+func (m *AccountStore) DeletePlayerMatches(uid uint64) error {
+	m.matchesMu.Lock()
+	defer m.matchesMu.Unlock()
+
+	for id, x := range m.matches {
+		ps := x.Players[:0]
+		for _, p := range x.Players {
+			if p.UID != uid {
+				ps = append(ps, p)
+			}
+		}
+		x.Players = ps
+		m.matches[id] = x
+	}
+	return nil
+}
+
+type muteReportKey struct {
+	uid  uint64
+	addr netip.Addr
+	kind api0.MuteKind
+}
+
+func (m *AccountStore) GetMuteReports(uid uint64, since time.Time) ([]api0.MuteReport, error) {
+	m.mutesMu.RLock()
+	defer m.mutesMu.RUnlock()
+
+	var rs []api0.MuteReport
+	for k, r := range m.mutes {
+		if k.uid == uid && !r.Time.Before(since) {
+			rs = append(rs, r)
+		}
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		return rs[i].Time.Before(rs[j].Time)
+	})
+	return rs, nil
+}
+
+func (m *AccountStore) SaveMuteReport(r api0.MuteReport) error {
+	m.mutesMu.Lock()
+	defer m.mutesMu.Unlock()
+
+	if m.mutes == nil {
+		m.mutes = map[muteReportKey]api0.MuteReport{}
+	}
+	m.mutes[muteReportKey{r.UID, r.ServerAddr, r.Kind}] = r
+	return nil
+}
+
+func (m *AccountStore) DeleteMuteReport(uid uint64, addr netip.Addr, kind api0.MuteKind) error {
+	m.mutesMu.Lock()
+	defer m.mutesMu.Unlock()
+
+	delete(m.mutes, muteReportKey{uid, addr, kind})
+	return nil
+}
+
+func (m *AccountStore) DeleteMuteReportsBefore(t time.Time) error {
+	m.mutesMu.Lock()
+	defer m.mutesMu.Unlock()
+
+	for k, r := range m.mutes {
+		if r.Time.Before(t) {
+			delete(m.mutes, k)
+		}
+	}
+	return nil
+}
+
+func (m *AccountStore) DeletePlayerMuteReports(uid uint64) error {
+	m.mutesMu.Lock()
+	defer m.mutesMu.Unlock()
+
+	for k := range m.mutes {
+		if k.uid == uid {
+			delete(m.mutes, k)
+		}
+	}
+	return nil
+}
+
+func (m *AccountStore) GetFriends(uid uint64) ([]api0.Friend, error) {
+	m.friendsMu.RLock()
+	defer m.friendsMu.RUnlock()
+
+	var fs []api0.Friend
+	for _, f := range m.friends[uid] {
+		fs = append(fs, f)
+	}
+	sort.Slice(fs, func(i, j int) bool {
+		return fs[i].Friend < fs[j].Friend
+	})
+	return fs, nil
+}
+
+func (m *AccountStore) GetFriend(uid, friend uint64) (*api0.Friend, error) {
+	m.friendsMu.RLock()
+	defer m.friendsMu.RUnlock()
+
+	if f, ok := m.friends[uid][friend]; ok {
+		return &f, nil
+	}
+	return nil, nil
+}
+
+func (m *AccountStore) SaveFriends(fs []api0.Friend) error {
+	m.friendsMu.Lock()
+	defer m.friendsMu.Unlock()
+
+	if m.friends == nil {
+		m.friends = map[uint64]map[uint64]api0.Friend{}
+	}
+	for _, f := range fs {
+		x, ok := m.friends[f.UID]
+		if !ok {
+			x = map[uint64]api0.Friend{}
+			m.friends[f.UID] = x
+		}
+		x[f.Friend] = f
+	}
+	return nil
+}
+
+func (m *AccountStore) DeleteFriend(uid, friend uint64) error {
+	m.friendsMu.Lock()
+	defer m.friendsMu.Unlock()
+
+	delete(m.friends[uid], friend)
+	delete(m.friends[friend], uid)
+	return nil
+}
+
+func (m *AccountStore) DeleteFriends(uid uint64) error {
+	m.friendsMu.Lock()
+	defer m.friendsMu.Unlock()
+
+	for friend := range m.friends[uid] {
+		delete(m.friends[friend], uid)
+	}
+	delete(m.friends, uid)
+	return nil
+}
+
+func (m *AccountStore) GetBlocks(uid uint64) ([]api0.Block, error) {
+	m.blocksMu.RLock()
+	defer m.blocksMu.RUnlock()
+
+	var bs []api0.Block
+	for _, b := range m.blocks[uid] {
+		bs = append(bs, b)
+	}
+	sort.Slice(bs, func(i, j int) bool {
+		return bs[i].Blocked < bs[j].Blocked
+	})
+	return bs, nil
+}
+
+func (m *AccountStore) GetBlockedBy(uid uint64) ([]uint64, error) {
+	m.blocksMu.RLock()
+	defer m.blocksMu.RUnlock()
+
+	var uids []uint64
+	for x, bs := range m.blocks {
+		if _, ok := bs[uid]; ok {
+			uids = append(uids, x)
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		return uids[i] < uids[j]
+	})
+	return uids, nil
+}
+
+func (m *AccountStore) GetBlock(uid, blocked uint64) (*api0.Block, error) {
+	m.blocksMu.RLock()
+	defer m.blocksMu.RUnlock()
+
+	if b, ok := m.blocks[uid][blocked]; ok {
+		return &b, nil
+	}
+	return nil, nil
+}
+
+func (m *AccountStore) SaveBlock(b api0.Block) error {
+	m.blocksMu.Lock()
+	defer m.blocksMu.Unlock()
+
+	if m.blocks == nil {
+		m.blocks = map[uint64]map[uint64]api0.Block{}
+	}
+	x, ok := m.blocks[b.UID]
+	if !ok {
+		x = map[uint64]api0.Block{}
+		m.blocks[b.UID] = x
+	}
+	x[b.Blocked] = b
+	return nil
+}
+
+func (m *AccountStore) DeleteBlock(uid, blocked uint64) error {
+	m.blocksMu.Lock()
+	defer m.blocksMu.Unlock()
+
+	delete(m.blocks[uid], blocked)
+	return nil
+}
+
+func (m *AccountStore) DeleteBlocks(uid uint64) error {
+	m.blocksMu.Lock()
+	defer m.blocksMu.Unlock()
+
+	delete(m.blocks, uid)
+	for _, bs := range m.blocks {
+		delete(bs, uid)
+	}
+	return nil
+}
+
+func cloneCrash(x api0.CrashSignature) api0.CrashSignature {
+	x.Fingerprint.Stack = append([]string(nil), x.Fingerprint.Stack...)
+	vs := make(map[string]int, len(x.Versions))
+	for k, v := range x.Versions {
+		vs[k] = v
+	}
+	x.Versions = vs
+	ms := make(map[string]int, len(x.Mods))
+	for k, v := range x.Mods {
+		ms[k] = v
+	}
+	x.Mods = ms
+	return x
+}
+
+func (m *AccountStore) RecordCrash(r api0.CrashReport) error {
+	m.crashesMu.Lock()
+	defer m.crashesMu.Unlock()
+
+	if m.crashes == nil {
+		m.crashes = map[string]api0.CrashSignature{}
+	}
+	c, ok := m.crashes[r.Signature]
+	if ok {
+		c = cloneCrash(c)
+	} else {
+		c = api0.CrashSignature{
+			Signature: r.Signature,
+			First:     r.Time,
+			Versions:  map[string]int{},
+			Mods:      map[string]int{},
+		}
+	}
+	c.Last = r.Time
+	c.Count++
+	c.Fingerprint = r.Fingerprint
+	c.Fingerprint.Stack = append([]string(nil), r.Fingerprint.Stack...)
+	c.Versions[r.Version]++
+	for _, x := range r.Mods {
+		c.Mods[x]++
+	}
+	m.crashes[r.Signature] = c
+	return nil
+}
+
+func (m *AccountStore) GetCrashes(since time.Time, limit int) ([]api0.CrashSignature, error) {
+	m.crashesMu.RLock()
+	defer m.crashesMu.RUnlock()
+
+	var cs []api0.CrashSignature
+	for _, c := range m.crashes {
+		if !c.Last.Before(since) {
+			cs = append(cs, cloneCrash(c))
+		}
+	}
+	sort.SliceStable(cs, func(i, j int) bool {
+		if cs[i].Count != cs[j].Count {
+			return cs[i].Count > cs[j].Count
+		}
+		return cs[i].Last.After(cs[j].Last)
+	})
+	if limit > 0 && len(cs) > limit {
+		cs = cs[:limit]
+	}
+	return cs, nil
+}
+
+func (m *AccountStore) GetCrash(signature string) (*api0.CrashSignature, error) {
+	m.crashesMu.RLock()
+	defer m.crashesMu.RUnlock()
+
+	if c, ok := m.crashes[signature]; ok {
+		c = cloneCrash(c)
+		return &c, nil
+	}
+	return nil, nil
+}
+
+func (m *AccountStore) DeleteCrash(signature string) error {
+	m.crashesMu.Lock()
+	defer m.crashesMu.Unlock()
+
+	delete(m.crashes, signature)
+	return nil
+}
+
+func (m *AccountStore) DeleteCrashesBefore(t time.Time) error {
+	m.crashesMu.Lock()
+	defer m.crashesMu.Unlock()
+
+	for k, c := range m.crashes {
+		if c.Last.Before(t) {
+			delete(m.crashes, k)
+		}
+	}
+	return nil
+}
+
+func (m *AccountStore) GetState(key string) ([]byte, bool, error) {
+	v, ok := m.state.Load(key)
+	if !ok {
+		return nil, ok, nil
+	}
+	b := make([]byte, len(v.([]byte)))
+	copy(b, v.([]byte))
+	return b, ok, nil
+}
+
+func (m *AccountStore) GetStateKeys() ([]string, error) {
+	var ks []string
+	m.state.Range(func(k, _ any) bool {
+		ks = append(ks, k.(string))
+		return true
+	})
+	sort.Strings(ks)
+	return ks, nil
+}
+
+func (m *AccountStore) SetState(key string, buf []byte) error {
+	if buf == nil {
+		m.state.Delete(key)
+	} else {
+		b := make([]byte, len(buf))
+		copy(b, buf)
+		m.state.Store(key, b)
 	}
 	return nil
 }
 
+type serverStatsKey struct {
+	resolution time.Duration
+	start      int64
+}
+
+func (m *AccountStore) GetServerStats(addr netip.AddrPort, resolution time.Duration, since time.Time) ([]api0.ServerStatsBucket, error) {
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	var bs []api0.ServerStatsBucket
+	for k, b := range m.stats[addr] {
+		if k.resolution == resolution && !b.Start.Before(since) {
+			b.Maps = copyServerStatsMaps(b.Maps)
+			bs = append(bs, b)
+		}
+	}
+	sort.Slice(bs, func(i, j int) bool {
+		return bs[i].Start.Before(bs[j].Start)
+	})
+	return bs, nil
+}
+
+func (m *AccountStore) SaveServerStats(bs []api0.ServerStatsBucket) error {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+
+	if m.stats == nil {
+		m.stats = map[netip.AddrPort]map[serverStatsKey]api0.ServerStatsBucket{}
+	}
+	for _, b := range bs {
+		if m.stats[b.Addr] == nil {
+			m.stats[b.Addr] = map[serverStatsKey]api0.ServerStatsBucket{}
+		}
+		b.Maps = copyServerStatsMaps(b.Maps)
+		m.stats[b.Addr][serverStatsKey{b.Resolution, b.Start.UnixNano()}] = b
+	}
+	return nil
+}
+
+func (m *AccountStore) DeleteServerStats(resolution time.Duration, before time.Time) (int, error) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+
+	var n int
+	for addr, x := range m.stats {
+		for k, b := range x {
+			if k.resolution == resolution && b.Start.Before(before) {
+				delete(x, k)
+				n++
+			}
+		}
+		if len(x) == 0 {
+			delete(m.stats, addr)
+		}
+	}
+	return n, nil
+}
+
+func copyServerStatsMaps(x map[string]int) map[string]int {
+	if x == nil {
+		return nil
+	}
+	r := make(map[string]int, len(x))
+	for k, v := range x {
+		r[k] = v
+	}
+	return r
+}
+
 // PdataStore stores pdata in-memory, with optional compression.
 type PdataStore struct {
 	gzip  bool
@@ -77,6 +1012,18 @@ func (m *PdataStore) GetPdataHash(uid uint64) ([sha256.Size]byte, bool, error) {
 	return v.(pdataStoreEntry).Hash, ok, nil
 }
 
+func (m *PdataStore) GetPdataUIDs() ([]uint64, error) {
+	var uids []uint64
+	m.pdata.Range(func(k, _ any) bool {
+		uids = append(uids, k.(uint64))
+		return true
+	})
+	sort.Slice(uids, func(i, j int) bool {
+		return uids[i] < uids[j]
+	})
+	return uids, nil
+}
+
 func (m *PdataStore) GetPdataCached(uid uint64, sha [sha256.Size]byte) ([]byte, bool, error) {
 	v, ok := m.pdata.Load(uid)
 	if !ok {
@@ -125,3 +1072,8 @@ func (m *PdataStore) SetPdata(uid uint64, buf []byte) (int, error) {
 	})
 	return len(b), nil
 }
+
+func (m *PdataStore) DeletePdata(uid uint64) error {
+	m.pdata.Delete(uid)
+	return nil
+}
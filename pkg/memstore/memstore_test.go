@@ -18,3 +18,67 @@ func TestPdataStore(t *testing.T) {
 		api0testutil.TestPdataStorage(t, NewPdataStore(true))
 	})
 }
+
+func TestAccountLinkStore(t *testing.T) {
+	api0testutil.TestAccountLinkStorage(t, NewAccountStore())
+}
+
+func TestUsernameHistoryStore(t *testing.T) {
+	api0testutil.TestUsernameHistoryStorage(t, NewAccountStore())
+}
+
+func TestAccountSignalStore(t *testing.T) {
+	api0testutil.TestAccountSignalStorage(t, NewAccountStore())
+}
+
+func TestBanListStore(t *testing.T) {
+	api0testutil.TestBanListStorage(t, NewAccountStore())
+}
+
+func TestAbuseReportStore(t *testing.T) {
+	api0testutil.TestAbuseReportStorage(t, NewAccountStore())
+}
+
+func TestRatingStore(t *testing.T) {
+	api0testutil.TestRatingStorage(t, NewAccountStore())
+}
+
+func TestMatchHistoryStore(t *testing.T) {
+	api0testutil.TestMatchHistoryStorage(t, NewAccountStore())
+}
+
+func TestMuteReportStore(t *testing.T) {
+	api0testutil.TestMuteReportStorage(t, NewAccountStore())
+}
+
+func TestFriendStore(t *testing.T) {
+	api0testutil.TestFriendStorage(t, NewAccountStore())
+}
+
+func TestBlockStore(t *testing.T) {
+	api0testutil.TestBlockStorage(t, NewAccountStore())
+}
+
+func TestCrashStore(t *testing.T) {
+	api0testutil.TestCrashStorage(t, NewAccountStore())
+}
+
+func TestStateStore(t *testing.T) {
+	api0testutil.TestStateStorage(t, NewAccountStore())
+}
+
+func TestServerStatsStore(t *testing.T) {
+	api0testutil.TestServerStatsStorage(t, NewAccountStore())
+}
+
+func TestAccountListStore(t *testing.T) {
+	api0testutil.TestAccountListStorage(t, NewAccountStore())
+}
+
+func TestPdataListStore(t *testing.T) {
+	api0testutil.TestPdataListStorage(t, NewPdataStore(true))
+}
+
+func TestStateListStore(t *testing.T) {
+	api0testutil.TestStateListStorage(t, NewAccountStore())
+}
@@ -0,0 +1,67 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestAppend(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		buf  []byte
+		exp  string
+	}{
+		{"nil", AppendNil(nil), "c0"},
+		{"false", AppendBool(nil, false), "c2"},
+		{"true", AppendBool(nil, true), "c3"},
+		{"int 0", AppendInt(nil, 0), "00"},
+		{"int 127", AppendInt(nil, 127), "7f"},
+		{"int 128", AppendInt(nil, 128), "cc80"},
+		{"int 256", AppendInt(nil, 256), "cd0100"},
+		{"int 65536", AppendInt(nil, 65536), "ce00010000"},
+		{"int 1<<32", AppendInt(nil, 1<<32), "cf0000000100000000"},
+		{"int -1", AppendInt(nil, -1), "ff"},
+		{"int -32", AppendInt(nil, -32), "e0"},
+		{"int -33", AppendInt(nil, -33), "d0df"},
+		{"int -129", AppendInt(nil, -129), "d1ff7f"},
+		{"int -32769", AppendInt(nil, -32769), "d2ffff7fff"},
+		{"int min", AppendInt(nil, math.MinInt64), "d38000000000000000"},
+		{"uint max", AppendUint(nil, math.MaxUint64), "cfffffffffffffffff"},
+		{"float", AppendFloat(nil, 1.5), "cb3ff8000000000000"},
+		{"str empty", AppendString(nil, ""), "a0"},
+		{"str", AppendString(nil, "abc"), "a3616263"},
+		{"str 32", AppendString(nil, strings.Repeat("a", 32)), "d920" + strings.Repeat("61", 32)},
+		{"str 256", AppendString(nil, strings.Repeat("a", 256))[:3], "da0100"},
+		{"str 65536", AppendString(nil, strings.Repeat("a", 65536))[:5], "db00010000"},
+		{"array 0", AppendArrayHeader(nil, 0), "90"},
+		{"array 15", AppendArrayHeader(nil, 15), "9f"},
+		{"array 16", AppendArrayHeader(nil, 16), "dc0010"},
+		{"array 65536", AppendArrayHeader(nil, 65536), "dd00010000"},
+		{"map 1", AppendMapHeader(nil, 1), "81"},
+		{"map 16", AppendMapHeader(nil, 16), "de0010"},
+		{"map 65536", AppendMapHeader(nil, 65536), "df00010000"},
+	} {
+		if act := hex.EncodeToString(tc.buf); act != tc.exp {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.exp, act)
+		}
+	}
+}
+
+func TestAppendAllocs(t *testing.T) {
+	b := make([]byte, 0, 64)
+	if n := testing.AllocsPerRun(100, func() {
+		b = AppendMapHeader(b[:0], 2)
+		b = AppendString(b, "a")
+		b = AppendInt(b, -1000)
+		b = AppendString(b, "b")
+		b = AppendBool(b, true)
+	}); n != 0 {
+		t.Errorf("expected no allocations, got %f", n)
+	}
+	if !bytes.Equal(b, []byte{0x82, 0xa1, 'a', 0xd1, 0xfc, 0x18, 0xa1, 'b', 0xc3}) {
+		t.Errorf("incorrect result %x", b)
+	}
+}
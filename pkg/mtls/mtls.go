@@ -0,0 +1,254 @@
+// Package mtls implements mutual TLS with a private CA and certificate
+// revocation lists for cluster-internal connections.
+package mtls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRevoked is returned when a client certificate has been revoked.
+var ErrRevoked = errors.New("certificate revoked")
+
+// ErrNotAllowed is returned when a client certificate is valid, but doesn't
+// have an allowed name.
+var ErrNotAllowed = errors.New("certificate name not allowed")
+
+// Verifier verifies client certificates against a private CA, revocation
+// lists, and optionally, a list of allowed names. It is safe for concurrent
+// use.
+type Verifier struct {
+	cas     []*x509.Certificate
+	pool    *x509.CertPool
+	crlFile []string
+	names   map[string]bool
+	revoked atomic.Pointer[revocations]
+}
+
+// revocations contains the revoked serials for each issuer.
+type revocations struct {
+	serials map[string]map[string]bool // [raw issuer][serial]
+	next    time.Time                  // earliest NextUpdate, zero if none
+}
+
+// NewVerifier creates a new verifier trusting the PEM-encoded CA certificates
+// in caFiles. The CRLs (PEM or DER) in crlFiles are loaded, and can be
+// reloaded with Reload. If names is non-empty, client certificates must have a
+// common name or DNS SAN in it.
+func NewVerifier(caFiles, crlFiles, names []string) (*Verifier, error) {
+	if len(caFiles) == 0 {
+		return nil, fmt.Errorf("no ca certificates provided")
+	}
+	v := &Verifier{
+		pool:    x509.NewCertPool(),
+		crlFile: crlFiles,
+	}
+	for _, fn := range caFiles {
+		buf, err := os.ReadFile(fn)
+		if err != nil {
+			return nil, fmt.Errorf("read ca %q: %w", fn, err)
+		}
+		var n int
+		for {
+			var b *pem.Block
+			if b, buf = pem.Decode(buf); b == nil {
+				break
+			}
+			if b.Type != "CERTIFICATE" {
+				continue
+			}
+			c, err := x509.ParseCertificate(b.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parse ca %q: %w", fn, err)
+			}
+			v.cas = append(v.cas, c)
+			v.pool.AddCert(c)
+			n++
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("parse ca %q: no certificates found", fn)
+		}
+	}
+	if len(names) != 0 {
+		v.names = make(map[string]bool, len(names))
+		for _, n := range names {
+			v.names[n] = true
+		}
+	}
+	if err := v.Reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Reload reloads the CRLs. If an error occurs, the previous ones are kept.
+func (v *Verifier) Reload() error {
+	rv := &revocations{
+		serials: map[string]map[string]bool{},
+	}
+	for _, fn := range v.crlFile {
+		buf, err := os.ReadFile(fn)
+		if err != nil {
+			return fmt.Errorf("read crl %q: %w", fn, err)
+		}
+		var ders [][]byte
+		if bytes.Contains(buf, []byte("-----BEGIN")) {
+			for {
+				var b *pem.Block
+				if b, buf = pem.Decode(buf); b == nil {
+					break
+				}
+				if b.Type == "X509 CRL" {
+					ders = append(ders, b.Bytes)
+				}
+			}
+		} else {
+			ders = append(ders, buf)
+		}
+		if len(ders) == 0 {
+			return fmt.Errorf("parse crl %q: no crls found", fn)
+		}
+		for _, der := range ders {
+			rl, err := x509.ParseRevocationList(der)
+			if err != nil {
+				return fmt.Errorf("parse crl %q: %w", fn, err)
+			}
+			var signed bool
+			for _, ca := range v.cas {
+				if bytes.Equal(ca.RawSubject, rl.RawIssuer) && rl.CheckSignatureFrom(ca) == nil {
+					signed = true
+					break
+				}
+			}
+			if !signed {
+				return fmt.Errorf("parse crl %q: not signed by a trusted ca", fn)
+			}
+			m := rv.serials[string(rl.RawIssuer)]
+			if m == nil {
+				m = map[string]bool{}
+				rv.serials[string(rl.RawIssuer)] = m
+			}
+			for _, rc := range rl.RevokedCertificates {
+				m[serialKey(rc.SerialNumber)] = true
+			}
+			if !rl.NextUpdate.IsZero() && (rv.next.IsZero() || rl.NextUpdate.Before(rv.next)) {
+				rv.next = rl.NextUpdate
+			}
+		}
+	}
+	v.revoked.Store(rv)
+	return nil
+}
+
+// NextUpdate returns the earliest time the loaded CRLs should be updated by,
+// or zero if unknown.
+func (v *Verifier) NextUpdate() time.Time {
+	return v.revoked.Load().next
+}
+
+func serialKey(n *big.Int) string {
+	return string(n.Bytes())
+}
+
+// Check checks verified certificate chains (as in tls.ConnectionState) for
+// revocation and allowed names. At least one chain must pass.
+func (v *Verifier) Check(chains [][]*x509.Certificate) error {
+	if len(chains) == 0 {
+		return fmt.Errorf("no verified client certificate")
+	}
+	rv := v.revoked.Load()
+	err := fmt.Errorf("no verified client certificate")
+chains:
+	for _, chain := range chains {
+		if len(chain) == 0 {
+			continue
+		}
+		for _, c := range chain {
+			if rv.serials[string(c.RawIssuer)][serialKey(c.SerialNumber)] {
+				err = fmt.Errorf("%w: %s (serial %s)", ErrRevoked, c.Subject, c.SerialNumber)
+				continue chains
+			}
+		}
+		if v.names != nil && !v.allowed(chain[0]) {
+			err = fmt.Errorf("%w: %s", ErrNotAllowed, chain[0].Subject)
+			continue
+		}
+		return nil
+	}
+	return err
+}
+
+func (v *Verifier) allowed(c *x509.Certificate) bool {
+	if v.names[c.Subject.CommonName] {
+		return true
+	}
+	for _, n := range c.DNSNames {
+		if v.names[n] {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerConfig returns a copy of base (which may be nil) requiring verified
+// client certificates.
+func (v *Verifier) ServerConfig(base *tls.Config) *tls.Config {
+	var t *tls.Config
+	if base != nil {
+		t = base.Clone()
+	} else {
+		t = &tls.Config{}
+	}
+	t.ClientAuth = tls.RequireAndVerifyClientCert
+	t.ClientCAs = v.pool
+	t.VerifyConnection = func(cs tls.ConnectionState) error {
+		return v.Check(cs.VerifiedChains)
+	}
+	return t
+}
+
+// Verified checks if r was made over a connection with a verified client
+// certificate which is still valid (i.e., it hasn't been revoked by a CRL
+// loaded since the connection was established).
+func (v *Verifier) Verified(r *http.Request) bool {
+	if r.TLS == nil {
+		return false
+	}
+	return v.Check(r.TLS.VerifiedChains) == nil
+}
+
+// ClientConfig creates a TLS config for connecting to a server with mTLS
+// using the certificate and key in the provided PEM files. If caFiles is
+// non-empty, the server certificate is verified against it instead of the
+// system roots.
+func ClientConfig(certFile, keyFile string, caFiles []string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load client certificate: %w", err)
+	}
+	t := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if len(caFiles) != 0 {
+		t.RootCAs = x509.NewCertPool()
+		for _, fn := range caFiles {
+			buf, err := os.ReadFile(fn)
+			if err != nil {
+				return nil, fmt.Errorf("read ca %q: %w", fn, err)
+			}
+			if !t.RootCAs.AppendCertsFromPEM(buf) {
+				return nil, fmt.Errorf("parse ca %q: no certificates found", fn)
+			}
+		}
+	}
+	return t, nil
+}
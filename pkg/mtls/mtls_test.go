@@ -0,0 +1,192 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCA{cert, key}
+}
+
+func (ca testCA) issue(t *testing.T, serial int64, name string, server bool) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (ca testCA) crl(t *testing.T, serials ...int64) []byte {
+	t.Helper()
+	var rcs []pkix.RevokedCertificate
+	for _, s := range serials {
+		rcs = append(rcs, pkix.RevokedCertificate{SerialNumber: big.NewInt(s), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(int64(len(serials) + 1)),
+		ThisUpdate:          time.Now().Add(-time.Minute),
+		NextUpdate:          time.Now().Add(time.Hour),
+		RevokedCertificates: rcs,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func TestVerifier(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	crlFile := filepath.Join(dir, "ca.crl")
+	if err := os.WriteFile(crlFile, ca.crl(t, 3), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVerifier([]string{caFile}, []string{crlFile}, []string{"relay", "admin"})
+	if err != nil {
+		t.Fatalf("new verifier: %v", err)
+	}
+	if v.NextUpdate().IsZero() {
+		t.Errorf("expected crl next update to be set")
+	}
+
+	var verified bool
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verified = v.Verified(r)
+	}))
+	srv.TLS = v.ServerConfig(&tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, 100, "server", true)},
+	})
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(cert *tls.Certificate) error {
+		tc := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			tc.Certificates = []tls.Certificate{*cert}
+		}
+		cl := &http.Client{Transport: &http.Transport{TLSClientConfig: tc}}
+		defer cl.CloseIdleConnections()
+		resp, err := cl.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	good := ca.issue(t, 2, "relay", false)
+	if err := get(&good); err != nil || !verified {
+		t.Errorf("expected valid client certificate to be accepted, got %v", err)
+	}
+	if err := get(nil); err == nil {
+		t.Errorf("expected request without client certificate to be rejected")
+	}
+	revoked := ca.issue(t, 3, "relay", false)
+	if err := get(&revoked); err == nil {
+		t.Errorf("expected revoked client certificate to be rejected")
+	}
+	other := ca.issue(t, 4, "other", false)
+	if err := get(&other); err == nil {
+		t.Errorf("expected client certificate with a disallowed name to be rejected")
+	}
+	untrusted := newTestCA(t).issue(t, 2, "relay", false)
+	if err := get(&untrusted); err == nil {
+		t.Errorf("expected client certificate from an untrusted ca to be rejected")
+	}
+
+	chain, err := x509.ParseCertificate(good.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains := [][]*x509.Certificate{{chain, ca.cert}}
+	if err := v.Check(chains); err != nil {
+		t.Errorf("check valid chain: %v", err)
+	}
+
+	if err := os.WriteFile(crlFile, ca.crl(t, 2, 3), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if err := v.Check(chains); !errors.Is(err, ErrRevoked) {
+		t.Errorf("expected chain to be revoked after reload, got %v", err)
+	}
+
+	if err := os.WriteFile(crlFile, newTestCA(t).crl(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Reload(); err == nil {
+		t.Errorf("expected crl from an untrusted ca to be rejected")
+	}
+	if err := v.Check(chains); !errors.Is(err, ErrRevoked) {
+		t.Errorf("expected previous crls to be kept after failed reload, got %v", err)
+	}
+}
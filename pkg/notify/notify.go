@@ -0,0 +1,330 @@
+// Package notify sends operational notifications to webhooks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/outbox"
+	"github.com/rs/zerolog"
+)
+
+// Message is a notification.
+type Message struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Text   string    `json:"text"`
+	Fields []Field   `json:"fields,omitempty"`
+
+	// Key, if set, deduplicates messages when the Webhook has an Outbox.
+	Key string `json:"-"`
+}
+
+// Field is a named value attached to a Message.
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Format is the request body format for a Webhook.
+type Format string
+
+const (
+	FormatJSON    Format = "json"    // the Message as JSON
+	FormatDiscord Format = "discord" // a Discord webhook embed
+)
+
+// Webhook sends messages to a webhook one at a time. Unless an Outbox is set,
+// messages are dropped if the queue is full or the request fails. It is safe
+// for concurrent use.
+type Webhook struct {
+	// URL is the webhook URL. It is required.
+	URL string
+
+	// Format is the request body format. If empty, it defaults to FormatJSON.
+	Format Format
+
+	// Header contains additional headers to send (e.g., Authorization).
+	Header http.Header
+
+	// Client is the HTTP client to use. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// QueueSize is the maximum number of messages waiting to be sent. If
+	// zero, it defaults to 1000. It is ignored if Outbox is set.
+	QueueSize int
+
+	// Outbox, if set, persists messages until they are sent, retrying failed
+	// requests according to OutboxOptions. It must not be changed after the
+	// Webhook is first used.
+	Outbox        outbox.Backend
+	OutboxOptions outbox.Options
+
+	// Logger is used by the outbox, if set.
+	Logger zerolog.Logger
+
+	// ErrorHook is called when a message fails to be sent.
+	ErrorHook func(m Message, err error)
+
+	// Name, if provided, is added as a label to the metrics to distinguish
+	// multiple webhooks.
+	Name string
+
+	init  sync.Once
+	queue chan Message
+	ob    *outbox.Queue
+
+	metrics struct {
+		messages_total struct {
+			queued     atomic.Uint64
+			sent       atomic.Uint64
+			dropped    atomic.Uint64
+			failed     atomic.Uint64
+			encode_err atomic.Uint64
+		}
+	}
+}
+
+func (w *Webhook) initQueue() {
+	w.init.Do(func() {
+		n := w.QueueSize
+		if n <= 0 {
+			n = 1000
+		}
+		w.queue = make(chan Message, n)
+		if w.Outbox != nil {
+			name := "notify"
+			if w.Name != "" {
+				name += ":" + w.Name
+			}
+			w.ob = &outbox.Queue{
+				Backend: w.Outbox,
+				Name:    name,
+				Send:    w.sendOutbox,
+				Options: w.OutboxOptions,
+				Logger:  w.Logger,
+			}
+		}
+	})
+}
+
+// Publish queues m to be sent. It never blocks unless an Outbox is set, in
+// which case it waits for m to be persisted.
+func (w *Webhook) Publish(m Message) {
+	w.initQueue()
+
+	if w.ob != nil {
+		buf, err := json.Marshal(m)
+		if err != nil {
+			w.metrics.messages_total.encode_err.Add(1)
+			return
+		}
+		if err := w.ob.Enqueue(m.Key, buf); err != nil {
+			w.metrics.messages_total.dropped.Add(1)
+			if w.ErrorHook != nil {
+				w.ErrorHook(m, fmt.Errorf("persist message: %w", err))
+			}
+			return
+		}
+		w.metrics.messages_total.queued.Add(1)
+		return
+	}
+
+	select {
+	case w.queue <- m:
+		w.metrics.messages_total.queued.Add(1)
+	default:
+		w.metrics.messages_total.dropped.Add(1)
+	}
+}
+
+// Run sends queued messages until ctx is canceled, then attempts to send any
+// remaining queued messages. If an Outbox is set, unsent messages are left in
+// it instead.
+func (w *Webhook) Run(ctx context.Context) {
+	w.initQueue()
+
+	if w.ob != nil {
+		w.ob.Run(ctx)
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case m := <-w.queue:
+					w.send(m)
+				default:
+					return
+				}
+			}
+		case m := <-w.queue:
+			w.send(m)
+		}
+	}
+}
+
+func (w *Webhook) send(m Message) {
+	buf, err := w.encode(m)
+	if err != nil {
+		w.metrics.messages_total.encode_err.Add(1)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	if err := w.post(ctx, buf); err != nil {
+		w.metrics.messages_total.failed.Add(1)
+		if w.ErrorHook != nil {
+			w.ErrorHook(m, err)
+		}
+		return
+	}
+	w.metrics.messages_total.sent.Add(1)
+}
+
+// sendOutbox sends a persisted message. Client errors other than rate limits
+// are not retried.
+func (w *Webhook) sendOutbox(ctx context.Context, payload []byte) error {
+	var m Message
+	if err := json.Unmarshal(payload, &m); err != nil {
+		w.metrics.messages_total.encode_err.Add(1)
+		return outbox.Permanent(err)
+	}
+	buf, err := w.encode(m)
+	if err != nil {
+		w.metrics.messages_total.encode_err.Add(1)
+		return outbox.Permanent(err)
+	}
+	if err := w.post(ctx, buf); err != nil {
+		w.metrics.messages_total.failed.Add(1)
+		if w.ErrorHook != nil {
+			w.ErrorHook(m, err)
+		}
+		var se statusError
+		if errors.As(err, &se) && se.code >= 400 && se.code <= 499 && se.code != http.StatusTooManyRequests {
+			return outbox.Permanent(err)
+		}
+		return err
+	}
+	w.metrics.messages_total.sent.Add(1)
+	return nil
+}
+
+type statusError struct {
+	code   int
+	status string
+}
+
+func (err statusError) Error() string {
+	return fmt.Sprintf("response status %d (%s)", err.code, err.status)
+}
+
+func (w *Webhook) post(ctx context.Context, buf []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	for k, v := range w.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	cl := w.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+
+	resp, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError{resp.StatusCode, resp.Status}
+	}
+	return nil
+}
+
+func (w *Webhook) encode(m Message) ([]byte, error) {
+	switch w.Format {
+	case "", FormatJSON:
+		return json.Marshal(m)
+	case FormatDiscord:
+		type embedField struct {
+			Name   string `json:"name"`
+			Value  string `json:"value"`
+			Inline bool   `json:"inline"`
+		}
+		type embed struct {
+			Title       string       `json:"title"`
+			Description string       `json:"description,omitempty"`
+			Timestamp   string       `json:"timestamp,omitempty"`
+			Fields      []embedField `json:"fields,omitempty"`
+		}
+		e := embed{
+			Title:       truncate(m.Type, 256),
+			Description: truncate(m.Text, 4096),
+		}
+		if !m.Time.IsZero() {
+			e.Timestamp = m.Time.UTC().Format(time.RFC3339)
+		}
+		for _, f := range m.Fields {
+			if len(e.Fields) == 25 {
+				break
+			}
+			if f.Name != "" && f.Value != "" {
+				e.Fields = append(e.Fields, embedField{
+					Name:   truncate(f.Name, 256),
+					Value:  truncate(f.Value, 1024),
+					Inline: true,
+				})
+			}
+		}
+		return json.Marshal(map[string]any{
+			"embeds":           []embed{e},
+			"allowed_mentions": map[string]any{"parse": []string{}},
+		})
+	default:
+		return nil, fmt.Errorf("unknown format %q", w.Format)
+	}
+}
+
+// truncate truncates s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+// WritePrometheus writes prometheus text metrics to w.
+func (w *Webhook) WritePrometheus(wr io.Writer) {
+	var l string
+	if w.Name != "" {
+		l = `,webhook="` + w.Name + `"`
+	}
+	fmt.Fprintln(wr, `atlas_notify_messages_total{result="queued"`+l+`}`, w.metrics.messages_total.queued.Load())
+	fmt.Fprintln(wr, `atlas_notify_messages_total{result="sent"`+l+`}`, w.metrics.messages_total.sent.Load())
+	fmt.Fprintln(wr, `atlas_notify_messages_total{result="dropped"`+l+`}`, w.metrics.messages_total.dropped.Load())
+	fmt.Fprintln(wr, `atlas_notify_messages_total{result="failed"`+l+`}`, w.metrics.messages_total.failed.Load())
+	fmt.Fprintln(wr, `atlas_notify_messages_total{result="encode_err"`+l+`}`, w.metrics.messages_total.encode_err.Load())
+	if w.initQueue(); w.ob != nil {
+		w.ob.WritePrometheus(wr)
+	}
+}
@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/outbox"
+)
+
+func TestWebhook(t *testing.T) {
+	for _, f := range []Format{FormatJSON, FormatDiscord} {
+		f := f
+		t.Run(string(f), func(t *testing.T) {
+			var mu sync.Mutex
+			var bodies []map[string]any
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if v := r.Header.Get("Content-Type"); v != "application/json" {
+					t.Errorf("incorrect content type %q", v)
+				}
+				var v map[string]any
+				if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+					t.Errorf("invalid body: %v", err)
+				}
+				mu.Lock()
+				bodies = append(bodies, v)
+				mu.Unlock()
+			}))
+			defer srv.Close()
+
+			w := &Webhook{
+				URL:       srv.URL,
+				Format:    f,
+				QueueSize: 3,
+				ErrorHook: func(m Message, err error) {
+					t.Errorf("unexpected error sending %q: %v", m.Type, err)
+				},
+			}
+			for i := 0; i < 5; i++ {
+				w.Publish(Message{
+					Time:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+					Type:   "test",
+					Text:   strings.Repeat("x", 5000),
+					Fields: []Field{{Name: "n", Value: "1"}, {Name: "empty"}},
+				})
+			}
+			if n := w.metrics.messages_total.dropped.Load(); n != 2 {
+				t.Errorf("expected 2 messages to be dropped, got %d", n)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			w.Run(ctx)
+
+			if len(bodies) != 3 {
+				t.Fatalf("expected 3 messages to be sent, got %d", len(bodies))
+			}
+			switch f {
+			case FormatJSON:
+				if v := bodies[0]["type"]; v != "test" {
+					t.Errorf("incorrect type %v", v)
+				}
+			case FormatDiscord:
+				es, _ := bodies[0]["embeds"].([]any)
+				if len(es) != 1 {
+					t.Fatalf("expected 1 embed, got %v", bodies[0])
+				}
+				e := es[0].(map[string]any)
+				if v, _ := e["description"].(string); len(v) != 4096 {
+					t.Errorf("expected description to be truncated, got length %d", len(v))
+				}
+				if v, _ := e["fields"].([]any); len(v) != 1 {
+					t.Errorf("expected empty fields to be skipped, got %v", v)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookOutbox(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		n     int
+		types []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m Message
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			t.Errorf("invalid body: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if n++; n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if m.Type == "rejected" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		types = append(types, m.Type)
+	}))
+	defer srv.Close()
+
+	var b outbox.Memory
+	w := &Webhook{
+		URL:    srv.URL,
+		Outbox: &b,
+		OutboxOptions: outbox.Options{
+			Backoff:      time.Millisecond * 10,
+			PollInterval: time.Millisecond * 10,
+		},
+	}
+	w.Publish(Message{Type: "a", Key: "a"})
+	w.Publish(Message{Type: "a", Key: "a"}) // duplicate
+	w.Publish(Message{Type: "rejected"})
+	w.Publish(Message{Type: "b"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	for i := 0; i < 200; i++ {
+		mu.Lock()
+		v := n
+		mu.Unlock()
+		if v >= 4 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if n != 4 || strings.Join(types, ",") != "b,a" {
+		t.Errorf("expected a to be retried, rejected to be dead-lettered, and b to be sent, got %d requests, sent %q", n, types)
+	}
+}
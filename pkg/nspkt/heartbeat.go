@@ -0,0 +1,190 @@
+package nspkt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/netip"
+	"strconv"
+)
+
+// AtlasHeartbeatMACSize is the size of the truncated HMAC-SHA256 used to sign
+// heartbeat packets.
+const AtlasHeartbeatMACSize = 16
+
+// AtlasHeartbeat is a `Theartbeat1` packet, which game servers registered with
+// a signing key can send to the master server instead of /server/heartbeat to
+// avoid the overhead of a HTTP request.
+//
+//	4:  i32 = -1
+//	1:  u8  = 'T'
+//	11: str = "heartbeat1\0"
+//	8:  i64 = unix timestamp (ms)
+//	1:  u8  = player count (0xFF if unchanged)
+//	1:  u8  = max players (0xFF if unchanged)
+//	1:  u8  = server id length
+//	n:  str = server id
+//	16: u8s = HMAC-SHA256(signing key, all of the above)[:16]
+type AtlasHeartbeat struct {
+	ServerID    string
+	Timestamp   int64 // unix milliseconds
+	PlayerCount int   // -1 if unchanged
+	MaxPlayers  int   // -1 if unchanged
+
+	signed []byte
+	mac    []byte
+}
+
+// AtlasHeartbeatStatus is the result of an AtlasHeartbeat. Game servers
+// should fall back to /server/heartbeat (which will respond with a more
+// detailed error) if it isn't AtlasHeartbeatOK.
+type AtlasHeartbeatStatus uint8
+
+const (
+	AtlasHeartbeatOK       AtlasHeartbeatStatus = iota // heartbeat accepted
+	AtlasHeartbeatFallback                             // heartbeat must be sent over HTTP
+	AtlasHeartbeatInvalid                              // signature is invalid, expired, or replayed
+	AtlasHeartbeatNotFound                             // server is not registered
+)
+
+func (s AtlasHeartbeatStatus) String() string {
+	switch s {
+	case AtlasHeartbeatOK:
+		return "ok"
+	case AtlasHeartbeatFallback:
+		return "fallback"
+	case AtlasHeartbeatInvalid:
+		return "invalid"
+	case AtlasHeartbeatNotFound:
+		return "not_found"
+	}
+	return strconv.Itoa(int(s))
+}
+
+// AtlasHeartbeatHandler handles an AtlasHeartbeat from addr, returning the
+// status and the signing key for the acknowledgement (if the status is
+// AtlasHeartbeatOK).
+type AtlasHeartbeatHandler func(addr netip.AddrPort, hb *AtlasHeartbeat) (AtlasHeartbeatStatus, []byte)
+
+const (
+	atlasHeartbeatName    = "heartbeat1\x00"
+	atlasHeartbeatAckName = "heartbeatack1\x00"
+)
+
+// AppendAtlasHeartbeat appends an unencrypted heartbeat packet for hb signed
+// with key to b.
+func AppendAtlasHeartbeat(b, key []byte, hb AtlasHeartbeat) []byte {
+	n := len(b)
+	b = append(b, "\xFF\xFF\xFF\xFF"...)
+	b = append(b, 'T')
+	b = append(b, atlasHeartbeatName...)
+	b = binary.LittleEndian.AppendUint64(b, uint64(hb.Timestamp))
+	b = append(b, heartbeatCount(hb.PlayerCount), heartbeatCount(hb.MaxPlayers))
+	b = append(b, byte(len(hb.ServerID)))
+	b = append(b, hb.ServerID[:len(hb.ServerID)&0xFF]...)
+	return append(b, heartbeatMAC(key, b[n:])...)
+}
+
+// ParseAtlasHeartbeat parses an unencrypted heartbeat packet. The signature
+// must be checked with Verify.
+func ParseAtlasHeartbeat(data []byte) (*AtlasHeartbeat, bool) {
+	const hdr = 4 + 1 + len(atlasHeartbeatName)
+	if len(data) < hdr+8+1+1+1+AtlasHeartbeatMACSize || !isAtlasPacket(data, atlasHeartbeatName) {
+		return nil, false
+	}
+	p := data[hdr:]
+	hb := &AtlasHeartbeat{
+		Timestamp:   int64(binary.LittleEndian.Uint64(p)),
+		PlayerCount: heartbeatCountValue(p[8]),
+		MaxPlayers:  heartbeatCountValue(p[9]),
+	}
+	idLen := int(p[10])
+	if p = p[11:]; len(p) != idLen+AtlasHeartbeatMACSize {
+		return nil, false
+	}
+	hb.ServerID = string(p[:idLen])
+	hb.signed = data[:len(data)-AtlasHeartbeatMACSize]
+	hb.mac = p[idLen:]
+	return hb, true
+}
+
+// Verify checks if hb was signed with key.
+func (hb *AtlasHeartbeat) Verify(key []byte) bool {
+	return hmac.Equal(hb.mac, heartbeatMAC(key, hb.signed))
+}
+
+// MAC gets the signature of hb, which can be used for replay protection.
+func (hb *AtlasHeartbeat) MAC() []byte {
+	return hb.mac
+}
+
+// AppendAtlasHeartbeatAck appends an unencrypted `Theartbeatack1` packet
+// acknowledging the heartbeat with the provided timestamp. If the status is
+// AtlasHeartbeatOK, it is signed with key so game servers can't be tricked
+// into thinking a heartbeat was received. Other statuses are not signed
+// since the signing key may not be known, and they only cause game servers to
+// fall back to HTTP.
+//
+//	4:  i32 = -1
+//	1:  u8  = 'T'
+//	14: str = "heartbeatack1\0"
+//	8:  i64 = heartbeat timestamp
+//	1:  u8  = status
+//	16: u8s = HMAC-SHA256(signing key, all of the above)[:16] (if ok)
+func AppendAtlasHeartbeatAck(b, key []byte, ts int64, status AtlasHeartbeatStatus) []byte {
+	n := len(b)
+	b = append(b, "\xFF\xFF\xFF\xFF"...)
+	b = append(b, 'T')
+	b = append(b, atlasHeartbeatAckName...)
+	b = binary.LittleEndian.AppendUint64(b, uint64(ts))
+	b = append(b, byte(status))
+	if status == AtlasHeartbeatOK {
+		b = append(b, heartbeatMAC(key, b[n:])...)
+	}
+	return b
+}
+
+// ParseAtlasHeartbeatAck parses an unencrypted heartbeat acknowledgement,
+// verifying it with key if the status is AtlasHeartbeatOK.
+func ParseAtlasHeartbeatAck(data, key []byte) (ts int64, status AtlasHeartbeatStatus, ok bool) {
+	const hdr = 4 + 1 + len(atlasHeartbeatAckName)
+	if len(data) < hdr+8+1 || !isAtlasPacket(data, atlasHeartbeatAckName) {
+		return 0, 0, false
+	}
+	ts = int64(binary.LittleEndian.Uint64(data[hdr:]))
+	status = AtlasHeartbeatStatus(data[hdr+8])
+	if status == AtlasHeartbeatOK {
+		if len(data) != hdr+8+1+AtlasHeartbeatMACSize || !hmac.Equal(data[hdr+8+1:], heartbeatMAC(key, data[:hdr+8+1])) {
+			return 0, 0, false
+		}
+	} else if len(data) != hdr+8+1 {
+		return 0, 0, false
+	}
+	return ts, status, true
+}
+
+// isAtlasPacket checks if data is a connectionless Atlas packet with the
+// provided null-terminated name.
+func isAtlasPacket(data []byte, name string) bool {
+	return len(data) >= 4+1+len(name) && binary.LittleEndian.Uint32(data) == 0xFFFFFFFF && data[4] == 'T' && string(data[5:5+len(name)]) == name
+}
+
+func heartbeatMAC(key, data []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(data)
+	return m.Sum(nil)[:AtlasHeartbeatMACSize]
+}
+
+func heartbeatCount(n int) byte {
+	if n < 0 || n >= 0xFF {
+		return 0xFF
+	}
+	return byte(n)
+}
+
+func heartbeatCountValue(b byte) int {
+	if b == 0xFF {
+		return -1
+	}
+	return int(b)
+}
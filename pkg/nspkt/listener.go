@@ -31,17 +31,20 @@ type Listener struct {
 
 	mon map[chan<- MonitorPacket]struct{}
 	wcr map[wcrKey]map[chan struct{}]struct{}
+	hb  AtlasHeartbeatHandler
 
 	metrics struct {
 		rx_count, rx_bytes struct {
 			invalid         atomic.Uint64
 			ignored         atomic.Uint64
 			r2_connect_resp atomic.Uint64
+			atlas_heartbeat atomic.Uint64
 			other           atomic.Uint64
 		}
 		tx_count, tx_bytes struct {
-			atlas_sigreq1 atomic.Uint64
-			r2_connect    atomic.Uint64
+			atlas_sigreq1       atomic.Uint64
+			atlas_heartbeat_ack atomic.Uint64
+			r2_connect          atomic.Uint64
 		}
 		tx_err_count struct {
 			nonce atomic.Uint64
@@ -163,6 +166,28 @@ func (l *Listener) Serve(conn *net.UDPConn) error {
 			}
 			delete(l.wcr, key)
 			l.mu.Unlock()
+		case kind == 'T' && isAtlasPacket(pkt.Data(), atlasHeartbeatName):
+			l.metrics.rx_count.atlas_heartbeat.Add(1)
+			l.metrics.rx_bytes.atlas_heartbeat.Add(uint64(n))
+
+			hb, ok := ParseAtlasHeartbeat(pkt.Data())
+			if !ok {
+				desc = "atlas_heartbeat invalid"
+				break
+			}
+			desc = "atlas_heartbeat server=" + hb.ServerID
+
+			l.mu.Lock()
+			fn := l.hb
+			l.mu.Unlock()
+
+			status, key := AtlasHeartbeatFallback, []byte(nil)
+			if fn != nil {
+				status, key = fn(addr, hb)
+			}
+			desc += " status=" + status.String()
+
+			l.sendAtlasHeartbeatAck(addr, key, hb.Timestamp, status)
 		default:
 			l.metrics.rx_count.other.Add(1)
 			l.metrics.rx_bytes.other.Add(uint64(n))
@@ -291,6 +316,22 @@ func (l *Listener) SendAtlasSigreq1Raw(addr netip.AddrPort, key, data []byte) er
 	return err
 }
 
+// HandleAtlasHeartbeats sets the handler for AtlasHeartbeat packets. If no
+// handler is set, game servers are told to fall back to HTTP.
+func (l *Listener) HandleAtlasHeartbeats(fn AtlasHeartbeatHandler) {
+	l.mu.Lock()
+	l.hb = fn
+	l.mu.Unlock()
+}
+
+func (l *Listener) sendAtlasHeartbeatAck(addr netip.AddrPort, key []byte, ts int64, status AtlasHeartbeatStatus) {
+	b := AppendAtlasHeartbeatAck(nil, key, ts, status)
+	if n, err := l.send(addr, b, "atlas_heartbeat_ack status="+status.String()); err == nil {
+		l.metrics.tx_count.atlas_heartbeat_ack.Add(1)
+		l.metrics.tx_bytes.atlas_heartbeat_ack.Add(uint64(n))
+	}
+}
+
 // SendConnect sends a `Hconnect` packet to addr for uid.
 func (l *Listener) SendConnect(addr netip.AddrPort, uid uint64) error {
 	var b []byte
@@ -367,14 +408,18 @@ func (l *Listener) WritePrometheus(w io.Writer) {
 	fmt.Fprintln(w, `atlas_nspkt_rx_count{type="invalid"}`, l.metrics.rx_count.invalid.Load())
 	fmt.Fprintln(w, `atlas_nspkt_rx_count{type="ignored"}`, l.metrics.rx_count.ignored.Load())
 	fmt.Fprintln(w, `atlas_nspkt_rx_count{type="r2_connect_resp"}`, l.metrics.rx_count.r2_connect_resp.Load())
+	fmt.Fprintln(w, `atlas_nspkt_rx_count{type="atlas_heartbeat"}`, l.metrics.rx_count.atlas_heartbeat.Load())
 	fmt.Fprintln(w, `atlas_nspkt_rx_count{type="other"}`, l.metrics.rx_count.other.Load())
 	fmt.Fprintln(w, `atlas_nspkt_rx_bytes{type="invalid"}`, l.metrics.rx_bytes.invalid.Load())
 	fmt.Fprintln(w, `atlas_nspkt_rx_bytes{type="ignored"}`, l.metrics.rx_bytes.ignored.Load())
 	fmt.Fprintln(w, `atlas_nspkt_rx_bytes{type="r2_connect_resp"}`, l.metrics.rx_bytes.r2_connect_resp.Load())
+	fmt.Fprintln(w, `atlas_nspkt_rx_bytes{type="atlas_heartbeat"}`, l.metrics.rx_bytes.atlas_heartbeat.Load())
 	fmt.Fprintln(w, `atlas_nspkt_rx_bytes{type="other"}`, l.metrics.rx_bytes.other.Load())
 	fmt.Fprintln(w, `atlas_nspkt_tx_count{type="atlas_sigreq1"}`, l.metrics.tx_count.atlas_sigreq1.Load())
+	fmt.Fprintln(w, `atlas_nspkt_tx_count{type="atlas_heartbeat_ack"}`, l.metrics.tx_count.atlas_heartbeat_ack.Load())
 	fmt.Fprintln(w, `atlas_nspkt_tx_count{type="r2_connect"}`, l.metrics.tx_count.r2_connect.Load())
 	fmt.Fprintln(w, `atlas_nspkt_tx_bytes{type="atlas_sigreq1"}`, l.metrics.tx_bytes.atlas_sigreq1.Load())
+	fmt.Fprintln(w, `atlas_nspkt_tx_bytes{type="atlas_heartbeat_ack"}`, l.metrics.tx_bytes.atlas_heartbeat_ack.Load())
 	fmt.Fprintln(w, `atlas_nspkt_tx_bytes{type="r2_connect"}`, l.metrics.tx_bytes.r2_connect.Load())
 	fmt.Fprintln(w, `atlas_nspkt_tx_err_count{cause="nonce"}`, l.metrics.tx_err_count.nonce.Load())
 	fmt.Fprintln(w, `atlas_nspkt_tx_err_count{cause="conn"}`, l.metrics.tx_err_count.conn.Load())
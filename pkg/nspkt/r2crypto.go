@@ -93,3 +93,29 @@ func (pkt r2cb) Encrypt() {
 	}
 	copy(pkt.tagNet(), pkt.tagGo())
 }
+
+// EncryptPacket encrypts data as a Titanfall 2 packet using the provided
+// nonce, which must be 12 bytes and should be random.
+func EncryptPacket(nonce, data []byte) []byte {
+	if len(nonce) != r2cryptoNonceSize {
+		panic("r2crypto: incorrect nonce length")
+	}
+	pkt := r2crypto(len(data))
+	copy(pkt.Nonce(), nonce)
+	copy(pkt.Data(), data)
+	pkt.Encrypt()
+	return pkt.Packet()
+}
+
+// DecryptPacket decrypts a Titanfall 2 packet, returning false if it is invalid.
+func DecryptPacket(buf []byte) ([]byte, bool) {
+	if len(buf) < r2cryptoNonceSize+r2cryptoTagSize {
+		return nil, false
+	}
+	pkt := r2crypto(len(buf) - r2cryptoNonceSize - r2cryptoTagSize)
+	copy(pkt.Packet(), buf)
+	if !pkt.Decrypt() {
+		return nil, false
+	}
+	return pkt.Data(), true
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/r2northstar/atlas/pkg/juno"
+	"github.com/r2northstar/atlas/pkg/trace"
 )
 
 type NucleusToken string
@@ -18,7 +19,13 @@ type NucleusToken string
 // that this token generally lasts ~4h.
 //
 // If errors.Is(err, ErrAuthRequired), you need a new SID.
-func GetNucleusToken(ctx context.Context, t http.RoundTripper, sid juno.SID) (NucleusToken, time.Time, error) {
+func GetNucleusToken(ctx context.Context, t http.RoundTripper, sid juno.SID) (tok NucleusToken, exp time.Time, err error) {
+	ctx, span := trace.StartClient(ctx, "origin.GetNucleusToken")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
 	if t == nil {
 		t = http.DefaultClient.Transport
 	}
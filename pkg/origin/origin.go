@@ -12,6 +12,8 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+
+	"github.com/r2northstar/atlas/pkg/trace"
 )
 
 var (
@@ -33,8 +35,14 @@ type UserInfo struct {
 // GetUserInfo gets information about Origin accounts by their Origin UserID.
 //
 // If errors.Is(err, ErrAuthRequired), you need a new NucleusToken.
-func GetUserInfo(ctx context.Context, token NucleusToken, uid ...uint64) ([]UserInfo, error) {
-	uids := make([]string, len(uid))
+func GetUserInfo(ctx context.Context, token NucleusToken, uid ...uint64) (ui []UserInfo, err error) {
+	ctx, span := trace.StartClient(ctx, "origin.GetUserInfo", trace.Int("origin.users", len(uid)))
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	uids := make([]string, 0, len(uid))
 	for _, x := range uid {
 		uids = append(uids, strconv.FormatUint(x, 10))
 	}
@@ -0,0 +1,445 @@
+// Package outbox implements a persistent queue for outbound messages (e.g.,
+// webhooks) with retries, dead-lettering, and deduplication.
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// State is the state of an Item.
+type State int
+
+const (
+	StatePending   State = iota // waiting to be sent
+	StateDelivered              // sent successfully
+	StateDead                   // failed permanently or ran out of attempts
+)
+
+func (s State) String() string {
+	switch s {
+	case StatePending:
+		return "pending"
+	case StateDelivered:
+		return "delivered"
+	case StateDead:
+		return "dead"
+	default:
+		return "State(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// Item is a message in a queue.
+type Item struct {
+	// ID uniquely identifies the item.
+	ID string
+
+	// Queue is the name of the queue the item belongs to.
+	Queue string
+
+	// Key, if not empty, is unique among the items retained in the queue.
+	Key string
+
+	// Payload is the message.
+	Payload []byte
+
+	// Created is when the item was added.
+	Created time.Time
+
+	// State is the state of the item.
+	State State
+
+	// Attempts is the number of failed delivery attempts.
+	Attempts int
+
+	// Next is when the item is next due for delivery if it is pending, or when
+	// it was delivered or dead-lettered otherwise.
+	Next time.Time
+
+	// Error is the last delivery error, if any.
+	Error string
+}
+
+// Backend stores items. It must be safe for concurrent use by multiple
+// instances.
+type Backend interface {
+	// PutOutbox adds it. If it has a key and there is already an item with the
+	// same key in the queue, it is not added and false is returned.
+	PutOutbox(it Item) (bool, error)
+
+	// ClaimOutbox atomically gets up to n pending items in queue due at or
+	// before now, oldest first, and sets their next attempt to until so other
+	// consumers will skip them while they are being sent.
+	ClaimOutbox(queue string, now, until time.Time, n int) ([]Item, error)
+
+	// UpdateOutbox updates the state, attempts, next attempt, and error of the
+	// item with the ID of it. If it doesn't exist, nothing is done.
+	UpdateOutbox(it Item) error
+
+	// PruneOutbox deletes delivered and dead items in queue which finished
+	// before t, returning the number of deleted items.
+	PruneOutbox(queue string, before time.Time) (int, error)
+}
+
+// Options configures delivery for a Queue.
+type Options struct {
+	// MaxAttempts is the number of delivery attempts before an item is
+	// dead-lettered. If zero, it defaults to 10.
+	MaxAttempts int
+
+	// Backoff is the delay after the first failed attempt, which doubles with
+	// each attempt up to MaxBackoff. If zero, it defaults to 5 seconds.
+	Backoff time.Duration
+
+	// MaxBackoff is the maximum delay between attempts. If zero, it defaults
+	// to an hour.
+	MaxBackoff time.Duration
+
+	// Timeout is the maximum time for a single attempt, after which the item
+	// may be claimed again. If zero, it defaults to 30 seconds.
+	Timeout time.Duration
+
+	// PollInterval is how often the backend is checked for due items. If zero,
+	// it defaults to 5 seconds. Items enqueued by this instance are sent
+	// immediately.
+	PollInterval time.Duration
+
+	// Retention is how long delivered and dead items are kept, which is also
+	// how long keys are deduplicated for. If zero, it defaults to a day.
+	Retention time.Duration
+}
+
+func (o Options) maxAttempts() int {
+	if o.MaxAttempts > 0 {
+		return o.MaxAttempts
+	}
+	return 10
+}
+
+func (o Options) backoff(attempts int) time.Duration {
+	d, m := o.Backoff, o.MaxBackoff
+	if d <= 0 {
+		d = time.Second * 5
+	}
+	if m <= 0 {
+		m = time.Hour
+	}
+	for i := 1; i < attempts && d < m; i++ {
+		d *= 2
+	}
+	if d > m {
+		d = m
+	}
+	return d
+}
+
+func (o Options) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return time.Second * 30
+}
+
+func (o Options) pollInterval() time.Duration {
+	if o.PollInterval > 0 {
+		return o.PollInterval
+	}
+	return time.Second * 5
+}
+
+func (o Options) retention() time.Duration {
+	if o.Retention > 0 {
+		return o.Retention
+	}
+	return time.Hour * 24
+}
+
+// permanentError is an error which should not be retried.
+type permanentError struct {
+	err error
+}
+
+func (err permanentError) Error() string { return err.err.Error() }
+func (err permanentError) Unwrap() error { return err.err }
+
+// Permanent wraps err so the item is dead-lettered without retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// IsPermanent checks if err was wrapped with Permanent.
+func IsPermanent(err error) bool {
+	return errors.As(err, new(permanentError))
+}
+
+// Queue sends items from a Backend. The Backend, Name, and Send must be set.
+// It is safe for concurrent use.
+//
+// Items are delivered at least once. If multiple instances share the backend,
+// only one will attempt to send an item at a time, but an item may be sent
+// again if the attempt takes longer than the timeout.
+type Queue struct {
+	// Backend stores the items.
+	Backend Backend
+
+	// Name is the name of the queue.
+	Name string
+
+	// Send delivers a payload. If it returns an error wrapped with Permanent,
+	// the item is dead-lettered immediately.
+	Send func(ctx context.Context, payload []byte) error
+
+	// Options configures delivery.
+	Options Options
+
+	// Logger is used to log dead-lettered items and backend errors.
+	Logger zerolog.Logger
+
+	init sync.Once
+	wake chan struct{}
+
+	metrics struct {
+		items_total struct {
+			enqueued  atomic.Uint64
+			duplicate atomic.Uint64
+			delivered atomic.Uint64
+			retried   atomic.Uint64
+			dead      atomic.Uint64
+		}
+		errors_total atomic.Uint64
+	}
+}
+
+func (q *Queue) initQueue() {
+	q.init.Do(func() {
+		q.wake = make(chan struct{}, 1)
+	})
+}
+
+// Enqueue persists a payload to be sent. If key is not empty and an item with
+// the same key has been enqueued within the retention period, it is ignored.
+func (q *Queue) Enqueue(key string, payload []byte) error {
+	q.initQueue()
+
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	now := time.Now()
+	ok, err := q.Backend.PutOutbox(Item{
+		ID:      hex.EncodeToString(b[:]),
+		Queue:   q.Name,
+		Key:     key,
+		Payload: payload,
+		Created: now,
+		State:   StatePending,
+		Next:    now,
+	})
+	if err != nil {
+		q.metrics.errors_total.Add(1)
+		return err
+	}
+	if !ok {
+		q.metrics.items_total.duplicate.Add(1)
+		return nil
+	}
+	q.metrics.items_total.enqueued.Add(1)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run sends due items until ctx is canceled. Items which haven't been sent
+// yet remain in the backend.
+func (q *Queue) Run(ctx context.Context) error {
+	if q.Backend == nil || q.Name == "" || q.Send == nil {
+		return fmt.Errorf("outbox: backend, name, and send are required")
+	}
+	q.initQueue()
+
+	tk := time.NewTicker(q.Options.pollInterval())
+	defer tk.Stop()
+
+	var pruned time.Time
+	for {
+		if t := time.Now(); t.Sub(pruned) > q.Options.retention()/24 {
+			if _, err := q.Backend.PruneOutbox(q.Name, t.Add(-q.Options.retention())); err != nil {
+				q.metrics.errors_total.Add(1)
+				q.Logger.Warn().Err(err).Str("queue", q.Name).Msg("failed to prune outbox")
+			}
+			pruned = t
+		}
+		q.process(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tk.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// process sends due items until there are none left or ctx is canceled.
+func (q *Queue) process(ctx context.Context) {
+	const batch = 32
+	for ctx.Err() == nil {
+		now := time.Now()
+		its, err := q.Backend.ClaimOutbox(q.Name, now, now.Add(q.Options.timeout()*2), batch)
+		if err != nil {
+			q.metrics.errors_total.Add(1)
+			q.Logger.Warn().Err(err).Str("queue", q.Name).Msg("failed to claim outbox items")
+			return
+		}
+		for _, it := range its {
+			if ctx.Err() != nil {
+				return // it'll be claimed again after the timeout
+			}
+			q.send(ctx, it)
+		}
+		if len(its) < batch {
+			return
+		}
+	}
+}
+
+func (q *Queue) send(ctx context.Context, it Item) {
+	sctx, cancel := context.WithTimeout(ctx, q.Options.timeout())
+	err := q.Send(sctx, it.Payload)
+	cancel()
+
+	now := time.Now()
+	if err == nil {
+		it.State = StateDelivered
+		it.Next = now
+		it.Error = ""
+		q.metrics.items_total.delivered.Add(1)
+	} else {
+		it.Attempts++
+		it.Error = err.Error()
+		if IsPermanent(err) || it.Attempts >= q.Options.maxAttempts() {
+			it.State = StateDead
+			it.Next = now
+			q.metrics.items_total.dead.Add(1)
+			q.Logger.Warn().
+				Err(err).
+				Str("queue", q.Name).
+				Str("id", it.ID).
+				Str("key", it.Key).
+				Int("attempts", it.Attempts).
+				Msg("outbox item dead-lettered")
+		} else {
+			it.Next = now.Add(q.Options.backoff(it.Attempts))
+			q.metrics.items_total.retried.Add(1)
+		}
+	}
+	if err := q.Backend.UpdateOutbox(it); err != nil {
+		q.metrics.errors_total.Add(1)
+		q.Logger.Warn().Err(err).Str("queue", q.Name).Str("id", it.ID).Msg("failed to update outbox item")
+	}
+}
+
+// WritePrometheus writes prometheus text metrics to w.
+func (q *Queue) WritePrometheus(w io.Writer) {
+	l := `queue=` + strconv.Quote(q.Name)
+	fmt.Fprintln(w, `atlas_outbox_items_total{`+l+`,result="enqueued"}`, q.metrics.items_total.enqueued.Load())
+	fmt.Fprintln(w, `atlas_outbox_items_total{`+l+`,result="duplicate"}`, q.metrics.items_total.duplicate.Load())
+	fmt.Fprintln(w, `atlas_outbox_items_total{`+l+`,result="delivered"}`, q.metrics.items_total.delivered.Load())
+	fmt.Fprintln(w, `atlas_outbox_items_total{`+l+`,result="retried"}`, q.metrics.items_total.retried.Load())
+	fmt.Fprintln(w, `atlas_outbox_items_total{`+l+`,result="dead"}`, q.metrics.items_total.dead.Load())
+	fmt.Fprintln(w, `atlas_outbox_errors_total{`+l+`}`, q.metrics.errors_total.Load())
+}
+
+// Memory is an in-process Backend, for testing.
+type Memory struct {
+	mu    sync.Mutex
+	items []Item
+}
+
+var _ Backend = (*Memory)(nil)
+
+func (m *Memory) PutOutbox(it Item) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, x := range m.items {
+		if x.ID == it.ID {
+			return false, fmt.Errorf("duplicate id %q", it.ID)
+		}
+		if it.Key != "" && x.Queue == it.Queue && x.Key == it.Key {
+			return false, nil
+		}
+	}
+	it.Payload = append([]byte(nil), it.Payload...)
+	m.items = append(m.items, it)
+	return true, nil
+}
+
+func (m *Memory) ClaimOutbox(queue string, now, until time.Time, n int) ([]Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var its []Item
+	for i := range m.items { // items are in insertion order
+		x := &m.items[i]
+		if len(its) >= n {
+			break
+		}
+		if x.Queue == queue && x.State == StatePending && !x.Next.After(now) {
+			x.Next = until
+			it := *x
+			it.Payload = append([]byte(nil), x.Payload...)
+			its = append(its, it)
+		}
+	}
+	return its, nil
+}
+
+func (m *Memory) UpdateOutbox(it Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.items {
+		if x := &m.items[i]; x.ID == it.ID {
+			x.State = it.State
+			x.Attempts = it.Attempts
+			x.Next = it.Next
+			x.Error = it.Error
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Memory) PruneOutbox(queue string, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int
+	items := m.items[:0]
+	for _, x := range m.items {
+		if x.Queue == queue && x.State != StatePending && x.Next.Before(before) {
+			n++
+			continue
+		}
+		items = append(items, x)
+	}
+	m.items = items
+	return n, nil
+}
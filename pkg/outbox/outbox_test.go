@@ -0,0 +1,125 @@
+package outbox_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/outbox"
+	"github.com/r2northstar/atlas/pkg/outbox/outboxtest"
+)
+
+func TestMemory(t *testing.T) {
+	outboxtest.TestBackend(t, new(outbox.Memory))
+}
+
+func TestQueue(t *testing.T) {
+	var b outbox.Memory
+
+	var (
+		mu   sync.Mutex
+		sent []string
+		fail = map[string]int{"flaky": 2}
+	)
+	q := &outbox.Queue{
+		Backend: &b,
+		Name:    "test",
+		Send: func(ctx context.Context, payload []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			p := string(payload)
+			switch {
+			case p == "bad":
+				return outbox.Permanent(errors.New("bad payload"))
+			case p == "down":
+				return errors.New("receiver down")
+			case fail[p] > 0:
+				fail[p]--
+				return errors.New("flaky receiver")
+			}
+			sent = append(sent, p)
+			return nil
+		},
+		Options: outbox.Options{
+			MaxAttempts:  3,
+			Backoff:      time.Millisecond * 10,
+			PollInterval: time.Millisecond * 10,
+		},
+	}
+
+	// enqueued before running (i.e., persisted from a previous run)
+	for _, p := range []string{"a", "flaky", "bad", "down"} {
+		if err := q.Enqueue(p, []byte(p)); err != nil {
+			t.Fatalf("enqueue %q: %v", p, err)
+		}
+	}
+	if err := q.Enqueue("a", []byte("a")); err != nil {
+		t.Fatalf("enqueue duplicate: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := q.Run(ctx); err != nil {
+			t.Errorf("run: %v", err)
+		}
+	}()
+
+	if err := q.Enqueue("", []byte("b")); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	waitFor(t, "items to be delivered", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent) == 3
+	})
+	mu.Lock()
+	if s := strings.Join(sent, ","); s != "a,b,flaky" && s != "a,flaky,b" {
+		t.Errorf("incorrect deliveries %q", s)
+	}
+	mu.Unlock()
+
+	var buf bytes.Buffer
+	waitFor(t, "items to be dead-lettered", func() bool {
+		buf.Reset()
+		q.WritePrometheus(&buf)
+		return strings.Contains(buf.String(), `atlas_outbox_items_total{queue="test",result="dead"} 2`)
+	})
+	for _, m := range []string{
+		`atlas_outbox_items_total{queue="test",result="enqueued"} 5`,
+		`atlas_outbox_items_total{queue="test",result="duplicate"} 1`,
+		`atlas_outbox_items_total{queue="test",result="delivered"} 3`,
+		`atlas_outbox_items_total{queue="test",result="retried"} 4`,
+	} {
+		if !strings.Contains(buf.String(), m) {
+			t.Errorf("missing metric %q in:\n%s", m, buf.String())
+		}
+	}
+
+	if its, err := b.ClaimOutbox("test", time.Now().Add(time.Hour), time.Now().Add(time.Hour), 10); err != nil || len(its) != 0 {
+		t.Errorf("expected no pending items, got %+v %v", its, err)
+	}
+
+	cancel()
+	<-done
+}
+
+func waitFor(t *testing.T, what string, fn func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
@@ -0,0 +1,118 @@
+// Package outboxtest contains conformance tests for outbox backends.
+package outboxtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/outbox"
+)
+
+// TestBackend tests whether an EMPTY outbox backend implements the interface
+// correctly.
+func TestBackend(t *testing.T, b outbox.Backend) {
+	now := time.Now().Truncate(time.Millisecond)
+
+	put := func(t *testing.T, it outbox.Item, exp bool) {
+		t.Helper()
+		if ok, err := b.PutOutbox(it); err != nil {
+			t.Fatalf("put %q: unexpected error: %v", it.ID, err)
+		} else if ok != exp {
+			t.Fatalf("put %q: expected added=%t, got %t", it.ID, exp, ok)
+		}
+	}
+	claim := func(t *testing.T, queue string, at time.Time, n int, exp ...string) []outbox.Item {
+		t.Helper()
+		its, err := b.ClaimOutbox(queue, at, at.Add(time.Minute), n)
+		if err != nil {
+			t.Fatalf("claim %q: unexpected error: %v", queue, err)
+		}
+		var ids []string
+		for _, it := range its {
+			ids = append(ids, it.ID)
+		}
+		if len(ids) != len(exp) {
+			t.Fatalf("claim %q: expected %q, got %q", queue, exp, ids)
+		}
+		for i := range ids {
+			if ids[i] != exp[i] {
+				t.Fatalf("claim %q: expected %q, got %q", queue, exp, ids)
+			}
+		}
+		return its
+	}
+	item := func(id, queue, key string, created time.Time) outbox.Item {
+		return outbox.Item{
+			ID:      id,
+			Queue:   queue,
+			Key:     key,
+			Payload: []byte("payload " + id),
+			Created: created,
+			State:   outbox.StatePending,
+			Next:    created,
+		}
+	}
+
+	t.Run("Put", func(t *testing.T) {
+		put(t, item("a1", "a", "k1", now.Add(-time.Second*3)), true)
+		put(t, item("a2", "a", "k1", now.Add(-time.Second*2)), false) // duplicate key
+		put(t, item("a3", "a", "", now.Add(-time.Second*2)), true)
+		put(t, item("a4", "a", "", now.Add(-time.Second)), true)   // empty keys aren't deduplicated
+		put(t, item("b1", "b", "k1", now.Add(-time.Second)), true) // keys are per-queue
+	})
+	t.Run("Claim", func(t *testing.T) {
+		its := claim(t, "a", now, 2, "a1", "a3")
+		if it := its[0]; it.Key != "k1" || string(it.Payload) != "payload a1" || !it.Created.Equal(now.Add(-time.Second*3)) || it.State != outbox.StatePending {
+			t.Errorf("incorrect item %+v", it)
+		}
+		claim(t, "a", now, 2, "a4")               // others were claimed
+		claim(t, "a", now.Add(time.Second*30), 2) // still claimed
+		claim(t, "a", now.Add(time.Minute), 1, "a1")
+		claim(t, "c", now, 10)
+	})
+	t.Run("Update", func(t *testing.T) {
+		it := item("a1", "a", "k1", now)
+		it.Attempts = 2
+		it.Error = "failed"
+		it.Next = now.Add(time.Hour)
+		if err := b.UpdateOutbox(it); err != nil {
+			t.Fatalf("update: unexpected error: %v", err)
+		}
+		its := claim(t, "a", now.Add(time.Hour), 10, "a1", "a3", "a4")
+		if it := its[0]; it.Attempts != 2 || it.Error != "failed" || string(it.Payload) != "payload a1" {
+			t.Errorf("incorrect updated item %+v", it)
+		}
+
+		it.State = outbox.StateDelivered
+		it.Next = now
+		if err := b.UpdateOutbox(it); err != nil {
+			t.Fatalf("update: unexpected error: %v", err)
+		}
+		it = item("a3", "a", "", now)
+		it.State = outbox.StateDead
+		it.Next = now.Add(time.Second)
+		if err := b.UpdateOutbox(it); err != nil {
+			t.Fatalf("update: unexpected error: %v", err)
+		}
+		if err := b.UpdateOutbox(item("x", "a", "", now)); err != nil {
+			t.Fatalf("update nonexistent: unexpected error: %v", err)
+		}
+		claim(t, "a", now.Add(time.Hour*2), 10, "a4")
+		put(t, item("a5", "a", "k1", now), false) // still deduplicated after delivery
+	})
+	t.Run("Prune", func(t *testing.T) {
+		if n, err := b.PruneOutbox("a", now.Add(time.Millisecond*500)); err != nil {
+			t.Fatalf("prune: unexpected error: %v", err)
+		} else if n != 1 {
+			t.Errorf("prune: expected 1 item to be deleted, got %d", n)
+		}
+		if n, err := b.PruneOutbox("a", now.Add(time.Hour*3)); err != nil {
+			t.Fatalf("prune: unexpected error: %v", err)
+		} else if n != 1 {
+			t.Errorf("prune: expected 1 item to be deleted, got %d", n)
+		}
+		put(t, item("a6", "a", "k1", now), true) // no longer deduplicated
+		claim(t, "a", now.Add(time.Hour*3), 10, "a4", "a6")
+		claim(t, "b", now, 10, "b1")
+	})
+}
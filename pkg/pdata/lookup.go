@@ -0,0 +1,68 @@
+package pdata
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Numbers gets the numeric values of the field at path, which is a
+// dot-separated list of pdef field names and array indexes (e.g.,
+// "gameStats.modesWon.0"). An index of * matches every element of the array. The
+// field must be an int or float.
+func (v *Pdata) Numbers(path string) ([]float64, error) {
+	var xs []float64
+	if err := pdataNumbers(reflect.ValueOf(v).Elem(), strings.Split(path, "."), nil, &xs); err != nil {
+		return nil, fmt.Errorf("lookup %q (v%d): %w", "pdata", Version, err)
+	}
+	return xs, nil
+}
+
+func pdataNumbers(val reflect.Value, rest, path []string, xs *[]float64) error {
+	switch val.Kind() {
+	case reflect.Int32:
+		if len(rest) != 0 {
+			break
+		}
+		*xs = append(*xs, float64(val.Int()))
+		return nil
+	case reflect.Float32:
+		if len(rest) != 0 {
+			break
+		}
+		*xs = append(*xs, val.Float())
+		return nil
+	case reflect.Struct:
+		if len(rest) == 0 {
+			return fmt.Errorf("field %q: not a number", strings.Join(path, "."))
+		}
+		for i := 0; i < val.NumField(); i++ {
+			if n, _, _ := strings.Cut(val.Type().Field(i).Tag.Get("pdef"), ","); n != "" && n == rest[0] {
+				return pdataNumbers(val.Field(i), rest[1:], append(path, rest[0]), xs)
+			}
+		}
+		return fmt.Errorf("field %q: unknown field", strings.Join(append(path, rest[0]), "."))
+	case reflect.Array:
+		if len(rest) == 0 {
+			return fmt.Errorf("field %q: not a number", strings.Join(path, "."))
+		}
+		if rest[0] == "*" {
+			for i := 0; i < val.Len(); i++ {
+				if err := pdataNumbers(val.Index(i), rest[1:], append(path, strconv.Itoa(i)), xs); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		i, err := strconv.Atoi(rest[0])
+		if err != nil || i < 0 || i >= val.Len() {
+			return fmt.Errorf("field %q: index out of range", strings.Join(append(path, rest[0]), "."))
+		}
+		return pdataNumbers(val.Index(i), rest[1:], append(path, rest[0]), xs)
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("field %q: unknown field", strings.Join(append(path, rest[0]), "."))
+	}
+	return fmt.Errorf("field %q: not a number", strings.Join(path, "."))
+}
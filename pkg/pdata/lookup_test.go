@@ -0,0 +1,49 @@
+package pdata
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPdataNumbers(t *testing.T) {
+	var pd Pdata
+	if err := pd.UnmarshalBinary(DefaultPdata); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	pd.Gen = 3
+	pd.Xp_match[1] = 5
+	pd.GameStats.ModesWon[2] = 7
+
+	for path, exp := range map[string][]float64{
+		"gen":                  {3},
+		"xp_match.1":           {5},
+		"gameStats.modesWon.2": {7},
+	} {
+		if xs, err := pd.Numbers(path); err != nil {
+			t.Errorf("numbers %q: %v", path, err)
+		} else if !reflect.DeepEqual(xs, exp) {
+			t.Errorf("numbers %q: expected %v, got %v", path, exp, xs)
+		}
+	}
+	if xs, err := pd.Numbers("xp_match.*"); err != nil {
+		t.Errorf("numbers %q: %v", "xp_match.*", err)
+	} else if len(xs) != len(pd.Xp_match) || xs[1] != 5 {
+		t.Errorf("numbers %q: got %v", "xp_match.*", xs)
+	}
+
+	for _, path := range []string{
+		"",
+		"nope",
+		"gen.1",
+		"gameStats",
+		"xp_match",
+		"xp_match.20",
+		"xp_match.-1",
+		"factionChoice",
+		"pilotLoadouts.*.name",
+	} {
+		if _, err := pd.Numbers(path); err == nil {
+			t.Errorf("numbers %q: expected error", path)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package pdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Patch applies a partial update to v. The patch is a JSON object with the
+// same structure as the JSON encoding of v, but only containing the fields to
+// change. Arrays may be replaced entirely with a JSON array, or partially with
+// an object keyed by index. Unknown fields, out-of-range indexes, and values of
+// the wrong type are rejected. If an error is returned, v is not modified.
+//
+// Note that string lengths are only checked when v is marshaled.
+func (v *Pdata) Patch(patch []byte) error {
+	if x := v.InitializedVersion; x != Version {
+		return fmt.Errorf("patch %q (v%d): %w: got %d", "pdata", Version, ErrUnsupportedVersion, x)
+	}
+	nv := *v
+	if err := pdataPatchValue(reflect.ValueOf(&nv).Elem(), patch, nil); err != nil {
+		return fmt.Errorf("patch %q (v%d): %w", "pdata", Version, err)
+	}
+	if x := nv.InitializedVersion; x != Version {
+		return fmt.Errorf("patch %q (v%d): field %q: cannot be changed", "pdata", Version, "initializedVersion")
+	}
+	*v = nv
+	return nil
+}
+
+func pdataPatchValue(val reflect.Value, patch []byte, path []string) error {
+	patch = bytes.TrimSpace(patch)
+	if bytes.Equal(patch, []byte("null")) {
+		return fmt.Errorf("field %q: value must not be null", strings.Join(path, "."))
+	}
+	if u, ok := val.Addr().Interface().(json.Unmarshaler); ok && val.Kind() == reflect.Uint8 {
+		if err := u.UnmarshalJSON(patch); err != nil {
+			return fmt.Errorf("field %q: %w", strings.Join(path, "."), err)
+		}
+		return nil
+	}
+	switch val.Kind() {
+	case reflect.Struct:
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(patch, &m); err != nil {
+			return fmt.Errorf("field %q: expected object: %w", strings.Join(path, "."), err)
+		}
+		fields := make(map[string]int, val.NumField())
+		for i := 0; i < val.NumField(); i++ {
+			if n, _, _ := strings.Cut(val.Type().Field(i).Tag.Get("pdef"), ","); n != "" {
+				fields[n] = i
+			}
+		}
+		for _, k := range pdataPatchKeys(m) {
+			i, ok := fields[k]
+			if !ok {
+				return fmt.Errorf("field %q: unknown field", strings.Join(append(path, k), "."))
+			}
+			if err := pdataPatchValue(val.Field(i), m[k], append(path, k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Array:
+		if len(patch) != 0 && patch[0] == '[' {
+			var a []json.RawMessage
+			if err := json.Unmarshal(patch, &a); err != nil {
+				return fmt.Errorf("field %q: expected array: %w", strings.Join(path, "."), err)
+			}
+			if len(a) != val.Len() {
+				return fmt.Errorf("field %q: expected %d elements, got %d", strings.Join(path, "."), val.Len(), len(a))
+			}
+			for i, x := range a {
+				if err := pdataPatchValue(val.Index(i), x, append(path, strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(patch, &m); err != nil {
+			return fmt.Errorf("field %q: expected array or object: %w", strings.Join(path, "."), err)
+		}
+		for _, k := range pdataPatchKeys(m) {
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= val.Len() {
+				return fmt.Errorf("field %q: index out of range", strings.Join(append(path, k), "."))
+			}
+			if err := pdataPatchValue(val.Index(i), m[k], append(path, k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Int32, reflect.Float32, reflect.Bool, reflect.String:
+		x := reflect.New(val.Type())
+		if err := json.Unmarshal(patch, x.Interface()); err != nil {
+			return fmt.Errorf("field %q: expected %s: %w", strings.Join(path, "."), val.Kind(), err)
+		}
+		val.Set(x.Elem())
+		return nil
+	default:
+		panic(fmt.Errorf("unhandled type %s", val.Type()))
+	}
+}
+
+func pdataPatchKeys(m map[string]json.RawMessage) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
@@ -0,0 +1,44 @@
+package pdata
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPdataPatch(t *testing.T) {
+	var pd Pdata
+	if err := pd.UnmarshalBinary(DefaultPdata); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if err := pd.Patch([]byte(`{"xp":1234,"factionChoice":"faction_vinson","xp_match":{"3":7},"pilotLoadouts":{"1":{"name":"test"}}}`)); err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+	if pd.Xp != 1234 || pd.FactionChoice != Faction_faction_vinson || pd.Xp_match[3] != 7 || pd.PilotLoadouts[1].Name != "test" {
+		t.Errorf("patch not applied")
+	}
+	if _, err := pd.MarshalBinary(); err != nil {
+		t.Errorf("marshal patched pdata: %v", err)
+	}
+
+	for _, patch := range []string{
+		`null`,
+		`[]`,
+		`{"nope":1}`,
+		`{"xp":"1"}`,
+		`{"xp":1.5}`,
+		`{"xp":null}`,
+		`{"xp_match":{"20":1}}`,
+		`{"xp_match":[1,2]}`,
+		`{"factionChoice":"nope"}`,
+		`{"initializedVersion":1}`,
+		`{"credits":5,"pilotLoadouts":{"0":{"nope":1}}}`,
+	} {
+		before := pd
+		if err := pd.Patch([]byte(patch)); err == nil {
+			t.Errorf("patch %s: expected error", patch)
+		} else if !reflect.DeepEqual(pd, before) {
+			t.Errorf("patch %s: modified pdata on error", patch)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+// Package pdatashard distributes pdata across multiple storage backends using
+// consistent hashing.
+package pdatashard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+)
+
+// vnodes is the number of points on the ring for each shard. More points
+// spread the players more evenly.
+const vnodes = 256
+
+// Shard is a named pdata storage backend. The name determines which players
+// are stored on the shard, so it must not be changed after data is written.
+type Shard struct {
+	Name    string
+	Storage api0.PdataStorage
+}
+
+// Storage stores pdata across multiple shards. Each player's pdata is stored
+// on the shard which owns the first point on the ring after the hash of their
+// UID, so adding or removing a shard only moves the players between it and
+// its neighbours on the ring.
+//
+// While shards are being added or removed, pdata may still be on the previous
+// shard. If lookaside is enabled, reads for players without pdata on their
+// shard check the other shards, and move the pdata to the correct shard if
+// found. Rebalance moves all misplaced pdata.
+type Storage struct {
+	shards    []Shard
+	ring      []point
+	lookaside bool
+	mu        [64]sync.Mutex
+}
+
+type point struct {
+	hash  uint64
+	shard int
+}
+
+var _ interface {
+	api0.PdataStorage
+	api0.PdataListStorage
+	api0.CompactStorage
+} = (*Storage)(nil)
+
+// New creates a new Storage from shards, which must have unique non-empty
+// names.
+func New(lookaside bool, shards ...Shard) (*Storage, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("no shards")
+	}
+	s := &Storage{
+		shards:    shards,
+		lookaside: lookaside,
+	}
+	seen := map[string]bool{}
+	for i, sh := range shards {
+		if sh.Name == "" {
+			return nil, fmt.Errorf("shard %d: name is required", i)
+		}
+		if seen[sh.Name] {
+			return nil, fmt.Errorf("shard %d: duplicate name %q", i, sh.Name)
+		}
+		seen[sh.Name] = true
+		for v := 0; v < vnodes; v++ {
+			h := sha256.Sum256([]byte(sh.Name + "#" + strconv.Itoa(v)))
+			s.ring = append(s.ring, point{binary.BigEndian.Uint64(h[:]), i})
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool {
+		if s.ring[i].hash == s.ring[j].hash {
+			return s.shards[s.ring[i].shard].Name < s.shards[s.ring[j].shard].Name
+		}
+		return s.ring[i].hash < s.ring[j].hash
+	})
+	return s, nil
+}
+
+// Shards gets the shards in s.
+func (s *Storage) Shards() []Shard {
+	return s.shards
+}
+
+// Owner gets the index of the shard which should store pdata for uid.
+func (s *Storage) Owner(uid uint64) int {
+	h := hashUID(uid)
+	i := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i].hash >= h
+	})
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].shard
+}
+
+// hashUID spreads sequential UIDs evenly over the ring (splitmix64).
+func hashUID(uid uint64) uint64 {
+	x := uid + 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+func (s *Storage) lock(uid uint64) func() {
+	m := &s.mu[uid%uint64(len(s.mu))]
+	m.Lock()
+	return m.Unlock
+}
+
+func (s *Storage) GetPdataHash(uid uint64) ([sha256.Size]byte, bool, error) {
+	o := s.Owner(uid)
+	hash, exists, err := s.shards[o].Storage.GetPdataHash(uid)
+	if err != nil || exists || !s.lookaside {
+		return hash, exists, err
+	}
+	if _, err := s.moveUID(uid); err != nil {
+		return hash, false, err
+	}
+	return s.shards[o].Storage.GetPdataHash(uid)
+}
+
+func (s *Storage) GetPdataCached(uid uint64, sha [sha256.Size]byte) ([]byte, bool, error) {
+	o := s.Owner(uid)
+	buf, exists, err := s.shards[o].Storage.GetPdataCached(uid, sha)
+	if err != nil || exists || !s.lookaside {
+		return buf, exists, err
+	}
+	if _, err := s.moveUID(uid); err != nil {
+		return nil, false, err
+	}
+	return s.shards[o].Storage.GetPdataCached(uid, sha)
+}
+
+func (s *Storage) SetPdata(uid uint64, buf []byte) (int, error) {
+	defer s.lock(uid)()
+	return s.shards[s.Owner(uid)].Storage.SetPdata(uid, buf)
+}
+
+// DeletePdata deletes pdata for uid from all shards, so misplaced copies
+// aren't moved back later.
+func (s *Storage) DeletePdata(uid uint64) error {
+	defer s.lock(uid)()
+	for _, sh := range s.shards {
+		if err := sh.Storage.DeletePdata(uid); err != nil {
+			return fmt.Errorf("shard %q: %w", sh.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetPdataUIDs gets the UIDs with pdata on any shard. All shards must
+// implement PdataListStorage.
+func (s *Storage) GetPdataUIDs() ([]uint64, error) {
+	seen := map[uint64]struct{}{}
+	for _, sh := range s.shards {
+		uids, err := listUIDs(sh)
+		if err != nil {
+			return nil, err
+		}
+		for _, uid := range uids {
+			seen[uid] = struct{}{}
+		}
+	}
+	uids := make([]uint64, 0, len(seen))
+	for uid := range seen {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		return uids[i] < uids[j]
+	})
+	return uids, nil
+}
+
+func listUIDs(sh Shard) ([]uint64, error) {
+	ls, ok := sh.Storage.(api0.PdataListStorage)
+	if !ok {
+		return nil, fmt.Errorf("shard %q: storage does not support listing pdata", sh.Name)
+	}
+	uids, err := ls.GetPdataUIDs()
+	if err != nil {
+		return nil, fmt.Errorf("shard %q: %w", sh.Name, err)
+	}
+	return uids, nil
+}
+
+// moveUID moves pdata for uid from the first other shard which has it to its
+// owner if the owner doesn't have any, then deletes it from the other shards.
+// It returns true if pdata was moved.
+func (s *Storage) moveUID(uid uint64) (bool, error) {
+	defer s.lock(uid)()
+
+	o := s.Owner(uid)
+	_, exists, err := s.shards[o].Storage.GetPdataHash(uid)
+	if err != nil {
+		return false, fmt.Errorf("shard %q: %w", s.shards[o].Name, err)
+	}
+	var moved bool
+	for i, sh := range s.shards {
+		if i == o {
+			continue
+		}
+		if !exists {
+			buf, ok, err := sh.Storage.GetPdataCached(uid, [sha256.Size]byte{})
+			if err != nil {
+				return moved, fmt.Errorf("shard %q: %w", sh.Name, err)
+			}
+			if !ok {
+				continue
+			}
+			if _, err := s.shards[o].Storage.SetPdata(uid, buf); err != nil {
+				return moved, fmt.Errorf("shard %q: %w", s.shards[o].Name, err)
+			}
+			exists, moved = true, true
+		} else if _, ok, err := sh.Storage.GetPdataHash(uid); err != nil {
+			return moved, fmt.Errorf("shard %q: %w", sh.Name, err)
+		} else if !ok {
+			continue
+		}
+		if err := sh.Storage.DeletePdata(uid); err != nil {
+			return moved, fmt.Errorf("shard %q: %w", sh.Name, err)
+		}
+	}
+	return moved, nil
+}
+
+// RebalanceStats contains the results of Rebalance.
+type RebalanceStats struct {
+	// Checked is the number of stored pdata records checked.
+	Checked int
+
+	// Moved is the number of records copied to their owner.
+	Moved int
+
+	// Removed is the number of misplaced records deleted because the owner
+	// already had newer pdata.
+	Removed int
+
+	// Shards is the number of players on each shard after rebalancing.
+	Shards map[string]int
+}
+
+func (r RebalanceStats) String() string {
+	return fmt.Sprintf("%d checked, %d moved, %d stale copies removed", r.Checked, r.Moved, r.Removed)
+}
+
+// Rebalance moves all misplaced pdata to the correct shard. If dryRun is true,
+// it only counts the pdata which would be moved. All shards must implement
+// PdataListStorage. If progress is non-nil, it is called after every record.
+//
+// It is safe to run concurrently with other operations on s, but other
+// processes must not write to the shards at the same time.
+func (s *Storage) Rebalance(ctx context.Context, dryRun bool, progress func(RebalanceStats)) (RebalanceStats, error) {
+	st := RebalanceStats{Shards: map[string]int{}}
+	if progress == nil {
+		progress = func(RebalanceStats) {}
+	}
+	// list them first so moved records aren't checked again
+	lists := make([][]uint64, len(s.shards))
+	for i, sh := range s.shards {
+		var err error
+		if lists[i], err = listUIDs(sh); err != nil {
+			return st, err
+		}
+	}
+	all, misplaced := map[uint64]struct{}{}, map[uint64]struct{}{}
+	for i, uids := range lists {
+		for _, uid := range uids {
+			if err := ctx.Err(); err != nil {
+				return st, err
+			}
+			st.Checked++
+			all[uid] = struct{}{}
+			if s.Owner(uid) == i {
+				continue
+			}
+			if _, ok := misplaced[uid]; ok {
+				continue // already moved from another shard
+			}
+			misplaced[uid] = struct{}{}
+			if dryRun {
+				st.Moved++ // approximately, since the owner may already have it
+			} else if moved, err := s.moveUID(uid); err != nil {
+				return st, err
+			} else if moved {
+				st.Moved++
+			} else {
+				st.Removed++
+			}
+			progress(st)
+		}
+	}
+	for _, sh := range s.shards {
+		st.Shards[sh.Name] = 0
+	}
+	for uid := range all {
+		st.Shards[s.shards[s.Owner(uid)].Name]++
+	}
+	return st, nil
+}
+
+// Compact compacts the shards which implement CompactStorage.
+func (s *Storage) Compact(ctx context.Context) (int64, error) {
+	var n int64
+	for _, sh := range s.shards {
+		if c, ok := sh.Storage.(api0.CompactStorage); ok {
+			m, err := c.Compact(ctx)
+			n += m
+			if err != nil {
+				return n, fmt.Errorf("shard %q: %w", sh.Name, err)
+			}
+		}
+	}
+	return n, nil
+}
+
+// RotateKeys re-encrypts pdata on the shards which support it.
+func (s *Storage) RotateKeys(ctx context.Context) (int, error) {
+	var n int
+	for _, sh := range s.shards {
+		if r, ok := sh.Storage.(interface {
+			RotateKeys(context.Context) (int, error)
+		}); ok {
+			m, err := r.RotateKeys(ctx)
+			n += m
+			if err != nil {
+				return n, fmt.Errorf("shard %q: %w", sh.Name, err)
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close closes the shards which implement io.Closer.
+func (s *Storage) Close() error {
+	var err error
+	for _, sh := range s.shards {
+		if c, ok := sh.Storage.(io.Closer); ok {
+			if e := c.Close(); e != nil && err == nil {
+				err = fmt.Errorf("shard %q: %w", sh.Name, e)
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package pdatashard
+
+import (
+	"context"
+	"crypto/sha256"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/r2northstar/atlas/pkg/api/api0/api0testutil"
+	"github.com/r2northstar/atlas/pkg/memstore"
+)
+
+func newShards(names ...string) []Shard {
+	ss := make([]Shard, len(names))
+	for i, n := range names {
+		ss[i] = Shard{n, memstore.NewPdataStore(false)}
+	}
+	return ss
+}
+
+func TestPdataStorage(t *testing.T) {
+	s, err := New(true, newShards("a", "b", "c")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api0testutil.TestPdataStorage(t, s)
+}
+
+func TestPdataListStorage(t *testing.T) {
+	s, err := New(true, newShards("a", "b", "c")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api0testutil.TestPdataListStorage(t, s)
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(false); err == nil {
+		t.Errorf("expected error for no shards")
+	}
+	if _, err := New(false, newShards("a", "")...); err == nil {
+		t.Errorf("expected error for empty name")
+	}
+	if _, err := New(false, newShards("a", "a")...); err == nil {
+		t.Errorf("expected error for duplicate name")
+	}
+}
+
+func TestOwner(t *testing.T) {
+	const n = 100000
+
+	s3, _ := New(false, newShards("a", "b", "c")...)
+	s4, _ := New(false, newShards("a", "b", "c", "d")...)
+
+	// the order of the shards doesn't matter
+	r3, _ := New(false, newShards("c", "a", "b")...)
+
+	counts := map[string]int{}
+	var moved int
+	for uid := uint64(1000000000); uid < 1000000000+n; uid++ {
+		a, b := s3.Shards()[s3.Owner(uid)].Name, s4.Shards()[s4.Owner(uid)].Name
+		if c := r3.Shards()[r3.Owner(uid)].Name; a != c {
+			t.Fatalf("uid %d: owner depends on shard order (%s != %s)", uid, a, c)
+		}
+		if a != b {
+			if b != "d" {
+				t.Fatalf("uid %d: moved from %s to %s instead of the new shard", uid, a, b)
+			}
+			moved++
+		}
+		counts[b]++
+	}
+	for name, c := range counts {
+		if math.Abs(float64(c)-n/4) > n/4*0.15 {
+			t.Errorf("shard %s: unbalanced (%d of %d)", name, c, n)
+		}
+	}
+	if math.Abs(float64(moved)-n/4) > n/4*0.15 {
+		t.Errorf("expected about a quarter of players to move, got %d of %d", moved, n)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards("a", "b", "c")
+
+	old, _ := New(false, shards[:2]...)
+	for uid := uint64(1); uid <= 300; uid++ {
+		if _, err := old.SetPdata(uid, []byte(strconv.FormatUint(uid, 10))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, _ := New(true, shards...)
+	var misplaced []uint64
+	for uid := uint64(1); uid <= 300; uid++ {
+		if s.Owner(uid) == 2 {
+			misplaced = append(misplaced, uid)
+		}
+	}
+	if len(misplaced) == 0 {
+		t.Fatalf("expected some players to be owned by the new shard")
+	}
+
+	// lookaside
+	if buf, exists, err := s.GetPdataCached(misplaced[0], [sha256.Size]byte{}); err != nil || !exists || string(buf) != strconv.FormatUint(misplaced[0], 10) {
+		t.Fatalf("expected lookaside to find pdata on the old shard (exists=%t, err=%v)", exists, err)
+	}
+	if _, exists, _ := shards[2].Storage.GetPdataHash(misplaced[0]); !exists {
+		t.Errorf("expected lookaside to move pdata to the new shard")
+	}
+	for _, sh := range shards[:2] {
+		if _, exists, _ := sh.Storage.GetPdataHash(misplaced[0]); exists {
+			t.Errorf("expected lookaside to delete pdata from the old shard")
+		}
+	}
+
+	// stale copy on the old shard
+	if _, err := s.SetPdata(misplaced[1], []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	if st, err := s.Rebalance(ctx, true, nil); err != nil {
+		t.Fatalf("rebalance: %v", err)
+	} else if st.Checked != 301 || st.Moved != len(misplaced)-1 {
+		t.Errorf("dry run: incorrect stats %+v", st)
+	}
+	if st, err := s.Rebalance(ctx, false, nil); err != nil {
+		t.Fatalf("rebalance: %v", err)
+	} else if st.Checked != 301 || st.Moved != len(misplaced)-2 || st.Removed != 1 {
+		t.Errorf("incorrect stats %+v", st)
+	} else if st.Shards["c"] != len(misplaced) || st.Shards["a"]+st.Shards["b"]+st.Shards["c"] != 300 {
+		t.Errorf("incorrect shard counts %v", st.Shards)
+	}
+	if st, err := s.Rebalance(ctx, false, nil); err != nil {
+		t.Fatalf("rebalance: %v", err)
+	} else if st.Checked != 300 || st.Moved != 0 || st.Removed != 0 {
+		t.Errorf("expected nothing to be moved after rebalancing, got %+v", st)
+	}
+
+	if buf, _, _ := shards[2].Storage.GetPdataCached(misplaced[1], [sha256.Size]byte{}); string(buf) != "new" {
+		t.Errorf("expected stale copy not to replace newer pdata, got %q", buf)
+	}
+	for uid := uint64(1); uid <= 300; uid++ {
+		o := s.Owner(uid)
+		for i, sh := range shards {
+			if _, exists, _ := sh.Storage.GetPdataHash(uid); exists != (i == o) {
+				t.Errorf("uid %d: expected pdata only on shard %s", uid, shards[o].Name)
+			}
+		}
+	}
+}
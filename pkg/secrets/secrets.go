@@ -0,0 +1,287 @@
+// Package secrets resolves references to secrets stored in external providers
+// (e.g., HashiCorp Vault, SOPS-encrypted files, or docker secrets).
+//
+// A reference has the form secret:provider:ref, where the format of ref
+// depends on the provider.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-envparse"
+)
+
+// Prefix is the prefix of secret references.
+const Prefix = "secret:"
+
+// ErrNotFound is returned when a secret does not exist.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider fetches secrets.
+type Provider interface {
+	// Secret fetches the secret identified by ref.
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+// Resolver resolves secret references using the providers for each name.
+type Resolver map[string]Provider
+
+// IsRef checks whether v is a secret reference.
+func IsRef(v string) bool {
+	return strings.HasPrefix(v, Prefix)
+}
+
+// Resolve resolves v if it is a secret reference, otherwise returning it
+// as-is.
+func (r Resolver) Resolve(ctx context.Context, v string) (string, error) {
+	if !IsRef(v) {
+		return v, nil
+	}
+	name, ref, ok := strings.Cut(strings.TrimPrefix(v, Prefix), ":")
+	if !ok || ref == "" {
+		return "", fmt.Errorf("invalid secret reference %q", v)
+	}
+	p, ok := r[name]
+	if !ok || p == nil {
+		return "", fmt.Errorf("resolve %q: unknown or unconfigured secret provider %q", v, name)
+	}
+	s, err := p.Secret(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("resolve %q: %w", v, err)
+	}
+	return s, nil
+}
+
+// splitKey splits a reference in the form path#key.
+func splitKey(ref string) (path, key string, err error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", "", fmt.Errorf("invalid reference %q (expected path#key)", ref)
+	}
+	return path, key, nil
+}
+
+// Vault fetches secrets from the HashiCorp Vault HTTP API. References have the
+// form path#key (e.g., secret/data/atlas#origin_password). Both KV version 1
+// and version 2 (where the path includes /data/) are supported.
+type Vault struct {
+	// Addr is the base URL of the Vault server (e.g.,
+	// https://vault.example.com:8200). It is required.
+	Addr string
+
+	// Token is the Vault token. It is required.
+	Token string
+
+	// Namespace is the Vault Enterprise namespace, if any.
+	Namespace string
+
+	// Client is the HTTP client to use. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Secret implements Provider.
+func (v *Vault) Secret(ctx context.Context, ref string) (string, error) {
+	path, key, err := splitKey(ref)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(v.Addr, "/") + "/v1/" + strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return "", fmt.Errorf("vault: parse url: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("vault: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	req.Header.Set("X-Vault-Request", "true")
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	cl := v.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("vault: read response: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", fmt.Errorf("vault: %w: %s", ErrNotFound, path)
+	default:
+		var obj struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(buf, &obj) == nil && len(obj.Errors) != 0 {
+			return "", fmt.Errorf("vault: response status %d: %s", resp.StatusCode, strings.Join(obj.Errors, "; "))
+		}
+		return "", fmt.Errorf("vault: response status %d", resp.StatusCode)
+	}
+
+	var obj struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(buf, &obj); err != nil {
+		return "", fmt.Errorf("vault: decode response: %w", err)
+	}
+	data := obj.Data
+	if _, ok := data["metadata"]; ok {
+		// kv v2 wraps the secret data
+		if raw, ok := data["data"]; ok {
+			data = nil
+			if err := json.Unmarshal(raw, &data); err != nil {
+				return "", fmt.Errorf("vault: decode kv v2 data: %w", err)
+			}
+		}
+	}
+	raw, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault: %w: %s#%s", ErrNotFound, path, key)
+	}
+	return jsonString(raw)
+}
+
+// SOPS decrypts secrets from SOPS-encrypted files using the sops binary, which
+// must have access to the decryption keys (e.g., via SOPS_AGE_KEY_FILE or
+// cloud KMS credentials). References have the form path#key, where key is a
+// dot-separated path into the decrypted document (e.g.,
+// /etc/atlas/secrets.yaml#origin.password).
+type SOPS struct {
+	// Binary is the path to the sops binary. If empty, sops is looked up in
+	// the PATH.
+	Binary string
+}
+
+// Secret implements Provider.
+func (s *SOPS) Secret(ctx context.Context, ref string) (string, error) {
+	path, key, err := splitKey(ref)
+	if err != nil {
+		return "", err
+	}
+
+	bin := s.Binary
+	if bin == "" {
+		bin = "sops"
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, "--decrypt", "--output-type", "json", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("sops: decrypt %q: %w (%s)", path, err, msg)
+		}
+		return "", fmt.Errorf("sops: decrypt %q: %w", path, err)
+	}
+
+	var cur json.RawMessage = stdout.Bytes()
+	for _, k := range strings.Split(key, ".") {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(cur, &obj); err != nil {
+			return "", fmt.Errorf("sops: %w: %s#%s", ErrNotFound, path, key)
+		}
+		var ok bool
+		if cur, ok = obj[k]; !ok {
+			return "", fmt.Errorf("sops: %w: %s#%s", ErrNotFound, path, key)
+		}
+	}
+	return jsonString(cur)
+}
+
+// Dir reads secrets from files in a directory (e.g., docker or kubernetes
+// secrets). References are file names, or absolute paths. Leading and
+// trailing whitespace is trimmed.
+type Dir struct {
+	// Path is the directory containing the secrets. If empty, it defaults to
+	// /run/secrets.
+	Path string
+}
+
+// Secret implements Provider.
+func (d *Dir) Secret(ctx context.Context, ref string) (string, error) {
+	fn := ref
+	if !filepath.IsAbs(fn) {
+		if strings.ContainsAny(fn, `/\`) || fn == "." || fn == ".." {
+			return "", fmt.Errorf("invalid secret name %q", ref)
+		}
+		dir := d.Path
+		if dir == "" {
+			dir = "/run/secrets"
+		}
+		fn = filepath.Join(dir, fn)
+	}
+	buf, err := os.ReadFile(fn)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("%w: %s", ErrNotFound, fn)
+		}
+		return "", err
+	}
+	return string(bytes.TrimSpace(buf)), nil
+}
+
+// EnvFile reads secrets from env files in the same format as the Atlas env
+// file. References have the form path#KEY.
+type EnvFile struct{}
+
+// Secret implements Provider.
+func (EnvFile) Secret(ctx context.Context, ref string) (string, error) {
+	path, key, err := splitKey(ref)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	m, err := envparse.Parse(f)
+	if err != nil {
+		return "", fmt.Errorf("parse %q: %w", path, err)
+	}
+	v, ok := m[key]
+	if !ok {
+		return "", fmt.Errorf("%w: %s#%s", ErrNotFound, path, key)
+	}
+	return v, nil
+}
+
+// jsonString converts a JSON string, number, or boolean to a string.
+func jsonString(raw json.RawMessage) (string, error) {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64, bool:
+		return string(bytes.TrimSpace(raw)), nil
+	default:
+		return "", fmt.Errorf("secret is a %T, not a string", v)
+	}
+}
@@ -0,0 +1,141 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestResolver(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "origin_password"), []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env := filepath.Join(dir, "atlas.env")
+	if err := os.WriteFile(env, []byte("# comment\n\nexport A=1\nB=\"two\\nlines\"\nC='three'\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Resolver{
+		"file":    &Dir{Path: dir},
+		"envfile": EnvFile{},
+	}
+	for _, c := range []struct {
+		v, exp string
+		err    bool
+	}{
+		{"plain", "plain", false},
+		{"secret:file:origin_password", "hunter2", false},
+		{"secret:file:" + filepath.Join(dir, "origin_password"), "hunter2", false},
+		{"secret:file:../origin_password", "", true},
+		{"secret:file:missing", "", true},
+		{"secret:envfile:" + env + "#A", "1", false},
+		{"secret:envfile:" + env + "#B", "two\nlines", false},
+		{"secret:envfile:" + env + "#C", "three", false},
+		{"secret:envfile:" + env + "#D", "", true},
+		{"secret:envfile:" + env, "", true},
+		{"secret:vault:secret/atlas#x", "", true},
+		{"secret:file", "", true},
+	} {
+		s, err := r.Resolve(context.Background(), c.v)
+		if c.err {
+			if err == nil {
+				t.Errorf("resolve %q: expected error, got %q", c.v, s)
+			}
+		} else if err != nil {
+			t.Errorf("resolve %q: unexpected error: %v", c.v, err)
+		} else if s != c.exp {
+			t.Errorf("resolve %q: expected %q, got %q", c.v, c.exp, s)
+		}
+	}
+	if _, err := r.Resolve(context.Background(), "secret:file:missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected missing secret to return ErrNotFound, got %v", err)
+	}
+}
+
+func TestVault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		if r.Header.Get("X-Vault-Namespace") != "ns" {
+			t.Errorf("expected namespace header")
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/atlas":
+			w.Write([]byte(`{"data":{"data":{"password":"v2","port":1234},"metadata":{"version":3}}}`))
+		case "/v1/kv/atlas":
+			w.Write([]byte(`{"data":{"password":"v1","nested":{"a":"b"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer srv.Close()
+
+	v := &Vault{Addr: srv.URL + "/", Token: "token", Namespace: "ns"}
+	for _, c := range []struct {
+		ref, exp string
+		err      bool
+	}{
+		{"secret/data/atlas#password", "v2", false},
+		{"secret/data/atlas#port", "1234", false},
+		{"/kv/atlas#password", "v1", false},
+		{"kv/atlas#nested", "", true},
+		{"kv/atlas#missing", "", true},
+		{"kv/missing#password", "", true},
+		{"kv/atlas", "", true},
+	} {
+		s, err := v.Secret(context.Background(), c.ref)
+		if c.err {
+			if err == nil {
+				t.Errorf("vault %q: expected error, got %q", c.ref, s)
+			}
+		} else if err != nil {
+			t.Errorf("vault %q: unexpected error: %v", c.ref, err)
+		} else if s != c.exp {
+			t.Errorf("vault %q: expected %q, got %q", c.ref, c.exp, s)
+		}
+	}
+
+	v.Token = "wrong"
+	if _, err := v.Secret(context.Background(), "kv/atlas#password"); err == nil {
+		t.Errorf("expected error for invalid token")
+	}
+}
+
+func TestSOPS(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a shell script")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "sops")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n[ \"$1 $2 $3\" = \"--decrypt --output-type json\" ] || exit 2\n[ -f \"$4\" ] || { echo \"no such file\" >&2; exit 1; }\ncat \"$4\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(dir, "secrets.json")
+	if err := os.WriteFile(doc, []byte(`{"origin":{"password":"decrypted"},"flag":true}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &SOPS{Binary: bin}
+	if v, err := s.Secret(context.Background(), doc+"#origin.password"); err != nil || v != "decrypted" {
+		t.Errorf("expected decrypted secret, got %q (err: %v)", v, err)
+	}
+	if v, err := s.Secret(context.Background(), doc+"#flag"); err != nil || v != "true" {
+		t.Errorf("expected boolean secret, got %q (err: %v)", v, err)
+	}
+	if _, err := s.Secret(context.Background(), doc+"#origin.missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := s.Secret(context.Background(), filepath.Join(dir, "missing.json")+"#a"); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
@@ -0,0 +1,265 @@
+// Package signkeys manages rotating ed25519 signing keys with overlap windows,
+// and publishes them as a JSON Web Key Set (RFC 7517, RFC 8037).
+//
+// New signatures are always made with the newest key, but retired keys are
+// still published (and accepted) until the end of their overlap window, so
+// verifiers which cache the key set have time to pick up the new key.
+package signkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Key is a signing key.
+type Key struct {
+	// ID is the key ID, which is the RFC 7638 JWK thumbprint of the public
+	// key.
+	ID string `json:"kid"`
+
+	// Seed is the ed25519 private key seed.
+	Seed []byte `json:"seed"`
+
+	// Created is the time the key was generated.
+	Created time.Time `json:"created"`
+
+	// Expires, if non-zero, is the time the key is no longer accepted after.
+	// It is set when the key is rotated out.
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// Public gets the public key.
+func (k Key) Public() ed25519.PublicKey {
+	return ed25519.NewKeyFromSeed(k.Seed).Public().(ed25519.PublicKey)
+}
+
+// Sign signs msg with k.
+func (k Key) Sign(msg []byte) []byte {
+	return ed25519.Sign(ed25519.NewKeyFromSeed(k.Seed), msg)
+}
+
+// Active checks whether k can be used for verification at t.
+func (k Key) Active(t time.Time) bool {
+	return k.Expires.IsZero() || t.Before(k.Expires)
+}
+
+// NewKey creates a key from an ed25519 seed.
+func NewKey(seed []byte, created time.Time) (Key, error) {
+	if len(seed) != ed25519.SeedSize {
+		return Key{}, fmt.Errorf("invalid ed25519 seed length %d", len(seed))
+	}
+	return Key{
+		ID:      Thumbprint(ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)),
+		Seed:    append([]byte(nil), seed...),
+		Created: created,
+	}, nil
+}
+
+// GenerateKey generates a new random key.
+func GenerateKey(created time.Time) (Key, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return Key{}, err
+	}
+	return NewKey(seed, created)
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint of an ed25519 public key.
+func Thumbprint(pub ed25519.PublicKey) string {
+	h := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + base64.RawURLEncoding.EncodeToString(pub) + `"}`))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// JWK is an ed25519 public key in JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Exp int64  `json:"exp,omitempty"` // non-standard, the key expiry time
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Set is a set of signing keys. It is safe for concurrent use.
+type Set struct {
+	mu   sync.RWMutex
+	keys []Key // newest first
+	priv map[string]ed25519.PrivateKey
+}
+
+// NewSet creates a new key set from the provided keys.
+func NewSet(keys ...Key) (*Set, error) {
+	var s Set
+	if err := s.Replace(keys); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Replace replaces the keys in s. At least one key must be provided, and the
+// newest one must not have expired.
+func (s *Set) Replace(keys []Key) error {
+	if len(keys) == 0 {
+		return errors.New("no keys provided")
+	}
+	ks := append([]Key(nil), keys...)
+	sort.SliceStable(ks, func(i, j int) bool {
+		return ks[i].Created.After(ks[j].Created)
+	})
+	priv := make(map[string]ed25519.PrivateKey, len(ks))
+	for _, k := range ks {
+		if len(k.Seed) != ed25519.SeedSize {
+			return fmt.Errorf("key %q: invalid ed25519 seed length %d", k.ID, len(k.Seed))
+		}
+		p := ed25519.NewKeyFromSeed(k.Seed)
+		if id := Thumbprint(p.Public().(ed25519.PublicKey)); k.ID != id {
+			return fmt.Errorf("key %q: id does not match thumbprint %q", k.ID, id)
+		}
+		if _, dup := priv[k.ID]; dup {
+			return fmt.Errorf("key %q: duplicate key", k.ID)
+		}
+		priv[k.ID] = p
+	}
+	if !ks[0].Expires.IsZero() {
+		return fmt.Errorf("key %q: newest key must not be expiring", ks[0].ID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys, s.priv = ks, priv
+	return nil
+}
+
+// Keys gets a copy of the keys, newest first.
+func (s *Set) Keys() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Key(nil), s.keys...)
+}
+
+// Current gets the newest key, which is used for signing.
+func (s *Set) Current() Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys[0]
+}
+
+// Sign signs msg with the newest key, returning the key ID and signature.
+func (s *Set) Sign(msg []byte) (kid string, sig []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys[0].ID, ed25519.Sign(s.priv[s.keys[0].ID], msg)
+}
+
+// Verify verifies a signature made by the key with the provided ID at t.
+func (s *Set) Verify(t time.Time, kid string, msg, sig []byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if k.ID == kid {
+			return k.Active(t) && ed25519.Verify(s.priv[kid].Public().(ed25519.PublicKey), msg, sig)
+		}
+	}
+	return false
+}
+
+// JWKS gets the active public keys at t, newest first.
+func (s *Set) JWKS(t time.Time) JWKS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	j := JWKS{Keys: []JWK{}}
+	for _, k := range s.keys {
+		if k.Active(t) {
+			var exp int64
+			if !k.Expires.IsZero() {
+				exp = k.Expires.Unix()
+			}
+			j.Keys = append(j.Keys, JWK{
+				Kty: "OKP",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(s.priv[k.ID].Public().(ed25519.PublicKey)),
+				Kid: k.ID,
+				Use: "sig",
+				Alg: "EdDSA",
+				Exp: exp,
+			})
+		}
+	}
+	return j
+}
+
+// Rotate generates a new key at t, the previous keys expiring after overlap
+// (unless they are already expiring sooner). Expired keys are removed.
+func Rotate(keys []Key, t time.Time, overlap time.Duration) ([]Key, Key, error) {
+	nk, err := GenerateKey(t)
+	if err != nil {
+		return nil, Key{}, err
+	}
+	ks := []Key{nk}
+	for _, k := range keys {
+		if !k.Active(t) {
+			continue
+		}
+		if exp := t.Add(overlap); k.Expires.IsZero() || exp.Before(k.Expires) {
+			k.Expires = exp
+		}
+		ks = append(ks, k)
+	}
+	return ks, nk, nil
+}
+
+// file is the JSON format of a key file.
+type file struct {
+	Keys []Key `json:"keys"`
+}
+
+// ReadFile reads keys from a JSON file.
+func ReadFile(name string) ([]Key, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return nil, fmt.Errorf("parse %q: %w", name, err)
+	}
+	return f.Keys, nil
+}
+
+// WriteFile atomically writes keys to a JSON file, which is only readable by
+// the current user.
+func WriteFile(name string, keys []Key) error {
+	buf, err := json.MarshalIndent(file{keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(buf, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,91 @@
+package signkeys
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestThumbprint(t *testing.T) {
+	// RFC 8037 appendix A.3
+	pub, _ := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	if tp := Thumbprint(ed25519.PublicKey(pub)); tp != "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k" {
+		t.Errorf("incorrect thumbprint %q", tp)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+
+	k0, err := GenerateKey(t0)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	s, err := NewSet(k0)
+	if err != nil {
+		t.Fatalf("new set: %v", err)
+	}
+
+	msg := []byte("hello")
+	kid0, sig0 := s.Sign(msg)
+	if kid0 != k0.ID {
+		t.Errorf("expected signature with %q, got %q", k0.ID, kid0)
+	}
+
+	t1 := t0.Add(time.Hour)
+	ks, k1, err := Rotate(s.Keys(), t1, time.Hour*24)
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	fn := filepath.Join(t.TempDir(), "keys.json")
+	if err := WriteFile(fn, ks); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if ks, err = ReadFile(fn); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := s.Replace(ks); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+
+	kid1, sig1 := s.Sign(msg)
+	if kid1 != k1.ID || s.Current().ID != k1.ID {
+		t.Errorf("expected issuance to be pinned to the newest key")
+	}
+	if !s.Verify(t1, kid0, msg, sig0) || !s.Verify(t1, kid1, msg, sig1) {
+		t.Errorf("expected both keys to verify during the overlap window")
+	}
+	if s.Verify(t1, kid1, msg, sig0) {
+		t.Errorf("expected signature with the wrong key id to fail")
+	}
+	if j := s.JWKS(t1); len(j.Keys) != 2 || j.Keys[0].Kid != kid1 || j.Keys[1].Exp != t1.Add(time.Hour*24).Unix() {
+		t.Errorf("incorrect jwks during overlap: %+v", j)
+	}
+
+	t2 := t1.Add(time.Hour * 25)
+	if s.Verify(t2, kid0, msg, sig0) {
+		t.Errorf("expected old key to be rejected after the overlap window")
+	}
+	if j := s.JWKS(t2); len(j.Keys) != 1 || j.Keys[0].Kid != kid1 || j.Keys[0].Crv != "Ed25519" {
+		t.Errorf("incorrect jwks after overlap: %+v", j)
+	}
+
+	ks, _, err = Rotate(s.Keys(), t2, time.Hour)
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if len(ks) != 2 {
+		t.Errorf("expected expired keys to be removed, got %d keys", len(ks))
+	}
+
+	bad := k1
+	bad.ID = k0.ID
+	if err := s.Replace([]Key{bad}); err == nil {
+		t.Errorf("expected mismatched key id to be rejected")
+	}
+	if err := s.Replace([]Key{ks[1]}); err == nil {
+		t.Errorf("expected expiring newest key to be rejected")
+	}
+}
@@ -0,0 +1,379 @@
+// Package slo tracks rolling availability and latency of classes of endpoints
+// against service level objectives, and alerts on error budget burn rates.
+package slo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Objective is a service level objective for a class of endpoints. A request
+// is bad if it fails with a 5xx status or takes longer than Latency.
+type Objective struct {
+	// Name identifies the endpoint class.
+	Name string
+
+	// Paths are the request path prefixes in the class. A prefix matches the
+	// path itself and anything under it.
+	Paths []string
+
+	// Target is the fraction of requests which must be good (e.g., 0.995).
+	Target float64
+
+	// Latency is the maximum duration of a good request. If zero, latency is
+	// not considered.
+	Latency time.Duration
+}
+
+// ParseObjective parses an objective in the format
+// name=target%[/latency]@prefix[|prefix...] (e.g.,
+// auth=99.5%/2s@/client/origin_auth|/client/auth_with_server).
+func ParseObjective(s string) (Objective, error) {
+	var o Objective
+
+	name, rest, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return o, fmt.Errorf("parse objective %q: missing name", s)
+	}
+	o.Name = name
+
+	spec, paths, ok := strings.Cut(rest, "@")
+	if !ok || paths == "" {
+		return o, fmt.Errorf("parse objective %q: missing paths", s)
+	}
+	for _, p := range strings.Split(paths, "|") {
+		if !strings.HasPrefix(p, "/") {
+			return o, fmt.Errorf("parse objective %q: path %q must start with a slash", s, p)
+		}
+		o.Paths = append(o.Paths, strings.TrimSuffix(p, "/"))
+	}
+
+	target, latency, hasLatency := strings.Cut(spec, "/")
+	if !strings.HasSuffix(target, "%") {
+		return o, fmt.Errorf("parse objective %q: target must be a percentage", s)
+	}
+	if v, err := strconv.ParseFloat(strings.TrimSuffix(target, "%"), 64); err != nil || v <= 0 || v >= 100 {
+		return o, fmt.Errorf("parse objective %q: target must be between 0%% and 100%%", s)
+	} else {
+		o.Target = v / 100
+	}
+	if hasLatency {
+		if v, err := time.ParseDuration(latency); err != nil || v <= 0 {
+			return o, fmt.Errorf("parse objective %q: invalid latency %q", s, latency)
+		} else {
+			o.Latency = v
+		}
+	}
+	return o, nil
+}
+
+// Match checks if path is in the endpoint class.
+func (o Objective) Match(path string) bool {
+	for _, p := range o.Paths {
+		if p == "" || path == p || (strings.HasPrefix(path, p) && path[len(p)] == '/') {
+			return true
+		}
+	}
+	return false
+}
+
+// Severity is the urgency of an alert.
+type Severity string
+
+const (
+	SeverityPage   Severity = "page"   // the budget will be exhausted within days
+	SeverityTicket Severity = "ticket" // the budget is burning faster than sustainable
+)
+
+// alertRules are the multi-window burn-rate alerts from the Google SRE
+// workbook. The long window detects significant budget consumption, and the
+// short one makes the alert resolve quickly once the problem stops.
+var alertRules = []struct {
+	Severity Severity
+	Long     time.Duration
+	Short    time.Duration
+	BurnRate float64
+}{
+	{SeverityPage, time.Hour, time.Minute * 5, 14.4},
+	{SeverityTicket, time.Hour * 6, time.Minute * 30, 6},
+}
+
+// burnWindows are the windows burn rates are exported for.
+var burnWindows = []struct {
+	Name string
+	D    time.Duration
+}{
+	{"5m", time.Minute * 5},
+	{"30m", time.Minute * 30},
+	{"1h", time.Hour},
+	{"6h", time.Hour * 6},
+}
+
+// Alert is a change in the state of a burn-rate alert.
+type Alert struct {
+	Objective string
+	Severity  Severity
+	Firing    bool          // false if resolved
+	BurnRate  float64       // over Window
+	Window    time.Duration // the long window of the alert
+	Budget    float64       // remaining fraction of the error budget for the period
+}
+
+// Tracker tracks requests against objectives. The zero value is usable, but
+// doesn't have any objectives. It is safe for concurrent use, but Objectives
+// and Period must not be modified after the first request is observed.
+type Tracker struct {
+	// Objectives are the objectives to track. If a request matches multiple,
+	// the first one is used.
+	Objectives []Objective
+
+	// Period is the duration the error budget applies to. If zero, it
+	// defaults to 30 days.
+	Period time.Duration
+
+	// MinRequests is the minimum number of requests in the long window of an
+	// alert for it to fire, to prevent alerts on low traffic. If zero, it
+	// defaults to 100.
+	MinRequests int
+
+	// AlertHook, if set, is called when an alert starts firing or resolves.
+	AlertHook func(Alert)
+
+	now  func() time.Time // for testing
+	init sync.Once
+	st   []*objectiveState
+}
+
+type objectiveState struct {
+	mu      sync.Mutex
+	minutes []bucket // ring of the last 6h, by minute (for burn rates)
+	hours   []bucket // ring of the period, by hour (for the budget)
+	firing  map[Severity]bool
+	reqs    struct {
+		good  atomic.Uint64
+		error atomic.Uint64
+		slow  atomic.Uint64
+	}
+	alerts map[Severity]*atomic.Uint64
+}
+
+type bucket struct {
+	t    int64 // unix time / bucket size
+	good uint64
+	bad  uint64
+}
+
+func (t *Tracker) initState() {
+	t.init.Do(func() {
+		period := t.Period
+		if period <= 0 {
+			period = time.Hour * 24 * 30
+		}
+		hours := int((period + time.Hour - 1) / time.Hour)
+		for range t.Objectives {
+			st := &objectiveState{
+				minutes: make([]bucket, 6*60),
+				hours:   make([]bucket, hours),
+				firing:  map[Severity]bool{},
+				alerts:  map[Severity]*atomic.Uint64{},
+			}
+			for _, r := range alertRules {
+				st.alerts[r.Severity] = new(atomic.Uint64)
+			}
+			t.st = append(t.st, st)
+		}
+	})
+}
+
+func (t *Tracker) time() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// Observe records a request. If it doesn't match any objective, it is ignored.
+func (t *Tracker) Observe(path string, status int, d time.Duration) {
+	t.initState()
+
+	for i, o := range t.Objectives {
+		if !o.Match(path) {
+			continue
+		}
+		st := t.st[i]
+
+		var bad bool
+		switch {
+		case status >= 500:
+			st.reqs.error.Add(1)
+			bad = true
+		case o.Latency != 0 && d > o.Latency:
+			st.reqs.slow.Add(1)
+			bad = true
+		default:
+			st.reqs.good.Add(1)
+		}
+
+		now := t.time().Unix()
+		st.mu.Lock()
+		record(st.minutes, now/60, bad)
+		record(st.hours, now/3600, bad)
+		st.mu.Unlock()
+		return
+	}
+}
+
+func record(r []bucket, t int64, bad bool) {
+	b := &r[t%int64(len(r))]
+	if b.t != t {
+		*b = bucket{t: t}
+	}
+	if bad {
+		b.bad++
+	} else {
+		b.good++
+	}
+}
+
+// sum counts requests in the buckets of r within n buckets before t.
+func sum(r []bucket, t int64, n int64) (good, bad uint64) {
+	for _, b := range r {
+		if b.t <= t && b.t > t-n {
+			good += b.good
+			bad += b.bad
+		}
+	}
+	return
+}
+
+// burnRate gets the rate at which the error budget is being consumed over d
+// (1 means it will be exactly exhausted at the end of the period), and the
+// number of requests in d. The lock must be held.
+func (t *Tracker) burnRate(i int, now time.Time, d time.Duration) (float64, uint64) {
+	good, bad := sum(t.st[i].minutes, now.Unix()/60, int64(d/time.Minute))
+	if good+bad == 0 {
+		return 0, 0
+	}
+	return float64(bad) / float64(good+bad) / (1 - t.Objectives[i].Target), good + bad
+}
+
+// budget gets the remaining fraction of the error budget over the period,
+// which is negative if it has been exceeded. The lock must be held.
+func (t *Tracker) budget(i int, now time.Time) float64 {
+	st := t.st[i]
+	good, bad := sum(st.hours, now.Unix()/3600, int64(len(st.hours)))
+	if good+bad == 0 {
+		return 1
+	}
+	return 1 - float64(bad)/float64(good+bad)/(1-t.Objectives[i].Target)
+}
+
+// Run evaluates alerts every minute until ctx is canceled.
+func (t *Tracker) Run(ctx context.Context) {
+	tk := time.NewTicker(time.Minute)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			t.evaluate()
+		}
+	}
+}
+
+func (t *Tracker) evaluate() {
+	t.initState()
+
+	minReqs := uint64(t.MinRequests)
+	if minReqs == 0 {
+		minReqs = 100
+	}
+
+	now := t.time()
+	for i, o := range t.Objectives {
+		st := t.st[i]
+
+		var as []Alert
+		st.mu.Lock()
+		for _, r := range alertRules {
+			long, n := t.burnRate(i, now, r.Long)
+			short, _ := t.burnRate(i, now, r.Short)
+			firing := n >= minReqs && long > r.BurnRate && short > r.BurnRate
+			if firing != st.firing[r.Severity] {
+				st.firing[r.Severity] = firing
+				if firing {
+					st.alerts[r.Severity].Add(1)
+				}
+				as = append(as, Alert{
+					Objective: o.Name,
+					Severity:  r.Severity,
+					Firing:    firing,
+					BurnRate:  long,
+					Window:    r.Long,
+					Budget:    t.budget(i, now),
+				})
+			}
+		}
+		st.mu.Unlock()
+
+		if t.AlertHook != nil {
+			for _, a := range as {
+				t.AlertHook(a)
+			}
+		}
+	}
+}
+
+// WritePrometheus writes prometheus text metrics to w.
+func (t *Tracker) WritePrometheus(w io.Writer) {
+	t.initState()
+
+	now := t.time()
+	for i, o := range t.Objectives {
+		st := t.st[i]
+		l := strconv.Quote(o.Name)
+
+		fmt.Fprintf(w, "atlas_slo_requests_total{objective=%s,result=\"good\"} %d\n", l, st.reqs.good.Load())
+		fmt.Fprintf(w, "atlas_slo_requests_total{objective=%s,result=\"error\"} %d\n", l, st.reqs.error.Load())
+		fmt.Fprintf(w, "atlas_slo_requests_total{objective=%s,result=\"slow\"} %d\n", l, st.reqs.slow.Load())
+		fmt.Fprintf(w, "atlas_slo_target{objective=%s} %s\n", l, formatFloat(o.Target))
+		if o.Latency != 0 {
+			fmt.Fprintf(w, "atlas_slo_latency_seconds{objective=%s} %s\n", l, formatFloat(o.Latency.Seconds()))
+		}
+
+		st.mu.Lock()
+		for _, x := range burnWindows {
+			v, _ := t.burnRate(i, now, x.D)
+			fmt.Fprintf(w, "atlas_slo_burn_rate{objective=%s,window=%q} %s\n", l, x.Name, formatFloat(v))
+		}
+		fmt.Fprintf(w, "atlas_slo_error_budget_remaining{objective=%s} %s\n", l, formatFloat(t.budget(i, now)))
+		for _, r := range alertRules {
+			var v int
+			if st.firing[r.Severity] {
+				v = 1
+			}
+			fmt.Fprintf(w, "atlas_slo_alert_firing{objective=%s,severity=%q} %d\n", l, r.Severity, v)
+		}
+		st.mu.Unlock()
+
+		for _, r := range alertRules {
+			fmt.Fprintf(w, "atlas_slo_alerts_total{objective=%s,severity=%q} %d\n", l, r.Severity, st.alerts[r.Severity].Load())
+		}
+	}
+}
+
+func formatFloat(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "0"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
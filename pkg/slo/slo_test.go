@@ -0,0 +1,145 @@
+package slo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseObjective(t *testing.T) {
+	o, err := ParseObjective("auth=99.5%/2s@/client/origin_auth|/client/auth_with_server/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.Name != "auth" || o.Target != 0.995 || o.Latency != time.Second*2 || len(o.Paths) != 2 || o.Paths[1] != "/client/auth_with_server" {
+		t.Errorf("incorrect objective %+v", o)
+	}
+	for path, exp := range map[string]bool{
+		"/client/origin_auth":          true,
+		"/client/origin_auth/x":        true,
+		"/client/origin_authx":         false,
+		"/client/auth_with_server":     true,
+		"/client/auth_with_self":       false,
+		"/isolated/client/origin_auth": false,
+	} {
+		if act := o.Match(path); act != exp {
+			t.Errorf("match %q: expected %t, got %t", path, exp, act)
+		}
+	}
+
+	if o, err := ParseObjective("all=99%@/"); err != nil || o.Latency != 0 || !o.Match("/anything") {
+		t.Errorf("incorrect objective %+v (err: %v)", o, err)
+	}
+
+	for _, s := range []string{
+		"",
+		"auth",
+		"=99%@/",
+		"auth=99%",
+		"auth=99@/",
+		"auth=100%@/",
+		"auth=99%/x@/",
+		"auth=99%@client",
+	} {
+		if _, err := ParseObjective(s); err == nil {
+			t.Errorf("parse %q: expected error", s)
+		}
+	}
+}
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var alerts []Alert
+	tr := &Tracker{
+		Objectives: []Objective{{
+			Name:    "auth",
+			Paths:   []string{"/client/origin_auth"},
+			Target:  0.99,
+			Latency: time.Second,
+		}},
+		MinRequests: 10,
+		AlertHook: func(a Alert) {
+			alerts = append(alerts, a)
+		},
+		now: func() time.Time { return now },
+	}
+
+	// healthy
+	for i := 0; i < 60; i++ {
+		for j := 0; j < 10; j++ {
+			tr.Observe("/client/origin_auth", 200, time.Millisecond*100)
+		}
+		tr.Observe("/client/servers", 500, 0) // ignored
+		tr.evaluate()
+		now = now.Add(time.Minute)
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("unexpected alerts %+v", alerts)
+	}
+
+	// most requests are bad (burn rate 60)
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 10; j++ {
+			switch j % 4 {
+			case 0:
+				tr.Observe("/client/origin_auth", 500, time.Millisecond*100)
+			case 1:
+				tr.Observe("/client/origin_auth", 200, time.Second*2)
+			default:
+				tr.Observe("/client/origin_auth", 429, time.Millisecond*100)
+			}
+		}
+		tr.evaluate()
+		now = now.Add(time.Minute)
+	}
+	if len(alerts) != 2 {
+		t.Fatalf("expected page and ticket alerts, got %+v", alerts)
+	}
+	for _, a := range alerts {
+		if !a.Firing || a.Objective != "auth" || a.BurnRate <= 6 || a.Budget >= 1 {
+			t.Errorf("incorrect alert %+v", a)
+		}
+	}
+
+	var b bytes.Buffer
+	tr.WritePrometheus(&b)
+	for _, x := range []string{
+		`atlas_slo_requests_total{objective="auth",result="good"} 680`,
+		`atlas_slo_requests_total{objective="auth",result="error"} 60`,
+		`atlas_slo_requests_total{objective="auth",result="slow"} 60`,
+		`atlas_slo_target{objective="auth"} 0.99`,
+		`atlas_slo_alert_firing{objective="auth",severity="page"} 1`,
+		`atlas_slo_alerts_total{objective="auth",severity="ticket"} 1`,
+	} {
+		if !strings.Contains(b.String(), x+"\n") {
+			t.Errorf("expected %q in metrics:\n%s", x, b.String())
+		}
+	}
+
+	// recovered (the short windows resolve the alerts)
+	alerts = nil
+	for i := 0; i < 30; i++ {
+		for j := 0; j < 10; j++ {
+			tr.Observe("/client/origin_auth", 200, time.Millisecond*100)
+		}
+		tr.evaluate()
+		now = now.Add(time.Minute)
+	}
+	if len(alerts) != 2 {
+		t.Fatalf("expected alerts to resolve, got %+v", alerts)
+	}
+	for _, a := range alerts {
+		if a.Firing {
+			t.Errorf("expected alert to be resolved: %+v", a)
+		}
+	}
+
+	// old requests leave the period
+	now = now.Add(time.Hour * 24 * 31)
+	b.Reset()
+	tr.WritePrometheus(&b)
+	if !strings.Contains(b.String(), `atlas_slo_error_budget_remaining{objective="auth"} 1`+"\n") {
+		t.Errorf("expected full budget after period:\n%s", b.String())
+	}
+}
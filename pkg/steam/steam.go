@@ -0,0 +1,110 @@
+// Package steam is a client for the parts of the Steam Web API used for
+// verifying Steam session tickets.
+package steam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+var (
+	ErrSteam           = errors.New("steam api error")
+	ErrInvalidResponse = errors.New("invalid steam api response")
+	ErrInvalidTicket   = errors.New("invalid steam ticket")
+	ErrNotOwner        = errors.New("ticket is for a borrowed (family shared) game")
+)
+
+// Base is the base URL for the Steam Web API.
+var Base = "https://api.steampowered.com"
+
+// AppIDTitanfall2 is the Steam app ID for Titanfall 2.
+const AppIDTitanfall2 = 1237970
+
+// TicketInfo contains information about a verified session ticket.
+type TicketInfo struct {
+	SteamID         uint64
+	VACBanned       bool
+	PublisherBanned bool
+}
+
+// AuthenticateUserTicket verifies a hex-encoded session ticket for appID using
+// the provided publisher or user web api key.
+func AuthenticateUserTicket(ctx context.Context, key string, appID uint32, ticket string) (*TicketInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, Base+"/ISteamUserAuth/AuthenticateUserTicket/v1/?"+(url.Values{
+		"key":    {key},
+		"appid":  {strconv.FormatUint(uint64(appID), 10)},
+		"ticket": {ticket},
+	}).Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return authenticateUserTicket(resp)
+}
+
+func authenticateUserTicket(resp *http.Response) (*TicketInfo, error) {
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+
+	var obj struct {
+		Response struct {
+			Params *struct {
+				Result          string `json:"result"`
+				SteamID         string `json:"steamid"`
+				OwnerSteamID    string `json:"ownersteamid"`
+				VACBanned       bool   `json:"vacbanned"`
+				PublisherBanned bool   `json:"publisherbanned"`
+			} `json:"params"`
+			Error *struct {
+				ErrorCode int    `json:"errorcode"`
+				ErrorDesc string `json:"errordesc"`
+			} `json:"error"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(buf, &obj); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%w: response status %d (%s)", ErrSteam, resp.StatusCode, resp.Status)
+		}
+		return nil, fmt.Errorf("%w: invalid json: %v", ErrInvalidResponse, err)
+	}
+	if e := obj.Response.Error; e != nil {
+		switch e.ErrorCode {
+		case 3, 101, 102, 103: // invalid parameter, invalid ticket, ticket already used, ticket not for this app
+			return nil, fmt.Errorf("%w: %s (%d)", ErrInvalidTicket, e.ErrorDesc, e.ErrorCode)
+		}
+		return nil, fmt.Errorf("%w: %s (%d)", ErrSteam, e.ErrorDesc, e.ErrorCode)
+	}
+	p := obj.Response.Params
+	if p == nil {
+		return nil, fmt.Errorf("%w: missing params", ErrInvalidResponse)
+	}
+	if p.Result != "OK" {
+		return nil, fmt.Errorf("%w: result %q", ErrInvalidTicket, p.Result)
+	}
+	sid, err := strconv.ParseUint(p.SteamID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: parse steamid %q: %v", ErrInvalidResponse, p.SteamID, err)
+	}
+	if p.OwnerSteamID != "" && p.OwnerSteamID != p.SteamID {
+		return nil, ErrNotOwner
+	}
+	return &TicketInfo{
+		SteamID:         sid,
+		VACBanned:       p.VACBanned,
+		PublisherBanned: p.PublisherBanned,
+	}, nil
+}
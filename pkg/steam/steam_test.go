@@ -0,0 +1,57 @@
+package steam
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticateUserTicket(t *testing.T) {
+	testAuthenticateUserTicket(t, "Success", 200,
+		`{"response":{"params":{"result":"OK","steamid":"76561197960287930","ownersteamid":"76561197960287930","vacbanned":false,"publisherbanned":false}}}`,
+		&TicketInfo{SteamID: 76561197960287930}, nil)
+	testAuthenticateUserTicket(t, "Banned", 200,
+		`{"response":{"params":{"result":"OK","steamid":"76561197960287930","ownersteamid":"76561197960287930","vacbanned":true,"publisherbanned":false}}}`,
+		&TicketInfo{SteamID: 76561197960287930, VACBanned: true}, nil)
+	testAuthenticateUserTicket(t, "FamilyShared", 200,
+		`{"response":{"params":{"result":"OK","steamid":"76561197960287930","ownersteamid":"76561197960287931","vacbanned":false,"publisherbanned":false}}}`,
+		nil, ErrNotOwner)
+	testAuthenticateUserTicket(t, "InvalidTicket", 200,
+		`{"response":{"error":{"errorcode":101,"errordesc":"Invalid ticket"}}}`,
+		nil, ErrInvalidTicket)
+	testAuthenticateUserTicket(t, "OtherError", 200,
+		`{"response":{"error":{"errorcode":1,"errordesc":"Fake"}}}`,
+		nil, ErrSteam)
+	testAuthenticateUserTicket(t, "Forbidden", 403,
+		`<html><body>Forbidden</body></html>`,
+		nil, ErrSteam)
+	testAuthenticateUserTicket(t, "InvalidJSON", 200,
+		`fake`,
+		nil, ErrInvalidResponse)
+	testAuthenticateUserTicket(t, "MissingParams", 200,
+		`{"response":{}}`,
+		nil, ErrInvalidResponse)
+}
+
+func testAuthenticateUserTicket(t *testing.T, name string, status int, resp string, res *TicketInfo, rerr error) {
+	t.Run(name, func(t *testing.T) {
+		x, err := authenticateUserTicket(&http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       io.NopCloser(strings.NewReader(resp)),
+		})
+		if rerr == nil {
+			if err != nil {
+				t.Errorf("unexpected error (resp %q): %v", resp, err)
+			}
+		} else if !errors.Is(err, rerr) {
+			t.Errorf("expected error %q, got %q", rerr, err)
+		}
+		if !reflect.DeepEqual(x, res) {
+			t.Errorf("expected %#v, got %#v", res, x)
+		}
+	})
+}
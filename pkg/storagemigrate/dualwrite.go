@@ -0,0 +1,181 @@
+package storagemigrate
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+)
+
+// DualWriteAccounts wraps primary so writes are also applied to secondary (e.g.,
+// to keep a new backend in sync while migrating to it). Reads only use
+// primary. Errors from secondary are passed to onError (if non-nil) instead of
+// being returned. Optional interfaces other than [io.Closer] implemented by
+// primary are not exposed by the wrapper.
+func DualWriteAccounts(primary, secondary api0.AccountStorage, onError func(error)) api0.AccountStorage {
+	return dualAccountStorage{primary, secondary, onError}
+}
+
+type dualAccountStorage struct {
+	p, s    api0.AccountStorage
+	onError func(error)
+}
+
+func (x dualAccountStorage) GetUIDsByUsername(username string) ([]uint64, error) {
+	return x.p.GetUIDsByUsername(username)
+}
+
+func (x dualAccountStorage) GetAccount(uid uint64) (*api0.Account, error) {
+	return x.p.GetAccount(uid)
+}
+
+func (x dualAccountStorage) SaveAccount(a *api0.Account) error {
+	if err := x.p.SaveAccount(a); err != nil {
+		return err
+	}
+	if err := x.s.SaveAccount(a); err != nil && x.onError != nil {
+		x.onError(fmt.Errorf("save account %d: %w", a.UID, err))
+	}
+	return nil
+}
+
+func (x dualAccountStorage) DeleteAccount(uid uint64) error {
+	if err := x.p.DeleteAccount(uid); err != nil {
+		return err
+	}
+	if err := x.s.DeleteAccount(uid); err != nil && x.onError != nil {
+		x.onError(fmt.Errorf("delete account %d: %w", uid, err))
+	}
+	return nil
+}
+
+func (x dualAccountStorage) Close() error {
+	return closeBoth(x.p, x.s)
+}
+
+// DualWritePdata is like [DualWriteAccounts], but for pdata.
+func DualWritePdata(primary, secondary api0.PdataStorage, onError func(error)) api0.PdataStorage {
+	return dualPdataStorage{primary, secondary, onError}
+}
+
+type dualPdataStorage struct {
+	p, s    api0.PdataStorage
+	onError func(error)
+}
+
+func (x dualPdataStorage) GetPdataHash(uid uint64) ([sha256.Size]byte, bool, error) {
+	return x.p.GetPdataHash(uid)
+}
+
+func (x dualPdataStorage) GetPdataCached(uid uint64, sha [sha256.Size]byte) ([]byte, bool, error) {
+	return x.p.GetPdataCached(uid, sha)
+}
+
+func (x dualPdataStorage) SetPdata(uid uint64, buf []byte) (int, error) {
+	n, err := x.p.SetPdata(uid, buf)
+	if err != nil {
+		return n, err
+	}
+	if _, err := x.s.SetPdata(uid, buf); err != nil && x.onError != nil {
+		x.onError(fmt.Errorf("set pdata %d: %w", uid, err))
+	}
+	return n, nil
+}
+
+func (x dualPdataStorage) DeletePdata(uid uint64) error {
+	if err := x.p.DeletePdata(uid); err != nil {
+		return err
+	}
+	if err := x.s.DeletePdata(uid); err != nil && x.onError != nil {
+		x.onError(fmt.Errorf("delete pdata %d: %w", uid, err))
+	}
+	return nil
+}
+
+func (x dualPdataStorage) Close() error {
+	return closeBoth(x.p, x.s)
+}
+
+// DualWriteState is like [DualWriteAccounts], but for state. The returned
+// storage doesn't implement [io.Closer] since state storage is shared with the
+// account storage.
+func DualWriteState(primary, secondary api0.StateStorage, onError func(error)) api0.StateStorage {
+	return dualStateStorage{primary, secondary, onError}
+}
+
+type dualStateStorage struct {
+	p, s    api0.StateStorage
+	onError func(error)
+}
+
+func (x dualStateStorage) GetState(key string) ([]byte, bool, error) {
+	return x.p.GetState(key)
+}
+
+func (x dualStateStorage) SetState(key string, buf []byte) error {
+	if err := x.p.SetState(key, buf); err != nil {
+		return err
+	}
+	if err := x.s.SetState(key, buf); err != nil && x.onError != nil {
+		x.onError(fmt.Errorf("set state %q: %w", key, err))
+	}
+	return nil
+}
+
+// DualWriteBanLists is like [DualWriteState], but for ban list entries.
+func DualWriteBanLists(primary, secondary api0.BanListStorage, onError func(error)) api0.BanListStorage {
+	return dualBanListStorage{primary, secondary, onError}
+}
+
+type dualBanListStorage struct {
+	p, s    api0.BanListStorage
+	onError func(error)
+}
+
+func (x dualBanListStorage) GetBanListEntries(list string) ([]api0.BanListEntry, error) {
+	return x.p.GetBanListEntries(list)
+}
+
+func (x dualBanListStorage) SaveBanListEntry(e *api0.BanListEntry) error {
+	if err := x.p.SaveBanListEntry(e); err != nil {
+		return err
+	}
+	if err := x.s.SaveBanListEntry(e); err != nil && x.onError != nil {
+		x.onError(fmt.Errorf("save ban list %q entry for %d: %w", e.List, e.UID, err))
+	}
+	return nil
+}
+
+func (x dualBanListStorage) DeleteBanList(list string) error {
+	if err := x.p.DeleteBanList(list); err != nil {
+		return err
+	}
+	if err := x.s.DeleteBanList(list); err != nil && x.onError != nil {
+		x.onError(fmt.Errorf("delete ban list %q: %w", list, err))
+	}
+	return nil
+}
+
+func (x dualBanListStorage) DeleteBanListEntries(uid uint64) error {
+	if err := x.p.DeleteBanListEntries(uid); err != nil {
+		return err
+	}
+	if err := x.s.DeleteBanListEntries(uid); err != nil && x.onError != nil {
+		x.onError(fmt.Errorf("delete ban list entries for %d: %w", uid, err))
+	}
+	return nil
+}
+
+func closeBoth(p, s any) error {
+	var err error
+	if c, ok := p.(io.Closer); ok {
+		err = c.Close()
+	}
+	if c, ok := s.(io.Closer); ok {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,461 @@
+// Package storagemigrate copies and verifies data between Atlas storage
+// backends.
+package storagemigrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+)
+
+// maxMismatches is the maximum number of mismatched keys to report for each
+// kind of record.
+const maxMismatches = 100
+
+// Storage is a set of storage backends. The state and ban list storage are
+// taken from Accounts if it implements them.
+type Storage struct {
+	Accounts api0.AccountStorage
+	Pdata    api0.PdataStorage
+}
+
+// Stats contains the number of records of each kind copied or checked.
+type Stats struct {
+	Accounts       int
+	Pdata          int
+	State          int
+	BanListEntries int
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d accounts, %d pdata, %d state, %d ban list entries", s.Accounts, s.Pdata, s.State, s.BanListEntries)
+}
+
+// Copy copies accounts, pdata, state, and ban list entries from src to dst,
+// replacing existing records in dst. Records in dst which don't exist in src
+// are not removed. If either Accounts or Pdata is nil in src or dst, it is
+// skipped. If progress is non-nil, it is called after every record.
+func Copy(ctx context.Context, dst, src Storage, progress func(Stats)) (Stats, error) {
+	var st Stats
+	if progress == nil {
+		progress = func(Stats) {}
+	}
+
+	if src.Accounts != nil && dst.Accounts != nil {
+		uids, err := listAccounts(src.Accounts)
+		if err != nil {
+			return st, fmt.Errorf("source: %w", err)
+		}
+		for _, uid := range uids {
+			if err := ctx.Err(); err != nil {
+				return st, err
+			}
+			a, err := src.Accounts.GetAccount(uid)
+			if err != nil {
+				return st, fmt.Errorf("source: get account %d: %w", uid, err)
+			}
+			if a == nil {
+				continue // deleted since we listed them
+			}
+			if err := dst.Accounts.SaveAccount(a); err != nil {
+				return st, fmt.Errorf("destination: save account %d: %w", uid, err)
+			}
+			st.Accounts++
+			progress(st)
+		}
+
+		srcState, dstState, err := stateStorage(dst.Accounts, src.Accounts)
+		if err != nil {
+			return st, err
+		}
+		if srcState != nil {
+			ks, err := srcState.(api0.StateListStorage).GetStateKeys()
+			if err != nil {
+				return st, fmt.Errorf("source: list state: %w", err)
+			}
+			for _, k := range ks {
+				buf, exists, err := srcState.GetState(k)
+				if err != nil {
+					return st, fmt.Errorf("source: get state %q: %w", k, err)
+				}
+				if !exists {
+					continue
+				}
+				if err := dstState.SetState(k, buf); err != nil {
+					return st, fmt.Errorf("destination: set state %q: %w", k, err)
+				}
+				st.State++
+				progress(st)
+			}
+
+			srcBans, dstBans, err := banListStorage(dst.Accounts, src.Accounts)
+			if err != nil {
+				return st, err
+			}
+			if srcBans != nil {
+				ids, err := banListIDs(srcState)
+				if err != nil {
+					return st, fmt.Errorf("source: %w", err)
+				}
+				for _, id := range ids {
+					es, err := srcBans.GetBanListEntries(id)
+					if err != nil {
+						return st, fmt.Errorf("source: get ban list %q entries: %w", id, err)
+					}
+					for i := range es {
+						if err := dstBans.SaveBanListEntry(&es[i]); err != nil {
+							return st, fmt.Errorf("destination: save ban list %q entry for %d: %w", id, es[i].UID, err)
+						}
+						st.BanListEntries++
+						progress(st)
+					}
+				}
+			}
+		}
+	}
+
+	if src.Pdata != nil && dst.Pdata != nil {
+		uids, err := listPdata(src.Pdata)
+		if err != nil {
+			return st, fmt.Errorf("source: %w", err)
+		}
+		for _, uid := range uids {
+			if err := ctx.Err(); err != nil {
+				return st, err
+			}
+			buf, exists, err := src.Pdata.GetPdataCached(uid, [sha256.Size]byte{})
+			if err != nil {
+				return st, fmt.Errorf("source: get pdata %d: %w", uid, err)
+			}
+			if !exists {
+				continue
+			}
+			if _, err := dst.Pdata.SetPdata(uid, buf); err != nil {
+				return st, fmt.Errorf("destination: set pdata %d: %w", uid, err)
+			}
+			st.Pdata++
+			progress(st)
+		}
+	}
+	return st, nil
+}
+
+// Report is the result of comparing two storage backends.
+type Report struct {
+	Stats
+
+	// Digest contains a hex-encoded hash of all records of each kind, which
+	// may be compared with the digest of another backend.
+	Digest map[string]string
+
+	// Mismatched contains up to 100 keys of each kind of record which are
+	// missing or different in the destination.
+	Mismatched map[string][]string
+}
+
+// OK checks if no mismatches were found.
+func (r Report) OK() bool {
+	return len(r.Mismatched) == 0
+}
+
+// Verify checks that every record in src exists with identical contents in
+// dst. Times are compared with second precision.
+func Verify(ctx context.Context, dst, src Storage) (Report, error) {
+	r := Report{
+		Digest:     map[string]string{},
+		Mismatched: map[string][]string{},
+	}
+	mismatch := func(kind, key string) {
+		if len(r.Mismatched[kind]) < maxMismatches {
+			r.Mismatched[kind] = append(r.Mismatched[kind], key)
+		}
+	}
+
+	if src.Accounts != nil && dst.Accounts != nil {
+		uids, err := listAccounts(src.Accounts)
+		if err != nil {
+			return r, fmt.Errorf("source: %w", err)
+		}
+		d := sha256.New()
+		for _, uid := range uids {
+			if err := ctx.Err(); err != nil {
+				return r, err
+			}
+			a, err := src.Accounts.GetAccount(uid)
+			if err != nil {
+				return r, fmt.Errorf("source: get account %d: %w", uid, err)
+			}
+			if a == nil {
+				continue
+			}
+			b, err := dst.Accounts.GetAccount(uid)
+			if err != nil {
+				return r, fmt.Errorf("destination: get account %d: %w", uid, err)
+			}
+			h := hashAccount(a)
+			if b == nil || h != hashAccount(b) {
+				mismatch("accounts", strconv.FormatUint(uid, 10))
+			}
+			d.Write(h[:])
+			r.Accounts++
+		}
+		r.Digest["accounts"] = hex.EncodeToString(d.Sum(nil))
+
+		srcState, dstState, err := stateStorage(dst.Accounts, src.Accounts)
+		if err != nil {
+			return r, err
+		}
+		if srcState != nil {
+			ks, err := srcState.(api0.StateListStorage).GetStateKeys()
+			if err != nil {
+				return r, fmt.Errorf("source: list state: %w", err)
+			}
+			d := sha256.New()
+			for _, k := range ks {
+				a, exists, err := srcState.GetState(k)
+				if err != nil {
+					return r, fmt.Errorf("source: get state %q: %w", k, err)
+				}
+				if !exists {
+					continue
+				}
+				b, exists, err := dstState.GetState(k)
+				if err != nil {
+					return r, fmt.Errorf("destination: get state %q: %w", k, err)
+				}
+				h := sha256.Sum256(a)
+				if !exists || h != sha256.Sum256(b) {
+					mismatch("state", k)
+				}
+				writeString(d, k)
+				d.Write(h[:])
+				r.State++
+			}
+			r.Digest["state"] = hex.EncodeToString(d.Sum(nil))
+
+			srcBans, dstBans, err := banListStorage(dst.Accounts, src.Accounts)
+			if err != nil {
+				return r, err
+			}
+			if srcBans != nil {
+				ids, err := banListIDs(srcState)
+				if err != nil {
+					return r, fmt.Errorf("source: %w", err)
+				}
+				d := sha256.New()
+				for _, id := range ids {
+					a, err := srcBans.GetBanListEntries(id)
+					if err != nil {
+						return r, fmt.Errorf("source: get ban list %q entries: %w", id, err)
+					}
+					b, err := dstBans.GetBanListEntries(id)
+					if err != nil {
+						return r, fmt.Errorf("destination: get ban list %q entries: %w", id, err)
+					}
+					bh := map[string][sha256.Size]byte{}
+					for _, e := range b {
+						bh[strconv.FormatUint(e.UID, 10)+"/"+e.Source] = hashBanListEntry(e)
+					}
+					sortBanListEntries(a)
+					for _, e := range a {
+						k := strconv.FormatUint(e.UID, 10) + "/" + e.Source
+						h := hashBanListEntry(e)
+						if x, ok := bh[k]; !ok || x != h {
+							mismatch("ban_list_entries", id+"/"+k)
+						}
+						d.Write(h[:])
+						r.BanListEntries++
+					}
+				}
+				r.Digest["ban_list_entries"] = hex.EncodeToString(d.Sum(nil))
+			}
+		}
+	}
+
+	if src.Pdata != nil && dst.Pdata != nil {
+		uids, err := listPdata(src.Pdata)
+		if err != nil {
+			return r, fmt.Errorf("source: %w", err)
+		}
+		d := sha256.New()
+		for _, uid := range uids {
+			if err := ctx.Err(); err != nil {
+				return r, err
+			}
+			a, exists, err := src.Pdata.GetPdataHash(uid)
+			if err != nil {
+				return r, fmt.Errorf("source: get pdata hash %d: %w", uid, err)
+			}
+			if !exists {
+				continue
+			}
+			b, exists, err := dst.Pdata.GetPdataHash(uid)
+			if err != nil {
+				return r, fmt.Errorf("destination: get pdata hash %d: %w", uid, err)
+			}
+			if !exists || a != b {
+				mismatch("pdata", strconv.FormatUint(uid, 10))
+			}
+			binary.Write(d, binary.LittleEndian, uid)
+			d.Write(a[:])
+			r.Pdata++
+		}
+		r.Digest["pdata"] = hex.EncodeToString(d.Sum(nil))
+	}
+	return r, nil
+}
+
+func listAccounts(s api0.AccountStorage) ([]uint64, error) {
+	l, ok := s.(api0.AccountListStorage)
+	if !ok {
+		return nil, fmt.Errorf("account storage does not support listing accounts")
+	}
+	uids, err := l.GetAccountUIDs()
+	if err != nil {
+		return nil, fmt.Errorf("list accounts: %w", err)
+	}
+	return uids, nil
+}
+
+func listPdata(s api0.PdataStorage) ([]uint64, error) {
+	l, ok := s.(api0.PdataListStorage)
+	if !ok {
+		return nil, fmt.Errorf("pdata storage does not support listing pdata")
+	}
+	uids, err := l.GetPdataUIDs()
+	if err != nil {
+		return nil, fmt.Errorf("list pdata: %w", err)
+	}
+	return uids, nil
+}
+
+// stateStorage gets the state storage from the account storage, returning nil
+// if the source doesn't have any.
+func stateStorage(dst, src api0.AccountStorage) (api0.StateStorage, api0.StateStorage, error) {
+	s, ok := src.(api0.StateStorage)
+	if !ok {
+		return nil, nil, nil
+	}
+	if _, ok := s.(api0.StateListStorage); !ok {
+		return nil, nil, fmt.Errorf("source: state storage does not support listing keys")
+	}
+	d, ok := dst.(api0.StateStorage)
+	if !ok {
+		return nil, nil, fmt.Errorf("destination: account storage does not support state")
+	}
+	return s, d, nil
+}
+
+// banListStorage gets the ban list storage from the account storage,
+// returning nil if the source doesn't have any.
+func banListStorage(dst, src api0.AccountStorage) (api0.BanListStorage, api0.BanListStorage, error) {
+	s, ok := src.(api0.BanListStorage)
+	if !ok {
+		return nil, nil, nil
+	}
+	d, ok := dst.(api0.BanListStorage)
+	if !ok {
+		return nil, nil, fmt.Errorf("destination: account storage does not support ban lists")
+	}
+	return s, d, nil
+}
+
+// banListIDs gets the IDs of the configured ban lists.
+func banListIDs(s api0.StateStorage) ([]string, error) {
+	buf, exists, err := s.GetState("banlists")
+	if err != nil {
+		return nil, fmt.Errorf("get ban lists: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	var ls []api0.BanList
+	if err := json.Unmarshal(buf, &ls); err != nil {
+		return nil, fmt.Errorf("decode ban lists: %w", err)
+	}
+	ids := make([]string, 0, len(ls))
+	for _, l := range ls {
+		ids = append(ids, l.ID)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func sortBanListEntries(es []api0.BanListEntry) {
+	sort.Slice(es, func(i, j int) bool {
+		if es[i].UID != es[j].UID {
+			return es[i].UID < es[j].UID
+		}
+		return es[i].Source < es[j].Source
+	})
+}
+
+func hashAccount(a *api0.Account) [sha256.Size]byte {
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, a.UID)
+	writeString(h, a.Username)
+	writeString(h, a.AuthIP.String())
+	writeString(h, a.AuthToken)
+	writeTime(h, a.AuthTokenExpiry)
+	writeBool(h, a.AuthStaleVerified)
+	h.Write([]byte{byte(a.AuthIPFlags)})
+	binary.Write(h, binary.LittleEndian, uint32(len(a.OtherSessions)))
+	for _, s := range a.OtherSessions {
+		writeString(h, s.IP.String())
+		writeString(h, s.Token)
+		writeTime(h, s.Expiry)
+		writeBool(h, s.StaleVerified)
+		h.Write([]byte{byte(s.IPFlags)})
+	}
+	writeString(h, a.LastServerID)
+	writeTime(h, a.VerifiedAt)
+
+	var b [sha256.Size]byte
+	h.Sum(b[:0])
+	return b
+}
+
+func hashBanListEntry(e api0.BanListEntry) [sha256.Size]byte {
+	h := sha256.New()
+	writeString(h, e.List)
+	binary.Write(h, binary.LittleEndian, e.UID)
+	writeString(h, e.Source)
+	writeString(h, e.Reason)
+	writeBool(h, e.Revoked)
+	writeTime(h, e.Created)
+	writeTime(h, e.Updated)
+
+	var b [sha256.Size]byte
+	h.Sum(b[:0])
+	return b
+}
+
+func writeString(h hash.Hash, s string) {
+	binary.Write(h, binary.LittleEndian, uint32(len(s)))
+	h.Write([]byte(s))
+}
+
+func writeTime(h hash.Hash, t time.Time) {
+	var v int64
+	if !t.IsZero() {
+		v = t.Unix()
+	}
+	binary.Write(h, binary.LittleEndian, v)
+}
+
+func writeBool(h hash.Hash, b bool) {
+	if b {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+}
@@ -0,0 +1,137 @@
+package storagemigrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/memstore"
+)
+
+func TestCopyVerify(t *testing.T) {
+	ctx := context.Background()
+
+	srcAccounts := memstore.NewAccountStore()
+	src := Storage{srcAccounts, memstore.NewPdataStore(true)}
+	dst := Storage{memstore.NewAccountStore(), memstore.NewPdataStore(false)}
+
+	now := time.Now()
+	for uid := uint64(1); uid <= 10; uid++ {
+		if err := src.Accounts.SaveAccount(&api0.Account{
+			UID:             uid,
+			Username:        "user",
+			AuthIP:          netip.MustParseAddr("127.0.0.1"),
+			AuthToken:       "token",
+			AuthTokenExpiry: now.Add(time.Hour),
+			OtherSessions: []api0.AccountSession{{
+				IP:     netip.MustParseAddr("127.0.0.2"),
+				Token:  "token2",
+				Expiry: now,
+			}},
+		}); err != nil {
+			t.Fatalf("save account: %v", err)
+		}
+		if uid%2 == 0 {
+			if _, err := src.Pdata.SetPdata(uid, []byte{byte(uid)}); err != nil {
+				t.Fatalf("set pdata: %v", err)
+			}
+		}
+	}
+	buf, _ := json.Marshal([]api0.BanList{{ID: "test", Name: "Test"}})
+	if err := srcAccounts.SetState("banlists", buf); err != nil {
+		t.Fatalf("set state: %v", err)
+	}
+	if err := srcAccounts.SaveBanListEntry(&api0.BanListEntry{List: "test", UID: 1, Source: "a", Created: now, Updated: now}); err != nil {
+		t.Fatalf("save ban list entry: %v", err)
+	}
+
+	if r, err := Verify(ctx, dst, src); err != nil {
+		t.Fatalf("verify: %v", err)
+	} else if r.OK() || len(r.Mismatched["accounts"]) != 10 || len(r.Mismatched["pdata"]) != 5 {
+		t.Errorf("expected mismatches before copying, got %v", r.Mismatched)
+	}
+
+	st, err := Copy(ctx, dst, src, nil)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if exp := (Stats{Accounts: 10, Pdata: 5, State: 1, BanListEntries: 1}); st != exp {
+		t.Errorf("expected stats %+v, got %+v", exp, st)
+	}
+
+	r, err := Verify(ctx, dst, src)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !r.OK() {
+		t.Errorf("unexpected mismatches after copying: %v", r.Mismatched)
+	}
+	if r.Stats != st {
+		t.Errorf("expected verify stats %+v, got %+v", st, r.Stats)
+	}
+	if rr, _ := Verify(ctx, src, dst); rr.Digest["accounts"] != r.Digest["accounts"] || rr.Digest["pdata"] != r.Digest["pdata"] {
+		t.Errorf("digests differ between source and destination")
+	}
+
+	if _, err := dst.Pdata.SetPdata(2, []byte{0xFF}); err != nil {
+		t.Fatalf("set pdata: %v", err)
+	}
+	if err := dst.Accounts.DeleteAccount(3); err != nil {
+		t.Fatalf("delete account: %v", err)
+	}
+	if r, err := Verify(ctx, dst, src); err != nil {
+		t.Fatalf("verify: %v", err)
+	} else if len(r.Mismatched) != 2 || r.Mismatched["pdata"][0] != "2" || r.Mismatched["accounts"][0] != "3" {
+		t.Errorf("incorrect mismatches %v", r.Mismatched)
+	}
+}
+
+type failingPdataStorage struct {
+	api0.PdataStorage
+}
+
+func (failingPdataStorage) SetPdata(uint64, []byte) (int, error) {
+	return 0, errors.New("failed")
+}
+
+func TestDualWrite(t *testing.T) {
+	p, s := memstore.NewAccountStore(), memstore.NewAccountStore()
+	as := DualWriteAccounts(p, s, nil)
+
+	if err := as.SaveAccount(&api0.Account{UID: 1, Username: "a"}); err != nil {
+		t.Fatalf("save account: %v", err)
+	}
+	if a, _ := s.GetAccount(1); a == nil || a.Username != "a" {
+		t.Errorf("account not written to secondary")
+	}
+	if err := s.SaveAccount(&api0.Account{UID: 1, Username: "b"}); err != nil {
+		t.Fatalf("save account: %v", err)
+	}
+	if a, _ := as.GetAccount(1); a == nil || a.Username != "a" {
+		t.Errorf("account not read from primary")
+	}
+	if err := as.DeleteAccount(1); err != nil {
+		t.Fatalf("delete account: %v", err)
+	}
+	if a, _ := s.GetAccount(1); a != nil {
+		t.Errorf("account not deleted from secondary")
+	}
+
+	var errs []error
+	ps := DualWritePdata(memstore.NewPdataStore(false), failingPdataStorage{memstore.NewPdataStore(false)}, func(err error) {
+		errs = append(errs, err)
+	})
+	if _, err := ps.SetPdata(1, []byte{1}); err != nil {
+		t.Errorf("secondary error returned: %v", err)
+	}
+	if len(errs) != 1 {
+		t.Errorf("secondary error not reported")
+	}
+	if _, exists, _ := ps.GetPdataHash(1); !exists {
+		t.Errorf("pdata not written to primary")
+	}
+}
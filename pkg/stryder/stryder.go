@@ -13,6 +13,8 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+
+	"github.com/r2northstar/atlas/pkg/trace"
 )
 
 var (
@@ -22,10 +24,19 @@ var (
 	ErrInvalidGame           = errors.New("invalid game")
 )
 
+// Base is the base path for the Stryder API.
+var Base = "https://r2-pc.stryder.respawn.com"
+
 // NucleusAuth verifies the provided scoped nucleus token and uid for Titanfall
 // 2 multiplayer.
-func NucleusAuth(ctx context.Context, token string, uid uint64) ([]byte, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://r2-pc.stryder.respawn.com/nucleus-oauth.php?qt=origin-requesttoken&type=server_token&code="+url.PathEscape(token)+"&forceTrial=0&proto=0&json=1&&env=production&userId="+strings.ToUpper(strconv.FormatUint(uid, 16)), nil)
+func NucleusAuth(ctx context.Context, token string, uid uint64) (buf []byte, err error) {
+	ctx, span := trace.StartClient(ctx, "stryder.NucleusAuth")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, Base+"/nucleus-oauth.php?qt=origin-requesttoken&type=server_token&code="+url.PathEscape(token)+"&forceTrial=0&proto=0&json=1&&env=production&userId="+strings.ToUpper(strconv.FormatUint(uid, 16)), nil)
 	if err != nil {
 		return nil, err
 	}
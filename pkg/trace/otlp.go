@@ -0,0 +1,287 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OTLPExporter exports spans in batches to an OpenTelemetry collector using
+// OTLP/HTTP with JSON encoding. Spans are dropped if the queue is full or the
+// request fails. It is safe for concurrent use.
+type OTLPExporter struct {
+	// URL is the traces endpoint (e.g., http://localhost:4318/v1/traces). It
+	// is required.
+	URL string
+
+	// Header contains additional headers to send (e.g., Authorization).
+	Header http.Header
+
+	// Client is the HTTP client to use. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Resource contains attributes describing the process. It should include
+	// service.name.
+	Resource []Attr
+
+	// BatchSize is the maximum number of spans per request. If zero, it
+	// defaults to 512.
+	BatchSize int
+
+	// FlushInterval is the maximum amount of time to wait before sending a
+	// non-empty batch. If zero, it defaults to 5 seconds.
+	FlushInterval time.Duration
+
+	// QueueSize is the maximum number of spans waiting to be sent. If zero,
+	// it defaults to 4096.
+	QueueSize int
+
+	// ErrorHook is called when a batch fails to be sent.
+	ErrorHook func(n int, err error)
+
+	init  sync.Once
+	queue chan SpanData
+
+	metrics struct {
+		spans_total struct {
+			queued  atomic.Uint64
+			sent    atomic.Uint64
+			dropped atomic.Uint64
+			failed  atomic.Uint64
+		}
+	}
+}
+
+var _ Exporter = (*OTLPExporter)(nil)
+
+func (e *OTLPExporter) initQueue() {
+	e.init.Do(func() {
+		n := e.QueueSize
+		if n <= 0 {
+			n = 4096
+		}
+		e.queue = make(chan SpanData, n)
+	})
+}
+
+// ExportSpan queues sd to be exported. It never blocks.
+func (e *OTLPExporter) ExportSpan(sd SpanData) {
+	e.initQueue()
+
+	select {
+	case e.queue <- sd:
+		e.metrics.spans_total.queued.Add(1)
+	default:
+		e.metrics.spans_total.dropped.Add(1)
+	}
+}
+
+// Run sends queued spans until ctx is canceled, then attempts to send any
+// remaining queued spans.
+func (e *OTLPExporter) Run(ctx context.Context) {
+	e.initQueue()
+
+	size := e.BatchSize
+	if size <= 0 {
+		size = 512
+	}
+	interval := e.FlushInterval
+	if interval <= 0 {
+		interval = time.Second * 5
+	}
+
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+
+	var b []SpanData
+	flush := func() {
+		if len(b) != 0 {
+			e.send(b)
+			b = b[:0]
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case sd := <-e.queue:
+					if b = append(b, sd); len(b) >= size {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		case <-tk.C:
+			flush()
+		case sd := <-e.queue:
+			if b = append(b, sd); len(b) >= size {
+				flush()
+			}
+		}
+	}
+}
+
+func (e *OTLPExporter) send(b []SpanData) {
+	err := func() error {
+		buf, err := json.Marshal(e.encode(b))
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+		for k, v := range e.Header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		cl := e.Client
+		if cl == nil {
+			cl = http.DefaultClient
+		}
+
+		resp, err := cl.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("response status %d (%s)", resp.StatusCode, resp.Status)
+		}
+		return nil
+	}()
+	if err != nil {
+		e.metrics.spans_total.failed.Add(uint64(len(b)))
+		if e.ErrorHook != nil {
+			e.ErrorHook(len(b), err)
+		}
+		return
+	}
+	e.metrics.spans_total.sent.Add(uint64(len(b)))
+}
+
+// otlp* are the OTLP/JSON protobuf mappings. IDs are hex-encoded and 64-bit
+// integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes,omitempty"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              Kind           `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            *otlpStatus    `json:"status,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 2 = error
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+func (e *OTLPExporter) encode(b []SpanData) otlpRequest {
+	ss := make([]otlpSpan, 0, len(b))
+	for _, sd := range b {
+		s := otlpSpan{
+			TraceID:           sd.TraceID.String(),
+			SpanID:            sd.SpanID.String(),
+			Name:              sd.Name,
+			Kind:              sd.Kind,
+			StartTimeUnixNano: strconv.FormatInt(sd.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(sd.End.UnixNano(), 10),
+			Attributes:        otlpAttrs(sd.Attrs),
+		}
+		if sd.Parent.IsValid() {
+			s.ParentSpanID = sd.Parent.String()
+		}
+		if sd.Error != "" {
+			s.Status = &otlpStatus{Code: 2, Message: sd.Error}
+		}
+		ss = append(ss, s)
+	}
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: otlpAttrs(e.Resource),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/r2northstar/atlas"},
+				Spans: ss,
+			}},
+		}},
+	}
+}
+
+func otlpAttrs(as []Attr) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(as))
+	for _, a := range as {
+		var v map[string]any
+		switch x := a.Value.(type) {
+		case string:
+			v = map[string]any{"stringValue": x}
+		case bool:
+			v = map[string]any{"boolValue": x}
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(x)}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+		case float64:
+			if math.IsNaN(x) || math.IsInf(x, 0) {
+				continue
+			}
+			v = map[string]any{"doubleValue": x}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(x)}
+		}
+		kvs = append(kvs, otlpKeyValue{a.Key, v})
+	}
+	return kvs
+}
+
+// WritePrometheus writes prometheus text metrics to w.
+func (e *OTLPExporter) WritePrometheus(w io.Writer) {
+	fmt.Fprintln(w, `atlas_trace_spans_total{result="queued"}`, e.metrics.spans_total.queued.Load())
+	fmt.Fprintln(w, `atlas_trace_spans_total{result="sent"}`, e.metrics.spans_total.sent.Load())
+	fmt.Fprintln(w, `atlas_trace_spans_total{result="dropped"}`, e.metrics.spans_total.dropped.Load())
+	fmt.Fprintln(w, `atlas_trace_spans_total{result="failed"}`, e.metrics.spans_total.failed.Load())
+}
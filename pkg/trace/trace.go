@@ -0,0 +1,394 @@
+// Package trace implements lightweight distributed tracing compatible with
+// OpenTelemetry, with W3C Trace Context propagation and an OTLP/HTTP exporter.
+//
+// Spans are only recorded for requests handled by a Tracer. Functions in other
+// packages start child spans from the context, which are no-ops if there isn't
+// a span in it, so they don't need to know whether tracing is enabled.
+package trace
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// IsValid checks if t is not all zeros.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// IsValid checks if s is not all zeros.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext is the propagated part of a span.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+	Remote  bool // extracted from an incoming request
+}
+
+// IsValid checks if the trace and span IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Attr is a span attribute. The value must be a string, bool, int, int64, or
+// float64.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+func String(k, v string) Attr          { return Attr{k, v} }
+func Bool(k string, v bool) Attr       { return Attr{k, v} }
+func Int(k string, v int) Attr         { return Attr{k, int64(v)} }
+func Int64(k string, v int64) Attr     { return Attr{k, v} }
+func Float64(k string, v float64) Attr { return Attr{k, v} }
+
+// SpanData is a finished span.
+type SpanData struct {
+	Name string
+	Kind Kind
+	SpanContext
+	Parent SpanID
+	Start  time.Time
+	End    time.Time
+	Attrs  []Attr
+	Error  string // if not empty, the span status is error
+}
+
+// Exporter receives finished sampled spans. It must be safe for concurrent use
+// and must not block.
+type Exporter interface {
+	ExportSpan(sd SpanData)
+}
+
+// Tracer starts root and server spans. The zero value is usable, but doesn't
+// sample any spans.
+type Tracer struct {
+	// Exporter receives finished spans. If nil, trace context is still
+	// propagated, but spans are not recorded.
+	Exporter Exporter
+
+	// SampleRatio is the fraction of new traces to sample, between 0 and 1.
+	SampleRatio float64
+
+	// TrustRemote uses the sampling decision from the traceparent of incoming
+	// requests. Otherwise, SampleRatio is used so clients can't force traces
+	// to be recorded, but the trace is still continued.
+	TrustRemote bool
+}
+
+// Span is an operation within a trace. A nil Span is a valid no-op span. It is
+// safe for concurrent use.
+type Span struct {
+	t      *Tracer
+	sc     SpanContext
+	parent SpanID
+	name   string
+	kind   Kind
+	start  time.Time
+
+	mu    sync.Mutex
+	attrs []Attr
+	err   string
+	ended bool
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// FromContext gets the current span from ctx, or nil if there isn't one.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// ContextWithSpan returns a copy of ctx with s as the current span.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// Start starts a new span. If ctx contains a span or a remote span context,
+// it will be a child of it. Otherwise, it will be a new trace.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	s := &Span{
+		t:     t,
+		name:  name,
+		kind:  kind,
+		start: time.Now(),
+		attrs: attrs,
+	}
+	if p := FromContext(ctx); p != nil {
+		s.sc = p.sc
+		s.parent = p.sc.SpanID
+	} else if p, ok := ctx.Value(remoteKey{}).(SpanContext); ok && p.IsValid() {
+		s.sc = p
+		s.parent = p.SpanID
+		if !t.TrustRemote {
+			s.sc.Sampled = t.sample(s.sc.TraceID)
+		}
+	} else {
+		s.sc.TraceID = newTraceID()
+		s.sc.Sampled = t.sample(s.sc.TraceID)
+	}
+	s.sc.SpanID = newSpanID()
+	s.sc.Remote = false
+	return ContextWithSpan(ctx, s), s
+}
+
+// Start starts a child span of the span in ctx. If there isn't one, it returns
+// ctx and a nil span.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	return startChild(ctx, name, KindInternal, attrs)
+}
+
+// StartClient is like Start, but for a call to an external service.
+func StartClient(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	return startChild(ctx, name, KindClient, attrs)
+}
+
+func startChild(ctx context.Context, name string, kind Kind, attrs []Attr) (context.Context, *Span) {
+	if p := FromContext(ctx); p != nil {
+		return p.t.Start(ctx, name, kind, attrs...)
+	}
+	return ctx, nil
+}
+
+// Context gets the span context of s.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// Recording returns true if the span will be exported.
+func (s *Span) Recording() bool {
+	return s != nil && s.sc.Sampled && s.t.Exporter != nil
+}
+
+// SetAttr sets attributes on the span.
+func (s *Span) SetAttr(attrs ...Attr) {
+	if !s.Recording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// SetError marks the span as failed if err is not nil.
+func (s *Span) SetError(err error) {
+	if err == nil || !s.Recording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err.Error()
+}
+
+// End finishes the span. Subsequent calls do nothing.
+func (s *Span) End() {
+	if !s.Recording() {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	sd := SpanData{
+		Name:        s.name,
+		Kind:        s.kind,
+		SpanContext: s.sc,
+		Parent:      s.parent,
+		Start:       s.start,
+		End:         time.Now(),
+		Attrs:       s.attrs,
+		Error:       s.err,
+	}
+	s.mu.Unlock()
+
+	s.t.Exporter.ExportSpan(sd)
+}
+
+// Inject sets the traceparent header for the span in ctx, if any.
+func Inject(ctx context.Context, h http.Header) {
+	if sc := FromContext(ctx).Context(); sc.IsValid() {
+		h.Set("traceparent", formatTraceparent(sc))
+	}
+}
+
+// Extract returns a copy of ctx with the remote span context from the
+// traceparent header, if valid.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	if sc, ok := parseTraceparent(h.Get("traceparent")); ok {
+		return context.WithValue(ctx, remoteKey{}, sc)
+	}
+	return ctx
+}
+
+func formatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+func parseTraceparent(v string) (SpanContext, bool) {
+	var sc SpanContext
+
+	// version-traceid-spanid-flags (future versions may append fields)
+	ps := strings.Split(strings.TrimSpace(v), "-")
+	if len(ps) < 4 || len(ps[0]) != 2 || ps[0] == "ff" || (ps[0] == "00" && len(ps) != 4) {
+		return sc, false
+	}
+	if len(ps[1]) != 32 || len(ps[2]) != 16 || len(ps[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(ps[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(ps[2])); err != nil {
+		return sc, false
+	}
+	var f [1]byte
+	if _, err := hex.Decode(f[:], []byte(ps[3])); err != nil {
+		return sc, false
+	}
+	sc.Sampled = f[0]&1 != 0
+	sc.Remote = true
+	return sc, sc.IsValid()
+}
+
+func newTraceID() (t TraceID) {
+	if _, err := rand.Read(t[:]); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func newSpanID() (s SpanID) {
+	if _, err := rand.Read(s[:]); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// sample makes a deterministic sampling decision for id based on its random
+// lower bits, like the OpenTelemetry TraceIDRatioBased sampler.
+func (t *Tracer) sample(id TraceID) bool {
+	switch {
+	case t.SampleRatio >= 1:
+		return true
+	case t.SampleRatio <= 0:
+		return false
+	default:
+		return binary.BigEndian.Uint64(id[8:])>>1 < uint64(t.SampleRatio*(1<<63))
+	}
+}
+
+// Middleware returns a handler which starts a server span for each request,
+// continuing the caller's trace if the request has a traceparent header. The
+// traceparent of the span is set as a response header.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, s := t.Start(Extract(r.Context(), r.Header), r.Method+" "+r.URL.Path, KindServer,
+			String("http.request.method", r.Method),
+			String("url.path", r.URL.Path),
+			String("client.address", r.RemoteAddr),
+			String("user_agent.original", r.UserAgent()),
+		)
+		defer s.End()
+
+		w.Header().Set("traceparent", formatTraceparent(s.Context()))
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		s.SetAttr(Int("http.response.status_code", sw.status))
+		if sw.status >= 500 {
+			s.SetError(statusError(sw.status))
+		}
+	})
+}
+
+type statusError int
+
+func (err statusError) Error() string {
+	return http.StatusText(int(err))
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		if w.status == 0 {
+			w.status = http.StatusSwitchingProtocols
+		}
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijack not supported")
+}
+
+// Unwrap allows http.ResponseController to access the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,171 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *memoryExporter) ExportSpan(sd SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, sd)
+}
+
+func TestTraceparent(t *testing.T) {
+	for _, tc := range []struct {
+		v  string
+		ok bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false},
+		{"", false},
+	} {
+		sc, ok := parseTraceparent(tc.v)
+		if ok != tc.ok {
+			t.Errorf("parse %q: expected ok=%t, got %t", tc.v, tc.ok, ok)
+		}
+		if ok && tc.v[:2] == "00" && formatTraceparent(sc) != tc.v {
+			t.Errorf("parse %q: incorrect round-trip %q", tc.v, formatTraceparent(sc))
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var e memoryExporter
+	tr := &Tracer{Exporter: &e, SampleRatio: 1}
+
+	var outgoing string
+	h := tr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, s := StartClient(r.Context(), "dependency", String("a", "b"))
+		hdr := http.Header{}
+		Inject(ctx, hdr)
+		outgoing = hdr.Get("traceparent")
+		s.SetError(errors.New("failed"))
+		s.End()
+		s.End() // no-op
+
+		_, s = Start(context.Background(), "no parent")
+		if s != nil {
+			t.Errorf("expected nil span without a parent")
+		}
+		s.SetAttr(Int("x", 1)) // nil spans are no-ops
+		s.End()
+
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/client/origin_auth", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if len(e.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(e.spans))
+	}
+	dep, srv := e.spans[0], e.spans[1]
+	if srv.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || srv.Parent.String() != "00f067aa0ba902b7" || srv.Kind != KindServer || srv.Name != "GET /client/origin_auth" {
+		t.Errorf("incorrect server span %+v", srv)
+	}
+	if dep.TraceID != srv.TraceID || dep.Parent != srv.SpanID || dep.Kind != KindClient || dep.Error != "failed" {
+		t.Errorf("incorrect dependency span %+v", dep)
+	}
+	if exp := "00-" + dep.TraceID.String() + "-" + dep.SpanID.String() + "-01"; outgoing != exp {
+		t.Errorf("expected outgoing traceparent %q, got %q", exp, outgoing)
+	}
+	if v := w.Header().Get("traceparent"); !strings.Contains(v, srv.SpanID.String()) {
+		t.Errorf("incorrect response traceparent %q", v)
+	}
+	var status int64
+	for _, a := range srv.Attrs {
+		if a.Key == "http.response.status_code" {
+			status, _ = a.Value.(int64)
+		}
+	}
+	if status != http.StatusTeapot {
+		t.Errorf("expected status attribute %d, got %d", http.StatusTeapot, status)
+	}
+
+	// remote sampling decisions are honored if trusted
+	e.spans = nil
+	tr.SampleRatio, tr.TrustRemote = 1, true
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if len(e.spans) != 0 {
+		t.Errorf("expected unsampled remote trace not to be recorded, got %d spans", len(e.spans))
+	}
+}
+
+func TestSample(t *testing.T) {
+	tr := &Tracer{SampleRatio: 0.25}
+	var n int
+	for i := 0; i < 10000; i++ {
+		if tr.sample(newTraceID()) {
+			n++
+		}
+	}
+	if n < 2000 || n > 3000 {
+		t.Errorf("expected about 2500 sampled traces, got %d", n)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("Content-Type"); v != "application/json" {
+			t.Errorf("incorrect content type %q", v)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid body: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	e := &OTLPExporter{
+		URL:      srv.URL,
+		Resource: []Attr{String("service.name", "atlas")},
+		ErrorHook: func(n int, err error) {
+			t.Errorf("unexpected error sending %d spans: %v", n, err)
+		},
+	}
+	tr := &Tracer{Exporter: e, SampleRatio: 1}
+
+	ctx, root := tr.Start(context.Background(), "root", KindServer, Int("n", 1), Bool("b", true))
+	_, child := Start(ctx, "child")
+	child.SetError(errors.New("failed"))
+	child.End()
+	root.End()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e.Run(ctx)
+
+	buf, _ := json.Marshal(body)
+	for _, x := range []string{
+		`"key":"service.name","value":{"stringValue":"atlas"}`,
+		`"traceId":"` + root.Context().TraceID.String() + `"`,
+		`"parentSpanId":"` + root.Context().SpanID.String() + `"`,
+		`"key":"n","value":{"intValue":"1"}`,
+		`"status":{"code":2,"message":"failed"}`,
+		`"kind":2`,
+	} {
+		if !strings.Contains(string(buf), x) {
+			t.Errorf("expected %s in request body: %s", x, buf)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+// Package websocket implements a minimal RFC 6455 WebSocket server and client
+// for exchanging small messages (e.g., JSON notifications).
+//
+// Extensions and subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes.
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// MaxMessageSize is the maximum size of a received message.
+const MaxMessageSize = 1 << 20
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	ErrNotWebSocket = errors.New("not a websocket upgrade request")
+	ErrProtocol     = errors.New("websocket protocol error")
+	ErrTooLarge     = errors.New("websocket message too large")
+)
+
+// Conn is a WebSocket connection. Reads must not be done concurrently, but
+// writes are safe for concurrent use.
+type Conn struct {
+	nc     net.Conn
+	br     *bufio.Reader
+	client bool // if true, frames are masked
+
+	wmu    sync.Mutex
+	closed bool // if true, a close frame has been sent
+}
+
+// IsUpgrade checks whether r is a WebSocket upgrade request.
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade upgrades the HTTP connection for r to a WebSocket. If an error is
+// returned, a response has already been written. The request must be a GET
+// request, and w (or any ResponseWriter it wraps via Unwrap) must implement
+// http.Hijacker.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, ErrNotWebSocket
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("%w: unsupported version", ErrProtocol)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		http.Error(w, "invalid websocket key", http.StatusBadRequest)
+		return nil, fmt.Errorf("%w: invalid key", ErrProtocol)
+	}
+
+	hj, ok := hijacker(w)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response writer does not support hijacking")
+	}
+	nc, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("hijack connection: %w", err)
+	}
+	nc.SetDeadline(time.Time{}) // clear the http server timeouts
+
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	brw.WriteString("Upgrade: websocket\r\n")
+	brw.WriteString("Connection: Upgrade\r\n")
+	brw.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("write handshake: %w", err)
+	}
+	return &Conn{nc: nc, br: brw.Reader}, nil
+}
+
+// Dial opens a client WebSocket connection to u, which must be a ws, wss,
+// http, or https URL. Additional request headers may be provided in hdr.
+func Dial(ctx context.Context, u string, hdr http.Header) (*Conn, error) {
+	return DialClient(ctx, http.DefaultClient, u, hdr)
+}
+
+// DialClient is like Dial, but uses cl to make the request (e.g., for custom
+// TLS configuration). The client's transport must not use HTTP/2.
+func DialClient(ctx context.Context, cl *http.Client, u string, hdr http.Header) (*Conn, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	switch pu.Scheme {
+	case "ws":
+		pu.Scheme = "http"
+	case "wss":
+		pu.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", pu.Scheme)
+	}
+
+	var kb [16]byte
+	if _, err := rand.Read(kb[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(kb[:])
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pu.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	// note: net/http returns a writable body for 101 responses
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: response status %d", ErrNotWebSocket, resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: invalid accept key", ErrProtocol)
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, errors.New("response body is not writable")
+	}
+	return &Conn{nc: rwcConn{rwc}, br: bufio.NewReader(rwc), client: true}, nil
+}
+
+// Read reads the next text or binary message. Pings are answered
+// automatically. If the peer closes the connection, io.EOF is returned.
+func (c *Conn) Read() (op byte, msg []byte, err error) {
+	for {
+		fin, fop, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch fop {
+		case OpPing:
+			if err := c.Write(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			c.writeClose(payload)
+			c.nc.Close()
+			return 0, nil, io.EOF
+		case OpText, OpBinary:
+			if op != 0 {
+				return 0, nil, fmt.Errorf("%w: expected continuation frame", ErrProtocol)
+			}
+			op = fop
+		case OpContinuation:
+			if op == 0 {
+				return 0, nil, fmt.Errorf("%w: unexpected continuation frame", ErrProtocol)
+			}
+		default:
+			return 0, nil, fmt.Errorf("%w: unknown opcode %#x", ErrProtocol, fop)
+		}
+		if len(msg)+len(payload) > MaxMessageSize {
+			return 0, nil, ErrTooLarge
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.br, hdr[:]); err != nil {
+		return
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0F
+	if hdr[0]&0x70 != 0 {
+		err = fmt.Errorf("%w: reserved bits set", ErrProtocol)
+		return
+	}
+	if masked := hdr[1]&0x80 != 0; masked == c.client {
+		err = fmt.Errorf("%w: incorrect masking", ErrProtocol)
+		return
+	}
+
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if op >= OpClose && (n > 125 || !fin) {
+		err = fmt.Errorf("%w: invalid control frame", ErrProtocol)
+		return
+	}
+	if n > MaxMessageSize {
+		err = ErrTooLarge
+		return
+	}
+
+	var mask [4]byte
+	if !c.client {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if !c.client {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// Write writes a single-frame message.
+func (c *Conn) Write(op byte, msg []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+	if op == OpClose {
+		c.closed = true
+	}
+	return c.writeFrame(op, msg)
+}
+
+// WriteText writes a text message.
+func (c *Conn) WriteText(msg []byte) error {
+	return c.Write(OpText, msg)
+}
+
+func (c *Conn) writeFrame(op byte, msg []byte) error {
+	b := make([]byte, 0, 14+len(msg))
+	b = append(b, 0x80|op)
+
+	var m byte
+	if c.client {
+		m = 0x80
+	}
+	switch n := len(msg); {
+	case n <= 125:
+		b = append(b, m|byte(n))
+	case n <= 0xFFFF:
+		b = append(b, m|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, m|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		b = append(b, mask[:]...)
+		for i, x := range msg {
+			b = append(b, x^mask[i%4])
+		}
+	} else {
+		b = append(b, msg...)
+	}
+	_, err := c.nc.Write(b)
+	return err
+}
+
+func (c *Conn) writeClose(payload []byte) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		if len(payload) >= 2 {
+			payload = payload[:2] // echo the status code
+		}
+		c.writeFrame(OpClose, payload)
+	}
+}
+
+// Close sends a normal closure frame if one hasn't been sent already, then
+// closes the underlying connection.
+func (c *Conn) Close() error {
+	c.writeClose([]byte{0x03, 0xE8}) // 1000
+	return c.nc.Close()
+}
+
+// SetDeadline sets the read and write deadlines on the underlying connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.nc.SetDeadline(t)
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func hijacker(w http.ResponseWriter) (http.Hijacker, bool) {
+	for {
+		if hj, ok := w.(http.Hijacker); ok {
+			return hj, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil, false
+		}
+		w = u.Unwrap()
+	}
+}
+
+func headerContains(h http.Header, k, v string) bool {
+	for _, x := range h.Values(k) {
+		for _, t := range strings.Split(x, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rwcConn adapts the body of a client upgrade response to a net.Conn.
+type rwcConn struct {
+	io.ReadWriteCloser
+}
+
+var errNoDeadline = errors.New("deadlines not supported for client connections")
+
+func (rwcConn) LocalAddr() net.Addr                { return nil }
+func (rwcConn) RemoteAddr() net.Addr               { return nil }
+func (rwcConn) SetDeadline(t time.Time) error      { return errNoDeadline }
+func (rwcConn) SetReadDeadline(t time.Time) error  { return errNoDeadline }
+func (rwcConn) SetWriteDeadline(t time.Time) error { return errNoDeadline }
@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// from RFC 6455 section 1.3
+	if k := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); k != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("incorrect accept key %q", k)
+	}
+}
+
+func TestConn(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			op, msg, err := c.Read()
+			if err != nil {
+				return
+			}
+			if err := c.Write(op, msg); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	if resp, err := http.Get(srv.URL); err != nil {
+		t.Fatalf("get: %v", err)
+	} else if resp.Body.Close(); resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("non-upgrade request: expected status %d, got %d", http.StatusUpgradeRequired, resp.StatusCode)
+	}
+
+	c, err := Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+
+	for _, msg := range [][]byte{
+		[]byte("hello"),
+		bytes.Repeat([]byte{'a'}, 126),
+		bytes.Repeat([]byte{'b'}, 70000),
+		{},
+	} {
+		if err := c.WriteText(msg); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		op, res, err := c.Read()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if op != OpText || !bytes.Equal(res, msg) {
+			t.Errorf("incorrect echo for %d byte message: op %#x, %d bytes", len(msg), op, len(res))
+		}
+	}
+
+	if err := c.Write(OpPing, []byte("ping")); err != nil {
+		t.Fatalf("write ping: %v", err)
+	}
+	if err := c.WriteText([]byte("after ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, res, err := c.Read(); err != nil || string(res) != "after ping" {
+		t.Errorf("expected pong to be skipped, got %q (err: %v)", res, err)
+	}
+
+	if err := c.Write(OpClose, []byte{0x03, 0xE8}); err != nil {
+		t.Fatalf("write close: %v", err)
+	}
+	if _, _, err := c.Read(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF after close, got %v", err)
+	}
+}
@@ -0,0 +1,465 @@
+// Package writebehind buffers pdata writes to smooth out storage load.
+package writebehind
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+)
+
+// ErrClosed is returned when writing to a closed PdataStorage.
+var ErrClosed = errors.New("write-behind buffer closed")
+
+// journalDelete is the length used for delete records in the journal.
+const journalDelete = ^uint32(0)
+
+// Config configures a PdataStorage.
+type Config struct {
+	// Journal is the path prefix for journal segments. If provided, every
+	// buffered write is appended to the journal and synced to disk before
+	// returning, and writes not yet flushed are recovered from it on the next
+	// start. If empty, buffered writes are lost if the process crashes.
+	Journal string
+
+	// MaxPending is the maximum number of UIDs with buffered writes. Once
+	// reached, writes for other UIDs block while the buffer is flushed. If
+	// zero, it defaults to 1000.
+	MaxPending int
+
+	// Interval is how often to flush buffered writes. If zero, it defaults
+	// to one second.
+	Interval time.Duration
+
+	// ErrorHook is called when buffered writes fail to be flushed. Failed
+	// writes are retried on the next flush.
+	ErrorHook func(err error)
+}
+
+// PdataStorage wraps pdata storage, buffering writes and flushing them in the
+// background. Writes to the same UID between flushes are coalesced, and reads
+// include buffered writes. Optional interfaces other than [io.Closer]
+// implemented by the wrapped storage are not exposed. It is safe for
+// concurrent use.
+type PdataStorage struct {
+	s   api0.PdataStorage
+	cfg Config
+
+	flushMu sync.Mutex // held while flushing or deleting
+
+	jmu   sync.Mutex // held while writing to the journal
+	jf    *os.File
+	jseq  uint64
+	stale []string // journal segments to remove after the next flush
+
+	mu       sync.Mutex
+	pending  map[uint64][]byte
+	flushing map[uint64][]byte
+	closed   bool
+
+	metrics struct {
+		writes_total struct {
+			buffered  atomic.Uint64
+			coalesced atomic.Uint64
+		}
+		flushed_total struct {
+			success atomic.Uint64
+			error   atomic.Uint64
+		}
+		backpressure_total atomic.Uint64
+		journal_errors     atomic.Uint64
+		recovered_total    atomic.Uint64
+	}
+}
+
+var _ api0.PdataStorage = (*PdataStorage)(nil)
+
+// New wraps s with a write-behind buffer. If a journal is configured, writes
+// left over from a previous run are recovered from it and flushed with the
+// next batch.
+func New(s api0.PdataStorage, cfg Config) (*PdataStorage, error) {
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = 1000
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	b := &PdataStorage{
+		s:       s,
+		cfg:     cfg,
+		pending: map[uint64][]byte{},
+	}
+
+	if cfg.Journal != "" {
+		segs, err := journalSegments(cfg.Journal)
+		if err != nil {
+			return nil, fmt.Errorf("list journal segments: %w", err)
+		}
+		for _, seg := range segs {
+			if err := b.replay(seg.path); err != nil {
+				return nil, fmt.Errorf("replay journal segment %q: %w", seg.path, err)
+			}
+			b.stale = append(b.stale, seg.path)
+			b.jseq = seg.seq
+		}
+		if err := b.rotate(); err != nil {
+			return nil, err
+		}
+		b.metrics.recovered_total.Store(uint64(len(b.pending)))
+	}
+	return b, nil
+}
+
+type journalSegment struct {
+	path string
+	seq  uint64
+}
+
+func journalSegments(prefix string) ([]journalSegment, error) {
+	ms, err := filepath.Glob(prefix + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var segs []journalSegment
+	for _, m := range ms {
+		if seq, err := strconv.ParseUint(strings.TrimPrefix(m, prefix+"."), 10, 64); err == nil {
+			segs = append(segs, journalSegment{m, seq})
+		}
+	}
+	sort.Slice(segs, func(i, j int) bool {
+		return segs[i].seq < segs[j].seq
+	})
+	return segs, nil
+}
+
+// replay applies the records in a journal segment. Deletes are applied to the
+// underlying storage immediately. A truncated or corrupt record is assumed to
+// be the end of the segment.
+func (b *PdataStorage) replay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		var hdr [12]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil
+		}
+		uid, n := binary.LittleEndian.Uint64(hdr[:]), binary.LittleEndian.Uint32(hdr[8:])
+
+		var buf []byte
+		if n != journalDelete {
+			if n > 1<<24 {
+				return nil
+			}
+			buf = make([]byte, n)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return nil
+			}
+		}
+		var sum [4]byte
+		if _, err := io.ReadFull(r, sum[:]); err != nil {
+			return nil
+		}
+		if binary.LittleEndian.Uint32(sum[:]) != crc32.ChecksumIEEE(append(hdr[:], buf...)) {
+			return nil
+		}
+
+		if n == journalDelete {
+			delete(b.pending, uid)
+			if err := b.s.DeletePdata(uid); err != nil {
+				return fmt.Errorf("delete pdata %d: %w", uid, err)
+			}
+		} else {
+			b.pending[uid] = buf
+		}
+	}
+}
+
+// rotate starts a new journal segment, marking the current one as stale. The
+// caller must hold jmu (or be the constructor).
+func (b *PdataStorage) rotate() error {
+	f, err := os.OpenFile(b.cfg.Journal+"."+strconv.FormatUint(b.jseq+1, 10), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("create journal segment: %w", err)
+	}
+	if b.jf != nil {
+		b.stale = append(b.stale, b.jf.Name())
+		b.jf.Close()
+	}
+	b.jf = f
+	b.jseq++
+	return nil
+}
+
+// appendJournal writes a record to the journal and syncs it. If buf is nil,
+// it is a delete record. The caller must hold jmu.
+func (b *PdataStorage) appendJournal(uid uint64, buf []byte) error {
+	if b.jf == nil {
+		return nil
+	}
+	rec := make([]byte, 12, 12+len(buf)+4)
+	binary.LittleEndian.PutUint64(rec, uid)
+	if buf == nil {
+		binary.LittleEndian.PutUint32(rec[8:], journalDelete)
+	} else {
+		binary.LittleEndian.PutUint32(rec[8:], uint32(len(buf)))
+		rec = append(rec, buf...)
+	}
+	rec = binary.LittleEndian.AppendUint32(rec, crc32.ChecksumIEEE(rec))
+	if _, err := b.jf.Write(rec); err != nil {
+		b.metrics.journal_errors.Add(1)
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := b.jf.Sync(); err != nil {
+		b.metrics.journal_errors.Add(1)
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	return nil
+}
+
+// buffered gets the buffered pdata for uid, if any.
+func (b *PdataStorage) buffered(uid uint64) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if buf, ok := b.pending[uid]; ok {
+		return buf, true
+	}
+	if buf, ok := b.flushing[uid]; ok {
+		return buf, true
+	}
+	return nil, false
+}
+
+func (b *PdataStorage) GetPdataHash(uid uint64) ([sha256.Size]byte, bool, error) {
+	if buf, ok := b.buffered(uid); ok {
+		return sha256.Sum256(buf), true, nil
+	}
+	return b.s.GetPdataHash(uid)
+}
+
+func (b *PdataStorage) GetPdataCached(uid uint64, sha [sha256.Size]byte) ([]byte, bool, error) {
+	if buf, ok := b.buffered(uid); ok {
+		if sha != [sha256.Size]byte{} && sha == sha256.Sum256(buf) {
+			return nil, true, nil
+		}
+		return append([]byte{}, buf...), true, nil
+	}
+	return b.s.GetPdataCached(uid, sha)
+}
+
+// SetPdata buffers pdata for uid. If the buffer is full, it is flushed first.
+// The returned size is the uncompressed size.
+func (b *PdataStorage) SetPdata(uid uint64, buf []byte) (int, error) {
+	buf = append([]byte{}, buf...)
+
+	for {
+		b.mu.Lock()
+		closed := b.closed
+		_, exists := b.pending[uid]
+		full := len(b.pending) >= b.cfg.MaxPending && !exists
+		b.mu.Unlock()
+
+		if closed {
+			return 0, ErrClosed
+		}
+		if !full {
+			break
+		}
+		b.metrics.backpressure_total.Add(1)
+		if err := b.Flush(); err != nil {
+			return 0, fmt.Errorf("buffer full: %w", err)
+		}
+	}
+
+	b.jmu.Lock()
+	defer b.jmu.Unlock()
+
+	if err := b.appendJournal(uid, buf); err != nil {
+		return 0, err
+	}
+
+	b.mu.Lock()
+	if _, ok := b.pending[uid]; ok {
+		b.metrics.writes_total.coalesced.Add(1)
+	} else {
+		b.metrics.writes_total.buffered.Add(1)
+	}
+	b.pending[uid] = buf
+	b.mu.Unlock()
+
+	return len(buf), nil
+}
+
+// DeletePdata removes any buffered pdata for uid and deletes it from the
+// underlying storage. It waits for any in-progress flush.
+func (b *PdataStorage) DeletePdata(uid uint64) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.jmu.Lock()
+	if err := b.appendJournal(uid, nil); err != nil {
+		b.jmu.Unlock()
+		return err
+	}
+	b.mu.Lock()
+	delete(b.pending, uid)
+	b.mu.Unlock()
+	b.jmu.Unlock()
+
+	return b.s.DeletePdata(uid)
+}
+
+// Flush writes all buffered pdata to the underlying storage. Writes which fail
+// are kept for the next flush, and the first error is returned.
+func (b *PdataStorage) Flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.jmu.Lock()
+	b.mu.Lock()
+	b.flushing, b.pending = b.pending, map[uint64][]byte{}
+	batch := b.flushing
+	b.mu.Unlock()
+
+	var rerr error
+	if b.jf != nil && len(batch) != 0 {
+		if err := b.rotate(); err != nil {
+			rerr = err // keep appending to the current segment
+		}
+	}
+	b.jmu.Unlock()
+
+	failed := map[uint64][]byte{}
+	var ferr error
+	for uid, buf := range batch {
+		if _, err := b.s.SetPdata(uid, buf); err != nil {
+			b.metrics.flushed_total.error.Add(1)
+			failed[uid] = buf
+			if ferr == nil {
+				ferr = fmt.Errorf("set pdata %d: %w", uid, err)
+			}
+		} else {
+			b.metrics.flushed_total.success.Add(1)
+		}
+	}
+
+	b.jmu.Lock()
+	defer b.jmu.Unlock()
+
+	b.mu.Lock()
+	var jerr error
+	for uid, buf := range failed {
+		if _, ok := b.pending[uid]; ok {
+			continue // superseded by a newer write
+		}
+		if jerr == nil && b.jf != nil {
+			jerr = b.appendJournal(uid, buf)
+		}
+		b.pending[uid] = buf
+	}
+	b.flushing = nil
+	b.mu.Unlock()
+
+	// the stale segments only contain writes which are now either in the
+	// underlying storage or the current segment
+	if jerr == nil && rerr == nil {
+		for _, p := range b.stale {
+			os.Remove(p)
+		}
+		b.stale = nil
+	}
+
+	if ferr != nil {
+		return ferr
+	}
+	if jerr != nil {
+		return jerr
+	}
+	return rerr
+}
+
+// Run flushes buffered writes at the configured interval until ctx is
+// canceled. Close must still be called to flush
+// the remaining writes.
+func (b *PdataStorage) Run(ctx context.Context) {
+	tk := time.NewTicker(b.cfg.Interval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+		if err := b.Flush(); err != nil && b.cfg.ErrorHook != nil {
+			b.cfg.ErrorHook(err)
+		}
+	}
+}
+
+// Close flushes buffered writes, syncs and closes the journal, then closes the
+// underlying storage. If the flush fails, the remaining writes are kept in the
+// journal (if configured) for the next start.
+func (b *PdataStorage) Close() error {
+	err := b.Flush()
+
+	b.mu.Lock()
+	b.closed = true
+	empty := len(b.pending) == 0
+	b.mu.Unlock()
+
+	b.jmu.Lock()
+	if b.jf != nil {
+		if e := b.jf.Sync(); err == nil {
+			err = e
+		}
+		b.jf.Close()
+		if empty && err == nil {
+			os.Remove(b.jf.Name())
+		}
+		b.jf = nil
+	}
+	b.jmu.Unlock()
+
+	if c, ok := b.s.(io.Closer); ok {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// WritePrometheus writes prometheus text metrics to w.
+func (b *PdataStorage) WritePrometheus(w io.Writer) {
+	b.mu.Lock()
+	pending := len(b.pending) + len(b.flushing)
+	b.mu.Unlock()
+
+	fmt.Fprintln(w, `atlas_writebehind_pdata_writes_total{result="buffered"}`, b.metrics.writes_total.buffered.Load())
+	fmt.Fprintln(w, `atlas_writebehind_pdata_writes_total{result="coalesced"}`, b.metrics.writes_total.coalesced.Load())
+	fmt.Fprintln(w, `atlas_writebehind_pdata_flushed_total{result="success"}`, b.metrics.flushed_total.success.Load())
+	fmt.Fprintln(w, `atlas_writebehind_pdata_flushed_total{result="error"}`, b.metrics.flushed_total.error.Load())
+	fmt.Fprintln(w, `atlas_writebehind_pdata_backpressure_total`, b.metrics.backpressure_total.Load())
+	fmt.Fprintln(w, `atlas_writebehind_pdata_journal_errors_total`, b.metrics.journal_errors.Load())
+	fmt.Fprintln(w, `atlas_writebehind_pdata_recovered_total`, b.metrics.recovered_total.Load())
+	fmt.Fprintln(w, `atlas_writebehind_pdata_pending`, pending)
+}
@@ -0,0 +1,211 @@
+package writebehind
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r2northstar/atlas/pkg/api/api0"
+	"github.com/r2northstar/atlas/pkg/api/api0/api0testutil"
+	"github.com/r2northstar/atlas/pkg/memstore"
+)
+
+func TestPdataStorage(t *testing.T) {
+	t.Run("Memory", func(t *testing.T) {
+		b, err := New(memstore.NewPdataStore(false), Config{})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		defer b.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go b.Run(ctx)
+		api0testutil.TestPdataStorage(t, b)
+	})
+	t.Run("Journal", func(t *testing.T) {
+		b, err := New(memstore.NewPdataStore(true), Config{
+			Journal: filepath.Join(t.TempDir(), "journal"),
+		})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		defer b.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go b.Run(ctx)
+		api0testutil.TestPdataStorage(t, b)
+	})
+}
+
+func TestFlush(t *testing.T) {
+	s := memstore.NewPdataStore(false)
+	b, err := New(s, Config{})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := b.SetPdata(1, []byte{byte(i)}); err != nil {
+			t.Fatalf("set pdata: %v", err)
+		}
+	}
+	if _, exists, _ := s.GetPdataHash(1); exists {
+		t.Errorf("pdata written before flush")
+	}
+	if buf, _, _ := b.GetPdataCached(1, [32]byte{}); len(buf) != 1 || buf[0] != 2 {
+		t.Errorf("buffered write not visible: %v", buf)
+	}
+	if n := b.metrics.writes_total.coalesced.Load(); n != 2 {
+		t.Errorf("expected 2 coalesced writes, got %d", n)
+	}
+
+	if err := b.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if buf, _, _ := s.GetPdataCached(1, [32]byte{}); len(buf) != 1 || buf[0] != 2 {
+		t.Errorf("pdata not flushed: %v", buf)
+	}
+	if n := b.metrics.flushed_total.success.Load(); n != 1 {
+		t.Errorf("expected 1 flushed write, got %d", n)
+	}
+}
+
+type flakyPdataStorage struct {
+	api0.PdataStorage
+	fail atomic.Bool
+}
+
+func (s *flakyPdataStorage) SetPdata(uid uint64, buf []byte) (int, error) {
+	if s.fail.Load() {
+		return 0, errors.New("failed")
+	}
+	return s.PdataStorage.SetPdata(uid, buf)
+}
+
+func TestFlushRetry(t *testing.T) {
+	s := &flakyPdataStorage{PdataStorage: memstore.NewPdataStore(false)}
+	b, err := New(s, Config{Journal: filepath.Join(t.TempDir(), "journal")})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer b.Close()
+
+	s.fail.Store(true)
+	if _, err := b.SetPdata(1, []byte{1}); err != nil {
+		t.Fatalf("set pdata: %v", err)
+	}
+	if err := b.Flush(); err == nil {
+		t.Fatalf("expected flush error")
+	}
+	if _, exists, _ := b.GetPdataHash(1); !exists {
+		t.Errorf("failed write not kept")
+	}
+
+	s.fail.Store(false)
+	if err := b.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if _, exists, _ := s.GetPdataHash(1); !exists {
+		t.Errorf("failed write not retried")
+	}
+}
+
+func TestJournalRecovery(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "journal")
+
+	b, err := New(memstore.NewPdataStore(false), Config{Journal: journal})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	for uid := uint64(1); uid <= 3; uid++ {
+		if _, err := b.SetPdata(uid, []byte{byte(uid)}); err != nil {
+			t.Fatalf("set pdata: %v", err)
+		}
+	}
+	if err := b.DeletePdata(2); err != nil {
+		t.Fatalf("delete pdata: %v", err)
+	}
+	b.jf.Close() // simulate a crash
+
+	s := memstore.NewPdataStore(false)
+	if _, err := s.SetPdata(2, []byte{2}); err != nil {
+		t.Fatalf("set pdata: %v", err)
+	}
+	b, err = New(s, Config{Journal: journal})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	for uid, exp := range map[uint64]bool{1: true, 2: false, 3: true} {
+		if _, exists, _ := s.GetPdataHash(uid); exists != exp {
+			t.Errorf("uid %d: expected exists=%t after recovery", uid, exp)
+		}
+	}
+	if segs, _ := journalSegments(journal); len(segs) != 0 {
+		t.Errorf("expected journal segments to be removed, got %v", segs)
+	}
+}
+
+type slowPdataStorage struct {
+	api0.PdataStorage
+	release chan struct{}
+}
+
+func (s *slowPdataStorage) SetPdata(uid uint64, buf []byte) (int, error) {
+	<-s.release
+	return s.PdataStorage.SetPdata(uid, buf)
+}
+
+func TestBackpressure(t *testing.T) {
+	s := &slowPdataStorage{memstore.NewPdataStore(false), make(chan struct{})}
+	b, err := New(s, Config{MaxPending: 1})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	if _, err := b.SetPdata(1, []byte{1}); err != nil {
+		t.Fatalf("set pdata: %v", err)
+	}
+	if _, err := b.SetPdata(1, []byte{2}); err != nil {
+		t.Fatalf("set pdata: %v", err) // same uid shouldn't block
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	done := make(chan struct{})
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		if _, err := b.SetPdata(2, []byte{2}); err != nil {
+			t.Errorf("set pdata: %v", err)
+		}
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("write didn't block while buffer full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := b.metrics.backpressure_total.Load(); n == 0 {
+		t.Errorf("backpressure not counted")
+	}
+
+	close(s.release)
+	go b.Flush()
+	wg.Wait()
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	for uid := uint64(1); uid <= 2; uid++ {
+		if _, exists, _ := s.GetPdataHash(uid); !exists {
+			t.Errorf("uid %d not flushed", uid)
+		}
+	}
+}